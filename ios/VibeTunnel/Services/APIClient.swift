@@ -336,7 +336,20 @@ class APIClient: APIClientProtocol {
         let resize = TerminalResize(cols: cols, rows: rows)
         request.httpBody = try encoder.encode(resize)
 
-        let (_, response) = try await session.data(for: request)
+        let (data, response) = try await session.data(for: request)
+
+        // The server rejects resizes with code RESIZE_DISABLED when resizing is turned off
+        if let httpResponse = response as? HTTPURLResponse, httpResponse.statusCode == 403 {
+            struct ErrorResponse: Codable {
+                let code: String?
+            }
+            if let errorResponse = try? decoder.decode(ErrorResponse.self, from: data),
+               errorResponse.code == "RESIZE_DISABLED"
+            {
+                throw APIError.resizeDisabledByServer
+            }
+        }
+
         try validateResponse(response)
     }
 
@@ -0,0 +1,451 @@
+// Package bufferclient is a client for VibeTunnel's /buffers WebSocket. It
+// subscribes to sessions and decodes their binary buffer snapshots into
+// Screens, for Go viewers, tests and bots.
+//
+//	c, err := bufferclient.Dial(ctx, "http://localhost:4020", &bufferclient.Options{Token: token})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	if err := c.Subscribe(sessionID, nil); err != nil {
+//		return err
+//	}
+//	for update := range c.Updates() {
+//		fmt.Println(update.Screen.Text())
+//	}
+//
+// The wire format is described under "Binary Buffer Protocol" and "WebSocket"
+// in web/spec.md.
+package bufferclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the /buffers protocol version the client speaks.
+const ProtocolVersion = "1.3"
+
+// Protocol features the client can decode, asked for in its hello.
+const (
+	CapabilityCompression = "compression"
+	CapabilityDeltas      = "deltas"
+	CapabilityLinks       = "links"
+	CapabilityImages      = "images"
+)
+
+var supportedCapabilities = []string{
+	CapabilityCompression, CapabilityDeltas, CapabilityLinks, CapabilityImages,
+}
+
+// What servers use for connections before a hello is answered, and servers
+// older than 1.3 always.
+var legacyCapabilities = []string{CapabilityLinks, CapabilityImages}
+
+// Magic byte of binary messages: [0xBF][session ID length][session ID][snapshot]
+const bufferMagicByte = 0xbf
+
+const defaultBufferSize = 64
+
+// Options configures Dial.
+type Options struct {
+	// JWT from the login endpoints or an API token (vt_...), sent as a bearer
+	// token. Not needed for servers running with --no-auth.
+	Token string
+	// Extra headers for the upgrade request.
+	Header http.Header
+	// Protocol features to ask for; nil asks for all the client supports.
+	Capabilities []string
+	// TLS settings for https:// and wss:// URLs.
+	TLSConfig *tls.Config
+	// Capacity of the Updates and Events channels (default 64).
+	BufferSize int
+}
+
+// SubscribeOptions are the optional settings of a subscription.
+type SubscribeOptions struct {
+	// Upper bound for snapshots per second (the server caps it at 60).
+	MaxFPS int
+	// Viewer whose reported size snapshots are cropped to.
+	ViewerID string
+}
+
+// Update is a new screen of a subscribed session.
+type Update struct {
+	SessionID string
+	Screen    *Screen
+}
+
+// Event is a JSON message from the server other than snapshots, such as
+// subscribed, presence, collab, lock, image, trigger or error.
+type Event struct {
+	Type      string
+	SessionID string
+	// The whole message.
+	Raw json.RawMessage
+}
+
+// Client is a connection to the /buffers WebSocket. Its methods are safe for
+// concurrent use.
+type Client struct {
+	ws      *wsConn
+	updates chan Update
+	events  chan Event
+	done    chan struct{}
+
+	// Sender of input batches, which the server applies in seq order
+	clientID string
+
+	mu            sync.Mutex
+	inputSeq      int
+	serverVersion string
+	capabilities  []string
+	// Last screen per subscribed session, the base of delta snapshots
+	screens map[string]*Screen
+	err     error
+
+	closeOnce sync.Once
+}
+
+// Dial connects to the /buffers WebSocket of the server at serverURL (http,
+// https, ws or wss; a path is kept as prefix) and negotiates the protocol.
+func Dial(ctx context.Context, serverURL string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	u, err := buffersURL(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialConn(ctx, u, opts.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Host:       u.Host,
+		Header:     http.Header{},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	for name, values := range opts.Header {
+		req.Header[name] = values
+	}
+	if opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+	ws, err := handshake(conn, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &Client{
+		ws:           ws,
+		clientID:     "go-" + hex.EncodeToString(id),
+		done:         make(chan struct{}),
+		capabilities: legacyCapabilities,
+		screens:      make(map[string]*Screen),
+	}
+	if err := c.negotiate(opts.Capabilities); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	size := opts.BufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	c.updates = make(chan Update, size)
+	c.events = make(chan Event, size)
+	go c.readLoop()
+	return c, nil
+}
+
+func buffersURL(serverURL string) (*url.URL, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("bufferclient: invalid server URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("bufferclient: unsupported URL scheme %q", u.Scheme)
+	}
+	if !strings.HasSuffix(u.Path, "/buffers") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/buffers"
+	}
+	u.RawPath = ""
+	return u, nil
+}
+
+func dialConn(ctx context.Context, u *url.URL, tlsConfig *tls.Config) (net.Conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	if u.Scheme == "wss" {
+		dialer := &tls.Dialer{Config: tlsConfig}
+		return dialer.DialContext(ctx, "tcp", host)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", host)
+}
+
+// negotiate reads the welcome message and, for servers speaking 1.3 or later,
+// exchanges hellos. Runs before the read loop starts.
+func (c *Client) negotiate(requested []string) error {
+	welcome, err := c.readJSON()
+	if err != nil {
+		return err
+	}
+	if welcome.Type != "connected" {
+		return fmt.Errorf("bufferclient: unexpected first message %q", welcome.Type)
+	}
+	c.serverVersion = welcome.Version
+	if !versionAtLeast(welcome.Version, 1, 3) {
+		return nil
+	}
+
+	if requested == nil {
+		requested = supportedCapabilities
+	}
+	if err := c.send(map[string]any{
+		"type":         "hello",
+		"version":      ProtocolVersion,
+		"capabilities": requested,
+	}); err != nil {
+		return err
+	}
+	reply, err := c.readJSON()
+	if err != nil {
+		return err
+	}
+	if reply.Type != "hello" {
+		return fmt.Errorf("bufferclient: expected hello, got %q", reply.Type)
+	}
+	c.capabilities = reply.Capabilities
+	return nil
+}
+
+type jsonMessage struct {
+	Type         string   `json:"type"`
+	SessionID    string   `json:"sessionId"`
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func (c *Client) readJSON() (*jsonMessage, error) {
+	op, payload, err := c.ws.readMessage()
+	if err != nil {
+		return nil, err
+	}
+	if op != opText {
+		return nil, errors.New("bufferclient: expected a JSON message")
+	}
+	var message jsonMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, fmt.Errorf("bufferclient: invalid message: %w", err)
+	}
+	return &message, nil
+}
+
+func versionAtLeast(version string, major, minor int) bool {
+	majorPart, minorPart, _ := strings.Cut(version, ".")
+	gotMajor, err := strconv.Atoi(majorPart)
+	if err != nil {
+		return false
+	}
+	gotMinor, _ := strconv.Atoi(minorPart)
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
+}
+
+// ServerVersion is the protocol version from the server's welcome message.
+func (c *Client) ServerVersion() string {
+	return c.serverVersion
+}
+
+// Capabilities are the protocol features the server uses for this connection.
+func (c *Client) Capabilities() []string {
+	return append([]string(nil), c.capabilities...)
+}
+
+// Updates delivers the screens of subscribed sessions. It is closed when the
+// connection ends. Snapshots are read no faster than they are received here.
+func (c *Client) Updates() <-chan Update {
+	return c.updates
+}
+
+// Events delivers the server's other messages. Events that do not fit into
+// the channel are dropped.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Subscribe asks for the screens of a session; opts may be nil.
+func (c *Client) Subscribe(sessionID string, opts *SubscribeOptions) error {
+	message := map[string]any{"type": "subscribe", "sessionId": sessionID}
+	if opts != nil {
+		if opts.MaxFPS > 0 {
+			message["maxFps"] = opts.MaxFPS
+		}
+		if opts.ViewerID != "" {
+			message["viewerId"] = opts.ViewerID
+		}
+	}
+	return c.send(message)
+}
+
+// Unsubscribe stops the updates of a session.
+func (c *Client) Unsubscribe(sessionID string) error {
+	c.mu.Lock()
+	delete(c.screens, sessionID)
+	c.mu.Unlock()
+	return c.send(map[string]any{"type": "unsubscribe", "sessionId": sessionID})
+}
+
+// SendInput sends text (including escape sequences) to a session as one
+// sequenced input batch. The server answers with an input-ack or input-error
+// event.
+func (c *Client) SendInput(sessionID, text string) error {
+	c.mu.Lock()
+	c.inputSeq++
+	seq := c.inputSeq
+	c.mu.Unlock()
+	return c.send(map[string]any{
+		"type":      "input",
+		"sessionId": sessionID,
+		"clientId":  c.clientID,
+		"seq":       seq,
+		"inputs":    []map[string]string{{"text": text}},
+	})
+}
+
+// Err returns the error that ended the connection, or nil while it is open
+// and after Close.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.ws.close()
+	})
+	return err
+}
+
+func (c *Client) send(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.ws.writeFrame(opText, data)
+}
+
+func (c *Client) readLoop() {
+	defer close(c.updates)
+	defer close(c.events)
+	for {
+		op, payload, err := c.ws.readMessage()
+		if err != nil {
+			select {
+			case <-c.done:
+			default:
+				c.mu.Lock()
+				c.err = err
+				c.mu.Unlock()
+				c.ws.conn.Close()
+			}
+			return
+		}
+		if op == opBinary {
+			c.handleSnapshot(payload)
+		} else {
+			c.handleJSON(payload)
+		}
+	}
+}
+
+func (c *Client) handleSnapshot(payload []byte) {
+	if len(payload) < 5 || payload[0] != bufferMagicByte {
+		return
+	}
+	idLength := int(binary.LittleEndian.Uint32(payload[1:]))
+	if idLength > len(payload)-5 {
+		return
+	}
+	sessionID := string(payload[5 : 5+idLength])
+
+	c.mu.Lock()
+	previous := c.screens[sessionID]
+	c.mu.Unlock()
+	screen, err := DecodeSnapshot(payload[5+idLength:], previous)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.screens[sessionID] = screen
+	c.mu.Unlock()
+
+	select {
+	case c.updates <- Update{SessionID: sessionID, Screen: screen}:
+	case <-c.done:
+	}
+}
+
+func (c *Client) handleJSON(payload []byte) {
+	var message jsonMessage
+	if json.Unmarshal(payload, &message) != nil {
+		return
+	}
+	switch message.Type {
+	case "ping":
+		_ = c.send(map[string]any{"type": "pong"})
+		return
+	case "pong":
+		return
+	}
+	event := Event{Type: message.Type, SessionID: message.SessionID, Raw: payload}
+	select {
+	case c.events <- event:
+	default:
+	}
+}
@@ -0,0 +1,199 @@
+package bufferclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// serveBuffers runs handler on the server side of each /buffers connection.
+func serveBuffers(t *testing.T, handler func(ws *wsConn)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/buffers" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		rw.Flush()
+		handler(&wsConn{conn: conn, r: bufio.NewReader(rw)})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeJSON(t *testing.T, ws *wsConn, message any) {
+	t.Helper()
+	data, _ := json.Marshal(message)
+	if err := ws.writeFrame(opText, data); err != nil {
+		t.Error(err)
+	}
+}
+
+func readJSON(t *testing.T, ws *wsConn) map[string]any {
+	t.Helper()
+	_, payload, err := ws.readMessage()
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	var message map[string]any
+	json.Unmarshal(payload, &message)
+	return message
+}
+
+// waitClosed blocks until the client closes the connection.
+func waitClosed(ws *wsConn) {
+	for {
+		if _, _, err := ws.readMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func bufferMessage(sessionID string, snapshot []byte) []byte {
+	message := []byte{bufferMagicByte}
+	message = binary.LittleEndian.AppendUint32(message, uint32(len(sessionID)))
+	message = append(message, sessionID...)
+	return append(message, snapshot...)
+}
+
+func TestClientSubscribe(t *testing.T) {
+	server := serveBuffers(t, func(ws *wsConn) {
+		writeJSON(t, ws, map[string]any{"type": "connected", "version": "1.3"})
+		if hello := readJSON(t, ws); hello["type"] != "hello" {
+			t.Errorf("first message = %v, want hello", hello)
+		}
+		writeJSON(t, ws, map[string]any{"type": "hello", "version": "1.3", "capabilities": []string{"deltas", "links"}})
+
+		subscribe := readJSON(t, ws)
+		if subscribe["type"] != "subscribe" || subscribe["sessionId"] != "s1" || subscribe["maxFps"] != float64(10) {
+			t.Errorf("subscribe = %v", subscribe)
+		}
+		writeJSON(t, ws, map[string]any{"type": "subscribed", "sessionId": "s1"})
+		ws.writeFrame(opBinary, bufferMessage("s1", encodeSnapshot(0, 0, 0, encodeRow("one"), encodeRow("two"))))
+		ws.writeFrame(opBinary, bufferMessage("s1", encodeSnapshot(flagDelta, 0, 0, []byte{rowUnchanged, 1}, encodeRow("2"))))
+		waitClosed(ws)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, server.URL, &Options{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if got := c.Capabilities(); !reflect.DeepEqual(got, []string{"deltas", "links"}) {
+		t.Errorf("Capabilities() = %v", got)
+	}
+	if err := c.Subscribe("s1", &SubscribeOptions{MaxFPS: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if event := <-c.Events(); event.Type != "subscribed" || event.SessionID != "s1" {
+		t.Errorf("event = %+v", event)
+	}
+	for _, want := range []string{"one\ntwo", "one\n2"} {
+		select {
+		case update := <-c.Updates():
+			if got := update.Screen.Text(); update.SessionID != "s1" || got != want {
+				t.Errorf("update %s = %q, want %q", update.SessionID, got, want)
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for an update")
+		}
+	}
+}
+
+func TestClientSendInput(t *testing.T) {
+	batches := make(chan map[string]any, 2)
+	server := serveBuffers(t, func(ws *wsConn) {
+		writeJSON(t, ws, map[string]any{"type": "connected", "version": "1.2"})
+		batches <- readJSON(t, ws)
+		batches <- readJSON(t, ws)
+		waitClosed(ws)
+	})
+
+	c, err := Dial(context.Background(), server.URL, &Options{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SendInput("s1", "ls\r")
+	c.SendInput("s1", "\x1b[A")
+
+	first, second := <-batches, <-batches
+	if first["type"] != "input" || first["sessionId"] != "s1" || first["clientId"] != second["clientId"] {
+		t.Errorf("batch = %v", first)
+	}
+	if first["seq"] != float64(1) || second["seq"] != float64(2) {
+		t.Errorf("seqs = %v, %v, want 1, 2", first["seq"], second["seq"])
+	}
+	if inputs := second["inputs"].([]any); inputs[0].(map[string]any)["text"] != "\x1b[A" {
+		t.Errorf("inputs = %v", inputs)
+	}
+}
+
+func TestClientLegacyServer(t *testing.T) {
+	server := serveBuffers(t, func(ws *wsConn) {
+		writeJSON(t, ws, map[string]any{"type": "connected", "version": "1.2"})
+		waitClosed(ws)
+	})
+
+	c, err := Dial(context.Background(), server.URL, &Options{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.ServerVersion() != "1.2" {
+		t.Errorf("ServerVersion() = %q", c.ServerVersion())
+	}
+	if got := c.Capabilities(); !reflect.DeepEqual(got, legacyCapabilities) {
+		t.Errorf("Capabilities() = %v, want %v", got, legacyCapabilities)
+	}
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	server := serveBuffers(t, func(ws *wsConn) {})
+
+	_, err := Dial(context.Background(), server.URL, nil)
+	var handshakeErr *HandshakeError
+	if !errors.As(err, &handshakeErr) || handshakeErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("err = %v, want a 401 HandshakeError", err)
+	}
+}
+
+func TestBuffersURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"http://localhost:4020":        "ws://localhost:4020/buffers",
+		"https://example.com/vt/":      "wss://example.com/vt/buffers",
+		"wss://example.com/vt/buffers": "wss://example.com/vt/buffers",
+	} {
+		u, err := buffersURL(in)
+		if err != nil || u.String() != want {
+			t.Errorf("buffersURL(%q) = %v, %v; want %s", in, u, err, want)
+		}
+	}
+	if _, err := buffersURL("ftp://example.com"); err == nil {
+		t.Error("buffersURL accepted an ftp URL")
+	}
+}
@@ -0,0 +1,288 @@
+package bufferclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Snapshot format constants, as in web/src/shared/buffer-protocol.ts.
+const (
+	snapshotMagic      = 0x5654 // "VT"
+	snapshotHeaderSize = 28
+	snapshotFormat1    = 0x01
+	snapshotFormat2    = 0x02
+
+	flagLinkTable  = 0x01
+	flagDelta      = 0x02
+	flagCompressed = 0x04
+
+	rowEmpty     = 0xfe
+	rowContent   = 0xfd
+	rowUnchanged = 0xfc
+)
+
+// Cell attribute bits.
+const (
+	AttrBold          uint8 = 0x01
+	AttrItalic        uint8 = 0x02
+	AttrUnderline     uint8 = 0x04
+	AttrDim           uint8 = 0x08
+	AttrInverse       uint8 = 0x10
+	AttrInvisible     uint8 = 0x20
+	AttrStrikethrough uint8 = 0x40
+)
+
+// DefaultColor is the FG or BG of a cell using the terminal's default color.
+const DefaultColor int32 = -1
+
+// Cell is one character cell of a screen.
+type Cell struct {
+	// A grapheme cluster: a character with its combining marks, an emoji ZWJ
+	// sequence or a flag.
+	Char string
+	// Columns the cell takes (see CellWidth).
+	Width int
+	// Palette index (0-255), 0xRRGGBB for values above 255, or DefaultColor.
+	FG, BG     int32
+	Attributes uint8
+	// Target of an OSC 8 hyperlink covering the cell.
+	Link string
+}
+
+// Screen is a decoded buffer snapshot: the visible rows of a session's terminal.
+// Rows are trimmed, so a row may have fewer than Cols cells (at least one).
+type Screen struct {
+	Cols, Rows int
+	ViewportY  int
+	CursorX    int
+	CursorY    int
+	Cells      [][]Cell
+}
+
+// Line returns the text of row i, or "" if there is no such row.
+func (s *Screen) Line(i int) string {
+	if i < 0 || i >= len(s.Cells) {
+		return ""
+	}
+	var b strings.Builder
+	for _, cell := range s.Cells[i] {
+		b.WriteString(cell.Char)
+	}
+	return b.String()
+}
+
+// Text returns the rows as text, one line per row, with trailing spaces removed.
+func (s *Screen) Text() string {
+	lines := make([]string, len(s.Cells))
+	for i := range s.Cells {
+		lines[i] = strings.TrimRight(s.Line(i), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	// ErrInvalidSnapshot is returned for data that is not a buffer snapshot.
+	ErrInvalidSnapshot = errors.New("bufferclient: invalid snapshot")
+	// ErrMissingBase is returned for a delta snapshot decoded without the previous one.
+	ErrMissingBase = errors.New("bufferclient: delta snapshot without a previous screen")
+)
+
+// DecodeSnapshot decodes a binary buffer snapshot (format 1 or 2). Delta
+// snapshots take their unchanged rows from previous, the session's previous
+// screen; compressed snapshots are inflated.
+func DecodeSnapshot(data []byte, previous *Screen) (*Screen, error) {
+	if len(data) < snapshotHeaderSize || binary.LittleEndian.Uint16(data) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+	if version := data[2]; version != snapshotFormat1 && version != snapshotFormat2 {
+		return nil, fmt.Errorf("bufferclient: unsupported snapshot version %d", version)
+	}
+	flags := data[3]
+	if flags&flagCompressed != 0 {
+		inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(data[snapshotHeaderSize:])))
+		if err != nil {
+			return nil, fmt.Errorf("bufferclient: inflate snapshot: %w", err)
+		}
+		data = append(data[:snapshotHeaderSize:snapshotHeaderSize], inflated...)
+	}
+	if flags&flagDelta != 0 && previous == nil {
+		return nil, ErrMissingBase
+	}
+
+	screen := &Screen{
+		Cols:      int(binary.LittleEndian.Uint32(data[4:])),
+		Rows:      int(binary.LittleEndian.Uint32(data[8:])),
+		ViewportY: int(int32(binary.LittleEndian.Uint32(data[12:]))),
+		CursorX:   int(int32(binary.LittleEndian.Uint32(data[16:]))),
+		CursorY:   int(int32(binary.LittleEndian.Uint32(data[20:]))),
+	}
+	rowsEnd := len(data)
+	if flags&flagLinkTable != 0 {
+		rowsEnd = int(binary.LittleEndian.Uint32(data[24:]))
+		if rowsEnd < snapshotHeaderSize || rowsEnd > len(data) {
+			return nil, ErrInvalidSnapshot
+		}
+	}
+
+	d := decoder{data: data[:rowsEnd], offset: snapshotHeaderSize}
+	for d.offset < len(d.data) {
+		marker := d.byte()
+		switch marker {
+		case rowEmpty:
+			for n := d.byte(); n > 0; n-- {
+				screen.Cells = append(screen.Cells, []Cell{blankCell()})
+			}
+		case rowUnchanged:
+			for n := d.byte(); n > 0; n-- {
+				screen.Cells = append(screen.Cells, previousRow(previous, len(screen.Cells)))
+			}
+		case rowContent:
+			count := int(d.uint16())
+			row := make([]Cell, 0, count)
+			for i := 0; i < count && d.err == nil; i++ {
+				row = append(row, d.cell())
+			}
+			screen.Cells = append(screen.Cells, row)
+		default:
+			// Not a row marker: skipped, as the web decoder does
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+
+	if flags&flagLinkTable != 0 {
+		attachLinks(screen.Cells, data[rowsEnd:])
+	}
+	return screen, nil
+}
+
+func blankCell() Cell {
+	return Cell{Char: " ", Width: 1, FG: DefaultColor, BG: DefaultColor}
+}
+
+// previousRow copies row i of the previous screen without its links, which
+// come from the new snapshot's link table.
+func previousRow(previous *Screen, i int) []Cell {
+	if previous == nil || i >= len(previous.Cells) {
+		return []Cell{blankCell()}
+	}
+	row := make([]Cell, len(previous.Cells[i]))
+	for j, cell := range previous.Cells[i] {
+		cell.Link = ""
+		row[j] = cell
+	}
+	return row
+}
+
+type decoder struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if d.offset+n > len(d.data) {
+		d.err = ErrInvalidSnapshot
+		return nil
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) color(rgb bool) int32 {
+	if !rgb {
+		return int32(d.byte())
+	}
+	if b := d.take(3); b != nil {
+		return int32(b[0])<<16 | int32(b[1])<<8 | int32(b[2])
+	}
+	return 0
+}
+
+// cell decodes one cell. Type byte: bit 7 extended data (attributes and colors),
+// bit 6 Unicode, bit 5 foreground, bit 4 background, bit 3 RGB foreground, bit 2
+// RGB background, bits 1-0 character type (0 space, 1 ASCII, 2 Unicode).
+func (d *decoder) cell() Cell {
+	cell := blankCell()
+	typeByte := d.byte()
+	if typeByte == 0 {
+		return cell
+	}
+
+	switch {
+	case typeByte&0x03 == 0:
+		// Space with attributes or colors
+	case typeByte&0x40 != 0:
+		char := d.take(int(d.byte()))
+		if utf8.Valid(char) {
+			cell.Char = string(char)
+		} else {
+			cell.Char = string(utf8.RuneError)
+		}
+	default:
+		cell.Char = string(rune(d.byte()))
+	}
+	cell.Width = CellWidth(cell.Char)
+
+	if typeByte&0x80 != 0 {
+		cell.Attributes = d.byte()
+		if typeByte&0x20 != 0 {
+			cell.FG = d.color(typeByte&0x08 != 0)
+		}
+		if typeByte&0x10 != 0 {
+			cell.BG = d.color(typeByte&0x04 != 0)
+		}
+	}
+	return cell
+}
+
+// attachLinks sets the link of the cells covered by the link table's spans
+// ([row, startCell, endCell) per link). A malformed table is ignored.
+func attachLinks(cells [][]Cell, table []byte) {
+	var parsed struct {
+		Links []struct {
+			URI   string   `json:"uri"`
+			Spans [][3]int `json:"spans"`
+		} `json:"links"`
+	}
+	if json.Unmarshal(table, &parsed) != nil {
+		return
+	}
+	for _, link := range parsed.Links {
+		for _, span := range link.Spans {
+			row, start, end := span[0], span[1], span[2]
+			if row < 0 || row >= len(cells) || start < 0 {
+				continue
+			}
+			for i := start; i < end && i < len(cells[row]); i++ {
+				cells[row][i].Link = link.URI
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package bufferclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// encodeCell encodes a cell as the server does (encodeCell in
+// web/src/server/services/terminal-manager.ts).
+func encodeCell(cell Cell) []byte {
+	hasFG, hasBG := cell.FG != DefaultColor, cell.BG != DefaultColor
+	extended := cell.Attributes != 0 || hasFG || hasBG
+	if cell.Char == " " && !extended {
+		return []byte{0x00}
+	}
+
+	var typeByte byte
+	if extended {
+		typeByte |= 0x80
+	}
+	ascii := len(cell.Char) == 1 && cell.Char[0] <= 0x7f
+	switch {
+	case !ascii:
+		typeByte |= 0x40 | 0x02
+	case cell.Char != " ":
+		typeByte |= 0x01
+	}
+	if hasFG {
+		typeByte |= 0x20
+		if cell.FG > 0xff {
+			typeByte |= 0x08
+		}
+	}
+	if hasBG {
+		typeByte |= 0x10
+		if cell.BG > 0xff {
+			typeByte |= 0x04
+		}
+	}
+
+	data := []byte{typeByte}
+	switch {
+	case !ascii:
+		data = append(data, byte(len(cell.Char)))
+		data = append(data, cell.Char...)
+	case cell.Char != " ":
+		data = append(data, cell.Char[0])
+	}
+	if extended {
+		data = append(data, cell.Attributes)
+		for _, color := range []struct {
+			set   bool
+			value int32
+		}{{hasFG, cell.FG}, {hasBG, cell.BG}} {
+			switch {
+			case !color.set:
+			case color.value > 0xff:
+				data = append(data, byte(color.value>>16), byte(color.value>>8), byte(color.value))
+			default:
+				data = append(data, byte(color.value))
+			}
+		}
+	}
+	return data
+}
+
+func encodeCellRow(row []Cell) []byte {
+	data := binary.LittleEndian.AppendUint16([]byte{rowContent}, uint16(len(row)))
+	for _, cell := range row {
+		data = append(data, encodeCell(cell)...)
+	}
+	return data
+}
+
+// Characters the generator picks from: ASCII, box drawing, combining marks
+// (also after ASCII), wide, emoji and ZWJ sequences, zero width and empty
+var fuzzChars = []string{" ", "a", "Z", "~", "\u2500", "\u00e9", "e\u0301", "\u4e2d", "\U0001f642", "\U0001f469\u200d\U0001f4bb", "\u200b", ""}
+
+// cellsFromBytes builds rows of cells from fuzz input, so the fuzzer explores
+// cell contents rather than the encoding.
+func cellsFromBytes(data []byte) [][]Cell {
+	next := func() byte {
+		if len(data) == 0 {
+			return 0
+		}
+		b := data[0]
+		data = data[1:]
+		return b
+	}
+	color := func(b byte) int32 {
+		switch b % 3 {
+		case 0:
+			return DefaultColor
+		case 1:
+			return int32(next())
+		default:
+			// RGB values up to 0xff would read as palette indexes, which
+			// the format cannot tell apart
+			return 0x100 + int32(next())<<16 | int32(next())<<8 | int32(next())
+		}
+	}
+
+	var rows [][]Cell
+	for len(data) > 0 && len(rows) < 50 {
+		row := []Cell{}
+		for n := int(next()%40) + 1; n > 0; n-- {
+			b := next()
+			char := fuzzChars[int(b)%len(fuzzChars)]
+			cell := Cell{Char: char, Width: CellWidth(char), FG: DefaultColor, BG: DefaultColor}
+			if b&0x80 != 0 {
+				cell.Attributes = next() & 0x7f
+				cell.FG = color(next())
+				cell.BG = color(next())
+			}
+			row = append(row, cell)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func FuzzSnapshotRoundTrip(f *testing.F) {
+	f.Add([]byte("hello"))
+	f.Add([]byte{3, 0x81, 0x01, 1, 2, 0x84, 0, 2, 7, 7, 7, 5, 9, 10})
+	f.Add(bytes.Repeat([]byte{0xff, 0x86, 0x55, 2, 1, 2, 3, 4, 5, 6}, 20))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rows := cellsFromBytes(data)
+		body := make([][]byte, len(rows))
+		for i, row := range rows {
+			body[i] = encodeCellRow(row)
+		}
+		plain := encodeSnapshot(0, 0, 0, body...)
+
+		screen, err := DecodeSnapshot(plain, nil)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if !reflect.DeepEqual(screen.Cells, rows) && !(len(rows) == 0 && len(screen.Cells) == 0) {
+			t.Fatalf("cells differ:\n got %+v\nwant %+v", screen.Cells, rows)
+		}
+
+		// The same frame compressed decodes to the same screen
+		var deflated bytes.Buffer
+		w, _ := flate.NewWriter(&deflated, flate.BestSpeed)
+		w.Write(plain[snapshotHeaderSize:])
+		w.Close()
+		compressed, err := DecodeSnapshot(append(encodeSnapshot(flagCompressed, 0, 0), deflated.Bytes()...), nil)
+		if err != nil || !reflect.DeepEqual(compressed.Cells, screen.Cells) {
+			t.Fatalf("compressed: %v", err)
+		}
+
+		// A delta of unchanged rows rebuilds the screen from the previous one
+		var delta [][]byte
+		for n := len(rows); n > 0; n -= 255 {
+			delta = append(delta, []byte{rowUnchanged, byte(min(n, 255))})
+		}
+		rebuilt, err := DecodeSnapshot(encodeSnapshot(flagDelta, 0, 0, delta...), screen)
+		if err != nil || !reflect.DeepEqual(rebuilt.Cells, screen.Cells) {
+			t.Fatalf("delta: %v", err)
+		}
+	})
+}
+
+func FuzzDecodeSnapshot(f *testing.F) {
+	f.Add(encodeSnapshot(0, 3, 1, encodeRow("$ ls"), []byte{rowEmpty, 2}, encodeRow("a b")))
+	f.Add(encodeSnapshot(flagDelta, 0, 0, []byte{rowUnchanged, 3}, encodeRow("x")))
+	f.Add(append(encodeSnapshot(flagLinkTable, 0, 0, encodeRow("see docs")),
+		`{"links":[{"uri":"https://example.com","spans":[[0,4,8],[9,-1,2]]}]}`...))
+	f.Add(encodeSnapshot(flagCompressed, 0, 0, []byte{0x01, 0x02}))
+	previous := &Screen{Cells: [][]Cell{{blankCell()}, {{Char: "x", Width: 1, FG: 1, BG: DefaultColor, Link: "l"}}}}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, base := range []*Screen{nil, previous} {
+			screen, err := DecodeSnapshot(data, base)
+			if err != nil {
+				continue
+			}
+			for i, row := range screen.Cells {
+				for j, cell := range row {
+					if !utf8.ValidString(cell.Char) {
+						t.Fatalf("cell %d,%d: invalid UTF-8 %q", i, j, cell.Char)
+					}
+				}
+			}
+		}
+		// The decoder never changes the previous screen
+		if previous.Cells[1][0].Link != "l" {
+			t.Fatal("previous screen modified")
+		}
+	})
+}
+
+func TestDecodeSnapshotErrors(t *testing.T) {
+	// A link table offset past the end of the frame
+	data := encodeSnapshot(flagLinkTable, 0, 0, encodeRow("x"))
+	binary.LittleEndian.PutUint32(data[24:], uint32(len(data)+1))
+	if _, err := DecodeSnapshot(data, nil); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("link table offset: err = %v", err)
+	}
+	// A cell count larger than the row
+	row := binary.LittleEndian.AppendUint16([]byte{rowContent}, 0xffff)
+	if _, err := DecodeSnapshot(encodeSnapshot(0, 0, 0, append(row, 0x01, 'x')), nil); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("cell count: err = %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package bufferclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// encodeRow encodes a row of ASCII characters with default colors.
+func encodeRow(text string) []byte {
+	row := []byte{rowContent}
+	row = binary.LittleEndian.AppendUint16(row, uint16(len(text)))
+	for _, char := range []byte(text) {
+		if char == ' ' {
+			row = append(row, 0x00)
+		} else {
+			row = append(row, 0x01, char)
+		}
+	}
+	return row
+}
+
+func encodeSnapshot(flags byte, cursorX, cursorY int32, body ...[]byte) []byte {
+	version := byte(snapshotFormat1)
+	if flags&(flagDelta|flagCompressed) != 0 {
+		version = snapshotFormat2
+	}
+	data := binary.LittleEndian.AppendUint16(nil, snapshotMagic)
+	data = append(data, version, flags)
+	data = binary.LittleEndian.AppendUint32(data, 80)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(body)))
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, uint32(cursorX))
+	data = binary.LittleEndian.AppendUint32(data, uint32(cursorY))
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	for _, part := range body {
+		data = append(data, part...)
+	}
+	return data
+}
+
+func TestDecodeSnapshot(t *testing.T) {
+	data := encodeSnapshot(0, 3, 1, encodeRow("$ ls"), []byte{rowEmpty, 2}, encodeRow("a b"))
+	screen, err := DecodeSnapshot(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if screen.Cols != 80 || screen.CursorX != 3 || screen.CursorY != 1 {
+		t.Errorf("header = %d cols, cursor %d,%d", screen.Cols, screen.CursorX, screen.CursorY)
+	}
+	if got, want := screen.Text(), "$ ls\n\n\na b"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+	if cell := screen.Cells[0][0]; cell.FG != DefaultColor || cell.BG != DefaultColor {
+		t.Errorf("colors = %d/%d, want defaults", cell.FG, cell.BG)
+	}
+}
+
+func TestDecodeSnapshotAttributesAndColors(t *testing.T) {
+	// Bold "x" with palette foreground 2 and RGB background 0x102030, then a
+	// Unicode character
+	row := []byte{rowContent, 2, 0, 0x80 | 0x20 | 0x10 | 0x04 | 0x01, 'x', AttrBold, 2, 0x10, 0x20, 0x30}
+	row = append(row, 0x40|0x02, 3, 0xe2, 0x94, 0x80)
+	screen, err := DecodeSnapshot(encodeSnapshot(0, 0, 0, row), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell := screen.Cells[0][0]
+	if cell.Char != "x" || cell.Attributes != AttrBold || cell.FG != 2 || cell.BG != 0x102030 {
+		t.Errorf("cell = %+v", cell)
+	}
+	if got := screen.Cells[0][1].Char; got != "─" {
+		t.Errorf("unicode cell = %q", got)
+	}
+}
+
+func TestDecodeSnapshotLinks(t *testing.T) {
+	rows := encodeRow("see docs")
+	data := encodeSnapshot(flagLinkTable, 0, 0, rows)
+	binary.LittleEndian.PutUint32(data[24:], uint32(len(data)))
+	data = append(data, `{"links":[{"uri":"https://example.com","spans":[[0,4,8]]}]}`...)
+
+	screen, err := DecodeSnapshot(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := screen.Cells[0][3].Link; got != "" {
+		t.Errorf("cell 3 link = %q", got)
+	}
+	if got := screen.Cells[0][4].Link; got != "https://example.com" {
+		t.Errorf("cell 4 link = %q", got)
+	}
+}
+
+func TestDecodeSnapshotWidths(t *testing.T) {
+	// CJK, an emoji, a ZWJ sequence, a flag, a skin tone, a combining mark
+	clusters := []string{"\u4e2d", "|", "\U0001f642", "\U0001f469\u200d\U0001f4bb", "\U0001f1ef\U0001f1f5",
+		"\U0001f44d\U0001f3fd", "\u2764\ufe0f", "\u2764", "e\u0301", "x"}
+	var row []Cell
+	for _, cluster := range clusters {
+		row = append(row, Cell{Char: cluster, FG: DefaultColor, BG: DefaultColor})
+	}
+	screen, err := DecodeSnapshot(encodeSnapshot(0, 0, 0, encodeCellRow(row)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{2, 1, 2, 2, 2, 2, 2, 1, 1, 1}
+	for i, cell := range screen.Cells[0] {
+		if cell.Char != clusters[i] || cell.Width != want[i] {
+			t.Errorf("cell %d = %q width %d, want %q width %d", i, cell.Char, cell.Width, clusters[i], want[i])
+		}
+	}
+}
+
+func TestDecodeSnapshotDelta(t *testing.T) {
+	first, err := DecodeSnapshot(encodeSnapshot(0, 0, 0, encodeRow("one"), encodeRow("two"), encodeRow("six")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta := encodeSnapshot(flagDelta, 0, 0, []byte{rowUnchanged, 1}, encodeRow("TWO"), []byte{rowUnchanged, 1})
+
+	screen, err := DecodeSnapshot(delta, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := screen.Text(), "one\nTWO\nsix"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+	if _, err := DecodeSnapshot(delta, nil); !errors.Is(err, ErrMissingBase) {
+		t.Errorf("delta without base: err = %v", err)
+	}
+}
+
+func TestDecodeSnapshotCompressed(t *testing.T) {
+	plain := encodeSnapshot(0, 0, 0, encodeRow("hello"), encodeRow("world"))
+	var body bytes.Buffer
+	w, _ := flate.NewWriter(&body, flate.BestSpeed)
+	w.Write(plain[snapshotHeaderSize:])
+	w.Close()
+	compressed := append(encodeSnapshot(flagCompressed, 0, 0), body.Bytes()...)
+
+	screen, err := DecodeSnapshot(compressed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := screen.Text(), "hello\nworld"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeSnapshotInvalid(t *testing.T) {
+	if _, err := DecodeSnapshot([]byte("not a snapshot at all, really"), nil); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("err = %v, want ErrInvalidSnapshot", err)
+	}
+	truncated := encodeSnapshot(0, 0, 0, encodeRow("hello"))
+	if _, err := DecodeSnapshot(truncated[:len(truncated)-2], nil); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("truncated: err = %v, want ErrInvalidSnapshot", err)
+	}
+}
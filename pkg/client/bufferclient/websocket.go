@@ -0,0 +1,226 @@
+package bufferclient
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Minimal RFC 6455 WebSocket, enough for the /buffers endpoint: no extensions,
+// messages are read whole.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Messages larger than this close the connection.
+const maxMessageSize = 64 << 20
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+var errConnClosed = errors.New("bufferclient: connection closed")
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// Clients mask what they send, servers do not
+	client bool
+
+	writeMu sync.Mutex
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// handshake sends the upgrade request for req over conn and checks the response.
+func handshake(conn net.Conn, req *http.Request) (*wsConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, &HandshakeError{StatusCode: resp.StatusCode}
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.New("bufferclient: invalid Sec-WebSocket-Accept")
+	}
+	return &wsConn{conn: conn, r: r, client: true}, nil
+}
+
+// HandshakeError is returned by Dial when the server refuses the upgrade,
+// e.g. with 401 for a missing or invalid token.
+type HandshakeError struct {
+	StatusCode int
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("bufferclient: websocket upgrade refused: %d %s",
+		e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// readMessage returns the next text or binary message, answering pings on the
+// way. A close frame is answered and reported as errConnClosed.
+func (c *wsConn) readMessage() (opcode byte, payload []byte, err error) {
+	var message []byte
+	messageOp := byte(0)
+	for {
+		fin, op, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			_ = c.writeFrame(opClose, data)
+			return 0, nil, errConnClosed
+		case opContinuation:
+			if messageOp == 0 {
+				return 0, nil, errors.New("bufferclient: unexpected continuation frame")
+			}
+		case opText, opBinary:
+			if messageOp != 0 {
+				return 0, nil, errors.New("bufferclient: interleaved message")
+			}
+			messageOp = op
+		default:
+			return 0, nil, fmt.Errorf("bufferclient: unknown opcode %d", op)
+		}
+
+		if len(message)+len(data) > maxMessageSize {
+			return 0, nil, errors.New("bufferclient: message too large")
+		}
+		message = append(message, data...)
+		if fin {
+			return messageOp, message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, errors.New("bufferclient: frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame writes payload as a single final frame; safe for concurrent use.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|op)
+
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) close() error {
+	// Normal closure; the peer's reply is not awaited
+	_ = c.writeFrame(opClose, []byte{0x03, 0xe8})
+	return c.conn.Close()
+}
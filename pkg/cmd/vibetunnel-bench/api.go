@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// benchCommand runs in every benchmark session: it prints each input line
+// once (no terminal echo), so the output rate is the rate lines are sent.
+var benchCommand = []string{"sh", "-c", "stty -echo; exec cat"}
+
+// apiClient calls the server's REST API.
+type apiClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newAPIClient(serverURL, token string) *apiClient {
+	return &apiClient{
+		baseURL: strings.TrimSuffix(serverURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (a *apiClient) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+"/api"+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	return req, nil
+}
+
+func (a *apiClient) do(ctx context.Context, method, path string, body, result any) error {
+	req, err := a.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, method, path); err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func checkStatus(resp *http.Response, method, path string) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	var failure struct {
+		Error string `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&failure)
+	if failure.Error == "" {
+		failure.Error = resp.Status
+	}
+	return fmt.Errorf("%s %s: %s", method, path, failure.Error)
+}
+
+func (a *apiClient) createSession(ctx context.Context, name string) (string, error) {
+	body := map[string]any{"command": benchCommand, "name": name, "tags": []string{"bench"}}
+	var created struct {
+		SessionID string `json:"sessionId"`
+	}
+	err := a.do(ctx, http.MethodPost, "/sessions", body, &created)
+	return created.SessionID, err
+}
+
+// removeSession kills a session and deletes what it left behind.
+func (a *apiClient) removeSession(ctx context.Context, sessionID string) error {
+	path := "/sessions/" + url.PathEscape(sessionID)
+	killErr := a.do(ctx, http.MethodDelete, path, nil, nil)
+	if err := a.do(ctx, http.MethodDelete, path+"/cleanup", nil, nil); err != nil {
+		if killErr != nil {
+			return killErr
+		}
+		return err
+	}
+	return nil
+}
+
+// openStream starts the session's server-sent event stream; the caller
+// closes the body. It runs until ctx ends.
+func (a *apiClient) openStream(ctx context.Context, sessionID string) (io.ReadCloser, error) {
+	path := "/sessions/" + url.PathEscape(sessionID) + "/stream"
+	req, err := a.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	// No client timeout: the stream lasts the whole run
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp, http.MethodGet, path); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
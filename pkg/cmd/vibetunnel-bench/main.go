@@ -0,0 +1,296 @@
+// Command vibetunnel-bench load-tests a VibeTunnel server: it creates N
+// sessions, writes synthetic output to them at a fixed rate and watches them
+// with M viewers over the /buffers WebSocket and the SSE stream, then reports
+// how fast and how late the output reached the viewers.
+//
+//	vibetunnel-bench [-server URL] [-token TOKEN] [-sessions N] [-viewers M] [-duration D] [-json]
+//
+// Every line written carries a sequence number; a line's latency is the time
+// from sending it as input until a viewer first sees it (or a later line), so
+// it covers the input path, the PTY, the terminal emulator and the snapshot or
+// stream pipeline. The sessions are removed at the end.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/amantus-ai/vibetunnel/pkg/client/bufferclient"
+)
+
+const defaultServerURL = "http://localhost:4020"
+
+const (
+	transportWebSocket = "ws"
+	transportSSE       = "sse"
+	transportMixed     = "mixed"
+)
+
+// How long viewers get to connect before lines are sent, and to receive the
+// last ones afterwards
+const (
+	warmup = time.Second
+	drain  = 2 * time.Second
+)
+
+type benchConfig struct {
+	serverURL string
+	token     string
+	sessions  int
+	viewers   int
+	transport string
+	duration  time.Duration
+	rate      float64
+	lineBytes int
+	maxFPS    int
+}
+
+func main() {
+	var config benchConfig
+	flags := flag.NewFlagSet("vibetunnel-bench", flag.ExitOnError)
+	flags.StringVar(&config.serverURL, "server", envOr("VIBETUNNEL_SERVER", defaultServerURL),
+		"server URL (env VIBETUNNEL_SERVER)")
+	flags.StringVar(&config.token, "token", os.Getenv("VIBETUNNEL_TOKEN"),
+		"JWT or API token (env VIBETUNNEL_TOKEN); not needed with --no-auth")
+	flags.IntVar(&config.sessions, "sessions", 10, "sessions to create")
+	flags.IntVar(&config.viewers, "viewers", 20, "viewers, spread over the sessions")
+	flags.StringVar(&config.transport, "transport", transportMixed,
+		"viewer transport: ws, sse or mixed (alternating)")
+	flags.DurationVar(&config.duration, "duration", 30*time.Second, "how long to send output")
+	flags.Float64Var(&config.rate, "rate", 10, "lines per second written to each session")
+	flags.IntVar(&config.lineBytes, "line-bytes", 72, "length of each line")
+	flags.IntVar(&config.maxFPS, "max-fps", 0, "snapshot rate WebSocket viewers ask for (0: server default)")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(os.Args[1:])
+
+	if err := config.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "vibetunnel-bench:", err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	r, err := run(ctx, config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "vibetunnel-bench:", err)
+		os.Exit(1)
+	}
+	if *jsonOutput {
+		r.writeJSON(os.Stdout)
+	} else {
+		r.writeText(os.Stdout)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (c *benchConfig) validate() error {
+	switch {
+	case c.sessions < 1:
+		return errors.New("-sessions must be at least 1")
+	case c.viewers < 0:
+		return errors.New("-viewers must not be negative")
+	case c.transport != transportWebSocket && c.transport != transportSSE && c.transport != transportMixed:
+		return fmt.Errorf("unknown -transport %q", c.transport)
+	case c.duration <= 0:
+		return errors.New("-duration must be positive")
+	case c.rate <= 0:
+		return errors.New("-rate must be positive")
+	}
+	return nil
+}
+
+// viewerTransport is the transport of the i-th viewer.
+func (c *benchConfig) viewerTransport(i int) string {
+	if c.transport == transportMixed {
+		if i%2 == 0 {
+			return transportWebSocket
+		}
+		return transportSSE
+	}
+	return c.transport
+}
+
+func run(ctx context.Context, config benchConfig) (r *report, err error) {
+	api := newAPIClient(config.serverURL, config.token)
+	setupStart := time.Now()
+	sessions, err := createSessions(ctx, api, config.sessions)
+	// Sessions are removed even when the run fails or is interrupted
+	defer func() {
+		teardownErrors := removeSessions(api, sessions)
+		if r != nil {
+			r.TeardownErrors = teardownErrors
+		} else if teardownErrors > 0 {
+			fmt.Fprintf(os.Stderr, "vibetunnel-bench: %d sessions could not be removed\n", teardownErrors)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	viewerCtx, stopViewers := context.WithCancel(ctx)
+	defer stopViewers()
+	results := make([]viewerResult, config.viewers)
+	var viewers sync.WaitGroup
+	for i := range results {
+		viewers.Add(1)
+		go func(i int) {
+			defer viewers.Done()
+			session := sessions[i%len(sessions)]
+			if config.viewerTransport(i) == transportWebSocket {
+				results[i] = runWebSocketViewer(viewerCtx, config.serverURL, config.token, session, config.maxFPS)
+			} else {
+				results[i] = runSSEViewer(viewerCtx, api, session)
+			}
+		}(i)
+	}
+	if !sleep(ctx, warmup) {
+		return nil, ctx.Err()
+	}
+
+	inputs, err := dialInputs(ctx, config, len(sessions))
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll(inputs)
+	setup := time.Since(setupStart)
+
+	start := time.Now()
+	sendErrors := writeLines(ctx, config, sessions, inputs)
+	elapsed := time.Since(start)
+	sleep(ctx, drain)
+	stopViewers()
+	viewers.Wait()
+
+	r = &report{
+		Sessions:       config.sessions,
+		Viewers:        config.viewers,
+		Duration:       elapsed.Seconds(),
+		RatePerSession: config.rate,
+		LineBytes:      config.lineBytes,
+		SetupSeconds:   setup.Seconds(),
+		SendErrors:     sendErrors,
+		Transports:     buildTransportReports(results, elapsed),
+	}
+	for _, session := range sessions {
+		r.LinesSent += session.sentCount()
+	}
+	r.LinesPerSecond = float64(r.LinesSent) / elapsed.Seconds()
+	return r, nil
+}
+
+func createSessions(ctx context.Context, api *apiClient, count int) ([]*benchSession, error) {
+	var sessions []*benchSession
+	for i := 0; i < count; i++ {
+		id, err := api.createSession(ctx, fmt.Sprintf("bench-%d", i+1))
+		if err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, &benchSession{id: id})
+	}
+	return sessions, nil
+}
+
+func removeSessions(api *apiClient, sessions []*benchSession) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	failed := 0
+	for _, session := range sessions {
+		if err := api.removeSession(ctx, session.id); err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// dialInputs opens a /buffers connection per session for writing to it.
+func dialInputs(ctx context.Context, config benchConfig, count int) ([]*bufferclient.Client, error) {
+	var clients []*bufferclient.Client
+	for i := 0; i < count; i++ {
+		dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		client, err := bufferclient.Dial(dialCtx, config.serverURL, &bufferclient.Options{Token: config.token})
+		cancel()
+		if err != nil {
+			closeAll(clients)
+			return nil, fmt.Errorf("input connection: %w", err)
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+func closeAll(clients []*bufferclient.Client) {
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// writeLines sends config.rate lines per second to every session for
+// config.duration, session i over clients[i]. It returns the number of lines
+// that could not be sent or that the server refused.
+func writeLines(ctx context.Context, config benchConfig, sessions []*benchSession, clients []*bufferclient.Client) int {
+	ctx, cancel := context.WithTimeout(ctx, config.duration)
+	defer cancel()
+
+	var mu sync.Mutex
+	sendErrors := 0
+	failed := func() {
+		mu.Lock()
+		sendErrors++
+		mu.Unlock()
+	}
+	var writers sync.WaitGroup
+	for i, session := range sessions {
+		writers.Add(1)
+		go func(session *benchSession, client *bufferclient.Client) {
+			defer writers.Done()
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / config.rate))
+			defer ticker.Stop()
+			events := client.Events()
+			for {
+				select {
+				case <-ticker.C:
+					seq := session.markSent(time.Now())
+					if client.SendInput(session.id, markerLine(seq, config.lineBytes)) != nil {
+						failed()
+					}
+				case event, ok := <-events:
+					if !ok {
+						// Connection gone: the following sends fail
+						events = nil
+					} else if event.Type == "input-error" {
+						failed()
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(session, clients[i])
+	}
+	writers.Wait()
+	return sendErrors
+}
+
+// sleep waits for d and reports whether ctx is still live.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
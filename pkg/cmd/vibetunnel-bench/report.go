@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// latencySummary is the distribution of marker delivery latencies, in
+// milliseconds.
+type latencySummary struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50Ms"`
+	P90   float64 `json:"p90Ms"`
+	P99   float64 `json:"p99Ms"`
+	Max   float64 `json:"maxMs"`
+}
+
+func summarizeLatencies(latencies []time.Duration) latencySummary {
+	if len(latencies) == 0 {
+		return latencySummary{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return latencySummary{
+		Count: len(sorted),
+		P50:   milliseconds(percentile(sorted, 50)),
+		P90:   milliseconds(percentile(sorted, 90)),
+		P99:   milliseconds(percentile(sorted, 99)),
+		Max:   milliseconds(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted, which must not
+// be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(float64(len(sorted))*p/100)) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// transportReport sums up the viewers of one transport.
+type transportReport struct {
+	Transport string `json:"transport"`
+	Viewers   int    `json:"viewers"`
+	Failed    int    `json:"failed"`
+	// Snapshots (ws) or output events (sse) per second, all viewers together
+	MessagesPerSecond float64 `json:"messagesPerSecond"`
+	BytesPerSecond    float64 `json:"bytesPerSecond"`
+	// Lines delivered per second, all viewers together
+	LinesPerSecond float64        `json:"linesPerSecond"`
+	Missed         int            `json:"missedLines"`
+	Latency        latencySummary `json:"latency"`
+	Errors         []string       `json:"errors,omitempty"`
+}
+
+// report is the result of a run, printed as text or JSON.
+type report struct {
+	Sessions       int               `json:"sessions"`
+	Viewers        int               `json:"viewers"`
+	Duration       float64           `json:"durationSeconds"`
+	RatePerSession float64           `json:"ratePerSession"`
+	LineBytes      int               `json:"lineBytes"`
+	SetupSeconds   float64           `json:"setupSeconds"`
+	LinesSent      int               `json:"linesSent"`
+	LinesPerSecond float64           `json:"linesPerSecond"`
+	SendErrors     int               `json:"sendErrors"`
+	Transports     []transportReport `json:"transports"`
+	TeardownErrors int               `json:"teardownErrors"`
+}
+
+func buildTransportReports(results []viewerResult, duration time.Duration) []transportReport {
+	var reports []transportReport
+	byTransport := map[string]int{}
+	var latencies [][]time.Duration
+	seconds := duration.Seconds()
+	for _, result := range results {
+		i, ok := byTransport[result.transport]
+		if !ok {
+			i = len(reports)
+			byTransport[result.transport] = i
+			reports = append(reports, transportReport{Transport: result.transport})
+			latencies = append(latencies, nil)
+		}
+		r := &reports[i]
+		r.Viewers++
+		if result.err != nil {
+			r.Failed++
+			r.Errors = append(r.Errors, result.err.Error())
+		}
+		r.MessagesPerSecond += float64(result.messages) / seconds
+		r.BytesPerSecond += float64(result.bytes) / seconds
+		r.LinesPerSecond += float64(len(result.latencies)) / seconds
+		r.Missed += result.missed
+		latencies[i] = append(latencies[i], result.latencies...)
+	}
+	for i := range reports {
+		reports[i].Latency = summarizeLatencies(latencies[i])
+	}
+	return reports
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r *report) writeText(w io.Writer) {
+	fmt.Fprintf(w, "%d sessions, %d viewers, %.0fs, %g lines/s per session of %d bytes (setup %.1fs)\n",
+		r.Sessions, r.Viewers, r.Duration, r.RatePerSession, r.LineBytes, r.SetupSeconds)
+	fmt.Fprintf(w, "sent: %d lines (%.1f/s), %d send errors\n", r.LinesSent, r.LinesPerSecond, r.SendErrors)
+	fmt.Fprintf(w, "%-4s %7s %7s %10s %10s %10s %7s %8s %8s %8s %8s\n",
+		"", "viewers", "failed", "msgs/s", "KB/s", "lines/s", "missed", "p50 ms", "p90 ms", "p99 ms", "max ms")
+	for _, t := range r.Transports {
+		fmt.Fprintf(w, "%-4s %7d %7d %10.1f %10.1f %10.1f %7d %8.1f %8.1f %8.1f %8.1f\n",
+			t.Transport, t.Viewers, t.Failed, t.MessagesPerSecond, t.BytesPerSecond/1024, t.LinesPerSecond,
+			t.Missed, t.Latency.P50, t.Latency.P90, t.Latency.P99, t.Latency.Max)
+		for _, err := range uniqueStrings(t.Errors) {
+			fmt.Fprintf(w, "     error: %s\n", err)
+		}
+	}
+	if r.TeardownErrors > 0 {
+		fmt.Fprintf(w, "%d sessions could not be removed\n", r.TeardownErrors)
+	}
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizeLatencies(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	summary := summarizeLatencies(latencies)
+	want := latencySummary{Count: 100, P50: 50, P90: 90, P99: 99, Max: 100}
+	if summary != want {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+	if summary := summarizeLatencies(nil); summary != (latencySummary{}) {
+		t.Errorf("empty summary = %+v", summary)
+	}
+	single := summarizeLatencies([]time.Duration{1500 * time.Microsecond})
+	if single.P50 != 1.5 || single.P99 != 1.5 {
+		t.Errorf("single summary = %+v", single)
+	}
+}
+
+func TestBuildTransportReports(t *testing.T) {
+	ms := time.Millisecond
+	reports := buildTransportReports([]viewerResult{
+		{transport: transportWebSocket, messages: 20, latencies: []time.Duration{10 * ms, 30 * ms}},
+		{transport: transportSSE, messages: 4, bytes: 400, latencies: []time.Duration{5 * ms}, missed: 1},
+		{transport: transportWebSocket, messages: 10, latencies: []time.Duration{20 * ms}, err: errors.New("closed")},
+	}, 2*time.Second)
+
+	if len(reports) != 2 {
+		t.Fatalf("reports = %+v", reports)
+	}
+	ws, sse := reports[0], reports[1]
+	if ws.Transport != transportWebSocket || ws.Viewers != 2 || ws.Failed != 1 || ws.MessagesPerSecond != 15 ||
+		ws.LinesPerSecond != 1.5 || ws.Latency.P50 != 20 || ws.Latency.Max != 30 {
+		t.Errorf("ws report = %+v", ws)
+	}
+	if sse.Transport != transportSSE || sse.BytesPerSecond != 200 || sse.Missed != 1 || sse.Latency.Count != 1 {
+		t.Errorf("sse report = %+v", sse)
+	}
+
+	var out bytes.Buffer
+	(&report{Sessions: 1, Viewers: 3, Transports: reports}).writeText(&out)
+	if text := out.String(); !strings.Contains(text, "error: closed") || !strings.Contains(text, "sse") {
+		t.Errorf("text report = %q", text)
+	}
+}
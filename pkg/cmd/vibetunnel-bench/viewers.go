@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amantus-ai/vibetunnel/pkg/client/bufferclient"
+)
+
+// Every line sent to a session starts with a marker carrying its sequence
+// number, which viewers look for in what they receive.
+var markerPattern = regexp.MustCompile(`vtb:(\d+):`)
+
+// Longest marker, kept from the end of one stream chunk for the next
+const maxMarkerLength = len("vtb:18446744073709551615:")
+
+func markerLine(seq, lineBytes int) string {
+	line := "vtb:" + strconv.Itoa(seq) + ":"
+	if pad := lineBytes - len(line); pad > 0 {
+		line += strings.Repeat("x", pad)
+	}
+	return line + "\n"
+}
+
+// benchSession is a session the benchmark writes to, with the send time of
+// each marker.
+type benchSession struct {
+	id string
+
+	mu   sync.Mutex
+	sent []time.Time // index: seq-1
+}
+
+func (s *benchSession) markSent(at time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, at)
+	return len(s.sent)
+}
+
+func (s *benchSession) sentAt(seq int) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq < 1 || seq > len(s.sent) {
+		return time.Time{}, false
+	}
+	return s.sent[seq-1], true
+}
+
+func (s *benchSession) sentCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sent)
+}
+
+// markerTracker turns the markers a viewer sees into delivery latencies.
+// Markers arrive in order, so a marker counts once, when it or any later one
+// is first seen: lines that scroll by between two snapshots are delivered
+// with the later one.
+type markerTracker struct {
+	session   *benchSession
+	last      int
+	latencies []time.Duration
+}
+
+func (t *markerTracker) observe(text string, now time.Time) {
+	highest := t.last
+	for _, match := range markerPattern.FindAllStringSubmatch(text, -1) {
+		if seq, err := strconv.Atoi(match[1]); err == nil && seq > highest {
+			highest = seq
+		}
+	}
+	for seq := t.last + 1; seq <= highest; seq++ {
+		if sent, ok := t.session.sentAt(seq); ok {
+			t.latencies = append(t.latencies, now.Sub(sent))
+		}
+	}
+	if highest > t.last {
+		t.last = highest
+	}
+}
+
+// viewerResult is what one viewer measured.
+type viewerResult struct {
+	transport string
+	// Snapshots (ws) or output events (sse)
+	messages int
+	// Output bytes (sse only; snapshots are decoded by the client)
+	bytes     int64
+	latencies []time.Duration
+	// Markers sent to the session that the viewer never saw
+	missed int
+	err    error
+}
+
+// runWebSocketViewer subscribes to the session over /buffers until ctx ends.
+func runWebSocketViewer(ctx context.Context, serverURL, token string, session *benchSession, maxFPS int) (result viewerResult) {
+	result.transport = transportWebSocket
+	tracker := &markerTracker{session: session}
+	defer func() {
+		result.latencies = tracker.latencies
+		result.missed = session.sentCount() - tracker.last
+	}()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	client, err := bufferclient.Dial(dialCtx, serverURL, &bufferclient.Options{Token: token})
+	cancel()
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer client.Close()
+	if err := client.Subscribe(session.id, &bufferclient.SubscribeOptions{MaxFPS: maxFPS}); err != nil {
+		result.err = err
+		return result
+	}
+
+	for {
+		select {
+		case update, ok := <-client.Updates():
+			if !ok {
+				result.err = client.Err()
+				return result
+			}
+			if update.SessionID == session.id {
+				result.messages++
+				tracker.observe(update.Screen.Text(), time.Now())
+			}
+		case <-client.Events():
+		case <-ctx.Done():
+			return result
+		}
+	}
+}
+
+// runSSEViewer reads the session's event stream until ctx ends.
+func runSSEViewer(ctx context.Context, api *apiClient, session *benchSession) (result viewerResult) {
+	result.transport = transportSSE
+	tracker := &markerTracker{session: session}
+	defer func() {
+		result.latencies = tracker.latencies
+		result.missed = session.sentCount() - tracker.last
+	}()
+
+	body, err := api.openStream(ctx, session.id)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer body.Close()
+	// A marker split across two events is seen with the second, which is
+	// looked at together with the end of the first
+	var tail string
+	err = readOutputEvents(body, func(output string) {
+		result.messages++
+		result.bytes += int64(len(output))
+		tracker.observe(tail+output, time.Now())
+		tail = output
+		if len(tail) > maxMarkerLength {
+			tail = tail[len(tail)-maxMarkerLength:]
+		}
+	})
+	if ctx.Err() == nil {
+		result.err = err
+	}
+	return result
+}
+
+// readOutputEvents calls onOutput with the text of each asciinema output
+// event (`data: [time, "o", text]`) of an event stream.
+func readOutputEvents(r io.Reader, onOutput func(string)) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if data, ok := strings.CutPrefix(strings.TrimRight(line, "\r\n"), "data:"); ok {
+			var event []json.RawMessage
+			var kind, output string
+			if json.Unmarshal([]byte(strings.TrimSpace(data)), &event) == nil && len(event) == 3 &&
+				json.Unmarshal(event[1], &kind) == nil && kind == "o" &&
+				json.Unmarshal(event[2], &output) == nil {
+				onOutput(output)
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkerLine(t *testing.T) {
+	if line := markerLine(12, 10); line != "vtb:12:xxx\n" {
+		t.Errorf("markerLine = %q", line)
+	}
+	if line := markerLine(12345, 4); line != "vtb:12345:\n" {
+		t.Errorf("markerLine = %q", line)
+	}
+}
+
+func TestMarkerTracker(t *testing.T) {
+	start := time.Now()
+	session := &benchSession{}
+	for i := 0; i < 4; i++ {
+		session.markSent(start.Add(time.Duration(i) * 10 * time.Millisecond))
+	}
+	tracker := &markerTracker{session: session}
+
+	tracker.observe("$ \nvtb:1:xx\n", start.Add(5*time.Millisecond))
+	// Lines 2 and 3 arrive together; line 1 is still on screen
+	tracker.observe("vtb:1:xx\nvtb:2:xx\nvtb:3:xx\n", start.Add(50*time.Millisecond))
+	tracker.observe("vtb:3:xx\n", start.Add(60*time.Millisecond))
+
+	want := []time.Duration{5 * time.Millisecond, 40 * time.Millisecond, 30 * time.Millisecond}
+	if !reflect.DeepEqual(tracker.latencies, want) {
+		t.Errorf("latencies = %v, want %v", tracker.latencies, want)
+	}
+	if tracker.last != 3 {
+		t.Errorf("last = %d, want 3", tracker.last)
+	}
+}
+
+func TestReadOutputEvents(t *testing.T) {
+	stream := strings.Join([]string{
+		`data: {"version":2,"width":80,"height":24}`,
+		``,
+		`data: [0.1,"o","vtb:1:x\r\n"]`,
+		``,
+		`data: [0.2,"r","100x30"]`,
+		`id: 7`,
+		`data: [0.3,"o","vtb:2"]`,
+		``,
+	}, "\n")
+	var outputs []string
+	if err := readOutputEvents(strings.NewReader(stream), func(output string) {
+		outputs = append(outputs, output)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"vtb:1:x\r\n", "vtb:2"}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("outputs = %q, want %q", outputs, want)
+	}
+}
+
+func TestSSEViewer(t *testing.T) {
+	start := time.Now()
+	session := &benchSession{id: "s1"}
+	session.markSent(start)
+	session.markSent(start)
+	session.markSent(start)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/sessions/s1/stream" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		// The second marker is split across two events
+		for _, output := range []string{`vtb:1:x\r\nvtb:`, `2:x\r\n`} {
+			fmt.Fprintf(w, "data: [0.1,\"o\",\"%s\"]\n\n", output)
+		}
+	}))
+	defer server.Close()
+
+	result := runSSEViewer(context.Background(), newAPIClient(server.URL, "secret"), session)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	if result.messages != 2 || result.bytes != int64(len("vtb:1:x\r\nvtb:2:x\r\n")) {
+		t.Errorf("messages = %d, bytes = %d", result.messages, result.bytes)
+	}
+	if len(result.latencies) != 2 || result.missed != 1 {
+		t.Errorf("latencies = %v, missed = %d", result.latencies, result.missed)
+	}
+}
+
+func TestSSEViewerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"Session not found"}`)
+	}))
+	defer server.Close()
+
+	result := runSSEViewer(context.Background(), newAPIClient(server.URL, ""), &benchSession{id: "gone"})
+	if result.err == nil || !strings.Contains(result.err.Error(), "Session not found") {
+		t.Errorf("err = %v", result.err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// session is the part of a GET /api/sessions entry the viewer uses.
+type session struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Command    []string `json:"command"`
+	WorkingDir string   `json:"workingDir"`
+	Status     string   `json:"status"`
+	RemoteName string   `json:"remoteName"`
+}
+
+// apiClient calls the server's REST API.
+type apiClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newAPIClient(serverURL, token string) *apiClient {
+	return &apiClient{
+		baseURL: strings.TrimSuffix(serverURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (a *apiClient) do(ctx context.Context, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+"/api"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return fmt.Errorf("%s %s: %s", method, path, failure.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (a *apiClient) listSessions(ctx context.Context) ([]session, error) {
+	var sessions []session
+	err := a.do(ctx, http.MethodGet, "/sessions", nil, &sessions)
+	return sessions, err
+}
+
+// reportSize tells the server the viewer's size, which the session's size
+// policy may apply to the PTY.
+func (a *apiClient) reportSize(ctx context.Context, sessionID, viewerID string, cols, rows int) error {
+	body := map[string]any{"cols": cols, "rows": rows, "viewerId": viewerID}
+	return a.do(ctx, http.MethodPost, "/sessions/"+url.PathEscape(sessionID)+"/resize", body, nil)
+}
+
+func (a *apiClient) removeViewer(ctx context.Context, sessionID, viewerID string) error {
+	path := "/sessions/" + url.PathEscape(sessionID) + "/viewers/" + url.PathEscape(viewerID)
+	return a.do(ctx, http.MethodDelete, path, nil, nil)
+}
@@ -0,0 +1,283 @@
+// Command vibetunnel-tui shows a VibeTunnel session live in the local
+// terminal and forwards keyboard input to it, for hosts reached over SSH where
+// the web UI is out of reach. It is started as `vibetunnel tui`.
+//
+//	vibetunnel-tui [-server URL] [-token TOKEN] [session ID or name]
+//
+// Without a session argument it lists the running sessions to choose from.
+// Ctrl-] detaches; the session keeps running.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amantus-ai/vibetunnel/pkg/client/bufferclient"
+)
+
+// Ctrl-]: leaves the viewer, as in telnet
+const detachKey = 0x1d
+
+const defaultServerURL = "http://localhost:4020"
+
+func main() {
+	flags := flag.NewFlagSet("vibetunnel tui", flag.ExitOnError)
+	serverURL := flags.String("server", envOr("VIBETUNNEL_SERVER", defaultServerURL),
+		"server URL (env VIBETUNNEL_SERVER)")
+	token := flags.String("token", os.Getenv("VIBETUNNEL_TOKEN"),
+		"JWT or API token (env VIBETUNNEL_TOKEN); not needed with --no-auth")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: vibetunnel tui [-server URL] [-token TOKEN] [session ID or name]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if err := run(*serverURL, *token, flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "vibetunnel tui:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func run(serverURL, token, selector string) error {
+	ctx := context.Background()
+	api := newAPIClient(serverURL, token)
+	sessions, err := api.listSessions(ctx)
+	if err != nil {
+		return err
+	}
+	selected, err := chooseSession(sessions, selector, bufio.NewReader(os.Stdin), os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	client, err := bufferclient.Dial(dialCtx, serverURL, &bufferclient.Options{Token: token})
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	v := &viewer{
+		api:      api,
+		client:   client,
+		session:  selected,
+		viewerID: newViewerID(),
+		fd:       int(os.Stdin.Fd()),
+		out:      os.Stdout,
+	}
+	return v.run(ctx)
+}
+
+// chooseSession picks the running session matching selector (an ID, ID
+// prefix or name), or asks for one when selector is empty.
+func chooseSession(sessions []session, selector string, in *bufio.Reader, out io.Writer) (*session, error) {
+	var running []session
+	for _, s := range sessions {
+		if s.Status == "running" {
+			running = append(running, s)
+		}
+	}
+	if len(running) == 0 {
+		return nil, errors.New("no running sessions")
+	}
+
+	if selector == "" {
+		for i, s := range running {
+			fmt.Fprintf(out, "%3d) %-24s %-30s %s\n", i+1, s.displayName(), strings.Join(s.Command, " "), s.WorkingDir)
+		}
+		fmt.Fprint(out, "Session: ")
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, err
+		}
+		selector = strings.TrimSpace(line)
+		if n, err := strconv.Atoi(selector); err == nil && n >= 1 && n <= len(running) {
+			return &running[n-1], nil
+		}
+	}
+
+	var matches []session
+	for _, s := range running {
+		if s.ID == selector || s.Name == selector {
+			return &s, nil
+		}
+		if strings.HasPrefix(s.ID, selector) {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no running session %q", selector)
+	case 1:
+		return &matches[0], nil
+	default:
+		return nil, fmt.Errorf("session %q is ambiguous", selector)
+	}
+}
+
+func (s *session) displayName() string {
+	name := s.Name
+	if name == "" {
+		name = s.ID
+	}
+	if s.RemoteName != "" {
+		name += " @" + s.RemoteName
+	}
+	return name
+}
+
+func newViewerID() string {
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	return "tui-" + hex.EncodeToString(id)
+}
+
+// viewer shows one session in the local terminal.
+type viewer struct {
+	api      *apiClient
+	client   *bufferclient.Client
+	session  *session
+	viewerID string
+	fd       int
+	out      io.Writer
+
+	screen     *bufferclient.Screen
+	cols, rows int
+	notice     string
+}
+
+func (v *viewer) run(ctx context.Context) error {
+	state, err := makeRaw(v.fd)
+	if err != nil {
+		return fmt.Errorf("terminal: %w", err)
+	}
+	// Alternate screen while attached, restored on the way out
+	io.WriteString(v.out, "\x1b[?1049h\x1b[2J")
+	defer func() {
+		io.WriteString(v.out, "\x1b[0m\x1b[?25h\x1b[?1049l")
+		restoreTerminal(v.fd, state)
+		fmt.Fprintf(v.out, "Detached from %s; it keeps running.\n", v.session.displayName())
+	}()
+
+	resized := make(chan os.Signal, 1)
+	if len(resizeSignals) > 0 {
+		signal.Notify(resized, resizeSignals...)
+		defer signal.Stop(resized)
+	}
+	v.resize(ctx)
+
+	if err := v.client.Subscribe(v.session.ID, &bufferclient.SubscribeOptions{ViewerID: v.viewerID}); err != nil {
+		return err
+	}
+	defer v.api.removeViewer(ctx, v.session.ID, v.viewerID)
+
+	detached := make(chan struct{})
+	go v.forwardInput(detached)
+
+	for {
+		select {
+		case update, ok := <-v.client.Updates():
+			if !ok {
+				if err := v.client.Err(); err != nil {
+					return err
+				}
+				return nil
+			}
+			if update.SessionID == v.session.ID {
+				v.screen = update.Screen
+				v.draw()
+			}
+		case event := <-v.client.Events():
+			v.handleEvent(event)
+		case <-resized:
+			v.resize(ctx)
+		case <-detached:
+			return nil
+		}
+	}
+}
+
+// resize reports the local size, less the status line, as the viewer's size
+// and redraws.
+func (v *viewer) resize(ctx context.Context) {
+	cols, rows, err := terminalSize(v.fd)
+	if err != nil || cols < 1 || rows < 2 {
+		cols, rows = 80, 24
+	}
+	v.cols, v.rows = cols, rows-1
+	if err := v.api.reportSize(ctx, v.session.ID, v.viewerID, v.cols, v.rows); err != nil {
+		v.notice = err.Error()
+	}
+	v.draw()
+}
+
+func (v *viewer) handleEvent(event bufferclient.Event) {
+	var message struct {
+		Error string `json:"error"`
+	}
+	switch event.Type {
+	case "input-error", "error":
+		if json.Unmarshal(event.Raw, &message) == nil && message.Error != "" {
+			v.notice = message.Error
+			v.draw()
+		}
+	case "input-ack":
+		if v.notice != "" {
+			v.notice = ""
+			v.draw()
+		}
+	}
+}
+
+// forwardInput sends keyboard input to the session until the detach key.
+func (v *viewer) forwardInput(detached chan<- struct{}) {
+	defer close(detached)
+	buf := make([]byte, 4096)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		input := buf[:n]
+		if i := bytes.IndexByte(input, detachKey); i >= 0 {
+			if i > 0 {
+				v.client.SendInput(v.session.ID, string(input[:i]))
+			}
+			return
+		}
+		if err := v.client.SendInput(v.session.ID, string(input)); err != nil {
+			return
+		}
+	}
+}
+
+func (v *viewer) draw() {
+	status := " " + v.session.displayName() + " | Ctrl-] detach"
+	if v.notice != "" {
+		status += " | " + v.notice
+	}
+	var b bytes.Buffer
+	renderScreen(&b, v.screen, v.cols, v.rows, status)
+	v.out.Write(b.Bytes())
+}
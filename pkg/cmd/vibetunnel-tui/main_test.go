@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var testSessions = []session{
+	{ID: "0a1b2c", Name: "build", Command: []string{"npm", "run", "dev"}, Status: "running"},
+	{ID: "0a9f00", Name: "shell", Command: []string{"zsh"}, Status: "running"},
+	{ID: "7e7e7e", Name: "old", Command: []string{"ls"}, Status: "exited"},
+}
+
+func TestChooseSession(t *testing.T) {
+	for selector, want := range map[string]string{
+		"build":  "0a1b2c",
+		"0a9f00": "0a9f00",
+		"0a9":    "0a9f00",
+	} {
+		s, err := chooseSession(testSessions, selector, nil, nil)
+		if err != nil || s.ID != want {
+			t.Errorf("chooseSession(%q) = %v, %v; want %s", selector, s, err, want)
+		}
+	}
+	for _, selector := range []string{"0a", "old", "nope"} {
+		if s, err := chooseSession(testSessions, selector, nil, nil); err == nil {
+			t.Errorf("chooseSession(%q) = %s, want an error", selector, s.ID)
+		}
+	}
+}
+
+func TestChooseSessionPrompt(t *testing.T) {
+	var out bytes.Buffer
+	s, err := chooseSession(testSessions, "", bufio.NewReader(strings.NewReader("2\n")), &out)
+	if err != nil || s.ID != "0a9f00" {
+		t.Fatalf("chooseSession = %v, %v", s, err)
+	}
+	if listing := out.String(); !strings.Contains(listing, "npm run dev") || strings.Contains(listing, "old") {
+		t.Errorf("listing = %q", listing)
+	}
+}
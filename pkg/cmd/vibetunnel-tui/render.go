@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/amantus-ai/vibetunnel/pkg/client/bufferclient"
+)
+
+type style struct {
+	fg, bg int32
+	attrs  uint8
+}
+
+var defaultStyle = style{fg: bufferclient.DefaultColor, bg: bufferclient.DefaultColor}
+
+// SGR parameters per attribute bit
+var attributeCodes = []struct {
+	bit  uint8
+	code string
+}{
+	{bufferclient.AttrBold, "1"},
+	{bufferclient.AttrDim, "2"},
+	{bufferclient.AttrItalic, "3"},
+	{bufferclient.AttrUnderline, "4"},
+	{bufferclient.AttrInverse, "7"},
+	{bufferclient.AttrInvisible, "8"},
+	{bufferclient.AttrStrikethrough, "9"},
+}
+
+// writeStyle writes the SGR sequence switching to s from the default style.
+func writeStyle(b *bytes.Buffer, s style) {
+	b.WriteString("\x1b[0")
+	for _, attr := range attributeCodes {
+		if s.attrs&attr.bit != 0 {
+			b.WriteString(";" + attr.code)
+		}
+	}
+	writeColor(b, "38", s.fg)
+	writeColor(b, "48", s.bg)
+	b.WriteByte('m')
+}
+
+func writeColor(b *bytes.Buffer, prefix string, color int32) {
+	switch {
+	case color < 0:
+	case color <= 255:
+		b.WriteString(";" + prefix + ";5;" + strconv.Itoa(int(color)))
+	default:
+		b.WriteString(";" + prefix + ";2;" + strconv.Itoa(int(color>>16&0xff)) + ";" +
+			strconv.Itoa(int(color>>8&0xff)) + ";" + strconv.Itoa(int(color&0xff)))
+	}
+}
+
+// renderScreen draws screen into a cols x rows area at the top of the local
+// terminal and status into the line below it. Taller screens are scrolled to
+// keep the cursor visible.
+func renderScreen(b *bytes.Buffer, screen *bufferclient.Screen, cols, rows int, status string) {
+	b.WriteString("\x1b[?25l")
+	offset := 0
+	if screen != nil && screen.CursorY >= rows {
+		offset = screen.CursorY - rows + 1
+	}
+
+	for y := 0; y < rows; y++ {
+		b.WriteString("\x1b[" + strconv.Itoa(y+1) + ";1H\x1b[0m")
+		if screen != nil && offset+y < len(screen.Cells) {
+			current := defaultStyle
+			col := 0
+			for _, cell := range screen.Cells[offset+y] {
+				// Wide characters take two columns; one not fitting is left out
+				width := max(cell.Width, 1)
+				if col+width > cols {
+					break
+				}
+				col += width
+				if s := (style{fg: cell.FG, bg: cell.BG, attrs: cell.Attributes}); s != current {
+					writeStyle(b, s)
+					current = s
+				}
+				if cell.Char == "" {
+					b.WriteByte(' ')
+				} else {
+					b.WriteString(cell.Char)
+				}
+			}
+			b.WriteString("\x1b[0m")
+		}
+		b.WriteString("\x1b[K")
+	}
+
+	// Padded rather than erased: erasing does not use the inverse colors
+	var line strings.Builder
+	width := 0
+	for _, char := range status {
+		charWidth := bufferclient.CellWidth(string(char))
+		if width+charWidth > cols {
+			break
+		}
+		line.WriteRune(char)
+		width += charWidth
+	}
+	for ; width < cols; width++ {
+		line.WriteByte(' ')
+	}
+	b.WriteString("\x1b[" + strconv.Itoa(rows+1) + ";1H\x1b[0;7m" + line.String() + "\x1b[0m")
+
+	if screen != nil {
+		x, y := screen.CursorX, screen.CursorY-offset
+		if x >= 0 && x < cols && y >= 0 && y < rows {
+			b.WriteString("\x1b[" + strconv.Itoa(y+1) + ";" + strconv.Itoa(x+1) + "H\x1b[?25h")
+		}
+	}
+}
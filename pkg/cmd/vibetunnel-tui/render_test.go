@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/amantus-ai/vibetunnel/pkg/client/bufferclient"
+)
+
+func cells(text string) []bufferclient.Cell {
+	var row []bufferclient.Cell
+	for _, char := range text {
+		row = append(row, bufferclient.Cell{Char: string(char), Width: bufferclient.CellWidth(string(char)), FG: bufferclient.DefaultColor, BG: bufferclient.DefaultColor})
+	}
+	return row
+}
+
+func TestRenderScreen(t *testing.T) {
+	row := cells("ok!")
+	row[0].Attributes = bufferclient.AttrBold
+	row[0].FG = 1
+	row[1].BG = 0x102030
+	screen := &bufferclient.Screen{Cells: [][]bufferclient.Cell{row}, CursorX: 2}
+
+	var b bytes.Buffer
+	renderScreen(&b, screen, 2, 2, "status")
+	out := b.String()
+	for _, want := range []string{
+		"\x1b[0;1;38;5;1mo",
+		"\x1b[0;48;2;16;32;48mk",
+		"\x1b[3;1H\x1b[0;7mst\x1b[0m",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q lacks %q", out, want)
+		}
+	}
+	if strings.Contains(out, "!") {
+		t.Error("cells beyond the width were drawn")
+	}
+	if strings.HasSuffix(out, "\x1b[?25h") {
+		t.Error("cursor outside the area was shown")
+	}
+}
+
+func TestRenderScreenFollowsCursor(t *testing.T) {
+	screen := &bufferclient.Screen{
+		Cells:   [][]bufferclient.Cell{cells("one"), cells("two"), cells("three")},
+		CursorY: 2,
+	}
+	var b bytes.Buffer
+	renderScreen(&b, screen, 10, 2, "")
+	out := b.String()
+	if strings.Contains(out, "one") || !strings.Contains(out, "three") {
+		t.Errorf("output %q does not end at the cursor row", out)
+	}
+	if !strings.HasSuffix(out, "\x1b[2;1H\x1b[?25h") {
+		t.Errorf("output %q does not place the cursor on row 2", out)
+	}
+}
+
+func TestRenderScreenWideCharacters(t *testing.T) {
+	// CJK and an emoji ZWJ sequence in one cell take two columns each
+	row := cells("\u4e2d\u6587")
+	row = append(row, bufferclient.Cell{
+		Char: "\U0001f469\u200d\U0001f4bb", Width: 2, FG: bufferclient.DefaultColor, BG: bufferclient.DefaultColor,
+	})
+	row = append(row, cells("ab")...)
+	screen := &bufferclient.Screen{Cells: [][]bufferclient.Cell{row}}
+
+	var b bytes.Buffer
+	renderScreen(&b, screen, 7, 1, "\u72b6\u614b ok")
+	out := b.String()
+	if !strings.Contains(out, "\u4e2d\u6587\U0001f469\u200d\U0001f4bba\x1b[0m") || strings.Contains(out, "ab") {
+		t.Errorf("output %q is not cut at 7 columns", out)
+	}
+	// The status line takes 7 columns, not 7 characters
+	if !strings.Contains(out, "\x1b[0;7m\u72b6\u614b ok\x1b[0m") {
+		t.Errorf("output %q does not pad the status line by columns", out)
+	}
+
+	// A wide character not fitting in the last column is left out
+	b.Reset()
+	renderScreen(&b, screen, 3, 1, "")
+	if out := b.String(); !strings.Contains(out, "\u4e2d\x1b[0m") || strings.Contains(out, "\u6587") {
+		t.Errorf("output %q draws a wide character past the last column", out)
+	}
+}
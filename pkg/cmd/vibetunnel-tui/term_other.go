@@ -0,0 +1,26 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+type terminalState struct{}
+
+var errUnsupportedTerminal = errors.New("terminal control is not supported on this platform")
+
+func makeRaw(fd int) (*terminalState, error) {
+	return nil, errUnsupportedTerminal
+}
+
+func restoreTerminal(fd int, state *terminalState) error {
+	return errUnsupportedTerminal
+}
+
+func terminalSize(fd int) (cols, rows int, err error) {
+	return 0, 0, errUnsupportedTerminal
+}
+
+var resizeSignals []os.Signal
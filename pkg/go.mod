@@ -0,0 +1,3 @@
+module github.com/amantus-ai/vibetunnel/pkg
+
+go 1.22
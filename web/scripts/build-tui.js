@@ -0,0 +1,27 @@
+#!/usr/bin/env node
+
+/**
+ * Builds the Go terminal viewer used by `vibetunnel tui` into native/, next to
+ * the vibetunnel executable where the CLI looks for it.
+ * Skipped with a warning when Go is not installed.
+ */
+
+const { execSync } = require('child_process');
+const fs = require('fs');
+const path = require('path');
+
+const pkgDir = path.join(__dirname, '..', '..', 'pkg');
+const binary = process.platform === 'win32' ? 'vibetunnel-tui.exe' : 'vibetunnel-tui';
+const output = path.join(__dirname, '..', 'native', binary);
+
+try {
+  execSync('go version', { stdio: 'ignore' });
+} catch (e) {
+  console.warn(`Go not found, skipping ${binary}; \`vibetunnel tui\` needs it on the PATH`);
+  process.exit(0);
+}
+
+console.log(`Building ${binary}...`);
+fs.mkdirSync(path.dirname(output), { recursive: true });
+execSync(`go build -o "${output}" ./cmd/vibetunnel-tui`, { cwd: pkgDir, stdio: 'inherit' });
+console.log(`${binary} created in native/`);
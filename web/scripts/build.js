@@ -111,6 +111,9 @@ async function build() {
     execSync('node build-native.js', { stdio: 'inherit' });
   }
 
+  // Build the terminal viewer for `vibetunnel tui`
+  execSync('node scripts/build-tui.js', { stdio: 'inherit' });
+
   console.log('Build completed successfully!');
 }
 
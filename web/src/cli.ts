@@ -2,6 +2,8 @@
 // Entry point for the server - imports the modular server which starts automatically
 import { startVibeTunnelForward } from './server/fwd.js';
 import { startVibeTunnelServer } from './server/server.js';
+import { startVibeTunnelShim } from './server/shim.js';
+import { startVibeTunnelTui } from './server/tui.js';
 import { closeLogger, createLogger, initLogger } from './server/utils/logger.js';
 import { VERSION } from './server/version.js';
 
@@ -54,6 +56,15 @@ if (!module.parent && (require.main === module || require.main === undefined)) {
       closeLogger();
       process.exit(1);
     });
+  } else if (process.argv[2] === 'shim') {
+    const code = startVibeTunnelShim(process.argv.slice(3));
+    closeLogger();
+    process.exit(code);
+  } else if (process.argv[2] === 'tui') {
+    startVibeTunnelTui(process.argv.slice(3)).then((code) => {
+      closeLogger();
+      process.exit(code);
+    });
   } else {
     logger.log('Starting VibeTunnel server...');
     startVibeTunnelServer();
@@ -74,6 +74,7 @@ export class VibeTunnelApp extends LitElement {
   private errorTimeoutId: number | null = null;
   private successTimeoutId: number | null = null;
   private autoRefreshIntervalId: number | null = null;
+  private followSource: EventSource | null = null;
   private responsiveUnsubscribe?: () => void;
   private resizeCleanupFunctions: (() => void)[] = [];
 
@@ -122,6 +123,8 @@ export class VibeTunnelApp extends LitElement {
       clearInterval(this.autoRefreshIntervalId);
       this.autoRefreshIntervalId = null;
     }
+    this.followSource?.close();
+    this.followSource = null;
     // Clean up responsive observer
     if (this.responsiveUnsubscribe) {
       this.responsiveUnsubscribe();
@@ -176,6 +179,7 @@ export class VibeTunnelApp extends LitElement {
           await this.initializeServices(); // Initialize services after auth
           await this.loadSessions(); // Wait for sessions to load
           this.startAutoRefresh();
+          this.startFollowMode();
           return;
         }
       }
@@ -191,6 +195,7 @@ export class VibeTunnelApp extends LitElement {
       await this.initializeServices(); // Initialize services after auth
       await this.loadSessions(); // Wait for sessions to load
       this.startAutoRefresh();
+      this.startFollowMode();
     } else {
       this.currentView = 'auth';
     }
@@ -203,6 +208,7 @@ export class VibeTunnelApp extends LitElement {
     await this.initializeServices(); // Initialize services after auth
     await this.loadSessions();
     this.startAutoRefresh();
+    this.startFollowMode();
 
     // Check if there was a session ID in the URL that we should navigate to
     const url = new URL(window.location.href);
@@ -402,6 +408,38 @@ export class VibeTunnelApp extends LitElement {
     }, TIMING.AUTO_REFRESH_INTERVAL);
   }
 
+  /**
+   * Follow mode (`?follow` in the URL): show whichever session the server reports
+   * as needing eyes, for wall dashboards
+   */
+  private startFollowMode() {
+    if (this.followSource || !new URL(window.location.href).searchParams.has('follow')) return;
+
+    // EventSource cannot send headers, so the token goes in the query
+    const token = authClient.getCurrentUser()?.token;
+    const url = `/api/sessions/focus${token ? `?token=${encodeURIComponent(token)}` : ''}`;
+    this.followSource = new EventSource(url);
+    this.followSource.addEventListener('focus', (event) => {
+      const { sessionId } = JSON.parse((event as MessageEvent).data);
+      this.followSession(sessionId).catch((error) => {
+        logger.error('failed to follow session:', error);
+      });
+    });
+    logger.log('follow mode enabled');
+  }
+
+  private async followSession(sessionId: string | null) {
+    if (!sessionId || sessionId === this.selectedSessionId) return;
+    // The focus can move to a session created since the last refresh
+    if (!this.sessions.some((session) => session.id === sessionId)) {
+      await this.loadSessions();
+      if (!this.sessions.some((session) => session.id === sessionId)) return;
+    }
+    await this.handleNavigateToSession(
+      new CustomEvent('navigate-to-session', { detail: { sessionId } })
+    );
+  }
+
   private async handleSessionCreated(e: CustomEvent) {
     const sessionId = e.detail.sessionId;
     const message = e.detail.message;
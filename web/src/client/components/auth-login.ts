@@ -1,6 +1,6 @@
 import { html, LitElement } from 'lit';
 import { customElement, property, state } from 'lit/decorators.js';
-import type { AuthClient } from '../services/auth-client.js';
+import type { AuthClient, AuthResponse } from '../services/auth-client.js';
 import { responsiveObserver } from '../utils/responsive-utils.js';
 import './terminal-icon.js';
 
@@ -17,11 +17,16 @@ export class AuthLogin extends LitElement {
   @state() private success = '';
   @state() private currentUserId = '';
   @state() private loginPassword = '';
+  // Login waiting to be confirmed with a second factor
+  @state() private pendingSecondFactor: AuthResponse | null = null;
+  @state() private secondFactorCode = '';
   @state() private userAvatar = '';
   @state() private authConfig = {
     enableSSHKeys: false,
     disallowUserPassword: false,
     noAuth: false,
+    oidc: false,
+    oidcOnly: false,
   };
   @state() private isMobile = false;
   private unsubscribeResponsive?: () => void;
@@ -60,6 +65,10 @@ export class AuthLogin extends LitElement {
         console.error('❌ Error loading auth config:', error);
       }
 
+      if (this.authConfig.oidc && (await this.resumeSingleSignOn())) {
+        return;
+      }
+
       this.currentUserId = await this.authClient.getCurrentSystemUser();
       console.log('👤 Current user:', this.currentUserId);
 
@@ -85,6 +94,57 @@ export class AuthLogin extends LitElement {
     }
   }
 
+  /**
+   * Finish a single sign-on login the provider redirected back from.
+   * Returns whether the user is signed in.
+   */
+  private async resumeSingleSignOn(): Promise<boolean> {
+    const url = new URL(window.location.href);
+    const ssoError = url.searchParams.get('sso_error');
+    if (ssoError !== null) {
+      this.error = ssoError || 'Single sign-on failed';
+      url.searchParams.delete('sso_error');
+      window.history.replaceState(null, '', url.toString());
+      return false;
+    }
+
+    const result = await this.authClient.resumeSingleSignOn();
+    if (result.success) {
+      console.log('🎫 Resumed single sign-on session for', result.userId);
+      this.dispatchEvent(new CustomEvent('auth-success', { detail: result }));
+    }
+    return result.success;
+  }
+
+  private handleSingleSignOn() {
+    this.loading = true;
+    this.authClient.startSingleSignOn();
+  }
+
+  private renderSingleSignOn() {
+    return html`
+      <div class="p-5 sm:p-8">
+        <button
+          class="btn-primary w-full py-3 sm:py-4"
+          data-testid="sso-login"
+          @click=${this.handleSingleSignOn}
+          ?disabled=${this.loading}
+        >
+          ${this.loading ? 'Redirecting...' : 'Sign in with Single Sign-On'}
+        </button>
+      </div>
+      ${
+        !this.authConfig.oidcOnly
+          ? html`
+            <div class="auth-divider py-2 sm:py-3">
+              <span>or</span>
+            </div>
+          `
+          : ''
+      }
+    `;
+  }
+
   private async handlePasswordLogin(e: Event) {
     e.preventDefault();
     if (this.loading) return;
@@ -100,7 +160,10 @@ export class AuthLogin extends LitElement {
       );
       console.log('🎫 Password auth result:', result);
 
-      if (result.success) {
+      if (result.secondFactorRequired) {
+        this.loginPassword = '';
+        this.pendingSecondFactor = result;
+      } else if (result.success) {
         this.loginPassword = '';
         this.dispatchEvent(new CustomEvent('auth-success', { detail: result }));
       } else {
@@ -124,7 +187,9 @@ export class AuthLogin extends LitElement {
       const authResult = await this.authClient.authenticate(this.currentUserId);
       console.log('🎯 SSH auth result:', authResult);
 
-      if (authResult.success) {
+      if (authResult.secondFactorRequired) {
+        this.pendingSecondFactor = authResult;
+      } else if (authResult.success) {
         this.dispatchEvent(new CustomEvent('auth-success', { detail: authResult }));
       } else {
         this.error =
@@ -138,6 +203,99 @@ export class AuthLogin extends LitElement {
     }
   }
 
+  private async handleSecondFactor(verify: (pendingToken: string) => Promise<AuthResponse>) {
+    const pendingToken = this.pendingSecondFactor?.pendingToken;
+    if (this.loading || !pendingToken) return;
+
+    this.loading = true;
+    this.error = '';
+    try {
+      const result = await verify(pendingToken);
+      if (result.success) {
+        this.pendingSecondFactor = null;
+        this.secondFactorCode = '';
+        this.dispatchEvent(new CustomEvent('auth-success', { detail: result }));
+      } else {
+        this.error = result.error || 'Second factor verification failed';
+      }
+    } finally {
+      this.loading = false;
+    }
+  }
+
+  private handleSecondFactorCode(e: Event) {
+    e.preventDefault();
+    const code = this.secondFactorCode;
+    this.handleSecondFactor((pendingToken) =>
+      this.authClient.verifySecondFactorCode(pendingToken, code)
+    );
+  }
+
+  private handleSecurityKey() {
+    this.handleSecondFactor((pendingToken) => this.authClient.verifySecurityKey(pendingToken));
+  }
+
+  private renderSecondFactor() {
+    const methods = this.pendingSecondFactor?.methods ?? [];
+    return html`
+      <div class="p-5 sm:p-8 space-y-3" data-testid="second-factor">
+        <p class="text-dark-text text-sm text-center">Confirm your login with a second factor</p>
+        ${
+          methods.includes('totp')
+            ? html`
+              <form @submit=${this.handleSecondFactorCode} class="space-y-3">
+                <input
+                  type="text"
+                  class="input-field"
+                  data-testid="second-factor-code"
+                  placeholder="Authenticator code"
+                  inputmode="numeric"
+                  autocomplete="one-time-code"
+                  .value=${this.secondFactorCode}
+                  @input=${(e: Event) => {
+                    this.secondFactorCode = (e.target as HTMLInputElement).value;
+                  }}
+                  ?disabled=${this.loading}
+                  required
+                />
+                <button
+                  type="submit"
+                  class="btn-primary w-full py-3"
+                  ?disabled=${this.loading || !this.secondFactorCode}
+                >
+                  ${this.loading ? 'Verifying...' : 'Verify Code'}
+                </button>
+              </form>
+            `
+            : ''
+        }
+        ${
+          methods.includes('webauthn')
+            ? html`
+              <button
+                class="btn-secondary w-full py-3"
+                data-testid="second-factor-security-key"
+                @click=${this.handleSecurityKey}
+                ?disabled=${this.loading}
+              >
+                Use Security Key
+              </button>
+            `
+            : ''
+        }
+        <button
+          class="btn-ghost w-full text-xs"
+          @click=${() => {
+            this.pendingSecondFactor = null;
+            this.secondFactorCode = '';
+          }}
+        >
+          Cancel
+        </button>
+      </div>
+    `;
+  }
+
   private handleShowSSHKeyManager() {
     this.dispatchEvent(new CustomEvent('show-ssh-key-manager'));
   }
@@ -223,7 +381,11 @@ export class AuthLogin extends LitElement {
           }
 
           <div class="auth-form">
+            ${this.pendingSecondFactor ? this.renderSecondFactor() : ''}
+            ${!this.pendingSecondFactor && this.authConfig.oidc ? this.renderSingleSignOn() : ''}
             ${
+              !this.pendingSecondFactor &&
+              !this.authConfig.oidcOnly &&
               !this.authConfig.disallowUserPassword
                 ? html`
                   <!-- Password Login Section (Primary) -->
@@ -292,6 +454,8 @@ export class AuthLogin extends LitElement {
                 : ''
             }
             ${
+              !this.pendingSecondFactor &&
+              !this.authConfig.oidcOnly &&
               this.authConfig.disallowUserPassword
                 ? html`
                   <!-- Avatar for SSH-only mode -->
@@ -342,6 +506,8 @@ export class AuthLogin extends LitElement {
                 : ''
             }
             ${
+              !this.pendingSecondFactor &&
+              !this.authConfig.oidcOnly &&
               this.authConfig.enableSSHKeys === true
                 ? html`
                   <!-- Divider (only show if password auth is also available) -->
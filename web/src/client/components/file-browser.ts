@@ -54,14 +54,25 @@ interface GitStatus {
   untracked: string[];
 }
 
+interface ArchiveEntry {
+  name: string;
+  type: 'file' | 'directory' | 'symlink' | 'other';
+  size: number;
+  modified: string;
+}
+
 interface FilePreview {
-  type: 'image' | 'text' | 'binary';
+  type: 'image' | 'text' | 'binary' | 'archive';
   content?: string;
   language?: string;
   url?: string;
   mimeType?: string;
   size: number;
   humanSize?: string;
+  // Archives
+  archiveType?: 'zip' | 'tar' | 'tar.gz';
+  entries?: ArchiveEntry[];
+  truncated?: boolean;
 }
 
 interface FileDiff {
@@ -118,7 +129,7 @@ export class FileBrowser extends LitElement {
     await this.checkAuthConfig();
 
     if (this.visible) {
-      this.currentPath = this.session?.workingDir || '.';
+      this.currentPath = this.startPath;
       await this.loadDirectory(this.currentPath);
     }
     document.addEventListener('keydown', this.handleKeyDown);
@@ -126,12 +137,26 @@ export class FileBrowser extends LitElement {
     this.setupTouchHandlers();
   }
 
+  // Where the shell currently is (reported with OSC 7), else where the session started
+  private get startPath(): string {
+    return this.session?.currentWorkingDir || this.session?.workingDir || '.';
+  }
+
+  // URL of a filesystem endpoint; a remote session's files are served by its remote via HQ
+  private fsUrl(endpoint: string, params: Record<string, string>): string {
+    const query = new URLSearchParams(params);
+    if (this.session?.source === 'remote') {
+      query.set('sessionId', this.session.id);
+    }
+    return `/api/fs/${endpoint}?${query}`;
+  }
+
   async updated(changedProperties: Map<string, unknown>) {
     super.updated(changedProperties);
 
     if (changedProperties.has('visible') || changedProperties.has('session')) {
       if (this.visible) {
-        this.currentPath = this.session?.workingDir || '.';
+        this.currentPath = this.startPath;
         await this.loadDirectory(this.currentPath);
       }
     }
@@ -142,13 +167,11 @@ export class FileBrowser extends LitElement {
   private async loadDirectory(dirPath: string) {
     this.loading = true;
     try {
-      const params = new URLSearchParams({
+      const url = this.fsUrl('browse', {
         path: dirPath,
         showHidden: this.showHidden.toString(),
         gitFilter: this.gitFilter,
       });
-
-      const url = `/api/fs/browse?${params}`;
       logger.debug(`loading directory: ${dirPath}`);
       logger.debug(`fetching URL: ${url}`);
 
@@ -198,9 +221,7 @@ export class FileBrowser extends LitElement {
       logger.debug(`file path: ${file.path}`);
 
       const headers = this.noAuthMode ? {} : { ...authClient.getAuthHeader() };
-      const response = await fetch(`/api/fs/preview?path=${encodeURIComponent(file.path)}`, {
-        headers,
-      });
+      const response = await fetch(this.fsUrl('preview', { path: file.path }), { headers });
       if (response.ok) {
         this.preview = await response.json();
         this.requestUpdate(); // Trigger re-render to initialize Monaco if needed
@@ -224,12 +245,8 @@ export class FileBrowser extends LitElement {
       // Load both the unified diff and the full content for Monaco
       const headers = this.noAuthMode ? {} : { ...authClient.getAuthHeader() };
       const [diffResponse, contentResponse] = await Promise.all([
-        fetch(`/api/fs/diff?path=${encodeURIComponent(file.path)}`, {
-          headers,
-        }),
-        fetch(`/api/fs/diff-content?path=${encodeURIComponent(file.path)}`, {
-          headers,
-        }),
+        fetch(this.fsUrl('diff', { path: file.path }), { headers }),
+        fetch(this.fsUrl('diff-content', { path: file.path }), { headers }),
       ]);
 
       if (diffResponse.ok) {
@@ -265,6 +282,28 @@ export class FileBrowser extends LitElement {
     }
   }
 
+  private async openArchiveMember(entry: ArchiveEntry) {
+    if (!this.selectedFile || entry.type !== 'file') return;
+
+    try {
+      const headers = this.noAuthMode ? {} : { ...authClient.getAuthHeader() };
+      const response = await fetch(
+        this.fsUrl('archive/raw', { path: this.selectedFile.path, member: entry.name }),
+        { headers }
+      );
+      if (!response.ok) {
+        logger.error(`archive member failed: ${response.status}`, new Error(await response.text()));
+        return;
+      }
+      const url = URL.createObjectURL(await response.blob());
+      window.open(url, '_blank');
+      // The new tab has loaded the blob by then
+      setTimeout(() => URL.revokeObjectURL(url), 60000);
+    } catch (error) {
+      logger.error('error opening archive member:', error);
+    }
+  }
+
   private async handleCopyToClipboard(text: string) {
     const success = await copyToClipboard(text);
     if (success) {
@@ -445,6 +484,34 @@ export class FileBrowser extends LitElement {
             </div>
           </div>
         `;
+
+      case 'archive':
+        return html`
+          <div class="h-full overflow-y-auto p-2 font-mono text-xs">
+            <div class="px-2 pb-2 text-dark-text-muted">
+              ${this.preview.archiveType} · ${this.preview.entries?.length ?? 0} entries ·
+              ${this.preview.humanSize}${this.preview.truncated ? ' (listing truncated)' : ''}
+            </div>
+            ${(this.preview.entries || []).map(
+              (entry) => html`
+                <div
+                  class="flex justify-between gap-4 px-2 py-1 rounded ${
+                    entry.type === 'file'
+                      ? 'cursor-pointer hover:bg-dark-bg-lighter'
+                      : 'text-dark-text-muted'
+                  }"
+                  title=${entry.name}
+                  @click=${() => this.openArchiveMember(entry)}
+                >
+                  <span class="truncate">${entry.name}${entry.type === 'directory' ? '/' : ''}</span>
+                  <span class="flex-shrink-0 text-dark-text-muted">
+                    ${entry.type === 'file' ? entry.size : ''}
+                  </span>
+                </div>
+              `
+            )}
+          </div>
+        `;
     }
   }
 
@@ -0,0 +1,213 @@
+import { html, LitElement, svg } from 'lit';
+import { customElement, state } from 'lit/decorators.js';
+import { encodeQrCode } from '../../shared/qr-code.js';
+import { authClient } from '../services/auth-client.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('mobile-pairing');
+
+// How often the pairing is checked for a device claiming it
+const POLL_INTERVAL_MS = 2000;
+
+interface Pairing {
+  id: string;
+  code: string;
+  status: 'waiting' | 'claimed' | 'approved' | 'denied';
+  expiresAt: string;
+  device?: { name: string; ip: string; claimedAt: string };
+  url: string;
+}
+
+@customElement('mobile-pairing')
+export class MobilePairing extends LitElement {
+  // Disable shadow DOM to use Tailwind
+  createRenderRoot() {
+    return this;
+  }
+
+  @state() private pairing: Pairing | null = null;
+  @state() private error = '';
+  @state() private loading = false;
+
+  private pollTimer: number | null = null;
+
+  disconnectedCallback() {
+    super.disconnectedCallback();
+    this.cancelPairing();
+  }
+
+  private async request(path: string, method = 'GET') {
+    const response = await fetch(`/api/pairing${path}`, {
+      method,
+      headers: { 'Content-Type': 'application/json', ...authClient.getAuthHeader() },
+    });
+    const data = await response.json().catch(() => ({}));
+    if (!response.ok) {
+      throw new Error(data.error || `Request failed (${response.status})`);
+    }
+    return data;
+  }
+
+  private async startPairing() {
+    this.loading = true;
+    this.error = '';
+    try {
+      this.pairing = await this.request('', 'POST');
+      this.startPolling();
+    } catch (error) {
+      logger.error('failed to start pairing', error);
+      this.error = error instanceof Error ? error.message : String(error);
+    } finally {
+      this.loading = false;
+    }
+  }
+
+  private startPolling() {
+    this.stopPolling();
+    this.pollTimer = window.setInterval(() => this.refresh(), POLL_INTERVAL_MS);
+  }
+
+  private stopPolling() {
+    if (this.pollTimer !== null) {
+      clearInterval(this.pollTimer);
+      this.pollTimer = null;
+    }
+  }
+
+  private async refresh() {
+    const current = this.pairing;
+    if (!current) return;
+    try {
+      const info = await this.request(`/${current.id}`);
+      this.pairing = { ...info, url: current.url };
+    } catch {
+      // Expired, or collected by the device after approval
+      this.stopPolling();
+      this.pairing = null;
+      if (current.status !== 'approved') {
+        this.error = 'Pairing expired';
+      }
+    }
+  }
+
+  private async decide(action: 'approve' | 'deny') {
+    if (!this.pairing) return;
+    try {
+      const info = await this.request(`/${this.pairing.id}/${action}`, 'POST');
+      this.pairing = { ...info, url: this.pairing.url };
+      this.dispatchEvent(
+        new CustomEvent('success', {
+          detail: action === 'approve' ? 'Device paired' : 'Pairing denied',
+          bubbles: true,
+        })
+      );
+    } catch (error) {
+      this.error = error instanceof Error ? error.message : String(error);
+    }
+  }
+
+  private cancelPairing() {
+    this.stopPolling();
+    if (this.pairing && this.pairing.status !== 'approved') {
+      this.request(`/${this.pairing.id}`, 'DELETE').catch(() => {});
+    }
+    this.pairing = null;
+  }
+
+  private renderQrCode(text: string) {
+    const modules = encodeQrCode(text);
+    // Quiet zone of 4 modules around the code
+    const size = modules.length + 8;
+    let path = '';
+    modules.forEach((row, y) => {
+      row.forEach((dark, x) => {
+        if (dark) path += `M${x + 4} ${y + 4}h1v1h-1z`;
+      });
+    });
+    return html`
+      <svg
+        class="w-48 h-48 rounded bg-white"
+        viewBox="0 0 ${size} ${size}"
+        shape-rendering="crispEdges"
+        role="img"
+        aria-label="Pairing QR code"
+      >
+        ${svg`<path d=${path} fill="#000"></path>`}
+      </svg>
+    `;
+  }
+
+  private renderPairing(pairing: Pairing) {
+    if (pairing.status === 'waiting') {
+      return html`
+        <div class="flex flex-col items-center gap-3">
+          ${this.renderQrCode(pairing.url)}
+          <p class="text-dark-text-muted text-xs text-center">
+            Scan with the VibeTunnel app or enter the code
+          </p>
+          <p class="font-mono text-lg text-dark-text tracking-widest">
+            ${pairing.code.slice(0, 4)}-${pairing.code.slice(4)}
+          </p>
+          <p class="text-dark-text-muted text-xs">
+            Expires at ${new Date(pairing.expiresAt).toLocaleTimeString()}
+          </p>
+        </div>
+      `;
+    }
+    if (pairing.status === 'claimed' && pairing.device) {
+      return html`
+        <div class="space-y-3">
+          <p class="text-dark-text text-sm">
+            <span class="font-medium">${pairing.device.name}</span>
+            <span class="text-dark-text-muted">(${pairing.device.ip})</span>
+            wants to connect to your account.
+          </p>
+          <div class="flex gap-2">
+            <button class="btn-primary text-sm" @click=${() => this.decide('approve')}>
+              Approve
+            </button>
+            <button class="btn-secondary text-sm" @click=${() => this.decide('deny')}>
+              Deny
+            </button>
+          </div>
+        </div>
+      `;
+    }
+    return html`
+      <p class="text-dark-text-muted text-sm">
+        ${pairing.status === 'approved' ? 'Device approved.' : 'Pairing denied.'}
+      </p>
+    `;
+  }
+
+  render() {
+    const undecided = this.pairing?.status === 'waiting' || this.pairing?.status === 'claimed';
+    return html`
+      <div class="p-4 bg-dark-bg-tertiary rounded-lg border border-dark-border space-y-3">
+        <div class="flex items-center justify-between">
+          <div class="flex-1">
+            <label class="text-dark-text font-medium">Pair Mobile Device</label>
+            <p class="text-dark-text-muted text-xs mt-1">
+              Connect the iOS app by scanning a QR code
+            </p>
+          </div>
+          ${
+            this.pairing
+              ? html`<button class="btn-ghost text-sm" @click=${() => this.cancelPairing()}>
+                  ${undecided ? 'Cancel' : 'Done'}
+                </button>`
+              : html`<button
+                  class="btn-secondary text-sm"
+                  ?disabled=${this.loading}
+                  @click=${() => this.startPairing()}
+                >
+                  ${this.loading ? 'Starting...' : 'Pair'}
+                </button>`
+          }
+        </div>
+        ${this.error ? html`<p class="text-status-error text-xs">${this.error}</p>` : ''}
+        ${this.pairing ? this.renderPairing(this.pairing) : ''}
+      </div>
+    `;
+  }
+}
@@ -5,12 +5,43 @@
  * for terminal sessions.
  */
 
+import { isSpecialKey } from '../../../shared/keymap.js';
 import { authClient } from '../../services/auth-client.js';
 import { createLogger } from '../../utils/logger.js';
 import type { Session } from '../session-list.js';
 
 const logger = createLogger('input-manager');
 
+// Browser KeyboardEvent.key values for keys without a direct text representation
+const BROWSER_KEY_NAMES: Record<string, string> = {
+  Home: 'home',
+  End: 'end',
+  PageUp: 'page_up',
+  PageDown: 'page_down',
+  Insert: 'insert',
+  F1: 'f1',
+  F2: 'f2',
+  F3: 'f3',
+  F4: 'f4',
+  F5: 'f5',
+  F6: 'f6',
+  F7: 'f7',
+  F8: 'f8',
+  F9: 'f9',
+  F10: 'f10',
+  F11: 'f11',
+  F12: 'f12',
+};
+
+// Prefix a key name with the pressed modifiers (e.g. "ctrl_shift_arrow_left")
+function withModifiers(key: string, e: KeyboardEvent): string {
+  let prefix = '';
+  if (e.ctrlKey) prefix += 'ctrl_';
+  if (e.altKey) prefix += 'alt_';
+  if (e.shiftKey) prefix += 'shift_';
+  return prefix + key;
+}
+
 export interface InputManagerCallbacks {
   requestUpdate(): void;
 }
@@ -75,16 +106,16 @@ export class InputManager {
         inputText = 'escape';
         break;
       case 'ArrowUp':
-        inputText = 'arrow_up';
+        inputText = withModifiers('arrow_up', e);
         break;
       case 'ArrowDown':
-        inputText = 'arrow_down';
+        inputText = withModifiers('arrow_down', e);
         break;
       case 'ArrowLeft':
-        inputText = 'arrow_left';
+        inputText = withModifiers('arrow_left', e);
         break;
       case 'ArrowRight':
-        inputText = 'arrow_right';
+        inputText = withModifiers('arrow_right', e);
         break;
       case 'Tab':
         inputText = e.shiftKey ? 'shift_tab' : 'tab';
@@ -93,7 +124,26 @@ export class InputManager {
         inputText = 'backspace';
         break;
       case 'Delete':
-        inputText = 'delete';
+        inputText = withModifiers('delete', e);
+        break;
+      case 'Home':
+      case 'End':
+      case 'PageUp':
+      case 'PageDown':
+      case 'Insert':
+      case 'F1':
+      case 'F2':
+      case 'F3':
+      case 'F4':
+      case 'F5':
+      case 'F6':
+      case 'F7':
+      case 'F8':
+      case 'F9':
+      case 'F10':
+      case 'F11':
+      case 'F12':
+        inputText = withModifiers(BROWSER_KEY_NAMES[e.key], e);
         break;
       case ' ':
         inputText = ' ';
@@ -127,36 +177,7 @@ export class InputManager {
 
     try {
       // Determine if we should send as key or text
-      const body = [
-        'enter',
-        'escape',
-        'backspace',
-        'tab',
-        'shift_tab',
-        'arrow_up',
-        'arrow_down',
-        'arrow_left',
-        'arrow_right',
-        'ctrl_enter',
-        'shift_enter',
-        'page_up',
-        'page_down',
-        'home',
-        'end',
-        'delete',
-        'f1',
-        'f2',
-        'f3',
-        'f4',
-        'f5',
-        'f6',
-        'f7',
-        'f8',
-        'f9',
-        'f10',
-        'f11',
-        'f12',
-      ].includes(text)
+      const body = isSpecialKey(text)
         ? { key: text }
         : { text };
 
@@ -192,36 +213,7 @@ export class InputManager {
   private async sendInput(inputText: string): Promise<void> {
     try {
       // Determine if we should send as key or text
-      const body = [
-        'enter',
-        'escape',
-        'backspace',
-        'tab',
-        'shift_tab',
-        'arrow_up',
-        'arrow_down',
-        'arrow_left',
-        'arrow_right',
-        'ctrl_enter',
-        'shift_enter',
-        'page_up',
-        'page_down',
-        'home',
-        'end',
-        'delete',
-        'f1',
-        'f2',
-        'f3',
-        'f4',
-        'f5',
-        'f6',
-        'f7',
-        'f8',
-        'f9',
-        'f10',
-        'f11',
-        'f12',
-      ].includes(inputText)
+      const body = isSpecialKey(inputText)
         ? { key: inputText }
         : { text: inputText };
 
@@ -35,6 +35,10 @@ export class TerminalLifecycleManager {
   private resizeTimeout: number | null = null;
   private lastResizeWidth = 0;
   private lastResizeHeight = 0;
+  // Identifies this view in server-side size negotiation between viewers
+  private viewerId = `viewer-${Math.random().toString(36).substr(2, 9)}`;
+  // Set once the server reports that clients may not resize sessions
+  private resizeDisabled = false;
   private domElement: Element | null = null;
   private eventHandlers: TerminalEventHandlers | null = null;
   private stateCallbacks: TerminalStateCallbacks | null = null;
@@ -169,7 +173,7 @@ export class TerminalLifecycleManager {
       }
 
       // Send resize request to backend if session is active
-      if (this.session && this.session.status !== 'exited') {
+      if (this.session && this.session.status !== 'exited' && !this.resizeDisabled) {
         try {
           logger.debug(
             `sending resize request: ${cols}x${rows} (was ${this.lastResizeWidth}x${this.lastResizeHeight})`
@@ -181,13 +185,21 @@ export class TerminalLifecycleManager {
               'Content-Type': 'application/json',
               ...authClient.getAuthHeader(),
             },
-            body: JSON.stringify({ cols: cols, rows: rows }),
+            body: JSON.stringify({ cols: cols, rows: rows, viewerId: this.viewerId }),
           });
 
           if (response.ok) {
             // Cache the successfully sent dimensions
             this.lastResizeWidth = cols;
             this.lastResizeHeight = rows;
+          } else if (response.status === 403) {
+            const error = await response.json().catch(() => ({}));
+            if (error.code === 'RESIZE_DISABLED') {
+              logger.log('terminal resizing is disabled by the server');
+              this.resizeDisabled = true;
+            } else {
+              logger.warn(`failed to resize session: ${response.status}`);
+            }
           } else {
             logger.warn(`failed to resize session: ${response.status}`);
           }
@@ -244,5 +256,14 @@ export class TerminalLifecycleManager {
       clearTimeout(this.resizeTimeout);
       this.resizeTimeout = null;
     }
+
+    // Stop counting this view's size for the session
+    if (this.session && this.lastResizeWidth > 0) {
+      fetch(`/api/sessions/${this.session.id}/viewers/${this.viewerId}`, {
+        method: 'DELETE',
+        headers: authClient.getAuthHeader(),
+        keepalive: true,
+      }).catch((error) => logger.debug('failed to remove viewer', error));
+    }
   }
 }
@@ -7,6 +7,7 @@ import {
 } from '../services/push-notification-service.js';
 import { createLogger } from '../utils/logger.js';
 import { type MediaQueryState, responsiveObserver } from '../utils/responsive-utils.js';
+import './mobile-pairing.js';
 
 const logger = createLogger('unified-settings');
 
@@ -506,6 +507,9 @@ export class UnifiedSettings extends LitElement {
             ></span>
           </button>
         </div>
+
+        <!-- Pair mobile device -->
+        <mobile-pairing></mobile-pairing>
       </div>
     `;
   }
@@ -1,11 +1,15 @@
 import { BrowserSSHAgent } from './ssh-agent.js';
 
-interface AuthResponse {
+export interface AuthResponse {
   success: boolean;
   token?: string;
   userId?: string;
-  authMethod?: 'ssh-key' | 'password';
+  authMethod?: 'ssh-key' | 'password' | 'oidc';
   error?: string;
+  // The login must be confirmed with one of the user's second factors
+  secondFactorRequired?: boolean;
+  pendingToken?: string;
+  methods?: Array<'totp' | 'webauthn'>;
 }
 
 interface Challenge {
@@ -17,10 +21,22 @@ interface Challenge {
 interface User {
   userId: string;
   token: string;
-  authMethod: 'ssh-key' | 'password';
+  authMethod: 'ssh-key' | 'password' | 'oidc';
   loginTime: number;
 }
 
+// WebAuthn exchanges binary fields as base64url
+function fromBase64Url(value: string): ArrayBuffer {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+  const binary = atob(base64.padEnd(Math.ceil(base64.length / 4) * 4, '='));
+  return Uint8Array.from(binary, (char) => char.charCodeAt(0)).buffer;
+}
+
+function toBase64Url(buffer: ArrayBuffer): string {
+  const binary = String.fromCharCode(...new Uint8Array(buffer));
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
 export class AuthClient {
   private static readonly TOKEN_KEY = 'vibetunnel_auth_token';
   private static readonly USER_KEY = 'vibetunnel_user_data';
@@ -190,6 +206,118 @@ export class AuthClient {
     }
   }
 
+  /**
+   * Confirm a login with a code from the user's authenticator app
+   */
+  async verifySecondFactorCode(pendingToken: string, code: string): Promise<AuthResponse> {
+    return this.completeSecondFactor('/api/auth/second-factor/totp', { pendingToken, code });
+  }
+
+  /**
+   * Confirm a login with one of the user's security keys (WebAuthn)
+   */
+  async verifySecurityKey(pendingToken: string): Promise<AuthResponse> {
+    try {
+      const optionsResponse = await fetch('/api/auth/second-factor/webauthn/options', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ pendingToken }),
+      });
+      const options = await optionsResponse.json();
+      if (!optionsResponse.ok) {
+        return { success: false, error: options.error || 'Security key login failed' };
+      }
+
+      const credential = (await navigator.credentials.get({
+        publicKey: {
+          challenge: fromBase64Url(options.challenge),
+          rpId: options.rpId,
+          timeout: options.timeout,
+          userVerification: options.userVerification,
+          allowCredentials: options.allowCredentials.map((allowed: { id: string }) => ({
+            type: 'public-key',
+            id: fromBase64Url(allowed.id),
+          })),
+        },
+      })) as PublicKeyCredential | null;
+      if (!credential) {
+        return { success: false, error: 'No security key was used' };
+      }
+
+      const response = credential.response as AuthenticatorAssertionResponse;
+      return this.completeSecondFactor('/api/auth/second-factor/webauthn', {
+        pendingToken,
+        credential: {
+          id: credential.id,
+          response: {
+            clientDataJSON: toBase64Url(response.clientDataJSON),
+            authenticatorData: toBase64Url(response.authenticatorData),
+            signature: toBase64Url(response.signature),
+          },
+        },
+      });
+    } catch (error) {
+      console.error('Security key authentication failed:', error);
+      return { success: false, error: 'Security key authentication failed' };
+    }
+  }
+
+  private async completeSecondFactor(url: string, body: unknown): Promise<AuthResponse> {
+    try {
+      const response = await fetch(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body),
+      });
+      const result = await response.json();
+
+      if (result.success) {
+        this.setCurrentUser({
+          userId: result.userId,
+          token: result.token,
+          authMethod: result.authMethod,
+          loginTime: Date.now(),
+        });
+        return result;
+      }
+      return { success: false, error: result.error || 'Second factor verification failed' };
+    } catch (error) {
+      console.error('Second factor verification failed:', error);
+      return { success: false, error: 'Second factor verification failed' };
+    }
+  }
+
+  /**
+   * Sign in with the server's OpenID provider; the page returns to the app afterwards
+   */
+  startSingleSignOn(): void {
+    window.location.href = '/api/auth/oidc/login';
+  }
+
+  /**
+   * Pick up the session a single sign-on login left in its cookie
+   */
+  async resumeSingleSignOn(): Promise<AuthResponse> {
+    try {
+      const response = await fetch('/api/auth/oidc/session', { credentials: 'same-origin' });
+      const result = await response.json();
+
+      if (response.ok && result.success) {
+        this.setCurrentUser({
+          userId: result.userId,
+          token: result.token,
+          authMethod: 'oidc',
+          loginTime: Date.now(),
+        });
+        return result;
+      }
+      return { success: false, error: result.error };
+    } catch (error) {
+      console.error('Failed to resume single sign-on session:', error);
+      return { success: false, error: 'Single sign-on failed' };
+    }
+  }
+
   /**
    * Automated authentication - tries SSH keys first, then prompts for password
    */
@@ -211,7 +339,7 @@ export class AuthClient {
           const result = await this.authenticateWithSSHKey(userId, key.id);
           console.log(`🎯 SSH key ${key.name} result:`, result);
 
-          if (result.success) {
+          if (result.success || result.secondFactorRequired) {
             console.log(`✅ Authenticated with SSH key: ${key.name}`);
             return result;
           }
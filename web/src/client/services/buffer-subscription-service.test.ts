@@ -258,6 +258,10 @@ describe('BufferSubscriptionService', () => {
       await service.initialize();
       vi.advanceTimersByTime(100);
       mockWebSocketInstance.mockOpen();
+      expect(mockWebSocketInstance.send).toHaveBeenCalledWith(
+        expect.stringContaining('"type":"hello"')
+      );
+      mockWebSocketInstance.send.mockClear();
 
       // Subscribe first handler
       service.subscribe('session-123', handler1);
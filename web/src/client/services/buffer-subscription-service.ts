@@ -1,3 +1,8 @@
+import {
+  BUFFER_PROTOCOL_VERSION,
+  type BufferCapability,
+  FLAG_COMPRESSED,
+} from '../../shared/buffer-protocol.js';
 import { createLogger } from '../utils/logger.js';
 import type { BufferCell } from '../utils/terminal-renderer.js';
 import { authClient } from './auth-client.js';
@@ -15,17 +20,128 @@ interface BufferSnapshot {
 
 type BufferUpdateHandler = (snapshot: BufferSnapshot) => void;
 
+export interface SessionViewer {
+  id: string;
+  transport: 'sse' | 'websocket';
+  userId: string | null;
+  viewerId?: string;
+  connectedAt: string;
+  typing: boolean;
+  // Remote the viewer is connected to, for remote sessions viewed through HQ
+  via?: string;
+}
+
+export interface SessionPresence {
+  sessionId: string;
+  viewers: SessionViewer[];
+  typing: string[];
+}
+
+type PresenceHandler = (presence: SessionPresence) => void;
+
+export interface SelectionRange {
+  startCol: number;
+  startRow: number;
+  endCol: number;
+  endRow: number;
+}
+
+// Typing and selection indicators of other users sharing a session
+export type CollaborationEvent =
+  | { event: 'typing'; sessionId: string; userId: string }
+  | {
+      event: 'selection';
+      sessionId: string;
+      userId: string;
+      participantId: string;
+      selection: SelectionRange | null;
+    };
+
+type CollaborationHandler = (event: CollaborationEvent) => void;
+
+// Holder of a session's input lock; while set, input without its token is rejected
+export interface InputLockInfo {
+  sessionId: string;
+  userId: string | null;
+  holder?: string;
+  acquiredAt: string;
+  expiresAt: string;
+}
+
+type LockHandler = (lock: InputLockInfo | null) => void;
+
+// Image printed in a session (iTerm2 inline image or sixel), served from `url`
+export interface InlineImageEvent {
+  sessionId: string;
+  image: {
+    id: string;
+    format: 'iterm2' | 'sixel';
+    mime: string;
+    size: number;
+    url: string;
+    name?: string;
+    width?: string;
+    height?: string;
+  };
+  // Cursor position (viewport) the image was printed at
+  cursorX: number;
+  cursorY: number;
+}
+
+type ImageHandler = (event: InlineImageEvent) => void;
+
+// A trigger with an event action matched the session's output
+export interface TriggerEvent {
+  triggerId: string;
+  triggerName: string;
+  sessionId: string;
+  line: string;
+  match: string;
+  groups: string[];
+  firedAt: string;
+}
+
+type TriggerHandler = (event: TriggerEvent) => void;
+
 // Magic byte for binary messages
 const BUFFER_MAGIC_BYTE = 0xbf;
 
+/**
+ * Protocol features this client can decode; compression needs deflate-raw
+ * support in DecompressionStream
+ */
+function supportedCapabilities(): BufferCapability[] {
+  const capabilities: BufferCapability[] = ['deltas', 'links', 'images'];
+  try {
+    new DecompressionStream('deflate-raw');
+    capabilities.unshift('compression');
+  } catch {
+    // Not available in this browser
+  }
+  return capabilities;
+}
+
 export class BufferSubscriptionService {
   private ws: WebSocket | null = null;
   private subscriptions = new Map<string, Set<BufferUpdateHandler>>();
+  private presenceHandlers = new Map<string, Set<PresenceHandler>>();
+  // Latest presence per session, replayed to handlers registered later
+  private presence = new Map<string, SessionPresence>();
+  private collaborationHandlers = new Map<string, Set<CollaborationHandler>>();
+  private lockHandlers = new Map<string, Set<LockHandler>>();
+  private imageHandlers = new Map<string, Set<ImageHandler>>();
+  private triggerHandlers = new Map<string, Set<TriggerHandler>>();
   private reconnectAttempts = 0;
   private reconnectTimer: number | null = null;
   private pingInterval: number | null = null;
   private isConnecting = false;
   private messageQueue: Array<{ type: string; sessionId?: string }> = [];
+  // Token from the server's welcome message; lets a reconnect resume the subscriptions
+  private resumeToken: string | null = null;
+  // Cells of the last frame per session, the base of delta frames
+  private lastCells = new Map<string, BufferCell[][]>();
+  // Frames are decoded one after another, since delta frames build on the previous one
+  private decoding: Promise<void> = Promise.resolve();
 
   private initialized = false;
   private noAuthMode: boolean | null = null;
@@ -113,6 +229,15 @@ export class BufferSubscriptionService {
         // Start ping/pong
         this.startPingPong();
 
+        // Negotiate the protocol features before any frames are sent
+        this.ws?.send(
+          JSON.stringify({
+            type: 'hello',
+            version: BUFFER_PROTOCOL_VERSION,
+            capabilities: supportedCapabilities(),
+          })
+        );
+
         // Send any queued messages
         while (this.messageQueue.length > 0) {
           const message = this.messageQueue.shift();
@@ -121,10 +246,13 @@ export class BufferSubscriptionService {
           }
         }
 
-        // Re-subscribe to all sessions
-        this.subscriptions.forEach((_, sessionId) => {
-          this.sendMessage({ type: 'subscribe', sessionId });
-        });
+        // Resume the previous connection's subscriptions if the server still has them,
+        // otherwise re-subscribe to all sessions
+        if (this.resumeToken && this.subscriptions.size > 0) {
+          this.ws?.send(JSON.stringify({ type: 'resume', resumeToken: this.resumeToken }));
+        } else {
+          this.resubscribe([]);
+        }
       };
 
       this.ws.onmessage = (event) => {
@@ -149,6 +277,17 @@ export class BufferSubscriptionService {
     }
   }
 
+  /**
+   * Subscribe to all sessions except those restored by a resume
+   */
+  private resubscribe(resumedSessionIds: string[]) {
+    this.subscriptions.forEach((_, sessionId) => {
+      if (!resumedSessionIds.includes(sessionId)) {
+        this.sendMessage({ type: 'subscribe', sessionId });
+      }
+    });
+  }
+
   private scheduleReconnect() {
     if (this.reconnectTimer) return;
 
@@ -179,7 +318,7 @@ export class BufferSubscriptionService {
     }
   }
 
-  private sendMessage(message: { type: string; sessionId?: string }) {
+  private sendMessage(message: { type: string; sessionId?: string; [key: string]: unknown }) {
     if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
       // Queue message for when we reconnect
       if (message.type === 'subscribe' || message.type === 'unsubscribe') {
@@ -208,6 +347,29 @@ export class BufferSubscriptionService {
         case 'connected':
           // Server confirmed connection, version info available in message.version
           logger.log(`connected to server, version: ${message.version}`);
+          this.resumeToken = message.resumeToken ?? null;
+          break;
+
+        case 'hello':
+          logger.debug(
+            `protocol ${message.version}, capabilities: ${message.capabilities.join(', ')}`
+          );
+          break;
+
+        case 'resumed':
+          logger.log(`resumed ${message.sessionIds.length} subscriptions`);
+          for (const sessionId of message.sessionIds) {
+            // Dropped while disconnected
+            if (!this.subscriptions.has(sessionId)) {
+              this.sendMessage({ type: 'unsubscribe', sessionId });
+            }
+          }
+          this.resubscribe(message.sessionIds);
+          break;
+
+        case 'resume-failed':
+          logger.debug('resume not possible, re-subscribing');
+          this.resubscribe([]);
           break;
 
         case 'subscribed':
@@ -215,6 +377,57 @@ export class BufferSubscriptionService {
           logger.debug(`subscribed to session: ${message.sessionId}`);
           break;
 
+        case 'presence':
+          this.presence.set(message.sessionId, message);
+          this.presenceHandlers.get(message.sessionId)?.forEach((handler) => {
+            try {
+              handler(message);
+            } catch (error) {
+              logger.error('error in presence handler', error);
+            }
+          });
+          break;
+
+        case 'collab':
+          this.collaborationHandlers.get(message.sessionId)?.forEach((handler) => {
+            try {
+              handler(message);
+            } catch (error) {
+              logger.error('error in collaboration handler', error);
+            }
+          });
+          break;
+
+        case 'lock':
+          this.lockHandlers.get(message.sessionId)?.forEach((handler) => {
+            try {
+              handler(message.lock);
+            } catch (error) {
+              logger.error('error in lock handler', error);
+            }
+          });
+          break;
+
+        case 'image':
+          this.imageHandlers.get(message.sessionId)?.forEach((handler) => {
+            try {
+              handler(message);
+            } catch (error) {
+              logger.error('error in image handler', error);
+            }
+          });
+          break;
+
+        case 'trigger':
+          this.triggerHandlers.get(message.sessionId)?.forEach((handler) => {
+            try {
+              handler(message);
+            } catch (error) {
+              logger.error('error in trigger handler', error);
+            }
+          });
+          break;
+
         case 'ping':
           this.sendMessage({ type: 'pong' });
           break;
@@ -257,35 +470,36 @@ export class BufferSubscriptionService {
       // Remaining data is the buffer
       const bufferData = data.slice(offset);
 
-      // Import TerminalRenderer dynamically to avoid circular dependencies
-      import('../utils/terminal-renderer.js')
-        .then(({ TerminalRenderer }) => {
-          try {
-            const snapshot = TerminalRenderer.decodeBinaryBuffer(bufferData);
-
-            // Notify all handlers for this session
-            const handlers = this.subscriptions.get(sessionId);
-            if (handlers) {
-              handlers.forEach((handler) => {
-                try {
-                  handler(snapshot);
-                } catch (error) {
-                  logger.error('error in update handler', error);
-                }
-              });
-            }
-          } catch (error) {
-            logger.error('failed to decode binary buffer', error);
-          }
-        })
+      this.decoding = this.decoding
+        .then(() => this.decodeFrame(sessionId, bufferData))
         .catch((error) => {
-          logger.error('failed to import terminal renderer', error);
+          logger.error('failed to decode binary buffer', error);
         });
     } catch (error) {
       logger.error('failed to parse binary message', error);
     }
   }
 
+  private async decodeFrame(sessionId: string, bufferData: ArrayBuffer) {
+    // Import TerminalRenderer dynamically to avoid circular dependencies
+    const { TerminalRenderer } = await import('../utils/terminal-renderer.js');
+    const compressed = new Uint8Array(bufferData)[3] & FLAG_COMPRESSED;
+    const frame = compressed ? await TerminalRenderer.inflateBinaryBuffer(bufferData) : bufferData;
+    const snapshot = TerminalRenderer.decodeBinaryBuffer(frame, this.lastCells.get(sessionId));
+
+    // Notify all handlers for this session
+    const handlers = this.subscriptions.get(sessionId);
+    if (!handlers) return;
+    this.lastCells.set(sessionId, snapshot.cells);
+    handlers.forEach((handler) => {
+      try {
+        handler(snapshot);
+      } catch (error) {
+        logger.error('error in update handler', error);
+      }
+    });
+  }
+
   /**
    * Subscribe to buffer updates for a session
    * Returns an unsubscribe function
@@ -318,12 +532,127 @@ export class BufferSubscriptionService {
         // If no more handlers, unsubscribe from session
         if (handlers.size === 0) {
           this.subscriptions.delete(sessionId);
+          this.presence.delete(sessionId);
+          this.lastCells.delete(sessionId);
           this.sendMessage({ type: 'unsubscribe', sessionId });
         }
       }
     };
   }
 
+  /**
+   * Get notified about who is watching and typing into a subscribed session.
+   * Returns a function removing the handler.
+   */
+  onPresence(sessionId: string, handler: PresenceHandler): () => void {
+    let handlers = this.presenceHandlers.get(sessionId);
+    if (!handlers) {
+      handlers = new Set();
+      this.presenceHandlers.set(sessionId, handlers);
+    }
+    handlers.add(handler);
+
+    const current = this.presence.get(sessionId);
+    if (current) {
+      handler(current);
+    }
+
+    return () => {
+      handlers.delete(handler);
+      if (handlers.size === 0) {
+        this.presenceHandlers.delete(sessionId);
+      }
+    };
+  }
+
+  /**
+   * Get notified when other users type into or select text in a subscribed
+   * session. Returns a function removing the handler.
+   */
+  onCollaboration(sessionId: string, handler: CollaborationHandler): () => void {
+    let handlers = this.collaborationHandlers.get(sessionId);
+    if (!handlers) {
+      handlers = new Set();
+      this.collaborationHandlers.set(sessionId, handlers);
+    }
+    handlers.add(handler);
+
+    return () => {
+      handlers.delete(handler);
+      if (handlers.size === 0) {
+        this.collaborationHandlers.delete(sessionId);
+      }
+    };
+  }
+
+  /**
+   * Get notified when the input lock of a subscribed session is taken, released
+   * or expires. Returns a function removing the handler.
+   */
+  onLock(sessionId: string, handler: LockHandler): () => void {
+    let handlers = this.lockHandlers.get(sessionId);
+    if (!handlers) {
+      handlers = new Set();
+      this.lockHandlers.set(sessionId, handlers);
+    }
+    handlers.add(handler);
+
+    return () => {
+      handlers.delete(handler);
+      if (handlers.size === 0) {
+        this.lockHandlers.delete(sessionId);
+      }
+    };
+  }
+
+  /**
+   * Register a handler for images printed in a subscribed session. Returns a
+   * function removing the handler.
+   */
+  onImage(sessionId: string, handler: ImageHandler): () => void {
+    let handlers = this.imageHandlers.get(sessionId);
+    if (!handlers) {
+      handlers = new Set();
+      this.imageHandlers.set(sessionId, handlers);
+    }
+    handlers.add(handler);
+
+    return () => {
+      handlers.delete(handler);
+      if (handlers.size === 0) {
+        this.imageHandlers.delete(sessionId);
+      }
+    };
+  }
+
+  /**
+   * Register a handler for trigger events of a subscribed session. Returns a
+   * function removing the handler.
+   */
+  onTrigger(sessionId: string, handler: TriggerHandler): () => void {
+    let handlers = this.triggerHandlers.get(sessionId);
+    if (!handlers) {
+      handlers = new Set();
+      this.triggerHandlers.set(sessionId, handlers);
+    }
+    handlers.add(handler);
+
+    return () => {
+      handlers.delete(handler);
+      if (handlers.size === 0) {
+        this.triggerHandlers.delete(sessionId);
+      }
+    };
+  }
+
+  /**
+   * Share this client's selection in a subscribed session (null clears it).
+   * Not queued while disconnected.
+   */
+  sendSelection(sessionId: string, selection: SelectionRange | null) {
+    this.sendMessage({ type: 'selection', sessionId, selection });
+  }
+
   /**
    * Clean up and close connection
    */
@@ -341,6 +670,13 @@ export class BufferSubscriptionService {
     }
 
     this.subscriptions.clear();
+    this.presenceHandlers.clear();
+    this.collaborationHandlers.clear();
+    this.lockHandlers.clear();
+    this.imageHandlers.clear();
+    this.triggerHandlers.clear();
+    this.presence.clear();
+    this.lastCells.clear();
     this.messageQueue = [];
   }
 }
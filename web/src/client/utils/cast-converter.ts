@@ -402,6 +402,16 @@ export function connectToStream(
           }
         } else if (type === 'i') {
           // Ignore 'i' (input) events - those are for sending to server, not displaying
+        } else if (type === 'm') {
+          // Marker event - an annotation on the recording
+          if (terminal.dispatchEvent) {
+            terminal.dispatchEvent(
+              new CustomEvent('session-annotation', {
+                detail: { text: eventData },
+                bubbles: true,
+              })
+            );
+          }
         } else {
           logger.error('unknown stream message format');
         }
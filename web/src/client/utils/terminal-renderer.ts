@@ -1,4 +1,16 @@
 import type { IBufferCell } from '@xterm/headless';
+import {
+  FLAG_COMPRESSED,
+  FLAG_DELTA,
+  FLAG_LINK_TABLE,
+  ROW_CONTENT,
+  ROW_EMPTY,
+  ROW_UNCHANGED,
+  SNAPSHOT_FORMAT_1,
+  SNAPSHOT_FORMAT_2,
+  SNAPSHOT_HEADER_SIZE,
+} from '../../shared/buffer-protocol.js';
+import { cellWidth } from '../../shared/cell-width.js';
 
 export interface BufferCell {
   char: string;
@@ -6,8 +18,13 @@ export interface BufferCell {
   fg?: number;
   bg?: number;
   attributes?: number;
+  // OSC 8 hyperlink target
+  link?: string;
 }
 
+// Link targets rendered as clickable anchors
+const LINK_SCHEMES = ['http:', 'https:', 'mailto:', 'ftp:'];
+
 // Attribute bit flags
 const ATTR_BOLD = 0x01;
 const ATTR_ITALIC = 0x02;
@@ -78,6 +95,15 @@ export function renderLineFromBuffer(
   return html;
 }
 
+function isOpenableLink(uri: string | undefined): uri is string {
+  if (!uri) return false;
+  try {
+    return LINK_SCHEMES.includes(new URL(uri).protocol);
+  } catch {
+    return false;
+  }
+}
+
 /**
  * Render a line from BufferCell array (from JSON/binary buffer)
  */
@@ -86,11 +112,15 @@ export function renderLineFromCells(cells: BufferCell[], cursorCol: number = -1)
   let currentChars = '';
   let currentClasses = '';
   let currentStyle = '';
+  let currentLink: string | undefined;
 
   const flushGroup = () => {
     if (currentChars) {
       const escapedChars = escapeHtml(currentChars);
-      html += `<span class="${currentClasses}"${currentStyle ? ` style="${currentStyle}"` : ''}>${escapedChars}</span>`;
+      const span = `<span class="${currentClasses}"${currentStyle ? ` style="${currentStyle}"` : ''}>${escapedChars}</span>`;
+      html += isOpenableLink(currentLink)
+        ? `<a class="terminal-link" href="${escapeHtml(currentLink)}" target="_blank" rel="noopener noreferrer">${span}</a>`
+        : span;
       currentChars = '';
     }
   };
@@ -104,11 +134,12 @@ export function renderLineFromCells(cells: BufferCell[], cursorCol: number = -1)
     // Get styling
     const { classes, style } = getCellStylingFromBuffer(cell, col === cursorCol);
 
-    // Check if styling changed
-    if (classes !== currentClasses || style !== currentStyle) {
+    // Check if styling or link changed
+    if (classes !== currentClasses || style !== currentStyle || cell.link !== currentLink) {
       flushGroup();
       currentClasses = classes;
       currentStyle = style;
+      currentLink = cell.link;
     }
 
     currentChars += cell.char;
@@ -271,9 +302,14 @@ function getCellStylingFromBuffer(
 }
 
 /**
- * Decode binary buffer format
+ * Decode binary buffer format. Delta frames take their unchanged rows from
+ * `previous`, the cells of the session's previous frame; compressed frames must
+ * be inflated first (see inflateBinaryBuffer).
  */
-export function decodeBinaryBuffer(buffer: ArrayBuffer): {
+export function decodeBinaryBuffer(
+  buffer: ArrayBuffer,
+  previous?: BufferCell[][]
+): {
   cols: number;
   rows: number;
   viewportY: number;
@@ -292,11 +328,17 @@ export function decodeBinaryBuffer(buffer: ArrayBuffer): {
   }
 
   const version = view.getUint8(offset++);
-  if (version !== 0x01) {
+  if (version !== SNAPSHOT_FORMAT_1 && version !== SNAPSHOT_FORMAT_2) {
     throw new Error(`Unsupported buffer version: ${version}`);
   }
 
-  const _flags = view.getUint8(offset++);
+  const flags = view.getUint8(offset++);
+  if (flags & FLAG_COMPRESSED) {
+    throw new Error('Compressed buffer must be inflated before decoding');
+  }
+  if (flags & FLAG_DELTA && !previous) {
+    throw new Error('Delta buffer without a previous frame');
+  }
   const cols = view.getUint32(offset, true);
   offset += 4;
   const rows = view.getUint32(offset, true);
@@ -307,23 +349,34 @@ export function decodeBinaryBuffer(buffer: ArrayBuffer): {
   offset += 4;
   const cursorY = view.getInt32(offset, true); // Signed
   offset += 4;
-  offset += 4; // Skip reserved
+  // Offset of the link table, if flagged
+  const linkTableOffset = flags & FLAG_LINK_TABLE ? view.getUint32(offset, true) : 0;
+  offset += 4;
 
   // Decode cells
   const cells: BufferCell[][] = [];
   const uint8 = new Uint8Array(buffer);
+  // A malformed offset must not make the loop run past the frame
+  const rowsEnd = Math.min(linkTableOffset || uint8.length, uint8.length);
 
   // Optimized format
-  while (offset < uint8.length) {
+  while (offset < rowsEnd) {
     const marker = uint8[offset++];
 
-    if (marker === 0xfe) {
+    if (marker === ROW_EMPTY) {
       // Empty row(s)
       const count = uint8[offset++];
       for (let i = 0; i < count; i++) {
         cells.push([{ char: ' ', width: 1 }]);
       }
-    } else if (marker === 0xfd) {
+    } else if (marker === ROW_UNCHANGED) {
+      // Rows of the previous frame; links are attached from this frame's table
+      const count = uint8[offset++];
+      for (let i = 0; i < count; i++) {
+        const row = previous?.[cells.length] ?? [{ char: ' ', width: 1 }];
+        cells.push(row.map(({ link: _link, ...cell }) => cell));
+      }
+    } else if (marker === ROW_CONTENT) {
       // Row with content
       const cellCount = view.getUint16(offset, true);
       offset += 2;
@@ -338,9 +391,51 @@ export function decodeBinaryBuffer(buffer: ArrayBuffer): {
     }
   }
 
+  if (linkTableOffset) {
+    attachLinks(cells, new TextDecoder().decode(uint8.subarray(linkTableOffset)));
+  }
+
   return { cols, rows, viewportY, cursorX, cursorY, cells };
 }
 
+/**
+ * Inflate a compressed frame (everything after the header is deflate-raw) into
+ * the frame it was made from
+ */
+export async function inflateBinaryBuffer(buffer: ArrayBuffer): Promise<ArrayBuffer> {
+  const body = new Blob([buffer.slice(SNAPSHOT_HEADER_SIZE)])
+    .stream()
+    .pipeThrough(new DecompressionStream('deflate-raw'));
+  const inflated = new Uint8Array(await new Response(body).arrayBuffer());
+
+  const frame = new Uint8Array(SNAPSHOT_HEADER_SIZE + inflated.length);
+  frame.set(new Uint8Array(buffer, 0, SNAPSHOT_HEADER_SIZE));
+  frame.set(inflated, SNAPSHOT_HEADER_SIZE);
+  frame[3] &= ~FLAG_COMPRESSED;
+  return frame.buffer;
+}
+
+/**
+ * Set the link of the cells covered by the link table's spans
+ * ([row, startCell, endCell) per link)
+ */
+function attachLinks(cells: BufferCell[][], table: string) {
+  let links: Array<{ uri: string; spans: Array<[number, number, number]> }>;
+  try {
+    links = JSON.parse(table).links;
+  } catch {
+    return;
+  }
+  for (const { uri, spans } of links ?? []) {
+    for (const [row, start, end] of spans) {
+      const rowCells = cells[row] ?? [];
+      for (let i = start; i < Math.min(end, rowCells.length); i++) {
+        rowCells[i].link = uri;
+      }
+    }
+  }
+}
+
 function decodeCell(uint8: Uint8Array, offset: number): { cell: BufferCell; offset: number } {
   const typeByte = uint8[offset++];
 
@@ -382,8 +477,8 @@ function decodeCell(uint8: Uint8Array, offset: number): { cell: BufferCell; offs
     char = String.fromCharCode(uint8[offset++]);
   }
 
-  // Default cell
-  const cell: BufferCell = { char, width: 1 };
+  // Default cell; the format carries no widths, they follow from the cluster
+  const cell: BufferCell = { char, width: cellWidth(char) };
 
   // Read extended data if present
   if (hasExtended) {
@@ -422,4 +517,5 @@ export const TerminalRenderer = {
   renderLineFromBuffer,
   renderLineFromCells,
   decodeBinaryBuffer,
+  inflateBinaryBuffer,
 };
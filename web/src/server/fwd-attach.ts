@@ -0,0 +1,168 @@
+/**
+ * Attach the local terminal to a session hosted by another process
+ *
+ * Used by `vibetunnel fwd --detach-on-idle` (and `--attach`): the session runs
+ * in a detached host process, and this side only shows the session's output,
+ * read from its recording, and sends keyboard input and resizes to it. Leaving
+ * the attachment - on idle or when the terminal goes away - restores the
+ * terminal and leaves the session running.
+ */
+
+import * as fs from 'fs';
+import type { PtyManager } from './pty/index.js';
+import { createLogger } from './utils/logger.js';
+import { decodeCastLine } from './utils/recording-crypto.js';
+
+const logger = createLogger('fwd-attach');
+
+// How long the host may take to start the session
+const START_TIMEOUT_MS = 10_000;
+const POLL_INTERVAL_MS = 100;
+
+export type AttachResult = { reason: 'exited'; exitCode: number } | { reason: 'idle' | 'hangup' };
+
+/**
+ * Wait until the session is running, e.g. after starting its host
+ */
+export async function waitForSession(ptyManager: PtyManager, sessionId: string): Promise<void> {
+  const deadline = Date.now() + START_TIMEOUT_MS;
+  while (Date.now() < deadline) {
+    const session = ptyManager.getSession(sessionId);
+    if (session?.status === 'running') return;
+    if (session?.status === 'exited') {
+      throw new Error(`Session ${sessionId} exited (exit code: ${session.exitCode ?? 'unknown'})`);
+    }
+    await new Promise((resolve) => setTimeout(resolve, POLL_INTERVAL_MS));
+  }
+  throw new Error(`Session ${sessionId} did not start within ${START_TIMEOUT_MS / 1000}s`);
+}
+
+/**
+ * Show a running session in the local terminal until it exits, nothing was
+ * typed or printed for idleMs (0 = never), or the terminal hangs up
+ */
+export function attachToSession(
+  ptyManager: PtyManager,
+  sessionId: string,
+  idleMs: number
+): Promise<AttachResult> {
+  const paths = ptyManager.getSessionPaths(sessionId);
+  if (!paths) {
+    return Promise.reject(new Error(`Session ${sessionId} not found`));
+  }
+
+  return new Promise((resolve) => {
+    let position = 0;
+    let pending = Buffer.alloc(0);
+    let idleTimer: NodeJS.Timeout | undefined;
+    let done = false;
+
+    const finish = (result: AttachResult) => {
+      if (done) return;
+      done = true;
+      clearTimeout(idleTimer);
+      clearInterval(poll);
+      watcher?.close();
+      process.stdout.removeListener('resize', onResize);
+      process.removeListener('SIGHUP', onHangup);
+      process.stdin.removeListener('data', onInput);
+      if (process.stdin.isTTY) {
+        process.stdin.setRawMode(false);
+      }
+      process.stdin.pause();
+      resolve(result);
+    };
+
+    const resetIdle = () => {
+      if (idleMs <= 0) return;
+      clearTimeout(idleTimer);
+      idleTimer = setTimeout(() => finish({ reason: 'idle' }), idleMs);
+    };
+
+    // The recording: a header, then [time, type, data] events and a final exit event
+    const handleLine = (storedLine: string) => {
+      let event: unknown;
+      try {
+        event = JSON.parse(decodeCastLine(storedLine));
+      } catch (_error) {
+        return;
+      }
+      if (done || !Array.isArray(event)) return;
+      if (event[0] === 'exit') {
+        finish({ reason: 'exited', exitCode: Number(event[1]) || 0 });
+      } else if (event[1] === 'o' && typeof event[2] === 'string') {
+        process.stdout.write(event[2]);
+        resetIdle();
+      }
+    };
+
+    const readNewOutput = () => {
+      try {
+        const size = fs.statSync(paths.stdoutPath).size;
+        if (size <= position) return;
+        const chunk = Buffer.alloc(size - position);
+        const fd = fs.openSync(paths.stdoutPath, 'r');
+        try {
+          fs.readSync(fd, chunk, 0, chunk.length, position);
+        } finally {
+          fs.closeSync(fd);
+        }
+        position = size;
+
+        // Split on bytes, so characters split between reads stay whole
+        pending = Buffer.concat([pending, chunk]);
+        let newline = pending.indexOf(0x0a);
+        while (newline !== -1) {
+          handleLine(pending.subarray(0, newline).toString('utf8'));
+          pending = pending.subarray(newline + 1);
+          newline = pending.indexOf(0x0a);
+        }
+      } catch (error) {
+        logger.debug(`failed to read output of session ${sessionId}:`, error);
+      }
+    };
+
+    const onInput = (data: string) => {
+      resetIdle();
+      try {
+        ptyManager.sendInput(sessionId, { text: data });
+      } catch (error) {
+        logger.error(`failed to send input to session ${sessionId}:`, error);
+      }
+    };
+
+    const onResize = () => {
+      const cols = process.stdout.columns || 80;
+      const rows = process.stdout.rows || 24;
+      try {
+        ptyManager.resizeSession(sessionId, cols, rows);
+      } catch (error) {
+        logger.debug(`failed to resize session ${sessionId}:`, error);
+      }
+    };
+
+    const onHangup = () => finish({ reason: 'hangup' });
+
+    // File events can be coalesced or missed; the poll catches up
+    let watcher: fs.FSWatcher | undefined;
+    try {
+      watcher = fs.watch(paths.stdoutPath, readNewOutput);
+    } catch (error) {
+      logger.debug(`cannot watch output of session ${sessionId}, polling:`, error);
+    }
+    const poll = setInterval(readNewOutput, POLL_INTERVAL_MS * 5);
+
+    process.stdout.on('resize', onResize);
+    process.on('SIGHUP', onHangup);
+    if (process.stdin.isTTY) {
+      process.stdin.setRawMode(true);
+    }
+    process.stdin.setEncoding('utf8');
+    process.stdin.on('data', onInput);
+    process.stdin.resume();
+
+    onResize();
+    resetIdle();
+    readNewOutput();
+  });
+}
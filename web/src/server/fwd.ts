@@ -9,13 +9,20 @@
  * Usage:
  *   pnpm exec tsx src/fwd.ts <command> [args...]
  *   pnpm exec tsx src/fwd.ts claude --resume
+ *
+ * With --detach-on-idle the session runs in a detached host process and this
+ * one only attaches the terminal to it (fwd-attach.ts), so it can leave while
+ * the session keeps running.
  */
 
 import chalk from 'chalk';
+import { spawn } from 'child_process';
 import * as os from 'os';
 import * as path from 'path';
-import { PtyManager } from './pty/index.js';
+import { type AttachResult, attachToSession, waitForSession } from './fwd-attach.js';
+import { isValidSessionId, PtyManager } from './pty/index.js';
 import { closeLogger, createLogger } from './utils/logger.js';
+import { configureRecordingEncryption } from './utils/recording-crypto.js';
 import { generateSessionName } from './utils/session-naming.js';
 import { BUILD_DATE, GIT_COMMIT, VERSION } from './version.js';
 
@@ -26,15 +33,22 @@ function showUsage() {
   console.log('');
   console.log('Usage:');
   console.log('  pnpm exec tsx src/fwd.ts [--session-id <id>] <command> [args...]');
+  console.log('  pnpm exec tsx src/fwd.ts --attach <id> [--detach-on-idle <minutes>]');
   console.log('');
   console.log('Options:');
   console.log('  --session-id <id>   Use a pre-generated session ID');
+  console.log('  --scratch           Create a scratch directory for the session');
+  console.log('                      ($VIBETUNNEL_SCRATCH_DIR), removed with the session');
+  console.log('  --detach-on-idle <minutes>  Restore the terminal after this long without input');
+  console.log('                      or output; the session keeps running in the background');
+  console.log('  --attach <id>       Attach to a session started with --detach-on-idle');
   console.log('');
   console.log('Examples:');
   console.log('  pnpm exec tsx src/fwd.ts claude --resume');
   console.log('  pnpm exec tsx src/fwd.ts bash -l');
   console.log('  pnpm exec tsx src/fwd.ts python3 -i');
   console.log('  pnpm exec tsx src/fwd.ts --session-id abc123 claude');
+  console.log('  pnpm exec tsx src/fwd.ts --detach-on-idle 30 make test');
   console.log('');
   console.log('The command will be spawned in the current working directory');
   console.log('and managed through the VibeTunnel PTY infrastructure.');
@@ -55,17 +69,64 @@ export async function startVibeTunnelForward(args: string[]) {
 
   logger.log(chalk.blue(`VibeTunnel Forward v${VERSION}`) + chalk.gray(` (${BUILD_DATE})`));
 
-  // Check for --session-id parameter
+  // Options come before the command
   let sessionId: string | undefined;
+  let attachId: string | undefined;
+  let idleMinutes = 0;
+  let scratch = false;
+  // Internal: host the session without a terminal (started by --detach-on-idle)
+  let headlessSize: { cols: number; rows: number } | undefined;
   let remainingArgs = args;
 
-  if (args[0] === '--session-id' && args.length > 1) {
-    sessionId = args[1];
-    remainingArgs = args.slice(2);
+  while (remainingArgs.length > 1) {
+    const [option, value] = remainingArgs;
+    if (option === '--scratch') {
+      scratch = true;
+      remainingArgs = remainingArgs.slice(1);
+      continue;
+    }
+    if (option === '--session-id') {
+      sessionId = value;
+    } else if (option === '--attach') {
+      attachId = value;
+    } else if (option === '--detach-on-idle') {
+      idleMinutes = Number(value);
+      if (!(idleMinutes > 0)) {
+        logger.error('--detach-on-idle must be a positive number of minutes');
+        closeLogger();
+        process.exit(1);
+      }
+    } else if (option === '--headless') {
+      const [cols, rows] = value.split('x').map(Number);
+      headlessSize = { cols: cols || 80, rows: rows || 24 };
+    } else {
+      break;
+    }
+    remainingArgs = remainingArgs.slice(2);
   }
 
   const command = remainingArgs;
 
+  for (const id of [sessionId, attachId]) {
+    if (id !== undefined && !isValidSessionId(id)) {
+      logger.error(`Invalid session ID: ${id} (letters, digits, '.', '_' and '-' only)`);
+      closeLogger();
+      process.exit(1);
+    }
+  }
+
+  // Control path shared with the server and the session's host
+  const controlPath = path.join(os.homedir(), '.vibetunnel', 'control');
+  logger.debug(`Control path: ${controlPath}`);
+  // Encrypt the recording like the server does when recording keys are configured
+  await configureRecordingEncryption();
+  const ptyManager = new PtyManager(controlPath);
+
+  if (attachId) {
+    await attachAndExit(ptyManager, attachId, idleMinutes);
+    return;
+  }
+
   if (command.length === 0) {
     logger.error('No command specified');
     showUsage();
@@ -75,23 +136,36 @@ export async function startVibeTunnelForward(args: string[]) {
 
   const cwd = process.cwd();
 
-  // Initialize PTY manager
-  const controlPath = path.join(os.homedir(), '.vibetunnel', 'control');
-  logger.debug(`Control path: ${controlPath}`);
-  const ptyManager = new PtyManager(controlPath);
-
   // Store original terminal dimensions
-  const originalCols = process.stdout.columns || 80;
-  const originalRows = process.stdout.rows || 24;
+  const originalCols = headlessSize?.cols ?? (process.stdout.columns || 80);
+  const originalRows = headlessSize?.rows ?? (process.stdout.rows || 24);
   logger.debug(`Original terminal size: ${originalCols}x${originalRows}`);
 
+  // Pre-generate session ID if not provided
+  const finalSessionId = sessionId || `fwd_${Date.now()}`;
+
+  if (idleMinutes > 0 && !headlessSize) {
+    try {
+      startHost(args, command, finalSessionId, `${originalCols}x${originalRows}`, scratch);
+      await waitForSession(ptyManager, finalSessionId);
+    } catch (error) {
+      logger.error('Failed to start session host:', error);
+      closeLogger();
+      process.exit(1);
+    }
+    await attachAndExit(ptyManager, finalSessionId, idleMinutes);
+    return;
+  }
+
+  if (headlessSize) {
+    await hostSession(ptyManager, finalSessionId, command, cwd, headlessSize, scratch);
+    return;
+  }
+
   try {
     // Create a human-readable session name
     const sessionName = generateSessionName(command, cwd);
 
-    // Pre-generate session ID if not provided
-    const finalSessionId = sessionId || `fwd_${Date.now()}`;
-
     logger.log(`Creating session for command: ${command.join(' ')}`);
     logger.debug(`Session ID: ${finalSessionId}, working directory: ${cwd}`);
 
@@ -101,6 +175,7 @@ export async function startVibeTunnelForward(args: string[]) {
       workingDir: cwd,
       cols: originalCols,
       rows: originalRows,
+      scratch,
       forwardToStdout: true,
       onExit: async (exitCode: number) => {
         // Show exit message
@@ -178,3 +253,95 @@ export async function startVibeTunnelForward(args: string[]) {
     process.exit(1);
   }
 }
+
+/**
+ * Start this command again as a detached process hosting the session
+ */
+function startHost(
+  args: string[],
+  command: string[],
+  sessionId: string,
+  size: string,
+  scratch: boolean
+): void {
+  // The executable and arguments that led to startVibeTunnelForward (e.g. cli.js fwd)
+  const self = process.argv.slice(1, process.argv.length - args.length);
+  const options = ['--session-id', sessionId, '--headless', size];
+  if (scratch) options.push('--scratch');
+  const host = spawn(
+    process.execPath,
+    [...process.execArgv, ...self, ...options, ...command],
+    { cwd: process.cwd(), detached: true, stdio: 'ignore' }
+  );
+  host.unref();
+  logger.debug(`Started host for session ${sessionId} (PID: ${host.pid})`);
+}
+
+/**
+ * Run the session without a terminal until it exits
+ */
+async function hostSession(
+  ptyManager: PtyManager,
+  sessionId: string,
+  command: string[],
+  cwd: string,
+  size: { cols: number; rows: number },
+  scratch: boolean
+): Promise<void> {
+  // The attached terminal going away must not end the session
+  process.on('SIGHUP', () => logger.debug('Ignoring SIGHUP in session host'));
+  try {
+    await ptyManager.createSession(command, {
+      sessionId,
+      name: generateSessionName(command, cwd),
+      workingDir: cwd,
+      cols: size.cols,
+      rows: size.rows,
+      scratch,
+      onExit: async (exitCode: number) => {
+        logger.log(`Session ${sessionId} ended (exit code: ${exitCode})`);
+        await ptyManager.shutdown();
+        closeLogger();
+        process.exit(exitCode || 0);
+      },
+    });
+  } catch (error) {
+    logger.error('Failed to create session:', error);
+    closeLogger();
+    process.exit(1);
+  }
+}
+
+/**
+ * Attach the terminal to a hosted session, then exit with its exit code or,
+ * when detached, say how to come back
+ */
+async function attachAndExit(
+  ptyManager: PtyManager,
+  sessionId: string,
+  idleMinutes: number
+): Promise<never> {
+  let result: AttachResult;
+  try {
+    result = await attachToSession(ptyManager, sessionId, idleMinutes * 60_000);
+  } catch (error) {
+    logger.error('Failed to attach to session:', error);
+    closeLogger();
+    process.exit(1);
+  }
+
+  if (result.reason === 'exited') {
+    const exitInfo = chalk.gray(` (exit code: ${result.exitCode})`);
+    logger.log(chalk.yellow(`\n✓ VibeTunnel session ended`) + exitInfo);
+  } else if (result.reason === 'idle') {
+    logger.log(
+      chalk.yellow(`\n✓ Detached after ${idleMinutes} idle minutes`) +
+        chalk.gray(`; session ${sessionId} keeps running`)
+    );
+    logger.log(chalk.gray('Reattach with:'), `vibetunnel fwd --attach ${sessionId}`);
+  }
+
+  await ptyManager.shutdown();
+  closeLogger();
+  process.exit(result.reason === 'exited' ? result.exitCode : 0);
+}
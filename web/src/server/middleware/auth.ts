@@ -1,5 +1,15 @@
 import type { NextFunction, Request, Response } from 'express';
+import {
+  API_TOKEN_PREFIX,
+  type ApiTokenScope,
+  type ApiTokenStore,
+  hasScope,
+  requiredScope,
+} from '../services/api-tokens.js';
 import type { AuthService } from '../services/auth-service.js';
+import type { RemoteTokenStore } from '../services/remote-tokens.js';
+import { sendError } from '../utils/api-error.js';
+import { getCookie, SESSION_COOKIE } from '../utils/cookies.js';
 import { createLogger } from '../utils/logger.js';
 
 const logger = createLogger('auth');
@@ -9,16 +19,26 @@ interface AuthConfig {
   disallowUserPassword: boolean;
   noAuth: boolean;
   isHQMode: boolean;
-  bearerToken?: string; // Token that HQ must use to authenticate with this remote
+  remoteTokens?: RemoteTokenStore; // Tokens HQ may use to authenticate with this remote
   authService?: AuthService; // Enhanced auth service for JWT tokens
   allowLocalBypass?: boolean; // Allow localhost connections to bypass auth
   localAuthToken?: string; // Token for localhost authentication
+  apiTokens?: ApiTokenStore; // Long-lived tokens of scripts and CI
 }
 
 export interface AuthenticatedRequest extends Request {
   userId?: string;
-  authMethod?: 'ssh-key' | 'password' | 'hq-bearer' | 'no-auth' | 'local-bypass';
+  authMethod?:
+    | 'ssh-key'
+    | 'password'
+    | 'oidc'
+    | 'api-token'
+    | 'hq-bearer'
+    | 'no-auth'
+    | 'local-bypass';
   isHQRequest?: boolean;
+  // Scopes of the API token the request was authenticated with
+  tokenScopes?: ApiTokenScope[];
 }
 
 // Helper function to check if request is from localhost
@@ -48,6 +68,34 @@ function isLocalRequest(req: Request): boolean {
 }
 
 export function createAuthMiddleware(config: AuthConfig) {
+  // API tokens act for their user within their scopes
+  const authenticateApiToken = (
+    req: AuthenticatedRequest,
+    res: Response,
+    next: NextFunction,
+    token: string
+  ) => {
+    const apiToken = config.apiTokens?.verify(token);
+    if (!apiToken) {
+      logger.warn(`Unknown or expired API token used for ${req.method} ${req.path}`);
+      res.setHeader('WWW-Authenticate', 'Bearer realm="VibeTunnel", error="invalid_token"');
+      return sendError(res, 'INVALID_TOKEN');
+    }
+    const scope = requiredScope(req.method, req.path);
+    if (!hasScope(apiToken.scopes, scope)) {
+      logger.warn(`API token ${apiToken.id} lacks ${scope} for ${req.method} ${req.path}`);
+      res.setHeader(
+        'WWW-Authenticate',
+        `Bearer realm="VibeTunnel", error="insufficient_scope", scope="${scope}"`
+      );
+      return sendError(res, 'FORBIDDEN', `Token lacks the ${scope} scope`);
+    }
+    req.userId = apiToken.userId;
+    req.authMethod = 'api-token';
+    req.tokenScopes = apiToken.scopes;
+    next();
+  };
+
   return (req: AuthenticatedRequest, res: Response, next: NextFunction) => {
     // Skip auth for auth endpoints, client logging, and push notifications
     if (
@@ -95,13 +143,16 @@ export function createAuthMiddleware(config: AuthConfig) {
     // Check for Bearer token
     if (authHeader?.startsWith('Bearer ')) {
       const token = authHeader.substring(7);
+      if (token.startsWith(API_TOKEN_PREFIX)) {
+        return authenticateApiToken(req, res, next, token);
+      }
+      const hqTokenCheck = config.remoteTokens?.check(token) ?? 'unknown';
 
-      // In HQ mode, check if this is a valid HQ-to-remote bearer token
-      if (config.isHQMode && config.bearerToken && token === config.bearerToken) {
-        logger.debug('Valid HQ bearer token authentication');
-        req.isHQRequest = true;
-        req.authMethod = 'hq-bearer';
-        return next();
+      // Rotated-out and revoked HQ tokens are rejected outright
+      if (hqTokenCheck === 'revoked') {
+        logger.warn(`Revoked HQ token used for ${req.method} ${req.path} from ${req.ip}`);
+        res.setHeader('WWW-Authenticate', 'Bearer realm="VibeTunnel", error="invalid_token"');
+        return sendError(res, 'INVALID_TOKEN', 'Token has been revoked');
       }
 
       // If we have enhanced auth service and SSH keys are enabled, try JWT token validation
@@ -109,7 +160,8 @@ export function createAuthMiddleware(config: AuthConfig) {
         const verification = config.authService.verifyToken(token);
         if (verification.valid && verification.userId) {
           req.userId = verification.userId;
-          req.authMethod = 'ssh-key'; // JWT tokens are issued for SSH key auth
+          // JWT tokens are issued for SSH key auth unless they came from single sign-on
+          req.authMethod = verification.authMethod ?? 'ssh-key';
           return next();
         } else {
           logger.error('Invalid JWT token');
@@ -118,41 +170,99 @@ export function createAuthMiddleware(config: AuthConfig) {
         const verification = config.authService.verifyToken(token);
         if (verification.valid && verification.userId) {
           req.userId = verification.userId;
-          req.authMethod = 'password'; // Password auth only
+          req.authMethod = verification.authMethod ?? 'password';
           return next();
         } else {
           logger.error('Invalid JWT token');
         }
       }
 
-      // For non-HQ mode, check if bearer token matches remote expectation
-      if (!config.isHQMode && config.bearerToken && token === config.bearerToken) {
+      // Check if bearer token is one HQ issued to this server (a remote or federated HQ)
+      if (hqTokenCheck === 'valid') {
         logger.debug('Valid remote bearer token authentication');
+        req.isHQRequest = true;
         req.authMethod = 'hq-bearer';
         return next();
       }
 
       logger.error(
-        `Bearer token rejected - HQ mode: ${config.isHQMode}, token matches: ${config.bearerToken === token}`
+        `Bearer token rejected - HQ mode: ${config.isHQMode}, HQ token: ${hqTokenCheck}`
       );
     }
 
     // Check for token in query parameter (for EventSource connections)
+    if (tokenQuery?.startsWith(API_TOKEN_PREFIX)) {
+      return authenticateApiToken(req, res, next, tokenQuery);
+    }
     if (tokenQuery && config.authService) {
       const verification = config.authService.verifyToken(tokenQuery);
       if (verification.valid && verification.userId) {
         logger.debug(`Valid query token for user: ${verification.userId}`);
         req.userId = verification.userId;
-        req.authMethod = config.enableSSHKeys ? 'ssh-key' : 'password';
+        req.authMethod =
+          verification.authMethod ?? (config.enableSSHKeys ? 'ssh-key' : 'password');
         return next();
       } else {
         logger.error('Invalid query token');
       }
     }
 
+    // Check for the session cookie of single sign-on logins
+    const sessionCookie = getCookie(req.headers.cookie, SESSION_COOKIE);
+    if (sessionCookie && config.authService) {
+      const verification = config.authService.verifyToken(sessionCookie);
+      // Only single sign-on tokens are ever stored in the cookie
+      if (verification.valid && verification.userId && verification.authMethod === 'oidc') {
+        req.userId = verification.userId;
+        req.authMethod = 'oidc';
+        return next();
+      } else {
+        logger.debug('Invalid or expired session cookie');
+      }
+    }
+
     // No valid auth provided
     logger.error(`Unauthorized request to ${req.method} ${req.path} from ${req.ip}`);
     res.setHeader('WWW-Authenticate', 'Bearer realm="VibeTunnel"');
-    res.status(401).json({ error: 'Authentication required' });
+    sendError(res, 'AUTH_REQUIRED');
+  };
+}
+
+/**
+ * Check whether a request comes from a local operator (no-auth and local
+ * bypass) or the HQ this remote is registered with, which act for the server
+ * itself rather than for a user.
+ */
+export function isOperatorRequest(req: AuthenticatedRequest): boolean {
+  return (
+    req.authMethod === 'no-auth' ||
+    req.authMethod === 'local-bypass' ||
+    req.authMethod === 'hq-bearer'
+  );
+}
+
+/**
+ * Check whether an authenticated request has the admin role.
+ * Operators are always admins; otherwise the authenticated user must be
+ * listed in adminUsers.
+ */
+export function isAdminRequest(req: AuthenticatedRequest, adminUsers: string[]): boolean {
+  if (isOperatorRequest(req)) {
+    return true;
+  }
+  return !!req.userId && adminUsers.includes(req.userId);
+}
+
+/**
+ * Middleware that rejects requests without the admin role.
+ * Must run after the auth middleware.
+ */
+export function createAdminMiddleware(adminUsers: string[]) {
+  return (req: AuthenticatedRequest, res: Response, next: NextFunction) => {
+    if (isAdminRequest(req, adminUsers)) {
+      return next();
+    }
+    logger.warn(`admin access denied for ${req.userId || 'unknown user'} on ${req.path}`);
+    sendError(res, 'ADMIN_REQUIRED');
   };
 }
@@ -0,0 +1,57 @@
+import type { NextFunction, Request, Response } from 'express';
+import { errorCodeForStatus, sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+import { getRequestId } from '../utils/request-context.js';
+
+const logger = createLogger('api-errors');
+
+/**
+ * Brings every error response of the API into the ApiErrorBody shape.
+ *
+ * Handlers reply with `{ error, code?, details? }`; responses with status 400
+ * or above get `message` and, if the handler set none, the generic code for
+ * their status. Other fields (e.g. the limits of SESSION_LIMIT_REACHED) are
+ * kept. Codes of proxied remote responses pass through unchanged. The ID of
+ * the request is added as `requestId` so users can quote it in bug reports.
+ */
+export function errorEnvelope(_req: Request, res: Response, next: NextFunction) {
+  const json = res.json.bind(res);
+  res.json = (body?: unknown) => {
+    if (res.statusCode < 400 || !body || typeof body !== 'object') return json(body);
+
+    const { error, code, message, ...rest } = body as Record<string, unknown>;
+    if (typeof error !== 'string') return json(body);
+    const text = typeof message === 'string' ? message : error;
+    const requestId = getRequestId();
+    return json({
+      ...rest,
+      code: typeof code === 'string' ? code : errorCodeForStatus(res.statusCode),
+      message: text,
+      error: text,
+      ...(requestId && { requestId }),
+    });
+  };
+  next();
+}
+
+/**
+ * Error handler for the API: malformed bodies and errors handlers did not catch
+ */
+export function apiErrorHandler(
+  error: Error & { type?: string },
+  _req: Request,
+  res: Response,
+  next: NextFunction
+) {
+  if (res.headersSent) return next(error);
+
+  switch (error.type) {
+    case 'entity.parse.failed':
+      return sendError(res, 'INVALID_JSON', undefined, error.message);
+    case 'entity.too.large':
+      return sendError(res, 'PAYLOAD_TOO_LARGE');
+    default:
+      logger.error('unhandled error in API request:', error);
+      return sendError(res, 'INTERNAL_ERROR');
+  }
+}
@@ -0,0 +1,65 @@
+import type { NextFunction, Response } from 'express';
+import type { RuntimeConfig } from '../services/runtime-config.js';
+import { sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+import type { AuthenticatedRequest } from './auth.js';
+
+const logger = createLogger('rate-limit');
+
+interface RateLimitWindow {
+  start: number;
+  count: number;
+}
+
+/**
+ * Fixed-window rate limiter for API requests.
+ *
+ * Limits are read from the runtime config on every request so they can be
+ * changed through the admin API. Clients are keyed by user ID when
+ * authenticated, otherwise by IP address. HQ requests are never limited.
+ */
+export function createRateLimitMiddleware(runtimeConfig: RuntimeConfig) {
+  const windows = new Map<string, RateLimitWindow>();
+
+  // Drop stale windows whenever the limits change
+  runtimeConfig.on('change', (_settings, changedKeys: string[]) => {
+    if (changedKeys.includes('rateLimit')) {
+      windows.clear();
+    }
+  });
+
+  return (req: AuthenticatedRequest, res: Response, next: NextFunction) => {
+    const { windowMs, maxRequests } = runtimeConfig.get().rateLimit;
+    if (maxRequests === 0 || req.isHQRequest) {
+      return next();
+    }
+
+    const key = req.userId ? `user:${req.userId}` : `ip:${req.ip}`;
+    const now = Date.now();
+    let window = windows.get(key);
+
+    if (!window || now - window.start >= windowMs) {
+      window = { start: now, count: 0 };
+      windows.set(key, window);
+
+      // Opportunistically prune expired windows to keep the map bounded
+      if (windows.size > 1000) {
+        for (const [otherKey, otherWindow] of windows) {
+          if (now - otherWindow.start >= windowMs) {
+            windows.delete(otherKey);
+          }
+        }
+      }
+    }
+
+    window.count++;
+    if (window.count > maxRequests) {
+      const retryAfter = Math.ceil((window.start + windowMs - now) / 1000);
+      logger.warn(`rate limit exceeded for ${key} on ${req.method} ${req.path}`);
+      res.setHeader('Retry-After', String(retryAfter));
+      return sendError(res, 'RATE_LIMITED');
+    }
+
+    next();
+  };
+}
@@ -0,0 +1,18 @@
+import { randomUUID } from 'crypto';
+import type { NextFunction, Request, Response } from 'express';
+import { isValidRequestId, REQUEST_ID_HEADER, runWithRequestId } from '../utils/request-context.js';
+
+/**
+ * Assigns every request an ID and handles it within that request context.
+ *
+ * A valid X-Request-ID sent by the client (or by an HQ forwarding a request)
+ * is kept, so one action can be followed from the browser through HQ to the
+ * remote that owns the session. Otherwise a new ID is generated. The ID is
+ * echoed in the response header and included in error bodies and log lines.
+ */
+export function assignRequestId(req: Request, res: Response, next: NextFunction) {
+  const incoming = req.headers[REQUEST_ID_HEADER.toLowerCase()];
+  const id = isValidRequestId(incoming) ? incoming : randomUUID();
+  res.setHeader(REQUEST_ID_HEADER, id);
+  runWithRequestId(id, next);
+}
@@ -0,0 +1,52 @@
+import type { NextFunction, Request, Response } from 'express';
+import { getRequestId } from '../utils/request-context.js';
+import {
+  isTracingEnabled,
+  parseTraceparent,
+  runInSpan,
+  SpanKind,
+  startSpan,
+  TRACEPARENT_HEADER,
+} from '../utils/tracing.js';
+
+/**
+ * Traces each API request as a server span.
+ *
+ * A traceparent sent by the web client or by HQ forwarding the request makes
+ * the span part of the caller's trace. Handlers run with the span active, so
+ * session and forwarding spans they start become its children. The span is
+ * named after the matched route once the response is finished.
+ */
+export function traceRequests(req: Request, res: Response, next: NextFunction) {
+  if (!isTracingEnabled()) return next();
+
+  const span = startSpan(`${req.method} ${req.baseUrl}`, {
+    kind: SpanKind.SERVER,
+    parent: parseTraceparent(req.headers[TRACEPARENT_HEADER]),
+    attributes: {
+      'http.request.method': req.method,
+      'url.path': req.originalUrl.split('?')[0],
+      'user_agent.original': req.get('User-Agent'),
+      'vibetunnel.request_id': getRequestId(),
+    },
+  });
+  if (!span) return next();
+
+  const end = () => {
+    const route = req.route?.path;
+    if (typeof route === 'string') {
+      span.setAttribute('http.route', `${req.baseUrl}${route}`);
+      span.setName(`${req.method} ${req.baseUrl}${route}`);
+    }
+    const sessionId = req.params?.sessionId;
+    span.setAttribute('vibetunnel.session_id', sessionId);
+    span.setAttribute('http.response.status_code', res.statusCode);
+    if (res.statusCode >= 500) span.setError();
+    span.end();
+  };
+  // close also covers clients disconnecting from streams; end() only runs once
+  res.on('finish', end);
+  res.on('close', end);
+
+  runInSpan(span, next);
+}
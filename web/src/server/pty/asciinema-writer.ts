@@ -9,7 +9,9 @@ import { once } from 'events';
 import * as fs from 'fs';
 import * as path from 'path';
 import { promisify } from 'util';
+import type { InputSource } from '../utils/input-source.js';
 import { createLogger } from '../utils/logger.js';
+import { encodeCastLine } from '../utils/recording-crypto.js';
 import { WriteQueue } from '../utils/write-queue.js';
 import { type AsciinemaEvent, type AsciinemaHeader, PtyError } from './types.js';
 
@@ -23,6 +25,7 @@ export class AsciinemaWriter {
   private headerWritten = false;
   private fd: number | null = null;
   private writeQueue = new WriteQueue();
+  private lineListener: ((line: string, storedLine: string) => void) | null = null;
 
   constructor(
     private filePath: string,
@@ -75,6 +78,27 @@ export class AsciinemaWriter {
     return new AsciinemaWriter(filePath, header);
   }
 
+  /**
+   * Register a listener that receives every line (without newline) in the
+   * order it is appended to the file, along with the line as stored (which
+   * differs when recordings are encrypted). Used for in-process live fan-out.
+   */
+  setLineListener(listener: ((line: string, storedLine: string) => void) | null): void {
+    this.lineListener = listener;
+  }
+
+  /**
+   * Append a line to the file, notifying the line listener first
+   */
+  private async writeLine(line: string): Promise<void> {
+    const storedLine = encodeCastLine(line);
+    this.lineListener?.(line, storedLine);
+    const canWrite = this.writeStream.write(`${storedLine}\n`);
+    if (!canWrite) {
+      await once(this.writeStream, 'drain');
+    }
+  }
+
   /**
    * Write the asciinema header to the file
    */
@@ -83,10 +107,7 @@ export class AsciinemaWriter {
 
     this.writeQueue.enqueue(async () => {
       const headerJson = JSON.stringify(this.header);
-      const canWrite = this.writeStream.write(`${headerJson}\n`);
-      if (!canWrite) {
-        await once(this.writeStream, 'drain');
-      }
+      await this.writeLine(headerJson);
     });
     this.headerWritten = true;
   }
@@ -119,15 +140,16 @@ export class AsciinemaWriter {
   }
 
   /**
-   * Write terminal input data (usually from user)
+   * Write terminal input data (usually from user), attributed to its source if known
    */
-  writeInput(data: string): void {
+  writeInput(data: string, source?: InputSource): void {
     this.writeQueue.enqueue(async () => {
       const time = this.getElapsedTime();
       const event: AsciinemaEvent = {
         time,
         type: 'i',
         data,
+        ...(source ? { source } : {}),
       };
       await this.writeEvent(event);
     });
@@ -169,10 +191,7 @@ export class AsciinemaWriter {
   writeRawJson(jsonValue: unknown): void {
     this.writeQueue.enqueue(async () => {
       const jsonString = JSON.stringify(jsonValue);
-      const canWrite = this.writeStream.write(`${jsonString}\n`);
-      if (!canWrite) {
-        await once(this.writeStream, 'drain');
-      }
+      await this.writeLine(jsonString);
     });
   }
 
@@ -180,15 +199,15 @@ export class AsciinemaWriter {
    * Write an asciinema event to the file
    */
   private async writeEvent(event: AsciinemaEvent): Promise<void> {
-    // Asciinema format: [time, type, data]
-    const eventArray = [event.time, event.type, event.data];
+    // Asciinema format: [time, type, data], with the input source as an extra element
+    const eventArray: unknown[] = [event.time, event.type, event.data];
+    if (event.source) {
+      eventArray.push(event.source);
+    }
     const eventJson = JSON.stringify(eventArray);
 
     // Write and handle backpressure
-    const canWrite = this.writeStream.write(`${eventJson}\n`);
-    if (!canWrite) {
-      await once(this.writeStream, 'drain');
-    }
+    await this.writeLine(eventJson);
 
     // Sync to disk asynchronously
     if (this.fd !== null) {
@@ -383,6 +402,23 @@ export class AsciinemaWriter {
     return (Date.now() - this.startTime.getTime()) / 1000;
   }
 
+  /**
+   * Wait until everything written so far is in the file. Returns the file size.
+   */
+  async flush(): Promise<number> {
+    await this.writeQueue.drain();
+    // Callbacks run in order, so this one runs after the earlier writes are done
+    await new Promise<void>((resolve) => this.writeStream.write('', () => resolve()));
+    if (this.fd !== null) {
+      try {
+        await fsync(this.fd);
+      } catch (err) {
+        _logger.debug(`fsync failed for ${this.filePath}:`, err);
+      }
+    }
+    return this.writeStream.bytesWritten;
+  }
+
   /**
    * Close the writer and finalize the file
    */
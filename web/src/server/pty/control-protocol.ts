@@ -0,0 +1,334 @@
+/**
+ * Control protocol - Commands to the process owning a session, with acknowledgements
+ *
+ * Writers append newline-delimited JSON commands to the session's `control`
+ * file; the process owning the session (the server or `vibetunnel fwd`) runs
+ * them in order. A command with an `id` is acknowledged by a line in the
+ * `control-ack` file next to it:
+ *
+ *   → {"id":"c1","cmd":"signal","signal":"SIGINT"}
+ *   ← {"id":"c1","ok":true}
+ *   → {"id":"c2","cmd":"flush"}
+ *   ← {"id":"c2","ok":true,"size":18311}
+ *   ← {"id":"c3","ok":false,"error":"unknown command: frob"}
+ *
+ * Both are plain files that are only appended to, not FIFOs, so writers never
+ * block without a reader and a reader starting late sees earlier lines.
+ * Commands without an `id` (agent wrappers, older writers) get no
+ * acknowledgement.
+ *
+ * Commands:
+ * - `resize {cols, rows}`: resize the PTY; `reset-size`: to the fwd terminal's size
+ * - `kill {signal?}`: end the session (default SIGTERM)
+ * - `signal {signal}`: signal the session's process group (e.g. SIGINT, SIGUSR1)
+ * - `env {set?, unset?}`: change the environment of commands the session runs next
+ * - `title {title}`: rename the session
+ * - `metadata {currentWorkingDir?, description?, fields?}`: update recorded metadata
+ * - `flush`: write pending output to the stream file; acknowledged with its `size`
+ * - `agent {...}`: agent metadata (see session-agent.ts)
+ */
+
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import type { SessionInfo } from '../../shared/types.js';
+import type { ControlMessage } from './types.js';
+
+export const CONTROL_ACK_FILE = 'control-ack';
+export const DEFAULT_ACK_TIMEOUT_MS = 5000;
+const ACK_POLL_INTERVAL_MS = 20;
+
+const ENV_NAME_PATTERN = /^[A-Za-z_][A-Za-z0-9_]*$/;
+const MAX_TITLE_LENGTH = 256;
+const MAX_DESCRIPTION_LENGTH = 1000;
+const FIELD_NAME_PATTERN = /^[\w.-]{1,64}$/;
+const MAX_FIELD_LENGTH = 1000;
+const MAX_FIELDS = 32;
+
+export interface ControlAck {
+  id: string;
+  ok: boolean;
+  error?: string;
+  [key: string]: unknown;
+}
+
+export class ControlCommandError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'ControlCommandError';
+  }
+}
+
+/**
+ * Path of the acknowledgement file of a session's control file
+ */
+export function controlAckPath(controlPath: string): string {
+  return path.join(path.dirname(controlPath), CONTROL_ACK_FILE);
+}
+
+/**
+ * Signal of a command, given by name (SIGINT) or number
+ */
+export function parseSignal(value: unknown): NodeJS.Signals {
+  const signals = os.constants.signals as Record<string, number>;
+  if (typeof value === 'string' && value in signals) {
+    return value as NodeJS.Signals;
+  }
+  const name = Object.keys(signals).find((signal) => signals[signal] === value);
+  if (typeof value === 'number' && name) {
+    return name as NodeJS.Signals;
+  }
+  throw new ControlCommandError(`invalid signal: ${String(value)}`);
+}
+
+/**
+ * Validate the arguments of a command. Returns an error message or null;
+ * commands this version does not know are left to the caller.
+ */
+export function validateControlCommand(message: ControlMessage): string | null {
+  try {
+    switch (message.cmd) {
+      case 'resize':
+        if (!isDimension(message.cols) || !isDimension(message.rows)) {
+          return 'cols and rows must be positive integers';
+        }
+        break;
+      case 'kill':
+        if (message.signal !== undefined) parseSignal(message.signal);
+        break;
+      case 'signal':
+        parseSignal(message.signal);
+        break;
+      case 'env':
+        return validateEnvCommand(message);
+      case 'title':
+        if (typeof message.title !== 'string' || !message.title.trim()) {
+          return 'title must be a non-empty string';
+        }
+        if (message.title.length > MAX_TITLE_LENGTH) {
+          return `title must be at most ${MAX_TITLE_LENGTH} characters`;
+        }
+        break;
+      case 'metadata':
+        return validateMetadataCommand(message);
+    }
+  } catch (error) {
+    return (error as Error).message;
+  }
+  return null;
+}
+
+function isDimension(value: unknown): value is number {
+  return typeof value === 'number' && Number.isInteger(value) && value > 0 && value <= 10000;
+}
+
+function validateEnvCommand(message: ControlMessage): string | null {
+  const { set = {}, unset = [] } = message;
+  if (!set || typeof set !== 'object' || Array.isArray(set)) {
+    return 'set must be an object of strings';
+  }
+  for (const [name, value] of Object.entries(set)) {
+    if (!ENV_NAME_PATTERN.test(name)) return `invalid variable name: ${name}`;
+    if (typeof value !== 'string') return `value of ${name} must be a string`;
+  }
+  if (!Array.isArray(unset) || !unset.every((name) => typeof name === 'string')) {
+    return 'unset must be an array of names';
+  }
+  for (const name of unset) {
+    if (!ENV_NAME_PATTERN.test(name)) return `invalid variable name: ${name}`;
+  }
+  return null;
+}
+
+function validateMetadataCommand(message: ControlMessage): string | null {
+  const { currentWorkingDir, description, fields } = message;
+  if (
+    currentWorkingDir !== undefined &&
+    (typeof currentWorkingDir !== 'string' || !path.isAbsolute(currentWorkingDir))
+  ) {
+    return 'currentWorkingDir must be an absolute path';
+  }
+  if (description !== undefined && description !== null) {
+    if (typeof description !== 'string') return 'description must be a string or null';
+    if (description.length > MAX_DESCRIPTION_LENGTH) {
+      return `description must be at most ${MAX_DESCRIPTION_LENGTH} characters`;
+    }
+  }
+  if (fields !== undefined) {
+    if (!fields || typeof fields !== 'object' || Array.isArray(fields)) {
+      return 'fields must be an object of strings';
+    }
+    for (const [name, value] of Object.entries(fields)) {
+      if (!FIELD_NAME_PATTERN.test(name)) return `invalid field name: ${name}`;
+      if (value !== null && typeof value !== 'string') {
+        return `value of field ${name} must be a string or null`;
+      }
+      if (typeof value === 'string' && value.length > MAX_FIELD_LENGTH) {
+        return `value of field ${name} must be at most ${MAX_FIELD_LENGTH} characters`;
+      }
+    }
+  }
+  return null;
+}
+
+/**
+ * Apply a metadata command to a session's info. A session keeps at most
+ * MAX_FIELDS custom fields; changes beyond that are rejected.
+ */
+export function applyMetadataCommand(info: SessionInfo, message: ControlMessage): void {
+  const fields: Record<string, string> = { ...info.fields };
+  for (const [name, value] of Object.entries(
+    (message.fields ?? {}) as Record<string, string | null>
+  )) {
+    if (value === null) {
+      delete fields[name];
+    } else {
+      fields[name] = value;
+    }
+  }
+  if (Object.keys(fields).length > MAX_FIELDS) {
+    throw new ControlCommandError(`a session has at most ${MAX_FIELDS} fields`);
+  }
+
+  if (typeof message.currentWorkingDir === 'string') {
+    info.currentWorkingDir = message.currentWorkingDir;
+  }
+  if (message.description === null || message.description === '') {
+    delete info.description;
+  } else if (typeof message.description === 'string') {
+    info.description = message.description;
+  }
+  if (Object.keys(fields).length) {
+    info.fields = fields;
+  } else {
+    delete info.fields;
+  }
+}
+
+/**
+ * The metadata of a session that metadata commands change
+ */
+export function sessionMetadata(
+  info: SessionInfo
+): Pick<SessionInfo, 'currentWorkingDir' | 'description' | 'fields'> {
+  return {
+    currentWorkingDir: info.currentWorkingDir,
+    description: info.description,
+    fields: info.fields ?? {},
+  };
+}
+
+/**
+ * Apply an env command to the pending environment changes of a session
+ * (null: unset). Returns the changes.
+ */
+export function applyEnvCommand(
+  pending: Record<string, string | null> | undefined,
+  message: ControlMessage
+): Record<string, string | null> {
+  const result = { ...pending };
+  for (const [name, value] of Object.entries((message.set ?? {}) as Record<string, string>)) {
+    result[name] = value;
+  }
+  for (const name of (message.unset ?? []) as string[]) {
+    result[name] = null;
+  }
+  return result;
+}
+
+/**
+ * Reads the lines appended to a file since the last read. An incomplete last
+ * line is left for the next read, so a line written in two parts is read once.
+ */
+export class AppendedLineReader {
+  constructor(
+    private filePath: string,
+    private offset = 0
+  ) {}
+
+  /**
+   * Start at the current end of the file
+   */
+  static fromEnd(filePath: string): AppendedLineReader {
+    let size = 0;
+    try {
+      size = fs.statSync(filePath).size;
+    } catch {
+      // Not created yet
+    }
+    return new AppendedLineReader(filePath, size);
+  }
+
+  read(): string[] {
+    let size: number;
+    try {
+      size = fs.statSync(this.filePath).size;
+    } catch {
+      return [];
+    }
+    if (size < this.offset) {
+      // Truncated: start over
+      this.offset = 0;
+    }
+    if (size === this.offset) return [];
+
+    const buffer = Buffer.alloc(size - this.offset);
+    const fd = fs.openSync(this.filePath, 'r');
+    try {
+      fs.readSync(fd, buffer, 0, buffer.length, this.offset);
+    } finally {
+      fs.closeSync(fd);
+    }
+    const end = buffer.lastIndexOf(0x0a);
+    if (end === -1) return [];
+    this.offset += end + 1;
+    return buffer
+      .subarray(0, end)
+      .toString('utf8')
+      .split('\n')
+      .filter((line) => line.trim());
+  }
+}
+
+/**
+ * Append a command to a session's control file
+ */
+export function appendControlCommand(controlPath: string, message: ControlMessage): void {
+  fs.appendFileSync(controlPath, `${JSON.stringify(message)}\n`);
+}
+
+/**
+ * Acknowledge a command in the acknowledgement file next to the control file
+ */
+export function appendControlAck(controlPath: string, ack: ControlAck): void {
+  fs.appendFileSync(controlAckPath(controlPath), `${JSON.stringify(ack)}\n`);
+}
+
+/**
+ * Append a command with a new ID to a session's control file and wait for its
+ * acknowledgement. Resolves to null if none arrives within the timeout (no
+ * process owns the session, or it predates acknowledgements).
+ */
+export async function sendControlCommand(
+  controlPath: string,
+  message: ControlMessage,
+  timeoutMs = DEFAULT_ACK_TIMEOUT_MS
+): Promise<ControlAck | null> {
+  const id = `${process.pid}-${Date.now().toString(36)}-${Math.random().toString(36).slice(2, 8)}`;
+  const acks = AppendedLineReader.fromEnd(controlAckPath(controlPath));
+  appendControlCommand(controlPath, { ...message, id });
+
+  const deadline = Date.now() + timeoutMs;
+  while (Date.now() < deadline) {
+    await new Promise((resolve) => setTimeout(resolve, ACK_POLL_INTERVAL_MS));
+    for (const line of acks.read()) {
+      try {
+        const ack = JSON.parse(line);
+        if (ack?.id === id) return ack as ControlAck;
+      } catch {
+        // Not an acknowledgement
+      }
+    }
+  }
+  return null;
+}
@@ -0,0 +1,168 @@
+/**
+ * ControlRoots - The directories session directories are created in
+ *
+ * The first root is the default one; server-wide state (groups, schedules,
+ * triggers, ...) is kept there as well. Further roots, e.g. one per project
+ * or disk, receive the new sessions whose tags or creator they match.
+ * Existing sessions are found in whichever root holds their directory.
+ */
+
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { isValidSessionId, sessionPath } from './session-id.js';
+
+export interface ControlRoot {
+  name: string;
+  path: string;
+  // New sessions with one of these tags are created in this root
+  tags?: string[];
+  // New sessions created by one of these users are created in this root
+  users?: string[];
+}
+
+export const DEFAULT_CONTROL_ROOT = 'default';
+
+export class ControlRoots {
+  private readonly roots: ControlRoot[];
+  // Root of each session seen so far
+  private readonly sessionRoots = new Map<string, ControlRoot>();
+
+  constructor(roots: ControlRoot[]) {
+    if (roots.length === 0) {
+      throw new Error('At least one control root is required');
+    }
+    this.roots = roots.map((root) => ({ ...root, path: path.resolve(root.path) }));
+  }
+
+  /**
+   * Roots for a single control directory (the default one if none given)
+   */
+  static from(controlPath?: string | ControlRoots): ControlRoots {
+    if (controlPath instanceof ControlRoots) return controlPath;
+    return new ControlRoots([
+      {
+        name: DEFAULT_CONTROL_ROOT,
+        path: controlPath || path.join(os.homedir(), '.vibetunnel', 'control'),
+      },
+    ]);
+  }
+
+  /**
+   * Directory of the default root
+   */
+  get primary(): string {
+    return this.roots[0].path;
+  }
+
+  list(): ControlRoot[] {
+    return [...this.roots];
+  }
+
+  get(name: string): ControlRoot | undefined {
+    return this.roots.find((root) => root.name === name);
+  }
+
+  /**
+   * Root a new session is created in: the first root listing its creator or
+   * one of its tags, otherwise the default root
+   */
+  select(criteria: { tags?: string[]; user?: string } = {}): ControlRoot {
+    const tags = criteria.tags ?? [];
+    return (
+      this.roots.find(
+        (root) =>
+          (criteria.user !== undefined && root.users?.includes(criteria.user)) ||
+          tags.some((tag) => root.tags?.includes(tag))
+      ) ?? this.roots[0]
+    );
+  }
+
+  /**
+   * Record the root a new session is created in
+   */
+  assign(sessionId: string, root: ControlRoot): string {
+    const dir = sessionPath(root.path, sessionId);
+    this.sessionRoots.set(sessionId, root);
+    return dir;
+  }
+
+  forget(sessionId: string): void {
+    this.sessionRoots.delete(sessionId);
+  }
+
+  /**
+   * Root holding a session's directory, if it exists
+   */
+  findRoot(sessionId: string): ControlRoot | undefined {
+    if (!isValidSessionId(sessionId)) return undefined;
+    const known = this.sessionRoots.get(sessionId);
+    if (known && fs.existsSync(path.join(known.path, sessionId))) return known;
+
+    const root = this.roots.find((candidate) =>
+      fs.existsSync(path.join(candidate.path, sessionId))
+    );
+    if (root) {
+      this.sessionRoots.set(sessionId, root);
+    } else {
+      this.sessionRoots.delete(sessionId);
+    }
+    return root;
+  }
+
+  /**
+   * Directory of a session; sessions not found belong to the root they were
+   * assigned to, or the default root. Throws for invalid session IDs.
+   */
+  sessionDir(sessionId: string): string {
+    const root = this.findRoot(sessionId) ?? this.sessionRoots.get(sessionId) ?? this.roots[0];
+    return sessionPath(root.path, sessionId);
+  }
+
+  /**
+   * Session directories of all roots. A session ID present in several roots
+   * is taken from the first one.
+   */
+  listSessionDirs(): { sessionId: string; dir: string; root: ControlRoot }[] {
+    const result: { sessionId: string; dir: string; root: ControlRoot }[] = [];
+    const seen = new Set<string>();
+    for (const root of this.roots) {
+      if (!fs.existsSync(root.path)) continue;
+      for (const entry of fs.readdirSync(root.path, { withFileTypes: true })) {
+        if (!entry.isDirectory() || !isValidSessionId(entry.name) || seen.has(entry.name)) {
+          continue;
+        }
+        seen.add(entry.name);
+        this.sessionRoots.set(entry.name, root);
+        result.push({ sessionId: entry.name, dir: path.join(root.path, entry.name), root });
+      }
+    }
+    return result;
+  }
+}
+
+/**
+ * Parse a --control-root value: <path>[?name=<name>&tags=a,b&users=u,v]
+ */
+export function parseControlRoot(spec: string): ControlRoot {
+  const [rootPath, query = ''] = spec.split('?', 2);
+  if (!rootPath) {
+    throw new Error(`Control root has no path: ${spec}`);
+  }
+  const params = new URLSearchParams(query);
+  const list = (key: string) =>
+    params
+      .get(key)
+      ?.split(',')
+      .map((value) => value.trim())
+      .filter(Boolean);
+
+  const expanded = rootPath.startsWith('~/')
+    ? path.join(os.homedir(), rootPath.slice(2))
+    : rootPath;
+  const name = params.get('name') || path.basename(path.resolve(expanded));
+  if (!/^[A-Za-z0-9._-]+$/.test(name)) {
+    throw new Error(`Invalid control root name: ${name}`);
+  }
+  return { name, path: expanded, tags: list('tags'), users: list('users') };
+}
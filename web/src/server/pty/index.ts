@@ -7,10 +7,39 @@
 
 // Individual components (for advanced usage)
 export { AsciinemaWriter } from './asciinema-writer.js';
+export { type ControlAck, validateControlCommand } from './control-protocol.js';
+export {
+  type ControlRoot,
+  ControlRoots,
+  DEFAULT_CONTROL_ROOT,
+  parseControlRoot,
+} from './control-roots.js';
+export {
+  OutputBroadcaster,
+  type OutputLine,
+  type OutputListener,
+  type OutputSubscription,
+  splitBacklog,
+} from './output-broadcaster.js';
+export { compositionInputData, createUtf8ChunkDecoder } from './input-encoding.js';
+export { type InlineImage, inlineImageUrl } from './inline-images.js';
 export { ProcessUtils } from './process-utils.js';
 // Main service interface
-export { PtyManager } from './pty-manager.js';
+export { PtyManager, type PtyManagerOptions, type SessionLimits } from './pty-manager.js';
+export { parsePtyPoolProfile, type PtyPoolProfile, type PtyPoolProfileStats } from './pty-pool.js';
+export { matchesAgentFilter, parseAgentFields } from './session-agent.js';
+export { assertValidSessionId, isValidSessionId, sessionPath } from './session-id.js';
+export { isSessionPriority, SESSION_PRIORITIES } from './session-priority.js';
+export { type InitMode, type SessionInitOptions, supportsRcInit } from './session-init.js';
 export { SessionManager } from './session-manager.js';
+export {
+  COMMAND_DONE_MARKER,
+  COMMAND_MARKER,
+  commandScript,
+  PROMPT_MARKER,
+  stripEscapeSequences,
+  visibleLineText,
+} from './shell-integration.js';
 // Core types
 export * from './types.js';
 
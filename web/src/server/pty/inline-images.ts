@@ -0,0 +1,351 @@
+/**
+ * Inline images - iTerm2 (OSC 1337 File=) and sixel (DCS q) images in PTY output
+ *
+ * Programs that print images embed the whole picture in an escape sequence,
+ * which the web terminal cannot show. InlineImageExtractor takes these
+ * sequences out of the output; the session stores each image next to its cast
+ * and records an `image <metadata>` marker where it was printed, which viewers
+ * turn into image events with a URL to fetch the picture from.
+ */
+
+import * as fs from 'fs';
+import * as readline from 'readline';
+import { decodeCastLine } from '../utils/recording-crypto.js';
+import { encodePng } from '../utils/snapshot-image.js';
+
+export const IMAGE_MARKER = 'image';
+
+export interface InlineImage {
+  id: string;
+  format: 'iterm2' | 'sixel';
+  mime: string;
+  // Bytes of the stored image
+  size: number;
+  name?: string;
+  // Display size requested by the program: iTerm2's N (cells), Npx, N% or auto,
+  // the pixel size (Npx) of sixel images
+  width?: string;
+  height?: string;
+}
+
+export type ExtractedImage = Omit<InlineImage, 'id' | 'size'> & { data: Buffer };
+
+// Images over this many characters are passed through instead of buffered
+const MAX_SEQUENCE_LENGTH = 16 * 1024 * 1024;
+// Sixel images are clipped to this many pixels in each direction
+const MAX_SIXEL_SIZE = 4096;
+
+const SEQUENCE_START = /\x1b(?:\]1337;File=|P([0-9;]*)q)/g;
+const ITERM_START = '\x1b]1337;File=';
+
+const MIME_EXTENSIONS: Record<string, string> = {
+  'image/png': 'png',
+  'image/jpeg': 'jpg',
+  'image/gif': 'gif',
+  'image/webp': 'webp',
+  'image/bmp': 'bmp',
+};
+
+/**
+ * File name an image is stored under in the session's image directory
+ */
+export function imageFileName(image: Pick<InlineImage, 'id' | 'mime'>): string {
+  return `${image.id}.${MIME_EXTENSIONS[image.mime] ?? 'bin'}`;
+}
+
+/**
+ * URL clients fetch an image of a session from
+ */
+export function inlineImageUrl(sessionId: string, imageId: string): string {
+  return `/api/sessions/${encodeURIComponent(sessionId)}/images/${imageId}`;
+}
+
+function isStartPrefix(text: string): boolean {
+  return ITERM_START.startsWith(text) || /^\x1bP[0-9;]*$/.test(text);
+}
+
+export class InlineImageExtractor {
+  // Unfinished image sequence, or the start of what may become one
+  private pending = '';
+  // How much of the pending sequence was searched for its terminator
+  private scanned = 0;
+
+  constructor(
+    private onText: (text: string) => void,
+    private onImage: (image: ExtractedImage) => void
+  ) {}
+
+  /**
+   * Pass PTY output through; text reaches onText and images onImage, in order
+   */
+  feed(data: string): void {
+    // Fast path: no escape sequences in this chunk or a pending partial one
+    if (!this.pending && !data.includes('\x1b')) {
+      this.onText(data);
+      return;
+    }
+
+    const text = this.pending + data;
+    const resumeAt = this.scanned;
+    this.pending = '';
+    this.scanned = 0;
+    let pos = 0;
+
+    while (pos < text.length) {
+      SEQUENCE_START.lastIndex = pos;
+      const match = SEQUENCE_START.exec(text);
+      if (!match) break;
+
+      const isIterm = match[1] === undefined;
+      const bodyStart = match.index + match[0].length;
+      const from = match.index === 0 ? Math.max(bodyStart, resumeAt - 1) : bodyStart;
+      const end = findTerminator(text, from, isIterm);
+      if (!end) {
+        if (text.length - match.index > MAX_SEQUENCE_LENGTH) break;
+        this.emitText(text.slice(pos, match.index));
+        this.pending = text.slice(match.index);
+        this.scanned = this.pending.length;
+        return;
+      }
+
+      const body = text.slice(bodyStart, end.bodyEnd);
+      // Sequences cut short by another escape are cancelled
+      const image = end.cancelled ? null : isIterm ? parseItermImage(body) : decodeSixel(body);
+      if (image) {
+        this.emitText(text.slice(pos, match.index));
+        this.onImage(image);
+      } else {
+        // Not an image (e.g. a file download); the terminal ignores it
+        this.emitText(text.slice(pos, end.next));
+      }
+      pos = end.next;
+    }
+
+    // Hold back what may be the start of an image sequence
+    const rest = text.slice(pos);
+    const escape = rest.lastIndexOf('\x1b');
+    if (escape !== -1 && rest.length - escape <= 32 && isStartPrefix(rest.slice(escape))) {
+      this.pending = rest.slice(escape);
+      this.emitText(rest.slice(0, escape));
+    } else {
+      this.emitText(rest);
+    }
+  }
+
+  private emitText(text: string): void {
+    if (text) {
+      this.onText(text);
+    }
+  }
+}
+
+/**
+ * End of the sequence body starting at `from`: BEL (OSC only) or ST. An escape
+ * that does not start ST cancels the sequence and is left to follow it.
+ */
+function findTerminator(
+  text: string,
+  from: number,
+  allowBel: boolean
+): { bodyEnd: number; next: number; cancelled: boolean } | null {
+  const escape = text.indexOf('\x1b', from);
+  const bel = allowBel ? text.indexOf('\x07', from) : -1;
+  if (bel !== -1 && (escape === -1 || bel < escape)) {
+    return { bodyEnd: bel, next: bel + 1, cancelled: false };
+  }
+  if (escape === -1 || escape === text.length - 1) return null;
+  const cancelled = text[escape + 1] !== '\\';
+  return { bodyEnd: escape, next: cancelled ? escape : escape + 2, cancelled };
+}
+
+function detectMime(data: Buffer): string {
+  if (data.subarray(0, 4).equals(Buffer.from([0x89, 0x50, 0x4e, 0x47]))) return 'image/png';
+  if (data[0] === 0xff && data[1] === 0xd8 && data[2] === 0xff) return 'image/jpeg';
+  if (data.subarray(0, 4).toString('latin1') === 'GIF8') return 'image/gif';
+  if (
+    data.subarray(0, 4).toString('latin1') === 'RIFF' &&
+    data.subarray(8, 12).toString('latin1') === 'WEBP'
+  ) {
+    return 'image/webp';
+  }
+  if (data.subarray(0, 2).toString('latin1') === 'BM') return 'image/bmp';
+  return 'application/octet-stream';
+}
+
+/**
+ * Image of an OSC 1337 File= body (`key=value;...:<base64>`), or null if the
+ * file is not meant to be shown inline
+ */
+function parseItermImage(body: string): ExtractedImage | null {
+  const colon = body.indexOf(':');
+  if (colon === -1) return null;
+
+  const args = new Map<string, string>();
+  for (const arg of body.slice(0, colon).split(';')) {
+    const equals = arg.indexOf('=');
+    if (equals > 0) args.set(arg.slice(0, equals), arg.slice(equals + 1));
+  }
+  if (args.get('inline') !== '1') return null;
+
+  const data = Buffer.from(body.slice(colon + 1), 'base64');
+  if (data.length === 0) return null;
+
+  const name = args.get('name');
+  return {
+    format: 'iterm2',
+    mime: detectMime(data),
+    data,
+    name: name ? Buffer.from(name, 'base64').toString('utf8') : undefined,
+    width: args.get('width'),
+    height: args.get('height'),
+  };
+}
+
+// VT340 default color registers
+const SIXEL_PALETTE = [
+  0x000000, 0x3333cc, 0xcc2424, 0x33cc33, 0xcc33cc, 0x33cccc, 0xcccc33, 0x787878, 0x454545,
+  0x575799, 0x994545, 0x579957, 0x995799, 0x579999, 0x999957, 0xcccccc,
+];
+
+function hlsToRgb(hue: number, lightness: number, saturation: number): number {
+  // Sixel hues start at blue; standard HSL hues at red
+  const h = ((hue + 240) % 360) / 360;
+  const l = lightness / 100;
+  const s = saturation / 100;
+  const q = l < 0.5 ? l * (1 + s) : l + s - l * s;
+  const p = 2 * l - q;
+  const channel = (offset: number) => {
+    const t = (h + offset + 1) % 1;
+    if (t < 1 / 6) return Math.round((p + (q - p) * 6 * t) * 255);
+    if (t < 1 / 2) return Math.round(q * 255);
+    if (t < 2 / 3) return Math.round((p + (q - p) * (2 / 3 - t) * 6) * 255);
+    return Math.round(p * 255);
+  };
+  return (channel(1 / 3) << 16) | (channel(0) << 8) | channel(-1 / 3);
+}
+
+/**
+ * Render sixel data as a PNG image, or null if it draws nothing
+ */
+export function decodeSixel(body: string): ExtractedImage | null {
+  const palette = new Map(SIXEL_PALETTE.map((color, index) => [index, color]));
+  const pixels: number[][] = [];
+  let declaredWidth = 0;
+  let declaredHeight = 0;
+  let width = 0;
+  let height = 0;
+  let color = 0;
+  let x = 0;
+  let y = 0;
+  let repeat = 1;
+  let i = 0;
+
+  const readNumbers = (): number[] => {
+    const match = body.slice(i).match(/^[0-9;]*/);
+    const text = match ? match[0] : '';
+    i += text.length;
+    return text.split(';').map((value) => Number(value) || 0);
+  };
+
+  while (i < body.length) {
+    const char = body[i++];
+    if (char === '"') {
+      const [, , ph, pv] = readNumbers();
+      declaredWidth = Math.min(ph ?? 0, MAX_SIXEL_SIZE);
+      declaredHeight = Math.min(pv ?? 0, MAX_SIXEL_SIZE);
+    } else if (char === '#') {
+      const [register, system, a, b, c] = readNumbers();
+      color = register;
+      if (system === 1) palette.set(register, hlsToRgb(a, b, c));
+      if (system === 2) {
+        const level = (percent: number) => Math.round((Math.min(percent, 100) * 255) / 100);
+        palette.set(register, (level(a) << 16) | (level(b) << 8) | level(c));
+      }
+    } else if (char === '!') {
+      repeat = Math.max(1, readNumbers()[0]);
+    } else if (char === '$') {
+      x = 0;
+    } else if (char === '-') {
+      x = 0;
+      y += 6;
+    } else if (char >= '?' && char <= '~') {
+      const bits = char.charCodeAt(0) - 63;
+      for (let bit = 0; bit < 6; bit++) {
+        const row = y + bit;
+        if (!(bits & (1 << bit)) || row >= MAX_SIXEL_SIZE) continue;
+        pixels[row] ??= [];
+        for (let col = x; col < Math.min(x + repeat, MAX_SIXEL_SIZE); col++) {
+          pixels[row][col] = color;
+        }
+        height = Math.max(height, row + 1);
+      }
+      if (bits) width = Math.max(width, Math.min(x + repeat, MAX_SIXEL_SIZE));
+      x += repeat;
+      repeat = 1;
+    }
+  }
+
+  width = Math.max(width, declaredWidth);
+  height = Math.max(height, declaredHeight);
+  if (width === 0 || height === 0) return null;
+
+  // Unpainted pixels keep the background (color register 0)
+  const rgb = Buffer.alloc(width * height * 3);
+  for (let row = 0; row < height; row++) {
+    for (let col = 0; col < width; col++) {
+      const value = palette.get(pixels[row]?.[col] ?? 0) ?? 0;
+      const offset = (row * width + col) * 3;
+      rgb[offset] = value >> 16;
+      rgb[offset + 1] = (value >> 8) & 0xff;
+      rgb[offset + 2] = value & 0xff;
+    }
+  }
+
+  return {
+    format: 'sixel',
+    mime: 'image/png',
+    data: encodePng(width, height, rgb),
+    width: `${width}px`,
+    height: `${height}px`,
+  };
+}
+
+/**
+ * Metadata of an image marker's data (`image <json>`), or null if it is not one
+ */
+export function parseImageMarker(data: string): InlineImage | null {
+  if (!data.startsWith(`${IMAGE_MARKER} `)) return null;
+  try {
+    const image = JSON.parse(data.slice(IMAGE_MARKER.length + 1));
+    return typeof image?.id === 'string' && typeof image.mime === 'string' ? image : null;
+  } catch {
+    return null;
+  }
+}
+
+/**
+ * List the images recorded in a cast file, with the time (seconds into the
+ * recording) they were printed at
+ */
+export async function readInlineImages(
+  castPath: string
+): Promise<Array<InlineImage & { time: number }>> {
+  const images: Array<InlineImage & { time: number }> = [];
+  const lines = readline.createInterface({
+    input: fs.createReadStream(castPath, 'utf8'),
+    crlfDelay: Number.POSITIVE_INFINITY,
+  });
+  for await (const storedLine of lines) {
+    const line = decodeCastLine(storedLine);
+    // Cheap check before parsing every output event
+    if (!line.includes('"m"')) continue;
+    try {
+      const event = JSON.parse(line);
+      const image = Array.isArray(event) && event[1] === 'm' ? parseImageMarker(event[2]) : null;
+      if (image) images.push({ ...image, time: event[0] });
+    } catch {
+      // Not an event
+    }
+  }
+  return images;
+}
@@ -0,0 +1,39 @@
+/**
+ * Input encoding helpers
+ *
+ * Input reaches a PTY as byte chunks (from the input socket of forwarded
+ * sessions) or as API input objects. Byte chunks can end in the middle of a
+ * multi-byte UTF-8 character, and IME composition input must only reach the
+ * PTY once the composition is committed.
+ */
+
+import { StringDecoder } from 'string_decoder';
+import type { SessionInput } from '../../shared/types.js';
+
+/**
+ * Create a decoder for a stream of UTF-8 chunks. Incomplete characters at the
+ * end of a chunk are held back until the rest arrives, instead of being
+ * replaced with U+FFFD.
+ */
+export function createUtf8ChunkDecoder(): {
+  write: (chunk: Buffer) => string;
+  end: () => string;
+} {
+  const decoder = new StringDecoder('utf8');
+  return {
+    write: (chunk) => decoder.write(chunk),
+    end: () => decoder.end(),
+  };
+}
+
+/**
+ * Text to write for a composition input, or null if nothing should reach the
+ * PTY. Only a commit produces output; start, update and cancel only describe
+ * the in-progress composition shown by the client.
+ */
+export function compositionInputData(input: SessionInput): string | null {
+  if (input.composition !== 'commit') {
+    return null;
+  }
+  return input.text || null;
+}
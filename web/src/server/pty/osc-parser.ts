@@ -0,0 +1,89 @@
+/**
+ * OscParser - Picks operating system commands out of PTY output
+ *
+ * Shells report their state with OSC sequences (ESC ] Ps ; Pt, ended by BEL or
+ * ESC \), e.g. the working directory with OSC 7. Sequences may be split across
+ * output chunks; an unterminated one is kept until a later chunk completes it.
+ */
+
+const OSC_SEQUENCE = /\x1b\](\d+);([^\x07\x1b]*)(?:\x07|\x1b\\)/g;
+
+// Longer unterminated sequences are dropped instead of buffered
+const MAX_PENDING_LENGTH = 4096;
+
+export type OscHandler = (code: number, data: string) => void;
+// Receives the output between sequences, in order with the handler calls
+export type OscTextHandler = (text: string) => void;
+
+export class OscParser {
+  private codes: Set<number>;
+  private pending = '';
+
+  constructor(
+    codes: number[],
+    private handler: OscHandler,
+    private textHandler?: OscTextHandler
+  ) {
+    this.codes = new Set(codes);
+  }
+
+  /**
+   * Scan PTY output for OSC sequences with one of the parser's codes
+   */
+  feed(data: string): void {
+    // Fast path: no escape sequences in this chunk or a pending partial one
+    if (!this.pending && !data.includes('\x1b')) {
+      this.textHandler?.(data);
+      return;
+    }
+
+    const text = this.pending + data;
+    let consumed = 0;
+
+    OSC_SEQUENCE.lastIndex = 0;
+    for (let match = OSC_SEQUENCE.exec(text); match; match = OSC_SEQUENCE.exec(text)) {
+      const code = Number(match[1]);
+      if (this.codes.has(code)) {
+        this.emitText(text.slice(consumed, match.index));
+        this.handler(code, match[2]);
+        consumed = OSC_SEQUENCE.lastIndex;
+      }
+    }
+
+    this.pending = '';
+    let end = text.length;
+    const start = text.lastIndexOf('\x1b]');
+    if (start >= consumed) {
+      // Keep the sequence if it is only missing its terminator
+      const body = text.slice(start + 2).replace(/\x1b$/, '');
+      if (!/[\x07\x1b]/.test(body) && text.length - start < MAX_PENDING_LENGTH) {
+        this.pending = text.slice(start);
+        end = start;
+      }
+    } else if (text.endsWith('\x1b')) {
+      this.pending = '\x1b';
+      end = text.length - 1;
+    }
+    this.emitText(text.slice(consumed, end));
+  }
+
+  private emitText(text: string): void {
+    if (text) {
+      this.textHandler?.(text);
+    }
+  }
+}
+
+/**
+ * Directory of an OSC 7 report (file://host/path, percent-encoded), or null
+ * if the report is not a file URL
+ */
+export function parseCwdReport(data: string): string | null {
+  const match = data.match(/^(?:file|kitty-shell-cwd):\/\/[^/]*(\/.*)$/);
+  if (!match) return null;
+  try {
+    return decodeURIComponent(match[1]);
+  } catch {
+    return match[1];
+  }
+}
@@ -0,0 +1,145 @@
+/**
+ * OutputBroadcaster - In-memory fan-out of a session's cast lines
+ *
+ * The AsciinemaWriter publishes every line it writes to the stream file here,
+ * tagged with the byte range the line occupies in that file. Live viewers in
+ * the same process subscribe directly instead of waiting for the file to be
+ * flushed, watched and read back. A bounded ring of recent lines covers the
+ * window where lines are published but not yet flushed to disk, so a new
+ * subscriber can replay the file up to its flushed size and continue from the
+ * ring without gaps or duplicates.
+ */
+
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('output-broadcaster');
+
+// Keep up to 1MB of recent lines in memory per session
+const DEFAULT_RING_BYTES = 1024 * 1024;
+
+export interface OutputLine {
+  line: string; // JSON cast line without trailing newline
+  startOffset: number; // Byte offset of the line in the stream file
+  endOffset: number; // Byte offset just past the trailing newline
+}
+
+export type OutputListener = (entry: OutputLine) => void;
+
+export interface OutputSubscription {
+  // Recent lines published before subscribing, oldest first
+  backlog: OutputLine[];
+  unsubscribe: () => void;
+}
+
+export interface BacklogSplit {
+  // Replay the stream file up to this byte offset...
+  fileEndOffset: number;
+  // ...then these lines from memory
+  memoryLines: OutputLine[];
+  // True if lines between the file and the backlog were evicted
+  hasGap: boolean;
+}
+
+/**
+ * Given a subscription backlog and the number of bytes already flushed to the
+ * stream file, work out how much history to read from the file and which
+ * lines to take from memory so that every line is delivered exactly once.
+ */
+export function splitBacklog(backlog: OutputLine[], flushedSize: number): BacklogSplit {
+  const firstUnflushed = backlog.findIndex((entry) => entry.endOffset > flushedSize);
+  if (firstUnflushed === -1) {
+    return { fileEndOffset: flushedSize, memoryLines: [], hasGap: false };
+  }
+
+  const entry = backlog[firstUnflushed];
+  const hasGap = entry.startOffset > flushedSize;
+  return {
+    // A partially flushed line is taken from memory instead
+    fileEndOffset: hasGap ? flushedSize : entry.startOffset,
+    memoryLines: backlog.slice(firstUnflushed),
+    hasGap,
+  };
+}
+
+export class OutputBroadcaster {
+  private ring: OutputLine[] = [];
+  private ringBytes = 0;
+  private offset = 0;
+  private listeners = new Set<OutputListener>();
+  private closed = false;
+
+  constructor(private maxRingBytes: number = DEFAULT_RING_BYTES) {}
+
+  /**
+   * Publish a line that is about to be appended to the stream file. Offsets
+   * follow the line as stored, which differs from it in encrypted recordings.
+   */
+  publish(line: string, storedLine: string = line): void {
+    const size = Buffer.byteLength(storedLine, 'utf8') + 1; // Include newline
+    const entry: OutputLine = {
+      line,
+      startOffset: this.offset,
+      endOffset: this.offset + size,
+    };
+    this.offset += size;
+
+    this.ring.push(entry);
+    this.ringBytes += size;
+    while (this.ringBytes > this.maxRingBytes && this.ring.length > 1) {
+      const evicted = this.ring.shift();
+      if (evicted) {
+        this.ringBytes -= evicted.endOffset - evicted.startOffset;
+      }
+    }
+
+    for (const listener of this.listeners) {
+      try {
+        listener(entry);
+      } catch (error) {
+        logger.error('output listener failed:', error);
+      }
+    }
+  }
+
+  /**
+   * Subscribe to new lines. The backlog and the subscription are taken
+   * atomically, so no line is missed between them.
+   */
+  subscribe(listener: OutputListener): OutputSubscription {
+    if (!this.closed) {
+      this.listeners.add(listener);
+    }
+    return {
+      backlog: this.ring.slice(),
+      unsubscribe: () => {
+        this.listeners.delete(listener);
+      },
+    };
+  }
+
+  /**
+   * Total bytes published so far (the stream file size once fully flushed)
+   */
+  getOffset(): number {
+    return this.offset;
+  }
+
+  isClosed(): boolean {
+    return this.closed;
+  }
+
+  getListenerCount(): number {
+    return this.listeners.size;
+  }
+
+  /**
+   * Stop accepting subscribers and release memory. Existing listeners have
+   * already received the final (exit) line.
+   */
+  close(): void {
+    this.closed = true;
+    this.listeners.clear();
+    this.ring = [];
+    this.ringBytes = 0;
+  }
+}
@@ -13,35 +13,136 @@ import type { IPty } from 'node-pty';
 import * as pty from 'node-pty';
 import * as path from 'path';
 import { v4 as uuidv4 } from 'uuid';
+import { encodeKey, type KeyEncodingModes } from '../../shared/keymap.js';
 import type {
   Session,
+  SessionArchive,
+  SessionCrash,
   SessionCreateOptions,
   SessionInfo,
   SessionInput,
-  SpecialKey,
+  SessionMark,
+  SessionPriority,
 } from '../../shared/types.js';
+import { guardSessionTask } from '../services/crash-reporter.js';
 import { ProcessTreeAnalyzer } from '../services/process-tree-analyzer.js';
+import type { InputSource } from '../utils/input-source.js';
+import { accountEnvironment, type LocalAccount } from '../utils/local-accounts.js';
 import { createLogger } from '../utils/logger.js';
 import { WriteQueue } from '../utils/write-queue.js';
 import { AsciinemaWriter } from './asciinema-writer.js';
+import {
+  AppendedLineReader,
+  appendControlAck,
+  appendControlCommand,
+  applyEnvCommand,
+  applyMetadataCommand,
+  type ControlAck,
+  ControlCommandError,
+  controlAckPath,
+  parseSignal,
+  sessionMetadata,
+  validateControlCommand,
+} from './control-protocol.js';
+import type { ControlRoots } from './control-roots.js';
+import {
+  type ExtractedImage,
+  IMAGE_MARKER,
+  type InlineImage,
+  InlineImageExtractor,
+  imageFileName,
+  readInlineImages,
+} from './inline-images.js';
+import { compositionInputData, createUtf8ChunkDecoder } from './input-encoding.js';
+import { OscParser, parseCwdReport } from './osc-parser.js';
+import {
+  OutputBroadcaster,
+  type OutputListener,
+  type OutputSubscription,
+} from './output-broadcaster.js';
+import { TerminalModeTracker } from './terminal-modes.js';
 import { ProcessUtils } from './process-utils.js';
+import {
+  type PooledPty,
+  PtyPool,
+  type PtyPoolProfile,
+  type PtyPoolProfileStats,
+} from './pty-pool.js';
+import { ResizeThrottle } from './resize-throttle.js';
+import { applyAgentFields, parseAgentFields } from './session-agent.js';
+import { type SessionStats, SessionCounters } from './session-counters.js';
+import {
+  ENV_FILE,
+  ENV_HOOK_SCRIPT,
+  envFileLines,
+  INIT_DONE_MARKER,
+  INIT_MARKER,
+  INIT_MAX_MS,
+  INIT_SETTLE_MS,
+  type InitMode,
+  initScriptInput,
+  prepareRcInit,
+  type SessionInitOptions,
+} from './session-init.js';
+import { assertValidSessionId } from './session-id.js';
 import { SessionManager } from './session-manager.js';
+import { openStdinFifo } from './stdin-fifo.js';
+import { applySessionPriority, removeSessionCgroup, sessionCgroupDir } from './session-priority.js';
+import { type CommandHistory, CommandTracker, readCommandHistory } from './shell-integration.js';
 import {
+  type ControlMessage,
   type KillControlMessage,
+  type MetadataControlMessage,
   PtyError,
   type PtySession,
   type ResetSizeControlMessage,
   type ResizeControlMessage,
   type SessionCreationResult,
+  SessionLimitError,
+  type TitleControlMessage,
 } from './types.js';
 
 const logger = createLogger('pty-manager');
 
+// OSC codes shells use to report their working directory and prompt marks
+const OSC_CWD = 7;
+const OSC_PROMPT_MARK = 133;
+
+// Control pipe commands only fwd sessions run
+const FWD_ONLY_COMMANDS = ['resize', 'reset-size', 'kill'];
+
+// Per-session log of who sent input, next to the cast file
+const INPUT_AUDIT_FILE = 'input-audit.jsonl';
+
+// Inline images of a session, next to the cast file
+const IMAGES_DIR = 'images';
+// Images past this total size per session are dropped
+const MAX_SESSION_IMAGE_BYTES = 100 * 1024 * 1024;
+
+export interface PtyManagerOptions {
+  // Upper bound for browser resizes applied per session and second
+  maxResizesPerSecond?: number;
+  // Reject resize requests from clients (the hosting terminal still resizes sessions)
+  doNotAllowColumnSet?: boolean;
+  // Max concurrent sessions, globally and per user (0 = unlimited)
+  sessionLimits?: SessionLimits;
+  // Delegated cgroup v2 directory; each session gets a cgroup in it for its priority
+  sessionCgroup?: string;
+  // Shells started in advance for new sessions with the same command and directory
+  ptyPool?: PtyPoolProfile[];
+}
+
+export interface SessionLimits {
+  maxSessions: number;
+  maxSessionsPerUser: number;
+}
+
 export class PtyManager extends EventEmitter {
   private sessions = new Map<string, PtySession>();
   private sessionManager: SessionManager;
   private defaultTerm = 'xterm-256color';
   private inputSocketClients = new Map<string, net.Socket>(); // Cache socket connections
+  private inputAuditQueue = new WriteQueue();
   private lastTerminalSize: { cols: number; rows: number } | null = null;
   private resizeEventListeners: Array<() => void> = [];
   private sessionResizeSources = new Map<
@@ -51,11 +152,68 @@ export class PtyManager extends EventEmitter {
   private lastBellTime = new Map<string, number>(); // Track last bell time per session
   private sessionExitTimes = new Map<string, number>(); // Track session exit times to avoid false bells
   private processTreeAnalyzer = new ProcessTreeAnalyzer(); // Process tree analysis for bell source identification
-
-  constructor(controlPath?: string) {
+  // Keyboard modes for sessions owned by other processes (whose output is not seen here)
+  private keyModeResolver: ((sessionId: string) => KeyEncodingModes | undefined) | null = null;
+  private resizeThrottle: ResizeThrottle;
+  private doNotAllowColumnSet: boolean;
+  private sessionLimits: SessionLimits;
+  private sessionCgroup: string | null;
+  // Sessions whose process group was stopped with SIGSTOP
+  private stoppedSessions = new Set<string>();
+  private ptyPool: PtyPool | null = null;
+
+  constructor(controlPath?: string | ControlRoots, options: PtyManagerOptions = {}) {
     super();
     this.sessionManager = new SessionManager(controlPath);
+    this.resizeThrottle = new ResizeThrottle(options.maxResizesPerSecond);
+    this.doNotAllowColumnSet = options.doNotAllowColumnSet ?? false;
+    this.sessionLimits = options.sessionLimits ?? { maxSessions: 0, maxSessionsPerUser: 0 };
+    this.sessionCgroup = options.sessionCgroup ?? null;
     this.setupTerminalResizeDetection();
+    if (options.ptyPool?.length) {
+      this.startPtyPool(options.ptyPool);
+    }
+  }
+
+  /**
+   * Keep shells of the given profiles started for new sessions to claim
+   */
+  private startPtyPool(profiles: PtyPoolProfile[]): void {
+    const resolved = profiles.map((profile) => {
+      const { command, args } = ProcessUtils.resolveCommand(profile.command);
+      return { ...profile, command: [command, ...args] };
+    });
+    this.ptyPool = new PtyPool(resolved, (profile) => {
+      const sessionId = uuidv4();
+      const root = this.sessionManager.getControlRoots().select();
+      const ptyProcess = pty.spawn(profile.command[0], profile.command.slice(1), {
+        name: this.defaultTerm,
+        cols: 80,
+        rows: 24,
+        cwd: profile.workingDir,
+        env: {
+          ...process.env,
+          TERM: this.defaultTerm,
+          VIBETUNNEL_SESSION_ID: sessionId,
+          VIBETUNNEL_CONTROL_PATH: path.join(root.path, sessionId, 'control'),
+        },
+      });
+      return { sessionId, root: root.name, ptyProcess };
+    });
+    this.ptyPool.start();
+    logger.log(chalk.gray(`PTY pool started for ${resolved.length} profile(s)`));
+  }
+
+  getPtyPoolStats(): PtyPoolProfileStats[] {
+    return this.ptyPool?.getStats() ?? [];
+  }
+
+  /**
+   * Kill the pooled shells no session claimed
+   */
+  stopPtyPool(): void {
+    this.ptyPool?.stop();
+    this.ptyPool = null;
   }
 
   /**
@@ -157,6 +315,46 @@ export class PtyManager extends EventEmitter {
     }
   }
 
+  /**
+   * Change the session limits; running sessions above a lowered limit are kept
+   */
+  setSessionLimits(limits: SessionLimits): void {
+    this.sessionLimits = { ...limits };
+    logger.log(
+      `Session limits: ${limits.maxSessions || 'unlimited'} total, ${limits.maxSessionsPerUser || 'unlimited'} per user`
+    );
+  }
+
+  /**
+   * Running sessions counted against the limits, in total and for one user
+   */
+  getSessionUsage(user?: string): SessionLimits & { running: number; runningForUser: number } {
+    const active = this.sessionManager
+      .listSessions()
+      .filter((session) => session.status !== 'exited');
+    return {
+      ...this.sessionLimits,
+      running: active.length,
+      runningForUser: user ? active.filter((session) => session.createdBy === user).length : 0,
+    };
+  }
+
+  /**
+   * Throw a SessionLimitError if `count` more sessions for `user` would exceed a limit
+   */
+  checkSessionLimits(user?: string, count = 1): void {
+    const { maxSessions, maxSessionsPerUser } = this.sessionLimits;
+    if (!maxSessions && !maxSessionsPerUser) return;
+
+    const usage = this.getSessionUsage(user);
+    if (maxSessions && usage.running + count > maxSessions) {
+      throw new SessionLimitError('server', maxSessions, usage.running);
+    }
+    if (user && maxSessionsPerUser && usage.runningForUser + count > maxSessionsPerUser) {
+      throw new SessionLimitError('user', maxSessionsPerUser, usage.runningForUser);
+    }
+  }
+
   /**
    * Create a new PTY session
    */
@@ -165,11 +363,35 @@ export class PtyManager extends EventEmitter {
     options: SessionCreateOptions & {
       forwardToStdout?: boolean;
       onExit?: (exitCode: number, signal?: number) => void;
+      // User creating the session, for per-user session limits
+      createdBy?: string;
+      // Script run right after the session starts
+      init?: SessionInitOptions;
+      // Labels choosing the control root the session is created in
+      tags?: string[];
+      // Local account the session runs as (local user mode); the server's own by default
+      runAs?: LocalAccount;
+      // CPU and I/O priority of the session's processes
+      priority?: SessionPriority;
+      // Variables added to the session's environment
+      env?: Record<string, string>;
+      // Template the session was launched from, and the ports it declares
+      template?: string;
+      ports?: number[];
+      // Workspace the session belongs to
+      workspace?: string;
     }
   ): Promise<SessionCreationResult> {
-    const sessionId = options.sessionId || uuidv4();
+    if (options.sessionId !== undefined) {
+      assertValidSessionId(options.sessionId);
+    }
+    // Checked before anything is awaited, so concurrent requests cannot both pass
+    this.checkSessionLimits(options.createdBy);
+
+    let sessionId = options.sessionId || uuidv4();
     const sessionName = options.name || path.basename(command[0]);
-    const workingDir = options.workingDir || process.cwd();
+    const runAs = options.runAs;
+    const workingDir = options.workingDir || runAs?.home || process.cwd();
     const term = this.defaultTerm;
     const cols = options.cols || 80;
     const rows = options.rows || 24;
@@ -184,15 +406,62 @@ export class PtyManager extends EventEmitter {
       rows,
     });
 
+    let pooled: PooledPty | null = null;
     try {
-      // Create session directory structure
-      const paths = this.sessionManager.createSessionDirectory(sessionId);
+      const roots = this.sessionManager.getControlRoots();
+      const root = roots.select({ tags: options.tags, user: options.createdBy });
 
       // Resolve the command using unified resolution logic
       const resolved = ProcessUtils.resolveCommand(command);
-      const { command: finalCommand, args: finalArgs } = resolved;
+      const { command: finalCommand } = resolved;
+      let finalArgs = resolved.args;
       const resolvedCommand = [finalCommand, ...finalArgs];
 
+      // A pooled shell has its session ID and environment already; not for sessions that
+      // need their own ID, account, environment, startup files or scratch directory
+      let initMode = options.init?.mode ?? 'stdin';
+      const poolable =
+        !options.sessionId &&
+        !runAs &&
+        !options.scratch &&
+        !(options.env && Object.keys(options.env).length) &&
+        !(options.init && initMode === 'rc');
+      if (this.ptyPool && poolable) {
+        pooled = this.ptyPool.claim(resolvedCommand, workingDir, root.name);
+        if (pooled) {
+          sessionId = pooled.sessionId;
+        }
+      }
+
+      // Create session directory structure, in the root its tags or creator are routed to
+      const paths = this.sessionManager.createSessionDirectory(sessionId, root);
+      const scratchDir = options.scratch
+        ? this.sessionManager.createScratchDir(sessionId, runAs)
+        : undefined;
+
+      // Interactive bash and zsh start with our startup files, which install the env hook
+      // and run an rc init; anything else gets the init typed in. Pooled shells are
+      // already running, and shells of other accounts could not remove the env file.
+      let initEnv: Record<string, string> = {};
+      const rcScripts = runAs ? [] : [ENV_HOOK_SCRIPT];
+      if (options.init && initMode === 'rc') {
+        rcScripts.push(options.init.script);
+      }
+      const rcInit =
+        !pooled && rcScripts.length
+          ? prepareRcInit(resolvedCommand, rcScripts.join('\n'), paths.controlDir)
+          : null;
+      if (rcInit) {
+        finalArgs = rcInit.args;
+        initEnv = rcInit.env;
+        if (!runAs) {
+          initEnv.VIBETUNNEL_ENV_FILE = path.join(paths.controlDir, ENV_FILE);
+        }
+      } else if (options.init && initMode === 'rc') {
+        logger.warn('rc init needs an interactive bash or zsh, typing the script instead');
+        initMode = 'stdin';
+      }
+
       // Log resolution details
       if (resolved.resolvedFrom === 'alias') {
         logger.log(
@@ -216,6 +485,16 @@ export class PtyManager extends EventEmitter {
         workingDir: workingDir,
         status: 'starting',
         startedAt: new Date().toISOString(),
+        createdBy: options.createdBy,
+        ...(runAs ? { runAs: runAs.username } : {}),
+        ...(options.tags?.length ? { tags: options.tags } : {}),
+        ...(options.priority ? { priority: options.priority } : {}),
+        ...(roots.list().length > 1 ? { controlRoot: root.name } : {}),
+        ...(scratchDir ? { scratchDir } : {}),
+        ...(initEnv.VIBETUNNEL_ENV_FILE ? { envHook: true } : {}),
+        ...(options.template ? { template: options.template } : {}),
+        ...(options.ports?.length ? { ports: options.ports } : {}),
+        ...(options.workspace ? { workspace: options.workspace } : {}),
       };
 
       // Save initial session info
@@ -231,76 +510,97 @@ export class PtyManager extends EventEmitter {
         this.createEnvVars(term)
       );
 
+      // Fan out every line written to the stream file to in-process live viewers
+      const outputBroadcaster = new OutputBroadcaster();
+      asciinemaWriter.setLineListener((line, storedLine) =>
+        outputBroadcaster.publish(line, storedLine)
+      );
+
       // Create PTY process
       let ptyProcess: IPty;
-      try {
-        // Set up environment like Linux implementation
-        const ptyEnv = {
-          ...process.env,
-          TERM: term,
-          // Set session ID to prevent recursive vt calls and for debugging
-          VIBETUNNEL_SESSION_ID: sessionId,
-        };
-
-        // Debug log the spawn parameters
-        logger.debug('PTY spawn parameters:', {
-          command: finalCommand,
-          args: finalArgs,
-          options: {
+      if (pooled) {
+        ptyProcess = pooled.ptyProcess;
+        if (cols !== 80 || rows !== 24) {
+          ptyProcess.resize(cols, rows);
+        }
+        logger.debug(`Using pooled shell ${ptyProcess.pid} for session ${sessionId}`);
+      } else {
+        try {
+          // Set up environment like Linux implementation
+          const ptyEnv = {
+            ...(runAs ? accountEnvironment(runAs) : process.env),
+            ...options.env,
+            TERM: term,
+            // Set session ID to prevent recursive vt calls and for debugging
+            VIBETUNNEL_SESSION_ID: sessionId,
+            // Where agent wrappers report agent metadata (see session-agent.ts)
+            VIBETUNNEL_CONTROL_PATH: paths.controlPipePath,
+            ...(scratchDir ? { VIBETUNNEL_SCRATCH_DIR: scratchDir } : {}),
+            ...initEnv,
+          };
+
+          // Debug log the spawn parameters
+          logger.debug('PTY spawn parameters:', {
+            command: finalCommand,
+            args: finalArgs,
+            options: {
+              name: term,
+              cols,
+              rows,
+              cwd: workingDir,
+              runAs: runAs?.username,
+              hasEnv: !!ptyEnv,
+              envKeys: Object.keys(ptyEnv).length,
+            },
+          });
+
+          ptyProcess = pty.spawn(finalCommand, finalArgs, {
             name: term,
             cols,
             rows,
             cwd: workingDir,
-            hasEnv: !!ptyEnv,
-            envKeys: Object.keys(ptyEnv).length,
-          },
-        });
-
-        ptyProcess = pty.spawn(finalCommand, finalArgs, {
-          name: term,
-          cols,
-          rows,
-          cwd: workingDir,
-          env: ptyEnv,
-        });
-      } catch (spawnError) {
-        // Debug log the raw error first
-        logger.debug('Raw spawn error:', {
-          type: typeof spawnError,
-          isError: spawnError instanceof Error,
-          errorString: String(spawnError),
-          errorKeys: spawnError && typeof spawnError === 'object' ? Object.keys(spawnError) : [],
-        });
+            env: ptyEnv,
+            ...(runAs ? { uid: runAs.uid, gid: runAs.gid } : {}),
+          });
+        } catch (spawnError) {
+          // Debug log the raw error first
+          logger.debug('Raw spawn error:', {
+            type: typeof spawnError,
+            isError: spawnError instanceof Error,
+            errorString: String(spawnError),
+            errorKeys: spawnError && typeof spawnError === 'object' ? Object.keys(spawnError) : [],
+          });
+
+          // Provide better error messages for common issues
+          let errorMessage = spawnError instanceof Error ? spawnError.message : String(spawnError);
+
+          const errorCode =
+            spawnError instanceof Error && 'code' in spawnError
+              ? (spawnError as NodeJS.ErrnoException).code
+              : undefined;
+          if (errorCode === 'ENOENT' || errorMessage.includes('ENOENT')) {
+            errorMessage = `Command not found: '${command[0]}'. Please ensure the command exists and is in your PATH.`;
+          } else if (errorCode === 'EACCES' || errorMessage.includes('EACCES')) {
+            errorMessage = `Permission denied: '${command[0]}'. The command exists but is not executable.`;
+          } else if (errorCode === 'ENXIO' || errorMessage.includes('ENXIO')) {
+            errorMessage = `Failed to allocate terminal for '${command[0]}'. This may occur if the command doesn't exist or the system cannot create a pseudo-terminal.`;
+          } else if (errorMessage.includes('cwd') || errorMessage.includes('working directory')) {
+            errorMessage = `Working directory does not exist: '${workingDir}'`;
+          }
 
-        // Provide better error messages for common issues
-        let errorMessage = spawnError instanceof Error ? spawnError.message : String(spawnError);
-
-        const errorCode =
-          spawnError instanceof Error && 'code' in spawnError
-            ? (spawnError as NodeJS.ErrnoException).code
-            : undefined;
-        if (errorCode === 'ENOENT' || errorMessage.includes('ENOENT')) {
-          errorMessage = `Command not found: '${command[0]}'. Please ensure the command exists and is in your PATH.`;
-        } else if (errorCode === 'EACCES' || errorMessage.includes('EACCES')) {
-          errorMessage = `Permission denied: '${command[0]}'. The command exists but is not executable.`;
-        } else if (errorCode === 'ENXIO' || errorMessage.includes('ENXIO')) {
-          errorMessage = `Failed to allocate terminal for '${command[0]}'. This may occur if the command doesn't exist or the system cannot create a pseudo-terminal.`;
-        } else if (errorMessage.includes('cwd') || errorMessage.includes('working directory')) {
-          errorMessage = `Working directory does not exist: '${workingDir}'`;
+          // Log the error with better serialization
+          const errorDetails =
+            spawnError instanceof Error
+              ? {
+                  ...spawnError,
+                  message: spawnError.message,
+                  stack: spawnError.stack,
+                  code: (spawnError as NodeJS.ErrnoException).code,
+                }
+              : spawnError;
+          logger.error(`Failed to spawn PTY for command '${command.join(' ')}':`, errorDetails);
+          throw new PtyError(errorMessage, 'SPAWN_FAILED');
         }
-
-        // Log the error with better serialization
-        const errorDetails =
-          spawnError instanceof Error
-            ? {
-                ...spawnError,
-                message: spawnError.message,
-                stack: spawnError.stack,
-                code: (spawnError as NodeJS.ErrnoException).code,
-              }
-            : spawnError;
-        logger.error(`Failed to spawn PTY for command '${command.join(' ')}':`, errorDetails);
-        throw new PtyError(errorMessage, 'SPAWN_FAILED');
       }
 
       // Create session object
@@ -309,6 +609,9 @@ export class PtyManager extends EventEmitter {
         sessionInfo,
         ptyProcess,
         asciinemaWriter,
+        outputBroadcaster,
+        modeTracker: new TerminalModeTracker(),
+        counters: new SessionCounters(),
         controlDir: paths.controlDir,
         stdoutPath: paths.stdoutPath,
         stdinPath: paths.stdinPath,
@@ -317,23 +620,58 @@ export class PtyManager extends EventEmitter {
         startTime: new Date(),
       };
 
+      const commandTracker = new CommandTracker((marker) => asciinemaWriter.writeMarker(marker));
+      session.oscParser = new OscParser(
+        [OSC_CWD, OSC_PROMPT_MARK],
+        (code, data) => {
+          if (code === OSC_CWD) {
+            this.handleCwdReport(session, data);
+          } else {
+            commandTracker.handleMark(data);
+          }
+        },
+        (text) => commandTracker.handleText(text)
+      );
+      session.imageExtractor = new InlineImageExtractor(
+        (text) => asciinemaWriter.writeOutput(Buffer.from(text, 'utf8')),
+        (image) => this.handleInlineImage(session, image)
+      );
       this.sessions.set(sessionId, session);
 
       // Update session info with PID and running status
       sessionInfo.pid = ptyProcess.pid;
+      sessionInfo.hostPid = process.pid;
       sessionInfo.status = 'running';
       this.sessionManager.saveSessionInfo(sessionId, sessionInfo);
 
+      // Right after spawning, before the session starts processes of its own
+      if ((options.priority && options.priority !== 'normal') || this.sessionCgroup) {
+        applySessionPriority(
+          [ptyProcess.pid],
+          options.priority ?? 'normal',
+          this.sessionCgroup ? sessionCgroupDir(this.sessionCgroup, sessionId) : undefined
+        ).catch((error) => logger.warn(`Failed to set priority of session ${sessionId}:`, error));
+      }
+
       logger.log(chalk.green(`Session ${sessionId} created successfully (PID: ${ptyProcess.pid})`));
       logger.log(chalk.gray(`Running: ${resolvedCommand.join(' ')} in ${workingDir}`));
 
       // Setup PTY event handlers
-      this.setupPtyHandlers(session, options.forwardToStdout || false, options.onExit);
+      this.setupPtyHandlers(
+        session,
+        options.forwardToStdout || false,
+        options.onExit,
+        pooled?.output
+      );
 
-      // Setup control pipe if forwarding to stdout
-      if (options.forwardToStdout) {
-        this.setupControlPipe(session);
+      if (options.init) {
+        this.startInit(session, options.init.script, initMode);
+      }
 
+      // Control pipe: resize and kill in fwd mode, the other commands from every session
+      this.setupControlPipe(session, options.forwardToStdout || false);
+
+      if (options.forwardToStdout) {
         // Setup stdin forwarding for fwd mode
         this.setupStdinForwarding(session);
         logger.log(chalk.gray('Stdin forwarding enabled'));
@@ -345,6 +683,9 @@ export class PtyManager extends EventEmitter {
       };
     } catch (error) {
       // Cleanup on failure
+      if (pooled) {
+        pooled.ptyProcess.kill();
+      }
       try {
         this.sessionManager.cleanupSession(sessionId);
       } catch (cleanupError) {
@@ -367,13 +708,47 @@ export class PtyManager extends EventEmitter {
     return this.sessions.get(sessionId);
   }
 
+  /**
+   * Subscribe to the live cast lines of a session running in this process.
+   * Returns null for sessions owned by another process (e.g. fwd), whose
+   * output is only available through the stream file.
+   */
+  subscribeToOutput(sessionId: string, listener: OutputListener): OutputSubscription | null {
+    const broadcaster = this.sessions.get(sessionId)?.outputBroadcaster;
+    if (!broadcaster || broadcaster.isClosed()) {
+      return null;
+    }
+    return broadcaster.subscribe(listener);
+  }
+
+  /**
+   * Provide keyboard modes for sessions owned by other processes (e.g. from
+   * the terminal emulator that renders their stream file)
+   */
+  setKeyModeResolver(resolver: (sessionId: string) => KeyEncodingModes | undefined): void {
+    this.keyModeResolver = resolver;
+  }
+
+  /**
+   * Current keyboard modes of a session, used to encode special keys
+   */
+  private getKeyModes(sessionId: string): KeyEncodingModes {
+    const tracker = this.sessions.get(sessionId)?.modeTracker;
+    if (tracker) {
+      return tracker.getModes();
+    }
+    return this.keyModeResolver?.(sessionId) ?? {};
+  }
+
   /**
    * Setup event handlers for a PTY process
    */
   private setupPtyHandlers(
     session: PtySession,
     forwardToStdout: boolean,
-    onExit?: (exitCode: number, signal?: number) => void
+    onExit?: (exitCode: number, signal?: number) => void,
+    // Output of a pooled shell from before the session claimed it
+    earlierOutput?: string
   ): void {
     const { ptyProcess, asciinemaWriter } = session;
 
@@ -389,9 +764,24 @@ export class PtyManager extends EventEmitter {
     }
 
     // Handle PTY data output
-    ptyProcess.onData((data: string) => {
-      // Write to asciinema file (it has its own internal queue)
-      asciinemaWriter?.writeOutput(Buffer.from(data, 'utf8'));
+    const handleData = (data: string) => {
+      // Track cursor/keypad modes so special keys are encoded the way the program expects
+      session.modeTracker?.feed(data);
+      session.counters?.recordOutput(data);
+      session.oscParser?.feed(data);
+      if (session.pendingInit) {
+        this.settleInit(session);
+      }
+      // Output watchers (e.g. triggers)
+      this.emit('output', session.id, data);
+
+      // Write to asciinema file (it has its own internal queue), with inline images
+      // stored separately
+      if (session.imageExtractor) {
+        session.imageExtractor.feed(data);
+      } else {
+        asciinemaWriter?.writeOutput(Buffer.from(data, 'utf8'));
+      }
 
       // Forward to stdout if requested (using queue for ordering)
       if (forwardToStdout && stdoutQueue) {
@@ -402,7 +792,13 @@ export class PtyManager extends EventEmitter {
           }
         });
       }
-    });
+    };
+    // An output watcher that throws must not take the server down
+    const guardedHandleData = guardSessionTask(session.id, 'pty-output', handleData);
+    if (earlierOutput) {
+      guardedHandleData(earlierOutput);
+    }
+    ptyProcess.onData(guardedHandleData);
 
     // Handle PTY exit
     ptyProcess.onExit(async ({ exitCode, signal }: { exitCode: number; signal?: number }) => {
@@ -416,7 +812,14 @@ export class PtyManager extends EventEmitter {
             .close()
             .catch((error) =>
               logger.error(`Failed to close asciinema writer for session ${session.id}:`, error)
-            );
+            )
+            .finally(() => {
+              // The file now holds everything; late viewers read it directly
+              session.outputBroadcaster?.close();
+              this.emit('recordingClosed', session.id);
+            });
+        } else {
+          session.outputBroadcaster?.close();
         }
 
         // Update session status
@@ -426,6 +829,7 @@ export class PtyManager extends EventEmitter {
           undefined,
           exitCode || (signal ? 128 + (typeof signal === 'number' ? signal : 1) : 1)
         );
+        this.emit('sessionExited', session.id, exitCode || 0);
 
         // Wait for stdout queue to drain if it exists
         if (session.stdoutQueue) {
@@ -459,6 +863,94 @@ export class PtyManager extends EventEmitter {
     this.monitorStdinFile(session);
   }
 
+  /**
+   * Keep the directory the shell reported with OSC 7 in the session info
+   */
+  private handleCwdReport(session: PtySession, data: string): void {
+    const cwd = parseCwdReport(data);
+    if (!cwd || cwd === session.sessionInfo.currentWorkingDir) return;
+
+    session.sessionInfo.currentWorkingDir = cwd;
+    try {
+      this.sessionManager.saveSessionInfo(session.id, session.sessionInfo);
+      logger.debug(`Session ${session.id} working directory is now ${cwd}`);
+    } catch (error) {
+      logger.warn(`Failed to save working directory of session ${session.id}:`, error);
+    }
+  }
+
+  /**
+   * Store an image printed by the session and mark where it was printed
+   */
+  private handleInlineImage(session: PtySession, image: ExtractedImage): void {
+    const stored = session.inlineImages ?? { count: 0, bytes: 0 };
+    session.inlineImages = stored;
+    if (stored.bytes + image.data.length > MAX_SESSION_IMAGE_BYTES) {
+      logger.warn(`Session ${session.id} exceeded its image storage, dropping image`);
+      return;
+    }
+
+    const { data, ...details } = image;
+    const metadata: InlineImage = { id: String(stored.count + 1), size: data.length, ...details };
+    try {
+      const imagesDir = path.join(session.controlDir, IMAGES_DIR);
+      fs.mkdirSync(imagesDir, { recursive: true });
+      // Written synchronously so the image exists before its marker is seen
+      fs.writeFileSync(path.join(imagesDir, imageFileName(metadata)), data);
+    } catch (error) {
+      logger.warn(`Failed to store image of session ${session.id}:`, error);
+      return;
+    }
+    stored.count++;
+    stored.bytes += data.length;
+    session.asciinemaWriter?.writeMarker(`${IMAGE_MARKER} ${JSON.stringify(metadata)}`);
+    logger.debug(`Session ${session.id} printed ${metadata.format} image ${metadata.id}`);
+  }
+
+  /**
+   * Mark the start of a session's init script in the cast and, in stdin mode,
+   * type it into the session
+   */
+  private startInit(session: PtySession, script: string, mode: InitMode): void {
+    session.asciinemaWriter?.writeMarker(INIT_MARKER);
+    if (mode === 'stdin' && session.ptyProcess) {
+      const input = initScriptInput(script);
+      session.ptyProcess.write(input);
+      session.asciinemaWriter?.writeInput(input);
+      session.counters?.recordInput(input);
+    }
+
+    session.pendingInit = {
+      deadline: setTimeout(() => this.finishInit(session), INIT_MAX_MS),
+    };
+    this.settleInit(session);
+    logger.debug(`Running ${mode} init script for session ${session.id}`);
+  }
+
+  /**
+   * Restart the quiet period after which the init script counts as done
+   */
+  private settleInit(session: PtySession): void {
+    const pending = session.pendingInit;
+    if (!pending) return;
+    clearTimeout(pending.settleTimer);
+    pending.settleTimer = setTimeout(() => this.finishInit(session), INIT_SETTLE_MS);
+  }
+
+  /**
+   * Mark the end of the init script; output after it belongs to the session
+   */
+  private finishInit(session: PtySession): void {
+    const pending = session.pendingInit;
+    if (!pending) return;
+    clearTimeout(pending.settleTimer);
+    clearTimeout(pending.deadline);
+    session.pendingInit = undefined;
+    if (session.asciinemaWriter?.isOpen()) {
+      session.asciinemaWriter.writeMarker(INIT_DONE_MARKER);
+    }
+  }
+
   /**
    * Monitor stdin file for input data using Unix socket for lowest latency
    */
@@ -484,15 +976,14 @@ export class PtyManager extends EventEmitter {
       // Create Unix domain socket server
       const inputServer = net.createServer((client) => {
         client.setNoDelay(true);
-        client.on('data', (data) => {
-          const text = data.toString('utf8');
-          if (ptyProcess) {
-            // Write input first for fastest response
-            ptyProcess.write(text);
-            // Then record it (non-blocking)
-            session.asciinemaWriter?.writeInput(text);
-          }
-        });
+        // Characters may be split across chunks
+        const decoder = createUtf8ChunkDecoder();
+        client.on(
+          'data',
+          guardSessionTask(session.id, 'input-socket', (data: Buffer) =>
+            this.writeRawInput(session, decoder.write(data))
+          )
+        );
       });
 
       inputServer.listen(socketPath, () => {
@@ -511,13 +1002,33 @@ export class PtyManager extends EventEmitter {
       logger.error(`Failed to create input socket for session ${session.id}:`, error);
     }
 
-    // Socket-only approach - no FIFO monitoring
+    // The stdin FIFO, for tools writing to it directly
+    const decoder = createUtf8ChunkDecoder();
+    const stdinReader = openStdinFifo(session.stdinPath, (data) =>
+      this.writeRawInput(session, decoder.write(data))
+    );
+    if (stdinReader) {
+      session.stdinReader = stdinReader;
+    }
+  }
+
+  /**
+   * Write input from the input socket or stdin FIFO to the PTY and record it
+   */
+  private writeRawInput(session: PtySession, text: string): void {
+    if (!text || !session.ptyProcess) return;
+    this.finishInit(session);
+    // Write input first for fastest response
+    session.ptyProcess.write(text);
+    // Then record it (non-blocking)
+    session.asciinemaWriter?.writeInput(text);
+    session.counters?.recordInput(text);
   }
 
   /**
-   * Setup control pipe for fwd mode to handle resize and kill commands
+   * Setup control pipe to handle resize and kill commands (fwd mode) and the others
    */
-  private setupControlPipe(session: PtySession): void {
+  private setupControlPipe(session: PtySession, forwardToStdout: boolean): void {
     const controlPipePath = session.controlPipePath;
 
     try {
@@ -527,33 +1038,11 @@ export class PtyManager extends EventEmitter {
       }
 
       // Use file watching approach for all platforms
-      let lastControlPosition = 0;
-
+      const reader = new AppendedLineReader(controlPipePath);
       const readNewControlData = () => {
         try {
-          if (!fs.existsSync(controlPipePath)) return;
-
-          const stats = fs.statSync(controlPipePath);
-          if (stats.size > lastControlPosition) {
-            const fd = fs.openSync(controlPipePath, 'r');
-            const buffer = Buffer.allocUnsafe(stats.size - lastControlPosition);
-            fs.readSync(fd, buffer, 0, buffer.length, lastControlPosition);
-            fs.closeSync(fd);
-            const data = buffer.toString('utf8');
-
-            const lines = data.split('\n');
-            for (const line of lines) {
-              if (line.trim()) {
-                try {
-                  const message = JSON.parse(line);
-                  this.handleControlMessage(session, message);
-                } catch (_e) {
-                  logger.warn(`Invalid control message in session ${session.id}: ${line}`);
-                }
-              }
-            }
-
-            lastControlPosition = stats.size;
+          for (const line of reader.read()) {
+            this.handleControlLine(session, line, forwardToStdout);
           }
         } catch (error) {
           logger.debug(`Failed to read control data for session ${session.id}:`, error);
@@ -581,72 +1070,182 @@ export class PtyManager extends EventEmitter {
   }
 
   /**
-   * Handle control messages from control pipe
+   * Run a command from the control pipe and acknowledge it if it has an ID
    */
-  private handleControlMessage(session: PtySession, message: Record<string, unknown>): void {
-    if (
-      message.cmd === 'resize' &&
-      typeof message.cols === 'number' &&
-      typeof message.rows === 'number'
-    ) {
+  private handleControlLine(session: PtySession, line: string, forwardToStdout: boolean): void {
+    let message: ControlMessage;
+    try {
+      message = JSON.parse(line);
+    } catch {
+      logger.warn(`Invalid control message in session ${session.id}: ${line}`);
+      return;
+    }
+    if (!message || typeof message !== 'object' || typeof message.cmd !== 'string') {
+      logger.warn(`Invalid control message in session ${session.id}: ${line}`);
+      return;
+    }
+
+    const id = typeof message.id === 'string' ? message.id : undefined;
+    const acknowledge = (result: Omit<ControlAck, 'id'>) => {
+      if (!id) return;
       try {
-        if (session.ptyProcess) {
-          session.ptyProcess.resize(message.cols, message.rows);
-          session.asciinemaWriter?.writeResize(message.cols, message.rows);
-        }
+        appendControlAck(session.controlPipePath, { id, ...result });
       } catch (error) {
-        logger.warn(
-          `Failed to resize session ${session.id} to ${message.cols}x${message.rows}:`,
-          error
-        );
+        logger.debug(`Failed to acknowledge control message of session ${session.id}:`, error);
       }
-    } else if (message.cmd === 'kill') {
-      const signal =
-        typeof message.signal === 'string' || typeof message.signal === 'number'
-          ? message.signal
-          : 'SIGTERM';
-      try {
-        if (session.ptyProcess) {
-          session.ptyProcess.kill(signal as string);
+    };
+    // These act on the terminal fwd runs in; sessions of the server have none
+    if (!forwardToStdout && FWD_ONLY_COMMANDS.includes(message.cmd)) {
+      logger.debug(`Ignoring control command ${message.cmd} of session ${session.id}`);
+      acknowledge({ ok: false, error: `${message.cmd} is only supported in fwd sessions` });
+      return;
+    }
+    this.runControlCommand(session, message).then(
+      (result) => acknowledge({ ok: true, ...result }),
+      (error) => {
+        logger.warn(`Control command ${message.cmd} of session ${session.id} failed:`, error);
+        acknowledge({ ok: false, error: error instanceof Error ? error.message : String(error) });
+      }
+    );
+  }
+
+  /**
+   * Run a control command on a session of this process. Returns what the
+   * acknowledgement reports besides success; throws if the command failed.
+   */
+  private async runControlCommand(
+    session: PtySession,
+    message: ControlMessage
+  ): Promise<Record<string, unknown>> {
+    const invalid = validateControlCommand(message);
+    if (invalid) {
+      throw new ControlCommandError(invalid);
+    }
+    const { ptyProcess } = session;
+
+    switch (message.cmd) {
+      case 'resize': {
+        const { cols, rows } = message as ResizeControlMessage;
+        ptyProcess?.resize(cols, rows);
+        session.asciinemaWriter?.writeResize(cols, rows);
+        return {};
+      }
+      case 'reset-size': {
+        // Current size of the terminal running fwd
+        const cols = process.stdout.columns || 80;
+        const rows = process.stdout.rows || 24;
+        ptyProcess?.resize(cols, rows);
+        session.asciinemaWriter?.writeResize(cols, rows);
+        logger.debug(`Reset session ${session.id} size to terminal size: ${cols}x${rows}`);
+        return { cols, rows };
+      }
+      case 'kill': {
+        const signal = message.signal === undefined ? 'SIGTERM' : parseSignal(message.signal);
+        ptyProcess?.kill(signal);
+        return {};
+      }
+      case 'signal': {
+        const signal = parseSignal(message.signal);
+        if (!ptyProcess) throw new ControlCommandError('session is not running');
+        if (process.platform === 'win32') {
+          ptyProcess.kill(signal);
+        } else {
+          process.kill(-ptyProcess.pid, signal);
         }
-      } catch (error) {
-        logger.warn(`Failed to kill session ${session.id} with signal ${signal}:`, error);
+        logger.debug(`Sent ${signal} to process group of session ${session.id}`);
+        return {};
       }
-    } else if (message.cmd === 'reset-size') {
-      try {
-        if (session.ptyProcess) {
-          // Get current terminal size from process.stdout
-          const cols = process.stdout.columns || 80;
-          const rows = process.stdout.rows || 24;
-          session.ptyProcess.resize(cols, rows);
-          session.asciinemaWriter?.writeResize(cols, rows);
-          logger.debug(`Reset session ${session.id} size to terminal size: ${cols}x${rows}`);
+      case 'env': {
+        session.sessionInfo.pendingEnv = applyEnvCommand(session.sessionInfo.pendingEnv, message);
+        this.sessionManager.saveSessionInfo(session.id, session.sessionInfo);
+        // The shell's env hook sources the file at its next prompt
+        const injected = !!session.sessionInfo.envHook;
+        if (injected) {
+          fs.appendFileSync(
+            path.join(session.controlDir, ENV_FILE),
+            envFileLines(applyEnvCommand({}, message))
+          );
         }
-      } catch (error) {
-        logger.warn(`Failed to reset session ${session.id} size to terminal size:`, error);
+        return { env: session.sessionInfo.pendingEnv, injected };
+      }
+      case 'metadata': {
+        applyMetadataCommand(session.sessionInfo, message);
+        this.sessionManager.saveSessionInfo(session.id, session.sessionInfo);
+        return { metadata: sessionMetadata(session.sessionInfo) };
       }
+      case 'title': {
+        session.sessionInfo.name = (message as TitleControlMessage).title.trim();
+        this.sessionManager.saveSessionInfo(session.id, session.sessionInfo);
+        return { name: session.sessionInfo.name };
+      }
+      case 'flush': {
+        const size = (await session.asciinemaWriter?.flush()) ?? 0;
+        return { size };
+      }
+      case 'agent':
+        this.handleAgentReport(session, message);
+        return {};
+      default:
+        throw new ControlCommandError(`unknown command: ${message.cmd}`);
+    }
+  }
+
+  /**
+   * Keep the agent metadata an agent wrapper reported in the session info
+   */
+  private handleAgentReport(session: PtySession, message: Record<string, unknown>): void {
+    let fields: ReturnType<typeof parseAgentFields>;
+    try {
+      fields = parseAgentFields(message);
+    } catch (error) {
+      logger.warn(`Ignoring agent report of session ${session.id}: ${(error as Error).message}`);
+      return;
+    }
+    if (!applyAgentFields(session.sessionInfo, fields)) return;
+
+    try {
+      this.sessionManager.saveSessionInfo(session.id, session.sessionInfo);
+      logger.debug(`Session ${session.id} agent is now ${session.sessionInfo.agentType ?? 'none'}`);
+    } catch (error) {
+      logger.warn(`Failed to save agent metadata of session ${session.id}:`, error);
     }
   }
 
   /**
-   * Send text input to a session
+   * Send text input to a session. With a source, the input is attributed to it in
+   * the recording and the session's input audit log.
    */
-  sendInput(sessionId: string, input: SessionInput): void {
+  sendInput(sessionId: string, input: SessionInput, source?: InputSource): void {
     try {
       let dataToSend = '';
-      if (input.text !== undefined) {
+      if (input.composition !== undefined) {
+        // IME composition: only the committed text reaches the terminal
+        const committed = compositionInputData(input);
+        if (committed === null) {
+          logger.debug(`composition ${input.composition} for session ${sessionId}`);
+          return;
+        }
+        dataToSend = committed;
+      } else if (input.text !== undefined) {
         dataToSend = input.text;
       } else if (input.key !== undefined) {
-        dataToSend = this.convertSpecialKey(input.key);
+        dataToSend = this.convertSpecialKey(input.key, this.getKeyModes(sessionId));
       } else {
         throw new PtyError('No text or key specified in input', 'INVALID_INPUT');
       }
 
+      if (source) {
+        this.auditInput(sessionId, dataToSend, source);
+      }
+
       // If we have an in-memory session with active PTY, use it
       const memorySession = this.sessions.get(sessionId);
       if (memorySession?.ptyProcess) {
+        // Input from a user ends the init script
+        this.finishInit(memorySession);
         memorySession.ptyProcess.write(dataToSend);
-        memorySession.asciinemaWriter?.writeInput(dataToSend);
+        memorySession.asciinemaWriter?.writeInput(dataToSend, source);
+        memorySession.counters?.recordInput(dataToSend);
         return; // Important: return here to avoid socket path
       } else {
         const sessionPaths = this.sessionManager.getSessionPaths(sessionId);
@@ -711,20 +1310,36 @@ export class PtyManager extends EventEmitter {
   }
 
   /**
-   * Send a control message to an external session
+   * Append an entry for input to the session's input audit log. Sessions owned by
+   * another process record their input without a source, so this log is the only
+   * attribution for them.
    */
-  private sendControlMessage(
-    sessionId: string,
-    message: ResizeControlMessage | KillControlMessage | ResetSizeControlMessage
-  ): boolean {
+  private auditInput(sessionId: string, data: string, source: InputSource): void {
+    const sessionPaths = this.sessionManager.getSessionPaths(sessionId);
+    if (!sessionPaths) return;
+
+    const auditPath = path.join(sessionPaths.controlDir, INPUT_AUDIT_FILE);
+    const entry = JSON.stringify({ time: new Date().toISOString(), bytes: data.length, ...source });
+    this.inputAuditQueue.enqueue(async () => {
+      try {
+        await fs.promises.appendFile(auditPath, `${entry}\n`);
+      } catch (error) {
+        logger.debug(`Failed to write input audit entry for session ${sessionId}:`, error);
+      }
+    });
+  }
+
+  /**
+   * Send a control message to an external session without waiting for it
+   */
+  private sendControlMessage(sessionId: string, message: ControlMessage): boolean {
     const sessionPaths = this.sessionManager.getSessionPaths(sessionId);
     if (!sessionPaths) {
       return false;
     }
 
     try {
-      const messageStr = `${JSON.stringify(message)}\n`;
-      fs.appendFileSync(sessionPaths.controlPipePath, messageStr);
+      appendControlCommand(sessionPaths.controlPipePath, message);
       return true;
     } catch (error) {
       logger.error(`Failed to send control message to session ${sessionId}:`, error);
@@ -733,42 +1348,70 @@ export class PtyManager extends EventEmitter {
   }
 
   /**
-   * Convert special key names to escape sequences
+   * Run a control command on a session, in this process or through the
+   * control pipe of the process owning it, and return its acknowledgement
    */
-  private convertSpecialKey(key: SpecialKey): string {
-    const keyMap: Record<SpecialKey, string> = {
-      arrow_up: '\x1b[A',
-      arrow_down: '\x1b[B',
-      arrow_right: '\x1b[C',
-      arrow_left: '\x1b[D',
-      escape: '\x1b',
-      enter: '\r',
-      ctrl_enter: '\n',
-      shift_enter: '\r\n',
-      backspace: '\x7f',
-      tab: '\t',
-      shift_tab: '\x1b[Z',
-      page_up: '\x1b[5~',
-      page_down: '\x1b[6~',
-      home: '\x1b[H',
-      end: '\x1b[F',
-      delete: '\x1b[3~',
-      f1: '\x1bOP',
-      f2: '\x1bOQ',
-      f3: '\x1bOR',
-      f4: '\x1bOS',
-      f5: '\x1b[15~',
-      f6: '\x1b[17~',
-      f7: '\x1b[18~',
-      f8: '\x1b[19~',
-      f9: '\x1b[20~',
-      f10: '\x1b[21~',
-      f11: '\x1b[23~',
-      f12: '\x1b[24~',
-    };
+  async sendControlCommand(sessionId: string, message: ControlMessage): Promise<ControlAck> {
+    const memorySession = this.sessions.get(sessionId);
+    if (memorySession) {
+      const id = `${process.pid}-local`;
+      try {
+        return { id, ok: true, ...(await this.runControlCommand(memorySession, message)) };
+      } catch (error) {
+        return { id, ok: false, error: error instanceof Error ? error.message : String(error) };
+      }
+    }
 
-    const sequence = keyMap[key];
-    if (!sequence) {
+    const sessionInfo = this.sessionManager.loadSessionInfo(sessionId);
+    if (!sessionInfo) {
+      throw new PtyError(`Session ${sessionId} not found`, 'SESSION_NOT_FOUND', sessionId);
+    }
+    if (sessionInfo.status !== 'running') {
+      throw new PtyError(`Session ${sessionId} is not running`, 'SESSION_NOT_RUNNING', sessionId);
+    }
+    const ack = await this.sessionManager.sendControlCommand(sessionId, message);
+    if (!ack) {
+      throw new PtyError(
+        `Session ${sessionId} did not acknowledge ${message.cmd}`,
+        'CONTROL_MESSAGE_FAILED',
+        sessionId
+      );
+    }
+    return ack;
+  }
+
+  /**
+   * Update the recorded metadata of a session. Running sessions are updated by
+   * the process owning them, so its next save keeps the change; exited ones
+   * have no owner and are changed here.
+   */
+  async updateSessionMetadata(
+    sessionId: string,
+    message: MetadataControlMessage
+  ): Promise<ControlAck> {
+    const sessionInfo = this.sessions.has(sessionId)
+      ? null
+      : this.sessionManager.loadSessionInfo(sessionId);
+    if (!sessionInfo || sessionInfo.status === 'running') {
+      return this.sendControlCommand(sessionId, message);
+    }
+
+    const id = `${process.pid}-local`;
+    try {
+      applyMetadataCommand(sessionInfo, message);
+    } catch (error) {
+      return { id, ok: false, error: error instanceof Error ? error.message : String(error) };
+    }
+    this.sessionManager.saveSessionInfo(sessionId, sessionInfo);
+    return { id, ok: true, metadata: sessionMetadata(sessionInfo) };
+  }
+
+  /**
+   * Convert special key names to escape sequences
+   */
+  private convertSpecialKey(key: string, modes: KeyEncodingModes = {}): string {
+    const sequence = encodeKey(key, modes);
+    if (sequence === null) {
       throw new PtyError(`Unknown special key: ${key}`, 'UNKNOWN_KEY');
     }
 
@@ -776,15 +1419,38 @@ export class PtyManager extends EventEmitter {
   }
 
   /**
-   * Resize a session terminal
+   * Resize a session terminal. Sizes that are already current are skipped and
+   * bursts of resizes are coalesced into one resize with the latest size.
    */
   resizeSession(sessionId: string, cols: number, rows: number): void {
+    if (this.doNotAllowColumnSet) {
+      throw new PtyError(
+        'Terminal resizing is disabled by the server',
+        'RESIZE_DISABLED',
+        sessionId
+      );
+    }
+    this.resizeThrottle.request(sessionId, cols, rows, (cols, rows) =>
+      this.applyResize(sessionId, cols, rows)
+    );
+  }
+
+  /**
+   * Resize the PTY (or ask the owning process to). Returns false if the size
+   * is already current.
+   */
+  private applyResize(sessionId: string, cols: number, rows: number): boolean {
     const memorySession = this.sessions.get(sessionId);
     const currentTime = Date.now();
 
     try {
       // If we have an in-memory session with active PTY, resize it
       if (memorySession?.ptyProcess) {
+        if (memorySession.ptyProcess.cols === cols && memorySession.ptyProcess.rows === rows) {
+          logger.debug(`Skipping resize of session ${sessionId}, already ${cols}x${rows}`);
+          return false;
+        }
+
         memorySession.ptyProcess.resize(cols, rows);
         memorySession.asciinemaWriter?.writeResize(cols, rows);
 
@@ -814,6 +1480,7 @@ export class PtyManager extends EventEmitter {
           timestamp: currentTime,
         });
       }
+      return true;
     } catch (error) {
       throw new PtyError(
         `Failed to resize session ${sessionId}: ${error instanceof Error ? error.message : String(error)}`,
@@ -823,6 +1490,50 @@ export class PtyManager extends EventEmitter {
     }
   }
 
+  /**
+   * Whether clients are prevented from resizing sessions
+   */
+  getDoNotAllowColumnSet(): boolean {
+    return this.doNotAllowColumnSet;
+  }
+
+  /**
+   * Allow or prevent client resizes. Sessions keep their current size.
+   */
+  setDoNotAllowColumnSet(value: boolean): void {
+    this.doNotAllowColumnSet = value;
+    logger.log(`Client resizing ${value ? 'disabled' : 'enabled'}`);
+  }
+
+  /**
+   * Change the priority of a running session and all its processes. Returns
+   * what could not be applied (e.g. raising it again without privileges).
+   */
+  async setSessionPriority(sessionId: string, priority: SessionPriority): Promise<string[]> {
+    const sessionInfo =
+      this.sessions.get(sessionId)?.sessionInfo ?? this.sessionManager.loadSessionInfo(sessionId);
+    if (!sessionInfo) {
+      throw new PtyError(`Session ${sessionId} not found`, 'SESSION_NOT_FOUND', sessionId);
+    }
+    if (sessionInfo.status !== 'running' || !sessionInfo.pid) {
+      throw new PtyError(`Session ${sessionId} is not running`, 'SESSION_NOT_RUNNING', sessionId);
+    }
+
+    const rootPid = sessionInfo.pid;
+    const tree = await this.processTreeAnalyzer.getProcessTree(rootPid);
+    const pids = [rootPid, ...tree.map((info) => info.pid).filter((pid) => pid !== rootPid)];
+    const errors = await applySessionPriority(
+      pids,
+      priority,
+      this.sessionCgroup ? sessionCgroupDir(this.sessionCgroup, sessionId) : undefined
+    );
+
+    sessionInfo.priority = priority;
+    this.sessionManager.saveSessionInfo(sessionId, sessionInfo);
+    logger.log(chalk.blue(`Session ${sessionId} priority set to ${priority}`));
+    return errors;
+  }
+
   /**
    * Reset session size to terminal size (for external terminals)
    */
@@ -863,6 +1574,45 @@ export class PtyManager extends EventEmitter {
     }
   }
 
+  /**
+   * Stop (SIGSTOP) or continue (SIGCONT) the process group of a session
+   */
+  signalProcessGroup(sessionId: string, signal: 'SIGSTOP' | 'SIGCONT'): void {
+    if (process.platform === 'win32') {
+      throw new PtyError('Process signals are not supported on Windows', 'UNSUPPORTED', sessionId);
+    }
+
+    const pid =
+      this.sessions.get(sessionId)?.ptyProcess?.pid ??
+      this.sessionManager.loadSessionInfo(sessionId)?.pid;
+    if (!pid || !ProcessUtils.isProcessRunning(pid)) {
+      throw new PtyError(`Session ${sessionId} is not running`, 'SESSION_NOT_RUNNING', sessionId);
+    }
+
+    try {
+      process.kill(-pid, signal);
+      logger.debug(`Sent ${signal} to process group -${pid} for session ${sessionId}`);
+      if (signal === 'SIGSTOP') {
+        this.stoppedSessions.add(sessionId);
+      } else {
+        this.stoppedSessions.delete(sessionId);
+      }
+    } catch (error) {
+      throw new PtyError(
+        `Failed to send ${signal} to session ${sessionId}: ${error instanceof Error ? error.message : String(error)}`,
+        'SIGNAL_FAILED',
+        sessionId
+      );
+    }
+  }
+
+  /**
+   * Whether the process group of a session was stopped with signalProcessGroup()
+   */
+  isProcessGroupStopped(sessionId: string): boolean {
+    return this.stoppedSessions.has(sessionId);
+  }
+
   /**
    * Kill a session with proper SIGTERM -> SIGKILL escalation
    * Returns a promise that resolves when the process is actually terminated
@@ -906,10 +1656,11 @@ export class PtyManager extends EventEmitter {
           signal,
         };
 
-        const sentControl = this.sendControlMessage(sessionId, killMessage);
-        if (sentControl) {
-          // Wait a bit for the control message to be processed
-          await new Promise((resolve) => setTimeout(resolve, 500));
+        // Wait a bit for the owning process to acknowledge it
+        try {
+          await this.sessionManager.sendControlCommand(sessionId, killMessage, 500);
+        } catch (error) {
+          logger.debug(`Failed to send kill command to session ${sessionId}:`, error);
         }
 
         // Check if process is still running, if so, use direct PID kill
@@ -1163,6 +1914,9 @@ export class PtyManager extends EventEmitter {
       socket.destroy();
       this.inputSocketClients.delete(sessionId);
     }
+
+    // Drop a queued resize for sessions owned by other processes
+    this.resizeThrottle.clear(sessionId);
   }
 
   /**
@@ -1206,6 +1960,135 @@ export class PtyManager extends EventEmitter {
     return this.sessions.has(sessionId);
   }
 
+  /**
+   * I/O statistics of a session running in this process, or null for sessions
+   * owned by another process (which are not tracked)
+   */
+  getSessionStats(sessionId: string): SessionStats | null {
+    const session = this.sessions.get(sessionId);
+    if (!session?.counters) return null;
+    return session.counters.getStats(this.getRecordingSize(sessionId));
+  }
+
+  /**
+   * Size of a session's cast file in bytes (0 if it does not exist)
+   */
+  getRecordingSize(sessionId: string): number {
+    const sessionPaths = this.sessionManager.getSessionPaths(sessionId);
+    if (!sessionPaths) return 0;
+    try {
+      return fs.statSync(sessionPaths.stdoutPath).size;
+    } catch {
+      return 0;
+    }
+  }
+
+  /**
+   * Inline images a session printed, from the markers in its cast file
+   */
+  async getInlineImages(sessionId: string): Promise<Array<InlineImage & { time: number }>> {
+    const sessionPaths = this.sessionManager.getSessionPaths(sessionId);
+    if (!sessionPaths || !fs.existsSync(sessionPaths.stdoutPath)) {
+      return [];
+    }
+    return readInlineImages(sessionPaths.stdoutPath);
+  }
+
+  /**
+   * Path of a stored inline image, or null if there is no such image
+   */
+  getInlineImagePath(sessionId: string, imageId: string): string | null {
+    const sessionPaths = this.sessionManager.getSessionPaths(sessionId);
+    if (!sessionPaths || !/^\d+$/.test(imageId)) return null;
+
+    const imagesDir = path.resolve(sessionPaths.controlDir, IMAGES_DIR);
+    try {
+      const fileName = fs.readdirSync(imagesDir).find((name) => name.startsWith(`${imageId}.`));
+      return fileName ? path.join(imagesDir, fileName) : null;
+    } catch {
+      return null;
+    }
+  }
+
+  /**
+   * Set or clear (null) the mark of a session. Returns false if there is no such session.
+   */
+  setSessionMark(sessionId: string, mark: SessionMark | null): boolean {
+    // Sessions of other processes are only known by their session.json
+    const sessionInfo =
+      this.sessions.get(sessionId)?.sessionInfo ?? this.sessionManager.loadSessionInfo(sessionId);
+    if (!sessionInfo) return false;
+
+    if (mark) {
+      sessionInfo.mark = mark;
+    } else {
+      delete sessionInfo.mark;
+    }
+    this.sessionManager.saveSessionInfo(sessionId, sessionInfo);
+    logger.debug(`Session ${sessionId} ${mark ? `marked '${mark.label}'` : 'mark cleared'}`);
+    return true;
+  }
+
+  /**
+   * Record the first crash of one of a session's tasks. Returns false if there is no such session.
+   */
+  setSessionCrash(sessionId: string, crash: SessionCrash): boolean {
+    const sessionInfo =
+      this.sessions.get(sessionId)?.sessionInfo ?? this.sessionManager.loadSessionInfo(sessionId);
+    if (!sessionInfo) return false;
+    if (!sessionInfo.crash) {
+      sessionInfo.crash = crash;
+      this.sessionManager.saveSessionInfo(sessionId, sessionInfo);
+    }
+    return true;
+  }
+
+  /**
+   * Record where a session's recording was archived. Returns false if there is no such session.
+   */
+  setSessionArchive(sessionId: string, archive: SessionArchive): boolean {
+    const sessionInfo = this.sessionManager.loadSessionInfo(sessionId);
+    if (!sessionInfo) return false;
+
+    sessionInfo.archive = archive;
+    this.sessionManager.saveSessionInfo(sessionId, sessionInfo);
+    return true;
+  }
+
+  /**
+   * Commands a session ran, from the OSC 133 marks recorded in its cast file
+   * or else from the lines typed into it
+   */
+  async getCommandHistory(sessionId: string): Promise<CommandHistory> {
+    const sessionPaths = this.sessionManager.getSessionPaths(sessionId);
+    if (!sessionPaths || !fs.existsSync(sessionPaths.stdoutPath)) {
+      return { source: 'input', commands: [] };
+    }
+    return readCommandHistory(sessionPaths.stdoutPath);
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let ptyProcesses = 0;
+    let inputSocketServers = 0;
+    let controlWatchers = 0;
+    for (const session of this.sessions.values()) {
+      if (session.ptyProcess) ptyProcesses++;
+      if (session.inputSocketServer) inputSocketServers++;
+      if (session.controlWatcher) controlWatchers++;
+    }
+    return {
+      sessions: this.sessions.size,
+      ptyProcesses,
+      inputSocketServers,
+      inputSocketClients: this.inputSocketClients.size,
+      controlWatchers,
+      resizes: this.resizeThrottle.getStats(),
+    };
+  }
+
   /**
    * Capture process information for bell source identification
    */
@@ -1260,6 +2143,7 @@ export class PtyManager extends EventEmitter {
    * Shutdown all active sessions and clean up resources
    */
   async shutdown(): Promise<void> {
+    this.stopPtyPool();
     for (const [sessionId, session] of Array.from(this.sessions.entries())) {
       try {
         if (session.ptyProcess) {
@@ -1326,7 +2210,9 @@ export class PtyManager extends EventEmitter {
     // Forward stdin to PTY with maximum speed
     process.stdin.on('data', (data: string) => {
       try {
+        this.finishInit(session);
         session.ptyProcess?.write(data);
+        session.counters?.recordInput(data);
       } catch (error) {
         logger.error(`Failed to forward stdin to session ${session.id}:`, error);
       }
@@ -1337,8 +2223,19 @@ export class PtyManager extends EventEmitter {
    * Clean up all resources associated with a session
    */
   private cleanupSessionResources(session: PtySession): void {
+    if (this.sessionCgroup) {
+      removeSessionCgroup(sessionCgroupDir(this.sessionCgroup, session.id));
+    }
+
     // Clean up resize tracking
     this.sessionResizeSources.delete(session.id);
+    this.resizeThrottle.clear(session.id);
+    this.stoppedSessions.delete(session.id);
+    if (session.pendingInit) {
+      clearTimeout(session.pendingInit.settleTimer);
+      clearTimeout(session.pendingInit.deadline);
+      session.pendingInit = undefined;
+    }
 
     // Clean up input socket server
     if (session.inputSocketServer) {
@@ -1353,17 +2250,26 @@ export class PtyManager extends EventEmitter {
       }
     }
 
+    session.stdinReader?.destroy();
+
     // Close control watcher
     if (session.controlWatcher) {
       session.controlWatcher.close();
     }
 
-    // Remove control pipe
-    if (fs.existsSync(session.controlPipePath)) {
-      try {
-        fs.unlinkSync(session.controlPipePath);
-      } catch (_e) {
-        // Control pipe already removed
+    // Remove control pipe, its acknowledgements and env changes no prompt picked up
+    const controlFiles = [
+      session.controlPipePath,
+      controlAckPath(session.controlPipePath),
+      path.join(session.controlDir, ENV_FILE),
+    ];
+    for (const controlPath of controlFiles) {
+      if (fs.existsSync(controlPath)) {
+        try {
+          fs.unlinkSync(controlPath);
+        } catch (_e) {
+          // Control pipe already removed
+        }
       }
     }
   }
@@ -0,0 +1,267 @@
+/**
+ * PtyPool - Pre-started shells new sessions can claim
+ *
+ * Starting a login shell that loads its rc files can take hundreds of
+ * milliseconds. For each configured profile (command and working directory)
+ * the pool keeps a few shells started in advance; a session created with the
+ * same command and directory takes one over instead of spawning, and the pool
+ * starts a replacement in the background. Output a pooled shell wrote before
+ * being claimed (its prompt) is kept and handed to the session.
+ *
+ * Pooled shells are spawned with a reserved session ID, so the environment
+ * they were started with (VIBETUNNEL_SESSION_ID, ...) is already right for
+ * the session claiming them.
+ */
+
+import type { IDisposable, IPty } from 'node-pty';
+import * as os from 'os';
+import * as path from 'path';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('pty-pool');
+
+const DEFAULT_POOL_SIZE = 2;
+// A shell exiting sooner than this after starting counts as a failed start
+const MIN_SHELL_LIFETIME_MS = 1000;
+// Delay before starting shells of a profile again after failed starts
+const RETRY_DELAY_MS = 5000;
+
+export interface PtyPoolProfile {
+  // Matched against the resolved command of new sessions
+  command: string[];
+  workingDir: string;
+  // Shells kept ready
+  size: number;
+}
+
+export interface PooledPtySpawn {
+  sessionId: string;
+  // Control root the shell's environment points to
+  root: string;
+  ptyProcess: IPty;
+}
+
+export interface PooledPty extends PooledPtySpawn {
+  // Output written before the shell was claimed
+  output: string;
+}
+
+export interface PtyPoolProfileStats {
+  command: string[];
+  workingDir: string;
+  size: number;
+  ready: number;
+  starting: number;
+  hits: number;
+  misses: number;
+  spawned: number;
+  failures: number;
+  // Average time from spawning a shell to its first output (prompt)
+  averageReadyMs: number | null;
+}
+
+interface PoolEntry extends PooledPtySpawn {
+  output: string[];
+  spawnedAt: number;
+  readyAt: number | null;
+  listeners: IDisposable[];
+}
+
+interface ProfileState {
+  profile: PtyPoolProfile;
+  entries: PoolEntry[];
+  hits: number;
+  misses: number;
+  spawned: number;
+  failures: number;
+  readyMsTotal: number;
+  readyCount: number;
+  retryTimer: NodeJS.Timeout | null;
+}
+
+/**
+ * Parse a --pty-pool value: `<command>[;cwd=<dir>][;size=<n>]`
+ */
+export function parsePtyPoolProfile(spec: string): PtyPoolProfile {
+  const [commandPart, ...options] = spec.split(';');
+  const command = commandPart.trim().split(/\s+/).filter(Boolean);
+  if (command.length === 0) {
+    throw new Error(`Invalid PTY pool profile "${spec}": no command`);
+  }
+
+  let workingDir = os.homedir();
+  let size = DEFAULT_POOL_SIZE;
+  for (const option of options) {
+    const [key, ...rest] = option.split('=');
+    const value = rest.join('=').trim();
+    if (key.trim() === 'cwd' && value) {
+      workingDir = path.resolve(value.replace(/^~(?=$|\/)/, os.homedir()));
+    } else if (key.trim() === 'size' && /^\d+$/.test(value) && Number(value) > 0) {
+      size = Number(value);
+    } else {
+      throw new Error(`Invalid PTY pool profile "${spec}": bad option "${option}"`);
+    }
+  }
+  return { command, workingDir, size };
+}
+
+export class PtyPool {
+  private states: ProfileState[];
+  private stopped = false;
+
+  constructor(
+    profiles: PtyPoolProfile[],
+    private spawn: (profile: PtyPoolProfile) => PooledPtySpawn
+  ) {
+    this.states = profiles.map((profile) => ({
+      profile,
+      entries: [],
+      hits: 0,
+      misses: 0,
+      spawned: 0,
+      failures: 0,
+      readyMsTotal: 0,
+      readyCount: 0,
+      retryTimer: null,
+    }));
+  }
+
+  /**
+   * Start the shells of every profile
+   */
+  start(): void {
+    for (const state of this.states) {
+      this.fill(state);
+    }
+  }
+
+  /**
+   * Take a ready shell started with the given command in the given directory
+   * and control root; null if no profile matches or none is ready
+   */
+  claim(command: string[], workingDir: string, root: string): PooledPty | null {
+    const state = this.states.find(
+      ({ profile }) =>
+        profile.workingDir === path.resolve(workingDir) &&
+        profile.command.length === command.length &&
+        profile.command.every((arg, i) => arg === command[i])
+    );
+    if (!state || this.stopped) return null;
+
+    const index = state.entries.findIndex((entry) => entry.root === root);
+    if (index === -1) {
+      state.misses++;
+      return null;
+    }
+
+    const [entry] = state.entries.splice(index, 1);
+    for (const listener of entry.listeners) {
+      listener.dispose();
+    }
+    state.hits++;
+    setImmediate(() => this.fill(state));
+
+    logger.debug(`claimed pooled shell ${entry.ptyProcess.pid} for session ${entry.sessionId}`);
+    return {
+      sessionId: entry.sessionId,
+      root: entry.root,
+      ptyProcess: entry.ptyProcess,
+      output: entry.output.join(''),
+    };
+  }
+
+  getStats(): PtyPoolProfileStats[] {
+    return this.states.map((state) => {
+      const ready = state.entries.filter((entry) => entry.readyAt !== null).length;
+      return {
+        command: state.profile.command,
+        workingDir: state.profile.workingDir,
+        size: state.profile.size,
+        ready,
+        starting: state.entries.length - ready,
+        hits: state.hits,
+        misses: state.misses,
+        spawned: state.spawned,
+        failures: state.failures,
+        averageReadyMs: state.readyCount
+          ? Math.round(state.readyMsTotal / state.readyCount)
+          : null,
+      };
+    });
+  }
+
+  /**
+   * Kill the shells nobody claimed
+   */
+  stop(): void {
+    this.stopped = true;
+    for (const state of this.states) {
+      if (state.retryTimer) clearTimeout(state.retryTimer);
+      for (const entry of state.entries.splice(0)) {
+        for (const listener of entry.listeners) {
+          listener.dispose();
+        }
+        try {
+          entry.ptyProcess.kill();
+        } catch (_error) {
+          // Already gone
+        }
+      }
+    }
+  }
+
+  private fill(state: ProfileState): void {
+    while (!this.stopped && !state.retryTimer && state.entries.length < state.profile.size) {
+      let spawned: PooledPtySpawn;
+      try {
+        spawned = this.spawn(state.profile);
+      } catch (error) {
+        logger.warn(`failed to start pooled shell ${state.profile.command.join(' ')}:`, error);
+        this.failed(state);
+        return;
+      }
+      state.spawned++;
+
+      const entry: PoolEntry = {
+        ...spawned,
+        output: [],
+        spawnedAt: Date.now(),
+        readyAt: null,
+        listeners: [],
+      };
+      entry.listeners.push(
+        entry.ptyProcess.onData((data) => {
+          if (entry.readyAt === null) {
+            entry.readyAt = Date.now();
+            state.readyMsTotal += entry.readyAt - entry.spawnedAt;
+            state.readyCount++;
+          }
+          entry.output.push(data);
+        }),
+        entry.ptyProcess.onExit(() => {
+          const index = state.entries.indexOf(entry);
+          if (index === -1) return;
+          state.entries.splice(index, 1);
+          if (Date.now() - entry.spawnedAt < MIN_SHELL_LIFETIME_MS) {
+            logger.warn(`pooled shell ${state.profile.command.join(' ')} exited right away`);
+            this.failed(state);
+          } else {
+            this.fill(state);
+          }
+        })
+      );
+      state.entries.push(entry);
+    }
+  }
+
+  // Retry later instead of respawning a failing shell in a loop
+  private failed(state: ProfileState): void {
+    state.failures++;
+    if (this.stopped || state.retryTimer) return;
+    state.retryTimer = setTimeout(() => {
+      state.retryTimer = null;
+      this.fill(state);
+    }, RETRY_DELAY_MS);
+    state.retryTimer.unref?.();
+  }
+}
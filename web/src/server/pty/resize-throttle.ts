@@ -0,0 +1,101 @@
+/**
+ * ResizeThrottle - Limits how often a session is resized
+ *
+ * Dragging a browser window produces a burst of resize requests. Each one
+ * resizes the PTY (making the program redraw) and adds a resize event to the
+ * asciinema recording. The throttle applies the first request right away and
+ * coalesces the rest of the burst into a single resize with the latest size.
+ */
+
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('resize-throttle');
+
+export const DEFAULT_MAX_RESIZES_PER_SECOND = 10;
+
+// Applies a size; returns false if nothing changed (e.g. the size is already current)
+type ApplyResize = (cols: number, rows: number) => boolean;
+
+interface ThrottleState {
+  lastAppliedAt: number;
+  pending?: { cols: number; rows: number; apply: ApplyResize };
+  timer?: NodeJS.Timeout;
+}
+
+export class ResizeThrottle {
+  private states = new Map<string, ThrottleState>();
+  private intervalMs: number;
+  private coalesced = 0;
+
+  constructor(maxPerSecond = DEFAULT_MAX_RESIZES_PER_SECOND) {
+    this.intervalMs = 1000 / maxPerSecond;
+  }
+
+  /**
+   * Resize a session now if the rate allows it, otherwise queue the size and
+   * apply it when the interval has passed. A queued size is replaced by newer
+   * requests. Returns true if the resize was applied immediately; errors from
+   * an immediate resize are thrown to the caller.
+   */
+  request(sessionId: string, cols: number, rows: number, apply: ApplyResize): boolean {
+    let state = this.states.get(sessionId);
+    if (!state) {
+      state = { lastAppliedAt: 0 };
+      this.states.set(sessionId, state);
+    }
+
+    if (state.pending) {
+      state.pending = { cols, rows, apply };
+      this.coalesced++;
+      return false;
+    }
+
+    const wait = state.lastAppliedAt + this.intervalMs - Date.now();
+    if (wait > 0) {
+      state.pending = { cols, rows, apply };
+      state.timer = setTimeout(() => this.flush(sessionId), wait);
+      return false;
+    }
+
+    if (apply(cols, rows)) {
+      state.lastAppliedAt = Date.now();
+    }
+    return true;
+  }
+
+  /**
+   * Drop a session's queued resize
+   */
+  clear(sessionId: string): void {
+    const state = this.states.get(sessionId);
+    if (state?.timer) {
+      clearTimeout(state.timer);
+    }
+    this.states.delete(sessionId);
+  }
+
+  getStats(): { coalesced: number; pending: number } {
+    let pending = 0;
+    for (const state of this.states.values()) {
+      if (state.pending) pending++;
+    }
+    return { coalesced: this.coalesced, pending };
+  }
+
+  private flush(sessionId: string): void {
+    const state = this.states.get(sessionId);
+    if (!state?.pending) return;
+
+    const { cols, rows, apply } = state.pending;
+    state.pending = undefined;
+    state.timer = undefined;
+
+    try {
+      if (apply(cols, rows)) {
+        state.lastAppliedAt = Date.now();
+      }
+    } catch (error) {
+      logger.error(`Failed to apply queued resize for session ${sessionId}:`, error);
+    }
+  }
+}
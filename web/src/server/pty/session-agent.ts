@@ -0,0 +1,105 @@
+/**
+ * Session agent metadata - what an AI agent running in a session reports
+ *
+ * Agent wrappers (e.g. around claude or gemini) describe the session they run
+ * in by appending an `agent` message to its control pipe, whose path they get
+ * in VIBETUNNEL_CONTROL_PATH:
+ *
+ *   {"cmd":"agent","agentType":"claude","taskDescription":"Fix the flaky test","tokensUsed":1200}
+ *
+ * Fields left out are kept, null clears them. The fields end up in the
+ * session info, so session lists show and filter agent sessions.
+ */
+
+import type { SessionInfo } from '../../shared/types.js';
+
+// Lowercased; e.g. claude, gemini, codex, aider
+const AGENT_TYPE_PATTERN = /^[a-z0-9][a-z0-9._-]{0,31}$/;
+export const MAX_TASK_DESCRIPTION_LENGTH = 500;
+
+export type AgentFields = Pick<SessionInfo, 'agentType' | 'taskDescription' | 'tokensUsed'>;
+
+/**
+ * Validated agent fields of a control message or request body; null for
+ * fields to clear. Throws with a message for invalid values.
+ */
+export function parseAgentFields(
+  input: Record<string, unknown>
+): { [K in keyof AgentFields]?: AgentFields[K] | null } {
+  const fields: { [K in keyof AgentFields]?: AgentFields[K] | null } = {};
+  const { agentType, taskDescription, tokensUsed } = input;
+
+  if (agentType === null) {
+    fields.agentType = null;
+  } else if (agentType !== undefined) {
+    const normalized = typeof agentType === 'string' ? agentType.trim().toLowerCase() : '';
+    if (!AGENT_TYPE_PATTERN.test(normalized)) {
+      throw new Error('agentType must be 1-32 letters, digits, ".", "_" or "-"');
+    }
+    fields.agentType = normalized;
+  }
+
+  if (taskDescription === null) {
+    fields.taskDescription = null;
+  } else if (taskDescription !== undefined) {
+    if (typeof taskDescription !== 'string') {
+      throw new Error('taskDescription must be a string');
+    }
+    // One line, cut to length
+    const line = taskDescription.replace(/\s+/g, ' ').trim();
+    fields.taskDescription = line.slice(0, MAX_TASK_DESCRIPTION_LENGTH) || null;
+  }
+
+  if (tokensUsed === null) {
+    fields.tokensUsed = null;
+  } else if (tokensUsed !== undefined) {
+    if (typeof tokensUsed !== 'number' || !Number.isSafeInteger(tokensUsed) || tokensUsed < 0) {
+      throw new Error('tokensUsed must be a non-negative integer');
+    }
+    fields.tokensUsed = tokensUsed;
+  }
+
+  return fields;
+}
+
+/**
+ * Apply parsed agent fields to a session info; returns whether anything changed
+ */
+export function applyAgentFields(
+  sessionInfo: SessionInfo,
+  fields: ReturnType<typeof parseAgentFields>
+): boolean {
+  let changed = false;
+  for (const key of ['agentType', 'taskDescription', 'tokensUsed'] as const) {
+    const value = fields[key];
+    if (value === undefined || value === sessionInfo[key]) continue;
+    if (value === null) {
+      if (sessionInfo[key] === undefined) continue;
+      delete sessionInfo[key];
+    } else {
+      (sessionInfo as unknown as Record<string, unknown>)[key] = value;
+    }
+    changed = true;
+  }
+  if (changed) {
+    sessionInfo.agentUpdatedAt = new Date().toISOString();
+  }
+  return changed;
+}
+
+/**
+ * Whether a session passes the agent filters of a session list request:
+ * `agent=true|false` (has an agent type) and `agentType=<type>[,<type>...]`
+ */
+export function matchesAgentFilter(
+  sessionInfo: SessionInfo,
+  filter: { agent?: unknown; agentType?: unknown }
+): boolean {
+  if (filter.agent === 'true' && !sessionInfo.agentType) return false;
+  if (filter.agent === 'false' && sessionInfo.agentType) return false;
+  if (typeof filter.agentType === 'string' && filter.agentType) {
+    const types = filter.agentType.split(',').map((type) => type.trim().toLowerCase());
+    if (!sessionInfo.agentType || !types.includes(sessionInfo.agentType)) return false;
+  }
+  return true;
+}
@@ -0,0 +1,107 @@
+/**
+ * SessionCounters - Live I/O statistics of a session
+ *
+ * Counts bytes and lines as they pass through the PTY. Output is also kept in
+ * one-second buckets for the last minute, which gives the current output rate
+ * and a short history for graphs.
+ */
+
+// Seconds of per-second output history kept
+const HISTORY_SECONDS = 60;
+// Seconds the output rate is averaged over
+const RATE_WINDOW_SECONDS = 10;
+
+export interface SessionStats {
+  bytesOut: number;
+  bytesIn: number;
+  linesOut: number;
+  inputEvents: number;
+  // Output averaged over the last RATE_WINDOW_SECONDS
+  outputRate: { bytesPerSecond: number; linesPerSecond: number };
+  // Output bytes per second over the last minute, oldest first
+  outputHistory: number[];
+  lastOutputAt: string | null;
+  lastInputAt: string | null;
+  // Size of the cast file
+  recordingBytes: number;
+}
+
+export class SessionCounters {
+  private bytesOut = 0;
+  private bytesIn = 0;
+  private linesOut = 0;
+  private inputEvents = 0;
+  private lastOutputAt: number | null = null;
+  private lastInputAt: number | null = null;
+  // Ring of per-second output, indexed by epoch second modulo its length
+  private buckets = Array.from({ length: HISTORY_SECONDS }, () => ({
+    second: 0,
+    bytes: 0,
+    lines: 0,
+  }));
+
+  recordOutput(data: string, now = Date.now()): void {
+    const bytes = Buffer.byteLength(data, 'utf8');
+    let lines = 0;
+    for (let i = data.indexOf('\n'); i !== -1; i = data.indexOf('\n', i + 1)) {
+      lines++;
+    }
+
+    this.bytesOut += bytes;
+    this.linesOut += lines;
+    this.lastOutputAt = now;
+
+    const bucket = this.bucketAt(Math.floor(now / 1000));
+    bucket.bytes += bytes;
+    bucket.lines += lines;
+  }
+
+  recordInput(data: string, now = Date.now()): void {
+    this.bytesIn += Buffer.byteLength(data, 'utf8');
+    this.inputEvents++;
+    this.lastInputAt = now;
+  }
+
+  getStats(recordingBytes: number, now = Date.now()): SessionStats {
+    const currentSecond = Math.floor(now / 1000);
+    const history: number[] = [];
+    let windowBytes = 0;
+    let windowLines = 0;
+    // Oldest first, ending with the current (partial) second
+    for (let second = currentSecond - HISTORY_SECONDS + 1; second <= currentSecond; second++) {
+      const bucket = this.buckets[second % HISTORY_SECONDS];
+      const current = bucket.second === second;
+      history.push(current ? bucket.bytes : 0);
+      // The rate covers full seconds only
+      if (current && second < currentSecond && second >= currentSecond - RATE_WINDOW_SECONDS) {
+        windowBytes += bucket.bytes;
+        windowLines += bucket.lines;
+      }
+    }
+
+    return {
+      bytesOut: this.bytesOut,
+      bytesIn: this.bytesIn,
+      linesOut: this.linesOut,
+      inputEvents: this.inputEvents,
+      outputRate: {
+        bytesPerSecond: Math.round(windowBytes / RATE_WINDOW_SECONDS),
+        linesPerSecond: Math.round((windowLines / RATE_WINDOW_SECONDS) * 10) / 10,
+      },
+      outputHistory: history,
+      lastOutputAt: this.lastOutputAt ? new Date(this.lastOutputAt).toISOString() : null,
+      lastInputAt: this.lastInputAt ? new Date(this.lastInputAt).toISOString() : null,
+      recordingBytes,
+    };
+  }
+
+  private bucketAt(second: number) {
+    const bucket = this.buckets[second % HISTORY_SECONDS];
+    if (bucket.second !== second) {
+      bucket.second = second;
+      bucket.bytes = 0;
+      bucket.lines = 0;
+    }
+    return bucket;
+  }
+}
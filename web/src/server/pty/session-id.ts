@@ -0,0 +1,40 @@
+/**
+ * Session IDs - Validation and the paths derived from them
+ *
+ * Session IDs come from URLs, WebSocket messages and the command line and
+ * name directories in the control roots. Generated IDs are UUIDs; fwd also
+ * accepts IDs of its own (`--session-id`, `fwd_<time>`). Only a safe
+ * charset is allowed, so an ID is always a single path component: no
+ * separators, no `.` or `..`, no leading dot.
+ */
+
+import * as path from 'path';
+import { PtyError } from './types.js';
+
+const SESSION_ID_PATTERN = /^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}$/;
+
+export function isValidSessionId(value: unknown): value is string {
+  return typeof value === 'string' && SESSION_ID_PATTERN.test(value);
+}
+
+export function assertValidSessionId(value: unknown): asserts value is string {
+  if (!isValidSessionId(value)) {
+    throw new PtyError(`Invalid session ID: ${JSON.stringify(value)}`, 'INVALID_SESSION_ID');
+  }
+}
+
+/**
+ * Path of a session's directory in a control root, or of a file in it.
+ * Throws for invalid IDs and for paths that would end up outside the root.
+ */
+export function sessionPath(rootDir: string, sessionId: string, ...parts: string[]): string {
+  assertValidSessionId(sessionId);
+  const root = path.resolve(rootDir);
+  const sessionDir = path.join(root, sessionId);
+  const resolved = path.resolve(sessionDir, ...parts);
+  const relative = path.relative(sessionDir, resolved);
+  if (path.dirname(sessionDir) !== root || relative.startsWith('..') || path.isAbsolute(relative)) {
+    throw new PtyError(`Path outside of session ${sessionId}`, 'INVALID_SESSION_ID', sessionId);
+  }
+  return resolved;
+}
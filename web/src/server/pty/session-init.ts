@@ -0,0 +1,141 @@
+/**
+ * Session init scripts - commands run right after a session starts
+ *
+ * A script is either typed into the session (`stdin`), which works with any
+ * program but shows up like typed commands, or injected into the startup of an
+ * interactive bash or zsh (`rc`), which runs it after the user's own rc files
+ * without echoing it. The cast brackets the init with the markers `init` and
+ * `init-done` so players can skip what it printed.
+ *
+ * Interactive bash and zsh sessions also get an env hook in their startup
+ * files: before each prompt it sources and removes `env.sh` in the session
+ * directory, where `env` control commands leave their changes, so the next
+ * command the user runs sees them.
+ */
+
+import * as fs from 'fs';
+import * as path from 'path';
+
+export type InitMode = 'stdin' | 'rc';
+
+export interface SessionInitOptions {
+  script: string;
+  mode?: InitMode;
+}
+
+export const INIT_MARKER = 'init';
+export const INIT_DONE_MARKER = 'init-done';
+// The init counts as done once the session is quiet this long after it
+export const INIT_SETTLE_MS = 1000;
+// ...or at the latest after this long
+export const INIT_MAX_MS = 10000;
+
+// Environment changes for the shell to pick up at its next prompt
+export const ENV_FILE = 'env.sh';
+
+// Sources the env file once (renamed first, so a change written meanwhile is
+// kept for the next prompt) and keeps $? for the user's own prompt commands
+export const ENV_HOOK_SCRIPT = [
+  '__vibetunnel_env() {',
+  '  local ret=$? file="$VIBETUNNEL_ENV_FILE"',
+  '  if [ -n "$file" ] && [ -f "$file" ] && mv -f "$file" "$file.$$" 2>/dev/null; then',
+  '    . "$file.$$"',
+  '    rm -f "$file.$$"',
+  '  fi',
+  '  return $ret',
+  '}',
+  'if [ -n "$ZSH_VERSION" ]; then',
+  '  precmd_functions=(__vibetunnel_env $precmd_functions)',
+  'else',
+  '  PROMPT_COMMAND="__vibetunnel_env${PROMPT_COMMAND:+;$PROMPT_COMMAND}"',
+  'fi',
+].join('\n');
+
+// Arguments that still start a plain interactive shell
+const BASH_ARGS = ['-i'];
+const ZSH_ARGS = ['-i', '-l', '--login'];
+
+function rcShell(command: string[]): 'bash' | 'zsh' | null {
+  const name = path.basename(command[0] ?? '');
+  const args = command.slice(1);
+  // bash reads its rc file only when not started as a login shell
+  if (name === 'bash' && args.every((arg) => BASH_ARGS.includes(arg))) return 'bash';
+  if (name === 'zsh' && args.every((arg) => ZSH_ARGS.includes(arg))) return 'zsh';
+  return null;
+}
+
+/**
+ * Whether `rc` init works for a command (an interactive bash or zsh)
+ */
+export function supportsRcInit(command: string[]): boolean {
+  return rcShell(command) !== null;
+}
+
+/**
+ * Type a script into a session: lines are sent as if each was followed by Enter
+ */
+export function initScriptInput(script: string): string {
+  const lines = script.replace(/\r\n?/g, '\n').replace(/\n+$/, '');
+  return `${lines.replace(/\n/g, '\r')}\r`;
+}
+
+/**
+ * Write rc files into `dir` that load the user's own startup files and then
+ * run `script`, and return the arguments and environment to start the shell
+ * with. Null if the command is not an interactive bash or zsh.
+ */
+export function prepareRcInit(
+  command: string[],
+  script: string,
+  dir: string
+): { args: string[]; env: Record<string, string> } | null {
+  const shell = rcShell(command);
+  if (shell === 'bash') {
+    const rcFile = path.join(dir, 'init.bashrc');
+    fs.writeFileSync(rcFile, `[ -f ~/.bashrc ] && . ~/.bashrc\n${script}\n`);
+    return { args: ['--rcfile', rcFile, ...command.slice(1)], env: {} };
+  }
+
+  if (shell === 'zsh') {
+    // zsh reads its startup files from ZDOTDIR; each of ours loads the user's
+    // file of the same name, and .zshrc (the last one read) hands ZDOTDIR back
+    const zdotdir = path.join(dir, 'init-zsh');
+    fs.mkdirSync(zdotdir, { recursive: true });
+    const writeStartupFile = (name: string, after: string) =>
+      fs.writeFileSync(
+        path.join(zdotdir, name),
+        [
+          '_vt_init_dir="$ZDOTDIR"',
+          'ZDOTDIR="${VIBETUNNEL_USER_ZDOTDIR:-$HOME}"',
+          `[ -f "$ZDOTDIR/${name}" ] && . "$ZDOTDIR/${name}"`,
+          after,
+          '',
+        ].join('\n')
+      );
+    // The user's .zshenv may move ZDOTDIR; later files are loaded from there
+    writeStartupFile('.zshenv', 'VIBETUNNEL_USER_ZDOTDIR="$ZDOTDIR"\nZDOTDIR="$_vt_init_dir"');
+    writeStartupFile('.zprofile', 'ZDOTDIR="$_vt_init_dir"');
+    writeStartupFile('.zshrc', `unset _vt_init_dir VIBETUNNEL_USER_ZDOTDIR\n${script}`);
+    return {
+      args: command.slice(1),
+      env: { ZDOTDIR: zdotdir, VIBETUNNEL_USER_ZDOTDIR: process.env.ZDOTDIR ?? '' },
+    };
+  }
+
+  return null;
+}
+
+function shellQuote(value: string): string {
+  return `'${value.replace(/'/g, `'\\''`)}'`;
+}
+
+/**
+ * Shell lines applying environment changes (null: unset), for the env file
+ */
+export function envFileLines(changes: Record<string, string | null>): string {
+  return Object.entries(changes)
+    .map(([name, value]) =>
+      value === null ? `unset ${name}\n` : `export ${name}=${shellQuote(value)}\n`
+    )
+    .join('');
+}
@@ -8,45 +8,61 @@
 import chalk from 'chalk';
 import { spawnSync } from 'child_process';
 import * as fs from 'fs';
-import * as os from 'os';
 import * as path from 'path';
+import { parseSessionInfo, serializeSessionInfo } from '../../shared/session-schema.js';
 import type { Session, SessionInfo } from '../../shared/types.js';
 import { createLogger } from '../utils/logger.js';
+import { decodeCastLine } from '../utils/recording-crypto.js';
+import { type ControlAck, sendControlCommand } from './control-protocol.js';
+import { type ControlRoot, ControlRoots } from './control-roots.js';
 import { ProcessUtils } from './process-utils.js';
-import { PtyError } from './types.js';
+import { isValidSessionId } from './session-id.js';
+import { type ControlMessage, PtyError } from './types.js';
 
 const logger = createLogger('session-manager');
 
 export class SessionManager {
   private controlPath: string;
-
-  constructor(controlPath?: string) {
-    this.controlPath = controlPath || path.join(os.homedir(), '.vibetunnel', 'control');
-    logger.debug(`initializing session manager with control path: ${this.controlPath}`);
-    this.ensureControlDirectory();
+  private roots: ControlRoots;
+
+  constructor(controlPath?: string | ControlRoots) {
+    this.roots = ControlRoots.from(controlPath);
+    this.controlPath = this.roots.primary;
+    logger.debug(
+      `initializing session manager with control roots: ${this.roots
+        .list()
+        .map((root) => root.path)
+        .join(', ')}`
+    );
+    this.ensureControlDirectories();
   }
 
   /**
-   * Ensure the control directory exists
+   * Ensure the control directories exist
    */
-  private ensureControlDirectory(): void {
-    if (!fs.existsSync(this.controlPath)) {
-      fs.mkdirSync(this.controlPath, { recursive: true });
-      logger.log(chalk.green(`control directory created: ${this.controlPath}`));
+  private ensureControlDirectories(): void {
+    for (const root of this.roots.list()) {
+      if (!fs.existsSync(root.path)) {
+        fs.mkdirSync(root.path, { recursive: true });
+        logger.log(chalk.green(`control directory created: ${root.path}`));
+      }
     }
   }
 
   /**
-   * Create a new session directory structure
+   * Create a new session directory structure, in the default root unless given
    */
-  createSessionDirectory(sessionId: string): {
+  createSessionDirectory(
+    sessionId: string,
+    root?: ControlRoot
+  ): {
     controlDir: string;
     stdoutPath: string;
     stdinPath: string;
     controlPipePath: string;
     sessionJsonPath: string;
   } {
-    const controlDir = path.join(this.controlPath, sessionId);
+    const controlDir = this.roots.assign(sessionId, root ?? this.roots.select());
 
     // Create session directory
     if (!fs.existsSync(controlDir)) {
@@ -64,6 +80,19 @@ export class SessionManager {
     return paths;
   }
 
+  /**
+   * Create the session's scratch directory. It lives in the session directory,
+   * so cleaning up the session removes it.
+   */
+  createScratchDir(sessionId: string, owner?: { uid: number; gid: number }): string {
+    const scratchDir = path.join(this.roots.sessionDir(sessionId), 'scratch');
+    fs.mkdirSync(scratchDir, { recursive: true, mode: 0o700 });
+    if (owner) {
+      fs.chownSync(scratchDir, owner.uid, owner.gid);
+    }
+    return scratchDir;
+  }
+
   /**
    * Create stdin pipe (FIFO if possible, regular file otherwise)
    */
@@ -98,10 +127,10 @@ export class SessionManager {
    */
   saveSessionInfo(sessionId: string, sessionInfo: SessionInfo): void {
     try {
-      const sessionInfoStr = JSON.stringify(sessionInfo, null, 2);
+      const sessionInfoStr = serializeSessionInfo(sessionInfo);
 
       // Write to temporary file first, then move to final location (atomic write)
-      const sessionJsonPath = path.join(this.controlPath, sessionId, 'session.json');
+      const sessionJsonPath = path.join(this.roots.sessionDir(sessionId), 'session.json');
       const tempPath = `${sessionJsonPath}.tmp`;
       fs.writeFileSync(tempPath, sessionInfoStr, 'utf8');
       fs.renameSync(tempPath, sessionJsonPath);
@@ -118,14 +147,15 @@ export class SessionManager {
    * Load session info from JSON file
    */
   loadSessionInfo(sessionId: string): SessionInfo | null {
-    const sessionJsonPath = path.join(this.controlPath, sessionId, 'session.json');
+    if (!isValidSessionId(sessionId)) return null;
+    const sessionJsonPath = path.join(this.roots.sessionDir(sessionId), 'session.json');
     try {
       if (!fs.existsSync(sessionJsonPath)) {
         return null;
       }
 
       const content = fs.readFileSync(sessionJsonPath, 'utf8');
-      return JSON.parse(content) as SessionInfo;
+      return parseSessionInfo(JSON.parse(content), sessionId);
     } catch (error) {
       logger.warn(`failed to load session info for ${sessionId}:`, error);
       return null;
@@ -156,48 +186,94 @@ export class SessionManager {
   }
 
   /**
-   * List all sessions
+   * Run a control command in the process owning a session and wait for its
+   * acknowledgement (null: none within the timeout)
    */
-  listSessions(): Session[] {
+  async sendControlCommand(
+    sessionId: string,
+    message: ControlMessage,
+    timeoutMs?: number
+  ): Promise<ControlAck | null> {
+    const paths = this.getSessionPaths(sessionId, true);
+    if (!paths) {
+      throw new PtyError(`Session ${sessionId} not found`, 'SESSION_NOT_FOUND', sessionId);
+    }
+    return sendControlCommand(paths.controlPipePath, message, timeoutMs);
+  }
+
+  /**
+   * End the recording of a session whose host died with an exit event, unless
+   * it has one, so that its streams end like those of any other session
+   */
+  private finishRecording(sessionId: string, exitCode: number): void {
+    const paths = this.getSessionPaths(sessionId);
+    if (!paths) return;
     try {
-      if (!fs.existsSync(this.controlPath)) {
-        return [];
+      const { size } = fs.statSync(paths.stdoutPath);
+      const tailSize = Math.min(size, 64 * 1024);
+      const fd = fs.openSync(paths.stdoutPath, 'r');
+      const tail = Buffer.alloc(tailSize);
+      fs.readSync(fd, tail, 0, tailSize, size - tailSize);
+      fs.closeSync(fd);
+
+      const text = tail.toString('utf8');
+      // A host killed while writing leaves an incomplete line behind
+      const complete = text.endsWith('\n') || text === '';
+      const lines = text.split('\n').filter((line) => line.trim());
+      const lastLine = complete ? lines[lines.length - 1] : lines[lines.length - 2];
+      if (lastLine) {
+        try {
+          const event = JSON.parse(decodeCastLine(lastLine));
+          if (Array.isArray(event) && event[0] === 'exit') return;
+        } catch {
+          // Cut off by the tail; not an exit event
+        }
+      }
+      fs.appendFileSync(
+        paths.stdoutPath,
+        `${complete ? '' : '\n'}${JSON.stringify(['exit', exitCode, sessionId])}\n`
+      );
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code !== 'ENOENT') {
+        logger.warn(`failed to end the recording of session ${sessionId}:`, error);
       }
+    }
+  }
 
+  /**
+   * List all sessions
+   */
+  listSessions(): Session[] {
+    try {
       const sessions: Session[] = [];
-      const entries = fs.readdirSync(this.controlPath, { withFileTypes: true });
-
-      for (const entry of entries) {
-        if (entry.isDirectory()) {
-          const sessionId = entry.name;
-          const sessionDir = path.join(this.controlPath, sessionId);
-          const stdoutPath = path.join(sessionDir, 'stdout');
-
-          const sessionInfo = this.loadSessionInfo(sessionId);
-          if (sessionInfo) {
-            // Determine active state for running processes
-            if (sessionInfo.status === 'running' && sessionInfo.pid) {
-              // Update status if process is no longer alive
-              if (!ProcessUtils.isProcessRunning(sessionInfo.pid)) {
-                logger.log(
-                  chalk.yellow(
-                    `process ${sessionInfo.pid} no longer running for session ${sessionId}`
-                  )
-                );
-                sessionInfo.status = 'exited';
-                if (sessionInfo.exitCode === undefined) {
-                  sessionInfo.exitCode = 1; // Default exit code for dead processes
-                }
-                this.saveSessionInfo(sessionId, sessionInfo);
+
+      for (const { sessionId, dir: sessionDir } of this.roots.listSessionDirs()) {
+        const stdoutPath = path.join(sessionDir, 'stdout');
+
+        const sessionInfo = this.loadSessionInfo(sessionId);
+        if (sessionInfo) {
+          // Determine active state for running processes
+          if (sessionInfo.status === 'running' && sessionInfo.pid) {
+            // Update status if process is no longer alive
+            if (!ProcessUtils.isProcessRunning(sessionInfo.pid)) {
+              logger.log(
+                chalk.yellow(
+                  `process ${sessionInfo.pid} no longer running for session ${sessionId}`
+                )
+              );
+              sessionInfo.status = 'exited';
+              if (sessionInfo.exitCode === undefined) {
+                sessionInfo.exitCode = 1; // Default exit code for dead processes
               }
-            }
-            if (fs.existsSync(stdoutPath)) {
-              const lastModified = fs.statSync(stdoutPath).mtime.toISOString();
-              sessions.push({ ...sessionInfo, id: sessionId, lastModified });
-            } else {
-              sessions.push({ ...sessionInfo, id: sessionId, lastModified: sessionInfo.startedAt });
+              this.saveSessionInfo(sessionId, sessionInfo);
             }
           }
+          if (fs.existsSync(stdoutPath)) {
+            const lastModified = fs.statSync(stdoutPath).mtime.toISOString();
+            sessions.push({ ...sessionInfo, id: sessionId, lastModified });
+          } else {
+            sessions.push({ ...sessionInfo, id: sessionId, lastModified: sessionInfo.startedAt });
+          }
         }
       }
 
@@ -222,8 +298,8 @@ export class SessionManager {
    * Check if a session exists
    */
   sessionExists(sessionId: string): boolean {
-    const sessionDir = path.join(this.controlPath, sessionId);
-    const sessionJsonPath = path.join(sessionDir, 'session.json');
+    if (!isValidSessionId(sessionId)) return false;
+    const sessionJsonPath = path.join(this.roots.sessionDir(sessionId), 'session.json');
     return fs.existsSync(sessionJsonPath);
   }
 
@@ -231,18 +307,19 @@ export class SessionManager {
    * Cleanup a specific session
    */
   cleanupSession(sessionId: string): void {
-    if (!sessionId) {
-      throw new PtyError('Session ID is required for cleanup', 'INVALID_SESSION_ID');
+    if (!isValidSessionId(sessionId)) {
+      throw new PtyError('A valid session ID is required for cleanup', 'INVALID_SESSION_ID');
     }
 
     try {
-      const sessionDir = path.join(this.controlPath, sessionId);
+      const sessionDir = this.roots.sessionDir(sessionId);
 
       if (fs.existsSync(sessionDir)) {
         // Remove directory and all contents
         fs.rmSync(sessionDir, { recursive: true, force: true });
         logger.log(chalk.green(`session ${sessionId} cleaned up`));
       }
+      this.roots.forget(sessionId);
     } catch (error) {
       throw new PtyError(
         `Failed to cleanup session ${sessionId}: ${error instanceof Error ? error.message : String(error)}`,
@@ -293,7 +370,8 @@ export class SessionManager {
     controlPipePath: string;
     sessionJsonPath: string;
   } | null {
-    const sessionDir = path.join(this.controlPath, sessionId);
+    if (!isValidSessionId(sessionId)) return null;
+    const sessionDir = this.roots.sessionDir(sessionId);
 
     if (checkExists && !fs.existsSync(sessionDir)) {
       return null;
@@ -318,9 +396,21 @@ export class SessionManager {
     }
 
     try {
-      // For FIFO pipes, we need to open in append mode
-      // For regular files, we also use append mode to avoid conflicts
-      fs.appendFileSync(paths.stdinPath, data);
+      // Non-blocking, so a FIFO without a reader (the session's process is gone) fails with
+      // ENXIO instead of hanging; regular files are appended to
+      const fd = fs.openSync(
+        paths.stdinPath,
+        fs.constants.O_WRONLY | fs.constants.O_APPEND | fs.constants.O_NONBLOCK
+      );
+      try {
+        const buffer = Buffer.from(data, 'utf8');
+        let written = 0;
+        while (written < buffer.length) {
+          written += fs.writeSync(fd, buffer, written);
+        }
+      } finally {
+        fs.closeSync(fd);
+      }
       logger.debug(`wrote ${data.length} bytes to stdin for session ${sessionId}`);
     } catch (error) {
       throw new PtyError(
@@ -332,7 +422,10 @@ export class SessionManager {
   }
 
   /**
-   * Update sessions that have zombie processes
+   * Update sessions that have zombie processes, and sessions whose host died.
+   * The PTY is gone with its host (a fwd process, or the server that ran the
+   * session), so a command that survives it - e.g. one ignoring SIGHUP - has no
+   * terminal left, and nothing records its output or takes its input.
    */
   updateZombieSessions(): string[] {
     const updatedSessions: string[] = [];
@@ -342,19 +435,35 @@ export class SessionManager {
 
       for (const session of sessions) {
         if (session.status === 'running' && session.pid) {
-          if (!ProcessUtils.isProcessRunning(session.pid)) {
-            // Process is dead, update status
-            const paths = this.getSessionPaths(session.id);
-            if (paths) {
-              logger.log(
-                chalk.yellow(
-                  `marking zombie process ${session.pid} as exited for session ${session.id}`
-                )
-              );
-              this.updateSessionStatus(session.id, 'exited', undefined, 1);
-              updatedSessions.push(session.id);
-            }
+          const commandRunning = ProcessUtils.isProcessRunning(session.pid);
+          const hostLost =
+            commandRunning &&
+            session.hostPid !== undefined &&
+            !ProcessUtils.isProcessRunning(session.hostPid);
+          if (commandRunning && !hostLost) continue;
+
+          const sessionInfo = this.loadSessionInfo(session.id);
+          if (!sessionInfo) continue;
+          if (hostLost) {
+            logger.warn(
+              chalk.yellow(
+                `host ${session.hostPid} of session ${session.id} died, marking it as exited; ` +
+                  `its command (PID ${session.pid}) is still running without a terminal`
+              )
+            );
+            sessionInfo.hostLost = true;
+          } else {
+            logger.log(
+              chalk.yellow(
+                `marking zombie process ${session.pid} as exited for session ${session.id}`
+              )
+            );
           }
+          sessionInfo.status = 'exited';
+          sessionInfo.exitCode = 1;
+          this.saveSessionInfo(session.id, sessionInfo);
+          this.finishRecording(session.id, 1);
+          updatedSessions.push(session.id);
         }
       }
 
@@ -366,9 +475,16 @@ export class SessionManager {
   }
 
   /**
-   * Get control path
+   * Get control path (the default root)
    */
   getControlPath(): string {
     return this.controlPath;
   }
+
+  /**
+   * Get all control roots
+   */
+  getControlRoots(): ControlRoots {
+    return this.roots;
+  }
 }
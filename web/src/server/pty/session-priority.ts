@@ -0,0 +1,126 @@
+/**
+ * Session priority - CPU and I/O scheduling of a session's processes
+ *
+ * A priority maps to a nice value, an ionice class (Linux) and, when the
+ * server is given a delegated cgroup v2 directory (--session-cgroup), the
+ * cpu.weight and io.weight of a cgroup per session. Processes the session
+ * starts later inherit all three. Raising the priority again (lower nice
+ * values) needs privileges the server usually lacks; what could not be
+ * applied is reported rather than thrown.
+ */
+
+import { execFile } from 'child_process';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { promisify } from 'util';
+import type { SessionPriority } from '../../shared/types.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('session-priority');
+
+const execFileAsync = promisify(execFile);
+
+export const SESSION_PRIORITIES: readonly SessionPriority[] = [
+  'high',
+  'normal',
+  'low',
+  'background',
+];
+
+interface PrioritySettings {
+  nice: number;
+  // ionice class: 2 best-effort (with level 0-7), 3 idle
+  ioClass: 2 | 3;
+  ioLevel?: number;
+  // cgroup v2 weights (1-10000, default 100)
+  weight: number;
+}
+
+const PRIORITY_SETTINGS: Record<SessionPriority, PrioritySettings> = {
+  high: { nice: -5, ioClass: 2, ioLevel: 0, weight: 400 },
+  normal: { nice: 0, ioClass: 2, ioLevel: 4, weight: 100 },
+  low: { nice: 10, ioClass: 2, ioLevel: 7, weight: 25 },
+  background: { nice: 19, ioClass: 3, weight: 1 },
+};
+
+export function isSessionPriority(value: unknown): value is SessionPriority {
+  return SESSION_PRIORITIES.includes(value as SessionPriority);
+}
+
+/**
+ * The cgroup of a session below the delegated directory
+ */
+export function sessionCgroupDir(cgroupRoot: string, sessionId: string): string {
+  return path.join(cgroupRoot, `session-${sessionId}`);
+}
+
+/**
+ * Apply a priority to processes of a session. Nice values and the cgroup are
+ * set before the first await, so a process spawned right before is covered
+ * before it can start children. Returns what could not be applied.
+ */
+export async function applySessionPriority(
+  pids: number[],
+  priority: SessionPriority,
+  cgroupDir?: string
+): Promise<string[]> {
+  const settings = PRIORITY_SETTINGS[priority];
+  const errors = new Set<string>();
+
+  for (const pid of pids) {
+    try {
+      os.setPriority(pid, settings.nice);
+    } catch (error) {
+      // Processes can exit while the tree is walked (a SystemError with the errno in info)
+      if ((error as { info?: { code?: string } }).info?.code !== 'ESRCH') {
+        errors.add(`nice ${settings.nice}: ${(error as Error).message}`);
+      }
+    }
+  }
+
+  if (cgroupDir) {
+    try {
+      fs.mkdirSync(cgroupDir, { recursive: true });
+      fs.writeFileSync(path.join(cgroupDir, 'cpu.weight'), String(settings.weight));
+      // io.weight needs the io controller, which not every parent enables
+      const ioWeightPath = path.join(cgroupDir, 'io.weight');
+      if (fs.existsSync(ioWeightPath)) {
+        fs.writeFileSync(ioWeightPath, `default ${settings.weight}`);
+      }
+      for (const pid of pids) {
+        fs.writeFileSync(path.join(cgroupDir, 'cgroup.procs'), String(pid));
+      }
+    } catch (error) {
+      errors.add(`cgroup: ${(error as Error).message}`);
+    }
+  }
+
+  if (process.platform === 'linux' && pids.length > 0) {
+    const args = ['-c', String(settings.ioClass)];
+    if (settings.ioLevel !== undefined) args.push('-n', String(settings.ioLevel));
+    try {
+      await execFileAsync('ionice', [...args, '-p', ...pids.map(String)], { timeout: 5000 });
+    } catch (error) {
+      errors.add(`ionice: ${(error as Error).message.split('\n')[0]}`);
+    }
+  }
+
+  if (errors.size > 0) {
+    logger.warn(`priority ${priority} partly applied to ${pids.join(', ')}: ${[...errors]}`);
+  }
+  return [...errors];
+}
+
+/**
+ * Remove the cgroup of a session that exited
+ */
+export function removeSessionCgroup(cgroupDir: string): void {
+  try {
+    fs.rmdirSync(cgroupDir);
+  } catch (error) {
+    if ((error as NodeJS.ErrnoException).code !== 'ENOENT') {
+      logger.debug(`failed to remove cgroup ${cgroupDir}:`, error);
+    }
+  }
+}
@@ -0,0 +1,277 @@
+/**
+ * Shell integration - Command boundaries from OSC 133 prompt marks
+ *
+ * Shells with FinalTerm/iTerm2-style integration mark their output with
+ * OSC 133: A (prompt starts), B (command line starts), C (command runs) and
+ * D;<exit code> (command finished). CommandTracker turns these into cast
+ * markers; readCommandHistory reads them back from a cast file to list the
+ * commands of a session with their timing and exit status. Casts without marks
+ * fall back to the lines typed into the session, with durations estimated from
+ * when output stopped.
+ */
+
+import * as fs from 'fs';
+import * as readline from 'readline';
+import { decodeCastLine } from '../utils/recording-crypto.js';
+
+export const PROMPT_MARKER = 'prompt';
+// Followed by a space and the command line
+export const COMMAND_MARKER = 'command';
+// Followed by a space and the exit code, if the shell reported one
+export const COMMAND_DONE_MARKER = 'command-done';
+
+// Longest command line kept
+const MAX_COMMAND_LENGTH = 1000;
+
+// CSI, OSC and other escape sequences
+const ESCAPE_SEQUENCE =
+  /\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[PX^_][^\x1b]*\x1b\\|[ -/]*[0-~])/g;
+
+export interface CommandEntry {
+  command: string;
+  // Seconds into the recording, for seeking
+  time: number;
+  startedAt: string;
+  durationMs: number | null;
+  exitCode: number | null;
+}
+
+export interface CommandHistory {
+  // 'marks' from shell integration, 'input' reconstructed from typed lines
+  source: 'marks' | 'input';
+  commands: CommandEntry[];
+}
+
+/**
+ * Rebuilds command lines from raw terminal input: Enter ends a line, Backspace
+ * deletes, Ctrl-C and Ctrl-U discard the line and escape sequences (cursor and
+ * history keys) are dropped, so edited lines are approximations
+ */
+class TypedLines {
+  private line = '';
+
+  /**
+   * Feed input and get the lines it completed
+   */
+  feed(input: string): string[] {
+    const completed: string[] = [];
+    for (const char of input.replace(ESCAPE_SEQUENCE, '')) {
+      if (char === '\r' || char === '\n') {
+        const line = this.line.trim();
+        this.line = '';
+        if (line) completed.push(line);
+      } else if (char === '\x7f' || char === '\b') {
+        this.line = this.line.slice(0, -1);
+      } else if (char === '\x03' || char === '\x15') {
+        this.line = '';
+      } else if (char >= ' ') {
+        this.line += char;
+      }
+    }
+    return completed;
+  }
+}
+
+/**
+ * Text without escape sequences
+ */
+export function stripEscapeSequences(text: string): string {
+  return text.replace(ESCAPE_SEQUENCE, '');
+}
+
+/**
+ * Text a line of output leaves on screen: without escape sequences, and only
+ * what follows the last carriage return (which redraws the line)
+ */
+export function visibleLineText(rawLine: string): string {
+  const redraws = stripEscapeSequences(rawLine).replace(/\r+$/, '').split('\r');
+  return redraws[redraws.length - 1];
+}
+
+/**
+ * Plain text of what the shell echoed while the command line was edited
+ */
+export function commandLineText(output: string): string {
+  let line = '';
+  for (const char of output.replace(ESCAPE_SEQUENCE, '')) {
+    if (char === '\b' || char === '\x7f') {
+      line = line.slice(0, -1);
+    } else if (char === '\r' || char === '\n') {
+      // Wrapped or continued lines
+      line += line.endsWith(' ') ? '' : ' ';
+    } else if (char >= ' ') {
+      line += char;
+    }
+  }
+  return line.trim();
+}
+
+export class CommandTracker {
+  private commandLine: string | null = null;
+  private running = false;
+
+  constructor(private writeMarker: (marker: string) => void) {}
+
+  /**
+   * Handle the data of an OSC 133 sequence (e.g. "A", "C", "D;0")
+   */
+  handleMark(data: string): void {
+    const [kind, ...params] = data.split(';');
+    switch (kind) {
+      case 'A':
+        this.writeMarker(PROMPT_MARKER);
+        break;
+      case 'B':
+        this.commandLine = '';
+        break;
+      case 'C': {
+        // kitty passes the command line itself
+        const param = params.find((value) => /^cmdline(_url)?=/.test(value));
+        const command = param ? decodeCommandLine(param) : commandLineText(this.commandLine ?? '');
+        this.commandLine = null;
+        this.running = true;
+        this.writeMarker(`${COMMAND_MARKER} ${command.slice(0, MAX_COMMAND_LENGTH)}`);
+        break;
+      }
+      case 'D': {
+        // Shells also send D for prompts without a command (e.g. Ctrl-C)
+        if (!this.running) break;
+        this.running = false;
+        const exitCode = /^-?\d+$/.test(params[0] ?? '') ? params[0] : '';
+        this.writeMarker(`${COMMAND_DONE_MARKER} ${exitCode}`.trim());
+        break;
+      }
+    }
+  }
+
+  /**
+   * Handle output between marks; what follows B is the echoed command line
+   */
+  handleText(text: string): void {
+    if (this.commandLine !== null && this.commandLine.length < MAX_COMMAND_LENGTH * 4) {
+      this.commandLine += text;
+    }
+  }
+}
+
+function decodeCommandLine(param: string): string {
+  const value = param.slice(param.indexOf('=') + 1);
+  if (!param.startsWith('cmdline_url=')) return value;
+  try {
+    return decodeURIComponent(value);
+  } catch {
+    return value;
+  }
+}
+
+/**
+ * List the commands recorded in a cast file
+ */
+export async function readCommandHistory(castPath: string): Promise<CommandHistory> {
+  const marked: CommandEntry[] = [];
+  const typed: CommandEntry[] = [];
+  const typedLines = new TypedLines();
+  let startTimestamp = 0;
+  let openMarked: CommandEntry | null = null;
+  let openTyped: CommandEntry | null = null;
+  let lastOutputTime = 0;
+
+  const entry = (command: string, time: number): CommandEntry => ({
+    command,
+    time,
+    startedAt: new Date(startTimestamp + time * 1000).toISOString(),
+    durationMs: null,
+    exitCode: null,
+  });
+
+  const lines = readline.createInterface({
+    input: fs.createReadStream(castPath, 'utf8'),
+    crlfDelay: Number.POSITIVE_INFINITY,
+  });
+  for await (const storedLine of lines) {
+    const line = decodeCastLine(storedLine);
+    if (!startTimestamp && line.startsWith('{')) {
+      try {
+        startTimestamp = (JSON.parse(line).timestamp ?? 0) * 1000;
+      } catch {
+        // Not a header
+      }
+      continue;
+    }
+
+    let event: unknown;
+    try {
+      event = JSON.parse(line);
+    } catch {
+      continue;
+    }
+    if (!Array.isArray(event) || typeof event[2] !== 'string') continue;
+    const [time, type, data] = event as [number, string, string];
+
+    if (type === 'o') {
+      lastOutputTime = time;
+    } else if (type === 'i') {
+      // A typed command ran until its output stopped before the next input
+      if (openTyped) {
+        openTyped.durationMs = Math.round(Math.max(0, lastOutputTime - openTyped.time) * 1000);
+        openTyped = null;
+      }
+      for (const command of typedLines.feed(data)) {
+        openTyped = entry(command, time);
+        typed.push(openTyped);
+      }
+    } else if (type === 'm' && data.startsWith(`${COMMAND_MARKER} `)) {
+      openMarked = entry(data.slice(COMMAND_MARKER.length + 1), time);
+      marked.push(openMarked);
+    } else if (type === 'm' && openMarked && data.split(' ')[0] === COMMAND_DONE_MARKER) {
+      const exitCode = data.slice(COMMAND_DONE_MARKER.length + 1);
+      openMarked.durationMs = Math.round((time - openMarked.time) * 1000);
+      openMarked.exitCode = exitCode ? Number(exitCode) : null;
+      openMarked = null;
+    }
+  }
+
+  return marked.length > 0
+    ? { source: 'marks', commands: marked }
+    : { source: 'input', commands: typed };
+}
+
+/**
+ * Quote a string for a POSIX shell
+ */
+function shellQuote(value: string): string {
+  return /^[\w@%+=:,./-]+$/.test(value) ? value : `'${value.replace(/'/g, `'\\''`)}'`;
+}
+
+function formatDuration(durationMs: number): string {
+  return durationMs < 1000 ? `${durationMs}ms` : `${(durationMs / 1000).toFixed(1)}s`;
+}
+
+/**
+ * Turn a session's command history into a shell script that runs the same
+ * commands from the session's starting directory, each preceded by a comment
+ * with when it ran, how long it took and how it exited
+ */
+export function commandScript(
+  session: { id: string; name: string; workingDir: string; startedAt: string },
+  history: CommandHistory
+): string {
+  const lines = [
+    '#!/bin/sh',
+    `# Commands of session "${session.name.replace(/[\r\n]/g, ' ')}" (${session.id})`,
+    `# Started ${session.startedAt}`,
+    history.source === 'marks'
+      ? '# Reconstructed from shell integration marks'
+      : '# Reconstructed from typed input; edited lines may differ from what ran',
+    '',
+    `cd ${shellQuote(session.workingDir)} || exit 1`,
+  ];
+
+  for (const entry of history.commands) {
+    const details = [entry.startedAt];
+    if (entry.durationMs !== null) details.push(formatDuration(entry.durationMs));
+    if (entry.exitCode !== null) details.push(`exit ${entry.exitCode}`);
+    lines.push('', `# ${details.join(', ')}`, entry.command);
+  }
+  return `${lines.join('\n')}\n`;
+}
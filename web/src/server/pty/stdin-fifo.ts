@@ -0,0 +1,44 @@
+/**
+ * Stdin FIFO - Input written to a session's `stdin` FIFO
+ *
+ * Tools compatible with tty-fwd send input by writing to `<session>/stdin`.
+ * The FIFO is opened read-write and non-blocking: holding a write end of our
+ * own, the reader never sees EOF when writers come and go, so no reopen loop
+ * is needed and no write is lost between two opens. Reads run on the event
+ * loop through a pipe handle, in the order the bytes were written.
+ */
+
+import * as fs from 'fs';
+import * as net from 'net';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('stdin-fifo');
+
+/**
+ * Read the FIFO until the returned socket is destroyed; null if the path is
+ * not a FIFO (platforms without mkfifo get a regular file nobody reads)
+ */
+export function openStdinFifo(
+  fifoPath: string,
+  onData: (data: Buffer) => void
+): net.Socket | null {
+  let fd: number;
+  try {
+    if (!fs.statSync(fifoPath).isFIFO()) {
+      return null;
+    }
+    fd = fs.openSync(fifoPath, fs.constants.O_RDWR | fs.constants.O_NONBLOCK);
+  } catch (error) {
+    logger.warn(`failed to open stdin FIFO ${fifoPath}:`, error);
+    return null;
+  }
+
+  const reader = new net.Socket({ fd, readable: true, writable: false });
+  reader.on('data', onData);
+  reader.on('error', (error) => {
+    logger.warn(`failed to read stdin FIFO ${fifoPath}:`, error);
+  });
+  // Input from the FIFO alone does not keep the process alive
+  reader.unref();
+  return reader;
+}
@@ -0,0 +1,70 @@
+/**
+ * TerminalModeTracker - Follows the keyboard-related terminal modes set by the
+ * program running in a PTY
+ *
+ * Full-screen programs switch the terminal into application cursor mode
+ * (DECCKM, CSI ? 1 h) and application keypad mode (DECKPAM, ESC =) and then
+ * expect cursor and keypad keys as SS3 sequences (ESC O A) instead of CSI
+ * sequences (ESC [ A). Input sent through the API is translated with the
+ * current modes so those programs receive the sequences they expect.
+ */
+
+import type { KeyEncodingModes } from '../../shared/keymap.js';
+
+// DECSET/DECRST (CSI ? Pm h/l), DECKPAM (ESC =), DECKPNM (ESC >), RIS (ESC c), DECSTR (CSI ! p)
+const MODE_SEQUENCE = /\x1b(?:\[\?([\d;]*)([hl])|([=>])|c|\[!p)/g;
+
+// Longest sequence prefix that may be cut off at the end of a chunk
+const MAX_PARTIAL_LENGTH = 32;
+
+export class TerminalModeTracker {
+  private applicationCursor = false;
+  private applicationKeypad = false;
+  private pending = '';
+
+  /**
+   * Scan PTY output for mode changes
+   */
+  feed(data: string): void {
+    // Fast path: no escape sequences in this chunk or a pending partial one
+    if (!this.pending && !data.includes('\x1b')) {
+      return;
+    }
+
+    const text = this.pending + data;
+    let consumed = 0;
+
+    MODE_SEQUENCE.lastIndex = 0;
+    for (let match = MODE_SEQUENCE.exec(text); match; match = MODE_SEQUENCE.exec(text)) {
+      const [, params, setOrReset, keypad] = match;
+      if (setOrReset) {
+        const enabled = setOrReset === 'h';
+        for (const param of params.split(';')) {
+          if (param === '1') this.applicationCursor = enabled;
+          if (param === '66') this.applicationKeypad = enabled; // DECNKM
+        }
+      } else if (keypad) {
+        this.applicationKeypad = keypad === '=';
+      } else {
+        // Full (RIS) or soft (DECSTR) reset
+        this.applicationCursor = false;
+        this.applicationKeypad = false;
+      }
+      consumed = MODE_SEQUENCE.lastIndex;
+    }
+
+    // Keep a possibly incomplete sequence for the next chunk
+    const lastEscape = text.lastIndexOf('\x1b');
+    this.pending =
+      lastEscape >= consumed && text.length - lastEscape < MAX_PARTIAL_LENGTH
+        ? text.slice(lastEscape)
+        : '';
+  }
+
+  getModes(): KeyEncodingModes {
+    return {
+      applicationCursor: this.applicationCursor,
+      applicationKeypad: this.applicationKeypad,
+    };
+  }
+}
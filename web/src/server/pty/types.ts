@@ -8,8 +8,14 @@ import type * as fs from 'fs';
 import type * as net from 'net';
 import type { IPty } from 'node-pty';
 import type { SessionInfo } from '../../shared/types.js';
+import type { InputSource } from '../utils/input-source.js';
 import type { WriteQueue } from '../utils/write-queue.js';
 import type { AsciinemaWriter } from './asciinema-writer.js';
+import type { InlineImageExtractor } from './inline-images.js';
+import type { OscParser } from './osc-parser.js';
+import type { OutputBroadcaster } from './output-broadcaster.js';
+import type { SessionCounters } from './session-counters.js';
+import type { TerminalModeTracker } from './terminal-modes.js';
 
 export interface AsciinemaHeader {
   version: number;
@@ -31,6 +37,8 @@ export interface AsciinemaTheme {
 
 export interface ControlMessage {
   cmd: string;
+  // Set to get an acknowledgement (see control-protocol.ts)
+  id?: string;
   [key: string]: unknown;
 }
 
@@ -49,10 +57,50 @@ export interface ResetSizeControlMessage extends ControlMessage {
   cmd: 'reset-size';
 }
 
+export interface SignalControlMessage extends ControlMessage {
+  cmd: 'signal';
+  signal: string | number;
+}
+
+// Environment changes for the commands the session runs next
+export interface EnvControlMessage extends ControlMessage {
+  cmd: 'env';
+  set?: Record<string, string>;
+  unset?: string[];
+}
+
+export interface TitleControlMessage extends ControlMessage {
+  cmd: 'title';
+  title: string;
+}
+
+// Recorded metadata of the session; null clears a field or custom field
+export interface MetadataControlMessage extends ControlMessage {
+  cmd: 'metadata';
+  currentWorkingDir?: string;
+  description?: string | null;
+  fields?: Record<string, string | null>;
+}
+
+// Acknowledged once pending output is in the stream file
+export interface FlushControlMessage extends ControlMessage {
+  cmd: 'flush';
+}
+
+// Agent metadata of the session; null clears a field
+export interface AgentControlMessage extends ControlMessage {
+  cmd: 'agent';
+  agentType?: string | null;
+  taskDescription?: string | null;
+  tokensUsed?: number | null;
+}
+
 export type AsciinemaEvent = {
   time: number;
   type: 'o' | 'i' | 'r' | 'm';
   data: string;
+  // Who sent an `i` event, written as a fourth element
+  source?: InputSource;
 };
 
 // Internal session state for PtyManager
@@ -61,6 +109,17 @@ export interface PtySession {
   sessionInfo: SessionInfo;
   ptyProcess?: IPty;
   asciinemaWriter?: AsciinemaWriter;
+  outputBroadcaster?: OutputBroadcaster;
+  // Keyboard modes (DECCKM/DECKPAM) set by the running program
+  modeTracker?: TerminalModeTracker;
+  // Bytes, lines and output rate
+  counters?: SessionCounters;
+  // Shell state reports (OSC 7 working directory)
+  oscParser?: OscParser;
+  // Takes inline images out of the recorded output
+  imageExtractor?: InlineImageExtractor;
+  // Images stored so far and their total size
+  inlineImages?: { count: number; bytes: number };
   controlDir: string;
   stdoutPath: string;
   stdinPath: string;
@@ -69,9 +128,13 @@ export interface PtySession {
   startTime: Date;
   // Optional fields for resource cleanup
   inputSocketServer?: net.Server;
+  // Reader of the stdin FIFO
+  stdinReader?: net.Socket;
   controlWatcher?: fs.FSWatcher;
   stdinHandler?: (data: string) => void;
   stdoutQueue?: WriteQueue;
+  // Init script still running; ends with the init-done marker
+  pendingInit?: { settleTimer?: NodeJS.Timeout; deadline: NodeJS.Timeout };
 }
 
 export class PtyError extends Error {
@@ -85,6 +148,36 @@ export class PtyError extends Error {
   }
 }
 
+/**
+ * Thrown when creating a session would exceed the global or per-user session limit
+ */
+export class SessionLimitError extends PtyError {
+  constructor(
+    public readonly scope: 'server' | 'user',
+    public readonly limit: number,
+    public readonly current: number
+  ) {
+    super(
+      scope === 'server'
+        ? `Session limit reached (${current}/${limit} sessions running)`
+        : `Per-user session limit reached (${current}/${limit} sessions running)`,
+      'SESSION_LIMIT_REACHED'
+    );
+    this.name = 'SessionLimitError';
+  }
+
+  // Body of the 429 response sent to API clients
+  toResponse() {
+    return {
+      error: this.message,
+      code: this.code,
+      scope: this.scope,
+      limit: this.limit,
+      current: this.current,
+    };
+  }
+}
+
 // Utility type for session creation result
 export interface SessionCreationResult {
   sessionId: string;
@@ -0,0 +1,227 @@
+import { Router } from 'express';
+import * as fs from 'fs';
+import { createAdminMiddleware } from '../middleware/auth.js';
+import type { PtyManager } from '../pty/index.js';
+import type { RemoteRegistry, RemoteServer } from '../services/remote-registry.js';
+import {
+  type RuntimeConfig,
+  RuntimeConfigError,
+  type RuntimeSettingsPatch,
+} from '../services/runtime-config.js';
+import { type SelfUpdater, UpdateError } from '../services/self-updater.js';
+import { sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+import { getRecordingCipher, rekeyRecording } from '../utils/recording-crypto.js';
+import { requestIdHeaders } from '../utils/request-context.js';
+import { tracedFetch } from '../utils/tracing.js';
+
+const logger = createLogger('admin');
+
+interface AdminRoutesConfig {
+  runtimeConfig: RuntimeConfig;
+  adminUsers: string[];
+  // Read-only startup configuration (must not contain secrets)
+  getStaticConfig: () => Record<string, unknown>;
+  selfUpdater: SelfUpdater;
+  ptyManager: PtyManager;
+  // HQ mode: update registered remotes through this server
+  remoteRegistry: RemoteRegistry | null;
+}
+
+interface UpdateBody {
+  url: string;
+  sha256: string;
+  restart: boolean;
+  // Restart even though sessions are running (they end with the old process)
+  force: boolean;
+}
+
+/**
+ * Validate an update request body. Returns an error message or the parsed body.
+ */
+function parseUpdateBody(body: Record<string, unknown>): UpdateBody | string {
+  const { url, sha256, restart, force } = body;
+  if (typeof url !== 'string' || !/^https?:\/\//.test(url)) {
+    return 'url must be an http(s) URL';
+  }
+  if (typeof sha256 !== 'string' || !/^[0-9a-fA-F]{64}$/.test(sha256)) {
+    return 'sha256 must be a hex SHA-256 digest';
+  }
+  if (restart !== undefined && typeof restart !== 'boolean') {
+    return 'restart must be a boolean';
+  }
+  if (force !== undefined && typeof force !== 'boolean') {
+    return 'force must be a boolean';
+  }
+  return { url, sha256, restart: restart ?? true, force: force ?? false };
+}
+
+export function createAdminRoutes(config: AdminRoutesConfig): Router {
+  const router = Router();
+  const { runtimeConfig, adminUsers, getStaticConfig, selfUpdater, ptyManager, remoteRegistry } =
+    config;
+
+  router.use('/admin', createAdminMiddleware(adminUsers));
+
+  // Get the current effective configuration
+  router.get('/admin/config', (_req, res) => {
+    res.json({
+      runtime: runtimeConfig.get(),
+      static: getStaticConfig(),
+    });
+  });
+
+  // Update a safe subset of runtime settings
+  router.patch('/admin/config', (req, res) => {
+    try {
+      const updated = runtimeConfig.update(req.body as RuntimeSettingsPatch);
+      res.json({ runtime: updated });
+    } catch (error) {
+      if (error instanceof RuntimeConfigError) {
+        logger.warn(`rejected runtime config update: ${error.message}`);
+        return res.status(400).json({ error: error.message });
+      }
+      logger.error('failed to update runtime config:', error);
+      res.status(500).json({ error: 'Failed to update configuration' });
+    }
+  });
+
+  // Status of the last self-update
+  router.get('/admin/update', (_req, res) => {
+    res.json({ supported: selfUpdater.isSupported(), ...selfUpdater.getStatus() });
+  });
+
+  // Download, verify and install a new executable, then restart through a socket handoff
+  router.post('/admin/update', async (req, res) => {
+    const body = parseUpdateBody(req.body);
+    if (typeof body === 'string') {
+      return res.status(400).json({ error: body });
+    }
+
+    const running = ptyManager.listSessions().filter((s) => s.status === 'running').length;
+    if (body.restart && running > 0 && !body.force) {
+      return res.status(409).json({
+        error: `${running} sessions are running and would end with the restart`,
+        details: 'Pass force: true to restart anyway',
+      });
+    }
+
+    try {
+      const status = await selfUpdater.update(body);
+      res.json({ ...status, restarting: body.restart });
+    } catch (error) {
+      if (error instanceof UpdateError) {
+        logger.warn(`update rejected: ${error.message}`);
+        return res.status(error.status).json({ error: error.message });
+      }
+      logger.error('update failed:', error);
+      res.status(500).json({
+        error: 'Update failed',
+        details: error instanceof Error ? error.message : 'Unknown error',
+      });
+    }
+  });
+
+  // Re-encrypt the recordings of finished sessions with the current recording key,
+  // so rotated-out keys can be removed from the keyring afterwards
+  router.post('/admin/recordings/rekey', async (_req, res) => {
+    const cipher = getRecordingCipher();
+    if (!cipher) {
+      return res.status(409).json({ error: 'Recording encryption is not enabled' });
+    }
+
+    let rekeyed = 0;
+    let unchanged = 0;
+    let skipped = 0;
+    const failed: string[] = [];
+    for (const session of ptyManager.listSessions()) {
+      const castPath = ptyManager.getSessionPaths(session.id)?.stdoutPath;
+      // Running recordings are still appended to; they are rekeyed once finished
+      if (session.status === 'running' || !castPath || !fs.existsSync(castPath)) {
+        skipped++;
+        continue;
+      }
+      try {
+        if (await rekeyRecording(castPath)) {
+          rekeyed++;
+        } else {
+          unchanged++;
+        }
+      } catch (error) {
+        logger.error(`failed to rekey recording of session ${session.id}:`, error);
+        failed.push(session.id);
+      }
+    }
+    logger.log(`rekeyed ${rekeyed} recordings with key ${cipher.currentKeyId}`);
+    res.json({ keyId: cipher.currentKeyId, rekeyed, unchanged, skipped, failed });
+  });
+
+  const updateRemote = async (remote: RemoteServer, body: UpdateBody) => {
+    try {
+      const response = await tracedFetch(`${remote.url}/api/admin/update`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${remote.token}`,
+          ...requestIdHeaders(),
+        },
+        body: JSON.stringify(body),
+        // The remote downloads the executable before it answers
+        signal: AbortSignal.timeout(6 * 60 * 1000),
+      });
+      const result = await response.json().catch(() => ({}));
+      return { remoteId: remote.id, remoteName: remote.name, status: response.status, result };
+    } catch (error) {
+      logger.error(`failed to update remote ${remote.name}:`, error);
+      return {
+        remoteId: remote.id,
+        remoteName: remote.name,
+        status: 502,
+        result: { error: 'Failed to reach remote server' },
+      };
+    }
+  };
+
+  // HQ mode: update one remote
+  router.post('/admin/remotes/:remoteId/update', async (req, res) => {
+    if (!remoteRegistry) {
+      return sendError(res, 'NOT_HQ_MODE');
+    }
+    const remote = remoteRegistry.getRemote(req.params.remoteId);
+    if (!remote) {
+      return sendError(res, 'REMOTE_NOT_FOUND');
+    }
+    const body = parseUpdateBody(req.body);
+    if (typeof body === 'string') {
+      return res.status(400).json({ error: body });
+    }
+
+    logger.log(`updating remote ${remote.name} from ${body.url}`);
+    const { status, result } = await updateRemote(remote, body);
+    res.status(status).json(result);
+  });
+
+  // HQ mode: update all (or the listed) remotes in parallel
+  router.post('/admin/remotes/update', async (req, res) => {
+    if (!remoteRegistry) {
+      return sendError(res, 'NOT_HQ_MODE');
+    }
+    const body = parseUpdateBody(req.body);
+    if (typeof body === 'string') {
+      return res.status(400).json({ error: body });
+    }
+    const { remoteIds } = req.body;
+    if (remoteIds !== undefined && !Array.isArray(remoteIds)) {
+      return res.status(400).json({ error: 'remoteIds must be an array' });
+    }
+
+    const remotes = remoteRegistry
+      .getRemotes()
+      .filter((remote) => !remoteIds || remoteIds.includes(remote.id));
+    logger.log(`updating ${remotes.length} remotes from ${body.url}`);
+    const results = await Promise.all(remotes.map((remote) => updateRemote(remote, body)));
+    res.json({ results });
+  });
+
+  return router;
+}
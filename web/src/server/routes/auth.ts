@@ -1,17 +1,70 @@
-import { Router } from 'express';
+import { type Response, Router } from 'express';
 import { promisify } from 'util';
-import type { AuthService } from '../services/auth-service.js';
+import type { AuthResult, AuthService } from '../services/auth-service.js';
+import {
+  type CompletedLogin,
+  SecondFactorError,
+  type SecondFactorService,
+} from '../services/second-factor.js';
+import { sendError } from '../utils/api-error.js';
+import { SESSION_COOKIE } from '../utils/cookies.js';
 
 interface AuthRoutesConfig {
   authService: AuthService;
+  secondFactor: SecondFactorService;
   enableSSHKeys?: boolean;
   disallowUserPassword?: boolean;
   noAuth?: boolean;
+  // Single sign-on is configured (see routes/oidc.ts)
+  oidc?: boolean;
+  // Single sign-on is the only way to log in
+  oidcOnly?: boolean;
+}
+
+/**
+ * Send the result of a failed second factor step
+ */
+export function sendSecondFactorError(res: Response, error: unknown): Response {
+  if (error instanceof SecondFactorError) {
+    return error.status === 401
+      ? sendError(res, 'SECOND_FACTOR_FAILED', error.message)
+      : res.status(error.status).json({ error: error.message });
+  }
+  console.error('Error verifying second factor:', error);
+  return res.status(500).json({ error: 'Second factor verification failed' });
 }
 
 export function createAuthRoutes(config: AuthRoutesConfig): Router {
   const router = Router();
-  const { authService } = config;
+  const { authService, secondFactor } = config;
+
+  // Password and SSH key logins are turned off in favour of single sign-on
+  router.use(['/challenge', '/ssh-key', '/password'], (_req, res, next) => {
+    if (config.oidcOnly) {
+      return sendError(res, 'FORBIDDEN', 'Sign in with single sign-on');
+    }
+    next();
+  });
+
+  // Users with a second factor get a pending login to confirm instead of a token
+  const sendSecondFactorRequired = (res: Response, result: AuthResult) =>
+    res.json({
+      success: false,
+      secondFactorRequired: true,
+      userId: result.userId,
+      ...result.secondFactor,
+      error: 'Second factor required',
+    });
+
+  const sendCompletedLogin = (res: Response, login: CompletedLogin) => {
+    const result = authService.completeSecondFactor(login.userId);
+    res.json({
+      success: true,
+      token: result.token,
+      userId: result.userId,
+      authMethod: login.authMethod,
+    });
+  };
 
   /**
    * Create authentication challenge for SSH key auth
@@ -65,7 +118,9 @@ export function createAuthRoutes(config: AuthRoutesConfig): Router {
         signature,
       });
 
-      if (result.success) {
+      if (result.success && result.secondFactor) {
+        sendSecondFactorRequired(res, result);
+      } else if (result.success) {
         res.json({
           success: true,
           token: result.token,
@@ -76,6 +131,7 @@ export function createAuthRoutes(config: AuthRoutesConfig): Router {
         res.status(401).json({
           success: false,
           error: result.error,
+          code: 'INVALID_CREDENTIALS',
         });
       }
     } catch (error) {
@@ -100,7 +156,9 @@ export function createAuthRoutes(config: AuthRoutesConfig): Router {
 
       const result = await authService.authenticateWithPassword(userId, password);
 
-      if (result.success) {
+      if (result.success && result.secondFactor) {
+        sendSecondFactorRequired(res, result);
+      } else if (result.success) {
         res.json({
           success: true,
           token: result.token,
@@ -111,6 +169,7 @@ export function createAuthRoutes(config: AuthRoutesConfig): Router {
         res.status(401).json({
           success: false,
           error: result.error,
+          code: 'INVALID_CREDENTIALS',
         });
       }
     } catch (error) {
@@ -119,6 +178,54 @@ export function createAuthRoutes(config: AuthRoutesConfig): Router {
     }
   });
 
+  /**
+   * Complete a login with an authenticator app code
+   * POST /api/auth/second-factor/totp
+   */
+  router.post('/second-factor/totp', (req, res) => {
+    const { pendingToken, code } = req.body;
+    if (typeof pendingToken !== 'string' || typeof code !== 'string') {
+      return sendError(res, 'INVALID_REQUEST', 'Pending token and code are required');
+    }
+    try {
+      sendCompletedLogin(res, secondFactor.verifyTotpLogin(pendingToken, code));
+    } catch (error) {
+      sendSecondFactorError(res, error);
+    }
+  });
+
+  /**
+   * Options for confirming a login with a security key (navigator.credentials.get)
+   * POST /api/auth/second-factor/webauthn/options
+   */
+  router.post('/second-factor/webauthn/options', (req, res) => {
+    const { pendingToken } = req.body;
+    if (typeof pendingToken !== 'string') {
+      return sendError(res, 'INVALID_REQUEST', 'Pending token is required');
+    }
+    try {
+      res.json(secondFactor.webAuthnLoginOptions(pendingToken, req.headers.origin));
+    } catch (error) {
+      sendSecondFactorError(res, error);
+    }
+  });
+
+  /**
+   * Complete a login with a security key assertion
+   * POST /api/auth/second-factor/webauthn
+   */
+  router.post('/second-factor/webauthn', (req, res) => {
+    const { pendingToken, credential } = req.body;
+    if (typeof pendingToken !== 'string' || !credential || typeof credential !== 'object') {
+      return sendError(res, 'INVALID_REQUEST', 'Pending token and credential are required');
+    }
+    try {
+      sendCompletedLogin(res, secondFactor.verifyWebAuthnLogin(pendingToken, credential));
+    } catch (error) {
+      sendSecondFactorError(res, error);
+    }
+  });
+
   /**
    * Verify current authentication status
    * GET /api/auth/verify
@@ -143,6 +250,7 @@ export function createAuthRoutes(config: AuthRoutesConfig): Router {
         res.status(401).json({
           valid: false,
           error: 'Invalid or expired token',
+          code: 'INVALID_TOKEN',
         });
       }
     } catch (error) {
@@ -175,6 +283,8 @@ export function createAuthRoutes(config: AuthRoutesConfig): Router {
         enableSSHKeys: config.enableSSHKeys || false,
         disallowUserPassword: config.disallowUserPassword || false,
         noAuth: config.noAuth || false,
+        oidc: config.oidc || false,
+        oidcOnly: config.oidcOnly || false,
       });
     } catch (error) {
       console.error('Error getting auth config:', error);
@@ -283,6 +393,7 @@ export function createAuthRoutes(config: AuthRoutesConfig): Router {
   router.post('/logout', (_req, res) => {
     // For JWT tokens, logout is primarily client-side (remove token)
     // In the future, we could implement token blacklisting
+    res.clearCookie(SESSION_COOKIE, { path: '/' });
     res.json({ success: true, message: 'Logged out successfully' });
   });
 
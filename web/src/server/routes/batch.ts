@@ -0,0 +1,171 @@
+import { Router } from 'express';
+import type { AuthenticatedRequest } from '../middleware/auth.js';
+import type { PtyManager } from '../pty/index.js';
+import type { ActivityMonitor } from '../services/activity-monitor.js';
+import { hasScope, requiredBatchScope } from '../services/api-tokens.js';
+import type { RemoteRegistry } from '../services/remote-registry.js';
+import type { Scheduler } from '../services/scheduler.js';
+import type { SessionGroupStore } from '../services/session-groups.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('batch');
+
+// Upper bound for queries in one batch
+const MAX_QUERIES = 20;
+
+interface BatchRoutesConfig {
+  ptyManager: PtyManager;
+  activityMonitor: ActivityMonitor;
+  remoteRegistry: RemoteRegistry | null;
+  groupStore: SessionGroupStore;
+  scheduler: Scheduler;
+}
+
+interface BatchQuery {
+  resource: string;
+  params?: Record<string, unknown>;
+  // Keys to keep on each returned object (all keys when omitted)
+  fields?: string[];
+  // Related resources to attach (e.g. "activity" on sessions)
+  include?: string[];
+}
+
+class BatchQueryError extends Error {}
+
+type Resolver = (query: BatchQuery) => unknown;
+
+function pickFields(value: unknown, fields: string[] | undefined): unknown {
+  if (!fields || value === null || typeof value !== 'object') {
+    return value;
+  }
+  if (Array.isArray(value)) {
+    return value.map((item) => pickFields(item, fields));
+  }
+  const record = value as Record<string, unknown>;
+  return Object.fromEntries(fields.filter((key) => key in record).map((key) => [key, record[key]]));
+}
+
+function isBatchQuery(value: unknown): value is BatchQuery {
+  if (!value || typeof value !== 'object') return false;
+  const query = value as Record<string, unknown>;
+  const isStringArray = (item: unknown) =>
+    Array.isArray(item) && item.every((entry) => typeof entry === 'string');
+  return (
+    typeof query.resource === 'string' &&
+    (query.params === undefined || (typeof query.params === 'object' && query.params !== null)) &&
+    (query.fields === undefined || isStringArray(query.fields)) &&
+    (query.include === undefined || isStringArray(query.include))
+  );
+}
+
+/**
+ * Batch query endpoint for dashboards: several named queries over the local
+ * managers answered in one round trip, each with optional field selection and
+ * related resources.
+ */
+export function createBatchRoutes(config: BatchRoutesConfig): Router {
+  const router = Router();
+  const { ptyManager, activityMonitor, remoteRegistry, groupStore, scheduler } = config;
+
+  const withActivity = <T extends { id: string }>(session: T, include?: string[]) =>
+    include?.includes('activity')
+      ? { ...session, activity: activityMonitor.getSessionActivityStatus(session.id) }
+      : session;
+
+  const resolvers: Record<string, Resolver> = {
+    sessions: ({ params, include }) => {
+      const status = params?.status;
+      return ptyManager
+        .listSessions()
+        .filter((session) => status === undefined || session.status === status)
+        .map((session) => withActivity(session, include));
+    },
+    session: ({ params, include }) => {
+      if (typeof params?.id !== 'string') {
+        throw new BatchQueryError('params.id is required');
+      }
+      const session = ptyManager.getSession(params.id);
+      if (!session) {
+        throw new BatchQueryError('Session not found');
+      }
+      return withActivity(session, include);
+    },
+    activity: () => activityMonitor.getActivityStatus(),
+    remotes: () =>
+      (remoteRegistry?.getRemotes() ?? []).map(({ token: _token, ...remote }) => ({
+        ...remote,
+        sessionIds: Array.from(remote.sessionIds),
+      })),
+    groups: ({ include }) =>
+      groupStore.list().map((group) =>
+        include?.includes('sessions')
+          ? {
+              ...group,
+              sessions: group.sessionIds.map((sessionId) => ptyManager.getSession(sessionId)),
+            }
+          : group
+      ),
+    schedules: ({ include }) =>
+      scheduler.list().map((job) =>
+        include?.includes('runs') ? { ...job, runs: scheduler.getRuns(job.id) } : job
+      ),
+    stats: () => {
+      const byStatus: Record<string, number> = {};
+      for (const session of ptyManager.listSessions()) {
+        byStatus[session.status] = (byStatus[session.status] ?? 0) + 1;
+      }
+      return {
+        sessions: byStatus,
+        pty: ptyManager.getStats(),
+        remotes: remoteRegistry?.getRemotes().length ?? 0,
+        uptime: process.uptime(),
+      };
+    },
+  };
+
+  router.post('/batch', (req: AuthenticatedRequest, res) => {
+    const { queries } = req.body;
+    if (!queries || typeof queries !== 'object' || Array.isArray(queries)) {
+      return res.status(400).json({ error: 'queries must be an object of named queries' });
+    }
+
+    const entries = Object.entries(queries as Record<string, unknown>);
+    if (entries.length > MAX_QUERIES) {
+      return res.status(400).json({ error: `At most ${MAX_QUERIES} queries per batch` });
+    }
+
+    const results: Record<string, { data?: unknown; error?: string }> = {};
+    for (const [name, query] of entries) {
+      if (!isBatchQuery(query)) {
+        results[name] = { error: 'Invalid query' };
+        continue;
+      }
+      const resolver = Object.prototype.hasOwnProperty.call(resolvers, query.resource)
+        ? resolvers[query.resource]
+        : undefined;
+      if (!resolver) {
+        results[name] = { error: `Unknown resource: ${query.resource}` };
+        continue;
+      }
+      const scope = requiredBatchScope(query.resource);
+      if (req.authMethod === 'api-token' && !hasScope(req.tokenScopes ?? [], scope)) {
+        results[name] = { error: `API token lacks the ${scope} scope` };
+        continue;
+      }
+      try {
+        results[name] = { data: pickFields(resolver(query), query.fields) };
+      } catch (error) {
+        if (error instanceof BatchQueryError) {
+          results[name] = { error: error.message };
+        } else {
+          logger.error(`batch query ${name} (${query.resource}) failed:`, error);
+          results[name] = { error: 'Query failed' };
+        }
+      }
+    }
+
+    res.json({ results });
+  });
+
+  return router;
+}
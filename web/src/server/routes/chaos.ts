@@ -0,0 +1,60 @@
+import { Router } from 'express';
+import { type AuthenticatedRequest, isAdminRequest } from '../middleware/auth.js';
+import { sendError } from '../utils/api-error.js';
+import { type FaultInjector, FaultSpecError, parseFaultSpec } from '../utils/fault-injection.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('chaos');
+
+interface ChaosRoutesConfig {
+  injector: FaultInjector;
+  adminUsers: string[];
+}
+
+/**
+ * --chaos mode: lets integration tests change the injected faults while HQ runs
+ */
+export function createChaosRoutes(config: ChaosRoutesConfig): Router {
+  const router = Router();
+  const { injector, adminUsers } = config;
+
+  const state = () => ({ config: injector.getConfig(), stats: injector.getStats() });
+
+  router.use('/chaos', (req: AuthenticatedRequest, res, next) => {
+    if (!isAdminRequest(req, adminUsers)) {
+      return sendError(res, 'ADMIN_REQUIRED');
+    }
+    next();
+  });
+
+  router.get('/chaos', (_req, res) => {
+    res.json(state());
+  });
+
+  // Replace the faults with a spec (`latency=200,drop=0.5`); stats start over
+  router.put('/chaos', (req, res) => {
+    const { spec } = req.body;
+    if (typeof spec !== 'string') {
+      return sendError(res, 'INVALID_REQUEST', 'spec must be a string');
+    }
+    try {
+      injector.configure(parseFaultSpec(spec));
+    } catch (error) {
+      if (error instanceof FaultSpecError) {
+        return sendError(res, 'INVALID_REQUEST', `Invalid fault spec: ${error.message}`);
+      }
+      throw error;
+    }
+    logger.warn(`injected faults changed to: ${spec || 'none'}`);
+    res.json(state());
+  });
+
+  // Stop injecting faults (the routes stay available)
+  router.delete('/chaos', (_req, res) => {
+    injector.configure({});
+    logger.warn('injected faults cleared');
+    res.json(state());
+  });
+
+  return router;
+}
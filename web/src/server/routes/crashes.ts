@@ -0,0 +1,48 @@
+import { Router } from 'express';
+import { type AuthenticatedRequest, isAdminRequest } from '../middleware/auth.js';
+import type { CrashReporter } from '../services/crash-reporter.js';
+import { sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('crashes');
+
+interface CrashRoutesConfig {
+  reporter: CrashReporter;
+  adminUsers: string[];
+}
+
+/**
+ * Crashes of per-session tasks. Admin only, as stacks show server internals.
+ */
+export function createCrashRoutes(config: CrashRoutesConfig): Router {
+  const router = Router();
+  const { reporter, adminUsers } = config;
+
+  router.use('/crashes', (req: AuthenticatedRequest, res, next) => {
+    if (!isAdminRequest(req, adminUsers)) {
+      return sendError(res, 'ADMIN_REQUIRED');
+    }
+    next();
+  });
+
+  // Most recent first, optionally of one session (?sessionId=)
+  router.get('/crashes', async (req, res) => {
+    const sessionId = typeof req.query.sessionId === 'string' ? req.query.sessionId : undefined;
+    try {
+      res.json({ crashes: await reporter.list(sessionId) });
+    } catch (error) {
+      logger.error('failed to list crash reports:', error);
+      sendError(res, 'INTERNAL_ERROR', 'Failed to list crash reports');
+    }
+  });
+
+  router.get('/crashes/:id', async (req, res) => {
+    const report = await reporter.get(req.params.id);
+    if (!report) {
+      return sendError(res, 'NOT_FOUND', 'Crash report not found');
+    }
+    res.json(report);
+  });
+
+  return router;
+}
@@ -0,0 +1,136 @@
+import * as crypto from 'crypto';
+import { type NextFunction, type Request, type Response, Router } from 'express';
+import * as inspector from 'inspector';
+import { monitorEventLoopDelay } from 'perf_hooks';
+import * as v8 from 'v8';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('debug');
+
+const MAX_PROFILE_SECONDS = 60;
+
+interface DebugRoutesConfig {
+  // Separate credential from regular user auth; required for every /debug request.
+  // The endpoints are disabled when empty.
+  debugToken: string;
+  // Component counters (sessions, watchers, subscriptions, ...)
+  getCounters: () => Record<string, unknown>;
+}
+
+// Constant-time token comparison
+function tokenMatches(provided: string, expected: string): boolean {
+  const providedBuffer = Buffer.from(provided);
+  const expectedBuffer = Buffer.from(expected);
+  return (
+    providedBuffer.length === expectedBuffer.length &&
+    crypto.timingSafeEqual(providedBuffer, expectedBuffer)
+  );
+}
+
+// Count active libuv resources by type (timers, sockets, fs watchers, ...)
+function countActiveResources(): Record<string, number> {
+  const counts: Record<string, number> = {};
+  for (const resource of process.getActiveResourcesInfo()) {
+    counts[resource] = (counts[resource] || 0) + 1;
+  }
+  return counts;
+}
+
+export function createDebugRoutes(config: DebugRoutesConfig): Router {
+  const router = Router();
+  const { debugToken, getCounters } = config;
+
+  // Track event loop delay for the lifetime of the process
+  const eventLoopDelay = monitorEventLoopDelay({ resolution: 20 });
+  eventLoopDelay.enable();
+
+  router.use((req: Request, res: Response, next: NextFunction) => {
+    if (!debugToken) {
+      return res.status(404).json({ error: 'Not found' });
+    }
+    const authHeader = req.headers.authorization;
+    const token = authHeader?.startsWith('Bearer ') ? authHeader.substring(7) : '';
+    if (!token || !tokenMatches(token, debugToken)) {
+      logger.warn(`unauthorized debug request to ${req.path} from ${req.ip}`);
+      res.setHeader('WWW-Authenticate', 'Bearer realm="VibeTunnel Debug"');
+      return res.status(401).json({ error: 'Debug credential required' });
+    }
+    next();
+  });
+
+  // Runtime counters
+  router.get('/vars', (_req, res) => {
+    res.json({
+      pid: process.pid,
+      uptime: process.uptime(),
+      memory: process.memoryUsage(),
+      heap: v8.getHeapStatistics(),
+      eventLoopDelayMs: {
+        min: eventLoopDelay.min / 1e6,
+        mean: eventLoopDelay.mean / 1e6,
+        p99: eventLoopDelay.percentile(99) / 1e6,
+        max: eventLoopDelay.max / 1e6,
+      },
+      activeResources: countActiveResources(),
+      counters: getCounters(),
+    });
+  });
+
+  // Full diagnostic report: JS stack, native stack, libuv handles, resource usage
+  router.get('/report', (_req, res) => {
+    res.json(process.report.getReport());
+  });
+
+  // V8 heap snapshot (open in Chrome DevTools)
+  router.get('/heap', (_req, res) => {
+    logger.log('writing heap snapshot');
+    res.setHeader('Content-Type', 'application/octet-stream');
+    res.setHeader(
+      'Content-Disposition',
+      `attachment; filename="vibetunnel-${process.pid}-${Date.now()}.heapsnapshot"`
+    );
+    v8.getHeapSnapshot().pipe(res);
+  });
+
+  // CPU profile for ?seconds=N (default 10)
+  router.get('/profile', (req, res) => {
+    const seconds = Number.parseInt(req.query.seconds as string, 10) || 10;
+    if (seconds < 1 || seconds > MAX_PROFILE_SECONDS) {
+      return res
+        .status(400)
+        .json({ error: `seconds must be between 1 and ${MAX_PROFILE_SECONDS}` });
+    }
+
+    logger.log(`starting cpu profile for ${seconds}s`);
+    const session = new inspector.Session();
+    session.connect();
+
+    const fail = (error: Error) => {
+      logger.error('cpu profile failed:', error);
+      session.disconnect();
+      if (!res.headersSent) {
+        res.status(500).json({ error: 'Failed to collect CPU profile' });
+      }
+    };
+
+    session.post('Profiler.enable', (enableError) => {
+      if (enableError) return fail(enableError);
+      session.post('Profiler.start', (startError) => {
+        if (startError) return fail(startError);
+        setTimeout(() => {
+          session.post('Profiler.stop', (stopError, result) => {
+            if (stopError) return fail(stopError);
+            session.disconnect();
+            res.setHeader(
+              'Content-Disposition',
+              `attachment; filename="vibetunnel-${process.pid}-${Date.now()}.cpuprofile"`
+            );
+            res.json(result.profile);
+          });
+        }, seconds * 1000);
+      });
+    });
+  });
+
+  return router;
+}
@@ -0,0 +1,88 @@
+import { Router } from 'express';
+import * as fs from 'fs';
+import type { AuthenticatedRequest } from '../middleware/auth.js';
+import { execCommand, MAX_EXEC_TIMEOUT_MS } from '../services/exec-runner.js';
+import { accountForRequest, canAccessPath } from '../utils/local-accounts.js';
+import { createLogger } from '../utils/logger.js';
+import { resolvePath } from '../utils/path-utils.js';
+
+const logger = createLogger('exec');
+
+interface ExecRoutesConfig {
+  // Run commands as the local account of the requesting user
+  localUsers?: boolean;
+}
+
+export function createExecRoutes(config: ExecRoutesConfig = {}): Router {
+  const router = Router();
+
+  // Run a command to completion and return its output (no session is created)
+  router.post('/exec', async (req, res) => {
+    const { command, workingDir, timeoutMs, input, tty, cols, rows } = req.body;
+
+    if (
+      !Array.isArray(command) ||
+      command.length === 0 ||
+      !command.every((part) => typeof part === 'string')
+    ) {
+      return res.status(400).json({ error: 'Command array is required' });
+    }
+    if (
+      timeoutMs !== undefined &&
+      (typeof timeoutMs !== 'number' || timeoutMs < 1 || timeoutMs > MAX_EXEC_TIMEOUT_MS)
+    ) {
+      return res
+        .status(400)
+        .json({ error: `timeoutMs must be between 1 and ${MAX_EXEC_TIMEOUT_MS}` });
+    }
+    if (input !== undefined && typeof input !== 'string') {
+      return res.status(400).json({ error: 'Input must be a string' });
+    }
+    if (input !== undefined && tty) {
+      return res.status(400).json({ error: 'Input is not supported with tty' });
+    }
+
+    const local = accountForRequest(req as AuthenticatedRequest, !!config.localUsers);
+    if (local.error) {
+      return res.status(403).json({ error: local.error });
+    }
+    const account = local.account;
+
+    const cwd = resolvePath(
+      typeof workingDir === 'string' ? workingDir : '',
+      account?.home ?? process.cwd(),
+      account?.home
+    );
+    if (!fs.existsSync(cwd)) {
+      return res.status(400).json({ error: 'Working directory does not exist' });
+    }
+    if (account && !(await canAccessPath(account, cwd, 'read'))) {
+      return res.status(403).json({ error: `${account.username} cannot access ${cwd}` });
+    }
+
+    try {
+      const result = await execCommand({
+        command,
+        workingDir: cwd,
+        timeoutMs,
+        input,
+        tty: tty === true,
+        cols: typeof cols === 'number' ? cols : undefined,
+        rows: typeof rows === 'number' ? rows : undefined,
+        runAs: account,
+      });
+      logger.debug(
+        `exec ${command[0]} finished with ${result.exitCode ?? result.signal} in ${result.durationMs}ms`
+      );
+      res.json(result);
+    } catch (error) {
+      logger.error(`error executing ${command.join(' ')}:`, error);
+      res.status(500).json({
+        error: 'Failed to execute command',
+        details: error instanceof Error ? error.message : 'Unknown error',
+      });
+    }
+  });
+
+  return router;
+}
@@ -1,17 +1,91 @@
 import chalk from 'chalk';
 import { exec } from 'child_process';
 import { type Request, type Response, Router } from 'express';
-import { createReadStream, statSync } from 'fs';
+import { createReadStream } from 'fs';
 import * as fs from 'fs/promises';
 import mime from 'mime-types';
+import * as os from 'os';
 import * as path from 'path';
+import { Readable } from 'stream';
+import type { ReadableStream } from 'stream/web';
 import { promisify } from 'util';
+import { type AuthenticatedRequest, isOperatorRequest } from '../middleware/auth.js';
+import { FileTail, readLastLines } from '../services/file-tail.js';
+import { FsTrash, moveAcrossDevices, TrashError } from '../services/fs-trash.js';
+import type { RemoteRegistry } from '../services/remote-registry.js';
+import { isInWorkspace, type WorkspaceStore } from '../services/workspaces.js';
+import { sendError } from '../utils/api-error.js';
+import {
+  ArchiveError,
+  archiveTypeOf,
+  listArchive,
+  openArchiveMember,
+} from '../utils/archive.js';
+import { type FsProxyTarget, resolveFsProxyTarget } from '../utils/fs-proxy.js';
+import type { FsSandbox } from '../utils/fs-sandbox.js';
+import { accountForRequest, canAccessPath } from '../utils/local-accounts.js';
+import { DEFAULT_SEARCH_RESULTS, MAX_SEARCH_RESULTS, searchFiles } from '../utils/fs-search.js';
+import { globMatcher } from '../utils/glob.js';
 import { createLogger } from '../utils/logger.js';
+import { requestIdHeaders } from '../utils/request-context.js';
+import { tracedFetch } from '../utils/tracing.js';
 
 const logger = createLogger('filesystem');
 
 const execAsync = promisify(exec);
 
+// Larger files are not read into memory for /fs/content and /fs/preview;
+// /fs/raw (with ranges) and /fs/tail serve them instead
+const MAX_TEXT_BYTES = 5 * 1024 * 1024;
+const DEFAULT_TAIL_LINES = 100;
+const MAX_TAIL_LINES = 10000;
+const HEARTBEAT_INTERVAL_MS = 30000;
+// Response headers passed through from a remote; Content-Length only for bodies that
+// were not re-encoded
+const PROXIED_RESPONSE_HEADERS = [
+  'content-type',
+  'content-range',
+  'accept-ranges',
+  'last-modified',
+  'content-disposition',
+  'cache-control',
+];
+// Listing a tar.gz means decompressing all of it; larger archives get no listing in previews
+const MAX_PREVIEW_ARCHIVE_BYTES = 512 * 1024 * 1024;
+
+function sendArchiveError(res: Response, error: ArchiveError): Response {
+  return error.code === 'MEMBER_NOT_FOUND'
+    ? sendError(res, 'NOT_FOUND', error.message)
+    : sendError(res, 'INVALID_REQUEST', error.message);
+}
+
+/**
+ * Parse a single `bytes=` range against a file size: the inclusive byte range,
+ * 'unsatisfiable', or null for a header we ignore (and serve the whole file)
+ */
+export function parseByteRange(
+  header: string,
+  size: number
+): { start: number; end: number } | 'unsatisfiable' | null {
+  const match = /^bytes=(\d*)-(\d*)$/.exec(header.trim());
+  if (!match || (match[1] === '' && match[2] === '')) return null;
+
+  let start: number;
+  let end: number;
+  if (match[1] === '') {
+    // Suffix range: the last n bytes
+    const suffix = Number(match[2]);
+    if (suffix === 0) return 'unsatisfiable';
+    start = Math.max(0, size - suffix);
+    end = size - 1;
+  } else {
+    start = Number(match[1]);
+    end = match[2] === '' ? size - 1 : Math.min(Number(match[2]), size - 1);
+  }
+  if (start >= size || start > end) return 'unsatisfiable';
+  return { start, end };
+}
+
 interface FileInfo {
   name: string;
   path: string;
@@ -33,13 +107,140 @@ interface GitStatus {
   untracked: string[];
 }
 
-export function createFilesystemRoutes(): Router {
+interface FilesystemRoutesConfig {
+  // Limit access to what the requesting user's local account could read or write
+  localUsers?: boolean;
+  // Limit access to the allowed roots (--fs-root)
+  sandbox?: FsSandbox;
+  // Where deleted and overwritten files go
+  trash?: FsTrash;
+  // HQ mode: requests for remote sessions are forwarded to their remote
+  remoteRegistry?: RemoteRegistry | null;
+  // Workspaces browsing can be scoped to
+  workspaceStore?: WorkspaceStore;
+}
+
+export function createFilesystemRoutes(config: FilesystemRoutesConfig = {}): Router {
   const router = Router();
+  const localUsers = config.localUsers ?? false;
+  const trash = config.trash ?? new FsTrash();
+
+  // Helper to check if a path may be accessed: it has to lie in one of the sandbox roots
+  // and, in local user mode, be accessible to the user's account
+  async function isPathAllowed(
+    req: Request,
+    fullPath: string,
+    access: 'read' | 'write'
+  ): Promise<boolean> {
+    const { account, error } = accountForRequest(req as AuthenticatedRequest, localUsers);
+    if (error) return false;
+    if (config.sandbox) {
+      // Local operators (no auth, local bypass) and HQs are not scoped to a user
+      const authReq = req as AuthenticatedRequest;
+      const requester = {
+        user: isOperatorRequest(authReq) ? undefined : authReq.userId,
+        home: account?.home || os.homedir(),
+      };
+      if (!(await config.sandbox.isAllowed(fullPath, requester))) return false;
+    }
+    return !account || canAccessPath(account, fullPath, access);
+  }
 
-  // Helper to check if path is safe (no directory traversal) - DISABLED for full filesystem access
-  function isPathSafe(_requestedPath: string, _basePath: string): boolean {
-    // Security check disabled - allow access to all directories
-    return true;
+  // Helper to name the trash of the requester; local operators and HQs share one
+  function trashOwner(req: Request): string {
+    const authReq = req as AuthenticatedRequest;
+    return isOperatorRequest(authReq) ? '' : (authReq.userId ?? '');
+  }
+
+  // Helper to forward a request to a remote's filesystem API and stream its answer back
+  async function forwardToRemote(req: Request, res: Response, target: FsProxyTarget) {
+    const { remote } = target;
+    const controller = new AbortController();
+    res.on('close', () => controller.abort());
+    const sendsBody = target.body !== undefined && !['GET', 'HEAD'].includes(req.method);
+
+    let response: Awaited<ReturnType<typeof tracedFetch>>;
+    try {
+      response = await tracedFetch(target.url, {
+        method: req.method,
+        headers: {
+          Authorization: `Bearer ${remote.token}`,
+          ...requestIdHeaders(),
+          ...(req.headers.accept ? { Accept: req.headers.accept } : {}),
+          ...(req.headers.range ? { Range: req.headers.range } : {}),
+          ...(sendsBody ? { 'Content-Type': 'application/json' } : {}),
+        },
+        body: sendsBody ? JSON.stringify(target.body) : undefined,
+        signal: controller.signal,
+      });
+    } catch (error) {
+      if (controller.signal.aborted) return;
+      logger.error(`failed to forward ${req.method} ${req.path} to remote ${remote.name}:`, error);
+      return sendError(res, 'REMOTE_UNREACHABLE');
+    }
+
+    for (const header of PROXIED_RESPONSE_HEADERS) {
+      const value = response.headers.get(header);
+      if (value) res.setHeader(header, value);
+    }
+    // fetch has already decoded compressed bodies, so their length no longer applies
+    const length = response.headers.get('content-length');
+    if (length && !response.headers.get('content-encoding')) {
+      res.setHeader('Content-Length', length);
+    }
+    if (response.headers.get('content-type')?.startsWith('text/event-stream')) {
+      res.setHeader('X-Accel-Buffering', 'no');
+      res.setHeader('Content-Encoding', 'identity');
+    }
+    res.status(response.status);
+    if (!response.body || req.method === 'HEAD') {
+      res.end();
+      return;
+    }
+    res.flushHeaders();
+
+    Readable.fromWeb(response.body as ReadableStream<Uint8Array>)
+      .on('error', (error) => {
+        if (!controller.signal.aborted) {
+          logger.error(`stream from remote ${remote.name} failed:`, error);
+        }
+        res.destroy();
+      })
+      .pipe(res);
+  }
+
+  // In HQ mode, requests naming a remote (remoteId) or a remote session (sessionId) are
+  // served by that remote's filesystem API
+  router.use(async (req: Request, res: Response, next) => {
+    if (!config.remoteRegistry || !/^\/fs(\/|$)/.test(req.path)) return next();
+    const target = resolveFsProxyTarget(config.remoteRegistry, req.url, req.body);
+    if (target === null) return next();
+    if (target === 'unknown-remote') return sendError(res, 'REMOTE_NOT_FOUND');
+
+    // The remote cannot apply a local account's permissions
+    if (localUsers && !isOperatorRequest(req as AuthenticatedRequest)) {
+      return sendError(res, 'FORBIDDEN', 'Remote files are not available in local user mode');
+    }
+
+    logger.debug(`forwarding ${req.method} ${req.path} to remote ${target.remote.name}`);
+    await forwardToRemote(req, res, target);
+  });
+
+  function sendTrashError(res: Response, error: TrashError): Response {
+    return sendError(res, error.code, error.message);
+  }
+
+  // Helper to expand a leading ~ to the requester's home directory
+  function expandHome(req: Request, requestedPath: string): string | null {
+    if (requestedPath !== '~' && !requestedPath.startsWith('~/')) {
+      return requestedPath;
+    }
+    const homeDir =
+      accountForRequest(req as AuthenticatedRequest, localUsers).account?.home ||
+      process.env.HOME ||
+      process.env.USERPROFILE;
+    if (!homeDir) return null;
+    return requestedPath === '~' ? homeDir : path.join(homeDir, requestedPath.slice(2));
   }
 
   // Helper to get Git status for a directory
@@ -116,19 +317,29 @@ export function createFilesystemRoutes(): Router {
   // Browse directory endpoint
   router.get('/fs/browse', async (req: Request, res: Response) => {
     try {
-      let requestedPath = (req.query.path as string) || '.';
       const showHidden = req.query.showHidden === 'true';
       const gitFilter = req.query.gitFilter as string; // 'all' | 'changed' | 'none'
+      // Only files whose names match are listed; directories always are
+      const glob = typeof req.query.glob === 'string' && req.query.glob ? req.query.glob : null;
+
+      // Within a workspace, paths are relative to its root and cannot leave it
+      const workspaceId = typeof req.query.workspace === 'string' ? req.query.workspace : '';
+      const workspace = workspaceId ? config.workspaceStore?.get(workspaceId) : undefined;
+      if (workspaceId && !workspace) {
+        return sendError(res, 'WORKSPACE_NOT_FOUND');
+      }
 
       // Handle tilde expansion for home directory
-      if (requestedPath === '~' || requestedPath.startsWith('~/')) {
-        const homeDir = process.env.HOME || process.env.USERPROFILE;
-        if (!homeDir) {
-          logger.error('unable to determine home directory');
-          return res.status(500).json({ error: 'Unable to determine home directory' });
-        }
-        requestedPath =
-          requestedPath === '~' ? homeDir : path.join(homeDir, requestedPath.slice(2));
+      const requestedPath = workspace
+        ? path.resolve(workspace.rootDir, (req.query.path as string) || '.')
+        : expandHome(req, (req.query.path as string) || '.');
+      if (!requestedPath) {
+        logger.error('unable to determine home directory');
+        return res.status(500).json({ error: 'Unable to determine home directory' });
+      }
+      if (workspace && !isInWorkspace(workspace, requestedPath)) {
+        logger.warn(`path ${requestedPath} is outside workspace ${workspace.name}`);
+        return sendError(res, 'FORBIDDEN', 'Path is outside the workspace');
       }
 
       logger.debug(
@@ -136,9 +347,9 @@ export function createFilesystemRoutes(): Router {
       );
 
       // Security check
-      if (!isPathSafe(requestedPath, process.cwd())) {
+      if (!(await isPathAllowed(req, path.resolve(requestedPath), 'read'))) {
         logger.warn(`access denied for path: ${requestedPath}`);
-        return res.status(403).json({ error: 'Access denied' });
+        return sendError(res, 'FORBIDDEN');
       }
 
       const fullPath = path.resolve(requestedPath);
@@ -277,8 +488,11 @@ export function createFilesystemRoutes(): Router {
         );
       }
 
-      // No additional filtering needed if we already filtered by git status above
-      const filteredFiles = files;
+      // Git filtering happened above; the glob applies to file names
+      const matchesGlob = glob ? globMatcher(glob) : null;
+      const filteredFiles = matchesGlob
+        ? files.filter((file) => file.type === 'directory' || matchesGlob(file.name))
+        : files;
 
       // Sort: directories first, then by name
       filteredFiles.sort((a, b) => {
@@ -306,6 +520,74 @@ export function createFilesystemRoutes(): Router {
     }
   });
 
+  // Recursive search by path glob and/or file content
+  router.get('/fs/search', async (req: Request, res: Response) => {
+    try {
+      const pattern = typeof req.query.pattern === 'string' ? req.query.pattern : '';
+      const content = typeof req.query.content === 'string' ? req.query.content : '';
+      if (!pattern && !content) {
+        return sendError(res, 'INVALID_REQUEST', 'pattern or content is required');
+      }
+      const limit =
+        req.query.limit === undefined ? DEFAULT_SEARCH_RESULTS : Number(req.query.limit);
+      if (!Number.isInteger(limit) || limit < 1 || limit > MAX_SEARCH_RESULTS) {
+        return sendError(res, 'INVALID_REQUEST', `limit must be 1-${MAX_SEARCH_RESULTS}`);
+      }
+
+      const requestedPath = expandHome(req, (req.query.path as string) || '.');
+      if (!requestedPath) {
+        return res.status(500).json({ error: 'Unable to determine home directory' });
+      }
+
+      // Security check
+      const fullPath = path.resolve(requestedPath);
+      if (!(await isPathAllowed(req, fullPath, 'read'))) {
+        logger.warn(`access denied for search: ${requestedPath}`);
+        return sendError(res, 'FORBIDDEN');
+      }
+      if (!(await fs.stat(fullPath)).isDirectory()) {
+        return res.status(400).json({ error: 'Path is not a directory' });
+      }
+
+      // Stop walking once the client is gone
+      const abort = new AbortController();
+      res.on('close', () => abort.abort());
+
+      const { account } = accountForRequest(req as AuthenticatedRequest, localUsers);
+      const started = Date.now();
+      const result = await searchFiles(fullPath, {
+        pattern: pattern || undefined,
+        content: content || undefined,
+        showHidden: req.query.showHidden === 'true',
+        maxResults: limit,
+        signal: abort.signal,
+        canRead: account ? (target) => canAccessPath(account, target, 'read') : undefined,
+      });
+
+      const elapsed = Date.now() - started;
+      logger.log(
+        chalk.green(`search in ${requestedPath}: ${result.results.length} results in ${elapsed}ms`)
+      );
+      res.json({
+        path: requestedPath,
+        fullPath,
+        results: result.results.map((match) => ({
+          ...match,
+          path: path.relative(process.cwd(), path.join(fullPath, match.path)),
+          relativePath: match.path,
+        })),
+        truncated: result.truncated,
+        visited: result.visited,
+      });
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        return res.status(404).json({ error: 'Directory not found' });
+      }
+      logger.error(`failed to search ${req.query.path}:`, error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
   // Get file preview
   router.get('/fs/preview', async (req: Request, res: Response) => {
     try {
@@ -317,9 +599,9 @@ export function createFilesystemRoutes(): Router {
       logger.debug(`previewing file: ${requestedPath}`);
 
       // Security check
-      if (!isPathSafe(requestedPath, process.cwd())) {
+      if (!(await isPathAllowed(req, path.resolve(process.cwd(), requestedPath), 'read'))) {
         logger.warn(`access denied for file preview: ${requestedPath}`);
-        return res.status(403).json({ error: 'Access denied' });
+        return sendError(res, 'FORBIDDEN');
       }
 
       const fullPath = path.resolve(process.cwd(), requestedPath);
@@ -339,6 +621,27 @@ export function createFilesystemRoutes(): Router {
         mimeType === 'application/typescript' ||
         mimeType === 'application/xml';
       const isImage = mimeType.startsWith('image/');
+      const archiveType = archiveTypeOf(fullPath);
+
+      if (archiveType && stats.size <= MAX_PREVIEW_ARCHIVE_BYTES) {
+        const listing = await listArchive(fullPath).catch((error) => {
+          if (error instanceof ArchiveError) return null;
+          throw error;
+        });
+        if (listing) {
+          const count = listing.entries.length;
+          logger.log(chalk.green(`archive preview generated: ${requestedPath} (${count} entries)`));
+          return res.json({
+            type: 'archive',
+            mimeType,
+            size: stats.size,
+            humanSize: formatBytes(stats.size),
+            archiveType,
+            entries: listing.entries,
+            truncated: listing.truncated,
+          });
+        }
+      }
 
       if (isImage) {
         // For images, return URL to fetch the image
@@ -351,6 +654,17 @@ export function createFilesystemRoutes(): Router {
           url: `/api/fs/raw?path=${encodeURIComponent(requestedPath)}`,
           size: stats.size,
         });
+      } else if (isText && stats.size > MAX_TEXT_BYTES) {
+        // Too large to send whole; the client can page through it with /fs/tail
+        logger.log(`large text file preview metadata returned: ${requestedPath}`);
+        res.json({
+          type: 'binary',
+          mimeType,
+          size: stats.size,
+          humanSize: formatBytes(stats.size),
+          tooLarge: true,
+          tailUrl: `/api/fs/tail?path=${encodeURIComponent(requestedPath)}`,
+        });
       } else if (isText || stats.size < 1024 * 1024) {
         // Text or small files (< 1MB)
         const content = await fs.readFile(fullPath, 'utf-8');
@@ -388,7 +702,7 @@ export function createFilesystemRoutes(): Router {
   });
 
   // Serve raw file content
-  router.get('/fs/raw', (req: Request, res: Response) => {
+  router.get('/fs/raw', async (req: Request, res: Response) => {
     try {
       const requestedPath = req.query.path as string;
       if (!requestedPath) {
@@ -398,15 +712,16 @@ export function createFilesystemRoutes(): Router {
       logger.debug(`serving raw file: ${requestedPath}`);
 
       // Security check
-      if (!isPathSafe(requestedPath, process.cwd())) {
+      if (!(await isPathAllowed(req, path.resolve(process.cwd(), requestedPath), 'read'))) {
         logger.warn(`access denied for raw file: ${requestedPath}`);
-        return res.status(403).json({ error: 'Access denied' });
+        return sendError(res, 'FORBIDDEN');
       }
 
       const fullPath = path.resolve(process.cwd(), requestedPath);
 
       // Check if file exists
-      if (!statSync(fullPath).isFile()) {
+      const stats = await fs.stat(fullPath).catch(() => null);
+      if (!stats?.isFile()) {
         logger.warn(`file not found for raw access: ${requestedPath}`);
         return res.status(404).json({ error: 'File not found' });
       }
@@ -414,9 +729,35 @@ export function createFilesystemRoutes(): Router {
       // Set appropriate content type
       const mimeType = mime.lookup(fullPath) || 'application/octet-stream';
       res.setHeader('Content-Type', mimeType);
+      res.setHeader('Accept-Ranges', 'bytes');
+      res.setHeader('Last-Modified', stats.mtime.toUTCString());
+
+      // Serve a single byte range (video seeking, resumed downloads)
+      let start = 0;
+      let end = stats.size - 1;
+      const range = req.headers.range ? parseByteRange(req.headers.range, stats.size) : null;
+      if (range === 'unsatisfiable') {
+        res.setHeader('Content-Range', `bytes */${stats.size}`);
+        return sendError(res, 'RANGE_NOT_SATISFIABLE');
+      }
+      if (range) {
+        ({ start, end } = range);
+        res.status(206);
+        res.setHeader('Content-Range', `bytes ${start}-${end}/${stats.size}`);
+      }
+      res.setHeader('Content-Length', String(stats.size === 0 ? 0 : end - start + 1));
+      if (stats.size === 0) {
+        return res.end();
+      }
 
       // Stream the file
-      const stream = createReadStream(fullPath);
+      const stream = createReadStream(fullPath, { start, end });
+      stream.on('error', (error) => {
+        logger.error(`failed to stream raw file ${requestedPath}:`, error);
+        // Headers are out already; cutting the connection tells the client
+        res.destroy(error);
+      });
+      res.on('close', () => stream.destroy());
       stream.pipe(res);
 
       stream.on('end', () => {
@@ -439,12 +780,22 @@ export function createFilesystemRoutes(): Router {
       logger.debug(`getting file content: ${requestedPath}`);
 
       // Security check
-      if (!isPathSafe(requestedPath, process.cwd())) {
+      if (!(await isPathAllowed(req, path.resolve(process.cwd(), requestedPath), 'read'))) {
         logger.warn(`access denied for file content: ${requestedPath}`);
-        return res.status(403).json({ error: 'Access denied' });
+        return sendError(res, 'FORBIDDEN');
       }
 
       const fullPath = path.resolve(process.cwd(), requestedPath);
+      const { size } = await fs.stat(fullPath);
+      if (size > MAX_TEXT_BYTES) {
+        logger.warn(`file too large for content: ${requestedPath} (${formatBytes(size)})`);
+        return sendError(
+          res,
+          'FILE_TOO_LARGE',
+          `File is ${formatBytes(size)}; use /api/fs/tail or /api/fs/raw`,
+          { size, maxSize: MAX_TEXT_BYTES }
+        );
+      }
       const content = await fs.readFile(fullPath, 'utf-8');
 
       logger.log(chalk.green(`file content retrieved: ${requestedPath}`));
@@ -460,6 +811,144 @@ export function createFilesystemRoutes(): Router {
     }
   });
 
+  // List the contents of a zip or tar(.gz) archive
+  router.get('/fs/archive', async (req: Request, res: Response) => {
+    try {
+      const requestedPath = req.query.path as string;
+      if (!requestedPath) {
+        return res.status(400).json({ error: 'Path is required' });
+      }
+
+      // Security check
+      if (!(await isPathAllowed(req, path.resolve(process.cwd(), requestedPath), 'read'))) {
+        logger.warn(`access denied for archive listing: ${requestedPath}`);
+        return sendError(res, 'FORBIDDEN');
+      }
+
+      const fullPath = path.resolve(process.cwd(), requestedPath);
+      const listing = await listArchive(fullPath);
+      const count = listing.entries.length;
+      logger.log(chalk.green(`archive listed: ${requestedPath} (${count} entries)`));
+      res.json({ path: requestedPath, ...listing });
+    } catch (error) {
+      if (error instanceof ArchiveError) {
+        return sendArchiveError(res, error);
+      }
+      logger.error(`failed to list archive ${req.query.path}:`, error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
+  // Stream one file out of an archive
+  router.get('/fs/archive/raw', async (req: Request, res: Response) => {
+    try {
+      const requestedPath = req.query.path as string;
+      const member = req.query.member as string;
+      if (!requestedPath || !member) {
+        return res.status(400).json({ error: 'Path and member are required' });
+      }
+
+      // Security check
+      if (!(await isPathAllowed(req, path.resolve(process.cwd(), requestedPath), 'read'))) {
+        logger.warn(`access denied for archive member: ${requestedPath}`);
+        return sendError(res, 'FORBIDDEN');
+      }
+
+      const fullPath = path.resolve(process.cwd(), requestedPath);
+      const { stream, size } = await openArchiveMember(fullPath, member);
+      res.setHeader('Content-Type', mime.lookup(member) || 'application/octet-stream');
+      res.setHeader('Content-Length', String(size));
+      res.setHeader(
+        'Content-Disposition',
+        `inline; filename*=UTF-8''${encodeURIComponent(path.posix.basename(member))}`
+      );
+      stream.on('error', (error) => {
+        logger.error(`failed to stream ${member} from ${requestedPath}:`, error);
+        res.destroy(error);
+      });
+      res.on('close', () => stream.destroy());
+      stream.pipe(res);
+      stream.on('end', () => {
+        logger.log(chalk.green(`archive member served: ${requestedPath}:${member}`));
+      });
+    } catch (error) {
+      if (error instanceof ArchiveError) {
+        return sendArchiveError(res, error);
+      }
+      logger.error(`failed to read archive member ${req.query.path}:`, error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
+  // Last lines of a (log) file, optionally followed as an SSE stream
+  router.get('/fs/tail', async (req: Request, res: Response) => {
+    try {
+      const requestedPath = req.query.path as string;
+      if (!requestedPath) {
+        return res.status(400).json({ error: 'Path is required' });
+      }
+      const lines = req.query.lines === undefined ? DEFAULT_TAIL_LINES : Number(req.query.lines);
+      if (!Number.isInteger(lines) || lines < 0 || lines > MAX_TAIL_LINES) {
+        return sendError(res, 'INVALID_REQUEST', `lines must be 0-${MAX_TAIL_LINES}`);
+      }
+      const follow = req.query.follow === 'true';
+
+      // Security check
+      if (!(await isPathAllowed(req, path.resolve(process.cwd(), requestedPath), 'read'))) {
+        logger.warn(`access denied for file tail: ${requestedPath}`);
+        return sendError(res, 'FORBIDDEN');
+      }
+
+      const fullPath = path.resolve(process.cwd(), requestedPath);
+      if (!(await fs.stat(fullPath)).isFile()) {
+        return sendError(res, 'INVALID_REQUEST', 'Not a file');
+      }
+      const tail = await readLastLines(fullPath, lines);
+
+      if (!follow) {
+        return res.json({ path: requestedPath, ...tail });
+      }
+
+      res.writeHead(200, {
+        'Content-Type': 'text/event-stream',
+        'Cache-Control': 'no-cache',
+        Connection: 'keep-alive',
+        'X-Accel-Buffering': 'no',
+        'Content-Encoding': 'identity',
+      });
+      const send = (event: string, data: unknown) => {
+        res.write(`event: ${event}\ndata: ${JSON.stringify(data)}\n\n`);
+      };
+      send('lines', { lines: tail.lines, complete: tail.complete });
+
+      const follower = new FileTail(fullPath, tail.offset);
+      follower.on('lines', (appended: string[]) => send('lines', { lines: appended }));
+      follower.on('reset', () => send('reset', {}));
+      follower.on('error', (error) => {
+        send('error', { message: error instanceof Error ? error.message : String(error) });
+        res.end();
+      });
+      follower.start();
+
+      const heartbeat = setInterval(() => res.write(':heartbeat\n\n'), HEARTBEAT_INTERVAL_MS);
+      logger.debug(`following ${requestedPath}`);
+      req.on('close', () => {
+        clearInterval(heartbeat);
+        follower.close();
+        logger.debug(`stopped following ${requestedPath}`);
+      });
+    } catch (error) {
+      logger.error(`failed to tail file ${req.query.path}:`, error);
+      if (res.headersSent) {
+        res.end();
+      } else if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        sendError(res, 'NOT_FOUND', 'File not found');
+      } else {
+        res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+      }
+    }
+  });
+
   // Get Git diff for a file
   router.get('/fs/diff', async (req: Request, res: Response) => {
     try {
@@ -471,9 +960,9 @@ export function createFilesystemRoutes(): Router {
       logger.debug(`getting git diff: ${requestedPath}`);
 
       // Security check
-      if (!isPathSafe(requestedPath, process.cwd())) {
+      if (!(await isPathAllowed(req, path.resolve(process.cwd(), requestedPath), 'read'))) {
         logger.warn(`access denied for git diff: ${requestedPath}`);
-        return res.status(403).json({ error: 'Access denied' });
+        return sendError(res, 'FORBIDDEN');
       }
 
       const fullPath = path.resolve(process.cwd(), requestedPath);
@@ -518,9 +1007,9 @@ export function createFilesystemRoutes(): Router {
       logger.debug(`getting diff content: ${requestedPath}`);
 
       // Security check
-      if (!isPathSafe(requestedPath, process.cwd())) {
+      if (!(await isPathAllowed(req, path.resolve(process.cwd(), requestedPath), 'read'))) {
         logger.warn(`access denied for diff content: ${requestedPath}`);
-        return res.status(403).json({ error: 'Access denied' });
+        return sendError(res, 'FORBIDDEN');
       }
 
       const fullPath = path.resolve(process.cwd(), requestedPath);
@@ -598,15 +1087,18 @@ export function createFilesystemRoutes(): Router {
       }
 
       // Security check
-      if (!isPathSafe(dirPath, process.cwd())) {
+      const fullPath = path.resolve(process.cwd(), dirPath, name);
+      if (!(await isPathAllowed(req, fullPath, 'write'))) {
         logger.warn(`access denied for mkdir: ${dirPath}/${name}`);
-        return res.status(403).json({ error: 'Access denied' });
+        return sendError(res, 'FORBIDDEN');
       }
 
-      const fullPath = path.resolve(process.cwd(), dirPath, name);
-
-      // Create directory
+      // Create directory, owned by the local account it was created for
       await fs.mkdir(fullPath, { recursive: true });
+      const { account } = accountForRequest(req as AuthenticatedRequest, localUsers);
+      if (account) {
+        await fs.chown(fullPath, account.uid, account.gid);
+      }
 
       logger.log(chalk.green(`directory created: ${path.relative(process.cwd(), fullPath)}`));
 
@@ -620,6 +1112,155 @@ export function createFilesystemRoutes(): Router {
     }
   });
 
+  // Delete a file or directory by moving it to the trash
+  router.delete('/fs', async (req: Request, res: Response) => {
+    try {
+      const requestedPath = req.query.path as string;
+      if (!requestedPath) {
+        return res.status(400).json({ error: 'Path is required' });
+      }
+
+      const fullPath = path.resolve(process.cwd(), requestedPath);
+      const homeDir = expandHome(req, '~');
+      if (fullPath === path.parse(fullPath).root || fullPath === homeDir) {
+        return sendError(res, 'INVALID_REQUEST', 'Refusing to delete this directory');
+      }
+
+      // Security check: the item and the directory it is removed from
+      if (
+        !(await isPathAllowed(req, fullPath, 'write')) ||
+        !(await isPathAllowed(req, path.dirname(fullPath), 'write'))
+      ) {
+        logger.warn(`access denied for delete: ${requestedPath}`);
+        return sendError(res, 'FORBIDDEN');
+      }
+
+      const entry = await trash.trash(trashOwner(req), fullPath);
+      logger.log(chalk.green(`moved to trash: ${requestedPath}`));
+      res.json({ success: true, trashEntry: entry });
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        return sendError(res, 'NOT_FOUND', 'File not found');
+      }
+      logger.error(`failed to delete ${req.query.path}:`, error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
+  // Rename or move a file or directory; what it overwrites goes to the trash
+  router.post('/fs/move', async (req: Request, res: Response) => {
+    try {
+      const { from, to, overwrite } = req.body ?? {};
+      if (typeof from !== 'string' || typeof to !== 'string' || !from || !to) {
+        return res.status(400).json({ error: 'From and to are required' });
+      }
+
+      const fromPath = path.resolve(process.cwd(), from);
+      const toPath = path.resolve(process.cwd(), to);
+      if (toPath === fromPath || toPath.startsWith(`${fromPath}${path.sep}`)) {
+        return sendError(res, 'INVALID_REQUEST', 'Cannot move a directory into itself');
+      }
+
+      // Security check
+      for (const target of [fromPath, path.dirname(fromPath), toPath, path.dirname(toPath)]) {
+        if (!(await isPathAllowed(req, target, 'write'))) {
+          logger.warn(`access denied for move: ${from} -> ${to}`);
+          return sendError(res, 'FORBIDDEN');
+        }
+      }
+
+      await fs.lstat(fromPath);
+      let replaced = null;
+      const exists = await fs.lstat(toPath).then(
+        () => true,
+        () => false
+      );
+      if (exists) {
+        if (overwrite !== true) {
+          return sendError(res, 'CONFLICT', `${to} already exists`);
+        }
+        replaced = await trash.trash(trashOwner(req), toPath);
+      }
+
+      await moveAcrossDevices(fromPath, toPath);
+      logger.log(chalk.green(`moved: ${from} -> ${to}`));
+      res.json({
+        success: true,
+        path: path.relative(process.cwd(), toPath),
+        ...(replaced ? { trashEntry: replaced } : {}),
+      });
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') {
+        return sendError(res, 'NOT_FOUND', 'File not found');
+      }
+      logger.error(`failed to move ${req.body?.from} to ${req.body?.to}:`, error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
+  // List the requester's trash
+  router.get('/fs/trash', async (req: Request, res: Response) => {
+    try {
+      res.json({ entries: await trash.list(trashOwner(req)) });
+    } catch (error) {
+      logger.error('failed to list the trash:', error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
+  // Move a trash entry back to where it was deleted from
+  router.post('/fs/trash/:id/restore', async (req: Request, res: Response) => {
+    try {
+      const owner = trashOwner(req);
+      const entry = await trash.get(owner, req.params.id);
+
+      // Security check: the requester may still write there
+      if (
+        !(await isPathAllowed(req, entry.originalPath, 'write')) ||
+        !(await isPathAllowed(req, path.dirname(entry.originalPath), 'write'))
+      ) {
+        logger.warn(`access denied for restore: ${entry.originalPath}`);
+        return sendError(res, 'FORBIDDEN');
+      }
+
+      await trash.restore(owner, entry.id, req.body?.overwrite === true);
+      logger.log(chalk.green(`restored from trash: ${entry.originalPath}`));
+      res.json({ success: true, path: path.relative(process.cwd(), entry.originalPath) });
+    } catch (error) {
+      if (error instanceof TrashError) {
+        return sendTrashError(res, error);
+      }
+      logger.error(`failed to restore ${req.params.id}:`, error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
+  // Delete a trash entry for good
+  router.delete('/fs/trash/:id', async (req: Request, res: Response) => {
+    try {
+      await trash.remove(trashOwner(req), req.params.id);
+      res.json({ success: true });
+    } catch (error) {
+      if (error instanceof TrashError) {
+        return sendTrashError(res, error);
+      }
+      logger.error(`failed to delete trash entry ${req.params.id}:`, error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
+  // Empty the requester's trash
+  router.delete('/fs/trash', async (req: Request, res: Response) => {
+    try {
+      const removed = await trash.empty(trashOwner(req));
+      logger.log(chalk.green(`emptied trash (${removed} entries)`));
+      res.json({ success: true, removed });
+    } catch (error) {
+      logger.error('failed to empty the trash:', error);
+      res.status(500).json({ error: error instanceof Error ? error.message : String(error) });
+    }
+  });
+
   return router;
 }
 
@@ -0,0 +1,241 @@
+import chalk from 'chalk';
+import { Router } from 'express';
+import * as fs from 'fs';
+import { isSpecialKey } from '../../shared/keymap.js';
+import type { SessionInput } from '../../shared/types.js';
+import type { AuthenticatedRequest } from '../middleware/auth.js';
+import { PtyError, type PtyManager, SessionLimitError } from '../pty/index.js';
+import { type InputLockManager, lockTokenFromRequest } from '../services/input-lock.js';
+import type { SessionGroup, SessionGroupStore } from '../services/session-groups.js';
+import { sendError } from '../utils/api-error.js';
+import { inputSourceFromRequest } from '../utils/input-source.js';
+import { accountForRequest, canAccessPath } from '../utils/local-accounts.js';
+import { createLogger } from '../utils/logger.js';
+import { resolvePath } from '../utils/path-utils.js';
+import { generateSessionName } from '../utils/session-naming.js';
+
+const logger = createLogger('groups');
+
+interface GroupRoutesConfig {
+  ptyManager: PtyManager;
+  groupStore: SessionGroupStore;
+  // Sessions locked by another client are skipped by group input
+  inputLocks?: InputLockManager;
+  // Run sessions as the local account of the requesting user
+  localUsers?: boolean;
+}
+
+interface GroupSessionSpec {
+  command: string[];
+  workingDir?: string;
+  name?: string;
+}
+
+function isSessionSpec(value: unknown): value is GroupSessionSpec {
+  if (!value || typeof value !== 'object') return false;
+  const spec = value as Record<string, unknown>;
+  return (
+    Array.isArray(spec.command) &&
+    spec.command.length > 0 &&
+    spec.command.every((part) => typeof part === 'string') &&
+    (spec.workingDir === undefined || typeof spec.workingDir === 'string') &&
+    (spec.name === undefined || typeof spec.name === 'string')
+  );
+}
+
+export function createGroupRoutes(config: GroupRoutesConfig): Router {
+  const router = Router();
+  const { ptyManager, groupStore, inputLocks, localUsers = false } = config;
+
+  // Group with the current state of its sessions
+  const withSessions = (group: SessionGroup) => ({
+    ...group,
+    sessions: group.sessionIds.map(
+      (sessionId) => ptyManager.getSession(sessionId) ?? { id: sessionId, status: 'missing' }
+    ),
+  });
+
+  // List all groups
+  router.get('/groups', (_req, res) => {
+    res.json(groupStore.list().map(withSessions));
+  });
+
+  // Create several sessions as a named group
+  router.post('/groups', async (req, res) => {
+    const { name, sessions } = req.body;
+
+    if (typeof name !== 'string' || name.trim() === '') {
+      return res.status(400).json({ error: 'Group name is required' });
+    }
+    if (!Array.isArray(sessions) || sessions.length === 0 || !sessions.every(isSessionSpec)) {
+      return res
+        .status(400)
+        .json({ error: 'Sessions must be a non-empty array of { command, workingDir?, name? }' });
+    }
+
+    const { userId } = req as AuthenticatedRequest;
+    const local = accountForRequest(req as AuthenticatedRequest, localUsers);
+    if (local.error) {
+      return sendError(res, 'FORBIDDEN', local.error);
+    }
+    const account = local.account;
+    const defaultDir = account?.home ?? process.cwd();
+
+    // Working directories are checked up front, before any session is created
+    const workingDirs: string[] = [];
+    for (const spec of sessions) {
+      let cwd = resolvePath(spec.workingDir ?? '', defaultDir, account?.home);
+      if (!fs.existsSync(cwd)) {
+        logger.warn(`working directory '${cwd}' does not exist, using ${defaultDir}`);
+        cwd = defaultDir;
+      }
+      if (account && !(await canAccessPath(account, cwd, 'read'))) {
+        return sendError(res, 'FORBIDDEN', `${account.username} cannot access ${cwd}`);
+      }
+      workingDirs.push(cwd);
+    }
+
+    const sessionIds: string[] = [];
+    try {
+      // The whole group has to fit within the session limits
+      ptyManager.checkSessionLimits(userId, sessions.length);
+
+      for (const [index, spec] of sessions.entries()) {
+        const cwd = workingDirs[index];
+        const result = await ptyManager.createSession(spec.command, {
+          name: spec.name || generateSessionName(spec.command, cwd),
+          workingDir: cwd,
+          createdBy: userId,
+          runAs: account,
+        });
+        sessionIds.push(result.sessionId);
+      }
+    } catch (error) {
+      // Creating a group is all or nothing
+      logger.error(`error creating group ${name}, removing its sessions:`, error);
+      await Promise.all(
+        sessionIds.map(async (sessionId) => {
+          await ptyManager.killSession(sessionId).catch(() => {});
+          ptyManager.cleanupSession(sessionId);
+        })
+      );
+      if (error instanceof SessionLimitError) {
+        return res.status(429).json(error.toResponse());
+      }
+      if (error instanceof PtyError) {
+        return res.status(500).json({ error: 'Failed to create group', details: error.message });
+      }
+      return res.status(500).json({ error: 'Failed to create group' });
+    }
+
+    const group = groupStore.create(name.trim(), sessionIds);
+    logger.log(chalk.green(`group ${group.name} created with ${sessionIds.length} sessions`));
+    res.json({ groupId: group.id, sessionIds });
+  });
+
+  // Get a single group
+  router.get('/groups/:groupId', (req, res) => {
+    const group = groupStore.get(req.params.groupId);
+    if (!group) {
+      return sendError(res, 'GROUP_NOT_FOUND');
+    }
+    res.json(withSessions(group));
+  });
+
+  // Send the same input to every running session of a group
+  router.post('/groups/:groupId/input', (req, res) => {
+    const group = groupStore.get(req.params.groupId);
+    if (!group) {
+      return sendError(res, 'GROUP_NOT_FOUND');
+    }
+
+    const { text, key } = req.body;
+    if ((text === undefined) === (key === undefined)) {
+      return res.status(400).json({ error: 'Either text or key must be provided' });
+    }
+    if (text !== undefined && typeof text !== 'string') {
+      return res.status(400).json({ error: 'Text must be a string' });
+    }
+    if (key !== undefined && (typeof key !== 'string' || !isSpecialKey(key))) {
+      return res.status(400).json({ error: 'Unknown key' });
+    }
+    const input: SessionInput = text !== undefined ? { text } : { key };
+    const source = inputSourceFromRequest(req as AuthenticatedRequest);
+    const lockToken = lockTokenFromRequest(req);
+
+    const sent: string[] = [];
+    const failed: string[] = [];
+    const locked: string[] = [];
+    for (const sessionId of group.sessionIds) {
+      if (ptyManager.getSession(sessionId)?.status !== 'running') {
+        continue;
+      }
+      if (inputLocks && !inputLocks.checkInput(sessionId, lockToken)) {
+        locked.push(sessionId);
+        continue;
+      }
+      try {
+        ptyManager.sendInput(sessionId, input, source);
+        sent.push(sessionId);
+      } catch (error) {
+        logger.warn(`failed to send group input to session ${sessionId}:`, error);
+        failed.push(sessionId);
+      }
+    }
+
+    logger.debug(`group ${group.id} input sent to ${sent.length} sessions`);
+    res.json({ success: failed.length === 0, sent, failed, locked });
+  });
+
+  // Kill all sessions of a group and remove the group
+  router.delete('/groups/:groupId', async (req, res) => {
+    const group = groupStore.get(req.params.groupId);
+    if (!group) {
+      return sendError(res, 'GROUP_NOT_FOUND');
+    }
+
+    const failed: string[] = [];
+    await Promise.all(
+      group.sessionIds.map(async (sessionId) => {
+        if (ptyManager.getSession(sessionId)?.status !== 'running') return;
+        try {
+          await ptyManager.killSession(sessionId, 'SIGTERM');
+        } catch (error) {
+          logger.warn(`failed to kill session ${sessionId} of group ${group.id}:`, error);
+          failed.push(sessionId);
+        }
+      })
+    );
+
+    if (failed.length > 0) {
+      // Keep the group so the remaining sessions can still be managed together
+      return res.status(500).json({ error: 'Failed to kill all sessions', failed });
+    }
+
+    groupStore.delete(group.id);
+    logger.log(chalk.yellow(`group ${group.name} killed`));
+    res.json({ success: true, message: 'Group killed' });
+  });
+
+  // Kill sessions, remove their files and remove the group
+  router.delete('/groups/:groupId/cleanup', async (req, res) => {
+    const group = groupStore.get(req.params.groupId);
+    if (!group) {
+      return sendError(res, 'GROUP_NOT_FOUND');
+    }
+
+    try {
+      for (const sessionId of group.sessionIds) {
+        ptyManager.cleanupSession(sessionId);
+      }
+      groupStore.delete(group.id);
+      logger.log(chalk.yellow(`group ${group.name} cleaned up`));
+      res.json({ success: true, message: 'Group cleaned up' });
+    } catch (error) {
+      logger.error(`error cleaning up group ${group.id}:`, error);
+      res.status(500).json({ error: 'Failed to cleanup group' });
+    }
+  });
+
+  return router;
+}
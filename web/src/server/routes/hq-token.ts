@@ -0,0 +1,60 @@
+import { Router } from 'express';
+import type { AuthenticatedRequest } from '../middleware/auth.js';
+import {
+  type RemoteTokenStore,
+  SIGNATURE_HEADER,
+  TIMESTAMP_HEADER,
+  verifySignature,
+} from '../services/remote-tokens.js';
+import { sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('hq-token');
+
+interface HQTokenRoutesConfig {
+  remoteTokens: RemoteTokenStore;
+  // Shared secret token pushes must be signed with (unsigned when null)
+  hqSecret: string | null;
+}
+
+/**
+ * Remote mode: lets HQ replace the token it authenticates with
+ */
+export function createHQTokenRoutes(config: HQTokenRoutesConfig): Router {
+  const router = Router();
+  const { remoteTokens, hqSecret } = config;
+
+  // Accept a new token from HQ; only the current token may rotate itself
+  router.post('/hq/token', (req: AuthenticatedRequest, res) => {
+    const bearer = req.headers.authorization?.startsWith('Bearer ')
+      ? req.headers.authorization.substring(7)
+      : '';
+    if (req.authMethod !== 'hq-bearer' || !remoteTokens.isCurrent(bearer)) {
+      logger.warn('token rotation rejected: not authenticated with the current hq token');
+      return res.status(403).json({ error: 'Current HQ token required' });
+    }
+
+    const { token, revokePrevious } = req.body;
+    if (typeof token !== 'string' || token.length < 32) {
+      return res.status(400).json({ error: 'Token must be a string of at least 32 characters' });
+    }
+    if (
+      hqSecret &&
+      !verifySignature(
+        hqSecret,
+        req.headers[TIMESTAMP_HEADER],
+        req.headers[SIGNATURE_HEADER],
+        token
+      )
+    ) {
+      logger.warn('token rotation rejected: invalid signature');
+      return sendError(res, 'INVALID_TOKEN', 'Invalid token signature');
+    }
+
+    remoteTokens.rotate(token, { revokePrevious: revokePrevious === true });
+    logger.log(`hq token rotated${revokePrevious === true ? ', previous token revoked' : ''}`);
+    res.json({ success: true });
+  });
+
+  return router;
+}
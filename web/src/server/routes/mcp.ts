@@ -0,0 +1,44 @@
+import { Router } from 'express';
+import type { AuthenticatedRequest } from '../middleware/auth.js';
+import type { McpServer } from '../services/mcp-server.js';
+import { inputSourceFromRequest } from '../utils/input-source.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('mcp-routes');
+
+interface McpRoutesConfig {
+  mcp: McpServer;
+}
+
+/**
+ * Model Context Protocol endpoint (streamable HTTP, JSON responses only)
+ */
+export function createMcpRoutes(config: McpRoutesConfig): Router {
+  const router = Router();
+  const { mcp } = config;
+
+  router.post('/mcp', async (req: AuthenticatedRequest, res) => {
+    try {
+      const response = await mcp.handle(req.body, {
+        scopes: req.authMethod === 'api-token' ? req.tokenScopes : undefined,
+        source: inputSourceFromRequest(req),
+      });
+      if (response === null) {
+        // Only notifications or responses were posted
+        return res.status(202).end();
+      }
+      res.json(response);
+    } catch (error) {
+      logger.error('error handling MCP request:', error);
+      res.status(500).json({ error: 'Failed to handle MCP request' });
+    }
+  });
+
+  // No server-initiated stream and no sessions to end
+  router.all('/mcp', (_req, res) => {
+    res.setHeader('Allow', 'POST');
+    res.status(405).json({ error: 'Only POST is supported' });
+  });
+
+  return router;
+}
@@ -0,0 +1,103 @@
+import { type Request, type Response, Router } from 'express';
+import type { AuthService } from '../services/auth-service.js';
+import { OidcError, type OidcService } from '../services/oidc.js';
+import { sendError } from '../utils/api-error.js';
+import { getCookie, SESSION_COOKIE } from '../utils/cookies.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('oidc');
+
+// Same lifetime as the JWT the cookie holds
+const SESSION_COOKIE_MAX_AGE_MS = 24 * 60 * 60 * 1000;
+
+interface OidcRoutesConfig {
+  oidc: OidcService;
+  authService: AuthService;
+}
+
+/**
+ * Single sign-on with an OpenID provider, mounted under /api/auth
+ */
+export function createOidcRoutes(config: OidcRoutesConfig): Router {
+  const router = Router();
+  const { oidc, authService } = config;
+
+  // Back to the login page, which shows the message
+  const redirectWithError = (res: Response, message: string) => {
+    res.redirect(`/?sso_error=${encodeURIComponent(message)}`);
+  };
+
+  const isSecure = (req: Request) => req.secure || req.headers['x-forwarded-proto'] === 'https';
+
+  /**
+   * Start a login at the provider
+   * GET /api/auth/oidc/login
+   */
+  router.get('/oidc/login', async (req, res) => {
+    try {
+      const redirectUri = `${req.protocol}://${req.get('host')}/api/auth/oidc/callback`;
+      res.redirect(await oidc.authorizationUrl(redirectUri));
+    } catch (error) {
+      logger.error('failed to start single sign-on:', error);
+      redirectWithError(res, 'Single sign-on is unavailable');
+    }
+  });
+
+  /**
+   * Provider callback: sets the session cookie and returns to the app
+   * GET /api/auth/oidc/callback
+   */
+  router.get('/oidc/callback', async (req, res) => {
+    const { state, code, error, error_description } = req.query;
+    if (typeof error === 'string') {
+      logger.warn(`single sign-on failed at the provider: ${error}`);
+      return redirectWithError(
+        res,
+        typeof error_description === 'string' ? error_description : error
+      );
+    }
+    if (typeof state !== 'string' || typeof code !== 'string') {
+      return redirectWithError(res, 'Invalid single sign-on response');
+    }
+
+    try {
+      const identity = await oidc.completeLogin(state, code);
+      res.cookie(SESSION_COOKIE, authService.createSingleSignOnToken(identity.userId), {
+        httpOnly: true,
+        sameSite: 'lax',
+        secure: isSecure(req),
+        maxAge: SESSION_COOKIE_MAX_AGE_MS,
+        path: '/',
+      });
+      logger.log(`user ${identity.userId} signed in with single sign-on`);
+      res.redirect('/');
+    } catch (error) {
+      if (error instanceof OidcError && error.status < 500) {
+        logger.warn(`single sign-on rejected: ${error.message}`);
+        return redirectWithError(res, error.message);
+      }
+      logger.error('single sign-on failed:', error);
+      redirectWithError(res, 'Single sign-on failed');
+    }
+  });
+
+  /**
+   * Token of the single sign-on session, for API requests of the web app
+   * GET /api/auth/oidc/session
+   */
+  router.get('/oidc/session', (req, res) => {
+    const token = getCookie(req.headers.cookie, SESSION_COOKIE) ?? '';
+    const verification = authService.verifyToken(token);
+    if (!verification.valid || verification.authMethod !== 'oidc') {
+      return sendError(res, 'AUTH_REQUIRED', 'No single sign-on session');
+    }
+    res.json({
+      success: true,
+      token,
+      userId: verification.userId,
+      authMethod: 'oidc',
+    });
+  });
+
+  return router;
+}
@@ -0,0 +1,133 @@
+import { type NextFunction, type Request, type Response, Router } from 'express';
+import { encodeQrCode, qrCodeToText } from '../../shared/qr-code.js';
+import { type AuthenticatedRequest, isAdminRequest } from '../middleware/auth.js';
+import { API_TOKEN_SCOPES, isApiTokenScope } from '../services/api-tokens.js';
+import { PairingError, type PairingService, pairingUrl } from '../services/pairing.js';
+import { sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('pairing');
+
+interface PairingRoutesConfig {
+  pairing: PairingService;
+  // Only admins may pair devices with the admin scope
+  adminUsers: string[];
+}
+
+function sendPairingError(res: Response, error: unknown): Response {
+  if (error instanceof PairingError) {
+    return res.status(error.status).json({ error: error.message });
+  }
+  logger.error('pairing request failed:', error);
+  return sendError(res, 'INTERNAL_ERROR', 'Pairing failed');
+}
+
+/**
+ * Pairing of mobile clients: started and approved by a signed-in user in the
+ * web UI, claimed by the device under /api/auth (no authentication)
+ */
+export function createPairingRoutes(config: PairingRoutesConfig): Router {
+  const router = Router();
+  const { pairing, adminUsers } = config;
+
+  // Server URL as the browser reached it, for the device to connect to
+  const serverUrl = (req: Request) => `${req.protocol}://${req.get('host')}`;
+
+  // Devices are paired by users who signed in, not with API tokens or as HQ
+  router.use('/pairing', (req: AuthenticatedRequest, res: Response, next: NextFunction) => {
+    if (!req.userId || req.authMethod === 'api-token' || req.authMethod === 'hq-bearer') {
+      return sendError(res, 'FORBIDDEN', 'Devices are paired by signed-in users');
+    }
+    next();
+  });
+
+  // Start a pairing; returns the code and the URL to show as a QR code
+  router.post('/pairing', (req: AuthenticatedRequest, res) => {
+    const { scopes } = req.body;
+    if (scopes !== undefined && (!Array.isArray(scopes) || !scopes.every(isApiTokenScope))) {
+      return res
+        .status(400)
+        .json({ error: `Scopes must be an array of ${API_TOKEN_SCOPES.join(', ')}` });
+    }
+    if (scopes?.includes('admin') && !isAdminRequest(req, adminUsers)) {
+      return sendError(res, 'ADMIN_REQUIRED', 'Only admins can pair devices with the admin scope');
+    }
+    try {
+      const info = pairing.start(req.userId as string, scopes);
+      const url = pairingUrl(serverUrl(req), info.code);
+      // Also scannable from the server's terminal
+      logger.log(`pairing code ${info.code}:\n${qrCodeToText(encodeQrCode(url))}`);
+      res.status(201).json({ ...info, url });
+    } catch (error) {
+      sendPairingError(res, error);
+    }
+  });
+
+  // State of a pairing, polled by the web UI until a device claims it
+  router.get('/pairing/:id', (req: AuthenticatedRequest, res) => {
+    try {
+      res.json(pairing.get(req.params.id, req.userId as string));
+    } catch (error) {
+      sendPairingError(res, error);
+    }
+  });
+
+  router.post('/pairing/:id/approve', (req: AuthenticatedRequest, res) => {
+    try {
+      res.json(pairing.approve(req.params.id, req.userId as string));
+    } catch (error) {
+      sendPairingError(res, error);
+    }
+  });
+
+  router.post('/pairing/:id/deny', (req: AuthenticatedRequest, res) => {
+    try {
+      res.json(pairing.deny(req.params.id, req.userId as string));
+    } catch (error) {
+      sendPairingError(res, error);
+    }
+  });
+
+  router.delete('/pairing/:id', (req: AuthenticatedRequest, res) => {
+    try {
+      pairing.cancel(req.params.id, req.userId as string);
+      res.json({ success: true });
+    } catch (error) {
+      sendPairingError(res, error);
+    }
+  });
+
+  /**
+   * Claim a pairing code from the device
+   * POST /api/auth/pairing/claim
+   */
+  router.post('/auth/pairing/claim', (req, res) => {
+    const { code, deviceName } = req.body;
+    if (typeof code !== 'string' || (deviceName !== undefined && typeof deviceName !== 'string')) {
+      return res.status(400).json({ error: 'Code is required' });
+    }
+    try {
+      res.json(pairing.claim(code, deviceName ?? '', req.ip || 'unknown'));
+    } catch (error) {
+      sendPairingError(res, error);
+    }
+  });
+
+  /**
+   * Poll a claimed pairing from the device; returns the API token once approved
+   * POST /api/auth/pairing/status
+   */
+  router.post('/auth/pairing/status', (req, res) => {
+    const { pairingId, secret } = req.body;
+    if (typeof pairingId !== 'string' || typeof secret !== 'string') {
+      return res.status(400).json({ error: 'Pairing ID and secret are required' });
+    }
+    try {
+      res.json(pairing.poll(pairingId, secret));
+    } catch (error) {
+      sendPairingError(res, error);
+    }
+  });
+
+  return router;
+}
@@ -1,31 +1,60 @@
 import chalk from 'chalk';
 import { Router } from 'express';
 import { isShuttingDown } from '../server.js';
+import type { HQClient } from '../services/hq-client.js';
+import { validateRemoteTags } from '../services/remote-affinity.js';
 import type { RemoteRegistry } from '../services/remote-registry.js';
+import {
+  registrationPayload,
+  SIGNATURE_HEADER,
+  TIMESTAMP_HEADER,
+  verifySignature,
+} from '../services/remote-tokens.js';
+import { sendError } from '../utils/api-error.js';
 import { createLogger } from '../utils/logger.js';
+import { requestIdHeaders } from '../utils/request-context.js';
+import {
+  FEDERATION_DEPTH_HEADER,
+  namespaceSessionId,
+  SESSION_NAMESPACE_SEPARATOR,
+} from '../utils/session-namespace.js';
+import { tracedFetch } from '../utils/tracing.js';
 
 const logger = createLogger('remotes');
 
 interface RemoteRoutesConfig {
   remoteRegistry: RemoteRegistry | null;
   isHQMode: boolean;
+  // Shared secret remotes must sign their registration with (unsigned when null)
+  hqSecret?: string | null;
+  // Federated HQ: client registered with the higher-level HQ (created once listening)
+  getHQClient?: () => HQClient | null;
 }
 
 export function createRemoteRoutes(config: RemoteRoutesConfig): Router {
   const router = Router();
-  const { remoteRegistry, isHQMode } = config;
+  const { remoteRegistry, isHQMode, hqSecret, getHQClient } = config;
+
+  // Federated HQ: sessions of our remotes are ours to the higher-level HQ, so pass changes on
+  const notifyUpstream = (action: string, sessionId?: string) => {
+    const hqClient = getHQClient?.();
+    if (!hqClient || isShuttingDown()) return;
+    hqClient.notifySessionChange(action, sessionId).catch((error) => {
+      logger.warn(`failed to notify upstream hq about ${action}:`, error);
+    });
+  };
 
   // HQ Mode: List all registered remotes
   router.get('/remotes', (_req, res) => {
     if (!isHQMode || !remoteRegistry) {
       logger.debug('remotes list requested but not in HQ mode');
-      return res.status(404).json({ error: 'Not running in HQ mode' });
+      return sendError(res, 'NOT_HQ_MODE');
     }
 
     const remotes = remoteRegistry.getRemotes();
     logger.debug(`listing ${remotes.length} registered remotes`);
-    // Convert Set to Array for JSON serialization
-    const remotesWithArraySessionIds = remotes.map((remote) => ({
+    // Convert Set to Array for JSON serialization; tokens never leave HQ
+    const remotesWithArraySessionIds = remotes.map(({ token: _token, ...remote }) => ({
       ...remote,
       sessionIds: Array.from(remote.sessionIds),
     }));
@@ -36,24 +65,51 @@ export function createRemoteRoutes(config: RemoteRoutesConfig): Router {
   router.post('/remotes/register', (req, res) => {
     if (!isHQMode || !remoteRegistry) {
       logger.debug('remote registration attempted but not in HQ mode');
-      return res.status(404).json({ error: 'Not running in HQ mode' });
+      return sendError(res, 'NOT_HQ_MODE');
     }
 
-    const { id, name, url, token } = req.body;
+    const { id, name, url, tags } = req.body;
 
-    if (!id || !name || !url || !token) {
+    if (!id || !name || !url) {
       logger.warn(
-        `remote registration missing required fields: got id=${!!id}, name=${!!name}, url=${!!url}, token=${!!token}`
+        `remote registration missing required fields: got id=${!!id}, name=${!!name}, url=${!!url}`
       );
-      return res.status(400).json({ error: 'Missing required fields: id, name, url, token' });
+      return res.status(400).json({ error: 'Missing required fields: id, name, url' });
+    }
+
+    // Remote names prefix the IDs of their sessions (<remoteName>/<id>)
+    if (String(name).includes(SESSION_NAMESPACE_SEPARATOR)) {
+      return res
+        .status(400)
+        .json({ error: `Remote name must not contain '${SESSION_NAMESPACE_SEPARATOR}'` });
+    }
+    const tagsError = tags !== undefined ? validateRemoteTags(tags) : null;
+    if (tagsError) {
+      return res.status(400).json({ error: tagsError });
+    }
+
+    // Shared-secret handshake: the remote signs its identity with the HQ secret
+    if (
+      hqSecret &&
+      !verifySignature(
+        hqSecret,
+        req.headers[TIMESTAMP_HEADER],
+        req.headers[SIGNATURE_HEADER],
+        registrationPayload(String(id), String(name), String(url), tags)
+      )
+    ) {
+      logger.warn(`remote registration rejected: invalid signature for ${name} (${id})`);
+      return res.status(401).json({ error: 'Invalid registration signature' });
     }
 
     logger.debug(`attempting to register remote ${name} (${id}) from ${url}`);
 
     try {
-      const remote = remoteRegistry.register({ id, name, url, token });
+      // HQ issues the token it uses to authenticate with the remote
+      const { token, ...remote } = remoteRegistry.register({ id, name, url, tags });
       logger.log(chalk.green(`remote registered: ${name} (${id}) from ${url}`));
-      res.json({ success: true, remote });
+      res.json({ success: true, remote, token });
+      notifyUpstream('remote-registered');
     } catch (error) {
       if (error instanceof Error && error.message.includes('already registered')) {
         return res.status(409).json({ error: error.message });
@@ -67,7 +123,7 @@ export function createRemoteRoutes(config: RemoteRoutesConfig): Router {
   router.delete('/remotes/:remoteId', (req, res) => {
     if (!isHQMode || !remoteRegistry) {
       logger.debug('remote unregistration attempted but not in HQ mode');
-      return res.status(404).json({ error: 'Not running in HQ mode' });
+      return sendError(res, 'NOT_HQ_MODE');
     }
 
     const remoteId = req.params.remoteId;
@@ -77,9 +133,30 @@ export function createRemoteRoutes(config: RemoteRoutesConfig): Router {
     if (success) {
       logger.log(chalk.yellow(`remote unregistered: ${remoteId}`));
       res.json({ success: true });
+      notifyUpstream('remote-unregistered');
     } else {
       logger.warn(`attempted to unregister non-existent remote: ${remoteId}`);
-      res.status(404).json({ error: 'Remote not found' });
+      sendError(res, 'REMOTE_NOT_FOUND');
+    }
+  });
+
+  // HQ Mode: Issue a new token to a remote; with revoke the old token is rejected at once
+  router.post('/remotes/:remoteId/rotate-token', async (req, res) => {
+    if (!isHQMode || !remoteRegistry) {
+      logger.debug('token rotation attempted but not in HQ mode');
+      return sendError(res, 'NOT_HQ_MODE');
+    }
+
+    const remoteId = req.params.remoteId;
+    if (!remoteRegistry.getRemote(remoteId)) {
+      return sendError(res, 'REMOTE_NOT_FOUND');
+    }
+
+    const revoke = req.body?.revoke === true;
+    if (await remoteRegistry.rotateToken(remoteId, revoke)) {
+      res.json({ success: true, revoked: revoke });
+    } else {
+      res.status(502).json({ error: 'Remote did not accept the new token' });
     }
   });
 
@@ -87,13 +164,13 @@ export function createRemoteRoutes(config: RemoteRoutesConfig): Router {
   router.post('/remotes/:remoteName/refresh-sessions', async (req, res) => {
     if (!isHQMode || !remoteRegistry) {
       logger.debug('session refresh attempted but not in HQ mode');
-      return res.status(404).json({ error: 'Not running in HQ mode' });
+      return sendError(res, 'NOT_HQ_MODE');
     }
 
     // If server is shutting down, return service unavailable
     if (isShuttingDown()) {
       logger.debug('session refresh rejected during shutdown');
-      return res.status(503).json({ error: 'Server is shutting down' });
+      return sendError(res, 'SERVER_SHUTTING_DOWN');
     }
 
     const remoteName = req.params.remoteName;
@@ -108,15 +185,17 @@ export function createRemoteRoutes(config: RemoteRoutesConfig): Router {
 
     if (!remote) {
       logger.warn(`remote not found for session refresh: ${remoteName}`);
-      return res.status(404).json({ error: 'Remote not found' });
+      return sendError(res, 'REMOTE_NOT_FOUND');
     }
 
     try {
       // Fetch latest sessions from the remote
       const startTime = Date.now();
-      const response = await fetch(`${remote.url}/api/sessions`, {
+      const response = await tracedFetch(`${remote.url}/api/sessions`, {
         headers: {
           Authorization: `Bearer ${remote.token}`,
+          ...requestIdHeaders(),
+          [FEDERATION_DEPTH_HEADER]: '1',
         },
         signal: AbortSignal.timeout(5000),
       });
@@ -135,6 +214,7 @@ export function createRemoteRoutes(config: RemoteRoutesConfig): Router {
           `session refresh completed in ${duration}ms (action: ${action}, sessionId: ${sessionId})`
         );
         res.json({ success: true, sessionCount: sessionIds.length });
+        notifyUpstream(action, sessionId && namespaceSessionId(remote.name, sessionId));
       } else {
         throw new Error(`Failed to fetch sessions: ${response.status}`);
       }
@@ -142,7 +222,7 @@ export function createRemoteRoutes(config: RemoteRoutesConfig): Router {
       // During shutdown, connection failures are expected
       if (isShuttingDown()) {
         logger.log(chalk.yellow(`remote ${remote.name} refresh failed during shutdown (expected)`));
-        return res.status(503).json({ error: 'Server is shutting down' });
+        return sendError(res, 'SERVER_SHUTTING_DOWN');
       }
 
       logger.error(`failed to refresh sessions for remote ${remote.name}:`, error);
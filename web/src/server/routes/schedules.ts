@@ -0,0 +1,197 @@
+import { type Request, type Response, Router } from 'express';
+import {
+  type AuthenticatedRequest,
+  isAdminRequest,
+  isOperatorRequest,
+} from '../middleware/auth.js';
+import type { PtyManager } from '../pty/index.js';
+import {
+  type ScheduledJob,
+  type ScheduledJobInput,
+  type Scheduler,
+  SchedulerError,
+  type ScheduleTarget,
+} from '../services/scheduler.js';
+import { sendError } from '../utils/api-error.js';
+import { accountForRequest, canUseSession } from '../utils/local-accounts.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('schedules');
+
+interface ScheduleRoutesConfig {
+  scheduler: Scheduler;
+  ptyManager: PtyManager;
+  // Users who may manage every job
+  adminUsers: string[];
+  // Spawned sessions run as the local account of the job's owner
+  localUsers?: boolean;
+}
+
+function isTarget(value: unknown): value is ScheduleTarget {
+  if (!value || typeof value !== 'object') return false;
+  const target = value as Record<string, unknown>;
+  if (target.type === 'session') {
+    return typeof target.sessionId === 'string' && target.sessionId !== '';
+  }
+  return (
+    target.type === 'spawn' &&
+    (target.workingDir === undefined || typeof target.workingDir === 'string') &&
+    (target.name === undefined || typeof target.name === 'string')
+  );
+}
+
+/**
+ * Validate a job body. With `partial` only the given fields are checked.
+ * Returns an error message or null.
+ */
+function validateJob(body: Record<string, unknown>, partial: boolean): string | null {
+  const { name, cron, command, target, enabled } = body;
+  if ((!partial || name !== undefined) && (typeof name !== 'string' || name.trim() === '')) {
+    return 'Name is required';
+  }
+  if ((!partial || cron !== undefined) && typeof cron !== 'string') {
+    return 'Cron expression is required';
+  }
+  if ((!partial || command !== undefined) && (typeof command !== 'string' || command === '')) {
+    return 'Command is required';
+  }
+  if ((!partial || target !== undefined) && !isTarget(target)) {
+    return "Target must be { type: 'session', sessionId } or { type: 'spawn', workingDir?, name? }";
+  }
+  if (enabled !== undefined && typeof enabled !== 'boolean') {
+    return 'Enabled must be a boolean';
+  }
+  return null;
+}
+
+export function createScheduleRoutes(config: ScheduleRoutesConfig): Router {
+  const router = Router();
+  const { scheduler, ptyManager, adminUsers, localUsers = false } = config;
+
+  // Jobs are managed by their owner and admins; jobs of operators only by admins
+  const mayManage = (req: Request, job: ScheduledJob) => {
+    const authReq = req as AuthenticatedRequest;
+    return isAdminRequest(authReq, adminUsers) || (!!job.owner && job.owner === authReq.userId);
+  };
+
+  // Look up the job of the request's path; sends the error and returns null if
+  // the requester may not manage it
+  const findJob = (req: Request, res: Response): ScheduledJob | null => {
+    const job = scheduler.get(req.params.jobId);
+    if (!job || !mayManage(req, job)) {
+      sendError(res, 'SCHEDULE_NOT_FOUND');
+      return null;
+    }
+    return job;
+  };
+
+  // Jobs act for their owner and may only type into sessions the owner can use;
+  // jobs of operators into all. Sends the error and returns false otherwise.
+  const checkTarget = (res: Response, owner: string | undefined, target: ScheduleTarget) => {
+    const session = target.type === 'session' ? ptyManager.getSession(target.sessionId) : null;
+    const ownerRequest = { userId: owner } as AuthenticatedRequest;
+    if (session && owner && !canUseSession(ownerRequest, session, localUsers, adminUsers)) {
+      sendError(res, 'FORBIDDEN', 'Session belongs to another user');
+      return false;
+    }
+    return true;
+  };
+
+  const sendSchedulerError = (res: Response, error: unknown, action: string) => {
+    if (error instanceof SchedulerError) {
+      return sendError(res, 'INVALID_REQUEST', error.message);
+    }
+    logger.error(`error trying to ${action} scheduled job:`, error);
+    sendError(res, 'INTERNAL_ERROR', `Failed to ${action} scheduled job`);
+  };
+
+  // List the jobs of the requester; admins see all
+  router.get('/schedules', (req, res) => {
+    res.json(scheduler.list().filter((job) => mayManage(req, job)));
+  });
+
+  // Register a job, owned by the requester
+  router.post('/schedules', (req, res) => {
+    const error = validateJob(req.body, false);
+    if (error) {
+      return sendError(res, 'INVALID_REQUEST', error);
+    }
+
+    // Sessions of the job run as the owner's local account
+    const authReq = req as AuthenticatedRequest;
+    const local = accountForRequest(authReq, localUsers);
+    if (local.error) {
+      return sendError(res, 'FORBIDDEN', local.error);
+    }
+
+    const { name, cron, command, target, enabled } = req.body as ScheduledJobInput;
+    const owner = isOperatorRequest(authReq) ? undefined : authReq.userId;
+    if (!checkTarget(res, owner, target)) return;
+    try {
+      res.json(scheduler.create({ name: name.trim(), cron, command, target, enabled }, owner));
+    } catch (error) {
+      sendSchedulerError(res, error, 'create');
+    }
+  });
+
+  // Get a single job
+  router.get('/schedules/:jobId', (req, res) => {
+    const job = findJob(req, res);
+    if (job) res.json(job);
+  });
+
+  // Change a job (e.g. enable/disable it)
+  router.patch('/schedules/:jobId', (req, res) => {
+    const error = validateJob(req.body, true);
+    if (error) {
+      return sendError(res, 'INVALID_REQUEST', error);
+    }
+    const existing = findJob(req, res);
+    if (!existing) return;
+
+    const { name, cron, command, target, enabled } = req.body as Partial<ScheduledJobInput>;
+    const changes: Partial<ScheduledJobInput> = { cron, command, target, enabled };
+    if (name !== undefined) changes.name = name.trim();
+    for (const key of Object.keys(changes) as Array<keyof ScheduledJobInput>) {
+      if (changes[key] === undefined) delete changes[key];
+    }
+    if (changes.target && !checkTarget(res, existing.owner, changes.target)) return;
+
+    try {
+      const job = scheduler.update(req.params.jobId, changes);
+      if (!job) {
+        return sendError(res, 'SCHEDULE_NOT_FOUND');
+      }
+      res.json(job);
+    } catch (error) {
+      sendSchedulerError(res, error, 'update');
+    }
+  });
+
+  // Remove a job and its history
+  router.delete('/schedules/:jobId', (req, res) => {
+    if (!findJob(req, res)) return;
+    if (!scheduler.delete(req.params.jobId)) {
+      return sendError(res, 'SCHEDULE_NOT_FOUND');
+    }
+    res.json({ success: true });
+  });
+
+  // Run history of a job, oldest first
+  router.get('/schedules/:jobId/runs', (req, res) => {
+    if (!findJob(req, res)) return;
+    res.json(scheduler.getRuns(req.params.jobId));
+  });
+
+  // Run a job now
+  router.post('/schedules/:jobId/run', async (req, res) => {
+    if (!findJob(req, res)) return;
+    const run = await scheduler.runNow(req.params.jobId);
+    if (!run) {
+      return sendError(res, 'SCHEDULE_NOT_FOUND');
+    }
+    res.json(run);
+  });
+
+  return router;
+}
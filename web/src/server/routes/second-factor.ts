@@ -0,0 +1,92 @@
+import { type NextFunction, type Response, Router } from 'express';
+import type { AuthenticatedRequest } from '../middleware/auth.js';
+import type { SecondFactorService } from '../services/second-factor.js';
+import { sendError } from '../utils/api-error.js';
+import { sendSecondFactorError } from './auth.js';
+
+interface SecondFactorRoutesConfig {
+  secondFactor: SecondFactorService;
+}
+
+/**
+ * Enrollment of second factors by the signed-in user. Verification during
+ * login happens in the auth routes.
+ */
+export function createSecondFactorRoutes(config: SecondFactorRoutesConfig): Router {
+  const router = Router();
+  const { secondFactor } = config;
+
+  // Only users who signed in with a password or SSH key have factors to manage
+  router.use('/second-factor', (req: AuthenticatedRequest, res: Response, next: NextFunction) => {
+    if (!req.userId || (req.authMethod !== 'password' && req.authMethod !== 'ssh-key')) {
+      return sendError(res, 'FORBIDDEN', 'Second factors are managed by signed-in users');
+    }
+    next();
+  });
+
+  // Enrolled factors of the current user
+  router.get('/second-factor', (req: AuthenticatedRequest, res) => {
+    res.json(secondFactor.getStatus(req.userId as string));
+  });
+
+  // Start enrolling an authenticator app; returns the secret and otpauth:// URL
+  router.post('/second-factor/totp', (req: AuthenticatedRequest, res) => {
+    res.json(secondFactor.beginTotpEnrollment(req.userId as string));
+  });
+
+  // Enable the authenticator app with a code it generated
+  router.post('/second-factor/totp/confirm', (req: AuthenticatedRequest, res) => {
+    const { code } = req.body;
+    if (typeof code !== 'string') {
+      return sendError(res, 'INVALID_REQUEST', 'Code is required');
+    }
+    try {
+      secondFactor.confirmTotpEnrollment(req.userId as string, code);
+      res.json(secondFactor.getStatus(req.userId as string));
+    } catch (error) {
+      sendSecondFactorError(res, error);
+    }
+  });
+
+  // Remove the authenticator app
+  router.delete('/second-factor/totp', (req: AuthenticatedRequest, res) => {
+    if (!secondFactor.removeTotp(req.userId as string)) {
+      return sendError(res, 'NOT_FOUND', 'No authenticator app is enrolled');
+    }
+    res.json(secondFactor.getStatus(req.userId as string));
+  });
+
+  // Options for registering a security key (navigator.credentials.create)
+  router.post('/second-factor/webauthn/options', (req: AuthenticatedRequest, res) => {
+    try {
+      res.json(secondFactor.webAuthnRegistrationOptions(req.userId as string, req.headers.origin));
+    } catch (error) {
+      sendSecondFactorError(res, error);
+    }
+  });
+
+  // Register a security key from the browser's attestation response
+  router.post('/second-factor/webauthn', (req: AuthenticatedRequest, res) => {
+    const { credential, name } = req.body;
+    if (!credential || typeof credential !== 'object') {
+      return sendError(res, 'INVALID_REQUEST', 'Credential is required');
+    }
+    try {
+      res
+        .status(201)
+        .json(secondFactor.finishWebAuthnRegistration(req.userId as string, credential, name));
+    } catch (error) {
+      sendSecondFactorError(res, error);
+    }
+  });
+
+  // Remove a security key
+  router.delete('/second-factor/webauthn/:credentialId', (req: AuthenticatedRequest, res) => {
+    if (!secondFactor.removeWebAuthn(req.userId as string, req.params.credentialId)) {
+      return sendError(res, 'NOT_FOUND', 'Security key not found');
+    }
+    res.json(secondFactor.getStatus(req.userId as string));
+  });
+
+  return router;
+}
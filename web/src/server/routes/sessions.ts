@@ -1,81 +1,323 @@
 import chalk from 'chalk';
-import { Router } from 'express';
+import { type Request, type Response, Router } from 'express';
 import * as fs from 'fs';
 import * as net from 'net';
-import * as os from 'os';
-import * as path from 'path';
+import { isSpecialKey } from '../../shared/keymap.js';
 import { cellsToText } from '../../shared/terminal-text-formatter.js';
-import type { Session, SessionActivity } from '../../shared/types.js';
-import { PtyError, type PtyManager } from '../pty/index.js';
+import type { Session, SessionActivity, SessionInput } from '../../shared/types.js';
+import type { AuthenticatedRequest } from '../middleware/auth.js';
+import { isAdminRequest } from '../middleware/auth.js';
+import {
+  commandScript,
+  type EnvControlMessage,
+  inlineImageUrl,
+  isSessionPriority,
+  isValidSessionId,
+  type MetadataControlMessage,
+  matchesAgentFilter,
+  PtyError,
+  type PtyManager,
+  SESSION_PRIORITIES,
+  type SessionInitOptions,
+  SessionLimitError,
+  supportsRcInit,
+  validateControlCommand,
+} from '../pty/index.js';
+import type { ActivityFocus, ActivityFocusState } from '../services/activity-focus.js';
 import type { ActivityMonitor } from '../services/activity-monitor.js';
-import type { RemoteRegistry } from '../services/remote-registry.js';
+import { AnnotationError, type AnnotationStore } from '../services/annotation-store.js';
+import type { CollaborationService } from '../services/collaboration.js';
+import {
+  INPUT_LOCKED_ERROR,
+  type InputLockManager,
+  LOCK_TOKEN_HEADER,
+  lockTokenFromRequest,
+} from '../services/input-lock.js';
+import {
+  type InputSequencer,
+  parseInputBatch,
+  validateComposition,
+} from '../services/input-sequencer.js';
+import { detectLinks } from '../services/link-detector.js';
+import { type LogForwarder, parseLogSinks } from '../services/log-forwarder.js';
+import { PlainTextTransform } from '../services/plain-text-stream.js';
+import { ArchiveError, type RecordingArchiver } from '../services/recording-archiver.js';
+import {
+  compareRecordings,
+  DEFAULT_CONTEXT_LINES,
+  readRecordingText,
+} from '../services/recording-diff.js';
+import { placeSession } from '../services/remote-affinity.js';
+import type { RemoteRegistry, RemoteServer } from '../services/remote-registry.js';
+import {
+  isSizePolicy,
+  type SizeNegotiator,
+  type SizePolicySettings,
+} from '../services/size-negotiator.js';
 import type { StreamWatcher } from '../services/stream-watcher.js';
 import type { TerminalManager } from '../services/terminal-manager.js';
+import type { WorkspaceStore } from '../services/workspaces.js';
+import {
+  DEFAULT_WAIT_TIMEOUT_MS,
+  MAX_WAIT_TIMEOUT_MS,
+  type TextWaiter,
+  WaitError,
+} from '../services/text-waiter.js';
+import {
+  DEFAULT_THUMBNAIL_LINES,
+  MAX_THUMBNAIL_LINES,
+  type ThumbnailService,
+} from '../services/thumbnail-service.js';
+import {
+  mergeRemotePresence,
+  type SessionPresence,
+  type ViewerPresence,
+} from '../services/viewer-presence.js';
+import { sendError } from '../utils/api-error.js';
+import { INPUT_SOURCE_HEADER, inputSourceFromRequest } from '../utils/input-source.js';
+import { accountForRequest, canAccessPath, canUseSession } from '../utils/local-accounts.js';
 import { createLogger } from '../utils/logger.js';
+import { resolvePath } from '../utils/path-utils.js';
+import { requestIdHeaders } from '../utils/request-context.js';
+import {
+  FEDERATION_DEPTH_HEADER,
+  isValidNamespacedSessionId,
+  MAX_FEDERATION_DEPTH,
+  namespaceSessionId,
+  toRemoteSessionId,
+} from '../utils/session-namespace.js';
 import { generateSessionName } from '../utils/session-naming.js';
+import { renderSnapshotAnsi } from '../utils/snapshot-ansi.js';
+import { renderSnapshotPng, renderSnapshotSvg } from '../utils/snapshot-image.js';
+import { getActiveSpan, tracedFetch, withSpan } from '../utils/tracing.js';
 
 const logger = createLogger('sessions');
 
-interface SessionRoutesConfig {
-  ptyManager: PtyManager;
-  terminalManager: TerminalManager;
-  streamWatcher: StreamWatcher;
-  remoteRegistry: RemoteRegistry | null;
-  isHQMode: boolean;
-  activityMonitor: ActivityMonitor;
+// Lines of recent output scanned for links by default, and at most
+const DEFAULT_LINK_LINES = 500;
+const MAX_LINK_LINES = 5000;
+
+// Longest annotation text accepted
+const MAX_ANNOTATION_LENGTH = 1000;
+
+// Most unchanged lines shown around each change of a session comparison
+const MAX_COMPARE_CONTEXT = 100;
+
+// Tags of a create request, which route the session to a control root
+const MAX_SESSION_TAGS = 20;
+const SESSION_TAG_PATTERN = /^[A-Za-z0-9._:-]{1,64}$/;
+
+// Response for resize requests while the server does not allow clients to resize sessions
+const RESIZE_DISABLED_ERROR = {
+  error: 'Terminal resizing is disabled by the server',
+  code: 'RESIZE_DISABLED',
+};
+
+/**
+ * Lines of screen preview requested with `?thumbnails` (default count) or
+ * `?thumbnails=<lines>`; null if none were requested
+ */
+function parseThumbnailLines(value: unknown): number | null {
+  if (value === undefined || value === 'false' || value === '0') return null;
+  const lines = Number(value);
+  if (value === '' || value === 'true' || !Number.isInteger(lines) || lines < 1) {
+    return DEFAULT_THUMBNAIL_LINES;
+  }
+  return Math.min(lines, MAX_THUMBNAIL_LINES);
 }
 
-// Helper function to resolve path (handles ~)
-function resolvePath(inputPath: string, defaultPath: string): string {
-  if (!inputPath || inputPath.trim() === '') {
-    return defaultPath;
+/**
+ * Init script of a create request, given as the script or `{ script, mode? }`
+ */
+function parseSessionInit(
+  value: unknown,
+  command: string[]
+): { init?: SessionInitOptions; error?: string } {
+  if (value === undefined || value === null) return {};
+  const { script, mode = 'stdin' } =
+    typeof value === 'string' ? { script: value } : (value as Partial<SessionInitOptions>);
+  if (typeof script !== 'string' || script.trim() === '') {
+    return { error: 'init must be a script or { script, mode? }' };
   }
-
-  if (inputPath.startsWith('~/')) {
-    return path.join(os.homedir(), inputPath.slice(2));
+  if (mode !== 'stdin' && mode !== 'rc') {
+    return { error: "init mode must be 'stdin' or 'rc'" };
+  }
+  if (mode === 'rc' && !supportsRcInit(command)) {
+    return { error: 'rc init needs an interactive bash or zsh' };
   }
+  return { init: { script, mode } };
+}
 
-  if (!path.isAbsolute(inputPath)) {
-    return path.join(defaultPath, inputPath);
+/**
+ * Tags of a create request: up to 20 short names without spaces
+ */
+function parseSessionTags(value: unknown): { tags?: string[]; error?: string } {
+  if (value === undefined || value === null) return {};
+  if (
+    !Array.isArray(value) ||
+    value.length > MAX_SESSION_TAGS ||
+    !value.every((tag) => typeof tag === 'string' && SESSION_TAG_PATTERN.test(tag))
+  ) {
+    return {
+      error: `tags must be an array of at most ${MAX_SESSION_TAGS} names ([A-Za-z0-9._:-])`,
+    };
   }
+  return { tags: [...new Set(value as string[])] };
+}
 
-  return inputPath;
+interface SessionRoutesConfig {
+  ptyManager: PtyManager;
+  terminalManager: TerminalManager;
+  streamWatcher: StreamWatcher;
+  remoteRegistry: RemoteRegistry | null;
+  isHQMode: boolean;
+  activityMonitor: ActivityMonitor;
+  // Session that currently needs eyes (follow mode)
+  activityFocus: ActivityFocus;
+  inputSequencer: InputSequencer;
+  sizeNegotiator: SizeNegotiator;
+  viewerPresence: ViewerPresence;
+  collaboration: CollaborationService;
+  inputLocks: InputLockManager;
+  thumbnails: ThumbnailService;
+  annotations: AnnotationStore;
+  // Waits for text in sessions (expect-style automation)
+  textWaiter: TextWaiter;
+  // Output forwarding requested per session
+  logForwarder?: LogForwarder;
+  // Uploads finished recordings to object storage (null when not configured)
+  archiver?: RecordingArchiver | null;
+  // Users allowed to take over locks held by others
+  adminUsers: string[];
+  // Run sessions as the local account of the requesting user
+  localUsers?: boolean;
+  // Workspaces sessions can be created in
+  workspaceStore?: WorkspaceStore;
 }
 
 export function createSessionRoutes(config: SessionRoutesConfig): Router {
   const router = Router();
-  const { ptyManager, terminalManager, streamWatcher, remoteRegistry, isHQMode, activityMonitor } =
-    config;
+
+  // Session IDs name directories in the control roots; reject anything but a safe charset
+  // (and, on HQs, remote sessions' namespaced IDs)
+  router.param('sessionId', (req, res, next, sessionId) => {
+    if (!isValidSessionId(sessionId) && !(isHQMode && isValidNamespacedSessionId(sessionId))) {
+      return sendError(res, 'INVALID_SESSION_ID');
+    }
+    const session = localUsers ? ptyManager.getSession(sessionId) : null;
+    if (session && !mayUseSession(req, session)) {
+      return sendError(res, 'FORBIDDEN', 'Session belongs to another user');
+    }
+    next();
+  });
+  const {
+    ptyManager,
+    terminalManager,
+    streamWatcher,
+    remoteRegistry,
+    isHQMode,
+    activityMonitor,
+    activityFocus,
+    inputSequencer,
+    sizeNegotiator,
+    viewerPresence,
+    collaboration,
+    inputLocks,
+    thumbnails,
+    annotations,
+    textWaiter,
+    logForwarder,
+    archiver,
+    adminUsers,
+    localUsers = false,
+    workspaceStore,
+  } = config;
+
+  // Sessions of other users are off limits in local user mode
+  const mayUseSession = (req: Request, session: Session) =>
+    canUseSession(req as AuthenticatedRequest, session, localUsers, adminUsers);
+
+  // URL of a session on its remote, which knows it without our namespace. Behind a
+  // federated HQ that ID is itself namespaced, so it is encoded as one path segment.
+  const remoteSessionUrl = (remote: RemoteServer, sessionId: string, subPath = '') =>
+    `${remote.url}/api/sessions/${encodeURIComponent(toRemoteSessionId(sessionId))}${subPath}`;
+
+  // Clients may put namespaced remote session IDs (<remoteName>/<id>) into URLs without
+  // encoding the separator; fold them back into a single path segment
+  router.use((req, _res, next) => {
+    const match = isHQMode && req.url.match(/^\/sessions\/([^?]+)(.*)$/);
+    const segments = match ? match[1].split('/') : [];
+    if (match && remoteRegistry && segments.length > 1 && segments[1]) {
+      let remoteName: string;
+      try {
+        remoteName = decodeURIComponent(segments[0]);
+      } catch {
+        return next();
+      }
+      const remote = remoteRegistry.getRemoteByName(remoteName);
+      if (remote) {
+        // IDs re-exported by a federated HQ span more segments; take the longest known one
+        let idLength = 2;
+        for (let n = segments.length; n > 2; n--) {
+          if (remote.sessionIds.has(segments.slice(0, n).join('/'))) {
+            idLength = n;
+            break;
+          }
+        }
+        const sessionId = segments.slice(0, idLength).join('/');
+        const subPath = segments.slice(idLength).map((segment) => `/${segment}`);
+        req.url = `/sessions/${encodeURIComponent(sessionId)}${subPath.join('')}${match[2]}`;
+      }
+    }
+    next();
+  });
 
   // List all sessions (aggregate local + remote in HQ mode)
-  router.get('/sessions', async (_req, res) => {
+  router.get('/sessions', async (req, res) => {
     logger.debug('listing all sessions');
     try {
       let allSessions = [];
 
       // Get local sessions
-      const localSessions = ptyManager.listSessions();
+      const localSessions = ptyManager
+        .listSessions()
+        .filter((session) => mayUseSession(req, session));
       logger.debug(`found ${localSessions.length} local sessions`);
 
-      // Add source info to local sessions
-      const localSessionsWithSource = localSessions.map((session) => ({
-        ...session,
-        source: 'local' as const,
-      }));
+      // Add source info (and screen previews if requested) to local sessions
+      const thumbnailLines = parseThumbnailLines(req.query.thumbnails);
+      const localSessionsWithSource = await Promise.all(
+        localSessions.map(async (session) => ({
+          ...session,
+          source: 'local' as const,
+          ...(thumbnailLines && session.status === 'running'
+            ? { thumbnail: await getThumbnail(session.id, thumbnailLines) }
+            : {}),
+        }))
+      );
 
       allSessions = [...localSessionsWithSource];
 
+      // Requests from a higher-level HQ carry how many HQs they already passed
+      const federationDepth = Number(req.headers[FEDERATION_DEPTH_HEADER]) || 0;
+      if (isHQMode && remoteRegistry && federationDepth >= MAX_FEDERATION_DEPTH) {
+        logger.warn(`not aggregating remote sessions at federation depth ${federationDepth}`);
+      }
+
       // If in HQ mode, aggregate sessions from all remotes
-      if (isHQMode && remoteRegistry) {
+      if (isHQMode && remoteRegistry && federationDepth < MAX_FEDERATION_DEPTH) {
         const remotes = remoteRegistry.getRemotes();
         logger.debug(`checking ${remotes.length} remote servers for sessions`);
 
         // Fetch sessions from each remote in parallel
         const remotePromises = remotes.map(async (remote) => {
           try {
-            const response = await fetch(`${remote.url}/api/sessions`, {
+            const query = thumbnailLines ? `?thumbnails=${thumbnailLines}` : '';
+            const response = await tracedFetch(`${remote.url}/api/sessions${query}`, {
               headers: {
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
+                [FEDERATION_DEPTH_HEADER]: String(federationDepth + 1),
               },
               signal: AbortSignal.timeout(5000), // 5 second timeout
             });
@@ -88,13 +330,17 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
               const sessionIds = remoteSessions.map((s: Session) => s.id);
               remoteRegistry.updateRemoteSessions(remote.id, sessionIds);
 
-              // Add remote info to each session
+              // Namespace IDs so sessions of different remotes cannot collide
               return remoteSessions.map((session: Session) => ({
                 ...session,
+                id: namespaceSessionId(remote.name, session.id),
+                remoteSessionId: session.id,
                 source: 'remote',
                 remoteId: remote.id,
                 remoteName: remote.name,
                 remoteUrl: remote.url,
+                // Sessions re-exported by a federated HQ keep their path below it
+                remotePath: [remote.name, ...(session.remotePath ?? [])],
               }));
             } else {
               logger.warn(
@@ -115,6 +361,13 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         allSessions = [...allSessions, ...remoteSessions];
       }
 
+      // Agent filters apply to remote sessions too, so they are not forwarded
+      allSessions = allSessions.filter((session) => matchesAgentFilter(session, req.query));
+      if (typeof req.query.workspace === 'string' && req.query.workspace) {
+        const workspaceId = req.query.workspace;
+        allSessions = allSessions.filter((session) => session.workspace === workspaceId);
+      }
+
       logger.debug(`returning ${allSessions.length} total sessions`);
       res.json(allSessions);
     } catch (error) {
@@ -125,7 +378,19 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
 
   // Create new session (local or on remote)
   router.post('/sessions', async (req, res) => {
-    const { command, workingDir, name, remoteId, spawn_terminal } = req.body;
+    const {
+      command,
+      workingDir,
+      name,
+      remoteId,
+      spawn_terminal,
+      init,
+      logForwarding,
+      tags,
+      priority,
+      scratch,
+      workspace: workspaceId,
+    } = req.body;
     logger.debug(
       `creating new session: command=${JSON.stringify(command)}, remoteId=${remoteId || 'local'}`
     );
@@ -135,30 +400,92 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       return res.status(400).json({ error: 'Command array is required' });
     }
 
+    const initOption = parseSessionInit(init, command);
+    if (initOption.error) {
+      return res.status(400).json({ error: initOption.error });
+    }
+    const logSinks = parseLogSinks(logForwarding);
+    if (logSinks.error) {
+      return res.status(400).json({ error: logSinks.error });
+    }
+    const sessionTags = parseSessionTags(tags);
+    if (sessionTags.error) {
+      return res.status(400).json({ error: sessionTags.error });
+    }
+    if (priority !== undefined && !isSessionPriority(priority)) {
+      return res
+        .status(400)
+        .json({ error: `priority must be one of ${SESSION_PRIORITIES.join(', ')}` });
+    }
+    if (scratch !== undefined && typeof scratch !== 'boolean') {
+      return res.status(400).json({ error: 'scratch must be a boolean' });
+    }
+    if (workspaceId !== undefined && (typeof workspaceId !== 'string' || workspaceId === '')) {
+      return res.status(400).json({ error: 'workspace must be a workspace ID' });
+    }
+
+    // Sessions of a workspace start in its root and on its remote unless told otherwise.
+    // An HQ creating a session in one of its workspaces passes the ID on as is.
+    const workspace = workspaceId ? workspaceStore?.get(workspaceId) : undefined;
+    if (workspaceId && !workspace && (req as AuthenticatedRequest).authMethod !== 'hq-bearer') {
+      return sendError(res, 'WORKSPACE_NOT_FOUND');
+    }
+    const sessionWorkingDir = workingDir || workspace?.rootDir;
+
     try {
-      // If remoteId is specified and we're in HQ mode, forward to remote
-      if (remoteId && isHQMode && remoteRegistry) {
-        const remote = remoteRegistry.getRemote(remoteId);
-        if (!remote) {
-          logger.warn(`session creation failed: remote ${remoteId} not found`);
-          return res.status(404).json({ error: 'Remote server not found' });
+      // In HQ mode the session goes to the requested remote, or the workspace's: one its
+      // affinity pins sessions to (which a requested remote has to be), else its default
+      let remote: RemoteServer | undefined;
+      if (isHQMode && remoteRegistry) {
+        if (remoteId) {
+          remote = remoteRegistry.getRemote(remoteId);
+          if (!remote) {
+            logger.warn(`session creation failed: remote ${remoteId} not found`);
+            return sendError(res, 'REMOTE_NOT_FOUND', 'Remote server not found');
+          }
+        }
+        if (workspace?.affinity) {
+          const placement = placeSession(workspace.affinity, remoteRegistry.getRemotes(), remote);
+          if ('error' in placement) {
+            logger.warn(`session creation refused: ${placement.error}`);
+            return sendError(res, placement.code, placement.error);
+          }
+          remote = placement.remote;
+          if (!remote) {
+            logger.log(`no remote matches workspace ${workspace.name}, creating session locally`);
+          }
+        } else if (!remote && workspace?.defaultRemote) {
+          remote = remoteRegistry.getRemoteByName(workspace.defaultRemote);
+          if (!remote) {
+            logger.warn(`session creation failed: remote ${workspace.defaultRemote} not found`);
+            return sendError(res, 'REMOTE_NOT_FOUND', 'Remote server not found');
+          }
         }
+      }
 
+      if (remote) {
         logger.log(chalk.blue(`forwarding session creation to remote ${remote.name}`));
 
         // Forward the request to the remote server
         const startTime = Date.now();
-        const response = await fetch(`${remote.url}/api/sessions`, {
+        const response = await tracedFetch(`${remote.url}/api/sessions`, {
           method: 'POST',
           headers: {
             'Content-Type': 'application/json',
             Authorization: `Bearer ${remote.token}`,
+            ...requestIdHeaders(),
           },
           body: JSON.stringify({
             command,
-            workingDir,
+            workingDir: sessionWorkingDir,
             name,
             spawn_terminal,
+            init,
+            logForwarding,
+            tags,
+            priority,
+            scratch,
+            workspace: workspaceId,
             // Don't forward remoteId to avoid recursion
           }),
           signal: AbortSignal.timeout(10000), // 10 second timeout
@@ -174,21 +501,32 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
 
         // Track the session in the remote's sessionIds
         if (result.sessionId) {
-          remoteRegistry.addSessionToRemote(remote.id, result.sessionId);
+          remoteRegistry?.addSessionToRemote(remote.id, result.sessionId);
         }
 
-        res.json(result); // Return sessionId as-is, no namespacing
+        res.json({ ...result, sessionId: namespaceSessionId(remote.name, result.sessionId) });
         return;
       }
 
-      // If spawn_terminal is true and socket exists, use the spawn-terminal logic
+      // Sessions spawned by the Mac app count against the limits as well
+      const { userId } = req as AuthenticatedRequest;
+      ptyManager.checkSessionLimits(userId);
+
+      const local = accountForRequest(req as AuthenticatedRequest, localUsers);
+      if (local.error) {
+        return sendError(res, 'FORBIDDEN', local.error);
+      }
+      const account = local.account;
+
+      // If spawn_terminal is true and socket exists, use the spawn-terminal logic.
+      // Terminals of the Mac app run as its user, so not for other local accounts.
       const socketPath = '/tmp/vibetunnel-terminal.sock';
-      if (spawn_terminal && fs.existsSync(socketPath)) {
+      if (spawn_terminal && !account && fs.existsSync(socketPath)) {
         try {
           // Generate session ID
           const sessionId = generateSessionId();
           const sessionName =
-            name || generateSessionName(command, resolvePath(workingDir, process.cwd()));
+            name || generateSessionName(command, resolvePath(sessionWorkingDir, process.cwd()));
 
           // Request Mac app to spawn terminal
           logger.log(
@@ -198,7 +536,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
             sessionId,
             sessionName,
             command,
-            workingDir: resolvePath(workingDir, process.cwd()),
+            workingDir: resolvePath(sessionWorkingDir, process.cwd()),
           });
 
           if (!spawnResult.success) {
@@ -224,43 +562,137 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
           });
           return;
         }
-      } else if (spawn_terminal && !fs.existsSync(socketPath)) {
+      } else if (spawn_terminal && !account && !fs.existsSync(socketPath)) {
         logger.debug('terminal spawn socket not available, falling back to normal spawn');
       }
 
-      // Create local session
-      let cwd = resolvePath(workingDir, process.cwd());
+      // Create local session; sessions of local accounts start in their home
+      const defaultDir = account?.home ?? process.cwd();
+      let cwd = resolvePath(sessionWorkingDir, defaultDir, account?.home);
 
-      // Check if the working directory exists, fall back to process.cwd() if not
+      // Check if the working directory exists, fall back to the default if not
       if (!fs.existsSync(cwd)) {
-        logger.warn(
-          `Working directory '${cwd}' does not exist, using current directory as fallback`
-        );
-        cwd = process.cwd();
+        logger.warn(`Working directory '${cwd}' does not exist, using ${defaultDir} as fallback`);
+        cwd = defaultDir;
+      }
+      if (account && !(await canAccessPath(account, cwd, 'read'))) {
+        return sendError(res, 'FORBIDDEN', `${account.username} cannot access ${cwd}`);
       }
 
       const sessionName = name || generateSessionName(command, cwd);
 
       logger.log(chalk.blue(`creating session: ${command.join(' ')} in ${cwd}`));
 
-      const result = await ptyManager.createSession(command, {
-        name: sessionName,
-        workingDir: cwd,
-      });
+      const result = await withSpan(
+        'session.create',
+        { 'process.command': command[0], 'vibetunnel.session_name': sessionName },
+        () =>
+          ptyManager.createSession(command, {
+            name: sessionName,
+            workingDir: cwd,
+            createdBy: userId,
+            init: initOption.init,
+            tags: sessionTags.tags,
+            runAs: account,
+            priority,
+            scratch,
+            workspace: workspaceId,
+          })
+      );
 
       const { sessionId, sessionInfo } = result;
       logger.log(chalk.green(`session ${sessionId} created (PID: ${sessionInfo.pid})`));
+      if (logSinks.sinks?.length) {
+        logForwarder?.addSessionSinks(sessionId, logSinks.sinks);
+      }
 
       // Stream watcher is set up when clients connect to the stream endpoint
 
       res.json({ sessionId });
     } catch (error) {
+      if (error instanceof SessionLimitError) {
+        logger.warn(`session creation refused: ${error.message}`);
+        return res.status(429).json(error.toResponse());
+      }
       logger.error('error creating session:', error);
       if (error instanceof PtyError) {
-        res.status(500).json({ error: 'Failed to create session', details: error.message });
+        sendError(res, 'PTY_CREATE_FAILED', undefined, error.message);
       } else {
-        res.status(500).json({ error: 'Failed to create session' });
+        sendError(res, 'PTY_CREATE_FAILED');
+      }
+    }
+  });
+
+  // Session limits of this server and how many sessions count against them
+  router.get('/sessions/limits', (req, res) => {
+    res.json(ptyManager.getSessionUsage((req as AuthenticatedRequest).userId));
+  });
+
+  // Follow mode: which session needs eyes, now and on every change (SSE). Only
+  // sessions of this server are followed, also in HQ mode.
+  router.get('/sessions/focus', (req, res) => {
+    res.writeHead(200, {
+      'Content-Type': 'text/event-stream',
+      'Cache-Control': 'no-cache',
+      Connection: 'keep-alive',
+      'X-Accel-Buffering': 'no',
+      'Content-Encoding': 'identity',
+    });
+    const send = (focus: ActivityFocusState) => {
+      res.write(`event: focus\ndata: ${JSON.stringify(focus)}\n\n`);
+    };
+    send(activityFocus.getFocus());
+    activityFocus.on('focus-changed', send);
+
+    const heartbeat = setInterval(() => res.write(':heartbeat\n\n'), 30000);
+    req.on('close', () => {
+      clearInterval(heartbeat);
+      activityFocus.off('focus-changed', send);
+    });
+  });
+
+  // Diff the output of two sessions, per command if both have shell integration marks
+  router.get('/sessions/compare', async (req, res) => {
+    const { left, right } = req.query;
+    if (typeof left !== 'string' || typeof right !== 'string') {
+      return res.status(400).json({ error: 'left and right session IDs are required' });
+    }
+    const context =
+      req.query.context === undefined ? DEFAULT_CONTEXT_LINES : Number(req.query.context);
+    if (!Number.isInteger(context) || context < 0 || context > MAX_COMPARE_CONTEXT) {
+      return res
+        .status(400)
+        .json({ error: `context must be an integer between 0 and ${MAX_COMPARE_CONTEXT}` });
+    }
+    if (isHQMode && [left, right].some((id) => remoteRegistry?.getRemoteBySessionId(id))) {
+      return res.status(400).json({ error: 'Sessions on remote servers cannot be compared' });
+    }
+
+    const castPaths: string[] = [];
+    for (const sessionId of [left, right]) {
+      const session = ptyManager.getSession(sessionId);
+      if (!session) {
+        return sendError(res, 'SESSION_NOT_FOUND', `Session ${sessionId} not found`);
+      }
+      if (!mayUseSession(req, session)) {
+        return sendError(res, 'FORBIDDEN', 'Session belongs to another user');
       }
+      if (!(await restoreArchived(session))) {
+        return res.status(502).json({ error: 'Failed to restore archived recording' });
+      }
+      const stdoutPath = ptyManager.getSessionPaths(sessionId)?.stdoutPath;
+      if (!stdoutPath || !fs.existsSync(stdoutPath)) {
+        return res.status(404).json({ error: `Recording of session ${sessionId} not found` });
+      }
+      castPaths.push(stdoutPath);
+    }
+
+    try {
+      const [leftText, rightText] = await Promise.all(castPaths.map(readRecordingText));
+      res.json({ left, right, ...compareRecordings(leftText, rightText, context) });
+    } catch (error) {
+      logger.error(`error comparing sessions ${left} and ${right}:`, error);
+      res.status(500).json({ error: 'Failed to compare sessions' });
     }
   });
 
@@ -281,9 +713,10 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         // Fetch activity from each remote in parallel
         const remotePromises = remotes.map(async (remote) => {
           try {
-            const response = await fetch(`${remote.url}/api/sessions/activity`, {
+            const response = await tracedFetch(`${remote.url}/api/sessions/activity`, {
               headers: {
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
               signal: AbortSignal.timeout(5000),
             });
@@ -307,11 +740,13 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
 
         const remoteResults = await Promise.all(remotePromises);
 
-        // Merge remote activity data
+        // Merge remote activity data under namespaced session IDs
         for (const result of remoteResults) {
           if (result?.activity) {
-            // Merge remote activity data
-            Object.assign(activityStatus, result.activity);
+            for (const [id, activity] of Object.entries(result.activity)) {
+              activityStatus[namespaceSessionId(result.remote.name, id)] =
+                activity as SessionActivity;
+            }
           }
         }
       }
@@ -334,9 +769,10 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         if (remote) {
           // Forward to remote server
           try {
-            const response = await fetch(`${remote.url}/api/sessions/${sessionId}/activity`, {
+            const response = await tracedFetch(remoteSessionUrl(remote, sessionId, '/activity'), {
               headers: {
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
               signal: AbortSignal.timeout(5000),
             });
@@ -348,7 +784,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
             return res.json(await response.json());
           } catch (error) {
             logger.error(`failed to get activity from remote ${remote.name}:`, error);
-            return res.status(503).json({ error: 'Failed to reach remote server' });
+            return sendError(res, 'REMOTE_UNREACHABLE');
           }
         }
       }
@@ -356,7 +792,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       // Local session handling
       const activityStatus = activityMonitor.getSessionActivityStatus(sessionId);
       if (!activityStatus) {
-        return res.status(404).json({ error: 'Session not found' });
+        return sendError(res, 'SESSION_NOT_FOUND');
       }
       res.json(activityStatus);
     } catch (error) {
@@ -377,9 +813,10 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         if (remote) {
           // Forward to remote server
           try {
-            const response = await fetch(`${remote.url}/api/sessions/${sessionId}`, {
+            const response = await tracedFetch(remoteSessionUrl(remote, sessionId), {
               headers: {
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
               signal: AbortSignal.timeout(5000),
             });
@@ -388,10 +825,11 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
               return res.status(response.status).json(await response.json());
             }
 
-            return res.json(await response.json());
+            // Report the session under the ID HQ exposes it as
+            return res.json({ ...(await response.json()), id: sessionId });
           } catch (error) {
             logger.error(`failed to get session info from remote ${remote.name}:`, error);
-            return res.status(503).json({ error: 'Failed to reach remote server' });
+            return sendError(res, 'REMOTE_UNREACHABLE');
           }
         }
       }
@@ -400,7 +838,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       const session = ptyManager.getSession(sessionId);
 
       if (!session) {
-        return res.status(404).json({ error: 'Session not found' });
+        return sendError(res, 'SESSION_NOT_FOUND');
       }
       res.json(session);
     } catch (error) {
@@ -409,6 +847,87 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
     }
   });
 
+  // Change settings of a running session; currently its priority
+  router.patch('/sessions/:sessionId', async (req, res) => {
+    const { sessionId } = req.params;
+    const { priority } = req.body;
+    if (!isSessionPriority(priority)) {
+      return res
+        .status(400)
+        .json({ error: `priority must be one of ${SESSION_PRIORITIES.join(', ')}` });
+    }
+
+    try {
+      if (await forwardToRemote(sessionId, '', 'PATCH', req.body, res)) return;
+
+      const errors = await ptyManager.setSessionPriority(sessionId, priority);
+      // Partly applied priorities are kept; errors say what did not take effect
+      res.json({ sessionId, priority, ...(errors.length ? { errors } : {}) });
+    } catch (error) {
+      if (error instanceof PtyError && error.code === 'SESSION_NOT_FOUND') {
+        return sendError(res, 'SESSION_NOT_FOUND');
+      }
+      if (error instanceof PtyError && error.code === 'SESSION_NOT_RUNNING') {
+        return sendError(res, 'SESSION_NOT_RUNNING');
+      }
+      logger.error(`error setting priority of session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to set session priority' });
+    }
+  });
+
+  // Update recorded metadata: the working directory (for shells without OSC 7), a description
+  // and custom fields; null clears the description or a field
+  router.patch('/sessions/:sessionId/metadata', async (req, res) => {
+    const { sessionId } = req.params;
+    const { currentWorkingDir, description, fields } = req.body ?? {};
+    const message: MetadataControlMessage = {
+      cmd: 'metadata',
+      currentWorkingDir,
+      description,
+      fields,
+    };
+    const invalid = validateControlCommand(message);
+    if (invalid) {
+      return sendError(res, 'INVALID_REQUEST', invalid);
+    }
+
+    try {
+      if (await forwardToRemote(sessionId, 'metadata', 'PATCH', req.body, res)) return;
+
+      const ack = await ptyManager.updateSessionMetadata(sessionId, message);
+      if (!ack.ok) {
+        return sendError(res, 'INVALID_REQUEST', ack.error);
+      }
+      res.json({ sessionId, ...(ack.metadata as object) });
+    } catch (error) {
+      sendControlCommandError(res, sessionId, 'metadata', error);
+    }
+  });
+
+  // Change the environment of the commands a running session runs next. Shells with the env
+  // hook pick the changes up at their next prompt (`injected`); others only record them.
+  router.put('/sessions/:sessionId/env', async (req, res) => {
+    const { sessionId } = req.params;
+    const { set, unset } = req.body ?? {};
+    const message: EnvControlMessage = { cmd: 'env', set, unset };
+    const invalid = validateControlCommand(message);
+    if (invalid) {
+      return sendError(res, 'INVALID_REQUEST', invalid);
+    }
+
+    try {
+      if (await forwardToRemote(sessionId, 'env', 'PUT', req.body, res)) return;
+
+      const ack = await ptyManager.sendControlCommand(sessionId, message);
+      if (!ack.ok) {
+        return sendError(res, 'INVALID_REQUEST', ack.error);
+      }
+      res.json({ sessionId, env: ack.env, injected: ack.injected });
+    } catch (error) {
+      sendControlCommandError(res, sessionId, 'env', error);
+    }
+  });
+
   // Kill session (just kill the process)
   router.delete('/sessions/:sessionId', async (req, res) => {
     const sessionId = req.params.sessionId;
@@ -421,10 +940,11 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         if (remote) {
           // Forward kill request to remote server
           try {
-            const response = await fetch(`${remote.url}/api/sessions/${sessionId}`, {
+            const response = await tracedFetch(remoteSessionUrl(remote, sessionId), {
               method: 'DELETE',
               headers: {
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
               signal: AbortSignal.timeout(10000),
             });
@@ -440,7 +960,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
             return res.json(await response.json());
           } catch (error) {
             logger.error(`failed to kill session on remote ${remote.name}:`, error);
-            return res.status(503).json({ error: 'Failed to reach remote server' });
+            return sendError(res, 'REMOTE_UNREACHABLE');
           }
         }
       }
@@ -449,10 +969,13 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       const session = ptyManager.getSession(sessionId);
 
       if (!session) {
-        return res.status(404).json({ error: 'Session not found' });
+        return sendError(res, 'SESSION_NOT_FOUND');
       }
 
-      await ptyManager.killSession(sessionId, 'SIGTERM');
+      await withSpan('session.kill', { 'vibetunnel.session_id': sessionId }, () =>
+        ptyManager.killSession(sessionId, 'SIGTERM')
+      );
+      sizeNegotiator.clearSession(sessionId);
       logger.log(chalk.yellow(`local session ${sessionId} killed`));
 
       res.json({ success: true, message: 'Session killed' });
@@ -478,10 +1001,11 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         if (remote) {
           // Forward cleanup request to remote server
           try {
-            const response = await fetch(`${remote.url}/api/sessions/${sessionId}/cleanup`, {
+            const response = await tracedFetch(remoteSessionUrl(remote, sessionId, '/cleanup'), {
               method: 'DELETE',
               headers: {
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
               signal: AbortSignal.timeout(10000),
             });
@@ -497,13 +1021,14 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
             return res.json(await response.json());
           } catch (error) {
             logger.error(`failed to cleanup session on remote ${remote.name}:`, error);
-            return res.status(503).json({ error: 'Failed to reach remote server' });
+            return sendError(res, 'REMOTE_UNREACHABLE');
           }
         }
       }
 
       // Local session handling - just cleanup, no registry updates needed
       ptyManager.cleanupSession(sessionId);
+      sizeNegotiator.clearSession(sessionId);
       logger.log(chalk.yellow(`local session ${sessionId} cleaned up`));
 
       res.json({ success: true, message: 'Session cleaned up' });
@@ -518,7 +1043,11 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
   });
 
   // Cleanup all exited sessions (local and remote)
-  router.post('/cleanup-exited', async (_req, res) => {
+  router.post('/cleanup-exited', async (req, res) => {
+    // Exited sessions of every user are removed
+    if (localUsers && !isAdminRequest(req as AuthenticatedRequest, adminUsers)) {
+      return sendError(res, 'ADMIN_REQUIRED');
+    }
     logger.log(chalk.blue('cleaning up all exited sessions'));
     try {
       // Clean up local sessions
@@ -542,11 +1071,12 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         // Clean up on each remote in parallel
         const remoteCleanupPromises = allRemotes.map(async (remote) => {
           try {
-            const response = await fetch(`${remote.url}/api/cleanup-exited`, {
+            const response = await tracedFetch(`${remote.url}/api/cleanup-exited`, {
               method: 'POST',
               headers: {
                 'Content-Type': 'application/json',
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
               signal: AbortSignal.timeout(10000), // 10 second timeout
             });
@@ -559,7 +1089,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
 
               // Remove cleaned remote sessions from registry
               for (const sessionId of cleanedSessionIds) {
-                remoteRegistry.removeSessionFromRemote(sessionId);
+                remoteRegistry.removeSessionFromRemote(namespaceSessionId(remote.name, sessionId));
               }
 
               remoteResults.push({ remoteName: remote.name, cleaned: cleanedCount });
@@ -610,14 +1140,15 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         if (remote) {
           // Forward text request to remote server
           try {
-            const url = new URL(`${remote.url}/api/sessions/${sessionId}/text`);
+            const url = new URL(remoteSessionUrl(remote, sessionId, '/text'));
             if (includeStyles) {
               url.searchParams.set('styles', '');
             }
 
-            const response = await fetch(url.toString(), {
+            const response = await tracedFetch(url.toString(), {
               headers: {
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
               signal: AbortSignal.timeout(5000),
             });
@@ -632,7 +1163,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
             return res.send(text);
           } catch (error) {
             logger.error(`failed to get text from remote ${remote.name}:`, error);
-            return res.status(503).json({ error: 'Failed to reach remote server' });
+            return sendError(res, 'REMOTE_UNREACHABLE');
           }
         }
       }
@@ -640,7 +1171,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       // Local session handling
       const session = ptyManager.getSession(sessionId);
       if (!session) {
-        return res.status(404).json({ error: 'Session not found' });
+        return sendError(res, 'SESSION_NOT_FOUND');
       }
 
       // Get terminal buffer snapshot
@@ -658,6 +1189,86 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
     }
   });
 
+  // Wait for text on the screen or in new output, optionally sending input once listening
+  // ({ pattern, ignoreCase?, scope?: 'screen' | 'output', timeoutMs?, input?: { text } | { key } })
+  router.post('/sessions/:sessionId/wait', async (req, res) => {
+    const sessionId = req.params.sessionId;
+    const { pattern, ignoreCase, scope, timeoutMs, input } = req.body;
+
+    let inputData: SessionInput | undefined;
+    if (input !== undefined) {
+      if (typeof input?.text === 'string') {
+        inputData = { text: input.text };
+      } else if (typeof input?.key === 'string' && isSpecialKey(input.key)) {
+        inputData = { key: input.key };
+      } else {
+        return res
+          .status(400)
+          .json({ error: 'Input must be { text } or { key } with a known key' });
+      }
+    }
+
+    // Remote sessions wait on their remote, for as long as the wait may take
+    const remote = isHQMode && remoteRegistry?.getRemoteBySessionId(sessionId);
+    if (remote) {
+      try {
+        const waitMs = typeof timeoutMs === 'number' ? timeoutMs : DEFAULT_WAIT_TIMEOUT_MS;
+        const response = await tracedFetch(remoteSessionUrl(remote, sessionId, '/wait'), {
+          method: 'POST',
+          headers: {
+            'Content-Type': 'application/json',
+            Authorization: `Bearer ${remote.token}`,
+            ...requestIdHeaders(),
+            [INPUT_SOURCE_HEADER]: JSON.stringify(
+              inputSourceFromRequest(req as AuthenticatedRequest)
+            ),
+          },
+          body: JSON.stringify(req.body),
+          signal: AbortSignal.timeout(Math.min(waitMs, MAX_WAIT_TIMEOUT_MS) + 5000),
+        });
+        return res.status(response.status).json(await response.json());
+      } catch (error) {
+        logger.error(`failed to wait on remote ${remote.name}:`, error);
+        return sendError(res, 'REMOTE_UNREACHABLE');
+      }
+    }
+
+    const session = ptyManager.getSession(sessionId);
+    if (!session) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+    if (session.status !== 'running') {
+      return sendError(res, 'SESSION_NOT_RUNNING');
+    }
+    const lockToken = lockTokenFromRequest(req);
+    if (inputData && !inputLocks.checkInput(sessionId, lockToken)) {
+      return res.status(423).json({ ...INPUT_LOCKED_ERROR, lock: inputLocks.getLock(sessionId) });
+    }
+
+    // Clients giving up end the wait
+    const aborted = new AbortController();
+    res.on('close', () => aborted.abort());
+
+    const source = inputSourceFromRequest(req as AuthenticatedRequest);
+    const sendInput = inputData && (() => ptyManager.sendInput(sessionId, inputData, source));
+    try {
+      const result = await textWaiter.waitFor(
+        sessionId,
+        { pattern, ignoreCase: ignoreCase === true, scope, timeoutMs, signal: aborted.signal },
+        sendInput
+      );
+      if (result.reason !== 'aborted') {
+        res.json(result);
+      }
+    } catch (error) {
+      if (error instanceof WaitError) {
+        return res.status(error.status).json({ error: error.message });
+      }
+      logger.error(`error waiting for text in session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to wait for text' });
+    }
+  });
+
   // Get session buffer
   router.get('/sessions/:sessionId/buffer', async (req, res) => {
     const sessionId = req.params.sessionId;
@@ -671,9 +1282,10 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         if (remote) {
           // Forward buffer request to remote server
           try {
-            const response = await fetch(`${remote.url}/api/sessions/${sessionId}/buffer`, {
+            const response = await tracedFetch(remoteSessionUrl(remote, sessionId, '/buffer'), {
               headers: {
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
               signal: AbortSignal.timeout(5000),
             });
@@ -688,7 +1300,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
             return res.send(Buffer.from(buffer));
           } catch (error) {
             logger.error(`failed to get buffer from remote ${remote.name}:`, error);
-            return res.status(503).json({ error: 'Failed to reach remote server' });
+            return sendError(res, 'REMOTE_UNREACHABLE');
           }
         }
       }
@@ -697,7 +1309,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       const session = ptyManager.getSession(sessionId);
       if (!session) {
         logger.error(`session ${sessionId} not found`);
-        return res.status(404).json({ error: 'Session not found' });
+        return sendError(res, 'SESSION_NOT_FOUND');
       }
 
       // Get terminal buffer snapshot
@@ -720,132 +1332,407 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
     }
   });
 
-  // Stream session output
-  router.get('/sessions/:sessionId/stream', async (req, res) => {
-    const sessionId = req.params.sessionId;
-    const startTime = Date.now();
-
-    logger.log(
-      chalk.blue(
-        `new SSE client connected to session ${sessionId} from ${req.get('User-Agent')?.substring(0, 50) || 'unknown'}`
-      )
-    );
-
-    // If in HQ mode, check if this is a remote session
-    if (isHQMode && remoteRegistry) {
-      const remote = remoteRegistry.getRemoteBySessionId(sessionId);
-      if (remote) {
-        // Proxy SSE stream from remote server
-        try {
-          const controller = new AbortController();
-          const response = await fetch(`${remote.url}/api/sessions/${sessionId}/stream`, {
-            headers: {
-              Authorization: `Bearer ${remote.token}`,
-              Accept: 'text/event-stream',
-            },
-            signal: controller.signal,
-          });
+  // Render the current screen as an image (snapshot.png or snapshot.svg)
+  router.get('/sessions/:sessionId/snapshot.:format(png|svg)', async (req, res) => {
+    const { sessionId, format } = req.params;
+    const scale = req.query.scale !== undefined ? Number(req.query.scale) : 1;
+    const cursor = req.query.cursor !== 'false';
 
-          if (!response.ok) {
-            return res.status(response.status).json(await response.json());
-          }
+    if (!Number.isInteger(scale) || scale < 1 || scale > 4) {
+      return res.status(400).json({ error: 'scale must be an integer from 1 to 4' });
+    }
+    const contentType = format === 'png' ? 'image/png' : 'image/svg+xml';
 
-          // Set up SSE headers
-          res.writeHead(200, {
-            'Content-Type': 'text/event-stream',
-            'Cache-Control': 'no-cache',
-            Connection: 'keep-alive',
-            'Access-Control-Allow-Origin': '*',
-            'Access-Control-Allow-Headers': 'Cache-Control',
-            'X-Accel-Buffering': 'no',
-          });
+    try {
+      // If in HQ mode, check if this is a remote session
+      if (isHQMode && remoteRegistry) {
+        const remote = remoteRegistry.getRemoteBySessionId(sessionId);
+        if (remote) {
+          try {
+            const url = new URL(remoteSessionUrl(remote, sessionId, `/snapshot.${format}`));
+            url.searchParams.set('scale', String(scale));
+            url.searchParams.set('cursor', String(cursor));
 
-          // Proxy the stream
-          const reader = response.body?.getReader();
-          if (!reader) {
-            throw new Error('No response body');
-          }
+            const response = await tracedFetch(url.toString(), {
+              headers: {
+                Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
+              },
+              signal: AbortSignal.timeout(5000),
+            });
 
-          const decoder = new TextDecoder();
-          const bytesProxied = { count: 0 };
-          const pump = async () => {
-            try {
-              while (true) {
-                const { done, value } = await reader.read();
-                if (done) break;
-                bytesProxied.count += value.length;
-                const chunk = decoder.decode(value, { stream: true });
-                res.write(chunk);
-              }
-            } catch (error) {
-              logger.error(`stream proxy error for remote ${remote.name}:`, error);
+            if (!response.ok) {
+              return res.status(response.status).json(await response.json());
             }
-          };
-
-          pump();
-
-          // Clean up on disconnect
-          req.on('close', () => {
-            logger.log(
-              chalk.yellow(
-                `SSE client disconnected from remote session ${sessionId} (proxied ${bytesProxied.count} bytes)`
-              )
-            );
-            controller.abort();
-          });
 
-          return;
-        } catch (error) {
-          logger.error(`failed to stream from remote ${remote.name}:`, error);
-          return res.status(503).json({ error: 'Failed to reach remote server' });
+            const image = await response.arrayBuffer();
+            res.setHeader('Content-Type', contentType);
+            return res.send(Buffer.from(image));
+          } catch (error) {
+            logger.error(`failed to get snapshot from remote ${remote.name}:`, error);
+            return sendError(res, 'REMOTE_UNREACHABLE');
+          }
         }
       }
+
+      const session = ptyManager.getSession(sessionId);
+      if (!session) {
+        return sendError(res, 'SESSION_NOT_FOUND');
+      }
+
+      const snapshot = await terminalManager.getBufferSnapshot(sessionId);
+      const image =
+        format === 'png'
+          ? renderSnapshotPng(snapshot, { scale, cursor })
+          : renderSnapshotSvg(snapshot, { cursor });
+
+      logger.debug(
+        `rendered ${format} snapshot of session ${sessionId} (${snapshot.cols}x${snapshot.rows})`
+      );
+      res.setHeader('Content-Type', contentType);
+      res.setHeader('Cache-Control', 'no-store');
+      res.send(image);
+    } catch (error) {
+      logger.error(`error rendering snapshot of session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to render snapshot' });
     }
+  });
 
-    // Local session handling
-    const session = ptyManager.getSession(sessionId);
-    if (!session) {
-      return res.status(404).json({ error: 'Session not found' });
+  // Get the screen preview of a session
+  router.get('/sessions/:sessionId/thumbnail', async (req, res) => {
+    const { sessionId } = req.params;
+    const lines = parseThumbnailLines(req.query.lines ?? '') ?? DEFAULT_THUMBNAIL_LINES;
+
+    if (await forwardToRemote(sessionId, `thumbnail?lines=${lines}`, 'GET', undefined, res)) {
+      return;
     }
 
-    const sessionPaths = ptyManager.getSessionPaths(sessionId);
-    if (!sessionPaths) {
-      return res.status(404).json({ error: 'Session paths not found' });
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
     }
 
-    const streamPath = sessionPaths.stdoutPath;
-    if (!streamPath || !fs.existsSync(streamPath)) {
-      logger.warn(`stream path not found for session ${sessionId}`);
-      return res.status(404).json({ error: 'Session stream not found' });
+    try {
+      res.json(await thumbnails.getThumbnail(sessionId, lines));
+    } catch (error) {
+      logger.error(`error getting thumbnail of session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to get thumbnail' });
     }
+  });
 
-    // Set up SSE headers
-    res.writeHead(200, {
-      'Content-Type': 'text/event-stream',
-      'Cache-Control': 'no-cache',
-      Connection: 'keep-alive',
-      'Access-Control-Allow-Origin': '*',
-      'Access-Control-Allow-Headers': 'Cache-Control',
-      'X-Accel-Buffering': 'no', // Disable Nginx buffering
-      'Content-Encoding': 'identity', // Prevent compression
-    });
+  // Live I/O statistics of a session
+  router.get('/sessions/:sessionId/stats', async (req, res) => {
+    const { sessionId } = req.params;
 
-    // Force headers to be sent immediately
-    res.flushHeaders();
+    if (await forwardToRemote(sessionId, 'stats', 'GET', undefined, res)) {
+      return;
+    }
 
-    // Send initial connection event
-    res.write(':ok\n\n');
-    // @ts-expect-error - flush exists but not in types
-    if (res.flush) res.flush();
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
 
-    // Add client to stream watcher
-    streamWatcher.addClient(sessionId, streamPath, res);
-    logger.debug(`SSE stream setup completed in ${Date.now() - startTime}ms`);
+    const stats = ptyManager.getSessionStats(sessionId);
+    if (!stats) {
+      // Sessions owned by another process are not counted here
+      return res.json({
+        tracked: false,
+        recordingBytes: ptyManager.getRecordingSize(sessionId),
+      });
+    }
+    res.json({ tracked: true, ...stats });
+  });
 
-    // Send heartbeat every 30 seconds to keep connection alive
-    const heartbeat = setInterval(() => {
-      res.write(':heartbeat\n\n');
-      // @ts-expect-error - flush exists but not in types
+  // Commands the session ran, from OSC 133 marks or else the lines typed into it
+  router.get('/sessions/:sessionId/commands', async (req, res) => {
+    const { sessionId } = req.params;
+    // ?format=text lists just the command lines, ready to copy
+    const asText = req.query.format === 'text';
+
+    const remote = isHQMode && remoteRegistry?.getRemoteBySessionId(sessionId);
+    if (remote && asText) {
+      try {
+        const response = await tracedFetch(
+          remoteSessionUrl(remote, sessionId, '/commands?format=text'),
+          {
+            headers: { Authorization: `Bearer ${remote.token}`, ...requestIdHeaders() },
+            signal: AbortSignal.timeout(5000),
+          }
+        );
+        res.status(response.status).type(response.ok ? 'text/plain' : 'application/json');
+        return res.send(await response.text());
+      } catch (error) {
+        logger.error(`failed to get commands from remote ${remote.name}:`, error);
+        return sendError(res, 'REMOTE_UNREACHABLE');
+      }
+    }
+    if (await forwardToRemote(sessionId, 'commands', 'GET', undefined, res)) {
+      return;
+    }
+
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+
+    try {
+      const history = await ptyManager.getCommandHistory(sessionId);
+      if (asText) {
+        const lines = history.commands.map((entry) => `${entry.command}\n`);
+        return res.type('text/plain').send(lines.join(''));
+      }
+      res.json(history);
+    } catch (error) {
+      logger.error(`error reading commands of session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to read commands' });
+    }
+  });
+
+  // Export the session's commands as a shell script
+  router.get('/sessions/:sessionId/export', async (req, res) => {
+    const { sessionId } = req.params;
+    if (req.query.format !== 'script') {
+      return res
+        .status(400)
+        .json({ error: 'Unsupported export format', details: 'Use format=script' });
+    }
+
+    const remote = isHQMode && remoteRegistry?.getRemoteBySessionId(sessionId);
+    if (remote) {
+      try {
+        const response = await tracedFetch(
+          remoteSessionUrl(remote, sessionId, '/export?format=script'),
+          {
+            headers: { Authorization: `Bearer ${remote.token}`, ...requestIdHeaders() },
+            signal: AbortSignal.timeout(5000),
+          }
+        );
+        if (response.ok) {
+          const disposition = response.headers.get('Content-Disposition');
+          if (disposition) res.setHeader('Content-Disposition', disposition);
+        }
+        res.status(response.status).type(response.ok ? 'text/x-shellscript' : 'application/json');
+        return res.send(await response.text());
+      } catch (error) {
+        logger.error(`failed to export session from remote ${remote.name}:`, error);
+        return sendError(res, 'REMOTE_UNREACHABLE');
+      }
+    }
+
+    const session = ptyManager.getSession(sessionId);
+    if (!session) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+
+    try {
+      const history = await ptyManager.getCommandHistory(sessionId);
+      res.setHeader('Content-Disposition', `attachment; filename="session-${sessionId}.sh"`);
+      res.type('text/x-shellscript').send(commandScript(session, history));
+    } catch (error) {
+      logger.error(`error exporting session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to export session' });
+    }
+  });
+
+  // List the inline images a session printed
+  router.get('/sessions/:sessionId/images', async (req, res) => {
+    const { sessionId } = req.params;
+    if (await forwardToRemote(sessionId, 'images', 'GET', undefined, res)) {
+      return;
+    }
+
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+
+    try {
+      const images = await ptyManager.getInlineImages(sessionId);
+      res.json({
+        images: images.map((image) => ({ ...image, url: inlineImageUrl(sessionId, image.id) })),
+      });
+    } catch (error) {
+      logger.error(`error reading images of session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to read images' });
+    }
+  });
+
+  // Get an inline image a session printed
+  router.get('/sessions/:sessionId/images/:imageId', async (req, res) => {
+    const { sessionId, imageId } = req.params;
+
+    const remote = isHQMode && remoteRegistry?.getRemoteBySessionId(sessionId);
+    if (remote) {
+      try {
+        const response = await tracedFetch(
+          remoteSessionUrl(remote, sessionId, `/images/${imageId}`),
+          {
+            headers: { Authorization: `Bearer ${remote.token}`, ...requestIdHeaders() },
+            signal: AbortSignal.timeout(5000),
+          }
+        );
+        if (!response.ok) {
+          return res.status(response.status).json(await response.json());
+        }
+        const image = await response.arrayBuffer();
+        res.setHeader(
+          'Content-Type',
+          response.headers.get('Content-Type') ?? 'application/octet-stream'
+        );
+        return res.send(Buffer.from(image));
+      } catch (error) {
+        logger.error(`failed to get image from remote ${remote.name}:`, error);
+        return sendError(res, 'REMOTE_UNREACHABLE');
+      }
+    }
+
+    const imagePath = ptyManager.getInlineImagePath(sessionId, imageId);
+    if (!imagePath) {
+      return res.status(404).json({ error: 'Image not found' });
+    }
+    // Images never change once stored
+    res.sendFile(imagePath, { headers: { 'Cache-Control': 'private, max-age=86400' } });
+  });
+
+  // URLs and file paths in the session's recent output, for quick-open buttons
+  router.get('/sessions/:sessionId/links', async (req, res) => {
+    const { sessionId } = req.params;
+    const lines = Number(req.query.lines ?? DEFAULT_LINK_LINES);
+    const type = req.query.type;
+    if (!Number.isInteger(lines) || lines < 1) {
+      return res.status(400).json({ error: 'lines must be a positive integer' });
+    }
+    if (type !== undefined && type !== 'url' && type !== 'path') {
+      return res.status(400).json({ error: 'type must be url or path' });
+    }
+
+    const query = new URLSearchParams({ lines: String(lines), ...(type ? { type } : {}) });
+    if (await forwardToRemote(sessionId, `links?${query}`, 'GET', undefined, res)) {
+      return;
+    }
+
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+
+    try {
+      const output = await terminalManager.getRecentLines(
+        sessionId,
+        Math.min(lines, MAX_LINK_LINES)
+      );
+      res.json({ links: detectLinks(output, { types: type ? [type] : undefined }) });
+    } catch (error) {
+      logger.error(`error detecting links of session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to detect links' });
+    }
+  });
+
+  // Stream session output
+  router.get('/sessions/:sessionId/stream', async (req, res) => {
+    const sessionId = req.params.sessionId;
+    const startTime = Date.now();
+
+    const resumeOffset = lastEventOffset(req);
+    // New viewers get the rendered screen and then the tail; ?replay=full replays the whole cast
+    const fullReplay = req.query.replay === 'full';
+
+    logger.log(
+      chalk.blue(
+        `new SSE client connected to session ${sessionId} from ${req.get('User-Agent')?.substring(0, 50) || 'unknown'}`
+      )
+    );
+
+    const remotePath = fullReplay ? '/stream?replay=full' : '/stream';
+    if (await proxyRemoteStream(req as AuthenticatedRequest, res, remotePath, resumeOffset)) {
+      return;
+    }
+
+    // Local session handling
+    const session = ptyManager.getSession(sessionId);
+    if (!session) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+
+    const sessionPaths = ptyManager.getSessionPaths(sessionId);
+    if (!sessionPaths) {
+      return res.status(404).json({ error: 'Session paths not found' });
+    }
+
+    // Recordings deleted after archiving are downloaded again for playback
+    if (!(await restoreArchived(session))) {
+      return res.status(502).json({ error: 'Failed to restore archived recording' });
+    }
+
+    const streamPath = sessionPaths.stdoutPath;
+    if (!streamPath || !fs.existsSync(streamPath)) {
+      logger.warn(`stream path not found for session ${sessionId}`);
+      return res.status(404).json({ error: 'Session stream not found' });
+    }
+
+    // Render the current screen instead of replaying history; the stream continues after
+    // the offset the screen reflects. Exited sessions are replayed so the exit event is sent.
+    let startOffset = resumeOffset;
+    let snapshotEvents = '';
+    if (!resumeOffset && !fullReplay && session.status === 'running') {
+      try {
+        const { snapshot, offset, cols, rows } = await withSpan(
+          'session.stream.snapshot',
+          { 'vibetunnel.session_id': sessionId },
+          () => terminalManager.getSnapshotWithOffset(sessionId)
+        );
+        if (offset > 0) {
+          const header = JSON.stringify({ version: 2, width: cols, height: rows });
+          const screen = JSON.stringify([0, 'o', renderSnapshotAnsi(snapshot)]);
+          snapshotEvents = `id: ${offset}\ndata: ${header}\n\nid: ${offset}\ndata: ${screen}\n\n`;
+          startOffset = offset;
+        }
+      } catch (error) {
+        logger.warn(`failed to render snapshot for session ${sessionId}, replaying:`, error);
+      }
+    }
+
+    // Set up SSE headers
+    res.writeHead(200, {
+      'Content-Type': 'text/event-stream',
+      'Cache-Control': 'no-cache',
+      Connection: 'keep-alive',
+      'Access-Control-Allow-Origin': '*',
+      'Access-Control-Allow-Headers': 'Cache-Control',
+      'X-Accel-Buffering': 'no', // Disable Nginx buffering
+      'Content-Encoding': 'identity', // Prevent compression
+    });
+
+    // Force headers to be sent immediately
+    res.flushHeaders();
+
+    // Send initial connection event
+    res.write(':ok\n\n');
+    // @ts-expect-error - flush exists but not in types
+    if (res.flush) res.flush();
+
+    if (snapshotEvents) {
+      res.write(snapshotEvents);
+      logger.debug(`sent screen snapshot for session ${sessionId}, tailing from ${startOffset}`);
+    } else if (resumeOffset > 0) {
+      logger.debug(`resuming stream for session ${sessionId} after offset ${resumeOffset}`);
+    }
+
+    // Add client to stream watcher
+    streamWatcher.addClient(sessionId, streamPath, res, startOffset);
+    logger.debug(`SSE stream setup completed in ${Date.now() - startTime}ms`);
+    // The request span lasts as long as the stream; this marks when output starts flowing
+    getActiveSpan()?.addEvent('stream.ready', { 'vibetunnel.stream_offset': startOffset });
+
+    // Presence changes are named events, so clients only listening for output ignore them
+    const onPresenceChanged = (presence: SessionPresence) => {
+      if (presence.sessionId === sessionId) {
+        res.write(`event: presence\ndata: ${JSON.stringify(presence)}\n\n`);
+      }
+    };
+    viewerPresence.on('presence-changed', onPresenceChanged);
+    const presenceId = joinPresence(req as AuthenticatedRequest, sessionId);
+
+    // Send heartbeat every 30 seconds to keep connection alive
+    const heartbeat = setInterval(() => {
+      res.write(':heartbeat\n\n');
+      // @ts-expect-error - flush exists but not in types
       if (res.flush) res.flush();
     }, 30000);
 
@@ -857,6 +1744,8 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         logger.log(chalk.yellow(`SSE client disconnected from session ${sessionId}`));
         streamWatcher.removeClient(sessionId, res);
         clearInterval(heartbeat);
+        viewerPresence.off('presence-changed', onPresenceChanged);
+        if (presenceId) viewerPresence.leave(sessionId, presenceId);
       }
     };
 
@@ -870,13 +1759,96 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
     res.on('finish', cleanup);
   });
 
+  // Stream newly printed lines as plain text, for screen readers and low-powered clients
+  // (services/plain-text-stream.ts). ?replay=full starts with the whole recording.
+  router.get('/sessions/:sessionId/text/stream', async (req, res) => {
+    const { sessionId } = req.params;
+    const resumeOffset = lastEventOffset(req);
+    const fullReplay = req.query.replay === 'full';
+
+    const remotePath = fullReplay ? '/text/stream?replay=full' : '/text/stream';
+    if (await proxyRemoteStream(req as AuthenticatedRequest, res, remotePath, resumeOffset)) {
+      return;
+    }
+
+    const session = ptyManager.getSession(sessionId);
+    const streamPath = session && ptyManager.getSessionPaths(sessionId)?.stdoutPath;
+    if (!session || !streamPath) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+    if (!(await restoreArchived(session))) {
+      return res.status(502).json({ error: 'Failed to restore archived recording' });
+    }
+    if (!fs.existsSync(streamPath)) {
+      return sendError(res, 'NOT_FOUND', 'Session stream not found');
+    }
+
+    // Only what is printed from now on, unless resuming; exited sessions are replayed
+    // so their output and exit are sent
+    let startOffset = resumeOffset;
+    if (!resumeOffset && !fullReplay && session.status === 'running') {
+      startOffset = fs.statSync(streamPath).size;
+    }
+
+    res.writeHead(200, {
+      'Content-Type': 'text/event-stream',
+      'Cache-Control': 'no-cache',
+      Connection: 'keep-alive',
+      'X-Accel-Buffering': 'no',
+      'Content-Encoding': 'identity',
+    });
+    res.write(':ok\n\n');
+
+    const transform = new PlainTextTransform();
+    // A replay still reading when the client leaves writes to the destroyed transform
+    transform.on('error', (error) => logger.debug(`plain-text stream ${sessionId}: ${error}`));
+    transform.pipe(res);
+    streamWatcher.addClient(sessionId, streamPath, transform, startOffset);
+    logger.debug(`plain-text stream of session ${sessionId} from offset ${startOffset}`);
+
+    const heartbeat = setInterval(() => res.write(':heartbeat\n\n'), 30000);
+    let cleanedUp = false;
+    const cleanup = () => {
+      if (cleanedUp) return;
+      cleanedUp = true;
+      streamWatcher.removeClient(sessionId, transform);
+      transform.destroy();
+      clearInterval(heartbeat);
+    };
+    req.on('close', cleanup);
+    res.on('close', cleanup);
+    res.on('finish', cleanup);
+  });
+
   // Send input to session
   router.post('/sessions/:sessionId/input', async (req, res) => {
     const sessionId = req.params.sessionId;
-    const { text, key } = req.body;
+    const { text, key, composition } = req.body;
+
+    // Sequenced batch: { clientId?, seq, inputs: [{ text } | { key }, ...] }
+    const isBatch = req.body?.inputs !== undefined;
+    const parsedBatch = isBatch ? parseInputBatch(req.body) : null;
+    if (parsedBatch?.error) {
+      logger.warn(`invalid input batch for session ${sessionId}: ${parsedBatch.error}`);
+      return res.status(400).json({ error: parsedBatch.error });
+    }
+
+    // IME composition event: { composition: 'start' | 'update' | 'commit' | 'cancel', text }
+    const isComposition = !isBatch && composition !== undefined;
+    if (isComposition) {
+      const error = validateComposition(composition, text);
+      if (error) {
+        logger.warn(`invalid composition input for session ${sessionId}: ${error}`);
+        return res.status(400).json({ error });
+      }
+    }
 
     // Validate that only one of text or key is provided
-    if ((text === undefined && key === undefined) || (text !== undefined && key !== undefined)) {
+    if (
+      !isBatch &&
+      !isComposition &&
+      ((text === undefined && key === undefined) || (text !== undefined && key !== undefined))
+    ) {
       logger.warn(
         `invalid input request for session ${sessionId}: both or neither text/key provided`
       );
@@ -893,6 +1865,20 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       return res.status(400).json({ error: 'Key must be a string' });
     }
 
+    if (key !== undefined && !isComposition && !isSpecialKey(key)) {
+      logger.warn(`invalid input request for session ${sessionId}: unknown key ${key}`);
+      return res.status(400).json({ error: `Unknown key: ${key}` });
+    }
+
+    // HQ records the input of its own users; input it forwards is not counted again
+    const { userId, isHQRequest } = req as AuthenticatedRequest;
+    if (!isHQRequest) {
+      viewerPresence.recordInput(sessionId, userId);
+      collaboration.recordTyping(sessionId, userId);
+    }
+    const source = inputSourceFromRequest(req as AuthenticatedRequest);
+    const lockToken = lockTokenFromRequest(req);
+
     try {
       // If in HQ mode, check if this is a remote session
       if (isHQMode && remoteRegistry) {
@@ -900,11 +1886,14 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         if (remote) {
           // Forward input to remote server
           try {
-            const response = await fetch(`${remote.url}/api/sessions/${sessionId}/input`, {
+            const response = await tracedFetch(remoteSessionUrl(remote, sessionId, '/input'), {
               method: 'POST',
               headers: {
                 'Content-Type': 'application/json',
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
+                [INPUT_SOURCE_HEADER]: JSON.stringify(source),
+                ...(lockToken ? { [LOCK_TOKEN_HEADER]: lockToken } : {}),
               },
               body: JSON.stringify(req.body),
               signal: AbortSignal.timeout(5000),
@@ -917,7 +1906,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
             return res.json(await response.json());
           } catch (error) {
             logger.error(`failed to send input to remote ${remote.name}:`, error);
-            return res.status(503).json({ error: 'Failed to reach remote server' });
+            return sendError(res, 'REMOTE_UNREACHABLE');
           }
         }
       }
@@ -926,18 +1915,48 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       const session = ptyManager.getSession(sessionId);
       if (!session) {
         logger.error(`session ${sessionId} not found for input`);
-        return res.status(404).json({ error: 'Session not found' });
+        return sendError(res, 'SESSION_NOT_FOUND');
       }
 
       if (session.status !== 'running') {
         logger.error(`session ${sessionId} is not running (status: ${session.status})`);
-        return res.status(400).json({ error: 'Session is not running' });
+        return sendError(res, 'SESSION_NOT_RUNNING');
+      }
+
+      if (!inputLocks.checkInput(sessionId, lockToken)) {
+        logger.debug(`rejected input for locked session ${sessionId}`);
+        return res.status(423).json({ ...INPUT_LOCKED_ERROR, lock: inputLocks.getLock(sessionId) });
+      }
+
+      if (parsedBatch?.batch) {
+        const batch = parsedBatch.batch;
+        logger.debug(
+          `received input batch ${batch.seq} (${batch.inputs.length} inputs) for session ${sessionId}`
+        );
+        const result = withSpan(
+          'session.input',
+          { 'vibetunnel.session_id': sessionId, 'vibetunnel.input_count': batch.inputs.length },
+          () =>
+            inputSequencer.submit(sessionId, batch, (inputs) => {
+              for (const input of inputs) {
+                ptyManager.sendInput(sessionId, input, source);
+              }
+            })
+        );
+        return res.json({ success: true, ...result });
       }
 
-      const inputData = text !== undefined ? { text } : { key };
+      let inputData: SessionInput;
+      if (isComposition) {
+        inputData = { composition, text };
+      } else {
+        inputData = text !== undefined ? { text } : { key };
+      }
       logger.debug(`sending input to session ${sessionId}: ${JSON.stringify(inputData)}`);
 
-      ptyManager.sendInput(sessionId, inputData);
+      withSpan('session.input', { 'vibetunnel.session_id': sessionId }, () =>
+        ptyManager.sendInput(sessionId, inputData, source)
+      );
       res.json({ success: true });
     } catch (error) {
       logger.error('error sending input:', error);
@@ -953,6 +1972,9 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
   router.post('/sessions/:sessionId/resize', async (req, res) => {
     const sessionId = req.params.sessionId;
     const { cols, rows } = req.body;
+    // Requests without a viewer ID come from a single anonymous viewer
+    const viewerId =
+      typeof req.body.viewerId === 'string' && req.body.viewerId ? req.body.viewerId : 'default';
 
     if (typeof cols !== 'number' || typeof rows !== 'number') {
       logger.warn(`invalid resize request for session ${sessionId}: cols/rows not numbers`);
@@ -975,13 +1997,14 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         if (remote) {
           // Forward resize to remote server
           try {
-            const response = await fetch(`${remote.url}/api/sessions/${sessionId}/resize`, {
+            const response = await tracedFetch(remoteSessionUrl(remote, sessionId, '/resize'), {
               method: 'POST',
               headers: {
                 'Content-Type': 'application/json',
                 Authorization: `Bearer ${remote.token}`,
+                ...requestIdHeaders(),
               },
-              body: JSON.stringify({ cols, rows }),
+              body: JSON.stringify({ cols, rows, viewerId }),
               signal: AbortSignal.timeout(5000),
             });
 
@@ -992,7 +2015,7 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
             return res.json(await response.json());
           } catch (error) {
             logger.error(`failed to resize session on remote ${remote.name}:`, error);
-            return res.status(503).json({ error: 'Failed to reach remote server' });
+            return sendError(res, 'REMOTE_UNREACHABLE');
           }
         }
       }
@@ -1001,20 +2024,39 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       const session = ptyManager.getSession(sessionId);
       if (!session) {
         logger.warn(`session ${sessionId} not found for resize`);
-        return res.status(404).json({ error: 'Session not found' });
+        return sendError(res, 'SESSION_NOT_FOUND');
       }
 
       if (session.status !== 'running') {
         logger.warn(`session ${sessionId} is not running (status: ${session.status})`);
-        return res.status(400).json({ error: 'Session is not running' });
+        return sendError(res, 'SESSION_NOT_RUNNING');
       }
 
-      // Resize the session
-      ptyManager.resizeSession(sessionId, cols, rows);
-      logger.log(chalk.green(`session ${sessionId} resized to ${cols}x${rows}`));
+      if (ptyManager.getDoNotAllowColumnSet()) {
+        logger.debug(`rejecting resize of session ${sessionId}: resizing is disabled`);
+        return res.status(403).json(RESIZE_DISABLED_ERROR);
+      }
 
-      res.json({ success: true, cols, rows });
+      // Let the session's size policy decide what this viewer's size means for the PTY
+      const size = sizeNegotiator.reportViewerSize(sessionId, viewerId, { cols, rows });
+      if (size) {
+        ptyManager.resizeSession(sessionId, size.cols, size.rows);
+        logger.log(chalk.green(`session ${sessionId} resized to ${size.cols}x${size.rows}`));
+      } else {
+        logger.debug(`size policy kept session ${sessionId} size for viewer ${viewerId}`);
+      }
+
+      res.json({
+        success: true,
+        cols,
+        rows,
+        policy: sizeNegotiator.getSettings(sessionId).policy,
+        effectiveSize: sizeNegotiator.getEffectiveSize(sessionId),
+      });
     } catch (error) {
+      if (error instanceof PtyError && error.code === 'RESIZE_DISABLED') {
+        return res.status(403).json(RESIZE_DISABLED_ERROR);
+      }
       logger.error('error resizing session via PTY service:', error);
       if (error instanceof PtyError) {
         res.status(500).json({ error: 'Failed to resize session', details: error.message });
@@ -1034,11 +2076,12 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
         const remote = remoteRegistry.getRemoteBySessionId(sessionId);
         if (remote) {
           logger.debug(`forwarding reset-size to remote ${remote.id}`);
-          const response = await fetch(`${remote.url}/api/sessions/${sessionId}/reset-size`, {
+          const response = await tracedFetch(remoteSessionUrl(remote, sessionId, '/reset-size'), {
             method: 'POST',
             headers: {
               'Content-Type': 'application/json',
               Authorization: `Bearer ${remote.token}`,
+              ...requestIdHeaders(),
             },
           });
 
@@ -1058,13 +2101,13 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
       const session = ptyManager.getSession(sessionId);
       if (!session) {
         logger.error(`session ${sessionId} not found for reset-size`);
-        return res.status(404).json({ error: 'Session not found' });
+        return sendError(res, 'SESSION_NOT_FOUND');
       }
 
       // Check if session is running
       if (session.status !== 'running') {
         logger.error(`session ${sessionId} is not running (status: ${session.status})`);
-        return res.status(400).json({ error: 'Session is not running' });
+        return sendError(res, 'SESSION_NOT_RUNNING');
       }
 
       // Reset the session size
@@ -1082,6 +2125,603 @@ export function createSessionRoutes(config: SessionRoutesConfig): Router {
     }
   });
 
+  // Get the effective resize policy so clients can hide their resize UI
+  router.get('/resize-policy', (_req, res) => {
+    res.json({
+      resizeAllowed: !ptyManager.getDoNotAllowColumnSet(),
+      defaultSizePolicy: sizeNegotiator.getDefaultPolicy(),
+    });
+  });
+
+  // Get the terminal size policy of a session
+  router.get('/sessions/:sessionId/size-policy', async (req, res) => {
+    const { sessionId } = req.params;
+
+    try {
+      if (await forwardToRemote(sessionId, 'size-policy', 'GET', undefined, res)) return;
+
+      if (!ptyManager.getSession(sessionId)) {
+        return sendError(res, 'SESSION_NOT_FOUND');
+      }
+
+      res.json(sizePolicyResponse(sessionId));
+    } catch (error) {
+      logger.error(`error getting size policy for session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to get size policy' });
+    }
+  });
+
+  // Change the terminal size policy of a session
+  router.put('/sessions/:sessionId/size-policy', async (req, res) => {
+    const { sessionId } = req.params;
+    const { policy, ownerId, cols, rows } = req.body;
+
+    if (!isSizePolicy(policy)) {
+      return res.status(400).json({ error: 'Invalid size policy' });
+    }
+    if (ownerId !== undefined && typeof ownerId !== 'string') {
+      return res.status(400).json({ error: 'ownerId must be a string' });
+    }
+
+    const settings: SizePolicySettings = { policy, ownerId };
+    if (policy === 'fixed') {
+      if (
+        typeof cols !== 'number' ||
+        typeof rows !== 'number' ||
+        cols < 1 ||
+        rows < 1 ||
+        cols > 1000 ||
+        rows > 1000
+      ) {
+        return res
+          .status(400)
+          .json({ error: 'Fixed size policy requires cols and rows between 1 and 1000' });
+      }
+      settings.fixedSize = { cols, rows };
+    }
+
+    try {
+      if (await forwardToRemote(sessionId, 'size-policy', 'PUT', req.body, res)) return;
+
+      const session = ptyManager.getSession(sessionId);
+      if (!session) {
+        return sendError(res, 'SESSION_NOT_FOUND');
+      }
+
+      const size = sizeNegotiator.setSettings(sessionId, settings);
+      if (size && session.status === 'running' && !ptyManager.getDoNotAllowColumnSet()) {
+        ptyManager.resizeSession(sessionId, size.cols, size.rows);
+      }
+      logger.log(chalk.blue(`session ${sessionId} size policy set to ${policy}`));
+
+      res.json(sizePolicyResponse(sessionId));
+    } catch (error) {
+      logger.error(`error setting size policy for session ${sessionId}:`, error);
+      if (error instanceof PtyError) {
+        res.status(500).json({ error: 'Failed to set size policy', details: error.message });
+      } else {
+        res.status(500).json({ error: 'Failed to set size policy' });
+      }
+    }
+  });
+
+  // Remove a viewer from size negotiation (e.g. when its tab closes)
+  router.delete('/sessions/:sessionId/viewers/:viewerId', async (req, res) => {
+    const { sessionId, viewerId } = req.params;
+
+    try {
+      if (await forwardToRemote(sessionId, `viewers/${viewerId}`, 'DELETE', undefined, res)) {
+        return;
+      }
+
+      const session = ptyManager.getSession(sessionId);
+      if (!session) {
+        return sendError(res, 'SESSION_NOT_FOUND');
+      }
+
+      const size = sizeNegotiator.removeViewer(sessionId, viewerId);
+      if (size && session.status === 'running' && !ptyManager.getDoNotAllowColumnSet()) {
+        ptyManager.resizeSession(sessionId, size.cols, size.rows);
+        logger.debug(`session ${sessionId} resized to ${size.cols}x${size.rows} after viewer left`);
+      }
+
+      res.json({ success: true, effectiveSize: sizeNegotiator.getEffectiveSize(sessionId) });
+    } catch (error) {
+      logger.error(`error removing viewer ${viewerId} from session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to remove viewer' });
+    }
+  });
+
+  // Get the input lock of a session
+  router.get('/sessions/:sessionId/lock', async (req, res) => {
+    const { sessionId } = req.params;
+
+    if (await forwardToRemote(sessionId, 'lock', 'GET', undefined, res)) return;
+
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+    res.json({ lock: inputLocks.getLock(sessionId) });
+  });
+
+  // Take (or renew, with the lock token) exclusive input control of a session
+  router.post('/sessions/:sessionId/lock', async (req, res) => {
+    const { sessionId } = req.params;
+    const { ttlSeconds, holder, force } = req.body;
+
+    if (ttlSeconds !== undefined && (typeof ttlSeconds !== 'number' || ttlSeconds <= 0)) {
+      return res.status(400).json({ error: 'ttlSeconds must be a positive number' });
+    }
+    if (holder !== undefined && typeof holder !== 'string') {
+      return res.status(400).json({ error: 'holder must be a string' });
+    }
+    if (force && !isAdminRequest(req as AuthenticatedRequest, adminUsers)) {
+      return res.status(403).json({ error: 'Only admins can take over a lock' });
+    }
+
+    const lockToken = lockTokenFromRequest(req);
+    const body = { ...req.body, lockToken };
+    if (await forwardToRemote(sessionId, 'lock', 'POST', body, res)) return;
+
+    const session = ptyManager.getSession(sessionId);
+    if (!session) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+    if (session.status !== 'running') {
+      return sendError(res, 'SESSION_NOT_RUNNING');
+    }
+
+    const result = inputLocks.acquire(sessionId, {
+      userId: (req as AuthenticatedRequest).userId,
+      holder,
+      ttlMs: ttlSeconds !== undefined ? ttlSeconds * 1000 : undefined,
+      token: lockToken,
+      force: !!force,
+    });
+    if (!result.acquired) {
+      return res.status(409).json({ ...INPUT_LOCKED_ERROR, lock: result.lock });
+    }
+    res.json({ lockToken: result.token, lock: result.lock });
+  });
+
+  // Release the input lock of a session (admins may release it without the token)
+  router.delete('/sessions/:sessionId/lock', async (req, res) => {
+    const { sessionId } = req.params;
+    const lockToken = lockTokenFromRequest(req);
+    if (!lockToken && !isAdminRequest(req as AuthenticatedRequest, adminUsers)) {
+      return res.status(400).json({ error: 'Lock token required' });
+    }
+
+    if (await forwardToRemote(sessionId, 'lock', 'DELETE', { lockToken }, res)) return;
+
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+    if (!inputLocks.release(sessionId, lockToken)) {
+      return res.status(403).json({ error: 'Lock token does not match' });
+    }
+    res.json({ success: true });
+  });
+
+  // Clear the mark a trigger put on a session
+  router.delete('/sessions/:sessionId/mark', async (req, res) => {
+    const { sessionId } = req.params;
+    if (await forwardToRemote(sessionId, 'mark', 'DELETE', undefined, res)) return;
+
+    try {
+      if (!ptyManager.setSessionMark(sessionId, null)) {
+        return sendError(res, 'SESSION_NOT_FOUND');
+      }
+      res.json({ success: true });
+    } catch (error) {
+      logger.error(`error clearing mark of session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to clear mark' });
+    }
+  });
+
+  // Upload the recording of an exited session to object storage
+  router.post('/sessions/:sessionId/archive', async (req, res) => {
+    const { sessionId } = req.params;
+    if (await forwardToRemote(sessionId, 'archive', 'POST', undefined, res)) return;
+
+    if (!archiver) {
+      return sendError(res, 'NOT_CONFIGURED', 'Recording archiving is not configured');
+    }
+    try {
+      res.json({ archive: await archiver.archive(sessionId) });
+    } catch (error) {
+      if (error instanceof ArchiveError) {
+        return res.status(error.status).json({ error: error.message });
+      }
+      res.status(502).json({ error: 'Failed to archive recording' });
+    }
+  });
+
+  // Download an archived recording whose local copy was deleted
+  router.post('/sessions/:sessionId/restore', async (req, res) => {
+    const { sessionId } = req.params;
+    if (await forwardToRemote(sessionId, 'restore', 'POST', undefined, res)) return;
+
+    if (!archiver) {
+      return sendError(res, 'NOT_CONFIGURED', 'Recording archiving is not configured');
+    }
+    try {
+      res.json({ archive: await archiver.restore(sessionId) });
+    } catch (error) {
+      if (error instanceof ArchiveError) {
+        return res.status(error.status).json({ error: error.message });
+      }
+      res.status(502).json({ error: 'Failed to restore archived recording' });
+    }
+  });
+
+  // List the notes and bookmarks on a session's recording
+  router.get('/sessions/:sessionId/annotations', async (req, res) => {
+    const { sessionId } = req.params;
+    if (await forwardToRemote(sessionId, 'annotations', 'GET', undefined, res)) return;
+
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+    res.json({ annotations: annotations.list(sessionId) });
+  });
+
+  // Add a note or bookmark at a time in a session's recording
+  router.post('/sessions/:sessionId/annotations', async (req: AuthenticatedRequest, res) => {
+    const { sessionId } = req.params;
+    if (await forwardToRemote(sessionId, 'annotations', 'POST', req.body, res)) return;
+
+    const { text, time } = req.body;
+    if (typeof text !== 'string' || !text.trim() || text.length > MAX_ANNOTATION_LENGTH) {
+      return res
+        .status(400)
+        .json({ error: `text must be 1 to ${MAX_ANNOTATION_LENGTH} characters` });
+    }
+    if (time !== undefined && (typeof time !== 'number' || !Number.isFinite(time) || time < 0)) {
+      return res.status(400).json({ error: 'time must be a non-negative number of seconds' });
+    }
+
+    const session = ptyManager.getSession(sessionId);
+    if (!session) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+    if (time === undefined && session.status === 'exited') {
+      return res.status(400).json({ error: 'time is required for exited sessions' });
+    }
+
+    try {
+      const annotation = annotations.add(sessionId, {
+        // Defaults to now in running sessions
+        time: time ?? Math.max(0, (Date.now() - Date.parse(session.startedAt)) / 1000),
+        text: text.trim(),
+        author: req.userId,
+      });
+      streamWatcher.sendAnnotation(sessionId, annotation);
+      res.status(201).json(annotation);
+    } catch (error) {
+      if (error instanceof AnnotationError) {
+        return res.status(400).json({ error: error.message });
+      }
+      logger.error(`error annotating session ${sessionId}:`, error);
+      res.status(500).json({ error: 'Failed to add annotation' });
+    }
+  });
+
+  router.delete('/sessions/:sessionId/annotations/:annotationId', async (req, res) => {
+    const { sessionId, annotationId } = req.params;
+    const subPath = `annotations/${encodeURIComponent(annotationId)}`;
+    if (await forwardToRemote(sessionId, subPath, 'DELETE', undefined, res)) return;
+
+    if (!annotations.delete(sessionId, annotationId)) {
+      return res.status(404).json({ error: 'Annotation not found' });
+    }
+    res.json({ success: true });
+  });
+
+  // Stop delivering a session's output to viewers, optionally stopping its processes
+  router.post('/sessions/:sessionId/pause', async (req, res) => {
+    const { sessionId } = req.params;
+    const { signal } = req.body;
+
+    if (signal !== undefined && typeof signal !== 'boolean') {
+      return res.status(400).json({ error: 'signal must be a boolean' });
+    }
+
+    if (await forwardToRemote(sessionId, 'pause', 'POST', req.body, res)) return;
+
+    const session = ptyManager.getSession(sessionId);
+    if (!session) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+    if (session.status !== 'running') {
+      return sendError(res, 'SESSION_NOT_RUNNING');
+    }
+
+    try {
+      if (signal) {
+        ptyManager.signalProcessGroup(sessionId, 'SIGSTOP');
+      }
+      streamWatcher.pauseSession(sessionId);
+      terminalManager.pauseUpdates(sessionId);
+      const stopped = signal ? ' (processes stopped)' : '';
+      logger.log(chalk.yellow(`session ${sessionId} paused${stopped}`));
+      res.json(pauseResponse(sessionId));
+    } catch (error) {
+      logger.error(`error pausing session ${sessionId}:`, error);
+      if (error instanceof PtyError) {
+        res.status(500).json({ error: 'Failed to pause session', details: error.message });
+      } else {
+        res.status(500).json({ error: 'Failed to pause session' });
+      }
+    }
+  });
+
+  // Resume output delivery (and stopped processes); viewers get the current screen
+  router.post('/sessions/:sessionId/resume', async (req, res) => {
+    const { sessionId } = req.params;
+
+    if (await forwardToRemote(sessionId, 'resume', 'POST', req.body, res)) return;
+
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+
+    try {
+      if (ptyManager.isProcessGroupStopped(sessionId)) {
+        ptyManager.signalProcessGroup(sessionId, 'SIGCONT');
+      }
+
+      let redraw: { ansi: string; offset: number } | undefined;
+      if (streamWatcher.isPaused(sessionId)) {
+        try {
+          const { snapshot, offset } = await terminalManager.getSnapshotWithOffset(sessionId);
+          redraw = { ansi: renderSnapshotAnsi(snapshot), offset };
+        } catch (error) {
+          logger.warn(`failed to render snapshot for session ${sessionId}, sending held output`);
+          logger.debug('snapshot error:', error);
+        }
+      }
+      streamWatcher.resumeSession(sessionId, redraw);
+      terminalManager.resumeUpdates(sessionId);
+      logger.log(chalk.green(`session ${sessionId} resumed`));
+      res.json(pauseResponse(sessionId));
+    } catch (error) {
+      logger.error(`error resuming session ${sessionId}:`, error);
+      if (error instanceof PtyError) {
+        res.status(500).json({ error: 'Failed to resume session', details: error.message });
+      } else {
+        res.status(500).json({ error: 'Failed to resume session' });
+      }
+    }
+  });
+
+  // Get who is watching and typing into a session
+  router.get('/sessions/:sessionId/viewers', async (req, res) => {
+    const { sessionId } = req.params;
+
+    const remote = isHQMode && remoteRegistry?.getRemoteBySessionId(sessionId);
+    if (remote) {
+      // Viewers on the remote plus the ones watching through this HQ
+      try {
+        const response = await tracedFetch(remoteSessionUrl(remote, sessionId, '/viewers'), {
+          headers: { Authorization: `Bearer ${remote.token}`, ...requestIdHeaders() },
+          signal: AbortSignal.timeout(5000),
+        });
+        if (!response.ok) {
+          return res.status(response.status).json(await response.json());
+        }
+        const reported = (await response.json()) as SessionPresence;
+        const local = viewerPresence.getPresence(sessionId);
+        return res.json(mergeRemotePresence(local, reported, remote.name));
+      } catch (error) {
+        logger.error(`failed to get viewers from remote ${remote.name}:`, error);
+        return sendError(res, 'REMOTE_UNREACHABLE');
+      }
+    }
+
+    if (!ptyManager.getSession(sessionId)) {
+      return sendError(res, 'SESSION_NOT_FOUND');
+    }
+
+    res.json(viewerPresence.getPresence(sessionId));
+  });
+
+  // EventSource sends the ID of the last event it received when it reconnects; event IDs are
+  // stream file offsets, so a stream resumes right after it
+  function lastEventOffset(req: Request): number {
+    const lastEventId = req.get('Last-Event-ID') ?? (req.query.lastEventId as string | undefined);
+    return lastEventId && /^\d+$/.test(lastEventId) ? Number(lastEventId) : 0;
+  }
+
+  // Proxy an SSE stream of a remote's session (HQ mode); false if the session is not a remote's
+  async function proxyRemoteStream(
+    req: AuthenticatedRequest,
+    res: Response,
+    streamPath: string,
+    resumeOffset: number
+  ): Promise<boolean> {
+    const { sessionId } = req.params;
+    const remote = isHQMode && remoteRegistry?.getRemoteBySessionId(sessionId);
+    if (!remote) {
+      return false;
+    }
+
+    try {
+      const controller = new AbortController();
+      const response = await tracedFetch(remoteSessionUrl(remote, sessionId, streamPath), {
+        headers: {
+          Authorization: `Bearer ${remote.token}`,
+          ...requestIdHeaders(),
+          Accept: 'text/event-stream',
+          // Event IDs are the remote's offsets; it resumes the stream itself
+          ...(resumeOffset > 0 ? { 'Last-Event-ID': String(resumeOffset) } : {}),
+        },
+        signal: controller.signal,
+      });
+
+      if (!response.ok) {
+        res.status(response.status).json(await response.json());
+        return true;
+      }
+
+      // Set up SSE headers
+      res.writeHead(200, {
+        'Content-Type': 'text/event-stream',
+        'Cache-Control': 'no-cache',
+        Connection: 'keep-alive',
+        'Access-Control-Allow-Origin': '*',
+        'Access-Control-Allow-Headers': 'Cache-Control',
+        'X-Accel-Buffering': 'no',
+      });
+
+      // Proxy the stream
+      const reader = response.body?.getReader();
+      if (!reader) {
+        throw new Error('No response body');
+      }
+
+      const decoder = new TextDecoder();
+      const bytesProxied = { count: 0 };
+      const pump = async () => {
+        try {
+          while (true) {
+            const { done, value } = await reader.read();
+            if (done) break;
+            bytesProxied.count += value.length;
+            const chunk = decoder.decode(value, { stream: true });
+            res.write(chunk);
+          }
+        } catch (error) {
+          logger.error(`stream proxy error for remote ${remote.name}:`, error);
+        }
+      };
+
+      pump();
+
+      // The remote only sees HQ; its own clients are tracked here
+      const presenceId = joinPresence(req, sessionId);
+
+      // Clean up on disconnect
+      req.on('close', () => {
+        logger.log(
+          chalk.yellow(
+            `SSE client disconnected from remote session ${sessionId} (proxied ${bytesProxied.count} bytes)`
+          )
+        );
+        controller.abort();
+        if (presenceId) viewerPresence.leave(sessionId, presenceId);
+      });
+    } catch (error) {
+      logger.error(`failed to stream from remote ${remote.name}:`, error);
+      sendError(res, 'REMOTE_UNREACHABLE');
+    }
+    return true;
+  }
+
+  /**
+   * Add an SSE client to the viewers of a session. Returns null for HQ, which
+   * tracks the clients it proxies for itself.
+   */
+  function joinPresence(req: AuthenticatedRequest, sessionId: string): string | null {
+    if (req.isHQRequest) return null;
+    return viewerPresence.join(sessionId, 'sse', req.userId);
+  }
+
+  // Size policy of a session as returned by the size-policy endpoints
+  function sizePolicyResponse(sessionId: string) {
+    return {
+      ...sizeNegotiator.getSettings(sessionId),
+      effectiveSize: sizeNegotiator.getEffectiveSize(sessionId),
+      viewers: sizeNegotiator.getViewerCount(sessionId),
+      resizeAllowed: !ptyManager.getDoNotAllowColumnSet(),
+    };
+  }
+
+  // Thumbnail for the session list; sessions whose screen cannot be read get none
+  async function getThumbnail(sessionId: string, lines: number) {
+    try {
+      return await thumbnails.getThumbnail(sessionId, lines);
+    } catch (error) {
+      logger.debug(`no thumbnail for session ${sessionId}: ${error}`);
+      return undefined;
+    }
+  }
+
+  // Download a recording deleted after archiving; false if that failed
+  async function restoreArchived(session: Session): Promise<boolean> {
+    if (!session.archive?.localRemoved || !archiver) return true;
+    try {
+      await archiver.restore(session.id);
+      return true;
+    } catch (_error) {
+      return false;
+    }
+  }
+
+  // Pause state of a session as returned by the pause and resume endpoints
+  function pauseResponse(sessionId: string) {
+    return {
+      success: true,
+      paused: streamWatcher.isPaused(sessionId),
+      processesStopped: ptyManager.isProcessGroupStopped(sessionId),
+    };
+  }
+
+  // Map failures of a control command to API errors
+  function sendControlCommandError(
+    res: Response,
+    sessionId: string,
+    command: string,
+    error: unknown
+  ): void {
+    if (error instanceof PtyError && error.code === 'SESSION_NOT_FOUND') {
+      sendError(res, 'SESSION_NOT_FOUND');
+    } else if (error instanceof PtyError && error.code === 'SESSION_NOT_RUNNING') {
+      sendError(res, 'SESSION_NOT_RUNNING');
+    } else if (error instanceof PtyError && error.code === 'CONTROL_MESSAGE_FAILED') {
+      // The process owning the session did not answer
+      sendError(res, 'UNAVAILABLE', error.message);
+    } else {
+      logger.error(`error sending ${command} to session ${sessionId}:`, error);
+      sendError(res, 'INTERNAL_ERROR', `Failed to send ${command} to session`);
+    }
+  }
+
+  /**
+   * Forward a request for a remote session in HQ mode. Returns true if the
+   * session is remote and the response has been sent.
+   */
+  async function forwardToRemote(
+    sessionId: string,
+    subPath: string,
+    method: string,
+    body: unknown,
+    res: Response
+  ): Promise<boolean> {
+    const remote = isHQMode && remoteRegistry?.getRemoteBySessionId(sessionId);
+    if (!remote) {
+      return false;
+    }
+
+    try {
+      const response = await tracedFetch(remoteSessionUrl(remote, sessionId, `/${subPath}`), {
+        method,
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${remote.token}`,
+          ...requestIdHeaders(),
+        },
+        body: body === undefined ? undefined : JSON.stringify(body),
+        signal: AbortSignal.timeout(5000),
+      });
+      res.status(response.status).json(await response.json());
+    } catch (error) {
+      logger.error(`failed to forward ${method} ${subPath} to remote ${remote.name}:`, error);
+      sendError(res, 'REMOTE_UNREACHABLE');
+    }
+    return true;
+  }
+
   return router;
 }
 
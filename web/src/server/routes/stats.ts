@@ -0,0 +1,254 @@
+import { Router } from 'express';
+import type { PtyManager, PtyPoolProfileStats } from '../pty/index.js';
+import type { SessionStats } from '../pty/session-counters.js';
+import type { RemoteRegistry } from '../services/remote-registry.js';
+import type { StreamThrottleStats, StreamWatcher } from '../services/stream-watcher.js';
+import type { HostSystemStats, SystemStats } from '../services/system-stats.js';
+import { createLogger } from '../utils/logger.js';
+import { requestIdHeaders } from '../utils/request-context.js';
+import { tracedFetch } from '../utils/tracing.js';
+
+const logger = createLogger('stats');
+
+interface StatsRoutesConfig {
+  ptyManager: PtyManager;
+  systemStats: SystemStats;
+  // HQ only, for ?remotes on the system stats
+  remoteRegistry?: RemoteRegistry | null;
+  // For the state of the viewer output caps
+  streamWatcher?: StreamWatcher;
+}
+
+interface RemoteSystemStats {
+  remoteId: string;
+  remoteName: string;
+  stats?: HostSystemStats;
+  error?: string;
+}
+
+interface SessionStatsEntry extends SessionStats {
+  sessionId: string;
+  name: string;
+}
+
+interface Metric {
+  name: string;
+  type: 'counter' | 'gauge';
+  help: string;
+  value: (stats: SessionStats) => number;
+}
+
+const SESSION_METRICS: Metric[] = [
+  {
+    name: 'vibetunnel_session_output_bytes_total',
+    type: 'counter',
+    help: 'Bytes written by the session',
+    value: (stats) => stats.bytesOut,
+  },
+  {
+    name: 'vibetunnel_session_input_bytes_total',
+    type: 'counter',
+    help: 'Bytes sent to the session',
+    value: (stats) => stats.bytesIn,
+  },
+  {
+    name: 'vibetunnel_session_output_lines_total',
+    type: 'counter',
+    help: 'Lines written by the session',
+    value: (stats) => stats.linesOut,
+  },
+  {
+    name: 'vibetunnel_session_output_bytes_per_second',
+    type: 'gauge',
+    help: 'Output rate over the last seconds',
+    value: (stats) => stats.outputRate.bytesPerSecond,
+  },
+  {
+    name: 'vibetunnel_session_recording_bytes',
+    type: 'gauge',
+    help: 'Size of the session recording',
+    value: (stats) => stats.recordingBytes,
+  },
+];
+
+interface PoolMetric {
+  name: string;
+  type: 'counter' | 'gauge';
+  help: string;
+  value: (stats: PtyPoolProfileStats) => number | null;
+}
+
+const PTY_POOL_METRICS: PoolMetric[] = [
+  {
+    name: 'vibetunnel_pty_pool_ready',
+    type: 'gauge',
+    help: 'Pooled shells that have written their prompt',
+    value: (stats) => stats.ready,
+  },
+  {
+    name: 'vibetunnel_pty_pool_starting',
+    type: 'gauge',
+    help: 'Pooled shells still starting',
+    value: (stats) => stats.starting,
+  },
+  {
+    name: 'vibetunnel_pty_pool_hits_total',
+    type: 'counter',
+    help: 'Sessions that claimed a pooled shell',
+    value: (stats) => stats.hits,
+  },
+  {
+    name: 'vibetunnel_pty_pool_misses_total',
+    type: 'counter',
+    help: 'Sessions of a pooled profile that found no shell left',
+    value: (stats) => stats.misses,
+  },
+  {
+    name: 'vibetunnel_pty_pool_failures_total',
+    type: 'counter',
+    help: 'Pooled shells that failed to start',
+    value: (stats) => stats.failures,
+  },
+  {
+    name: 'vibetunnel_pty_pool_ready_milliseconds',
+    type: 'gauge',
+    help: 'Average time from spawning a pooled shell to its first output',
+    value: (stats) => stats.averageReadyMs,
+  },
+];
+
+interface ThrottleMetric {
+  name: string;
+  type: 'counter' | 'gauge';
+  help: string;
+  value: (stats: StreamThrottleStats) => number;
+}
+
+const THROTTLE_METRICS: ThrottleMetric[] = [
+  {
+    name: 'vibetunnel_session_throttled_viewers',
+    type: 'gauge',
+    help: 'Viewers whose output is skipped by an output cap',
+    value: (stats) => stats.throttledViewers,
+  },
+  {
+    name: 'vibetunnel_session_skipped_output_bytes_total',
+    type: 'counter',
+    help: 'Output bytes not sent to throttled viewers',
+    value: (stats) => stats.skippedBytes,
+  },
+];
+
+// Escape a Prometheus label value
+function labelValue(value: string): string {
+  return value.replace(/\\/g, '\\\\').replace(/"/g, '\\"').replace(/\n/g, '\\n');
+}
+
+export function createStatsRoutes(config: StatsRoutesConfig): Router {
+  const router = Router();
+  const { ptyManager, systemStats, remoteRegistry, streamWatcher } = config;
+
+  // Stats of every session running in this process
+  const collect = (): SessionStatsEntry[] => {
+    const entries: SessionStatsEntry[] = [];
+    for (const session of ptyManager.listSessions()) {
+      const stats = ptyManager.getSessionStats(session.id);
+      if (stats) {
+        entries.push({ sessionId: session.id, name: session.name, ...stats });
+      }
+    }
+    return entries;
+  };
+
+  // I/O statistics of all tracked sessions, with totals
+  router.get('/stats', (_req, res) => {
+    const sessions = collect();
+    const totals = { bytesOut: 0, bytesIn: 0, linesOut: 0, bytesPerSecond: 0, recordingBytes: 0 };
+    for (const stats of sessions) {
+      totals.bytesOut += stats.bytesOut;
+      totals.bytesIn += stats.bytesIn;
+      totals.linesOut += stats.linesOut;
+      totals.bytesPerSecond += stats.outputRate.bytesPerSecond;
+      totals.recordingBytes += stats.recordingBytes;
+    }
+    const ptyPool = ptyManager.getPtyPoolStats();
+    res.json({
+      sessions,
+      totals: { sessions: sessions.length, ...totals },
+      ...(ptyPool.length ? { ptyPool } : {}),
+      ...(streamWatcher ? { outputThrottle: streamWatcher.getThrottleStats() } : {}),
+    });
+  });
+
+  // The same statistics in the Prometheus text format
+  router.get('/stats/metrics', (_req, res) => {
+    const sessions = collect();
+    const lines = [
+      '# HELP vibetunnel_sessions Sessions running in this server process',
+      '# TYPE vibetunnel_sessions gauge',
+      `vibetunnel_sessions ${sessions.length}`,
+    ];
+    for (const { name, type, help, value } of SESSION_METRICS) {
+      lines.push(`# HELP ${name} ${help}`, `# TYPE ${name} ${type}`);
+      for (const stats of sessions) {
+        const labels = `session_id="${labelValue(stats.sessionId)}",name="${labelValue(stats.name)}"`;
+        lines.push(`${name}{${labels}} ${value(stats)}`);
+      }
+    }
+    const ptyPool = ptyManager.getPtyPoolStats();
+    for (const { name, type, help, value } of ptyPool.length ? PTY_POOL_METRICS : []) {
+      lines.push(`# HELP ${name} ${help}`, `# TYPE ${name} ${type}`);
+      for (const stats of ptyPool) {
+        const metricValue = value(stats);
+        if (metricValue === null) continue;
+        const command = labelValue(stats.command.join(' '));
+        const labels = `command="${command}",cwd="${labelValue(stats.workingDir)}"`;
+        lines.push(`${name}{${labels}} ${metricValue}`);
+      }
+    }
+    const streamed = streamWatcher?.getThrottleStats().sessions ?? [];
+    for (const { name, type, help, value } of streamed.length ? THROTTLE_METRICS : []) {
+      lines.push(`# HELP ${name} ${help}`, `# TYPE ${name} ${type}`);
+      for (const stats of streamed) {
+        lines.push(`${name}{session_id="${labelValue(stats.sessionId)}"} ${value(stats)}`);
+      }
+    }
+
+    res.type('text/plain; version=0.0.4').send(`${lines.join('\n')}\n`);
+  });
+
+  // CPU, load, memory and disk of this host; ?remotes adds every remote's (HQ only)
+  router.get('/stats/system', async (req, res) => {
+    try {
+      const stats = await systemStats.getStats();
+      if (req.query.remotes === undefined || !remoteRegistry) {
+        return res.json(stats);
+      }
+
+      const remotes = await Promise.all(
+        remoteRegistry.getRemotes().map(async (remote): Promise<RemoteSystemStats> => {
+          const entry = { remoteId: remote.id, remoteName: remote.name };
+          try {
+            const response = await tracedFetch(`${remote.url}/api/stats/system`, {
+              headers: { Authorization: `Bearer ${remote.token}`, ...requestIdHeaders() },
+              signal: AbortSignal.timeout(5000),
+            });
+            if (!response.ok) {
+              return { ...entry, error: `HTTP ${response.status}` };
+            }
+            return { ...entry, stats: (await response.json()) as HostSystemStats };
+          } catch (error) {
+            logger.warn(`failed to get system stats from remote ${remote.name}:`, error);
+            return { ...entry, error: error instanceof Error ? error.message : String(error) };
+          }
+        })
+      );
+      res.json({ ...stats, remotes });
+    } catch (error) {
+      logger.error('error getting system stats:', error);
+      res.status(500).json({ error: 'Failed to get system stats' });
+    }
+  });
+
+  return router;
+}
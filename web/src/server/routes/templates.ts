@@ -0,0 +1,204 @@
+import chalk from 'chalk';
+import { type Request, type Response, Router } from 'express';
+import * as fs from 'fs';
+import {
+  type AuthenticatedRequest,
+  isAdminRequest,
+  isOperatorRequest,
+} from '../middleware/auth.js';
+import {
+  PtyError,
+  type PtyManager,
+  type SessionInitOptions,
+  SessionLimitError,
+  supportsRcInit,
+  validateControlCommand,
+} from '../pty/index.js';
+import {
+  type SessionTemplate,
+  type SessionTemplateInput,
+  type SessionTemplateStore,
+  TemplateError,
+} from '../services/session-templates.js';
+import { sendError } from '../utils/api-error.js';
+import { accountForRequest, canAccessPath } from '../utils/local-accounts.js';
+import { createLogger } from '../utils/logger.js';
+import { resolvePath } from '../utils/path-utils.js';
+
+const logger = createLogger('templates');
+
+interface TemplateRoutesConfig {
+  ptyManager: PtyManager;
+  templateStore: SessionTemplateStore;
+  // Users who may change every template
+  adminUsers: string[];
+  // Run sessions as the local account of the requesting user
+  localUsers?: boolean;
+}
+
+/**
+ * Startup commands of a template as an init script: run from the shell's
+ * startup files where possible, typed in otherwise
+ */
+function startupInit(template: SessionTemplate, command: string[]): SessionInitOptions | undefined {
+  if (!template.startup?.length) return undefined;
+  return {
+    script: template.startup.join('\n'),
+    mode: supportsRcInit(command) ? 'rc' : 'stdin',
+  };
+}
+
+function sendTemplateError(res: Response, error: unknown): void {
+  if (error instanceof TemplateError) {
+    sendError(res, error.code, error.message);
+    return;
+  }
+  logger.error('error saving template:', error);
+  sendError(res, 'INTERNAL_ERROR', 'Failed to save template');
+}
+
+export function createTemplateRoutes(config: TemplateRoutesConfig): Router {
+  const router = Router();
+  const { ptyManager, templateStore, adminUsers, localUsers = false } = config;
+
+  const isAdmin = (req: Request) => isAdminRequest(req as AuthenticatedRequest, adminUsers);
+
+  // Users see shared templates and their own; admins see all
+  const isVisible = (req: Request, template: SessionTemplate) =>
+    !template.owner || template.owner === (req as AuthenticatedRequest).userId || isAdmin(req);
+
+  // Only the owner changes a template; shared templates are changed by admins
+  const mayChange = (req: Request, template: SessionTemplate) =>
+    isAdmin(req) || (!!template.owner && template.owner === (req as AuthenticatedRequest).userId);
+
+  // Look up a template of the request's path; sends the error and returns null if
+  // it is not visible or, with `change`, may not be changed by the requester
+  const findTemplate = (req: Request, res: Response, change = false): SessionTemplate | null => {
+    const template = templateStore.get(req.params.name);
+    if (!template || !isVisible(req, template)) {
+      sendError(res, 'TEMPLATE_NOT_FOUND');
+      return null;
+    }
+    if (change && !mayChange(req, template)) {
+      sendError(res, 'FORBIDDEN', 'Only the owner or an admin can change this template');
+      return null;
+    }
+    return template;
+  };
+
+  // List the templates of the requester
+  router.get('/templates', (req, res) => {
+    res.json({ templates: templateStore.list().filter((template) => isVisible(req, template)) });
+  });
+
+  // Add a template, owned by the requester; operators add shared templates
+  router.post('/templates', (req, res) => {
+    const authReq = req as AuthenticatedRequest;
+    const owner = isOperatorRequest(authReq) ? undefined : authReq.userId;
+    try {
+      res.status(201).json(templateStore.create(req.body as SessionTemplateInput, owner));
+    } catch (error) {
+      sendTemplateError(res, error);
+    }
+  });
+
+  // Get a single template
+  router.get('/templates/:name', (req, res) => {
+    const template = findTemplate(req, res);
+    if (template) res.json(template);
+  });
+
+  // Change a template; null removes a field
+  router.patch('/templates/:name', (req, res) => {
+    if (req.body?.name !== undefined && req.body.name !== req.params.name) {
+      return sendError(res, 'INVALID_REQUEST', 'Templates cannot be renamed');
+    }
+    if (!findTemplate(req, res, true)) return;
+    try {
+      const template = templateStore.update(req.params.name, req.body ?? {});
+      if (!template) {
+        return sendError(res, 'TEMPLATE_NOT_FOUND');
+      }
+      res.json(template);
+    } catch (error) {
+      sendTemplateError(res, error);
+    }
+  });
+
+  // Remove a template; sessions launched from it keep running
+  router.delete('/templates/:name', (req, res) => {
+    if (!findTemplate(req, res, true)) return;
+    if (!templateStore.delete(req.params.name)) {
+      return sendError(res, 'TEMPLATE_NOT_FOUND');
+    }
+    res.json({ success: true });
+  });
+
+  // Start a session from a template, optionally with another name, working
+  // directory or additional variables
+  router.post('/templates/:name/launch', async (req, res) => {
+    const template = findTemplate(req, res);
+    if (!template) return;
+
+    const { name, workingDir, env } = req.body ?? {};
+    if (name !== undefined && (typeof name !== 'string' || name.trim() === '')) {
+      return sendError(res, 'INVALID_REQUEST', 'name must be a non-empty string');
+    }
+    if (workingDir !== undefined && typeof workingDir !== 'string') {
+      return sendError(res, 'INVALID_REQUEST', 'workingDir must be a string');
+    }
+    const envError = env !== undefined ? validateControlCommand({ cmd: 'env', set: env }) : null;
+    if (envError) {
+      return sendError(res, 'INVALID_REQUEST', envError.replace(/^set /, 'env '));
+    }
+
+    const { userId } = req as AuthenticatedRequest;
+    const local = accountForRequest(req as AuthenticatedRequest, localUsers);
+    if (local.error) {
+      return sendError(res, 'FORBIDDEN', local.error);
+    }
+    const account = local.account;
+
+    // A template describes a reproducible environment: a missing directory is
+    // an error rather than a reason to start somewhere else
+    const defaultDir = account?.home ?? process.cwd();
+    const cwd = resolvePath(workingDir ?? template.workingDir ?? '', defaultDir, account?.home);
+    if (!fs.existsSync(cwd)) {
+      return sendError(res, 'INVALID_REQUEST', `Working directory ${cwd} does not exist`);
+    }
+    if (account && !(await canAccessPath(account, cwd, 'read'))) {
+      return sendError(res, 'FORBIDDEN', `${account.username} cannot access ${cwd}`);
+    }
+
+    const command = template.command ?? [account?.shell || process.env.SHELL || '/bin/sh'];
+    try {
+      const { sessionId } = await ptyManager.createSession(command, {
+        name: name?.trim() || template.name,
+        workingDir: cwd,
+        createdBy: userId,
+        runAs: account,
+        env: { ...template.env, ...env },
+        init: startupInit(template, command),
+        tags: template.tags,
+        priority: template.priority,
+        template: template.name,
+        ports: template.ports,
+      });
+      logger.log(chalk.green(`session ${sessionId} launched from template ${template.name}`));
+      res.json({ sessionId, template: template.name, ports: template.ports ?? [] });
+    } catch (error) {
+      if (error instanceof SessionLimitError) {
+        return res.status(429).json(error.toResponse());
+      }
+      logger.error(`error launching template ${template.name}:`, error);
+      sendError(
+        res,
+        'PTY_CREATE_FAILED',
+        undefined,
+        error instanceof PtyError ? error.message : undefined
+      );
+    }
+  });
+
+  return router;
+}
@@ -0,0 +1,93 @@
+import { type NextFunction, type Response, Router } from 'express';
+import { type AuthenticatedRequest, isAdminRequest } from '../middleware/auth.js';
+import {
+  API_TOKEN_SCOPES,
+  ApiTokenError,
+  type ApiTokenStore,
+  isApiTokenScope,
+} from '../services/api-tokens.js';
+import { sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('tokens');
+
+interface TokenRoutesConfig {
+  apiTokens: ApiTokenStore;
+  // Admins may list and revoke everyone's tokens and create admin tokens
+  adminUsers: string[];
+}
+
+/**
+ * Management of the signed-in user's API tokens
+ */
+export function createTokenRoutes(config: TokenRoutesConfig): Router {
+  const router = Router();
+  const { apiTokens, adminUsers } = config;
+
+  // Tokens are managed by users who signed in, not with other tokens or as HQ
+  router.use('/tokens', (req: AuthenticatedRequest, res: Response, next: NextFunction) => {
+    if (!req.userId || req.authMethod === 'api-token' || req.authMethod === 'hq-bearer') {
+      return sendError(res, 'FORBIDDEN', 'API tokens are managed by signed-in users');
+    }
+    next();
+  });
+
+  // Tokens of the current user; admins get everyone's with ?all=true
+  router.get('/tokens', (req: AuthenticatedRequest, res) => {
+    const all = req.query.all === 'true';
+    if (all && !isAdminRequest(req, adminUsers)) {
+      return sendError(res, 'ADMIN_REQUIRED');
+    }
+    res.json({
+      tokens: apiTokens.list(all ? undefined : req.userId),
+      scopes: API_TOKEN_SCOPES,
+    });
+  });
+
+  // Create a token; it is only returned in this response
+  router.post('/tokens', (req: AuthenticatedRequest, res) => {
+    const { name, scopes, expiresInDays } = req.body;
+    if (typeof name !== 'string') {
+      return res.status(400).json({ error: 'Name is required' });
+    }
+    if (!Array.isArray(scopes) || !scopes.every(isApiTokenScope)) {
+      return res
+        .status(400)
+        .json({ error: `Scopes must be an array of ${API_TOKEN_SCOPES.join(', ')}` });
+    }
+    if (expiresInDays !== undefined && typeof expiresInDays !== 'number') {
+      return res.status(400).json({ error: 'expiresInDays must be a number' });
+    }
+    // A token cannot do more than its user
+    if (scopes.includes('admin') && !isAdminRequest(req, adminUsers)) {
+      return sendError(res, 'ADMIN_REQUIRED', 'Only admins can create admin tokens');
+    }
+
+    try {
+      const { token, info } = apiTokens.create(req.userId as string, {
+        name,
+        scopes,
+        expiresInDays,
+      });
+      res.status(201).json({ ...info, token });
+    } catch (error) {
+      if (error instanceof ApiTokenError) {
+        return res.status(error.status).json({ error: error.message });
+      }
+      logger.error('failed to create api token:', error);
+      sendError(res, 'INTERNAL_ERROR', 'Failed to create token');
+    }
+  });
+
+  // Revoke a token of the current user (admins: any token)
+  router.delete('/tokens/:id', (req: AuthenticatedRequest, res) => {
+    const token = apiTokens.get(req.params.id);
+    if (!token || (token.userId !== req.userId && !isAdminRequest(req, adminUsers))) {
+      return sendError(res, 'NOT_FOUND', 'Token not found');
+    }
+    apiTokens.revoke(token.id);
+    res.json({ success: true });
+  });
+
+  return router;
+}
@@ -0,0 +1,167 @@
+import { Router } from 'express';
+import {
+  type TriggerAction,
+  type TriggerEngine,
+  TriggerError,
+  type TriggerInput,
+} from '../services/trigger-engine.js';
+import { sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('triggers');
+
+interface TriggerRoutesConfig {
+  triggers: TriggerEngine;
+}
+
+function isAction(value: unknown): value is TriggerAction {
+  if (!value || typeof value !== 'object') return false;
+  const action = value as Record<string, unknown>;
+  switch (action.type) {
+    case 'webhook':
+      return typeof action.url === 'string' && /^https?:\/\//.test(action.url);
+    case 'event':
+      return true;
+    case 'mark':
+      return action.label === undefined || typeof action.label === 'string';
+    case 'input':
+      return (
+        typeof action.text === 'string' &&
+        (action.enter === undefined || typeof action.enter === 'boolean')
+      );
+    default:
+      return false;
+  }
+}
+
+/**
+ * Validate a trigger body. With `partial` only the given fields are checked.
+ * Returns an error message or null.
+ */
+function validateTrigger(body: Record<string, unknown>, partial: boolean): string | null {
+  const { name, pattern, actions, ignoreCase, sessionId, enabled, cooldownMs } = body;
+  if ((!partial || name !== undefined) && (typeof name !== 'string' || name.trim() === '')) {
+    return 'Name is required';
+  }
+  if ((!partial || pattern !== undefined) && (typeof pattern !== 'string' || pattern === '')) {
+    return 'Pattern is required';
+  }
+  if (
+    (!partial || actions !== undefined) &&
+    (!Array.isArray(actions) || actions.length === 0 || !actions.every(isAction))
+  ) {
+    return 'Actions must be a non-empty list of webhook, event, mark or input actions';
+  }
+  if (ignoreCase !== undefined && typeof ignoreCase !== 'boolean') {
+    return 'ignoreCase must be a boolean';
+  }
+  if (sessionId !== undefined && (typeof sessionId !== 'string' || sessionId === '')) {
+    return 'sessionId must be a session ID';
+  }
+  if (enabled !== undefined && typeof enabled !== 'boolean') {
+    return 'Enabled must be a boolean';
+  }
+  if (
+    cooldownMs !== undefined &&
+    (typeof cooldownMs !== 'number' || !Number.isInteger(cooldownMs) || cooldownMs < 0)
+  ) {
+    return 'cooldownMs must be a non-negative integer';
+  }
+  return null;
+}
+
+export function createTriggerRoutes(config: TriggerRoutesConfig): Router {
+  const router = Router();
+  const { triggers } = config;
+
+  // List all triggers
+  router.get('/triggers', (_req, res) => {
+    res.json(triggers.list());
+  });
+
+  // Register a trigger
+  router.post('/triggers', (req, res) => {
+    const error = validateTrigger(req.body, false);
+    if (error) {
+      return res.status(400).json({ error });
+    }
+
+    const { name, pattern, actions, ignoreCase, sessionId, enabled, cooldownMs } =
+      req.body as TriggerInput;
+    try {
+      res.json(
+        triggers.create({
+          name: name.trim(),
+          pattern,
+          actions,
+          ignoreCase,
+          sessionId,
+          enabled,
+          cooldownMs,
+        })
+      );
+    } catch (error) {
+      if (error instanceof TriggerError) {
+        return res.status(400).json({ error: error.message });
+      }
+      logger.error('error creating trigger:', error);
+      res.status(500).json({ error: 'Failed to create trigger' });
+    }
+  });
+
+  // Get a single trigger
+  router.get('/triggers/:triggerId', (req, res) => {
+    const trigger = triggers.get(req.params.triggerId);
+    if (!trigger) {
+      return sendError(res, 'TRIGGER_NOT_FOUND');
+    }
+    res.json(trigger);
+  });
+
+  // Change a trigger (e.g. enable/disable it)
+  router.patch('/triggers/:triggerId', (req, res) => {
+    const error = validateTrigger(req.body, true);
+    if (error) {
+      return res.status(400).json({ error });
+    }
+
+    const { name, pattern, actions, ignoreCase, sessionId, enabled, cooldownMs } =
+      req.body as Partial<TriggerInput>;
+    const changes: Partial<TriggerInput> = {
+      pattern,
+      actions,
+      ignoreCase,
+      sessionId,
+      enabled,
+      cooldownMs,
+    };
+    if (name !== undefined) changes.name = name.trim();
+    for (const key of Object.keys(changes) as Array<keyof TriggerInput>) {
+      if (changes[key] === undefined) delete changes[key];
+    }
+
+    try {
+      const trigger = triggers.update(req.params.triggerId, changes);
+      if (!trigger) {
+        return sendError(res, 'TRIGGER_NOT_FOUND');
+      }
+      res.json(trigger);
+    } catch (error) {
+      if (error instanceof TriggerError) {
+        return res.status(400).json({ error: error.message });
+      }
+      logger.error(`error updating trigger ${req.params.triggerId}:`, error);
+      res.status(500).json({ error: 'Failed to update trigger' });
+    }
+  });
+
+  // Remove a trigger
+  router.delete('/triggers/:triggerId', (req, res) => {
+    if (!triggers.delete(req.params.triggerId)) {
+      return sendError(res, 'TRIGGER_NOT_FOUND');
+    }
+    res.json({ success: true });
+  });
+
+  return router;
+}
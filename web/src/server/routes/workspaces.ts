@@ -0,0 +1,132 @@
+import { type Response, Router } from 'express';
+import type { Session } from '../../shared/types.js';
+import type { PtyManager } from '../pty/index.js';
+import type { RemoteRegistry } from '../services/remote-registry.js';
+import {
+  summarizeWorkspaceActivity,
+  WorkspaceError,
+  type WorkspaceInput,
+  type WorkspaceStore,
+} from '../services/workspaces.js';
+import { sendError } from '../utils/api-error.js';
+import { createLogger } from '../utils/logger.js';
+import { requestIdHeaders } from '../utils/request-context.js';
+import { FEDERATION_DEPTH_HEADER } from '../utils/session-namespace.js';
+import { tracedFetch } from '../utils/tracing.js';
+
+const logger = createLogger('workspaces');
+
+interface WorkspaceRoutesConfig {
+  ptyManager: PtyManager;
+  workspaceStore: WorkspaceStore;
+  // HQ mode: sessions of workspaces on remotes count towards their activity
+  remoteRegistry: RemoteRegistry | null;
+  isHQMode: boolean;
+}
+
+function sendWorkspaceError(res: Response, error: unknown): void {
+  if (error instanceof WorkspaceError) {
+    sendError(res, error.code, error.message);
+    return;
+  }
+  logger.error('error saving workspace:', error);
+  sendError(res, 'INTERNAL_ERROR', 'Failed to save workspace');
+}
+
+export function createWorkspaceRoutes(config: WorkspaceRoutesConfig): Router {
+  const router = Router();
+  const { ptyManager, workspaceStore, remoteRegistry, isHQMode } = config;
+
+  // Sessions of a workspace on this server and, in HQ mode, on all remotes
+  async function listWorkspaceSessions(workspaceId: string): Promise<Session[]> {
+    const sessions = ptyManager
+      .listSessions()
+      .filter((session) => session.workspace === workspaceId);
+    if (!isHQMode || !remoteRegistry) return sessions;
+
+    const remoteResults = await Promise.all(
+      remoteRegistry.getRemotes().map(async (remote) => {
+        try {
+          const query = `?workspace=${encodeURIComponent(workspaceId)}`;
+          const response = await tracedFetch(`${remote.url}/api/sessions${query}`, {
+            headers: {
+              Authorization: `Bearer ${remote.token}`,
+              ...requestIdHeaders(),
+              [FEDERATION_DEPTH_HEADER]: '1',
+            },
+            signal: AbortSignal.timeout(5000),
+          });
+          if (response.ok) {
+            return (await response.json()) as Session[];
+          }
+          logger.warn(`failed to get sessions from remote ${remote.name}: HTTP ${response.status}`);
+        } catch (error) {
+          logger.error(`failed to get sessions from remote ${remote.name}:`, error);
+        }
+        return [];
+      })
+    );
+    return [...sessions, ...remoteResults.flat()];
+  }
+
+  // List all workspaces
+  router.get('/workspaces', (_req, res) => {
+    res.json({ workspaces: workspaceStore.list() });
+  });
+
+  // Add a workspace
+  router.post('/workspaces', (req, res) => {
+    try {
+      res.status(201).json(workspaceStore.create(req.body as WorkspaceInput));
+    } catch (error) {
+      sendWorkspaceError(res, error);
+    }
+  });
+
+  // Get a single workspace
+  router.get('/workspaces/:workspaceId', (req, res) => {
+    const workspace = workspaceStore.get(req.params.workspaceId);
+    if (!workspace) {
+      return sendError(res, 'WORKSPACE_NOT_FOUND');
+    }
+    res.json(workspace);
+  });
+
+  // Change a workspace; null removes the default remote
+  router.patch('/workspaces/:workspaceId', (req, res) => {
+    try {
+      const workspace = workspaceStore.update(req.params.workspaceId, req.body ?? {});
+      if (!workspace) {
+        return sendError(res, 'WORKSPACE_NOT_FOUND');
+      }
+      res.json(workspace);
+    } catch (error) {
+      sendWorkspaceError(res, error);
+    }
+  });
+
+  // Remove a workspace; its sessions keep running
+  router.delete('/workspaces/:workspaceId', (req, res) => {
+    if (!workspaceStore.delete(req.params.workspaceId)) {
+      return sendError(res, 'WORKSPACE_NOT_FOUND');
+    }
+    res.json({ success: true });
+  });
+
+  // Aggregate activity of the workspace's sessions
+  router.get('/workspaces/:workspaceId/activity', async (req, res) => {
+    const workspace = workspaceStore.get(req.params.workspaceId);
+    if (!workspace) {
+      return sendError(res, 'WORKSPACE_NOT_FOUND');
+    }
+    try {
+      const sessions = await listWorkspaceSessions(workspace.id);
+      res.json({ workspaceId: workspace.id, ...summarizeWorkspaceActivity(sessions) });
+    } catch (error) {
+      logger.error(`error getting activity of workspace ${workspace.name}:`, error);
+      sendError(res, 'INTERNAL_ERROR', 'Failed to get workspace activity');
+    }
+  });
+
+  return router;
+}
@@ -2,31 +2,113 @@ import chalk from 'chalk';
 import type { Response as ExpressResponse } from 'express';
 import express from 'express';
 import * as fs from 'fs';
-import { createServer } from 'http';
+import { createServer, type IncomingMessage } from 'http';
 import * as os from 'os';
 import * as path from 'path';
-import { v4 as uuidv4 } from 'uuid';
 import { WebSocketServer } from 'ws';
 import type { AuthenticatedRequest } from './middleware/auth.js';
 import { createAuthMiddleware } from './middleware/auth.js';
-import { PtyManager } from './pty/index.js';
+import { apiErrorHandler, errorEnvelope } from './middleware/error-envelope.js';
+import { createRateLimitMiddleware } from './middleware/rate-limit.js';
+import { assignRequestId } from './middleware/request-id.js';
+import { traceRequests } from './middleware/tracing.js';
+import {
+  type ControlRoot,
+  ControlRoots,
+  DEFAULT_CONTROL_ROOT,
+  PtyManager,
+  type PtyPoolProfile,
+  parseControlRoot,
+  parsePtyPoolProfile,
+} from './pty/index.js';
+import { createAdminRoutes } from './routes/admin.js';
 import { createAuthRoutes } from './routes/auth.js';
+import { createBatchRoutes } from './routes/batch.js';
+import { createChaosRoutes } from './routes/chaos.js';
+import { createCrashRoutes } from './routes/crashes.js';
+import { createDebugRoutes } from './routes/debug.js';
+import { createExecRoutes } from './routes/exec.js';
 import { createFilesystemRoutes } from './routes/filesystem.js';
+import { createGroupRoutes } from './routes/groups.js';
+import { createHQTokenRoutes } from './routes/hq-token.js';
 import { createLogRoutes } from './routes/logs.js';
+import { createOidcRoutes } from './routes/oidc.js';
+import { createMcpRoutes } from './routes/mcp.js';
+import { createPairingRoutes } from './routes/pairing.js';
 import { createPushRoutes } from './routes/push.js';
 import { createRemoteRoutes } from './routes/remotes.js';
+import { createScheduleRoutes } from './routes/schedules.js';
+import { createSecondFactorRoutes } from './routes/second-factor.js';
 import { createSessionRoutes } from './routes/sessions.js';
+import { createStatsRoutes } from './routes/stats.js';
+import { createTemplateRoutes } from './routes/templates.js';
+import { createTokenRoutes } from './routes/tokens.js';
+import { createTriggerRoutes } from './routes/triggers.js';
+import { createWorkspaceRoutes } from './routes/workspaces.js';
+import { ActivityFocus } from './services/activity-focus.js';
 import { ActivityMonitor } from './services/activity-monitor.js';
+import { AnnotationStore } from './services/annotation-store.js';
+import { ApiTokenStore } from './services/api-tokens.js';
 import { AuthService } from './services/auth-service.js';
 import { BellEventHandler } from './services/bell-event-handler.js';
-import { BufferAggregator } from './services/buffer-aggregator.js';
+import { BufferAggregator, type ClientIdentity } from './services/buffer-aggregator.js';
+import { CollaborationService } from './services/collaboration.js';
 import { ControlDirWatcher } from './services/control-dir-watcher.js';
+import { type CrashReport, CrashReporter, setCrashReporter } from './services/crash-reporter.js';
+import { fileWatcherPool } from './services/file-watcher-pool.js';
+import { DEFAULT_TRASH_RETENTION_DAYS, FsTrash } from './services/fs-trash.js';
 import { HQClient } from './services/hq-client.js';
+import { InputLockManager } from './services/input-lock.js';
+import { InputSequencer } from './services/input-sequencer.js';
+import { LogForwarder, type LogSinkConfig, parseLogSink } from './services/log-forwarder.js';
+import { McpServer } from './services/mcp-server.js';
+import { OidcService } from './services/oidc.js';
+import { PairingService } from './services/pairing.js';
+import {
+  createPasswordVerifier,
+  isPasswordBackend,
+  PASSWORD_BACKENDS,
+  type PasswordBackend,
+} from './services/password-verifier.js';
 import { PushNotificationService } from './services/push-notification-service.js';
+import {
+  type ArchiveConfig,
+  parseArchiveUrl,
+  RecordingArchiver,
+} from './services/recording-archiver.js';
+import { validateRemoteTags } from './services/remote-affinity.js';
 import { RemoteRegistry } from './services/remote-registry.js';
+import { RemoteTokenStore } from './services/remote-tokens.js';
+import type { OutputThrottleSettings } from './services/output-throttle.js';
+import { RuntimeConfig } from './services/runtime-config.js';
+import { Scheduler } from './services/scheduler.js';
+import { SecondFactorService } from './services/second-factor.js';
+import { handOffServer, SelfUpdater } from './services/self-updater.js';
+import { SessionGroupStore } from './services/session-groups.js';
+import { SessionTemplateStore } from './services/session-templates.js';
+import { isSizePolicy, SizeNegotiator, type SizePolicy } from './services/size-negotiator.js';
 import { StreamWatcher } from './services/stream-watcher.js';
+import { SystemStats } from './services/system-stats.js';
 import { TerminalManager } from './services/terminal-manager.js';
+import { TextWaiter } from './services/text-waiter.js';
+import { ThumbnailService } from './services/thumbnail-service.js';
+import { TriggerEngine } from './services/trigger-engine.js';
+import { ViewerPresence } from './services/viewer-presence.js';
+import { WorkspaceStore } from './services/workspaces.js';
+import { sendError } from './utils/api-error.js';
+import { snapshotBufferPool } from './utils/buffer-pool.js';
+import {
+  type FaultConfig,
+  FaultInjector,
+  parseFaultSpec,
+  setFaultInjector,
+} from './utils/fault-injection.js';
+import { type FsRoot, FsSandbox, parseFsRoot } from './utils/fs-sandbox.js';
+import { inputSourceFromRequest } from './utils/input-source.js';
 import { closeLogger, createLogger, initLogger, setDebugMode } from './utils/logger.js';
+import { configureRecordingEncryption, getRecordingCipher } from './utils/recording-crypto.js';
+import { renderSnapshotAnsi } from './utils/snapshot-ansi.js';
+import { configureTracing, parseOtlpHeaders, shutdownTracing } from './utils/tracing.js';
 import { VapidManager } from './utils/vapid-manager.js';
 import { getVersionInfo, printVersionBanner } from './version.js';
 
@@ -54,7 +136,13 @@ interface Config {
   hqUsername: string | null;
   hqPassword: string | null;
   remoteName: string | null;
+  // Tags of this remote, which workspace affinities on HQ match
+  remoteTags: string[];
   allowInsecureHQ: boolean;
+  // Shared secret signing HQ <-> remote registration and token rotation
+  hqSecret: string | null;
+  // Rotate remote tokens this often in HQ mode (0 disables rotation)
+  hqTokenRotationMinutes: number;
   showHelp: boolean;
   showVersion: boolean;
   debug: boolean;
@@ -68,6 +156,60 @@ interface Config {
   localAuthToken: string | null;
   // HQ auth bypass for testing
   noHqAuth: boolean;
+  // Users allowed to access the admin API
+  adminUsers: string[];
+  // Credential for /debug diagnostics endpoints (disabled when null)
+  debugToken: string | null;
+  // Quiet period before buffer change notifications are sent
+  bufferDebounceMs: number;
+  // Default policy for deciding the PTY size when several viewers watch a session
+  sizePolicy: SizePolicy;
+  // Upper bound for browser resizes applied per session and second
+  maxResizeRate: number;
+  // Reject resize requests from clients
+  doNotAllowColumnSet: boolean;
+  // Max concurrent sessions, in total and per user (0 = unlimited)
+  maxSessions: number;
+  maxSessionsPerUser: number;
+  // Output rate caps per viewer and per session, with burst allowance (0 = unlimited)
+  outputThrottle: OutputThrottleSettings;
+  // Delegated cgroup v2 directory sessions get their own cgroup in (priority weights)
+  sessionCgroup: string | null;
+  // Shells started in advance for new sessions to claim
+  ptyPool: PtyPoolProfile[];
+  // Sinks all session output is forwarded to
+  logForward: LogSinkConfig[];
+  // Where finished recordings are archived (s3://bucket/prefix)
+  archive: string | null;
+  archiveCompress: boolean;
+  archiveDeleteLocal: boolean;
+  archiveHook: string | null;
+  // OpenTelemetry collector spans are exported to (OTLP/HTTP)
+  otelEndpoint: string | null;
+  // Keys recordings are encrypted with (VIBETUNNEL_RECORDING_KEYS if unset)
+  recordingKeyFile: string | null;
+  // Control directories besides the default one, with the sessions routed to them
+  controlRoots: ControlRoot[];
+  // Directories the filesystem API may use (home and session directories if empty)
+  fsRoots: FsRoot[];
+  // Days deleted files stay in the trash (0: until emptied)
+  trashRetentionDays: number;
+  // Faults injected into HQ's connections to remotes (integration tests only)
+  chaos: FaultConfig | null;
+  // Single sign-on with an OpenID provider
+  oidcIssuer: string | null;
+  oidcClientId: string | null;
+  oidcClientSecret: string | null;
+  oidcRedirectUrl: string | null;
+  oidcUsernameClaim: string;
+  oidcAllowedGroups: string[];
+  oidcAllowedEmails: string[];
+  oidcOnly: boolean;
+  // How system account passwords are checked
+  passwordBackend: PasswordBackend;
+  passwordHelper: string | null;
+  // Run each user's sessions and commands as their local account
+  localUsers: boolean;
 }
 
 // Show help message
@@ -87,8 +229,61 @@ Options:
   --no-auth             Disable authentication (auto-login as current user)
   --allow-local-bypass  Allow localhost connections to bypass authentication
   --local-auth-token <token>  Token for localhost authentication bypass
+  --admin-user <user>   Grant admin API access to a user (repeatable)
+  --debug-token <token> Enable /debug diagnostics endpoints with this Bearer token
+  --buffer-debounce <ms>  Delay before sending buffer updates to clients (default: 50)
+  --size-policy <policy>  Default terminal size policy for sessions with several viewers:
+                        follow-last, largest-wins, owner-wins (default: follow-last)
+  --max-resize-rate <n>  Resizes applied per session and second, bursts are coalesced (default: 10)
+  --do-not-allow-column-set  Reject terminal resize requests from clients
+  --max-sessions <n>    Max concurrent sessions (default: unlimited)
+  --max-sessions-per-user <n>  Max concurrent sessions per user (default: unlimited)
+  --viewer-output-rate <bytes>  Output bytes per second sent to one viewer (default: unlimited)
+  --session-output-rate <bytes>  Output bytes per second sent to all viewers of a session
+                        (default: unlimited)
+  --output-burst <bytes>  Output bytes sent at once above these rates (default: one second's)
+  --session-cgroup <dir>  Delegated cgroup v2 directory; sessions get a cgroup in it whose
+                        cpu.weight and io.weight follow their priority
+  --pty-pool <command>[;cwd=<dir>][;size=<n>]  Keep shells started for new sessions with this
+                        command and directory (repeatable, default size 2)
+  --log-forward <target>  Forward session output as text (repeatable): file:///path,
+                        syslog://host[:port], syslog+tcp://host[:port], loki+http(s)://host[:port]
+  --archive <s3://bucket/prefix>  Upload recordings of finished sessions to S3-compatible storage
+  --archive-compress    Gzip recordings before uploading them
+  --archive-delete-local  Delete local recordings once archived (restored on playback)
+  --archive-hook <cmd>  Shell command run after each archive, restore or failure
+  --otel-endpoint <url>  Export OpenTelemetry traces to this OTLP/HTTP collector
+  --recording-key-file <path>  Encrypt recordings with the keys in this file (one
+                        <id>:<base64 key> or <id>:kms:<ciphertext> per line, current first)
+  --control-root <path>[?name=<name>&tags=a,b&users=u,v]  Additional control directory
+                        (repeatable); new sessions with a listed tag or creator go there
+  --fs-root <path>[?users=u,v]  Directory the file browser may use (repeatable, ~ is the user's
+                        home; default: home and the user's session directories)
+  --trash-retention <days>  Days files deleted through the file browser stay restorable
+                        (default: 7, 0: until the trash is emptied)
+  --chaos <spec>        TESTING ONLY: inject latency, drops and 5xx into requests to remotes
+                        (e.g. latency=200,jitter=100,drop=0.1,error=0.1,status=503,seed=7)
   --debug               Enable debug logging
 
+Single Sign-On Options:
+  --oidc-issuer <url>   Log in with this OpenID provider (Google, Okta, Keycloak, Dex, ...)
+  --oidc-client-id <id>  Client ID registered with the provider
+  --oidc-client-secret <secret>  Client secret (or VIBETUNNEL_OIDC_CLIENT_SECRET env var)
+  --oidc-redirect-url <url>  Callback URL registered with the provider
+                        (default: <request origin>/api/auth/oidc/callback)
+  --oidc-username-claim <claim>  Claim used as the user ID (default: email)
+  --oidc-allowed-group <group>  Allow members of this group (repeatable)
+  --oidc-allowed-email <email>  Allow this address, or a domain as @example.com (repeatable)
+  --oidc-only           Disable password and SSH key logins
+
+Local User Options:
+  --password-backend <backend>  How system passwords are checked: pam (default),
+                        shadow (/etc/shadow, needs root) or helper
+  --password-helper <cmd>  Program checking a username and password given on its stdin
+                        (pwauth style, exit 0 when valid) for --password-backend helper
+  --local-users         Run each user's sessions and commands as their local account and
+                        limit file browsing to what it can access (needs root)
+
 Push Notification Options:
   --push-enabled        Enable push notifications (default: enabled)
   --push-disabled       Disable push notifications
@@ -97,13 +292,18 @@ Push Notification Options:
 
 HQ Mode Options:
   --hq                  Run as HQ (headquarters) server
+  --hq-token-rotation <minutes>  Rotate the tokens issued to remotes (default: 60, 0 disables)
+                        Combine with the remote options to register this HQ with a
+                        higher-level HQ (federation)
 
 Remote Server Options:
   --hq-url <url>        HQ server URL to register with
   --hq-username <user>  Username for HQ authentication
   --hq-password <pass>  Password for HQ authentication
   --name <name>         Unique name for this remote server
+  --remote-tag <tag>    Tag this remote (e.g. gpu) for workspace affinities (repeatable)
   --allow-insecure-hq   Allow HTTP URLs for HQ (default: HTTPS only)
+  --hq-secret <secret>  Shared secret signing registration and token rotation (HQ and remotes)
   --no-hq-auth          Disable HQ authentication (for testing only)
 
 Environment Variables:
@@ -111,7 +311,31 @@ Environment Variables:
   VIBETUNNEL_USERNAME   Default username if --username not specified
   VIBETUNNEL_PASSWORD   Default password if --password not specified
   VIBETUNNEL_CONTROL_DIR Control directory for session data
+  VIBETUNNEL_CONTROL_ROOTS Semicolon-separated control roots if --control-root not specified
+  VIBETUNNEL_FS_ROOTS   Semicolon-separated filesystem roots if --fs-root not specified
   PUSH_CONTACT_EMAIL    Contact email for VAPID configuration
+  VIBETUNNEL_ADMIN_USERS Comma-separated list of admin users
+  VIBETUNNEL_DEBUG_TOKEN Token for /debug diagnostics if --debug-token not specified
+  VIBETUNNEL_HQ_SECRET  Shared HQ secret if --hq-secret not specified
+  VIBETUNNEL_LOG_FORWARD Comma-separated log forwarding targets if --log-forward not specified
+  VIBETUNNEL_ARCHIVE    Archive location if --archive not specified
+  VIBETUNNEL_CHAOS      Fault spec if --chaos not specified (testing only)
+  AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN  Credentials for --archive
+  AWS_REGION            Region of the archive bucket (default: us-east-1)
+  AWS_ENDPOINT_URL      S3-compatible endpoint for --archive (e.g. MinIO)
+  OTEL_EXPORTER_OTLP_ENDPOINT  Collector URL if --otel-endpoint not specified
+  OTEL_EXPORTER_OTLP_HEADERS   Collector headers (key=value,key=value)
+  OTEL_SERVICE_NAME     Service name of exported spans (default: vibetunnel)
+  VIBETUNNEL_RECORDING_KEY_FILE  Recording key file if --recording-key-file not specified
+  VIBETUNNEL_RECORDING_KEYS  Comma-separated recording keys if no key file is given
+  VIBETUNNEL_KMS_ENDPOINT  KMS endpoint for KMS-encrypted recording keys (default: AWS)
+  VIBETUNNEL_OIDC_ISSUER, VIBETUNNEL_OIDC_CLIENT_ID, VIBETUNNEL_OIDC_CLIENT_SECRET
+                        Single sign-on provider if the --oidc options are not specified
+  VIBETUNNEL_OIDC_ALLOWED_GROUPS, VIBETUNNEL_OIDC_ALLOWED_EMAILS  Comma-separated allow lists
+  VIBETUNNEL_PASSWORD_BACKEND, VIBETUNNEL_PASSWORD_HELPER  Password backend and helper if
+                        the --password options are not specified
+  VIBETUNNEL_LOCAL_USERS  Set to true for --local-users
+  VIBETUNNEL_REMOTE_TAGS  Comma-separated remote tags if --remote-tag not specified
 
 Examples:
   # Run a simple server with authentication
@@ -125,6 +349,12 @@ Examples:
     --hq-url https://hq.example.com \\
     --hq-username hq-admin --hq-password hq-secret \\
     --name remote-1
+
+  # Run a team HQ that re-exports its remotes to a global HQ
+  vibetunnel-server --hq --username team-admin --password team-secret \\
+    --hq-url https://global-hq.example.com \\
+    --hq-username hq-admin --hq-password hq-secret \\
+    --name team-a
 `);
 }
 
@@ -142,7 +372,13 @@ function parseArgs(): Config {
     hqUsername: null as string | null,
     hqPassword: null as string | null,
     remoteName: null as string | null,
+    // Tags of this remote, which workspace affinities on HQ match
+    remoteTags: [] as string[],
     allowInsecureHQ: false,
+    // Shared secret signing HQ <-> remote registration and token rotation
+    hqSecret: null as string | null,
+    // Rotate remote tokens this often in HQ mode (0 disables rotation)
+    hqTokenRotationMinutes: 60,
     showHelp: false,
     showVersion: false,
     debug: false,
@@ -156,7 +392,62 @@ function parseArgs(): Config {
     localAuthToken: null as string | null,
     // HQ auth bypass for testing
     noHqAuth: false,
+    // Users allowed to access the admin API
+    adminUsers: [] as string[],
+    // Credential for /debug diagnostics endpoints
+    debugToken: null as string | null,
+    // Quiet period before buffer change notifications are sent
+    bufferDebounceMs: 50,
+    // Default policy for deciding the PTY size when several viewers watch a session
+    sizePolicy: 'follow-last' as SizePolicy,
+    // Upper bound for browser resizes applied per session and second
+    maxResizeRate: 10,
+    // Reject resize requests from clients
+    doNotAllowColumnSet: false,
+    // Max concurrent sessions, in total and per user (0 = unlimited)
+    maxSessions: 0,
+    maxSessionsPerUser: 0,
+    // Output rate caps per viewer and per session, with burst allowance (0 = unlimited)
+    outputThrottle: { viewerBytesPerSecond: 0, sessionBytesPerSecond: 0, burstBytes: 0 },
+    // Delegated cgroup v2 directory sessions get their own cgroup in (priority weights)
+    sessionCgroup: null as string | null,
+    // Shells started in advance for new sessions to claim
+    ptyPool: [] as PtyPoolProfile[],
+    // Sinks all session output is forwarded to
+    logForward: [] as LogSinkConfig[],
+    // Where finished recordings are archived (s3://bucket/prefix)
+    archive: null as string | null,
+    archiveCompress: false,
+    archiveDeleteLocal: false,
+    archiveHook: null as string | null,
+    // OpenTelemetry collector spans are exported to (OTLP/HTTP)
+    otelEndpoint: null as string | null,
+    // Keys recordings are encrypted with (VIBETUNNEL_RECORDING_KEYS if unset)
+    recordingKeyFile: null as string | null,
+    // Control directories besides the default one, with the sessions routed to them
+    controlRoots: [] as ControlRoot[],
+    // Directories the filesystem API may use (home and session directories if empty)
+    fsRoots: [] as FsRoot[],
+    // Days deleted files stay in the trash (0: until emptied)
+    trashRetentionDays: DEFAULT_TRASH_RETENTION_DAYS,
+    // Faults injected into HQ's connections to remotes (integration tests only)
+    chaos: null as FaultConfig | null,
+    // Single sign-on with an OpenID provider
+    oidcIssuer: null as string | null,
+    oidcClientId: null as string | null,
+    oidcClientSecret: null as string | null,
+    oidcRedirectUrl: null as string | null,
+    oidcUsernameClaim: 'email',
+    oidcAllowedGroups: [] as string[],
+    oidcAllowedEmails: [] as string[],
+    oidcOnly: false,
+    // Local accounts: password backend and running sessions as the user
+    passwordBackend: 'pam' as PasswordBackend,
+    passwordHelper: null as string | null,
+    localUsers: false,
   };
+  // The environment only applies when no backend was given on the command line
+  let passwordBackendSet = false;
 
   // Check for help flag first
   if (args.includes('--help') || args.includes('-h')) {
@@ -199,8 +490,17 @@ function parseArgs(): Config {
     } else if (args[i] === '--name' && i + 1 < args.length) {
       config.remoteName = args[i + 1];
       i++; // Skip the name value in next iteration
+    } else if (args[i] === '--remote-tag' && i + 1 < args.length) {
+      config.remoteTags.push(args[i + 1]);
+      i++; // Skip the tag in next iteration
     } else if (args[i] === '--allow-insecure-hq') {
       config.allowInsecureHQ = true;
+    } else if (args[i] === '--hq-secret' && i + 1 < args.length) {
+      config.hqSecret = args[i + 1];
+      i++; // Skip the secret value in next iteration
+    } else if (args[i] === '--hq-token-rotation' && i + 1 < args.length) {
+      config.hqTokenRotationMinutes = Number.parseInt(args[i + 1], 10);
+      i++; // Skip the minutes value in next iteration
     } else if (args[i] === '--debug') {
       config.debug = true;
     } else if (args[i] === '--push-enabled') {
@@ -219,6 +519,118 @@ function parseArgs(): Config {
       i++; // Skip the token value in next iteration
     } else if (args[i] === '--no-hq-auth') {
       config.noHqAuth = true;
+    } else if (args[i] === '--admin-user' && i + 1 < args.length) {
+      config.adminUsers.push(args[i + 1]);
+      i++; // Skip the user value in next iteration
+    } else if (args[i] === '--debug-token' && i + 1 < args.length) {
+      config.debugToken = args[i + 1];
+      i++; // Skip the token value in next iteration
+    } else if (args[i] === '--buffer-debounce' && i + 1 < args.length) {
+      config.bufferDebounceMs = Number.parseInt(args[i + 1], 10);
+      i++; // Skip the debounce value in next iteration
+    } else if (args[i] === '--size-policy' && i + 1 < args.length) {
+      config.sizePolicy = args[i + 1] as SizePolicy;
+      i++; // Skip the policy value in next iteration
+    } else if (args[i] === '--max-resize-rate' && i + 1 < args.length) {
+      config.maxResizeRate = Number.parseInt(args[i + 1], 10);
+      i++; // Skip the rate value in next iteration
+    } else if (args[i] === '--do-not-allow-column-set') {
+      config.doNotAllowColumnSet = true;
+    } else if (args[i] === '--max-sessions' && i + 1 < args.length) {
+      config.maxSessions = Number(args[i + 1]);
+      i++; // Skip the limit value in next iteration
+    } else if (args[i] === '--max-sessions-per-user' && i + 1 < args.length) {
+      config.maxSessionsPerUser = Number(args[i + 1]);
+      i++; // Skip the limit value in next iteration
+    } else if (args[i] === '--viewer-output-rate' && i + 1 < args.length) {
+      config.outputThrottle.viewerBytesPerSecond = Number(args[i + 1]);
+      i++; // Skip the rate value in next iteration
+    } else if (args[i] === '--session-output-rate' && i + 1 < args.length) {
+      config.outputThrottle.sessionBytesPerSecond = Number(args[i + 1]);
+      i++; // Skip the rate value in next iteration
+    } else if (args[i] === '--output-burst' && i + 1 < args.length) {
+      config.outputThrottle.burstBytes = Number(args[i + 1]);
+      i++; // Skip the burst value in next iteration
+    } else if (args[i] === '--session-cgroup' && i + 1 < args.length) {
+      config.sessionCgroup = args[i + 1];
+      i++; // Skip the directory in next iteration
+    } else if (args[i] === '--pty-pool' && i + 1 < args.length) {
+      config.ptyPool.push(parsePtyPoolArg(args[i + 1]));
+      i++; // Skip the profile in next iteration
+    } else if (args[i] === '--log-forward' && i + 1 < args.length) {
+      config.logForward.push(parseLogForwardTarget(args[i + 1]));
+      i++; // Skip the target in next iteration
+    } else if (args[i] === '--archive' && i + 1 < args.length) {
+      config.archive = args[i + 1];
+      i++; // Skip the location in next iteration
+    } else if (args[i] === '--archive-compress') {
+      config.archiveCompress = true;
+    } else if (args[i] === '--archive-delete-local') {
+      config.archiveDeleteLocal = true;
+    } else if (args[i] === '--archive-hook' && i + 1 < args.length) {
+      config.archiveHook = args[i + 1];
+      i++; // Skip the command in next iteration
+    } else if (args[i] === '--otel-endpoint' && i + 1 < args.length) {
+      config.otelEndpoint = args[i + 1];
+      i++; // Skip the URL in next iteration
+    } else if (args[i] === '--recording-key-file' && i + 1 < args.length) {
+      config.recordingKeyFile = args[i + 1];
+      i++; // Skip the path in next iteration
+    } else if (args[i] === '--control-root' && i + 1 < args.length) {
+      config.controlRoots.push(parseControlRootArg(args[i + 1]));
+      i++; // Skip the root in next iteration
+    } else if (args[i] === '--fs-root' && i + 1 < args.length) {
+      config.fsRoots.push(parseFsRootArg(args[i + 1]));
+      i++; // Skip the root in next iteration
+    } else if (args[i] === '--trash-retention' && i + 1 < args.length) {
+      config.trashRetentionDays = Number(args[i + 1]);
+      if (!Number.isInteger(config.trashRetentionDays) || config.trashRetentionDays < 0) {
+        logger.error(`Invalid --trash-retention: ${args[i + 1]}`);
+        process.exit(1);
+      }
+      i++; // Skip the days in next iteration
+    } else if (args[i] === '--chaos' && i + 1 < args.length) {
+      config.chaos = parseChaosArg(args[i + 1]);
+      i++; // Skip the spec in next iteration
+    } else if (args[i] === '--oidc-issuer' && i + 1 < args.length) {
+      config.oidcIssuer = args[i + 1];
+      i++; // Skip the URL in next iteration
+    } else if (args[i] === '--oidc-client-id' && i + 1 < args.length) {
+      config.oidcClientId = args[i + 1];
+      i++; // Skip the ID in next iteration
+    } else if (args[i] === '--oidc-client-secret' && i + 1 < args.length) {
+      config.oidcClientSecret = args[i + 1];
+      i++; // Skip the secret in next iteration
+    } else if (args[i] === '--oidc-redirect-url' && i + 1 < args.length) {
+      config.oidcRedirectUrl = args[i + 1];
+      i++; // Skip the URL in next iteration
+    } else if (args[i] === '--oidc-username-claim' && i + 1 < args.length) {
+      config.oidcUsernameClaim = args[i + 1];
+      i++; // Skip the claim in next iteration
+    } else if (args[i] === '--oidc-allowed-group' && i + 1 < args.length) {
+      config.oidcAllowedGroups.push(args[i + 1]);
+      i++; // Skip the group in next iteration
+    } else if (args[i] === '--oidc-allowed-email' && i + 1 < args.length) {
+      config.oidcAllowedEmails.push(args[i + 1]);
+      i++; // Skip the address in next iteration
+    } else if (args[i] === '--oidc-only') {
+      config.oidcOnly = true;
+    } else if (args[i] === '--password-backend' && i + 1 < args.length) {
+      const backend = args[i + 1];
+      if (!isPasswordBackend(backend)) {
+        logger.error(
+          `Invalid password backend: ${backend} (must be one of ${PASSWORD_BACKENDS.join(', ')})`
+        );
+        process.exit(1);
+      }
+      config.passwordBackend = backend;
+      passwordBackendSet = true;
+      i++; // Skip the backend in next iteration
+    } else if (args[i] === '--password-helper' && i + 1 < args.length) {
+      config.passwordHelper = args[i + 1];
+      i++; // Skip the command in next iteration
+    } else if (args[i] === '--local-users') {
+      config.localUsers = true;
     } else if (args[i].startsWith('--')) {
       // Unknown argument
       logger.error(`Unknown argument: ${args[i]}`);
@@ -232,9 +644,184 @@ function parseArgs(): Config {
     config.vapidEmail = process.env.PUSH_CONTACT_EMAIL;
   }
 
+  // Check environment variables for admin users
+  if (process.env.VIBETUNNEL_ADMIN_USERS) {
+    const envAdmins = process.env.VIBETUNNEL_ADMIN_USERS.split(',')
+      .map((user) => user.trim())
+      .filter((user) => user.length > 0);
+    config.adminUsers.push(...envAdmins);
+  }
+
+  // Check environment variables for debug token
+  if (!config.debugToken && process.env.VIBETUNNEL_DEBUG_TOKEN) {
+    config.debugToken = process.env.VIBETUNNEL_DEBUG_TOKEN;
+  }
+
+  // Check environment variables for the shared HQ secret
+  if (!config.hqSecret && process.env.VIBETUNNEL_HQ_SECRET) {
+    config.hqSecret = process.env.VIBETUNNEL_HQ_SECRET;
+  }
+
+  // Check environment variables for session limits
+  if (!config.maxSessions && process.env.VIBETUNNEL_MAX_SESSIONS) {
+    config.maxSessions = Number(process.env.VIBETUNNEL_MAX_SESSIONS);
+  }
+  if (!config.maxSessionsPerUser && process.env.VIBETUNNEL_MAX_SESSIONS_PER_USER) {
+    config.maxSessionsPerUser = Number(process.env.VIBETUNNEL_MAX_SESSIONS_PER_USER);
+  }
+
+  // Check environment variables for log forwarding
+  if (config.logForward.length === 0 && process.env.VIBETUNNEL_LOG_FORWARD) {
+    config.logForward = process.env.VIBETUNNEL_LOG_FORWARD.split(',')
+      .map((target) => target.trim())
+      .filter(Boolean)
+      .map(parseLogForwardTarget);
+  }
+
+  // Check environment variables for recording archiving
+  if (!config.chaos && process.env.VIBETUNNEL_CHAOS) {
+    config.chaos = parseChaosArg(process.env.VIBETUNNEL_CHAOS);
+  }
+  if (!config.archive && process.env.VIBETUNNEL_ARCHIVE) {
+    config.archive = process.env.VIBETUNNEL_ARCHIVE;
+  }
+
+  // Check environment variables for control roots
+  if (config.controlRoots.length === 0 && process.env.VIBETUNNEL_CONTROL_ROOTS) {
+    config.controlRoots = process.env.VIBETUNNEL_CONTROL_ROOTS.split(';')
+      .map((root) => root.trim())
+      .filter(Boolean)
+      .map(parseControlRootArg);
+  }
+  if (config.fsRoots.length === 0 && process.env.VIBETUNNEL_FS_ROOTS) {
+    config.fsRoots = process.env.VIBETUNNEL_FS_ROOTS.split(';')
+      .map((root) => root.trim())
+      .filter(Boolean)
+      .map(parseFsRootArg);
+  }
+
+  // Check environment variables for tracing; the traces URL is used as it is
+  if (!config.otelEndpoint) {
+    config.otelEndpoint =
+      process.env.OTEL_EXPORTER_OTLP_TRACES_ENDPOINT ||
+      process.env.OTEL_EXPORTER_OTLP_ENDPOINT ||
+      null;
+  }
+
+  // Check environment variables for the recording key file
+  if (!config.recordingKeyFile && process.env.VIBETUNNEL_RECORDING_KEY_FILE) {
+    config.recordingKeyFile = process.env.VIBETUNNEL_RECORDING_KEY_FILE;
+  }
+
+  // Check environment variables for single sign-on
+  config.oidcIssuer ??= process.env.VIBETUNNEL_OIDC_ISSUER || null;
+  config.oidcClientId ??= process.env.VIBETUNNEL_OIDC_CLIENT_ID || null;
+  config.oidcClientSecret ??= process.env.VIBETUNNEL_OIDC_CLIENT_SECRET || null;
+  const splitList = (value: string | undefined) =>
+    (value ?? '')
+      .split(',')
+      .map((entry) => entry.trim())
+      .filter(Boolean);
+  if (config.oidcAllowedGroups.length === 0) {
+    config.oidcAllowedGroups = splitList(process.env.VIBETUNNEL_OIDC_ALLOWED_GROUPS);
+  }
+  if (config.oidcAllowedEmails.length === 0) {
+    config.oidcAllowedEmails = splitList(process.env.VIBETUNNEL_OIDC_ALLOWED_EMAILS);
+  }
+  if (config.remoteTags.length === 0) {
+    config.remoteTags = splitList(process.env.VIBETUNNEL_REMOTE_TAGS);
+  }
+
+  // Check environment variables for local users
+  const envBackend = process.env.VIBETUNNEL_PASSWORD_BACKEND;
+  if (!passwordBackendSet && envBackend) {
+    if (!isPasswordBackend(envBackend)) {
+      logger.error(`Invalid VIBETUNNEL_PASSWORD_BACKEND: ${envBackend}`);
+      process.exit(1);
+    }
+    config.passwordBackend = envBackend;
+  }
+  config.passwordHelper ??= process.env.VIBETUNNEL_PASSWORD_HELPER || null;
+  if (process.env.VIBETUNNEL_LOCAL_USERS === 'true') {
+    config.localUsers = true;
+  }
+
   return config;
 }
 
+// Parse a --log-forward target, exiting on invalid ones
+function parseLogForwardTarget(target: string): LogSinkConfig {
+  try {
+    return parseLogSink(target);
+  } catch (error) {
+    logger.error(`Invalid --log-forward target: ${target}`);
+    logger.error(error instanceof Error ? error.message : String(error));
+    process.exit(1);
+  }
+}
+
+// Parse a --pty-pool profile, exiting on invalid ones
+function parsePtyPoolArg(spec: string): PtyPoolProfile {
+  try {
+    return parsePtyPoolProfile(spec);
+  } catch (error) {
+    logger.error(`Invalid --pty-pool: ${spec}`);
+    logger.error(error instanceof Error ? error.message : String(error));
+    process.exit(1);
+  }
+}
+
+// Parse a --fs-root value, exiting on invalid ones
+function parseFsRootArg(spec: string): FsRoot {
+  try {
+    return parseFsRoot(spec);
+  } catch (error) {
+    logger.error(`Invalid --fs-root: ${spec}`);
+    logger.error(error instanceof Error ? error.message : String(error));
+    process.exit(1);
+  }
+}
+
+// Parse a --control-root value, exiting on invalid ones
+function parseControlRootArg(spec: string): ControlRoot {
+  try {
+    return parseControlRoot(spec);
+  } catch (error) {
+    logger.error(`Invalid --control-root: ${spec}`);
+    logger.error(error instanceof Error ? error.message : String(error));
+    process.exit(1);
+  }
+}
+
+// Parse a --chaos fault spec, exiting on invalid ones
+function parseChaosArg(spec: string): FaultConfig {
+  try {
+    return parseFaultSpec(spec);
+  } catch (error) {
+    logger.error(`Invalid --chaos: ${spec}`);
+    logger.error(error instanceof Error ? error.message : String(error));
+    process.exit(1);
+  }
+}
+
+// Archive settings from --archive and the AWS environment variables
+function createArchiveConfig(config: ReturnType<typeof parseArgs>): ArchiveConfig | null {
+  if (!config.archive) return null;
+  return {
+    ...parseArchiveUrl(config.archive),
+    region: process.env.AWS_REGION || process.env.AWS_DEFAULT_REGION || 'us-east-1',
+    credentials: {
+      accessKeyId: process.env.AWS_ACCESS_KEY_ID || '',
+      secretAccessKey: process.env.AWS_SECRET_ACCESS_KEY || '',
+      sessionToken: process.env.AWS_SESSION_TOKEN,
+    },
+    endpoint: process.env.AWS_ENDPOINT_URL,
+    compress: config.archiveCompress,
+    deleteLocal: config.archiveDeleteLocal,
+    hook: config.archiveHook ?? undefined,
+  };
+}
+
 // Validate configuration
 function validateConfig(config: ReturnType<typeof parseArgs>) {
   // Validate auth configuration
@@ -249,6 +836,33 @@ function validateConfig(config: ReturnType<typeof parseArgs>) {
     config.enableSSHKeys = true;
   }
 
+  // Validate single sign-on configuration
+  if (config.oidcIssuer && (!config.oidcClientId || !config.oidcClientSecret)) {
+    logger.error('--oidc-issuer requires --oidc-client-id and --oidc-client-secret');
+    process.exit(1);
+  }
+  if (config.oidcIssuer && !/^https?:\/\//.test(config.oidcIssuer)) {
+    logger.error(`Invalid --oidc-issuer: ${config.oidcIssuer}`);
+    process.exit(1);
+  }
+  if (config.oidcOnly && !config.oidcIssuer) {
+    logger.error('--oidc-only requires --oidc-issuer');
+    process.exit(1);
+  }
+
+  // Validate local user configuration
+  if (config.passwordBackend === 'helper' && !config.passwordHelper) {
+    logger.error('--password-backend helper requires --password-helper');
+    process.exit(1);
+  }
+  if (config.localUsers && process.getuid?.() !== 0) {
+    logger.error('--local-users requires the server to run as root');
+    process.exit(1);
+  }
+  if (config.localUsers && config.noAuth) {
+    logger.warn('--local-users has no effect with --no-auth, sessions run as the server user');
+  }
+
   // Validate HQ registration configuration
   if (config.hqUrl && (!config.hqUsername || !config.hqPassword) && !config.noHqAuth) {
     logger.error('HQ username and password required when --hq-url is specified');
@@ -264,6 +878,17 @@ function validateConfig(config: ReturnType<typeof parseArgs>) {
     process.exit(1);
   }
 
+  // Remote names prefix session IDs at HQ (<remoteName>/<id>)
+  if (config.remoteName?.includes('/')) {
+    logger.error("Remote name must not contain '/'");
+    process.exit(1);
+  }
+  const remoteTagsError = config.remoteTags.length ? validateRemoteTags(config.remoteTags) : null;
+  if (remoteTagsError) {
+    logger.error(`Invalid --remote-tag: ${remoteTagsError}`);
+    process.exit(1);
+  }
+
   // Validate HQ URL is HTTPS unless explicitly allowed
   if (config.hqUrl && !config.hqUrl.startsWith('https://') && !config.allowInsecureHQ) {
     logger.error('HQ URL must use HTTPS protocol');
@@ -282,10 +907,14 @@ function validateConfig(config: ReturnType<typeof parseArgs>) {
     process.exit(1);
   }
 
-  // Can't be both HQ mode and register with HQ
+  // HQ mode and registering with an HQ: federated HQ
   if (config.isHQMode && config.hqUrl) {
-    logger.error('Cannot use --hq and --hq-url together');
-    logger.error('Use --hq to run as HQ server, or --hq-url to register with an HQ');
+    logger.log(`Federated HQ: re-exporting remote sessions to ${config.hqUrl}`);
+  }
+
+  // Validate token rotation interval
+  if (Number.isNaN(config.hqTokenRotationMinutes) || config.hqTokenRotationMinutes < 0) {
+    logger.error('Token rotation interval must be a non-negative number of minutes');
     process.exit(1);
   }
 
@@ -294,6 +923,80 @@ function validateConfig(config: ReturnType<typeof parseArgs>) {
     logger.warn('--no-hq-auth is enabled: Remote servers can register without authentication');
     logger.warn('This should only be used for testing!');
   }
+
+  // Validate buffer debounce
+  if (
+    Number.isNaN(config.bufferDebounceMs) ||
+    config.bufferDebounceMs < 0 ||
+    config.bufferDebounceMs > 1000
+  ) {
+    logger.error('--buffer-debounce must be between 0 and 1000 milliseconds');
+    process.exit(1);
+  }
+
+  // Validate size policy (fixed needs a size and is only set per session)
+  if (!isSizePolicy(config.sizePolicy) || config.sizePolicy === 'fixed') {
+    logger.error('--size-policy must be one of: follow-last, largest-wins, owner-wins');
+    process.exit(1);
+  }
+
+  // Validate resize rate
+  if (Number.isNaN(config.maxResizeRate) || config.maxResizeRate < 1 || config.maxResizeRate > 60) {
+    logger.error('--max-resize-rate must be between 1 and 60 per second');
+    process.exit(1);
+  }
+
+  // Validate session limits
+  for (const [flag, limit] of [
+    ['--max-sessions', config.maxSessions],
+    ['--max-sessions-per-user', config.maxSessionsPerUser],
+    ['--viewer-output-rate', config.outputThrottle.viewerBytesPerSecond],
+    ['--session-output-rate', config.outputThrottle.sessionBytesPerSecond],
+    ['--output-burst', config.outputThrottle.burstBytes],
+  ] as const) {
+    if (!Number.isInteger(limit) || limit < 0) {
+      logger.error(`${flag} must be a non-negative integer`);
+      process.exit(1);
+    }
+  }
+
+  // The session cgroup must be a delegated cgroup v2 directory
+  if (config.sessionCgroup && !fs.existsSync(path.join(config.sessionCgroup, 'cgroup.procs'))) {
+    logger.error(`--session-cgroup ${config.sessionCgroup} is not a cgroup v2 directory`);
+    process.exit(1);
+  }
+
+  // Validate recording archiving
+  if (config.archive) {
+    try {
+      parseArchiveUrl(config.archive);
+    } catch (error) {
+      logger.error(error instanceof Error ? error.message : String(error));
+      process.exit(1);
+    }
+    if (!process.env.AWS_ACCESS_KEY_ID || !process.env.AWS_SECRET_ACCESS_KEY) {
+      logger.error('--archive requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY');
+      process.exit(1);
+    }
+  } else if (config.archiveCompress || config.archiveDeleteLocal || config.archiveHook) {
+    logger.warn('--archive-* options have no effect without --archive');
+  }
+
+  // Control root names identify roots in session.json and must be unique
+  const rootNames = new Set<string>([DEFAULT_CONTROL_ROOT]);
+  for (const root of config.controlRoots) {
+    if (rootNames.has(root.name)) {
+      logger.error(`Duplicate control root name: ${root.name} (set one with ?name=)`);
+      process.exit(1);
+    }
+    rootNames.add(root.name);
+  }
+
+  // Validate the tracing collector
+  if (config.otelEndpoint && !/^https?:\/\/[^/]/.test(config.otelEndpoint)) {
+    logger.error('--otel-endpoint must be an http(s) URL');
+    process.exit(1);
+  }
 }
 
 interface AppInstance {
@@ -311,6 +1014,10 @@ interface AppInstance {
   bufferAggregator: BufferAggregator | null;
   activityMonitor: ActivityMonitor;
   pushNotificationService: PushNotificationService | null;
+  runtimeConfig: RuntimeConfig;
+  scheduler: Scheduler;
+  logForwarder: LogForwarder;
+  fsTrash: FsTrash;
 }
 
 // Track if app has been created
@@ -351,9 +1058,28 @@ export async function createApp(): Promise<AppInstance> {
   const app = express();
   const server = createServer(app);
   const wss = new WebSocketServer({ noServer: true });
+  // Who each upgrade request was authenticated as, for viewer presence
+  const clientIdentities = new WeakMap<IncomingMessage, ClientIdentity>();
 
   // Add JSON body parser middleware
   app.use(express.json());
+  // Tag each request with an X-Request-ID for correlating logs across HQ and remotes
+  app.use(assignRequestId);
+  // Trace API requests when an OpenTelemetry collector is configured
+  if (config.otelEndpoint) {
+    configureTracing({
+      endpoint: config.otelEndpoint,
+      serviceName: process.env.OTEL_SERVICE_NAME || 'vibetunnel',
+      headers: parseOtlpHeaders(process.env.OTEL_EXPORTER_OTLP_HEADERS),
+      resource: {
+        'service.instance.id': config.remoteName ?? os.hostname(),
+        'vibetunnel.role': config.isHQMode ? 'hq' : config.hqUrl ? 'remote' : 'standalone',
+      },
+    });
+  }
+  app.use('/api', traceRequests);
+  // Error responses of all API routes carry a machine-readable code
+  app.use('/api', errorEnvelope);
   logger.debug('Configured express middleware');
 
   // Control directory for session data
@@ -368,22 +1094,140 @@ export async function createApp(): Promise<AppInstance> {
     logger.debug(`Using existing control directory: ${CONTROL_DIR}`);
   }
 
+  // Initialize runtime configuration (adjustable via the admin API)
+  const runtimeConfig = new RuntimeConfig({
+    logLevel: config.debug ? 'debug' : 'info',
+    sessionLimits: {
+      maxSessions: config.maxSessions,
+      maxSessionsPerUser: config.maxSessionsPerUser,
+    },
+    outputThrottle: config.outputThrottle,
+  });
+  logger.debug('Initialized runtime configuration');
+
+  // The default control directory comes first; it also holds the server-wide state
+  const controlRoots = new ControlRoots([
+    { name: DEFAULT_CONTROL_ROOT, path: CONTROL_DIR },
+    ...config.controlRoots,
+  ]);
+  for (const root of config.controlRoots) {
+    logger.log(chalk.blue(`Using control root ${root.name}: ${root.path}`));
+  }
+
+  // Encrypt recordings at rest when recording keys are configured
+  try {
+    await configureRecordingEncryption(config.recordingKeyFile);
+  } catch (error) {
+    logger.error('Failed to load recording keys:', error);
+    process.exit(1);
+  }
+
   // Initialize PTY manager
-  const ptyManager = new PtyManager(CONTROL_DIR);
+  const ptyManager = new PtyManager(controlRoots, {
+    maxResizesPerSecond: config.maxResizeRate,
+    doNotAllowColumnSet: config.doNotAllowColumnSet,
+    sessionLimits: runtimeConfig.get().sessionLimits,
+    sessionCgroup: config.sessionCgroup ?? undefined,
+    ptyPool: config.ptyPool,
+  });
   logger.debug('Initialized PTY manager');
 
   // Initialize Terminal Manager for server-side terminal state
-  const terminalManager = new TerminalManager(CONTROL_DIR);
+  const terminalManager = new TerminalManager(controlRoots, {
+    liveOutput: ptyManager,
+    notifyDebounceMs: config.bufferDebounceMs,
+  });
+  // Sessions from other processes: use the emulator's view of their keyboard modes
+  ptyManager.setKeyModeResolver((sessionId) => terminalManager.getKeyModes(sessionId));
   logger.debug('Initialized terminal manager');
 
-  // Initialize stream watcher for file-based streaming
-  const streamWatcher = new StreamWatcher();
+  // Notes on session recordings, merged into stream replays
+  const annotations = new AnnotationStore(controlRoots);
+
+  // Failures of per-session tasks mark the session instead of crashing the server
+  const crashReporter = new CrashReporter();
+  setCrashReporter(crashReporter);
+  crashReporter.on('crash', ({ sessionId, id, task, message, crashedAt }: CrashReport) => {
+    ptyManager.setSessionCrash(sessionId, { id, task, message, crashedAt });
+  });
+
+  // Initialize stream watcher (live output for local sessions, file-based otherwise)
+  // Throttled viewers get the rendered screen once their output caps allow it again
+  const streamWatcher = new StreamWatcher({
+    liveOutput: ptyManager,
+    annotations,
+    throttle: runtimeConfig.get().outputThrottle,
+    redraw: {
+      getRedraw: async (sessionId) => {
+        const { snapshot, offset } = await terminalManager.getSnapshotWithOffset(sessionId);
+        return { ansi: renderSnapshotAnsi(snapshot), offset };
+      },
+    },
+  });
   logger.debug('Initialized stream watcher');
 
+  // Screen previews for the session list
+  const thumbnails = new ThumbnailService(terminalManager);
+
   // Initialize activity monitor
-  const activityMonitor = new ActivityMonitor(CONTROL_DIR);
+  const activityMonitor = new ActivityMonitor(controlRoots);
   logger.debug('Initialized activity monitor');
 
+  // Session that currently needs eyes, for follow mode
+  const activityFocus = new ActivityFocus();
+  activityMonitor.onOutput((sessionId) => activityFocus.recordOutput(sessionId));
+  ptyManager.on('bell', (bellContext) => activityFocus.recordBell(bellContext.sessionInfo.id));
+
+  // Exclusive input control of sessions
+  const inputLocks = new InputLockManager();
+
+  // Initialize scheduler for cron-style commands
+  const scheduler = new Scheduler(CONTROL_DIR, ptyManager, inputLocks, config.localUsers);
+  logger.debug('Initialized scheduler');
+
+  // Regex triggers on session output
+  const triggers = new TriggerEngine(CONTROL_DIR, ptyManager, inputLocks);
+  ptyManager.on('output', (sessionId: string, data: string) => {
+    triggers.handleOutput(sessionId, data);
+  });
+  logger.debug('Initialized triggers');
+
+  // Waits for text in sessions (expect-style automation)
+  const textWaiter = new TextWaiter(terminalManager);
+  ptyManager.on('output', (sessionId: string, data: string) => {
+    textWaiter.handleOutput(sessionId, data);
+  });
+
+  // Forwards session output to external log sinks
+  const logForwarder = new LogForwarder(ptyManager, config.logForward);
+  ptyManager.on('output', (sessionId: string, data: string) => {
+    logForwarder.handleOutput(sessionId, data);
+  });
+  logger.debug('Initialized log forwarder');
+
+  // Uploads finished recordings to object storage
+  const archiveConfig = createArchiveConfig(config);
+  const archiver = archiveConfig ? new RecordingArchiver(ptyManager, archiveConfig) : null;
+  if (archiver) {
+    ptyManager.on('recordingClosed', (sessionId: string) => {
+      archiver.archive(sessionId).catch(() => {
+        // Logged by the archiver
+      });
+    });
+    logger.log(chalk.green(`Archiving recordings to ${archiver.describe()}`));
+  }
+
+  // Orders sequenced input batches (HTTP and WebSocket share per-client state)
+  const inputSequencer = new InputSequencer();
+
+  // Decides the PTY size from the sizes of all viewers of a session
+  const sizeNegotiator = new SizeNegotiator({ defaultPolicy: config.sizePolicy });
+
+  // Tracks who is watching and typing into each session
+  const viewerPresence = new ViewerPresence();
+  // Relays typing and selection indicators between users sharing a session
+  const collaboration = new CollaborationService(viewerPresence);
+
   // Initialize push notification services
   let vapidManager: VapidManager | null = null;
   let pushNotificationService: PushNotificationService | null = null;
@@ -427,20 +1271,28 @@ export async function createApp(): Promise<AppInstance> {
   let hqClient: HQClient | null = null;
   let controlDirWatcher: ControlDirWatcher | null = null;
   let bufferAggregator: BufferAggregator | null = null;
-  let remoteBearerToken: string | null = null;
+  let remoteTokens: RemoteTokenStore | null = null;
 
   if (config.isHQMode) {
-    remoteRegistry = new RemoteRegistry();
+    remoteRegistry = new RemoteRegistry({
+      tokenRotationMs: config.hqTokenRotationMinutes * 60 * 1000,
+      hqSecret: config.hqSecret,
+    });
     logger.log(chalk.green('Running in HQ mode'));
     logger.debug('Initialized remote registry for HQ mode');
-  } else if (
+    if (!config.hqSecret) {
+      logger.warn('No --hq-secret set: remote registrations are not signed');
+    }
+  }
+
+  if (
     config.hqUrl &&
     config.remoteName &&
     (config.noHqAuth || (config.hqUsername && config.hqPassword))
   ) {
-    // Generate bearer token for this remote server
-    remoteBearerToken = uuidv4();
-    logger.debug(`Generated bearer token for remote server: ${config.remoteName}`);
+    // HQ issues (and rotates) the bearer token when this remote registers
+    remoteTokens = new RemoteTokenStore();
+    logger.debug(`Initialized HQ token store for remote server: ${config.remoteName}`);
   }
 
   // Initialize buffer aggregator
@@ -448,23 +1300,64 @@ export async function createApp(): Promise<AppInstance> {
     terminalManager,
     remoteRegistry,
     isHQMode: config.isHQMode,
+    ptyManager,
+    inputSequencer,
+    sizeNegotiator,
+    viewerPresence,
+    collaboration,
+    inputLocks,
+    triggers,
+    crashReporter,
   });
   logger.debug('Initialized buffer aggregator');
 
+  // Second factors users enrolled for the web login (TOTP, security keys)
+  const secondFactor = new SecondFactorService();
+
   // Initialize authentication service
-  const authService = new AuthService();
+  const authService = new AuthService(
+    secondFactor,
+    createPasswordVerifier(config.passwordBackend, config.passwordHelper ?? undefined)
+  );
   logger.debug('Initialized authentication service');
 
+  // Long-lived API tokens of scripts and CI
+  const apiTokens = new ApiTokenStore();
+
+  // Pairing of mobile clients by QR code, which hands out API tokens
+  const pairing = new PairingService(apiTokens);
+
+  // Single sign-on with an OpenID provider
+  let oidc: OidcService | null = null;
+  if (config.oidcIssuer && config.oidcClientId && config.oidcClientSecret) {
+    oidc = new OidcService({
+      issuer: config.oidcIssuer,
+      clientId: config.oidcClientId,
+      clientSecret: config.oidcClientSecret,
+      redirectUrl: config.oidcRedirectUrl ?? undefined,
+      usernameClaim: config.oidcUsernameClaim,
+      allowedGroups: config.oidcAllowedGroups,
+      allowedEmails: config.oidcAllowedEmails,
+    });
+    if (!oidc.isRestricted()) {
+      logger.warn(
+        `Single sign-on allows any account of ${config.oidcIssuer}; ` +
+          'restrict it with --oidc-allowed-group or --oidc-allowed-email'
+      );
+    }
+  }
+
   // Set up authentication
   const authMiddleware = createAuthMiddleware({
     enableSSHKeys: config.enableSSHKeys,
     disallowUserPassword: config.disallowUserPassword,
     noAuth: config.noAuth,
     isHQMode: config.isHQMode,
-    bearerToken: remoteBearerToken || undefined, // Token that HQ must use to auth with us
+    remoteTokens: remoteTokens || undefined, // Tokens HQ issued to authenticate with us
     authService, // Add enhanced auth service for JWT tokens
     allowLocalBypass: config.allowLocalBypass,
     localAuthToken: config.localAuthToken || undefined,
+    apiTokens,
   });
 
   // Serve static files with .html extension handling
@@ -500,22 +1393,52 @@ export async function createApp(): Promise<AppInstance> {
     logger.debug('Connected bell event handler to PTY manager');
   }
 
+  // Remote mode: report exits to HQ (queued while HQ is unreachable)
+  ptyManager.on('sessionExited', (sessionId: string) => {
+    hqClient?.notifySessionChange('exited', sessionId);
+    inputLocks.release(sessionId);
+    thumbnails.remove(sessionId);
+    triggers.removeSession(sessionId);
+    textWaiter.removeSession(sessionId);
+    activityFocus.removeSession(sessionId);
+    crashReporter.forgetSession(sessionId);
+    logForwarder.endSession(sessionId).catch((error) => {
+      logger.error(`Failed to flush forwarded output of session ${sessionId}:`, error);
+    });
+    // Paused viewers still get the final output
+    streamWatcher.resumeSession(sessionId);
+    terminalManager.resumeUpdates(sessionId);
+  });
+
   // Mount authentication routes (no auth required)
   app.use(
     '/api/auth',
     createAuthRoutes({
       authService,
+      secondFactor,
       enableSSHKeys: config.enableSSHKeys,
       disallowUserPassword: config.disallowUserPassword,
       noAuth: config.noAuth,
+      oidc: !!oidc,
+      oidcOnly: config.oidcOnly,
     })
   );
+  if (oidc) {
+    app.use('/api/auth', createOidcRoutes({ oidc, authService }));
+  }
   logger.debug('Mounted authentication routes');
 
   // Apply auth middleware to all API routes (except auth routes which are handled above)
   app.use('/api', authMiddleware);
   logger.debug('Applied authentication middleware to /api routes');
 
+  // Apply rate limiting after auth so limits can be keyed by user
+  app.use('/api', createRateLimitMiddleware(runtimeConfig));
+  logger.debug('Applied rate limit middleware to /api routes');
+
+  // Workspaces group sessions and file browsing by project
+  const workspaceStore = new WorkspaceStore(CONTROL_DIR);
+
   // Mount routes
   app.use(
     '/api',
@@ -526,27 +1449,219 @@ export async function createApp(): Promise<AppInstance> {
       remoteRegistry,
       isHQMode: config.isHQMode,
       activityMonitor,
+      activityFocus,
+      inputSequencer,
+      sizeNegotiator,
+      viewerPresence,
+      collaboration,
+      inputLocks,
+      thumbnails,
+      annotations,
+      textWaiter,
+      logForwarder,
+      archiver,
+      adminUsers: config.adminUsers,
+      localUsers: config.localUsers,
+      workspaceStore,
     })
   );
   logger.debug('Mounted session routes');
 
+  // Mount second factor enrollment routes
+  app.use('/api', createSecondFactorRoutes({ secondFactor }));
+  logger.debug('Mounted second factor routes');
+
+  // Mount API token management routes
+  app.use('/api', createTokenRoutes({ apiTokens, adminUsers: config.adminUsers }));
+  logger.debug('Mounted API token routes');
+
+  // Mount mobile pairing routes
+  app.use('/api', createPairingRoutes({ pairing, adminUsers: config.adminUsers }));
+  logger.debug('Mounted pairing routes');
+
+  // Mount the MCP endpoint for AI agents
+  const mcp = new McpServer({ ptyManager, terminalManager, textWaiter, inputLocks });
+  app.use('/api', createMcpRoutes({ mcp }));
+  logger.debug('Mounted MCP routes');
+
+  // Mount session group routes
+  const groupStore = new SessionGroupStore(CONTROL_DIR);
+  app.use(
+    '/api',
+    createGroupRoutes({ ptyManager, groupStore, inputLocks, localUsers: config.localUsers })
+  );
+  logger.debug('Mounted group routes');
+
+  // Mount schedule routes
+  app.use(
+    '/api',
+    createScheduleRoutes({
+      scheduler,
+      ptyManager,
+      adminUsers: config.adminUsers,
+      localUsers: config.localUsers,
+    })
+  );
+  logger.debug('Mounted schedule routes');
+
+  // Mount session template routes
+  const templateStore = new SessionTemplateStore(CONTROL_DIR);
+  app.use(
+    '/api',
+    createTemplateRoutes({
+      ptyManager,
+      templateStore,
+      adminUsers: config.adminUsers,
+      localUsers: config.localUsers,
+    })
+  );
+  logger.debug('Mounted template routes');
+
+  // Mount workspace routes
+  app.use(
+    '/api',
+    createWorkspaceRoutes({ ptyManager, workspaceStore, remoteRegistry, isHQMode: config.isHQMode })
+  );
+  logger.debug('Mounted workspace routes');
+
+  // Mount trigger routes
+  app.use('/api', createTriggerRoutes({ triggers }));
+  logger.debug('Mounted trigger routes');
+
+  // Mount exec routes
+  app.use('/api', createExecRoutes({ localUsers: config.localUsers }));
+  logger.debug('Mounted exec routes');
+
   app.use(
     '/api',
     createRemoteRoutes({
       remoteRegistry,
       isHQMode: config.isHQMode,
+      hqSecret: config.hqSecret,
+      getHQClient: () => hqClient,
     })
   );
   logger.debug('Mounted remote routes');
 
+  // Crash reports of per-session tasks
+  app.use('/api', createCrashRoutes({ reporter: crashReporter, adminUsers: config.adminUsers }));
+  logger.debug('Mounted crash routes');
+
+  // Fault injection for integration tests of HQ networking
+  if (config.chaos) {
+    const injector = new FaultInjector(config.chaos);
+    setFaultInjector(injector);
+    app.use('/api', createChaosRoutes({ injector, adminUsers: config.adminUsers }));
+    logger.warn(chalk.red('--chaos is set: requests to remotes will be delayed and failed'));
+    logger.debug('Mounted chaos routes');
+  }
+
+  // Mount HQ token rotation routes (remote mode and federated HQ)
+  if (remoteTokens) {
+    app.use('/api', createHQTokenRoutes({ remoteTokens, hqSecret: config.hqSecret }));
+    logger.debug('Mounted HQ token routes');
+  }
+
+  // Mount batch query routes
+  app.use(
+    '/api',
+    createBatchRoutes({ ptyManager, activityMonitor, remoteRegistry, groupStore, scheduler })
+  );
+  logger.debug('Mounted batch routes');
+
   // Mount filesystem routes
-  app.use('/api', createFilesystemRoutes());
+  // The user's running sessions' working directories are allowed unless roots are configured,
+  // the scratch directories of all of the user's sessions always
+  const sessionsOf = (user?: string) =>
+    ptyManager
+      .listSessions()
+      .filter((session) => user === undefined || session.createdBy === user);
+  const fsSandbox = new FsSandbox(
+    config.fsRoots,
+    (user) =>
+      sessionsOf(user)
+        .filter((session) => session.status !== 'exited')
+        .map((session) => session.workingDir),
+    (user) =>
+      sessionsOf(user).flatMap((session) => (session.scratchDir ? [session.scratchDir] : []))
+  );
+  // Deleted and overwritten files, purged after the retention
+  const fsTrash = new FsTrash(undefined, config.trashRetentionDays);
+  fsTrash.start();
+  app.use(
+    '/api',
+    createFilesystemRoutes({
+      localUsers: config.localUsers,
+      sandbox: fsSandbox,
+      trash: fsTrash,
+      remoteRegistry,
+      workspaceStore,
+    })
+  );
   logger.debug('Mounted filesystem routes');
 
   // Mount log routes
   app.use('/api', createLogRoutes());
   logger.debug('Mounted log routes');
 
+  // Mount statistics routes
+  app.use(
+    '/api',
+    createStatsRoutes({
+      ptyManager,
+      systemStats: new SystemStats(CONTROL_DIR),
+      remoteRegistry,
+      streamWatcher,
+    })
+  );
+  logger.debug('Mounted stats routes');
+
+  // Self-update replaces the executable and hands the listening socket to the new build
+  const selfUpdater = new SelfUpdater(CONTROL_DIR);
+  selfUpdater.setRestartHandler(async () => {
+    // Unregister first so the new process can register under the same name
+    await hqClient?.destroy();
+    try {
+      await handOffServer(server, selfUpdater.getExecutablePath());
+    } catch (error) {
+      hqClient?.register().catch((err) => {
+        logger.error('Failed to re-register with HQ:', err);
+      });
+      throw error;
+    }
+    logger.log('new server took over the socket, shutting down');
+    process.kill(process.pid, 'SIGTERM');
+  });
+
+  // Mount admin routes
+  app.use(
+    '/api',
+    createAdminRoutes({
+      runtimeConfig,
+      adminUsers: config.adminUsers,
+      getStaticConfig: () => ({
+        port: config.port,
+        bind: config.bind,
+        controlDir: CONTROL_DIR,
+        controlRoots: controlRoots.list(),
+        mode: config.isHQMode ? 'hq' : 'remote',
+        hqUrl: config.hqUrl,
+        remoteName: config.remoteName,
+        recordingEncryptionKeyId: getRecordingCipher()?.currentKeyId ?? null,
+        enableSSHKeys: config.enableSSHKeys,
+        disallowUserPassword: config.disallowUserPassword,
+        noAuth: config.noAuth,
+        allowLocalBypass: config.allowLocalBypass,
+        pushEnabled: config.pushEnabled,
+        adminUsers: config.adminUsers,
+      }),
+      selfUpdater,
+      ptyManager,
+      remoteRegistry,
+    })
+  );
+  logger.debug('Mounted admin routes');
+
   // Mount push notification routes
   if (vapidManager) {
     app.use(
@@ -560,6 +1675,33 @@ export async function createApp(): Promise<AppInstance> {
     logger.debug('Mounted push notification routes');
   }
 
+  // Mount diagnostics routes (separate credential, outside /api auth)
+  if (config.debugToken) {
+    app.use(
+      '/debug',
+      createDebugRoutes({
+        debugToken: config.debugToken,
+        getCounters: () => ({
+          ptyManager: ptyManager.getStats(),
+          terminalManager: terminalManager.getStats(),
+          streamWatcher: streamWatcher.getStats(),
+          activityMonitor: activityMonitor.getStats(),
+          bufferAggregator: bufferAggregator?.getStats() ?? null,
+          remoteRegistry: remoteRegistry?.getStats() ?? null,
+          hqClient: hqClient?.getStats() ?? null,
+          fileWatcherPool: fileWatcherPool.getStats(),
+          snapshotBufferPool: snapshotBufferPool.getStats(),
+          inputSequencer: inputSequencer.getStats(),
+          viewerPresence: viewerPresence.getStats(),
+          collaboration: collaboration.getStats(),
+          inputLocks: inputLocks.getStats(),
+          thumbnails: thumbnails.getStats(),
+        }),
+      })
+    );
+    logger.log(chalk.yellow('Diagnostics endpoints enabled at /debug'));
+  }
+
   // Handle WebSocket upgrade with authentication
   server.on('upgrade', async (request, socket, head) => {
     // Parse the URL to extract path and query parameters
@@ -573,6 +1715,7 @@ export async function createApp(): Promise<AppInstance> {
     }
 
     // Check authentication
+    let identity: ClientIdentity = {};
     const isAuthenticated = await new Promise<boolean>((resolve) => {
       // Track if promise has been resolved to prevent multiple resolutions
       let resolved = false;
@@ -632,6 +1775,11 @@ export async function createApp(): Promise<AppInstance> {
 
       const next = (error?: unknown) => {
         // Authentication succeeds if next() is called without error and no auth failure was recorded
+        identity = {
+          userId: req.userId,
+          isHQRequest: req.isHQRequest,
+          inputSource: inputSourceFromRequest(req),
+        };
         safeResolve(!error && !authFailed);
       };
 
@@ -662,14 +1810,15 @@ export async function createApp(): Promise<AppInstance> {
 
     // Handle the upgrade
     wss.handleUpgrade(request, socket, head, (ws) => {
+      clientIdentities.set(request, identity);
       wss.emit('connection', ws, request);
     });
   });
 
   // WebSocket endpoint for buffer updates
-  wss.on('connection', (ws, _req) => {
+  wss.on('connection', (ws, req) => {
     if (bufferAggregator) {
-      bufferAggregator.handleClientConnection(ws);
+      bufferAggregator.handleClientConnection(ws, clientIdentities.get(req));
     } else {
       logger.error('BufferAggregator not initialized for WebSocket connection');
       ws.close();
@@ -684,7 +1833,7 @@ export async function createApp(): Promise<AppInstance> {
   // 404 handler for all other routes
   app.use((req, res) => {
     if (req.path.startsWith('/api/')) {
-      res.status(404).json({ error: 'API endpoint not found' });
+      sendError(res, 'ENDPOINT_NOT_FOUND');
     } else {
       res.status(404).sendFile(path.join(publicPath, '404.html'), (err) => {
         if (err) {
@@ -694,6 +1843,9 @@ export async function createApp(): Promise<AppInstance> {
     }
   });
 
+  // Malformed request bodies and errors not caught by API handlers
+  app.use('/api', apiErrorHandler);
+
   // Start server function
   const startServer = () => {
     const requestedPort = config.port !== null ? config.port : Number(process.env.PORT) || 4020;
@@ -718,7 +1870,7 @@ export async function createApp(): Promise<AppInstance> {
     });
 
     const bindAddress = config.bind || '0.0.0.0';
-    server.listen(requestedPort, bindAddress, () => {
+    const onListening = () => {
       const address = server.address();
       const actualPort =
         typeof address === 'string' ? requestedPort : address?.port || requestedPort;
@@ -730,6 +1882,8 @@ export async function createApp(): Promise<AppInstance> {
       if (config.noAuth) {
         logger.warn(chalk.yellow('Authentication: DISABLED (--no-auth)'));
         logger.warn('Anyone can access this server without authentication');
+      } else if (config.oidcOnly) {
+        logger.log(chalk.green(`Authentication: SINGLE SIGN-ON ONLY (${config.oidcIssuer})`));
       } else if (config.disallowUserPassword) {
         logger.log(chalk.green('Authentication: SSH KEYS ONLY (--disallow-user-password)'));
         logger.log(chalk.gray('Password authentication is disabled'));
@@ -743,6 +1897,12 @@ export async function createApp(): Promise<AppInstance> {
           );
         }
       }
+      if (!config.noAuth && !config.oidcOnly && config.oidcIssuer) {
+        logger.log(chalk.green(`Single Sign-On: ENABLED (${config.oidcIssuer})`));
+      }
+      if (!config.noAuth && config.localUsers) {
+        logger.log(chalk.green('Local Users: sessions run as the logged-in user'));
+      }
 
       // Initialize HQ client now that we know the actual port
       if (
@@ -757,7 +1917,9 @@ export async function createApp(): Promise<AppInstance> {
           config.hqPassword || 'no-auth',
           config.remoteName,
           remoteUrl,
-          remoteBearerToken || ''
+          remoteTokens || new RemoteTokenStore(),
+          config.hqSecret,
+          config.remoteTags
         );
         if (config.noHqAuth) {
           logger.log(
@@ -767,9 +1929,8 @@ export async function createApp(): Promise<AppInstance> {
           );
         } else {
           logger.log(
-            chalk.green(`Remote mode: ${config.remoteName} will accept Bearer token for HQ access`)
+            chalk.green(`Remote mode: ${config.remoteName} will accept HQ-issued Bearer tokens`)
           );
-          logger.debug(`Bearer token: ${hqClient.getToken()}`);
         }
       }
 
@@ -789,7 +1950,7 @@ export async function createApp(): Promise<AppInstance> {
 
       // Start control directory watcher
       controlDirWatcher = new ControlDirWatcher({
-        controlDir: CONTROL_DIR,
+        controlRoots,
         remoteRegistry,
         isHQMode: config.isHQMode,
         hqClient,
@@ -801,7 +1962,22 @@ export async function createApp(): Promise<AppInstance> {
       // Start activity monitor
       activityMonitor.start();
       logger.debug('Started activity monitor');
-    });
+
+      // Start scheduler
+      scheduler.start();
+      logger.debug('Started scheduler');
+    };
+
+    // Started by a self-update: serve on the socket handed over by the previous process
+    if (process.env.VIBETUNNEL_HANDOFF && process.send) {
+      logger.log('Waiting for the listening socket from the previous server');
+      process.once('message', (_message, handle) => {
+        server.listen(handle, onListening);
+      });
+      return;
+    }
+
+    server.listen(requestedPort, bindAddress, onListening);
   };
 
   return {
@@ -819,6 +1995,10 @@ export async function createApp(): Promise<AppInstance> {
     bufferAggregator,
     activityMonitor,
     pushNotificationService,
+    runtimeConfig,
+    scheduler,
+    logForwarder,
+    fsTrash,
   };
 }
 
@@ -844,12 +2024,17 @@ export async function startVibeTunnelServer() {
   const {
     startServer,
     server,
+    ptyManager,
     terminalManager,
     remoteRegistry,
     hqClient,
     controlDirWatcher,
     activityMonitor,
     config,
+    runtimeConfig,
+    scheduler,
+    logForwarder,
+    fsTrash,
   } = appInstance;
 
   // Update debug mode based on config
@@ -860,14 +2045,31 @@ export async function startVibeTunnelServer() {
 
   startServer();
 
-  // Cleanup old terminals every 5 minutes
-  const _terminalCleanupInterval = setInterval(
-    () => {
-      terminalManager.cleanup(5 * 60 * 1000); // 5 minutes
-    },
-    5 * 60 * 1000
+  // Cleanup old terminals periodically (interval is adjustable at runtime)
+  const startTerminalCleanupInterval = (intervalMs: number) =>
+    setInterval(() => {
+      terminalManager.cleanup(intervalMs);
+    }, intervalMs);
+  let _terminalCleanupInterval = startTerminalCleanupInterval(
+    runtimeConfig.get().terminalCleanupIntervalMs
   );
-  logger.debug('Started terminal cleanup interval (5 minutes)');
+  logger.debug(
+    `Started terminal cleanup interval (${runtimeConfig.get().terminalCleanupIntervalMs}ms)`
+  );
+
+  runtimeConfig.on('change', (settings, changedKeys: string[]) => {
+    if (changedKeys.includes('terminalCleanupIntervalMs')) {
+      clearInterval(_terminalCleanupInterval);
+      _terminalCleanupInterval = startTerminalCleanupInterval(settings.terminalCleanupIntervalMs);
+      logger.log(`Terminal cleanup interval changed to ${settings.terminalCleanupIntervalMs}ms`);
+    }
+    if (changedKeys.includes('sessionLimits')) {
+      ptyManager.setSessionLimits(settings.sessionLimits);
+    }
+    if (changedKeys.includes('outputThrottle')) {
+      streamWatcher.setOutputThrottle(settings.outputThrottle);
+    }
+  });
 
   // Cleanup inactive push subscriptions every 30 minutes
   let _subscriptionCleanupInterval: NodeJS.Timeout | null = null;
@@ -908,6 +2110,23 @@ export async function startVibeTunnelServer() {
       activityMonitor.stop();
       logger.debug('Stopped activity monitor');
 
+      // Stop scheduler
+      scheduler.stop();
+      logger.debug('Stopped scheduler');
+
+      // Kill the pooled shells no session claimed
+      ptyManager.stopPtyPool();
+
+      // Stop purging the trash
+      fsTrash.stop();
+
+      // Write the output still waiting to be forwarded
+      await logForwarder.close();
+      logger.debug('Closed log forwarder');
+
+      // Send the spans not exported yet
+      await shutdownTracing();
+
       // Stop control directory watcher
       if (controlDirWatcher) {
         controlDirWatcher.stop();
@@ -0,0 +1,109 @@
+/**
+ * ActivityFocus - Tracks which session currently needs eyes, for follow mode
+ *
+ * The focus moves to the session that most recently printed output or rang
+ * the bell (how terminal programs ask for input). To keep a wall dashboard
+ * from flickering between busy sessions, output only takes the focus once the
+ * focused session has held it for DWELL_MS and been quiet for QUIET_MS; a bell
+ * takes it right away, unless another bell took it less than BELL_HOLD_MS ago.
+ * Every change emits 'focus-changed' with the new focus.
+ */
+
+import { EventEmitter } from 'events';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('activity-focus');
+
+const DWELL_MS = 3000;
+const QUIET_MS = 1500;
+const BELL_HOLD_MS = 10_000;
+
+export type FocusReason = 'output' | 'bell' | 'exited';
+
+export interface ActivityFocusState {
+  // Null until a session is active, and after the last active one exits
+  sessionId: string | null;
+  reason: FocusReason;
+  since: string;
+}
+
+export class ActivityFocus extends EventEmitter {
+  private lastOutputAt = new Map<string, number>();
+  private sessionId: string | null = null;
+  private reason: FocusReason = 'output';
+  private since = Date.now();
+
+  constructor() {
+    super();
+    // Every follow-mode client listens for changes
+    this.setMaxListeners(0);
+  }
+
+  /**
+   * Record output of a session
+   */
+  recordOutput(sessionId: string): void {
+    const now = Date.now();
+    this.lastOutputAt.set(sessionId, now);
+    if (sessionId === this.sessionId) return;
+
+    if (this.sessionId !== null) {
+      const holdMs = this.reason === 'bell' ? BELL_HOLD_MS : DWELL_MS;
+      const focusQuietMs = now - (this.lastOutputAt.get(this.sessionId) ?? 0);
+      if (now - this.since < holdMs || focusQuietMs < QUIET_MS) return;
+    }
+    this.moveFocus(sessionId, 'output');
+  }
+
+  /**
+   * Record a bell of a session
+   */
+  recordBell(sessionId: string): void {
+    if (
+      sessionId !== this.sessionId &&
+      this.reason === 'bell' &&
+      Date.now() - this.since < BELL_HOLD_MS
+    ) {
+      return;
+    }
+    this.moveFocus(sessionId, 'bell');
+  }
+
+  /**
+   * Forget a session that exited; its focus passes to the most recently
+   * active remaining session
+   */
+  removeSession(sessionId: string): void {
+    this.lastOutputAt.delete(sessionId);
+    if (sessionId !== this.sessionId) return;
+
+    let next: string | null = null;
+    let nextAt = 0;
+    for (const [id, at] of this.lastOutputAt) {
+      if (at > nextAt) {
+        next = id;
+        nextAt = at;
+      }
+    }
+    this.moveFocus(next, 'exited');
+  }
+
+  getFocus(): ActivityFocusState {
+    return {
+      sessionId: this.sessionId,
+      reason: this.reason,
+      since: new Date(this.since).toISOString(),
+    };
+  }
+
+  private moveFocus(sessionId: string | null, reason: FocusReason): void {
+    const changed = sessionId !== this.sessionId;
+    this.sessionId = sessionId;
+    this.reason = reason;
+    this.since = Date.now();
+    if (!changed) return;
+
+    logger.debug(`focus moved to ${sessionId ?? 'no session'} (${reason})`);
+    this.emit('focus-changed', this.getFocus());
+  }
+}
@@ -1,8 +1,15 @@
 import chalk from 'chalk';
 import * as fs from 'fs';
 import * as path from 'path';
-import type { SessionActivity } from '../../shared/types.js';
+import { parseSessionInfo } from '../../shared/session-schema.js';
+import type { SessionActivity, SessionInfo } from '../../shared/types.js';
+import { ControlRoots } from '../pty/control-roots.js';
 import { createLogger } from '../utils/logger.js';
+import {
+  type FileWatcherPool,
+  type FileWatchHandle,
+  fileWatcherPool,
+} from './file-watcher-pool.js';
 
 const logger = createLogger('activity-monitor');
 
@@ -14,15 +21,22 @@ interface SessionActivityState {
 }
 
 export class ActivityMonitor {
-  private controlPath: string;
+  private controlRoots: ControlRoots;
   private activities: Map<string, SessionActivityState> = new Map();
-  private watchers: Map<string, fs.FSWatcher> = new Map();
+  private watchers: Map<string, FileWatchHandle> = new Map();
   private checkInterval: NodeJS.Timeout | null = null;
   private readonly ACTIVITY_TIMEOUT = 500; // 500ms of no activity = inactive
   private readonly CHECK_INTERVAL = 100; // Check every 100ms
 
-  constructor(controlPath: string) {
-    this.controlPath = controlPath;
+  private watcherPool: FileWatcherPool;
+  private outputListeners = new Set<(sessionId: string) => void>();
+
+  constructor(
+    controlPath: string | ControlRoots,
+    watcherPool: FileWatcherPool = fileWatcherPool
+  ) {
+    this.controlRoots = ControlRoots.from(controlPath);
+    this.watcherPool = watcherPool;
   }
 
   /**
@@ -69,34 +83,43 @@ export class ActivityMonitor {
     }
   }
 
+  /**
+   * Call listener whenever a session writes output; returns a function removing it
+   */
+  onOutput(listener: (sessionId: string) => void): () => void {
+    this.outputListeners.add(listener);
+    return () => this.outputListeners.delete(listener);
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    return {
+      monitoredSessions: this.activities.size,
+      fileWatchers: this.watchers.size,
+    };
+  }
+
   /**
    * Scan for sessions and start monitoring new ones
    */
   private scanSessions(): number {
     try {
-      if (!fs.existsSync(this.controlPath)) {
-        return 0;
-      }
-
-      const entries = fs.readdirSync(this.controlPath, { withFileTypes: true });
       let newSessions = 0;
 
-      for (const entry of entries) {
-        if (entry.isDirectory()) {
-          const sessionId = entry.name;
-
-          // Skip if already monitoring
-          if (this.activities.has(sessionId)) {
-            continue;
-          }
+      for (const { sessionId, dir } of this.controlRoots.listSessionDirs()) {
+        // Skip if already monitoring
+        if (this.activities.has(sessionId)) {
+          continue;
+        }
 
-          const streamOutPath = path.join(this.controlPath, sessionId, 'stdout');
+        const streamOutPath = path.join(dir, 'stdout');
 
-          // Check if stdout exists
-          if (fs.existsSync(streamOutPath)) {
-            if (this.startMonitoringSession(sessionId, streamOutPath)) {
-              newSessions++;
-            }
+        // Check if stdout exists
+        if (fs.existsSync(streamOutPath)) {
+          if (this.startMonitoringSession(sessionId, streamOutPath)) {
+            newSessions++;
           }
         }
       }
@@ -104,8 +127,7 @@ export class ActivityMonitor {
       // Clean up sessions that no longer exist
       const sessionsToCleanup = [];
       for (const [sessionId, _] of this.activities) {
-        const sessionDir = path.join(this.controlPath, sessionId);
-        if (!fs.existsSync(sessionDir)) {
+        if (!this.controlRoots.findRoot(sessionId)) {
           sessionsToCleanup.push(sessionId);
         }
       }
@@ -140,7 +162,7 @@ export class ActivityMonitor {
       });
 
       // Watch for file changes
-      const watcher = fs.watch(streamOutPath, (eventType) => {
+      const watcher = this.watcherPool.watch(streamOutPath, (eventType) => {
         if (eventType === 'change') {
           this.handleFileChange(sessionId, streamOutPath);
         }
@@ -193,6 +215,10 @@ export class ActivityMonitor {
 
         // Write activity status immediately
         this.writeActivityStatus(sessionId, true);
+
+        for (const listener of this.outputListeners) {
+          listener(sessionId);
+        }
       }
     } catch (error) {
       logger.error(`failed to handle file change for session ${sessionId}:`, error);
@@ -214,13 +240,19 @@ export class ActivityMonitor {
     }
   }
 
+  // Session data embedded in activity, in the current session.json schema
+  private readSessionInfo(sessionJsonPath: string, sessionId: string): SessionInfo {
+    return parseSessionInfo(JSON.parse(fs.readFileSync(sessionJsonPath, 'utf8')), sessionId);
+  }
+
   /**
    * Write activity status to disk
    */
   private writeActivityStatus(sessionId: string, isActive: boolean) {
     try {
-      const activityPath = path.join(this.controlPath, sessionId, 'activity.json');
-      const sessionJsonPath = path.join(this.controlPath, sessionId, 'session.json');
+      const sessionDir = this.controlRoots.sessionDir(sessionId);
+      const activityPath = path.join(sessionDir, 'activity.json');
+      const sessionJsonPath = path.join(sessionDir, 'session.json');
 
       const activityData: SessionActivity = {
         isActive,
@@ -230,7 +262,7 @@ export class ActivityMonitor {
       // Try to read full session data
       if (fs.existsSync(sessionJsonPath)) {
         try {
-          const sessionData = JSON.parse(fs.readFileSync(sessionJsonPath, 'utf8'));
+          const sessionData = this.readSessionInfo(sessionJsonPath, sessionId);
           activityData.session = sessionData;
         } catch (_error) {
           // If we can't read session.json, just proceed without session data
@@ -253,62 +285,53 @@ export class ActivityMonitor {
 
     // Read from disk to get the most up-to-date status
     try {
-      if (!fs.existsSync(this.controlPath)) {
-        return status;
-      }
-
-      const entries = fs.readdirSync(this.controlPath, { withFileTypes: true });
-
-      for (const entry of entries) {
-        if (entry.isDirectory()) {
-          const sessionId = entry.name;
-          const activityPath = path.join(this.controlPath, sessionId, 'activity.json');
-          const sessionJsonPath = path.join(this.controlPath, sessionId, 'session.json');
-
-          if (fs.existsSync(activityPath)) {
-            try {
-              const data = JSON.parse(fs.readFileSync(activityPath, 'utf8'));
-              status[sessionId] = data;
-            } catch (_error) {
-              // If we can't read the file, create one from current state
-              logger.debug(`could not read activity.json for ${sessionId}`);
-              const activity = this.activities.get(sessionId);
-              if (activity) {
-                const activityStatus: SessionActivity = {
-                  isActive: activity.isActive,
-                  timestamp: new Date().toISOString(),
-                };
-
-                // Try to read full session data
-                if (fs.existsSync(sessionJsonPath)) {
-                  try {
-                    const sessionData = JSON.parse(fs.readFileSync(sessionJsonPath, 'utf8'));
-                    activityStatus.session = sessionData;
-                  } catch (_error) {
-                    // Ignore session.json read errors
-                    logger.debug(
-                      `could not read session.json for ${sessionId} when creating activity`
-                    );
-                  }
-                }
+      for (const { sessionId, dir } of this.controlRoots.listSessionDirs()) {
+        const activityPath = path.join(dir, 'activity.json');
+        const sessionJsonPath = path.join(dir, 'session.json');
 
-                status[sessionId] = activityStatus;
-              }
-            }
-          } else if (fs.existsSync(sessionJsonPath)) {
-            // No activity file yet, but session exists - create default activity
-            try {
-              const sessionData = JSON.parse(fs.readFileSync(sessionJsonPath, 'utf8'));
-              status[sessionId] = {
-                isActive: false,
+        if (fs.existsSync(activityPath)) {
+          try {
+            const data = JSON.parse(fs.readFileSync(activityPath, 'utf8'));
+            status[sessionId] = data;
+          } catch (_error) {
+            // If we can't read the file, create one from current state
+            logger.debug(`could not read activity.json for ${sessionId}`);
+            const activity = this.activities.get(sessionId);
+            if (activity) {
+              const activityStatus: SessionActivity = {
+                isActive: activity.isActive,
                 timestamp: new Date().toISOString(),
-                session: sessionData,
               };
-            } catch (_error) {
-              // Ignore errors
-              logger.debug(`could not read session.json for ${sessionId}`);
+
+              // Try to read full session data
+              if (fs.existsSync(sessionJsonPath)) {
+                try {
+                  const sessionData = this.readSessionInfo(sessionJsonPath, sessionId);
+                  activityStatus.session = sessionData;
+                } catch (_error) {
+                  // Ignore session.json read errors
+                  logger.debug(
+                    `could not read session.json for ${sessionId} when creating activity`
+                  );
+                }
+              }
+
+              status[sessionId] = activityStatus;
             }
           }
+        } else if (fs.existsSync(sessionJsonPath)) {
+          // No activity file yet, but session exists - create default activity
+          try {
+            const sessionData = this.readSessionInfo(sessionJsonPath, sessionId);
+            status[sessionId] = {
+              isActive: false,
+              timestamp: new Date().toISOString(),
+              session: sessionData,
+            };
+          } catch (_error) {
+            // Ignore errors
+            logger.debug(`could not read session.json for ${sessionId}`);
+          }
         }
       }
 
@@ -329,11 +352,12 @@ export class ActivityMonitor {
    * Get activity status for a specific session
    */
   getSessionActivityStatus(sessionId: string): SessionActivity | null {
-    const sessionJsonPath = path.join(this.controlPath, sessionId, 'session.json');
+    const sessionDir = this.controlRoots.sessionDir(sessionId);
+    const sessionJsonPath = path.join(sessionDir, 'session.json');
 
     // Try to read from disk first
     try {
-      const activityPath = path.join(this.controlPath, sessionId, 'activity.json');
+      const activityPath = path.join(sessionDir, 'activity.json');
       if (fs.existsSync(activityPath)) {
         const data = JSON.parse(fs.readFileSync(activityPath, 'utf8'));
         return data;
@@ -353,7 +377,7 @@ export class ActivityMonitor {
         // Try to read full session data
         if (fs.existsSync(sessionJsonPath)) {
           try {
-            const sessionData = JSON.parse(fs.readFileSync(sessionJsonPath, 'utf8'));
+            const sessionData = this.readSessionInfo(sessionJsonPath, sessionId);
             activityStatus.session = sessionData;
           } catch (_error) {
             // Ignore session.json read errors
@@ -370,7 +394,7 @@ export class ActivityMonitor {
     // If no activity data but session exists, create default
     if (fs.existsSync(sessionJsonPath)) {
       try {
-        const sessionData = JSON.parse(fs.readFileSync(sessionJsonPath, 'utf8'));
+        const sessionData = this.readSessionInfo(sessionJsonPath, sessionId);
         return {
           isActive: false,
           timestamp: new Date().toISOString(),
@@ -0,0 +1,85 @@
+import * as fs from 'fs';
+import * as path from 'path';
+import { v4 as uuidv4 } from 'uuid';
+import type { SessionAnnotation } from '../../shared/types.js';
+import { ControlRoots } from '../pty/control-roots.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('annotations');
+
+const ANNOTATIONS_FILE = 'annotations.json';
+// Most annotations kept per session
+export const MAX_ANNOTATIONS = 1000;
+
+export type AnnotationInput = Pick<SessionAnnotation, 'time' | 'text' | 'author'>;
+
+export class AnnotationError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'AnnotationError';
+  }
+}
+
+/**
+ * Notes and bookmarks on session recordings.
+ * Annotations are stored in `annotations.json` next to the session's cast file
+ * so they are removed with the session, and are sorted by their time in the
+ * recording.
+ */
+export class AnnotationStore {
+  private controlRoots: ControlRoots;
+
+  constructor(controlDir: string | ControlRoots) {
+    this.controlRoots = ControlRoots.from(controlDir);
+  }
+
+  list(sessionId: string): SessionAnnotation[] {
+    const filePath = this.filePath(sessionId);
+    try {
+      if (!fs.existsSync(filePath)) return [];
+      return JSON.parse(fs.readFileSync(filePath, 'utf8')) as SessionAnnotation[];
+    } catch (error) {
+      logger.error(`failed to load annotations of session ${sessionId}:`, error);
+      return [];
+    }
+  }
+
+  /**
+   * Add an annotation. Throws AnnotationError when the session has too many.
+   */
+  add(sessionId: string, input: AnnotationInput): SessionAnnotation {
+    const annotations = this.list(sessionId);
+    if (annotations.length >= MAX_ANNOTATIONS) {
+      throw new AnnotationError(`Sessions can have at most ${MAX_ANNOTATIONS} annotations`);
+    }
+
+    const annotation: SessionAnnotation = {
+      id: uuidv4(),
+      time: input.time,
+      text: input.text,
+      author: input.author,
+      createdAt: new Date().toISOString(),
+    };
+    annotations.push(annotation);
+    annotations.sort((a, b) => a.time - b.time);
+    this.save(sessionId, annotations);
+    logger.debug(`annotation ${annotation.id} added to session ${sessionId} at ${input.time}s`);
+    return annotation;
+  }
+
+  delete(sessionId: string, annotationId: string): boolean {
+    const annotations = this.list(sessionId);
+    const remaining = annotations.filter((annotation) => annotation.id !== annotationId);
+    if (remaining.length === annotations.length) return false;
+    this.save(sessionId, remaining);
+    return true;
+  }
+
+  private filePath(sessionId: string): string {
+    return path.join(this.controlRoots.sessionDir(sessionId), ANNOTATIONS_FILE);
+  }
+
+  private save(sessionId: string, annotations: SessionAnnotation[]): void {
+    fs.writeFileSync(this.filePath(sessionId), JSON.stringify(annotations, null, 2));
+  }
+}
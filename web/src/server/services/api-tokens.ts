@@ -0,0 +1,246 @@
+/**
+ * ApiTokenStore - long-lived API tokens for scripts and CI
+ *
+ * A token acts for the user who created it, limited to its scopes. Only the
+ * SHA-256 of each token is kept; the token itself is shown once, when it is
+ * created. Tokens are persisted in ~/.vibetunnel/api-tokens.json.
+ */
+
+import { createHash, randomBytes, randomUUID } from 'crypto';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('api-tokens');
+
+// Prefix telling API tokens apart from JWTs and HQ tokens
+export const API_TOKEN_PREFIX = 'vt_';
+
+export const API_TOKEN_SCOPES = [
+  'sessions:read',
+  'sessions:write',
+  'fs:read',
+  'fs:write',
+  'admin',
+] as const;
+export type ApiTokenScope = (typeof API_TOKEN_SCOPES)[number];
+
+const MAX_TOKENS_PER_USER = 50;
+const MAX_NAME_LENGTH = 64;
+const MAX_EXPIRY_DAYS = 3650;
+// Last use is written to disk at most this often per token
+const LAST_USED_SAVE_INTERVAL_MS = 60 * 1000;
+
+interface StoredApiToken {
+  id: string;
+  name: string;
+  userId: string;
+  scopes: ApiTokenScope[];
+  // SHA-256 of the token, hex
+  hash: string;
+  createdAt: string;
+  expiresAt?: string;
+  lastUsedAt?: string;
+}
+
+export type ApiToken = Omit<StoredApiToken, 'hash'>;
+
+export class ApiTokenError extends Error {
+  constructor(
+    message: string,
+    readonly status = 400
+  ) {
+    super(message);
+    this.name = 'ApiTokenError';
+  }
+}
+
+export function isApiTokenScope(value: unknown): value is ApiTokenScope {
+  return (API_TOKEN_SCOPES as readonly unknown[]).includes(value);
+}
+
+/**
+ * Whether scopes allow what `required` needs; admin allows everything
+ */
+export function hasScope(scopes: readonly ApiTokenScope[], required: ApiTokenScope): boolean {
+  return scopes.includes('admin') || scopes.includes(required);
+}
+
+/**
+ * Scope an API request needs, from its path below /api (or /buffers for the
+ * WebSocket) and method. Endpoints not listed here need admin.
+ */
+export function requiredScope(method: string, apiPath: string): ApiTokenScope {
+  const section = apiPath.split('/')[1] ?? '';
+  const write = !['GET', 'HEAD', 'OPTIONS'].includes(method.toUpperCase());
+  switch (section) {
+    case 'fs':
+      return write ? 'fs:write' : 'fs:read';
+    case 'sessions':
+    case 'groups':
+    case 'batch':
+    case 'exec':
+    case 'cleanup-exited':
+    case 'resize-policy':
+    case 'schedules':
+    case 'triggers':
+    case 'stats':
+    case 'buffers':
+      return write ? 'sessions:write' : 'sessions:read';
+    // Tools check the scopes they need themselves
+    case 'mcp':
+      return 'sessions:read';
+    default:
+      return 'admin';
+  }
+}
+
+/**
+ * Scope an API token needs for a resource of a batch query; queries of other
+ * resources answer the same as their own endpoints would
+ */
+export function requiredBatchScope(resource: string): ApiTokenScope {
+  switch (resource) {
+    // The remote registry and server-wide counters are admin endpoints of their own
+    case 'remotes':
+    case 'stats':
+      return 'admin';
+    default:
+      return 'sessions:read';
+  }
+}
+
+const hashToken = (token: string) => createHash('sha256').update(token).digest('hex');
+
+export class ApiTokenStore {
+  private tokens = new Map<string, StoredApiToken>();
+  private lastSaved = new Map<string, number>();
+
+  constructor(
+    private readonly filePath: string = path.join(os.homedir(), '.vibetunnel', 'api-tokens.json')
+  ) {
+    this.load();
+  }
+
+  /**
+   * Create a token for a user; returns the token, which is not stored
+   */
+  create(
+    userId: string,
+    options: { name: string; scopes: ApiTokenScope[]; expiresInDays?: number }
+  ): { token: string; info: ApiToken } {
+    const name = options.name.trim();
+    if (!name || name.length > MAX_NAME_LENGTH) {
+      throw new ApiTokenError(`Name must be 1 to ${MAX_NAME_LENGTH} characters`);
+    }
+    if (options.scopes.length === 0) {
+      throw new ApiTokenError('At least one scope is required');
+    }
+    const { expiresInDays } = options;
+    if (
+      expiresInDays !== undefined &&
+      (!Number.isInteger(expiresInDays) || expiresInDays < 1 || expiresInDays > MAX_EXPIRY_DAYS)
+    ) {
+      throw new ApiTokenError(`expiresInDays must be between 1 and ${MAX_EXPIRY_DAYS}`);
+    }
+    if (this.list(userId).length >= MAX_TOKENS_PER_USER) {
+      throw new ApiTokenError(`At most ${MAX_TOKENS_PER_USER} tokens per user`, 409);
+    }
+
+    const token = `${API_TOKEN_PREFIX}${randomBytes(32).toString('base64url')}`;
+    const now = new Date();
+    const stored: StoredApiToken = {
+      id: randomUUID(),
+      name,
+      userId,
+      scopes: [...new Set(options.scopes)],
+      hash: hashToken(token),
+      createdAt: now.toISOString(),
+      ...(expiresInDays && {
+        expiresAt: new Date(now.getTime() + expiresInDays * 86400000).toISOString(),
+      }),
+    };
+    this.tokens.set(stored.id, stored);
+    this.save();
+    logger.log(`api token ${stored.name} (${stored.id}) created for ${userId}`);
+    return { token, info: this.toInfo(stored) };
+  }
+
+  /**
+   * Tokens of a user, or of everyone without one
+   */
+  list(userId?: string): ApiToken[] {
+    return Array.from(this.tokens.values())
+      .filter((stored) => userId === undefined || stored.userId === userId)
+      .map((stored) => this.toInfo(stored));
+  }
+
+  get(id: string): ApiToken | undefined {
+    const stored = this.tokens.get(id);
+    return stored && this.toInfo(stored);
+  }
+
+  revoke(id: string): boolean {
+    const stored = this.tokens.get(id);
+    if (!stored) return false;
+    this.tokens.delete(id);
+    this.lastSaved.delete(id);
+    this.save();
+    logger.log(`api token ${stored.name} (${id}) of ${stored.userId} revoked`);
+    return true;
+  }
+
+  /**
+   * The token's record if it is known and not expired
+   */
+  verify(token: string): ApiToken | null {
+    if (!token.startsWith(API_TOKEN_PREFIX)) return null;
+    const hash = hashToken(token);
+    const stored = Array.from(this.tokens.values()).find((candidate) => candidate.hash === hash);
+    if (!stored) return null;
+    if (stored.expiresAt && Date.parse(stored.expiresAt) <= Date.now()) {
+      logger.debug(`api token ${stored.id} has expired`);
+      return null;
+    }
+
+    stored.lastUsedAt = new Date().toISOString();
+    if (Date.now() - (this.lastSaved.get(stored.id) ?? 0) > LAST_USED_SAVE_INTERVAL_MS) {
+      this.lastSaved.set(stored.id, Date.now());
+      this.save();
+    }
+    return this.toInfo(stored);
+  }
+
+  private toInfo(stored: StoredApiToken): ApiToken {
+    const { hash: _hash, ...info } = stored;
+    return { ...info, scopes: [...info.scopes] };
+  }
+
+  private load(): void {
+    try {
+      if (!fs.existsSync(this.filePath)) return;
+      const stored = JSON.parse(fs.readFileSync(this.filePath, 'utf8')) as {
+        tokens?: StoredApiToken[];
+      };
+      for (const token of stored.tokens ?? []) {
+        this.tokens.set(token.id, { ...token, scopes: token.scopes.filter(isApiTokenScope) });
+      }
+      logger.debug(`loaded ${this.tokens.size} api tokens`);
+    } catch (error) {
+      logger.error(`failed to load api tokens from ${this.filePath}:`, error);
+    }
+  }
+
+  private save(): void {
+    try {
+      fs.mkdirSync(path.dirname(this.filePath), { recursive: true });
+      const tempPath = `${this.filePath}.tmp`;
+      const tokens = Array.from(this.tokens.values());
+      fs.writeFileSync(tempPath, JSON.stringify({ tokens }, null, 2), { mode: 0o600 });
+      fs.renameSync(tempPath, this.filePath);
+    } catch (error) {
+      logger.error('failed to save api tokens:', error);
+    }
+  }
+}
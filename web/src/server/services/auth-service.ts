@@ -1,6 +1,8 @@
 import * as crypto from 'crypto';
 import * as jwt from 'jsonwebtoken';
-import { authenticate as pamAuthenticate } from './authenticate-pam-loader.js';
+import { lookupLocalAccount } from '../utils/local-accounts.js';
+import { type PasswordVerifier, verifyWithPam } from './password-verifier.js';
+import type { PendingLoginInfo, SecondFactorService } from './second-factor.js';
 
 interface AuthChallenge {
   challengeId: string;
@@ -9,11 +11,13 @@ interface AuthChallenge {
   userId: string;
 }
 
-interface AuthResult {
+export interface AuthResult {
   success: boolean;
   userId?: string;
   token?: string;
   error?: string;
+  // Set instead of token when the login must be confirmed with a second factor
+  secondFactor?: PendingLoginInfo;
 }
 
 interface SSHKeyAuth {
@@ -27,7 +31,11 @@ export class AuthService {
   private jwtSecret: string;
   private challengeTimeout = 5 * 60 * 1000; // 5 minutes
 
-  constructor() {
+  constructor(
+    private secondFactor?: SecondFactorService,
+    // Checks system account passwords (see password-verifier.ts)
+    private verifyPassword: PasswordVerifier = verifyWithPam
+  ) {
     // Generate or load JWT secret
     this.jwtSecret = process.env.JWT_SECRET || this.generateSecret();
 
@@ -82,14 +90,7 @@ export class AuthService {
       // Clean up challenge
       this.challenges.delete(sshKeyAuth.challengeId);
 
-      // Generate JWT token
-      const token = this.generateToken(challenge.userId);
-
-      return {
-        success: true,
-        userId: challenge.userId,
-        token,
-      };
+      return this.completeFirstFactor(challenge.userId, 'ssh-key');
     } catch (error) {
       console.error('SSH key authentication error:', error);
       return { success: false, error: 'SSH key authentication failed' };
@@ -97,7 +98,7 @@ export class AuthService {
   }
 
   /**
-   * Authenticate user with PAM (fallback method)
+   * Authenticate user with the system account password (fallback method)
    */
   async authenticateWithPassword(userId: string, password: string): Promise<AuthResult> {
     try {
@@ -108,32 +109,21 @@ export class AuthService {
       if (envUsername && envPassword) {
         // Use environment variable authentication
         if (userId === envUsername && password === envPassword) {
-          const token = this.generateToken(userId);
-          return {
-            success: true,
-            userId,
-            token,
-          };
+          return this.completeFirstFactor(userId, 'password');
         } else {
           return { success: false, error: 'Invalid username or password' };
         }
       }
 
-      // Fall back to PAM authentication
-      const isValid = await this.verifyPAMCredentials(userId, password);
+      // Fall back to the system account password (PAM by default)
+      const isValid = await this.verifyPassword(userId, password);
       if (!isValid) {
         return { success: false, error: 'Invalid username or password' };
       }
 
-      const token = this.generateToken(userId);
-
-      return {
-        success: true,
-        userId,
-        token,
-      };
+      return this.completeFirstFactor(userId, 'password');
     } catch (error) {
-      console.error('PAM authentication error:', error);
+      console.error('Password authentication error:', error);
       return { success: false, error: 'Authentication failed' };
     }
   }
@@ -161,37 +151,50 @@ export class AuthService {
   /**
    * Verify JWT token
    */
-  verifyToken(token: string): { valid: boolean; userId?: string } {
+  verifyToken(token: string): { valid: boolean; userId?: string; authMethod?: 'oidc' } {
     try {
-      const payload = jwt.verify(token, this.jwtSecret) as jwt.JwtPayload & { userId: string };
-      return { valid: true, userId: payload.userId };
+      const payload = jwt.verify(token, this.jwtSecret) as jwt.JwtPayload & {
+        userId: string;
+        authMethod?: 'oidc';
+      };
+      return { valid: true, userId: payload.userId, authMethod: payload.authMethod };
     } catch (_error) {
       return { valid: false };
     }
   }
 
   /**
-   * Generate JWT token
+   * Issue the token of a login whose second factor was verified
    */
-  private generateToken(userId: string): string {
-    return jwt.sign({ userId, iat: Math.floor(Date.now() / 1000) }, this.jwtSecret, {
-      expiresIn: '24h',
-    });
+  completeSecondFactor(userId: string): AuthResult {
+    return { success: true, userId, token: this.generateToken(userId) };
   }
 
   /**
-   * Verify credentials using PAM
+   * Issue the token of a user who signed in with the OpenID provider
    */
-  private async verifyPAMCredentials(username: string, password: string): Promise<boolean> {
-    return new Promise((resolve) => {
-      pamAuthenticate(username, password, (err: Error | null) => {
-        if (err) {
-          console.error('PAM authentication failed:', err.message);
-          resolve(false);
-        } else {
-          resolve(true);
-        }
-      });
+  createSingleSignOnToken(userId: string): string {
+    return this.generateToken(userId, 'oidc');
+  }
+
+  /**
+   * Issue a token, or start the second step for users who enrolled a second factor
+   */
+  private completeFirstFactor(userId: string, authMethod: 'ssh-key' | 'password'): AuthResult {
+    if (this.secondFactor?.isEnrolled(userId)) {
+      const secondFactor = this.secondFactor.startLogin(userId, authMethod);
+      return { success: true, userId, secondFactor };
+    }
+    return { success: true, userId, token: this.generateToken(userId) };
+  }
+
+  /**
+   * Generate JWT token
+   */
+  private generateToken(userId: string, authMethod?: 'oidc'): string {
+    const iat = Math.floor(Date.now() / 1000);
+    return jwt.sign({ userId, iat, ...(authMethod && { authMethod }) }, this.jwtSecret, {
+      expiresIn: '24h',
     });
   }
 
@@ -280,7 +283,10 @@ export class AuthService {
       const path = require('path');
 
       // Check user's authorized_keys file
-      const homeDir = userId === process.env.USER ? os.homedir() : `/home/${userId}`;
+      const homeDir =
+        userId === process.env.USER
+          ? os.homedir()
+          : (lookupLocalAccount(userId)?.home ?? `/home/${userId}`);
       const authorizedKeysPath = path.join(homeDir, '.ssh', 'authorized_keys');
 
       if (!fs.existsSync(authorizedKeysPath)) {
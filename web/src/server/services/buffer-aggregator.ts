@@ -1,47 +1,212 @@
 import chalk from 'chalk';
+import { randomBytes } from 'crypto';
 import { WebSocket } from 'ws';
+import {
+  BUFFER_PROTOCOL_VERSION,
+  type BufferCapability,
+  LEGACY_CAPABILITIES,
+  negotiateCapabilities,
+} from '../../shared/buffer-protocol.js';
+import { getFaultInjector } from '../utils/fault-injection.js';
+import { INPUT_SOURCE_HEADER, type InputSource } from '../utils/input-source.js';
 import { createLogger } from '../utils/logger.js';
+import {
+  isValidNamespacedSessionId,
+  namespaceSessionId,
+  toRemoteSessionId,
+} from '../utils/session-namespace.js';
+import { isValidSessionId, type PtyManager } from '../pty/index.js';
+import { type InlineImage, inlineImageUrl } from '../pty/inline-images.js';
+import {
+  type CollaborationMessage,
+  type CollaborationService,
+  parseSelection,
+} from './collaboration.js';
+import type { CrashReport, CrashReporter } from './crash-reporter.js';
+import {
+  INPUT_LOCKED_ERROR,
+  type InputLockInfo,
+  type InputLockManager,
+  LOCK_TOKEN_HEADER,
+} from './input-lock.js';
+import { type InputSequencer, parseInputBatch } from './input-sequencer.js';
 import type { RemoteRegistry } from './remote-registry.js';
+import type { SizeNegotiator } from './size-negotiator.js';
 import type { TerminalManager } from './terminal-manager.js';
+import type { TriggerEngine, TriggerEvent } from './trigger-engine.js';
+import {
+  mergeRemotePresence,
+  type SessionPresence,
+  type ViewerPresence,
+} from './viewer-presence.js';
+import {
+  closeWithTimeout,
+  type KeepaliveOptions,
+  type StaleReason,
+  startKeepalive,
+} from './websocket-keepalive.js';
+import { WebSocketWriter } from './websocket-writer.js';
 
 const logger = createLogger('buffer-aggregator');
 
+type Snapshot = Parameters<TerminalManager['encodeSnapshot']>[0];
+
+// Upper bound for the per-subscription frame rate a client may request
+const MAX_CLIENT_FPS = 60;
+
+// How long the subscriptions of a dropped client are kept for it to resume
+const RESUME_WINDOW_MS = 30 * 1000;
+
+// Backoff for reconnecting to remotes whose sessions are still mirrored
+const REMOTE_RECONNECT_BASE_MS = 1000;
+const REMOTE_RECONNECT_MAX_MS = 30 * 1000;
+
 interface BufferAggregatorConfig {
   terminalManager: TerminalManager;
   remoteRegistry: RemoteRegistry | null;
   isHQMode: boolean;
+  // Sequenced input over the WebSocket (disabled when not provided)
+  ptyManager?: PtyManager;
+  inputSequencer?: InputSequencer;
+  // Ping/deadline settings for client and remote connections
+  keepalive?: Partial<KeepaliveOptions>;
+  // Viewer sizes used to crop snapshots for viewers smaller than the PTY
+  sizeNegotiator?: SizeNegotiator;
+  // Presence of clients subscribed to local and remote sessions
+  viewerPresence?: ViewerPresence;
+  // Typing and selection indicators relayed between subscribers
+  collaboration?: CollaborationService;
+  // Exclusive input control; input without the holder's token is rejected
+  inputLocks?: InputLockManager;
+  // Trigger events sent to the clients viewing the session
+  triggers?: TriggerEngine;
+  // Crashes of session tasks, sent to the clients viewing the session
+  crashReporter?: CrashReporter;
+}
+
+// Who a client connection was authenticated as
+export interface ClientIdentity {
+  userId?: string;
+  // HQ mirroring sessions for its own clients, which HQ tracks as viewers itself
+  isHQRequest?: boolean;
+  // Attribution of input sent over the connection
+  inputSource?: InputSource;
 }
 
 interface RemoteWebSocketConnection {
   ws: WebSocket;
   remoteId: string;
   remoteName: string;
-  subscriptions: Set<string>;
+  // Protocol version from the remote's welcome message
+  protocolVersion: string;
+  // HQ clients mirroring each session (by the remote's own session ID); the remote is
+  // subscribed once per session
+  subscriptions: Map<string, Set<WebSocket>>;
+  // Latest frame per session (already framed with the namespaced ID), replayed to clients
+  // joining an existing mirror
+  lastFrames: Map<string, Buffer>;
+  // Latest presence the remote reported per session, merged with HQ's own viewers
+  presence: Map<string, Pick<SessionPresence, 'viewers' | 'typing'>>;
+}
+
+interface DeliveredFrame {
+  snapshot: Snapshot;
+  viewerSize: string;
+}
+
+interface ResumableSubscription {
+  maxFps?: number;
+  viewerId?: string;
+  // Last frame written to the socket, not sent again to a resumed client
+  delivered?: DeliveredFrame;
+}
+
+interface ResumeState {
+  token: string;
+  // Local session subscriptions (remote mirrors are not resumable)
+  sessions: Map<string, ResumableSubscription>;
+}
+
+interface DetachedClient {
+  sessions: Map<string, ResumableSubscription>;
+  timer: NodeJS.Timeout;
+}
+
+interface PendingRemoteReconnect {
+  timer: NodeJS.Timeout;
+  subscriptions: Map<string, Set<WebSocket>>;
 }
 
 export class BufferAggregator {
   private config: BufferAggregatorConfig;
   private remoteConnections: Map<string, RemoteWebSocketConnection> = new Map();
+  private remoteReconnects: Map<string, PendingRemoteReconnect> = new Map();
   private clientSubscriptions: Map<WebSocket, Map<string, () => void>> = new Map();
+  // All writes to a client go through its bounded outbound queue
+  private clientWriters: Map<WebSocket, WebSocketWriter> = new Map();
+  private clientIdentities: Map<WebSocket, ClientIdentity> = new Map();
+  // Connection IDs, which identify clients as collaboration participants
+  private clientIds: Map<WebSocket, string> = new Map();
+  // Features negotiated with the client's hello (legacy set until then)
+  private clientCapabilities: Map<WebSocket, Set<BufferCapability>> = new Map();
+  // Resume tokens of connected clients, and subscriptions kept for dropped ones
+  private clientResume: Map<WebSocket, ResumeState> = new Map();
+  private detachedClients: Map<string, DetachedClient> = new Map();
+  private resumeStats = { resumed: 0, failed: 0 };
+  private staleConnections: Record<StaleReason, number> = {
+    'pong-timeout': 0,
+    'write-timeout': 0,
+  };
 
   constructor(config: BufferAggregatorConfig) {
     this.config = config;
+    config.viewerPresence?.on('presence-changed', (presence: SessionPresence) => {
+      this.broadcastPresence(presence.sessionId);
+    });
+    config.collaboration?.on('collaboration', (message: CollaborationMessage) => {
+      this.broadcastCollaboration(message);
+    });
+    config.inputLocks?.on('lock-changed', (sessionId: string, lock: InputLockInfo | null) => {
+      this.broadcastToSubscribers(sessionId, JSON.stringify({ type: 'lock', sessionId, lock }));
+    });
+    config.triggers?.on('trigger', (event: TriggerEvent) => {
+      this.broadcastToSubscribers(event.sessionId, JSON.stringify({ type: 'trigger', ...event }));
+    });
+    config.crashReporter?.on('crash', ({ sessionId, id, task, message, crashedAt }: CrashReport) => {
+      const crash = { id, task, message, crashedAt };
+      this.broadcastToSubscribers(sessionId, JSON.stringify({ type: 'crash', sessionId, crash }));
+    });
     logger.log(`BufferAggregator initialized (HQ mode: ${config.isHQMode})`);
   }
 
   /**
    * Handle a new client WebSocket connection
    */
-  async handleClientConnection(ws: WebSocket): Promise<void> {
+  async handleClientConnection(ws: WebSocket, identity: ClientIdentity = {}): Promise<void> {
     logger.log(chalk.blue('New client connected'));
-    const clientId = `client-${Date.now()}`;
+    const clientId = `client-${randomBytes(8).toString('hex')}`;
     logger.debug(`Assigned client ID: ${clientId}`);
 
     // Initialize subscription map for this client
     this.clientSubscriptions.set(ws, new Map());
+    this.clientWriters.set(ws, new WebSocketWriter(ws));
+    this.clientIdentities.set(ws, identity);
+    this.clientIds.set(ws, clientId);
+    this.clientCapabilities.set(ws, new Set(LEGACY_CAPABILITIES));
+    const resumeToken = randomBytes(16).toString('hex');
+    this.clientResume.set(ws, { token: resumeToken, sessions: new Map() });
+
+    // Reap the connection if the client stops responding
+    startKeepalive(ws, this.config.keepalive, (reason) => {
+      this.staleConnections[reason]++;
+      logger.warn(`Client ${clientId} stopped responding (${reason}), closing connection`);
+    });
 
     // Send welcome message
-    ws.send(JSON.stringify({ type: 'connected', version: '1.0' }));
+    this.sendToClient(
+      ws,
+      JSON.stringify({ type: 'connected', version: BUFFER_PROTOCOL_VERSION, resumeToken })
+    );
     logger.debug('Sent welcome message to client');
 
     // Handle messages from client
@@ -51,7 +216,8 @@ export class BufferAggregator {
         await this.handleClientMessage(ws, data);
       } catch (error) {
         logger.error('Error handling client message:', error);
-        ws.send(
+        this.sendToClient(
+          ws,
           JSON.stringify({
             type: 'error',
             message: 'Invalid message format',
@@ -70,16 +236,39 @@ export class BufferAggregator {
     });
   }
 
+  private isValidClientSessionId(sessionId: unknown): boolean {
+    return (
+      isValidSessionId(sessionId) ||
+      (this.config.isHQMode &&
+        typeof sessionId === 'string' &&
+        isValidNamespacedSessionId(sessionId))
+    );
+  }
+
   /**
    * Handle messages from a client
    */
   private async handleClientMessage(
     clientWs: WebSocket,
-    data: { type: string; sessionId?: string }
+    data: {
+      type: string;
+      sessionId?: string;
+      maxFps?: number;
+      viewerId?: string;
+      sessionIds?: unknown;
+      resumeToken?: unknown;
+      capabilities?: unknown;
+      [key: string]: unknown;
+    }
   ): Promise<void> {
     const subscriptions = this.clientSubscriptions.get(clientWs);
     if (!subscriptions) return;
 
+    if (data.sessionId !== undefined && !this.isValidClientSessionId(data.sessionId)) {
+      this.sendToClient(clientWs, JSON.stringify({ type: 'error', message: 'Invalid session ID' }));
+      return;
+    }
+
     if (data.type === 'subscribe' && data.sessionId) {
       const sessionId = data.sessionId;
 
@@ -90,6 +279,7 @@ export class BufferAggregator {
           existingUnsubscribe();
         }
         subscriptions.delete(sessionId);
+        this.clientResume.get(clientWs)?.sessions.delete(sessionId);
       }
 
       // Check if this is a local or remote session
@@ -98,17 +288,22 @@ export class BufferAggregator {
         this.config.remoteRegistry &&
         this.config.remoteRegistry.getRemoteBySessionId(sessionId);
 
+      const viewerId = typeof data.viewerId === 'string' ? data.viewerId : undefined;
       if (isRemoteSession) {
         // Subscribe to remote session
         logger.debug(`Subscribing to remote session ${sessionId} on remote ${isRemoteSession.id}`);
-        await this.subscribeToRemoteSession(clientWs, sessionId, isRemoteSession.id);
+        await this.subscribeToRemoteSession(clientWs, sessionId, isRemoteSession.id, viewerId);
       } else {
         // Subscribe to local session
         logger.debug(`Subscribing to local session ${sessionId}`);
-        await this.subscribeToLocalSession(clientWs, sessionId);
+        const maxFps =
+          typeof data.maxFps === 'number' && data.maxFps > 0
+            ? Math.min(data.maxFps, MAX_CLIENT_FPS)
+            : undefined;
+        await this.subscribeToLocalSession(clientWs, sessionId, maxFps, viewerId);
       }
 
-      clientWs.send(JSON.stringify({ type: 'subscribed', sessionId }));
+      this.sendToClient(clientWs, JSON.stringify({ type: 'subscribed', sessionId }));
       logger.log(chalk.green(`Client subscribed to session ${sessionId}`));
     } else if (data.type === 'unsubscribe' && data.sessionId) {
       const sessionId = data.sessionId;
@@ -116,108 +311,368 @@ export class BufferAggregator {
       if (unsubscribe) {
         unsubscribe();
         subscriptions.delete(sessionId);
+        this.clientResume.get(clientWs)?.sessions.delete(sessionId);
         logger.log(chalk.yellow(`Client unsubscribed from session ${sessionId}`));
       }
+    } else if (data.type === 'hello') {
+      this.handleHello(clientWs, data.version, data.capabilities);
+    } else if (data.type === 'resume' && typeof data.resumeToken === 'string') {
+      await this.handleResume(clientWs, data.resumeToken);
+    } else if (data.type === 'sync' && Array.isArray(data.sessionIds)) {
+      const sessionIds = data.sessionIds.filter((id): id is string => typeof id === 'string');
+      await this.handleSync(clientWs, sessionIds);
+    } else if (data.type === 'input' && data.sessionId) {
+      await this.handleClientInput(clientWs, data.sessionId, data);
+    } else if (data.type === 'selection' && data.sessionId) {
+      this.handleClientSelection(clientWs, data.sessionId, data.selection);
+    } else if (data.type === 'ping') {
+      this.sendToClient(clientWs, JSON.stringify({ type: 'pong', timestamp: Date.now() }));
+    }
+  }
 
-      // Also unsubscribe from remote if applicable
-      if (this.config.isHQMode && this.config.remoteRegistry) {
-        const remote = this.config.remoteRegistry.getRemoteBySessionId(sessionId);
-        if (remote) {
-          const remoteConn = this.remoteConnections.get(remote.id);
-          if (remoteConn) {
-            remoteConn.subscriptions.delete(sessionId);
-            if (remoteConn.ws.readyState === WebSocket.OPEN) {
-              remoteConn.ws.send(JSON.stringify({ type: 'unsubscribe', sessionId }));
-              logger.debug(
-                `Sent unsubscribe request to remote ${remoteConn.remoteName} for session ${sessionId}`
-              );
-            } else {
-              logger.debug(
-                `Cannot unsubscribe from remote ${remoteConn.remoteName} - WebSocket not open`
-              );
-            }
-          }
+  /**
+   * Negotiate the protocol features of a connection
+   * ({ type: 'hello', version, capabilities: [...] }). Answered with the server's
+   * version and the capabilities both sides support; frames sent from then on use them.
+   */
+  private handleHello(clientWs: WebSocket, version: unknown, requested: unknown): void {
+    const capabilities = negotiateCapabilities(requested);
+    this.clientCapabilities.set(clientWs, new Set(capabilities));
+    logger.debug(
+      `Client ${this.clientIds.get(clientWs)} speaks protocol ${String(version)}, ` +
+        `using ${capabilities.join(', ') || 'no capabilities'}`
+    );
+    this.sendToClient(
+      clientWs,
+      JSON.stringify({ type: 'hello', version: BUFFER_PROTOCOL_VERSION, capabilities })
+    );
+  }
+
+  private hasCapability(clientWs: WebSocket, capability: BufferCapability): boolean {
+    return this.clientCapabilities.get(clientWs)?.has(capability) ?? false;
+  }
+
+  /**
+   * Replace all of a client's subscriptions with the given local sessions and
+   * send a fresh snapshot for each ({ type: 'sync', sessionIds }). HQ uses this
+   * to (re)establish its mirror of a remote in one message.
+   */
+  private async handleSync(clientWs: WebSocket, sessionIds: string[]): Promise<void> {
+    const subscriptions = this.clientSubscriptions.get(clientWs);
+    if (!subscriptions) return;
+
+    for (const unsubscribe of subscriptions.values()) {
+      unsubscribe();
+    }
+    subscriptions.clear();
+    this.clientResume.get(clientWs)?.sessions.clear();
+
+    const wanted = Array.from(new Set(sessionIds));
+    for (const sessionId of wanted) {
+      await this.subscribeToLocalSession(clientWs, sessionId);
+    }
+
+    this.sendToClient(clientWs, JSON.stringify({ type: 'synced', sessionIds: wanted }));
+    logger.log(chalk.green(`Client synced ${wanted.length} session subscriptions`));
+  }
+
+  /**
+   * Restore the local subscriptions of a connection that dropped less than
+   * RESUME_WINDOW_MS ago ({ type: 'resume', resumeToken } with the token from its
+   * welcome message). Frames the client already received are not sent again, so it
+   * only gets the sessions that changed while it was away.
+   */
+  private async handleResume(clientWs: WebSocket, resumeToken: string): Promise<void> {
+    const detached = this.detachedClients.get(resumeToken);
+    if (!detached) {
+      this.resumeStats.failed++;
+      logger.debug('Resume token unknown or expired');
+      this.sendToClient(clientWs, JSON.stringify({ type: 'resume-failed' }));
+      return;
+    }
+    clearTimeout(detached.timer);
+    this.detachedClients.delete(resumeToken);
+
+    const subscriptions = this.clientSubscriptions.get(clientWs);
+    for (const [sessionId, subscription] of detached.sessions) {
+      if (subscriptions?.has(sessionId)) continue;
+      await this.subscribeToLocalSession(
+        clientWs,
+        sessionId,
+        subscription.maxFps,
+        subscription.viewerId,
+        subscription.delivered
+      );
+    }
+
+    this.resumeStats.resumed++;
+    const sessionIds = Array.from(detached.sessions.keys());
+    this.sendToClient(clientWs, JSON.stringify({ type: 'resumed', sessionIds }));
+    logger.log(chalk.green(`Client resumed ${sessionIds.length} session subscriptions`));
+  }
+
+  /**
+   * Apply a sequenced input batch and acknowledge it
+   * ({ type: 'input', sessionId, clientId?, seq, inputs: [{ text } | { key }, ...], lockToken? })
+   */
+  private async handleClientInput(
+    clientWs: WebSocket,
+    sessionId: string,
+    data: Record<string, unknown>
+  ): Promise<void> {
+    const reply = (message: Record<string, unknown>) =>
+      this.sendToClient(clientWs, JSON.stringify({ sessionId, seq: data.seq, ...message }));
+
+    const { ptyManager, inputSequencer } = this.config;
+    if (!ptyManager || !inputSequencer) {
+      reply({ type: 'input-error', error: 'Input over WebSocket is not supported' });
+      return;
+    }
+
+    const parsed = parseInputBatch(data);
+    if (!parsed.batch) {
+      reply({ type: 'input-error', error: parsed.error });
+      return;
+    }
+    const batch = parsed.batch;
+    const lockToken = typeof data.lockToken === 'string' ? data.lockToken : undefined;
+
+    const identity = this.clientIdentities.get(clientWs);
+    if (!identity?.isHQRequest) {
+      this.config.viewerPresence?.recordInput(sessionId, identity?.userId);
+      const clientId = this.clientIds.get(clientWs);
+      this.config.collaboration?.recordTyping(sessionId, identity?.userId, clientId);
+    }
+
+    // Remote sessions: forward the batch and relay the remote's acknowledgement
+    const remote =
+      this.config.isHQMode && this.config.remoteRegistry
+        ? this.config.remoteRegistry.getRemoteBySessionId(sessionId)
+        : undefined;
+    if (remote) {
+      try {
+        // Encoded: behind a federated HQ the remote's ID is itself namespaced
+        const remoteSessionId = encodeURIComponent(toRemoteSessionId(sessionId));
+        const response = await fetch(`${remote.url}/api/sessions/${remoteSessionId}/input`, {
+          method: 'POST',
+          headers: {
+            'Content-Type': 'application/json',
+            Authorization: `Bearer ${remote.token}`,
+            [INPUT_SOURCE_HEADER]: JSON.stringify(identity?.inputSource ?? {}),
+            ...(lockToken ? { [LOCK_TOKEN_HEADER]: lockToken } : {}),
+          },
+          body: JSON.stringify(batch),
+          signal: AbortSignal.timeout(5000),
+        });
+        const result = await response.json();
+        if (!response.ok) {
+          reply({ type: 'input-error', error: result.error || 'Remote rejected input' });
+        } else {
+          reply({ type: 'input-ack', ack: result.ack, status: result.status });
         }
+      } catch (error) {
+        logger.error(`Failed to forward input to remote ${remote.name}:`, error);
+        reply({ type: 'input-error', error: 'Failed to reach remote server' });
       }
-    } else if (data.type === 'ping') {
-      clientWs.send(JSON.stringify({ type: 'pong', timestamp: Date.now() }));
+      return;
+    }
+
+    const session = ptyManager.getSession(sessionId);
+    if (!session || session.status !== 'running') {
+      reply({ type: 'input-error', error: 'Session is not running' });
+      return;
+    }
+
+    if (this.config.inputLocks && !this.config.inputLocks.checkInput(sessionId, lockToken)) {
+      reply({ type: 'input-error', ...INPUT_LOCKED_ERROR });
+      return;
+    }
+
+    try {
+      const result = inputSequencer.submit(sessionId, batch, (inputs) => {
+        for (const input of inputs) {
+          ptyManager.sendInput(sessionId, input, identity?.inputSource);
+        }
+      });
+      reply({ type: 'input-ack', ...result });
+    } catch (error) {
+      logger.error(`Failed to send input to session ${sessionId}:`, error);
+      reply({ type: 'input-error', error: 'Failed to send input' });
     }
   }
 
   /**
    * Subscribe a client to a local session
    */
-  private async subscribeToLocalSession(clientWs: WebSocket, sessionId: string): Promise<void> {
+  private async subscribeToLocalSession(
+    clientWs: WebSocket,
+    sessionId: string,
+    maxFps?: number,
+    viewerId?: string,
+    resumeFrom?: DeliveredFrame
+  ): Promise<void> {
     const subscriptions = this.clientSubscriptions.get(clientWs);
     if (!subscriptions) return;
 
+    const resumable: ResumableSubscription = { maxFps, viewerId, delivered: resumeFrom };
+    this.clientResume.get(clientWs)?.sessions.set(sessionId, resumable);
+
+    // Snapshots are reused while the buffer is unchanged, so identical frames can be skipped.
+    // The viewer size is part of the key because a resized viewer needs a different crop.
+    let lastSent: DeliveredFrame | undefined = resumeFrom;
+    // Rows of the last frame handed to the socket, the base of delta frames. Queued frames
+    // are replaced by newer ones, so only handed over frames reach the client.
+    let baseRows: Buffer[] | undefined;
+
+    const sendIfChanged = (snapshot: Snapshot): number | null => {
+      const viewerSize = viewerId
+        ? this.config.sizeNegotiator?.getViewerSize(sessionId, viewerId)
+        : undefined;
+      const viewerSizeKey = viewerSize ? `${viewerSize.cols}x${viewerSize.rows}` : '';
+      if (lastSent?.snapshot === snapshot && lastSent.viewerSize === viewerSizeKey) {
+        return null;
+      }
+      const sent = { snapshot, viewerSize: viewerSizeKey };
+      lastSent = sent;
+      const frame = viewerSize
+        ? this.config.terminalManager.cropSnapshot(snapshot, viewerSize.cols, viewerSize.rows)
+        : snapshot;
+      return this.sendSnapshot(clientWs, sessionId, frame, {
+        baseRows,
+        onSent: (rows) => {
+          baseRows = rows;
+        },
+        onWritten: () => {
+          resumable.delivered = sent;
+        },
+      });
+    };
+
     try {
       const unsubscribe = await this.config.terminalManager.subscribeToBufferChanges(
         sessionId,
-        (sessionId: string, snapshot: Parameters<TerminalManager['encodeSnapshot']>[0]) => {
+        (sessionId: string, snapshot: Snapshot) => {
           try {
-            const buffer = this.config.terminalManager.encodeSnapshot(snapshot);
-            const sessionIdBuffer = Buffer.from(sessionId, 'utf8');
-            const totalLength = 1 + 4 + sessionIdBuffer.length + buffer.length;
-            const fullBuffer = Buffer.allocUnsafe(totalLength);
-
-            let offset = 0;
-            fullBuffer.writeUInt8(0xbf, offset); // Magic byte for binary message
-            offset += 1;
-
-            fullBuffer.writeUInt32LE(sessionIdBuffer.length, offset);
-            offset += 4;
-
-            sessionIdBuffer.copy(fullBuffer, offset);
-            offset += sessionIdBuffer.length;
-
-            buffer.copy(fullBuffer, offset);
-
-            if (clientWs.readyState === WebSocket.OPEN) {
-              clientWs.send(fullBuffer);
-            } else {
+            if (clientWs.readyState !== WebSocket.OPEN) {
               logger.debug(`Skipping buffer update - client WebSocket not open`);
+            } else if (sendIfChanged(snapshot) === null) {
+              logger.debug(`Skipping unchanged buffer update for session ${sessionId}`);
             }
           } catch (error) {
             logger.error('Error encoding buffer update:', error);
           }
+        },
+        {
+          maxFps,
+          onImage: (image, cursor) => this.sendImage(clientWs, sessionId, image, cursor),
         }
       );
 
-      subscriptions.set(sessionId, unsubscribe);
+      let leavePresence = () => {};
+      subscriptions.set(sessionId, () => {
+        unsubscribe();
+        leavePresence();
+      });
+      leavePresence = this.joinPresence(clientWs, sessionId, viewerId);
       logger.debug(`Created subscription for local session ${sessionId}`);
 
       // Send initial buffer
       logger.debug(`Sending initial buffer for session ${sessionId}`);
       const initialSnapshot = await this.config.terminalManager.getBufferSnapshot(sessionId);
-      const buffer = this.config.terminalManager.encodeSnapshot(initialSnapshot);
 
-      const sessionIdBuffer = Buffer.from(sessionId, 'utf8');
-      const totalLength = 1 + 4 + sessionIdBuffer.length + buffer.length;
-      const fullBuffer = Buffer.allocUnsafe(totalLength);
+      if (clientWs.readyState !== WebSocket.OPEN) {
+        logger.warn(`Cannot send initial buffer - client WebSocket not open`);
+      } else {
+        const size = sendIfChanged(initialSnapshot);
+        if (size === null) {
+          logger.debug(`Initial buffer for session ${sessionId} already sent`);
+        } else {
+          logger.debug(`Sent initial buffer (${size} bytes) for session ${sessionId}`);
+        }
+      }
+    } catch (error) {
+      logger.error(`Error subscribing to local session ${sessionId}:`, error);
+      this.clientResume.get(clientWs)?.sessions.delete(sessionId);
+      this.sendToClient(
+        clientWs,
+        JSON.stringify({ type: 'error', message: 'Failed to subscribe to session' })
+      );
+    }
+  }
 
-      let offset = 0;
-      fullBuffer.writeUInt8(0xbf, offset);
-      offset += 1;
+  /**
+   * Tell a client about an image printed in a session it is subscribed to
+   * ({ type: 'image', sessionId, image: { id, format, mime, size, url, ... }, cursorX, cursorY })
+   */
+  private sendImage(
+    clientWs: WebSocket,
+    sessionId: string,
+    image: InlineImage,
+    cursor: { cursorX: number; cursorY: number }
+  ): void {
+    if (!this.hasCapability(clientWs, 'images')) return;
+    const url = inlineImageUrl(sessionId, image.id);
+    this.sendToClient(
+      clientWs,
+      JSON.stringify({ type: 'image', sessionId, image: { ...image, url }, ...cursor })
+    );
+  }
 
-      fullBuffer.writeUInt32LE(sessionIdBuffer.length, offset);
-      offset += 4;
+  /**
+   * Encode a snapshot into a pooled buffer with the binary message framing
+   * (magic byte, session ID length, session ID) and send it, using the format
+   * features the client negotiated. The buffer is returned to the pool once the
+   * socket has written it. Returns the frame size.
+   */
+  private sendSnapshot(
+    clientWs: WebSocket,
+    sessionId: string,
+    snapshot: Snapshot,
+    options: {
+      // Rows of the previous frame handed to the socket, for a delta frame
+      baseRows?: Buffer[];
+      onSent?: (rows: Buffer[]) => void;
+      onWritten?: () => void;
+    } = {}
+  ): number {
+    const sessionIdBuffer = Buffer.from(sessionId, 'utf8');
+    const prefixLength = 1 + 4 + sessionIdBuffer.length;
+    const frame =
+      snapshot.links && !this.hasCapability(clientWs, 'links')
+        ? { ...snapshot, links: undefined }
+        : snapshot;
+    const pooled = this.config.terminalManager.encodeSnapshotPooled(frame, prefixLength, {
+      baseRows: this.hasCapability(clientWs, 'deltas') ? options.baseRows : undefined,
+      compress: this.hasCapability(clientWs, 'compression'),
+    });
+    const fullBuffer = pooled.buffer;
 
-      sessionIdBuffer.copy(fullBuffer, offset);
-      offset += sessionIdBuffer.length;
+    let offset = 0;
+    fullBuffer.writeUInt8(0xbf, offset); // Magic byte for binary message
+    offset += 1;
 
-      buffer.copy(fullBuffer, offset);
+    fullBuffer.writeUInt32LE(sessionIdBuffer.length, offset);
+    offset += 4;
 
-      if (clientWs.readyState === WebSocket.OPEN) {
-        clientWs.send(fullBuffer);
-        logger.debug(`Sent initial buffer (${fullBuffer.length} bytes) for session ${sessionId}`);
-      } else {
-        logger.warn(`Cannot send initial buffer - client WebSocket not open`);
-      }
-    } catch (error) {
-      logger.error(`Error subscribing to local session ${sessionId}:`, error);
-      clientWs.send(JSON.stringify({ type: 'error', message: 'Failed to subscribe to session' }));
+    sessionIdBuffer.copy(fullBuffer, offset);
+
+    const size = fullBuffer.length;
+    const writer = this.clientWriters.get(clientWs);
+    if (writer) {
+      // A newer snapshot for the same session replaces this one if it is still queued
+      writer.sendFrame(
+        sessionId,
+        fullBuffer,
+        (written) => {
+          pooled.release();
+          if (written) options.onWritten?.();
+        },
+        () => options.onSent?.(pooled.rows)
+      );
+    } else {
+      pooled.release();
     }
+    return size;
   }
 
   /**
@@ -226,7 +681,8 @@ export class BufferAggregator {
   private async subscribeToRemoteSession(
     clientWs: WebSocket,
     sessionId: string,
-    remoteId: string
+    remoteId: string,
+    viewerId?: string
   ): Promise<void> {
     // Ensure we have a connection to this remote
     let remoteConn = this.remoteConnections.get(remoteId);
@@ -236,7 +692,8 @@ export class BufferAggregator {
       const connected = await this.connectToRemote(remoteId);
       if (!connected) {
         logger.warn(`Failed to connect to remote ${remoteId} for session ${sessionId}`);
-        clientWs.send(
+        this.sendToClient(
+          clientWs,
           JSON.stringify({ type: 'error', message: 'Failed to connect to remote server' })
         );
         return;
@@ -246,19 +703,174 @@ export class BufferAggregator {
 
     if (!remoteConn) return;
 
-    // Subscribe to the session on the remote
-    remoteConn.subscriptions.add(sessionId);
-    remoteConn.ws.send(JSON.stringify({ type: 'subscribe', sessionId }));
-    logger.debug(
-      `Sent subscription request to remote ${remoteConn.remoteName} for session ${sessionId}`
-    );
+    // The remote knows the session by its plain ID
+    const remoteSessionId = toRemoteSessionId(sessionId);
+    let subscribers = remoteConn.subscriptions.get(remoteSessionId);
+    if (!subscribers) {
+      // First HQ client for this session: subscribe on the remote
+      subscribers = new Set();
+      remoteConn.subscriptions.set(remoteSessionId, subscribers);
+      remoteConn.ws.send(JSON.stringify({ type: 'subscribe', sessionId: remoteSessionId }));
+      logger.debug(
+        `Sent subscription request to remote ${remoteConn.remoteName} for session ${sessionId}`
+      );
+    } else {
+      // Already mirrored: replay the latest frame instead of asking the remote again
+      const lastFrame = remoteConn.lastFrames.get(remoteSessionId);
+      if (lastFrame) {
+        this.clientWriters.get(clientWs)?.sendFrame(sessionId, lastFrame);
+      }
+      logger.debug(
+        `Session ${sessionId} already mirrored from remote ${remoteConn.remoteName} for ${subscribers.size} clients`
+      );
+    }
+    subscribers.add(clientWs);
 
-    // Store an unsubscribe function for the client
     const subscriptions = this.clientSubscriptions.get(clientWs);
     if (subscriptions) {
+      let leavePresence = () => {};
       subscriptions.set(sessionId, () => {
-        // Will be handled in the unsubscribe message handler
+        this.releaseRemoteSubscription(remoteId, remoteSessionId, clientWs);
+        leavePresence();
       });
+      leavePresence = this.joinPresence(clientWs, sessionId, viewerId);
+    }
+  }
+
+  /**
+   * Add a subscribed client to the viewers of a session and return the function
+   * removing it again (which also clears its selection). HQ connections are not
+   * viewers but still get the current presence, which joining viewers receive
+   * through the broadcast. Both get the selections made before they joined.
+   */
+  private joinPresence(clientWs: WebSocket, sessionId: string, viewerId?: string): () => void {
+    const { viewerPresence: presence, collaboration } = this.config;
+    if (!presence) return () => {};
+
+    for (const payload of collaboration?.getSelections(sessionId) ?? []) {
+      this.sendToClient(clientWs, JSON.stringify({ type: 'collab', sessionId, ...payload }));
+    }
+
+    const identity = this.clientIdentities.get(clientWs);
+    if (identity?.isHQRequest) {
+      this.sendToClient(
+        clientWs,
+        JSON.stringify({ type: 'presence', ...this.getPresence(sessionId) })
+      );
+      return () => {};
+    }
+
+    const id = presence.join(sessionId, 'websocket', identity?.userId, viewerId);
+    const clientId = this.clientIds.get(clientWs);
+    return () => {
+      presence.leave(sessionId, id);
+      if (clientId) collaboration?.leave(sessionId, clientId);
+    };
+  }
+
+  /**
+   * Set or clear the selection of a client in a session it is subscribed to
+   * ({ type: 'selection', sessionId, selection: { startCol, startRow, endCol, endRow } | null })
+   */
+  private handleClientSelection(clientWs: WebSocket, sessionId: string, value: unknown): void {
+    const collaboration = this.config.collaboration;
+    const clientId = this.clientIds.get(clientWs);
+    const identity = this.clientIdentities.get(clientWs);
+    if (!collaboration || !clientId || identity?.isHQRequest) return;
+    if (!this.clientSubscriptions.get(clientWs)?.has(sessionId)) return;
+
+    const selection = parseSelection(value);
+    if (selection === undefined) {
+      this.sendToClient(clientWs, JSON.stringify({ type: 'error', message: 'Invalid selection' }));
+      return;
+    }
+    collaboration.setSelection(sessionId, clientId, identity?.userId, selection);
+  }
+
+  /**
+   * Relay a typing or selection event to the subscribers of its session other
+   * than the client it came from ({ type: 'collab', sessionId, event, userId, ... })
+   */
+  private broadcastCollaboration({ sessionId, origin, payload }: CollaborationMessage): void {
+    const message = JSON.stringify({ type: 'collab', sessionId, ...payload });
+    for (const [clientWs, subscriptions] of this.clientSubscriptions) {
+      if (subscriptions.has(sessionId) && this.clientIds.get(clientWs) !== origin) {
+        this.sendToClient(clientWs, message);
+      }
+    }
+  }
+
+  /**
+   * Send the presence of a session to every client subscribed to it
+   * ({ type: 'presence', sessionId, viewers, typing })
+   */
+  private broadcastPresence(sessionId: string): void {
+    this.broadcastToSubscribers(
+      sessionId,
+      JSON.stringify({ type: 'presence', ...this.getPresence(sessionId) })
+    );
+  }
+
+  /**
+   * Send a control message to every client subscribed to a session
+   */
+  private broadcastToSubscribers(sessionId: string, message: string): void {
+    for (const [clientWs, subscriptions] of this.clientSubscriptions) {
+      if (subscriptions.has(sessionId)) {
+        this.sendToClient(clientWs, message);
+      }
+    }
+  }
+
+  /**
+   * Presence of a session, merged with the viewers the remote reported for
+   * remote sessions in HQ mode
+   */
+  private getPresence(sessionId: string): SessionPresence {
+    const local = this.config.viewerPresence?.getPresence(sessionId) ?? {
+      sessionId,
+      viewers: [],
+      typing: [],
+    };
+    const remote =
+      this.config.isHQMode && this.config.remoteRegistry
+        ? this.config.remoteRegistry.getRemoteBySessionId(sessionId)
+        : undefined;
+    const reported =
+      remote &&
+      this.remoteConnections.get(remote.id)?.presence.get(toRemoteSessionId(sessionId));
+    return remote && reported ? mergeRemotePresence(local, reported, remote.name) : local;
+  }
+
+  /**
+   * Drop a client's interest in a remote session. The remote is unsubscribed
+   * once no HQ client mirrors the session anymore.
+   */
+  private releaseRemoteSubscription(
+    remoteId: string,
+    sessionId: string,
+    clientWs: WebSocket
+  ): void {
+    const remoteConn = this.remoteConnections.get(remoteId);
+    const subscriptions =
+      remoteConn?.subscriptions ?? this.remoteReconnects.get(remoteId)?.subscriptions;
+    const subscribers = subscriptions?.get(sessionId);
+    if (!subscriptions || !subscribers) return;
+
+    subscribers.delete(clientWs);
+    if (subscribers.size > 0) return;
+    subscriptions.delete(sessionId);
+    if (!remoteConn) return;
+
+    remoteConn.lastFrames.delete(sessionId);
+    remoteConn.presence.delete(sessionId);
+    if (remoteConn.ws.readyState === WebSocket.OPEN) {
+      remoteConn.ws.send(JSON.stringify({ type: 'unsubscribe', sessionId }));
+      logger.debug(
+        `Sent unsubscribe request to remote ${remoteConn.remoteName} for session ${sessionId}`
+      );
+    } else {
+      logger.debug(`Cannot unsubscribe from remote ${remoteConn.remoteName} - WebSocket not open`);
     }
   }
 
@@ -282,6 +894,7 @@ export class BufferAggregator {
     try {
       // Convert HTTP URL to WebSocket URL and add /buffers path
       const wsUrl = `${remote.url.replace(/^http/, 'ws')}/buffers`;
+      await getFaultInjector()?.beforeConnect(wsUrl);
       const ws = new WebSocket(wsUrl, {
         headers: {
           Authorization: `Bearer ${remote.token}`,
@@ -290,15 +903,21 @@ export class BufferAggregator {
 
       logger.debug(`Attempting WebSocket connection to ${wsUrl}`);
 
-      await new Promise<void>((resolve, reject) => {
+      // Every connection is greeted with the remote's protocol version
+      const protocolVersion = await new Promise<string>((resolve, reject) => {
         const timeout = setTimeout(() => {
           logger.warn(`Connection to remote ${remote.name} timed out after 5s`);
           reject(new Error('Connection timeout'));
         }, 5000);
 
-        ws.on('open', () => {
+        ws.once('message', (data: Buffer) => {
           clearTimeout(timeout);
-          resolve();
+          try {
+            const message = JSON.parse(data.toString());
+            resolve(message.type === 'connected' ? String(message.version) : '1.0');
+          } catch {
+            resolve('1.0');
+          }
         });
 
         ws.on('error', (error) => {
@@ -307,15 +926,31 @@ export class BufferAggregator {
         });
       });
 
+      // Take over the mirrored sessions of a dropped connection
+      const pending = this.remoteReconnects.get(remoteId);
+      if (pending) {
+        clearTimeout(pending.timer);
+        this.remoteReconnects.delete(remoteId);
+      }
+
       const remoteConn: RemoteWebSocketConnection = {
         ws,
         remoteId: remote.id,
         remoteName: remote.name,
-        subscriptions: new Set(),
+        protocolVersion,
+        subscriptions: pending?.subscriptions ?? new Map(),
+        lastFrames: new Map(),
+        presence: new Map(),
       };
 
       this.remoteConnections.set(remoteId, remoteConn);
 
+      // Drop the connection if the remote stops responding; it is re-established on demand
+      startKeepalive(ws, this.config.keepalive, (reason) => {
+        this.staleConnections[reason]++;
+        logger.warn(`Remote ${remote.name} stopped responding (${reason}), closing connection`);
+      });
+
       // Handle messages from remote
       ws.on('message', (data: Buffer) => {
         this.handleRemoteMessage(remoteId, data);
@@ -324,11 +959,19 @@ export class BufferAggregator {
       logger.debug(
         `Remote ${remote.name} connection established with ${remoteConn.subscriptions.size} initial subscriptions`
       );
+      if (remoteConn.subscriptions.size > 0) {
+        this.syncRemote(remoteConn);
+      }
 
       // Handle disconnection
       ws.on('close', () => {
         logger.log(chalk.yellow(`Disconnected from remote ${remote.name}`));
-        this.remoteConnections.delete(remoteId);
+        if (this.remoteConnections.get(remoteId) === remoteConn) {
+          this.remoteConnections.delete(remoteId);
+          if (remoteConn.subscriptions.size > 0) {
+            this.scheduleRemoteReconnect(remoteId, remoteConn.subscriptions, 0);
+          }
+        }
       });
 
       ws.on('error', (error) => {
@@ -343,19 +986,71 @@ export class BufferAggregator {
     }
   }
 
+  /**
+   * Re-establish all mirrored sessions on a (re)connected remote. Remotes older
+   * than protocol 1.1 get one subscribe message per session instead of `sync`.
+   */
+  private syncRemote(remoteConn: RemoteWebSocketConnection): void {
+    const sessionIds = Array.from(remoteConn.subscriptions.keys());
+    if (remoteConn.protocolVersion === '1.0') {
+      for (const sessionId of sessionIds) {
+        remoteConn.ws.send(JSON.stringify({ type: 'subscribe', sessionId }));
+      }
+    } else {
+      remoteConn.ws.send(JSON.stringify({ type: 'sync', sessionIds }));
+    }
+    logger.debug(`Synced ${sessionIds.length} sessions with remote ${remoteConn.remoteName}`);
+  }
+
+  /**
+   * Reconnect to a remote that dropped while HQ clients were still mirroring
+   * its sessions, with exponential backoff
+   */
+  private scheduleRemoteReconnect(
+    remoteId: string,
+    subscriptions: Map<string, Set<WebSocket>>,
+    attempt: number
+  ): void {
+    const delay = Math.min(REMOTE_RECONNECT_BASE_MS * 2 ** attempt, REMOTE_RECONNECT_MAX_MS);
+    const timer = setTimeout(async () => {
+      if (subscriptions.size === 0 || !this.config.remoteRegistry?.getRemote(remoteId)) {
+        logger.debug(`Dropping reconnect to remote ${remoteId}: no longer needed`);
+        this.remoteReconnects.delete(remoteId);
+        return;
+      }
+      const connected = await this.connectToRemote(remoteId);
+      // Still pending unless the remote was unregistered or connected meanwhile
+      if (!connected && this.remoteReconnects.get(remoteId)?.timer === timer) {
+        this.scheduleRemoteReconnect(remoteId, subscriptions, attempt + 1);
+      }
+    }, delay);
+    timer.unref();
+
+    this.remoteReconnects.set(remoteId, { timer, subscriptions });
+    logger.debug(`Reconnecting to remote ${remoteId} in ${delay}ms (attempt ${attempt + 1})`);
+  }
+
   /**
    * Handle messages from a remote server
    */
   private handleRemoteMessage(remoteId: string, data: Buffer): void {
     // Check if this is a binary buffer update
     if (data.length > 0 && data[0] === 0xbf) {
-      // Forward to all clients subscribed to sessions from this remote
-      this.forwardBufferToClients(data);
+      // Forward to the HQ clients mirroring the session
+      this.forwardBufferToClients(remoteId, data);
     } else {
       // JSON message
       try {
         const message = JSON.parse(data.toString());
         logger.debug(`Remote ${remoteId} message:`, message.type);
+        if (message.type === 'presence' && typeof message.sessionId === 'string') {
+          this.handleRemotePresence(remoteId, message);
+        } else if (
+          ['collab', 'lock', 'image', 'trigger', 'crash'].includes(message.type) &&
+          typeof message.sessionId === 'string'
+        ) {
+          this.forwardSessionMessageToClients(remoteId, message);
+        }
       } catch (error) {
         logger.error(`Failed to parse remote message:`, error);
       }
@@ -363,22 +1058,91 @@ export class BufferAggregator {
   }
 
   /**
-   * Forward a buffer update to all subscribed clients
+   * Remember the presence a remote reported for a mirrored session and pass it
+   * on, merged with HQ's own viewers, to the clients mirroring the session
+   */
+  private handleRemotePresence(remoteId: string, message: Record<string, unknown>): void {
+    const remoteConn = this.remoteConnections.get(remoteId);
+    const remoteSessionId = message.sessionId as string;
+    if (!remoteConn?.subscriptions.has(remoteSessionId)) return;
+
+    remoteConn.presence.set(remoteSessionId, {
+      viewers: Array.isArray(message.viewers) ? message.viewers : [],
+      typing: Array.isArray(message.typing) ? message.typing : [],
+    });
+    this.broadcastPresence(namespaceSessionId(remoteConn.remoteName, remoteSessionId));
+  }
+
+  /**
+   * Pass a typing, selection, lock, image or trigger event from a remote on to the
+   * clients mirroring the session, under the namespaced session ID
    */
-  private forwardBufferToClients(buffer: Buffer): void {
+  private forwardSessionMessageToClients(
+    remoteId: string,
+    message: Record<string, unknown>
+  ): void {
+    const remoteConn = this.remoteConnections.get(remoteId);
+    const remoteSessionId = message.sessionId as string;
+    const subscribers = remoteConn?.subscriptions.get(remoteSessionId);
+    if (!remoteConn || !subscribers) return;
+
+    const sessionId = namespaceSessionId(remoteConn.remoteName, remoteSessionId);
+    const isImage = message.type === 'image';
+    const forwarded = JSON.stringify({
+      ...message,
+      sessionId,
+      ...this.forwardedImage(message, sessionId),
+    });
+    for (const clientWs of subscribers) {
+      if (isImage && !this.hasCapability(clientWs, 'images')) continue;
+      this.sendToClient(clientWs, forwarded);
+    }
+  }
+
+  /**
+   * Image of a forwarded image message, pointing at HQ's proxy for the image
+   */
+  private forwardedImage(
+    message: Record<string, unknown>,
+    sessionId: string
+  ): { image?: InlineImage & { url: string } } {
+    const image = message.image as InlineImage | undefined;
+    if (message.type !== 'image' || typeof image?.id !== 'string') return {};
+    return { image: { ...image, url: inlineImageUrl(sessionId, image.id) } };
+  }
+
+  /**
+   * Forward a buffer update from a remote to the clients mirroring the session
+   */
+  private forwardBufferToClients(remoteId: string, buffer: Buffer): void {
     // Extract session ID from buffer
     if (buffer.length < 5) return;
 
     const sessionIdLength = buffer.readUInt32LE(1);
     if (buffer.length < 5 + sessionIdLength) return;
 
-    const sessionId = buffer.subarray(5, 5 + sessionIdLength).toString('utf8');
+    const remoteSessionId = buffer.subarray(5, 5 + sessionIdLength).toString('utf8');
+
+    // Frames may still arrive for a session that was just unsubscribed
+    const remoteConn = this.remoteConnections.get(remoteId);
+    const subscribers = remoteConn?.subscriptions.get(remoteSessionId);
+    if (!remoteConn || !subscribers) return;
+
+    // Reframe with the namespaced ID HQ clients subscribed with
+    const sessionId = namespaceSessionId(remoteConn.remoteName, remoteSessionId);
+    const sessionIdBuffer = Buffer.from(sessionId, 'utf8');
+    const payload = buffer.subarray(5 + sessionIdLength);
+    const frame = Buffer.allocUnsafe(5 + sessionIdBuffer.length + payload.length);
+    frame.writeUInt8(0xbf, 0);
+    frame.writeUInt32LE(sessionIdBuffer.length, 1);
+    sessionIdBuffer.copy(frame, 5);
+    payload.copy(frame, 5 + sessionIdBuffer.length);
+    remoteConn.lastFrames.set(remoteSessionId, frame);
 
-    // Forward to all clients subscribed to this session
     let forwardedCount = 0;
-    for (const [clientWs, subscriptions] of this.clientSubscriptions) {
-      if (subscriptions.has(sessionId) && clientWs.readyState === WebSocket.OPEN) {
-        clientWs.send(buffer);
+    for (const clientWs of subscribers) {
+      if (clientWs.readyState === WebSocket.OPEN) {
+        this.clientWriters.get(clientWs)?.sendFrame(sessionId, frame);
         forwardedCount++;
       }
     }
@@ -404,9 +1168,36 @@ export class BufferAggregator {
       logger.debug(`Cleaned up ${subscriptionCount} subscriptions`);
     }
     this.clientSubscriptions.delete(ws);
+    this.clientWriters.get(ws)?.close();
+    this.clientWriters.delete(ws);
+    this.clientIdentities.delete(ws);
+    this.clientIds.delete(ws);
+    this.clientCapabilities.delete(ws);
+
+    // Keep the subscriptions for a while so the client can resume after a brief drop
+    const resume = this.clientResume.get(ws);
+    this.clientResume.delete(ws);
+    if (resume && resume.sessions.size > 0) {
+      const timer = setTimeout(() => {
+        this.detachedClients.delete(resume.token);
+      }, RESUME_WINDOW_MS);
+      this.detachedClients.set(resume.token, { sessions: resume.sessions, timer });
+    }
     logger.log(chalk.yellow('Client disconnected'));
   }
 
+  /**
+   * Queue a control message for a client
+   */
+  private sendToClient(clientWs: WebSocket, message: string): void {
+    const writer = this.clientWriters.get(clientWs);
+    if (writer) {
+      writer.send(message);
+    } else if (clientWs.readyState === WebSocket.OPEN) {
+      clientWs.send(message);
+    }
+  }
+
   /**
    * Register a new remote server (called when a remote registers with HQ)
    */
@@ -424,18 +1215,57 @@ export class BufferAggregator {
    */
   onRemoteUnregistered(remoteId: string): void {
     logger.log(`Remote ${remoteId} unregistered, closing connection`);
+    const pending = this.remoteReconnects.get(remoteId);
+    if (pending) {
+      clearTimeout(pending.timer);
+      this.remoteReconnects.delete(remoteId);
+    }
     const remoteConn = this.remoteConnections.get(remoteId);
     if (remoteConn) {
       logger.debug(
         `Closing connection to remote ${remoteConn.remoteName} with ${remoteConn.subscriptions.size} active subscriptions`
       );
-      remoteConn.ws.close();
+      closeWithTimeout(remoteConn.ws, 1000, 'Remote unregistered');
       this.remoteConnections.delete(remoteId);
     } else {
       logger.debug(`No active connection found for unregistered remote ${remoteId}`);
     }
   }
 
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let queued = 0;
+    let merged = 0;
+    for (const writer of this.clientWriters.values()) {
+      const stats = writer.getStats();
+      queued += stats.queued;
+      merged += stats.merged;
+    }
+    let subscriptions = 0;
+    for (const subscriptionMap of this.clientSubscriptions.values()) {
+      subscriptions += subscriptionMap.size;
+    }
+    let mirroredSessions = 0;
+    for (const remoteConn of this.remoteConnections.values()) {
+      mirroredSessions += remoteConn.subscriptions.size;
+    }
+    return {
+      clients: this.clientSubscriptions.size,
+      subscriptions,
+      remoteConnections: this.remoteConnections.size,
+      mirroredSessions,
+      pendingRemoteReconnects: this.remoteReconnects.size,
+      resumableClients: this.detachedClients.size,
+      resumedClients: this.resumeStats.resumed,
+      failedResumes: this.resumeStats.failed,
+      staleConnections: { ...this.staleConnections },
+      outboundQueued: queued,
+      outboundMerged: merged,
+    };
+  }
+
   /**
    * Clean up all connections
    */
@@ -445,17 +1275,32 @@ export class BufferAggregator {
     // Close all client connections
     const clientCount = this.clientSubscriptions.size;
     for (const [ws] of this.clientSubscriptions) {
-      ws.close();
+      closeWithTimeout(ws);
     }
     this.clientSubscriptions.clear();
+    for (const writer of this.clientWriters.values()) {
+      writer.close();
+    }
+    this.clientWriters.clear();
+    this.clientIdentities.clear();
+    this.clientIds.clear();
+    this.clientResume.clear();
+    for (const detached of this.detachedClients.values()) {
+      clearTimeout(detached.timer);
+    }
+    this.detachedClients.clear();
     logger.debug(`Closed ${clientCount} client connections`);
 
     // Close all remote connections
     const remoteCount = this.remoteConnections.size;
     for (const [_, remoteConn] of this.remoteConnections) {
-      remoteConn.ws.close();
+      closeWithTimeout(remoteConn.ws);
     }
     this.remoteConnections.clear();
+    for (const pending of this.remoteReconnects.values()) {
+      clearTimeout(pending.timer);
+    }
+    this.remoteReconnects.clear();
     logger.debug(`Closed ${remoteCount} remote connections`);
   }
 }
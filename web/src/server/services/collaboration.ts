@@ -0,0 +1,206 @@
+/**
+ * CollaborationService - Typing and selection indicators for shared sessions
+ *
+ * Once more than one authenticated user watches a session (as tracked by
+ * ViewerPresence), input and selection changes are turned into lightweight
+ * events that the buffer WebSocket relays to the other subscribers:
+ *
+ * - typing: a user sent input (at most one event per user per second)
+ * - selection: a participant selected a buffer range, or cleared it (null)
+ *
+ * Participants are WebSocket connections; their selections are dropped when
+ * they unsubscribe from the session.
+ */
+
+import { EventEmitter } from 'events';
+import { createLogger } from '../utils/logger.js';
+import type { ViewerPresence } from './viewer-presence.js';
+
+const logger = createLogger('collaboration');
+
+// Minimum time between typing events of the same user
+const TYPING_THROTTLE_MS = 1000;
+
+export interface SelectionRange {
+  startCol: number;
+  startRow: number;
+  endCol: number;
+  endRow: number;
+}
+
+export type CollaborationEvent =
+  | { event: 'typing'; userId: string }
+  | {
+      event: 'selection';
+      userId: string;
+      participantId: string;
+      selection: SelectionRange | null;
+    };
+
+export interface CollaborationMessage {
+  sessionId: string;
+  // Participant the event came from, which does not get it back
+  origin?: string;
+  payload: CollaborationEvent;
+}
+
+interface ParticipantSelection {
+  userId: string;
+  selection: SelectionRange;
+}
+
+interface SessionState {
+  selections: Map<string, ParticipantSelection>;
+  lastTyping: Map<string, number>;
+}
+
+/**
+ * Validate a selection sent by a client. Returns null to clear the selection
+ * and undefined if the value is not a selection.
+ */
+export function parseSelection(value: unknown): SelectionRange | null | undefined {
+  if (value === null) return null;
+  if (typeof value !== 'object') return undefined;
+  const range = value as Record<string, unknown>;
+  const fields = ['startCol', 'startRow', 'endCol', 'endRow'] as const;
+  for (const field of fields) {
+    const n = range[field];
+    if (typeof n !== 'number' || !Number.isInteger(n) || n < 0) return undefined;
+  }
+  return {
+    startCol: range.startCol as number,
+    startRow: range.startRow as number,
+    endCol: range.endCol as number,
+    endRow: range.endRow as number,
+  };
+}
+
+export class CollaborationService extends EventEmitter {
+  private sessions = new Map<string, SessionState>();
+  private stats = { typingEvents: 0, selectionEvents: 0 };
+
+  constructor(private viewerPresence: ViewerPresence) {
+    super();
+  }
+
+  /**
+   * Whether more than one authenticated user is watching the session
+   */
+  isShared(sessionId: string): boolean {
+    const users = new Set<string>();
+    for (const viewer of this.viewerPresence.getPresence(sessionId).viewers) {
+      if (viewer.userId) users.add(viewer.userId);
+    }
+    return users.size > 1;
+  }
+
+  /**
+   * Announce that a user sent input to a session
+   */
+  recordTyping(sessionId: string, userId?: string, origin?: string): void {
+    if (!userId || !this.isShared(sessionId)) return;
+
+    const state = this.getState(sessionId);
+    const now = Date.now();
+    const last = state.lastTyping.get(userId);
+    if (last !== undefined && now - last < TYPING_THROTTLE_MS) return;
+    state.lastTyping.set(userId, now);
+
+    this.stats.typingEvents++;
+    this.emitEvent({ sessionId, origin, payload: { event: 'typing', userId } });
+  }
+
+  /**
+   * Set or clear (null) the selection of a participant
+   */
+  setSelection(
+    sessionId: string,
+    participantId: string,
+    userId: string | undefined,
+    selection: SelectionRange | null
+  ): void {
+    if (!userId) return;
+
+    const state = this.getState(sessionId);
+    if (selection) {
+      state.selections.set(participantId, { userId, selection });
+    } else if (!state.selections.delete(participantId)) {
+      return;
+    }
+
+    if (!this.isShared(sessionId)) return;
+    this.stats.selectionEvents++;
+    this.emitEvent({
+      sessionId,
+      origin: participantId,
+      payload: { event: 'selection', userId, participantId, selection },
+    });
+  }
+
+  /**
+   * Current selections of a session, sent to participants joining it
+   */
+  getSelections(sessionId: string): CollaborationEvent[] {
+    const state = this.sessions.get(sessionId);
+    if (!state) return [];
+    return Array.from(state.selections, ([participantId, { userId, selection }]) => ({
+      event: 'selection' as const,
+      userId,
+      participantId,
+      selection,
+    }));
+  }
+
+  /**
+   * Drop a participant leaving a session, clearing its selection for the others
+   */
+  leave(sessionId: string, participantId: string): void {
+    const state = this.sessions.get(sessionId);
+    if (!state) return;
+
+    const selected = state.selections.get(participantId);
+    if (selected) {
+      this.setSelection(sessionId, participantId, selected.userId, null);
+    }
+    // Typing timestamps are only kept while someone watches
+    const watched = this.viewerPresence.getPresence(sessionId).viewers.length > 0;
+    if (state.selections.size === 0 && !watched) {
+      this.sessions.delete(sessionId);
+    }
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let selections = 0;
+    for (const state of this.sessions.values()) {
+      selections += state.selections.size;
+    }
+    return {
+      sessions: this.sessions.size,
+      selections,
+      typingEvents: this.stats.typingEvents,
+      selectionEvents: this.stats.selectionEvents,
+    };
+  }
+
+  destroy(): void {
+    this.sessions.clear();
+    this.removeAllListeners();
+  }
+
+  private getState(sessionId: string): SessionState {
+    let state = this.sessions.get(sessionId);
+    if (!state) {
+      state = { selections: new Map(), lastTyping: new Map() };
+      this.sessions.set(sessionId, state);
+    }
+    return state;
+  }
+
+  private emitEvent(message: CollaborationMessage): void {
+    logger.debug(`${message.payload.event} event for session ${message.sessionId}`);
+    this.emit('collaboration', message);
+  }
+}
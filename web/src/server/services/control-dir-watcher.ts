@@ -1,7 +1,8 @@
 import chalk from 'chalk';
 import * as fs from 'fs';
 import * as path from 'path';
-import type { PtyManager } from '../pty/index.js';
+import { parseSessionInfo } from '../../shared/session-schema.js';
+import type { ControlRoots, PtyManager } from '../pty/index.js';
 import { isShuttingDown } from '../server.js';
 import { createLogger } from '../utils/logger.js';
 import type { HQClient } from './hq-client.js';
@@ -10,7 +11,8 @@ import type { RemoteRegistry } from './remote-registry.js';
 const logger = createLogger('control-dir-watcher');
 
 interface ControlDirWatcherConfig {
-  controlDir: string;
+  // Every root is watched for sessions created by other processes (e.g. vt)
+  controlRoots: ControlRoots;
   remoteRegistry: RemoteRegistry | null;
   isHQMode: boolean;
   hqClient: HQClient | null;
@@ -18,38 +20,40 @@ interface ControlDirWatcherConfig {
 }
 
 export class ControlDirWatcher {
-  private watcher: fs.FSWatcher | null = null;
+  private watchers: fs.FSWatcher[] = [];
   private config: ControlDirWatcherConfig;
 
   constructor(config: ControlDirWatcherConfig) {
     this.config = config;
-    logger.debug(`Initialized with control dir: ${config.controlDir}, HQ mode: ${config.isHQMode}`);
+    const dirs = config.controlRoots.list().map((root) => root.path);
+    logger.debug(`Initialized with control dirs: ${dirs.join(', ')}, HQ mode: ${config.isHQMode}`);
   }
 
   start(): void {
+    for (const { path: controlDir } of this.config.controlRoots.list()) {
+      this.watchControlDir(controlDir);
+    }
+  }
+
+  private watchControlDir(controlDir: string): void {
     // Create control directory if it doesn't exist
-    if (!fs.existsSync(this.config.controlDir)) {
-      logger.log(
-        chalk.yellow(`Control directory ${this.config.controlDir} does not exist, creating it`)
-      );
-      fs.mkdirSync(this.config.controlDir, { recursive: true });
+    if (!fs.existsSync(controlDir)) {
+      logger.log(chalk.yellow(`Control directory ${controlDir} does not exist, creating it`));
+      fs.mkdirSync(controlDir, { recursive: true });
     }
 
-    this.watcher = fs.watch(
-      this.config.controlDir,
-      { persistent: true },
-      async (eventType, filename) => {
-        if (eventType === 'rename' && filename) {
-          await this.handleFileChange(filename);
-        }
+    const watcher = fs.watch(controlDir, { persistent: true }, async (eventType, filename) => {
+      if (eventType === 'rename' && filename) {
+        await this.handleFileChange(controlDir, filename);
       }
-    );
+    });
+    this.watchers.push(watcher);
 
-    logger.log(chalk.green(`Control directory watcher started for ${this.config.controlDir}`));
+    logger.log(chalk.green(`Control directory watcher started for ${controlDir}`));
   }
 
-  private async handleFileChange(filename: string): Promise<void> {
-    const sessionPath = path.join(this.config.controlDir, filename);
+  private async handleFileChange(controlDir: string, filename: string): Promise<void> {
+    const sessionPath = path.join(controlDir, filename);
     const sessionJsonPath = path.join(sessionPath, 'session.json');
 
     try {
@@ -59,8 +63,11 @@ export class ControlDirWatcher {
 
       if (fs.existsSync(sessionJsonPath)) {
         // Session was created
-        const sessionData = JSON.parse(fs.readFileSync(sessionJsonPath, 'utf8'));
-        const sessionId = sessionData.session_id || filename;
+        const sessionData = parseSessionInfo(
+          JSON.parse(fs.readFileSync(sessionJsonPath, 'utf8')),
+          filename
+        );
+        const sessionId = sessionData.id;
 
         logger.log(chalk.blue(`Detected new external session: ${sessionId}`));
 
@@ -125,47 +132,17 @@ export class ControlDirWatcher {
       return;
     }
 
-    const hqUrl = this.config.hqClient.getHQUrl();
-    const hqAuth = this.config.hqClient.getHQAuth();
-    const remoteName = this.config.hqClient.getName();
-
-    logger.debug(
-      `Notifying HQ at ${hqUrl} about ${action} session ${sessionId} from remote ${remoteName}`
-    );
-    const startTime = Date.now();
-
-    // Notify HQ about session change
-    // For now, we'll trigger a session list refresh by calling the HQ's session endpoint
-    // This will cause HQ to update its registry with the latest session information
-    const response = await fetch(`${hqUrl}/api/remotes/${remoteName}/refresh-sessions`, {
-      method: 'POST',
-      headers: {
-        'Content-Type': 'application/json',
-        Authorization: hqAuth,
-      },
-      body: JSON.stringify({
-        action,
-        sessionId,
-      }),
-    });
-
-    if (!response.ok) {
-      // If we get a 503 during shutdown, that's expected
-      if (response.status === 503 && isShuttingDown()) {
-        logger.debug(`Got expected 503 from HQ during shutdown`);
-        return;
-      }
-      throw new Error(`HQ responded with ${response.status}: ${await response.text()}`);
-    }
-
-    const duration = Date.now() - startTime;
-    logger.log(chalk.green(`Notified HQ about ${action} session ${sessionId} (${duration}ms)`));
+    logger.debug(`Notifying HQ about ${action} session ${sessionId}`);
+    // Queued by the HQ client and replayed once HQ is reachable again
+    await this.config.hqClient.notifySessionChange(action, sessionId);
   }
 
   stop(): void {
-    if (this.watcher) {
-      this.watcher.close();
-      this.watcher = null;
+    if (this.watchers.length > 0) {
+      for (const watcher of this.watchers) {
+        watcher.close();
+      }
+      this.watchers = [];
       logger.log(chalk.yellow('Control directory watcher stopped'));
     } else {
       logger.debug('Stop called but watcher was not running');
@@ -0,0 +1,198 @@
+/**
+ * Crash reporter - Isolates failures of per-session tasks
+ *
+ * Output handlers, stream watchers and the like run as callbacks of a single
+ * session. An exception thrown in one of them would otherwise escape into
+ * node-pty or the file watcher and take down the whole server, or end the
+ * stream it feeds without a trace. Wrapped with guardSessionTask(), the error
+ * is caught instead: the session is marked as crashed, subscribers get a
+ * `crash` event and the stack is written to `~/.vibetunnel/crashes/<id>.json`
+ * for GET /api/crashes.
+ *
+ * Only the first crash of a task per session is recorded; a handler that
+ * throws on every chunk of output would otherwise fill the directory.
+ */
+
+import { EventEmitter } from 'events';
+import * as fs from 'fs/promises';
+import * as os from 'os';
+import * as path from 'path';
+import type { SessionCrash } from '../../shared/types.js';
+import { createLogger } from '../utils/logger.js';
+import { VERSION } from '../version.js';
+
+const logger = createLogger('crash-reporter');
+
+// Oldest reports beyond this are removed
+export const DEFAULT_MAX_CRASH_REPORTS = 100;
+
+export interface CrashReport extends SessionCrash {
+  sessionId: string;
+  stack: string | null;
+  version: string;
+  pid: number;
+}
+
+// What the listing shows of a report
+export type CrashSummary = Omit<CrashReport, 'stack'>;
+
+export class CrashReporter extends EventEmitter {
+  // `${sessionId}:${task}` of recorded crashes
+  private recorded = new Set<string>();
+  private sequence = 0;
+  private writes: Promise<void> = Promise.resolve();
+
+  constructor(
+    private crashDir = path.join(os.homedir(), '.vibetunnel', 'crashes'),
+    private maxReports = DEFAULT_MAX_CRASH_REPORTS
+  ) {
+    super();
+  }
+
+  /**
+   * Record an error thrown by a task of a session. Returns the report, or null
+   * if the task already crashed in that session.
+   */
+  record(sessionId: string, task: string, error: unknown): CrashReport | null {
+    const key = `${sessionId}:${task}`;
+    if (this.recorded.has(key)) {
+      logger.debug(`${task} of session ${sessionId} failed again: ${errorMessage(error)}`);
+      return null;
+    }
+    this.recorded.add(key);
+
+    const crashedAt = new Date();
+    // Sorts by time, also within a millisecond
+    const sequence = String(++this.sequence).padStart(6, '0');
+    const report: CrashReport = {
+      id: `${crashedAt.toISOString().replace(/[-:.]/g, '')}-${sequence}`,
+      sessionId,
+      task,
+      message: errorMessage(error),
+      crashedAt: crashedAt.toISOString(),
+      stack: error instanceof Error ? (error.stack ?? null) : null,
+      version: VERSION,
+      pid: process.pid,
+    };
+    logger.error(`${task} of session ${sessionId} crashed (${report.id}):`, error);
+
+    // Writes are chained so pruning never races a write
+    this.writes = this.writes
+      .then(() => this.write(report))
+      .catch((writeError) => logger.warn(`failed to write crash report ${report.id}:`, writeError));
+    this.emit('crash', report);
+    return report;
+  }
+
+  /**
+   * Forget a session's crashes, so a session ID used again is reported again
+   */
+  forgetSession(sessionId: string): void {
+    for (const key of this.recorded) {
+      if (key.startsWith(`${sessionId}:`)) this.recorded.delete(key);
+    }
+  }
+
+  /**
+   * Recorded crashes, most recent first
+   */
+  async list(sessionId?: string): Promise<CrashSummary[]> {
+    await this.writes;
+    const summaries: CrashSummary[] = [];
+    for (const id of await this.reportIds()) {
+      const report = await this.get(id);
+      if (!report || (sessionId && report.sessionId !== sessionId)) continue;
+      const { stack: _stack, ...summary } = report;
+      summaries.push(summary);
+    }
+    return summaries;
+  }
+
+  /**
+   * A crash report with its stack, or null if there is none with that ID
+   */
+  async get(id: string): Promise<CrashReport | null> {
+    if (!/^[\w-]+$/.test(id)) return null;
+    await this.writes;
+    try {
+      return JSON.parse(await fs.readFile(path.join(this.crashDir, `${id}.json`), 'utf8'));
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code !== 'ENOENT') {
+        logger.warn(`failed to read crash report ${id}:`, error);
+      }
+      return null;
+    }
+  }
+
+  // Report IDs, most recent first
+  private async reportIds(): Promise<string[]> {
+    let files: string[];
+    try {
+      files = await fs.readdir(this.crashDir);
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') return [];
+      throw error;
+    }
+    return files
+      .filter((file) => file.endsWith('.json'))
+      .map((file) => file.slice(0, -'.json'.length))
+      .sort()
+      .reverse();
+  }
+
+  private async write(report: CrashReport): Promise<void> {
+    await fs.mkdir(this.crashDir, { recursive: true, mode: 0o700 });
+    await fs.writeFile(
+      path.join(this.crashDir, `${report.id}.json`),
+      JSON.stringify(report, null, 2),
+      { mode: 0o600 }
+    );
+    const excess = (await this.reportIds()).slice(this.maxReports);
+    for (const id of excess) {
+      await fs.rm(path.join(this.crashDir, `${id}.json`), { force: true });
+    }
+  }
+}
+
+function errorMessage(error: unknown): string {
+  return error instanceof Error ? error.message : String(error);
+}
+
+let activeReporter: CrashReporter | null = null;
+
+/**
+ * The reporter guarded tasks report to (null: errors are only logged)
+ */
+export function getCrashReporter(): CrashReporter | null {
+  return activeReporter;
+}
+
+export function setCrashReporter(reporter: CrashReporter | null): void {
+  activeReporter = reporter;
+}
+
+/**
+ * Wrap a callback of a session so that an error it throws, or a promise it
+ * returns rejecting, is reported instead of escaping to the caller
+ */
+export function guardSessionTask<A extends unknown[]>(
+  sessionId: string,
+  task: string,
+  fn: (...args: A) => void | Promise<void>
+): (...args: A) => void {
+  const report = (error: unknown) => {
+    if (activeReporter) {
+      activeReporter.record(sessionId, task, error);
+    } else {
+      logger.error(`${task} of session ${sessionId} failed:`, error);
+    }
+  };
+  return (...args: A) => {
+    try {
+      const result = fn(...args);
+      if (result instanceof Promise) result.catch(report);
+    } catch (error) {
+      report(error);
+    }
+  };
+}
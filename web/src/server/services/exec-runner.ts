@@ -0,0 +1,147 @@
+import { spawn } from 'child_process';
+import * as pty from 'node-pty';
+import { ProcessUtils } from '../pty/index.js';
+import { accountEnvironment, type LocalAccount } from '../utils/local-accounts.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('exec-runner');
+
+export const DEFAULT_EXEC_TIMEOUT_MS = 30 * 1000;
+export const MAX_EXEC_TIMEOUT_MS = 10 * 60 * 1000;
+// Output kept per stream; the rest is dropped and reported as truncated
+export const MAX_EXEC_OUTPUT_BYTES = 1024 * 1024;
+
+export interface ExecOptions {
+  command: string[];
+  workingDir: string;
+  timeoutMs?: number;
+  // Written to stdin, which is then closed (pipe mode only)
+  input?: string;
+  // Run in a pseudo terminal; stdout and stderr are then combined in stdout
+  tty?: boolean;
+  cols?: number;
+  rows?: number;
+  // Local account the command runs as (local user mode)
+  runAs?: LocalAccount;
+}
+
+export interface ExecResult {
+  exitCode: number | null;
+  signal: string | null;
+  stdout: string;
+  stderr: string;
+  truncated: boolean;
+  timedOut: boolean;
+  durationMs: number;
+}
+
+/**
+ * Collects process output up to a byte limit
+ */
+class OutputCollector {
+  private chunks: Buffer[] = [];
+  private size = 0;
+  truncated = false;
+
+  add(chunk: Buffer | string): void {
+    const buffer = typeof chunk === 'string' ? Buffer.from(chunk, 'utf8') : chunk;
+    const remaining = MAX_EXEC_OUTPUT_BYTES - this.size;
+    if (buffer.length > remaining) {
+      this.truncated = true;
+    }
+    if (remaining > 0) {
+      const kept = buffer.subarray(0, remaining);
+      this.chunks.push(kept);
+      this.size += kept.length;
+    }
+  }
+
+  toString(): string {
+    return Buffer.concat(this.chunks).toString('utf8');
+  }
+}
+
+/**
+ * Run a command to completion and capture its output, without creating a
+ * session. The process is killed (SIGTERM, then SIGKILL) when the timeout
+ * expires.
+ */
+export function execCommand(options: ExecOptions): Promise<ExecResult> {
+  const timeoutMs = Math.min(options.timeoutMs ?? DEFAULT_EXEC_TIMEOUT_MS, MAX_EXEC_TIMEOUT_MS);
+  const resolved = ProcessUtils.resolveCommand(options.command);
+  const startTime = Date.now();
+  const stdout = new OutputCollector();
+  const stderr = new OutputCollector();
+  const { runAs } = options;
+  const env = runAs ? accountEnvironment(runAs) : process.env;
+  const identity = runAs ? { uid: runAs.uid, gid: runAs.gid } : {};
+
+  logger.debug(`exec: ${[resolved.command, ...resolved.args].join(' ')} (${timeoutMs}ms timeout)`);
+
+  return new Promise((resolve, reject) => {
+    let timedOut = false;
+    let killTimer: NodeJS.Timeout | undefined;
+    let kill: (signal: NodeJS.Signals) => void;
+
+    const finish = (exitCode: number | null, signal: string | null) => {
+      clearTimeout(timeout);
+      clearTimeout(killTimer);
+      resolve({
+        exitCode,
+        signal,
+        stdout: stdout.toString(),
+        stderr: stderr.toString(),
+        truncated: stdout.truncated || stderr.truncated,
+        timedOut,
+        durationMs: Date.now() - startTime,
+      });
+    };
+
+    if (options.tty) {
+      let ptyProcess: pty.IPty;
+      try {
+        ptyProcess = pty.spawn(resolved.command, resolved.args, {
+          name: 'xterm-256color',
+          cols: options.cols ?? 80,
+          rows: options.rows ?? 24,
+          cwd: options.workingDir,
+          env: { ...env, TERM: 'xterm-256color' } as Record<string, string>,
+          ...identity,
+        });
+      } catch (error) {
+        reject(error);
+        return;
+      }
+      ptyProcess.onData((data) => stdout.add(data));
+      ptyProcess.onExit(({ exitCode, signal }) =>
+        finish(signal ? null : exitCode, signal ? `signal ${signal}` : null)
+      );
+      kill = (signal) => ptyProcess.kill(signal);
+    } else {
+      const child = spawn(resolved.command, resolved.args, {
+        cwd: options.workingDir,
+        env,
+        stdio: ['pipe', 'pipe', 'pipe'],
+        ...identity,
+      });
+      child.stdout.on('data', (chunk: Buffer) => stdout.add(chunk));
+      child.stderr.on('data', (chunk: Buffer) => stderr.add(chunk));
+      child.on('error', (error) => {
+        clearTimeout(timeout);
+        reject(error);
+      });
+      child.on('close', (exitCode, signal) => finish(exitCode, signal));
+      // The command may exit without reading its input
+      child.stdin.on('error', () => {});
+      child.stdin.end(options.input ?? '');
+      kill = (signal) => child.kill(signal);
+    }
+
+    const timeout = setTimeout(() => {
+      timedOut = true;
+      logger.debug(`exec timed out after ${timeoutMs}ms: ${options.command.join(' ')}`);
+      kill('SIGTERM');
+      killTimer = setTimeout(() => kill('SIGKILL'), 2000);
+    }, timeoutMs);
+  });
+}
@@ -0,0 +1,210 @@
+/**
+ * File tail - The last lines of a file, and the lines appended to it
+ *
+ * Log files can be gigabytes, so the file is never read as a whole: the last
+ * lines are found by reading chunks backwards from the end, and following
+ * reads only what was appended since. Changes come from a pooled file watcher
+ * (inotify on Linux); a periodic stat catches what the watcher misses, namely
+ * truncation and rotation (the path now names a different file).
+ */
+
+import { EventEmitter } from 'events';
+import * as fs from 'fs';
+import { StringDecoder } from 'string_decoder';
+import { createLogger } from '../utils/logger.js';
+import {
+  type FileWatcherPool,
+  type FileWatchHandle,
+  fileWatcherPool,
+} from './file-watcher-pool.js';
+
+const logger = createLogger('file-tail');
+
+const CHUNK_SIZE = 64 * 1024;
+// Bytes read backwards at most for the initial lines, so one huge line
+// cannot make us read the whole file
+export const MAX_TAIL_BYTES = 8 * 1024 * 1024;
+// A partial line longer than this is emitted without waiting for its newline
+const MAX_PENDING_BYTES = 1024 * 1024;
+const STAT_INTERVAL_MS = 2000;
+
+export interface TailResult {
+  lines: string[];
+  // Byte offset just past the returned lines
+  offset: number;
+  // Whether the lines start at the beginning of the file
+  complete: boolean;
+}
+
+/**
+ * Read the last `count` lines of a file. A trailing newline ends the last
+ * line rather than starting an empty one.
+ */
+export async function readLastLines(
+  filePath: string,
+  count: number,
+  maxBytes = MAX_TAIL_BYTES
+): Promise<TailResult> {
+  const handle = await fs.promises.open(filePath, 'r');
+  try {
+    const { size } = await handle.stat();
+    const chunks: Buffer[] = [];
+    let start = size;
+    let newlines = 0;
+    // One more newline than lines wanted: the one ending the line before them
+    while (start > 0 && newlines <= count && size - start < maxBytes) {
+      const length = Math.min(CHUNK_SIZE, start, maxBytes - (size - start));
+      start -= length;
+      const chunk = Buffer.alloc(length);
+      const { bytesRead } = await handle.read(chunk, 0, length, start);
+      chunks.unshift(chunk.subarray(0, bytesRead));
+      for (let i = 0; i < bytesRead; i++) {
+        if (chunk[i] === 0x0a) newlines++;
+      }
+    }
+
+    let text = Buffer.concat(chunks).toString('utf-8');
+    if (text.endsWith('\n')) text = text.slice(0, -1);
+    const lines = text === '' ? [] : text.split('\n');
+    const complete = start === 0 && lines.length <= count;
+    // Without the start of the file, the first line read is only part of one
+    if (start > 0 && lines.length > 0 && lines.length <= count) lines.shift();
+    return { lines: lines.slice(-count), offset: size, complete };
+  } finally {
+    await handle.close();
+  }
+}
+
+/**
+ * Follows a file from an offset. Emits `lines` (string[]) for complete lines
+ * appended, `reset` when the file was truncated or replaced (reading starts
+ * over at its beginning) and `error` when the file can no longer be read.
+ */
+export class FileTail extends EventEmitter {
+  private watch: FileWatchHandle | null = null;
+  private statTimer: NodeJS.Timeout | null = null;
+  private inode: number | null = null;
+  private pending = '';
+  // Keeps characters split between two reads together
+  private decoder = new StringDecoder('utf8');
+  private reading = false;
+  private readAgain = false;
+  private closed = false;
+
+  constructor(
+    private filePath: string,
+    private offset: number,
+    private pool: FileWatcherPool = fileWatcherPool
+  ) {
+    super();
+  }
+
+  start(): void {
+    try {
+      this.inode = fs.statSync(this.filePath).ino;
+    } catch (error) {
+      this.fail(error);
+      return;
+    }
+    this.watchFile();
+    this.statTimer = setInterval(() => this.read(), STAT_INTERVAL_MS);
+    this.statTimer.unref?.();
+    this.read();
+  }
+
+  close(): void {
+    this.closed = true;
+    this.watch?.close();
+    this.watch = null;
+    if (this.statTimer) clearInterval(this.statTimer);
+    this.statTimer = null;
+    this.removeAllListeners();
+  }
+
+  private watchFile(): void {
+    this.watch?.close();
+    try {
+      this.watch = this.pool.watch(this.filePath, () => this.read());
+    } catch (error) {
+      // The stat timer keeps following the file, only less promptly
+      logger.debug(`cannot watch ${this.filePath}:`, error);
+      this.watch = null;
+    }
+  }
+
+  private read(): void {
+    if (this.closed) return;
+    if (this.reading) {
+      this.readAgain = true;
+      return;
+    }
+    this.reading = true;
+    this.readAppended()
+      .catch((error) => this.fail(error))
+      .finally(() => {
+        this.reading = false;
+        if (this.readAgain && !this.closed) {
+          this.readAgain = false;
+          this.read();
+        }
+      });
+  }
+
+  private async readAppended(): Promise<void> {
+    let stats: fs.Stats;
+    try {
+      stats = await fs.promises.stat(this.filePath);
+    } catch (error) {
+      // Between rotating out the old file and creating the new one
+      if ((error as NodeJS.ErrnoException).code === 'ENOENT') return;
+      throw error;
+    }
+    if (this.closed) return;
+
+    if (stats.ino !== this.inode || stats.size < this.offset) {
+      logger.debug(`${this.filePath} was ${stats.ino !== this.inode ? 'replaced' : 'truncated'}`);
+      if (stats.ino !== this.inode) {
+        this.inode = stats.ino;
+        this.watchFile();
+      }
+      this.offset = 0;
+      this.pending = '';
+      this.decoder = new StringDecoder('utf8');
+      this.emit('reset');
+    }
+    if (stats.size === this.offset) return;
+
+    const handle = await fs.promises.open(this.filePath, 'r');
+    try {
+      const buffer = Buffer.alloc(CHUNK_SIZE);
+      while (!this.closed && this.offset < stats.size) {
+        const length = Math.min(CHUNK_SIZE, stats.size - this.offset);
+        const { bytesRead } = await handle.read(buffer, 0, length, this.offset);
+        if (bytesRead === 0) break;
+        this.offset += bytesRead;
+        this.append(this.decoder.write(buffer.subarray(0, bytesRead)));
+      }
+    } finally {
+      await handle.close();
+    }
+  }
+
+  private append(text: string): void {
+    const parts = (this.pending + text).split('\n');
+    this.pending = parts.pop() ?? '';
+    if (this.pending.length > MAX_PENDING_BYTES) {
+      parts.push(this.pending);
+      this.pending = '';
+    }
+    if (parts.length > 0) {
+      this.emit('lines', parts);
+    }
+  }
+
+  private fail(error: unknown): void {
+    if (this.closed) return;
+    logger.warn(`failed to follow ${this.filePath}:`, error);
+    this.emit('error', error);
+    this.close();
+  }
+}
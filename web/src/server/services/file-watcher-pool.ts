@@ -0,0 +1,135 @@
+import * as fs from 'fs';
+import * as path from 'path';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('file-watcher-pool');
+
+export type FileChangeListener = (eventType: string) => void;
+export type FileWatchErrorListener = (error: Error) => void;
+
+/**
+ * Handle returned by FileWatcherPool.watch. Closing it only removes this
+ * listener; the underlying watcher is closed with its last listener.
+ */
+export interface FileWatchHandle {
+  close(): void;
+}
+
+interface WatchSubscription {
+  onChange: FileChangeListener;
+  onError?: FileWatchErrorListener;
+}
+
+interface PooledWatcher {
+  watcher: fs.FSWatcher;
+  listeners: Set<WatchSubscription>;
+}
+
+/**
+ * Shares file watchers between services.
+ *
+ * TerminalManager, StreamWatcher and ActivityMonitor all watch the same
+ * per-session stdout files. Without sharing, every session costs one inotify
+ * watch per service, which exhausts inotify limits with many sessions. The
+ * pool keeps a single watcher per path and dispatches change events to all
+ * interested listeners.
+ */
+export class FileWatcherPool {
+  private watchers: Map<string, PooledWatcher> = new Map();
+
+  /**
+   * Watch a file. Throws if the file cannot be watched (e.g. does not exist).
+   * When the underlying watcher fails, it is closed and onError is called for
+   * every handle, which then no longer receives changes.
+   */
+  watch(
+    filePath: string,
+    listener: FileChangeListener,
+    onError?: FileWatchErrorListener
+  ): FileWatchHandle {
+    const key = path.resolve(filePath);
+    let entry = this.watchers.get(key);
+
+    if (!entry) {
+      const listeners = new Set<WatchSubscription>();
+      const watcher = fs.watch(key, { persistent: true }, (eventType) => {
+        // Copy so listeners can unsubscribe while being notified
+        for (const subscription of Array.from(listeners)) {
+          try {
+            subscription.onChange(eventType);
+          } catch (error) {
+            logger.error(`listener for ${key} threw:`, error);
+          }
+        }
+      });
+
+      watcher.on('error', (error) => {
+        logger.error(`file watcher error for ${key}:`, error);
+        const failed = Array.from(listeners);
+        this.closeWatcher(key);
+        for (const subscription of failed) {
+          try {
+            subscription.onError?.(error);
+          } catch (listenerError) {
+            logger.error(`error listener for ${key} threw:`, listenerError);
+          }
+        }
+      });
+
+      entry = { watcher, listeners };
+      this.watchers.set(key, entry);
+      logger.debug(`created watcher for ${key}`);
+    }
+
+    const pooled = entry;
+    const subscription: WatchSubscription = { onChange: listener, onError };
+    pooled.listeners.add(subscription);
+
+    let closed = false;
+    return {
+      close: () => {
+        if (closed) return;
+        closed = true;
+        pooled.listeners.delete(subscription);
+        if (pooled.listeners.size === 0 && this.watchers.get(key) === pooled) {
+          this.closeWatcher(key);
+        }
+      },
+    };
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let listeners = 0;
+    for (const entry of this.watchers.values()) {
+      listeners += entry.listeners.size;
+    }
+    return {
+      watchedPaths: this.watchers.size,
+      listeners,
+    };
+  }
+
+  /**
+   * Close all watchers
+   */
+  closeAll(): void {
+    for (const key of Array.from(this.watchers.keys())) {
+      this.closeWatcher(key);
+    }
+  }
+
+  private closeWatcher(key: string): void {
+    const entry = this.watchers.get(key);
+    if (!entry) return;
+    entry.watcher.close();
+    entry.listeners.clear();
+    this.watchers.delete(key);
+    logger.debug(`closed watcher for ${key}`);
+  }
+}
+
+// Process-wide pool shared by all services
+export const fileWatcherPool = new FileWatcherPool();
@@ -0,0 +1,256 @@
+/**
+ * Filesystem trash - Undo for deletes and overwrites through the file API
+ *
+ * Deleting a file or directory moves it into the requesting user's trash
+ * (`~/.vibetunnel/trash/<user>/<id>/`, the item next to a `meta.json` with
+ * where it came from), so it can be restored. Entries older than the
+ * retention are purged hourly. Items on another filesystem than the trash are
+ * copied and then removed, since they cannot be renamed across devices.
+ */
+
+import * as fs from 'fs/promises';
+import * as os from 'os';
+import * as path from 'path';
+import { v4 as uuidv4 } from 'uuid';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('fs-trash');
+
+const META_FILE = 'meta.json';
+const ITEM_DIR = 'item';
+const PURGE_INTERVAL_MS = 60 * 60 * 1000;
+export const DEFAULT_TRASH_RETENTION_DAYS = 7;
+
+export interface TrashEntry {
+  id: string;
+  // Absolute path the item was deleted from
+  originalPath: string;
+  name: string;
+  type: 'file' | 'directory' | 'symlink';
+  // Bytes, for files
+  size: number | null;
+  deletedAt: string;
+  // When the purge removes it (null if kept until emptied)
+  expiresAt: string | null;
+}
+
+export class TrashError extends Error {
+  constructor(
+    message: string,
+    public readonly code: 'NOT_FOUND' | 'CONFLICT'
+  ) {
+    super(message);
+    this.name = 'TrashError';
+  }
+}
+
+/**
+ * Rename, or copy and remove when source and destination are on different filesystems
+ */
+export async function moveAcrossDevices(from: string, to: string): Promise<void> {
+  try {
+    await fs.rename(from, to);
+  } catch (error) {
+    if ((error as NodeJS.ErrnoException).code !== 'EXDEV') throw error;
+    await fs.cp(from, to, { recursive: true, preserveTimestamps: true, verbatimSymlinks: true });
+    await fs.rm(from, { recursive: true, force: true });
+  }
+}
+
+export class FsTrash {
+  private purgeTimer: NodeJS.Timeout | null = null;
+
+  constructor(
+    private trashDir = path.join(os.homedir(), '.vibetunnel', 'trash'),
+    // 0 keeps entries until they are deleted or the trash is emptied
+    private retentionDays = DEFAULT_TRASH_RETENTION_DAYS
+  ) {}
+
+  start(): void {
+    if (this.retentionDays <= 0 || this.purgeTimer) return;
+    const purge = () => {
+      this.purge().catch((error) => logger.warn('failed to purge the trash:', error));
+    };
+    purge();
+    this.purgeTimer = setInterval(purge, PURGE_INTERVAL_MS);
+    this.purgeTimer.unref?.();
+  }
+
+  stop(): void {
+    if (this.purgeTimer) clearInterval(this.purgeTimer);
+    this.purgeTimer = null;
+  }
+
+  /**
+   * Move a file or directory into the owner's trash
+   */
+  async trash(owner: string, target: string): Promise<TrashEntry> {
+    const originalPath = path.resolve(target);
+    const stats = await fs.lstat(originalPath);
+    const id = uuidv4();
+    const entryDir = path.join(this.ownerDir(owner), id);
+    await fs.mkdir(entryDir, { recursive: true, mode: 0o700 });
+
+    const deletedAt = new Date();
+    const entry: TrashEntry = {
+      id,
+      originalPath,
+      name: path.basename(originalPath),
+      type: stats.isSymbolicLink() ? 'symlink' : stats.isDirectory() ? 'directory' : 'file',
+      size: stats.isFile() ? stats.size : null,
+      deletedAt: deletedAt.toISOString(),
+      expiresAt:
+        this.retentionDays > 0
+          ? new Date(deletedAt.getTime() + this.retentionDays * 86400000).toISOString()
+          : null,
+    };
+    try {
+      await moveAcrossDevices(originalPath, path.join(entryDir, ITEM_DIR));
+    } catch (error) {
+      await fs.rm(entryDir, { recursive: true, force: true });
+      throw error;
+    }
+    await fs.writeFile(path.join(entryDir, META_FILE), JSON.stringify(entry, null, 2));
+    logger.log(`moved ${originalPath} to the trash of ${owner} (${id})`);
+    return entry;
+  }
+
+  /**
+   * The owner's trash, most recently deleted first
+   */
+  async list(owner: string): Promise<TrashEntry[]> {
+    let ids: string[];
+    try {
+      ids = await fs.readdir(this.ownerDir(owner));
+    } catch {
+      return [];
+    }
+    const entries: TrashEntry[] = [];
+    for (const id of ids) {
+      const entry = await this.readEntry(owner, id);
+      if (entry) entries.push(entry);
+    }
+    return entries.sort((a, b) => b.deletedAt.localeCompare(a.deletedAt));
+  }
+
+  async get(owner: string, id: string): Promise<TrashEntry> {
+    const entry = await this.readEntry(owner, id);
+    if (!entry) {
+      throw new TrashError(`No such trash entry: ${id}`, 'NOT_FOUND');
+    }
+    return entry;
+  }
+
+  /**
+   * Move an entry back to where it was deleted from. Throws a CONFLICT
+   * TrashError if something exists there now, unless `overwrite` is set; what
+   * is overwritten goes to the trash in turn.
+   */
+  async restore(owner: string, id: string, overwrite = false): Promise<TrashEntry> {
+    const entry = await this.get(owner, id);
+    const exists = await fs.lstat(entry.originalPath).then(
+      () => true,
+      () => false
+    );
+    if (exists) {
+      if (!overwrite) {
+        throw new TrashError(`${entry.originalPath} already exists`, 'CONFLICT');
+      }
+      await this.trash(owner, entry.originalPath);
+    }
+
+    await fs.mkdir(path.dirname(entry.originalPath), { recursive: true });
+    const entryDir = path.join(this.ownerDir(owner), id);
+    await moveAcrossDevices(path.join(entryDir, ITEM_DIR), entry.originalPath);
+    await fs.rm(entryDir, { recursive: true, force: true });
+    logger.log(`restored ${entry.originalPath} from the trash of ${owner}`);
+    return entry;
+  }
+
+  /**
+   * Delete an entry for good
+   */
+  async remove(owner: string, id: string): Promise<void> {
+    await this.get(owner, id);
+    await fs.rm(path.join(this.ownerDir(owner), id), { recursive: true, force: true });
+  }
+
+  /**
+   * Delete all of the owner's entries for good; returns how many there were
+   */
+  async empty(owner: string): Promise<number> {
+    const entries = await this.list(owner);
+    for (const entry of entries) {
+      await fs.rm(path.join(this.ownerDir(owner), entry.id), { recursive: true, force: true });
+    }
+    return entries.length;
+  }
+
+  /**
+   * Delete the entries of every owner that are past the retention
+   */
+  async purge(now = Date.now()): Promise<number> {
+    if (this.retentionDays <= 0) return 0;
+    let owners: string[];
+    try {
+      owners = await fs.readdir(this.trashDir);
+    } catch {
+      return 0;
+    }
+
+    let purged = 0;
+    for (const ownerKey of owners) {
+      const ownerDir = path.join(this.trashDir, ownerKey);
+      let ids: string[];
+      try {
+        ids = await fs.readdir(ownerDir);
+      } catch {
+        continue;
+      }
+      for (const id of ids) {
+        const entryDir = path.join(ownerDir, id);
+        const entry = await this.readEntryAt(entryDir);
+        const expired = entry
+          ? entry.expiresAt !== null && Date.parse(entry.expiresAt) <= now
+          : await this.isLeftover(entryDir, now);
+        if (expired) {
+          await fs.rm(entryDir, { recursive: true, force: true });
+          purged++;
+        }
+      }
+    }
+    if (purged > 0) {
+      logger.log(`purged ${purged} trash entries`);
+    }
+    return purged;
+  }
+
+  // Owners are user names; hex keeps any name a single, distinct path component
+  private ownerDir(owner: string): string {
+    return path.join(this.trashDir, Buffer.from(owner).toString('hex') || '_');
+  }
+
+  private async readEntry(owner: string, id: string): Promise<TrashEntry | null> {
+    // IDs are UUIDs; anything else cannot name an entry
+    if (!/^[0-9a-f-]{36}$/.test(id)) return null;
+    return this.readEntryAt(path.join(this.ownerDir(owner), id));
+  }
+
+  // An entry without metadata an hour after it was created is what an
+  // interrupted move left behind (a younger one may still be being written)
+  private async isLeftover(entryDir: string, now: number): Promise<boolean> {
+    try {
+      return now - (await fs.stat(entryDir)).mtimeMs > PURGE_INTERVAL_MS;
+    } catch {
+      return false;
+    }
+  }
+
+  private async readEntryAt(entryDir: string): Promise<TrashEntry | null> {
+    try {
+      return JSON.parse(await fs.readFile(path.join(entryDir, META_FILE), 'utf-8')) as TrashEntry;
+    } catch {
+      return null;
+    }
+  }
+}
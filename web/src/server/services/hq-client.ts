@@ -1,17 +1,45 @@
 import chalk from 'chalk';
 import { v4 as uuidv4 } from 'uuid';
 import { createLogger } from '../utils/logger.js';
+import { type RemoteTokenStore, registrationPayload, signatureHeaders } from './remote-tokens.js';
 
 const logger = createLogger('hq-client');
 
+// Session events kept while HQ is unreachable; the oldest are dropped beyond this
+const MAX_QUEUED_EVENTS = 500;
+const RETRY_BASE_MS = 1000;
+const RETRY_MAX_MS = 30000;
+
+interface SessionChangeEvent {
+  action: string;
+  sessionId?: string;
+  queuedAt: number;
+}
+
+/**
+ * HQ could not be reached (network error, 5xx); the event stays queued
+ */
+class HQUnreachableError extends Error {}
+
 export class HQClient {
   private readonly hqUrl: string;
   private readonly remoteId: string;
   private readonly remoteName: string;
-  private readonly token: string;
+  private readonly tokens: RemoteTokenStore;
+  private readonly hqSecret: string | null;
   private readonly hqUsername: string;
   private readonly hqPassword: string;
   private readonly remoteUrl: string;
+  // Capabilities workspaces can pin sessions to (--remote-tag)
+  private readonly tags: string[];
+  private eventQueue: SessionChangeEvent[] = [];
+  private flushing = false;
+  private offline = false;
+  private retryTimer: NodeJS.Timeout | null = null;
+  private retryAttempt = 0;
+  private sentEvents = 0;
+  private replayedEvents = 0;
+  private droppedEvents = 0;
 
   constructor(
     hqUrl: string,
@@ -19,21 +47,26 @@ export class HQClient {
     hqPassword: string,
     remoteName: string,
     remoteUrl: string,
-    bearerToken: string
+    tokens: RemoteTokenStore,
+    hqSecret: string | null = null,
+    tags: string[] = []
   ) {
     this.hqUrl = hqUrl;
     this.remoteId = uuidv4();
     this.remoteName = remoteName;
-    this.token = bearerToken;
+    this.tokens = tokens;
+    this.hqSecret = hqSecret;
     this.hqUsername = hqUsername;
     this.hqPassword = hqPassword;
     this.remoteUrl = remoteUrl;
+    this.tags = tags;
 
     logger.debug('hq client initialized', {
       hqUrl,
       remoteName,
       remoteId: this.remoteId,
       remoteUrl,
+      tags,
     });
   }
 
@@ -46,12 +79,19 @@ export class HQClient {
         headers: {
           'Content-Type': 'application/json',
           Authorization: `Basic ${Buffer.from(`${this.hqUsername}:${this.hqPassword}`).toString('base64')}`,
+          // Shared-secret handshake proving this remote knows the HQ secret
+          ...(this.hqSecret
+            ? signatureHeaders(
+                this.hqSecret,
+                registrationPayload(this.remoteId, this.remoteName, this.remoteUrl, this.tags)
+              )
+            : {}),
         },
         body: JSON.stringify({
           id: this.remoteId,
           name: this.remoteName,
           url: this.remoteUrl,
-          token: this.token, // Token for HQ to authenticate with this remote
+          ...(this.tags.length > 0 ? { tags: this.tags } : {}),
         }),
       });
 
@@ -68,12 +108,18 @@ export class HQClient {
             id: this.remoteId,
             name: this.remoteName,
             url: this.remoteUrl,
-            token: `${this.token.substring(0, 8)}...`,
           },
         });
         throw new Error(`Registration failed (${response.status}): ${errorText}`);
       }
 
+      // HQ issues the token it will authenticate with (and rotates it later)
+      const { token } = (await response.json()) as { token?: string };
+      if (!token) {
+        throw new Error('Registration response did not include a token');
+      }
+      this.tokens.rotate(token, { revokePrevious: true });
+
       logger.log(
         chalk.green(`successfully registered with hq: ${this.remoteName} (${this.remoteId})`) +
           chalk.gray(` at ${this.hqUrl}`)
@@ -81,17 +127,146 @@ export class HQClient {
       logger.debug('registration details', {
         remoteId: this.remoteId,
         remoteName: this.remoteName,
-        token: `${this.token.substring(0, 8)}...`,
+        token: `${token.substring(0, 8)}...`,
       });
+
+      // Reconnected: replay what happened while HQ was unreachable
+      if (this.eventQueue.length > 0 && this.retryTimer) {
+        clearTimeout(this.retryTimer);
+        this.retryTimer = null;
+        this.flushEvents();
+      }
     } catch (error) {
       logger.error('failed to register with hq:', error);
       throw error; // Let the caller handle retries if needed
     }
   }
 
+  /**
+   * Tell HQ that a session was created, exited or removed (or, for federated HQs,
+   * that the sessions of a remote changed) so it refreshes this server's sessions.
+   * While HQ is unreachable events are queued and replayed in order on reconnection.
+   */
+  async notifySessionChange(action: string, sessionId?: string): Promise<void> {
+    this.eventQueue.push({ action, sessionId, queuedAt: Date.now() });
+    if (this.eventQueue.length > MAX_QUEUED_EVENTS) {
+      const dropped = this.eventQueue.shift();
+      this.droppedEvents++;
+      logger.warn(`event queue full, dropped ${dropped?.action} event for ${dropped?.sessionId}`);
+    }
+    await this.flushEvents();
+  }
+
+  private async flushEvents(): Promise<void> {
+    // Offline: the retry timer flushes
+    if (this.flushing || this.retryTimer) return;
+    this.flushing = true;
+    let replayed = 0;
+
+    try {
+      while (this.eventQueue.length > 0) {
+        const event = this.eventQueue[0];
+        try {
+          await this.sendSessionChange(event);
+        } catch (error) {
+          if (error instanceof HQUnreachableError) {
+            this.scheduleRetry(error);
+            return;
+          }
+          // HQ answered but rejected the event; retrying will not help
+          logger.error(`hq rejected ${event.action} event for ${event.sessionId}:`, error);
+          this.eventQueue.shift();
+          continue;
+        }
+
+        this.eventQueue.shift();
+        this.sentEvents++;
+        if (this.offline) {
+          this.replayedEvents++;
+          replayed++;
+        }
+      }
+
+      if (this.offline) {
+        this.offline = false;
+        logger.log(chalk.green(`hq reachable again, replayed ${replayed} queued events`));
+      }
+      this.retryAttempt = 0;
+    } finally {
+      this.flushing = false;
+    }
+  }
+
+  private async sendSessionChange(event: SessionChangeEvent, reregister = true): Promise<void> {
+    let response: Response;
+    try {
+      response = await fetch(`${this.hqUrl}/api/remotes/${this.remoteName}/refresh-sessions`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: this.getHQAuth(),
+        },
+        body: JSON.stringify({ action: event.action, sessionId: event.sessionId }),
+        signal: AbortSignal.timeout(5000),
+      });
+    } catch (error) {
+      throw new HQUnreachableError(error instanceof Error ? error.message : String(error));
+    }
+
+    // HQ forgot this server (restart, failed health checks): register again first
+    if (response.status === 404 && reregister) {
+      logger.log('hq does not know this server, registering again');
+      try {
+        await this.register();
+      } catch (error) {
+        throw new HQUnreachableError(error instanceof Error ? error.message : String(error));
+      }
+      return this.sendSessionChange(event, false);
+    }
+    if (response.status === 404 || response.status >= 500) {
+      throw new HQUnreachableError(`HQ responded with ${response.status}`);
+    }
+    if (!response.ok) {
+      throw new Error(`HQ responded with ${response.status}: ${await response.text()}`);
+    }
+  }
+
+  private scheduleRetry(error: Error): void {
+    if (!this.offline) {
+      this.offline = true;
+      logger.warn(`hq unreachable, queueing session events: ${error.message}`);
+    }
+    const delay = Math.min(RETRY_BASE_MS * 2 ** this.retryAttempt, RETRY_MAX_MS);
+    this.retryAttempt++;
+    logger.debug(`retrying ${this.eventQueue.length} queued events in ${delay}ms`);
+    this.retryTimer = setTimeout(() => {
+      this.retryTimer = null;
+      this.flushEvents();
+    }, delay);
+  }
+
+  getStats() {
+    return {
+      offline: this.offline,
+      queuedEvents: this.eventQueue.length,
+      sentEvents: this.sentEvents,
+      replayedEvents: this.replayedEvents,
+      droppedEvents: this.droppedEvents,
+      retryAttempt: this.retryAttempt,
+    };
+  }
+
   async destroy(): Promise<void> {
     logger.log(chalk.yellow(`unregistering from hq: ${this.remoteName} (${this.remoteId})`));
 
+    if (this.retryTimer) {
+      clearTimeout(this.retryTimer);
+      this.retryTimer = null;
+    }
+    if (this.eventQueue.length > 0) {
+      logger.debug(`discarding ${this.eventQueue.length} queued events`);
+    }
+
     try {
       // Try to unregister
       const response = await fetch(`${this.hqUrl}/api/remotes/${this.remoteId}`, {
@@ -116,8 +291,8 @@ export class HQClient {
     return this.remoteId;
   }
 
-  getToken(): string {
-    return this.token;
+  getToken(): string | null {
+    return this.tokens.getCurrent();
   }
 
   getHQUrl(): string {
@@ -0,0 +1,154 @@
+/**
+ * HyperlinkTracker - Hyperlinks (OSC 8) printed to a terminal, for snapshots
+ *
+ * Programs like `ls --hyperlink` wrap text in OSC 8 sequences
+ * (ESC ] 8 ; params ; URI ST ... ESC ] 8 ; ; ST). The tracker records where each
+ * link started and ended while the output is parsed. Positions in the normal
+ * buffer are kept with markers so they follow the text as it scrolls; links in
+ * the alternate screen are dropped when the program leaves it.
+ */
+
+import type { IMarker, Terminal as XtermTerminal } from '@xterm/headless';
+
+// Links in a snapshot, positioned in snapshot rows
+export interface SnapshotLink {
+  uri: string;
+  // The `id` parameter, which groups links split across lines or redraws
+  id?: string;
+  // [row, start, end) ranges covered by the link; columns from the tracker,
+  // cell indexes in snapshots
+  spans: Array<[number, number, number]>;
+}
+
+interface LinkPosition {
+  // Normal buffer positions follow their line; alternate screen lines stay put
+  marker?: IMarker;
+  line: number;
+  x: number;
+}
+
+interface LinkRange {
+  uri: string;
+  id?: string;
+  alternate: boolean;
+  start: LinkPosition;
+  // Unset while the link is still open
+  end?: LinkPosition;
+}
+
+const OSC_HYPERLINK = 8;
+// Oldest links are forgotten beyond this many
+const MAX_LINKS = 1000;
+// Longest URI kept, like other terminals' limits
+const MAX_URI_LENGTH = 2048;
+
+export class HyperlinkTracker {
+  private links: LinkRange[] = [];
+  private open: LinkRange | null = null;
+
+  constructor(private terminal: XtermTerminal) {
+    terminal.parser.registerOscHandler(OSC_HYPERLINK, (data) => {
+      this.handleHyperlink(data);
+      // Let the terminal handle the sequence as well
+      return false;
+    });
+    terminal.buffer.onBufferChange((buffer) => {
+      if (buffer.type === 'normal') {
+        this.links = this.links.filter((link) => !link.alternate);
+        if (this.open?.alternate) this.open = null;
+      }
+    });
+  }
+
+  private handleHyperlink(data: string): void {
+    const separator = data.indexOf(';');
+    if (separator === -1) return;
+    const uri = data.slice(separator + 1);
+
+    // Any OSC 8 ends the current link; one with a URI starts the next
+    this.closeOpenLink();
+    if (!uri || uri.length > MAX_URI_LENGTH) return;
+
+    const id = data
+      .slice(0, separator)
+      .split(':')
+      .find((param) => param.startsWith('id='))
+      ?.slice(3);
+    this.open = {
+      uri,
+      id: id || undefined,
+      alternate: this.terminal.buffer.active.type === 'alternate',
+      start: this.cursorPosition(true),
+    };
+  }
+
+  private closeOpenLink(): void {
+    const link = this.open;
+    if (!link) return;
+    this.open = null;
+
+    link.end = this.cursorPosition(true);
+    if (link.end.line === link.start.line && link.end.x === link.start.x) {
+      this.disposeLink(link);
+      return;
+    }
+    this.links.push(link);
+    if (this.links.length > MAX_LINKS) {
+      this.links.splice(0, this.links.length - MAX_LINKS).forEach((old) => this.disposeLink(old));
+    }
+  }
+
+  /**
+   * The cursor's position; with `track` normal buffer positions get a marker
+   */
+  private cursorPosition(track: boolean): LinkPosition {
+    const buffer = this.terminal.buffer.active;
+    const line = buffer.baseY + buffer.cursorY;
+    const marker = track && buffer.type === 'normal' ? this.terminal.registerMarker(0) : undefined;
+    return { marker, line, x: buffer.cursorX };
+  }
+
+  private disposeLink(link: LinkRange): void {
+    link.start.marker?.dispose();
+    link.end?.marker?.dispose();
+  }
+
+  /**
+   * Links on buffer lines [startLine, startLine + rowCount), with rows counted
+   * from startLine. Null if there are none.
+   */
+  getLinks(startLine: number, rowCount: number): SnapshotLink[] | null {
+    const buffer = this.terminal.buffer.active;
+    const alternate = buffer.type === 'alternate';
+    const cols = this.terminal.cols;
+    // The open link reaches up to the cursor so far
+    const open = this.open ? [{ ...this.open, end: this.cursorPosition(false) }] : [];
+    const links: SnapshotLink[] = [];
+
+    for (const link of [...this.links, ...open]) {
+      if (link.alternate !== alternate || !link.end) continue;
+      const start = positionLine(link.start);
+      const end = positionLine(link.end);
+      if (start === null || end === null) continue;
+
+      const spans: Array<[number, number, number]> = [];
+      const first = Math.max(start, startLine);
+      const last = Math.min(end, startLine + rowCount - 1);
+      for (let line = first; line <= last; line++) {
+        const startCol = line === start ? link.start.x : 0;
+        const endCol = line === end ? link.end.x : cols;
+        if (endCol > startCol) spans.push([line - startLine, startCol, endCol]);
+      }
+      if (spans.length > 0) {
+        links.push({ uri: link.uri, id: link.id, spans });
+      }
+    }
+
+    return links.length > 0 ? links : null;
+  }
+}
+
+function positionLine(position: LinkPosition): number | null {
+  if (!position.marker) return position.line;
+  return position.marker.isDisposed ? null : position.marker.line;
+}
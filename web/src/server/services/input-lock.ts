@@ -0,0 +1,190 @@
+/**
+ * InputLockManager - Exclusive write control of a session
+ *
+ * A client that takes the lock of a session gets a lock token; until the lock
+ * is released or times out, input is only accepted with that token and every
+ * other client is read-only. Input sent with the token renews the lock, so an
+ * active holder keeps it while an abandoned one expires.
+ */
+
+import { randomBytes } from 'crypto';
+import { EventEmitter } from 'events';
+import type { Request } from 'express';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('input-lock');
+
+const DEFAULT_LOCK_TTL_MS = 5 * 60 * 1000;
+const MAX_LOCK_TTL_MS = 60 * 60 * 1000;
+
+// Header (or `lockToken` body field) carrying the lock token with input
+export const LOCK_TOKEN_HEADER = 'x-vibetunnel-lock-token';
+
+// Response for input rejected because another client holds the lock
+export const INPUT_LOCKED_ERROR = {
+  error: 'Session input is locked by another client',
+  code: 'INPUT_LOCKED',
+};
+
+/**
+ * Lock token sent with a request, from LOCK_TOKEN_HEADER or the `lockToken` body field
+ */
+export function lockTokenFromRequest(req: Request): string | undefined {
+  const header = req.headers[LOCK_TOKEN_HEADER];
+  if (typeof header === 'string' && header) return header;
+  const token = req.body?.lockToken;
+  return typeof token === 'string' && token ? token : undefined;
+}
+
+export interface InputLockInfo {
+  sessionId: string;
+  userId: string | null;
+  // Free-form label of the holder, e.g. 'agent' or a client name
+  holder?: string;
+  acquiredAt: string;
+  expiresAt: string;
+}
+
+interface InputLock {
+  token: string;
+  userId: string | null;
+  holder?: string;
+  ttlMs: number;
+  acquiredAt: number;
+  expiresAt: number;
+  timer: NodeJS.Timeout;
+}
+
+export type AcquireResult =
+  | { acquired: true; token: string; lock: InputLockInfo }
+  | { acquired: false; lock: InputLockInfo };
+
+export interface AcquireOptions {
+  userId?: string;
+  holder?: string;
+  ttlMs?: number;
+  // Token of a lock the caller already holds, renewing it
+  token?: string;
+  // Take the lock even if someone else holds it (admins)
+  force?: boolean;
+}
+
+export class InputLockManager extends EventEmitter {
+  private locks = new Map<string, InputLock>();
+  private stats = { acquired: 0, released: 0, expired: 0, rejectedInputs: 0 };
+
+  /**
+   * Take or renew the lock of a session. Fails with the current lock if another
+   * holder has it, unless forced.
+   */
+  acquire(sessionId: string, options: AcquireOptions = {}): AcquireResult {
+    const existing = this.locks.get(sessionId);
+    if (existing && existing.token !== options.token && !options.force) {
+      return { acquired: false, lock: this.toInfo(sessionId, existing) };
+    }
+    if (existing) {
+      clearTimeout(existing.timer);
+    }
+
+    const ttlMs = Math.min(options.ttlMs ?? DEFAULT_LOCK_TTL_MS, MAX_LOCK_TTL_MS);
+    const renewed = existing !== undefined && existing.token === options.token;
+    const now = Date.now();
+    const lock: InputLock = {
+      token: renewed ? existing.token : randomBytes(16).toString('hex'),
+      userId: options.userId ?? null,
+      holder: options.holder,
+      ttlMs,
+      acquiredAt: renewed ? existing.acquiredAt : now,
+      expiresAt: now + ttlMs,
+      timer: this.expireAfter(sessionId, ttlMs),
+    };
+    this.locks.set(sessionId, lock);
+
+    if (!renewed) {
+      this.stats.acquired++;
+      logger.log(`session ${sessionId} input locked by ${lock.userId ?? 'anonymous'}`);
+    }
+    this.emitChange(sessionId);
+    return { acquired: true, token: lock.token, lock: this.toInfo(sessionId, lock) };
+  }
+
+  /**
+   * Release the lock of a session. Without a token (forced release) any lock is
+   * released. Returns false if the token does not match the lock.
+   */
+  release(sessionId: string, token?: string): boolean {
+    const lock = this.locks.get(sessionId);
+    if (!lock) return true;
+    if (token !== undefined && token !== lock.token) return false;
+
+    clearTimeout(lock.timer);
+    this.locks.delete(sessionId);
+    this.stats.released++;
+    logger.log(`session ${sessionId} input lock released`);
+    this.emitChange(sessionId);
+    return true;
+  }
+
+  /**
+   * Whether input with the given token may be written to the session. Accepted
+   * input from the holder renews its lock.
+   */
+  checkInput(sessionId: string, token?: string): boolean {
+    const lock = this.locks.get(sessionId);
+    if (!lock) return true;
+    if (token !== lock.token) {
+      this.stats.rejectedInputs++;
+      return false;
+    }
+
+    clearTimeout(lock.timer);
+    lock.expiresAt = Date.now() + lock.ttlMs;
+    lock.timer = this.expireAfter(sessionId, lock.ttlMs);
+    return true;
+  }
+
+  getLock(sessionId: string): InputLockInfo | null {
+    const lock = this.locks.get(sessionId);
+    return lock ? this.toInfo(sessionId, lock) : null;
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    return { locks: this.locks.size, ...this.stats };
+  }
+
+  destroy(): void {
+    for (const lock of this.locks.values()) {
+      clearTimeout(lock.timer);
+    }
+    this.locks.clear();
+    this.removeAllListeners();
+  }
+
+  private expireAfter(sessionId: string, ttlMs: number): NodeJS.Timeout {
+    const timer = setTimeout(() => {
+      this.locks.delete(sessionId);
+      this.stats.expired++;
+      logger.log(`session ${sessionId} input lock expired`);
+      this.emitChange(sessionId);
+    }, ttlMs);
+    timer.unref();
+    return timer;
+  }
+
+  private toInfo(sessionId: string, lock: InputLock): InputLockInfo {
+    return {
+      sessionId,
+      userId: lock.userId,
+      ...(lock.holder ? { holder: lock.holder } : {}),
+      acquiredAt: new Date(lock.acquiredAt).toISOString(),
+      expiresAt: new Date(lock.expiresAt).toISOString(),
+    };
+  }
+
+  private emitChange(sessionId: string): void {
+    this.emit('lock-changed', sessionId, this.getLock(sessionId));
+  }
+}
@@ -0,0 +1,236 @@
+import { isSpecialKey } from '../../shared/keymap.js';
+import type {
+  CompositionPhase,
+  SessionInput,
+  SessionInputAck,
+  SessionInputBatch,
+} from '../../shared/types.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('input-sequencer');
+
+// Limits for a single batch
+const MAX_BATCH_INPUTS = 1000;
+const MAX_BATCH_TEXT_BYTES = 64 * 1024;
+
+const DEFAULT_CLIENT_ID = 'default';
+
+export interface InputSequencerOptions {
+  // How long an out-of-order batch waits for the missing ones before the gap is skipped
+  reorderTimeoutMs: number;
+  // Maximum out-of-order batches held per client
+  maxPendingBatches: number;
+  // Forget a client's sequence state after this long without input
+  idleTimeoutMs: number;
+}
+
+const DEFAULT_OPTIONS: InputSequencerOptions = {
+  reorderTimeoutMs: 2000,
+  maxPendingBatches: 32,
+  idleTimeoutMs: 10 * 60 * 1000,
+};
+
+export type ApplyInputs = (inputs: SessionInput[]) => void;
+
+interface InputStream {
+  lastSeq: number;
+  lastActivity: number;
+  pending: Map<number, { inputs: SessionInput[]; apply: ApplyInputs }>;
+  gapTimer: NodeJS.Timeout | null;
+}
+
+const COMPOSITION_PHASES = ['start', 'update', 'commit', 'cancel'];
+
+/**
+ * Validate a composition input. Returns an error message if invalid.
+ */
+export function validateComposition(composition: unknown, text: unknown): string | null {
+  if (typeof composition !== 'string' || !COMPOSITION_PHASES.includes(composition)) {
+    return `composition must be one of: ${COMPOSITION_PHASES.join(', ')}`;
+  }
+  if (text !== undefined && typeof text !== 'string') {
+    return 'Text must be a string';
+  }
+  if (composition === 'commit' && typeof text !== 'string') {
+    return 'Text is required to commit a composition';
+  }
+  return null;
+}
+
+/**
+ * Validate a request body as an input batch. Returns an error message if invalid.
+ */
+export function parseInputBatch(
+  body: unknown
+): { batch: SessionInputBatch; error?: undefined } | { batch?: undefined; error: string } {
+  const { clientId, seq, inputs } = (body || {}) as Record<string, unknown>;
+
+  if (clientId !== undefined && (typeof clientId !== 'string' || clientId.length > 128)) {
+    return { error: 'clientId must be a string of at most 128 characters' };
+  }
+  if (typeof seq !== 'number' || !Number.isSafeInteger(seq) || seq < 0) {
+    return { error: 'seq must be a non-negative integer' };
+  }
+  if (!Array.isArray(inputs) || inputs.length === 0) {
+    return { error: 'inputs must be a non-empty array' };
+  }
+  if (inputs.length > MAX_BATCH_INPUTS) {
+    return { error: `A batch may contain at most ${MAX_BATCH_INPUTS} inputs` };
+  }
+
+  let textBytes = 0;
+  const parsed: SessionInput[] = [];
+  for (const input of inputs) {
+    const { text, key, composition } = (input || {}) as Record<string, unknown>;
+    if (composition !== undefined) {
+      const error = validateComposition(composition, text);
+      if (error) return { error };
+      if (typeof text === 'string') textBytes += Buffer.byteLength(text, 'utf8');
+      parsed.push({ composition: composition as CompositionPhase, text: text as string });
+      continue;
+    }
+    if ((text === undefined) === (key === undefined)) {
+      return { error: 'Each input must have either text or key, but not both' };
+    }
+    if (text !== undefined) {
+      if (typeof text !== 'string') return { error: 'Text must be a string' };
+      textBytes += Buffer.byteLength(text, 'utf8');
+      parsed.push({ text });
+    } else {
+      if (typeof key !== 'string') return { error: 'Key must be a string' };
+      if (!isSpecialKey(key)) return { error: `Unknown key: ${key}` };
+      parsed.push({ key: key as SessionInput['key'] });
+    }
+  }
+  if (textBytes > MAX_BATCH_TEXT_BYTES) {
+    return { error: `A batch may contain at most ${MAX_BATCH_TEXT_BYTES} bytes of text` };
+  }
+
+  return { batch: { clientId: clientId as string | undefined, seq, inputs: parsed } };
+}
+
+/**
+ * Applies sequenced input batches in order, exactly once per client.
+ *
+ * Clients number their batches. A batch with the next expected number is
+ * applied immediately; earlier numbers are retries and only acknowledged; later
+ * numbers are held until the missing batches arrive (or reorderTimeoutMs
+ * passes, in which case the gap is skipped). The first batch from a client
+ * sets its starting point.
+ */
+export class InputSequencer {
+  private streams: Map<string, InputStream> = new Map();
+  private options: InputSequencerOptions;
+
+  constructor(options: Partial<InputSequencerOptions> = {}) {
+    this.options = { ...DEFAULT_OPTIONS, ...options };
+  }
+
+  /**
+   * Submit a batch. `apply` writes the inputs to the session and may throw, in
+   * which case the batch is not acknowledged.
+   */
+  submit(sessionId: string, batch: SessionInputBatch, apply: ApplyInputs): SessionInputAck {
+    this.pruneIdle();
+
+    const key = `${sessionId}:${batch.clientId || DEFAULT_CLIENT_ID}`;
+    let stream = this.streams.get(key);
+    if (!stream) {
+      stream = { lastSeq: batch.seq - 1, lastActivity: 0, pending: new Map(), gapTimer: null };
+      this.streams.set(key, stream);
+    }
+    stream.lastActivity = Date.now();
+
+    if (batch.seq <= stream.lastSeq || stream.pending.has(batch.seq)) {
+      logger.debug(`duplicate input batch ${batch.seq} for ${key}`);
+      return { ack: stream.lastSeq, status: 'duplicate' };
+    }
+
+    if (batch.seq > stream.lastSeq + 1) {
+      if (stream.pending.size >= this.options.maxPendingBatches) {
+        // Too far ahead; give up on the gap rather than buffering without bound
+        logger.warn(`input gap for ${key} not filled, skipping to batch ${batch.seq}`);
+        stream.pending.set(batch.seq, { inputs: batch.inputs, apply });
+        this.skipGap(key, stream);
+        return { ack: stream.lastSeq, status: 'applied' };
+      }
+      stream.pending.set(batch.seq, { inputs: batch.inputs, apply });
+      this.startGapTimer(key, stream);
+      return { ack: stream.lastSeq, status: 'queued' };
+    }
+
+    apply(batch.inputs);
+    stream.lastSeq = batch.seq;
+    this.drainPending(key, stream);
+    return { ack: stream.lastSeq, status: 'applied' };
+  }
+
+  /**
+   * Forget all sequence state for a session
+   */
+  clearSession(sessionId: string): void {
+    for (const [key, stream] of this.streams) {
+      if (key.startsWith(`${sessionId}:`)) {
+        if (stream.gapTimer) clearTimeout(stream.gapTimer);
+        this.streams.delete(key);
+      }
+    }
+  }
+
+  getStats() {
+    let pendingBatches = 0;
+    for (const stream of this.streams.values()) {
+      pendingBatches += stream.pending.size;
+    }
+    return { streams: this.streams.size, pendingBatches };
+  }
+
+  // Apply held batches that are now next in sequence
+  private drainPending(key: string, stream: InputStream): void {
+    let next = stream.pending.get(stream.lastSeq + 1);
+    while (next) {
+      stream.pending.delete(stream.lastSeq + 1);
+      try {
+        next.apply(next.inputs);
+      } catch (error) {
+        logger.error(`failed to apply queued input for ${key}:`, error);
+      }
+      stream.lastSeq++;
+      next = stream.pending.get(stream.lastSeq + 1);
+    }
+
+    if (stream.pending.size === 0 && stream.gapTimer) {
+      clearTimeout(stream.gapTimer);
+      stream.gapTimer = null;
+    }
+  }
+
+  // Jump over missing batches to the lowest held one
+  private skipGap(key: string, stream: InputStream): void {
+    if (stream.pending.size === 0) return;
+    const lowest = Math.min(...stream.pending.keys());
+    stream.lastSeq = lowest - 1;
+    this.drainPending(key, stream);
+    if (stream.pending.size > 0) {
+      this.startGapTimer(key, stream);
+    }
+  }
+
+  private startGapTimer(key: string, stream: InputStream): void {
+    if (stream.gapTimer) return;
+    stream.gapTimer = setTimeout(() => {
+      stream.gapTimer = null;
+      logger.warn(`input batch ${stream.lastSeq + 1} for ${key} never arrived, skipping`);
+      this.skipGap(key, stream);
+    }, this.options.reorderTimeoutMs);
+  }
+
+  private pruneIdle(): void {
+    const cutoff = Date.now() - this.options.idleTimeoutMs;
+    for (const [key, stream] of this.streams) {
+      if (stream.lastActivity < cutoff && stream.pending.size === 0) {
+        this.streams.delete(key);
+      }
+    }
+  }
+}
@@ -0,0 +1,125 @@
+/**
+ * LinkDetector - Finds URLs and file paths in terminal output
+ *
+ * Scans the text of a session's recent lines for URLs (http, https, ftp,
+ * file) and absolute or home-relative file paths, optionally followed by
+ * :line[:column] as compilers and test runners print them. Each distinct link
+ * is reported once, most recent first, with the line it last appeared on so
+ * clients can offer quick-open buttons without fetching the scrollback.
+ */
+
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+
+export type LinkType = 'url' | 'path';
+
+export interface DetectedLink {
+  type: LinkType;
+  value: string;
+  // Line and column printed after a path (file.ts:12:5)
+  line?: number;
+  column?: number;
+  // Whether a path exists on this host
+  exists?: boolean;
+  // The output line it appeared on most recently
+  context: string;
+  occurrences: number;
+}
+
+export interface DetectLinksOptions {
+  types?: LinkType[];
+  // Most links reported (default 100)
+  limit?: number;
+}
+
+const DEFAULT_LIMIT = 100;
+// Context lines are cut to this length around the link
+const MAX_CONTEXT_LENGTH = 200;
+
+const URL_PATTERN = /\b(?:https?|ftp|file):\/\/[^\s<>"'`]+/g;
+// Starts at the line start or after whitespace, a quote, a bracket or `=`, so URL
+// paths and fractions like km/s are not matched
+const PATH_PATTERN =
+  /(?<=^|[\s'"`([{<=])(~?\/[\w.@%+-]+(?:\/[\w.@%+-]+)*\/?)(?::(\d+)(?::(\d+))?)?/g;
+const TRAILING_PUNCTUATION = /[.,;:!?]+$/;
+const BRACKETS: Record<string, string> = { ')': '(', ']': '[', '}': '{' };
+
+/**
+ * Strip sentence punctuation and closing brackets that were not opened in the link
+ */
+function trimUrl(url: string): string {
+  let trimmed = url.replace(TRAILING_PUNCTUATION, '');
+  while (trimmed && BRACKETS[trimmed[trimmed.length - 1]]) {
+    const closing = trimmed[trimmed.length - 1];
+    const opening = BRACKETS[closing];
+    if (trimmed.split(opening).length >= trimmed.split(closing).length) break;
+    trimmed = trimmed.slice(0, -1).replace(TRAILING_PUNCTUATION, '');
+  }
+  return trimmed;
+}
+
+function contextAround(text: string, index: number): string {
+  if (text.length <= MAX_CONTEXT_LENGTH) return text.trim();
+  const start = Math.max(
+    0,
+    Math.min(index - MAX_CONTEXT_LENGTH / 2, text.length - MAX_CONTEXT_LENGTH)
+  );
+  return text.slice(start, start + MAX_CONTEXT_LENGTH).trim();
+}
+
+function pathExists(value: string): boolean {
+  const resolved = value.startsWith('~') ? path.join(os.homedir(), value.slice(1)) : value;
+  try {
+    fs.accessSync(resolved);
+    return true;
+  } catch {
+    return false;
+  }
+}
+
+/**
+ * Links in output lines (oldest first), deduplicated and most recent first
+ */
+export function detectLinks(lines: string[], options: DetectLinksOptions = {}): DetectedLink[] {
+  const types = new Set(options.types ?? ['url', 'path']);
+  const limit = options.limit ?? DEFAULT_LIMIT;
+  const links = new Map<string, DetectedLink>();
+
+  const record = (link: Omit<DetectedLink, 'occurrences'>) => {
+    const key = `${link.type}:${link.value}:${link.line ?? ''}:${link.column ?? ''}`;
+    const seen = links.get(key);
+    // Re-inserted so the map stays ordered by last appearance
+    links.delete(key);
+    links.set(key, { ...link, occurrences: (seen?.occurrences ?? 0) + 1 });
+  };
+
+  for (const text of lines) {
+    if (types.has('url')) {
+      for (const match of text.matchAll(URL_PATTERN)) {
+        const value = trimUrl(match[0]);
+        if (value.length > match[0].indexOf('//') + 2) {
+          record({ type: 'url', value, context: contextAround(text, match.index ?? 0) });
+        }
+      }
+    }
+    if (types.has('path')) {
+      for (const match of text.matchAll(PATH_PATTERN)) {
+        const value = match[1].replace(TRAILING_PUNCTUATION, '');
+        record({
+          type: 'path',
+          value,
+          line: match[2] ? Number(match[2]) : undefined,
+          column: match[3] ? Number(match[3]) : undefined,
+          context: contextAround(text, match.index ?? 0),
+        });
+      }
+    }
+  }
+
+  const recent = [...links.values()].reverse().slice(0, limit);
+  for (const link of recent) {
+    if (link.type === 'path') link.exists = pathExists(link.value);
+  }
+  return recent;
+}
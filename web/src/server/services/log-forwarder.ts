@@ -0,0 +1,500 @@
+import * as dgram from 'dgram';
+import * as fs from 'fs';
+import * as net from 'net';
+import * as os from 'os';
+import * as path from 'path';
+import { type PtyManager, visibleLineText } from '../pty/index.js';
+import { createLogger } from '../utils/logger.js';
+import { WriteQueue } from '../utils/write-queue.js';
+
+const logger = createLogger('log-forwarder');
+
+// Lines are collected and written to the sinks this often
+const FLUSH_INTERVAL_MS = 1000;
+// Unfinished lines longer than this are forwarded as they are
+const MAX_LINE_LENGTH = 16 * 1024;
+// Lines waiting for a flush; older ones are dropped beyond this
+const MAX_PENDING_LINES = 10000;
+const DEFAULT_MAX_FILE_BYTES = 10 * 1024 * 1024;
+const DEFAULT_MAX_FILES = 5;
+const SYSLOG_PORT = 514;
+// local0
+const SYSLOG_FACILITY = 16;
+const SYSLOG_SEVERITY_INFO = 6;
+// RFC 5426 recommends staying within 2048 bytes over UDP
+const MAX_SYSLOG_UDP_BYTES = 2048;
+// Structured data ID of the session labels (32473 is the documentation enterprise number)
+const SYSLOG_SD_ID = 'session@32473';
+const LOKI_PUSH_PATH = '/loki/api/v1/push';
+const LOKI_TIMEOUT_MS = 10000;
+
+export type LogSinkConfig =
+  // Appends to a file, rotated to <path>.1 ... <path>.<maxFiles> past maxBytes
+  | { type: 'file'; path: string; maxBytes?: number; maxFiles?: number }
+  | { type: 'syslog'; host: string; port?: number; protocol?: 'udp' | 'tcp' }
+  // Loki push API; `url` without a path gets /loki/api/v1/push
+  | { type: 'loki'; url: string; labels?: Record<string, string> };
+
+// Metadata sent along with each line
+export interface SessionLabels {
+  sessionId: string;
+  sessionName: string;
+  command: string;
+  workingDir: string;
+  user?: string;
+}
+
+export interface LogEntry {
+  time: number;
+  line: string;
+  session: SessionLabels;
+}
+
+interface LogSink {
+  write(entries: LogEntry[]): Promise<void>;
+  close(): void;
+}
+
+/**
+ * Sink of a target given on the command line or in a create request:
+ * file:///path, syslog://host[:port] (UDP), syslog+tcp://host[:port] or
+ * loki+http(s)://host[:port][/path]. Throws for anything else.
+ */
+export function parseLogSink(target: string): LogSinkConfig {
+  const url = new URL(target);
+  switch (url.protocol) {
+    case 'file:':
+      return { type: 'file', path: decodeURIComponent(url.pathname) };
+    case 'syslog:':
+    case 'syslog+tcp:':
+      return {
+        type: 'syslog',
+        // IPv6 addresses come in brackets
+        host: url.hostname.replace(/^\[(.*)\]$/, '$1'),
+        port: url.port ? Number(url.port) : undefined,
+        protocol: url.protocol === 'syslog:' ? 'udp' : 'tcp',
+      };
+    case 'loki+http:':
+    case 'loki+https:':
+      return { type: 'loki', url: target.slice('loki+'.length) };
+    default:
+      throw new Error(`Unsupported log forwarding target: ${target}`);
+  }
+}
+
+/**
+ * Sinks of a create request's `logForwarding` (targets or sink objects)
+ */
+export function parseLogSinks(value: unknown): { sinks?: LogSinkConfig[]; error?: string } {
+  if (value === undefined || value === null) return {};
+  if (!Array.isArray(value)) {
+    return { error: 'logForwarding must be a list of targets or sinks' };
+  }
+  const sinks: LogSinkConfig[] = [];
+  for (const item of value) {
+    try {
+      sinks.push(typeof item === 'string' ? parseLogSink(item) : validateSink(item));
+    } catch (error) {
+      return { error: error instanceof Error ? error.message : String(error) };
+    }
+  }
+  return { sinks };
+}
+
+function validateSink(value: unknown): LogSinkConfig {
+  const sink = (value ?? {}) as Record<string, unknown>;
+  const optionalNumber = (field: unknown) =>
+    field === undefined || (typeof field === 'number' && Number.isInteger(field) && field > 0);
+  if (
+    sink.type === 'file' &&
+    typeof sink.path === 'string' &&
+    path.isAbsolute(sink.path) &&
+    optionalNumber(sink.maxBytes) &&
+    optionalNumber(sink.maxFiles)
+  ) {
+    return sink as LogSinkConfig;
+  }
+  if (
+    sink.type === 'syslog' &&
+    typeof sink.host === 'string' &&
+    sink.host !== '' &&
+    optionalNumber(sink.port) &&
+    (sink.protocol === undefined || sink.protocol === 'udp' || sink.protocol === 'tcp')
+  ) {
+    return sink as LogSinkConfig;
+  }
+  if (
+    sink.type === 'loki' &&
+    typeof sink.url === 'string' &&
+    /^https?:\/\//.test(sink.url) &&
+    (sink.labels === undefined ||
+      (typeof sink.labels === 'object' &&
+        Object.values(sink.labels as object).every((label) => typeof label === 'string')))
+  ) {
+    return sink as LogSinkConfig;
+  }
+  throw new Error(
+    "Sinks must be { type: 'file', path }, { type: 'syslog', host } or { type: 'loki', url }"
+  );
+}
+
+/**
+ * `<time> <session name>[<session id>]: <line>`
+ */
+function formatTextEntry(entry: LogEntry): string {
+  const { sessionName, sessionId } = entry.session;
+  return `${new Date(entry.time).toISOString()} ${sessionName}[${sessionId}]: ${entry.line}\n`;
+}
+
+class FileSink implements LogSink {
+  private size: number | null = null;
+
+  constructor(private config: Extract<LogSinkConfig, { type: 'file' }>) {}
+
+  async write(entries: LogEntry[]): Promise<void> {
+    const text = entries.map(formatTextEntry).join('');
+    const bytes = Buffer.byteLength(text);
+    if (this.size === null) {
+      await fs.promises.mkdir(path.dirname(this.config.path), { recursive: true });
+      this.size = await fs.promises
+        .stat(this.config.path)
+        .then((stats) => stats.size)
+        .catch(() => 0);
+    }
+    if (this.size > 0 && this.size + bytes > (this.config.maxBytes ?? DEFAULT_MAX_FILE_BYTES)) {
+      await this.rotate();
+    }
+    await fs.promises.appendFile(this.config.path, text);
+    this.size += bytes;
+  }
+
+  private async rotate(): Promise<void> {
+    const file = this.config.path;
+    const ignoreMissing = (error: NodeJS.ErrnoException) => {
+      if (error.code !== 'ENOENT') throw error;
+    };
+    // The oldest file is overwritten
+    for (let i = (this.config.maxFiles ?? DEFAULT_MAX_FILES) - 1; i >= 1; i--) {
+      await fs.promises.rename(`${file}.${i}`, `${file}.${i + 1}`).catch(ignoreMissing);
+    }
+    await fs.promises.rename(file, `${file}.1`).catch(ignoreMissing);
+    this.size = 0;
+  }
+
+  close(): void {}
+}
+
+/**
+ * RFC 5424 message with the session labels as structured data
+ */
+function formatSyslogMessage(entry: LogEntry): string {
+  const { session } = entry;
+  const escape = (value: string) => value.replace(/["\\\]]/g, '\\$&');
+  const params = [
+    `session="${escape(session.sessionId)}"`,
+    `name="${escape(session.sessionName)}"`,
+    `cwd="${escape(session.workingDir)}"`,
+    ...(session.user ? [`user="${escape(session.user)}"`] : []),
+  ];
+  const priority = SYSLOG_FACILITY * 8 + SYSLOG_SEVERITY_INFO;
+  const header = `<${priority}>1 ${new Date(entry.time).toISOString()} ${os.hostname()} vibetunnel`;
+  return `${header} - - [${SYSLOG_SD_ID} ${params.join(' ')}] ${entry.line}`;
+}
+
+class SyslogSink implements LogSink {
+  private udp: dgram.Socket | null = null;
+  private tcp: net.Socket | null = null;
+
+  constructor(private config: Extract<LogSinkConfig, { type: 'syslog' }>) {}
+
+  async write(entries: LogEntry[]): Promise<void> {
+    const port = this.config.port ?? SYSLOG_PORT;
+    if (this.config.protocol === 'tcp') {
+      const socket = await this.connect(port);
+      // Octet-counting framing (RFC 6587)
+      const frames = entries.map((entry) => {
+        const message = formatSyslogMessage(entry);
+        return `${Buffer.byteLength(message)} ${message}`;
+      });
+      socket.write(frames.join(''));
+      return;
+    }
+
+    if (!this.udp) {
+      this.udp = dgram.createSocket(this.config.host.includes(':') ? 'udp6' : 'udp4');
+      this.udp.unref();
+    }
+    const socket = this.udp;
+    for (const entry of entries) {
+      const message = Buffer.from(formatSyslogMessage(entry)).subarray(0, MAX_SYSLOG_UDP_BYTES);
+      await new Promise<void>((resolve, reject) => {
+        socket.send(message, port, this.config.host, (error) =>
+          error ? reject(error) : resolve()
+        );
+      });
+    }
+  }
+
+  private connect(port: number): Promise<net.Socket> {
+    if (this.tcp && !this.tcp.destroyed) return Promise.resolve(this.tcp);
+    return new Promise((resolve, reject) => {
+      const socket = net.connect(port, this.config.host, () => {
+        socket.off('error', reject);
+        socket.on('error', (error) => {
+          logger.warn(`syslog connection to ${this.config.host} failed: ${error.message}`);
+          socket.destroy();
+        });
+        this.tcp = socket;
+        resolve(socket);
+      });
+      socket.once('error', reject);
+      socket.unref();
+    });
+  }
+
+  close(): void {
+    this.udp?.close();
+    this.udp = null;
+    this.tcp?.end();
+    this.tcp = null;
+  }
+}
+
+class LokiSink implements LogSink {
+  private url: string;
+  private headers: Record<string, string> = { 'Content-Type': 'application/json' };
+
+  constructor(private config: Extract<LogSinkConfig, { type: 'loki' }>) {
+    const url = new URL(config.url);
+    if (url.username) {
+      const credentials = `${decodeURIComponent(url.username)}:${decodeURIComponent(url.password)}`;
+      this.headers.Authorization = `Basic ${Buffer.from(credentials).toString('base64')}`;
+      url.username = '';
+      url.password = '';
+    }
+    if (url.pathname === '/') url.pathname = LOKI_PUSH_PATH;
+    this.url = url.toString();
+  }
+
+  async write(entries: LogEntry[]): Promise<void> {
+    // One stream per session
+    const streams = new Map<string, { stream: Record<string, string>; values: string[][] }>();
+    for (const entry of entries) {
+      const { session } = entry;
+      let stream = streams.get(session.sessionId);
+      if (!stream) {
+        stream = {
+          stream: {
+            job: 'vibetunnel',
+            host: os.hostname(),
+            session_id: session.sessionId,
+            session_name: session.sessionName,
+            ...(session.user ? { user: session.user } : {}),
+            ...this.config.labels,
+          },
+          values: [],
+        };
+        streams.set(session.sessionId, stream);
+      }
+      // Nanoseconds since the epoch, as a string
+      stream.values.push([`${entry.time}000000`, entry.line]);
+    }
+
+    const response = await fetch(this.url, {
+      method: 'POST',
+      headers: this.headers,
+      body: JSON.stringify({ streams: Array.from(streams.values()) }),
+      signal: AbortSignal.timeout(LOKI_TIMEOUT_MS),
+    });
+    if (!response.ok) {
+      throw new Error(`Loki returned ${response.status}: ${await response.text()}`);
+    }
+  }
+
+  close(): void {}
+}
+
+function createSink(config: LogSinkConfig): LogSink {
+  switch (config.type) {
+    case 'file':
+      return new FileSink(config);
+    case 'syslog':
+      return new SyslogSink(config);
+    case 'loki':
+      return new LokiSink(config);
+  }
+}
+
+function describeSink(config: LogSinkConfig): string {
+  switch (config.type) {
+    case 'file':
+      return `file ${config.path}`;
+    case 'syslog':
+      return `syslog ${config.host}`;
+    case 'loki':
+      return `loki ${new URL(config.url).host}`;
+  }
+}
+
+/**
+ * Sink with the lines waiting for it; writes to one sink never overlap
+ */
+class QueuedSink {
+  pending: LogEntry[] = [];
+  private queue = new WriteQueue();
+  private failing = false;
+
+  constructor(
+    private sink: LogSink,
+    private name: string
+  ) {}
+
+  add(entry: LogEntry): void {
+    this.pending.push(entry);
+    if (this.pending.length > MAX_PENDING_LINES) this.pending.shift();
+  }
+
+  flush(): void {
+    if (this.pending.length === 0) return;
+    const entries = this.pending;
+    this.pending = [];
+    this.queue.enqueue(async () => {
+      try {
+        await this.sink.write(entries);
+        if (this.failing) logger.log(`forwarding to ${this.name} recovered`);
+        this.failing = false;
+      } catch (error) {
+        // Lines that could not be written are dropped; warn once until it works again
+        if (!this.failing) {
+          const message = error instanceof Error ? error.message : String(error);
+          logger.warn(`forwarding to ${this.name} failed: ${message}`);
+        }
+        this.failing = true;
+      }
+    });
+  }
+
+  async close(): Promise<void> {
+    this.flush();
+    await this.queue.drain();
+    this.sink.close();
+  }
+}
+
+interface SessionState {
+  labels: SessionLabels;
+  // Output since the last newline
+  partial: string;
+  sinks: QueuedSink[];
+}
+
+/**
+ * LogForwarder - Forwards session output to files, syslog or Loki
+ *
+ * Output of sessions running in this process is decoded into plain lines
+ * (escape sequences removed, carriage-return redraws collapsed) and sent with
+ * the session's labels to the server-wide sinks and the sinks given when the
+ * session was created. Lines are batched and written every second; sinks that
+ * cannot keep up or fail drop lines instead of holding up the session.
+ */
+export class LogForwarder {
+  private globalSinks: QueuedSink[];
+  private sessions = new Map<string, SessionState>();
+  private timer: NodeJS.Timeout;
+
+  constructor(
+    private ptyManager: PtyManager,
+    globalSinks: LogSinkConfig[] = []
+  ) {
+    this.globalSinks = globalSinks.map(
+      (config) => new QueuedSink(createSink(config), describeSink(config))
+    );
+    this.timer = setInterval(() => this.flush(), FLUSH_INTERVAL_MS);
+    this.timer.unref();
+    if (globalSinks.length > 0) {
+      logger.log(`forwarding session output to ${globalSinks.map(describeSink).join(', ')}`);
+    }
+  }
+
+  /**
+   * Forward a session's output to more sinks, besides the server-wide ones
+   */
+  addSessionSinks(sessionId: string, sinks: LogSinkConfig[]): void {
+    const state = this.getState(sessionId);
+    if (!state) return;
+    for (const config of sinks) {
+      state.sinks.push(new QueuedSink(createSink(config), describeSink(config)));
+    }
+    logger.debug(`forwarding session ${sessionId} to ${sinks.map(describeSink).join(', ')}`);
+  }
+
+  handleOutput(sessionId: string, data: string): void {
+    if (this.globalSinks.length === 0 && !this.sessions.has(sessionId)) return;
+    const state = this.getState(sessionId);
+    if (!state) return;
+
+    const parts = (state.partial + data).split('\n');
+    state.partial = parts.pop() ?? '';
+    if (state.partial.length > MAX_LINE_LENGTH) {
+      parts.push(state.partial);
+      state.partial = '';
+    }
+    for (const rawLine of parts) {
+      this.addLine(state, rawLine);
+    }
+  }
+
+  /**
+   * Forward the session's last unfinished line and close its own sinks
+   */
+  async endSession(sessionId: string): Promise<void> {
+    const state = this.sessions.get(sessionId);
+    if (!state) return;
+    this.sessions.delete(sessionId);
+    if (state.partial) this.addLine(state, state.partial);
+    for (const sink of this.globalSinks) sink.flush();
+    await Promise.all(state.sinks.map((sink) => sink.close()));
+  }
+
+  async close(): Promise<void> {
+    clearInterval(this.timer);
+    await Promise.all(Array.from(this.sessions.keys(), (id) => this.endSession(id)));
+    await Promise.all(this.globalSinks.map((sink) => sink.close()));
+  }
+
+  private getState(sessionId: string): SessionState | undefined {
+    let state = this.sessions.get(sessionId);
+    if (state) return state;
+
+    const info = this.ptyManager.getSession(sessionId);
+    if (!info) return undefined;
+    state = {
+      labels: {
+        sessionId,
+        sessionName: info.name,
+        command: info.command.join(' '),
+        workingDir: info.workingDir,
+        user: info.createdBy,
+      },
+      partial: '',
+      sinks: [],
+    };
+    this.sessions.set(sessionId, state);
+    return state;
+  }
+
+  private addLine(state: SessionState, rawLine: string): void {
+    const line = visibleLineText(rawLine).trimEnd();
+    if (!line) return;
+    const entry: LogEntry = { time: Date.now(), line, session: state.labels };
+    for (const sink of this.globalSinks) sink.add(entry);
+    for (const sink of state.sinks) sink.add(entry);
+  }
+
+  private flush(): void {
+    for (const sink of this.globalSinks) sink.flush();
+    for (const state of this.sessions.values()) {
+      for (const sink of state.sinks) sink.flush();
+    }
+  }
+}
@@ -0,0 +1,383 @@
+/**
+ * McpServer - Model Context Protocol endpoint exposing sessions as tools
+ *
+ * Speaks JSON-RPC 2.0 as in MCP's streamable HTTP transport, without
+ * server-initiated messages: every request is answered in the HTTP response.
+ * Agents get tools to list sessions, read their screens, send input and wait
+ * for text. Tools act on sessions of this server; which ones a client may use
+ * follows the scopes of its API token (sessions:read, sessions:write).
+ */
+
+import { isSpecialKey } from '../../shared/keymap.js';
+import { cellsToText } from '../../shared/terminal-text-formatter.js';
+import type { SessionInput } from '../../shared/types.js';
+import type { PtyManager } from '../pty/index.js';
+import type { InputSource } from '../utils/input-source.js';
+import { createLogger } from '../utils/logger.js';
+import { VERSION } from '../version.js';
+import { type ApiTokenScope, hasScope } from './api-tokens.js';
+import type { InputLockManager } from './input-lock.js';
+import type { TerminalManager } from './terminal-manager.js';
+import { type TextWaiter, WAIT_SCOPES, WaitError } from './text-waiter.js';
+
+const logger = createLogger('mcp');
+
+// Newest protocol revision first; older ones clients ask for are accepted too
+export const MCP_PROTOCOL_VERSIONS = ['2025-06-18', '2025-03-26', '2024-11-05'] as const;
+
+// JSON-RPC error codes
+const PARSE_ERROR = -32700;
+const INVALID_REQUEST = -32600;
+const METHOD_NOT_FOUND = -32601;
+const INVALID_PARAMS = -32602;
+const INTERNAL_ERROR = -32603;
+
+export interface JsonRpcResponse {
+  jsonrpc: '2.0';
+  id: string | number | null;
+  result?: unknown;
+  error?: { code: number; message: string };
+}
+
+export interface McpContext {
+  // Scopes of the caller's API token; unset for other authentication (all scopes)
+  scopes?: readonly ApiTokenScope[];
+  // Attribution of input sent by tools
+  source: InputSource;
+}
+
+interface ToolResult {
+  content: Array<{ type: 'text'; text: string }>;
+  structuredContent?: Record<string, unknown>;
+  isError?: boolean;
+}
+
+interface Tool {
+  name: string;
+  description: string;
+  // Scope the caller's token needs for the tool
+  scope: ApiTokenScope;
+  inputSchema: Record<string, unknown>;
+  run(args: Record<string, unknown>, context: McpContext): Promise<ToolResult>;
+}
+
+// Protocol error answered with its JSON-RPC code
+class RpcError extends Error {
+  constructor(
+    readonly code: number,
+    message: string
+  ) {
+    super(message);
+  }
+}
+
+// Failure of a tool call, reported to the agent as an error result
+class ToolError extends Error {}
+
+export interface McpServerConfig {
+  ptyManager: PtyManager;
+  terminalManager: TerminalManager;
+  textWaiter: TextWaiter;
+  inputLocks: InputLockManager;
+}
+
+export class McpServer {
+  private tools: Tool[];
+
+  constructor(private config: McpServerConfig) {
+    this.tools = this.createTools();
+  }
+
+  /**
+   * Handle a JSON-RPC message or batch; null when nothing is to be answered
+   * (notifications and responses)
+   */
+  async handle(
+    body: unknown,
+    context: McpContext
+  ): Promise<JsonRpcResponse | JsonRpcResponse[] | null> {
+    if (Array.isArray(body)) {
+      if (body.length === 0) return rpcError(null, INVALID_REQUEST, 'Empty batch');
+      const responses = await Promise.all(body.map((message) => this.handleOne(message, context)));
+      const answered = responses.filter((response) => response !== null);
+      return answered.length > 0 ? answered : null;
+    }
+    return this.handleOne(body, context);
+  }
+
+  private async handleOne(message: unknown, context: McpContext): Promise<JsonRpcResponse | null> {
+    if (typeof message !== 'object' || message === null) {
+      return rpcError(null, PARSE_ERROR, 'Message must be a JSON object');
+    }
+    const { jsonrpc, id, method, params } = message as Record<string, unknown>;
+    const requestId = typeof id === 'string' || typeof id === 'number' ? id : null;
+    if (jsonrpc !== '2.0') {
+      return rpcError(requestId, INVALID_REQUEST, 'jsonrpc must be "2.0"');
+    }
+    if (typeof method !== 'string') {
+      // Responses to server requests; this server sends none
+      return null;
+    }
+    // Notifications (no id) are accepted without an answer
+    if (id === undefined) {
+      logger.debug(`notification ${method}`);
+      return null;
+    }
+
+    try {
+      const args = (params ?? {}) as Record<string, unknown>;
+      const result = await this.dispatch(method, args, context);
+      return { jsonrpc: '2.0', id: requestId, result };
+    } catch (error) {
+      if (error instanceof RpcError) {
+        return rpcError(requestId, error.code, error.message);
+      }
+      logger.error(`error handling ${method}:`, error);
+      return rpcError(requestId, INTERNAL_ERROR, 'Internal error');
+    }
+  }
+
+  private async dispatch(
+    method: string,
+    params: Record<string, unknown>,
+    context: McpContext
+  ): Promise<unknown> {
+    switch (method) {
+      case 'initialize': {
+        const requested = params.protocolVersion;
+        const protocolVersion = MCP_PROTOCOL_VERSIONS.find((version) => version === requested);
+        return {
+          protocolVersion: protocolVersion ?? MCP_PROTOCOL_VERSIONS[0],
+          capabilities: { tools: { listChanged: false } },
+          serverInfo: { name: 'vibetunnel', title: 'VibeTunnel', version: VERSION },
+          instructions:
+            'Terminal sessions of a VibeTunnel server. List sessions, read a screen, then send ' +
+            'input and wait for the text that shows it was handled.',
+        };
+      }
+      case 'ping':
+        return {};
+      case 'tools/list':
+        return {
+          tools: this.allowedTools(context).map(({ name, description, inputSchema }) => ({
+            name,
+            description,
+            inputSchema,
+          })),
+        };
+      case 'tools/call':
+        return this.callTool(params, context);
+      default:
+        throw new RpcError(METHOD_NOT_FOUND, `Method not found: ${method}`);
+    }
+  }
+
+  private allowedTools(context: McpContext): Tool[] {
+    const { scopes } = context;
+    return scopes ? this.tools.filter((tool) => hasScope(scopes, tool.scope)) : this.tools;
+  }
+
+  private async callTool(params: Record<string, unknown>, context: McpContext) {
+    const tool = this.tools.find((candidate) => candidate.name === params.name);
+    if (!tool) {
+      throw new RpcError(INVALID_PARAMS, `Unknown tool: ${String(params.name)}`);
+    }
+    if (!this.allowedTools(context).includes(tool)) {
+      return toolError(`The token lacks the ${tool.scope} scope needed for ${tool.name}`);
+    }
+    const args = params.arguments ?? {};
+    if (typeof args !== 'object' || args === null || Array.isArray(args)) {
+      throw new RpcError(INVALID_PARAMS, 'Tool arguments must be an object');
+    }
+
+    try {
+      return await tool.run(args as Record<string, unknown>, context);
+    } catch (error) {
+      if (error instanceof ToolError || error instanceof WaitError) {
+        return toolError(error.message);
+      }
+      throw error;
+    }
+  }
+
+  private runningSession(sessionId: unknown) {
+    if (typeof sessionId !== 'string' || !sessionId) {
+      throw new ToolError('sessionId is required');
+    }
+    const session = this.config.ptyManager.getSession(sessionId);
+    if (!session) {
+      throw new ToolError(`Session ${sessionId} not found`);
+    }
+    if (session.status !== 'running') {
+      throw new ToolError(`Session ${sessionId} is not running`);
+    }
+    return session;
+  }
+
+  private parseInput(args: Record<string, unknown>): SessionInput | undefined {
+    const { text, key } = args;
+    if (text !== undefined && key !== undefined) {
+      throw new ToolError('Give either text or key, not both');
+    }
+    if (typeof text === 'string') {
+      return { text: args.submit === true ? `${text}\r` : text };
+    }
+    if (key !== undefined) {
+      if (typeof key !== 'string' || !isSpecialKey(key)) {
+        throw new ToolError(`Unknown key: ${String(key)}`);
+      }
+      return { key };
+    }
+    return undefined;
+  }
+
+  private checkLock(sessionId: string): void {
+    if (!this.config.inputLocks.checkInput(sessionId, undefined)) {
+      throw new ToolError(`Session ${sessionId} is locked by another client`);
+    }
+  }
+
+  private createTools(): Tool[] {
+    const { ptyManager, terminalManager, textWaiter } = this.config;
+    const sessionIdSchema = { type: 'string', description: 'ID of the session' };
+    const inputSchema = {
+      text: { type: 'string', description: 'Text to type, including escape sequences' },
+      key: {
+        type: 'string',
+        description: 'Special key instead of text, e.g. enter, escape, arrow_up, ctrl_c',
+      },
+      submit: { type: 'boolean', description: 'Press Enter after the text' },
+    };
+
+    return [
+      {
+        name: 'list_sessions',
+        description: 'List the terminal sessions with their command, directory and status.',
+        scope: 'sessions:read',
+        inputSchema: {
+          type: 'object',
+          properties: {
+            includeExited: { type: 'boolean', description: 'Also list exited sessions' },
+          },
+        },
+        run: async (args) => {
+          const sessions = ptyManager
+            .listSessions()
+            .filter((session) => args.includeExited === true || session.status === 'running')
+            .map((session) => ({
+              id: session.id,
+              name: session.name,
+              command: session.command.join(' '),
+              workingDir: session.currentWorkingDir ?? session.workingDir,
+              status: session.status,
+              exitCode: session.exitCode,
+              startedAt: session.startedAt,
+            }));
+          return jsonResult({ sessions });
+        },
+      },
+      {
+        name: 'read_screen',
+        description:
+          'Read what a session shows on its screen as plain text, with the cursor position.',
+        scope: 'sessions:read',
+        inputSchema: {
+          type: 'object',
+          properties: { sessionId: sessionIdSchema },
+          required: ['sessionId'],
+        },
+        run: async (args) => {
+          if (typeof args.sessionId !== 'string' || !ptyManager.getSession(args.sessionId)) {
+            throw new ToolError(`Session ${String(args.sessionId)} not found`);
+          }
+          const snapshot = await terminalManager.getBufferSnapshot(args.sessionId);
+          const text = cellsToText(snapshot.cells, false);
+          return {
+            content: [{ type: 'text', text }],
+            structuredContent: {
+              text,
+              cols: snapshot.cols,
+              rows: snapshot.rows,
+              cursorX: snapshot.cursorX,
+              cursorY: snapshot.cursorY,
+            },
+          };
+        },
+      },
+      {
+        name: 'send_input',
+        description: 'Type text or press a key in a session.',
+        scope: 'sessions:write',
+        inputSchema: {
+          type: 'object',
+          properties: { sessionId: sessionIdSchema, ...inputSchema },
+          required: ['sessionId'],
+        },
+        run: async (args, context) => {
+          const session = this.runningSession(args.sessionId);
+          const input = this.parseInput(args);
+          if (!input) throw new ToolError('text or key is required');
+          this.checkLock(session.id);
+          ptyManager.sendInput(session.id, input, context.source);
+          return jsonResult({ sent: true });
+        },
+      },
+      {
+        name: 'wait_for_text',
+        description:
+          'Wait until a regular expression matches the screen or new output of a session, ' +
+          'optionally sending input first. Use it to know when a command finished.',
+        scope: 'sessions:read',
+        inputSchema: {
+          type: 'object',
+          properties: {
+            sessionId: sessionIdSchema,
+            pattern: { type: 'string', description: 'Regular expression (multiline)' },
+            ignoreCase: { type: 'boolean' },
+            scope: {
+              type: 'string',
+              enum: [...WAIT_SCOPES],
+              description: 'Match the screen (default) or output printed after the call',
+            },
+            timeoutMs: { type: 'integer', description: 'Default 30000' },
+            ...inputSchema,
+          },
+          required: ['sessionId', 'pattern'],
+        },
+        run: async (args, context) => {
+          const session = this.runningSession(args.sessionId);
+          const input = this.parseInput(args);
+          if (input) {
+            if (context.scopes && !hasScope(context.scopes, 'sessions:write')) {
+              throw new ToolError('Sending input needs the sessions:write scope');
+            }
+            this.checkLock(session.id);
+          }
+          const result = await textWaiter.waitFor(
+            session.id,
+            {
+              pattern: args.pattern as string,
+              ignoreCase: args.ignoreCase === true,
+              scope: args.scope as (typeof WAIT_SCOPES)[number] | undefined,
+              timeoutMs: args.timeoutMs as number | undefined,
+            },
+            input && (() => ptyManager.sendInput(session.id, input, context.source))
+          );
+          return jsonResult({ ...result });
+        },
+      },
+    ];
+  }
+}
+
+function rpcError(id: string | number | null, code: number, message: string): JsonRpcResponse {
+  return { jsonrpc: '2.0', id, error: { code, message } };
+}
+
+function jsonResult(result: Record<string, unknown>): ToolResult {
+  return { content: [{ type: 'text', text: JSON.stringify(result) }], structuredContent: result };
+}
+
+function toolError(message: string): ToolResult {
+  return { content: [{ type: 'text', text: message }], isError: true };
+}
@@ -0,0 +1,394 @@
+/**
+ * OidcService - OpenID Connect single sign-on for the web login
+ *
+ * Runs the authorization code flow (with PKCE) against an OpenID provider such
+ * as Google, Okta, Keycloak or Dex. The provider's endpoints are discovered from
+ * <issuer>/.well-known/openid-configuration and ID tokens are verified against
+ * its published keys. The identity becomes the VibeTunnel user ID, so sessions,
+ * admin users and control root routing work as for system users.
+ */
+
+import { createHash, createPublicKey, type KeyObject, randomBytes } from 'crypto';
+import * as jwt from 'jsonwebtoken';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('oidc');
+
+// How long a user may take to sign in at the provider
+const PENDING_TTL_MS = 10 * 60 * 1000;
+// Unknown key IDs refetch the provider keys at most this often
+const JWKS_REFRESH_MS = 60 * 1000;
+const FETCH_TIMEOUT_MS = 10000;
+const ID_TOKEN_ALGORITHMS: jwt.Algorithm[] = [
+  'RS256',
+  'RS384',
+  'RS512',
+  'PS256',
+  'PS384',
+  'PS512',
+  'ES256',
+  'ES384',
+  'ES512',
+];
+
+export interface OidcConfig {
+  issuer: string;
+  clientId: string;
+  clientSecret: string;
+  // Callback URL registered with the provider; derived from the request if unset
+  redirectUrl?: string;
+  // Claim used as the user ID (default: email)
+  usernameClaim?: string;
+  // Claim listing the user's groups (default: groups)
+  groupsClaim?: string;
+  // Anyone the provider authenticates may sign in when both lists are empty
+  allowedGroups?: string[];
+  // Addresses, or domains written as @example.com
+  allowedEmails?: string[];
+}
+
+export interface OidcIdentity {
+  userId: string;
+  subject: string;
+  email?: string;
+  groups: string[];
+}
+
+interface ProviderMetadata {
+  issuer: string;
+  authorization_endpoint: string;
+  token_endpoint: string;
+  jwks_uri: string;
+  userinfo_endpoint?: string;
+  token_endpoint_auth_methods_supported?: string[];
+}
+
+interface PendingAuthorization {
+  nonce: string;
+  codeVerifier: string;
+  redirectUri: string;
+  expiresAt: number;
+}
+
+type Claims = Record<string, unknown>;
+
+export class OidcError extends Error {
+  constructor(
+    message: string,
+    readonly status = 400
+  ) {
+    super(message);
+    this.name = 'OidcError';
+  }
+}
+
+export class OidcService {
+  private readonly issuer: string;
+  private metadata?: Promise<ProviderMetadata>;
+  private keys = new Map<string, KeyObject>();
+  private keysFetchedAt = 0;
+  private pending = new Map<string, PendingAuthorization>();
+  private cleanupInterval: NodeJS.Timeout;
+
+  constructor(private readonly config: OidcConfig) {
+    this.issuer = config.issuer.replace(/\/+$/, '');
+    this.cleanupInterval = setInterval(() => this.cleanupExpired(), 60000);
+    this.cleanupInterval.unref();
+  }
+
+  /**
+   * Whether the allow lists restrict who may sign in
+   */
+  isRestricted(): boolean {
+    return !!(this.config.allowedGroups?.length || this.config.allowedEmails?.length);
+  }
+
+  /**
+   * Start a login: the provider URL to send the browser to
+   */
+  async authorizationUrl(redirectUri: string): Promise<string> {
+    const metadata = await this.getMetadata();
+    const state = randomBytes(24).toString('base64url');
+    const nonce = randomBytes(24).toString('base64url');
+    const codeVerifier = randomBytes(48).toString('base64url');
+    const callbackUrl = this.config.redirectUrl ?? redirectUri;
+    this.pending.set(state, {
+      nonce,
+      codeVerifier,
+      redirectUri: callbackUrl,
+      expiresAt: Date.now() + PENDING_TTL_MS,
+    });
+
+    const url = new URL(metadata.authorization_endpoint);
+    url.searchParams.set('response_type', 'code');
+    url.searchParams.set('client_id', this.config.clientId);
+    url.searchParams.set('redirect_uri', callbackUrl);
+    url.searchParams.set('scope', 'openid email profile');
+    url.searchParams.set('state', state);
+    url.searchParams.set('nonce', nonce);
+    url.searchParams.set(
+      'code_challenge',
+      createHash('sha256').update(codeVerifier).digest('base64url')
+    );
+    url.searchParams.set('code_challenge_method', 'S256');
+    return url.toString();
+  }
+
+  /**
+   * Finish a login from the provider's callback and return who signed in
+   */
+  async completeLogin(state: string, code: string): Promise<OidcIdentity> {
+    const pending = this.pending.get(state);
+    this.pending.delete(state);
+    if (!pending || pending.expiresAt < Date.now()) {
+      throw new OidcError('Login expired, please sign in again', 401);
+    }
+
+    const metadata = await this.getMetadata();
+    const tokens = await this.exchangeCode(metadata, code, pending);
+    let claims = await this.verifyIdToken(tokens.id_token, pending.nonce);
+
+    // Providers may leave the email or groups out of the ID token
+    const usernameClaim = this.config.usernameClaim ?? 'email';
+    const groupsClaim = this.config.groupsClaim ?? 'groups';
+    if (
+      (claims[usernameClaim] === undefined || claims[groupsClaim] === undefined) &&
+      metadata.userinfo_endpoint &&
+      tokens.access_token
+    ) {
+      const userInfo = await this.fetchUserInfo(metadata.userinfo_endpoint, tokens.access_token);
+      // The ID token's claims win, and userinfo must describe the same user
+      if (userInfo.sub === claims.sub) {
+        claims = { ...userInfo, ...claims };
+      }
+    }
+
+    return this.toIdentity(claims);
+  }
+
+  destroy(): void {
+    clearInterval(this.cleanupInterval);
+    this.pending.clear();
+  }
+
+  /**
+   * Map verified claims to a VibeTunnel user, applying the allow lists
+   */
+  private toIdentity(claims: Claims): OidcIdentity {
+    const usernameClaim = this.config.usernameClaim ?? 'email';
+    const groupsClaim = this.config.groupsClaim ?? 'groups';
+    const email = typeof claims.email === 'string' ? claims.email.toLowerCase() : undefined;
+    // Unverified addresses could belong to anyone
+    const emailVerified = claims.email_verified !== false && claims.email_verified !== 'false';
+
+    const userId = usernameClaim === 'email' ? email : claims[usernameClaim];
+    if (typeof userId !== 'string' || !userId) {
+      throw new OidcError(`Identity has no ${usernameClaim} claim`, 403);
+    }
+    if (usernameClaim === 'email' && !emailVerified) {
+      throw new OidcError(`Email address ${email} is not verified`, 403);
+    }
+
+    const rawGroups = claims[groupsClaim];
+    const groups = Array.isArray(rawGroups)
+      ? rawGroups.filter((group): group is string => typeof group === 'string')
+      : typeof rawGroups === 'string'
+        ? [rawGroups]
+        : [];
+
+    const identity = { userId, subject: String(claims.sub), email, groups };
+    if (this.isRestricted() && !this.isAllowed(identity, emailVerified)) {
+      logger.warn(`single sign-on denied for ${userId}: not in the allowed groups or emails`);
+      throw new OidcError(`${userId} is not allowed to sign in`, 403);
+    }
+    return identity;
+  }
+
+  private isAllowed(identity: OidcIdentity, emailVerified: boolean): boolean {
+    const { allowedGroups = [], allowedEmails = [] } = this.config;
+    if (identity.groups.some((group) => allowedGroups.includes(group))) {
+      return true;
+    }
+    if (!identity.email || !emailVerified) {
+      return false;
+    }
+    const email = identity.email;
+    return allowedEmails.some((allowed) => {
+      const entry = allowed.toLowerCase();
+      return entry.startsWith('@') ? email.endsWith(entry) : email === entry;
+    });
+  }
+
+  private getMetadata(): Promise<ProviderMetadata> {
+    if (!this.metadata) {
+      this.metadata = this.discover().catch((error) => {
+        // Try again on the next login
+        this.metadata = undefined;
+        throw error;
+      });
+    }
+    return this.metadata;
+  }
+
+  private async discover(): Promise<ProviderMetadata> {
+    const metadata = (await this.fetchJson(
+      `${this.issuer}/.well-known/openid-configuration`
+    )) as ProviderMetadata;
+    if (metadata.issuer?.replace(/\/+$/, '') !== this.issuer) {
+      throw new OidcError(`Provider reports issuer ${metadata.issuer}, expected ${this.issuer}`);
+    }
+    for (const field of ['authorization_endpoint', 'token_endpoint', 'jwks_uri'] as const) {
+      if (typeof metadata[field] !== 'string') {
+        throw new OidcError(`Provider configuration has no ${field}`);
+      }
+    }
+    logger.log(`discovered OpenID provider ${metadata.issuer}`);
+    return metadata;
+  }
+
+  private async exchangeCode(
+    metadata: ProviderMetadata,
+    code: string,
+    pending: PendingAuthorization
+  ): Promise<{ id_token: string; access_token?: string }> {
+    const body = new URLSearchParams({
+      grant_type: 'authorization_code',
+      code,
+      redirect_uri: pending.redirectUri,
+      code_verifier: pending.codeVerifier,
+    });
+    const headers: Record<string, string> = {
+      'Content-Type': 'application/x-www-form-urlencoded',
+      Accept: 'application/json',
+    };
+
+    // client_secret_basic is the default; some providers only take the secret in the body
+    const methods = metadata.token_endpoint_auth_methods_supported ?? [];
+    if (!methods.includes('client_secret_basic') && methods.includes('client_secret_post')) {
+      body.set('client_id', this.config.clientId);
+      body.set('client_secret', this.config.clientSecret);
+    } else {
+      // Both parts are form-encoded before joining (RFC 6749 2.3.1)
+      const credentials = [this.config.clientId, this.config.clientSecret]
+        .map((part) => encodeURIComponent(part))
+        .join(':');
+      headers.Authorization = `Basic ${Buffer.from(credentials).toString('base64')}`;
+    }
+
+    const tokens = (await this.fetchJson(metadata.token_endpoint, {
+      method: 'POST',
+      headers,
+      body: body.toString(),
+    })) as { id_token?: unknown; access_token?: unknown };
+    if (typeof tokens.id_token !== 'string') {
+      throw new OidcError('Provider returned no ID token', 502);
+    }
+    return {
+      id_token: tokens.id_token,
+      access_token: typeof tokens.access_token === 'string' ? tokens.access_token : undefined,
+    };
+  }
+
+  private async verifyIdToken(idToken: string, nonce: string): Promise<Claims> {
+    const decoded = jwt.decode(idToken, { complete: true });
+    if (!decoded || typeof decoded.payload === 'string') {
+      throw new OidcError('ID token is malformed', 502);
+    }
+    const key = await this.getKey(decoded.header.kid);
+    try {
+      return jwt.verify(idToken, key, {
+        algorithms: ID_TOKEN_ALGORITHMS,
+        issuer: [this.issuer, `${this.issuer}/`],
+        audience: this.config.clientId,
+        nonce,
+        clockTolerance: 60,
+      }) as Claims;
+    } catch (error) {
+      throw new OidcError(
+        `ID token rejected: ${error instanceof Error ? error.message : String(error)}`,
+        401
+      );
+    }
+  }
+
+  /**
+   * Provider key for a key ID, refetching the key set when it was rotated
+   */
+  private async getKey(kid: string | undefined): Promise<KeyObject> {
+    const cached = this.findKey(kid);
+    if (cached) return cached;
+
+    if (Date.now() - this.keysFetchedAt > JWKS_REFRESH_MS) {
+      const metadata = await this.getMetadata();
+      const jwks = (await this.fetchJson(metadata.jwks_uri)) as { keys?: JsonWebKey[] };
+      this.keys.clear();
+      for (const jwk of jwks.keys ?? []) {
+        const { kid: keyId, use } = jwk as JsonWebKey & { kid?: string; use?: string };
+        if (use && use !== 'sig') continue;
+        try {
+          this.keys.set(keyId ?? '', createPublicKey({ key: jwk, format: 'jwk' }));
+        } catch (error) {
+          logger.debug(`skipping provider key ${keyId}: ${error}`);
+        }
+      }
+      this.keysFetchedAt = Date.now();
+      logger.debug(`fetched ${this.keys.size} provider keys`);
+    }
+
+    const key = this.findKey(kid);
+    if (!key) {
+      throw new OidcError(`ID token is signed with unknown key ${kid}`, 401);
+    }
+    return key;
+  }
+
+  private findKey(kid: string | undefined): KeyObject | undefined {
+    if (kid !== undefined) return this.keys.get(kid);
+    // Tokens without a key ID are only accepted from providers with a single key
+    return this.keys.size === 1 ? this.keys.values().next().value : undefined;
+  }
+
+  private async fetchUserInfo(endpoint: string, accessToken: string): Promise<Claims> {
+    try {
+      return (await this.fetchJson(endpoint, {
+        headers: { Authorization: `Bearer ${accessToken}`, Accept: 'application/json' },
+      })) as Claims;
+    } catch (error) {
+      logger.warn(`failed to fetch userinfo: ${error instanceof Error ? error.message : error}`);
+      return {};
+    }
+  }
+
+  private async fetchJson(url: string, init: RequestInit = {}): Promise<unknown> {
+    let response: Response;
+    try {
+      response = await fetch(url, { ...init, signal: AbortSignal.timeout(FETCH_TIMEOUT_MS) });
+    } catch (error) {
+      throw new OidcError(
+        `Failed to reach the OpenID provider: ${error instanceof Error ? error.message : error}`,
+        502
+      );
+    }
+    const text = await response.text();
+    if (!response.ok) {
+      throw new OidcError(
+        `OpenID provider returned ${response.status}: ${text.slice(0, 200)}`,
+        502
+      );
+    }
+    try {
+      return JSON.parse(text);
+    } catch {
+      throw new OidcError(`OpenID provider returned invalid JSON from ${url}`, 502);
+    }
+  }
+
+  private cleanupExpired(): void {
+    const now = Date.now();
+    for (const [state, pending] of this.pending) {
+      if (pending.expiresAt < now) {
+        this.pending.delete(state);
+      }
+    }
+  }
+}
@@ -0,0 +1,84 @@
+/**
+ * Output rate caps for stream viewers. 0 disables a cap.
+ */
+export interface OutputThrottleSettings {
+  // Bytes per second sent to one viewer connection
+  viewerBytesPerSecond: number;
+  // Bytes per second sent to all viewers of one session together
+  sessionBytesPerSecond: number;
+  // Bytes that may be sent at once above the rate; 0 means one second's worth
+  burstBytes: number;
+}
+
+export const DEFAULT_OUTPUT_THROTTLE: OutputThrottleSettings = {
+  viewerBytesPerSecond: 0,
+  sessionBytesPerSecond: 0,
+  burstBytes: 0,
+};
+
+/**
+ * Token bucket: `rate` bytes per second, holding at most `capacity` bytes.
+ * Starts full.
+ */
+export class TokenBucket {
+  private tokens: number;
+  private updatedAt: number;
+
+  constructor(
+    readonly rate: number,
+    readonly capacity: number,
+    now = Date.now()
+  ) {
+    this.tokens = capacity;
+    this.updatedAt = now;
+  }
+
+  /**
+   * Take bytes if the bucket holds them all; otherwise take nothing and return false
+   */
+  take(bytes: number, now = Date.now()): boolean {
+    this.refill(now);
+    if (bytes > this.tokens) return false;
+    this.tokens -= bytes;
+    return true;
+  }
+
+  /**
+   * Take bytes that are sent regardless (redraws); the bucket may go into debt
+   */
+  force(bytes: number, now = Date.now()): void {
+    this.refill(now);
+    this.tokens -= bytes;
+  }
+
+  available(now = Date.now()): number {
+    this.refill(now);
+    return Math.floor(this.tokens);
+  }
+
+  /**
+   * Milliseconds until the bucket is full again
+   */
+  msUntilFull(now = Date.now()): number {
+    this.refill(now);
+    return Math.ceil(((this.capacity - this.tokens) / this.rate) * 1000);
+  }
+
+  private refill(now: number): void {
+    const elapsed = Math.max(0, now - this.updatedAt) / 1000;
+    this.tokens = Math.min(this.capacity, this.tokens + elapsed * this.rate);
+    this.updatedAt = now;
+  }
+}
+
+/**
+ * Bucket for a cap, or null when the cap is disabled
+ */
+export function createBucket(
+  bytesPerSecond: number,
+  settings: OutputThrottleSettings,
+  now = Date.now()
+): TokenBucket | null {
+  if (bytesPerSecond <= 0) return null;
+  return new TokenBucket(bytesPerSecond, settings.burstBytes || bytesPerSecond, now);
+}
@@ -0,0 +1,237 @@
+/**
+ * PairingService - connecting mobile clients by scanning a QR code
+ *
+ * A signed-in user starts a pairing in the web UI, which shows a short-lived
+ * code and a QR code of a vibetunnel://pair URL. The mobile client claims the
+ * code, the user approves the device, and the client then collects an API
+ * token scoped to the pairing. Nothing is persisted; pairings expire after
+ * five minutes.
+ */
+
+import chalk from 'chalk';
+import { createHash, randomBytes, randomInt, randomUUID } from 'crypto';
+import { createLogger } from '../utils/logger.js';
+import type { ApiTokenScope, ApiTokenStore } from './api-tokens.js';
+
+const logger = createLogger('pairing');
+
+const PAIRING_TTL_MS = 5 * 60 * 1000;
+// Unambiguous characters (no 0/O, 1/I); 8 of them are 40 bits
+const CODE_ALPHABET = 'ABCDEFGHJKLMNPQRSTUVWXYZ23456789';
+const CODE_LENGTH = 8;
+const MAX_DEVICE_NAME = 64;
+const MAX_PENDING_PER_USER = 5;
+
+export const DEFAULT_PAIRING_SCOPES: ApiTokenScope[] = ['sessions:read', 'sessions:write'];
+
+export type PairingStatus = 'waiting' | 'claimed' | 'approved' | 'denied';
+
+interface Pairing {
+  id: string;
+  code: string;
+  userId: string;
+  scopes: ApiTokenScope[];
+  status: PairingStatus;
+  expiresAt: number;
+  // Set once a device claimed the code
+  device?: { name: string; ip: string; claimedAt: string };
+  // SHA-256 of the secret the device polls with
+  secretHash?: string;
+}
+
+export interface PairingInfo {
+  id: string;
+  code: string;
+  scopes: ApiTokenScope[];
+  status: PairingStatus;
+  expiresAt: string;
+  device?: { name: string; ip: string; claimedAt: string };
+}
+
+export class PairingError extends Error {
+  constructor(
+    message: string,
+    readonly status = 400
+  ) {
+    super(message);
+    this.name = 'PairingError';
+  }
+}
+
+const hashSecret = (secret: string) => createHash('sha256').update(secret).digest('hex');
+
+// Codes are entered by hand too: case, spaces and dashes do not matter
+export const normalizePairingCode = (code: string) =>
+  code.toUpperCase().replace(/[^A-Z0-9]/g, '');
+
+/**
+ * vibetunnel://pair URL the mobile client opens, as encoded in the QR code
+ */
+export function pairingUrl(serverUrl: string, code: string): string {
+  return `vibetunnel://pair?${new URLSearchParams({ server: serverUrl, code })}`;
+}
+
+export class PairingService {
+  private pairings = new Map<string, Pairing>();
+  private cleanupTimer: NodeJS.Timeout;
+
+  constructor(private readonly apiTokens: ApiTokenStore) {
+    this.cleanupTimer = setInterval(() => this.cleanupExpired(), 60000);
+    this.cleanupTimer.unref();
+  }
+
+  /**
+   * Start pairing a device for a user
+   */
+  start(userId: string, scopes: ApiTokenScope[] = DEFAULT_PAIRING_SCOPES): PairingInfo {
+    if (scopes.length === 0) {
+      throw new PairingError('At least one scope is required');
+    }
+    const pending = Array.from(this.pairings.values()).filter((p) => p.userId === userId);
+    if (pending.length >= MAX_PENDING_PER_USER) {
+      throw new PairingError(`At most ${MAX_PENDING_PER_USER} pairings at a time`, 409);
+    }
+
+    let code = '';
+    for (let i = 0; i < CODE_LENGTH; i++) code += CODE_ALPHABET[randomInt(CODE_ALPHABET.length)];
+    const pairing: Pairing = {
+      id: randomUUID(),
+      code,
+      userId,
+      scopes: [...new Set(scopes)],
+      status: 'waiting',
+      expiresAt: Date.now() + PAIRING_TTL_MS,
+    };
+    this.pairings.set(pairing.id, pairing);
+    logger.log(`pairing ${pairing.id} started by ${userId}`);
+    return this.toInfo(pairing);
+  }
+
+  /**
+   * A pairing of the user, for the web UI to follow
+   */
+  get(id: string, userId: string): PairingInfo {
+    return this.toInfo(this.getOwn(id, userId));
+  }
+
+  /**
+   * Claim a code from a device; returns the secret the device polls with
+   */
+  claim(
+    code: string,
+    deviceName: string,
+    ip: string
+  ): { pairingId: string; secret: string; expiresAt: string } {
+    const normalized = normalizePairingCode(code);
+    const pairing = Array.from(this.pairings.values()).find(
+      (candidate) => candidate.code === normalized && candidate.expiresAt > Date.now()
+    );
+    if (!pairing || pairing.status !== 'waiting') {
+      logger.warn(`invalid pairing code from ${ip}`);
+      throw new PairingError('Invalid or expired pairing code', 404);
+    }
+    const name = deviceName.trim().slice(0, MAX_DEVICE_NAME) || 'Unnamed device';
+
+    const secret = randomBytes(32).toString('base64url');
+    pairing.status = 'claimed';
+    pairing.secretHash = hashSecret(secret);
+    pairing.device = { name, ip, claimedAt: new Date().toISOString() };
+    // Approval gets a fresh five minutes
+    pairing.expiresAt = Date.now() + PAIRING_TTL_MS;
+    logger.log(
+      chalk.yellow(
+        `device "${name}" (${ip}) wants to pair as ${pairing.userId}; ` +
+          'approve it in the web UI settings'
+      )
+    );
+    return { pairingId: pairing.id, secret, expiresAt: new Date(pairing.expiresAt).toISOString() };
+  }
+
+  approve(id: string, userId: string): PairingInfo {
+    return this.decide(id, userId, 'approved');
+  }
+
+  deny(id: string, userId: string): PairingInfo {
+    return this.decide(id, userId, 'denied');
+  }
+
+  cancel(id: string, userId: string): void {
+    this.getOwn(id, userId);
+    this.pairings.delete(id);
+  }
+
+  /**
+   * Status of a claimed pairing for the device. Once approved, the API token
+   * is created and returned, only this once.
+   */
+  poll(
+    id: string,
+    secret: string
+  ): { status: PairingStatus; token?: string; userId?: string; scopes?: ApiTokenScope[] } {
+    const pairing = this.pairings.get(id);
+    if (
+      !pairing ||
+      pairing.expiresAt <= Date.now() ||
+      !pairing.secretHash ||
+      pairing.secretHash !== hashSecret(secret)
+    ) {
+      throw new PairingError('Invalid or expired pairing', 404);
+    }
+    if (pairing.status === 'denied') {
+      this.pairings.delete(id);
+      return { status: 'denied' };
+    }
+    if (pairing.status !== 'approved') {
+      return { status: pairing.status };
+    }
+
+    this.pairings.delete(id);
+    const { token } = this.apiTokens.create(pairing.userId, {
+      name: `${pairing.device?.name} (paired)`.slice(0, 64),
+      scopes: pairing.scopes,
+    });
+    logger.log(chalk.green(`device "${pairing.device?.name}" paired as ${pairing.userId}`));
+    return { status: 'approved', token, userId: pairing.userId, scopes: pairing.scopes };
+  }
+
+  destroy(): void {
+    clearInterval(this.cleanupTimer);
+    this.pairings.clear();
+  }
+
+  private decide(id: string, userId: string, status: 'approved' | 'denied'): PairingInfo {
+    const pairing = this.getOwn(id, userId);
+    if (pairing.status !== 'claimed') {
+      throw new PairingError('No device is waiting for approval', 409);
+    }
+    pairing.status = status;
+    logger.log(`pairing of "${pairing.device?.name}" ${status} by ${userId}`);
+    return this.toInfo(pairing);
+  }
+
+  private getOwn(id: string, userId: string): Pairing {
+    const pairing = this.pairings.get(id);
+    if (!pairing || pairing.userId !== userId || pairing.expiresAt <= Date.now()) {
+      throw new PairingError('Pairing not found or expired', 404);
+    }
+    return pairing;
+  }
+
+  private toInfo(pairing: Pairing): PairingInfo {
+    return {
+      id: pairing.id,
+      code: pairing.code,
+      scopes: [...pairing.scopes],
+      status: pairing.status,
+      expiresAt: new Date(pairing.expiresAt).toISOString(),
+      ...(pairing.device && { device: { ...pairing.device } }),
+    };
+  }
+
+  private cleanupExpired(): void {
+    const now = Date.now();
+    for (const [id, pairing] of this.pairings) {
+      if (pairing.expiresAt <= now) this.pairings.delete(id);
+    }
+  }
+}
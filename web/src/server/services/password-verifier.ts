@@ -0,0 +1,145 @@
+/**
+ * Password backends for system account logins
+ *
+ * - pam: the host's PAM stack through the authenticate-pam native module (default)
+ * - shadow: SHA-crypt hashes in /etc/shadow, for hosts without the PAM module;
+ *   the server must be able to read /etc/shadow (i.e. run as root)
+ * - helper: an external program in the style of pwauth / mod_authnz_external,
+ *   usually setuid, that gets the username and password on two lines of its
+ *   standard input and exits with 0 when they are valid
+ */
+
+import { spawn } from 'child_process';
+import * as fs from 'fs';
+import { createLogger } from '../utils/logger.js';
+import { verifyCryptHash } from '../utils/sha-crypt.js';
+import { authenticate as pamAuthenticate } from './authenticate-pam-loader.js';
+
+const logger = createLogger('password-verifier');
+
+export const PASSWORD_BACKENDS = ['pam', 'shadow', 'helper'] as const;
+export type PasswordBackend = (typeof PASSWORD_BACKENDS)[number];
+
+export type PasswordVerifier = (username: string, password: string) => Promise<boolean>;
+
+const SHADOW_FILE = '/etc/shadow';
+const HELPER_TIMEOUT_MS = 10000;
+// Longer passwords are rejected without hashing them
+const MAX_PASSWORD_LENGTH = 4096;
+
+export function isPasswordBackend(value: string): value is PasswordBackend {
+  return (PASSWORD_BACKENDS as readonly string[]).includes(value);
+}
+
+export function verifyWithPam(username: string, password: string): Promise<boolean> {
+  return new Promise((resolve) => {
+    pamAuthenticate(username, password, (err: Error | null) => {
+      if (err) {
+        logger.warn(`pam authentication failed for ${username}: ${err.message}`);
+        resolve(false);
+      } else {
+        resolve(true);
+      }
+    });
+  });
+}
+
+/**
+ * Check a password against the user's /etc/shadow entry
+ */
+export async function verifyWithShadow(
+  username: string,
+  password: string,
+  shadowFile = SHADOW_FILE
+): Promise<boolean> {
+  if (password.length > MAX_PASSWORD_LENGTH) return false;
+
+  // name:hash:lastchange:min:max:warn:inactive:expire:reserved
+  const content = await fs.promises.readFile(shadowFile, 'utf8');
+  const fields = content
+    .split('\n')
+    .map((line) => line.split(':'))
+    .find((entry) => entry[0] === username);
+  if (!fields || fields.length < 2) return false;
+
+  // Account expiry, in days since the epoch
+  const expire = Number.parseInt(fields[7] ?? '', 10);
+  if (!Number.isNaN(expire) && expire * 86400000 < Date.now()) {
+    logger.warn(`account ${username} has expired`);
+    return false;
+  }
+
+  // Locked (!...) and password-less (* or empty) accounts cannot log in with a password
+  const hash = fields[1];
+  if (!hash.startsWith('$')) return false;
+
+  const valid = verifyCryptHash(password, hash);
+  if (valid === null) {
+    logger.error(
+      `password hash of ${username} uses an unsupported scheme (${hash.split('$')[1]}); ` +
+        'use the pam or helper password backend'
+    );
+    return false;
+  }
+  return valid;
+}
+
+/**
+ * Check a password with an external helper program
+ */
+export function verifyWithHelper(
+  helper: string,
+  username: string,
+  password: string
+): Promise<boolean> {
+  if (password.length > MAX_PASSWORD_LENGTH || /[\r\n]/.test(username + password)) {
+    return Promise.resolve(false);
+  }
+
+  return new Promise((resolve) => {
+    const child = spawn(helper, [], { stdio: ['pipe', 'ignore', 'pipe'] });
+    let stderr = '';
+    const timeout = setTimeout(() => {
+      logger.error(`password helper timed out checking ${username}`);
+      child.kill('SIGKILL');
+    }, HELPER_TIMEOUT_MS);
+
+    child.stderr.on('data', (chunk: Buffer) => {
+      stderr += chunk.toString();
+    });
+    child.on('error', (error) => {
+      clearTimeout(timeout);
+      logger.error(`failed to run password helper ${helper}:`, error);
+      resolve(false);
+    });
+    child.on('close', (code) => {
+      clearTimeout(timeout);
+      if (code !== 0) {
+        logger.warn(`password helper rejected ${username} (exit ${code}) ${stderr.trim()}`);
+      }
+      resolve(code === 0);
+    });
+    child.stdin.on('error', () => {});
+    child.stdin.end(`${username}\n${password}\n`);
+  });
+}
+
+/**
+ * The verifier of a password backend
+ */
+export function createPasswordVerifier(
+  backend: PasswordBackend = 'pam',
+  helper?: string
+): PasswordVerifier {
+  switch (backend) {
+    case 'shadow':
+      return (username, password) => verifyWithShadow(username, password);
+    case 'helper':
+      if (!helper) {
+        throw new Error('The helper password backend needs a helper program');
+      }
+      return (username, password) => verifyWithHelper(helper, username, password);
+    default:
+      return verifyWithPam;
+  }
+}
@@ -0,0 +1,192 @@
+/**
+ * Plain-text stream - Newly printed lines of a session, without escape sequences
+ *
+ * For screen readers and clients too slow to run a terminal emulator. The
+ * transform sits between the stream watcher and an SSE response: it reads the
+ * cast events the watcher writes, keeps only output, strips escape sequences,
+ * applies carriage returns and backspaces, and sends the lines printed within
+ * a short window as one event:
+ *
+ *   id: 18311
+ *   data: {"lines":["total 8","-rw-r--r-- 1 me staff 42 README.md"]}
+ *
+ *   event: exit
+ *   data: {"exitCode":0}
+ *
+ * A line still being printed when the window ends (a prompt, a progress bar)
+ * is sent as it stands; once it is finished only what was added since is sent.
+ * Event IDs are stream file offsets like those of the cast stream, so clients
+ * resume with Last-Event-ID. Full-screen programs redraw with cursor movement
+ * and come out as fragments; this mode is meant for line-oriented output.
+ */
+
+import { Transform, type TransformCallback } from 'stream';
+import { stripEscapeSequences } from '../pty/shell-integration.js';
+
+// Lines printed within this window are sent together
+export const DEFAULT_COALESCE_MS = 250;
+// ...unless this many are waiting
+const MAX_PENDING_LINES = 100;
+
+// Erase in line (to its end, or all of it), as used when redrawing a line
+const ERASE_IN_LINE = /(\x1b\[[02]?K)/;
+
+/**
+ * Lines of plain text from terminal output: carriage returns move back to the
+ * start of the line, so a redrawn line keeps only what is left on screen
+ */
+export class PlainTextLines {
+  private line: string[] = [];
+  private column = 0;
+
+  /**
+   * Feed output and get the lines it finished
+   */
+  feed(output: string): string[] {
+    const finished: string[] = [];
+    for (const part of output.split(ERASE_IN_LINE)) {
+      if (ERASE_IN_LINE.test(part)) {
+        this.line = part === '\x1b[2K' ? [] : this.line.slice(0, this.column);
+      } else {
+        this.feedText(stripEscapeSequences(part), finished);
+      }
+    }
+    return finished;
+  }
+
+  private feedText(text: string, finished: string[]): void {
+    for (const char of text) {
+      if (char === '\n') {
+        finished.push(this.current());
+        this.line = [];
+        this.column = 0;
+      } else if (char === '\r') {
+        this.column = 0;
+      } else if (char === '\b') {
+        this.column = Math.max(0, this.column - 1);
+      } else if (char === '\t' || char >= ' ') {
+        this.line[this.column++] = char === '\t' ? ' ' : char;
+      }
+    }
+  }
+
+  /**
+   * The line being printed
+   */
+  current(): string {
+    return Array.from(this.line, (char) => char ?? ' ')
+      .join('')
+      .trimEnd();
+  }
+}
+
+export class PlainTextTransform extends Transform {
+  private events = '';
+  private lines = new PlainTextLines();
+  private pending: string[] = [];
+  // What was sent of the line still being printed
+  private sentPartial = '';
+  // Stream offset of the last cast event read
+  private offset: number | null = null;
+  private timer: NodeJS.Timeout | null = null;
+
+  constructor(private coalesceMs = DEFAULT_COALESCE_MS) {
+    super();
+  }
+
+  _transform(
+    chunk: Buffer | string,
+    _encoding: BufferEncoding,
+    callback: TransformCallback
+  ): void {
+    this.events += chunk.toString();
+    const events = this.events.split('\n\n');
+    this.events = events.pop() ?? '';
+    for (const event of events) {
+      this.handleEvent(event);
+    }
+    callback();
+  }
+
+  _flush(callback: TransformCallback): void {
+    this.sendLines();
+    callback();
+  }
+
+  _destroy(error: Error | null, callback: (error: Error | null) => void): void {
+    if (this.timer) clearTimeout(this.timer);
+    callback(error);
+  }
+
+  /**
+   * Handle an SSE event of the cast stream
+   */
+  private handleEvent(event: string): void {
+    let data: string | null = null;
+    for (const field of event.split('\n')) {
+      if (field.startsWith('id: ')) {
+        const offset = Number(field.slice(4));
+        if (Number.isInteger(offset)) this.offset = offset;
+      } else if (field.startsWith('data: ')) {
+        data = field.slice(6);
+      }
+    }
+    if (data === null) return;
+
+    let parsed: unknown;
+    try {
+      parsed = JSON.parse(data);
+    } catch {
+      return;
+    }
+    // Headers, markers, resizes and input carry no printed text
+    if (!Array.isArray(parsed) || parsed.length < 3) return;
+
+    if (parsed[0] === 'exit') {
+      this.sendLines();
+      this.push(`event: exit\ndata: ${JSON.stringify({ exitCode: parsed[1] })}\n\n`);
+      return;
+    }
+    if (parsed[1] !== 'o' || typeof parsed[2] !== 'string') return;
+
+    for (const line of this.lines.feed(parsed[2])) {
+      // Only the rest of a line that was sent while being printed
+      const text =
+        this.sentPartial && line.startsWith(this.sentPartial)
+          ? line.slice(this.sentPartial.length).trim()
+          : line;
+      this.sentPartial = '';
+      if (text.trim()) this.pending.push(text);
+    }
+
+    if (this.pending.length >= MAX_PENDING_LINES) {
+      this.sendLines();
+    } else if (!this.timer) {
+      this.timer = setTimeout(() => this.sendLines(), this.coalesceMs);
+    }
+  }
+
+  /**
+   * Send the finished lines and the line being printed, if it changed
+   */
+  private sendLines(): void {
+    if (this.timer) {
+      clearTimeout(this.timer);
+      this.timer = null;
+    }
+
+    const partial = this.lines.current();
+    if (partial.trim() && partial !== this.sentPartial) {
+      const text = partial.startsWith(this.sentPartial)
+        ? partial.slice(this.sentPartial.length).trim()
+        : partial;
+      if (text) this.pending.push(text);
+      this.sentPartial = partial;
+    }
+    if (!this.pending.length) return;
+
+    const id = this.offset !== null ? `id: ${this.offset}\n` : '';
+    this.push(`${id}data: ${JSON.stringify({ lines: this.pending })}\n\n`);
+    this.pending = [];
+  }
+}
@@ -0,0 +1,213 @@
+import { execFile } from 'child_process';
+import * as fs from 'fs';
+import { promisify } from 'util';
+import { gunzip, gzip } from 'zlib';
+import type { SessionArchive } from '../../shared/types.js';
+import type { PtyManager } from '../pty/index.js';
+import { createLogger } from '../utils/logger.js';
+import { S3Client, type S3Credentials } from '../utils/s3-client.js';
+
+const logger = createLogger('archiver');
+
+const gzipAsync = promisify(gzip);
+const gunzipAsync = promisify(gunzip);
+
+// Hooks taking longer are killed
+const HOOK_TIMEOUT_MS = 30000;
+
+export interface ArchiveConfig {
+  bucket: string;
+  // Key prefix, e.g. "recordings/"
+  prefix: string;
+  region: string;
+  credentials: S3Credentials;
+  // S3-compatible endpoint (MinIO, R2, ...); AWS S3 if unset
+  endpoint?: string;
+  compress: boolean;
+  // Remove the local cast file once it is uploaded
+  deleteLocal: boolean;
+  // Shell command run after each archive, restore or failure
+  hook?: string;
+}
+
+export type ArchiveEvent = 'archived' | 'restored' | 'failed';
+
+export class ArchiveError extends Error {
+  constructor(
+    message: string,
+    public status: number
+  ) {
+    super(message);
+    this.name = 'ArchiveError';
+  }
+}
+
+/**
+ * Bucket and key prefix of an s3://bucket/prefix URL
+ */
+export function parseArchiveUrl(value: string): { bucket: string; prefix: string } {
+  const match = value.match(/^s3:\/\/([^/]+)\/?(.*)$/);
+  if (!match) {
+    throw new Error(`Archive location must be s3://bucket[/prefix], got ${value}`);
+  }
+  const prefix = match[2] && !match[2].endsWith('/') ? `${match[2]}/` : match[2];
+  return { bucket: match[1], prefix };
+}
+
+/**
+ * RecordingArchiver - Uploads finished recordings to S3-compatible storage
+ *
+ * Cast files of sessions are uploaded (gzip-compressed if configured) as
+ * `<prefix><session id>.cast[.gz]` once the session has exited, and the
+ * session info records the archive. With deleteLocal the local cast file is
+ * removed after the upload and downloaded again when the recording is played.
+ */
+export class RecordingArchiver {
+  private client: S3Client;
+  // Uploads and downloads in progress, so the same session is not handled twice at once
+  private inFlight = new Map<string, Promise<SessionArchive>>();
+
+  constructor(
+    private ptyManager: PtyManager,
+    private config: ArchiveConfig
+  ) {
+    this.client = new S3Client({
+      bucket: config.bucket,
+      region: config.region,
+      credentials: config.credentials,
+      endpoint: config.endpoint,
+    });
+  }
+
+  /**
+   * Where recordings go, for logs
+   */
+  describe(): string {
+    return `s3://${this.config.bucket}/${this.config.prefix}`;
+  }
+
+  /**
+   * Upload the recording of an exited session. Throws ArchiveError if it
+   * cannot be archived.
+   */
+  archive(sessionId: string): Promise<SessionArchive> {
+    return this.once(sessionId, () => this.upload(sessionId));
+  }
+
+  /**
+   * Download an archived recording whose local cast file was removed
+   */
+  restore(sessionId: string): Promise<SessionArchive> {
+    return this.once(sessionId, () => this.download(sessionId));
+  }
+
+  private once(
+    sessionId: string,
+    operation: () => Promise<SessionArchive>
+  ): Promise<SessionArchive> {
+    const pending = this.inFlight.get(sessionId);
+    if (pending) return pending;
+    const promise = operation().finally(() => this.inFlight.delete(sessionId));
+    this.inFlight.set(sessionId, promise);
+    return promise;
+  }
+
+  private async upload(sessionId: string): Promise<SessionArchive> {
+    const session = this.ptyManager.getSession(sessionId);
+    const paths = this.ptyManager.getSessionPaths(sessionId);
+    if (!session || !paths) {
+      throw new ArchiveError('Session not found', 404);
+    }
+    if (session.status !== 'exited') {
+      throw new ArchiveError('Session is still running', 409);
+    }
+    if (session.archive?.localRemoved) {
+      return session.archive;
+    }
+
+    try {
+      const cast = await fs.promises.readFile(paths.stdoutPath);
+      const body = this.config.compress ? await gzipAsync(cast) : cast;
+      const key = `${this.config.prefix}${sessionId}.cast${this.config.compress ? '.gz' : ''}`;
+      await this.client.putObject(key, body, {
+        'content-type': this.config.compress ? 'application/gzip' : 'application/x-asciicast',
+        // Metadata values must be ASCII
+        'x-amz-meta-session-name': encodeURIComponent(session.name),
+      });
+
+      const archive: SessionArchive = {
+        bucket: this.config.bucket,
+        key,
+        size: body.length,
+        compressed: this.config.compress,
+        archivedAt: new Date().toISOString(),
+        localRemoved: false,
+      };
+      if (this.config.deleteLocal) {
+        await fs.promises.unlink(paths.stdoutPath);
+        archive.localRemoved = true;
+      }
+      this.ptyManager.setSessionArchive(sessionId, archive);
+      logger.log(`archived session ${sessionId} to s3://${archive.bucket}/${key}`);
+      this.runHook('archived', sessionId, archive);
+      return archive;
+    } catch (error) {
+      logger.error(`failed to archive session ${sessionId}:`, error);
+      this.runHook('failed', sessionId, session.archive);
+      throw error;
+    }
+  }
+
+  private async download(sessionId: string): Promise<SessionArchive> {
+    const archive = this.ptyManager.getSession(sessionId)?.archive;
+    const paths = this.ptyManager.getSessionPaths(sessionId);
+    if (!archive || !paths) {
+      throw new ArchiveError('Session is not archived', 404);
+    }
+    if (!archive.localRemoved && fs.existsSync(paths.stdoutPath)) {
+      return archive;
+    }
+
+    try {
+      const body = await this.client.getObject(archive.key);
+      const cast = archive.compressed ? await gunzipAsync(body) : body;
+      // Written next to the final path first so readers never see a partial file
+      const tempPath = `${paths.stdoutPath}.restore`;
+      await fs.promises.writeFile(tempPath, cast);
+      await fs.promises.rename(tempPath, paths.stdoutPath);
+
+      const restored = { ...archive, localRemoved: false };
+      this.ptyManager.setSessionArchive(sessionId, restored);
+      logger.log(`restored session ${sessionId} from s3://${archive.bucket}/${archive.key}`);
+      this.runHook('restored', sessionId, restored);
+      return restored;
+    } catch (error) {
+      logger.error(`failed to restore session ${sessionId}:`, error);
+      this.runHook('failed', sessionId, archive);
+      throw error;
+    }
+  }
+
+  /**
+   * Run the configured hook with the event in its environment
+   */
+  private runHook(event: ArchiveEvent, sessionId: string, archive?: SessionArchive): void {
+    if (!this.config.hook) return;
+    const env = {
+      ...process.env,
+      VIBETUNNEL_ARCHIVE_EVENT: event,
+      VIBETUNNEL_SESSION_ID: sessionId,
+      VIBETUNNEL_ARCHIVE_URL: archive ? `s3://${archive.bucket}/${archive.key}` : '',
+    };
+    execFile(
+      process.env.SHELL || '/bin/sh',
+      ['-c', this.config.hook],
+      { env, timeout: HOOK_TIMEOUT_MS },
+      (error, _stdout, stderr) => {
+        if (error) {
+          logger.warn(`archive hook failed for ${event} of session ${sessionId}: ${stderr}`);
+        }
+      }
+    );
+  }
+}
@@ -0,0 +1,336 @@
+/**
+ * RecordingDiff - Compares the plain-text output of two session recordings
+ *
+ * Cast files are reduced to the text their output leaves on screen, line by
+ * line (escape sequences removed, carriage-return redraws collapsed). When
+ * both recordings have shell integration marks the commands are lined up by
+ * their command lines and the output of each pair is diffed; otherwise the
+ * whole output is. Diffs use Myers' algorithm and are returned as hunks with
+ * a few lines of unchanged context, like `diff -u`.
+ */
+
+import * as fs from 'fs';
+import * as readline from 'readline';
+import {
+  COMMAND_DONE_MARKER,
+  COMMAND_MARKER,
+  PROMPT_MARKER,
+  visibleLineText,
+} from '../pty/index.js';
+import { decodeCastLine } from '../utils/recording-crypto.js';
+
+// Lines read per recording; the rest is left out of the comparison
+export const MAX_RECORDING_LINES = 20000;
+// Diffs needing more edits are reported as a complete replacement
+const MAX_EDIT_DISTANCE = 2000;
+export const DEFAULT_CONTEXT_LINES = 3;
+
+export interface RecordedCommand {
+  command: string;
+  // Seconds into the recording
+  time: number;
+  exitCode: number | null;
+  lines: string[];
+}
+
+export interface RecordingText {
+  // All output lines
+  lines: string[];
+  // Commands from shell integration marks, with the output of each
+  commands: RecordedCommand[];
+  // More than MAX_RECORDING_LINES lines of output
+  truncated: boolean;
+}
+
+export interface DiffLine {
+  type: 'equal' | 'removed' | 'added';
+  text: string;
+}
+
+export interface DiffHunk {
+  // 1-based first line and line count on each side
+  leftStart: number;
+  leftLines: number;
+  rightStart: number;
+  rightLines: number;
+  lines: DiffLine[];
+}
+
+export interface CommandComparison {
+  command: string;
+  // same/changed: ran in both recordings; removed: only left; added: only right
+  status: 'same' | 'changed' | 'removed' | 'added';
+  left: { time: number; exitCode: number | null; lines: number } | null;
+  right: { time: number; exitCode: number | null; lines: number } | null;
+  hunks: DiffHunk[];
+}
+
+export interface RecordingComparison {
+  // 'commands' when both recordings have shell integration marks
+  mode: 'commands' | 'output';
+  identical: boolean;
+  truncated: boolean;
+  // Output diff in 'output' mode
+  hunks?: DiffHunk[];
+  commands?: CommandComparison[];
+}
+
+/**
+ * Split output into the lines it leaves on screen
+ */
+function outputLines(output: string): string[] {
+  const lines = output.split('\n').map((line) => visibleLineText(line).trimEnd());
+  while (lines.length > 0 && !lines[lines.length - 1]) lines.pop();
+  return lines;
+}
+
+/**
+ * Read the output of a cast file as text, split by command if it has marks
+ */
+export async function readRecordingText(castPath: string): Promise<RecordingText> {
+  let output = '';
+  const commands: { entry: RecordedCommand; output: string }[] = [];
+  let open: { entry: RecordedCommand; output: string } | null = null;
+
+  const lines = readline.createInterface({
+    input: fs.createReadStream(castPath, 'utf8'),
+    crlfDelay: Number.POSITIVE_INFINITY,
+  });
+  for await (const line of lines) {
+    let event: unknown;
+    try {
+      event = JSON.parse(decodeCastLine(line));
+    } catch {
+      continue;
+    }
+    if (!Array.isArray(event) || typeof event[2] !== 'string') continue;
+    const [time, type, data] = event as [number, string, string];
+
+    if (type === 'o') {
+      output += data;
+      if (open) open.output += data;
+    } else if (type === 'm' && data.startsWith(`${COMMAND_MARKER} `)) {
+      open = {
+        entry: { command: data.slice(COMMAND_MARKER.length + 1), time, exitCode: null, lines: [] },
+        output: '',
+      };
+      commands.push(open);
+    } else if (type === 'm' && open && data.split(' ')[0] === COMMAND_DONE_MARKER) {
+      const exitCode = data.slice(COMMAND_DONE_MARKER.length + 1);
+      open.entry.exitCode = exitCode ? Number(exitCode) : null;
+      open = null;
+    } else if (type === 'm' && data === PROMPT_MARKER) {
+      // The prompt and the next command line are not part of any command's output
+      open = null;
+    }
+  }
+
+  const allLines = outputLines(output);
+  return {
+    lines: allLines.slice(0, MAX_RECORDING_LINES),
+    commands: commands.map(({ entry, output }) => ({
+      ...entry,
+      lines: outputLines(output).slice(0, MAX_RECORDING_LINES),
+    })),
+    truncated: allLines.length > MAX_RECORDING_LINES,
+  };
+}
+
+/**
+ * Edit script turning `left` into `right` (Myers' O(ND) algorithm), or null
+ * if it needs more than `maxEdits` insertions and deletions
+ */
+export function diffSequences<T>(
+  left: T[],
+  right: T[],
+  maxEdits: number
+): DiffLine['type'][] | null {
+  const n = left.length;
+  const m = right.length;
+  const max = Math.min(n + m, maxEdits);
+  // Furthest x reached on each diagonal k = x - y, at index k + offset
+  const offset = max + 1;
+  const v = new Int32Array(2 * max + 3);
+  // v before each round d, for diagonals -d-1..d+1
+  const trace: Int32Array[] = [];
+
+  let found = false;
+  for (let d = 0; d <= max && !found; d++) {
+    trace.push(v.slice(offset - d - 1, offset + d + 2));
+    for (let k = -d; k <= d; k += 2) {
+      let x =
+        k === -d || (k !== d && v[offset + k - 1] < v[offset + k + 1])
+          ? v[offset + k + 1]
+          : v[offset + k - 1] + 1;
+      let y = x - k;
+      while (x < n && y < m && left[x] === right[y]) {
+        x++;
+        y++;
+      }
+      v[offset + k] = x;
+      if (x >= n && y >= m) {
+        found = true;
+        break;
+      }
+    }
+  }
+  if (!found) return null;
+
+  // Walk back from the end through the rounds
+  const ops: DiffLine['type'][] = [];
+  let x = n;
+  let y = m;
+  for (let d = trace.length - 1; d >= 0; d--) {
+    const previous = trace[d];
+    const at = (k: number) => previous[k + d + 1];
+    const k = x - y;
+    const previousK = k === -d || (k !== d && at(k - 1) < at(k + 1)) ? k + 1 : k - 1;
+    const previousX = at(previousK);
+    const previousY = previousX - previousK;
+    while (x > previousX && y > previousY) {
+      ops.push('equal');
+      x--;
+      y--;
+    }
+    if (d > 0) {
+      if (x === previousX) {
+        ops.push('added');
+        y--;
+      } else {
+        ops.push('removed');
+        x--;
+      }
+    }
+  }
+  return ops.reverse();
+}
+
+/**
+ * Diff two lists of lines into hunks with `context` unchanged lines around changes
+ */
+export function diffLines(
+  left: string[],
+  right: string[],
+  context = DEFAULT_CONTEXT_LINES
+): DiffHunk[] {
+  const ops = diffSequences(left, right, MAX_EDIT_DISTANCE) ?? [
+    ...left.map(() => 'removed' as const),
+    ...right.map(() => 'added' as const),
+  ];
+
+  // Every line with its position on both sides
+  const lines: (DiffLine & { leftIndex: number; rightIndex: number })[] = [];
+  let leftIndex = 0;
+  let rightIndex = 0;
+  for (const type of ops) {
+    const text = type === 'added' ? right[rightIndex] : left[leftIndex];
+    lines.push({ type, text, leftIndex, rightIndex });
+    if (type !== 'added') leftIndex++;
+    if (type !== 'removed') rightIndex++;
+  }
+
+  const hunks: DiffHunk[] = [];
+  let index = 0;
+  while (index < lines.length) {
+    const firstChange = lines.findIndex((line, i) => i >= index && line.type !== 'equal');
+    if (firstChange === -1) break;
+
+    // Extend the hunk while changes are at most 2 * context lines apart
+    let end = firstChange;
+    let unchanged = 0;
+    for (let i = firstChange; i < lines.length && unchanged <= 2 * context; i++) {
+      if (lines[i].type === 'equal') {
+        unchanged++;
+      } else {
+        unchanged = 0;
+        end = i;
+      }
+    }
+
+    const start = Math.max(index, firstChange - context);
+    const hunkLines = lines.slice(start, Math.min(lines.length, end + context + 1));
+    hunks.push({
+      leftStart: hunkLines[0].leftIndex + 1,
+      leftLines: hunkLines.filter((line) => line.type !== 'added').length,
+      rightStart: hunkLines[0].rightIndex + 1,
+      rightLines: hunkLines.filter((line) => line.type !== 'removed').length,
+      lines: hunkLines.map(({ type, text }) => ({ type, text })),
+    });
+    index = end + context + 1;
+  }
+  return hunks;
+}
+
+function commandSummary(command: RecordedCommand) {
+  return { time: command.time, exitCode: command.exitCode, lines: command.lines.length };
+}
+
+/**
+ * Compare two recordings, per command if both have shell integration marks
+ */
+export function compareRecordings(
+  left: RecordingText,
+  right: RecordingText,
+  context = DEFAULT_CONTEXT_LINES
+): RecordingComparison {
+  const truncated = left.truncated || right.truncated;
+  if (left.commands.length === 0 || right.commands.length === 0) {
+    const hunks = diffLines(left.lines, right.lines, context);
+    return { mode: 'output', identical: hunks.length === 0, truncated, hunks };
+  }
+
+  // Line the commands up by their command lines
+  const ops =
+    diffSequences(
+      left.commands.map((command) => command.command),
+      right.commands.map((command) => command.command),
+      MAX_EDIT_DISTANCE
+    ) ?? [
+      ...left.commands.map(() => 'removed' as const),
+      ...right.commands.map(() => 'added' as const),
+    ];
+
+  const commands: CommandComparison[] = [];
+  let leftIndex = 0;
+  let rightIndex = 0;
+  for (const type of ops) {
+    if (type === 'removed') {
+      const command = left.commands[leftIndex++];
+      commands.push({
+        command: command.command,
+        status: 'removed',
+        left: commandSummary(command),
+        right: null,
+        hunks: diffLines(command.lines, [], context),
+      });
+    } else if (type === 'added') {
+      const command = right.commands[rightIndex++];
+      commands.push({
+        command: command.command,
+        status: 'added',
+        left: null,
+        right: commandSummary(command),
+        hunks: diffLines([], command.lines, context),
+      });
+    } else {
+      const leftCommand = left.commands[leftIndex++];
+      const rightCommand = right.commands[rightIndex++];
+      const hunks = diffLines(leftCommand.lines, rightCommand.lines, context);
+      const same = hunks.length === 0 && leftCommand.exitCode === rightCommand.exitCode;
+      commands.push({
+        command: leftCommand.command,
+        status: same ? 'same' : 'changed',
+        left: commandSummary(leftCommand),
+        right: commandSummary(rightCommand),
+        hunks,
+      });
+    }
+  }
+
+  return {
+    mode: 'commands',
+    identical: commands.every((command) => command.status === 'same'),
+    truncated,
+    commands,
+  };
+}
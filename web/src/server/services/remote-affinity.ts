@@ -0,0 +1,119 @@
+import type { RemoteServer } from './remote-registry.js';
+
+// Remote tags name capabilities of a machine (gpu, arm64, ...)
+export const REMOTE_TAG_PATTERN = /^[A-Za-z0-9._:-]{1,64}$/;
+export const MAX_REMOTE_TAGS = 20;
+
+export const AFFINITY_FALLBACKS = ['fail', 'local', 'any'] as const;
+export type AffinityFallback = (typeof AFFINITY_FALLBACKS)[number];
+
+/**
+ * Remotes the sessions of a workspace are pinned to
+ */
+export interface RemoteAffinity {
+  // Remotes by name, in order of preference
+  remotes?: string[];
+  // Remotes with any of these tags, after the named ones; the least busy first
+  tags?: string[];
+  // When none of them is registered: refuse ('fail', the default), create the
+  // session on the HQ itself ('local') or on the least busy remote ('any')
+  fallback?: AffinityFallback;
+}
+
+export type Placement =
+  | { remote?: RemoteServer }
+  | { error: string; code: 'INVALID_REQUEST' | 'UNAVAILABLE' };
+
+const isStringArray = (value: unknown): value is string[] =>
+  Array.isArray(value) && value.every((item) => typeof item === 'string');
+
+/**
+ * Tags of a registering remote; returns an error message or null
+ */
+export function validateRemoteTags(value: unknown): string | null {
+  if (
+    !isStringArray(value) ||
+    value.length > MAX_REMOTE_TAGS ||
+    !value.every((tag) => REMOTE_TAG_PATTERN.test(tag))
+  ) {
+    return `tags must be an array of at most ${MAX_REMOTE_TAGS} names ([A-Za-z0-9._:-])`;
+  }
+  return null;
+}
+
+/**
+ * Validate an affinity; returns an error message or null
+ */
+export function validateAffinity(value: unknown): string | null {
+  if (!value || typeof value !== 'object' || Array.isArray(value)) {
+    return 'affinity must be { remotes?, tags?, fallback? }';
+  }
+  const { remotes, tags, fallback } = value as Record<string, unknown>;
+  if (remotes !== undefined && (!isStringArray(remotes) || remotes.some((name) => !name))) {
+    return 'affinity remotes must be an array of remote names';
+  }
+  if (tags !== undefined && validateRemoteTags(tags)) {
+    return `affinity ${validateRemoteTags(tags)}`;
+  }
+  if (!remotes?.length && !tags?.length) {
+    return 'affinity needs remotes or tags';
+  }
+  if (fallback !== undefined && !AFFINITY_FALLBACKS.includes(fallback as AffinityFallback)) {
+    return `affinity fallback must be one of ${AFFINITY_FALLBACKS.join(', ')}`;
+  }
+  return null;
+}
+
+const byLoad = (a: RemoteServer, b: RemoteServer) => a.sessionIds.size - b.sessionIds.size;
+
+/**
+ * Registered remotes matching an affinity, in order of preference
+ */
+export function matchingRemotes(affinity: RemoteAffinity, remotes: RemoteServer[]): RemoteServer[] {
+  const named = (affinity.remotes ?? []).flatMap((name) => {
+    const remote = remotes.find((candidate) => candidate.name === name);
+    return remote ? [remote] : [];
+  });
+  const tagged = remotes
+    .filter(
+      (remote) =>
+        !named.includes(remote) && remote.tags.some((tag) => affinity.tags?.includes(tag))
+    )
+    .sort(byLoad);
+  return [...named, ...tagged];
+}
+
+/**
+ * Where a session pinned by an affinity is created: on a matching remote (the
+ * requested one if it matches), locally (no remote), or nowhere
+ */
+export function placeSession(
+  affinity: RemoteAffinity,
+  remotes: RemoteServer[],
+  requested?: RemoteServer
+): Placement {
+  const candidates = matchingRemotes(affinity, remotes);
+  const fallback = affinity.fallback ?? 'fail';
+
+  if (requested) {
+    // With no match left, 'any' lets the client choose
+    if (candidates.includes(requested) || (candidates.length === 0 && fallback === 'any')) {
+      return { remote: requested };
+    }
+    return {
+      error: `Remote ${requested.name} does not match the workspace affinity`,
+      code: 'INVALID_REQUEST',
+    };
+  }
+  if (candidates.length > 0) {
+    return { remote: candidates[0] };
+  }
+
+  if (fallback === 'local') {
+    return {};
+  }
+  if (fallback === 'any' && remotes.length > 0) {
+    return { remote: [...remotes].sort(byLoad)[0] };
+  }
+  return { error: 'No registered remote matches the workspace affinity', code: 'UNAVAILABLE' };
+}
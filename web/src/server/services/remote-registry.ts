@@ -1,6 +1,14 @@
 import chalk from 'chalk';
 import { isShuttingDown } from '../server.js';
 import { createLogger } from '../utils/logger.js';
+import { requestIdHeaders } from '../utils/request-context.js';
+import {
+  namespaceSessionId,
+  parseNamespacedSessionId,
+  toRemoteSessionId,
+} from '../utils/session-namespace.js';
+import { tracedFetch } from '../utils/tracing.js';
+import { generateRemoteToken, signatureHeaders } from './remote-tokens.js';
 
 const logger = createLogger('remote-registry');
 
@@ -8,30 +16,53 @@ export interface RemoteServer {
   id: string;
   name: string;
   url: string;
-  token: string;
+  token: string; // Issued by HQ, rotated on a schedule
+  tokenIssuedAt: Date;
   registeredAt: Date;
   lastHeartbeat: Date;
-  sessionIds: Set<string>; // Track which sessions belong to this remote
+  sessionIds: Set<string>; // Namespaced (<remoteName>/<id>) sessions belonging to this remote
+  tags: string[]; // Given at registration, matched by workspace affinities
 }
 
 export class RemoteRegistry {
   private remotes: Map<string, RemoteServer> = new Map();
   private remotesByName: Map<string, RemoteServer> = new Map();
-  private sessionToRemote: Map<string, string> = new Map(); // sessionId -> remoteId
+  private sessionToRemote: Map<string, string> = new Map(); // namespaced sessionId -> remoteId
+  // Session IDs as reported by the remotes, to detect two remotes using the same ID
+  private rawSessionOwners: Map<string, Set<string>> = new Map(); // raw sessionId -> remoteIds
+  private collisionsDetected = 0;
   private healthCheckInterval: NodeJS.Timeout | null = null;
   private readonly HEALTH_CHECK_INTERVAL = 15000; // Check every 15 seconds
   private readonly HEALTH_CHECK_TIMEOUT = 5000; // 5 second timeout per check
-
-  constructor() {
+  private tokenRotationInterval: NodeJS.Timeout | null = null;
+  private readonly tokenRotationMs: number;
+  private readonly hqSecret: string | null;
+
+  /**
+   * @param options.tokenRotationMs - Rotate remote tokens this often (0 disables rotation)
+   * @param options.hqSecret - Shared secret used to sign token pushes to remotes
+   */
+  constructor(options: { tokenRotationMs?: number; hqSecret?: string | null } = {}) {
+    this.tokenRotationMs = options.tokenRotationMs ?? 0;
+    this.hqSecret = options.hqSecret ?? null;
     this.startHealthChecker();
+    if (this.tokenRotationMs > 0) {
+      this.startTokenRotation();
+    }
     logger.debug('remote registry initialized with health check interval', {
       interval: this.HEALTH_CHECK_INTERVAL,
       timeout: this.HEALTH_CHECK_TIMEOUT,
     });
   }
 
+  /**
+   * Register a remote and issue the token HQ uses to authenticate with it
+   */
   register(
-    remote: Omit<RemoteServer, 'registeredAt' | 'lastHeartbeat' | 'sessionIds'>
+    remote: Omit<
+      RemoteServer,
+      'token' | 'tokenIssuedAt' | 'registeredAt' | 'lastHeartbeat' | 'sessionIds' | 'tags'
+    > & { tags?: string[] }
   ): RemoteServer {
     // Check if a remote with the same name already exists
     if (this.remotesByName.has(remote.name)) {
@@ -41,9 +72,12 @@ export class RemoteRegistry {
     const now = new Date();
     const registeredRemote: RemoteServer = {
       ...remote,
+      token: generateRemoteToken(),
+      tokenIssuedAt: now,
       registeredAt: now,
       lastHeartbeat: now,
       sessionIds: new Set<string>(),
+      tags: remote.tags ?? [],
     };
 
     this.remotes.set(remote.id, registeredRemote);
@@ -56,6 +90,47 @@ export class RemoteRegistry {
     return registeredRemote;
   }
 
+  /**
+   * Issue a new token to a remote, authenticated with the current one. With
+   * `revokePrevious` the remote rejects the old token at once (e.g. when it
+   * leaked); otherwise it stays valid for a short grace period.
+   */
+  async rotateToken(remoteId: string, revokePrevious = false): Promise<boolean> {
+    const remote = this.remotes.get(remoteId);
+    if (!remote) {
+      logger.debug(`cannot rotate token: remote ${remoteId} not found`);
+      return false;
+    }
+
+    const token = generateRemoteToken();
+    try {
+      const response = await tracedFetch(`${remote.url}/api/hq/token`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${remote.token}`,
+          ...requestIdHeaders(),
+          ...(this.hqSecret ? signatureHeaders(this.hqSecret, token) : {}),
+        },
+        body: JSON.stringify({ token, revokePrevious }),
+        signal: AbortSignal.timeout(this.HEALTH_CHECK_TIMEOUT),
+      });
+      if (!response.ok) {
+        throw new Error(`HTTP ${response.status}`);
+      }
+    } catch (error) {
+      logger.warn(`failed to rotate token for remote ${remote.name} (${remote.id}):`, error);
+      return false;
+    }
+
+    remote.token = token;
+    remote.tokenIssuedAt = new Date();
+    logger.log(
+      chalk.green(`token rotated for remote ${remote.name}${revokePrevious ? ' (revoked)' : ''}`)
+    );
+    return true;
+  }
+
   unregister(remoteId: string): boolean {
     const remote = this.remotes.get(remoteId);
     if (remote) {
@@ -64,6 +139,7 @@ export class RemoteRegistry {
       // Clean up session mappings
       for (const sessionId of remote.sessionIds) {
         this.sessionToRemote.delete(sessionId);
+        this.releaseRawSessionId(toRemoteSessionId(sessionId), remoteId);
       }
 
       this.remotesByName.delete(remote.name);
@@ -80,6 +156,10 @@ export class RemoteRegistry {
     return remote;
   }
 
+  getRemoteByName(name: string): RemoteServer | undefined {
+    return this.remotesByName.get(name);
+  }
+
   getRemoteByUrl(url: string): RemoteServer | undefined {
     return Array.from(this.remotes.values()).find((r) => r.url === url);
   }
@@ -88,11 +168,18 @@ export class RemoteRegistry {
     return Array.from(this.remotes.values());
   }
 
+  /**
+   * Find the remote owning a namespaced session ID (`<remoteName>/<id>`). Plain
+   * IDs always refer to local sessions.
+   */
   getRemoteBySessionId(sessionId: string): RemoteServer | undefined {
-    const remoteId = this.sessionToRemote.get(sessionId);
-    return remoteId ? this.remotes.get(remoteId) : undefined;
+    const namespaced = parseNamespacedSessionId(sessionId);
+    return namespaced ? this.remotesByName.get(namespaced.remoteName) : undefined;
   }
 
+  /**
+   * Replace a remote's sessions with the IDs it reported
+   */
   updateRemoteSessions(remoteId: string, sessionIds: string[]): void {
     const remote = this.remotes.get(remoteId);
     if (!remote) {
@@ -105,12 +192,16 @@ export class RemoteRegistry {
     // Remove old session mappings
     for (const oldSessionId of remote.sessionIds) {
       this.sessionToRemote.delete(oldSessionId);
+      this.releaseRawSessionId(toRemoteSessionId(oldSessionId), remoteId);
     }
 
     // Update with new sessions
-    remote.sessionIds = new Set(sessionIds);
+    remote.sessionIds = new Set();
     for (const sessionId of sessionIds) {
-      this.sessionToRemote.set(sessionId, remoteId);
+      const namespacedId = namespaceSessionId(remote.name, sessionId);
+      remote.sessionIds.add(namespacedId);
+      this.sessionToRemote.set(namespacedId, remoteId);
+      this.trackRawSessionId(sessionId, remote);
     }
 
     logger.debug(`updated sessions for remote ${remote.name}`, {
@@ -126,9 +217,11 @@ export class RemoteRegistry {
       return;
     }
 
-    remote.sessionIds.add(sessionId);
-    this.sessionToRemote.set(sessionId, remoteId);
-    logger.debug(`session ${sessionId} added to remote ${remote.name}`);
+    const namespacedId = namespaceSessionId(remote.name, sessionId);
+    remote.sessionIds.add(namespacedId);
+    this.sessionToRemote.set(namespacedId, remoteId);
+    this.trackRawSessionId(sessionId, remote);
+    logger.debug(`session ${namespacedId} added to remote ${remote.name}`);
   }
 
   removeSessionFromRemote(sessionId: string): void {
@@ -145,6 +238,47 @@ export class RemoteRegistry {
     }
 
     this.sessionToRemote.delete(sessionId);
+    this.releaseRawSessionId(toRemoteSessionId(sessionId), remoteId);
+  }
+
+  private trackRawSessionId(sessionId: string, remote: RemoteServer): void {
+    let owners = this.rawSessionOwners.get(sessionId);
+    if (!owners) {
+      owners = new Set();
+      this.rawSessionOwners.set(sessionId, owners);
+    }
+    if (!owners.has(remote.id) && owners.size > 0) {
+      this.collisionsDetected++;
+      const others = Array.from(owners, (id) => this.remotes.get(id)?.name ?? id).join(', ');
+      logger.warn(
+        `session ID collision: ${sessionId} on remote ${remote.name} is also used by ${others}`
+      );
+    }
+    owners.add(remote.id);
+  }
+
+  private releaseRawSessionId(sessionId: string, remoteId: string): void {
+    const owners = this.rawSessionOwners.get(sessionId);
+    owners?.delete(remoteId);
+    if (owners?.size === 0) {
+      this.rawSessionOwners.delete(sessionId);
+    }
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let collidingSessionIds = 0;
+    for (const owners of this.rawSessionOwners.values()) {
+      if (owners.size > 1) collidingSessionIds++;
+    }
+    return {
+      remotes: this.remotes.size,
+      sessions: this.sessionToRemote.size,
+      collidingSessionIds,
+      collisionsDetected: this.collisionsDetected,
+    };
   }
 
   private async checkRemoteHealth(remote: RemoteServer): Promise<void> {
@@ -157,7 +291,7 @@ export class RemoteRegistry {
       const controller = new AbortController();
       const timeoutId = setTimeout(() => controller.abort(), this.HEALTH_CHECK_TIMEOUT);
 
-      // Use the token provided by the remote for authentication
+      // Use the token issued to the remote for authentication
       const headers: Record<string, string> = {
         Authorization: `Bearer ${remote.token}`,
       };
@@ -205,11 +339,35 @@ export class RemoteRegistry {
     }, this.HEALTH_CHECK_INTERVAL);
   }
 
+  private startTokenRotation() {
+    logger.debug(`starting token rotation every ${this.tokenRotationMs}ms`);
+    // Check often enough that no token outlives the rotation interval by much
+    const checkInterval = Math.min(this.tokenRotationMs, this.HEALTH_CHECK_INTERVAL * 4);
+    this.tokenRotationInterval = setInterval(() => {
+      if (isShuttingDown()) {
+        return;
+      }
+
+      const now = Date.now();
+      for (const remote of this.remotes.values()) {
+        if (now - remote.tokenIssuedAt.getTime() >= this.tokenRotationMs) {
+          this.rotateToken(remote.id).catch((err) => {
+            logger.error(`error rotating token for ${remote.name}:`, err);
+          });
+        }
+      }
+    }, checkInterval);
+  }
+
   destroy() {
     logger.log(chalk.yellow('destroying remote registry'));
     if (this.healthCheckInterval) {
       clearInterval(this.healthCheckInterval);
       logger.debug('health checker stopped');
     }
+    if (this.tokenRotationInterval) {
+      clearInterval(this.tokenRotationInterval);
+      logger.debug('token rotation stopped');
+    }
   }
 }
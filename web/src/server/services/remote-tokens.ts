@@ -0,0 +1,150 @@
+import * as crypto from 'crypto';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('remote-tokens');
+
+// Headers carrying the shared-secret signature of HQ <-> remote handshake requests
+export const SIGNATURE_HEADER = 'x-vibetunnel-signature';
+export const TIMESTAMP_HEADER = 'x-vibetunnel-timestamp';
+
+// Signed requests older (or newer) than this are rejected
+export const SIGNATURE_MAX_SKEW_MS = 5 * 60 * 1000;
+
+// The previous token stays valid this long after a rotation so in-flight HQ requests succeed
+export const TOKEN_ROTATION_GRACE_MS = 60 * 1000;
+
+/**
+ * Generate a token HQ uses to authenticate with a remote
+ */
+export function generateRemoteToken(): string {
+  return crypto.randomBytes(32).toString('hex');
+}
+
+/**
+ * HMAC-SHA256 of `<timestamp>.<payload>` with the shared HQ secret
+ */
+export function signPayload(secret: string, timestamp: string, payload: string): string {
+  return crypto.createHmac('sha256', secret).update(`${timestamp}.${payload}`).digest('hex');
+}
+
+/**
+ * Headers for a request signed with the shared HQ secret
+ */
+export function signatureHeaders(secret: string, payload: string): Record<string, string> {
+  const timestamp = Date.now().toString();
+  return {
+    [SIGNATURE_HEADER]: signPayload(secret, timestamp, payload),
+    [TIMESTAMP_HEADER]: timestamp,
+  };
+}
+
+/**
+ * Check a signature made with signPayload, rejecting stale timestamps
+ */
+export function verifySignature(
+  secret: string,
+  timestamp: unknown,
+  signature: unknown,
+  payload: string,
+  now = Date.now()
+): boolean {
+  if (typeof timestamp !== 'string' || typeof signature !== 'string') {
+    return false;
+  }
+  const time = Number.parseInt(timestamp, 10);
+  if (!Number.isFinite(time) || Math.abs(now - time) > SIGNATURE_MAX_SKEW_MS) {
+    return false;
+  }
+  const expected = Buffer.from(signPayload(secret, timestamp, payload), 'utf8');
+  const actual = Buffer.from(signature, 'utf8');
+  return expected.length === actual.length && crypto.timingSafeEqual(expected, actual);
+}
+
+/**
+ * Payload signed by a remote when it registers with HQ. Tags are only part of
+ * it when given, so untagged remotes sign what they always did.
+ */
+export function registrationPayload(
+  id: string,
+  name: string,
+  url: string,
+  tags: string[] = []
+): string {
+  const payload = `${id}\n${name}\n${url}`;
+  return tags.length > 0 ? `${payload}\n${tags.join(',')}` : payload;
+}
+
+export type TokenCheck = 'valid' | 'revoked' | 'unknown';
+
+const hashToken = (token: string) => crypto.createHash('sha256').update(token).digest('hex');
+
+/**
+ * Bearer tokens a remote accepts from HQ. HQ issues the token at registration
+ * and rotates it on a schedule; replaced tokens are valid for a grace period
+ * and then revoked. Only hashes of revoked tokens are kept.
+ */
+export class RemoteTokenStore {
+  private current: string | null = null;
+  private previous: { token: string; validUntil: number } | null = null;
+  private revoked = new Set<string>();
+  private readonly graceMs: number;
+
+  constructor(graceMs = TOKEN_ROTATION_GRACE_MS) {
+    this.graceMs = graceMs;
+  }
+
+  getCurrent(): string | null {
+    return this.current;
+  }
+
+  isCurrent(token: string): boolean {
+    return this.check(token) === 'valid' && token === this.current;
+  }
+
+  /**
+   * Replace the current token. With `revokePrevious` the replaced token is
+   * rejected immediately instead of after the grace period.
+   */
+  rotate(token: string, options: { revokePrevious?: boolean } = {}): void {
+    if (this.previous) {
+      this.revoke(this.previous.token);
+      this.previous = null;
+    }
+    if (this.current && this.current !== token) {
+      if (options.revokePrevious) {
+        this.revoke(this.current);
+      } else {
+        this.previous = { token: this.current, validUntil: Date.now() + this.graceMs };
+      }
+    }
+    this.current = token;
+    logger.debug(`token rotated (previous ${options.revokePrevious ? 'revoked' : 'in grace'})`);
+  }
+
+  revoke(token: string): void {
+    this.revoked.add(hashToken(token));
+    if (this.current === token) {
+      this.current = null;
+    }
+    if (this.previous?.token === token) {
+      this.previous = null;
+    }
+  }
+
+  check(token: string): TokenCheck {
+    if (this.previous && this.previous.validUntil <= Date.now()) {
+      this.revoke(this.previous.token);
+    }
+    if (this.revoked.has(hashToken(token))) {
+      return 'revoked';
+    }
+    if (token === this.current || token === this.previous?.token) {
+      return 'valid';
+    }
+    return 'unknown';
+  }
+
+  getRevokedCount(): number {
+    return this.revoked.size;
+  }
+}
@@ -0,0 +1,215 @@
+import { EventEmitter } from 'events';
+import {
+  createLogger,
+  getLogLevel,
+  isLogLevel,
+  type LogLevel,
+  setLogLevel,
+} from '../utils/logger.js';
+import { DEFAULT_OUTPUT_THROTTLE, type OutputThrottleSettings } from './output-throttle.js';
+
+const logger = createLogger('runtime-config');
+
+export interface RateLimitSettings {
+  windowMs: number; // Length of the rate limit window
+  maxRequests: number; // Max API requests per client per window (0 = unlimited)
+}
+
+export interface SessionLimitSettings {
+  maxSessions: number; // Max concurrent sessions on this server (0 = unlimited)
+  maxSessionsPerUser: number; // Max concurrent sessions created by one user (0 = unlimited)
+}
+
+export interface RuntimeSettings {
+  logLevel: LogLevel;
+  terminalCleanupIntervalMs: number;
+  rateLimit: RateLimitSettings;
+  sessionLimits: SessionLimitSettings;
+  outputThrottle: OutputThrottleSettings;
+}
+
+export interface RuntimeSettingsPatch {
+  logLevel?: LogLevel;
+  terminalCleanupIntervalMs?: number;
+  rateLimit?: Partial<RateLimitSettings>;
+  sessionLimits?: Partial<SessionLimitSettings>;
+  outputThrottle?: Partial<OutputThrottleSettings>;
+}
+
+export class RuntimeConfigError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'RuntimeConfigError';
+  }
+}
+
+const MIN_CLEANUP_INTERVAL_MS = 10 * 1000; // 10 seconds
+const MAX_CLEANUP_INTERVAL_MS = 24 * 60 * 60 * 1000; // 24 hours
+const MIN_RATE_LIMIT_WINDOW_MS = 1000; // 1 second
+const MAX_RATE_LIMIT_WINDOW_MS = 60 * 60 * 1000; // 1 hour
+
+const isPlainObject = (value: unknown): value is Record<string, unknown> =>
+  !!value && typeof value === 'object' && !Array.isArray(value);
+
+export const DEFAULT_RUNTIME_SETTINGS: RuntimeSettings = {
+  logLevel: 'info',
+  terminalCleanupIntervalMs: 5 * 60 * 1000, // 5 minutes
+  rateLimit: {
+    windowMs: 60 * 1000, // 1 minute
+    maxRequests: 0, // Disabled by default
+  },
+  sessionLimits: {
+    maxSessions: 0,
+    maxSessionsPerUser: 0,
+  },
+  outputThrottle: DEFAULT_OUTPUT_THROTTLE,
+};
+
+/**
+ * Holds the subset of server configuration that can be changed at runtime.
+ *
+ * Emits 'change' with (settings, changedKeys) after a successful update so that
+ * components (cleanup timers, rate limiter, ...) can pick up the new values
+ * without a restart.
+ */
+export class RuntimeConfig extends EventEmitter {
+  private settings: RuntimeSettings;
+
+  constructor(initial: Partial<RuntimeSettings> = {}) {
+    super();
+    this.settings = {
+      ...DEFAULT_RUNTIME_SETTINGS,
+      logLevel: getLogLevel(),
+      ...initial,
+      rateLimit: { ...DEFAULT_RUNTIME_SETTINGS.rateLimit, ...initial.rateLimit },
+      sessionLimits: { ...DEFAULT_RUNTIME_SETTINGS.sessionLimits, ...initial.sessionLimits },
+      outputThrottle: { ...DEFAULT_RUNTIME_SETTINGS.outputThrottle, ...initial.outputThrottle },
+    };
+  }
+
+  /**
+   * Get a copy of the current settings
+   */
+  get(): RuntimeSettings {
+    return {
+      ...this.settings,
+      rateLimit: { ...this.settings.rateLimit },
+      sessionLimits: { ...this.settings.sessionLimits },
+      outputThrottle: { ...this.settings.outputThrottle },
+    };
+  }
+
+  /**
+   * Validate and apply a partial update. Throws RuntimeConfigError on invalid input,
+   * in which case no setting is changed.
+   */
+  update(patch: RuntimeSettingsPatch): RuntimeSettings {
+    if (!isPlainObject(patch)) {
+      throw new RuntimeConfigError('Request body must be an object');
+    }
+
+    const allowedKeys = [
+      'logLevel',
+      'terminalCleanupIntervalMs',
+      'rateLimit',
+      'sessionLimits',
+      'outputThrottle',
+    ];
+    const unknownKeys = Object.keys(patch).filter((key) => !allowedKeys.includes(key));
+    if (unknownKeys.length > 0) {
+      throw new RuntimeConfigError(`Unsupported settings: ${unknownKeys.join(', ')}`);
+    }
+
+    const next = this.get();
+    const changedKeys: string[] = [];
+
+    if (patch.logLevel !== undefined) {
+      if (!isLogLevel(patch.logLevel)) {
+        throw new RuntimeConfigError('logLevel must be one of: error, warn, info, debug');
+      }
+      next.logLevel = patch.logLevel;
+      changedKeys.push('logLevel');
+    }
+
+    if (patch.terminalCleanupIntervalMs !== undefined) {
+      const interval = patch.terminalCleanupIntervalMs;
+      if (
+        !Number.isInteger(interval) ||
+        interval < MIN_CLEANUP_INTERVAL_MS ||
+        interval > MAX_CLEANUP_INTERVAL_MS
+      ) {
+        throw new RuntimeConfigError(
+          `terminalCleanupIntervalMs must be an integer between ${MIN_CLEANUP_INTERVAL_MS} and ${MAX_CLEANUP_INTERVAL_MS}`
+        );
+      }
+      next.terminalCleanupIntervalMs = interval;
+      changedKeys.push('terminalCleanupIntervalMs');
+    }
+
+    if (patch.rateLimit !== undefined) {
+      if (!isPlainObject(patch.rateLimit)) {
+        throw new RuntimeConfigError('rateLimit must be an object');
+      }
+      const { windowMs, maxRequests } = patch.rateLimit;
+      if (windowMs !== undefined) {
+        if (
+          !Number.isInteger(windowMs) ||
+          windowMs < MIN_RATE_LIMIT_WINDOW_MS ||
+          windowMs > MAX_RATE_LIMIT_WINDOW_MS
+        ) {
+          throw new RuntimeConfigError(
+            `rateLimit.windowMs must be an integer between ${MIN_RATE_LIMIT_WINDOW_MS} and ${MAX_RATE_LIMIT_WINDOW_MS}`
+          );
+        }
+        next.rateLimit.windowMs = windowMs;
+      }
+      if (maxRequests !== undefined) {
+        if (!Number.isInteger(maxRequests) || maxRequests < 0) {
+          throw new RuntimeConfigError('rateLimit.maxRequests must be a non-negative integer');
+        }
+        next.rateLimit.maxRequests = maxRequests;
+      }
+      changedKeys.push('rateLimit');
+    }
+
+    if (patch.sessionLimits !== undefined) {
+      if (!isPlainObject(patch.sessionLimits)) {
+        throw new RuntimeConfigError('sessionLimits must be an object');
+      }
+      for (const key of ['maxSessions', 'maxSessionsPerUser'] as const) {
+        const limit = patch.sessionLimits[key];
+        if (limit === undefined) continue;
+        if (!Number.isInteger(limit) || limit < 0) {
+          throw new RuntimeConfigError(`sessionLimits.${key} must be a non-negative integer`);
+        }
+        next.sessionLimits[key] = limit;
+      }
+      changedKeys.push('sessionLimits');
+    }
+
+    if (patch.outputThrottle !== undefined) {
+      if (!isPlainObject(patch.outputThrottle)) {
+        throw new RuntimeConfigError('outputThrottle must be an object');
+      }
+      for (const key of ['viewerBytesPerSecond', 'sessionBytesPerSecond', 'burstBytes'] as const) {
+        const value = patch.outputThrottle[key];
+        if (value === undefined) continue;
+        if (!Number.isInteger(value) || value < 0) {
+          throw new RuntimeConfigError(`outputThrottle.${key} must be a non-negative integer`);
+        }
+        next.outputThrottle[key] = value;
+      }
+      changedKeys.push('outputThrottle');
+    }
+
+    this.settings = next;
+
+    if (changedKeys.includes('logLevel')) {
+      setLogLevel(next.logLevel);
+    }
+
+    logger.log(`runtime settings updated: ${changedKeys.join(', ') || 'none'}`);
+    this.emit('change', this.get(), changedKeys);
+    return this.get();
+  }
+}
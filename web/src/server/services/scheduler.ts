@@ -0,0 +1,349 @@
+import * as fs from 'fs';
+import * as path from 'path';
+import { v4 as uuidv4 } from 'uuid';
+import type { PtyManager } from '../pty/index.js';
+import { type CronSchedule, nextCronRun, parseCron } from '../utils/cron.js';
+import type { InputSource } from '../utils/input-source.js';
+import { canAccessPath, type LocalAccount, lookupLocalAccount } from '../utils/local-accounts.js';
+import { createLogger } from '../utils/logger.js';
+import { resolvePath } from '../utils/path-utils.js';
+import { generateSessionName } from '../utils/session-naming.js';
+import type { InputLockManager } from './input-lock.js';
+
+const logger = createLogger('scheduler');
+
+// Run history kept per job
+const MAX_RUNS_PER_JOB = 50;
+
+// Attribution of input the scheduler types into sessions
+const SCHEDULER_SOURCE: InputSource = { authMethod: 'scheduler' };
+
+export type ScheduleTarget =
+  | { type: 'session'; sessionId: string }
+  | { type: 'spawn'; workingDir?: string; name?: string };
+
+export interface ScheduledJob {
+  id: string;
+  name: string;
+  cron: string;
+  // Command line typed into the target session, or run by the spawned session
+  command: string;
+  target: ScheduleTarget;
+  enabled: boolean;
+  // User who created the job; jobs of operators have none
+  owner?: string;
+  createdAt: string;
+  nextRunAt: string | null;
+}
+
+export interface ScheduledRun {
+  jobId: string;
+  startedAt: string;
+  success: boolean;
+  sessionId?: string;
+  error?: string;
+  manual?: boolean;
+}
+
+export type ScheduledJobInput = Pick<ScheduledJob, 'name' | 'cron' | 'command' | 'target'> & {
+  enabled?: boolean;
+};
+
+export class SchedulerError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'SchedulerError';
+  }
+}
+
+interface StoredSchedules {
+  jobs: ScheduledJob[];
+  runs: Record<string, ScheduledRun[]>;
+}
+
+/**
+ * Scheduler - Runs cron-style jobs that type a command into a session or
+ * spawn a new session for it
+ *
+ * Jobs and their run history are stored in `schedules.json` in the control
+ * directory. A single timer wakes up at the next due job; runs that were
+ * missed while the server was down are skipped, not caught up. In local user
+ * mode sessions spawned for a job run as the local account of its owner.
+ */
+export class Scheduler {
+  private jobs = new Map<string, ScheduledJob>();
+  private schedules = new Map<string, CronSchedule>();
+  private runs = new Map<string, ScheduledRun[]>();
+  private timer: NodeJS.Timeout | null = null;
+  private filePath: string;
+  private ptyManager: PtyManager;
+  // Jobs do not type into sessions locked by a client
+  private inputLocks?: InputLockManager;
+  private localUsers: boolean;
+
+  constructor(
+    controlDir: string,
+    ptyManager: PtyManager,
+    inputLocks?: InputLockManager,
+    localUsers = false
+  ) {
+    this.filePath = path.join(controlDir, 'schedules.json');
+    this.ptyManager = ptyManager;
+    this.inputLocks = inputLocks;
+    this.localUsers = localUsers;
+    this.load();
+  }
+
+  start(): void {
+    this.scheduleNextWakeup();
+    logger.debug(`scheduler started with ${this.jobs.size} jobs`);
+  }
+
+  stop(): void {
+    if (this.timer) {
+      clearTimeout(this.timer);
+      this.timer = null;
+    }
+  }
+
+  list(): ScheduledJob[] {
+    return Array.from(this.jobs.values());
+  }
+
+  get(jobId: string): ScheduledJob | undefined {
+    return this.jobs.get(jobId);
+  }
+
+  getRuns(jobId: string): ScheduledRun[] {
+    return [...(this.runs.get(jobId) ?? [])];
+  }
+
+  /**
+   * Register a job, owned by `owner` if given. Throws SchedulerError for invalid input.
+   */
+  create(input: ScheduledJobInput, owner?: string): ScheduledJob {
+    const schedule = this.parse(input.cron);
+    this.validateTarget(input.target);
+
+    const job: ScheduledJob = {
+      id: uuidv4(),
+      name: input.name,
+      cron: input.cron,
+      command: input.command,
+      target: input.target,
+      enabled: input.enabled ?? true,
+      ...(owner ? { owner } : {}),
+      createdAt: new Date().toISOString(),
+      nextRunAt: null,
+    };
+    this.jobs.set(job.id, job);
+    this.schedules.set(job.id, schedule);
+    this.updateNextRun(job);
+    this.save();
+    this.scheduleNextWakeup();
+
+    logger.log(`job ${job.name} (${job.id}) scheduled: ${job.cron}`);
+    return job;
+  }
+
+  /**
+   * Change a job. Throws SchedulerError for invalid input.
+   */
+  update(jobId: string, changes: Partial<ScheduledJobInput>): ScheduledJob | undefined {
+    const job = this.jobs.get(jobId);
+    if (!job) return undefined;
+
+    const schedule = changes.cron !== undefined ? this.parse(changes.cron) : undefined;
+    if (changes.target !== undefined) {
+      this.validateTarget(changes.target);
+    }
+
+    Object.assign(job, changes);
+    if (schedule) {
+      this.schedules.set(job.id, schedule);
+    }
+    this.updateNextRun(job);
+    this.save();
+    this.scheduleNextWakeup();
+    return job;
+  }
+
+  delete(jobId: string): boolean {
+    if (!this.jobs.delete(jobId)) return false;
+    this.schedules.delete(jobId);
+    this.runs.delete(jobId);
+    this.save();
+    this.scheduleNextWakeup();
+    logger.log(`job ${jobId} deleted`);
+    return true;
+  }
+
+  /**
+   * Run a job now, independent of its schedule
+   */
+  async runNow(jobId: string): Promise<ScheduledRun | undefined> {
+    const job = this.jobs.get(jobId);
+    if (!job) return undefined;
+    return this.run(job, true);
+  }
+
+  private async run(job: ScheduledJob, manual = false): Promise<ScheduledRun> {
+    const run: ScheduledRun = {
+      jobId: job.id,
+      startedAt: new Date().toISOString(),
+      success: true,
+    };
+    if (manual) {
+      run.manual = true;
+    }
+
+    try {
+      if (job.target.type === 'session') {
+        const session = this.ptyManager.getSession(job.target.sessionId);
+        if (session?.status !== 'running') {
+          throw new Error(`Session ${job.target.sessionId} is not running`);
+        }
+        if (this.inputLocks && !this.inputLocks.checkInput(job.target.sessionId)) {
+          throw new Error(`Session ${job.target.sessionId} input is locked`);
+        }
+        this.ptyManager.sendInput(job.target.sessionId, { text: job.command }, SCHEDULER_SOURCE);
+        this.ptyManager.sendInput(job.target.sessionId, { key: 'enter' }, SCHEDULER_SOURCE);
+        run.sessionId = job.target.sessionId;
+      } else {
+        const account = this.accountFor(job);
+        const defaultDir = account?.home ?? process.cwd();
+        let cwd = resolvePath(job.target.workingDir ?? '', defaultDir, account?.home);
+        if (!fs.existsSync(cwd)) {
+          cwd = defaultDir;
+        }
+        if (account && !(await canAccessPath(account, cwd, 'read'))) {
+          throw new Error(`${account.username} cannot access ${cwd}`);
+        }
+        const command = [account?.shell || process.env.SHELL || '/bin/sh', '-c', job.command];
+        // Counted against the owner's session limits like sessions they create
+        const result = await this.ptyManager.createSession(command, {
+          name: job.target.name || generateSessionName([job.command], cwd),
+          workingDir: cwd,
+          createdBy: job.owner,
+          runAs: account,
+        });
+        run.sessionId = result.sessionId;
+      }
+      logger.log(`job ${job.name} ran${run.sessionId ? ` in session ${run.sessionId}` : ''}`);
+    } catch (error) {
+      run.success = false;
+      run.error = error instanceof Error ? error.message : String(error);
+      logger.warn(`job ${job.name} failed: ${run.error}`);
+    }
+
+    const runs = this.runs.get(job.id) ?? [];
+    runs.push(run);
+    runs.splice(0, Math.max(0, runs.length - MAX_RUNS_PER_JOB));
+    this.runs.set(job.id, runs);
+    this.save();
+    return run;
+  }
+
+  private async runDueJobs(): Promise<void> {
+    this.timer = null;
+    const now = Date.now();
+
+    for (const job of this.jobs.values()) {
+      if (!job.enabled || !job.nextRunAt || Date.parse(job.nextRunAt) > now) continue;
+      // Compute the next run first so a slow run cannot fire twice
+      this.updateNextRun(job);
+      await this.run(job);
+    }
+
+    this.save();
+    this.scheduleNextWakeup();
+  }
+
+  private scheduleNextWakeup(): void {
+    if (this.timer) {
+      clearTimeout(this.timer);
+      this.timer = null;
+    }
+
+    let next = Number.POSITIVE_INFINITY;
+    for (const job of this.jobs.values()) {
+      if (job.enabled && job.nextRunAt) {
+        next = Math.min(next, Date.parse(job.nextRunAt));
+      }
+    }
+    if (next === Number.POSITIVE_INFINITY) return;
+
+    // Timers cannot wait longer than ~24.8 days; wake up early and re-check
+    const delay = Math.min(Math.max(0, next - Date.now()), 24 * 60 * 60 * 1000);
+    this.timer = setTimeout(() => {
+      this.runDueJobs().catch((error) => logger.error('failed to run scheduled jobs:', error));
+    }, delay);
+    this.timer.unref();
+  }
+
+  /**
+   * Account sessions of a job run as: its owner's in local user mode, the
+   * server's otherwise and for jobs of operators
+   */
+  private accountFor(job: ScheduledJob): LocalAccount | undefined {
+    if (!this.localUsers || !job.owner) return undefined;
+    const account = lookupLocalAccount(job.owner);
+    if (!account) {
+      throw new Error(`${job.owner} has no local account on this host`);
+    }
+    return account;
+  }
+
+  private updateNextRun(job: ScheduledJob): void {
+    const schedule = this.schedules.get(job.id);
+    const next = job.enabled && schedule ? nextCronRun(schedule, new Date()) : null;
+    job.nextRunAt = next ? next.toISOString() : null;
+  }
+
+  private parse(cron: string): CronSchedule {
+    try {
+      return parseCron(cron);
+    } catch (error) {
+      throw new SchedulerError(error instanceof Error ? error.message : String(error));
+    }
+  }
+
+  private validateTarget(target: ScheduleTarget): void {
+    if (target.type === 'session' && !this.ptyManager.getSession(target.sessionId)) {
+      throw new SchedulerError(`Session ${target.sessionId} not found`);
+    }
+  }
+
+  private load(): void {
+    try {
+      if (!fs.existsSync(this.filePath)) return;
+      const stored = JSON.parse(fs.readFileSync(this.filePath, 'utf8')) as StoredSchedules;
+      for (const job of stored.jobs) {
+        try {
+          this.schedules.set(job.id, parseCron(job.cron));
+          this.jobs.set(job.id, job);
+          this.runs.set(job.id, stored.runs[job.id] ?? []);
+          // Missed runs are skipped
+          this.updateNextRun(job);
+        } catch (_error) {
+          logger.warn(`skipping stored job ${job.id} with invalid schedule '${job.cron}'`);
+        }
+      }
+      logger.debug(`loaded ${this.jobs.size} scheduled jobs`);
+    } catch (error) {
+      logger.error('failed to load scheduled jobs:', error);
+    }
+  }
+
+  private save(): void {
+    const stored: StoredSchedules = {
+      jobs: this.list(),
+      runs: Object.fromEntries(this.runs),
+    };
+    try {
+      fs.writeFileSync(this.filePath, JSON.stringify(stored, null, 2));
+    } catch (error) {
+      logger.error('failed to save scheduled jobs:', error);
+    }
+  }
+}
@@ -0,0 +1,438 @@
+/**
+ * SecondFactorService - TOTP and WebAuthn second factors for the web login
+ *
+ * Users who enrolled a second factor get a short-lived pending login instead
+ * of a token after the password or SSH key step; the token is issued once they
+ * confirm it with an authenticator app code or a security key. Enrolled
+ * credentials are persisted in ~/.vibetunnel/second-factor.json.
+ */
+
+import { randomBytes } from 'crypto';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { createLogger } from '../utils/logger.js';
+import { generateTotpSecret, totpUri, verifyTotp } from '../utils/totp.js';
+import {
+  type AssertionResponse,
+  COSE_ALGORITHMS,
+  type RegisteredCredential,
+  type RegistrationResponse,
+  relyingParty,
+  verifyAssertion,
+  verifyRegistration,
+  WebAuthnError,
+} from '../utils/webauthn.js';
+
+const logger = createLogger('second-factor');
+
+// Time to complete the second step of a login, an enrollment or a WebAuthn ceremony
+const PENDING_TTL_MS = 5 * 60 * 1000;
+// Wrong codes or assertions before a pending login is dropped
+const MAX_ATTEMPTS = 5;
+const WEBAUTHN_TIMEOUT_MS = 60000;
+const MAX_CREDENTIAL_NAME = 64;
+
+export type SecondFactorMethod = 'totp' | 'webauthn';
+
+interface StoredTotp {
+  secret: string;
+  createdAt: string;
+  // Last time step used, so codes cannot be replayed
+  lastCounter: number;
+}
+
+interface StoredWebAuthnCredential extends RegisteredCredential {
+  name: string;
+  rpId: string;
+  createdAt: string;
+  lastUsedAt?: string;
+}
+
+interface UserFactors {
+  totp?: StoredTotp;
+  webauthn: StoredWebAuthnCredential[];
+}
+
+interface PendingLogin {
+  userId: string;
+  // How the first factor was verified
+  authMethod: 'ssh-key' | 'password';
+  expiresAt: number;
+  attempts: number;
+  // Challenge of the WebAuthn assertion requested for this login
+  challenge?: { value: Buffer; origin: string; rpId: string };
+}
+
+export interface PendingLoginInfo {
+  pendingToken: string;
+  methods: SecondFactorMethod[];
+  expiresAt: number;
+}
+
+export type CompletedLogin = Pick<PendingLogin, 'userId' | 'authMethod'>;
+
+export interface SecondFactorStatus {
+  totp: { enabled: boolean; createdAt?: string };
+  webauthn: { id: string; name: string; rpId: string; createdAt: string; lastUsedAt?: string }[];
+}
+
+export class SecondFactorError extends Error {
+  constructor(
+    message: string,
+    readonly status = 400
+  ) {
+    super(message);
+    this.name = 'SecondFactorError';
+  }
+}
+
+export class SecondFactorService {
+  private users = new Map<string, UserFactors>();
+  private pendingLogins = new Map<string, PendingLogin>();
+  private pendingTotp = new Map<string, { secret: string; expiresAt: number }>();
+  private pendingRegistrations = new Map<
+    string,
+    { challenge: Buffer; origin: string; rpId: string; expiresAt: number }
+  >();
+  private cleanupTimer: NodeJS.Timeout;
+
+  constructor(
+    private readonly filePath: string = path.join(
+      os.homedir(),
+      '.vibetunnel',
+      'second-factor.json'
+    )
+  ) {
+    this.load();
+    this.cleanupTimer = setInterval(() => this.cleanupExpired(), 60000);
+    this.cleanupTimer.unref();
+  }
+
+  /**
+   * Whether the user has to confirm logins with a second factor
+   */
+  isEnrolled(userId: string): boolean {
+    return this.methods(userId).length > 0;
+  }
+
+  getStatus(userId: string): SecondFactorStatus {
+    const factors = this.users.get(userId);
+    return {
+      totp: { enabled: !!factors?.totp, createdAt: factors?.totp?.createdAt },
+      webauthn: (factors?.webauthn ?? []).map(({ id, name, rpId, createdAt, lastUsedAt }) => ({
+        id,
+        name,
+        rpId,
+        createdAt,
+        lastUsedAt,
+      })),
+    };
+  }
+
+  /**
+   * Start the second step of a login whose first factor succeeded
+   */
+  startLogin(userId: string, authMethod: PendingLogin['authMethod']): PendingLoginInfo {
+    const pendingToken = randomBytes(32).toString('base64url');
+    const expiresAt = Date.now() + PENDING_TTL_MS;
+    this.pendingLogins.set(pendingToken, { userId, authMethod, expiresAt, attempts: 0 });
+    return { pendingToken, methods: this.methods(userId), expiresAt };
+  }
+
+  /**
+   * Complete a pending login with an authenticator app code
+   */
+  verifyTotpLogin(pendingToken: string, code: string): CompletedLogin {
+    const pending = this.getPendingLogin(pendingToken);
+    const totp = this.users.get(pending.userId)?.totp;
+    if (!totp) {
+      throw new SecondFactorError('Authenticator app is not enrolled');
+    }
+    const counter = verifyTotp(totp.secret, code, totp.lastCounter);
+    if (counter === null) {
+      this.countFailure(pendingToken, pending);
+      throw new SecondFactorError('Invalid authentication code', 401);
+    }
+    totp.lastCounter = counter;
+    this.save();
+    this.pendingLogins.delete(pendingToken);
+    return { userId: pending.userId, authMethod: pending.authMethod };
+  }
+
+  /**
+   * Options for navigator.credentials.get() to complete a pending login
+   */
+  webAuthnLoginOptions(pendingToken: string, originHeader: unknown) {
+    const pending = this.getPendingLogin(pendingToken);
+    const { origin, rpId } = this.relyingParty(originHeader);
+    const credentials = (this.users.get(pending.userId)?.webauthn ?? []).filter(
+      (credential) => credential.rpId === rpId
+    );
+    if (credentials.length === 0) {
+      throw new SecondFactorError(`No security key is registered for ${rpId}`);
+    }
+
+    const challenge = randomBytes(32);
+    pending.challenge = { value: challenge, origin, rpId };
+    return {
+      challenge: challenge.toString('base64url'),
+      rpId,
+      timeout: WEBAUTHN_TIMEOUT_MS,
+      userVerification: 'preferred',
+      allowCredentials: credentials.map((credential) => ({
+        type: 'public-key',
+        id: credential.id,
+      })),
+    };
+  }
+
+  /**
+   * Complete a pending login with a security key assertion
+   */
+  verifyWebAuthnLogin(pendingToken: string, assertion: AssertionResponse): CompletedLogin {
+    const pending = this.getPendingLogin(pendingToken);
+    if (!pending.challenge) {
+      throw new SecondFactorError('Request security key options first');
+    }
+    const credential = this.users
+      .get(pending.userId)
+      ?.webauthn.find((candidate) => candidate.id === assertion?.id);
+    if (!credential) {
+      this.countFailure(pendingToken, pending);
+      throw new SecondFactorError('Unknown security key', 401);
+    }
+
+    const { value, origin, rpId } = pending.challenge;
+    pending.challenge = undefined;
+    try {
+      credential.signCount = verifyAssertion(assertion, credential, {
+        challenge: value,
+        origin,
+        rpId,
+      });
+    } catch (error) {
+      this.countFailure(pendingToken, pending);
+      if (error instanceof WebAuthnError) {
+        logger.warn(`security key login of ${pending.userId} rejected: ${error.message}`);
+        throw new SecondFactorError(error.message, 401);
+      }
+      throw error;
+    }
+    credential.lastUsedAt = new Date().toISOString();
+    this.save();
+    this.pendingLogins.delete(pendingToken);
+    return { userId: pending.userId, authMethod: pending.authMethod };
+  }
+
+  /**
+   * Generate a TOTP secret for the user; enabled once confirmed with a code
+   */
+  beginTotpEnrollment(userId: string): { secret: string; otpauthUrl: string } {
+    const secret = generateTotpSecret();
+    this.pendingTotp.set(userId, { secret, expiresAt: Date.now() + PENDING_TTL_MS });
+    return { secret, otpauthUrl: totpUri(secret, userId) };
+  }
+
+  confirmTotpEnrollment(userId: string, code: string): void {
+    const pending = this.pendingTotp.get(userId);
+    if (!pending || pending.expiresAt < Date.now()) {
+      throw new SecondFactorError('No authenticator app enrollment in progress');
+    }
+    const counter = verifyTotp(pending.secret, code);
+    if (counter === null) {
+      throw new SecondFactorError('Invalid authentication code');
+    }
+    this.pendingTotp.delete(userId);
+    this.factors(userId).totp = {
+      secret: pending.secret,
+      createdAt: new Date().toISOString(),
+      lastCounter: counter,
+    };
+    this.save();
+    logger.log(`user ${userId} enrolled an authenticator app`);
+  }
+
+  removeTotp(userId: string): boolean {
+    const factors = this.users.get(userId);
+    if (!factors?.totp) return false;
+    factors.totp = undefined;
+    this.save();
+    logger.log(`user ${userId} removed their authenticator app`);
+    return true;
+  }
+
+  /**
+   * Options for navigator.credentials.create() to register a security key
+   */
+  webAuthnRegistrationOptions(userId: string, originHeader: unknown) {
+    const { origin, rpId } = this.relyingParty(originHeader);
+    const challenge = randomBytes(32);
+    this.pendingRegistrations.set(userId, {
+      challenge,
+      origin,
+      rpId,
+      expiresAt: Date.now() + PENDING_TTL_MS,
+    });
+    return {
+      challenge: challenge.toString('base64url'),
+      rp: { id: rpId, name: 'VibeTunnel' },
+      user: {
+        id: Buffer.from(userId).toString('base64url'),
+        name: userId,
+        displayName: userId,
+      },
+      pubKeyCredParams: Object.values(COSE_ALGORITHMS).map((alg) => ({
+        type: 'public-key',
+        alg,
+      })),
+      timeout: WEBAUTHN_TIMEOUT_MS,
+      attestation: 'none',
+      authenticatorSelection: { userVerification: 'preferred', residentKey: 'discouraged' },
+      excludeCredentials: (this.users.get(userId)?.webauthn ?? [])
+        .filter((credential) => credential.rpId === rpId)
+        .map((credential) => ({ type: 'public-key', id: credential.id })),
+    };
+  }
+
+  finishWebAuthnRegistration(
+    userId: string,
+    registration: RegistrationResponse,
+    name: unknown
+  ): SecondFactorStatus['webauthn'][number] {
+    const pending = this.pendingRegistrations.get(userId);
+    if (!pending || pending.expiresAt < Date.now()) {
+      throw new SecondFactorError('No security key registration in progress');
+    }
+    this.pendingRegistrations.delete(userId);
+
+    let registered: RegisteredCredential;
+    try {
+      registered = verifyRegistration(registration, pending);
+    } catch (error) {
+      if (error instanceof WebAuthnError) {
+        throw new SecondFactorError(error.message);
+      }
+      throw error;
+    }
+
+    const factors = this.factors(userId);
+    if (factors.webauthn.some((credential) => credential.id === registered.id)) {
+      throw new SecondFactorError('Security key is already registered', 409);
+    }
+    const credential: StoredWebAuthnCredential = {
+      ...registered,
+      name:
+        typeof name === 'string' && name.trim()
+          ? name.trim().slice(0, MAX_CREDENTIAL_NAME)
+          : `Security key ${factors.webauthn.length + 1}`,
+      rpId: pending.rpId,
+      createdAt: new Date().toISOString(),
+    };
+    factors.webauthn.push(credential);
+    this.save();
+    logger.log(`user ${userId} registered security key ${credential.name} for ${pending.rpId}`);
+    return {
+      id: credential.id,
+      name: credential.name,
+      rpId: credential.rpId,
+      createdAt: credential.createdAt,
+    };
+  }
+
+  removeWebAuthn(userId: string, credentialId: string): boolean {
+    const factors = this.users.get(userId);
+    const index = factors?.webauthn.findIndex((credential) => credential.id === credentialId);
+    if (!factors || index === undefined || index === -1) return false;
+    factors.webauthn.splice(index, 1);
+    this.save();
+    logger.log(`user ${userId} removed a security key`);
+    return true;
+  }
+
+  destroy(): void {
+    clearInterval(this.cleanupTimer);
+  }
+
+  private methods(userId: string): SecondFactorMethod[] {
+    const factors = this.users.get(userId);
+    const methods: SecondFactorMethod[] = [];
+    if (factors?.totp) methods.push('totp');
+    if (factors?.webauthn.length) methods.push('webauthn');
+    return methods;
+  }
+
+  private factors(userId: string): UserFactors {
+    let factors = this.users.get(userId);
+    if (!factors) {
+      factors = { webauthn: [] };
+      this.users.set(userId, factors);
+    }
+    return factors;
+  }
+
+  private relyingParty(originHeader: unknown): { origin: string; rpId: string } {
+    try {
+      return relyingParty(originHeader);
+    } catch (error) {
+      throw new SecondFactorError(error instanceof Error ? error.message : String(error));
+    }
+  }
+
+  private getPendingLogin(pendingToken: string): PendingLogin {
+    const pending = this.pendingLogins.get(pendingToken);
+    if (!pending || pending.expiresAt < Date.now()) {
+      this.pendingLogins.delete(pendingToken);
+      throw new SecondFactorError('Login expired, please sign in again', 401);
+    }
+    return pending;
+  }
+
+  private countFailure(pendingToken: string, pending: PendingLogin): void {
+    pending.attempts++;
+    if (pending.attempts >= MAX_ATTEMPTS) {
+      logger.warn(`too many failed second factor attempts for ${pending.userId}`);
+      this.pendingLogins.delete(pendingToken);
+    }
+  }
+
+  private cleanupExpired(): void {
+    const now = Date.now();
+    for (const pending of [this.pendingLogins, this.pendingTotp, this.pendingRegistrations]) {
+      for (const [key, entry] of pending) {
+        if (entry.expiresAt < now) pending.delete(key);
+      }
+    }
+  }
+
+  private load(): void {
+    try {
+      if (!fs.existsSync(this.filePath)) return;
+      const stored = JSON.parse(fs.readFileSync(this.filePath, 'utf8')) as {
+        users?: Record<string, UserFactors>;
+      };
+      for (const [userId, factors] of Object.entries(stored.users ?? {})) {
+        this.users.set(userId, { totp: factors.totp, webauthn: factors.webauthn ?? [] });
+      }
+      logger.debug(`loaded second factors of ${this.users.size} users`);
+    } catch (error) {
+      // Failing open would disable everyone's second factor
+      logger.error(`failed to load second factors from ${this.filePath}:`, error);
+      throw error;
+    }
+  }
+
+  private save(): void {
+    const users = Object.fromEntries(
+      Array.from(this.users).filter(([, factors]) => factors.totp || factors.webauthn.length > 0)
+    );
+    try {
+      fs.mkdirSync(path.dirname(this.filePath), { recursive: true });
+      const tempPath = `${this.filePath}.tmp`;
+      fs.writeFileSync(tempPath, JSON.stringify({ users }, null, 2), { mode: 0o600 });
+      fs.renameSync(tempPath, this.filePath);
+    } catch (error) {
+      logger.error('failed to save second factors:', error);
+    }
+  }
+}
@@ -0,0 +1,250 @@
+import { spawn } from 'child_process';
+import * as crypto from 'crypto';
+import { once } from 'events';
+import * as fs from 'fs';
+import type { Server } from 'http';
+import * as path from 'path';
+import { Readable } from 'stream';
+import { finished } from 'stream/promises';
+import type { ReadableStream } from 'stream/web';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('self-updater');
+
+// Largest executable accepted for an update
+const MAX_UPDATE_BYTES = 512 * 1024 * 1024;
+const DOWNLOAD_TIMEOUT_MS = 5 * 60 * 1000;
+// Time the new process gets to take over the listening socket
+const HANDOFF_TIMEOUT_MS = 30 * 1000;
+
+export type UpdateState = 'idle' | 'downloading' | 'installed' | 'restarting' | 'failed';
+
+export interface UpdateStatus {
+  state: UpdateState;
+  url?: string;
+  sha256?: string;
+  startedAt?: string;
+  finishedAt?: string;
+  error?: string;
+}
+
+export interface UpdateRequest {
+  url: string;
+  sha256: string;
+  // Hand the listening socket to the new executable and exit once it serves
+  restart: boolean;
+}
+
+export class UpdateError extends Error {
+  constructor(
+    message: string,
+    readonly status = 400
+  ) {
+    super(message);
+    this.name = 'UpdateError';
+  }
+}
+
+/**
+ * Start a new server process from the given executable and pass it the
+ * listening socket. Resolves once the new process reports that it serves
+ * requests; the caller is then expected to shut down.
+ */
+export function handOffServer(server: Server, executablePath: string): Promise<void> {
+  return new Promise((resolve, reject) => {
+    const child = spawn(executablePath, process.argv.slice(2), {
+      detached: true,
+      stdio: ['ignore', 'inherit', 'inherit', 'ipc'],
+      env: { ...process.env, VIBETUNNEL_HANDOFF: '1' },
+    });
+
+    const fail = (error: Error) => {
+      clearTimeout(timeout);
+      reject(error);
+    };
+    const timeout = setTimeout(() => {
+      child.kill('SIGKILL');
+      fail(new Error('New server did not take over the socket in time'));
+    }, HANDOFF_TIMEOUT_MS);
+
+    child.once('error', fail);
+    child.once('exit', (code, signal) => fail(new Error(`New server exited (${code ?? signal})`)));
+    child.on('message', (message: { type?: string }) => {
+      if (message?.type !== 'server-started') return;
+      clearTimeout(timeout);
+      child.removeAllListeners('exit');
+      child.disconnect();
+      child.unref();
+      resolve();
+    });
+
+    child.send({ type: 'handoff' }, server);
+  });
+}
+
+/**
+ * Replaces the standalone vibetunnel executable with a downloaded build after
+ * verifying its SHA-256 checksum, and restarts through a socket handoff. The
+ * replaced executable is kept as `<executable>.previous` for rollback.
+ */
+export class SelfUpdater {
+  private readonly updatesDir: string;
+  private readonly executablePath: string;
+  private readonly maxUpdateBytes: number;
+  private status: UpdateStatus = { state: 'idle' };
+  private restartHandler: (() => Promise<void>) | null = null;
+
+  constructor(
+    controlDir: string,
+    executablePath = process.execPath,
+    maxUpdateBytes = MAX_UPDATE_BYTES
+  ) {
+    this.updatesDir = path.join(controlDir, 'updates');
+    this.executablePath = executablePath;
+    this.maxUpdateBytes = maxUpdateBytes;
+  }
+
+  /**
+   * Updates replace the executable, so they are only possible in the packaged build
+   */
+  isSupported(): boolean {
+    return !!process.env.VIBETUNNEL_SEA;
+  }
+
+  getStatus(): UpdateStatus {
+    return { ...this.status };
+  }
+
+  setRestartHandler(handler: () => Promise<void>): void {
+    this.restartHandler = handler;
+  }
+
+  /**
+   * Download, verify and install an executable. With `restart` the restart is
+   * started after this resolves, so the response can still be sent.
+   */
+  async update(request: UpdateRequest): Promise<UpdateStatus> {
+    if (this.status.state === 'downloading' || this.status.state === 'restarting') {
+      throw new UpdateError('An update is already in progress', 409);
+    }
+    if (!this.isSupported()) {
+      throw new UpdateError('Updates require the standalone vibetunnel executable', 409);
+    }
+    if (request.restart && !this.restartHandler) {
+      throw new UpdateError('Restart is not available', 409);
+    }
+
+    const sha256 = request.sha256.toLowerCase();
+    this.status = {
+      state: 'downloading',
+      url: request.url,
+      sha256,
+      startedAt: new Date().toISOString(),
+    };
+    logger.log(`downloading update from ${request.url}`);
+
+    try {
+      const staged = await this.download(request.url, sha256);
+      this.install(staged);
+    } catch (error) {
+      this.fail(error);
+      throw error;
+    }
+
+    this.status = { ...this.status, state: 'installed', finishedAt: new Date().toISOString() };
+    logger.log(`update ${sha256.slice(0, 12)} installed to ${this.executablePath}`);
+
+    if (request.restart) {
+      setImmediate(() => this.restart());
+    }
+    return this.getStatus();
+  }
+
+  private async download(url: string, sha256: string): Promise<string> {
+    fs.mkdirSync(this.updatesDir, { recursive: true });
+    const staged = path.join(this.updatesDir, `vibetunnel-${sha256.slice(0, 12)}`);
+    const partial = `${staged}.partial`;
+
+    const response = await fetch(url, { signal: AbortSignal.timeout(DOWNLOAD_TIMEOUT_MS) });
+    if (!response.ok || !response.body) {
+      throw new UpdateError(`Download failed: HTTP ${response.status}`, 502);
+    }
+
+    const hash = crypto.createHash('sha256');
+    const file = fs.createWriteStream(partial, { mode: 0o755 });
+    let size = 0;
+    try {
+      for await (const chunk of Readable.fromWeb(response.body as ReadableStream<Uint8Array>)) {
+        size += chunk.length;
+        if (size > this.maxUpdateBytes) {
+          throw new UpdateError('Update exceeds the size limit', 413);
+        }
+        hash.update(chunk);
+        if (!file.write(chunk)) {
+          await once(file, 'drain');
+        }
+      }
+      file.end();
+      await finished(file);
+
+      const digest = hash.digest('hex');
+      if (digest !== sha256) {
+        throw new UpdateError(`Checksum mismatch: expected ${sha256}, got ${digest}`, 422);
+      }
+      fs.renameSync(partial, staged);
+      logger.debug(`downloaded and verified ${size} bytes to ${staged}`);
+      return staged;
+    } catch (error) {
+      file.destroy();
+      fs.rmSync(partial, { force: true });
+      throw error;
+    }
+  }
+
+  private install(staged: string): void {
+    const next = `${this.executablePath}.new`;
+    // Copy next to the executable first so the final rename stays on one filesystem
+    fs.copyFileSync(staged, next);
+    fs.chmodSync(next, 0o755);
+    fs.copyFileSync(this.executablePath, `${this.executablePath}.previous`);
+    // The running process keeps the old inode; new processes start the new build
+    fs.renameSync(next, this.executablePath);
+    fs.rmSync(staged, { force: true });
+  }
+
+  private rollback(): void {
+    const previous = `${this.executablePath}.previous`;
+    try {
+      fs.renameSync(previous, this.executablePath);
+      logger.warn('rolled back to the previous executable');
+    } catch (error) {
+      logger.error('failed to roll back the executable:', error);
+    }
+  }
+
+  private async restart(): Promise<void> {
+    if (!this.restartHandler) return;
+    this.status = { ...this.status, state: 'restarting' };
+    logger.log('restarting into the updated executable');
+    try {
+      await this.restartHandler();
+    } catch (error) {
+      logger.error('restart after update failed:', error);
+      this.rollback();
+      this.fail(error);
+    }
+  }
+
+  private fail(error: unknown): void {
+    this.status = {
+      ...this.status,
+      state: 'failed',
+      finishedAt: new Date().toISOString(),
+      error: error instanceof Error ? error.message : String(error),
+    };
+  }
+
+  getExecutablePath(): string {
+    return this.executablePath;
+  }
+}
@@ -0,0 +1,91 @@
+import * as fs from 'fs';
+import * as path from 'path';
+import { v4 as uuidv4 } from 'uuid';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('session-groups');
+
+export interface SessionGroup {
+  id: string;
+  name: string;
+  sessionIds: string[];
+  createdAt: string;
+}
+
+/**
+ * Named groups of sessions that belong together (e.g. editor + server + logs).
+ * Groups are stored in `groups.json` in the control directory so they survive
+ * server restarts like the sessions themselves.
+ */
+export class SessionGroupStore {
+  private groups = new Map<string, SessionGroup>();
+  private filePath: string;
+
+  constructor(controlDir: string) {
+    this.filePath = path.join(controlDir, 'groups.json');
+    this.load();
+  }
+
+  create(name: string, sessionIds: string[]): SessionGroup {
+    const group: SessionGroup = {
+      id: uuidv4(),
+      name,
+      sessionIds: [...sessionIds],
+      createdAt: new Date().toISOString(),
+    };
+    this.groups.set(group.id, group);
+    this.save();
+    logger.log(`group ${group.name} (${group.id}) created with ${sessionIds.length} sessions`);
+    return group;
+  }
+
+  get(groupId: string): SessionGroup | undefined {
+    return this.groups.get(groupId);
+  }
+
+  list(): SessionGroup[] {
+    return Array.from(this.groups.values());
+  }
+
+  /**
+   * Group a session belongs to, if any
+   */
+  findBySessionId(sessionId: string): SessionGroup | undefined {
+    for (const group of this.groups.values()) {
+      if (group.sessionIds.includes(sessionId)) {
+        return group;
+      }
+    }
+    return undefined;
+  }
+
+  delete(groupId: string): boolean {
+    const deleted = this.groups.delete(groupId);
+    if (deleted) {
+      this.save();
+      logger.log(`group ${groupId} deleted`);
+    }
+    return deleted;
+  }
+
+  private load(): void {
+    try {
+      if (!fs.existsSync(this.filePath)) return;
+      const groups = JSON.parse(fs.readFileSync(this.filePath, 'utf8')) as SessionGroup[];
+      for (const group of groups) {
+        this.groups.set(group.id, group);
+      }
+      logger.debug(`loaded ${this.groups.size} session groups`);
+    } catch (error) {
+      logger.error('failed to load session groups:', error);
+    }
+  }
+
+  private save(): void {
+    try {
+      fs.writeFileSync(this.filePath, JSON.stringify(this.list(), null, 2));
+    } catch (error) {
+      logger.error('failed to save session groups:', error);
+    }
+  }
+}
@@ -0,0 +1,217 @@
+import * as fs from 'fs';
+import * as path from 'path';
+import type { SessionPriority } from '../../shared/types.js';
+import { validateControlCommand } from '../pty/control-protocol.js';
+import { isSessionPriority, SESSION_PRIORITIES } from '../pty/session-priority.js';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('session-templates');
+
+// Template names appear in URLs
+const TEMPLATE_NAME_PATTERN = /^[A-Za-z0-9._-]{1,64}$/;
+const MAX_PORTS = 20;
+const MAX_STARTUP_COMMANDS = 50;
+
+export interface SessionTemplate {
+  name: string;
+  description?: string;
+  // The user's shell when not given
+  command?: string[];
+  workingDir?: string;
+  env?: Record<string, string>;
+  // Ports the session's services listen on, recorded on launched sessions
+  ports?: number[];
+  // Command lines run when the session starts
+  startup?: string[];
+  tags?: string[];
+  priority?: SessionPriority;
+  // User who created the template; templates of operators have none and are shared
+  owner?: string;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export type SessionTemplateInput = Omit<SessionTemplate, 'owner' | 'createdAt' | 'updatedAt'>;
+
+// Fields taken from request bodies; anything else is ignored
+const TEMPLATE_FIELDS: ReadonlyArray<keyof SessionTemplateInput> = [
+  'name',
+  'description',
+  'command',
+  'workingDir',
+  'env',
+  'ports',
+  'startup',
+  'tags',
+  'priority',
+];
+
+function pickFields<T extends object>(body: T): Partial<T> {
+  return Object.fromEntries(
+    Object.entries(body).filter(
+      ([key, value]) =>
+        TEMPLATE_FIELDS.includes(key as keyof SessionTemplateInput) && value !== undefined
+    )
+  ) as Partial<T>;
+}
+
+export class TemplateError extends Error {
+  constructor(
+    message: string,
+    public readonly code: 'INVALID_REQUEST' | 'CONFLICT' = 'INVALID_REQUEST'
+  ) {
+    super(message);
+    this.name = 'TemplateError';
+  }
+}
+
+const isStringArray = (value: unknown): value is string[] =>
+  Array.isArray(value) && value.every((item) => typeof item === 'string');
+
+/**
+ * Validate a template body. With `partial` only the given fields are checked.
+ * Returns an error message or null.
+ */
+export function validateTemplate(body: Record<string, unknown>, partial: boolean): string | null {
+  const { name, description, command, workingDir, env, ports, startup, priority } = body;
+  if ((!partial || name !== undefined) && !TEMPLATE_NAME_PATTERN.test(String(name ?? ''))) {
+    return 'name must be 1-64 characters of [A-Za-z0-9._-]';
+  }
+  if (description !== undefined && typeof description !== 'string') {
+    return 'description must be a string';
+  }
+  if (command !== undefined && (!isStringArray(command) || command.length === 0)) {
+    return 'command must be a non-empty array of strings';
+  }
+  if (workingDir !== undefined && typeof workingDir !== 'string') {
+    return 'workingDir must be a string';
+  }
+  if (env !== undefined) {
+    const error = validateControlCommand({ cmd: 'env', set: env });
+    if (error) return error.replace(/^set /, 'env ');
+  }
+  if (
+    ports !== undefined &&
+    (!Array.isArray(ports) ||
+      ports.length > MAX_PORTS ||
+      !ports.every((port) => Number.isInteger(port) && port > 0 && port <= 65535))
+  ) {
+    return `ports must be an array of at most ${MAX_PORTS} port numbers`;
+  }
+  if (
+    startup !== undefined &&
+    (!isStringArray(startup) || startup.length > MAX_STARTUP_COMMANDS)
+  ) {
+    return `startup must be an array of at most ${MAX_STARTUP_COMMANDS} command lines`;
+  }
+  if (body.tags !== undefined && !isStringArray(body.tags)) {
+    return 'tags must be an array of strings';
+  }
+  if (priority !== undefined && !isSessionPriority(priority)) {
+    return `priority must be one of ${SESSION_PRIORITIES.join(', ')}`;
+  }
+  return null;
+}
+
+/**
+ * Named environments sessions are launched from: a command, working directory,
+ * environment, declared ports and startup commands. Templates are stored in
+ * `templates.json` in the control directory.
+ */
+export class SessionTemplateStore {
+  private templates = new Map<string, SessionTemplate>();
+  private filePath: string;
+
+  constructor(controlDir: string) {
+    this.filePath = path.join(controlDir, 'templates.json');
+    this.load();
+  }
+
+  list(): SessionTemplate[] {
+    return Array.from(this.templates.values()).sort((a, b) => a.name.localeCompare(b.name));
+  }
+
+  get(name: string): SessionTemplate | undefined {
+    return this.templates.get(name);
+  }
+
+  /**
+   * Add a template, owned by `owner` if given. Throws TemplateError for invalid
+   * input or a taken name.
+   */
+  create(input: SessionTemplateInput, owner?: string): SessionTemplate {
+    const fields = pickFields(input) as SessionTemplateInput;
+    const error = validateTemplate({ ...fields }, false);
+    if (error) throw new TemplateError(error);
+    if (this.templates.has(fields.name)) {
+      throw new TemplateError(`Template ${fields.name} already exists`, 'CONFLICT');
+    }
+
+    const now = new Date().toISOString();
+    const template: SessionTemplate = {
+      ...fields,
+      ...(owner ? { owner } : {}),
+      createdAt: now,
+      updatedAt: now,
+    };
+    this.templates.set(template.name, template);
+    this.save();
+    logger.log(`template ${template.name} created`);
+    return template;
+  }
+
+  /**
+   * Change a template; null removes an optional field. Renaming is not
+   * supported, as launched sessions refer to templates by name.
+   */
+  update(
+    name: string,
+    changes: { [K in keyof SessionTemplateInput]?: SessionTemplateInput[K] | null }
+  ): SessionTemplate | undefined {
+    const template = this.templates.get(name);
+    if (!template) return undefined;
+
+    const { name: _name, ...fields } = pickFields(changes);
+    const set = Object.fromEntries(Object.entries(fields).filter(([, value]) => value !== null));
+    const error = validateTemplate(set, true);
+    if (error) throw new TemplateError(error);
+
+    const updated: SessionTemplate = { ...template, ...set, updatedAt: new Date().toISOString() };
+    for (const [key, value] of Object.entries(fields)) {
+      if (value === null) delete updated[key as Exclude<keyof SessionTemplateInput, 'name'>];
+    }
+    this.templates.set(name, updated);
+    this.save();
+    return updated;
+  }
+
+  delete(name: string): boolean {
+    const deleted = this.templates.delete(name);
+    if (deleted) {
+      this.save();
+      logger.log(`template ${name} deleted`);
+    }
+    return deleted;
+  }
+
+  private load(): void {
+    try {
+      if (!fs.existsSync(this.filePath)) return;
+      const templates = JSON.parse(fs.readFileSync(this.filePath, 'utf8')) as SessionTemplate[];
+      for (const template of templates) {
+        this.templates.set(template.name, template);
+      }
+      logger.debug(`loaded ${this.templates.size} session templates`);
+    } catch (error) {
+      logger.error('failed to load session templates:', error);
+    }
+  }
+
+  private save(): void {
+    try {
+      fs.writeFileSync(this.filePath, JSON.stringify(this.list(), null, 2));
+    } catch (error) {
+      logger.error('failed to save session templates:', error);
+    }
+  }
+}
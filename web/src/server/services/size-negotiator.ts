@@ -0,0 +1,206 @@
+/**
+ * SizeNegotiator - Decides the PTY size when several viewers watch a session
+ *
+ * Every viewer reports the size of its terminal. Instead of the last resize
+ * request winning a race, a per-session policy picks the effective size:
+ *
+ * - follow-last: the viewer that resized most recently (previous behavior)
+ * - largest-wins: the largest cols and rows of all viewers; smaller viewers
+ *   receive snapshots cropped to their own size
+ * - owner-wins: the owner viewer (the first one to report unless set
+ *   explicitly); other viewers are cropped
+ * - fixed: a configured size regardless of viewers
+ */
+
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('size-negotiator');
+
+export const SIZE_POLICIES = ['follow-last', 'largest-wins', 'owner-wins', 'fixed'] as const;
+
+export type SizePolicy = (typeof SIZE_POLICIES)[number];
+
+export interface TerminalSize {
+  cols: number;
+  rows: number;
+}
+
+export interface SizePolicySettings {
+  policy: SizePolicy;
+  // Viewer whose size is used by owner-wins
+  ownerId?: string;
+  // Size used by fixed
+  fixedSize?: TerminalSize;
+}
+
+export interface SizeNegotiatorOptions {
+  defaultPolicy?: SizePolicy;
+  // Viewers that have not reported a size for this long are ignored
+  viewerTtlMs?: number;
+}
+
+interface ViewerSize extends TerminalSize {
+  updatedAt: number;
+}
+
+interface SessionSizeState {
+  settings: SizePolicySettings;
+  viewers: Map<string, ViewerSize>;
+  applied?: TerminalSize;
+}
+
+const DEFAULT_VIEWER_TTL_MS = 10 * 60 * 1000;
+
+export function isSizePolicy(value: unknown): value is SizePolicy {
+  return typeof value === 'string' && (SIZE_POLICIES as readonly string[]).includes(value);
+}
+
+export class SizeNegotiator {
+  private sessions = new Map<string, SessionSizeState>();
+  private defaultPolicy: SizePolicy;
+  private viewerTtlMs: number;
+
+  constructor(options: SizeNegotiatorOptions = {}) {
+    this.defaultPolicy = options.defaultPolicy ?? 'follow-last';
+    this.viewerTtlMs = options.viewerTtlMs ?? DEFAULT_VIEWER_TTL_MS;
+  }
+
+  getDefaultPolicy(): SizePolicy {
+    return this.defaultPolicy;
+  }
+
+  getSettings(sessionId: string): SizePolicySettings {
+    return { ...this.getState(sessionId).settings };
+  }
+
+  /**
+   * Change the policy of a session. Returns the size to apply to the PTY, or
+   * null if it does not change.
+   */
+  setSettings(sessionId: string, settings: SizePolicySettings): TerminalSize | null {
+    const state = this.getState(sessionId);
+    state.settings = { ...settings };
+    logger.debug(`session ${sessionId} size policy set to ${settings.policy}`);
+    return this.apply(sessionId, state);
+  }
+
+  /**
+   * Record the terminal size of a viewer. Returns the size to apply to the
+   * PTY, or null if it does not change. Under follow-last the reporting viewer
+   * always takes over, even if the size looks unchanged, since something
+   * else (e.g. an external terminal) may have resized the PTY in between.
+   */
+  reportViewerSize(sessionId: string, viewerId: string, size: TerminalSize): TerminalSize | null {
+    const state = this.getState(sessionId);
+    // Without an explicit owner the first viewer owns the session
+    if (state.settings.policy === 'owner-wins' && !state.settings.ownerId) {
+      state.settings.ownerId = viewerId;
+    }
+    // Re-insert so map order follows the most recent report
+    state.viewers.delete(viewerId);
+    state.viewers.set(viewerId, { cols: size.cols, rows: size.rows, updatedAt: Date.now() });
+    return this.apply(sessionId, state, state.settings.policy === 'follow-last');
+  }
+
+  /**
+   * Forget a viewer, e.g. when its tab closes. Returns the size to apply to
+   * the PTY, or null if it does not change.
+   */
+  removeViewer(sessionId: string, viewerId: string): TerminalSize | null {
+    const state = this.sessions.get(sessionId);
+    if (!state || !state.viewers.delete(viewerId)) {
+      return null;
+    }
+    return this.apply(sessionId, state);
+  }
+
+  /**
+   * Size reported by a viewer, used to crop the snapshots it receives
+   */
+  getViewerSize(sessionId: string, viewerId: string): TerminalSize | undefined {
+    const viewer = this.sessions.get(sessionId)?.viewers.get(viewerId);
+    return viewer && { cols: viewer.cols, rows: viewer.rows };
+  }
+
+  /**
+   * Size the policy currently selects, or null if there is nothing to decide on
+   */
+  getEffectiveSize(sessionId: string): TerminalSize | null {
+    const state = this.sessions.get(sessionId);
+    return state ? this.computeSize(state) : null;
+  }
+
+  getViewerCount(sessionId: string): number {
+    const state = this.sessions.get(sessionId);
+    if (!state) return 0;
+    this.pruneViewers(state);
+    return state.viewers.size;
+  }
+
+  clearSession(sessionId: string): void {
+    this.sessions.delete(sessionId);
+  }
+
+  private getState(sessionId: string): SessionSizeState {
+    let state = this.sessions.get(sessionId);
+    if (!state) {
+      state = { settings: { policy: this.defaultPolicy }, viewers: new Map() };
+      this.sessions.set(sessionId, state);
+    }
+    return state;
+  }
+
+  private apply(sessionId: string, state: SessionSizeState, force = false): TerminalSize | null {
+    const size = this.computeSize(state);
+    if (!size) {
+      return null;
+    }
+    if (!force && state.applied?.cols === size.cols && state.applied.rows === size.rows) {
+      return null;
+    }
+    state.applied = size;
+    logger.debug(
+      `session ${sessionId} negotiated size ${size.cols}x${size.rows} (${state.settings.policy})`
+    );
+    return size;
+  }
+
+  private computeSize(state: SessionSizeState): TerminalSize | null {
+    const { settings } = state;
+    if (settings.policy === 'fixed') {
+      return settings.fixedSize ? { ...settings.fixedSize } : null;
+    }
+
+    this.pruneViewers(state);
+    const viewers = Array.from(state.viewers.values());
+    if (viewers.length === 0) {
+      return null;
+    }
+
+    switch (settings.policy) {
+      case 'largest-wins':
+        return {
+          cols: Math.max(...viewers.map((viewer) => viewer.cols)),
+          rows: Math.max(...viewers.map((viewer) => viewer.rows)),
+        };
+      case 'owner-wins': {
+        // Keep the current size while the owner is away
+        const owner = settings.ownerId ? state.viewers.get(settings.ownerId) : undefined;
+        return owner ? { cols: owner.cols, rows: owner.rows } : null;
+      }
+      default: {
+        const last = viewers[viewers.length - 1];
+        return { cols: last.cols, rows: last.rows };
+      }
+    }
+  }
+
+  private pruneViewers(state: SessionSizeState): void {
+    const cutoff = Date.now() - this.viewerTtlMs;
+    for (const [viewerId, viewer] of state.viewers) {
+      if (viewer.updatedAt < cutoff) {
+        state.viewers.delete(viewerId);
+      }
+    }
+  }
+}
@@ -1,28 +1,177 @@
 import chalk from 'chalk';
-import type { Response } from 'express';
 import * as fs from 'fs';
+import type { SessionAnnotation } from '../../shared/types.js';
+import {
+  type OutputLine,
+  type OutputListener,
+  type OutputSubscription,
+  splitBacklog,
+} from '../pty/output-broadcaster.js';
 import { createLogger } from '../utils/logger.js';
+import { decodeCastLine } from '../utils/recording-crypto.js';
+import { guardSessionTask } from './crash-reporter.js';
+import {
+  type FileWatcherPool,
+  type FileWatchHandle,
+  fileWatcherPool,
+} from './file-watcher-pool.js';
+import {
+  createBucket,
+  DEFAULT_OUTPUT_THROTTLE,
+  type OutputThrottleSettings,
+  type TokenBucket,
+} from './output-throttle.js';
 
 const logger = createLogger('stream-watcher');
 
+/**
+ * Format an SSE event. The ID is the byte offset just past the event's line in
+ * the stream file, so a reconnecting client's Last-Event-ID says where to resume.
+ */
+function formatEvent(data: string, id?: number): string {
+  return id !== undefined ? `id: ${id}\ndata: ${data}\n\n` : `data: ${data}\n\n`;
+}
+
+/**
+ * Read the unterminated last line of a file (empty when it ends with a newline)
+ */
+function readUnterminatedLine(filePath: string, size: number): string {
+  const fd = fs.openSync(filePath, 'r');
+  try {
+    const chunks: Buffer[] = [];
+    let end = size;
+    while (end > 0) {
+      const start = Math.max(0, end - 64 * 1024);
+      const chunk = Buffer.alloc(end - start);
+      fs.readSync(fd, chunk, 0, chunk.length, start);
+      const newline = chunk.lastIndexOf(0x0a);
+      chunks.unshift(newline === -1 ? chunk : chunk.subarray(newline + 1));
+      if (newline !== -1) break;
+      end = start;
+    }
+    return Buffer.concat(chunks).toString('utf8');
+  } finally {
+    fs.closeSync(fd);
+  }
+}
+
+/**
+ * Where a client's events are written: its SSE response, or a transform in
+ * front of it (see plain-text-stream.ts)
+ */
+export interface StreamSink {
+  write(chunk: string): boolean;
+  end(): void;
+  locals?: Record<string, unknown>;
+}
+
 interface StreamClient {
-  response: Response;
+  response: StreamSink;
   startTime: number;
+  // Output cap of this viewer (see output-throttle.ts)
+  throttle: ViewerThrottle;
+  // Annotations not yet merged into the replay, by time; unset once the replay is done
+  markers?: SessionAnnotation[];
+  // Set when the client is fed directly from the in-process output broadcaster
+  live?: {
+    unsubscribe: () => void;
+    replaying: boolean;
+    pending: OutputLine[];
+  };
+}
+
+interface ViewerThrottle {
+  bucket: TokenBucket | null;
+  // Set while output is skipped; the screen is redrawn when the timer fires
+  skipping?: {
+    timer: NodeJS.Timeout;
+    // Lines arriving while the redraw is rendered, sent after it
+    held?: OutputLine[];
+  };
+}
+
+/**
+ * Source of live cast lines for sessions running in this process (PtyManager)
+ */
+export interface LiveOutputSource {
+  subscribeToOutput(sessionId: string, listener: OutputListener): OutputSubscription | null;
+}
+
+/**
+ * Source of the annotations merged into replays as marker events (AnnotationStore)
+ */
+export interface AnnotationSource {
+  list(sessionId: string): SessionAnnotation[];
+}
+
+/**
+ * Renders the current screen of a session and the stream offset it reflects,
+ * for viewers catching up after output was skipped (TerminalManager)
+ */
+export interface RedrawSource {
+  getRedraw(sessionId: string): Promise<{ ansi: string; offset: number } | null>;
+}
+
+interface StreamWatcherOptions {
+  watcherPool?: FileWatcherPool;
+  liveOutput?: LiveOutputSource;
+  annotations?: AnnotationSource;
+  redraw?: RedrawSource;
+  throttle?: OutputThrottleSettings;
 }
 
 interface WatcherInfo {
   clients: Set<StreamClient>;
-  watcher?: fs.FSWatcher;
+  watcher?: FileWatchHandle;
   lastOffset: number;
   lastSize: number;
   lastMtime: number;
   lineBuffer: string;
+  // Output cap of all viewers of the session together
+  bucket: TokenBucket | null;
+  // Output bytes not sent to throttled viewers
+  skippedBytes: number;
+}
+
+/**
+ * Output cap state of the viewers of one streamed session
+ */
+export interface StreamThrottleStats {
+  sessionId: string;
+  viewers: number;
+  throttledViewers: number;
+  // Output bytes not sent to throttled viewers
+  skippedBytes: number;
+  // Bytes all viewers together may still receive at once (with a session cap)
+  sessionBudgetBytes?: number;
+}
+
+// Output held while a session is paused, sent on resume after the redraw
+const MAX_HELD_LINES = 256;
+// Shortest time output of a throttled viewer is skipped before the redraw
+const MIN_SKIP_MS = 250;
+
+interface PausedSession {
+  held: OutputLine[];
+  // End offset of the last held line; live clients each see the same lines
+  heldOffset: number;
 }
 
 export class StreamWatcher {
   private activeWatchers: Map<string, WatcherInfo> = new Map();
-
-  constructor() {
+  private watcherPool: FileWatcherPool;
+  private liveOutput: LiveOutputSource | null;
+  private annotations: AnnotationSource | null;
+  private redraw: RedrawSource | null;
+  private throttle: OutputThrottleSettings;
+  private pausedSessions: Map<string, PausedSession> = new Map();
+
+  constructor(options: StreamWatcherOptions = {}) {
+    this.watcherPool = options.watcherPool ?? fileWatcherPool;
+    this.liveOutput = options.liveOutput ?? null;
+    this.annotations = options.annotations ?? null;
+    this.redraw = options.redraw ?? null;
+    this.throttle = options.throttle ?? DEFAULT_OUTPUT_THROTTLE;
     // Clean up notification listeners on exit
     process.on('beforeExit', () => {
       this.cleanup();
@@ -31,46 +180,79 @@ export class StreamWatcher {
   }
 
   /**
-   * Add a client to watch a stream file
+   * Add a client to watch a stream file. With a resume offset (the Last-Event-ID
+   * of a reconnecting client) only events after that offset are replayed.
    */
-  addClient(sessionId: string, streamPath: string, response: Response): void {
+  addClient(
+    sessionId: string,
+    streamPath: string,
+    response: StreamSink,
+    resumeOffset = 0
+  ): void {
     logger.debug(`adding client to session ${sessionId}`);
     const startTime = Date.now() / 1000;
-    const client: StreamClient = { response, startTime };
+    const client: StreamClient = {
+      response,
+      startTime,
+      throttle: { bucket: createBucket(this.throttle.viewerBytesPerSecond, this.throttle) },
+      // Reconnecting clients already got the annotations
+      markers: resumeOffset ? [] : (this.annotations?.list(sessionId) ?? []),
+    };
 
     let watcherInfo = this.activeWatchers.get(sessionId);
-
     if (!watcherInfo) {
-      // Create new watcher for this session
-      logger.log(chalk.green(`creating new stream watcher for session ${sessionId}`));
       watcherInfo = {
         clients: new Set(),
         lastOffset: 0,
         lastSize: 0,
         lastMtime: 0,
         lineBuffer: '',
+        bucket: createBucket(this.throttle.sessionBytesPerSecond, this.throttle),
+        skippedBytes: 0,
       };
       this.activeWatchers.set(sessionId, watcherInfo);
+    }
 
-      // Send existing content first
-      this.sendExistingContent(streamPath, client);
+    // Sessions running in this process are streamed straight from memory; the
+    // stream file is only used to replay history
+    const liveSubscription = this.liveOutput?.subscribeToOutput(
+      sessionId,
+      guardSessionTask(sessionId, 'stream-live', (entry: OutputLine) =>
+        this.handleLiveLine(sessionId, client, entry)
+      )
+    );
 
-      // Get current file size and stats
+    if (liveSubscription) {
+      this.startLiveClient(sessionId, streamPath, client, liveSubscription, resumeOffset);
+    } else if (!watcherInfo.watcher) {
+      // Create new watcher for this session
+      logger.log(chalk.green(`creating new stream watcher for session ${sessionId}`));
+
+      // Get current file size and stats. A line still being written is left to the
+      // watcher, which completes it once the rest is appended.
+      let replayEnd: number | undefined;
       if (fs.existsSync(streamPath)) {
         const stats = fs.statSync(streamPath);
         watcherInfo.lastOffset = stats.size;
         watcherInfo.lastSize = stats.size;
         watcherInfo.lastMtime = stats.mtimeMs;
+        watcherInfo.lineBuffer = readUnterminatedLine(streamPath, stats.size);
+        replayEnd = stats.size - Buffer.byteLength(watcherInfo.lineBuffer, 'utf8');
         logger.debug(`initial file size: ${stats.size} bytes`);
       } else {
         logger.debug(`stream file does not exist yet: ${streamPath}`);
       }
 
+      // Send existing content first
+      this.sendExistingContent(streamPath, client, replayEnd, undefined, resumeOffset);
+
       // Start watching for new content
       this.startWatching(sessionId, streamPath, watcherInfo);
     } else {
-      // Send existing content to new client
-      this.sendExistingContent(streamPath, client);
+      // Send existing content to new client; the watcher delivers everything after it
+      const replayEnd =
+        watcherInfo.lastOffset - Buffer.byteLength(watcherInfo.lineBuffer, 'utf8');
+      this.sendExistingContent(streamPath, client, replayEnd, undefined, resumeOffset);
     }
 
     // Add client to set
@@ -83,7 +265,7 @@ export class StreamWatcher {
   /**
    * Remove a client
    */
-  removeClient(sessionId: string, response: Response): void {
+  removeClient(sessionId: string, response: StreamSink): void {
     const watcherInfo = this.activeWatchers.get(sessionId);
     if (!watcherInfo) {
       logger.debug(`no watcher found for session ${sessionId}`);
@@ -100,6 +282,8 @@ export class StreamWatcher {
     }
 
     if (clientToRemove) {
+      clientToRemove.live?.unsubscribe();
+      clearTimeout(clientToRemove.throttle.skipping?.timer);
       watcherInfo.clients.delete(clientToRemove);
       logger.log(
         chalk.yellow(
@@ -119,62 +303,84 @@ export class StreamWatcher {
   }
 
   /**
-   * Send existing content to a client
+   * Send existing content (from startOffset up to endOffset) to a client
    */
-  private sendExistingContent(streamPath: string, client: StreamClient): void {
+  private sendExistingContent(
+    streamPath: string,
+    client: StreamClient,
+    endOffset?: number,
+    onComplete?: (exitEventFound: boolean) => void,
+    startOffset = 0
+  ): void {
+    if (endOffset !== undefined && endOffset <= startOffset) {
+      this.finishMarkers(client);
+      onComplete?.(false);
+      return;
+    }
+
     try {
-      const stream = fs.createReadStream(streamPath, { encoding: 'utf8' });
+      // Read raw bytes so event IDs stay exact byte offsets, even when a resume
+      // offset points into the middle of a line
+      const stream = fs.createReadStream(streamPath, {
+        start: startOffset,
+        end: endOffset !== undefined ? endOffset - 1 : undefined,
+      });
       let exitEventFound = false;
-      let lineBuffer = '';
+      let lineBuffer = Buffer.alloc(0);
+      // Byte offset just past the last line read
+      let position = startOffset;
 
-      stream.on('data', (chunk: string | Buffer) => {
-        lineBuffer += chunk.toString();
-        const lines = lineBuffer.split('\n');
-        lineBuffer = lines.pop() || ''; // Keep incomplete line for next chunk
+      const replayLine = (storedLine: string) => {
+        const line = decodeCastLine(storedLine);
+        try {
+          const parsed = JSON.parse(line);
+          if (parsed.version && parsed.width && parsed.height) {
+            // Send header as-is
+            client.response.write(formatEvent(line, position));
+          } else if (Array.isArray(parsed) && parsed.length >= 3) {
+            if (parsed[0] === 'exit') {
+              exitEventFound = true;
+              this.finishMarkers(client);
+              client.response.write(formatEvent(line, position));
+            } else {
+              if (typeof parsed[0] === 'number') this.writeMarkers(client, parsed[0]);
+              // Set timestamp to 0 for existing content
+              const instantEvent = [0, parsed[1], parsed[2]];
+              client.response.write(formatEvent(JSON.stringify(instantEvent), position));
+            }
+          }
+        } catch (e) {
+          logger.debug(`skipping invalid JSON line during replay: ${e}`);
+        }
+      };
 
-        for (const line of lines) {
+      stream.on('data', (chunk: string | Buffer) => {
+        lineBuffer = Buffer.concat([lineBuffer, Buffer.from(chunk)]);
+        let newline = lineBuffer.indexOf(0x0a);
+        while (newline !== -1) {
+          // A resume offset inside a line leaves a fragment that does not parse and is skipped
+          const line = lineBuffer.subarray(0, newline).toString('utf8');
+          position += newline + 1;
           if (line.trim()) {
-            try {
-              const parsed = JSON.parse(line);
-              if (parsed.version && parsed.width && parsed.height) {
-                // Send header as-is
-                client.response.write(`data: ${line}\n\n`);
-              } else if (Array.isArray(parsed) && parsed.length >= 3) {
-                if (parsed[0] === 'exit') {
-                  exitEventFound = true;
-                  client.response.write(`data: ${line}\n\n`);
-                } else {
-                  // Set timestamp to 0 for existing content
-                  const instantEvent = [0, parsed[1], parsed[2]];
-                  client.response.write(`data: ${JSON.stringify(instantEvent)}\n\n`);
-                }
-              }
-            } catch (e) {
-              logger.debug(`skipping invalid JSON line during replay: ${e}`);
-            }
+            replayLine(line);
           }
+          lineBuffer = lineBuffer.subarray(newline + 1);
+          newline = lineBuffer.indexOf(0x0a);
         }
       });
 
       stream.on('end', () => {
         // Process any remaining line
-        if (lineBuffer.trim()) {
-          try {
-            const parsed = JSON.parse(lineBuffer);
-            if (parsed.version && parsed.width && parsed.height) {
-              client.response.write(`data: ${lineBuffer}\n\n`);
-            } else if (Array.isArray(parsed) && parsed.length >= 3) {
-              if (parsed[0] === 'exit') {
-                exitEventFound = true;
-                client.response.write(`data: ${lineBuffer}\n\n`);
-              } else {
-                const instantEvent = [0, parsed[1], parsed[2]];
-                client.response.write(`data: ${JSON.stringify(instantEvent)}\n\n`);
-              }
-            }
-          } catch (e) {
-            logger.debug(`skipping invalid JSON in line buffer: ${e}`);
-          }
+        const lastLine = lineBuffer.toString('utf8');
+        if (lastLine.trim()) {
+          position += lineBuffer.length;
+          replayLine(lastLine);
+        }
+        this.finishMarkers(client);
+
+        if (onComplete) {
+          onComplete(exitEventFound);
+          return;
         }
 
         // If exit event found, close connection
@@ -190,10 +396,201 @@ export class StreamWatcher {
 
       stream.on('error', (error) => {
         logger.error('failed to stream existing content:', error);
+        this.finishMarkers(client);
+        onComplete?.(false);
       });
     } catch (error) {
       logger.error('failed to create read stream:', error);
+      this.finishMarkers(client);
+      onComplete?.(false);
+    }
+  }
+
+  /**
+   * Send the client's pending annotations up to a time in the recording as
+   * marker events, with zeroed timestamps like the rest of the replay
+   */
+  private writeMarkers(client: StreamClient, untilTime: number): void {
+    const markers = client.markers;
+    while (markers?.length && markers[0].time <= untilTime) {
+      const marker = markers.shift() as SessionAnnotation;
+      client.response.write(formatEvent(JSON.stringify([0, 'm', marker.text])));
+    }
+  }
+
+  /**
+   * End of a replay: send the remaining annotations, later ones are sent as they are added
+   */
+  private finishMarkers(client: StreamClient): void {
+    this.writeMarkers(client, Number.POSITIVE_INFINITY);
+    client.markers = undefined;
+  }
+
+  /**
+   * Send an annotation added to a session to its viewers as a marker event
+   */
+  sendAnnotation(sessionId: string, annotation: SessionAnnotation): void {
+    const watcherInfo = this.activeWatchers.get(sessionId);
+    if (!watcherInfo) return;
+
+    for (const client of watcherInfo.clients) {
+      if (client.markers) {
+        // Still replaying: merged at its time
+        client.markers.push(annotation);
+        client.markers.sort((a, b) => a.time - b.time);
+        continue;
+      }
+      const event = [Date.now() / 1000 - client.startTime, 'm', annotation.text];
+      try {
+        client.response.write(formatEvent(JSON.stringify(event)));
+      } catch (error) {
+        logger.debug(
+          `client write failed (likely disconnected): ${error instanceof Error ? error.message : String(error)}`
+        );
+      }
+    }
+  }
+
+  /**
+   * Start streaming to a client from the in-process output broadcaster.
+   *
+   * History is replayed from the stream file (from resumeOffset on) up to the
+   * point where the broadcaster's backlog takes over: the first line not yet
+   * fully flushed to disk. Live lines arriving during the replay are queued and
+   * sent after it.
+   */
+  private startLiveClient(
+    sessionId: string,
+    streamPath: string,
+    client: StreamClient,
+    subscription: OutputSubscription,
+    resumeOffset: number
+  ): void {
+    client.live = {
+      unsubscribe: subscription.unsubscribe,
+      replaying: true,
+      pending: [],
+    };
+
+    let flushedSize = 0;
+    try {
+      flushedSize = fs.statSync(streamPath).size;
+    } catch {
+      // File not created yet - everything comes from the backlog
+    }
+
+    const split = splitBacklog(subscription.backlog, flushedSize);
+    const { fileEndOffset, hasGap } = split;
+    const memoryLines = split.memoryLines.filter((entry) => entry.startOffset >= resumeOffset);
+    if (hasGap) {
+      logger.warn(
+        `output backlog for session ${sessionId} no longer covers unflushed data, stream may have a gap`
+      );
+    }
+
+    logger.debug(
+      `live client for session ${sessionId}: replaying ${fileEndOffset} bytes from file, ${memoryLines.length} lines from memory`
+    );
+
+    const onReplayed = (exitEventFound: boolean) => {
+      const live = client.live;
+      if (!live) return;
+
+      if (exitEventFound) {
+        live.unsubscribe();
+        client.response.end();
+        return;
+      }
+
+      for (const entry of memoryLines) {
+        if (this.sendLineToClient(sessionId, client, entry, true)) return;
+      }
+
+      const pending = live.pending;
+      live.pending = [];
+      live.replaying = false;
+      for (const entry of pending) {
+        if (this.sendLineToClient(sessionId, client, entry, false)) return;
+      }
+    };
+
+    if (resumeOffset >= fileEndOffset) {
+      this.finishMarkers(client);
+      onReplayed(false);
+    } else {
+      this.sendExistingContent(streamPath, client, fileEndOffset, onReplayed, resumeOffset);
+    }
+  }
+
+  /**
+   * Handle a line from the output broadcaster for a live client
+   */
+  private handleLiveLine(sessionId: string, client: StreamClient, entry: OutputLine): void {
+    const live = client.live;
+    if (!live) return;
+    if (live.replaying) {
+      live.pending.push(entry);
+      return;
+    }
+    this.sendLineToClient(sessionId, client, entry, false);
+  }
+
+  /**
+   * Send a cast line to a single client. Replayed events are sent with a zero
+   * timestamp, live events relative to the client's start time.
+   * Returns true if the line was the exit event and the stream was closed.
+   */
+  private sendLineToClient(
+    sessionId: string,
+    client: StreamClient,
+    entry: OutputLine,
+    replay: boolean
+  ): boolean {
+    const { line, endOffset } = entry;
+    let parsed: unknown;
+    try {
+      parsed = JSON.parse(line);
+    } catch (e) {
+      logger.debug(`skipping invalid JSON line for session ${sessionId}: ${e}`);
+      return false;
     }
+
+    try {
+      if (!Array.isArray(parsed)) {
+        const header = parsed as { version?: number; width?: number; height?: number };
+        if (replay && header.version && header.width && header.height) {
+          client.response.write(formatEvent(line, endOffset));
+        }
+        return false;
+      }
+
+      if (parsed.length < 3) return false;
+
+      if (parsed[0] === 'exit') {
+        logger.log(chalk.yellow(`session ${sessionId} ended with exit code ${parsed[1]}`));
+        client.response.write(formatEvent(line, endOffset));
+        client.live?.unsubscribe();
+        client.response.end();
+        return true;
+      }
+
+      if (!replay && this.holdLine(sessionId, entry)) return false;
+
+      const time = replay ? 0 : Date.now() / 1000 - client.startTime;
+      const data = formatEvent(JSON.stringify([time, parsed[1], parsed[2]]), endOffset);
+      if (!replay && parsed[1] === 'o') {
+        this.writeOutput(sessionId, client, data, entry);
+        return false;
+      }
+      client.response.write(data);
+      // @ts-expect-error - flush exists but not in types
+      if (client.response.flush) client.response.flush();
+    } catch (error) {
+      logger.debug(
+        `client write failed (likely disconnected): ${error instanceof Error ? error.message : String(error)}`
+      );
+    }
+    return false;
   }
 
   /**
@@ -202,76 +599,126 @@ export class StreamWatcher {
   private startWatching(sessionId: string, streamPath: string, watcherInfo: WatcherInfo): void {
     logger.log(chalk.green(`started watching stream file for session ${sessionId}`));
 
-    // Use standard fs.watch with stat checking
-    watcherInfo.watcher = fs.watch(streamPath, { persistent: true }, (eventType) => {
-      if (eventType === 'change') {
-        try {
-          // Check if file actually changed by comparing stats
-          const stats = fs.statSync(streamPath);
-
-          // Only process if size increased (append-only file)
-          if (stats.size > watcherInfo.lastSize || stats.mtimeMs > watcherInfo.lastMtime) {
-            const sizeDiff = stats.size - watcherInfo.lastSize;
-            if (sizeDiff > 0) {
-              logger.debug(`file grew by ${sizeDiff} bytes`);
-            }
-            watcherInfo.lastSize = stats.size;
-            watcherInfo.lastMtime = stats.mtimeMs;
-
-            // Read only new data
-            if (stats.size > watcherInfo.lastOffset) {
-              const fd = fs.openSync(streamPath, 'r');
-              const buffer = Buffer.alloc(stats.size - watcherInfo.lastOffset);
-              fs.readSync(fd, buffer, 0, buffer.length, watcherInfo.lastOffset);
-              fs.closeSync(fd);
-
-              // Update offset
-              watcherInfo.lastOffset = stats.size;
-
-              // Process new data
-              const newData = buffer.toString('utf8');
-              watcherInfo.lineBuffer += newData;
-
-              // Process complete lines
-              const lines = watcherInfo.lineBuffer.split('\n');
-              watcherInfo.lineBuffer = lines.pop() || '';
-
-              for (const line of lines) {
-                if (line.trim()) {
-                  this.broadcastLine(sessionId, line, watcherInfo);
-                }
+    // Read what was appended since the last read
+    const readChanges = () => {
+      try {
+        // Check if file actually changed by comparing stats
+        const stats = fs.statSync(streamPath);
+
+        // Only process if size increased (append-only file)
+        if (stats.size > watcherInfo.lastSize || stats.mtimeMs > watcherInfo.lastMtime) {
+          const sizeDiff = stats.size - watcherInfo.lastSize;
+          if (sizeDiff > 0) {
+            logger.debug(`file grew by ${sizeDiff} bytes`);
+          }
+          watcherInfo.lastSize = stats.size;
+          watcherInfo.lastMtime = stats.mtimeMs;
+
+          // Read only new data
+          if (stats.size > watcherInfo.lastOffset) {
+            const fd = fs.openSync(streamPath, 'r');
+            const buffer = Buffer.alloc(stats.size - watcherInfo.lastOffset);
+            fs.readSync(fd, buffer, 0, buffer.length, watcherInfo.lastOffset);
+            fs.closeSync(fd);
+
+            // Byte offset where the buffered incomplete line starts
+            let lineEnd =
+              watcherInfo.lastOffset - Buffer.byteLength(watcherInfo.lineBuffer, 'utf8');
+
+            // Update offset
+            watcherInfo.lastOffset = stats.size;
+
+            // Process new data
+            const newData = buffer.toString('utf8');
+            watcherInfo.lineBuffer += newData;
+
+            // Process complete lines
+            const lines = watcherInfo.lineBuffer.split('\n');
+            watcherInfo.lineBuffer = lines.pop() || '';
+
+            for (const line of lines) {
+              lineEnd += Buffer.byteLength(line, 'utf8') + 1;
+              if (line.trim()) {
+                this.broadcastLine(sessionId, line, watcherInfo, lineEnd);
               }
             }
           }
-        } catch (error) {
-          logger.error('failed to read file changes:', error);
         }
+      } catch (error) {
+        logger.error('failed to read file changes:', error);
       }
-    });
+    };
+
+    // Use the shared watcher pool with stat checking
+    watcherInfo.watcher = this.watcherPool.watch(
+      streamPath,
+      (eventType) => {
+        if (eventType === 'change') readChanges();
+      },
+      (error) => {
+        // The pool dropped the failed watcher: watch the file again and catch up on
+        // what was missed, or end the streams fed from the file if that fails
+        watcherInfo.watcher = undefined;
+        if (this.activeWatchers.get(sessionId) !== watcherInfo) return;
+        logger.warn(`stream watcher for session ${sessionId} failed, watching again:`, error);
+        try {
+          this.startWatching(sessionId, streamPath, watcherInfo);
+        } catch (watchError) {
+          logger.error(`cannot watch stream file of session ${sessionId}:`, watchError);
+          this.endFileClients(sessionId, watcherInfo);
+          return;
+        }
+        readChanges();
+      }
+    );
+  }
 
-    watcherInfo.watcher.on('error', (error) => {
-      logger.error(`file watcher error for session ${sessionId}:`, error);
-    });
+  /**
+   * End the streams of the clients fed from the stream file, e.g. when it can
+   * no longer be watched
+   */
+  private endFileClients(sessionId: string, watcherInfo: WatcherInfo): void {
+    for (const client of Array.from(watcherInfo.clients)) {
+      if (client.live) continue;
+      clearTimeout(client.throttle.skipping?.timer);
+      watcherInfo.clients.delete(client);
+      try {
+        client.response.end();
+      } catch (error) {
+        logger.debug(`failed to end stream of session ${sessionId}:`, error);
+      }
+    }
+    if (watcherInfo.clients.size === 0) {
+      this.activeWatchers.delete(sessionId);
+    }
   }
 
   /**
    * Broadcast a line to all clients
    */
-  private broadcastLine(sessionId: string, line: string, watcherInfo: WatcherInfo): void {
+  private broadcastLine(
+    sessionId: string,
+    line: string,
+    watcherInfo: WatcherInfo,
+    endOffset: number
+  ): void {
     let eventData: string | null = null;
+    const startOffset = endOffset - Buffer.byteLength(line, 'utf8') - 1;
 
     try {
-      const parsed = JSON.parse(line);
+      const decoded = decodeCastLine(line);
+      const parsed = JSON.parse(decoded);
       if (parsed.version && parsed.width && parsed.height) {
         return; // Skip duplicate headers
       }
       if (Array.isArray(parsed) && parsed.length >= 3) {
         if (parsed[0] === 'exit') {
           logger.log(chalk.yellow(`session ${sessionId} ended with exit code ${parsed[2]}`));
-          eventData = `data: ${JSON.stringify(parsed)}\n\n`;
+          eventData = formatEvent(JSON.stringify(parsed), endOffset);
 
           // Send exit event to all clients and close connections
           for (const client of watcherInfo.clients) {
+            if (client.live) continue;
             try {
               client.response.write(eventData);
               client.response.end();
@@ -281,13 +728,21 @@ export class StreamWatcher {
           }
           return;
         } else {
+          if (this.holdLine(sessionId, { line, startOffset, endOffset })) return;
+
           // Calculate relative timestamp for each client
           for (const client of watcherInfo.clients) {
+            if (client.live) continue;
             const currentTime = Date.now() / 1000;
             const relativeEvent = [currentTime - client.startTime, parsed[1], parsed[2]];
-            const clientData = `data: ${JSON.stringify(relativeEvent)}\n\n`;
+            const clientData = formatEvent(JSON.stringify(relativeEvent), endOffset);
 
             try {
+              if (parsed[1] === 'o') {
+                const entry = { line: decoded, startOffset, endOffset };
+                this.writeOutput(sessionId, client, clientData, entry);
+                continue;
+              }
               client.response.write(clientData);
               // @ts-expect-error - flush exists but not in types
               if (client.response.flush) client.response.flush();
@@ -305,21 +760,235 @@ export class StreamWatcher {
       logger.debug(`broadcasting raw output line: ${line.substring(0, 50)}...`);
       const currentTime = Date.now() / 1000;
       for (const client of watcherInfo.clients) {
+        if (client.live) continue;
         const castEvent = [currentTime - client.startTime, 'o', line];
-        const clientData = `data: ${JSON.stringify(castEvent)}\n\n`;
+        const clientData = formatEvent(JSON.stringify(castEvent), endOffset);
+
+        try {
+          const entry = { line: JSON.stringify([0, 'o', line]), startOffset, endOffset };
+          this.writeOutput(sessionId, client, clientData, entry);
+        } catch (error) {
+          logger.debug(
+            `client write failed (likely disconnected): ${error instanceof Error ? error.message : String(error)}`
+          );
+        }
+      }
+      return;
+    }
+  }
+
+  /**
+   * Stop delivering output of a session to its clients. Output keeps being
+   * recorded; the exit event is still delivered.
+   */
+  pauseSession(sessionId: string): void {
+    if (this.pausedSessions.has(sessionId)) return;
+    this.pausedSessions.set(sessionId, { held: [], heldOffset: 0 });
+    logger.log(chalk.yellow(`paused output of session ${sessionId}`));
+  }
+
+  /**
+   * Resume delivering output of a paused session. With a redraw (the rendered
+   * screen and the stream offset it reflects) clients get the redraw followed by
+   * the held output past that offset, otherwise the held output (at most
+   * MAX_HELD_LINES lines) is sent as is.
+   */
+  resumeSession(sessionId: string, redraw?: { ansi: string; offset: number }): void {
+    const paused = this.pausedSessions.get(sessionId);
+    if (!paused) return;
+    this.pausedSessions.delete(sessionId);
+    logger.log(chalk.green(`resumed output of session ${sessionId}`));
+
+    const watcherInfo = this.activeWatchers.get(sessionId);
+    if (!watcherInfo) return;
 
+    const held = redraw
+      ? paused.held.filter((entry) => entry.endOffset > redraw.offset)
+      : paused.held;
+    for (const client of watcherInfo.clients) {
+      if (client.live?.replaying) continue;
+      if (redraw) {
+        const time = Date.now() / 1000 - client.startTime;
+        const event = JSON.stringify([time, 'o', redraw.ansi]);
         try {
-          client.response.write(clientData);
-          // @ts-expect-error - flush exists but not in types
-          if (client.response.flush) client.response.flush();
+          client.response.write(formatEvent(event, redraw.offset));
         } catch (error) {
           logger.debug(
             `client write failed (likely disconnected): ${error instanceof Error ? error.message : String(error)}`
           );
+          continue;
         }
       }
+      for (const entry of held) {
+        if (this.sendLineToClient(sessionId, client, entry, false)) break;
+      }
+    }
+  }
+
+  isPaused(sessionId: string): boolean {
+    return this.pausedSessions.has(sessionId);
+  }
+
+  /**
+   * Hold a line of a paused session instead of sending it. Returns false if the
+   * session is not paused.
+   */
+  private holdLine(sessionId: string, entry: OutputLine): boolean {
+    const paused = this.pausedSessions.get(sessionId);
+    if (!paused) return false;
+    if (entry.endOffset > paused.heldOffset) {
+      paused.held.push(entry);
+      paused.heldOffset = entry.endOffset;
+      if (paused.held.length > MAX_HELD_LINES) paused.held.shift();
+    }
+    return true;
+  }
+
+  /**
+   * Change the output caps; buckets of connected viewers start over full
+   */
+  setOutputThrottle(settings: OutputThrottleSettings): void {
+    this.throttle = { ...settings };
+    for (const watcherInfo of this.activeWatchers.values()) {
+      watcherInfo.bucket = createBucket(settings.sessionBytesPerSecond, settings);
+      for (const client of watcherInfo.clients) {
+        client.throttle.bucket = createBucket(settings.viewerBytesPerSecond, settings);
+      }
+    }
+  }
+
+  /**
+   * Write a live output event to a client within the output caps. Over a cap
+   * the client's output is skipped until the buckets have refilled; it then
+   * gets a redraw of the screen and continues from there.
+   */
+  private writeOutput(
+    sessionId: string,
+    client: StreamClient,
+    data: string,
+    entry: OutputLine
+  ): void {
+    const watcherInfo = this.activeWatchers.get(sessionId);
+    const bytes = Buffer.byteLength(data, 'utf8');
+    const skipping = client.throttle.skipping;
+    if (skipping) {
+      if (skipping.held && skipping.held.length < MAX_HELD_LINES) {
+        skipping.held.push(entry);
+      } else if (watcherInfo) {
+        watcherInfo.skippedBytes += bytes;
+      }
+      return;
+    }
+
+    const buckets = [client.throttle.bucket, watcherInfo?.bucket ?? null].filter(
+      (bucket): bucket is TokenBucket => bucket !== null
+    );
+    const now = Date.now();
+    // Events larger than a bucket pass once it is full and leave it in debt
+    const fits = (bucket: TokenBucket) =>
+      bucket.available(now) >= Math.min(bytes, bucket.capacity);
+    if (buckets.every(fits)) {
+      for (const bucket of buckets) bucket.force(bytes, now);
+      client.response.write(data);
+      // @ts-expect-error - flush exists but not in types
+      if (client.response.flush) client.response.flush();
       return;
     }
+
+    if (watcherInfo) watcherInfo.skippedBytes += bytes;
+    const waitMs = Math.max(MIN_SKIP_MS, ...buckets.map((bucket) => bucket.msUntilFull(now)));
+    client.throttle.skipping = {
+      timer: setTimeout(() => void this.redrawClient(sessionId, client), waitMs),
+    };
+    logger.debug(`throttling a viewer of session ${sessionId}, skipping output for ${waitMs}ms`);
+  }
+
+  /**
+   * End skipping the output of a throttled client: send the current screen, then
+   * the lines that arrived while it was rendered
+   */
+  private async redrawClient(sessionId: string, client: StreamClient): Promise<void> {
+    const skipping = client.throttle.skipping;
+    if (!skipping) return;
+    skipping.held = [];
+
+    let redraw: { ansi: string; offset: number } | null = null;
+    try {
+      redraw = (await this.redraw?.getRedraw(sessionId)) ?? null;
+    } catch (error) {
+      logger.warn(`failed to redraw throttled viewer of session ${sessionId}:`, error);
+    }
+    const watcherInfo = this.activeWatchers.get(sessionId);
+    client.throttle.skipping = undefined;
+    if (!watcherInfo?.clients.has(client)) return;
+
+    if (redraw) {
+      const time = Date.now() / 1000 - client.startTime;
+      const event = formatEvent(JSON.stringify([time, 'o', redraw.ansi]), redraw.offset);
+      // The redraw is sent regardless; later output waits until it is paid for
+      const bytes = Buffer.byteLength(event, 'utf8');
+      client.throttle.bucket?.force(bytes);
+      watcherInfo.bucket?.force(bytes);
+      try {
+        client.response.write(event);
+      } catch (error) {
+        logger.debug(
+          `client write failed (likely disconnected): ${error instanceof Error ? error.message : String(error)}`
+        );
+        return;
+      }
+    }
+    const offset = redraw?.offset ?? -1;
+    const held = skipping.held.filter((entry) => entry.endOffset > offset);
+    for (const entry of held) {
+      if (this.sendLineToClient(sessionId, client, entry, false)) break;
+    }
+  }
+
+  /**
+   * Output caps, and per watched session how many viewers are throttled
+   */
+  getThrottleStats() {
+    const sessions = Array.from(
+      this.activeWatchers,
+      ([sessionId, watcherInfo]): StreamThrottleStats => {
+        const clients = Array.from(watcherInfo.clients);
+        return {
+          sessionId,
+          viewers: clients.length,
+          throttledViewers: clients.filter((client) => client.throttle.skipping).length,
+          skippedBytes: watcherInfo.skippedBytes,
+          ...(watcherInfo.bucket ? { sessionBudgetBytes: watcherInfo.bucket.available() } : {}),
+        };
+      }
+    );
+    return { ...this.throttle, sessions };
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let clients = 0;
+    let liveClients = 0;
+    let throttledClients = 0;
+    let fileWatchers = 0;
+    for (const watcherInfo of this.activeWatchers.values()) {
+      clients += watcherInfo.clients.size;
+      for (const client of watcherInfo.clients) {
+        if (client.live) liveClients++;
+        if (client.throttle.skipping) throttledClients++;
+      }
+      if (watcherInfo.watcher) fileWatchers++;
+    }
+    return {
+      watchedSessions: this.activeWatchers.size,
+      fileWatchers,
+      clients,
+      liveClients,
+      throttledClients,
+      pausedSessions: this.pausedSessions.size,
+    };
   }
 
   /**
@@ -333,6 +1002,10 @@ export class StreamWatcher {
         if (watcherInfo.watcher) {
           watcherInfo.watcher.close();
         }
+        for (const client of watcherInfo.clients) {
+          client.live?.unsubscribe();
+          clearTimeout(client.throttle.skipping?.timer);
+        }
         logger.debug(`closed watcher for session ${sessionId}`);
       }
       this.activeWatchers.clear();
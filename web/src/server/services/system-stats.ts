@@ -0,0 +1,171 @@
+/**
+ * SystemStats - CPU, load, memory and disk usage of the host
+ *
+ * Lets HQ and dashboards show how much headroom each machine has. CPU usage is
+ * measured between two samples of the per-core CPU times, so the first reading
+ * covers the time since the service was created and later readings the time
+ * since the previous one.
+ */
+
+import * as fs from 'fs';
+import * as os from 'os';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('system-stats');
+
+// Readings closer together than this reuse the previous CPU usage
+const MIN_SAMPLE_INTERVAL_MS = 250;
+
+export interface HostSystemStats {
+  hostname: string;
+  platform: NodeJS.Platform;
+  arch: string;
+  uptimeSeconds: number;
+  cpu: {
+    count: number;
+    model: string;
+    // Busy share of all cores since the previous reading, 0-100
+    usagePercent: number;
+  };
+  // 1, 5 and 15 minute load averages (always 0 on Windows)
+  load: [number, number, number];
+  memory: {
+    totalBytes: number;
+    // Memory available to new processes without swapping
+    availableBytes: number;
+    usedPercent: number;
+    swapTotalBytes: number;
+    swapFreeBytes: number;
+  };
+  // File system holding the control directory (session recordings)
+  disk: {
+    path: string;
+    totalBytes: number;
+    availableBytes: number;
+    usedPercent: number;
+  } | null;
+  timestamp: string;
+}
+
+interface CpuSample {
+  idle: number;
+  total: number;
+  takenAt: number;
+}
+
+function sampleCpu(): CpuSample {
+  let idle = 0;
+  let total = 0;
+  for (const cpu of os.cpus()) {
+    const { user, nice, sys, idle: cpuIdle, irq } = cpu.times;
+    idle += cpuIdle;
+    total += user + nice + sys + cpuIdle + irq;
+  }
+  return { idle, total, takenAt: Date.now() };
+}
+
+function percent(part: number, whole: number): number {
+  return whole > 0 ? Math.round((part / whole) * 1000) / 10 : 0;
+}
+
+function round2(value: number): number {
+  return Math.round(value * 100) / 100;
+}
+
+/**
+ * Parse /proc/meminfo into bytes per field (Linux only)
+ */
+export function parseMeminfo(content: string): Map<string, number> {
+  const fields = new Map<string, number>();
+  for (const line of content.split('\n')) {
+    const match = line.match(/^(\w+(?:\(\w+\))?):\s+(\d+)(?:\s+kB)?/);
+    if (match) {
+      const value = Number(match[2]);
+      fields.set(match[1], line.endsWith('kB') ? value * 1024 : value);
+    }
+  }
+  return fields;
+}
+
+export class SystemStats {
+  private lastSample = sampleCpu();
+  private lastUsage = 0;
+
+  constructor(private diskPath: string) {}
+
+  async getStats(): Promise<HostSystemStats> {
+    const cpus = os.cpus();
+    const load = os.loadavg();
+    return {
+      hostname: os.hostname(),
+      platform: process.platform,
+      arch: process.arch,
+      uptimeSeconds: Math.round(os.uptime()),
+      cpu: {
+        count: cpus.length,
+        model: cpus[0]?.model.trim() ?? 'unknown',
+        usagePercent: this.cpuUsage(),
+      },
+      load: [round2(load[0]), round2(load[1]), round2(load[2])],
+      memory: await this.memory(),
+      disk: await this.disk(),
+      timestamp: new Date().toISOString(),
+    };
+  }
+
+  private cpuUsage(): number {
+    const sample = sampleCpu();
+    if (sample.takenAt - this.lastSample.takenAt < MIN_SAMPLE_INTERVAL_MS) {
+      return this.lastUsage;
+    }
+
+    const total = sample.total - this.lastSample.total;
+    const idle = sample.idle - this.lastSample.idle;
+    this.lastSample = sample;
+    this.lastUsage = percent(total - idle, total);
+    return this.lastUsage;
+  }
+
+  private async memory(): Promise<HostSystemStats['memory']> {
+    const totalBytes = os.totalmem();
+    // os.freemem() leaves out reclaimable caches on Linux; MemAvailable counts them
+    let availableBytes = os.freemem();
+    let swapTotalBytes = 0;
+    let swapFreeBytes = 0;
+    if (process.platform === 'linux') {
+      try {
+        const meminfo = parseMeminfo(await fs.promises.readFile('/proc/meminfo', 'utf8'));
+        availableBytes = meminfo.get('MemAvailable') ?? availableBytes;
+        swapTotalBytes = meminfo.get('SwapTotal') ?? 0;
+        swapFreeBytes = meminfo.get('SwapFree') ?? 0;
+      } catch (error) {
+        logger.debug('Failed to read /proc/meminfo:', error);
+      }
+    }
+
+    return {
+      totalBytes,
+      availableBytes,
+      usedPercent: percent(totalBytes - availableBytes, totalBytes),
+      swapTotalBytes,
+      swapFreeBytes,
+    };
+  }
+
+  private async disk(): Promise<HostSystemStats['disk']> {
+    try {
+      const stats = await fs.promises.statfs(this.diskPath);
+      const totalBytes = stats.blocks * stats.bsize;
+      const availableBytes = stats.bavail * stats.bsize;
+      return {
+        path: this.diskPath,
+        totalBytes,
+        availableBytes,
+        usedPercent: percent(totalBytes - stats.bfree * stats.bsize, totalBytes),
+      };
+    } catch (error) {
+      logger.debug(`Failed to get disk usage of ${this.diskPath}:`, error);
+      return null;
+    }
+  }
+}
@@ -1,18 +1,93 @@
-import { Terminal as XtermTerminal } from '@xterm/headless';
+import {
+  type IBufferCell,
+  type IBufferLine,
+  Terminal as XtermTerminal,
+} from '@xterm/headless';
 import chalk from 'chalk';
+import {
+  FLAG_COMPRESSED,
+  FLAG_DELTA,
+  FLAG_LINK_TABLE,
+  ROW_UNCHANGED,
+  SNAPSHOT_FORMAT_1,
+  SNAPSHOT_FORMAT_2,
+  SNAPSHOT_HEADER_SIZE,
+} from '../../shared/buffer-protocol.js';
+import { cellWidth, continuesCluster } from '../../shared/cell-width.js';
+import type { KeyEncodingModes } from '../../shared/keymap.js';
 import * as fs from 'fs';
 import * as path from 'path';
+import { deflateRawSync } from 'zlib';
+import { ControlRoots } from '../pty/control-roots.js';
+import { type InlineImage, parseImageMarker } from '../pty/inline-images.js';
+import { type OutputLine, splitBacklog } from '../pty/output-broadcaster.js';
+import { type PooledBuffer, snapshotBufferPool } from '../utils/buffer-pool.js';
 import { createLogger } from '../utils/logger.js';
+import { decodeCastLine } from '../utils/recording-crypto.js';
+import { guardSessionTask } from './crash-reporter.js';
+import {
+  type FileWatcherPool,
+  type FileWatchHandle,
+  fileWatcherPool,
+} from './file-watcher-pool.js';
+import { HyperlinkTracker, type SnapshotLink } from './hyperlink-tracker.js';
+import type { LiveOutputSource } from './stream-watcher.js';
 
 const logger = createLogger('terminal-manager');
 
 interface SessionTerminal {
   terminal: XtermTerminal;
-  watcher?: fs.FSWatcher;
+  watcher?: FileWatchHandle;
   lastUpdate: number;
+  // Fixed-size ring of recently extracted rows keyed by content hash, so
+  // unchanged rows are not re-extracted (or re-encoded) on every update
+  rowCache: Map<number, BufferCell[]>;
+  // Bumped whenever the parser changes the buffer or the terminal is resized
+  generation: number;
+  // Last snapshot taken and the generation it reflects
+  snapshot?: { generation: number; value: BufferSnapshot };
+  // Last snapshot delivered to buffer listeners
+  notifiedSnapshot?: BufferSnapshot;
+  // Adaptive coalescing level; grows while output is continuous, reset when idle
+  coalesceLevel: number;
+  // Byte offset in the stream file just past the last line written to the terminal
+  streamOffset: number;
+  // OSC 8 links printed to the terminal
+  hyperlinks: HyperlinkTracker;
 }
 
+interface PendingNotification {
+  timer: NodeJS.Timeout;
+  firstChangeAt: number; // When the first change since the last notification arrived
+}
+
+export interface BufferSubscriptionOptions {
+  // Deliver at most this many snapshots per second to this listener
+  maxFps?: number;
+  // Called for images the session prints, with the cursor position they were printed at
+  onImage?: ImageListener;
+}
+
+export interface TerminalManagerOptions {
+  watcherPool?: FileWatcherPool;
+  liveOutput?: LiveOutputSource;
+  // Quiet period after the last change before listeners are notified
+  notifyDebounceMs?: number;
+  // Upper bound for how long continuous output may delay a notification
+  maxCoalesceMs?: number;
+}
+
+const DEFAULT_NOTIFY_DEBOUNCE_MS = 50;
+const DEFAULT_MAX_COALESCE_MS = 500;
+
+// Empty row encoding: marker + count
+const EMPTY_ROW_ENCODING = Buffer.from([0xfe, 1]);
+
+// Longest character a cell can carry: its UTF-8 length is one byte
+const MAX_CELL_CHAR_BYTES = 255;
+
 type BufferChangeListener = (sessionId: string, snapshot: BufferSnapshot) => void;
+type ImageListener = (image: InlineImage, cursor: { cursorX: number; cursorY: number }) => void;
 
 interface BufferCell {
   char: string;
@@ -29,16 +104,52 @@ interface BufferSnapshot {
   cursorX: number;
   cursorY: number;
   cells: BufferCell[][];
+  // OSC 8 hyperlinks on the snapshot's rows
+  links?: SnapshotLink[];
+}
+
+// Frames smaller than this are not worth deflating
+const COMPRESSION_THRESHOLD = 1024;
+
+// Format 2 features a client negotiated (see shared/buffer-protocol.ts)
+export interface SnapshotEncodeOptions {
+  // Rows of the client's previous frame of the session; equal rows are sent as unchanged
+  baseRows?: Buffer[];
+  // Deflate the frame when it is large enough and that makes it smaller
+  compress?: boolean;
+}
+
+export interface EncodedSnapshot extends PooledBuffer {
+  // Encoded rows of the full frame, the base for the client's next delta frame
+  rows: Buffer[];
 }
 
 export class TerminalManager {
   private terminals: Map<string, SessionTerminal> = new Map();
-  private controlDir: string;
+  private controlRoots: ControlRoots;
   private bufferListeners: Map<string, Set<BufferChangeListener>> = new Map();
-  private changeTimers: Map<string, NodeJS.Timeout> = new Map();
-
-  constructor(controlDir: string) {
-    this.controlDir = controlDir;
+  private imageListeners: Map<string, Set<ImageListener>> = new Map();
+  private changeTimers: Map<string, PendingNotification> = new Map();
+  // Sessions whose buffer listeners are not notified (output delivery paused)
+  private pausedSessions: Set<string> = new Set();
+  private watcherPool: FileWatcherPool;
+  private liveOutput: LiveOutputSource | null;
+  private notifyDebounceMs: number;
+  private maxCoalesceMs: number;
+  // Encoded bytes per extracted row; rows are shared between snapshots while unchanged
+  private encodedRows: WeakMap<BufferCell[], Buffer> = new WeakMap();
+  // Rows cropped to a viewer's width, keyed by the full row
+  private croppedRows: WeakMap<BufferCell[], { cols: number; row: BufferCell[] }> = new WeakMap();
+
+  constructor(controlDir: string | ControlRoots, options: TerminalManagerOptions = {}) {
+    this.controlRoots = ControlRoots.from(controlDir);
+    this.watcherPool = options.watcherPool ?? fileWatcherPool;
+    this.liveOutput = options.liveOutput ?? null;
+    this.notifyDebounceMs = options.notifyDebounceMs ?? DEFAULT_NOTIFY_DEBOUNCE_MS;
+    this.maxCoalesceMs = Math.max(
+      this.notifyDebounceMs,
+      options.maxCoalesceMs ?? DEFAULT_MAX_COALESCE_MS
+    );
   }
 
   /**
@@ -60,8 +171,23 @@ export class TerminalManager {
       sessionTerminal = {
         terminal,
         lastUpdate: Date.now(),
+        rowCache: new Map(),
+        generation: 0,
+        coalesceLevel: 0,
+        streamOffset: 0,
+        hyperlinks: new HyperlinkTracker(terminal),
       };
 
+      // Track changes as the parser applies them (writes are parsed asynchronously)
+      const tracked = sessionTerminal;
+      terminal.onWriteParsed(() => {
+        tracked.generation++;
+        this.scheduleBufferChangeNotification(sessionId);
+      });
+      terminal.onResize(() => {
+        tracked.generation++;
+      });
+
       this.terminals.set(sessionId, sessionTerminal);
       logger.log(
         chalk.green(`Terminal created for session ${sessionId} (${terminal.cols}x${terminal.rows})`)
@@ -82,7 +208,7 @@ export class TerminalManager {
     const sessionTerminal = this.terminals.get(sessionId);
     if (!sessionTerminal) return;
 
-    const streamPath = path.join(this.controlDir, sessionId, 'stdout');
+    const streamPath = path.join(this.controlRoots.sessionDir(sessionId), 'stdout');
     let lastOffset = 0;
     let lineBuffer = '';
 
@@ -92,21 +218,50 @@ export class TerminalManager {
       return;
     }
 
+    // Sessions running in this process are fed from memory after replaying history
+    if (this.liveOutput) {
+      const subscription = this.liveOutput.subscribeToOutput(
+        sessionId,
+        guardSessionTask(sessionId, 'terminal-live', (entry: OutputLine) => {
+          this.handleStreamLine(sessionId, sessionTerminal, entry.line, entry.endOffset);
+        })
+      );
+      if (subscription) {
+        try {
+          const flushedSize = fs.statSync(streamPath).size;
+          const { fileEndOffset, memoryLines } = splitBacklog(subscription.backlog, flushedSize);
+
+          const fd = fs.openSync(streamPath, 'r');
+          const buffer = Buffer.alloc(fileEndOffset);
+          fs.readSync(fd, buffer, 0, fileEndOffset, 0);
+          fs.closeSync(fd);
+
+          this.handleStreamLines(sessionId, sessionTerminal, buffer.toString('utf8'), 0);
+          for (const entry of memoryLines) {
+            this.handleStreamLine(sessionId, sessionTerminal, entry.line, entry.endOffset);
+          }
+
+          sessionTerminal.watcher = { close: subscription.unsubscribe };
+          logger.log(chalk.green(`Streaming live output for session ${sessionId}`));
+          return;
+        } catch (error) {
+          subscription.unsubscribe();
+          logger.error(`Failed to replay stream file for session ${sessionId}:`, error);
+          throw error;
+        }
+      }
+    }
+
     try {
       // Read existing content first
       const content = fs.readFileSync(streamPath, 'utf8');
       lastOffset = Buffer.byteLength(content, 'utf8');
 
       // Process existing content
-      const lines = content.split('\n');
-      for (const line of lines) {
-        if (line.trim()) {
-          this.handleStreamLine(sessionId, sessionTerminal, line);
-        }
-      }
+      this.handleStreamLines(sessionId, sessionTerminal, content, 0);
 
       // Watch for changes
-      sessionTerminal.watcher = fs.watch(streamPath, (eventType) => {
+      const handleChange = guardSessionTask(sessionId, 'terminal-watch', (eventType: string) => {
         if (eventType === 'change') {
           try {
             const stats = fs.statSync(streamPath);
@@ -117,28 +272,25 @@ export class TerminalManager {
               fs.readSync(fd, buffer, 0, buffer.length, lastOffset);
               fs.closeSync(fd);
 
-              // Update offset
+              // Process new data, starting where the buffered incomplete line began
+              const startOffset = lastOffset - Buffer.byteLength(lineBuffer, 'utf8');
               lastOffset = stats.size;
-
-              // Process new data
-              const newData = buffer.toString('utf8');
-              lineBuffer += newData;
-
-              // Process complete lines
-              const lines = lineBuffer.split('\n');
-              lineBuffer = lines.pop() || ''; // Keep incomplete line for next time
-
-              for (const line of lines) {
-                if (line.trim()) {
-                  this.handleStreamLine(sessionId, sessionTerminal, line);
-                }
-              }
+              const data = lineBuffer + buffer.toString('utf8');
+              // Keep incomplete line for next time
+              lineBuffer = this.handleStreamLines(
+                sessionId,
+                sessionTerminal,
+                data,
+                startOffset,
+                true
+              );
             }
           } catch (error) {
             logger.error(`Error reading stream file for session ${sessionId}:`, error);
           }
         }
       });
+      sessionTerminal.watcher = this.watcherPool.watch(streamPath, handleChange);
 
       logger.log(chalk.green(`Watching stream file for session ${sessionId}`));
     } catch (error) {
@@ -148,14 +300,46 @@ export class TerminalManager {
   }
 
   /**
-   * Handle stream line
+   * Handle the lines of stream file content read from startOffset. With
+   * keepIncomplete the trailing line without a newline is returned instead of
+   * handled.
+   */
+  private handleStreamLines(
+    sessionId: string,
+    sessionTerminal: SessionTerminal,
+    content: string,
+    startOffset: number,
+    keepIncomplete = false
+  ): string {
+    const lines = content.split('\n');
+    const incomplete = keepIncomplete ? lines.pop() || '' : '';
+    let offset = startOffset;
+    for (const [index, line] of lines.entries()) {
+      // Every line but the last of complete content is followed by a newline
+      const newline = keepIncomplete || index < lines.length - 1 ? 1 : 0;
+      offset += Buffer.byteLength(line, 'utf8') + newline;
+      if (line.trim()) {
+        this.handleStreamLine(sessionId, sessionTerminal, line, offset);
+      }
+    }
+    return incomplete;
+  }
+
+  /**
+   * Handle stream line ending at endOffset in the stream file
    */
-  private handleStreamLine(sessionId: string, sessionTerminal: SessionTerminal, line: string) {
+  private handleStreamLine(
+    sessionId: string,
+    sessionTerminal: SessionTerminal,
+    line: string,
+    endOffset: number
+  ) {
     try {
-      const data = JSON.parse(line);
+      const data = JSON.parse(decodeCastLine(line));
 
       // Handle asciinema header
       if (data.version && data.width && data.height) {
+        sessionTerminal.streamOffset = endOffset;
         sessionTerminal.terminal.resize(data.width, data.height);
         this.notifyBufferChange(sessionId);
         return;
@@ -174,10 +358,10 @@ export class TerminalManager {
           return;
         }
 
+        sessionTerminal.streamOffset = endOffset;
         if (type === 'o') {
-          // Output event - write to terminal
+          // Output event - write to terminal (listeners are notified once parsed)
           sessionTerminal.terminal.write(eventData);
-          this.scheduleBufferChangeNotification(sessionId);
         } else if (type === 'r') {
           // Resize event
           const match = eventData.match(/^(\d+)x(\d+)$/);
@@ -187,6 +371,9 @@ export class TerminalManager {
             sessionTerminal.terminal.resize(cols, rows);
             this.notifyBufferChange(sessionId);
           }
+        } else if (type === 'm') {
+          const image = parseImageMarker(eventData);
+          if (image) this.notifyImage(sessionId, sessionTerminal, image);
         }
         // Ignore 'i' (input) events
       }
@@ -195,6 +382,62 @@ export class TerminalManager {
     }
   }
 
+  /**
+   * Tell image listeners about an image once the output before it is parsed
+   */
+  private notifyImage(sessionId: string, sessionTerminal: SessionTerminal, image: InlineImage) {
+    if (!this.imageListeners.has(sessionId)) return;
+    sessionTerminal.terminal.write('', () => {
+      const buffer = sessionTerminal.terminal.buffer.active;
+      const cursor = { cursorX: buffer.cursorX, cursorY: buffer.cursorY };
+      for (const listener of this.imageListeners.get(sessionId) ?? []) {
+        try {
+          listener(image, cursor);
+        } catch (error) {
+          logger.error(`Image listener failed for session ${sessionId}:`, error);
+        }
+      }
+    });
+  }
+
+  /**
+   * Keyboard modes set by the program in a session, if its terminal is active
+   */
+  getKeyModes(sessionId: string): KeyEncodingModes | undefined {
+    const sessionTerminal = this.terminals.get(sessionId);
+    if (!sessionTerminal) return undefined;
+    const { modes } = sessionTerminal.terminal;
+    return {
+      applicationCursor: modes.applicationCursorKeysMode,
+      applicationKeypad: modes.applicationKeypadMode,
+    };
+  }
+
+  /**
+   * Text of the last `maxLines` lines of a session's screen and scrollback,
+   * oldest first, with wrapped lines joined
+   */
+  async getRecentLines(sessionId: string, maxLines: number): Promise<string[]> {
+    const terminal = await this.getTerminal(sessionId);
+    const buffer = terminal.buffer.active;
+    const lines: string[] = [];
+    let current = '';
+    const start = Math.max(0, buffer.length - maxLines);
+    for (let y = start; y < buffer.length; y++) {
+      const line = buffer.getLine(y);
+      if (!line) continue;
+      if (!line.isWrapped && y > start) {
+        lines.push(current.trimEnd());
+        current = '';
+      }
+      current += line.translateToString(true);
+    }
+    lines.push(current.trimEnd());
+    // Drop the blank screen rows below the output
+    while (lines.length > 0 && !lines[lines.length - 1]) lines.pop();
+    return lines;
+  }
+
   /**
    * Get buffer stats for a session
    */
@@ -215,11 +458,49 @@ export class TerminalManager {
   }
 
   /**
-   * Get buffer snapshot for a session - always returns full terminal buffer (cols x rows)
+   * Snapshot of a session's screen together with the stream file offset it reflects,
+   * so a viewer can be sent the screen followed by only the stream events after it.
+   * Resolves once everything written to the terminal so far has been parsed.
+   */
+  async getSnapshotWithOffset(
+    sessionId: string
+  ): Promise<{ snapshot: BufferSnapshot; offset: number; cols: number; rows: number }> {
+    const terminal = await this.getTerminal(sessionId);
+    const sessionTerminal = this.terminals.get(sessionId);
+    const offset = sessionTerminal?.streamOffset ?? 0;
+    return new Promise((resolve) => {
+      // Write callbacks run right after their chunk is parsed, before any later chunk
+      terminal.write('', () => {
+        resolve({
+          snapshot: this.takeSnapshot(sessionId, terminal),
+          offset,
+          cols: terminal.cols,
+          rows: terminal.rows,
+        });
+      });
+    });
+  }
+
+  /**
+   * Get buffer snapshot for a session - always returns full terminal buffer (cols x rows).
+   * Returns the previous snapshot object if nothing changed since it was taken.
    */
   async getBufferSnapshot(sessionId: string): Promise<BufferSnapshot> {
-    const startTime = Date.now();
     const terminal = await this.getTerminal(sessionId);
+    return this.takeSnapshot(sessionId, terminal);
+  }
+
+  private takeSnapshot(sessionId: string, terminal: XtermTerminal): BufferSnapshot {
+    const startTime = Date.now();
+    const sessionTerminal = this.terminals.get(sessionId);
+    const generation = sessionTerminal?.generation ?? 0;
+    const previous = sessionTerminal?.snapshot;
+
+    // Nothing was parsed or resized since the last snapshot
+    if (previous && previous.generation === generation) {
+      return previous.value;
+    }
+
     const buffer = terminal.buffer.active;
 
     // Always get the visible terminal area from bottom
@@ -231,75 +512,40 @@ export class TerminalManager {
     const cursorX = buffer.cursorX;
     const cursorY = buffer.cursorY + buffer.viewportY - startLine;
 
-    // Extract cells
+    // Extract cells, reusing rows whose content has not changed
+    const rowCache = sessionTerminal?.rowCache;
+    const rowCacheSize = terminal.rows * 2;
     const cells: BufferCell[][] = [];
     const cell = buffer.getNullCell();
+    let reusedRows = 0;
 
     for (let row = 0; row < actualLines; row++) {
       const line = buffer.getLine(startLine + row);
-      const rowCells: BufferCell[] = [];
-
-      if (line) {
-        for (let col = 0; col < terminal.cols; col++) {
-          line.getCell(col, cell);
-
-          const char = cell.getChars() || ' ';
-          const width = cell.getWidth();
-
-          // Skip zero-width cells (part of wide characters)
-          if (width === 0) continue;
-
-          // Build attributes byte
-          let attributes = 0;
-          if (cell.isBold()) attributes |= 0x01;
-          if (cell.isItalic()) attributes |= 0x02;
-          if (cell.isUnderline()) attributes |= 0x04;
-          if (cell.isDim()) attributes |= 0x08;
-          if (cell.isInverse()) attributes |= 0x10;
-          if (cell.isInvisible()) attributes |= 0x20;
-          if (cell.isStrikethrough()) attributes |= 0x40;
-
-          const bufferCell: BufferCell = {
-            char,
-            width,
-          };
-
-          // Only include non-default values
-          const fg = cell.getFgColor();
-          const bg = cell.getBgColor();
-
-          // Handle color values - -1 means default color
-          if (fg !== undefined && fg !== -1) bufferCell.fg = fg;
-          if (bg !== undefined && bg !== -1) bufferCell.bg = bg;
-          if (attributes !== 0) bufferCell.attributes = attributes;
-
-          rowCells.push(bufferCell);
-        }
 
-        // Trim blank cells from the end of the line
-        let lastNonBlankCell = rowCells.length - 1;
-        while (lastNonBlankCell >= 0) {
-          const cell = rowCells[lastNonBlankCell];
-          if (
-            cell.char !== ' ' ||
-            cell.fg !== undefined ||
-            cell.bg !== undefined ||
-            cell.attributes !== undefined
-          ) {
-            break;
-          }
-          lastNonBlankCell--;
-        }
-
-        // Trim the array, but keep at least one cell
-        if (lastNonBlankCell < rowCells.length - 1) {
-          rowCells.splice(Math.max(1, lastNonBlankCell + 1));
-        }
-      } else {
+      if (!line) {
         // Empty line - just add a single space
-        rowCells.push({ char: ' ', width: 1 });
+        cells.push([{ char: ' ', width: 1 }]);
+        continue;
       }
 
+      const hash = this.hashLine(line, terminal.cols, cell);
+      const cached = rowCache?.get(hash);
+      if (cached) {
+        cells.push(cached);
+        reusedRows++;
+        continue;
+      }
+
+      const rowCells = this.extractRow(line, terminal.cols, cell);
+      if (rowCache) {
+        rowCache.set(hash, rowCells);
+        // Evict the oldest rows once the ring is full
+        while (rowCache.size > rowCacheSize) {
+          const oldest = rowCache.keys().next().value;
+          if (oldest === undefined) break;
+          rowCache.delete(oldest);
+        }
+      }
       cells.push(rowCells);
     }
 
@@ -324,11 +570,12 @@ export class TerminalManager {
     const duration = Date.now() - startTime;
     if (duration > 10) {
       logger.debug(
-        `Buffer snapshot for session ${sessionId} took ${duration}ms (${trimmedCells.length} rows)`
+        `Buffer snapshot for session ${sessionId} took ${duration}ms ` +
+          `(${trimmedCells.length} rows, ${reusedRows} reused)`
       );
     }
 
-    return {
+    let snapshot: BufferSnapshot = {
       cols: terminal.cols,
       rows: trimmedCells.length,
       viewportY: startLine,
@@ -336,6 +583,219 @@ export class TerminalManager {
       cursorY,
       cells: trimmedCells,
     };
+    const links = sessionTerminal?.hyperlinks.getLinks(startLine, trimmedCells.length);
+    if (links) {
+      // Wide characters take one cell for two columns; encoded cells carry no width
+      const cellLinks = links
+        .map((link) => ({ ...link, spans: columnsToCells(link.spans, trimmedCells) }))
+        .filter((link) => link.spans.length > 0);
+      if (cellLinks.length > 0) snapshot.links = cellLinks;
+    }
+
+    // Changes that did not affect the visible content (e.g. title updates) keep the old snapshot
+    if (previous && this.isSameSnapshot(previous.value, snapshot)) {
+      snapshot = previous.value;
+    }
+    if (sessionTerminal) {
+      sessionTerminal.snapshot = { generation, value: snapshot };
+    }
+
+    return snapshot;
+  }
+
+  /**
+   * Cheap equality check: unchanged rows are shared through the row cache,
+   * so rows can be compared by identity
+   */
+  private isSameSnapshot(a: BufferSnapshot, b: BufferSnapshot): boolean {
+    if (
+      a.cols !== b.cols ||
+      a.rows !== b.rows ||
+      a.viewportY !== b.viewportY ||
+      a.cursorX !== b.cursorX ||
+      a.cursorY !== b.cursorY ||
+      a.cells.length !== b.cells.length
+    ) {
+      return false;
+    }
+    for (let i = 0; i < a.cells.length; i++) {
+      if (a.cells[i] !== b.cells[i]) return false;
+    }
+    return JSON.stringify(a.links) === JSON.stringify(b.links);
+  }
+
+  /**
+   * Crop a snapshot to a viewer that is smaller than the PTY (overscan).
+   * Columns are cut on the right; rows are cut from the top while keeping the
+   * cursor line visible. Returns the snapshot itself if it already fits.
+   */
+  cropSnapshot(snapshot: BufferSnapshot, cols: number, rows: number): BufferSnapshot {
+    if (snapshot.cols <= cols && snapshot.cells.length <= rows) {
+      return snapshot;
+    }
+
+    let start = Math.max(0, snapshot.cells.length - rows);
+    if (snapshot.cursorY < start) {
+      start = Math.max(0, snapshot.cursorY);
+    }
+    const visibleRows = snapshot.cells.slice(start, start + rows);
+    const cells =
+      snapshot.cols <= cols ? visibleRows : visibleRows.map((row) => this.cropRow(row, cols));
+
+    const cropped: BufferSnapshot = {
+      cols: Math.min(snapshot.cols, cols),
+      rows: cells.length,
+      viewportY: snapshot.viewportY + start,
+      cursorX: Math.min(snapshot.cursorX, cols - 1),
+      cursorY: snapshot.cursorY - start,
+      cells,
+    };
+    const links = snapshot.links
+      ?.map((link) => ({ ...link, spans: cropSpans(link.spans, start, cells) }))
+      .filter((link) => link.spans.length > 0);
+    if (links?.length) cropped.links = links;
+    return cropped;
+  }
+
+  /**
+   * Cut a row to a number of columns. Results are memoized per row so cropped
+   * rows keep their identity and their cached encoding.
+   */
+  private cropRow(row: BufferCell[], cols: number): BufferCell[] {
+    const cached = this.croppedRows.get(row);
+    if (cached && cached.cols === cols) {
+      return cached.row;
+    }
+
+    let width = 0;
+    let end = 0;
+    while (end < row.length && width + row[end].width <= cols) {
+      width += row[end].width;
+      end++;
+    }
+    // Keep at least one cell, like extractRow
+    const cropped = end >= row.length ? row : row.slice(0, Math.max(1, end));
+    this.croppedRows.set(row, { cols, row: cropped });
+    return cropped;
+  }
+
+  /**
+   * Hash the visible content of a line (characters, widths, colors, attributes)
+   * without allocating per-cell objects. Two independent 32-bit hashes are
+   * combined to keep collisions negligible.
+   */
+  private hashLine(line: IBufferLine, cols: number, cell: IBufferCell): number {
+    let h1 = 0x811c9dc5;
+    let h2 = 0x9747b28c ^ cols;
+
+    const mix = (value: number) => {
+      h1 = Math.imul(h1 ^ value, 0x01000193);
+      h2 = Math.imul(h2 ^ value, 0x5bd1e995);
+      h2 ^= h2 >>> 15;
+    };
+
+    for (let col = 0; col < cols; col++) {
+      line.getCell(col, cell);
+
+      const chars = cell.getChars();
+      mix(chars.length);
+      for (let i = 0; i < chars.length; i++) {
+        mix(chars.charCodeAt(i));
+      }
+      mix(cell.getWidth());
+      mix(cell.getFgColor());
+      mix(cell.getBgColor());
+
+      let attributes = 0;
+      if (cell.isBold()) attributes |= 0x01;
+      if (cell.isItalic()) attributes |= 0x02;
+      if (cell.isUnderline()) attributes |= 0x04;
+      if (cell.isDim()) attributes |= 0x08;
+      if (cell.isInverse()) attributes |= 0x10;
+      if (cell.isInvisible()) attributes |= 0x20;
+      if (cell.isStrikethrough()) attributes |= 0x40;
+      mix(attributes);
+    }
+
+    return (h1 >>> 0) * 0x100000 + (h2 >>> 12);
+  }
+
+  /**
+   * Extract the cells of a line, trimming trailing blank cells
+   */
+  private extractRow(line: IBufferLine, cols: number, cell: IBufferCell): BufferCell[] {
+    let rowCells: BufferCell[] = [];
+    let merged = false;
+
+    for (let col = 0; col < cols; col++) {
+      line.getCell(col, cell);
+
+      const char = cell.getChars() || ' ';
+      const width = cell.getWidth();
+
+      // Skip zero-width cells (part of wide characters)
+      if (width === 0) continue;
+
+      // A cell continuing the previous cell's grapheme cluster (the rest of a
+      // ZWJ sequence, a skin tone modifier, the second half of a flag) joins it
+      const previous = rowCells[rowCells.length - 1];
+      if (previous && char.charCodeAt(0) >= 0x300 && continuesCluster(previous.char, char)) {
+        previous.char += char;
+        previous.width += width;
+        merged = true;
+        continue;
+      }
+
+      // Build attributes byte
+      let attributes = 0;
+      if (cell.isBold()) attributes |= 0x01;
+      if (cell.isItalic()) attributes |= 0x02;
+      if (cell.isUnderline()) attributes |= 0x04;
+      if (cell.isDim()) attributes |= 0x08;
+      if (cell.isInverse()) attributes |= 0x10;
+      if (cell.isInvisible()) attributes |= 0x20;
+      if (cell.isStrikethrough()) attributes |= 0x40;
+
+      const bufferCell: BufferCell = {
+        char,
+        width,
+      };
+
+      // Only include non-default values
+      const fg = cell.getFgColor();
+      const bg = cell.getBgColor();
+
+      // Handle color values - -1 means default color
+      if (fg !== undefined && fg !== -1) bufferCell.fg = fg;
+      if (bg !== undefined && bg !== -1) bufferCell.bg = bg;
+      if (attributes !== 0) bufferCell.attributes = attributes;
+
+      rowCells.push(bufferCell);
+    }
+
+    if (merged) rowCells = fitClusterWidths(rowCells);
+
+    // Trim blank cells from the end of the line
+    let lastNonBlankCell = rowCells.length - 1;
+    while (lastNonBlankCell >= 0) {
+      const cell = rowCells[lastNonBlankCell];
+      if (
+        cell.char !== ' ' ||
+        cell.fg !== undefined ||
+        cell.bg !== undefined ||
+        cell.attributes !== undefined
+      ) {
+        break;
+      }
+      lastNonBlankCell--;
+    }
+
+    // Trim the array, but keep at least one cell
+    if (lastNonBlankCell < rowCells.length - 1) {
+      rowCells.splice(Math.max(1, lastNonBlankCell + 1));
+    }
+
+    return rowCells;
   }
 
   /**
@@ -343,47 +803,86 @@ export class TerminalManager {
    */
   encodeSnapshot(snapshot: BufferSnapshot): Buffer {
     const startTime = Date.now();
-    const { cols, rows, viewportY, cursorX, cursorY, cells } = snapshot;
+    const rows = snapshot.cells.map((rowCells) => this.encodeRow(rowCells));
+    const linkTable = encodeLinkTable(snapshot.links);
+    const size =
+      SNAPSHOT_HEADER_SIZE +
+      rows.reduce((sum, row) => sum + row.length, 0) +
+      (linkTable?.length ?? 0);
 
-    // Pre-calculate actual data size for efficiency
-    let dataSize = 32; // Header size
+    const buffer = Buffer.allocUnsafe(size);
+    this.writeSnapshot(buffer, 0, snapshot, rows, linkTable);
 
-    // First pass: calculate exact size needed
-    for (let row = 0; row < cells.length; row++) {
-      const rowCells = cells[row];
-      if (
-        rowCells.length === 0 ||
-        (rowCells.length === 1 &&
-          rowCells[0].char === ' ' &&
-          !rowCells[0].fg &&
-          !rowCells[0].bg &&
-          !rowCells[0].attributes)
-      ) {
-        // Empty row marker: 2 bytes
-        dataSize += 2;
-      } else {
-        // Row header: 3 bytes (marker + length)
-        dataSize += 3;
-
-        for (const cell of rowCells) {
-          dataSize += this.calculateCellSize(cell);
-        }
+    const duration = Date.now() - startTime;
+    if (duration > 5) {
+      logger.debug(`Encoded snapshot: ${size} bytes in ${duration}ms (${snapshot.rows} rows)`);
+    }
+
+    return buffer;
+  }
+
+  /**
+   * Encode a snapshot into a pooled buffer, leaving `prefixLength` bytes free at
+   * the start for the caller's framing. The caller must release the buffer once
+   * it has been written out (e.g. in the WebSocket send callback).
+   */
+  encodeSnapshotPooled(
+    snapshot: BufferSnapshot,
+    prefixLength = 0,
+    options: SnapshotEncodeOptions = {}
+  ): EncodedSnapshot {
+    const rows = snapshot.cells.map((rowCells) => this.encodeRow(rowCells));
+    const linkTable = encodeLinkTable(snapshot.links);
+    const body = options.baseRows ? deltaRows(rows, options.baseRows) : rows;
+    const flags = body === rows ? 0 : FLAG_DELTA;
+    const bodySize = body.reduce((sum, row) => sum + row.length, 0) + (linkTable?.length ?? 0);
+
+    if (options.compress && bodySize >= COMPRESSION_THRESHOLD) {
+      const plain = Buffer.allocUnsafe(SNAPSHOT_HEADER_SIZE + bodySize);
+      this.writeSnapshot(plain, 0, snapshot, body, linkTable, flags);
+      const compressed = deflateRawSync(plain.subarray(SNAPSHOT_HEADER_SIZE), { level: 1 });
+      if (compressed.length < bodySize) {
+        const pooled = snapshotBufferPool.acquire(
+          prefixLength + SNAPSHOT_HEADER_SIZE + compressed.length
+        );
+        plain.copy(pooled.buffer, prefixLength, 0, SNAPSHOT_HEADER_SIZE);
+        pooled.buffer.writeUInt8(SNAPSHOT_FORMAT_2, prefixLength + 2);
+        pooled.buffer.writeUInt8(plain[3] | FLAG_COMPRESSED, prefixLength + 3);
+        compressed.copy(pooled.buffer, prefixLength + SNAPSHOT_HEADER_SIZE);
+        return { ...pooled, rows };
       }
     }
 
-    const buffer = Buffer.allocUnsafe(dataSize);
-    let offset = 0;
+    const pooled = snapshotBufferPool.acquire(prefixLength + SNAPSHOT_HEADER_SIZE + bodySize);
+    this.writeSnapshot(pooled.buffer, prefixLength, snapshot, body, linkTable, flags);
+    return { ...pooled, rows };
+  }
 
-    // Write header (32 bytes)
+  /**
+   * Write the 28-byte header followed by pre-encoded rows and the link table
+   */
+  private writeSnapshot(
+    buffer: Buffer,
+    start: number,
+    snapshot: BufferSnapshot,
+    rows: Buffer[],
+    linkTable: Buffer | null,
+    flags = 0
+  ): number {
+    const { cols, rows: rowCount, viewportY, cursorX, cursorY } = snapshot;
+    let offset = start;
+
+    // Write header (28 bytes)
     buffer.writeUInt16LE(0x5654, offset);
     offset += 2; // Magic "VT"
-    buffer.writeUInt8(0x01, offset); // Version 1 - our only format
+    // Version 2 only for frames using its features, so old clients can decode the rest
+    buffer.writeUInt8(flags ? SNAPSHOT_FORMAT_2 : SNAPSHOT_FORMAT_1, offset);
     offset += 1; // Version
-    buffer.writeUInt8(0x00, offset);
+    buffer.writeUInt8(flags | (linkTable ? FLAG_LINK_TABLE : 0x00), offset);
     offset += 1; // Flags
     buffer.writeUInt32LE(cols, offset);
     offset += 4; // Cols (32-bit)
-    buffer.writeUInt32LE(rows, offset);
+    buffer.writeUInt32LE(rowCount, offset);
     offset += 4; // Rows (32-bit)
     buffer.writeInt32LE(viewportY, offset); // Signed for large buffers
     offset += 4; // ViewportY (32-bit signed)
@@ -391,47 +890,65 @@ export class TerminalManager {
     offset += 4; // CursorX (32-bit signed)
     buffer.writeInt32LE(cursorY, offset); // Signed for relative positions
     offset += 4; // CursorY (32-bit signed)
+    const reservedOffset = offset;
     buffer.writeUInt32LE(0, offset);
-    offset += 4; // Reserved
+    offset += 4; // Reserved (link table offset when flagged)
 
-    // Write cells with new optimized format
-    for (let row = 0; row < cells.length; row++) {
-      const rowCells = cells[row];
+    for (const row of rows) {
+      row.copy(buffer, offset);
+      offset += row.length;
+    }
 
-      // Check if this is an empty row
-      if (
-        rowCells.length === 0 ||
-        (rowCells.length === 1 &&
-          rowCells[0].char === ' ' &&
-          !rowCells[0].fg &&
-          !rowCells[0].bg &&
-          !rowCells[0].attributes)
-      ) {
-        // Empty row marker
-        buffer.writeUInt8(0xfe, offset++); // Empty row marker
-        buffer.writeUInt8(1, offset++); // Count of empty rows (for now just 1)
-      } else {
-        // Row with content
-        buffer.writeUInt8(0xfd, offset++); // Row marker
-        buffer.writeUInt16LE(rowCells.length, offset); // Number of cells in row
-        offset += 2;
-
-        // Write each cell
-        for (const cell of rowCells) {
-          offset = this.encodeCell(buffer, offset, cell);
-        }
-      }
+    if (linkTable) {
+      buffer.writeUInt32LE(offset - start, reservedOffset);
+      linkTable.copy(buffer, offset);
+      offset += linkTable.length;
     }
 
-    // Return exact size buffer
-    const result = buffer.subarray(0, offset);
+    return offset;
+  }
 
-    const duration = Date.now() - startTime;
-    if (duration > 5) {
-      logger.debug(`Encoded snapshot: ${result.length} bytes in ${duration}ms (${rows} rows)`);
+  /**
+   * Encode a single row (cached per row array, since unchanged rows are shared
+   * between snapshots)
+   */
+  private encodeRow(rowCells: BufferCell[]): Buffer {
+    // Check if this is an empty row
+    if (
+      rowCells.length === 0 ||
+      (rowCells.length === 1 &&
+        rowCells[0].char === ' ' &&
+        rowCells[0].fg === undefined &&
+        rowCells[0].bg === undefined &&
+        !rowCells[0].attributes)
+    ) {
+      return EMPTY_ROW_ENCODING;
+    }
+
+    const cached = this.encodedRows.get(rowCells);
+    if (cached) {
+      return cached;
     }
 
-    return result;
+    // Row header: 3 bytes (marker + length)
+    let size = 3;
+    for (const cell of rowCells) {
+      size += this.calculateCellSize(cell);
+    }
+
+    const buffer = Buffer.allocUnsafe(size);
+    let offset = 0;
+    buffer.writeUInt8(0xfd, offset++); // Row marker
+    buffer.writeUInt16LE(rowCells.length, offset); // Number of cells in row
+    offset += 2;
+
+    // Write each cell
+    for (const cell of rowCells) {
+      offset = this.encodeCell(buffer, offset, cell);
+    }
+
+    this.encodedRows.set(rowCells, buffer);
+    return buffer;
   }
 
   /**
@@ -448,7 +965,8 @@ export class TerminalManager {
     const hasAttrs = cell.attributes && cell.attributes !== 0;
     const hasFg = cell.fg !== undefined;
     const hasBg = cell.bg !== undefined;
-    const isAscii = cell.char.charCodeAt(0) <= 127;
+    // One ASCII character; anything longer (e.g. with combining marks) is Unicode
+    const isAscii = cell.char.length === 1 && cell.char.charCodeAt(0) <= 127;
 
     if (isSpace && !hasAttrs && !hasFg && !hasBg) {
       return 1; // Just a space marker
@@ -459,8 +977,7 @@ export class TerminalManager {
     if (isAscii) {
       size += 1; // ASCII character
     } else {
-      const charBytes = Buffer.byteLength(cell.char, 'utf8');
-      size += 1 + charBytes; // Length byte + UTF-8 bytes
+      size += 1 + cellCharBytes(cell.char).length; // Length byte + UTF-8 bytes
     }
 
     // Attributes/colors byte
@@ -487,7 +1004,8 @@ export class TerminalManager {
     const hasAttrs = cell.attributes && cell.attributes !== 0;
     const hasFg = cell.fg !== undefined;
     const hasBg = cell.bg !== undefined;
-    const isAscii = cell.char.charCodeAt(0) <= 127;
+    // One ASCII character; anything longer (e.g. with combining marks) is Unicode
+    const isAscii = cell.char.length === 1 && cell.char.charCodeAt(0) <= 127;
 
     // Type byte format:
     // Bit 7: Has extended data (attrs/colors)
@@ -531,7 +1049,7 @@ export class TerminalManager {
 
     // Write character
     if (!isAscii) {
-      const charBytes = Buffer.from(cell.char, 'utf8');
+      const charBytes = cellCharBytes(cell.char);
       buffer.writeUInt8(charBytes.length, offset++);
       charBytes.copy(buffer, offset);
       offset += charBytes.length;
@@ -589,6 +1107,11 @@ export class TerminalManager {
       }
       sessionTerminal.terminal.dispose();
       this.terminals.delete(sessionId);
+      const pending = this.changeTimers.get(sessionId);
+      if (pending) {
+        clearTimeout(pending.timer);
+        this.changeTimers.delete(sessionId);
+      }
       logger.log(chalk.yellow(`Terminal closed for session ${sessionId}`));
     }
   }
@@ -623,12 +1146,34 @@ export class TerminalManager {
     return Array.from(this.terminals.keys());
   }
 
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let fileWatchers = 0;
+    for (const sessionTerminal of this.terminals.values()) {
+      if (sessionTerminal.watcher) fileWatchers++;
+    }
+    let bufferSubscriptions = 0;
+    for (const listeners of this.bufferListeners.values()) {
+      bufferSubscriptions += listeners.size;
+    }
+    return {
+      terminals: this.terminals.size,
+      fileWatchers,
+      bufferSubscriptions,
+      pendingNotifications: this.changeTimers.size,
+      pausedSessions: this.pausedSessions.size,
+    };
+  }
+
   /**
    * Subscribe to buffer changes for a session
    */
   async subscribeToBufferChanges(
     sessionId: string,
-    listener: BufferChangeListener
+    listener: BufferChangeListener,
+    options: BufferSubscriptionOptions = {}
   ): Promise<() => void> {
     // Ensure terminal exists and is watching
     await this.getTerminal(sessionId);
@@ -637,9 +1182,19 @@ export class TerminalManager {
       this.bufferListeners.set(sessionId, new Set());
     }
 
+    const throttled = options.maxFps ? this.throttleListener(listener, options.maxFps) : null;
+    const registered = throttled ? throttled.listener : listener;
+
+    const { onImage } = options;
+    if (onImage) {
+      const imageListeners = this.imageListeners.get(sessionId) ?? new Set();
+      imageListeners.add(onImage);
+      this.imageListeners.set(sessionId, imageListeners);
+    }
+
     const listeners = this.bufferListeners.get(sessionId);
     if (listeners) {
-      listeners.add(listener);
+      listeners.add(registered);
       logger.log(
         chalk.blue(`Buffer listener subscribed for session ${sessionId} (${listeners.size} total)`)
       );
@@ -647,9 +1202,15 @@ export class TerminalManager {
 
     // Return unsubscribe function
     return () => {
+      throttled?.cancel();
+      const imageListeners = onImage && this.imageListeners.get(sessionId);
+      if (imageListeners) {
+        imageListeners.delete(onImage);
+        if (imageListeners.size === 0) this.imageListeners.delete(sessionId);
+      }
       const listeners = this.bufferListeners.get(sessionId);
       if (listeners) {
-        listeners.delete(listener);
+        listeners.delete(registered);
         logger.log(
           chalk.yellow(
             `Buffer listener unsubscribed for session ${sessionId} (${listeners.size} remaining)`
@@ -663,28 +1224,114 @@ export class TerminalManager {
   }
 
   /**
-   * Schedule buffer change notification (debounced)
+   * Wrap a listener so it receives at most maxFps snapshots per second. Snapshots
+   * arriving too early are held and only the latest one is delivered.
+   */
+  private throttleListener(
+    listener: BufferChangeListener,
+    maxFps: number
+  ): { listener: BufferChangeListener; cancel: () => void } {
+    const minInterval = 1000 / maxFps;
+    let lastDelivered = 0;
+    let timer: NodeJS.Timeout | null = null;
+    let latest: { sessionId: string; snapshot: BufferSnapshot } | null = null;
+
+    const deliver = () => {
+      timer = null;
+      if (!latest) return;
+      const { sessionId, snapshot } = latest;
+      latest = null;
+      lastDelivered = Date.now();
+      listener(sessionId, snapshot);
+    };
+
+    return {
+      listener: (sessionId, snapshot) => {
+        latest = { sessionId, snapshot };
+        if (timer) return;
+        const wait = lastDelivered + minInterval - Date.now();
+        if (wait <= 0) {
+          deliver();
+        } else {
+          timer = setTimeout(() => {
+            try {
+              deliver();
+            } catch (error) {
+              logger.error(`Error notifying throttled buffer listener for ${sessionId}:`, error);
+            }
+          }, wait);
+        }
+      },
+      cancel: () => {
+        if (timer) clearTimeout(timer);
+        timer = null;
+        latest = null;
+      },
+    };
+  }
+
+  /**
+   * Schedule buffer change notification (debounced).
+   *
+   * Listeners are notified once output has been quiet for the debounce period.
+   * Continuous output cannot postpone a notification beyond the coalescing
+   * window, which starts at the debounce period and doubles (up to
+   * maxCoalesceMs) each time output is still running when it expires, so
+   * high-rate output is sent at a decreasing frame rate while idle sessions
+   * stay responsive.
    */
   private scheduleBufferChangeNotification(sessionId: string) {
-    // Cancel existing timer
-    const existingTimer = this.changeTimers.get(sessionId);
-    if (existingTimer) {
-      clearTimeout(existingTimer);
+    const now = Date.now();
+    const sessionTerminal = this.terminals.get(sessionId);
+    const level = sessionTerminal?.coalesceLevel ?? 0;
+    const maxWait = Math.min(this.maxCoalesceMs, this.notifyDebounceMs * 2 ** (level + 1));
+
+    // Cancel existing timer, keeping the start of the pending window
+    const pending = this.changeTimers.get(sessionId);
+    if (pending) {
+      clearTimeout(pending.timer);
     }
+    const firstChangeAt = pending ? pending.firstChangeAt : now;
+
+    const deadline = firstChangeAt + maxWait;
+    const delay = Math.max(0, Math.min(this.notifyDebounceMs, deadline - now));
+    const forced = delay < this.notifyDebounceMs;
 
-    // Schedule new notification in 50ms
     const timer = setTimeout(() => {
       this.changeTimers.delete(sessionId);
+      if (sessionTerminal) {
+        // Output still running when the window closed: coalesce more next time
+        sessionTerminal.coalesceLevel = forced
+          ? Math.min(sessionTerminal.coalesceLevel + 1, 8)
+          : 0;
+      }
       this.notifyBufferChange(sessionId);
-    }, 50);
+    }, delay);
 
-    this.changeTimers.set(sessionId, timer);
+    this.changeTimers.set(sessionId, { timer, firstChangeAt });
+  }
+
+  /**
+   * Stop notifying buffer listeners of a session. The terminal keeps processing
+   * output, so snapshots stay current.
+   */
+  pauseUpdates(sessionId: string): void {
+    this.pausedSessions.add(sessionId);
+  }
+
+  /**
+   * Resume notifying buffer listeners, sending them the current buffer
+   */
+  resumeUpdates(sessionId: string): void {
+    if (!this.pausedSessions.delete(sessionId)) return;
+    this.notifyBufferChange(sessionId);
   }
 
   /**
    * Notify listeners of buffer change
    */
   private async notifyBufferChange(sessionId: string) {
+    if (this.pausedSessions.has(sessionId)) return;
     const listeners = this.bufferListeners.get(sessionId);
     if (!listeners || listeners.size === 0) return;
 
@@ -694,6 +1341,16 @@ export class TerminalManager {
       // Get full buffer snapshot
       const snapshot = await this.getBufferSnapshot(sessionId);
 
+      // Skip if listeners already have this exact frame
+      const sessionTerminal = this.terminals.get(sessionId);
+      if (sessionTerminal) {
+        if (sessionTerminal.notifiedSnapshot === snapshot) {
+          logger.debug(`Buffer unchanged for session ${sessionId}, skipping notification`);
+          return;
+        }
+        sessionTerminal.notifiedSnapshot = snapshot;
+      }
+
       // Notify all listeners
       listeners.forEach((listener) => {
         try {
@@ -707,3 +1364,118 @@ export class TerminalManager {
     }
   }
 }
+
+/**
+ * UTF-8 bytes of a cell's character, cut at a code point boundary if a long
+ * grapheme cluster (e.g. stacked combining marks) exceeds the length byte
+ */
+function cellCharBytes(char: string): Buffer {
+  const bytes = Buffer.from(char, 'utf8');
+  if (bytes.length <= MAX_CELL_CHAR_BYTES) return bytes;
+  let end = MAX_CELL_CHAR_BYTES;
+  // Continuation bytes (10xxxxxx) belong to the code point before them
+  while (end > 0 && (bytes[end] & 0xc0) === 0x80) end--;
+  return bytes.subarray(0, end);
+}
+
+/**
+ * Link table appended to encoded snapshots: UTF-8 JSON `{ links }`. Decoders
+ * that predate it skip it, since UTF-8 never contains the row markers.
+ */
+function encodeLinkTable(links: SnapshotLink[] | undefined): Buffer | null {
+  return links?.length ? Buffer.from(JSON.stringify({ links }), 'utf8') : null;
+}
+
+/**
+ * Rows of a delta frame: runs of rows equal to the base frame's row at the same
+ * index become ROW_UNCHANGED markers. Returns `rows` itself if no row is unchanged.
+ */
+function deltaRows(rows: Buffer[], baseRows: Buffer[]): Buffer[] {
+  const delta: Buffer[] = [];
+  let run = 0;
+  let unchanged = false;
+  const endRun = () => {
+    while (run > 0) {
+      const count = Math.min(run, 255);
+      delta.push(Buffer.from([ROW_UNCHANGED, count]));
+      run -= count;
+    }
+  };
+
+  rows.forEach((row, index) => {
+    const base = baseRows[index];
+    // Unchanged rows usually share the cached encoding
+    if (base && (base === row || base.equals(row))) {
+      run++;
+      unchanged = true;
+    } else {
+      endRun();
+      delta.push(row);
+    }
+  });
+  endRun();
+  return unchanged ? delta : rows;
+}
+
+/**
+ * Give merged clusters the width they are shown with. A cluster taking fewer
+ * columns than the cells it was merged from (a family emoji spans three) is
+ * followed by blank cells, so the cells after it stay in their columns.
+ */
+function fitClusterWidths(rowCells: BufferCell[]): BufferCell[] {
+  const fitted: BufferCell[] = [];
+  for (const rowCell of rowCells) {
+    const shown = rowCell.width > 1 ? cellWidth(rowCell.char) : rowCell.width;
+    fitted.push(shown < rowCell.width ? { ...rowCell, width: shown } : rowCell);
+    for (let i = shown; i < rowCell.width; i++) {
+      const blank: BufferCell = { char: ' ', width: 1 };
+      if (rowCell.bg !== undefined) blank.bg = rowCell.bg;
+      fitted.push(blank);
+    }
+  }
+  return fitted;
+}
+
+/**
+ * Turn column ranges into ranges of the row's cells, dropping those past the
+ * row's trimmed end
+ */
+function columnsToCells(
+  spans: SnapshotLink['spans'],
+  cells: BufferCell[][]
+): SnapshotLink['spans'] {
+  const converted: SnapshotLink['spans'] = [];
+  for (const [row, startCol, endCol] of spans) {
+    const rowCells = cells[row] ?? [];
+    let startCell = -1;
+    let endCell = rowCells.length;
+    let col = 0;
+    for (let i = 0; i < rowCells.length; i++) {
+      if (col >= endCol) {
+        endCell = i;
+        break;
+      }
+      if (startCell === -1 && col + rowCells[i].width > startCol) startCell = i;
+      col += rowCells[i].width;
+    }
+    if (startCell !== -1 && endCell > startCell) converted.push([row, startCell, endCell]);
+  }
+  return converted;
+}
+
+/**
+ * Move link spans to rows counted from `start` and cut them to the cropped rows
+ */
+function cropSpans(
+  spans: SnapshotLink['spans'],
+  start: number,
+  cells: BufferCell[][]
+): SnapshotLink['spans'] {
+  const cropped: SnapshotLink['spans'] = [];
+  for (const [row, startCell, endCell] of spans) {
+    const rowLength = cells[row - start]?.length ?? 0;
+    if (startCell >= rowLength) continue;
+    cropped.push([row - start, startCell, Math.min(endCell, rowLength)]);
+  }
+  return cropped;
+}
@@ -0,0 +1,207 @@
+/**
+ * TextWaiter - Waits for text to appear in sessions, for expect-style automation
+ *
+ * A wait matches a regular expression against the session's screen (rendered
+ * as plain text and checked again on every buffer change) or against the
+ * output printed after the wait started, without escape sequences. Output
+ * waits only see sessions running in this process, whose output the PTY
+ * manager emits; screen waits work for every session.
+ */
+
+import { cellsToText } from '../../shared/terminal-text-formatter.js';
+import { stripEscapeSequences } from '../pty/index.js';
+import { createLogger } from '../utils/logger.js';
+import type { TerminalManager } from './terminal-manager.js';
+
+const logger = createLogger('text-waiter');
+
+export const WAIT_SCOPES = ['screen', 'output'] as const;
+export type WaitScope = (typeof WAIT_SCOPES)[number];
+
+export const DEFAULT_WAIT_TIMEOUT_MS = 30_000;
+export const MAX_WAIT_TIMEOUT_MS = 10 * 60_000;
+// Output kept for matching per wait; older output is dropped
+const MAX_OUTPUT_LENGTH = 64 * 1024;
+// Pending waits across all sessions
+const MAX_PENDING_WAITS = 100;
+
+export interface WaitOptions {
+  pattern: string;
+  ignoreCase?: boolean;
+  scope?: WaitScope;
+  timeoutMs?: number;
+  // Ends the wait early, e.g. when the client disconnects
+  signal?: AbortSignal;
+}
+
+export interface WaitResult {
+  matched: boolean;
+  // Why the wait ended without a match
+  reason?: 'timeout' | 'exited' | 'aborted';
+  match?: string;
+  groups?: string[];
+  // Line of the screen or output the match starts on
+  line?: string;
+  elapsedMs: number;
+}
+
+export class WaitError extends Error {
+  constructor(
+    message: string,
+    readonly status = 400
+  ) {
+    super(message);
+    this.name = 'WaitError';
+  }
+}
+
+interface PendingWait {
+  sessionId: string;
+  scope: WaitScope;
+  pattern: RegExp;
+  // Output seen since the wait started (output scope)
+  output: string;
+  finish(result: Omit<WaitResult, 'elapsedMs'>): void;
+}
+
+export class TextWaiter {
+  private waits = new Set<PendingWait>();
+
+  constructor(private terminalManager: TerminalManager) {}
+
+  /**
+   * Wait until the pattern matches, the timeout passes or the session exits.
+   * `armed` runs once the wait is listening, so output caused by input sent
+   * there cannot be missed; screen waits given one only match changes after it.
+   * Throws WaitError for invalid options.
+   */
+  waitFor(sessionId: string, options: WaitOptions, armed?: () => void): Promise<WaitResult> {
+    const scope = options.scope ?? 'screen';
+    if (!WAIT_SCOPES.includes(scope)) {
+      throw new WaitError(`scope must be one of ${WAIT_SCOPES.join(', ')}`);
+    }
+    const timeoutMs = options.timeoutMs ?? DEFAULT_WAIT_TIMEOUT_MS;
+    if (!Number.isInteger(timeoutMs) || timeoutMs < 1 || timeoutMs > MAX_WAIT_TIMEOUT_MS) {
+      throw new WaitError(`timeoutMs must be an integer between 1 and ${MAX_WAIT_TIMEOUT_MS}`);
+    }
+    const pattern = compilePattern(options.pattern, options.ignoreCase ?? false);
+    if (this.waits.size >= MAX_PENDING_WAITS) {
+      throw new WaitError('Too many pending waits', 429);
+    }
+
+    const startedAt = Date.now();
+    return new Promise((resolve, reject) => {
+      let done = false;
+      let unsubscribe: (() => void) | undefined;
+      const onAbort = () => wait.finish({ matched: false, reason: 'aborted' });
+      const end = () => {
+        done = true;
+        clearTimeout(timer);
+        unsubscribe?.();
+        options.signal?.removeEventListener('abort', onAbort);
+        this.waits.delete(wait);
+      };
+      const wait: PendingWait = {
+        sessionId,
+        scope,
+        pattern,
+        output: '',
+        finish: (result) => {
+          if (done) return;
+          end();
+          logger.debug(
+            `wait for /${pattern.source}/ in ${sessionId} ended: ${result.reason ?? 'matched'}`
+          );
+          resolve({ ...result, elapsedMs: Date.now() - startedAt });
+        },
+      };
+      const timer = setTimeout(() => wait.finish({ matched: false, reason: 'timeout' }), timeoutMs);
+      this.waits.add(wait);
+      if (options.signal?.aborted) return onAbort();
+      options.signal?.addEventListener('abort', onAbort);
+
+      if (scope === 'output') {
+        try {
+          armed?.();
+        } catch (error) {
+          end();
+          reject(error);
+        }
+        return;
+      }
+
+      const check = (cells: Parameters<typeof cellsToText>[0]) => {
+        const result = matchText(pattern, cellsToText(cells, false));
+        if (result) wait.finish(result);
+      };
+      this.terminalManager
+        .subscribeToBufferChanges(sessionId, (_sessionId, snapshot) => check(snapshot.cells))
+        .then(async (stop) => {
+          if (done) return stop();
+          unsubscribe = stop;
+          if (armed) {
+            armed();
+          } else {
+            check((await this.terminalManager.getBufferSnapshot(sessionId)).cells);
+          }
+        })
+        .catch((error) => {
+          if (done) return;
+          end();
+          reject(error);
+        });
+    });
+  }
+
+  /**
+   * Match output of a session against its output waits
+   */
+  handleOutput(sessionId: string, data: string): void {
+    if (this.waits.size === 0) return;
+    const text = stripEscapeSequences(data).replace(/\r\n?/g, '\n');
+    for (const wait of this.waits) {
+      if (wait.sessionId !== sessionId || wait.scope !== 'output') continue;
+      wait.output = (wait.output + text).slice(-MAX_OUTPUT_LENGTH);
+      const result = matchText(wait.pattern, wait.output);
+      if (result) wait.finish(result);
+    }
+  }
+
+  /**
+   * End the waits of a session that exited
+   */
+  removeSession(sessionId: string): void {
+    for (const wait of this.waits) {
+      if (wait.sessionId === sessionId) wait.finish({ matched: false, reason: 'exited' });
+    }
+  }
+
+  getPendingCount(): number {
+    return this.waits.size;
+  }
+}
+
+function compilePattern(pattern: unknown, ignoreCase: boolean): RegExp {
+  if (typeof pattern !== 'string' || !pattern) {
+    throw new WaitError('Pattern is required');
+  }
+  try {
+    // Multiline: ^ and $ match at line boundaries of the screen or output
+    return new RegExp(pattern, ignoreCase ? 'im' : 'm');
+  } catch (error) {
+    throw new WaitError(`Invalid pattern: ${(error as Error).message}`);
+  }
+}
+
+function matchText(pattern: RegExp, text: string): Omit<WaitResult, 'elapsedMs'> | null {
+  const match = pattern.exec(text);
+  if (!match) return null;
+  const lineStart = text.lastIndexOf('\n', match.index - 1) + 1;
+  const lineEnd = text.indexOf('\n', match.index);
+  return {
+    matched: true,
+    match: match[0],
+    groups: match.slice(1).map((group) => group ?? ''),
+    line: text.slice(lineStart, lineEnd === -1 ? undefined : lineEnd),
+  };
+}
@@ -0,0 +1,109 @@
+/**
+ * ThumbnailService - Text previews of what each session is showing
+ *
+ * A thumbnail is the last few non-blank lines of a session's screen, for
+ * session pickers that want to show what each terminal is doing without
+ * subscribing to its buffer. Thumbnails are rendered on request and reused
+ * for the refresh interval, so clients polling the session list with
+ * thumbnails do not snapshot every terminal on every poll.
+ */
+
+import type { SessionThumbnail } from '../../shared/types.js';
+import { createLogger } from '../utils/logger.js';
+import type { TerminalManager } from './terminal-manager.js';
+
+const logger = createLogger('thumbnail-service');
+
+const DEFAULT_REFRESH_MS = 5000;
+export const DEFAULT_THUMBNAIL_LINES = 5;
+export const MAX_THUMBNAIL_LINES = 50;
+// Longer lines are cut; previews are small
+const MAX_LINE_LENGTH = 200;
+
+interface CachedThumbnail {
+  // Screen lines up to the last non-blank one
+  thumbnail: Promise<SessionThumbnail>;
+  expiresAt: number;
+}
+
+export interface ThumbnailServiceOptions {
+  refreshMs?: number;
+}
+
+export class ThumbnailService {
+  private cache = new Map<string, CachedThumbnail>();
+  private refreshMs: number;
+  private stats = { rendered: 0, cacheHits: 0 };
+
+  constructor(
+    private terminalManager: TerminalManager,
+    options: ThumbnailServiceOptions = {}
+  ) {
+    this.refreshMs = options.refreshMs ?? DEFAULT_REFRESH_MS;
+  }
+
+  /**
+   * Get the last `lines` non-blank lines of a session's screen, rendered at
+   * most once per refresh interval
+   */
+  async getThumbnail(
+    sessionId: string,
+    lines = DEFAULT_THUMBNAIL_LINES
+  ): Promise<SessionThumbnail> {
+    const now = Date.now();
+    let cached = this.cache.get(sessionId);
+    if (cached && cached.expiresAt > now) {
+      this.stats.cacheHits++;
+    } else {
+      cached = { thumbnail: this.render(sessionId), expiresAt: now + this.refreshMs };
+      this.cache.set(sessionId, cached);
+      // Failed renders are retried on the next request
+      cached.thumbnail.catch(() => this.cache.delete(sessionId));
+    }
+
+    const thumbnail = await cached.thumbnail;
+    const count = Math.min(Math.max(1, lines), MAX_THUMBNAIL_LINES);
+    return { ...thumbnail, lines: thumbnail.lines.slice(-count) };
+  }
+
+  /**
+   * Drop the cached thumbnail of a session (e.g. when it exits)
+   */
+  remove(sessionId: string): void {
+    this.cache.delete(sessionId);
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    return { cached: this.cache.size, ...this.stats };
+  }
+
+  destroy(): void {
+    this.cache.clear();
+  }
+
+  private async render(sessionId: string): Promise<SessionThumbnail> {
+    const snapshot = await this.terminalManager.getBufferSnapshot(sessionId);
+    const lines = snapshot.cells.map((row) =>
+      row
+        .map((cell) => cell.char)
+        .join('')
+        .trimEnd()
+        .slice(0, MAX_LINE_LENGTH)
+    );
+    while (lines.length > 0 && lines[lines.length - 1] === '') {
+      lines.pop();
+    }
+
+    this.stats.rendered++;
+    logger.debug(`rendered thumbnail of session ${sessionId} (${lines.length} lines)`);
+    return {
+      lines,
+      cols: snapshot.cols,
+      rows: snapshot.rows,
+      updatedAt: new Date().toISOString(),
+    };
+  }
+}
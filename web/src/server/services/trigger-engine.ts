@@ -0,0 +1,338 @@
+import { EventEmitter } from 'events';
+import * as fs from 'fs';
+import * as path from 'path';
+import { v4 as uuidv4 } from 'uuid';
+import { type PtyManager, visibleLineText } from '../pty/index.js';
+import type { InputSource } from '../utils/input-source.js';
+import { createLogger } from '../utils/logger.js';
+import type { InputLockManager } from './input-lock.js';
+
+const logger = createLogger('triggers');
+
+// Partial lines longer than this are matched and dropped
+const MAX_LINE_LENGTH = 16 * 1024;
+const DEFAULT_COOLDOWN_MS = 5000;
+const WEBHOOK_TIMEOUT_MS = 5000;
+
+// Attribution of input triggers type into sessions
+const TRIGGER_SOURCE: InputSource = { authMethod: 'trigger' };
+
+export type TriggerAction =
+  // POST the trigger event as JSON
+  | { type: 'webhook'; url: string }
+  // Send the trigger event to clients viewing the session
+  | { type: 'event' }
+  // Mark the session (label defaults to the trigger name)
+  | { type: 'mark'; label?: string }
+  // Type text into the session, followed by Enter unless `enter` is false
+  | { type: 'input'; text: string; enter?: boolean };
+
+export interface Trigger {
+  id: string;
+  name: string;
+  // Regular expression matched against each line of output, without escape sequences
+  pattern: string;
+  ignoreCase: boolean;
+  // Session the trigger watches; all sessions if unset
+  sessionId?: string;
+  actions: TriggerAction[];
+  enabled: boolean;
+  // Least time between two firings in the same session
+  cooldownMs: number;
+  createdAt: string;
+  lastFiredAt: string | null;
+  fireCount: number;
+}
+
+export type TriggerInput = Pick<Trigger, 'name' | 'pattern' | 'actions'> &
+  Partial<Pick<Trigger, 'ignoreCase' | 'sessionId' | 'enabled' | 'cooldownMs'>>;
+
+export interface TriggerEvent {
+  triggerId: string;
+  triggerName: string;
+  sessionId: string;
+  // The line that matched and the matched text, with capture groups
+  line: string;
+  match: string;
+  groups: string[];
+  firedAt: string;
+}
+
+export class TriggerError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'TriggerError';
+  }
+}
+
+interface LineState {
+  // Output since the last newline
+  text: string;
+  // Triggers that already fired on the unfinished line
+  fired: Set<string>;
+}
+
+/**
+ * TriggerEngine - Regex triggers on session output that fire actions
+ *
+ * Triggers are stored in `triggers.json` in the control directory. Output of
+ * sessions running in this process is split into lines and matched as it
+ * arrives; unfinished lines (e.g. prompts) are matched too, once per trigger.
+ * A trigger fires at most once per cooldown in each session, which also keeps
+ * input actions from re-triggering on their own echo.
+ *
+ * Event actions are emitted as 'trigger' events (TriggerEvent).
+ */
+export class TriggerEngine extends EventEmitter {
+  private triggers = new Map<string, Trigger>();
+  private patterns = new Map<string, RegExp>();
+  private lines = new Map<string, LineState>();
+  // Last firing per trigger and session ("<triggerId>:<sessionId>")
+  private lastFired = new Map<string, number>();
+  private filePath: string;
+  private ptyManager: PtyManager;
+  // Triggers do not type into sessions locked by a client
+  private inputLocks?: InputLockManager;
+
+  constructor(controlDir: string, ptyManager: PtyManager, inputLocks?: InputLockManager) {
+    super();
+    this.filePath = path.join(controlDir, 'triggers.json');
+    this.ptyManager = ptyManager;
+    this.inputLocks = inputLocks;
+    this.load();
+  }
+
+  list(): Trigger[] {
+    return Array.from(this.triggers.values());
+  }
+
+  get(triggerId: string): Trigger | undefined {
+    return this.triggers.get(triggerId);
+  }
+
+  /**
+   * Register a trigger. Throws TriggerError for invalid input.
+   */
+  create(input: TriggerInput): Trigger {
+    const ignoreCase = input.ignoreCase ?? false;
+    const pattern = this.compile(input.pattern, ignoreCase);
+    this.validateSession(input.sessionId);
+
+    const trigger: Trigger = {
+      id: uuidv4(),
+      name: input.name,
+      pattern: input.pattern,
+      ignoreCase,
+      sessionId: input.sessionId,
+      actions: input.actions,
+      enabled: input.enabled ?? true,
+      cooldownMs: input.cooldownMs ?? DEFAULT_COOLDOWN_MS,
+      createdAt: new Date().toISOString(),
+      lastFiredAt: null,
+      fireCount: 0,
+    };
+    this.triggers.set(trigger.id, trigger);
+    this.patterns.set(trigger.id, pattern);
+    this.save();
+
+    logger.log(`trigger ${trigger.name} (${trigger.id}) added: /${trigger.pattern}/`);
+    return trigger;
+  }
+
+  /**
+   * Change a trigger. Throws TriggerError for invalid input.
+   */
+  update(triggerId: string, changes: Partial<TriggerInput>): Trigger | undefined {
+    const trigger = this.triggers.get(triggerId);
+    if (!trigger) return undefined;
+
+    const pattern = this.compile(
+      changes.pattern ?? trigger.pattern,
+      changes.ignoreCase ?? trigger.ignoreCase
+    );
+    this.validateSession(changes.sessionId);
+    Object.assign(trigger, changes);
+    this.patterns.set(trigger.id, pattern);
+    this.save();
+    return trigger;
+  }
+
+  delete(triggerId: string): boolean {
+    if (!this.triggers.delete(triggerId)) return false;
+    this.patterns.delete(triggerId);
+    for (const key of this.lastFired.keys()) {
+      if (key.startsWith(`${triggerId}:`)) this.lastFired.delete(key);
+    }
+    this.save();
+    logger.log(`trigger ${triggerId} deleted`);
+    return true;
+  }
+
+  /**
+   * Match output of a session against the triggers
+   */
+  handleOutput(sessionId: string, data: string): void {
+    if (!this.hasTriggersFor(sessionId)) return;
+
+    const state = this.lines.get(sessionId) ?? { text: '', fired: new Set<string>() };
+    this.lines.set(sessionId, state);
+
+    const parts = (state.text + data).split('\n');
+    state.text = parts.pop() ?? '';
+    for (const line of parts) {
+      this.matchLine(sessionId, line, state.fired);
+      state.fired = new Set();
+    }
+
+    if (state.text.length > MAX_LINE_LENGTH) {
+      this.matchLine(sessionId, state.text, state.fired);
+      state.text = '';
+      state.fired = new Set();
+    } else if (state.text) {
+      this.matchLine(sessionId, state.text, state.fired);
+    }
+  }
+
+  /**
+   * Forget the output state of a session that exited
+   */
+  removeSession(sessionId: string): void {
+    this.lines.delete(sessionId);
+    for (const key of this.lastFired.keys()) {
+      if (key.endsWith(`:${sessionId}`)) this.lastFired.delete(key);
+    }
+  }
+
+  private hasTriggersFor(sessionId: string): boolean {
+    for (const trigger of this.triggers.values()) {
+      if (trigger.enabled && (!trigger.sessionId || trigger.sessionId === sessionId)) return true;
+    }
+    return false;
+  }
+
+  private matchLine(sessionId: string, rawLine: string, fired: Set<string>): void {
+    const line = visibleLineText(rawLine);
+    if (!line.trim()) return;
+
+    for (const trigger of this.triggers.values()) {
+      if (!trigger.enabled || fired.has(trigger.id)) continue;
+      if (trigger.sessionId && trigger.sessionId !== sessionId) continue;
+
+      const match = this.patterns.get(trigger.id)?.exec(line);
+      if (!match) continue;
+      fired.add(trigger.id);
+
+      const key = `${trigger.id}:${sessionId}`;
+      const now = Date.now();
+      if (now - (this.lastFired.get(key) ?? 0) < trigger.cooldownMs) continue;
+      this.lastFired.set(key, now);
+
+      this.fire(trigger, {
+        triggerId: trigger.id,
+        triggerName: trigger.name,
+        sessionId,
+        line,
+        match: match[0],
+        groups: match.slice(1).map((group) => group ?? ''),
+        firedAt: new Date(now).toISOString(),
+      });
+    }
+  }
+
+  private fire(trigger: Trigger, event: TriggerEvent): void {
+    trigger.lastFiredAt = event.firedAt;
+    trigger.fireCount++;
+    logger.debug(`trigger ${trigger.name} fired in session ${event.sessionId}: ${event.match}`);
+
+    for (const action of trigger.actions) {
+      try {
+        this.runAction(action, trigger, event);
+      } catch (error) {
+        logger.warn(
+          `trigger ${trigger.name} ${action.type} action failed: ${error instanceof Error ? error.message : String(error)}`
+        );
+      }
+    }
+    this.save();
+  }
+
+  private runAction(action: TriggerAction, trigger: Trigger, event: TriggerEvent): void {
+    switch (action.type) {
+      case 'webhook':
+        fetch(action.url, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ type: 'trigger', ...event }),
+          signal: AbortSignal.timeout(WEBHOOK_TIMEOUT_MS),
+        })
+          .then((response) => {
+            if (!response.ok) {
+              logger.warn(`trigger ${trigger.name} webhook returned ${response.status}`);
+            }
+          })
+          .catch((error) => {
+            logger.warn(`trigger ${trigger.name} webhook failed: ${error.message}`);
+          });
+        break;
+      case 'event':
+        this.emit('trigger', event);
+        break;
+      case 'mark':
+        this.ptyManager.setSessionMark(event.sessionId, {
+          label: action.label || trigger.name,
+          triggerId: trigger.id,
+          markedAt: event.firedAt,
+        });
+        break;
+      case 'input':
+        if (this.inputLocks && !this.inputLocks.checkInput(event.sessionId)) {
+          throw new Error(`Session ${event.sessionId} input is locked`);
+        }
+        this.ptyManager.sendInput(event.sessionId, { text: action.text }, TRIGGER_SOURCE);
+        if (action.enter !== false) {
+          this.ptyManager.sendInput(event.sessionId, { key: 'enter' }, TRIGGER_SOURCE);
+        }
+        break;
+    }
+  }
+
+  private compile(pattern: string, ignoreCase: boolean): RegExp {
+    try {
+      return new RegExp(pattern, ignoreCase ? 'i' : '');
+    } catch (error) {
+      throw new TriggerError(error instanceof Error ? error.message : String(error));
+    }
+  }
+
+  private validateSession(sessionId: string | undefined): void {
+    if (sessionId && !this.ptyManager.getSession(sessionId)) {
+      throw new TriggerError(`Session ${sessionId} not found`);
+    }
+  }
+
+  private load(): void {
+    try {
+      if (!fs.existsSync(this.filePath)) return;
+      const stored = JSON.parse(fs.readFileSync(this.filePath, 'utf8')) as Trigger[];
+      for (const trigger of stored) {
+        try {
+          this.patterns.set(trigger.id, this.compile(trigger.pattern, trigger.ignoreCase));
+          this.triggers.set(trigger.id, trigger);
+        } catch (_error) {
+          logger.warn(`skipping stored trigger ${trigger.id} with invalid pattern`);
+        }
+      }
+      logger.debug(`loaded ${this.triggers.size} triggers`);
+    } catch (error) {
+      logger.error('failed to load triggers:', error);
+    }
+  }
+
+  private save(): void {
+    try {
+      fs.writeFileSync(this.filePath, JSON.stringify(this.list(), null, 2));
+    } catch (error) {
+      logger.error('failed to save triggers:', error);
+    }
+  }
+}
@@ -0,0 +1,216 @@
+/**
+ * ViewerPresence - Tracks who is watching and typing into each session
+ *
+ * A viewer joins when it opens an SSE stream or subscribes to the session over
+ * the buffer WebSocket, and leaves when the stream or subscription ends. Input
+ * marks its user as typing until TYPING_TIMEOUT_MS pass without more input.
+ * Every change emits 'presence-changed' with the session's new presence.
+ *
+ * Connections HQ opens to a remote on behalf of its own clients are not
+ * viewers; HQ tracks those clients itself and merges them with the remote's.
+ */
+
+import { randomBytes } from 'crypto';
+import { EventEmitter } from 'events';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('viewer-presence');
+
+// Input more recent than this counts as typing
+const TYPING_TIMEOUT_MS = 5000;
+
+// Key for input and viewers without an authenticated user
+const ANONYMOUS_USER = 'anonymous';
+
+export type ViewerTransport = 'sse' | 'websocket';
+
+export interface SessionViewer {
+  // Connection ID assigned on join
+  id: string;
+  transport: ViewerTransport;
+  userId: string | null;
+  // Viewer ID the client uses for size negotiation, if it sent one
+  viewerId?: string;
+  connectedAt: string;
+  typing: boolean;
+  // Where the viewer is connected when it is not on this server (HQ only)
+  via?: string;
+}
+
+export interface SessionPresence {
+  sessionId: string;
+  viewers: SessionViewer[];
+  // Users that sent input within the typing timeout
+  typing: string[];
+}
+
+interface ViewerEntry {
+  id: string;
+  transport: ViewerTransport;
+  userId: string | null;
+  viewerId?: string;
+  connectedAt: number;
+}
+
+interface TypingEntry {
+  lastInputAt: number;
+  timer: NodeJS.Timeout;
+}
+
+interface SessionState {
+  viewers: Map<string, ViewerEntry>;
+  typing: Map<string, TypingEntry>;
+}
+
+export class ViewerPresence extends EventEmitter {
+  private sessions = new Map<string, SessionState>();
+  private stats = { joined: 0, left: 0, inputs: 0 };
+
+  constructor() {
+    super();
+    // Every SSE viewer listens for changes
+    this.setMaxListeners(0);
+  }
+
+  /**
+   * Add a viewer to a session. Returns the connection ID to pass to leave().
+   */
+  join(sessionId: string, transport: ViewerTransport, userId?: string, viewerId?: string): string {
+    const id = randomBytes(8).toString('hex');
+    this.getState(sessionId).viewers.set(id, {
+      id,
+      transport,
+      userId: userId ?? null,
+      viewerId,
+      connectedAt: Date.now(),
+    });
+    this.stats.joined++;
+    logger.debug(`${transport} viewer ${id} joined session ${sessionId}`);
+    this.emitChange(sessionId);
+    return id;
+  }
+
+  /**
+   * Remove a viewer added with join()
+   */
+  leave(sessionId: string, id: string): void {
+    const state = this.sessions.get(sessionId);
+    if (!state?.viewers.delete(id)) return;
+    this.stats.left++;
+    logger.debug(`viewer ${id} left session ${sessionId}`);
+    this.pruneState(sessionId, state);
+    this.emitChange(sessionId);
+  }
+
+  /**
+   * Record input from a user. Emits a change only when the user starts typing;
+   * the typing state is cleared after TYPING_TIMEOUT_MS without input.
+   */
+  recordInput(sessionId: string, userId?: string): void {
+    const state = this.getState(sessionId);
+    const key = userId ?? ANONYMOUS_USER;
+    const existing = state.typing.get(key);
+    if (existing) {
+      clearTimeout(existing.timer);
+    }
+    const timer = setTimeout(() => {
+      state.typing.delete(key);
+      this.pruneState(sessionId, state);
+      this.emitChange(sessionId);
+    }, TYPING_TIMEOUT_MS);
+    timer.unref();
+    state.typing.set(key, { lastInputAt: Date.now(), timer });
+    this.stats.inputs++;
+    if (!existing) {
+      this.emitChange(sessionId);
+    }
+  }
+
+  /**
+   * Current viewers of a session and the users typing into it
+   */
+  getPresence(sessionId: string): SessionPresence {
+    const state = this.sessions.get(sessionId);
+    if (!state) {
+      return { sessionId, viewers: [], typing: [] };
+    }
+    const viewers = Array.from(state.viewers.values()).map((viewer) => ({
+      id: viewer.id,
+      transport: viewer.transport,
+      userId: viewer.userId,
+      ...(viewer.viewerId ? { viewerId: viewer.viewerId } : {}),
+      connectedAt: new Date(viewer.connectedAt).toISOString(),
+      typing: state.typing.has(viewer.userId ?? ANONYMOUS_USER),
+    }));
+    return { sessionId, viewers, typing: Array.from(state.typing.keys()) };
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let viewers = 0;
+    let typingUsers = 0;
+    for (const state of this.sessions.values()) {
+      viewers += state.viewers.size;
+      typingUsers += state.typing.size;
+    }
+    return {
+      sessions: this.sessions.size,
+      viewers,
+      typingUsers,
+      joined: this.stats.joined,
+      left: this.stats.left,
+      inputs: this.stats.inputs,
+    };
+  }
+
+  destroy(): void {
+    for (const state of this.sessions.values()) {
+      for (const entry of state.typing.values()) {
+        clearTimeout(entry.timer);
+      }
+    }
+    this.sessions.clear();
+    this.removeAllListeners();
+  }
+
+  private getState(sessionId: string): SessionState {
+    let state = this.sessions.get(sessionId);
+    if (!state) {
+      state = { viewers: new Map(), typing: new Map() };
+      this.sessions.set(sessionId, state);
+    }
+    return state;
+  }
+
+  private pruneState(sessionId: string, state: SessionState): void {
+    if (state.viewers.size === 0 && state.typing.size === 0) {
+      this.sessions.delete(sessionId);
+    }
+  }
+
+  private emitChange(sessionId: string): void {
+    this.emit('presence-changed', this.getPresence(sessionId));
+  }
+}
+
+/**
+ * Combine a remote session's presence (as reported by the remote) with the
+ * viewers HQ tracks for it. Remote viewers are tagged with the remote's name.
+ */
+export function mergeRemotePresence(
+  local: SessionPresence,
+  remote: Pick<SessionPresence, 'viewers' | 'typing'>,
+  remoteName: string
+): SessionPresence {
+  const remoteViewers = remote.viewers.map((viewer) => ({
+    ...viewer,
+    via: viewer.via ? `${remoteName}/${viewer.via}` : remoteName,
+  }));
+  return {
+    sessionId: local.sessionId,
+    viewers: [...remoteViewers, ...local.viewers],
+    typing: Array.from(new Set([...remote.typing, ...local.typing])),
+  };
+}
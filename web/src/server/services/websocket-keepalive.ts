@@ -0,0 +1,146 @@
+import type { WebSocket } from 'ws';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('websocket-keepalive');
+
+export interface KeepaliveOptions {
+  // Send a ping after this long without hearing from the peer
+  pingIntervalMs: number;
+  // Consider the connection dead if no pong arrives within this time
+  pongTimeoutMs: number;
+  // Consider the connection dead if queued writes make no progress for this long
+  writeTimeoutMs: number;
+  // How often the connection is checked
+  checkIntervalMs: number;
+}
+
+export const DEFAULT_KEEPALIVE_OPTIONS: KeepaliveOptions = {
+  pingIntervalMs: 30000,
+  pongTimeoutMs: 10000,
+  writeTimeoutMs: 30000,
+  checkIntervalMs: 5000,
+};
+
+export type StaleReason = 'pong-timeout' | 'write-timeout';
+
+// Minimal surface used here, so tests can pass a fake socket
+export type KeepaliveSocket = Pick<
+  WebSocket,
+  'ping' | 'terminate' | 'on' | 'off' | 'readyState' | 'bufferedAmount'
+>;
+
+/**
+ * Keep a WebSocket connection alive and reap it when the peer stops responding.
+ *
+ * Any incoming message or pong counts as proof of life. After pingIntervalMs of
+ * silence a ping is sent; if no pong follows within pongTimeoutMs, or if
+ * outbound data stays queued without draining for writeTimeoutMs, the socket
+ * is terminated (which emits 'close', so normal cleanup runs).
+ *
+ * Returns a function that stops the keepalive.
+ */
+export function startKeepalive(
+  ws: KeepaliveSocket,
+  options: Partial<KeepaliveOptions> = {},
+  onStale?: (reason: StaleReason) => void
+): () => void {
+  const { pingIntervalMs, pongTimeoutMs, writeTimeoutMs, checkIntervalMs } = {
+    ...DEFAULT_KEEPALIVE_OPTIONS,
+    ...options,
+  };
+
+  let lastSeen = Date.now();
+  let pingSentAt: number | null = null;
+  let lastBufferedAmount = 0;
+  let stalledSince: number | null = null;
+
+  const markAlive = () => {
+    lastSeen = Date.now();
+    pingSentAt = null;
+  };
+
+  const reap = (reason: StaleReason) => {
+    stop();
+    logger.warn(`terminating stale websocket (${reason})`);
+    onStale?.(reason);
+    ws.terminate();
+  };
+
+  const check = () => {
+    // 1 = OPEN; other states are handled by the close handshake
+    if (ws.readyState !== 1) return;
+    const now = Date.now();
+
+    // Write deadline: queued data must keep draining
+    const buffered = ws.bufferedAmount;
+    if (buffered > 0 && buffered >= lastBufferedAmount) {
+      if (stalledSince === null) {
+        stalledSince = now;
+      } else if (now - stalledSince >= writeTimeoutMs) {
+        return reap('write-timeout');
+      }
+    } else {
+      stalledSince = null;
+    }
+    lastBufferedAmount = buffered;
+
+    // Read deadline: the peer must answer pings
+    if (pingSentAt !== null) {
+      if (now - pingSentAt >= pongTimeoutMs) {
+        return reap('pong-timeout');
+      }
+    } else if (now - lastSeen >= pingIntervalMs) {
+      pingSentAt = now;
+      try {
+        ws.ping();
+      } catch (error) {
+        logger.debug('failed to send ping:', error);
+      }
+    }
+  };
+
+  const timer = setInterval(check, checkIntervalMs);
+  ws.on('pong', markAlive);
+  ws.on('message', markAlive);
+
+  let stopped = false;
+  function stop() {
+    if (stopped) return;
+    stopped = true;
+    clearInterval(timer);
+    ws.off('pong', markAlive);
+    ws.off('message', markAlive);
+  }
+
+  ws.on('close', stop);
+  return stop;
+}
+
+/**
+ * Start a close handshake and terminate the socket if the peer does not
+ * complete it in time.
+ */
+export function closeWithTimeout(
+  ws: WebSocket,
+  code = 1001,
+  reason = 'Server shutting down',
+  timeoutMs = 5000
+): void {
+  if (ws.readyState === ws.CLOSED) return;
+
+  const timer = setTimeout(() => {
+    if (ws.readyState !== ws.CLOSED) {
+      logger.debug('close handshake timed out, terminating websocket');
+      ws.terminate();
+    }
+  }, timeoutMs);
+  ws.once('close', () => clearTimeout(timer));
+
+  try {
+    ws.close(code, reason);
+  } catch (error) {
+    logger.debug('failed to close websocket cleanly:', error);
+    clearTimeout(timer);
+    ws.terminate();
+  }
+}
@@ -0,0 +1,158 @@
+import type { WebSocket } from 'ws';
+import { createLogger } from '../utils/logger.js';
+
+const logger = createLogger('websocket-writer');
+
+export interface WebSocketWriterOptions {
+  // Stop handing data to the socket while this many bytes are still unsent
+  maxBufferedBytes: number;
+  // Close the connection if this many messages are waiting in the queue
+  maxQueuedMessages: number;
+}
+
+const DEFAULT_OPTIONS: WebSocketWriterOptions = {
+  maxBufferedBytes: 1024 * 1024,
+  maxQueuedMessages: 256,
+};
+
+interface QueuedMessage {
+  data: Buffer | string;
+  // Frames with a key replace any queued frame with the same key
+  key?: string;
+  // Called once the data has been written (true) or discarded (false)
+  done?: (written: boolean) => void;
+  // Called when the data is handed to the socket, after which it can no longer be replaced
+  sent?: () => void;
+}
+
+// Minimal surface used here, so tests can pass a fake socket
+export type WriterSocket = Pick<WebSocket, 'send' | 'close' | 'readyState' | 'bufferedAmount'>;
+
+/**
+ * Bounded outbound queue for a single WebSocket connection.
+ *
+ * All writes to a connection go through its writer, in order. Data is only
+ * handed to the socket while its send buffer is below maxBufferedBytes, so a
+ * slow client cannot make the server buffer unbounded amounts of data:
+ *
+ * - Keyed frames (full buffer snapshots, keyed by session ID) are merged: a
+ *   newer frame replaces a queued one for the same key, since only the latest
+ *   state matters.
+ * - Other messages are never dropped; if the queue still grows past
+ *   maxQueuedMessages the client is too slow and the connection is closed.
+ */
+export class WebSocketWriter {
+  private queue: QueuedMessage[] = [];
+  private keyed: Map<string, QueuedMessage> = new Map();
+  private options: WebSocketWriterOptions;
+  private closed = false;
+  private stats = { sent: 0, merged: 0 };
+
+  constructor(
+    private ws: WriterSocket,
+    options: Partial<WebSocketWriterOptions> = {}
+  ) {
+    this.options = { ...DEFAULT_OPTIONS, ...options };
+  }
+
+  /**
+   * Queue a message that must be delivered
+   */
+  send(data: Buffer | string, done?: (written: boolean) => void): void {
+    this.enqueue({ data, done });
+  }
+
+  /**
+   * Queue a frame that may be replaced by a newer frame with the same key
+   */
+  sendFrame(
+    key: string,
+    data: Buffer,
+    done?: (written: boolean) => void,
+    sent?: () => void
+  ): void {
+    const queued = this.keyed.get(key);
+    if (queued) {
+      // Keep the queue position, replace the content
+      queued.done?.(false);
+      queued.data = data;
+      queued.done = done;
+      queued.sent = sent;
+      this.stats.merged++;
+      return;
+    }
+    this.enqueue({ data, key, done, sent });
+  }
+
+  /**
+   * Number of messages waiting to be handed to the socket
+   */
+  getQueueLength(): number {
+    return this.queue.length;
+  }
+
+  getStats() {
+    return { ...this.stats, queued: this.queue.length };
+  }
+
+  /**
+   * Discard queued messages (e.g. after the connection closed)
+   */
+  close(): void {
+    this.closed = true;
+    for (const message of this.queue) {
+      message.done?.(false);
+    }
+    this.queue = [];
+    this.keyed.clear();
+  }
+
+  private enqueue(message: QueuedMessage): void {
+    if (this.closed || this.ws.readyState !== 1) {
+      message.done?.(false);
+      return;
+    }
+
+    this.queue.push(message);
+    if (message.key) {
+      this.keyed.set(message.key, message);
+    }
+
+    if (this.queue.length > this.options.maxQueuedMessages) {
+      logger.warn(`outbound queue exceeded ${this.options.maxQueuedMessages} messages, closing`);
+      this.close();
+      this.ws.close(1008, 'Client too slow');
+      return;
+    }
+
+    this.flush();
+  }
+
+  private flush(): void {
+    while (
+      !this.closed &&
+      this.queue.length > 0 &&
+      this.ws.bufferedAmount < this.options.maxBufferedBytes
+    ) {
+      const message = this.queue.shift();
+      if (!message) break;
+      if (message.key) {
+        this.keyed.delete(message.key);
+      }
+
+      this.stats.sent++;
+      message.sent?.();
+      this.ws.send(message.data, (error) => {
+        message.done?.(!error);
+        if (error) {
+          logger.debug('websocket send failed:', error);
+          return;
+        }
+        // Written to the socket; there may be room for more now
+        if (this.queue.length > 0) {
+          this.flush();
+        }
+      });
+    }
+  }
+}
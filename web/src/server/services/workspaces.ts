@@ -0,0 +1,245 @@
+import * as fs from 'fs';
+import * as path from 'path';
+import { v4 as uuidv4 } from 'uuid';
+import type { Session } from '../../shared/types.js';
+import { createLogger } from '../utils/logger.js';
+import { type RemoteAffinity, validateAffinity } from './remote-affinity.js';
+
+const logger = createLogger('workspaces');
+
+const MAX_NAME_LENGTH = 100;
+// Running sessions with output this recent count as active
+export const WORKSPACE_ACTIVE_WINDOW_MS = 60 * 1000;
+
+export interface Workspace {
+  id: string;
+  name: string;
+  // Absolute directory sessions start in and file browsing is limited to
+  rootDir: string;
+  // Name of the remote new sessions are created on (HQ mode) without an affinity
+  defaultRemote?: string;
+  // Remotes sessions are pinned to (HQ mode)
+  affinity?: RemoteAffinity;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export type WorkspaceInput = Omit<Workspace, 'id' | 'createdAt' | 'updatedAt'>;
+
+// Fields taken from request bodies; anything else is ignored
+const WORKSPACE_FIELDS: ReadonlyArray<keyof WorkspaceInput> = [
+  'name',
+  'rootDir',
+  'defaultRemote',
+  'affinity',
+];
+
+function pickFields<T extends object>(body: T): Partial<T> {
+  return Object.fromEntries(
+    Object.entries(body).filter(
+      ([key, value]) =>
+        WORKSPACE_FIELDS.includes(key as keyof WorkspaceInput) && value !== undefined
+    )
+  ) as Partial<T>;
+}
+
+export class WorkspaceError extends Error {
+  constructor(
+    message: string,
+    public readonly code: 'INVALID_REQUEST' | 'CONFLICT' = 'INVALID_REQUEST'
+  ) {
+    super(message);
+    this.name = 'WorkspaceError';
+  }
+}
+
+/**
+ * Validate a workspace body. With `partial` only the given fields are checked.
+ * Returns an error message or null.
+ */
+export function validateWorkspace(body: Record<string, unknown>, partial: boolean): string | null {
+  const { name, rootDir, defaultRemote, affinity } = body;
+  if (
+    (!partial || name !== undefined) &&
+    (typeof name !== 'string' || name.trim() === '' || name.length > MAX_NAME_LENGTH)
+  ) {
+    return `name must be a non-empty string of at most ${MAX_NAME_LENGTH} characters`;
+  }
+  if (
+    (!partial || rootDir !== undefined) &&
+    (typeof rootDir !== 'string' || !path.isAbsolute(rootDir))
+  ) {
+    return 'rootDir must be an absolute path';
+  }
+  if (defaultRemote !== undefined && (typeof defaultRemote !== 'string' || defaultRemote === '')) {
+    return 'defaultRemote must be a remote name';
+  }
+  if (affinity !== undefined) {
+    return validateAffinity(affinity);
+  }
+  return null;
+}
+
+/**
+ * Whether a path is the root directory of a workspace or below it
+ */
+export function isInWorkspace(workspace: Workspace, target: string): boolean {
+  const relative = path.relative(workspace.rootDir, path.resolve(target));
+  return relative === '' || (!relative.startsWith('..') && !path.isAbsolute(relative));
+}
+
+/**
+ * Projects sessions belong to: a root directory new sessions start in and
+ * file browsing is scoped to, and optionally the remote sessions run on.
+ * Workspaces are stored in `workspaces.json` in the control directory;
+ * sessions record the ID of their workspace.
+ */
+export class WorkspaceStore {
+  private workspaces = new Map<string, Workspace>();
+  private filePath: string;
+
+  constructor(controlDir: string) {
+    this.filePath = path.join(controlDir, 'workspaces.json');
+    this.load();
+  }
+
+  list(): Workspace[] {
+    return Array.from(this.workspaces.values()).sort((a, b) => a.name.localeCompare(b.name));
+  }
+
+  get(workspaceId: string): Workspace | undefined {
+    return this.workspaces.get(workspaceId);
+  }
+
+  /**
+   * Add a workspace. Throws WorkspaceError for invalid input or a taken name.
+   */
+  create(input: WorkspaceInput): Workspace {
+    const fields = pickFields(input) as WorkspaceInput;
+    const error = validateWorkspace({ ...fields }, false);
+    if (error) throw new WorkspaceError(error);
+    const name = fields.name.trim();
+    this.checkNameAvailable(name);
+
+    const now = new Date().toISOString();
+    const workspace: Workspace = {
+      id: uuidv4(),
+      ...fields,
+      name,
+      rootDir: path.resolve(fields.rootDir),
+      createdAt: now,
+      updatedAt: now,
+    };
+    this.workspaces.set(workspace.id, workspace);
+    this.save();
+    logger.log(`workspace ${workspace.name} (${workspace.id}) created in ${workspace.rootDir}`);
+    return workspace;
+  }
+
+  /**
+   * Change a workspace; null removes the default remote or affinity
+   */
+  update(
+    workspaceId: string,
+    changes: { [K in keyof WorkspaceInput]?: WorkspaceInput[K] | null }
+  ): Workspace | undefined {
+    const workspace = this.workspaces.get(workspaceId);
+    if (!workspace) return undefined;
+
+    const fields = pickFields(changes);
+    if (fields.name === null || fields.rootDir === null) {
+      throw new WorkspaceError('name and rootDir cannot be removed');
+    }
+    const set = Object.fromEntries(
+      Object.entries(fields).filter(([, value]) => value !== null)
+    ) as Partial<WorkspaceInput>;
+    const error = validateWorkspace(set, true);
+    if (error) throw new WorkspaceError(error);
+    if (set.name !== undefined) {
+      set.name = set.name.trim();
+      if (set.name !== workspace.name) this.checkNameAvailable(set.name);
+    }
+    if (set.rootDir !== undefined) {
+      set.rootDir = path.resolve(set.rootDir);
+    }
+
+    const updated: Workspace = { ...workspace, ...set, updatedAt: new Date().toISOString() };
+    if (fields.defaultRemote === null) delete updated.defaultRemote;
+    if (fields.affinity === null) delete updated.affinity;
+    this.workspaces.set(workspaceId, updated);
+    this.save();
+    return updated;
+  }
+
+  delete(workspaceId: string): boolean {
+    const deleted = this.workspaces.delete(workspaceId);
+    if (deleted) {
+      this.save();
+      logger.log(`workspace ${workspaceId} deleted`);
+    }
+    return deleted;
+  }
+
+  private checkNameAvailable(name: string): void {
+    if (this.list().some((workspace) => workspace.name === name)) {
+      throw new WorkspaceError(`Workspace ${name} already exists`, 'CONFLICT');
+    }
+  }
+
+  private load(): void {
+    try {
+      if (!fs.existsSync(this.filePath)) return;
+      const workspaces = JSON.parse(fs.readFileSync(this.filePath, 'utf8')) as Workspace[];
+      for (const workspace of workspaces) {
+        this.workspaces.set(workspace.id, workspace);
+      }
+      logger.debug(`loaded ${this.workspaces.size} workspaces`);
+    } catch (error) {
+      logger.error('failed to load workspaces:', error);
+    }
+  }
+
+  private save(): void {
+    try {
+      fs.writeFileSync(this.filePath, JSON.stringify(this.list(), null, 2));
+    } catch (error) {
+      logger.error('failed to save workspaces:', error);
+    }
+  }
+}
+
+export interface WorkspaceActivity {
+  sessions: number;
+  running: number;
+  exited: number;
+  // Running sessions that printed output within the active window
+  active: number;
+  // Latest output of any session of the workspace
+  lastActivity: string | null;
+}
+
+/**
+ * Aggregate activity of the sessions of a workspace
+ */
+export function summarizeWorkspaceActivity(
+  sessions: Array<Pick<Session, 'status' | 'lastModified'>>,
+  now = Date.now()
+): WorkspaceActivity {
+  let lastActivity = 0;
+  let active = 0;
+  for (const session of sessions) {
+    const modified = Date.parse(session.lastModified);
+    if (Number.isNaN(modified)) continue;
+    lastActivity = Math.max(lastActivity, modified);
+    if (session.status === 'running' && now - modified <= WORKSPACE_ACTIVE_WINDOW_MS) {
+      active++;
+    }
+  }
+  return {
+    sessions: sessions.length,
+    running: sessions.filter((session) => session.status === 'running').length,
+    exited: sessions.filter((session) => session.status === 'exited').length,
+    active,
+    lastActivity: lastActivity ? new Date(lastActivity).toISOString() : null,
+  };
+}
@@ -0,0 +1,217 @@
+/**
+ * VibeTunnel Shims (shim.ts)
+ *
+ * `vibetunnel shim install <command>...` writes wrapper executables into
+ * ~/.vibetunnel/shims, so configured commands run in a VibeTunnel session (via
+ * `vibetunnel fwd`) from any terminal once that directory is first on the PATH.
+ * A configured command may include leading arguments: with `npm run dev` the
+ * `npm` shim wraps `npm run dev ...` and runs other npm commands directly.
+ *
+ * Shims run the real command directly when VIBETUNNEL_NO_SHIM is set, inside a
+ * VibeTunnel session, or when stdin or stdout is not a terminal (scripts, pipes).
+ */
+
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { createLogger } from './utils/logger.js';
+
+const logger = createLogger('shim');
+
+export const DEFAULT_SHIM_DIR = path.join(os.homedir(), '.vibetunnel', 'shims');
+// The configured commands; shims are generated from it
+const CONFIG_FILE = 'shims.json';
+// First line of generated shims, so only those are ever overwritten or removed
+const SHIM_MARKER = '# VibeTunnel shim';
+
+const PROGRAM_PATTERN = /^[A-Za-z0-9._+-]+$/;
+
+interface ShimConfig {
+  commands: string[];
+}
+
+/**
+ * Split a configured command into the program and the leading arguments it wraps
+ */
+export function parseShimCommand(command: string): { program: string; args: string[] } {
+  const [program, ...args] = command.trim().split(/\s+/);
+  if (!program || !PROGRAM_PATTERN.test(program)) {
+    throw new Error(`Invalid command "${command}": the program must be a plain name`);
+  }
+  return { program, args };
+}
+
+function quote(value: string): string {
+  return `'${value.replace(/'/g, `'\\''`)}'`;
+}
+
+/**
+ * The shell script of the shim of one program, wrapping the given argument
+ * prefixes (an empty prefix wraps every invocation)
+ */
+export function shimScript(
+  program: string,
+  prefixes: string[][],
+  shimDir: string,
+  vibetunnel: string[]
+): string {
+  const conditions = prefixes.map((prefix) =>
+    prefix.length === 0
+      ? 'true'
+      : prefix.map((arg, i) => `[ "$${i + 1}" = ${quote(arg)} ]`).join(' && ')
+  );
+  const fwd = [...vibetunnel.map(quote), 'fwd', '"$real"', '"$@"'].join(' ');
+
+  return `#!/bin/sh
+${SHIM_MARKER} for ${program}; written by \`vibetunnel shim install\`, do not edit
+shim_dir=${quote(shimDir)}
+
+# The real ${program}: the first one on the PATH outside the shim directory
+real=$(
+  IFS=:
+  for dir in $PATH; do
+    [ "$dir" = "$shim_dir" ] && continue
+    if [ -x "$dir/${program}" ] && [ ! -d "$dir/${program}" ]; then
+      printf '%s\\n' "$dir/${program}"
+      break
+    fi
+  done
+)
+if [ -z "$real" ]; then
+  echo "vibetunnel shim: ${program} not found on the PATH" >&2
+  exit 127
+fi
+
+if [ -n "$VIBETUNNEL_NO_SHIM" ] || [ -n "$VIBETUNNEL_SESSION_ID" ] || [ ! -t 0 ] || [ ! -t 1 ]; then
+  exec "$real" "$@"
+fi
+
+if ${conditions.map((condition) => `{ ${condition}; }`).join(' || ')}; then
+  exec ${fwd}
+fi
+exec "$real" "$@"
+`;
+}
+
+/**
+ * How to start this vibetunnel: the bundled executable, or node with the script
+ */
+function vibetunnelCommand(): string[] {
+  const script = process.argv[1];
+  return script && /\.[cm]?[jt]s$/.test(script)
+    ? [process.execPath, ...process.execArgv, path.resolve(script)]
+    : [process.execPath];
+}
+
+function readConfig(shimDir: string): ShimConfig {
+  try {
+    const config = JSON.parse(fs.readFileSync(path.join(shimDir, CONFIG_FILE), 'utf8'));
+    return { commands: Array.isArray(config.commands) ? config.commands : [] };
+  } catch (_error) {
+    return { commands: [] };
+  }
+}
+
+function isShim(file: string): boolean {
+  try {
+    return fs.readFileSync(file, 'utf8').split('\n', 2)[1]?.startsWith(SHIM_MARKER) ?? false;
+  } catch (_error) {
+    return false;
+  }
+}
+
+/**
+ * Write the shims of the configured commands and remove shims no longer
+ * configured. Returns the programs that have a shim.
+ */
+export function writeShims(
+  shimDir: string,
+  commands: string[],
+  vibetunnel = vibetunnelCommand()
+): string[] {
+  fs.mkdirSync(shimDir, { recursive: true });
+  fs.writeFileSync(path.join(shimDir, CONFIG_FILE), `${JSON.stringify({ commands }, null, 2)}\n`);
+
+  const prefixes = new Map<string, string[][]>();
+  for (const command of commands) {
+    const { program, args } = parseShimCommand(command);
+    prefixes.set(program, [...(prefixes.get(program) ?? []), args]);
+  }
+
+  for (const file of fs.readdirSync(shimDir)) {
+    const shimPath = path.join(shimDir, file);
+    if (!prefixes.has(file) && isShim(shimPath)) {
+      fs.unlinkSync(shimPath);
+    }
+  }
+  for (const [program, programPrefixes] of prefixes) {
+    const shimPath = path.join(shimDir, program);
+    if (fs.existsSync(shimPath) && !isShim(shimPath)) {
+      throw new Error(`${shimPath} exists and is not a VibeTunnel shim`);
+    }
+    fs.writeFileSync(shimPath, shimScript(program, programPrefixes, shimDir, vibetunnel), {
+      mode: 0o755,
+    });
+  }
+  return [...prefixes.keys()];
+}
+
+function showUsage() {
+  console.log('Usage:');
+  console.log('  vibetunnel shim install [command...]    Add commands and (re)write all shims');
+  console.log('  vibetunnel shim uninstall [command...]  Remove commands (all without arguments)');
+  console.log('  vibetunnel shim list                    Show the configured commands');
+  console.log('');
+  console.log('Options:');
+  console.log(`  --dir <dir>  Shim directory (default: ${DEFAULT_SHIM_DIR})`);
+  console.log('');
+  console.log('Examples:');
+  console.log('  vibetunnel shim install claude "npm run dev"');
+  console.log('  VIBETUNNEL_NO_SHIM=1 claude   # run once without a session');
+}
+
+/**
+ * Run `vibetunnel shim`; returns the exit code
+ */
+export function startVibeTunnelShim(args: string[]): number {
+  let shimDir = DEFAULT_SHIM_DIR;
+  const dirIndex = args.indexOf('--dir');
+  if (dirIndex !== -1 && dirIndex + 1 < args.length) {
+    shimDir = path.resolve(args[dirIndex + 1]);
+    args = [...args.slice(0, dirIndex), ...args.slice(dirIndex + 2)];
+  }
+  const [action, ...commands] = args;
+  const configured = readConfig(shimDir).commands;
+
+  try {
+    switch (action) {
+      case 'install': {
+        for (const command of commands) parseShimCommand(command);
+        const all = [...new Set([...configured, ...commands.map((c) => c.trim())])];
+        const programs = writeShims(shimDir, all);
+        console.log(`Shims for ${programs.join(', ') || 'no commands'} in ${shimDir}`);
+        if (!(process.env.PATH ?? '').split(':').includes(shimDir)) {
+          console.log('Put the shim directory first on the PATH, e.g. in your shell profile:');
+          console.log(`  export PATH="${shimDir}:$PATH"`);
+        }
+        return 0;
+      }
+      case 'uninstall': {
+        const removed = new Set(commands.map((c) => c.trim()));
+        const remaining = commands.length ? configured.filter((c) => !removed.has(c)) : [];
+        writeShims(shimDir, remaining);
+        console.log(`${configured.length - remaining.length} command(s) removed`);
+        return 0;
+      }
+      case 'list':
+        for (const command of configured) console.log(command);
+        return 0;
+      default:
+        showUsage();
+        return action === undefined || action === '--help' || action === '-h' ? 0 : 1;
+    }
+  } catch (error) {
+    logger.error(error instanceof Error ? error.message : String(error));
+    return 1;
+  }
+}
@@ -0,0 +1,42 @@
+/**
+ * VibeTunnel TUI (tui.ts)
+ *
+ * `vibetunnel tui` runs the Go terminal viewer (pkg/cmd/vibetunnel-tui), which
+ * shows a session live in the local terminal. The binary is looked up next to
+ * the running executable, then on the PATH.
+ */
+
+import { spawn } from 'child_process';
+import * as fs from 'fs';
+import * as path from 'path';
+import { createLogger } from './utils/logger.js';
+
+const logger = createLogger('tui');
+
+const TUI_BINARY = process.platform === 'win32' ? 'vibetunnel-tui.exe' : 'vibetunnel-tui';
+
+function findTuiBinary(): string {
+  const bundled = path.join(path.dirname(process.execPath), TUI_BINARY);
+  return fs.existsSync(bundled) ? bundled : TUI_BINARY;
+}
+
+/**
+ * Run the viewer with the terminal handed over; resolves to its exit code
+ */
+export function startVibeTunnelTui(args: string[]): Promise<number> {
+  return new Promise((resolve) => {
+    const child = spawn(findTuiBinary(), args, { stdio: 'inherit' });
+    child.on('error', (error: NodeJS.ErrnoException) => {
+      if (error.code === 'ENOENT') {
+        console.error(
+          `${TUI_BINARY} not found; build it with \`pnpm run build:tui\` in web/ ` +
+            `or \`go build ./cmd/vibetunnel-tui\` in pkg/ and put it on the PATH`
+        );
+      } else {
+        logger.error('failed to start the terminal viewer:', error);
+      }
+      resolve(1);
+    });
+    child.on('exit', (code) => resolve(code ?? 1));
+  });
+}
@@ -0,0 +1,54 @@
+import type { Response } from 'express';
+import { type ApiErrorBody, ERROR_CODES, type ErrorCode } from '../../shared/error-codes.js';
+
+/**
+ * Error response body for a code, with its default message unless given
+ */
+export function errorBody(code: ErrorCode, message?: string, details?: unknown): ApiErrorBody {
+  const text = message ?? ERROR_CODES[code].message;
+  return details === undefined
+    ? { code, message: text, error: text }
+    : { code, message: text, details, error: text };
+}
+
+/**
+ * Send an error response with the status registered for its code
+ */
+export function sendError(
+  res: Response,
+  code: ErrorCode,
+  message?: string,
+  details?: unknown
+): Response {
+  return res.status(ERROR_CODES[code].status).json(errorBody(code, message, details));
+}
+
+/**
+ * Generic code for error responses sent without one
+ */
+export function errorCodeForStatus(status: number): ErrorCode {
+  switch (status) {
+    case 400:
+      return 'INVALID_REQUEST';
+    case 401:
+      return 'AUTH_REQUIRED';
+    case 403:
+      return 'FORBIDDEN';
+    case 404:
+      return 'NOT_FOUND';
+    case 409:
+      return 'CONFLICT';
+    case 413:
+      return 'PAYLOAD_TOO_LARGE';
+    case 423:
+      return 'INPUT_LOCKED';
+    case 429:
+      return 'RATE_LIMITED';
+    case 502:
+      return 'REMOTE_ERROR';
+    case 503:
+      return 'UNAVAILABLE';
+    default:
+      return status < 500 ? 'INVALID_REQUEST' : 'INTERNAL_ERROR';
+  }
+}
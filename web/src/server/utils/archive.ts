@@ -0,0 +1,462 @@
+/**
+ * Archives - Listing and reading zip and tar(.gz) files without extracting
+ *
+ * Zip members are found through the central directory at the end of the file
+ * and read with a ranged read, inflated on the fly. Tar has no index, so
+ * listing and reading a member stream the archive (through gunzip for .tar.gz)
+ * and parse the 512-byte headers as they pass; a member is streamed as soon
+ * as it is reached. Nothing is written to disk.
+ */
+
+import { createReadStream } from 'fs';
+import * as fs from 'fs/promises';
+import { PassThrough, Readable } from 'stream';
+import * as zlib from 'zlib';
+
+export type ArchiveType = 'zip' | 'tar' | 'tar.gz';
+
+export interface ArchiveEntry {
+  // Path inside the archive, `/`-separated, without a trailing slash
+  name: string;
+  type: 'file' | 'directory' | 'symlink' | 'other';
+  size: number;
+  // Size in the archive (zip only)
+  compressedSize?: number;
+  modified: string;
+}
+
+export interface ArchiveListing {
+  type: ArchiveType;
+  entries: ArchiveEntry[];
+  // Whether more entries exist than were listed
+  truncated: boolean;
+}
+
+export class ArchiveError extends Error {
+  constructor(
+    message: string,
+    public readonly code: 'UNSUPPORTED' | 'CORRUPT' | 'MEMBER_NOT_FOUND'
+  ) {
+    super(message);
+    this.name = 'ArchiveError';
+  }
+}
+
+export const MAX_ARCHIVE_ENTRIES = 10000;
+
+const ZIP_EOCD_SIGNATURE = 0x06054b50;
+const ZIP_CENTRAL_SIGNATURE = 0x02014b50;
+const ZIP_LOCAL_SIGNATURE = 0x04034b50;
+// End of central directory record plus the longest comment
+const ZIP_EOCD_SEARCH = 22 + 0xffff;
+const TAR_BLOCK = 512;
+
+export function archiveTypeOf(filePath: string): ArchiveType | null {
+  const name = filePath.toLowerCase();
+  if (/\.(zip|jar|war|whl|apk|ipa|nupkg|vsix)$/.test(name)) return 'zip';
+  if (/\.(tar\.gz|tgz)$/.test(name)) return 'tar.gz';
+  if (name.endsWith('.tar')) return 'tar';
+  return null;
+}
+
+/**
+ * Member names never lead out of the archive's root: no absolute paths, no `..`
+ */
+function normalizeMemberName(name: string): string | null {
+  const parts = name.replace(/\\/g, '/').split('/');
+  const clean: string[] = [];
+  for (const part of parts) {
+    if (part === '' || part === '.') continue;
+    if (part === '..') return null;
+    clean.push(part);
+  }
+  return clean.length > 0 ? clean.join('/') : null;
+}
+
+export async function listArchive(
+  filePath: string,
+  maxEntries = MAX_ARCHIVE_ENTRIES
+): Promise<ArchiveListing> {
+  const type = archiveTypeOf(filePath);
+  if (!type) {
+    throw new ArchiveError(`Not a supported archive: ${filePath}`, 'UNSUPPORTED');
+  }
+  if (type === 'zip') {
+    const entries = await readZipDirectory(filePath);
+    return {
+      type,
+      entries: entries.slice(0, maxEntries).map(({ entry }) => entry),
+      truncated: entries.length > maxEntries,
+    };
+  }
+
+  const entries: ArchiveEntry[] = [];
+  let truncated = false;
+  await scanTar(filePath, type, (entry) => {
+    if (entries.length >= maxEntries) {
+      truncated = true;
+      return 'stop';
+    }
+    entries.push(entry);
+    return 'skip';
+  });
+  return { type, entries, truncated };
+}
+
+/**
+ * Stream one file of an archive
+ */
+export async function openArchiveMember(
+  filePath: string,
+  member: string
+): Promise<{ stream: Readable; size: number }> {
+  const type = archiveTypeOf(filePath);
+  const wanted = normalizeMemberName(member);
+  if (!type) {
+    throw new ArchiveError(`Not a supported archive: ${filePath}`, 'UNSUPPORTED');
+  }
+  if (!wanted) {
+    throw new ArchiveError(`Invalid member name: ${member}`, 'MEMBER_NOT_FOUND');
+  }
+
+  if (type === 'zip') {
+    const found = (await readZipDirectory(filePath)).find(({ entry }) => entry.name === wanted);
+    if (!found || found.entry.type !== 'file') {
+      throw new ArchiveError(`No such file in archive: ${member}`, 'MEMBER_NOT_FOUND');
+    }
+    return { stream: await openZipMember(filePath, found), size: found.entry.size };
+  }
+
+  const output = new PassThrough();
+  let size = -1;
+  let foundMember: () => void = () => {};
+  const found = new Promise<void>((resolve) => {
+    foundMember = resolve;
+  });
+  // The scan resolves once the member was read, or the archive ended without it
+  const done = scanTar(filePath, type, (entry, body) => {
+    if (entry.name !== wanted || entry.type !== 'file') return 'skip';
+    size = entry.size;
+    body(output);
+    foundMember();
+    return 'stop';
+  }).then(() => {
+    if (size === -1) {
+      throw new ArchiveError(`No such file in archive: ${member}`, 'MEMBER_NOT_FOUND');
+    }
+  });
+  // Errors after the member was found reach the client through the stream
+  done.catch(() => {});
+  // A missing member is an error rather than an empty stream
+  await Promise.race([found, done]);
+  return { stream: output, size };
+}
+
+// Zip
+
+interface ZipEntry {
+  entry: ArchiveEntry;
+  method: number;
+  localHeaderOffset: number;
+}
+
+function dosDateTime(date: number, time: number): string {
+  return new Date(
+    Date.UTC(
+      (date >> 9) + 1980,
+      ((date >> 5) & 0xf) - 1,
+      date & 0x1f,
+      time >> 11,
+      (time >> 5) & 0x3f,
+      (time & 0x1f) * 2
+    )
+  ).toISOString();
+}
+
+async function readZipDirectory(filePath: string): Promise<ZipEntry[]> {
+  const handle = await fs.open(filePath, 'r');
+  try {
+    const { size } = await handle.stat();
+    const tailLength = Math.min(size, ZIP_EOCD_SEARCH);
+    const tail = Buffer.alloc(tailLength);
+    await handle.read(tail, 0, tailLength, size - tailLength);
+
+    let eocd = -1;
+    for (let i = tailLength - 22; i >= 0; i--) {
+      if (tail.readUInt32LE(i) === ZIP_EOCD_SIGNATURE) {
+        eocd = i;
+        break;
+      }
+    }
+    if (eocd === -1) {
+      throw new ArchiveError('Not a zip file (no end of central directory)', 'CORRUPT');
+    }
+    const count = tail.readUInt16LE(eocd + 10);
+    const directorySize = tail.readUInt32LE(eocd + 12);
+    const directoryOffset = tail.readUInt32LE(eocd + 16);
+    if (count === 0xffff || directoryOffset === 0xffffffff) {
+      throw new ArchiveError('Zip64 archives are not supported', 'UNSUPPORTED');
+    }
+    if (directoryOffset + directorySize > size) {
+      throw new ArchiveError('Zip central directory is out of bounds', 'CORRUPT');
+    }
+
+    const directory = Buffer.alloc(directorySize);
+    await handle.read(directory, 0, directorySize, directoryOffset);
+
+    const entries: ZipEntry[] = [];
+    let pos = 0;
+    for (let i = 0; i < count; i++) {
+      if (pos + 46 > directory.length || directory.readUInt32LE(pos) !== ZIP_CENTRAL_SIGNATURE) {
+        throw new ArchiveError('Zip central directory is corrupt', 'CORRUPT');
+      }
+      const nameLength = directory.readUInt16LE(pos + 28);
+      const extraLength = directory.readUInt16LE(pos + 30);
+      const commentLength = directory.readUInt16LE(pos + 32);
+      const rawName = directory.toString('utf-8', pos + 46, pos + 46 + nameLength);
+      const externalAttributes = directory.readUInt32LE(pos + 38);
+      const name = normalizeMemberName(rawName);
+      if (name) {
+        const isDirectory = rawName.endsWith('/');
+        // Unix mode in the high bits of the external attributes
+        const isSymlink = ((externalAttributes >>> 16) & 0o170000) === 0o120000;
+        entries.push({
+          entry: {
+            name,
+            type: isDirectory ? 'directory' : isSymlink ? 'symlink' : 'file',
+            size: directory.readUInt32LE(pos + 24),
+            compressedSize: directory.readUInt32LE(pos + 20),
+            modified: dosDateTime(
+              directory.readUInt16LE(pos + 14),
+              directory.readUInt16LE(pos + 12)
+            ),
+          },
+          // Flag bit 0: encrypted
+          method: directory.readUInt16LE(pos + 8) & 1 ? -1 : directory.readUInt16LE(pos + 10),
+          localHeaderOffset: directory.readUInt32LE(pos + 42),
+        });
+      }
+      pos += 46 + nameLength + extraLength + commentLength;
+    }
+    return entries;
+  } finally {
+    await handle.close();
+  }
+}
+
+async function openZipMember(
+  filePath: string,
+  { entry, method, localHeaderOffset }: ZipEntry
+): Promise<Readable> {
+  if (method !== 0 && method !== 8) {
+    const reason = method === -1 ? 'encrypted' : `compressed with method ${method}`;
+    throw new ArchiveError(`Zip members ${reason} are not supported`, 'UNSUPPORTED');
+  }
+
+  const header = Buffer.alloc(30);
+  const handle = await fs.open(filePath, 'r');
+  try {
+    await handle.read(header, 0, 30, localHeaderOffset);
+  } finally {
+    await handle.close();
+  }
+  if (header.readUInt32LE(0) !== ZIP_LOCAL_SIGNATURE) {
+    throw new ArchiveError('Zip local header is corrupt', 'CORRUPT');
+  }
+  // The local header's name and extra field can differ in length from the central one's
+  const start = localHeaderOffset + 30 + header.readUInt16LE(26) + header.readUInt16LE(28);
+  const compressedSize = entry.compressedSize ?? 0;
+  if (compressedSize === 0) {
+    return Readable.from([]);
+  }
+
+  const raw = createReadStream(filePath, { start, end: start + compressedSize - 1 });
+  if (method === 0) return raw;
+  const inflate = zlib.createInflateRaw();
+  raw.on('error', (error) => inflate.destroy(error));
+  inflate.on('close', () => raw.destroy());
+  return raw.pipe(inflate);
+}
+
+// Tar
+
+type TarVisit = (entry: ArchiveEntry, body: (target: PassThrough) => void) => 'skip' | 'stop';
+
+function tarString(block: Buffer, start: number, length: number): string {
+  const end = block.indexOf(0, start);
+  return block.toString('utf-8', start, end === -1 || end > start + length ? start + length : end);
+}
+
+function tarNumber(block: Buffer, start: number, length: number): number {
+  // GNU base-256 for values too large for octal
+  if (block[start] & 0x80) {
+    let value = block[start] & 0x7f;
+    for (let i = 1; i < length; i++) value = value * 256 + block[start + i];
+    return value;
+  }
+  const text = tarString(block, start, length).trim();
+  return text ? Number.parseInt(text, 8) : 0;
+}
+
+function paxPath(data: Buffer): string | undefined {
+  // Records: "<length> <key>=<value>\n"
+  let pos = 0;
+  let found: string | undefined;
+  while (pos < data.length) {
+    const space = data.indexOf(0x20, pos);
+    const length = Number.parseInt(data.toString('utf-8', pos, space), 10);
+    if (space === -1 || !(length > 0)) break;
+    const record = data.toString('utf-8', space + 1, pos + length - 1);
+    const eq = record.indexOf('=');
+    if (record.slice(0, eq) === 'path') found = record.slice(eq + 1);
+    pos += length;
+  }
+  return found;
+}
+
+/**
+ * Walk the headers of a tar archive. The visitor may ask for an entry's body
+ * (before returning); 'stop' ends the scan once that body has been read.
+ */
+function scanTar(filePath: string, type: 'tar' | 'tar.gz', visit: TarVisit): Promise<void> {
+  return new Promise<void>((resolve, reject) => {
+    const file = createReadStream(filePath);
+    const source: Readable = type === 'tar.gz' ? file.pipe(zlib.createGunzip()) : file;
+    if (source !== file) file.on('error', (error) => source.destroy(error));
+
+    let buffered = Buffer.alloc(0);
+    // Bytes of the current entry's body (and padding) still to pass
+    let remaining = 0;
+    let padding = 0;
+    let target: PassThrough | null = null;
+    let stopAfterBody = false;
+    // Long names from GNU 'L' and pax 'x' headers apply to the next entry
+    let longName: string | undefined;
+    let metaType: string | null = null;
+    let metaChunks: Buffer[] = [];
+    let finished = false;
+
+    const finish = (error?: Error) => {
+      if (finished) return;
+      finished = true;
+      file.destroy();
+      source.destroy();
+      if (error) {
+        target?.destroy(error);
+        reject(error);
+      } else {
+        target?.end();
+        resolve();
+      }
+    };
+
+    const endBody = () => {
+      if (metaType) {
+        const data = Buffer.concat(metaChunks);
+        longName =
+          metaType === 'L' ? tarString(data, 0, data.length) : (paxPath(data) ?? longName);
+        metaType = null;
+        metaChunks = [];
+      }
+      if (target) {
+        target.end();
+        target = null;
+      }
+      if (stopAfterBody) finish();
+    };
+
+    const onHeader = (block: Buffer): boolean => {
+      if (block.every((byte) => byte === 0)) {
+        finish();
+        return false;
+      }
+      const typeFlag = String.fromCharCode(block[156] || 0x30);
+      const size = tarNumber(block, 124, 12);
+      remaining = size;
+      padding = (TAR_BLOCK - (size % TAR_BLOCK)) % TAR_BLOCK;
+
+      if (typeFlag === 'L' || typeFlag === 'x') {
+        metaType = typeFlag;
+      } else if (typeFlag !== 'g') {
+        const isUstar = tarString(block, 257, 6).startsWith('ustar');
+        const prefix = isUstar ? tarString(block, 345, 155) : '';
+        const shortName = tarString(block, 0, 100);
+        const rawName = longName ?? (prefix ? `${prefix}/${shortName}` : shortName);
+        longName = undefined;
+        const name = normalizeMemberName(rawName);
+        if (name) {
+          const entry: ArchiveEntry = {
+            name,
+            type:
+              typeFlag === '0' || typeFlag === '7'
+                ? 'file'
+                : typeFlag === '5'
+                  ? 'directory'
+                  : typeFlag === '2'
+                    ? 'symlink'
+                    : 'other',
+            size: typeFlag === '5' ? 0 : size,
+            modified: new Date(tarNumber(block, 136, 12) * 1000).toISOString(),
+          };
+          const action = visit(entry, (body) => {
+            target = body;
+          });
+          if (action === 'stop') {
+            stopAfterBody = true;
+            if (!target || remaining === 0) {
+              endBody();
+              return false;
+            }
+          }
+        }
+      }
+      if (remaining === 0) endBody();
+      return true;
+    };
+
+    source.on('data', (chunk: Buffer) => {
+      buffered = buffered.length ? Buffer.concat([buffered, chunk]) : chunk;
+      let pos = 0;
+      while (!finished) {
+        if (remaining > 0) {
+          const take = Math.min(remaining, buffered.length - pos);
+          if (take === 0) break;
+          const part = buffered.subarray(pos, pos + take);
+          if (target) {
+            // Read no further than the client consumes
+            if (!target.write(Buffer.from(part))) {
+              source.pause();
+              target.once('drain', () => source.resume());
+            }
+          } else if (metaType) metaChunks.push(Buffer.from(part));
+          remaining -= take;
+          pos += take;
+          if (remaining === 0) endBody();
+        } else if (padding > 0) {
+          const take = Math.min(padding, buffered.length - pos);
+          if (take === 0) break;
+          padding -= take;
+          pos += take;
+        } else {
+          if (buffered.length - pos < TAR_BLOCK) break;
+          const block = buffered.subarray(pos, pos + TAR_BLOCK);
+          pos += TAR_BLOCK;
+          if (!onHeader(block)) break;
+        }
+      }
+      buffered = buffered.subarray(pos);
+    });
+    source.on('end', () => {
+      if (remaining > 0) finish(new ArchiveError('Tar archive is truncated', 'CORRUPT'));
+      else finish();
+    });
+    source.on('error', (error: NodeJS.ErrnoException) => {
+      // zlib errors (Z_DATA_ERROR, ...) mean a corrupt archive, others a failed read
+      finish(
+        error.code?.startsWith('Z_')
+          ? new ArchiveError(`Corrupt archive: ${error.message}`, 'CORRUPT')
+          : error
+      );
+    });
+  });
+}
@@ -0,0 +1,84 @@
+/**
+ * Pool of reusable Buffers grouped by power-of-two size class.
+ *
+ * Used on hot paths (snapshot encoding) where a buffer is filled, written to a
+ * socket and then no longer needed. Callers must not touch a buffer after
+ * releasing it.
+ */
+
+const MIN_SIZE_CLASS = 1024;
+
+export interface PooledBuffer {
+  buffer: Buffer; // View of exactly the requested size
+  release: () => void;
+}
+
+function sizeClassFor(size: number): number {
+  let sizeClass = MIN_SIZE_CLASS;
+  while (sizeClass < size) {
+    sizeClass *= 2;
+  }
+  return sizeClass;
+}
+
+export class BufferPool {
+  private free: Map<number, Buffer[]> = new Map();
+  private allocations = 0;
+  private reuses = 0;
+
+  constructor(private maxBuffersPerClass: number = 16) {}
+
+  /**
+   * Get a buffer of at least `size` bytes. The returned view has exactly `size` bytes.
+   */
+  acquire(size: number): PooledBuffer {
+    const sizeClass = sizeClassFor(size);
+    const freeList = this.free.get(sizeClass);
+    let backing = freeList?.pop();
+    if (backing) {
+      this.reuses++;
+    } else {
+      backing = Buffer.allocUnsafeSlow(sizeClass);
+      this.allocations++;
+    }
+
+    const owned = backing;
+    let released = false;
+    return {
+      buffer: owned.subarray(0, size),
+      release: () => {
+        if (released) return;
+        released = true;
+        let list = this.free.get(sizeClass);
+        if (!list) {
+          list = [];
+          this.free.set(sizeClass, list);
+        }
+        if (list.length < this.maxBuffersPerClass) {
+          list.push(owned);
+        }
+      },
+    };
+  }
+
+  /**
+   * Get diagnostic counters
+   */
+  getStats() {
+    let pooledBuffers = 0;
+    let pooledBytes = 0;
+    for (const [sizeClass, list] of this.free) {
+      pooledBuffers += list.length;
+      pooledBytes += sizeClass * list.length;
+    }
+    return {
+      allocations: this.allocations,
+      reuses: this.reuses,
+      pooledBuffers,
+      pooledBytes,
+    };
+  }
+}
+
+// Shared pool for encoded terminal snapshots
+export const snapshotBufferPool = new BufferPool();
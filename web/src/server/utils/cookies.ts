@@ -0,0 +1,23 @@
+/**
+ * Session cookie of single sign-on logins; holds the same JWT API clients send
+ * as a Bearer token, so page loads and WebSocket upgrades are authenticated too
+ */
+export const SESSION_COOKIE = 'vibetunnel_session';
+
+/**
+ * Value of a cookie in a Cookie request header
+ */
+export function getCookie(header: string | undefined, name: string): string | undefined {
+  if (!header) return undefined;
+  for (const part of header.split(';')) {
+    const separator = part.indexOf('=');
+    if (separator === -1 || part.slice(0, separator).trim() !== name) continue;
+    const value = part.slice(separator + 1).trim();
+    try {
+      return decodeURIComponent(value);
+    } catch {
+      return value;
+    }
+  }
+  return undefined;
+}
@@ -0,0 +1,161 @@
+/**
+ * Minimal cron expression support for scheduled commands
+ *
+ * Standard five fields (minute hour day-of-month month day-of-week) with `*`,
+ * numbers, ranges (`1-5`), steps (`0-30/10`, also after `*`) and lists (`1,15`), plus
+ * the `@hourly`, `@daily`, `@weekly`, `@monthly` and `@yearly` shortcuts.
+ * Times are evaluated in the server's local time zone.
+ */
+
+export interface CronSchedule {
+  minutes: Set<number>;
+  hours: Set<number>;
+  daysOfMonth: Set<number>;
+  months: Set<number>;
+  daysOfWeek: Set<number>;
+  // With both day fields restricted a day matches if either matches (cron semantics)
+  dayOfMonthRestricted: boolean;
+  dayOfWeekRestricted: boolean;
+}
+
+const SHORTCUTS: Record<string, string> = {
+  '@yearly': '0 0 1 1 *',
+  '@annually': '0 0 1 1 *',
+  '@monthly': '0 0 1 * *',
+  '@weekly': '0 0 * * 0',
+  '@daily': '0 0 * * *',
+  '@midnight': '0 0 * * *',
+  '@hourly': '0 * * * *',
+};
+
+const FIELD_RANGES: Array<[number, number]> = [
+  [0, 59], // minute
+  [0, 23], // hour
+  [1, 31], // day of month
+  [1, 12], // month
+  [0, 7], // day of week (0 and 7 are Sunday)
+];
+
+// Give up searching after this many years without a match (e.g. "0 0 31 2 *")
+const MAX_SEARCH_YEARS = 5;
+
+export class CronParseError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'CronParseError';
+  }
+}
+
+function parseNumber(value: string, min: number, max: number, expression: string): number {
+  if (!/^\d+$/.test(value)) {
+    throw new CronParseError(`Invalid value '${value}' in '${expression}'`);
+  }
+  const number = Number.parseInt(value, 10);
+  if (number < min || number > max) {
+    throw new CronParseError(`Value ${number} out of range ${min}-${max} in '${expression}'`);
+  }
+  return number;
+}
+
+function parseField(field: string, min: number, max: number, expression: string): Set<number> {
+  const values = new Set<number>();
+
+  for (const part of field.split(',')) {
+    const [range, stepText] = part.split('/');
+    const step = stepText === undefined ? 1 : parseNumber(stepText, 1, max, expression);
+
+    let start = min;
+    let end = max;
+    if (range !== '*') {
+      const [startText, endText] = range.split('-');
+      start = parseNumber(startText, min, max, expression);
+      // Without an end, "5" is a single value and "5/10" runs up to the maximum
+      if (endText !== undefined) {
+        end = parseNumber(endText, min, max, expression);
+      } else if (stepText === undefined) {
+        end = start;
+      }
+      if (end < start) {
+        throw new CronParseError(`Invalid range '${range}' in '${expression}'`);
+      }
+    }
+
+    for (let value = start; value <= end; value += step) {
+      values.add(value);
+    }
+  }
+
+  return values;
+}
+
+/**
+ * Parse a cron expression. Throws CronParseError for invalid expressions.
+ */
+export function parseCron(expression: string): CronSchedule {
+  const normalized = SHORTCUTS[expression.trim()] ?? expression.trim();
+  const fields = normalized.split(/\s+/);
+  if (fields.length !== 5) {
+    throw new CronParseError(`Expected 5 fields in '${expression}'`);
+  }
+
+  const [minutes, hours, daysOfMonth, months, daysOfWeek] = fields.map((field, index) =>
+    parseField(field, FIELD_RANGES[index][0], FIELD_RANGES[index][1], expression)
+  );
+  if (daysOfWeek.delete(7)) {
+    daysOfWeek.add(0);
+  }
+
+  return {
+    minutes,
+    hours,
+    daysOfMonth,
+    months,
+    daysOfWeek,
+    dayOfMonthRestricted: fields[2] !== '*',
+    dayOfWeekRestricted: fields[4] !== '*',
+  };
+}
+
+function matchesDay(schedule: CronSchedule, date: Date): boolean {
+  const dayOfMonth = schedule.daysOfMonth.has(date.getDate());
+  const dayOfWeek = schedule.daysOfWeek.has(date.getDay());
+  if (schedule.dayOfMonthRestricted && schedule.dayOfWeekRestricted) {
+    return dayOfMonth || dayOfWeek;
+  }
+  return dayOfMonth && dayOfWeek;
+}
+
+/**
+ * First time strictly after `after` that matches the schedule, or null if
+ * there is none within the next few years.
+ */
+export function nextCronRun(schedule: CronSchedule, after: Date): Date | null {
+  const date = new Date(after.getTime());
+  date.setSeconds(0, 0);
+  date.setMinutes(date.getMinutes() + 1);
+
+  const limit = after.getFullYear() + MAX_SEARCH_YEARS;
+  while (date.getFullYear() <= limit) {
+    if (!schedule.months.has(date.getMonth() + 1)) {
+      date.setMonth(date.getMonth() + 1, 1);
+      date.setHours(0, 0);
+      continue;
+    }
+    if (!matchesDay(schedule, date)) {
+      date.setDate(date.getDate() + 1);
+      date.setHours(0, 0);
+      continue;
+    }
+    if (!schedule.hours.has(date.getHours())) {
+      date.setHours(date.getHours() + 1, 0);
+      continue;
+    }
+    if (!schedule.minutes.has(date.getMinutes())) {
+      date.setMinutes(date.getMinutes() + 1);
+      continue;
+    }
+    return date;
+  }
+
+  return null;
+}
@@ -0,0 +1,232 @@
+/**
+ * Fault injection - Latency, drops and errors on HQ's connections to remotes
+ *
+ * For integration tests only (`--chaos <spec>`): every request HQ makes to a
+ * remote (tracedFetch) and every buffer socket it opens passes through the
+ * injector, which may delay it, fail it like a network error or answer it
+ * with a 5xx instead of the remote. Decisions come from a seeded generator in
+ * request order, so a test run with the same seed and requests sees the same
+ * faults.
+ *
+ * Spec: comma-separated `key=value` pairs, e.g. `latency=200,drop=0.1,seed=7`
+ * - `latency`, `jitter`: added delay and random extra delay in ms
+ * - `drop`, `error`: fraction of requests failing as network errors / with `status`
+ * - `status`: status of injected errors (default 503)
+ * - `match`: only URLs containing this (a remote's host or a path)
+ * - `seed`: generator seed (default 1)
+ */
+
+export interface FaultConfig {
+  latencyMs: number;
+  jitterMs: number;
+  dropRate: number;
+  errorRate: number;
+  errorStatus: number;
+  match: string | null;
+  seed: number;
+}
+
+export interface FaultStats {
+  requests: number;
+  delayed: number;
+  dropped: number;
+  errors: number;
+}
+
+export type FaultOutcome = 'pass' | 'drop' | 'error';
+
+export const DEFAULT_FAULT_CONFIG: FaultConfig = {
+  latencyMs: 0,
+  jitterMs: 0,
+  dropRate: 0,
+  errorRate: 0,
+  errorStatus: 503,
+  match: null,
+  seed: 1,
+};
+
+export class FaultSpecError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'FaultSpecError';
+  }
+}
+
+function parseNumber(key: string, value: string, min: number, max: number): number {
+  const number = Number(value);
+  if (value.trim() === '' || !Number.isFinite(number) || number < min || number > max) {
+    throw new FaultSpecError(`${key} must be a number from ${min} to ${max}`);
+  }
+  return number;
+}
+
+/**
+ * Parse a fault spec; unknown keys and out of range values are errors
+ */
+export function parseFaultSpec(spec: string): FaultConfig {
+  const config = { ...DEFAULT_FAULT_CONFIG };
+  for (const pair of spec.split(',')) {
+    if (!pair.trim()) continue;
+    const index = pair.indexOf('=');
+    if (index <= 0) throw new FaultSpecError(`expected key=value, got ${pair}`);
+    const key = pair.slice(0, index).trim();
+    const value = pair.slice(index + 1).trim();
+    switch (key) {
+      case 'latency':
+        config.latencyMs = parseNumber(key, value, 0, 600000);
+        break;
+      case 'jitter':
+        config.jitterMs = parseNumber(key, value, 0, 600000);
+        break;
+      case 'drop':
+        config.dropRate = parseNumber(key, value, 0, 1);
+        break;
+      case 'error':
+        config.errorRate = parseNumber(key, value, 0, 1);
+        break;
+      case 'status':
+        config.errorStatus = Math.floor(parseNumber(key, value, 500, 599));
+        break;
+      case 'match':
+        config.match = value || null;
+        break;
+      case 'seed':
+        config.seed = Math.floor(parseNumber(key, value, 0, 2 ** 32 - 1));
+        break;
+      default:
+        throw new FaultSpecError(`unknown key: ${key}`);
+    }
+  }
+  if (config.dropRate + config.errorRate > 1) {
+    throw new FaultSpecError('drop and error together cannot exceed 1');
+  }
+  return config;
+}
+
+// mulberry32: small, seedable and good enough to spread faults
+function createRandom(seed: number): () => number {
+  let state = seed >>> 0;
+  return () => {
+    state = (state + 0x6d2b79f5) >>> 0;
+    let t = state;
+    t = Math.imul(t ^ (t >>> 15), t | 1);
+    t ^= t + Math.imul(t ^ (t >>> 7), t | 61);
+    return ((t ^ (t >>> 14)) >>> 0) / 4294967296;
+  };
+}
+
+export class FaultInjector {
+  private config: FaultConfig;
+  private random: () => number;
+  private stats: FaultStats = { requests: 0, delayed: 0, dropped: 0, errors: 0 };
+
+  constructor(config: Partial<FaultConfig> = {}) {
+    this.config = { ...DEFAULT_FAULT_CONFIG, ...config };
+    this.random = createRandom(this.config.seed);
+  }
+
+  /**
+   * Replace the configuration; the generator restarts from the seed and the stats are reset
+   */
+  configure(config: Partial<FaultConfig>): void {
+    this.config = { ...DEFAULT_FAULT_CONFIG, ...config };
+    this.random = createRandom(this.config.seed);
+    this.stats = { requests: 0, delayed: 0, dropped: 0, errors: 0 };
+  }
+
+  getConfig(): FaultConfig {
+    return { ...this.config };
+  }
+
+  getStats(): FaultStats {
+    return { ...this.stats };
+  }
+
+  /**
+   * What happens to a request to `url`: its delay and outcome
+   */
+  decide(url: string): { delayMs: number; outcome: FaultOutcome } {
+    if (this.config.match && !url.includes(this.config.match)) {
+      return { delayMs: 0, outcome: 'pass' };
+    }
+    this.stats.requests++;
+    // Always two draws per request, so one fault rate does not shift the other's sequence
+    const jitter = this.random();
+    const roll = this.random();
+    const delayMs = this.config.latencyMs + Math.floor(jitter * this.config.jitterMs);
+    if (delayMs > 0) this.stats.delayed++;
+
+    let outcome: FaultOutcome = 'pass';
+    if (roll < this.config.dropRate) {
+      outcome = 'drop';
+      this.stats.dropped++;
+    } else if (roll < this.config.dropRate + this.config.errorRate) {
+      outcome = 'error';
+      this.stats.errors++;
+    }
+    return { delayMs, outcome };
+  }
+
+  /**
+   * Apply the faults for a fetch: resolves to a response to use instead of the
+   * remote's (an injected error), null to go ahead, or rejects like a network error
+   */
+  async beforeFetch(url: string, signal?: AbortSignal | null): Promise<Response | null> {
+    const { delayMs, outcome } = this.decide(url);
+    if (delayMs > 0) await delay(delayMs, signal);
+    if (outcome === 'drop') {
+      throw new TypeError('fetch failed', { cause: new Error('connection dropped by --chaos') });
+    }
+    if (outcome === 'error') {
+      const message = 'Error injected by --chaos';
+      return new Response(JSON.stringify({ code: 'REMOTE_ERROR', message, error: message }), {
+        status: this.config.errorStatus,
+        headers: { 'Content-Type': 'application/json' },
+      });
+    }
+    return null;
+  }
+
+  /**
+   * Apply the faults for opening a socket; both drops and errors fail the connection
+   */
+  async beforeConnect(url: string): Promise<void> {
+    const { delayMs, outcome } = this.decide(url);
+    if (delayMs > 0) await delay(delayMs);
+    if (outcome !== 'pass') {
+      throw new Error(
+        outcome === 'drop'
+          ? 'connection dropped by --chaos'
+          : `Unexpected server response: ${this.config.errorStatus} (injected by --chaos)`
+      );
+    }
+  }
+}
+
+function delay(ms: number, signal?: AbortSignal | null): Promise<void> {
+  return new Promise((resolve, reject) => {
+    if (signal?.aborted) return reject(signal.reason);
+    const timer = setTimeout(() => {
+      signal?.removeEventListener('abort', onAbort);
+      resolve();
+    }, ms);
+    const onAbort = () => {
+      clearTimeout(timer);
+      reject(signal?.reason);
+    };
+    signal?.addEventListener('abort', onAbort, { once: true });
+  });
+}
+
+let activeInjector: FaultInjector | null = null;
+
+/**
+ * The injector HQ's remote connections go through (null: no faults, the default)
+ */
+export function getFaultInjector(): FaultInjector | null {
+  return activeInjector;
+}
+
+export function setFaultInjector(injector: FaultInjector | null): void {
+  activeInjector = injector;
+}
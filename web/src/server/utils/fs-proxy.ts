@@ -0,0 +1,91 @@
+/**
+ * Filesystem proxying - Which remote an HQ hands a /api/fs request to
+ *
+ * Files of a remote session live on the remote, so an HQ forwards file requests
+ * that name a remote session (a namespaced `sessionId`) or a remote (`remoteId`,
+ * its ID or name) to that remote's own filesystem API. The remote gets the
+ * request without `remoteId` and with the session ID it knows; behind a
+ * federated HQ that ID is still namespaced and the next HQ forwards it again.
+ */
+
+import type { RemoteRegistry, RemoteServer } from '../services/remote-registry.js';
+import { parseNamespacedSessionId, toRemoteSessionId } from './session-namespace.js';
+
+export type RemoteLookup = Pick<
+  RemoteRegistry,
+  'getRemote' | 'getRemoteByName' | 'getRemoteBySessionId'
+>;
+
+export interface FsProxyTarget {
+  remote: RemoteServer;
+  // Full URL on the remote
+  url: string;
+  // JSON body to send instead of the original one
+  body?: Record<string, unknown>;
+}
+
+function stringParam(value: unknown): string | undefined {
+  return typeof value === 'string' && value !== '' ? value : undefined;
+}
+
+/**
+ * The remote a filesystem request belongs to: a target, 'unknown-remote' when
+ * it names a remote (or remote session) that is not registered, or null for
+ * requests served locally.
+ *
+ * @param url - The request URL below `/api` (`/fs/browse?path=...`)
+ */
+export function resolveFsProxyTarget(
+  remotes: RemoteLookup,
+  url: string,
+  body: unknown
+): FsProxyTarget | 'unknown-remote' | null {
+  const parsed = new URL(url, 'http://localhost');
+  const jsonBody =
+    body && typeof body === 'object' && !Array.isArray(body)
+      ? (body as Record<string, unknown>)
+      : undefined;
+  const remoteId =
+    stringParam(parsed.searchParams.get('remoteId')) ?? stringParam(jsonBody?.remoteId);
+  const sessionId =
+    stringParam(parsed.searchParams.get('sessionId')) ?? stringParam(jsonBody?.sessionId);
+
+  let remote: RemoteServer | undefined;
+  if (remoteId) {
+    remote = remotes.getRemote(remoteId) ?? remotes.getRemoteByName(remoteId);
+  } else if (sessionId && parseNamespacedSessionId(sessionId)) {
+    // Namespaced IDs never name local sessions
+    remote = remotes.getRemoteBySessionId(sessionId);
+  } else {
+    return null;
+  }
+  if (!remote) {
+    return 'unknown-remote';
+  }
+
+  // Only IDs in this remote's namespace are translated; the remote resolves others itself
+  const remoteSessionId =
+    sessionId && parseNamespacedSessionId(sessionId)?.remoteName === remote.name
+      ? toRemoteSessionId(sessionId)
+      : sessionId;
+
+  parsed.searchParams.delete('remoteId');
+  if (parsed.searchParams.has('sessionId') && remoteSessionId) {
+    parsed.searchParams.set('sessionId', remoteSessionId);
+  }
+
+  let forwardedBody: Record<string, unknown> | undefined;
+  if (jsonBody) {
+    const { remoteId: _remoteId, ...rest } = jsonBody;
+    forwardedBody = rest;
+    if (typeof rest.sessionId === 'string' && remoteSessionId) {
+      forwardedBody.sessionId = remoteSessionId;
+    }
+  }
+
+  return {
+    remote,
+    url: `${remote.url}/api${parsed.pathname}${parsed.search}`,
+    body: forwardedBody,
+  };
+}
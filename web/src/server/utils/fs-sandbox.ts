@@ -0,0 +1,120 @@
+/**
+ * Filesystem sandbox - The directories the filesystem API may touch
+ *
+ * Roots come from --fs-root (`<path>[?users=u,v]`, repeatable). A root with
+ * users applies to those users only; `~` stands for the requesting user's
+ * home. Without configured roots, a user may use their home and the working
+ * directories of their running sessions. The scratch directories of a user's
+ * sessions are always allowed. Paths are compared after resolving
+ * symlinks, so a link inside a root cannot reach a file outside of it.
+ */
+
+import * as fs from 'fs/promises';
+import * as path from 'path';
+
+export interface FsRoot {
+  path: string;
+  // Only these users get the root (everyone if unset)
+  users?: string[];
+}
+
+export interface FsRequester {
+  // Authenticated user, if any
+  user?: string;
+  home: string;
+}
+
+// Working directories of the sessions a user (everyone's if undefined) is running
+export type SessionDirsProvider = (user?: string) => string[];
+
+/**
+ * Parse a --fs-root value: <path>[?users=u,v]
+ */
+export function parseFsRoot(spec: string): FsRoot {
+  const [rootPath, query = ''] = spec.split('?', 2);
+  if (!rootPath) {
+    throw new Error(`Filesystem root has no path: ${spec}`);
+  }
+  if (rootPath !== '~' && !rootPath.startsWith('~/') && !path.isAbsolute(rootPath)) {
+    throw new Error(`Filesystem root must be absolute or start with ~: ${spec}`);
+  }
+  const users = new URLSearchParams(query)
+    .get('users')
+    ?.split(',')
+    .map((user) => user.trim())
+    .filter(Boolean);
+  return { path: rootPath, ...(users?.length ? { users } : {}) };
+}
+
+/**
+ * The real path of a file, or for one that does not exist (yet), the real
+ * path of its closest existing ancestor with the rest appended
+ */
+export async function realPathOf(target: string): Promise<string | null> {
+  let existing = path.resolve(target);
+  const rest: string[] = [];
+  for (;;) {
+    try {
+      return path.join(await fs.realpath(existing), ...rest);
+    } catch (error) {
+      const parent = path.dirname(existing);
+      if ((error as NodeJS.ErrnoException).code !== 'ENOENT' || parent === existing) {
+        return null;
+      }
+      rest.unshift(path.basename(existing));
+      existing = parent;
+    }
+  }
+}
+
+function isWithin(root: string, target: string): boolean {
+  const relative = path.relative(root, target);
+  // Names starting with two dots (e.g. "..cache") are inside the root
+  const outside = relative === '..' || relative.startsWith(`..${path.sep}`);
+  return !outside && !path.isAbsolute(relative);
+}
+
+export class FsSandbox {
+  constructor(
+    private roots: FsRoot[],
+    private sessionDirs: SessionDirsProvider = () => [],
+    private scratchDirs: SessionDirsProvider = () => []
+  ) {}
+
+  /**
+   * The roots that apply to a requester, before resolving symlinks
+   */
+  rootsFor(requester: FsRequester): string[] {
+    const expand = (root: string) =>
+      root === '~' || root.startsWith('~/')
+        ? path.join(requester.home, root.slice(2))
+        : path.resolve(root);
+
+    const scratchDirs = this.scratchDirs(requester.user);
+    if (this.roots.length === 0) {
+      const defaults = [requester.home, ...this.sessionDirs(requester.user)];
+      return defaults.map(expand).concat(scratchDirs);
+    }
+    const user = requester.user;
+    return this.roots
+      .filter((root) => !root.users || (user !== undefined && root.users.includes(user)))
+      .map((root) => expand(root.path))
+      .concat(scratchDirs);
+  }
+
+  /**
+   * Whether the path, with symlinks resolved, lies in one of the requester's roots
+   */
+  async isAllowed(target: string, requester: FsRequester): Promise<boolean> {
+    const resolved = await realPathOf(target);
+    if (!resolved) return false;
+
+    for (const root of this.rootsFor(requester)) {
+      const realRoot = await realPathOf(root);
+      if (realRoot && isWithin(realRoot, resolved)) {
+        return true;
+      }
+    }
+    return false;
+  }
+}
@@ -0,0 +1,168 @@
+/**
+ * Filesystem search - Recursive name and content search below a directory
+ *
+ * Walks the tree breadth-first without following directory symlinks (no
+ * loops, no escaping the searched root). Every search is bounded: by result
+ * count, by the number of entries visited and by time; a search that hits a
+ * bound returns what it found with `truncated` set. Content search reads
+ * text files line by line and skips binary and large files.
+ */
+
+import { createReadStream, type Dirent, type Stats } from 'fs';
+import * as fs from 'fs/promises';
+import * as path from 'path';
+import * as readline from 'readline';
+import { globMatcher } from './glob.js';
+
+export const DEFAULT_SEARCH_RESULTS = 200;
+export const MAX_SEARCH_RESULTS = 1000;
+const MAX_VISITED_ENTRIES = 200000;
+const SEARCH_TIME_LIMIT_MS = 10000;
+// Files larger than this are not searched for content
+const MAX_CONTENT_FILE_BYTES = 2 * 1024 * 1024;
+const MAX_MATCHES_PER_FILE = 5;
+const MAX_MATCH_LINE_LENGTH = 300;
+// Never descended into; they are large and rarely what anyone searches for
+const SKIPPED_DIRECTORIES = new Set(['.git', 'node_modules']);
+
+export interface SearchOptions {
+  // Glob over paths relative to the root (base names if it has no `/`)
+  pattern?: string;
+  // Literal text to find in files, case-insensitive
+  content?: string;
+  showHidden?: boolean;
+  maxResults?: number;
+  signal?: AbortSignal;
+  // Directories and files the requester may read (all if unset)
+  canRead?: (fullPath: string) => Promise<boolean>;
+}
+
+export interface SearchMatch {
+  line: number;
+  text: string;
+}
+
+export interface SearchResult {
+  // Relative to the searched root, `/`-separated
+  path: string;
+  type: 'file' | 'directory';
+  size: number;
+  modified: string;
+  matches?: SearchMatch[];
+}
+
+export interface SearchResponse {
+  results: SearchResult[];
+  truncated: boolean;
+  // Entries looked at
+  visited: number;
+}
+
+async function findInFile(filePath: string, needle: string): Promise<SearchMatch[]> {
+  const handle = await fs.open(filePath, 'r');
+  try {
+    // A NUL byte near the start means binary
+    const head = Buffer.alloc(8192);
+    const { bytesRead } = await handle.read(head, 0, head.length, 0);
+    if (head.subarray(0, bytesRead).includes(0)) return [];
+  } finally {
+    await handle.close();
+  }
+
+  const matches: SearchMatch[] = [];
+  const stream = createReadStream(filePath, { encoding: 'utf-8' });
+  const lines = readline.createInterface({ input: stream, crlfDelay: Number.POSITIVE_INFINITY });
+  let lineNumber = 0;
+  try {
+    for await (const line of lines) {
+      lineNumber++;
+      if (line.toLowerCase().includes(needle)) {
+        matches.push({ line: lineNumber, text: line.slice(0, MAX_MATCH_LINE_LENGTH) });
+        if (matches.length >= MAX_MATCHES_PER_FILE) break;
+      }
+    }
+  } finally {
+    lines.close();
+    stream.destroy();
+  }
+  return matches;
+}
+
+export async function searchFiles(root: string, options: SearchOptions): Promise<SearchResponse> {
+  const maxResults = Math.min(options.maxResults ?? DEFAULT_SEARCH_RESULTS, MAX_SEARCH_RESULTS);
+  const matchesPath = options.pattern ? globMatcher(options.pattern) : () => true;
+  const needle = options.content ? options.content.toLowerCase() : undefined;
+  const deadline = Date.now() + SEARCH_TIME_LIMIT_MS;
+
+  const results: SearchResult[] = [];
+  let visited = 0;
+  let truncated = false;
+  const queue: string[] = [''];
+
+  while (queue.length > 0) {
+    if (options.signal?.aborted || Date.now() > deadline) {
+      truncated = true;
+      break;
+    }
+    const relativeDir = queue.shift() as string;
+    const dir = path.join(root, relativeDir);
+    if (options.canRead && !(await options.canRead(dir))) continue;
+    let entries: Dirent[];
+    try {
+      entries = await fs.readdir(dir, { withFileTypes: true });
+    } catch {
+      // Unreadable directories are skipped, not fatal
+      continue;
+    }
+    entries.sort((a, b) => a.name.localeCompare(b.name));
+
+    for (const entry of entries) {
+      if (++visited > MAX_VISITED_ENTRIES || results.length >= maxResults) {
+        truncated = true;
+        queue.length = 0;
+        break;
+      }
+      if (!options.showHidden && entry.name.startsWith('.')) continue;
+
+      const relativePath = relativeDir ? `${relativeDir}/${entry.name}` : entry.name;
+      const isDirectory = entry.isDirectory();
+      if (isDirectory) {
+        if (SKIPPED_DIRECTORIES.has(entry.name)) continue;
+        queue.push(relativePath);
+      }
+      // Content search only looks at regular files, never through symlinks
+      if (needle !== undefined && !entry.isFile()) continue;
+      if (!matchesPath(relativePath)) continue;
+
+      const fullPath = path.join(root, relativePath);
+      let stats: Stats;
+      try {
+        stats = await fs.stat(fullPath);
+      } catch {
+        continue;
+      }
+
+      let matches: SearchMatch[] | undefined;
+      if (needle !== undefined) {
+        if (stats.size > MAX_CONTENT_FILE_BYTES) continue;
+        if (options.canRead && !(await options.canRead(fullPath))) continue;
+        try {
+          matches = await findInFile(fullPath, needle);
+        } catch {
+          continue;
+        }
+        if (matches.length === 0) continue;
+      }
+
+      results.push({
+        path: relativePath,
+        type: stats.isDirectory() ? 'directory' : 'file',
+        size: stats.size,
+        modified: stats.mtime.toISOString(),
+        ...(matches ? { matches } : {}),
+      });
+    }
+  }
+
+  return { results, truncated, visited: Math.min(visited, MAX_VISITED_ENTRIES) };
+}
@@ -0,0 +1,74 @@
+/**
+ * Glob - Shell-style patterns for file names and paths
+ *
+ * Supports `*` (anything but `/`), `**` (any number of directories), `?`,
+ * character classes (`[a-z]`, `[!.]`) and alternatives (`{ts,tsx}`). A
+ * pattern without `/` matches the base name at any depth, like .gitignore.
+ */
+
+function escapeRegExp(char: string): string {
+  return /[\\^$.*+?()[\]{}|/]/.test(char) ? `\\${char}` : char;
+}
+
+function translate(pattern: string): string {
+  let out = '';
+  let braces = 0;
+  for (let i = 0; i < pattern.length; i++) {
+    const char = pattern[i];
+    if (char === '*') {
+      if (pattern[i + 1] === '*') {
+        // `**/` matches zero or more directories, a trailing `**` everything
+        const slash = pattern[i + 2] === '/';
+        i += slash ? 2 : 1;
+        out += slash ? '(?:[^/]*/)*' : '.*';
+      } else {
+        out += '[^/]*';
+      }
+    } else if (char === '?') {
+      out += '[^/]';
+    } else if (char === '[') {
+      const end = pattern.indexOf(']', i + 2);
+      if (end === -1) {
+        out += '\\[';
+        continue;
+      }
+      let body = pattern.slice(i + 1, end).replace(/\\/g, '\\\\');
+      if (body.startsWith('!')) body = `^${body.slice(1)}`;
+      out += `[${body}]`;
+      i = end;
+    } else if (char === '{') {
+      braces++;
+      out += '(?:';
+    } else if (char === '}' && braces > 0) {
+      braces--;
+      out += ')';
+    } else if (char === ',' && braces > 0) {
+      out += '|';
+    } else if (char === '\\' && i + 1 < pattern.length) {
+      out += escapeRegExp(pattern[++i]);
+    } else {
+      out += escapeRegExp(char);
+    }
+  }
+  // Unclosed braces are literal in shells; close them rather than fail
+  return out + ')'.repeat(braces);
+}
+
+export function globToRegExp(pattern: string, caseInsensitive = false): RegExp {
+  const trimmed = pattern.replace(/^\.\//, '');
+  return new RegExp(`^${translate(trimmed)}$`, caseInsensitive ? 'i' : '');
+}
+
+/**
+ * Matcher for `/`-separated paths relative to the search root
+ */
+export function globMatcher(
+  pattern: string,
+  caseInsensitive = false
+): (relativePath: string) => boolean {
+  const regex = globToRegExp(pattern, caseInsensitive);
+  if (!pattern.includes('/')) {
+    return (relativePath) => regex.test(relativePath.slice(relativePath.lastIndexOf('/') + 1));
+  }
+  return (relativePath) => regex.test(relativePath);
+}
@@ -0,0 +1,60 @@
+import { createHash } from 'crypto';
+import type { AuthenticatedRequest } from '../middleware/auth.js';
+
+/**
+ * Who sent a piece of input. Recorded with `i` events in the cast file and in
+ * the session's input audit log. Tokens are never stored, only a fingerprint.
+ */
+export interface InputSource {
+  userId?: string;
+  ip?: string;
+  authMethod?: string;
+  // First 12 hex digits of the SHA-256 of the bearer or query token
+  token?: string;
+  // Server the input was relayed through (e.g. 'hq')
+  via?: string;
+}
+
+// HQ passes the source of input it forwards to a remote, which trusts it from HQ only
+export const INPUT_SOURCE_HEADER = 'x-vibetunnel-input-source';
+
+export function tokenFingerprint(token: string): string {
+  return createHash('sha256').update(token).digest('hex').slice(0, 12);
+}
+
+/**
+ * Source of input sent with a request. For requests from HQ the source HQ
+ * forwarded in INPUT_SOURCE_HEADER is used.
+ */
+export function inputSourceFromRequest(req: AuthenticatedRequest): InputSource {
+  if (req.isHQRequest) {
+    const forwarded = parseInputSource(req.headers[INPUT_SOURCE_HEADER]);
+    return { ...forwarded, via: forwarded.via ? `hq/${forwarded.via}` : 'hq' };
+  }
+
+  const authHeader = req.headers.authorization;
+  const token = authHeader?.startsWith('Bearer ')
+    ? authHeader.substring(7)
+    : (req.query?.token as string | undefined);
+
+  const source: InputSource = {};
+  if (req.userId) source.userId = req.userId;
+  if (req.ip) source.ip = req.ip;
+  if (req.authMethod) source.authMethod = req.authMethod;
+  if (token) source.token = tokenFingerprint(token);
+  return source;
+}
+
+function parseInputSource(value: string | string[] | undefined): InputSource {
+  if (typeof value !== 'string') return {};
+  try {
+    const parsed = JSON.parse(value);
+    const source: InputSource = {};
+    for (const field of ['userId', 'ip', 'authMethod', 'token', 'via'] as const) {
+      if (typeof parsed?.[field] === 'string') source[field] = parsed[field];
+    }
+    return source;
+  } catch {
+    return {};
+  }
+}
@@ -0,0 +1,207 @@
+import { execFileSync } from 'child_process';
+import * as fs from 'fs/promises';
+import * as path from 'path';
+import {
+  type AuthenticatedRequest,
+  isAdminRequest,
+  isOperatorRequest,
+} from '../middleware/auth.js';
+import { createLogger } from './logger.js';
+
+const logger = createLogger('local-accounts');
+
+// Account lookups are cached briefly; they shell out to getent/dscl and id
+const CACHE_TTL_MS = 60 * 1000;
+const USERNAME_PATTERN = /^[A-Za-z_][A-Za-z0-9_.-]{0,31}\$?$/;
+
+/**
+ * An operating system account sessions can run as
+ */
+export interface LocalAccount {
+  username: string;
+  uid: number;
+  gid: number;
+  // Primary and supplementary groups
+  groups: number[];
+  home: string;
+  shell: string;
+}
+
+const cache = new Map<string, { account: LocalAccount | null; expiresAt: number }>();
+
+function run(command: string, args: string[]): string | null {
+  try {
+    return execFileSync(command, args, { encoding: 'utf8', stdio: ['ignore', 'pipe', 'ignore'] });
+  } catch {
+    return null;
+  }
+}
+
+// Home directory and shell from the user database (NSS on Linux, Directory Services on macOS)
+function lookupHomeAndShell(username: string): { home: string; shell: string } | null {
+  if (process.platform === 'darwin') {
+    const output = run('dscl', [
+      '.',
+      '-read',
+      `/Users/${username}`,
+      'NFSHomeDirectory',
+      'UserShell',
+    ]);
+    const home = output?.match(/^NFSHomeDirectory:\s*(.+)$/m)?.[1].trim();
+    const shell = output?.match(/^UserShell:\s*(.+)$/m)?.[1].trim();
+    return home ? { home, shell: shell || '/bin/zsh' } : null;
+  }
+  // name:password:uid:gid:gecos:home:shell
+  const fields = run('getent', ['passwd', username])?.trim().split(':');
+  return fields && fields.length >= 7 ? { home: fields[5], shell: fields[6] || '/bin/sh' } : null;
+}
+
+/**
+ * Look up a local account by name; null when there is none
+ */
+export function lookupLocalAccount(username: string): LocalAccount | null {
+  if (!USERNAME_PATTERN.test(username)) return null;
+
+  const cached = cache.get(username);
+  if (cached && cached.expiresAt > Date.now()) return cached.account;
+
+  let account: LocalAccount | null = null;
+  const uid = run('id', ['-u', username]);
+  const gid = run('id', ['-g', username]);
+  const groups = run('id', ['-G', username]);
+  const entry = uid !== null && gid !== null ? lookupHomeAndShell(username) : null;
+  if (uid !== null && gid !== null && entry) {
+    account = {
+      username,
+      uid: Number(uid.trim()),
+      gid: Number(gid.trim()),
+      groups: (groups ?? gid).trim().split(/\s+/).map(Number),
+      ...entry,
+    };
+  }
+
+  cache.set(username, { account, expiresAt: Date.now() + CACHE_TTL_MS });
+  return account;
+}
+
+/**
+ * Environment for a process running as the account: the server's environment
+ * with the account's identity and home instead of the server user's
+ */
+export function accountEnvironment(
+  account: LocalAccount,
+  env: NodeJS.ProcessEnv = process.env
+): Record<string, string> {
+  const result: Record<string, string> = {};
+  for (const [key, value] of Object.entries(env)) {
+    // Per-user locations of the server user would point into its home or runtime dir
+    if (value === undefined || key.startsWith('XDG_') || key.startsWith('SUDO_')) continue;
+    result[key] = value;
+  }
+  return {
+    ...result,
+    HOME: account.home,
+    USER: account.username,
+    LOGNAME: account.username,
+    SHELL: account.shell,
+  };
+}
+
+// Permission bit of the account on a file: owner, group or other
+function permitted(
+  account: LocalAccount,
+  stats: { mode: number; uid: number; gid: number },
+  bit: number
+): boolean {
+  if (account.uid === 0) return true;
+  if (stats.uid === account.uid) return (stats.mode & (bit << 6)) !== 0;
+  if (account.groups.includes(stats.gid)) return (stats.mode & (bit << 3)) !== 0;
+  return (stats.mode & bit) !== 0;
+}
+
+/**
+ * Whether the account could read (or write) a path, judged by the permission
+ * bits of the path and the search permission of every directory above it.
+ * Symlinks are resolved first; a path that does not exist is judged by its
+ * parent directory, for creating it.
+ */
+export async function canAccessPath(
+  account: LocalAccount,
+  targetPath: string,
+  access: 'read' | 'write'
+): Promise<boolean> {
+  let resolved: string;
+  let exists = true;
+  try {
+    resolved = await fs.realpath(targetPath);
+  } catch {
+    exists = false;
+    try {
+      resolved = path.join(await fs.realpath(path.dirname(targetPath)), path.basename(targetPath));
+    } catch {
+      return false;
+    }
+  }
+
+  try {
+    // Every directory on the way needs search (execute) permission
+    const parts = resolved.split(path.sep).filter(Boolean);
+    let current = path.sep;
+    for (const part of parts.slice(0, -1)) {
+      current = path.join(current, part);
+      if (!permitted(account, await fs.stat(current), 1)) return false;
+    }
+
+    if (!exists) {
+      // Creating an entry needs write and search permission on the parent
+      const parent = await fs.stat(path.dirname(resolved));
+      return access === 'write' && permitted(account, parent, 2) && permitted(account, parent, 1);
+    }
+
+    const stats = await fs.stat(resolved);
+    const bit = access === 'write' ? 2 : 4;
+    // Listing a directory also needs search permission
+    return (
+      permitted(account, stats, bit) && (!stats.isDirectory() || permitted(account, stats, 1))
+    );
+  } catch (error) {
+    logger.debug(`access check of ${targetPath} for ${account.username} failed: ${error}`);
+    return false;
+  }
+}
+
+/**
+ * Account a request's sessions and commands run as in local user mode.
+ * Local operators (no auth, local bypass) and HQ requests keep the server's
+ * account; everyone else needs a local account of the same name.
+ */
+export function accountForRequest(
+  req: AuthenticatedRequest,
+  localUsers: boolean
+): { account?: LocalAccount; error?: string } {
+  if (!localUsers || isOperatorRequest(req)) {
+    return {};
+  }
+  const account = req.userId ? lookupLocalAccount(req.userId) : null;
+  if (!account) {
+    return { error: `${req.userId || 'Anonymous user'} has no local account on this host` };
+  }
+  return { account };
+}
+
+/**
+ * Whether a request may use a session. In local user mode sessions run as the
+ * account of their creator, so only the creator, operators and admins may.
+ */
+export function canUseSession(
+  req: AuthenticatedRequest,
+  session: { createdBy?: string },
+  localUsers: boolean,
+  adminUsers: string[]
+): boolean {
+  return (
+    !localUsers ||
+    isAdminRequest(req, adminUsers) ||
+    (!!req.userId && session.createdBy === req.userId)
+  );
+}
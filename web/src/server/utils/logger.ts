@@ -2,13 +2,24 @@ import chalk from 'chalk';
 import * as fs from 'fs';
 import * as os from 'os';
 import * as path from 'path';
+import { getRequestId } from './request-context.js';
 
 // Log file path
 const LOG_DIR = path.join(os.homedir(), '.vibetunnel');
 const LOG_FILE = path.join(LOG_DIR, 'log.txt');
 
-// Debug mode flag
-let debugMode = false;
+// Log levels in increasing verbosity
+export type LogLevel = 'error' | 'warn' | 'info' | 'debug';
+
+const LOG_LEVEL_ORDER: Record<LogLevel, number> = {
+  error: 0,
+  warn: 1,
+  info: 2,
+  debug: 3,
+};
+
+// Current log level (debug mode is simply the 'debug' level)
+let logLevel: LogLevel = 'info';
 
 // File handle for log file
 let logFileHandle: fs.WriteStream | null = null;
@@ -21,7 +32,7 @@ const ANSI_PATTERN = /\x1b\[[0-9;]*m/g;
  * Initialize the logger - creates log directory and file
  */
 export function initLogger(debug: boolean = false): void {
-  debugMode = debug;
+  logLevel = debug ? 'debug' : 'info';
 
   try {
     // Ensure log directory exists
@@ -82,9 +93,13 @@ function formatMessage(
     })
     .join(' ');
 
+  // Lines logged while handling an API request are tagged with its ID
+  const requestId = getRequestId();
+  const tag = requestId ? `[${module}] [req:${requestId}]` : `[${module}]`;
+
   // Console format with colors
   let consoleFormat: string;
-  const moduleColor = chalk.cyan(`[${module}]`);
+  const moduleColor = chalk.cyan(tag);
   const timestampColor = chalk.gray(timestamp);
 
   switch (level) {
@@ -102,7 +117,7 @@ function formatMessage(
   }
 
   // File format (no colors)
-  const fileFormat = `${timestamp} ${level.padEnd(5)} [${module}] ${message}`;
+  const fileFormat = `${timestamp} ${level.padEnd(5)} ${tag} ${message}`;
 
   return { console: consoleFormat, file: fileFormat };
 }
@@ -126,14 +141,44 @@ function writeToFile(message: string): void {
  * Enable or disable debug mode
  */
 export function setDebugMode(enabled: boolean): void {
-  debugMode = enabled;
+  logLevel = enabled ? 'debug' : 'info';
+}
+
+/**
+ * Set the minimum log level. Errors are always logged.
+ */
+export function setLogLevel(level: LogLevel): void {
+  logLevel = level;
+}
+
+/**
+ * Get the current log level
+ */
+export function getLogLevel(): LogLevel {
+  return logLevel;
+}
+
+/**
+ * Check whether a log level is valid
+ */
+export function isLogLevel(value: unknown): value is LogLevel {
+  return typeof value === 'string' && Object.prototype.hasOwnProperty.call(LOG_LEVEL_ORDER, value);
+}
+
+/**
+ * Check whether messages at the given level should be emitted
+ */
+function isLevelEnabled(level: LogLevel): boolean {
+  return LOG_LEVEL_ORDER[level] <= LOG_LEVEL_ORDER[logLevel];
 }
 
 /**
  * Log from a specific module (used by client-side API)
  */
 export function logFromModule(level: string, module: string, args: unknown[]): void {
-  if (level === 'DEBUG' && !debugMode) return;
+  if (level === 'DEBUG' && !isLevelEnabled('debug')) return;
+  if (level === 'WARN' && !isLevelEnabled('warn')) return;
+  if (level === 'LOG' && !isLevelEnabled('info')) return;
 
   const { console: consoleMsg, file: fileMsg } = formatMessage(level, module, args);
 
@@ -160,11 +205,13 @@ export function logFromModule(level: string, module: string, args: unknown[]): v
 export function createLogger(moduleName: string) {
   return {
     log: (...args: unknown[]) => {
+      if (!isLevelEnabled('info')) return;
       const { console: consoleMsg, file: fileMsg } = formatMessage('LOG', moduleName, args);
       console.log(consoleMsg);
       writeToFile(fileMsg);
     },
     warn: (...args: unknown[]) => {
+      if (!isLevelEnabled('warn')) return;
       const { console: consoleMsg, file: fileMsg } = formatMessage('WARN', moduleName, args);
       console.warn(consoleMsg);
       writeToFile(fileMsg);
@@ -175,7 +222,7 @@ export function createLogger(moduleName: string) {
       writeToFile(fileMsg);
     },
     debug: (...args: unknown[]) => {
-      if (debugMode) {
+      if (isLevelEnabled('debug')) {
         const { console: consoleMsg, file: fileMsg } = formatMessage('DEBUG', moduleName, args);
         console.log(consoleMsg);
         writeToFile(fileMsg);
@@ -0,0 +1,28 @@
+import * as os from 'os';
+import * as path from 'path';
+
+/**
+ * Resolve a user-supplied path: `~/` is expanded to the home directory and
+ * relative paths are resolved against `defaultPath`. Empty input yields
+ * `defaultPath`. `homeDir` is the server user's home unless given (e.g. the
+ * account a session runs as).
+ */
+export function resolvePath(
+  inputPath: string,
+  defaultPath: string,
+  homeDir = os.homedir()
+): string {
+  if (!inputPath || inputPath.trim() === '') {
+    return defaultPath;
+  }
+
+  if (inputPath.startsWith('~/')) {
+    return path.join(homeDir, inputPath.slice(2));
+  }
+
+  if (!path.isAbsolute(inputPath)) {
+    return path.join(defaultPath, inputPath);
+  }
+
+  return inputPath;
+}
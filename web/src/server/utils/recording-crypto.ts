@@ -0,0 +1,340 @@
+/**
+ * Recording encryption - AES-256-GCM encryption of stream files at rest
+ *
+ * Every line of a cast file is encrypted on its own, so recordings can still be
+ * appended and tailed line by line:
+ *
+ *   !vtenc1:<keyId>:<base64(iv | ciphertext | tag)>
+ *
+ * The key ID names the key the line was encrypted with, which lets old keys
+ * stay in the keyring for reading while new lines use the current key.
+ * Plaintext lines (recordings from before encryption was enabled) are read
+ * as they are.
+ */
+
+import { createCipheriv, createDecipheriv, createHash, randomBytes } from 'crypto';
+import * as fs from 'fs';
+import * as readline from 'readline';
+import { createLogger } from './logger.js';
+import { type S3Credentials, signRequest } from './s3-client.js';
+
+const logger = createLogger('recording-crypto');
+
+export const ENCRYPTED_LINE_PREFIX = '!vtenc1:';
+
+const KEY_BYTES = 32;
+const IV_BYTES = 12;
+const TAG_BYTES = 16;
+const KEY_ID_PATTERN = /^[A-Za-z0-9._-]{1,32}$/;
+const KMS_TIMEOUT_MS = 10000;
+
+export interface RecordingKey {
+  id: string;
+  key: Buffer;
+}
+
+/**
+ * A key as configured: the key itself, or a data key encrypted with AWS KMS
+ */
+export type RecordingKeySpec = { id: string; key: Buffer } | { id: string; kmsCiphertext: string };
+
+export class RecordingCipher {
+  private readonly keys = new Map<string, Buffer>();
+  readonly currentKeyId: string;
+
+  /**
+   * The first key encrypts new lines; all keys decrypt
+   */
+  constructor(keys: RecordingKey[]) {
+    if (keys.length === 0) {
+      throw new Error('At least one recording key is required');
+    }
+    for (const { id, key } of keys) {
+      if (key.length !== KEY_BYTES) {
+        throw new Error(`Recording key ${id} must be ${KEY_BYTES} bytes`);
+      }
+      if (this.keys.has(id)) {
+        throw new Error(`Duplicate recording key ${id}`);
+      }
+      this.keys.set(id, key);
+    }
+    this.currentKeyId = keys[0].id;
+  }
+
+  hasKey(id: string): boolean {
+    return this.keys.has(id);
+  }
+
+  encrypt(line: string): string {
+    const key = this.keys.get(this.currentKeyId) as Buffer;
+    const iv = randomBytes(IV_BYTES);
+    const cipher = createCipheriv('aes-256-gcm', key, iv);
+    const ciphertext = Buffer.concat([cipher.update(line, 'utf8'), cipher.final()]);
+    const payload = Buffer.concat([iv, ciphertext, cipher.getAuthTag()]);
+    return `${ENCRYPTED_LINE_PREFIX}${this.currentKeyId}:${payload.toString('base64')}`;
+  }
+
+  /**
+   * Decrypt an encrypted line; throws if its key is unknown or it was altered
+   */
+  decrypt(line: string): string {
+    const { keyId, payload } = parseEncryptedLine(line);
+    const key = this.keys.get(keyId);
+    if (!key) {
+      throw new Error(`Unknown recording key ${keyId}`);
+    }
+    if (payload.length < IV_BYTES + TAG_BYTES) {
+      throw new Error('Encrypted line is truncated');
+    }
+    const decipher = createDecipheriv('aes-256-gcm', key, payload.subarray(0, IV_BYTES));
+    decipher.setAuthTag(payload.subarray(payload.length - TAG_BYTES));
+    return Buffer.concat([
+      decipher.update(payload.subarray(IV_BYTES, payload.length - TAG_BYTES)),
+      decipher.final(),
+    ]).toString('utf8');
+  }
+}
+
+function parseEncryptedLine(line: string): { keyId: string; payload: Buffer } {
+  const rest = line.slice(ENCRYPTED_LINE_PREFIX.length);
+  const separator = rest.indexOf(':');
+  if (separator <= 0) {
+    throw new Error('Malformed encrypted line');
+  }
+  return {
+    keyId: rest.slice(0, separator),
+    payload: Buffer.from(rest.slice(separator + 1), 'base64'),
+  };
+}
+
+export function isEncryptedLine(line: string): boolean {
+  return line.startsWith(ENCRYPTED_LINE_PREFIX);
+}
+
+let activeCipher: RecordingCipher | null = null;
+// Keys already reported as missing, to log once per key instead of per line
+const reportedKeys = new Set<string>();
+
+/**
+ * Encrypt recordings written from now on (null writes plaintext)
+ */
+export function setRecordingCipher(cipher: RecordingCipher | null): void {
+  activeCipher = cipher;
+  reportedKeys.clear();
+}
+
+export function getRecordingCipher(): RecordingCipher | null {
+  return activeCipher;
+}
+
+/**
+ * The form a cast line is stored in: encrypted if encryption is enabled
+ */
+export function encodeCastLine(line: string): string {
+  return activeCipher ? activeCipher.encrypt(line) : line;
+}
+
+/**
+ * The plaintext of a stored cast line. Lines that cannot be decrypted are
+ * returned unchanged, so readers skip them like any other invalid line.
+ */
+export function decodeCastLine(line: string): string {
+  if (!isEncryptedLine(line)) return line;
+  if (!activeCipher) {
+    if (!reportedKeys.has('')) {
+      reportedKeys.add('');
+      logger.warn('found encrypted recording lines but no recording keys are configured');
+    }
+    return line;
+  }
+  try {
+    return activeCipher.decrypt(line);
+  } catch (error) {
+    const keyId = line.slice(ENCRYPTED_LINE_PREFIX.length).split(':', 1)[0];
+    if (!reportedKeys.has(keyId)) {
+      reportedKeys.add(keyId);
+      logger.warn(`failed to decrypt recording line (key ${keyId}):`, error);
+    }
+    return line;
+  }
+}
+
+/**
+ * Parse recording keys: entries of `<id>:<base64 key>` or
+ * `<id>:kms:<base64 ciphertext blob>`, separated by commas or newlines.
+ * Lines starting with # are ignored.
+ */
+export function parseRecordingKeys(value: string): RecordingKeySpec[] {
+  const specs: RecordingKeySpec[] = [];
+  for (const raw of value.split(/[,\n]/)) {
+    const entry = raw.trim();
+    if (!entry || entry.startsWith('#')) continue;
+    const separator = entry.indexOf(':');
+    const id = entry.slice(0, separator);
+    if (separator <= 0 || !KEY_ID_PATTERN.test(id)) {
+      throw new Error(`Invalid recording key ID in "${id || entry.slice(0, 8)}..."`);
+    }
+    const material = entry.slice(separator + 1);
+    if (material.startsWith('kms:')) {
+      specs.push({ id, kmsCiphertext: material.slice(4) });
+      continue;
+    }
+    const key = Buffer.from(material, 'base64');
+    if (key.length !== KEY_BYTES) {
+      throw new Error(`Recording key ${id} must be ${KEY_BYTES} base64-encoded bytes`);
+    }
+    specs.push({ id, key });
+  }
+  return specs;
+}
+
+export interface KmsOptions {
+  region: string;
+  credentials: S3Credentials;
+  // Custom endpoint (e.g. LocalStack); AWS KMS if unset
+  endpoint?: string;
+}
+
+/**
+ * Decrypt a data key with AWS KMS (TrentService.Decrypt)
+ */
+export async function decryptKmsDataKey(
+  ciphertextBlob: string,
+  options: KmsOptions
+): Promise<Buffer> {
+  const url = new URL(options.endpoint || `https://kms.${options.region}.amazonaws.com/`);
+  const body = JSON.stringify({ CiphertextBlob: ciphertextBlob });
+  const signed = signRequest(
+    {
+      method: 'POST',
+      url,
+      headers: {
+        'content-type': 'application/x-amz-json-1.1',
+        'x-amz-target': 'TrentService.Decrypt',
+      },
+      payloadHash: createHash('sha256').update(body).digest('hex'),
+    },
+    options.credentials,
+    options.region,
+    undefined,
+    'kms'
+  );
+  // The host header is set by fetch itself
+  const { host: _host, ...headers } = signed.headers;
+  const response = await fetch(url, {
+    method: 'POST',
+    headers,
+    body,
+    signal: AbortSignal.timeout(KMS_TIMEOUT_MS),
+  });
+  const result = (await response.json().catch(() => ({}))) as {
+    Plaintext?: string;
+    __type?: string;
+    message?: string;
+  };
+  if (!response.ok || !result.Plaintext) {
+    const reason = result.__type?.split('#').pop() ?? `HTTP ${response.status}`;
+    throw new Error(`KMS decrypt failed: ${reason}${result.message ? ` ${result.message}` : ''}`);
+  }
+  return Buffer.from(result.Plaintext, 'base64');
+}
+
+/**
+ * Resolve configured keys into a cipher, decrypting KMS data keys
+ */
+export async function loadRecordingCipher(
+  specs: RecordingKeySpec[],
+  kms: KmsOptions
+): Promise<RecordingCipher> {
+  const keys: RecordingKey[] = [];
+  for (const spec of specs) {
+    if ('key' in spec) {
+      keys.push(spec);
+    } else {
+      keys.push({ id: spec.id, key: await decryptKmsDataKey(spec.kmsCiphertext, kms) });
+      logger.debug(`decrypted recording key ${spec.id} with kms`);
+    }
+  }
+  return new RecordingCipher(keys);
+}
+
+/**
+ * Enable recording encryption with the keys from the key file or, if none is
+ * given, VIBETUNNEL_RECORDING_KEY_FILE or VIBETUNNEL_RECORDING_KEYS. KMS data
+ * keys are decrypted with the AWS credentials from the environment. Returns
+ * null (and writes plaintext) if no keys are configured.
+ */
+export async function configureRecordingEncryption(
+  keyFile: string | null = process.env.VIBETUNNEL_RECORDING_KEY_FILE || null
+): Promise<RecordingCipher | null> {
+  const value = keyFile
+    ? await fs.promises.readFile(keyFile, 'utf8')
+    : process.env.VIBETUNNEL_RECORDING_KEYS;
+  const specs = value ? parseRecordingKeys(value) : [];
+  if (specs.length === 0) {
+    setRecordingCipher(null);
+    return null;
+  }
+
+  const cipher = await loadRecordingCipher(specs, {
+    region: process.env.AWS_REGION || process.env.AWS_DEFAULT_REGION || 'us-east-1',
+    credentials: {
+      accessKeyId: process.env.AWS_ACCESS_KEY_ID || '',
+      secretAccessKey: process.env.AWS_SECRET_ACCESS_KEY || '',
+      sessionToken: process.env.AWS_SESSION_TOKEN,
+    },
+    endpoint: process.env.VIBETUNNEL_KMS_ENDPOINT,
+  });
+  setRecordingCipher(cipher);
+  logger.log(`encrypting recordings with key ${cipher.currentKeyId} (${specs.length} keys)`);
+  return cipher;
+}
+
+/**
+ * Re-encrypt a finished recording with the current key, encrypting plaintext
+ * lines as well. The file is replaced atomically; returns false if every line
+ * already used the current key.
+ */
+export async function rekeyRecording(castPath: string): Promise<boolean> {
+  const cipher = activeCipher;
+  if (!cipher) {
+    throw new Error('Recording encryption is not enabled');
+  }
+
+  const currentPrefix = `${ENCRYPTED_LINE_PREFIX}${cipher.currentKeyId}:`;
+  const tempPath = `${castPath}.rekey`;
+  const output = fs.createWriteStream(tempPath, { encoding: 'utf8' });
+  const lines = readline.createInterface({
+    input: fs.createReadStream(castPath, 'utf8'),
+    crlfDelay: Number.POSITIVE_INFINITY,
+  });
+
+  let changed = false;
+  try {
+    for await (const line of lines) {
+      if (!line.trim() || line.startsWith(currentPrefix)) {
+        output.write(`${line}\n`);
+        continue;
+      }
+      // Decrypting throws for unknown keys, so no line is lost to a missing key
+      const plaintext = isEncryptedLine(line) ? cipher.decrypt(line) : line;
+      output.write(`${cipher.encrypt(plaintext)}\n`);
+      changed = true;
+    }
+    await new Promise<void>((resolve, reject) => {
+      output.on('error', reject);
+      output.end(resolve);
+    });
+  } catch (error) {
+    output.destroy();
+    await fs.promises.rm(tempPath, { force: true });
+    throw error;
+  }
+
+  if (!changed) {
+    await fs.promises.rm(tempPath, { force: true });
+    return false;
+  }
+  await fs.promises.rename(tempPath, castPath);
+  return true;
+}
@@ -0,0 +1,46 @@
+import { AsyncLocalStorage } from 'async_hooks';
+
+/**
+ * Per-request context carried through async calls
+ *
+ * The request ID middleware runs each API request inside a context, so
+ * handlers, the PtyManager and services they call can tag log lines and
+ * forwarded requests with the same ID without passing it around.
+ */
+
+export const REQUEST_ID_HEADER = 'X-Request-ID';
+
+// Incoming IDs are reused only if they are short and cannot break log lines
+const REQUEST_ID_PATTERN = /^[A-Za-z0-9._:-]{1,128}$/;
+
+interface RequestContext {
+  requestId: string;
+}
+
+const storage = new AsyncLocalStorage<RequestContext>();
+
+export function isValidRequestId(value: unknown): value is string {
+  return typeof value === 'string' && REQUEST_ID_PATTERN.test(value);
+}
+
+/**
+ * Run fn with requestId as the current request ID
+ */
+export function runWithRequestId<T>(requestId: string, fn: () => T): T {
+  return storage.run({ requestId }, fn);
+}
+
+/**
+ * ID of the request being handled, if any
+ */
+export function getRequestId(): string | undefined {
+  return storage.getStore()?.requestId;
+}
+
+/**
+ * Headers propagating the current request ID to a remote server
+ */
+export function requestIdHeaders(): Record<string, string> {
+  const requestId = getRequestId();
+  return requestId ? { [REQUEST_ID_HEADER]: requestId } : {};
+}
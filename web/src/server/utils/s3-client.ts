@@ -0,0 +1,185 @@
+/**
+ * S3Client - Minimal client for S3-compatible object storage
+ *
+ * Puts and gets single objects with AWS Signature Version 4. Works
+ * with AWS S3 (virtual-hosted buckets) and S3-compatible servers such as
+ * MinIO or R2 (path-style buckets on a custom endpoint).
+ */
+
+import { createHash, createHmac } from 'crypto';
+
+export interface S3Credentials {
+  accessKeyId: string;
+  secretAccessKey: string;
+  sessionToken?: string;
+}
+
+export interface S3ClientOptions {
+  bucket: string;
+  region: string;
+  credentials: S3Credentials;
+  // Custom endpoint (e.g. http://localhost:9000); AWS S3 if unset
+  endpoint?: string;
+}
+
+export interface SignedRequest {
+  method: string;
+  url: URL;
+  headers: Record<string, string>;
+}
+
+const REQUEST_TIMEOUT_MS = 60000;
+
+function sha256Hex(data: string | Buffer): string {
+  return createHash('sha256').update(data).digest('hex');
+}
+
+function hmac(key: string | Buffer, data: string): Buffer {
+  return createHmac('sha256', key).update(data).digest();
+}
+
+/**
+ * URI-encode like SigV4 expects: everything but unreserved characters, and `/`
+ * unless it is part of a query value
+ */
+function encodeRfc3986(value: string, keepSlash: boolean): string {
+  const encoded = encodeURIComponent(value).replace(
+    /[!'()*]/g,
+    (char) => `%${char.charCodeAt(0).toString(16).toUpperCase()}`
+  );
+  return keepSlash ? encoded.replace(/%2F/g, '/') : encoded;
+}
+
+/**
+ * Sign a request with AWS Signature Version 4. `headers` must not include the
+ * signing headers; they are added to the result along with Authorization.
+ * Signs for S3 unless another service (e.g. kms) is given.
+ */
+export function signRequest(
+  request: { method: string; url: URL; headers?: Record<string, string>; payloadHash: string },
+  credentials: S3Credentials,
+  region: string,
+  date: Date = new Date(),
+  service = 's3'
+): SignedRequest {
+  const amzDate = date.toISOString().replace(/[-:]|\.\d{3}/g, '');
+  const day = amzDate.slice(0, 8);
+  const headers: Record<string, string> = {
+    ...request.headers,
+    host: request.url.host,
+    'x-amz-content-sha256': request.payloadHash,
+    'x-amz-date': amzDate,
+  };
+  if (credentials.sessionToken) {
+    headers['x-amz-security-token'] = credentials.sessionToken;
+  }
+
+  const names = Object.keys(headers)
+    .map((name) => name.toLowerCase())
+    .sort();
+  const lowerHeaders = Object.fromEntries(
+    Object.entries(headers).map(([name, value]) => [name.toLowerCase(), value.trim()])
+  );
+  const signedHeaders = names.join(';');
+  const query = Array.from(request.url.searchParams)
+    .map(([key, value]) => `${encodeRfc3986(key, false)}=${encodeRfc3986(value, false)}`)
+    .sort()
+    .join('&');
+  const canonicalRequest = [
+    request.method,
+    encodeRfc3986(decodeURIComponent(request.url.pathname), true),
+    query,
+    ...names.map((name) => `${name}:${lowerHeaders[name]}`),
+    '',
+    signedHeaders,
+    request.payloadHash,
+  ].join('\n');
+
+  const scope = `${day}/${region}/${service}/aws4_request`;
+  const stringToSign = ['AWS4-HMAC-SHA256', amzDate, scope, sha256Hex(canonicalRequest)].join(
+    '\n'
+  );
+  const signingKey = hmac(
+    hmac(hmac(hmac(`AWS4${credentials.secretAccessKey}`, day), region), service),
+    'aws4_request'
+  );
+  const signature = createHmac('sha256', signingKey).update(stringToSign).digest('hex');
+  const credential = `${credentials.accessKeyId}/${scope}`;
+
+  return {
+    method: request.method,
+    url: request.url,
+    headers: {
+      ...headers,
+      Authorization: `AWS4-HMAC-SHA256 Credential=${credential}, SignedHeaders=${signedHeaders}, Signature=${signature}`,
+    },
+  };
+}
+
+export class S3Error extends Error {
+  constructor(
+    message: string,
+    public status: number
+  ) {
+    super(message);
+    this.name = 'S3Error';
+  }
+}
+
+export class S3Client {
+  constructor(private options: S3ClientOptions) {}
+
+  /**
+   * URL of an object: path-style on custom endpoints, virtual-hosted on AWS
+   */
+  objectUrl(key: string): URL {
+    const path = key.split('/').map((segment) => encodeRfc3986(segment, false));
+    if (this.options.endpoint) {
+      const url = new URL(this.options.endpoint);
+      url.pathname = `${url.pathname.replace(/\/$/, '')}/${this.options.bucket}/${path.join('/')}`;
+      return url;
+    }
+    const host = `${this.options.bucket}.s3.${this.options.region}.amazonaws.com`;
+    return new URL(`https://${host}/${path.join('/')}`);
+  }
+
+  async putObject(key: string, body: Buffer, headers: Record<string, string> = {}): Promise<void> {
+    const response = await this.send('PUT', key, body, headers);
+    await response.arrayBuffer();
+  }
+
+  async getObject(key: string): Promise<Buffer> {
+    const response = await this.send('GET', key);
+    return Buffer.from(await response.arrayBuffer());
+  }
+
+  private async send(
+    method: string,
+    key: string,
+    body?: Buffer,
+    headers: Record<string, string> = {}
+  ): Promise<Response> {
+    const signed = signRequest(
+      { method, url: this.objectUrl(key), headers, payloadHash: sha256Hex(body ?? '') },
+      this.options.credentials,
+      this.options.region
+    );
+    // The host header is set by fetch itself
+    const { host: _host, ...requestHeaders } = signed.headers;
+    const response = await fetch(signed.url, {
+      method,
+      headers: requestHeaders,
+      body,
+      signal: AbortSignal.timeout(REQUEST_TIMEOUT_MS),
+    });
+    if (!response.ok) {
+      const text = await response.text().catch(() => '');
+      const code = text.match(/<Code>([^<]+)<\/Code>/)?.[1];
+      throw new S3Error(
+        `${method} ${key} failed: ${response.status}${code ? ` ${code}` : ''}`,
+        response.status
+      );
+    }
+    return response;
+  }
+}
@@ -0,0 +1,54 @@
+import { isValidSessionId } from '../pty/session-id.js';
+
+/**
+ * HQ exposes remote sessions as `<remoteName>/<sessionId>` so that two remotes
+ * producing the same session ID cannot be confused. Remote names may not
+ * contain the separator; local session IDs never do.
+ */
+export const SESSION_NAMESPACE_SEPARATOR = '/';
+
+export function namespaceSessionId(remoteName: string, sessionId: string): string {
+  return `${remoteName}${SESSION_NAMESPACE_SEPARATOR}${sessionId}`;
+}
+
+/**
+ * A federated HQ registers with a higher-level HQ like any remote and re-exports its
+ * namespaced sessions, which the higher HQ namespaces again (`<teamHQ>/<remote>/<id>`).
+ * HQs pass the number of hops along so a registration cycle cannot recurse forever.
+ */
+export const FEDERATION_DEPTH_HEADER = 'x-vibetunnel-federation-depth';
+export const MAX_FEDERATION_DEPTH = 4;
+
+/**
+ * Split a namespaced session ID; returns null for plain IDs
+ */
+export function parseNamespacedSessionId(
+  sessionId: string
+): { remoteName: string; sessionId: string } | null {
+  const index = sessionId.indexOf(SESSION_NAMESPACE_SEPARATOR);
+  if (index <= 0 || index === sessionId.length - 1) {
+    return null;
+  }
+  return { remoteName: sessionId.slice(0, index), sessionId: sessionId.slice(index + 1) };
+}
+
+/**
+ * The ID a remote knows a session by (plain IDs are returned unchanged)
+ */
+export function toRemoteSessionId(sessionId: string): string {
+  return parseNamespacedSessionId(sessionId)?.sessionId ?? sessionId;
+}
+
+/**
+ * Whether a namespaced ID names a remote session: non-empty remote names and
+ * a valid session ID at the end. Only HQs accept these; they never name
+ * local directories.
+ */
+export function isValidNamespacedSessionId(sessionId: string): boolean {
+  const parts = sessionId.split(SESSION_NAMESPACE_SEPARATOR);
+  return (
+    parts.length > 1 &&
+    isValidSessionId(parts[parts.length - 1]) &&
+    parts.slice(0, -1).every((part) => part.trim() !== '' && part !== '.' && part !== '..')
+  );
+}
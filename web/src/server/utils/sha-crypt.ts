@@ -0,0 +1,139 @@
+import { createHash, timingSafeEqual } from 'crypto';
+
+/**
+ * SHA-crypt password hashes ($5$ SHA-256 and $6$ SHA-512) as found in
+ * /etc/shadow, following Ulrich Drepper's "Unix crypt using SHA-256 and
+ * SHA-512" specification
+ */
+
+const ITOA64 = './0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz';
+const DEFAULT_ROUNDS = 5000;
+const MIN_ROUNDS = 1000;
+const MAX_ROUNDS = 999999999;
+const MAX_SALT_LENGTH = 16;
+
+// Byte triples (most significant first) written as four characters each
+const SHA256_ORDER = [
+  [0, 10, 20],
+  [21, 1, 11],
+  [12, 22, 2],
+  [3, 13, 23],
+  [24, 4, 14],
+  [15, 25, 5],
+  [6, 16, 26],
+  [27, 7, 17],
+  [18, 28, 8],
+  [9, 19, 29],
+];
+const SHA512_ORDER = [
+  [0, 21, 42],
+  [22, 43, 1],
+  [44, 2, 23],
+  [3, 24, 45],
+  [25, 46, 4],
+  [47, 5, 26],
+  [6, 27, 48],
+  [28, 49, 7],
+  [50, 8, 29],
+  [9, 30, 51],
+  [31, 52, 10],
+  [53, 11, 32],
+  [12, 33, 54],
+  [34, 55, 13],
+  [56, 14, 35],
+  [15, 36, 57],
+  [37, 58, 16],
+  [59, 17, 38],
+  [18, 39, 60],
+  [40, 61, 19],
+  [62, 20, 41],
+];
+
+const SCHEMES = {
+  '5': { algorithm: 'sha256', order: SHA256_ORDER },
+  '6': { algorithm: 'sha512', order: SHA512_ORDER },
+} as const;
+
+function encode64(value: number, length: number): string {
+  let output = '';
+  for (let i = 0; i < length; i++) {
+    output += ITOA64[value & 0x3f];
+    value >>>= 6;
+  }
+  return output;
+}
+
+// The digest repeated (and cut) to the given length
+function stretch(digest: Buffer, length: number): Buffer {
+  const output = Buffer.alloc(length);
+  for (let offset = 0; offset < length; offset += digest.length) {
+    digest.copy(output, offset, 0, Math.min(digest.length, length - offset));
+  }
+  return output;
+}
+
+/**
+ * Hash a password with the scheme, rounds and salt of a setting such as
+ * `$6$salt` or `$5$rounds=10000$salt` (anything after the salt is ignored)
+ */
+export function shaCrypt(password: string, setting: string): string {
+  const match = /^\$([56])\$(?:rounds=(\d+)\$)?([^$]*)/.exec(setting);
+  if (!match) {
+    throw new Error('Not a SHA-crypt setting');
+  }
+  const { algorithm, order } = SCHEMES[match[1] as keyof typeof SCHEMES];
+  const customRounds = match[2] !== undefined;
+  const rounds = customRounds
+    ? Math.min(Math.max(Number(match[2]), MIN_ROUNDS), MAX_ROUNDS)
+    : DEFAULT_ROUNDS;
+  const salt = Buffer.from(match[3].slice(0, MAX_SALT_LENGTH));
+  const key = Buffer.from(password);
+  const hash = (...parts: Buffer[]) => {
+    const context = createHash(algorithm);
+    for (const part of parts) context.update(part);
+    return context.digest();
+  };
+
+  const alternate = hash(key, salt, key);
+  const digestA = createHash(algorithm).update(key).update(salt);
+  digestA.update(stretch(alternate, key.length));
+  for (let length = key.length; length > 0; length >>= 1) {
+    digestA.update(length & 1 ? alternate : key);
+  }
+  let result = digestA.digest();
+
+  const keySequence = stretch(hash(...Array(key.length).fill(key)), key.length);
+  const saltSequence = stretch(hash(...Array(16 + result[0]).fill(salt)), salt.length);
+
+  for (let round = 0; round < rounds; round++) {
+    const context = createHash(algorithm);
+    context.update(round & 1 ? keySequence : result);
+    if (round % 3) context.update(saltSequence);
+    if (round % 7) context.update(keySequence);
+    context.update(round & 1 ? result : keySequence);
+    result = context.digest();
+  }
+
+  let encoded = '';
+  for (const [a, b, c] of order) {
+    encoded += encode64((result[a] << 16) | (result[b] << 8) | result[c], 4);
+  }
+  encoded +=
+    algorithm === 'sha256'
+      ? encode64((result[31] << 8) | result[30], 3)
+      : encode64(result[63], 2);
+
+  const roundsPart = customRounds ? `rounds=${rounds}$` : '';
+  return `$${match[1]}$${roundsPart}${salt.toString()}$${encoded}`;
+}
+
+/**
+ * Check a password against a crypt(3) hash. Returns null for schemes other
+ * than SHA-crypt (e.g. yescrypt, bcrypt), which need PAM to verify.
+ */
+export function verifyCryptHash(password: string, hash: string): boolean | null {
+  if (!/^\$[56]\$/.test(hash)) return null;
+  const expected = Buffer.from(hash);
+  const actual = Buffer.from(shaCrypt(password, hash));
+  return actual.length === expected.length && timingSafeEqual(actual, expected);
+}
@@ -0,0 +1,58 @@
+import type { BufferCell } from '../../shared/terminal-text-formatter.js';
+
+// Attribute bits as extracted by TerminalManager
+const ATTRIBUTE_SGR: Array<[number, number]> = [
+  [0x01, 1], // bold
+  [0x02, 3], // italic
+  [0x04, 4], // underline
+  [0x08, 2], // dim
+  [0x10, 7], // inverse
+  [0x20, 8], // invisible
+  [0x40, 9], // strikethrough
+];
+
+function colorSgr(color: number, base: number, brightBase: number, extended: number): string {
+  if (color < 8) return String(base + color);
+  if (color < 16) return String(brightBase + color - 8);
+  if (color <= 255) return `${extended};5;${color}`;
+  return `${extended};2;${(color >> 16) & 0xff};${(color >> 8) & 0xff};${color & 0xff}`;
+}
+
+function cellSgr(cell: BufferCell): string {
+  const codes = ['0'];
+  if (cell.attributes) {
+    for (const [bit, code] of ATTRIBUTE_SGR) {
+      if (cell.attributes & bit) codes.push(String(code));
+    }
+  }
+  if (cell.fg !== undefined) codes.push(colorSgr(cell.fg, 30, 90, 38));
+  if (cell.bg !== undefined) codes.push(colorSgr(cell.bg, 40, 100, 48));
+  return `\x1b[${codes.join(';')}m`;
+}
+
+/**
+ * Render a buffer snapshot as terminal output that redraws the screen from a
+ * blank terminal: clear, rows with their styles, then the cursor position.
+ */
+export function renderSnapshotAnsi(snapshot: {
+  cells: BufferCell[][];
+  cursorX: number;
+  cursorY: number;
+}): string {
+  let output = '\x1b[0m\x1b[H\x1b[2J';
+  let currentSgr = '\x1b[0m';
+
+  snapshot.cells.forEach((row, index) => {
+    if (index > 0) output += '\r\n';
+    for (const cell of row) {
+      const sgr = cellSgr(cell);
+      if (sgr !== currentSgr) {
+        output += sgr;
+        currentSgr = sgr;
+      }
+      output += cell.char;
+    }
+  });
+
+  return `${output}\x1b[0m\x1b[${snapshot.cursorY + 1};${snapshot.cursorX + 1}H`;
+}
@@ -0,0 +1,464 @@
+import { deflateSync } from 'zlib';
+import type { BufferCell } from '../../shared/terminal-text-formatter.js';
+
+/**
+ * Render buffer snapshots as images (SVG or PNG) for previews outside the web
+ * client: chat messages, dashboards, notification payloads.
+ *
+ * Colors follow the web terminal's theme. SVG leaves glyphs to the viewer's
+ * monospace font; PNG is rasterized here with a built-in 8x8 bitmap font
+ * (printable ASCII, box drawing and block elements; other characters are drawn
+ * as boxes), so no native image libraries are needed.
+ */
+
+export interface ImageSnapshot {
+  cols: number;
+  rows: number;
+  cells: BufferCell[][];
+  cursorX: number;
+  cursorY: number;
+}
+
+export interface SnapshotImageOptions {
+  // Draw the cursor (default true)
+  cursor?: boolean;
+  // Integer pixel scale of PNG cells (8x16 pixels at scale 1)
+  scale?: number;
+}
+
+// Same theme as the web terminal (client/components/terminal.ts)
+const THEME = { background: 0x1e1e1e, foreground: 0xd4d4d4, cursor: 0x00ff00 };
+const ANSI_COLORS = [
+  0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5, 0x7f7f7f,
+  0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+];
+const CUBE_LEVELS = [0, 95, 135, 175, 215, 255];
+
+// Attribute bits as extracted by TerminalManager
+const BOLD = 0x01;
+const ITALIC = 0x02;
+const UNDERLINE = 0x04;
+const DIM = 0x08;
+const INVERSE = 0x10;
+const INVISIBLE = 0x20;
+const STRIKETHROUGH = 0x40;
+
+function paletteColor(index: number): number {
+  if (index < 16) return ANSI_COLORS[index];
+  if (index < 232) {
+    const i = index - 16;
+    const r = CUBE_LEVELS[Math.floor(i / 36)];
+    const g = CUBE_LEVELS[Math.floor(i / 6) % 6];
+    const b = CUBE_LEVELS[i % 6];
+    return (r << 16) | (g << 8) | b;
+  }
+  const level = 8 + (index - 232) * 10;
+  return (level << 16) | (level << 8) | level;
+}
+
+// Mix two colors, taking `amount` (0-1) of the second
+function blend(from: number, to: number, amount: number): number {
+  let result = 0;
+  for (const shift of [16, 8, 0]) {
+    const a = (from >> shift) & 0xff;
+    const b = (to >> shift) & 0xff;
+    result |= Math.round(a + (b - a) * amount) << shift;
+  }
+  return result;
+}
+
+/**
+ * Foreground and background of a cell as RGB, with bold-as-bright, dim,
+ * inverse and invisible applied
+ */
+function cellColors(cell: BufferCell): { fg: number; bg: number } {
+  const attributes = cell.attributes ?? 0;
+  let fgIndex = cell.fg;
+  if (attributes & BOLD && fgIndex !== undefined && fgIndex < 8) fgIndex += 8;
+
+  const resolve = (color: number | undefined, fallback: number) => {
+    if (color === undefined) return fallback;
+    return color <= 255 ? paletteColor(color) : color & 0xffffff;
+  };
+  let fg = resolve(fgIndex, THEME.foreground);
+  let bg = resolve(cell.bg, THEME.background);
+
+  if (attributes & INVERSE) [fg, bg] = [bg, fg];
+  if (attributes & DIM) fg = blend(fg, bg, 0.5);
+  if (attributes & INVISIBLE) fg = bg;
+  return { fg, bg };
+}
+
+interface PlacedCell {
+  cell: BufferCell;
+  col: number;
+  fg: number;
+  bg: number;
+}
+
+/**
+ * Cells of each row with their columns and colors; the cursor cell gets the
+ * cursor colors, and cursors past the end of a trimmed row get a blank cell
+ */
+function layoutRows(snapshot: ImageSnapshot, showCursor: boolean): PlacedCell[][] {
+  return Array.from({ length: snapshot.rows }, (_, rowIndex) => {
+    const placed: PlacedCell[] = [];
+    let col = 0;
+    for (const cell of snapshot.cells[rowIndex] ?? []) {
+      if (col >= snapshot.cols) break;
+      placed.push({ cell, col, ...cellColors(cell) });
+      col += Math.max(1, cell.width);
+    }
+
+    if (showCursor && rowIndex === snapshot.cursorY && snapshot.cursorX < snapshot.cols) {
+      let cursorCell = placed.find((entry) => entry.col === snapshot.cursorX);
+      if (!cursorCell) {
+        cursorCell = { cell: { char: ' ', width: 1 }, col: snapshot.cursorX, fg: 0, bg: 0 };
+        placed.push(cursorCell);
+      }
+      cursorCell.fg = THEME.background;
+      cursorCell.bg = THEME.cursor;
+    }
+    return placed;
+  });
+}
+
+function hexColor(color: number): string {
+  return `#${color.toString(16).padStart(6, '0')}`;
+}
+
+function escapeXml(text: string): string {
+  return text
+    .replace(/&/g, '&amp;')
+    .replace(/</g, '&lt;')
+    .replace(/>/g, '&gt;')
+    .replace(/"/g, '&quot;');
+}
+
+// SVG cell metrics for a 14px monospace font
+const SVG_FONT_SIZE = 14;
+const SVG_CELL_WIDTH = 8.4;
+const SVG_CELL_HEIGHT = 17;
+const SVG_BASELINE = 13;
+
+/**
+ * Render a snapshot as an SVG document. Runs of cells with the same style
+ * become one text element.
+ */
+export function renderSnapshotSvg(
+  snapshot: ImageSnapshot,
+  options: SnapshotImageOptions = {}
+): string {
+  const width = snapshot.cols * SVG_CELL_WIDTH;
+  const height = snapshot.rows * SVG_CELL_HEIGHT;
+  const backgrounds: string[] = [];
+  const texts: string[] = [];
+
+  layoutRows(snapshot, options.cursor !== false).forEach((row, rowIndex) => {
+    const y = rowIndex * SVG_CELL_HEIGHT;
+    row.sort((a, b) => a.col - b.col);
+
+    // Adjacent cells with the same background share a rect
+    const fills: Array<{ col: number; end: number; bg: number }> = [];
+    for (const { cell, col, bg } of row) {
+      if (bg === THEME.background) continue;
+      const end = col + Math.max(1, cell.width);
+      const last = fills[fills.length - 1];
+      if (last && last.bg === bg && last.end === col) {
+        last.end = end;
+      } else {
+        fills.push({ col, end, bg });
+      }
+    }
+    for (const { col, end, bg } of fills) {
+      const x = (col * SVG_CELL_WIDTH).toFixed(1);
+      const fillWidth = ((end - col) * SVG_CELL_WIDTH).toFixed(1);
+      backgrounds.push(
+        `<rect x="${x}" y="${y}" width="${fillWidth}" height="${SVG_CELL_HEIGHT}" fill="${hexColor(bg)}"/>`
+      );
+    }
+
+    // Wide characters end a run, so the next cell starts at its own column
+    const runs: Array<{ col: number; text: string; style: string }> = [];
+    let nextCol = 0;
+    for (const { cell, col, fg } of row) {
+      const attributes = cell.attributes ?? 0;
+      let style = fg === THEME.foreground ? '' : ` fill="${hexColor(fg)}"`;
+      if (attributes & BOLD) style += ' font-weight="bold"';
+      if (attributes & ITALIC) style += ' font-style="italic"';
+      const decorations = [
+        attributes & UNDERLINE ? 'underline' : '',
+        attributes & STRIKETHROUGH ? 'line-through' : '',
+      ].filter(Boolean);
+      if (decorations.length) style += ` text-decoration="${decorations.join(' ')}"`;
+
+      const last = runs[runs.length - 1];
+      if (last && last.style === style && col === nextCol) {
+        last.text += cell.char;
+      } else {
+        runs.push({ col, text: cell.char, style });
+      }
+      nextCol = col + 1;
+    }
+
+    for (const run of runs) {
+      if (!run.text.trim()) continue;
+      const x = (run.col * SVG_CELL_WIDTH).toFixed(1);
+      const text = escapeXml(run.text);
+      texts.push(`<text x="${x}" y="${y + SVG_BASELINE}"${run.style}>${text}</text>`);
+    }
+  });
+
+  return [
+    `<svg xmlns="http://www.w3.org/2000/svg" width="${width.toFixed(0)}" height="${height}" viewBox="0 0 ${width.toFixed(1)} ${height}">`,
+    `<rect width="100%" height="100%" fill="${hexColor(THEME.background)}"/>`,
+    ...backgrounds,
+    `<g font-family="Menlo, Consolas, 'DejaVu Sans Mono', monospace" font-size="${SVG_FONT_SIZE}" fill="${hexColor(THEME.foreground)}" xml:space="preserve">`,
+    ...texts,
+    '</g>',
+    '</svg>',
+  ].join('\n');
+}
+
+// 8x8 glyphs of printable ASCII (0x20-0x7e): 16 hex digits each, one byte per row with
+// bit 0 leftmost
+const FONT_8X8 = [
+  '0000000000000000183c3c1818001800363600000000000036367f367f363600',
+  '0c3e031e301f0c00006333180c6663001c361c6e3b336e000606030000000000',
+  '180c0606060c1800060c1818180c060000663cff3c660000000c0c3f0c0c0000',
+  '00000000000c0c060000003f0000000000000000000c0c006030180c06030100',
+  '3e63737b6f673e000c0e0c0c0c0c3f001e33301c06333f001e33301c30331e00',
+  '383c36337f3078003f031f3030331e001c06031f33331e003f3330180c0c0c00',
+  '1e33331e33331e001e33333e30180e00000c0c00000c0c00000c0c00000c0c06',
+  '180c0603060c180000003f00003f0000060c1830180c06001e3330180c000c00',
+  '3e637b7b7b031e000c1e33333f3333003f66663e66663f003c66030303663c00',
+  '1f36666666361f007f46161e16467f007f46161e16060f003c66030373667c00',
+  '3333333f333333001e0c0c0c0c0c1e007830303033331e006766361e36666700',
+  '0f06060646667f0063777f7f6b63630063676f7b736363001c36636363361c00',
+  '3f66663e06060f001e3333333b1e38003f66663e366667001e33070e38331e00',
+  '3f2d0c0c0c0c1e003333333333333f0033333333331e0c006363636b7f776300',
+  '6363361c1c3663003333331e0c0c1e007f6331184c667f001e06060606061e00',
+  '03060c18306040001e18181818181e00081c36630000000000000000000000ff',
+  '0c0c18000000000000001e303e336e000706063e66663b0000001e3303331e00',
+  '3830303e33336e0000001e333f031e001c36060f06060f0000006e33333e301f',
+  '0706366e666667000c000e0c0c0c1e00300030303033331e0706663616366700',
+  '0e0c0c0c0c0c1e000000337f7f6b630000001f333333330000001e3333331e00',
+  '00003b66663e060f00006e33333e307800003b6e66060f0000003e031e301f00',
+  '080c3e0c0c2c18000000333333336e0000003333331e0c000000636b7f7f3600',
+  '000063361c36630000003333333e301f00003f190c263f00380c0c070c0c3800',
+  '1818180018181800070c0c380c0c07006e3b000000000000',
+].join('');
+
+// Box drawing characters by the line segments they have: (l)eft, (r)ight, (u)p, (d)own.
+// Heavy and double lines are drawn as light ones.
+const BOX_SEGMENTS = new Map<string, string>();
+for (const [segments, chars] of Object.entries({
+  lr: '─━═',
+  ud: '│┃║',
+  rd: '┌╭╔',
+  ld: '┐╮╗',
+  ru: '└╰╚',
+  lu: '┘╯╝',
+  udr: '├╠',
+  udl: '┤╣',
+  lrd: '┬╦',
+  lru: '┴╩',
+  lrud: '┼╬',
+})) {
+  for (const char of chars) BOX_SEGMENTS.set(char, segments);
+}
+
+// Block elements as [x0, y0, x1, y1] fractions of the cell
+const BLOCKS: Record<string, [number, number, number, number]> = {
+  '█': [0, 0, 1, 1],
+  '▀': [0, 0, 1, 0.5],
+  '▄': [0, 0.5, 1, 1],
+  '▌': [0, 0, 0.5, 1],
+  '▐': [0.5, 0, 1, 1],
+};
+
+// Shade characters as the share of foreground mixed into the background
+const SHADES: Record<string, number> = { '░': 0.25, '▒': 0.5, '▓': 0.75 };
+
+const PNG_CELL_WIDTH = 8;
+const PNG_CELL_HEIGHT = 16;
+const MAX_SCALE = 4;
+
+class PixelCanvas {
+  readonly data: Buffer;
+
+  constructor(
+    readonly width: number,
+    readonly height: number,
+    background: number
+  ) {
+    this.data = Buffer.alloc(width * height * 3);
+    this.fill(0, 0, width, height, background);
+  }
+
+  fill(x: number, y: number, w: number, h: number, color: number): void {
+    const x1 = Math.min(this.width, x + w);
+    const y1 = Math.min(this.height, y + h);
+    for (let py = Math.max(0, y); py < y1; py++) {
+      for (let px = Math.max(0, x); px < x1; px++) {
+        const i = (py * this.width + px) * 3;
+        this.data[i] = (color >> 16) & 0xff;
+        this.data[i + 1] = (color >> 8) & 0xff;
+        this.data[i + 2] = color & 0xff;
+      }
+    }
+  }
+}
+
+/**
+ * Draw the glyph of a cell into its box (x, y, w, h) in pixels
+ */
+function drawGlyph(
+  canvas: PixelCanvas,
+  cell: BufferCell,
+  box: { x: number; y: number; w: number; h: number },
+  fg: number,
+  bg: number,
+  scale: number
+): void {
+  const { x, y, w, h } = box;
+  const char = cell.char;
+  const code = char.length === 1 ? char.charCodeAt(0) : -1;
+
+  if (code >= 0x21 && code <= 0x7e) {
+    const glyph = FONT_8X8.slice((code - 0x20) * 16, (code - 0x19) * 16);
+    const bold = ((cell.attributes ?? 0) & BOLD) !== 0;
+    for (let row = 0; row < 8; row++) {
+      const bits = Number.parseInt(glyph.slice(row * 2, row * 2 + 2), 16);
+      for (let bit = 0; bit < 8; bit++) {
+        if (!(bits & (1 << bit))) continue;
+        // Rows are doubled to fit the 8x16 cell; bold smears one pixel right
+        canvas.fill(x + bit * scale, y + row * 2 * scale, (bold ? 2 : 1) * scale, 2 * scale, fg);
+      }
+    }
+    return;
+  }
+
+  const segments = BOX_SEGMENTS.get(char);
+  if (segments) {
+    const cx = x + Math.floor(w / 2);
+    const cy = y + Math.floor(h / 2);
+    if (segments.includes('l')) canvas.fill(x, cy, cx - x + scale, scale, fg);
+    if (segments.includes('r')) canvas.fill(cx, cy, x + w - cx, scale, fg);
+    if (segments.includes('u')) canvas.fill(cx, y, scale, cy - y + scale, fg);
+    if (segments.includes('d')) canvas.fill(cx, cy, scale, y + h - cy, fg);
+    return;
+  }
+
+  const block = BLOCKS[char];
+  if (block) {
+    const [x0, y0, x1, y1] = block;
+    canvas.fill(x + x0 * w, y + y0 * h, (x1 - x0) * w, (y1 - y0) * h, fg);
+    return;
+  }
+
+  const shade = SHADES[char];
+  if (shade !== undefined) {
+    canvas.fill(x, y, w, h, blend(bg, fg, shade));
+    return;
+  }
+
+  if (char.trim()) {
+    // No glyph: draw a box
+    const inset = scale;
+    canvas.fill(x + inset, y + 3 * scale, w - 2 * inset, scale, fg);
+    canvas.fill(x + inset, y + h - 4 * scale, w - 2 * inset, scale, fg);
+    canvas.fill(x + inset, y + 3 * scale, scale, h - 6 * scale, fg);
+    canvas.fill(x + w - 2 * inset, y + 3 * scale, scale, h - 6 * scale, fg);
+  }
+}
+
+/**
+ * Render a snapshot as a PNG image with 8x16 pixel cells (times the scale)
+ */
+export function renderSnapshotPng(
+  snapshot: ImageSnapshot,
+  options: SnapshotImageOptions = {}
+): Buffer {
+  const scale = Math.min(MAX_SCALE, Math.max(1, Math.floor(options.scale ?? 1)));
+  const cellWidth = PNG_CELL_WIDTH * scale;
+  const cellHeight = PNG_CELL_HEIGHT * scale;
+  const canvas = new PixelCanvas(
+    Math.max(1, snapshot.cols * cellWidth),
+    Math.max(1, snapshot.rows * cellHeight),
+    THEME.background
+  );
+
+  layoutRows(snapshot, options.cursor !== false).forEach((row, rowIndex) => {
+    for (const { cell, col, fg, bg } of row) {
+      const box = {
+        x: col * cellWidth,
+        y: rowIndex * cellHeight,
+        w: Math.max(1, cell.width) * cellWidth,
+        h: cellHeight,
+      };
+      if (bg !== THEME.background) canvas.fill(box.x, box.y, box.w, box.h, bg);
+      drawGlyph(canvas, cell, box, fg, bg, scale);
+
+      const attributes = cell.attributes ?? 0;
+      if (attributes & UNDERLINE) canvas.fill(box.x, box.y + 14 * scale, box.w, scale, fg);
+      if (attributes & STRIKETHROUGH) canvas.fill(box.x, box.y + 8 * scale, box.w, scale, fg);
+    }
+  });
+
+  return encodePng(canvas.width, canvas.height, canvas.data);
+}
+
+let crcTable: Uint32Array | null = null;
+
+function crc32(data: Buffer): number {
+  if (!crcTable) {
+    crcTable = new Uint32Array(256);
+    for (let n = 0; n < 256; n++) {
+      let c = n;
+      for (let k = 0; k < 8; k++) {
+        c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
+      }
+      crcTable[n] = c >>> 0;
+    }
+  }
+  let crc = 0xffffffff;
+  for (const byte of data) {
+    crc = crcTable[(crc ^ byte) & 0xff] ^ (crc >>> 8);
+  }
+  return (crc ^ 0xffffffff) >>> 0;
+}
+
+function pngChunk(type: string, data: Buffer): Buffer {
+  const length = Buffer.alloc(4);
+  length.writeUInt32BE(data.length);
+  const typeAndData = Buffer.concat([Buffer.from(type, 'ascii'), data]);
+  const crc = Buffer.alloc(4);
+  crc.writeUInt32BE(crc32(typeAndData));
+  return Buffer.concat([length, typeAndData, crc]);
+}
+
+/**
+ * Encode 8-bit RGB pixels as a PNG file
+ */
+export function encodePng(width: number, height: number, rgb: Buffer): Buffer {
+  const header = Buffer.alloc(13);
+  header.writeUInt32BE(width, 0);
+  header.writeUInt32BE(height, 4);
+  header[8] = 8; // bit depth
+  header[9] = 2; // color type: RGB
+
+  // Every scanline starts with filter type 0 (none)
+  const stride = width * 3;
+  const raw = Buffer.alloc((stride + 1) * height);
+  for (let y = 0; y < height; y++) {
+    rgb.copy(raw, y * (stride + 1) + 1, y * stride, (y + 1) * stride);
+  }
+
+  return Buffer.concat([
+    Buffer.from([0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a]),
+    pngChunk('IHDR', header),
+    pngChunk('IDAT', deflateSync(raw)),
+    pngChunk('IEND', Buffer.alloc(0)),
+  ]);
+}
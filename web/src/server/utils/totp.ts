@@ -0,0 +1,111 @@
+import { createHmac, randomBytes, timingSafeEqual } from 'crypto';
+
+/**
+ * Time-based one-time passwords (RFC 6238) as used by authenticator apps:
+ * HMAC-SHA1, 6 digits, 30 second steps
+ */
+
+const BASE32_ALPHABET = 'ABCDEFGHIJKLMNOPQRSTUVWXYZ234567';
+const SECRET_BYTES = 20;
+const DIGITS = 6;
+export const TOTP_PERIOD_SECONDS = 30;
+// Codes of the neighbouring steps are accepted too, for clock drift
+const DRIFT_STEPS = 1;
+
+export function base32Encode(data: Buffer): string {
+  let bits = 0;
+  let value = 0;
+  let output = '';
+  for (const byte of data) {
+    value = (value << 8) | byte;
+    bits += 8;
+    while (bits >= 5) {
+      output += BASE32_ALPHABET[(value >>> (bits - 5)) & 31];
+      bits -= 5;
+    }
+  }
+  if (bits > 0) {
+    output += BASE32_ALPHABET[(value << (5 - bits)) & 31];
+  }
+  return output;
+}
+
+export function base32Decode(text: string): Buffer {
+  const clean = text.toUpperCase().replace(/[\s=]/g, '');
+  const bytes: number[] = [];
+  let bits = 0;
+  let value = 0;
+  for (const char of clean) {
+    const index = BASE32_ALPHABET.indexOf(char);
+    if (index === -1) {
+      throw new Error(`Invalid base32 character: ${char}`);
+    }
+    value = (value << 5) | index;
+    bits += 5;
+    if (bits >= 8) {
+      bytes.push((value >>> (bits - 8)) & 255);
+      bits -= 8;
+    }
+  }
+  return Buffer.from(bytes);
+}
+
+export function generateTotpSecret(): string {
+  return base32Encode(randomBytes(SECRET_BYTES));
+}
+
+export function totpCounter(time: number = Date.now()): number {
+  return Math.floor(time / 1000 / TOTP_PERIOD_SECONDS);
+}
+
+/**
+ * The code for a time step (HOTP, RFC 4226)
+ */
+export function generateTotp(secret: string, counter: number = totpCounter()): string {
+  const message = Buffer.alloc(8);
+  message.writeBigUInt64BE(BigInt(counter));
+  const digest = createHmac('sha1', base32Decode(secret)).update(message).digest();
+  const offset = digest[digest.length - 1] & 0x0f;
+  const binary = digest.readUInt32BE(offset) & 0x7fffffff;
+  return String(binary % 10 ** DIGITS).padStart(DIGITS, '0');
+}
+
+/**
+ * Check a code against the current time step and its neighbours. Returns the
+ * matching step, or null. Steps up to `usedCounter` are rejected so a code
+ * cannot be used twice.
+ */
+export function verifyTotp(
+  secret: string,
+  code: string,
+  usedCounter = -1,
+  time: number = Date.now()
+): number | null {
+  const normalized = code.replace(/\s/g, '');
+  if (!/^\d{6}$/.test(normalized)) return null;
+
+  const current = totpCounter(time);
+  for (let counter = current - DRIFT_STEPS; counter <= current + DRIFT_STEPS; counter++) {
+    if (counter <= usedCounter) continue;
+    const expected = generateTotp(secret, counter);
+    if (timingSafeEqual(Buffer.from(expected), Buffer.from(normalized))) {
+      return counter;
+    }
+  }
+  return null;
+}
+
+/**
+ * otpauth:// URL for enrolling the secret in an authenticator app (as a QR code)
+ */
+export function totpUri(secret: string, account: string, issuer = 'VibeTunnel'): string {
+  const label = encodeURIComponent(`${issuer}:${account}`);
+  const params = new URLSearchParams({
+    secret,
+    issuer,
+    algorithm: 'SHA1',
+    digits: String(DIGITS),
+    period: String(TOTP_PERIOD_SECONDS),
+  });
+  return `otpauth://totp/${label}?${params}`;
+}
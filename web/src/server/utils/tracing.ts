@@ -0,0 +1,402 @@
+import { AsyncLocalStorage } from 'async_hooks';
+import { randomBytes } from 'crypto';
+import { getFaultInjector } from './fault-injection.js';
+import { createLogger } from './logger.js';
+
+/**
+ * Minimal OpenTelemetry tracing
+ *
+ * Spans are exported with OTLP/HTTP (JSON) to any OpenTelemetry collector and
+ * propagated with the W3C `traceparent` header, so a request from the web
+ * client shows up as one trace spanning HQ, the remote and the PTY. Tracing is
+ * off until configureTracing() is called with an endpoint; until then spans
+ * are not created at all.
+ */
+
+const logger = createLogger('tracing');
+
+export const TRACEPARENT_HEADER = 'traceparent';
+
+// Spans are sent in batches this often, or as soon as a batch is full
+const EXPORT_INTERVAL_MS = 5000;
+const MAX_EXPORT_BATCH = 512;
+// Spans waiting for export; newer ones are dropped beyond this
+const MAX_QUEUED_SPANS = 4096;
+const EXPORT_TIMEOUT_MS = 10000;
+const TRACES_PATH = '/v1/traces';
+
+// version-traceId-parentId-flags; versions after 00 may append fields after a dash
+const TRACEPARENT_PATTERN = /^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$/;
+
+// OTLP span kinds and status codes
+export const SpanKind = {
+  INTERNAL: 1,
+  SERVER: 2,
+  CLIENT: 3,
+} as const;
+export type SpanKind = (typeof SpanKind)[keyof typeof SpanKind];
+
+const STATUS_ERROR = 2;
+
+export type AttributeValue = string | number | boolean;
+export type Attributes = Record<string, AttributeValue | undefined>;
+
+export interface TracingConfig {
+  // Collector base URL (/v1/traces is appended) or full traces URL
+  endpoint: string;
+  serviceName: string;
+  // Extra headers for the collector, e.g. an API key
+  headers?: Record<string, string>;
+  // Further resource attributes, e.g. service.instance.id
+  resource?: Attributes;
+}
+
+interface SpanContext {
+  traceId: string;
+  spanId: string;
+}
+
+type OtlpValue =
+  | { stringValue: string }
+  | { intValue: string }
+  | { doubleValue: number }
+  | { boolValue: boolean };
+
+interface OtlpAttribute {
+  key: string;
+  value: OtlpValue;
+}
+
+interface OtlpSpan {
+  traceId: string;
+  spanId: string;
+  parentSpanId?: string;
+  name: string;
+  kind: SpanKind;
+  startTimeUnixNano: string;
+  endTimeUnixNano: string;
+  attributes: OtlpAttribute[];
+  events: { timeUnixNano: string; name: string; attributes: OtlpAttribute[] }[];
+  status: { code: number; message?: string };
+}
+
+// Wall clock in nanoseconds with the resolution of the monotonic clock
+const clockOffset = BigInt(Date.now()) * 1_000_000n - process.hrtime.bigint();
+function nowNanos(): string {
+  return (clockOffset + process.hrtime.bigint()).toString();
+}
+
+function toOtlpAttributes(attributes: Attributes): OtlpAttribute[] {
+  const result: OtlpAttribute[] = [];
+  for (const [key, value] of Object.entries(attributes)) {
+    if (value === undefined) continue;
+    if (typeof value === 'string') {
+      result.push({ key, value: { stringValue: value } });
+    } else if (typeof value === 'boolean') {
+      result.push({ key, value: { boolValue: value } });
+    } else if (Number.isInteger(value)) {
+      result.push({ key, value: { intValue: String(value) } });
+    } else {
+      result.push({ key, value: { doubleValue: value } });
+    }
+  }
+  return result;
+}
+
+export class Span {
+  readonly traceId: string;
+  readonly spanId: string;
+  private readonly parentSpanId?: string;
+  private readonly startTime = nowNanos();
+  private readonly attributes: Attributes;
+  private readonly events: OtlpSpan['events'] = [];
+  private status: OtlpSpan['status'] = { code: 0 };
+  private ended = false;
+
+  constructor(
+    private readonly tracer: Tracer,
+    private name: string,
+    private readonly kind: SpanKind,
+    parent: SpanContext | undefined,
+    attributes: Attributes
+  ) {
+    this.traceId = parent?.traceId ?? randomBytes(16).toString('hex');
+    this.spanId = randomBytes(8).toString('hex');
+    this.parentSpanId = parent?.spanId;
+    this.attributes = { ...attributes };
+  }
+
+  setName(name: string): void {
+    this.name = name;
+  }
+
+  setAttribute(key: string, value: AttributeValue | undefined): void {
+    this.attributes[key] = value;
+  }
+
+  addEvent(name: string, attributes: Attributes = {}): void {
+    this.events.push({ timeUnixNano: nowNanos(), name, attributes: toOtlpAttributes(attributes) });
+  }
+
+  // Marks the span failed, recording the error as an exception event
+  setError(error?: unknown): void {
+    const message = error instanceof Error ? error.message : error ? String(error) : undefined;
+    this.status = { code: STATUS_ERROR, message };
+    if (error !== undefined) {
+      this.addEvent('exception', {
+        'exception.type': error instanceof Error ? error.name : typeof error,
+        'exception.message': message,
+      });
+    }
+  }
+
+  // Value of the traceparent header making a remote span a child of this one
+  traceparent(): string {
+    return `00-${this.traceId}-${this.spanId}-01`;
+  }
+
+  end(): void {
+    if (this.ended) return;
+    this.ended = true;
+    this.tracer.export({
+      traceId: this.traceId,
+      spanId: this.spanId,
+      parentSpanId: this.parentSpanId,
+      name: this.name,
+      kind: this.kind,
+      startTimeUnixNano: this.startTime,
+      endTimeUnixNano: nowNanos(),
+      attributes: toOtlpAttributes(this.attributes),
+      events: this.events,
+      status: this.status,
+    });
+  }
+}
+
+/**
+ * Collects ended spans and sends them to the collector in batches
+ */
+export class Tracer {
+  private queue: OtlpSpan[] = [];
+  private timer: NodeJS.Timeout | null = null;
+  private exporting: Promise<void> = Promise.resolve();
+  private dropped = 0;
+  private readonly url: string;
+  private readonly resource: OtlpAttribute[];
+
+  constructor(private readonly config: TracingConfig) {
+    const base = config.endpoint.replace(/\/+$/, '');
+    this.url = base.endsWith(TRACES_PATH) ? base : `${base}${TRACES_PATH}`;
+    this.resource = toOtlpAttributes({ 'service.name': config.serviceName, ...config.resource });
+  }
+
+  startSpan(
+    name: string,
+    kind: SpanKind,
+    parent: SpanContext | undefined,
+    attributes: Attributes
+  ): Span {
+    return new Span(this, name, kind, parent, attributes);
+  }
+
+  export(span: OtlpSpan): void {
+    if (this.queue.length >= MAX_QUEUED_SPANS) {
+      this.dropped++;
+      return;
+    }
+    this.queue.push(span);
+    if (this.queue.length >= MAX_EXPORT_BATCH) {
+      this.flush();
+    } else if (!this.timer) {
+      this.timer = setTimeout(() => this.flush(), EXPORT_INTERVAL_MS);
+      this.timer.unref();
+    }
+  }
+
+  // Sends all queued spans; resolves once they are sent or failed
+  flush(): Promise<void> {
+    if (this.timer) {
+      clearTimeout(this.timer);
+      this.timer = null;
+    }
+    while (this.queue.length > 0) {
+      const batch = this.queue.splice(0, MAX_EXPORT_BATCH);
+      this.exporting = this.exporting.then(() => this.send(batch));
+    }
+    return this.exporting;
+  }
+
+  private async send(spans: OtlpSpan[]): Promise<void> {
+    if (this.dropped > 0) {
+      logger.warn(`dropped ${this.dropped} spans, the collector is not keeping up`);
+      this.dropped = 0;
+    }
+    const body = {
+      resourceSpans: [
+        {
+          resource: { attributes: this.resource },
+          scopeSpans: [{ scope: { name: 'vibetunnel' }, spans }],
+        },
+      ],
+    };
+    try {
+      const response = await fetch(this.url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...this.config.headers },
+        body: JSON.stringify(body),
+        signal: AbortSignal.timeout(EXPORT_TIMEOUT_MS),
+      });
+      if (!response.ok) {
+        logger.warn(`collector rejected ${spans.length} spans: HTTP ${response.status}`);
+      }
+    } catch (error) {
+      logger.warn(`failed to export ${spans.length} spans:`, error);
+    }
+  }
+}
+
+let tracer: Tracer | null = null;
+const activeSpan = new AsyncLocalStorage<Span>();
+
+/**
+ * Enable tracing; spans are exported to the given collector from now on
+ */
+export function configureTracing(config: TracingConfig): Tracer {
+  tracer = new Tracer(config);
+  logger.log(`exporting traces to ${config.endpoint} as ${config.serviceName}`);
+  return tracer;
+}
+
+/**
+ * Flush the remaining spans, e.g. on shutdown
+ */
+export async function shutdownTracing(): Promise<void> {
+  await tracer?.flush();
+}
+
+export function isTracingEnabled(): boolean {
+  return tracer !== null;
+}
+
+/**
+ * Parse a W3C traceparent header. Like the spec requires, hex must be lowercase,
+ * version ff is invalid and version 00 must not carry extra fields.
+ */
+export function parseTraceparent(value: unknown): SpanContext | undefined {
+  if (typeof value !== 'string') return undefined;
+  const match = TRACEPARENT_PATTERN.exec(value.trim());
+  if (!match) return undefined;
+  const [, version, traceId, spanId, , extra] = match;
+  if (version === 'ff' || (version === '00' && extra !== undefined)) return undefined;
+  if (/^0+$/.test(traceId) || /^0+$/.test(spanId)) return undefined;
+  return { traceId, spanId };
+}
+
+export function getActiveSpan(): Span | undefined {
+  return activeSpan.getStore();
+}
+
+/**
+ * Start a span, a child of the active span or of `parent` if given.
+ * Returns undefined while tracing is disabled.
+ */
+export function startSpan(
+  name: string,
+  options: { kind?: SpanKind; attributes?: Attributes; parent?: SpanContext } = {}
+): Span | undefined {
+  if (!tracer) return undefined;
+  const kind = options.kind ?? SpanKind.INTERNAL;
+  const parent = options.parent ?? getActiveSpan();
+  return tracer.startSpan(name, kind, parent, options.attributes ?? {});
+}
+
+/**
+ * Run fn with span as the active span
+ */
+export function runInSpan<T>(span: Span | undefined, fn: () => T): T {
+  return span ? activeSpan.run(span, fn) : fn();
+}
+
+/**
+ * Run fn inside a new span that ends when fn returns or its promise settles.
+ * Errors thrown by fn mark the span failed and are rethrown.
+ */
+export function withSpan<T>(name: string, attributes: Attributes, fn: () => T): T {
+  const span = startSpan(name, { attributes });
+  if (!span) return fn();
+
+  let result: T;
+  try {
+    result = activeSpan.run(span, fn);
+  } catch (error) {
+    span.setError(error);
+    span.end();
+    throw error;
+  }
+  if (result instanceof Promise) {
+    return result.then(
+      (value) => {
+        span.end();
+        return value;
+      },
+      (error) => {
+        span.setError(error);
+        span.end();
+        throw error;
+      }
+    ) as T;
+  }
+  span.end();
+  return result;
+}
+
+/**
+ * fetch() to another VibeTunnel server, traced as a client span whose context
+ * is passed on in the traceparent header
+ */
+export async function tracedFetch(url: string, init: RequestInit = {}): Promise<Response> {
+  // Only set in --chaos mode
+  const injected = await getFaultInjector()?.beforeFetch(url, init.signal);
+  if (injected) return injected;
+
+  const method = init.method ?? 'GET';
+  const target = new URL(url);
+  const span = startSpan(method, {
+    kind: SpanKind.CLIENT,
+    attributes: {
+      'http.request.method': method,
+      'url.full': `${target.origin}${target.pathname}`,
+      'server.address': target.hostname,
+    },
+  });
+  if (!span) return fetch(url, init);
+
+  const headers = new Headers(init.headers);
+  headers.set(TRACEPARENT_HEADER, span.traceparent());
+  try {
+    const response = await fetch(url, { ...init, headers });
+    span.setAttribute('http.response.status_code', response.status);
+    if (response.status >= 500) span.setError();
+    return response;
+  } catch (error) {
+    span.setError(error);
+    throw error;
+  } finally {
+    span.end();
+  }
+}
+
+/**
+ * OTLP collector headers from OTEL_EXPORTER_OTLP_HEADERS (key=value,key=value)
+ */
+export function parseOtlpHeaders(value: string | undefined): Record<string, string> {
+  const headers: Record<string, string> = {};
+  for (const pair of (value ?? '').split(',')) {
+    const index = pair.indexOf('=');
+    if (index <= 0) continue;
+    headers[decodeURIComponent(pair.slice(0, index).trim())] = decodeURIComponent(
+      pair.slice(index + 1).trim()
+    );
+  }
+  return headers;
+}
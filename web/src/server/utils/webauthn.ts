@@ -0,0 +1,348 @@
+import { createHash, createPublicKey, type KeyObject, timingSafeEqual, verify } from 'crypto';
+
+/**
+ * Minimal WebAuthn (FIDO2) relying party
+ *
+ * Verifies registrations (attestation is not checked; `none` is requested) and
+ * assertions of security keys and platform authenticators with ES256, RS256
+ * or EdDSA keys. Binary fields are exchanged as base64url strings.
+ */
+
+// COSE algorithm identifiers
+export const COSE_ALGORITHMS = {
+  ES256: -7,
+  EdDSA: -8,
+  RS256: -257,
+} as const;
+
+const FLAG_USER_PRESENT = 0x01;
+const FLAG_ATTESTED_DATA = 0x40;
+
+export class WebAuthnError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'WebAuthnError';
+  }
+}
+
+export interface RegistrationResponse {
+  id: string;
+  response: { clientDataJSON: string; attestationObject: string };
+}
+
+export interface AssertionResponse {
+  id: string;
+  response: { clientDataJSON: string; authenticatorData: string; signature: string };
+}
+
+export interface RegisteredCredential {
+  // base64url credential ID
+  id: string;
+  // SPKI PEM
+  publicKey: string;
+  algorithm: number;
+  signCount: number;
+}
+
+interface Expected {
+  challenge: Buffer;
+  origin: string;
+  rpId: string;
+}
+
+/**
+ * IEEE 754 half-precision float (RFC 8949 Appendix D)
+ */
+function halfToNumber(half: number): number {
+  const sign = half & 0x8000 ? -1 : 1;
+  const exponent = (half >> 10) & 0x1f;
+  const mantissa = half & 0x3ff;
+  if (exponent === 0) return sign * mantissa * 2 ** -24;
+  if (exponent === 31) return mantissa ? Number.NaN : sign * Number.POSITIVE_INFINITY;
+  return sign * (mantissa + 1024) * 2 ** (exponent - 25);
+}
+
+/**
+ * Decoder for the CBOR (RFC 8949) subset used by WebAuthn: definite lengths only
+ */
+class CborReader {
+  pos = 0;
+
+  constructor(private readonly data: Buffer) {}
+
+  read(): unknown {
+    const initial = this.byte();
+    const major = initial >> 5;
+    const info = initial & 0x1f;
+    if (major === 7) return this.readSimple(info);
+
+    const length = this.readLength(info);
+    switch (major) {
+      case 0:
+        return length;
+      case 1:
+        return -1 - length;
+      case 2:
+        return Buffer.from(this.take(length));
+      case 3:
+        return this.take(length).toString('utf8');
+      case 4:
+        return Array.from({ length }, () => this.read());
+      case 5: {
+        const map = new Map<unknown, unknown>();
+        for (let i = 0; i < length; i++) {
+          const key = this.read();
+          map.set(key, this.read());
+        }
+        return map;
+      }
+      default:
+        // Tags: the tagged item is returned as is
+        return this.read();
+    }
+  }
+
+  private readSimple(info: number): unknown {
+    switch (info) {
+      case 20:
+        return false;
+      case 21:
+        return true;
+      case 22:
+        return null;
+      case 23:
+        return undefined;
+      case 25:
+        return halfToNumber(this.take(2).readUInt16BE(0));
+      case 26:
+        return this.take(4).readFloatBE(0);
+      case 27:
+        return this.take(8).readDoubleBE(0);
+      default:
+        throw new WebAuthnError(`Unsupported CBOR simple value ${info}`);
+    }
+  }
+
+  private readLength(info: number): number {
+    if (info < 24) return info;
+    if (info === 24) return this.byte();
+    if (info === 25) return this.take(2).readUInt16BE(0);
+    if (info === 26) return this.take(4).readUInt32BE(0);
+    if (info === 27) return Number(this.take(8).readBigUInt64BE(0));
+    throw new WebAuthnError('Indefinite-length CBOR is not supported');
+  }
+
+  private byte(): number {
+    return this.take(1)[0];
+  }
+
+  private take(length: number): Buffer {
+    if (this.pos + length > this.data.length) {
+      throw new WebAuthnError('Truncated CBOR data');
+    }
+    const slice = this.data.subarray(this.pos, this.pos + length);
+    this.pos += length;
+    return slice;
+  }
+}
+
+export function decodeCbor(data: Buffer): unknown {
+  return new CborReader(data).read();
+}
+
+function fromBase64Url(value: unknown, field: string): Buffer {
+  if (typeof value !== 'string' || !/^[A-Za-z0-9_-]*={0,2}$/.test(value)) {
+    throw new WebAuthnError(`${field} must be base64url`);
+  }
+  return Buffer.from(value, 'base64url');
+}
+
+function sha256(data: Buffer | string): Buffer {
+  return createHash('sha256').update(data).digest();
+}
+
+/**
+ * Check the client data of a ceremony: its type, challenge and origin
+ */
+function verifyClientData(
+  clientDataJSON: Buffer,
+  type: 'webauthn.create' | 'webauthn.get',
+  expected: Expected
+): void {
+  let clientData: { type?: string; challenge?: string; origin?: string };
+  try {
+    clientData = JSON.parse(clientDataJSON.toString('utf8'));
+  } catch {
+    throw new WebAuthnError('clientDataJSON is not valid JSON');
+  }
+  if (clientData.type !== type) {
+    throw new WebAuthnError(`Unexpected ceremony type ${clientData.type}`);
+  }
+  const challenge = fromBase64Url(clientData.challenge, 'challenge');
+  if (
+    challenge.length !== expected.challenge.length ||
+    !timingSafeEqual(challenge, expected.challenge)
+  ) {
+    throw new WebAuthnError('Challenge does not match');
+  }
+  if (clientData.origin !== expected.origin) {
+    throw new WebAuthnError(`Unexpected origin ${clientData.origin}`);
+  }
+}
+
+interface AuthenticatorData {
+  rpIdHash: Buffer;
+  flags: number;
+  signCount: number;
+  credential?: { id: Buffer; publicKey: Map<unknown, unknown> };
+}
+
+function parseAuthenticatorData(data: Buffer): AuthenticatorData {
+  if (data.length < 37) {
+    throw new WebAuthnError('Authenticator data is truncated');
+  }
+  const result: AuthenticatorData = {
+    rpIdHash: data.subarray(0, 32),
+    flags: data[32],
+    signCount: data.readUInt32BE(33),
+  };
+  if (result.flags & FLAG_ATTESTED_DATA) {
+    // AAGUID (16 bytes), credential ID length (2 bytes), credential ID, COSE key
+    const idLength = data.readUInt16BE(53);
+    const id = data.subarray(55, 55 + idLength);
+    const publicKey = decodeCbor(data.subarray(55 + idLength));
+    if (!(publicKey instanceof Map)) {
+      throw new WebAuthnError('Credential public key is not a COSE key');
+    }
+    result.credential = { id: Buffer.from(id), publicKey };
+  }
+  return result;
+}
+
+function checkAuthenticatorData(authData: AuthenticatorData, rpId: string): void {
+  if (!authData.rpIdHash.equals(sha256(rpId))) {
+    throw new WebAuthnError('Credential belongs to another relying party');
+  }
+  if (!(authData.flags & FLAG_USER_PRESENT)) {
+    throw new WebAuthnError('User presence was not confirmed');
+  }
+}
+
+/**
+ * Convert a COSE public key to a Node key object
+ */
+function coseToKeyObject(cose: Map<unknown, unknown>): { key: KeyObject; algorithm: number } {
+  const kty = cose.get(1);
+  const algorithm = cose.get(3) as number;
+  const coordinate = (label: number) => {
+    const value = cose.get(label);
+    if (!Buffer.isBuffer(value)) {
+      throw new WebAuthnError(`COSE key parameter ${label} is missing`);
+    }
+    return value.toString('base64url');
+  };
+
+  if (kty === 2 && algorithm === COSE_ALGORITHMS.ES256 && cose.get(-1) === 1) {
+    const jwk = { kty: 'EC', crv: 'P-256', x: coordinate(-2), y: coordinate(-3) };
+    return { key: createPublicKey({ key: jwk, format: 'jwk' }), algorithm };
+  }
+  if (kty === 3 && algorithm === COSE_ALGORITHMS.RS256) {
+    const jwk = { kty: 'RSA', n: coordinate(-1), e: coordinate(-2) };
+    return { key: createPublicKey({ key: jwk, format: 'jwk' }), algorithm };
+  }
+  if (kty === 1 && algorithm === COSE_ALGORITHMS.EdDSA && cose.get(-1) === 6) {
+    const jwk = { kty: 'OKP', crv: 'Ed25519', x: coordinate(-2) };
+    return { key: createPublicKey({ key: jwk, format: 'jwk' }), algorithm };
+  }
+  throw new WebAuthnError(`Unsupported credential key (kty ${kty}, alg ${algorithm})`);
+}
+
+/**
+ * Verify a registration (navigator.credentials.create) and return the new credential
+ */
+export function verifyRegistration(
+  registration: RegistrationResponse,
+  expected: Expected
+): RegisteredCredential {
+  const clientDataJSON = fromBase64Url(
+    registration.response?.clientDataJSON,
+    'clientDataJSON'
+  );
+  verifyClientData(clientDataJSON, 'webauthn.create', expected);
+
+  const attestation = decodeCbor(
+    fromBase64Url(registration.response?.attestationObject, 'attestationObject')
+  );
+  const authDataBytes = attestation instanceof Map ? attestation.get('authData') : undefined;
+  if (!Buffer.isBuffer(authDataBytes)) {
+    throw new WebAuthnError('Attestation object has no authenticator data');
+  }
+  const authData = parseAuthenticatorData(authDataBytes);
+  checkAuthenticatorData(authData, expected.rpId);
+  if (!authData.credential) {
+    throw new WebAuthnError('Registration contains no credential');
+  }
+
+  const { key, algorithm } = coseToKeyObject(authData.credential.publicKey);
+  return {
+    id: authData.credential.id.toString('base64url'),
+    publicKey: key.export({ type: 'spki', format: 'pem' }).toString(),
+    algorithm,
+    signCount: authData.signCount,
+  };
+}
+
+/**
+ * Verify an assertion (navigator.credentials.get) made with a registered
+ * credential. Returns the new signature counter.
+ */
+export function verifyAssertion(
+  assertion: AssertionResponse,
+  credential: RegisteredCredential,
+  expected: Expected
+): number {
+  const clientDataJSON = fromBase64Url(assertion.response?.clientDataJSON, 'clientDataJSON');
+  verifyClientData(clientDataJSON, 'webauthn.get', expected);
+
+  const authDataBytes = fromBase64Url(
+    assertion.response?.authenticatorData,
+    'authenticatorData'
+  );
+  const authData = parseAuthenticatorData(authDataBytes);
+  checkAuthenticatorData(authData, expected.rpId);
+
+  const signed = Buffer.concat([authDataBytes, sha256(clientDataJSON)]);
+  const signature = fromBase64Url(assertion.response?.signature, 'signature');
+  const digest = credential.algorithm === COSE_ALGORITHMS.EdDSA ? null : 'sha256';
+  if (!verify(digest, signed, credential.publicKey, signature)) {
+    throw new WebAuthnError('Invalid assertion signature');
+  }
+
+  // Authenticators with counters must increase them; a lower value hints at a cloned key
+  if (
+    (authData.signCount !== 0 || credential.signCount !== 0) &&
+    authData.signCount <= credential.signCount
+  ) {
+    throw new WebAuthnError('Signature counter did not increase');
+  }
+  return authData.signCount;
+}
+
+/**
+ * Relying party ID and origin of a ceremony started from the given Origin header
+ */
+export function relyingParty(origin: unknown): { origin: string; rpId: string } {
+  if (typeof origin !== 'string') {
+    throw new WebAuthnError('Origin header is required');
+  }
+  let url: URL;
+  try {
+    url = new URL(origin);
+  } catch {
+    throw new WebAuthnError(`Invalid origin ${origin}`);
+  }
+  if (url.protocol !== 'https:' && url.protocol !== 'http:') {
+    throw new WebAuthnError(`Invalid origin ${origin}`);
+  }
+  return { origin: url.origin, rpId: url.hostname };
+}
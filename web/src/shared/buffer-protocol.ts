@@ -0,0 +1,49 @@
+/**
+ * Versioning of the /buffers WebSocket protocol and its binary snapshot format
+ *
+ * Clients send `{ type: 'hello', version, capabilities }` right after connecting;
+ * the server answers with its version and the capabilities it will use for the
+ * connection. Clients that never send a hello (web clients from before 1.3) get
+ * what they always got: format 1 snapshots with link tables, and image messages.
+ */
+
+// 1.1 adds `sync` for HQ mirroring of remote sessions, 1.2 `resume`, 1.3 `hello`
+export const BUFFER_PROTOCOL_VERSION = '1.3';
+
+// compression: deflated snapshot bodies; deltas: rows unchanged since the previous
+// frame are not resent; links: OSC 8 link tables; images: inline image messages
+export const BUFFER_CAPABILITIES = ['compression', 'deltas', 'links', 'images'] as const;
+export type BufferCapability = (typeof BUFFER_CAPABILITIES)[number];
+
+// What a connection without a hello is sent
+export const LEGACY_CAPABILITIES: readonly BufferCapability[] = ['links', 'images'];
+
+// Snapshot header: magic "VT", version, flags, cols, rows, viewportY, cursorX, cursorY,
+// reserved. Format 2 is only used for frames with the delta or compressed flag.
+export const SNAPSHOT_HEADER_SIZE = 28;
+export const SNAPSHOT_FORMAT_1 = 0x01;
+export const SNAPSHOT_FORMAT_2 = 0x02;
+
+// Header flags. The link table follows the rows, at the offset in the reserved field.
+export const FLAG_LINK_TABLE = 0x01;
+// Rows may be ROW_UNCHANGED runs, taken from the session's previous frame
+export const FLAG_DELTA = 0x02;
+// Everything after the header is deflate-raw; offsets refer to the inflated frame
+export const FLAG_COMPRESSED = 0x04;
+
+// Row markers: a run of empty rows, a row with content, a run of unchanged rows
+// (format 2). Runs are followed by a one-byte count.
+export const ROW_EMPTY = 0xfe;
+export const ROW_CONTENT = 0xfd;
+export const ROW_UNCHANGED = 0xfc;
+
+/**
+ * Capabilities of a hello that the server supports, in canonical order
+ */
+export function negotiateCapabilities(
+  requested: unknown,
+  supported: readonly BufferCapability[] = BUFFER_CAPABILITIES
+): BufferCapability[] {
+  if (!Array.isArray(requested)) return [];
+  return supported.filter((capability) => requested.includes(capability));
+}
@@ -0,0 +1,70 @@
+/**
+ * Error codes returned by the HTTP API
+ *
+ * Every error response is an ApiErrorBody. Clients branch on `code`; `message`
+ * is for people and may change. Each code has the HTTP status it is sent with
+ * and a default message.
+ */
+
+export const ERROR_CODES = {
+  // Request validation
+  INVALID_REQUEST: { status: 400, message: 'Invalid request' },
+  INVALID_JSON: { status: 400, message: 'Request body is not valid JSON' },
+  SESSION_NOT_RUNNING: { status: 400, message: 'Session is not running' },
+  INVALID_SESSION_ID: { status: 400, message: 'Invalid session ID' },
+  // Authentication and permissions
+  AUTH_REQUIRED: { status: 401, message: 'Authentication required' },
+  INVALID_TOKEN: { status: 401, message: 'Invalid or expired token' },
+  INVALID_CREDENTIALS: { status: 401, message: 'Authentication failed' },
+  SECOND_FACTOR_FAILED: { status: 401, message: 'Second factor verification failed' },
+  FORBIDDEN: { status: 403, message: 'Access denied' },
+  ADMIN_REQUIRED: { status: 403, message: 'Admin access required' },
+  RESIZE_DISABLED: { status: 403, message: 'Terminal resizing is disabled by the server' },
+  // Missing resources
+  NOT_FOUND: { status: 404, message: 'Not found' },
+  ENDPOINT_NOT_FOUND: { status: 404, message: 'API endpoint not found' },
+  NOT_HQ_MODE: { status: 404, message: 'Not running in HQ mode' },
+  SESSION_NOT_FOUND: { status: 404, message: 'Session not found' },
+  REMOTE_NOT_FOUND: { status: 404, message: 'Remote not found' },
+  GROUP_NOT_FOUND: { status: 404, message: 'Group not found' },
+  SCHEDULE_NOT_FOUND: { status: 404, message: 'Scheduled job not found' },
+  TRIGGER_NOT_FOUND: { status: 404, message: 'Trigger not found' },
+  TEMPLATE_NOT_FOUND: { status: 404, message: 'Template not found' },
+  WORKSPACE_NOT_FOUND: { status: 404, message: 'Workspace not found' },
+  // State conflicts and limits
+  CONFLICT: { status: 409, message: 'Conflict' },
+  PAYLOAD_TOO_LARGE: { status: 413, message: 'Request body is too large' },
+  FILE_TOO_LARGE: { status: 413, message: 'File is too large to return at once' },
+  RANGE_NOT_SATISFIABLE: { status: 416, message: 'Requested range is not satisfiable' },
+  INPUT_LOCKED: { status: 423, message: 'Session input is locked by another client' },
+  RATE_LIMITED: { status: 429, message: 'Too many requests' },
+  SESSION_LIMIT_REACHED: { status: 429, message: 'Session limit reached' },
+  // Server failures
+  INTERNAL_ERROR: { status: 500, message: 'Internal server error' },
+  PTY_CREATE_FAILED: { status: 500, message: 'Failed to create session' },
+  REMOTE_ERROR: { status: 502, message: 'Remote server returned an error' },
+  REMOTE_UNREACHABLE: { status: 503, message: 'Failed to reach remote server' },
+  SERVER_SHUTTING_DOWN: { status: 503, message: 'Server is shutting down' },
+  NOT_CONFIGURED: { status: 503, message: 'Feature is not configured on this server' },
+  UNAVAILABLE: { status: 503, message: 'Service unavailable' },
+} as const;
+
+export type ErrorCode = keyof typeof ERROR_CODES;
+
+/**
+ * Body of every error response
+ */
+export interface ApiErrorBody {
+  code: ErrorCode;
+  message: string;
+  // Further information, usually the underlying error message
+  details?: unknown;
+  // Same as message, for clients written before codes existed
+  error: string;
+  // X-Request-ID of the failed request, for correlating server logs
+  requestId?: string;
+}
+
+export function isErrorCode(value: unknown): value is ErrorCode {
+  return typeof value === 'string' && Object.prototype.hasOwnProperty.call(ERROR_CODES, value);
+}
@@ -0,0 +1,223 @@
+/**
+ * Shared keymap for special keys
+ * Used by the client to decide what to send as a key and by the server to
+ * translate key names into terminal input sequences (xterm conventions).
+ *
+ * Key names are a base key optionally prefixed by modifiers joined with "_",
+ * e.g. "arrow_up", "ctrl_arrow_left", "alt_shift_f5", "ctrl_c", "alt_x".
+ * Modifier order does not matter.
+ */
+
+export type KeyModifier = 'shift' | 'alt' | 'ctrl' | 'meta';
+
+export interface KeyEncodingModes {
+  // DECCKM: cursor keys send SS3 sequences (ESC O A) instead of CSI (ESC [ A)
+  applicationCursor?: boolean;
+  // DECKPAM: keypad keys send SS3 sequences (ESC O p) instead of their characters
+  applicationKeypad?: boolean;
+}
+
+export interface ParsedKey {
+  base: string;
+  modifiers: Set<KeyModifier>;
+}
+
+const MODIFIERS: KeyModifier[] = ['shift', 'alt', 'ctrl', 'meta'];
+
+// Cursor-style keys: CSI <final>, SS3 <final> in application cursor mode
+const CURSOR_KEYS: Record<string, string> = {
+  arrow_up: 'A',
+  arrow_down: 'B',
+  arrow_right: 'C',
+  arrow_left: 'D',
+  home: 'H',
+  end: 'F',
+};
+
+// Editing and function keys sent as CSI <number> ~
+const TILDE_KEYS: Record<string, number> = {
+  insert: 2,
+  delete: 3,
+  page_up: 5,
+  page_down: 6,
+  f5: 15,
+  f6: 17,
+  f7: 18,
+  f8: 19,
+  f9: 20,
+  f10: 21,
+  f11: 23,
+  f12: 24,
+};
+
+// F1-F4 are SS3 P..S unmodified, CSI 1;<mod> P..S with modifiers
+const SS3_FUNCTION_KEYS: Record<string, string> = {
+  f1: 'P',
+  f2: 'Q',
+  f3: 'R',
+  f4: 'S',
+};
+
+// Keypad keys: character in numeric mode, SS3 <final> in application keypad mode
+const KEYPAD_KEYS: Record<string, [string, string]> = {
+  kp_0: ['0', 'p'],
+  kp_1: ['1', 'q'],
+  kp_2: ['2', 'r'],
+  kp_3: ['3', 's'],
+  kp_4: ['4', 't'],
+  kp_5: ['5', 'u'],
+  kp_6: ['6', 'v'],
+  kp_7: ['7', 'w'],
+  kp_8: ['8', 'x'],
+  kp_9: ['9', 'y'],
+  kp_decimal: ['.', 'n'],
+  kp_plus: ['+', 'k'],
+  kp_minus: ['-', 'm'],
+  kp_multiply: ['*', 'j'],
+  kp_divide: ['/', 'o'],
+  kp_equal: ['=', 'X'],
+  kp_enter: ['\r', 'M'],
+};
+
+// Keys that produce a single control character
+const SIMPLE_KEYS: Record<string, string> = {
+  enter: '\r',
+  escape: '\x1b',
+  backspace: '\x7f',
+  tab: '\t',
+  space: ' ',
+};
+
+// Combinations kept for compatibility with existing clients
+const LEGACY_KEYS: Record<string, string> = {
+  ctrl_enter: '\n',
+  shift_enter: '\r\n',
+  shift_tab: '\x1b[Z',
+  ctrl_backspace: '\x08',
+  ctrl_space: '\x00',
+};
+
+/**
+ * Split a key name into base key and modifiers. Returns null for names that
+ * are not special keys.
+ */
+export function parseKey(key: string): ParsedKey | null {
+  const modifiers = new Set<KeyModifier>();
+  let rest = key;
+
+  for (;;) {
+    const modifier = MODIFIERS.find((name) => rest.startsWith(`${name}_`));
+    if (!modifier || modifiers.has(modifier)) break;
+    modifiers.add(modifier);
+    rest = rest.slice(modifier.length + 1);
+  }
+
+  const isNamedKey =
+    rest in CURSOR_KEYS ||
+    rest in TILDE_KEYS ||
+    rest in SS3_FUNCTION_KEYS ||
+    rest in KEYPAD_KEYS ||
+    rest in SIMPLE_KEYS;
+  // Single printable characters are only keys when combined with modifiers
+  const isModifiedChar = modifiers.size > 0 && rest.length === 1 && rest >= ' ' && rest <= '~';
+
+  if (!isNamedKey && !isModifiedChar) {
+    return null;
+  }
+  return { base: rest, modifiers };
+}
+
+/**
+ * Whether a name is a special key (rather than literal text)
+ */
+export function isSpecialKey(key: string): boolean {
+  return key in LEGACY_KEYS || parseKey(key) !== null;
+}
+
+// xterm modifier parameter: 1 + shift(1) + alt(2) + ctrl(4) + meta(8)
+function modifierParam(modifiers: Set<KeyModifier>): number {
+  let value = 1;
+  if (modifiers.has('shift')) value += 1;
+  if (modifiers.has('alt')) value += 2;
+  if (modifiers.has('ctrl')) value += 4;
+  if (modifiers.has('meta')) value += 8;
+  return value;
+}
+
+// Ctrl+<char> as a C0 control character, or null if there is none
+function controlCharacter(char: string): string | null {
+  const code = char.toLowerCase().charCodeAt(0);
+  if (code >= 0x61 && code <= 0x7a) return String.fromCharCode(code - 0x60); // a-z
+  if (char === '@' || char === '2') return '\x00';
+  if (char === '[' || char === '3') return '\x1b';
+  if (char === '\\' || char === '4') return '\x1c';
+  if (char === ']' || char === '5') return '\x1d';
+  if (char === '^' || char === '6') return '\x1e';
+  if (char === '_' || char === '-' || char === '7') return '\x1f';
+  if (char === '?' || char === '8') return '\x7f';
+  return null;
+}
+
+/**
+ * Translate a key name into the byte sequence a terminal would send.
+ * Returns null for unknown keys.
+ */
+export function encodeKey(key: string, modes: KeyEncodingModes = {}): string | null {
+  if (key in LEGACY_KEYS) {
+    return LEGACY_KEYS[key];
+  }
+
+  const parsed = parseKey(key);
+  if (!parsed) return null;
+
+  const { base, modifiers } = parsed;
+  const param = modifierParam(modifiers);
+  const modified = param > 1;
+
+  if (base in CURSOR_KEYS) {
+    const final = CURSOR_KEYS[base];
+    if (modified) return `\x1b[1;${param}${final}`;
+    return modes.applicationCursor ? `\x1bO${final}` : `\x1b[${final}`;
+  }
+
+  if (base in SS3_FUNCTION_KEYS) {
+    const final = SS3_FUNCTION_KEYS[base];
+    return modified ? `\x1b[1;${param}${final}` : `\x1bO${final}`;
+  }
+
+  if (base in KEYPAD_KEYS) {
+    const [char, final] = KEYPAD_KEYS[base];
+    if (modes.applicationKeypad) {
+      return modified ? `\x1bO${param}${final}` : `\x1bO${final}`;
+    }
+    return modifiers.has('alt') || modifiers.has('meta') ? `\x1b${char}` : char;
+  }
+
+  if (base in TILDE_KEYS) {
+    const code = TILDE_KEYS[base];
+    return modified ? `\x1b[${code};${param}~` : `\x1b[${code}~`;
+  }
+
+  // Simple keys and characters: ctrl maps to a control character, alt/meta prefix ESC
+  let sequence: string;
+  if (base in SIMPLE_KEYS) {
+    sequence = SIMPLE_KEYS[base];
+    if (base === 'tab' && modifiers.has('shift')) sequence = '\x1b[Z';
+    if (modifiers.has('ctrl')) {
+      if (base === 'backspace') sequence = '\x08';
+      if (base === 'space') sequence = '\x00';
+    }
+  } else {
+    sequence = modifiers.has('shift') ? base.toUpperCase() : base;
+    if (modifiers.has('ctrl')) {
+      const control = controlCharacter(base);
+      if (control === null) return null;
+      sequence = control;
+    }
+  }
+
+  if (modifiers.has('alt') || modifiers.has('meta')) {
+    sequence = `\x1b${sequence}`;
+  }
+  return sequence;
+}
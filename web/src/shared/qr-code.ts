@@ -0,0 +1,345 @@
+/**
+ * QR codes (ISO/IEC 18004) for short texts such as pairing URLs: byte mode,
+ * error correction level M, versions 1 to 10 (up to 213 bytes)
+ */
+
+// Per version (1-10, level M): error correction codewords per block and data codewords per block
+const VERSIONS: { ecPerBlock: number; blocks: number[] }[] = [
+  { ecPerBlock: 10, blocks: [16] },
+  { ecPerBlock: 16, blocks: [28] },
+  { ecPerBlock: 26, blocks: [44] },
+  { ecPerBlock: 18, blocks: [32, 32] },
+  { ecPerBlock: 24, blocks: [43, 43] },
+  { ecPerBlock: 16, blocks: [27, 27, 27, 27] },
+  { ecPerBlock: 18, blocks: [31, 31, 31, 31] },
+  { ecPerBlock: 22, blocks: [38, 38, 39, 39] },
+  { ecPerBlock: 22, blocks: [36, 36, 36, 37, 37] },
+  { ecPerBlock: 26, blocks: [43, 43, 43, 43, 44] },
+];
+
+// Centers of alignment patterns per version (rows and columns alike)
+const ALIGNMENT_POSITIONS = [
+  [],
+  [6, 18],
+  [6, 22],
+  [6, 26],
+  [6, 30],
+  [6, 34],
+  [6, 22, 38],
+  [6, 24, 42],
+  [6, 26, 46],
+  [6, 28, 50],
+];
+
+const MASKS: ((row: number, col: number) => boolean)[] = [
+  (row, col) => (row + col) % 2 === 0,
+  (row) => row % 2 === 0,
+  (_row, col) => col % 3 === 0,
+  (row, col) => (row + col) % 3 === 0,
+  (row, col) => (Math.floor(row / 2) + Math.floor(col / 3)) % 2 === 0,
+  (row, col) => ((row * col) % 2) + ((row * col) % 3) === 0,
+  (row, col) => (((row * col) % 2) + ((row * col) % 3)) % 2 === 0,
+  (row, col) => (((row + col) % 2) + ((row * col) % 3)) % 2 === 0,
+];
+
+// GF(256) with the QR polynomial x^8 + x^4 + x^3 + x^2 + 1
+const EXP = new Uint8Array(512);
+const LOG = new Uint8Array(256);
+for (let i = 0, value = 1; i < 255; i++) {
+  EXP[i] = value;
+  LOG[value] = i;
+  value <<= 1;
+  if (value & 0x100) value ^= 0x11d;
+}
+for (let i = 255; i < 512; i++) EXP[i] = EXP[i - 255];
+
+const multiply = (a: number, b: number) => (a && b ? EXP[LOG[a] + LOG[b]] : 0);
+
+// Reed-Solomon error correction codewords of a block
+function errorCorrection(data: number[], length: number): number[] {
+  let generator = [1];
+  for (let i = 0; i < length; i++) {
+    const next = new Array(generator.length + 1).fill(0);
+    generator.forEach((coefficient, j) => {
+      next[j] ^= coefficient;
+      next[j + 1] ^= multiply(coefficient, EXP[i]);
+    });
+    generator = next;
+  }
+
+  const remainder = new Array(length).fill(0);
+  for (const byte of data) {
+    const factor = byte ^ remainder.shift();
+    remainder.push(0);
+    for (let j = 0; j < length; j++) remainder[j] ^= multiply(generator[j + 1], factor);
+  }
+  return remainder;
+}
+
+// Data and error correction codewords, interleaved across blocks
+function codewords(bytes: Uint8Array, version: number): number[] {
+  const { ecPerBlock, blocks } = VERSIONS[version - 1];
+  const capacity = blocks.reduce((sum, size) => sum + size, 0);
+
+  const bits: number[] = [];
+  const put = (value: number, length: number) => {
+    for (let i = length - 1; i >= 0; i--) bits.push((value >>> i) & 1);
+  };
+  put(0b0100, 4);
+  put(bytes.length, version < 10 ? 8 : 16);
+  for (const byte of bytes) put(byte, 8);
+  put(0, Math.min(4, capacity * 8 - bits.length));
+  put(0, (8 - (bits.length % 8)) % 8);
+
+  const data: number[] = [];
+  for (let i = 0; i < bits.length; i += 8) {
+    data.push(bits.slice(i, i + 8).reduce((byte, bit) => (byte << 1) | bit, 0));
+  }
+  for (let pad = 0; data.length < capacity; pad++) data.push(pad % 2 ? 0x11 : 0xec);
+
+  const dataBlocks: number[][] = [];
+  let offset = 0;
+  for (const size of blocks) {
+    dataBlocks.push(data.slice(offset, offset + size));
+    offset += size;
+  }
+  const ecBlocks = dataBlocks.map((block) => errorCorrection(block, ecPerBlock));
+
+  const result: number[] = [];
+  for (let i = 0; i < Math.max(...blocks); i++) {
+    for (const block of dataBlocks) if (i < block.length) result.push(block[i]);
+  }
+  for (let i = 0; i < ecPerBlock; i++) {
+    for (const block of ecBlocks) result.push(block[i]);
+  }
+  return result;
+}
+
+class Matrix {
+  readonly modules: boolean[][];
+  // Finder, timing, alignment, format and version modules, which are not masked
+  readonly reserved: boolean[][];
+
+  constructor(readonly size: number) {
+    this.modules = Array.from({ length: size }, () => new Array(size).fill(false));
+    this.reserved = Array.from({ length: size }, () => new Array(size).fill(false));
+  }
+
+  set(row: number, col: number, dark: boolean): void {
+    this.modules[row][col] = dark;
+    this.reserved[row][col] = true;
+  }
+}
+
+function drawFunctionPatterns(matrix: Matrix, version: number): void {
+  const { size } = matrix;
+
+  // Finder patterns with their light separators
+  for (const [top, left] of [
+    [0, 0],
+    [0, size - 7],
+    [size - 7, 0],
+  ]) {
+    for (let dy = -1; dy <= 7; dy++) {
+      for (let dx = -1; dx <= 7; dx++) {
+        const row = top + dy;
+        const col = left + dx;
+        if (row < 0 || row >= size || col < 0 || col >= size) continue;
+        const ring = Math.max(Math.abs(dy - 3), Math.abs(dx - 3));
+        matrix.set(row, col, ring !== 2 && ring !== 4);
+      }
+    }
+  }
+
+  // Timing patterns
+  for (let i = 8; i < size - 8; i++) {
+    matrix.set(6, i, i % 2 === 0);
+    matrix.set(i, 6, i % 2 === 0);
+  }
+
+  // Alignment patterns, except where they would overlap a finder
+  const positions = ALIGNMENT_POSITIONS[version - 1];
+  const last = positions.length - 1;
+  positions.forEach((row, i) => {
+    positions.forEach((col, j) => {
+      if ((i === 0 && j === 0) || (i === 0 && j === last) || (i === last && j === 0)) return;
+      for (let dy = -2; dy <= 2; dy++) {
+        for (let dx = -2; dx <= 2; dx++) {
+          matrix.set(row + dy, col + dx, Math.max(Math.abs(dy), Math.abs(dx)) !== 1);
+        }
+      }
+    });
+  });
+
+  // Format areas are reserved now and drawn once the mask is known
+  drawFormatBits(matrix, 0);
+
+  // Version information (version 7 and up)
+  if (version >= 7) {
+    let remainder = version;
+    for (let i = 0; i < 12; i++) remainder = (remainder << 1) ^ ((remainder >>> 11) * 0x1f25);
+    const bits = (version << 12) | remainder;
+    for (let i = 0; i < 18; i++) {
+      const dark = ((bits >>> i) & 1) === 1;
+      const a = size - 11 + (i % 3);
+      const b = Math.floor(i / 3);
+      matrix.set(b, a, dark);
+      matrix.set(a, b, dark);
+    }
+  }
+}
+
+function drawFormatBits(matrix: Matrix, mask: number): void {
+  const { size } = matrix;
+  // Level M is 00
+  const data = mask;
+  let remainder = data;
+  for (let i = 0; i < 10; i++) remainder = (remainder << 1) ^ ((remainder >>> 9) * 0x537);
+  const bits = ((data << 10) | remainder) ^ 0x5412;
+  const bit = (i: number) => ((bits >>> i) & 1) === 1;
+
+  for (let i = 0; i <= 5; i++) matrix.set(i, 8, bit(i));
+  matrix.set(7, 8, bit(6));
+  matrix.set(8, 8, bit(7));
+  matrix.set(8, 7, bit(8));
+  for (let i = 9; i < 15; i++) matrix.set(8, 14 - i, bit(i));
+  for (let i = 0; i < 8; i++) matrix.set(8, size - 1 - i, bit(i));
+  for (let i = 8; i < 15; i++) matrix.set(size - 15 + i, 8, bit(i));
+  matrix.set(size - 8, 8, true);
+}
+
+// Data modules in the zigzag order of two-column strips, right to left
+function drawCodewords(matrix: Matrix, data: number[]): void {
+  const { size } = matrix;
+  let index = 0;
+  for (let right = size - 1; right >= 1; right -= 2) {
+    if (right === 6) right = 5;
+    const upward = ((right + 1) & 2) === 0;
+    for (let vertical = 0; vertical < size; vertical++) {
+      const row = upward ? size - 1 - vertical : vertical;
+      for (const col of [right, right - 1]) {
+        if (matrix.reserved[row][col] || index >= data.length * 8) continue;
+        matrix.modules[row][col] = ((data[index >>> 3] >>> (7 - (index & 7))) & 1) === 1;
+        index++;
+      }
+    }
+  }
+}
+
+function applyMask(matrix: Matrix, mask: number): void {
+  for (let row = 0; row < matrix.size; row++) {
+    for (let col = 0; col < matrix.size; col++) {
+      if (!matrix.reserved[row][col] && MASKS[mask](row, col)) {
+        matrix.modules[row][col] = !matrix.modules[row][col];
+      }
+    }
+  }
+}
+
+// Penalty score of a masked symbol; the mask with the lowest is used
+function penalty(modules: boolean[][]): number {
+  const size = modules.length;
+  let score = 0;
+  const lines = [...modules, ...modules.map((_, col) => modules.map((line) => line[col]))];
+
+  for (const line of lines) {
+    // Runs of five or more modules of one color
+    let run = 1;
+    for (let i = 1; i <= size; i++) {
+      if (i < size && line[i] === line[i - 1]) {
+        run++;
+      } else {
+        if (run >= 5) score += run - 2;
+        run = 1;
+      }
+    }
+    // Patterns looking like a finder (1:1:3:1:1 with four light modules on one side)
+    const text = line.map((dark) => (dark ? '1' : '0')).join('');
+    for (const pattern of ['10111010000', '00001011101']) {
+      for (let i = text.indexOf(pattern); i !== -1; i = text.indexOf(pattern, i + 1)) {
+        score += 40;
+      }
+    }
+  }
+
+  // 2x2 blocks of one color
+  for (let row = 0; row < size - 1; row++) {
+    for (let col = 0; col < size - 1; col++) {
+      const dark = modules[row][col];
+      if (
+        modules[row][col + 1] === dark &&
+        modules[row + 1][col] === dark &&
+        modules[row + 1][col + 1] === dark
+      ) {
+        score += 3;
+      }
+    }
+  }
+
+  // Imbalance of dark and light modules
+  const dark = modules.reduce((sum, line) => sum + line.filter(Boolean).length, 0);
+  const total = size * size;
+  score += (Math.ceil(Math.abs(dark * 20 - total * 10) / total) - 1) * 10;
+  return score;
+}
+
+/**
+ * Modules of the QR code of a text (true = dark), without the quiet zone.
+ * `mask` forces a mask pattern (0-7) instead of the one with the lowest penalty.
+ */
+export function encodeQrCode(text: string, mask?: number): boolean[][] {
+  const bytes = new TextEncoder().encode(text);
+  const version =
+    VERSIONS.findIndex(({ blocks }, i) => {
+      const capacity = blocks.reduce((sum, size) => sum + size, 0);
+      return 4 + (i + 1 < 10 ? 8 : 16) + bytes.length * 8 <= capacity * 8;
+    }) + 1;
+  if (version === 0) {
+    throw new Error('Text is too long for a QR code');
+  }
+
+  const data = codewords(bytes, version);
+  const build = (maskPattern: number) => {
+    const matrix = new Matrix(17 + version * 4);
+    drawFunctionPatterns(matrix, version);
+    drawCodewords(matrix, data);
+    applyMask(matrix, maskPattern);
+    drawFormatBits(matrix, maskPattern);
+    return matrix.modules;
+  };
+
+  if (mask !== undefined) return build(mask);
+  let best = build(0);
+  let bestScore = penalty(best);
+  for (let candidate = 1; candidate < MASKS.length; candidate++) {
+    const modules = build(candidate);
+    const score = penalty(modules);
+    if (score < bestScore) {
+      best = modules;
+      bestScore = score;
+    }
+  }
+  return best;
+}
+
+/**
+ * QR code drawn with Unicode half blocks, two module rows per line, for terminals
+ * with a dark background
+ */
+export function qrCodeToText(modules: boolean[][]): string {
+  const quiet = 2;
+  const size = modules.length + quiet * 2;
+  const dark = (row: number, col: number) => modules[row - quiet]?.[col - quiet] === true;
+  const lines: string[] = [];
+  for (let row = 0; row < size; row += 2) {
+    let line = '';
+    for (let col = 0; col < size; col++) {
+      // Light modules are drawn (white on dark), dark ones are left blank
+      const top = !dark(row, col);
+      const bottom = row + 1 < size && !dark(row + 1, col);
+      line += top && bottom ? '█' : top ? '▀' : bottom ? '▄' : ' ';
+    }
+    lines.push(line);
+  }
+  return lines.join('\n');
+}
@@ -0,0 +1,112 @@
+/**
+ * session.json schema - The versioned format of session files in control directories
+ *
+ * Session files are written by the server and `vibetunnel fwd` and read by every
+ * server sharing the control directory. Each file carries a `version`; reading
+ * migrates older files one version at a time to the current one, so all readers
+ * see the same SessionInfo and writers never need to duplicate fields under old
+ * names. Version 0 is every file from before versioning, including the
+ * snake_case files of the earlier Go and Rust servers.
+ *
+ * Files from a newer version are read as they are as long as the fields this
+ * version needs are valid; later versions only add fields.
+ */
+
+import type { SessionInfo, SessionStatus } from './types.js';
+
+export const SESSION_SCHEMA_VERSION = 1;
+
+type SessionFile = Record<string, unknown>;
+
+// Names used before version 1 and what they are called now
+const LEGACY_FIELDS: Record<string, keyof SessionInfo> = {
+  session_id: 'id',
+  cmdline: 'command',
+  cwd: 'workingDir',
+  started_at: 'startedAt',
+  exit_code: 'exitCode',
+};
+// Written by the Go server only, with no equivalent
+const DROPPED_LEGACY_FIELDS = ['term', 'spawn_type'];
+
+const STATUSES: SessionStatus[] = ['starting', 'running', 'exited'];
+
+/**
+ * Step `i` turns a version `i` file into a version `i + 1` one
+ */
+const MIGRATIONS: Array<(file: SessionFile) => SessionFile> = [
+  // 0 → 1: camelCase names; where a file has both (written for old readers), the new one wins
+  (file) => {
+    const migrated: SessionFile = {};
+    for (const [key, value] of Object.entries(file)) {
+      if (DROPPED_LEGACY_FIELDS.includes(key)) continue;
+      const name = LEGACY_FIELDS[key] ?? key;
+      if (name !== key && file[name] !== undefined) continue;
+      migrated[name] = value;
+    }
+    // Version 0 files of the Go server stored the command line as one string
+    if (typeof migrated.command === 'string') {
+      migrated.command = [migrated.command];
+    }
+    return migrated;
+  },
+];
+
+export class SessionSchemaError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'SessionSchemaError';
+  }
+}
+
+/**
+ * Read parsed session.json content as the current SessionInfo
+ *
+ * @param sessionId - ID to use if the file has none (the name of its directory)
+ * @throws SessionSchemaError if the file is not a valid session of any version
+ */
+export function parseSessionInfo(data: unknown, sessionId?: string): SessionInfo {
+  if (!data || typeof data !== 'object' || Array.isArray(data)) {
+    throw new SessionSchemaError('session file is not a JSON object');
+  }
+  const { version = 0, ...fields } = data as SessionFile;
+  if (typeof version !== 'number' || !Number.isInteger(version) || version < 0) {
+    throw new SessionSchemaError(`invalid session file version: ${String(version)}`);
+  }
+
+  let file: SessionFile = fields;
+  for (let step = version; step < SESSION_SCHEMA_VERSION; step++) {
+    file = MIGRATIONS[step](file);
+  }
+
+  const id = typeof file.id === 'string' && file.id !== '' ? file.id : sessionId;
+  if (!id) {
+    throw new SessionSchemaError('session file has no id');
+  }
+  const { command, workingDir, status, startedAt } = file;
+  if (!Array.isArray(command) || !command.every((arg) => typeof arg === 'string')) {
+    throw new SessionSchemaError(`session ${id}: command must be an array of strings`);
+  }
+  if (typeof workingDir !== 'string') {
+    throw new SessionSchemaError(`session ${id}: workingDir must be a string`);
+  }
+  if (!STATUSES.includes(status as SessionStatus)) {
+    throw new SessionSchemaError(`session ${id}: invalid status ${String(status)}`);
+  }
+  if (typeof startedAt !== 'string') {
+    throw new SessionSchemaError(`session ${id}: startedAt must be a string`);
+  }
+
+  const name =
+    typeof file.name === 'string' && file.name !== ''
+      ? file.name
+      : (command[0]?.split('/').pop() ?? id);
+  return { ...file, id, name } as SessionInfo;
+}
+
+/**
+ * session.json content for a session, in the current version
+ */
+export function serializeSessionInfo(sessionInfo: SessionInfo): string {
+  return JSON.stringify({ version: SESSION_SCHEMA_VERSION, ...sessionInfo }, null, 2);
+}
@@ -7,6 +7,11 @@
  */
 export type SessionStatus = 'starting' | 'running' | 'exited';
 
+/**
+ * CPU and I/O scheduling priority of a session's processes
+ */
+export type SessionPriority = 'high' | 'normal' | 'low' | 'background';
+
 /**
  * Core session information stored in session.json
  * Minimal, clean data persisted to disk
@@ -20,6 +25,95 @@ export interface SessionInfo {
   exitCode?: number;
   startedAt: string;
   pid?: number;
+  // Process holding the session's PTY: the server, or the fwd process that started the session
+  hostPid?: number;
+  // The host died before the command; the command may still run, without a terminal
+  hostLost?: boolean;
+  // Environment changes (null: unset) for the commands the session runs next
+  pendingEnv?: Record<string, string | null>;
+  // The shell picks up environment changes at its next prompt (see session-init.ts)
+  envHook?: boolean;
+  // User that created the session through the API (counted for per-user session limits)
+  createdBy?: string;
+  // Local account the session runs as, in local user mode
+  runAs?: string;
+  // Directory the shell last reported with OSC 7 (workingDir is where the session started)
+  currentWorkingDir?: string;
+  // Set by users through PATCH /api/sessions/:sessionId/metadata
+  description?: string;
+  fields?: Record<string, string>;
+  // Set by a trigger's mark action, until cleared
+  mark?: SessionMark;
+  // First failure of one of the session's output or watch tasks
+  crash?: SessionCrash;
+  // Where the finished recording was archived
+  archive?: SessionArchive;
+  // Labels given at creation, used to route the session to a control root
+  tags?: string[];
+  // Template the session was launched from (POST /api/templates/:name/launch)
+  template?: string;
+  // Ports the session's template declares its services listen on
+  ports?: number[];
+  // ID of the workspace the session was created in
+  workspace?: string;
+  // Name of the control root holding the session (set when several are configured)
+  controlRoot?: string;
+  // Set at creation or later; absent means normal
+  priority?: SessionPriority;
+  // Directory for the session's artifacts (VIBETUNNEL_SCRATCH_DIR), removed with the session
+  scratchDir?: string;
+  // Reported by AI agent wrappers (control message `agent`)
+  agentType?: string;
+  taskDescription?: string;
+  tokensUsed?: number;
+  agentUpdatedAt?: string;
+}
+
+/**
+ * Flag a trigger put on a session (e.g. "Build failed")
+ */
+export interface SessionMark {
+  label: string;
+  triggerId?: string;
+  markedAt: string;
+}
+
+/**
+ * A task of a session that threw (see GET /api/crashes/:id for the stack)
+ */
+export interface SessionCrash {
+  id: string;
+  // e.g. pty-output, terminal-watch
+  task: string;
+  message: string;
+  crashedAt: string;
+}
+
+/**
+ * Recording of a session stored in object storage
+ */
+export interface SessionArchive {
+  bucket: string;
+  key: string;
+  // Bytes stored (after compression)
+  size: number;
+  compressed: boolean;
+  archivedAt: string;
+  // The local cast file was removed; it is downloaded again for playback
+  localRemoved: boolean;
+}
+
+/**
+ * Timestamped note on a session's recording, merged into playback as a marker
+ */
+export interface SessionAnnotation {
+  id: string;
+  // Seconds into the recording
+  time: number;
+  text: string;
+  // User that added it
+  author?: string;
+  createdAt: string;
 }
 
 /**
@@ -35,6 +129,20 @@ export interface Session extends SessionInfo {
   remoteId?: string;
   remoteName?: string;
   remoteUrl?: string;
+  // Remote names from this server to the one running the session (longer behind federated HQs)
+  remotePath?: string[];
+  // Screen preview, when requested with ?thumbnails
+  thumbnail?: SessionThumbnail;
+}
+
+/**
+ * Text preview of a session: the last non-blank lines of its screen
+ */
+export interface SessionThumbnail {
+  lines: string[];
+  cols: number;
+  rows: number;
+  updatedAt: string;
 }
 
 /**
@@ -55,20 +163,54 @@ export interface SessionCreateOptions {
   workingDir?: string;
   cols?: number;
   rows?: number;
+  // Create a scratch directory for the session
+  scratch?: boolean;
 }
 
 /**
  * Session input (keyboard/special keys)
+ *
+ * With `composition` set, the input is an IME composition event and `text` is
+ * the current composition; only `commit` writes `text` to the terminal.
  */
 export interface SessionInput {
   text?: string;
-  key?: SpecialKey;
+  // A SpecialKey, optionally with modifiers (e.g. "ctrl_arrow_left"); see shared/keymap.ts
+  key?: SpecialKey | (string & {});
+  composition?: CompositionPhase;
+}
+
+/**
+ * IME composition phases
+ */
+export type CompositionPhase = 'start' | 'update' | 'commit' | 'cancel';
+
+/**
+ * Batch of inputs with a client-assigned sequence number. Batches from the same
+ * client are applied in sequence order exactly once; retries are acknowledged
+ * without being applied again.
+ */
+export interface SessionInputBatch {
+  clientId?: string;
+  seq: number;
+  inputs: SessionInput[];
+}
+
+/**
+ * Acknowledgement for an input batch
+ */
+export interface SessionInputAck {
+  // Highest sequence number applied for this client
+  ack: number;
+  status: 'applied' | 'duplicate' | 'queued';
 }
 
 /**
  * Special keys that can be sent to sessions
  */
 export type SpecialKey =
+  | 'insert'
+  | 'space'
   | 'arrow_up'
   | 'arrow_down'
   | 'arrow_left'
@@ -0,0 +1,256 @@
+import path from 'path';
+import { afterAll, beforeAll, describe, expect, it } from 'vitest';
+import {
+  CLEANUP_EXITED,
+  DIRECTORY_CREATED,
+  DIRECTORY_LISTING,
+  ERROR,
+  FILE_CONTENT,
+  HEALTH,
+  RESIZED,
+  SESSION,
+  SESSION_CREATED,
+  type Shape,
+  SUCCESS,
+  shapeErrors,
+} from './shapes';
+import { type ConformanceTarget, startConformanceTarget, uniqueName } from './target';
+
+// Black-box checks of the HTTP API: status codes and JSON shapes every server
+// implementation must agree on. See target.ts for choosing the server.
+describe('HTTP API conformance', () => {
+  let target: ConformanceTarget;
+  let sessionId: string;
+  let sessionDir: string;
+
+  async function request(
+    method: string,
+    apiPath: string,
+    body?: unknown
+  ): Promise<{ status: number; json: unknown }> {
+    const response = await fetch(`${target.baseUrl}/api${apiPath}`, {
+      method,
+      headers: {
+        ...target.headers,
+        ...(body === undefined ? {} : { 'Content-Type': 'application/json' }),
+      },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    const text = await response.text();
+    let json: unknown = text;
+    try {
+      json = JSON.parse(text);
+    } catch {
+      // Compared as text, which no shape accepts
+    }
+    return { status: response.status, json };
+  }
+
+  async function expectResponse(
+    method: string,
+    apiPath: string,
+    body: unknown,
+    status: number,
+    shape: Shape
+  ): Promise<Record<string, unknown>> {
+    const response = await request(method, apiPath, body);
+    expect({ status: response.status, body: response.json }).toMatchObject({ status });
+    expect(shapeErrors(response.json, shape)).toEqual([]);
+    return response.json as Record<string, unknown>;
+  }
+
+  async function waitFor<T>(check: () => Promise<T | undefined>, timeoutMs = 10000): Promise<T> {
+    const deadline = Date.now() + timeoutMs;
+    while (Date.now() < deadline) {
+      const result = await check();
+      if (result !== undefined) return result;
+      await new Promise((resolve) => setTimeout(resolve, 200));
+    }
+    throw new Error(`condition not met within ${timeoutMs}ms`);
+  }
+
+  beforeAll(async () => {
+    target = await startConformanceTarget();
+  });
+
+  afterAll(async () => {
+    if (sessionId) {
+      await request('DELETE', `/sessions/${sessionId}`).catch(() => undefined);
+      await request('DELETE', `/sessions/${sessionId}/cleanup`).catch(() => undefined);
+    }
+    await target?.stop();
+  });
+
+  describe('health', () => {
+    it('GET /health', async () => {
+      await expectResponse('GET', '/health', undefined, 200, HEALTH);
+    });
+  });
+
+  describe('sessions', () => {
+    it('POST /sessions rejects a missing command', async () => {
+      await expectResponse('POST', '/sessions', { workingDir: target.workDir }, 400, ERROR);
+    });
+
+    it('POST /sessions creates a session', async () => {
+      const created = await expectResponse(
+        'POST',
+        '/sessions',
+        { command: ['sh'], workingDir: target.workDir, name: 'conformance' },
+        200,
+        SESSION_CREATED
+      );
+      sessionId = created.sessionId as string;
+    });
+
+    it('GET /sessions lists it', async () => {
+      const sessions = await expectResponse('GET', '/sessions', undefined, 200, [SESSION]);
+      const session = (sessions as unknown as Array<Record<string, unknown>>).find(
+        (s) => s.id === sessionId
+      );
+      expect(session).toMatchObject({ name: 'conformance', command: ['sh'] });
+      sessionDir = session?.workingDir as string;
+    });
+
+    it('GET /sessions/:id', async () => {
+      const sessionPath = `/sessions/${sessionId}`;
+      const session = await expectResponse('GET', sessionPath, undefined, 200, SESSION);
+      expect(session.id).toBe(sessionId);
+    });
+
+    it('GET /sessions/:id of an unknown session', async () => {
+      await expectResponse('GET', '/sessions/no-such-session', undefined, 404, ERROR);
+    });
+
+    it('POST /sessions/:id/input and GET /sessions/:id/stream', async () => {
+      const response = await fetch(`${target.baseUrl}/api/sessions/${sessionId}/stream`, {
+        headers: { ...target.headers, Accept: 'text/event-stream' },
+      });
+      expect(response.status).toBe(200);
+      expect(response.headers.get('content-type')).toMatch(/^text\/event-stream/);
+
+      const marker = uniqueName('marker');
+      const input = { text: `echo ${marker}\n` };
+      await expectResponse('POST', `/sessions/${sessionId}/input`, input, 200, SUCCESS);
+
+      // Output arrives as asciinema events in `data:` lines
+      const reader = response.body?.getReader();
+      if (!reader) throw new Error('stream has no body');
+      const decoder = new TextDecoder();
+      let received = '';
+      const deadline = Date.now() + 10000;
+      while (!received.includes(marker) && Date.now() < deadline) {
+        const { done, value } = await reader.read();
+        if (done) break;
+        received += decoder.decode(value, { stream: true });
+      }
+      await reader.cancel();
+
+      const events = received
+        .split('\n')
+        .filter((line) => line.startsWith('data:'))
+        .map((line) => JSON.parse(line.slice('data:'.length)));
+      expect(events.length).toBeGreaterThan(0);
+      expect(JSON.stringify(events)).toContain(marker);
+    });
+
+    it('POST /sessions/:id/input rejects text and key together', async () => {
+      const body = { text: 'a', key: 'enter' };
+      await expectResponse('POST', `/sessions/${sessionId}/input`, body, 400, ERROR);
+    });
+
+    it('POST /sessions/:id/resize', async () => {
+      const resized = await expectResponse(
+        'POST',
+        `/sessions/${sessionId}/resize`,
+        { cols: 100, rows: 30 },
+        200,
+        RESIZED
+      );
+      expect(resized).toMatchObject({ success: true, cols: 100, rows: 30 });
+    });
+
+    it('POST /sessions/:id/resize rejects invalid sizes', async () => {
+      const body = { cols: 0, rows: 30 };
+      await expectResponse('POST', `/sessions/${sessionId}/resize`, body, 400, ERROR);
+    });
+  });
+
+  describe('filesystem', () => {
+    const fileName = uniqueName('conformance');
+
+    it('POST /fs/mkdir', async () => {
+      const body = { path: sessionDir, name: uniqueName('dir') };
+      await expectResponse('POST', '/fs/mkdir', body, 200, DIRECTORY_CREATED);
+    });
+
+    it('GET /fs/browse', async () => {
+      // The session writes a file, so the server sees it like any other
+      await request('POST', `/sessions/${sessionId}/input`, {
+        text: `printf conformance > ${fileName}\n`,
+      });
+      const query = new URLSearchParams({ path: sessionDir });
+      const listing = await waitFor(async () => {
+        const response = await request('GET', `/fs/browse?${query}`);
+        const files = (response.json as { files?: Array<{ name: string }> }).files ?? [];
+        return files.some((file) => file.name === fileName) ? response : undefined;
+      });
+      expect(listing.status).toBe(200);
+      expect(shapeErrors(listing.json, DIRECTORY_LISTING)).toEqual([]);
+    });
+
+    it('GET /fs/content', async () => {
+      const query = new URLSearchParams({ path: path.posix.join(sessionDir, fileName) });
+      const contentPath = `/fs/content?${query}`;
+      const file = await expectResponse('GET', contentPath, undefined, 200, FILE_CONTENT);
+      expect(file.content).toBe('conformance');
+    });
+
+    it('GET /fs/browse of a missing directory', async () => {
+      const query = new URLSearchParams({ path: path.posix.join(sessionDir, uniqueName('none')) });
+      await expectResponse('GET', `/fs/browse?${query}`, undefined, 404, ERROR);
+    });
+
+    it('POST /fs/mkdir without a name', async () => {
+      await expectResponse('POST', '/fs/mkdir', { path: sessionDir }, 400, ERROR);
+    });
+  });
+
+  describe('remotes', () => {
+    it('GET /remotes', async () => {
+      const health = await request('GET', '/health');
+      if ((health.json as { mode?: string }).mode === 'hq') {
+        await expectResponse('GET', '/remotes', undefined, 200, [
+          { id: 'string', name: 'string', url: 'string', sessionIds: ['string'] },
+        ]);
+      } else {
+        await expectResponse('GET', '/remotes', undefined, 404, ERROR);
+      }
+    });
+  });
+
+  describe('ending sessions', () => {
+    it('DELETE /sessions/:id kills it', async () => {
+      await expectResponse('DELETE', `/sessions/${sessionId}`, undefined, 200, SUCCESS);
+      await waitFor(async () => {
+        const response = await request('GET', `/sessions/${sessionId}`);
+        const status = (response.json as { status?: string }).status;
+        return response.status === 404 || status === 'exited' ? true : undefined;
+      });
+    });
+
+    it('DELETE /sessions/:id/cleanup', async () => {
+      await expectResponse('DELETE', `/sessions/${sessionId}/cleanup`, undefined, 200, SUCCESS);
+      await expectResponse('GET', `/sessions/${sessionId}`, undefined, 404, ERROR);
+      sessionId = '';
+    });
+
+    it('POST /cleanup-exited', async () => {
+      await expectResponse('POST', '/cleanup-exited', undefined, 200, CLEANUP_EXITED);
+    });
+
+    it('DELETE /sessions/:id of an unknown session', async () => {
+      await expectResponse('DELETE', '/sessions/no-such-session', undefined, 404, ERROR);
+    });
+  });
+});
@@ -0,0 +1,100 @@
+/**
+ * JSON shapes of the HTTP API that every server implementation must return
+ *
+ * A shape names the fields a response needs and their types. Implementations
+ * may add fields; missing fields or other types are differences.
+ */
+
+export type Shape =
+  | 'string'
+  | 'number'
+  | 'boolean'
+  | 'any'
+  | Optional
+  | [Shape]
+  | { [field: string]: Shape };
+
+class Optional {
+  constructor(readonly shape: Shape) {}
+}
+
+/**
+ * A field that may be missing (or null)
+ */
+export function optional(shape: Shape): Optional {
+  return new Optional(shape);
+}
+
+/**
+ * Where a value differs from a shape, as `$.field[0]: expected ...` lines
+ */
+export function shapeErrors(value: unknown, shape: Shape, at = '$'): string[] {
+  if (shape instanceof Optional) {
+    return value === undefined || value === null ? [] : shapeErrors(value, shape.shape, at);
+  }
+  if (shape === 'any') {
+    return value === undefined ? [`${at}: missing`] : [];
+  }
+  if (typeof shape === 'string') {
+    return typeof value === shape ? [] : [`${at}: expected ${shape}, got ${describe(value)}`];
+  }
+  if (Array.isArray(shape)) {
+    if (!Array.isArray(value)) return [`${at}: expected array, got ${describe(value)}`];
+    return value.flatMap((item, index) => shapeErrors(item, shape[0], `${at}[${index}]`));
+  }
+  if (!value || typeof value !== 'object' || Array.isArray(value)) {
+    return [`${at}: expected object, got ${describe(value)}`];
+  }
+  const record = value as Record<string, unknown>;
+  return Object.entries(shape).flatMap(([field, fieldShape]) =>
+    shapeErrors(record[field], fieldShape, `${at}.${field}`)
+  );
+}
+
+function describe(value: unknown): string {
+  if (value === undefined) return 'nothing';
+  if (value === null) return 'null';
+  return Array.isArray(value) ? 'array' : typeof value;
+}
+
+export const ERROR: Shape = { error: 'string' };
+
+export const HEALTH: Shape = { status: 'string', timestamp: 'string', mode: 'string' };
+
+export const SESSION: Shape = {
+  id: 'string',
+  name: 'string',
+  command: ['string'],
+  workingDir: 'string',
+  status: 'string',
+  startedAt: 'string',
+  lastModified: 'string',
+  pid: optional('number'),
+  exitCode: optional('number'),
+};
+
+export const SESSION_CREATED: Shape = { sessionId: 'string' };
+
+export const SUCCESS: Shape = { success: 'boolean' };
+
+export const RESIZED: Shape = { success: 'boolean', cols: 'number', rows: 'number' };
+
+export const CLEANUP_EXITED: Shape = { success: 'boolean', message: 'string' };
+
+export const FILE_INFO: Shape = {
+  name: 'string',
+  path: 'string',
+  type: 'string',
+  size: 'number',
+  modified: 'string',
+};
+
+export const DIRECTORY_LISTING: Shape = {
+  path: 'string',
+  fullPath: 'string',
+  files: [FILE_INFO],
+};
+
+export const FILE_CONTENT: Shape = { path: 'string', content: 'string' };
+
+export const DIRECTORY_CREATED: Shape = { success: 'boolean', path: 'string' };
@@ -0,0 +1,69 @@
+import fs from 'fs';
+import {
+  cleanupTestDirectories,
+  createTestDirectory,
+  startTestServer,
+  stopServer,
+  waitForServerHealth,
+} from '../utils/server-utils';
+
+/**
+ * Server the conformance suite runs against
+ *
+ * - `VIBETUNNEL_CONFORMANCE_URL`: an already running server (any implementation);
+ *   `VIBETUNNEL_CONFORMANCE_TOKEN` is sent as bearer token if it needs auth, and
+ *   `VIBETUNNEL_CONFORMANCE_WORKDIR` names a directory on its host sessions may
+ *   run in (default: /tmp)
+ * - `VIBETUNNEL_CONFORMANCE_SERVER`: a server executable, started with
+ *   `--port 0 --no-auth` like the Node CLI
+ * - Neither: the Node server of this tree
+ */
+export interface ConformanceTarget {
+  baseUrl: string;
+  headers: Record<string, string>;
+  // Directory on the server's host for sessions and file tests
+  workDir: string;
+  stop(): Promise<void>;
+}
+
+export async function startConformanceTarget(): Promise<ConformanceTarget> {
+  const url = process.env.VIBETUNNEL_CONFORMANCE_URL;
+  if (url) {
+    const token = process.env.VIBETUNNEL_CONFORMANCE_TOKEN;
+    return {
+      baseUrl: url.replace(/\/$/, ''),
+      headers: token ? { Authorization: `Bearer ${token}` } : {},
+      workDir: process.env.VIBETUNNEL_CONFORMANCE_WORKDIR || '/tmp',
+      stop: async () => {},
+    };
+  }
+
+  const workDir = createTestDirectory('vtc');
+  const server = await startTestServer({
+    args: ['--port', '0', '--no-auth'],
+    binary: process.env.VIBETUNNEL_CONFORMANCE_SERVER,
+    logOutput: false,
+    serverType: 'CONFORMANCE',
+  });
+  if (!(await waitForServerHealth(server.port))) {
+    await stopServer(server.process);
+    throw new Error('conformance server did not become healthy');
+  }
+  return {
+    baseUrl: `http://localhost:${server.port}`,
+    headers: {},
+    // Symlinks resolved, as servers report real paths
+    workDir: fs.realpathSync(workDir),
+    stop: async () => {
+      await stopServer(server.process);
+      await cleanupTestDirectories([workDir, server.controlDir]);
+    },
+  };
+}
+
+/**
+ * A name for files and directories that no earlier run left behind
+ */
+export function uniqueName(prefix: string): string {
+  return `${prefix}-${Date.now().toString(36)}-${Math.random().toString(36).slice(2, 8)}`;
+}
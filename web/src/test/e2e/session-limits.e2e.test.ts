@@ -0,0 +1,73 @@
+import { afterAll, beforeAll, describe, expect, it } from 'vitest';
+import {
+  cleanupTestDirectories,
+  createTestDirectory,
+  type ServerInstance,
+  startTestServer,
+  stopServer,
+  waitForServerHealth,
+} from '../utils/server-utils';
+
+describe('Session Limits', () => {
+  let server: ServerInstance | null = null;
+  let testDir: string;
+
+  beforeAll(async () => {
+    testDir = createTestDirectory('sl');
+    server = await startTestServer({
+      args: ['--port', '0', '--no-auth', '--max-sessions', '2'],
+      controlDir: testDir,
+      serverType: 'SESSION_LIMITS_TEST',
+    });
+    await waitForServerHealth(server.port);
+  });
+
+  afterAll(async () => {
+    if (server) {
+      await stopServer(server.process);
+    }
+    await cleanupTestDirectories([testDir]);
+  });
+
+  const createSession = (name: string) =>
+    fetch(`http://localhost:${server?.port}/api/sessions`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ command: ['sleep', '30'], workingDir: testDir, name }),
+    });
+
+  it('should refuse sessions over the limit and report the count', async () => {
+    const sessionIds: string[] = [];
+    for (const name of ['first', 'second']) {
+      const response = await createSession(name);
+      expect(response.status).toBe(200);
+      sessionIds.push((await response.json()).sessionId);
+    }
+
+    const refused = await createSession('third');
+    expect(refused.status).toBe(429);
+    expect(await refused.json()).toMatchObject({
+      code: 'SESSION_LIMIT_REACHED',
+      scope: 'server',
+      limit: 2,
+      current: 2,
+    });
+
+    const limits = await fetch(`http://localhost:${server?.port}/api/sessions/limits`);
+    expect(await limits.json()).toMatchObject({ maxSessions: 2, running: 2 });
+
+    // Killed sessions no longer count
+    await fetch(`http://localhost:${server?.port}/api/sessions/${sessionIds[0]}`, {
+      method: 'DELETE',
+    });
+    const accepted = await createSession('third');
+    expect(accepted.status).toBe(200);
+    sessionIds.push((await accepted.json()).sessionId);
+
+    await Promise.all(
+      sessionIds.map((id) =>
+        fetch(`http://localhost:${server?.port}/api/sessions/${id}`, { method: 'DELETE' })
+      )
+    );
+  });
+});
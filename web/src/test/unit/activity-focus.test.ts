@@ -0,0 +1,74 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { ActivityFocus } from '../../server/services/activity-focus';
+
+describe('ActivityFocus', () => {
+  let focus: ActivityFocus;
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    focus = new ActivityFocus();
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+  });
+
+  it('should focus the first session with output', () => {
+    const changed = vi.fn();
+    focus.on('focus-changed', changed);
+    expect(focus.getFocus().sessionId).toBeNull();
+
+    focus.recordOutput('s1');
+    focus.recordOutput('s1');
+    expect(changed).toHaveBeenCalledOnce();
+    expect(focus.getFocus()).toMatchObject({ sessionId: 's1', reason: 'output' });
+  });
+
+  it('should keep the focus while it dwells or is busy', () => {
+    focus.recordOutput('s1');
+    vi.advanceTimersByTime(1000);
+    focus.recordOutput('s2');
+    expect(focus.getFocus().sessionId).toBe('s1');
+
+    // Past the dwell time, but s1 is still printing
+    vi.advanceTimersByTime(2500);
+    focus.recordOutput('s1');
+    vi.advanceTimersByTime(500);
+    focus.recordOutput('s2');
+    expect(focus.getFocus().sessionId).toBe('s1');
+
+    // s1 went quiet
+    vi.advanceTimersByTime(1500);
+    focus.recordOutput('s2');
+    expect(focus.getFocus().sessionId).toBe('s2');
+  });
+
+  it('should move to a bell right away and hold it', () => {
+    focus.recordOutput('s1');
+    focus.recordBell('s2');
+    expect(focus.getFocus()).toMatchObject({ sessionId: 's2', reason: 'bell' });
+
+    focus.recordBell('s3');
+    vi.advanceTimersByTime(5000);
+    focus.recordOutput('s1');
+    expect(focus.getFocus().sessionId).toBe('s2');
+
+    vi.advanceTimersByTime(5000);
+    focus.recordBell('s3');
+    expect(focus.getFocus()).toMatchObject({ sessionId: 's3', reason: 'bell' });
+  });
+
+  it('should pass the focus on when the focused session exits', () => {
+    focus.recordOutput('s1');
+    vi.advanceTimersByTime(100);
+    focus.recordOutput('s2');
+    vi.advanceTimersByTime(100);
+    focus.recordOutput('s3');
+
+    focus.removeSession('s1');
+    expect(focus.getFocus()).toMatchObject({ sessionId: 's3', reason: 'exited' });
+    focus.removeSession('s3');
+    focus.removeSession('s2');
+    expect(focus.getFocus().sessionId).toBeNull();
+  });
+});
@@ -0,0 +1,45 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { AnnotationStore } from '../../server/services/annotation-store';
+
+const SESSION_ID = 'session-1';
+
+describe('AnnotationStore', () => {
+  let controlDir: string;
+  let store: AnnotationStore;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'annotations-'));
+    fs.mkdirSync(path.join(controlDir, SESSION_ID));
+    store = new AnnotationStore(controlDir);
+  });
+
+  afterEach(() => {
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should keep annotations sorted by time next to the cast', () => {
+    store.add(SESSION_ID, { time: 12, text: 'bug reproduced here', author: 'alice' });
+    store.add(SESSION_ID, { time: 3.5, text: 'deploy starts' });
+
+    expect(store.list(SESSION_ID).map((annotation) => annotation.text)).toEqual([
+      'deploy starts',
+      'bug reproduced here',
+    ]);
+    expect(fs.existsSync(path.join(controlDir, SESSION_ID, 'annotations.json'))).toBe(true);
+  });
+
+  it('should delete annotations', () => {
+    const annotation = store.add(SESSION_ID, { time: 1, text: 'note' });
+
+    expect(store.delete(SESSION_ID, 'unknown')).toBe(false);
+    expect(store.delete(SESSION_ID, annotation.id)).toBe(true);
+    expect(store.list(SESSION_ID)).toEqual([]);
+  });
+
+  it('should list no annotations for sessions without any', () => {
+    expect(store.list('other-session')).toEqual([]);
+  });
+});
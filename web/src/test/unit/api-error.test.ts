@@ -0,0 +1,103 @@
+import type { NextFunction, Request, Response } from 'express';
+import { describe, expect, it, vi } from 'vitest';
+import { apiErrorHandler, errorEnvelope } from '../../server/middleware/error-envelope';
+import { errorBody, sendError } from '../../server/utils/api-error';
+import { ERROR_CODES, isErrorCode } from '../../shared/error-codes';
+
+function createResponse() {
+  const sent: unknown[] = [];
+  const res = {
+    statusCode: 200,
+    headersSent: false,
+    status: vi.fn(function (this: { statusCode: number }, code: number) {
+      this.statusCode = code;
+      return this;
+    }),
+    json: vi.fn((body: unknown) => {
+      sent.push(body);
+      return res;
+    }),
+  } as unknown as Response;
+  return { res, sent };
+}
+
+// Response passed through the envelope middleware
+function enveloped() {
+  const { res, sent } = createResponse();
+  errorEnvelope({} as Request, res, vi.fn() as NextFunction);
+  return { res, sent };
+}
+
+describe('sendError', () => {
+  it('should send the registered status and default message', () => {
+    const { res, sent } = createResponse();
+    sendError(res, 'SESSION_NOT_FOUND');
+
+    expect(res.status).toHaveBeenCalledWith(404);
+    expect(sent[0]).toEqual({
+      code: 'SESSION_NOT_FOUND',
+      message: 'Session not found',
+      error: 'Session not found',
+    });
+  });
+
+  it('should include details', () => {
+    expect(errorBody('PTY_CREATE_FAILED', undefined, 'spawn failed')).toMatchObject({
+      code: 'PTY_CREATE_FAILED',
+      details: 'spawn failed',
+    });
+  });
+});
+
+describe('errorEnvelope', () => {
+  it('should add a generic code and message to plain error responses', () => {
+    const { res, sent } = enveloped();
+    res.status(400).json({ error: 'Path is required' });
+
+    expect(sent[0]).toEqual({
+      code: 'INVALID_REQUEST',
+      message: 'Path is required',
+      error: 'Path is required',
+    });
+  });
+
+  it('should keep codes and extra fields set by handlers', () => {
+    const { res, sent } = enveloped();
+    res.status(429).json({ error: 'Limit', code: 'SESSION_LIMIT_REACHED', limit: 2 });
+
+    expect(sent[0]).toEqual({
+      code: 'SESSION_LIMIT_REACHED',
+      message: 'Limit',
+      error: 'Limit',
+      limit: 2,
+    });
+  });
+
+  it('should leave successful responses alone', () => {
+    const { res, sent } = enveloped();
+    res.json({ error: 'not an error response' });
+
+    expect(sent[0]).toEqual({ error: 'not an error response' });
+  });
+});
+
+describe('apiErrorHandler', () => {
+  it('should report malformed JSON bodies', () => {
+    const { res, sent } = createResponse();
+    const error = Object.assign(new Error('Unexpected token'), { type: 'entity.parse.failed' });
+    apiErrorHandler(error, {} as Request, res, vi.fn());
+
+    expect(res.status).toHaveBeenCalledWith(400);
+    expect(sent[0]).toMatchObject({ code: 'INVALID_JSON', details: 'Unexpected token' });
+  });
+});
+
+describe('ERROR_CODES', () => {
+  it('should register error statuses only', () => {
+    for (const [code, { status }] of Object.entries(ERROR_CODES)) {
+      expect(isErrorCode(code)).toBe(true);
+      expect(status).toBeGreaterThanOrEqual(400);
+    }
+    expect(isErrorCode('toString')).toBe(false);
+  });
+});
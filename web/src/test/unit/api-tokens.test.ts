@@ -0,0 +1,124 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { type AuthenticatedRequest, createAuthMiddleware } from '../../server/middleware/auth';
+import {
+  ApiTokenStore,
+  hasScope,
+  requiredBatchScope,
+  requiredScope,
+} from '../../server/services/api-tokens';
+
+describe('requiredScope', () => {
+  it('should map endpoints to scopes', () => {
+    expect(requiredScope('GET', '/sessions')).toBe('sessions:read');
+    expect(requiredScope('POST', '/sessions/abc/input')).toBe('sessions:write');
+    expect(requiredScope('GET', '/buffers')).toBe('sessions:read');
+    expect(requiredScope('GET', '/fs/browse')).toBe('fs:read');
+    expect(requiredScope('POST', '/fs/mkdir')).toBe('fs:write');
+    expect(requiredScope('POST', '/mcp')).toBe('sessions:read');
+    expect(requiredScope('GET', '/admin/config')).toBe('admin');
+    expect(requiredScope('GET', '/remotes')).toBe('admin');
+  });
+
+  it('should require admin for batch queries of admin resources', () => {
+    expect(requiredBatchScope('sessions')).toBe('sessions:read');
+    expect(requiredBatchScope('groups')).toBe('sessions:read');
+    expect(requiredBatchScope('remotes')).toBe('admin');
+    expect(requiredBatchScope('stats')).toBe('admin');
+  });
+
+  it('should let admin imply every scope', () => {
+    expect(hasScope(['admin'], 'fs:write')).toBe(true);
+    expect(hasScope(['sessions:read'], 'sessions:write')).toBe(false);
+  });
+});
+
+describe('ApiTokenStore', () => {
+  let tempDir: string;
+  let filePath: string;
+  let store: ApiTokenStore;
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'vt-api-tokens-'));
+    filePath = path.join(tempDir, 'api-tokens.json');
+    store = new ApiTokenStore(filePath);
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+    fs.rmSync(tempDir, { recursive: true, force: true });
+  });
+
+  it('should create, verify and revoke tokens', () => {
+    const { token, info } = store.create('alice', { name: 'ci', scopes: ['sessions:read'] });
+    expect(token.startsWith('vt_')).toBe(true);
+    expect(store.verify(token)).toMatchObject({ id: info.id, userId: 'alice' });
+    expect(store.verify(`${token}x`)).toBeNull();
+
+    // Only the hash is written to disk, and tokens survive a restart
+    expect(fs.readFileSync(filePath, 'utf8')).not.toContain(token);
+    expect(new ApiTokenStore(filePath).verify(token)?.scopes).toEqual(['sessions:read']);
+
+    expect(store.revoke(info.id)).toBe(true);
+    expect(store.verify(token)).toBeNull();
+    expect(store.list('alice')).toEqual([]);
+  });
+
+  it('should reject expired tokens', () => {
+    vi.useFakeTimers();
+    const { token } = store.create('alice', { name: 'ci', scopes: ['fs:read'], expiresInDays: 1 });
+    expect(store.verify(token)).not.toBeNull();
+    vi.advanceTimersByTime(86400000 + 1);
+    expect(store.verify(token)).toBeNull();
+  });
+
+  it('should validate new tokens', () => {
+    expect(() => store.create('alice', { name: ' ', scopes: ['fs:read'] })).toThrow('Name');
+    expect(() => store.create('alice', { name: 'ci', scopes: [] })).toThrow('scope');
+    expect(() =>
+      store.create('alice', { name: 'ci', scopes: ['fs:read'], expiresInDays: 0 })
+    ).toThrow('expiresInDays');
+  });
+
+  it('should enforce scopes in the auth middleware', () => {
+    const middleware = createAuthMiddleware({
+      enableSSHKeys: false,
+      disallowUserPassword: false,
+      noAuth: false,
+      isHQMode: false,
+      apiTokens: store,
+    });
+    const { token } = store.create('alice', { name: 'ci', scopes: ['sessions:read'] });
+
+    const run = (method: string, apiPath: string, bearer = token) => {
+      const req = {
+        method,
+        path: apiPath,
+        headers: { authorization: `Bearer ${bearer}` },
+        query: {},
+      } as unknown as AuthenticatedRequest;
+      const res = {
+        statusCode: 200,
+        setHeader: vi.fn(),
+        status(code: number) {
+          this.statusCode = code;
+          return this;
+        },
+        json: vi.fn(),
+      };
+      const next = vi.fn();
+      middleware(req, res as never, next);
+      return { req, status: next.mock.calls.length > 0 ? 'next' : res.statusCode };
+    };
+
+    const allowed = run('GET', '/sessions');
+    expect(allowed.status).toBe('next');
+    expect(allowed.req.userId).toBe('alice');
+    expect(allowed.req.authMethod).toBe('api-token');
+    expect(run('POST', '/sessions').status).toBe(403);
+    expect(run('GET', '/fs/browse').status).toBe(403);
+    expect(run('GET', '/sessions', 'vt_unknown').status).toBe(401);
+  });
+});
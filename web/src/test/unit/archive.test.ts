@@ -0,0 +1,116 @@
+import { spawnSync } from 'child_process';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import type { Readable } from 'stream';
+import * as zlib from 'zlib';
+import { afterAll, beforeAll, describe, expect, it } from 'vitest';
+import { archiveTypeOf, listArchive, openArchiveMember } from '../../server/utils/archive';
+
+async function readAll(stream: Readable): Promise<string> {
+  const chunks: Buffer[] = [];
+  for await (const chunk of stream) chunks.push(chunk as Buffer);
+  return Buffer.concat(chunks).toString('utf-8');
+}
+
+// A deflated zip of the given files, written by hand (no CRCs checked on read)
+function writeZip(file: string, members: Record<string, string>) {
+  const locals: Buffer[] = [];
+  const centrals: Buffer[] = [];
+  let offset = 0;
+  for (const [name, content] of Object.entries(members)) {
+    const data = zlib.deflateRawSync(Buffer.from(content));
+    const nameBytes = Buffer.from(name);
+    const local = Buffer.alloc(30);
+    local.writeUInt32LE(0x04034b50, 0);
+    local.writeUInt16LE(8, 8);
+    local.writeUInt32LE(data.length, 18);
+    local.writeUInt32LE(Buffer.byteLength(content), 22);
+    local.writeUInt16LE(nameBytes.length, 26);
+    const central = Buffer.alloc(46);
+    central.writeUInt32LE(0x02014b50, 0);
+    central.writeUInt16LE(8, 10);
+    central.writeUInt16LE(0x21, 14);
+    central.writeUInt32LE(data.length, 20);
+    central.writeUInt32LE(Buffer.byteLength(content), 24);
+    central.writeUInt16LE(nameBytes.length, 28);
+    central.writeUInt32LE(offset, 42);
+    locals.push(local, nameBytes, data);
+    centrals.push(central, nameBytes);
+    offset += 30 + nameBytes.length + data.length;
+  }
+  const directory = Buffer.concat(centrals);
+  const end = Buffer.alloc(22);
+  end.writeUInt32LE(0x06054b50, 0);
+  end.writeUInt16LE(Object.keys(members).length, 8);
+  end.writeUInt16LE(Object.keys(members).length, 10);
+  end.writeUInt32LE(directory.length, 12);
+  end.writeUInt32LE(offset, 16);
+  fs.writeFileSync(file, Buffer.concat([...locals, directory, end]));
+}
+
+describe('archives', () => {
+  let dir: string;
+  const big = 'log line\n'.repeat(50000);
+
+  beforeAll(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'archive-'));
+    const src = path.join(dir, 'build');
+    fs.mkdirSync(path.join(src, 'out'), { recursive: true });
+    fs.writeFileSync(path.join(src, 'README'), 'hello\n');
+    fs.writeFileSync(path.join(src, 'out', 'app.log'), big);
+    fs.writeFileSync(path.join(src, `${'n'.repeat(120)}.txt`), 'long name\n');
+    expect(spawnSync('tar', ['czf', 'build.tar.gz', 'build'], { cwd: dir }).status).toBe(0);
+    expect(spawnSync('tar', ['cf', 'build.tar', 'build'], { cwd: dir }).status).toBe(0);
+    writeZip(path.join(dir, 'build.zip'), { 'build/README': 'hello\n', 'build/out/app.log': big });
+  });
+
+  afterAll(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should recognize archives by extension', () => {
+    expect(archiveTypeOf('/x/a.ZIP')).toBe('zip');
+    expect(archiveTypeOf('/x/a.jar')).toBe('zip');
+    expect(archiveTypeOf('/x/a.tgz')).toBe('tar.gz');
+    expect(archiveTypeOf('/x/a.tar')).toBe('tar');
+    expect(archiveTypeOf('/x/a.gz')).toBeNull();
+  });
+
+  for (const name of ['build.tar.gz', 'build.tar', 'build.zip']) {
+    it(`should list and read members of ${name}`, async () => {
+      const file = path.join(dir, name);
+      const listing = await listArchive(file);
+      expect(listing.entries).toContainEqual(
+        expect.objectContaining({ name: 'build/out/app.log', type: 'file', size: big.length })
+      );
+      expect(listing.truncated).toBe(false);
+
+      const member = await openArchiveMember(file, './build/out/app.log');
+      expect(member.size).toBe(big.length);
+      expect(await readAll(member.stream)).toBe(big);
+      expect(await readAll((await openArchiveMember(file, 'build/README')).stream)).toBe('hello\n');
+
+      await expect(openArchiveMember(file, 'build/missing')).rejects.toMatchObject({
+        code: 'MEMBER_NOT_FOUND',
+      });
+      await expect(openArchiveMember(file, '../etc/passwd')).rejects.toMatchObject({
+        code: 'MEMBER_NOT_FOUND',
+      });
+    });
+  }
+
+  it('should read long tar names and stop listing at the limit', async () => {
+    const file = path.join(dir, 'build.tar.gz');
+    expect((await listArchive(file)).entries.map((entry) => entry.name)).toContain(
+      `build/${'n'.repeat(120)}.txt`
+    );
+    expect(await listArchive(file, 2)).toMatchObject({ truncated: true });
+  });
+
+  it('should reject corrupt archives', async () => {
+    const file = path.join(dir, 'broken.tar.gz');
+    fs.writeFileSync(file, 'not gzip');
+    await expect(listArchive(file)).rejects.toMatchObject({ code: 'CORRUPT' });
+  });
+});
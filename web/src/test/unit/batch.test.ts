@@ -0,0 +1,161 @@
+import express from 'express';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import { afterEach, describe, expect, it } from 'vitest';
+import type { AuthenticatedRequest } from '../../server/middleware/auth';
+import type { PtyManager } from '../../server/pty/index';
+import { createBatchRoutes } from '../../server/routes/batch';
+import type { ActivityMonitor } from '../../server/services/activity-monitor';
+import type { RemoteRegistry } from '../../server/services/remote-registry';
+import type { Scheduler } from '../../server/services/scheduler';
+import type { SessionGroupStore } from '../../server/services/session-groups';
+
+const sessions = [
+  { id: 'a', name: 'build', status: 'running', command: ['make'] },
+  { id: 'b', name: 'logs', status: 'exited', command: ['tail'] },
+];
+
+const remote = {
+  id: 'r1',
+  name: 'gpu-box',
+  url: 'http://gpu-box:4020',
+  token: 'secret',
+  sessionIds: new Set(['gpu-box/s1']),
+  tags: ['gpu'],
+};
+
+const servers: Server[] = [];
+
+afterEach(() => {
+  for (const server of servers.splice(0)) server.close();
+});
+
+// Batch routes over stub managers; auth fields are set on every request
+async function startBatchServer(auth: Partial<AuthenticatedRequest> = {}) {
+  const app = express();
+  app.use(express.json());
+  app.use((req, _res, next) => {
+    Object.assign(req, auth);
+    next();
+  });
+  app.use(
+    '/api',
+    createBatchRoutes({
+      ptyManager: {
+        listSessions: () => sessions,
+        getSession: (id: string) => sessions.find((session) => session.id === id) ?? null,
+        getStats: () => ({ sessions: sessions.length }),
+      } as unknown as PtyManager,
+      activityMonitor: {
+        getActivityStatus: () => ({}),
+        getSessionActivityStatus: () => ({ isActive: true }),
+      } as unknown as ActivityMonitor,
+      remoteRegistry: { getRemotes: () => [remote] } as unknown as RemoteRegistry,
+      groupStore: { list: () => [] } as unknown as SessionGroupStore,
+      scheduler: {
+        list: () => {
+          throw new Error('schedules unavailable');
+        },
+      } as unknown as Scheduler,
+    })
+  );
+  const server = app.listen(0);
+  servers.push(server);
+  await new Promise((resolve) => server.once('listening', resolve));
+  const { port } = server.address() as AddressInfo;
+
+  return async (queries: unknown) => {
+    const response = await fetch(`http://localhost:${port}/api/batch`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ queries }),
+    });
+    return { status: response.status, body: await response.json() };
+  };
+}
+
+describe('batch routes', () => {
+  it('should answer named queries with selected fields and includes', async () => {
+    const batch = await startBatchServer();
+    const { status, body } = await batch({
+      all: { resource: 'sessions', fields: ['id', 'status', 'missing'] },
+      one: { resource: 'session', params: { id: 'b' }, fields: ['name'] },
+      active: { resource: 'sessions', params: { status: 'running' }, include: ['activity'] },
+    });
+
+    expect(status).toBe(200);
+    expect(body.results.all).toEqual({
+      data: [
+        { id: 'a', status: 'running' },
+        { id: 'b', status: 'exited' },
+      ],
+    });
+    expect(body.results.one).toEqual({ data: { name: 'logs' } });
+    expect(body.results.active.data).toEqual([{ ...sessions[0], activity: { isActive: true } }]);
+  });
+
+  it('should report failing queries without failing the batch', async () => {
+    const batch = await startBatchServer();
+    const { status, body } = await batch({
+      ok: { resource: 'session', params: { id: 'a' }, fields: ['id'] },
+      unknown: { resource: 'toString' },
+      invalid: { resource: 'sessions', fields: 'id' },
+      missingId: { resource: 'session' },
+      notFound: { resource: 'session', params: { id: 'gone' } },
+      broken: { resource: 'schedules' },
+    });
+
+    expect(status).toBe(200);
+    expect(body.results).toEqual({
+      ok: { data: { id: 'a' } },
+      unknown: { error: 'Unknown resource: toString' },
+      invalid: { error: 'Invalid query' },
+      missingId: { error: 'params.id is required' },
+      notFound: { error: 'Session not found' },
+      broken: { error: 'Query failed' },
+    });
+  });
+
+  it('should reject malformed and oversized batches', async () => {
+    const batch = await startBatchServer();
+    expect((await batch([{ resource: 'sessions' }])).status).toBe(400);
+    expect((await batch(undefined)).status).toBe(400);
+
+    const queries = Object.fromEntries(
+      Array.from({ length: 21 }, (_, i) => [`q${i}`, { resource: 'activity' }])
+    );
+    const { status, body } = await batch(queries);
+    expect(status).toBe(400);
+    expect(body.error).toMatch(/At most 20 queries/);
+  });
+
+  it('should check the scope of API tokens per resource', async () => {
+    const batch = await startBatchServer({
+      authMethod: 'api-token',
+      tokenScopes: ['sessions:read', 'sessions:write'],
+    });
+    const { body } = await batch({
+      running: { resource: 'sessions', params: { status: 'running' }, fields: ['id'] },
+      remotes: { resource: 'remotes' },
+      stats: { resource: 'stats' },
+    });
+
+    expect(body.results).toEqual({
+      running: { data: [{ id: 'a' }] },
+      remotes: { error: 'API token lacks the admin scope' },
+      stats: { error: 'API token lacks the admin scope' },
+    });
+  });
+
+  it('should answer admin resources to admin tokens and other users', async () => {
+    const queries = { remotes: { resource: 'remotes', fields: ['name', 'token'] } };
+    const adminToken = await startBatchServer({ authMethod: 'api-token', tokenScopes: ['admin'] });
+    const user = await startBatchServer({ authMethod: 'password', userId: 'alice' });
+
+    for (const batch of [adminToken, user]) {
+      const { body } = await batch(queries);
+      // Remote tokens are never returned
+      expect(body.results.remotes).toEqual({ data: [{ name: 'gpu-box' }] });
+    }
+  });
+});
@@ -0,0 +1,334 @@
+import type { AddressInfo } from 'net';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { WebSocket, WebSocketServer } from 'ws';
+import { BufferAggregator } from '../../server/services/buffer-aggregator';
+import type { RemoteRegistry } from '../../server/services/remote-registry';
+import type { TerminalManager } from '../../server/services/terminal-manager';
+
+interface FakeRemote {
+  server: WebSocketServer;
+  url: string;
+  sockets: WebSocket[];
+  messages: Array<Record<string, unknown>>;
+}
+
+interface TestClient {
+  ws: WebSocket;
+  json: Array<Record<string, unknown>>;
+  frames: Buffer[];
+}
+
+async function openClient(port: number, clients: TestClient[]): Promise<TestClient> {
+  const ws = new WebSocket(`ws://127.0.0.1:${port}`);
+  const client: TestClient = { ws, json: [], frames: [] };
+  ws.on('message', (data: Buffer, isBinary: boolean) => {
+    if (isBinary) {
+      client.frames.push(Buffer.from(data));
+    } else {
+      client.json.push(JSON.parse(data.toString()));
+    }
+  });
+  clients.push(client);
+  await waitFor(() => client.json.some((m) => m.type === 'connected'));
+  return client;
+}
+
+async function request(
+  client: TestClient,
+  message: Record<string, unknown>,
+  replyType: string
+): Promise<Record<string, unknown>> {
+  const before = client.json.filter((m) => m.type === replyType).length;
+  client.ws.send(JSON.stringify(message));
+  await waitFor(() => client.json.filter((m) => m.type === replyType).length > before);
+  return client.json.filter((m) => m.type === replyType)[before];
+}
+
+async function listen(server: WebSocketServer): Promise<number> {
+  await new Promise<void>((resolve) => server.once('listening', () => resolve()));
+  return (server.address() as AddressInfo).port;
+}
+
+// A remote that greets every connection like a protocol 1.1 server and records what HQ sends
+async function startRemote(): Promise<FakeRemote> {
+  const server = new WebSocketServer({ port: 0, path: '/buffers' });
+  const remote: FakeRemote = {
+    server,
+    url: `http://127.0.0.1:${await listen(server)}`,
+    sockets: [],
+    messages: [],
+  };
+  server.on('connection', (ws) => {
+    remote.sockets.push(ws);
+    ws.on('message', (data) => remote.messages.push(JSON.parse(data.toString())));
+    ws.send(JSON.stringify({ type: 'connected', version: '1.1' }));
+  });
+  return remote;
+}
+
+function frame(sessionId: string, payload: string): Buffer {
+  const id = Buffer.from(sessionId, 'utf8');
+  const header = Buffer.alloc(5);
+  header.writeUInt8(0xbf, 0);
+  header.writeUInt32LE(id.length, 1);
+  return Buffer.concat([header, id, Buffer.from(payload)]);
+}
+
+function framePayload(buffer: Buffer): string {
+  return buffer.subarray(5 + buffer.readUInt32LE(1)).toString();
+}
+
+async function waitFor(condition: () => boolean, timeoutMs = 3000): Promise<void> {
+  const deadline = Date.now() + timeoutMs;
+  while (!condition()) {
+    if (Date.now() > deadline) throw new Error('Timed out waiting for condition');
+    await new Promise((resolve) => setTimeout(resolve, 10));
+  }
+}
+
+describe('BufferAggregator HQ mirroring', () => {
+  let remote: FakeRemote;
+  let hq: WebSocketServer;
+  let hqPort: number;
+  let aggregator: BufferAggregator;
+  let clients: TestClient[];
+
+  const sessions: Record<string, string> = { s1: 'remote-1', s2: 'remote-1' };
+
+  beforeEach(async () => {
+    remote = await startRemote();
+    const remoteServer = { id: 'remote-1', name: 'Remote One', url: remote.url, token: 'tok' };
+    const remoteRegistry = {
+      getRemote: vi.fn((id: string) => (id === 'remote-1' ? remoteServer : undefined)),
+      getRemoteBySessionId: vi.fn((id: string) => (sessions[id] ? remoteServer : undefined)),
+    } as unknown as RemoteRegistry;
+
+    aggregator = new BufferAggregator({
+      terminalManager: {} as unknown as TerminalManager,
+      remoteRegistry,
+      isHQMode: true,
+    });
+
+    hq = new WebSocketServer({ port: 0 });
+    hq.on('connection', (ws) => aggregator.handleClientConnection(ws));
+    hqPort = await listen(hq);
+    clients = [];
+  });
+
+  afterEach(async () => {
+    for (const client of clients) client.ws.terminate();
+    aggregator.destroy();
+    await new Promise((resolve) => hq.close(resolve));
+    for (const ws of remote.sockets) ws.terminate();
+    await new Promise((resolve) => remote.server.close(resolve));
+  });
+
+  const connectClient = () => openClient(hqPort, clients);
+  const subscribe = (client: TestClient, sessionId: string) =>
+    request(client, { type: 'subscribe', sessionId }, 'subscribed');
+
+  const remoteMessages = (type: string) => remote.messages.filter((m) => m.type === type);
+
+  it('should subscribe on the remote once for several HQ clients', async () => {
+    const a = await connectClient();
+    const b = await connectClient();
+
+    await subscribe(a, 's1');
+    await subscribe(b, 's1');
+    await waitFor(() => remoteMessages('subscribe').length >= 1);
+
+    expect(remote.sockets).toHaveLength(1);
+    expect(remoteMessages('subscribe')).toEqual([{ type: 'subscribe', sessionId: 's1' }]);
+    expect(aggregator.getStats().mirroredSessions).toBe(1);
+  });
+
+  it('should fan out remote frames to every subscribed client', async () => {
+    const a = await connectClient();
+    const b = await connectClient();
+    await subscribe(a, 's1');
+    await subscribe(b, 's1');
+
+    remote.sockets[0].send(frame('s1', 'hello'));
+    await waitFor(() => a.frames.length === 1 && b.frames.length === 1);
+
+    expect(framePayload(a.frames[0])).toBe('hello');
+    expect(framePayload(b.frames[0])).toBe('hello');
+  });
+
+  it('should replay the latest frame to a client joining an existing mirror', async () => {
+    const a = await connectClient();
+    await subscribe(a, 's1');
+    remote.sockets[0].send(frame('s1', 'first'));
+    await waitFor(() => a.frames.length === 1);
+
+    const b = await connectClient();
+    await subscribe(b, 's1');
+    await waitFor(() => b.frames.length === 1);
+
+    expect(framePayload(b.frames[0])).toBe('first');
+    expect(remoteMessages('subscribe')).toHaveLength(1);
+  });
+
+  it('should demultiplex frames to the clients of each session', async () => {
+    const a = await connectClient();
+    const b = await connectClient();
+    await subscribe(a, 's1');
+    await subscribe(b, 's2');
+
+    remote.sockets[0].send(frame('s2', 'for-b'));
+    remote.sockets[0].send(frame('s1', 'for-a'));
+    remote.sockets[0].send(frame('other', 'nobody'));
+    await waitFor(() => a.frames.length === 1 && b.frames.length === 1);
+    await new Promise((resolve) => setTimeout(resolve, 50));
+
+    expect(a.frames.map(framePayload)).toEqual(['for-a']);
+    expect(b.frames.map(framePayload)).toEqual(['for-b']);
+  });
+
+  it('should unsubscribe on the remote only after the last client leaves', async () => {
+    const a = await connectClient();
+    const b = await connectClient();
+    await subscribe(a, 's1');
+    await subscribe(b, 's1');
+
+    a.ws.send(JSON.stringify({ type: 'unsubscribe', sessionId: 's1' }));
+    await new Promise((resolve) => setTimeout(resolve, 50));
+    expect(remoteMessages('unsubscribe')).toHaveLength(0);
+
+    remote.sockets[0].send(frame('s1', 'still-b'));
+    await waitFor(() => b.frames.length === 1);
+    expect(a.frames).toHaveLength(0);
+
+    b.ws.close();
+    await waitFor(() => remoteMessages('unsubscribe').length === 1);
+    expect(remoteMessages('unsubscribe')).toEqual([{ type: 'unsubscribe', sessionId: 's1' }]);
+    expect(aggregator.getStats().mirroredSessions).toBe(0);
+  });
+
+  it('should resync mirrored sessions after the remote reconnects', async () => {
+    const a = await connectClient();
+    await subscribe(a, 's1');
+    await subscribe(a, 's2');
+    await waitFor(() => remoteMessages('subscribe').length === 2);
+
+    remote.messages.length = 0;
+    remote.sockets[0].terminate();
+    await waitFor(() => aggregator.getStats().pendingRemoteReconnects === 1);
+
+    // The first retry runs after the 1s base backoff
+    await waitFor(() => remote.sockets.length === 2 && remoteMessages('sync').length === 1);
+    expect(remoteMessages('sync')).toEqual([{ type: 'sync', sessionIds: ['s1', 's2'] }]);
+    expect(remoteMessages('subscribe')).toHaveLength(0);
+    expect(aggregator.getStats().pendingRemoteReconnects).toBe(0);
+
+    // Frames from the new connection reach the existing clients
+    remote.sockets[1].send(frame('s2', 'after-reconnect'));
+    await waitFor(() => a.frames.length === 1);
+    expect(framePayload(a.frames[0])).toBe('after-reconnect');
+  });
+});
+
+describe('BufferAggregator resume', () => {
+  let server: WebSocketServer;
+  let port: number;
+  let aggregator: BufferAggregator;
+  let clients: TestClient[];
+  let snapshots: Map<string, { text: string }>;
+  let listeners: Map<string, (sessionId: string, snapshot: unknown) => void>;
+
+  beforeEach(async () => {
+    snapshots = new Map([
+      ['s1', { text: 'one' }],
+      ['s2', { text: 'two' }],
+    ]);
+    listeners = new Map();
+    const terminalManager = {
+      subscribeToBufferChanges: vi.fn(
+        async (sessionId: string, listener: (id: string, snapshot: unknown) => void) => {
+          listeners.set(sessionId, listener);
+          return () => listeners.delete(sessionId);
+        }
+      ),
+      getBufferSnapshot: vi.fn(async (sessionId: string) => snapshots.get(sessionId)),
+      encodeSnapshotPooled: vi.fn((snapshot: { text: string }, prefixLength: number) => {
+        const buffer = Buffer.alloc(prefixLength + snapshot.text.length);
+        buffer.write(snapshot.text, prefixLength);
+        return { buffer, release: () => {} };
+      }),
+    } as unknown as TerminalManager;
+
+    aggregator = new BufferAggregator({ terminalManager, remoteRegistry: null, isHQMode: false });
+    server = new WebSocketServer({ port: 0 });
+    server.on('connection', (ws) => aggregator.handleClientConnection(ws));
+    port = await listen(server);
+    clients = [];
+  });
+
+  afterEach(async () => {
+    for (const client of clients) client.ws.terminate();
+    aggregator.destroy();
+    await new Promise((resolve) => server.close(resolve));
+  });
+
+  // Update a session's buffer and notify its subscriber
+  function update(sessionId: string, text: string) {
+    const snapshot = { text };
+    snapshots.set(sessionId, snapshot);
+    listeners.get(sessionId)?.(sessionId, snapshot);
+  }
+
+  it('should restore subscriptions and send only sessions that changed while away', async () => {
+    const first = await openClient(port, clients);
+    const resumeToken = first.json[0].resumeToken;
+    expect(typeof resumeToken).toBe('string');
+    await request(first, { type: 'subscribe', sessionId: 's1' }, 'subscribed');
+    await request(first, { type: 'subscribe', sessionId: 's2' }, 'subscribed');
+    await waitFor(() => first.frames.length === 2);
+
+    first.ws.close();
+    await waitFor(() => aggregator.getStats().resumableClients === 1);
+    update('s2', 'two changed');
+
+    const second = await openClient(port, clients);
+    const resumed = await request(second, { type: 'resume', resumeToken }, 'resumed');
+    await waitFor(() => second.frames.length === 1);
+    await new Promise((resolve) => setTimeout(resolve, 50));
+
+    expect(resumed.sessionIds).toEqual(['s1', 's2']);
+    expect(second.frames.map(framePayload)).toEqual(['two changed']);
+    expect(aggregator.getStats()).toMatchObject({ resumableClients: 0, resumedClients: 1 });
+
+    update('s1', 'one changed');
+    await waitFor(() => second.frames.length === 2);
+    expect(framePayload(second.frames[1])).toBe('one changed');
+  });
+
+  it('should not resume unsubscribed sessions', async () => {
+    const first = await openClient(port, clients);
+    const resumeToken = first.json[0].resumeToken;
+    await request(first, { type: 'subscribe', sessionId: 's1' }, 'subscribed');
+    await request(first, { type: 'subscribe', sessionId: 's2' }, 'subscribed');
+    first.ws.send(JSON.stringify({ type: 'unsubscribe', sessionId: 's1' }));
+
+    first.ws.close();
+    await waitFor(() => aggregator.getStats().resumableClients === 1);
+
+    const second = await openClient(port, clients);
+    const resumed = await request(second, { type: 'resume', resumeToken }, 'resumed');
+    expect(resumed.sessionIds).toEqual(['s2']);
+  });
+
+  it('should reject unknown and already used resume tokens', async () => {
+    const first = await openClient(port, clients);
+    const resumeToken = first.json[0].resumeToken;
+    await request(first, { type: 'subscribe', sessionId: 's1' }, 'subscribed');
+    first.ws.close();
+    await waitFor(() => aggregator.getStats().resumableClients === 1);
+
+    const second = await openClient(port, clients);
+    await request(second, { type: 'resume', resumeToken: 'unknown' }, 'resume-failed');
+    await request(second, { type: 'resume', resumeToken }, 'resumed');
+    await request(second, { type: 'resume', resumeToken }, 'resume-failed');
+    expect(aggregator.getStats()).toMatchObject({ resumedClients: 1, failedResumes: 2 });
+  });
+});
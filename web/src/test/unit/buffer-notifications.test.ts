@@ -0,0 +1,114 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { TerminalManager } from '../../server/services/terminal-manager';
+
+// The parts of TerminalManager that parsed output drives
+interface ManagerInternals {
+  terminals: Map<string, { coalesceLevel: number }>;
+  scheduleBufferChangeNotification(sessionId: string): void;
+}
+
+describe('buffer change notifications', () => {
+  let manager: TerminalManager;
+  let internals: ManagerInternals;
+  let start: number;
+  let snapshots: number;
+
+  const elapsed = () => Date.now() - start;
+  const change = () => internals.scheduleBufferChangeNotification('s1');
+
+  async function subscribe(maxFps?: number) {
+    const received: Array<{ at: number; snapshot: unknown }> = [];
+    const unsubscribe = await manager.subscribeToBufferChanges(
+      's1',
+      (_sessionId, snapshot) => received.push({ at: elapsed(), snapshot }),
+      { maxFps }
+    );
+    return { received, unsubscribe };
+  }
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    manager = new TerminalManager('/nonexistent', { notifyDebounceMs: 50, maxCoalesceMs: 400 });
+    internals = manager as unknown as ManagerInternals;
+    internals.terminals.set('s1', { coalesceLevel: 0 });
+    vi.spyOn(manager, 'getTerminal').mockResolvedValue({} as never);
+    snapshots = 0;
+    vi.spyOn(manager, 'getBufferSnapshot').mockImplementation(
+      async () => ({ frame: ++snapshots }) as never
+    );
+    start = Date.now();
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+    vi.restoreAllMocks();
+  });
+
+  it('should notify once output has been quiet for the debounce period', async () => {
+    const { received } = await subscribe();
+
+    change();
+    await vi.advanceTimersByTimeAsync(30);
+    change();
+    await vi.advanceTimersByTimeAsync(49);
+    expect(received).toHaveLength(0);
+
+    await vi.advanceTimersByTimeAsync(1);
+    expect(received.map(({ at }) => at)).toEqual([80]);
+  });
+
+  it('should widen the coalescing window while output is continuous', async () => {
+    const { received } = await subscribe();
+
+    // Output every 20ms for a second
+    for (let i = 0; i < 50; i++) {
+      change();
+      await vi.advanceTimersByTimeAsync(20);
+    }
+    await vi.advanceTimersByTimeAsync(100);
+
+    // Windows of 100, 200 and 400ms (the maximum), then the debounce after the last change
+    expect(received.map(({ at }) => at)).toEqual([100, 300, 700, 1030]);
+
+    // A quiet period resets the window to 100ms
+    change();
+    for (let i = 0; i < 5; i++) {
+      await vi.advanceTimersByTimeAsync(20);
+      change();
+    }
+    await vi.advanceTimersByTimeAsync(50);
+    expect(received.map(({ at }) => at).slice(4)).toEqual([1200, 1250]);
+  });
+
+  it('should deliver at most maxFps snapshots per second, the latest one', async () => {
+    const all = await subscribe();
+    const throttled = await subscribe(10);
+
+    // Notified at 50, 110, 170 and 230ms
+    for (let i = 0; i < 4; i++) {
+      change();
+      await vi.advanceTimersByTimeAsync(60);
+    }
+    await vi.advanceTimersByTimeAsync(100);
+
+    expect(all.received).toHaveLength(4);
+    expect(throttled.received).toEqual([
+      { at: 50, snapshot: { frame: 1 } },
+      { at: 150, snapshot: { frame: 2 } },
+      { at: 250, snapshot: { frame: 4 } },
+    ]);
+  });
+
+  it('should drop a held snapshot on unsubscribe', async () => {
+    const throttled = await subscribe(10);
+
+    change();
+    await vi.advanceTimersByTimeAsync(60);
+    change();
+    await vi.advanceTimersByTimeAsync(60);
+    throttled.unsubscribe();
+    await vi.advanceTimersByTimeAsync(100);
+
+    expect(throttled.received.map(({ at }) => at)).toEqual([50]);
+  });
+});
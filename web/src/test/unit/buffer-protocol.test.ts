@@ -0,0 +1,80 @@
+import { describe, expect, it } from 'vitest';
+import { decodeBinaryBuffer, inflateBinaryBuffer } from '../../client/utils/terminal-renderer';
+import { TerminalManager } from '../../server/services/terminal-manager';
+import {
+  FLAG_COMPRESSED,
+  FLAG_DELTA,
+  negotiateCapabilities,
+  SNAPSHOT_FORMAT_1,
+  SNAPSHOT_FORMAT_2,
+} from '../../shared/buffer-protocol';
+
+const row = (text: string) => Array.from(text, (char) => ({ char, width: 1 }));
+
+const snapshot = (lines: string[]) => ({
+  cols: 40,
+  rows: lines.length,
+  viewportY: 0,
+  cursorX: 0,
+  cursorY: 0,
+  cells: lines.map(row),
+});
+
+// Copy of a pooled frame as an ArrayBuffer, like the client receives it
+const toArrayBuffer = (buffer: Buffer) => new Uint8Array(buffer).slice().buffer;
+
+const text = (cells: Array<Array<{ char: string }>>) =>
+  cells.map((cells) => cells.map((cell) => cell.char).join(''));
+
+describe('negotiateCapabilities', () => {
+  it('should keep the supported capabilities in canonical order', () => {
+    expect(negotiateCapabilities(['images', 'unknown', 'compression'])).toEqual([
+      'compression',
+      'images',
+    ]);
+    expect(negotiateCapabilities(['deltas', 'links'], ['links'])).toEqual(['links']);
+    expect(negotiateCapabilities('links')).toEqual([]);
+  });
+});
+
+describe('snapshot format 2', () => {
+  const manager = new TerminalManager('/tmp/vibetunnel-test-control');
+
+  it('should keep version 1 without format 2 features', () => {
+    const encoded = manager.encodeSnapshotPooled(snapshot(['one', 'two']));
+    expect(encoded.buffer[2]).toBe(SNAPSHOT_FORMAT_1);
+    expect(encoded.buffer[3]).toBe(0);
+    encoded.release();
+  });
+
+  it('should send unchanged rows as runs and rebuild them from the previous frame', () => {
+    const first = manager.encodeSnapshotPooled(snapshot(['one', 'two', 'three']));
+    const previous = decodeBinaryBuffer(toArrayBuffer(first.buffer)).cells;
+
+    const second = manager.encodeSnapshotPooled(snapshot(['one', 'TWO', 'three']), 0, {
+      baseRows: first.rows,
+    });
+    expect(second.buffer[2]).toBe(SNAPSHOT_FORMAT_2);
+    expect(second.buffer[3] & FLAG_DELTA).toBe(FLAG_DELTA);
+    expect(second.buffer.length).toBeLessThan(first.buffer.length);
+
+    const decoded = decodeBinaryBuffer(toArrayBuffer(second.buffer), previous);
+    expect(text(decoded.cells)).toEqual(['one', 'TWO', 'three']);
+    expect(() => decodeBinaryBuffer(toArrayBuffer(second.buffer))).toThrow();
+    first.release();
+    second.release();
+  });
+
+  it('should deflate large frames', async () => {
+    const lines = Array.from({ length: 40 }, (_, i) => `line ${i} `.repeat(4));
+    const plain = manager.encodeSnapshotPooled(snapshot(lines));
+    const compressed = manager.encodeSnapshotPooled(snapshot(lines), 0, { compress: true });
+    expect(compressed.buffer[3] & FLAG_COMPRESSED).toBe(FLAG_COMPRESSED);
+    expect(compressed.buffer.length).toBeLessThan(plain.buffer.length);
+
+    const inflated = await inflateBinaryBuffer(toArrayBuffer(compressed.buffer));
+    expect(text(decodeBinaryBuffer(inflated).cells)).toEqual(lines);
+    plain.release();
+    compressed.release();
+  });
+});
@@ -0,0 +1,114 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { decodeBinaryBuffer } from '../../client/utils/terminal-renderer';
+import { TerminalManager } from '../../server/services/terminal-manager';
+import { cellWidth, continuesCluster } from '../../shared/cell-width';
+
+const toArrayBuffer = (buffer: Buffer) => new Uint8Array(buffer).slice().buffer;
+
+const CJK = '\u4e2d\u6587';
+const HANGUL = '\uac00';
+const SMILE = '\u{1f642}';
+// Woman + ZWJ + laptop, and man + ZWJ + woman + ZWJ + girl
+const CODER = '\u{1f469}\u200d\u{1f4bb}';
+const FAMILY = '\u{1f468}\u200d\u{1f469}\u200d\u{1f467}';
+const FLAG = '\u{1f1ef}\u{1f1f5}';
+const THUMBS = '\u{1f44d}\u{1f3fd}';
+const HEART = '\u2764';
+const ACCENTED = 'e\u0301';
+
+describe('cellWidth', () => {
+  it('should give wide characters and emoji two columns', () => {
+    for (const cluster of ['a', '\u00e9', ACCENTED, '\u2500', HEART, '']) {
+      expect(cellWidth(cluster)).toBe(1);
+    }
+    for (const cluster of [CJK[0], HANGUL, '\uff21', SMILE, CODER, FAMILY, FLAG, THUMBS]) {
+      expect(cellWidth(cluster)).toBe(2);
+    }
+    // Emoji presentation selector
+    expect(cellWidth(`${HEART}\ufe0f`)).toBe(2);
+  });
+
+  it('should tell which characters continue a cluster', () => {
+    expect(continuesCluster('\u{1f469}\u200d', '\u{1f4bb}')).toBe(true);
+    expect(continuesCluster('\u{1f44d}', '\u{1f3fd}')).toBe(true);
+    expect(continuesCluster('\u{1f1ef}', '\u{1f1f5}')).toBe(true);
+    expect(continuesCluster(FLAG, '\u{1f1fa}')).toBe(false);
+    expect(continuesCluster(SMILE, SMILE)).toBe(false);
+    expect(continuesCluster(CJK[0], CJK[1])).toBe(false);
+  });
+});
+
+describe('snapshot rows of CJK and emoji output', () => {
+  let controlDir: string;
+  let manager: TerminalManager;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'cell-width-'));
+    manager = new TerminalManager(controlDir);
+  });
+
+  afterEach(() => {
+    manager.closeTerminal('s1');
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  async function snapshotOf(output: string) {
+    const header = JSON.stringify({ version: 2, width: 40, height: 3 });
+    fs.mkdirSync(path.join(controlDir, 's1'));
+    fs.writeFileSync(
+      path.join(controlDir, 's1', 'stdout'),
+      `${header}\n${JSON.stringify([0, 'o', output])}\n`
+    );
+    await manager.getTerminal('s1');
+    // Output is parsed asynchronously
+    return vi.waitFor(async () => {
+      const snapshot = await manager.getBufferSnapshot('s1');
+      if (!snapshot.cells[0].some((cell) => cell.char === '$')) throw new Error('not parsed');
+      return snapshot;
+    });
+  }
+
+  it('should keep clusters in one cell and the cells after them in their columns', async () => {
+    const snapshot = await snapshotOf(`${CJK}|${FAMILY}|${CODER}|${FLAG}|${THUMBS}|${ACCENTED}|$`);
+    const cells = snapshot.cells[0];
+    expect(cells.map((cell) => cell.char).filter((char) => char !== ' ')).toEqual([
+      ...CJK,
+      '|',
+      FAMILY,
+      '|',
+      CODER,
+      '|',
+      FLAG,
+      '|',
+      THUMBS,
+      '|',
+      ACCENTED,
+      '|',
+      '$',
+    ]);
+
+    // The last cell starts at the column the terminal put it in
+    const terminal = await manager.getTerminal('s1');
+    const line = terminal.buffer.active.getLine(0);
+    let gridCol = 0;
+    while (line?.getCell(gridCol)?.getChars() !== '$') gridCol++;
+    const cellCol = cells.slice(0, -1).reduce((total, cell) => total + cell.width, 0);
+    expect(cells[cells.length - 1].char).toBe('$');
+    expect(cellCol).toBe(gridCol);
+  });
+
+  it('should decode the widths the clusters are shown with', async () => {
+    const snapshot = await snapshotOf(`${CJK[0]}|${CODER}|$`);
+    const decoded = decodeBinaryBuffer(toArrayBuffer(manager.encodeSnapshot(snapshot)));
+    expect(decoded.cells[0].map(({ char, width }) => [char, width])).toEqual([
+      [CJK[0], 2],
+      ['|', 1],
+      [CODER, 2],
+      ['|', 1],
+      ['$', 1],
+    ]);
+  });
+});
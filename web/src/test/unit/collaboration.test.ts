@@ -0,0 +1,86 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import {
+  type CollaborationMessage,
+  CollaborationService,
+  parseSelection,
+} from '../../server/services/collaboration';
+import { ViewerPresence } from '../../server/services/viewer-presence';
+
+describe('CollaborationService', () => {
+  let presence: ViewerPresence;
+  let collaboration: CollaborationService;
+  let messages: CollaborationMessage[];
+  const range = { startCol: 0, startRow: 1, endCol: 5, endRow: 1 };
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    presence = new ViewerPresence();
+    collaboration = new CollaborationService(presence);
+    messages = [];
+    collaboration.on('collaboration', (message) => messages.push(message));
+  });
+
+  afterEach(() => {
+    collaboration.destroy();
+    presence.destroy();
+    vi.useRealTimers();
+  });
+
+  it('should stay quiet while only one user watches', () => {
+    presence.join('s1', 'websocket', 'alice');
+    presence.join('s1', 'sse', 'alice');
+    collaboration.recordTyping('s1', 'alice');
+    collaboration.setSelection('s1', 'p1', 'alice', range);
+    expect(messages).toEqual([]);
+  });
+
+  it('should throttle typing events per user', () => {
+    presence.join('s1', 'websocket', 'alice');
+    presence.join('s1', 'websocket', 'bob');
+
+    collaboration.recordTyping('s1', 'alice', 'p1');
+    collaboration.recordTyping('s1', 'alice', 'p1');
+    vi.advanceTimersByTime(1000);
+    collaboration.recordTyping('s1', 'alice', 'p1');
+
+    expect(messages).toHaveLength(2);
+    expect(messages[0]).toEqual({
+      sessionId: 's1',
+      origin: 'p1',
+      payload: { event: 'typing', userId: 'alice' },
+    });
+  });
+
+  it('should clear the selection of a participant that leaves', () => {
+    presence.join('s1', 'websocket', 'alice');
+    presence.join('s1', 'websocket', 'bob');
+
+    collaboration.setSelection('s1', 'p1', 'alice', range);
+    expect(collaboration.getSelections('s1')).toHaveLength(1);
+
+    collaboration.leave('s1', 'p1');
+    expect(collaboration.getSelections('s1')).toEqual([]);
+    expect(messages.map((message) => message.payload)).toEqual([
+      { event: 'selection', userId: 'alice', participantId: 'p1', selection: range },
+      { event: 'selection', userId: 'alice', participantId: 'p1', selection: null },
+    ]);
+  });
+});
+
+describe('parseSelection', () => {
+  it('should accept ranges and null', () => {
+    expect(parseSelection({ startCol: 0, startRow: 0, endCol: 3, endRow: 2 })).toEqual({
+      startCol: 0,
+      startRow: 0,
+      endCol: 3,
+      endRow: 2,
+    });
+    expect(parseSelection(null)).toBeNull();
+  });
+
+  it('should reject malformed ranges', () => {
+    expect(parseSelection({ startCol: -1, startRow: 0, endCol: 3, endRow: 2 })).toBeUndefined();
+    expect(parseSelection({ startCol: 0.5, startRow: 0, endCol: 3, endRow: 2 })).toBeUndefined();
+    expect(parseSelection('0,0,3,2')).toBeUndefined();
+  });
+});
@@ -0,0 +1,122 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import type { SessionInfo } from '../../shared/types';
+import {
+  AppendedLineReader,
+  appendControlAck,
+  applyEnvCommand,
+  applyMetadataCommand,
+  controlAckPath,
+  parseSignal,
+  sendControlCommand,
+  validateControlCommand,
+} from '../../server/pty/control-protocol';
+
+describe('control protocol', () => {
+  let dir: string;
+  let controlPath: string;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'control-protocol-'));
+    controlPath = path.join(dir, 'control');
+    fs.writeFileSync(controlPath, '');
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should read appended lines once, leaving incomplete ones for later', () => {
+    const reader = new AppendedLineReader(controlPath);
+    fs.appendFileSync(controlPath, '{"cmd":"kill"}\n{"cmd":"res');
+    expect(reader.read()).toEqual(['{"cmd":"kill"}']);
+    expect(reader.read()).toEqual([]);
+    fs.appendFileSync(controlPath, 'ize","cols":80,"rows":24}\n\n');
+    expect(reader.read()).toEqual(['{"cmd":"resize","cols":80,"rows":24}']);
+
+    // Starting at the end skips what was there before
+    const late = AppendedLineReader.fromEnd(controlPath);
+    fs.appendFileSync(controlPath, '{"cmd":"flush"}\n');
+    expect(late.read()).toEqual(['{"cmd":"flush"}']);
+  });
+
+  it('should validate command arguments', () => {
+    expect(validateControlCommand({ cmd: 'resize', cols: 80, rows: 24 })).toBeNull();
+    expect(validateControlCommand({ cmd: 'resize', cols: 80 })).toMatch(/cols and rows/);
+    expect(validateControlCommand({ cmd: 'signal', signal: 'SIGINT' })).toBeNull();
+    expect(validateControlCommand({ cmd: 'signal', signal: 'SIGNOPE' })).toMatch(/invalid signal/);
+    expect(validateControlCommand({ cmd: 'kill' })).toBeNull();
+    expect(validateControlCommand({ cmd: 'title', title: ' ' })).toMatch(/non-empty/);
+    expect(validateControlCommand({ cmd: 'env', set: { A: '1' }, unset: ['B'] })).toBeNull();
+    expect(validateControlCommand({ cmd: 'env', set: { 'A=B': '1' } })).toMatch(/invalid/);
+    expect(validateControlCommand({ cmd: 'env', set: { A: 1 } })).toMatch(/must be a string/);
+    // Unknown commands are rejected when run, not here
+    expect(validateControlCommand({ cmd: 'frob' })).toBeNull();
+  });
+
+  it('should take signals by name or number', () => {
+    expect(parseSignal('SIGTERM')).toBe('SIGTERM');
+    expect(parseSignal(os.constants.signals.SIGINT)).toBe('SIGINT');
+    expect(() => parseSignal(1000)).toThrow(/invalid signal/);
+  });
+
+  it('should merge env changes, with null for unset variables', () => {
+    const pending = applyEnvCommand({ A: '1', B: '2' }, { cmd: 'env', set: { B: '3' } });
+    expect(applyEnvCommand(pending, { cmd: 'env', unset: ['A'] })).toEqual({ A: null, B: '3' });
+  });
+
+  it('should update metadata, with null clearing the description or a field', () => {
+    const relative = { cmd: 'metadata', currentWorkingDir: 'src' };
+    expect(validateControlCommand(relative)).toMatch(/absolute/);
+    expect(validateControlCommand({ cmd: 'metadata', fields: { 'a b': 'x' } })).toMatch(/invalid/);
+    expect(validateControlCommand({ cmd: 'metadata', fields: { ticket: 1 } })).toMatch(/string/);
+
+    const updated: SessionInfo = {
+      id: 's1',
+      command: ['bash'],
+      name: 'bash',
+      workingDir: '/',
+      status: 'running',
+      startedAt: new Date().toISOString(),
+      fields: { ticket: 'VT-1', owner: 'ops' },
+    };
+    applyMetadataCommand(updated, {
+      cmd: 'metadata',
+      currentWorkingDir: '/srv',
+      description: 'Deploy',
+      fields: { owner: null, stage: 'prod' },
+    });
+    expect(updated).toMatchObject({
+      currentWorkingDir: '/srv',
+      description: 'Deploy',
+      fields: { ticket: 'VT-1', stage: 'prod' },
+    });
+    applyMetadataCommand(updated, { cmd: 'metadata', description: null });
+    expect(updated).not.toHaveProperty('description');
+  });
+
+  it('should return the acknowledgement of a command', async () => {
+    // The owning process: acknowledges each command with an ID
+    const commands = new AppendedLineReader(controlPath);
+    const owner = setInterval(() => {
+      for (const line of commands.read()) {
+        const { id, cmd } = JSON.parse(line);
+        appendControlAck(controlPath, { id, ok: cmd === 'flush', size: 42 });
+      }
+    }, 5);
+    try {
+      // Acknowledgements of earlier commands are not mistaken for this one's
+      appendControlAck(controlPath, { id: 'old', ok: false });
+      await expect(sendControlCommand(controlPath, { cmd: 'flush' })).resolves.toMatchObject({
+        ok: true,
+        size: 42,
+      });
+      expect(fs.readFileSync(controlAckPath(controlPath), 'utf8').split('\n')).toHaveLength(3);
+    } finally {
+      clearInterval(owner);
+    }
+    await expect(sendControlCommand(controlPath, { cmd: 'flush' }, 100)).resolves.toBeNull();
+  });
+});
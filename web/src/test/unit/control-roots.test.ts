@@ -0,0 +1,98 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { ControlRoots, parseControlRoot } from '../../server/pty/control-roots';
+import { SessionManager } from '../../server/pty/session-manager';
+import type { SessionInfo } from '../../shared/types';
+
+describe('ControlRoots', () => {
+  let testDir: string;
+  let roots: ControlRoots;
+
+  beforeEach(() => {
+    testDir = fs.mkdtempSync(path.join(os.tmpdir(), 'control-roots-test-'));
+    roots = new ControlRoots([
+      { name: 'default', path: path.join(testDir, 'default') },
+      { name: 'ci', path: path.join(testDir, 'ci'), tags: ['ci', 'build'] },
+      { name: 'alice', path: path.join(testDir, 'alice'), users: ['alice'] },
+    ]);
+  });
+
+  afterEach(() => {
+    fs.rmSync(testDir, { recursive: true, force: true });
+  });
+
+  function sessionInfo(id: string): SessionInfo {
+    return {
+      id,
+      name: id,
+      command: ['bash'],
+      workingDir: '/tmp',
+      status: 'exited',
+      startedAt: new Date().toISOString(),
+    };
+  }
+
+  it('should route sessions by tag and user', () => {
+    expect(roots.select({ tags: ['build'] }).name).toBe('ci');
+    expect(roots.select({ user: 'alice' }).name).toBe('alice');
+    expect(roots.select({ tags: ['other'], user: 'bob' }).name).toBe('default');
+    expect(roots.select().name).toBe('default');
+  });
+
+  it('should find and list sessions across roots', () => {
+    const manager = new SessionManager(roots);
+    manager.createSessionDirectory('one', roots.select({ tags: ['ci'] }));
+    manager.saveSessionInfo('one', sessionInfo('one'));
+    manager.createSessionDirectory('two');
+    manager.saveSessionInfo('two', sessionInfo('two'));
+
+    expect(fs.existsSync(path.join(testDir, 'ci', 'one', 'session.json'))).toBe(true);
+    expect(fs.existsSync(path.join(testDir, 'default', 'two', 'session.json'))).toBe(true);
+    expect(manager.getSessionPaths('one')?.stdoutPath).toBe(
+      path.join(testDir, 'ci', 'one', 'stdout')
+    );
+    expect(
+      manager
+        .listSessions()
+        .map((session) => session.id)
+        .sort()
+    ).toEqual(['one', 'two']);
+  });
+
+  it('should locate sessions created by another process', () => {
+    fs.mkdirSync(path.join(testDir, 'alice', 'external'), { recursive: true });
+
+    const fresh = new ControlRoots(roots.list());
+    expect(fresh.findRoot('external')?.name).toBe('alice');
+    expect(fresh.sessionDir('missing')).toBe(path.join(testDir, 'default', 'missing'));
+  });
+
+  it('should forget removed sessions', () => {
+    const manager = new SessionManager(roots);
+    manager.createSessionDirectory('gone', roots.select({ user: 'alice' }));
+    manager.cleanupSession('gone');
+
+    expect(roots.findRoot('gone')).toBeUndefined();
+  });
+});
+
+describe('parseControlRoot', () => {
+  it('should parse name, tags and users', () => {
+    expect(parseControlRoot('/data/vt?name=fast&tags=ci,build&users=alice')).toEqual({
+      name: 'fast',
+      path: '/data/vt',
+      tags: ['ci', 'build'],
+      users: ['alice'],
+    });
+  });
+
+  it('should name roots after their directory by default', () => {
+    expect(parseControlRoot('/mnt/projects/control').name).toBe('control');
+  });
+
+  it('should reject invalid names', () => {
+    expect(() => parseControlRoot('/data?name=a b')).toThrow('Invalid control root name');
+  });
+});
@@ -0,0 +1,76 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import {
+  type CrashReport,
+  CrashReporter,
+  guardSessionTask,
+  setCrashReporter,
+} from '../../server/services/crash-reporter';
+
+describe('CrashReporter', () => {
+  let dir: string;
+  let reporter: CrashReporter;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'crash-reporter-'));
+    reporter = new CrashReporter(dir, 3);
+    setCrashReporter(reporter);
+  });
+
+  afterEach(() => {
+    setCrashReporter(null);
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should report errors of guarded tasks instead of throwing them', async () => {
+    const crashes: CrashReport[] = [];
+    reporter.on('crash', (report: CrashReport) => crashes.push(report));
+
+    const handler = guardSessionTask('s1', 'pty-output', (data: string) => {
+      if (data === 'boom') throw new Error('bad chunk');
+    });
+    handler('fine');
+    expect(() => handler('boom')).not.toThrow();
+    expect(crashes).toHaveLength(1);
+    expect(crashes[0]).toMatchObject({ sessionId: 's1', task: 'pty-output', message: 'bad chunk' });
+
+    const [summary] = await reporter.list();
+    expect(summary).toMatchObject({ id: crashes[0].id, task: 'pty-output' });
+    expect(summary).not.toHaveProperty('stack');
+    expect((await reporter.get(summary.id))?.stack).toContain('bad chunk');
+  });
+
+  it('should report rejected promises', async () => {
+    const crashed = new Promise<CrashReport>((resolve) => reporter.once('crash', resolve));
+    guardSessionTask('s1', 'watch', async () => {
+      throw new Error('later');
+    })();
+    expect((await crashed).message).toBe('later');
+  });
+
+  it('should record the first crash of a task per session', async () => {
+    const fail = () => {
+      throw new Error('again');
+    };
+    const handler = guardSessionTask('s1', 'pty-output', fail);
+    handler();
+    handler();
+    guardSessionTask('s2', 'pty-output', fail)();
+    expect((await reporter.list()).map((crash) => crash.sessionId).sort()).toEqual(['s1', 's2']);
+    expect(await reporter.list('s2')).toHaveLength(1);
+
+    // A session ID used again is reported again
+    reporter.forgetSession('s1');
+    handler();
+    expect(await reporter.list('s1')).toHaveLength(2);
+  });
+
+  it('should keep the most recent reports', async () => {
+    for (let i = 0; i < 5; i++) reporter.record(`s${i}`, 'task', new Error(`crash ${i}`));
+    const crashes = await reporter.list();
+    expect(crashes.map((crash) => crash.message)).toEqual(['crash 4', 'crash 3', 'crash 2']);
+    expect(await reporter.get('../secret')).toBeNull();
+  });
+});
@@ -0,0 +1,64 @@
+import { describe, expect, it } from 'vitest';
+import { CronParseError, nextCronRun, parseCron } from '../../server/utils/cron';
+
+// Local time, like the scheduler
+const at = (year: number, month: number, day: number, hour = 0, minute = 0) =>
+  new Date(year, month - 1, day, hour, minute);
+
+const next = (expression: string, after: Date) => nextCronRun(parseCron(expression), after);
+
+describe('cron', () => {
+  describe('parseCron', () => {
+    it('should parse lists, ranges and steps', () => {
+      const schedule = parseCron('0,30 9-17 */10 1-12/6 *');
+      expect([...schedule.minutes]).toEqual([0, 30]);
+      expect([...schedule.hours]).toEqual([9, 10, 11, 12, 13, 14, 15, 16, 17]);
+      expect([...schedule.daysOfMonth]).toEqual([1, 11, 21, 31]);
+      expect([...schedule.months]).toEqual([1, 7]);
+    });
+
+    it('should treat 7 as Sunday', () => {
+      expect([...parseCron('0 0 * * 7').daysOfWeek]).toEqual([0]);
+    });
+
+    it('should expand shortcuts', () => {
+      expect(parseCron('@daily')).toEqual(parseCron('0 0 * * *'));
+      expect(parseCron('@hourly')).toEqual(parseCron('0 * * * *'));
+    });
+
+    it('should reject invalid expressions', () => {
+      expect(() => parseCron('* * * *')).toThrow(CronParseError);
+      expect(() => parseCron('60 * * * *')).toThrow(CronParseError);
+      expect(() => parseCron('5-1 * * * *')).toThrow(CronParseError);
+      expect(() => parseCron('*/0 * * * *')).toThrow(CronParseError);
+      expect(() => parseCron('a * * * *')).toThrow(CronParseError);
+    });
+  });
+
+  describe('nextCronRun', () => {
+    it('should find the next matching minute', () => {
+      expect(next('*/15 * * * *', at(2024, 3, 1, 10, 7))).toEqual(at(2024, 3, 1, 10, 15));
+      expect(next('*/15 * * * *', at(2024, 3, 1, 10, 45))).toEqual(at(2024, 3, 1, 11, 0));
+    });
+
+    it('should be strictly after the given time', () => {
+      expect(next('0 9 * * *', at(2024, 3, 1, 9, 0))).toEqual(at(2024, 3, 2, 9, 0));
+    });
+
+    it('should roll over days, months and years', () => {
+      expect(next('30 2 1 * *', at(2024, 12, 15))).toEqual(at(2025, 1, 1, 2, 30));
+      expect(next('0 0 29 2 *', at(2024, 3, 1))).toEqual(at(2028, 2, 29));
+    });
+
+    it('should match either day field when both are restricted', () => {
+      // 15th of the month or any Monday; 2024-03-04 is a Monday
+      expect(next('0 0 15 * 1', at(2024, 3, 1))).toEqual(at(2024, 3, 4));
+      // Only weekdays when the day of month is unrestricted; 2024-03-02 is a Saturday
+      expect(next('0 8 * * 1-5', at(2024, 3, 1, 9))).toEqual(at(2024, 3, 4, 8));
+    });
+
+    it('should return null for schedules that never match', () => {
+      expect(next('0 0 31 2 *', at(2024, 1, 1))).toBeNull();
+    });
+  });
+});
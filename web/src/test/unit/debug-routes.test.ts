@@ -0,0 +1,65 @@
+import express from 'express';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import { afterEach, describe, expect, it } from 'vitest';
+import { createDebugRoutes } from '../../server/routes/debug';
+
+const servers: Server[] = [];
+
+afterEach(() => {
+  for (const server of servers.splice(0)) server.close();
+});
+
+async function startDebugServer(debugToken: string) {
+  const app = express();
+  app.use('/debug', createDebugRoutes({ debugToken, getCounters: () => ({ sessions: 2 }) }));
+  const server = app.listen(0);
+  servers.push(server);
+  await new Promise((resolve) => server.once('listening', resolve));
+  const { port } = server.address() as AddressInfo;
+
+  return (url: string, token?: string) =>
+    fetch(`http://localhost:${port}/debug${url}`, {
+      headers: token === undefined ? {} : { Authorization: `Bearer ${token}` },
+    });
+}
+
+describe('debug routes', () => {
+  it('should require the debug token on every endpoint', async () => {
+    const get = await startDebugServer('debug-secret');
+
+    for (const url of ['/vars', '/report', '/heap', '/profile']) {
+      for (const token of [undefined, '', 'wrong', 'debug-secret-and-more']) {
+        const response = await get(url, token);
+        expect(response.status).toBe(401);
+        expect(response.headers.get('WWW-Authenticate')).toBe('Bearer realm="VibeTunnel Debug"');
+      }
+    }
+  });
+
+  it('should serve runtime counters with the debug token', async () => {
+    const get = await startDebugServer('debug-secret');
+
+    const response = await get('/vars', 'debug-secret');
+    expect(response.status).toBe(200);
+    const vars = await response.json();
+    expect(vars.pid).toBe(process.pid);
+    expect(vars.counters).toEqual({ sessions: 2 });
+    expect(vars.eventLoopDelayMs).toHaveProperty('p99');
+  });
+
+  it('should reject profile durations out of range', async () => {
+    const get = await startDebugServer('debug-secret');
+
+    expect((await get('/profile?seconds=61', 'debug-secret')).status).toBe(400);
+    expect((await get('/profile?seconds=-1', 'debug-secret')).status).toBe(400);
+  });
+
+  it('should be off without a debug token', async () => {
+    const get = await startDebugServer('');
+
+    for (const token of [undefined, '']) {
+      expect((await get('/vars', token)).status).toBe(404);
+    }
+  });
+});
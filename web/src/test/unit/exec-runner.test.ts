@@ -0,0 +1,130 @@
+import express from 'express';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import * as pty from 'node-pty';
+import { afterEach, describe, expect, it, vi } from 'vitest';
+import { createExecRoutes } from '../../server/routes/exec';
+import { execCommand, MAX_EXEC_OUTPUT_BYTES } from '../../server/services/exec-runner';
+
+const workingDir = process.cwd();
+
+describe('execCommand', () => {
+  it('should capture output and the exit code', async () => {
+    const result = await execCommand({
+      command: ['sh', '-c', 'echo out; echo err >&2; exit 3'],
+      workingDir,
+    });
+    expect(result).toMatchObject({
+      exitCode: 3,
+      signal: null,
+      stdout: 'out\n',
+      stderr: 'err\n',
+      truncated: false,
+      timedOut: false,
+    });
+  });
+
+  it('should write input to stdin and close it', async () => {
+    const result = await execCommand({ command: ['cat'], workingDir, input: 'hello\n' });
+    expect(result).toMatchObject({ exitCode: 0, stdout: 'hello\n' });
+  });
+
+  it('should truncate output past the limit', async () => {
+    const result = await execCommand({
+      command: ['head', '-c', String(MAX_EXEC_OUTPUT_BYTES + 4096), '/dev/zero'],
+      workingDir,
+    });
+    expect(result.exitCode).toBe(0);
+    expect(result.stdout).toHaveLength(MAX_EXEC_OUTPUT_BYTES);
+    expect(result.truncated).toBe(true);
+  });
+
+  it('should terminate commands at the timeout', async () => {
+    const result = await execCommand({ command: ['sleep', '10'], workingDir, timeoutMs: 100 });
+    expect(result).toMatchObject({ exitCode: null, signal: 'SIGTERM', timedOut: true });
+  });
+
+  it('should kill commands that ignore SIGTERM', async () => {
+    const result = await execCommand({
+      command: ['sh', '-c', "trap '' TERM; exec sleep 10"],
+      workingDir,
+      timeoutMs: 100,
+    });
+    expect(result).toMatchObject({ exitCode: null, signal: 'SIGKILL', timedOut: true });
+    expect(result.durationMs).toBeGreaterThanOrEqual(2000);
+  });
+
+  it('should run commands in a pseudo terminal with tty', async () => {
+    let onData: (data: string) => void = () => {};
+    let onExit: (event: { exitCode: number; signal?: number }) => void = () => {};
+    vi.mocked(pty.spawn).mockReturnValueOnce({
+      onData: (listener: typeof onData) => (onData = listener),
+      onExit: (listener: typeof onExit) => (onExit = listener),
+      kill: vi.fn(),
+    } as unknown as pty.IPty);
+
+    const running = execCommand({ command: ['ls'], workingDir, tty: true, cols: 120, rows: 40 });
+    onData('\x1b[1mfile\x1b[0m\r\n');
+    onExit({ exitCode: 0 });
+
+    expect(await running).toMatchObject({
+      exitCode: 0,
+      stdout: '\x1b[1mfile\x1b[0m\r\n',
+      stderr: '',
+    });
+    expect(pty.spawn).toHaveBeenCalledWith(
+      'ls',
+      [],
+      expect.objectContaining({ name: 'xterm-256color', cols: 120, rows: 40, cwd: workingDir })
+    );
+  });
+});
+
+describe('exec routes', () => {
+  let server: Server | undefined;
+
+  afterEach(() => {
+    server?.close();
+    server = undefined;
+  });
+
+  async function exec(body: unknown) {
+    if (!server) {
+      const app = express();
+      app.use(express.json());
+      app.use('/api', createExecRoutes());
+      server = app.listen(0);
+      await new Promise((resolve) => server?.once('listening', resolve));
+    }
+    const { port } = server.address() as AddressInfo;
+    const response = await fetch(`http://localhost:${port}/api/exec`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    });
+    return { status: response.status, body: await response.json() };
+  }
+
+  it('should run a command', async () => {
+    const { status, body } = await exec({ command: ['echo', 'hi'], workingDir });
+    expect(status).toBe(200);
+    expect(body).toMatchObject({ exitCode: 0, stdout: 'hi\n' });
+  });
+
+  it('should reject invalid requests', async () => {
+    for (const invalid of [
+      {},
+      { command: [] },
+      { command: 'echo hi' },
+      { command: ['echo', 1] },
+      { command: ['true'], timeoutMs: 0 },
+      { command: ['true'], timeoutMs: '100' },
+      { command: ['true'], timeoutMs: 24 * 60 * 60 * 1000 },
+      { command: ['true'], input: 42 },
+      { command: ['true'], input: 'x', tty: true },
+      { command: ['true'], workingDir: '/nonexistent/dir' },
+    ]) {
+      expect((await exec(invalid)).status).toBe(400);
+    }
+  });
+});
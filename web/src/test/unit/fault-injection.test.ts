@@ -0,0 +1,128 @@
+import { afterEach, describe, expect, it, vi } from 'vitest';
+import {
+  DEFAULT_FAULT_CONFIG,
+  FaultInjector,
+  FaultSpecError,
+  parseFaultSpec,
+} from '../../server/utils/fault-injection';
+
+describe('fault injection', () => {
+  describe('parseFaultSpec', () => {
+    it('parses all keys', () => {
+      expect(
+        parseFaultSpec('latency=200, jitter=50,drop=0.1,error=0.2,status=502,match=:4021,seed=7')
+      ).toEqual({
+        latencyMs: 200,
+        jitterMs: 50,
+        dropRate: 0.1,
+        errorRate: 0.2,
+        errorStatus: 502,
+        match: ':4021',
+        seed: 7,
+      });
+    });
+
+    it('uses the defaults for an empty spec', () => {
+      expect(parseFaultSpec('')).toEqual(DEFAULT_FAULT_CONFIG);
+    });
+
+    it.each([
+      'latency',
+      'latency=-1',
+      'drop=2',
+      'drop=abc',
+      'status=404',
+      'speed=3',
+      'drop=0.6,error=0.6',
+    ])('rejects %s', (spec) => {
+      expect(() => parseFaultSpec(spec)).toThrow(FaultSpecError);
+    });
+  });
+
+  describe('FaultInjector', () => {
+    afterEach(() => {
+      vi.useRealTimers();
+    });
+
+    function outcomes(injector: FaultInjector, count: number): string[] {
+      return Array.from({ length: count }, () => injector.decide('http://remote/api').outcome);
+    }
+
+    it('makes the same decisions for the same seed', () => {
+      const config = parseFaultSpec('drop=0.3,error=0.3,jitter=100,seed=42');
+      const first = new FaultInjector(config);
+      const second = new FaultInjector(config);
+      const decide = (injector: FaultInjector) => injector.decide('http://remote/api');
+      const decisions = Array.from({ length: 50 }, () => decide(first));
+      expect(Array.from({ length: 50 }, () => decide(second))).toEqual(decisions);
+      expect(new Set(decisions.map((d) => d.outcome))).toEqual(new Set(['pass', 'drop', 'error']));
+    });
+
+    it('starts over when reconfigured', () => {
+      const injector = new FaultInjector(parseFaultSpec('drop=0.5,seed=3'));
+      const first = outcomes(injector, 20);
+      injector.configure(parseFaultSpec('drop=0.5,seed=3'));
+      expect(outcomes(injector, 20)).toEqual(first);
+      expect(injector.getStats().requests).toBe(20);
+    });
+
+    it('passes everything without faults', () => {
+      const injector = new FaultInjector();
+      expect(outcomes(injector, 20)).toEqual(Array(20).fill('pass'));
+      expect(injector.getStats()).toEqual({ requests: 20, delayed: 0, dropped: 0, errors: 0 });
+    });
+
+    it('only affects matching URLs', () => {
+      const injector = new FaultInjector(parseFaultSpec('drop=1,match=remote-a'));
+      expect(injector.decide('http://remote-b/api/sessions').outcome).toBe('pass');
+      expect(injector.decide('http://remote-a/api/sessions').outcome).toBe('drop');
+      expect(injector.getStats().requests).toBe(1);
+    });
+
+    it('fails fetches like network errors on drops', async () => {
+      const injector = new FaultInjector(parseFaultSpec('drop=1'));
+      await expect(injector.beforeFetch('http://remote/api')).rejects.toThrow(TypeError);
+    });
+
+    it('answers fetches with the error status on errors', async () => {
+      const injector = new FaultInjector(parseFaultSpec('error=1,status=502'));
+      const response = await injector.beforeFetch('http://remote/api');
+      expect(response?.status).toBe(502);
+      expect(await response?.json()).toMatchObject({ code: 'REMOTE_ERROR' });
+    });
+
+    it('delays fetches by the latency', async () => {
+      vi.useFakeTimers();
+      const injector = new FaultInjector(parseFaultSpec('latency=1000'));
+      let settled = false;
+      const result = injector.beforeFetch('http://remote/api').then((response) => {
+        settled = true;
+        return response;
+      });
+      await vi.advanceTimersByTimeAsync(999);
+      expect(settled).toBe(false);
+      await vi.advanceTimersByTimeAsync(1);
+      expect(await result).toBeNull();
+      expect(injector.getStats().delayed).toBe(1);
+    });
+
+    it('stops waiting when the fetch is aborted', async () => {
+      const injector = new FaultInjector(parseFaultSpec('latency=60000'));
+      const controller = new AbortController();
+      const result = injector.beforeFetch('http://remote/api', controller.signal);
+      controller.abort(new Error('timeout'));
+      await expect(result).rejects.toThrow('timeout');
+    });
+
+    it('fails sockets on drops and errors', async () => {
+      await expect(
+        new FaultInjector(parseFaultSpec('drop=1')).beforeConnect('ws://remote/buffers')
+      ).rejects.toThrow('dropped');
+      await expect(
+        new FaultInjector(parseFaultSpec('error=1')).beforeConnect('ws://remote/buffers')
+      ).rejects.toThrow('503');
+      const injector = new FaultInjector();
+      await expect(injector.beforeConnect('ws://remote/buffers')).resolves.toBeUndefined();
+    });
+  });
+});
@@ -0,0 +1,69 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { parseByteRange } from '../../server/routes/filesystem';
+import { FileTail, readLastLines } from '../../server/services/file-tail';
+
+describe('file tail', () => {
+  let dir: string;
+  let file: string;
+  let tail: FileTail | null = null;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'file-tail-'));
+    file = path.join(dir, 'app.log');
+  });
+
+  afterEach(() => {
+    tail?.close();
+    tail = null;
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should read the last lines across chunks', async () => {
+    const lines = Array.from({ length: 20000 }, (_, i) => `line ${i}`);
+    fs.writeFileSync(file, `${lines.join('\n')}\n`);
+
+    const result = await readLastLines(file, 3);
+    expect(result.lines).toEqual(['line 19997', 'line 19998', 'line 19999']);
+    expect(result.offset).toBe(fs.statSync(file).size);
+    expect(result.complete).toBe(false);
+
+    expect(await readLastLines(file, 30000)).toMatchObject({ complete: true });
+    expect((await readLastLines(file, 30000)).lines).toHaveLength(20000);
+  });
+
+  it('should drop a partial first line when the byte limit is hit', async () => {
+    fs.writeFileSync(file, `${'x'.repeat(100)}\nshort\nlast`);
+    expect((await readLastLines(file, 10, 20)).lines).toEqual(['short', 'last']);
+    expect((await readLastLines(file, 0)).lines).toEqual([]);
+  });
+
+  it('should follow appended lines, truncation and rotation', async () => {
+    fs.writeFileSync(file, 'old\n');
+    const events: unknown[] = [];
+    tail = new FileTail(file, fs.statSync(file).size);
+    tail.on('lines', (lines) => events.push(...lines));
+    tail.on('reset', () => events.push('<reset>'));
+    tail.start();
+
+    fs.appendFileSync(file, 'one\ntw');
+    fs.appendFileSync(file, 'o\n');
+    await expect.poll(() => events, { timeout: 5000 }).toEqual(['one', 'two']);
+
+    fs.renameSync(file, `${file}.1`);
+    fs.writeFileSync(file, 'fresh\n');
+    await expect.poll(() => events, { timeout: 5000 }).toEqual(['one', 'two', '<reset>', 'fresh']);
+  });
+
+  it('should parse byte ranges', () => {
+    expect(parseByteRange('bytes=0-9', 100)).toEqual({ start: 0, end: 9 });
+    expect(parseByteRange('bytes=90-', 100)).toEqual({ start: 90, end: 99 });
+    expect(parseByteRange('bytes=-10', 100)).toEqual({ start: 90, end: 99 });
+    expect(parseByteRange('bytes=50-500', 100)).toEqual({ start: 50, end: 99 });
+    expect(parseByteRange('bytes=100-', 100)).toBe('unsatisfiable');
+    expect(parseByteRange('bytes=0-1,5-6', 100)).toBeNull();
+    expect(parseByteRange('items=0-1', 100)).toBeNull();
+  });
+});
@@ -0,0 +1,85 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { FileWatcherPool } from '../../server/services/file-watcher-pool';
+
+vi.mock('fs', async (importOriginal) => {
+  const actual = await importOriginal<typeof import('fs')>();
+  return { ...actual, watch: vi.fn(actual.watch) };
+});
+
+describe('FileWatcherPool', () => {
+  let dir: string;
+  let filePath: string;
+  let pool: FileWatcherPool;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'file-watcher-pool-'));
+    filePath = path.join(dir, 'stdout');
+    fs.writeFileSync(filePath, '');
+    pool = new FileWatcherPool();
+    vi.mocked(fs.watch).mockClear();
+  });
+
+  afterEach(() => {
+    pool.closeAll();
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should share one watcher per path and close it with the last listener', () => {
+    const first = pool.watch(filePath, () => {});
+    const second = pool.watch(path.join(dir, '.', 'stdout'), () => {});
+
+    expect(fs.watch).toHaveBeenCalledTimes(1);
+    const watcher = vi.mocked(fs.watch).mock.results[0].value as fs.FSWatcher;
+    const close = vi.spyOn(watcher, 'close');
+    expect(pool.getStats()).toEqual({ watchedPaths: 1, listeners: 2 });
+
+    first.close();
+    first.close();
+    expect(close).not.toHaveBeenCalled();
+    expect(pool.getStats()).toEqual({ watchedPaths: 1, listeners: 1 });
+
+    second.close();
+    expect(close).toHaveBeenCalledTimes(1);
+    expect(pool.getStats()).toEqual({ watchedPaths: 0, listeners: 0 });
+
+    pool.watch(filePath, () => {}).close();
+    expect(fs.watch).toHaveBeenCalledTimes(2);
+  });
+
+  it('should dispatch changes to every listener', async () => {
+    const changes: string[] = [];
+    pool.watch(filePath, () => changes.push('first'));
+    pool.watch(filePath, () => changes.push('second'));
+
+    fs.appendFileSync(filePath, 'output\n');
+
+    await vi.waitFor(() => expect(changes).toEqual(expect.arrayContaining(['first', 'second'])));
+  });
+
+  it('should close a failed watcher and report the error to every handle', () => {
+    const errors: string[] = [];
+    pool.watch(filePath, () => {}, (error) => errors.push(`first: ${error.message}`));
+    pool.watch(filePath, () => {}, (error) => errors.push(`second: ${error.message}`));
+
+    const watcher = vi.mocked(fs.watch).mock.results[0].value as fs.FSWatcher;
+    const close = vi.spyOn(watcher, 'close');
+    watcher.emit('error', new Error('EPERM'));
+
+    expect(errors).toEqual(['first: EPERM', 'second: EPERM']);
+    expect(close).toHaveBeenCalledTimes(1);
+    expect(pool.getStats()).toEqual({ watchedPaths: 0, listeners: 0 });
+
+    // The next watch of the path starts a new watcher
+    pool.watch(filePath, () => {});
+    expect(fs.watch).toHaveBeenCalledTimes(2);
+    expect(pool.getStats()).toEqual({ watchedPaths: 1, listeners: 1 });
+  });
+
+  it('should throw for files that do not exist', () => {
+    expect(() => pool.watch(path.join(dir, 'missing'), () => {})).toThrow();
+    expect(pool.getStats()).toEqual({ watchedPaths: 0, listeners: 0 });
+  });
+});
@@ -0,0 +1,82 @@
+import { describe, expect, it } from 'vitest';
+import type { RemoteServer } from '../../server/services/remote-registry';
+import { type RemoteLookup, resolveFsProxyTarget } from '../../server/utils/fs-proxy';
+
+function remote(id: string, name: string): RemoteServer {
+  const now = new Date();
+  return {
+    id,
+    name,
+    url: `http://${name}:4020`,
+    token: `token-${id}`,
+    tokenIssuedAt: now,
+    registeredAt: now,
+    lastHeartbeat: now,
+    sessionIds: new Set(),
+    tags: [],
+  };
+}
+
+describe('resolveFsProxyTarget', () => {
+  const remotes = [remote('r1', 'build-box'), remote('r2', 'laptop')];
+  const lookup: RemoteLookup = {
+    getRemote: (id) => remotes.find((r) => r.id === id),
+    getRemoteByName: (name) => remotes.find((r) => r.name === name),
+    getRemoteBySessionId: (sessionId) => remotes.find((r) => sessionId.startsWith(`${r.name}/`)),
+  };
+
+  it('should serve requests without a remote locally', () => {
+    expect(resolveFsProxyTarget(lookup, '/fs/browse?path=~', {})).toBeNull();
+    expect(resolveFsProxyTarget(lookup, '/fs/browse?path=~&sessionId=abc', {})).toBeNull();
+  });
+
+  it('should forward requests for remote sessions with the remote session ID', () => {
+    const target = resolveFsProxyTarget(
+      lookup,
+      '/fs/browse?path=%2Fsrc&sessionId=build-box%2Fabc',
+      {}
+    );
+    expect(target).not.toBe('unknown-remote');
+    if (!target || target === 'unknown-remote') return;
+    expect(target.remote.id).toBe('r1');
+    const url = new URL(target.url);
+    expect(url.origin + url.pathname).toBe('http://build-box:4020/api/fs/browse');
+    expect(url.searchParams.get('path')).toBe('/src');
+    expect(url.searchParams.get('sessionId')).toBe('abc');
+  });
+
+  it('should keep the namespace of sessions behind federated HQs', () => {
+    const url = '/fs/tail?path=a&sessionId=laptop%2Fdev%2Fabc';
+    const target = resolveFsProxyTarget(lookup, url, {});
+    if (!target || target === 'unknown-remote') throw new Error('expected a target');
+    expect(new URL(target.url).searchParams.get('sessionId')).toBe('dev/abc');
+  });
+
+  it('should forward by remote ID or name and drop the parameter', () => {
+    const byId = resolveFsProxyTarget(lookup, '/fs/raw?path=a&remoteId=r2', {});
+    const byName = resolveFsProxyTarget(lookup, '/fs/raw?remoteId=laptop&path=a', {});
+    for (const target of [byId, byName]) {
+      if (!target || target === 'unknown-remote') throw new Error('expected a target');
+      expect(target.url).toBe('http://laptop:4020/api/fs/raw?path=a');
+    }
+  });
+
+  it('should rewrite JSON bodies', () => {
+    const target = resolveFsProxyTarget(lookup, '/fs/move', {
+      from: '/a',
+      to: '/b',
+      remoteId: 'r1',
+      sessionId: 'build-box/abc',
+    });
+    if (!target || target === 'unknown-remote') throw new Error('expected a target');
+    expect(target.url).toBe('http://build-box:4020/api/fs/move');
+    expect(target.body).toEqual({ from: '/a', to: '/b', sessionId: 'abc' });
+  });
+
+  it('should report unknown remotes', () => {
+    expect(resolveFsProxyTarget(lookup, '/fs/browse?remoteId=gone', {})).toBe('unknown-remote');
+    expect(resolveFsProxyTarget(lookup, '/fs/browse?sessionId=gone%2Fabc', {})).toBe(
+      'unknown-remote'
+    );
+  });
+});
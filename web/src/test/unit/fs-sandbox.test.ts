@@ -0,0 +1,100 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { FsSandbox, parseFsRoot, realPathOf } from '../../server/utils/fs-sandbox';
+
+describe('FsSandbox', () => {
+  let dir: string;
+  let home: string;
+
+  beforeEach(() => {
+    dir = fs.realpathSync(fs.mkdtempSync(path.join(os.tmpdir(), 'fs-sandbox-')));
+    home = path.join(dir, 'home');
+    fs.mkdirSync(path.join(home, 'project'), { recursive: true });
+    fs.mkdirSync(path.join(dir, 'secret'));
+    fs.writeFileSync(path.join(dir, 'secret', 'key'), 'x');
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should parse roots', () => {
+    expect(parseFsRoot('/srv/data')).toEqual({ path: '/srv/data' });
+    expect(parseFsRoot('~/src?users=alice,bob')).toEqual({
+      path: '~/src',
+      users: ['alice', 'bob'],
+    });
+    expect(() => parseFsRoot('relative/dir')).toThrow();
+    expect(() => parseFsRoot('?users=alice')).toThrow();
+  });
+
+  it('should default to the home and session directories', async () => {
+    const sessionDir = path.join(dir, 'work');
+    fs.mkdirSync(sessionDir);
+    const sandbox = new FsSandbox([], (user) => (user === 'alice' ? [sessionDir] : []));
+
+    expect(await sandbox.isAllowed(path.join(home, 'project'), { user: 'alice', home })).toBe(true);
+    expect(await sandbox.isAllowed(sessionDir, { user: 'alice', home })).toBe(true);
+    expect(await sandbox.isAllowed(sessionDir, { user: 'bob', home })).toBe(false);
+    expect(await sandbox.isAllowed(path.join(dir, 'secret'), { user: 'alice', home })).toBe(false);
+  });
+
+  it('should reject traversal and symlinks leading out of a root', async () => {
+    const sandbox = new FsSandbox([{ path: '~' }]);
+    fs.symlinkSync(path.join(dir, 'secret'), path.join(home, 'link'));
+
+    expect(await sandbox.isAllowed(path.join(home, '..', 'secret', 'key'), { home })).toBe(false);
+    expect(await sandbox.isAllowed(path.join(home, 'link', 'key'), { home })).toBe(false);
+    expect(await sandbox.isAllowed(path.join(home, 'link', 'new-dir'), { home })).toBe(false);
+    // Paths that do not exist yet are judged by their existing ancestor
+    expect(await sandbox.isAllowed(path.join(home, 'project', 'a', 'b'), { home })).toBe(true);
+  });
+
+  it('should allow names starting with two dots inside a root', async () => {
+    const sandbox = new FsSandbox([{ path: '~' }]);
+    fs.mkdirSync(path.join(home, '..cache'));
+    fs.mkdirSync(path.join(dir, 'home-other'));
+
+    expect(await sandbox.isAllowed(path.join(home, '..cache'), { home })).toBe(true);
+    expect(await sandbox.isAllowed(path.join(home, '..cache', 'new'), { home })).toBe(true);
+    expect(await sandbox.isAllowed(path.join(home, '...'), { home })).toBe(true);
+    expect(await sandbox.isAllowed(home, { home })).toBe(true);
+    expect(await sandbox.isAllowed(dir, { home })).toBe(false);
+    expect(await sandbox.isAllowed(path.join(dir, 'home-other'), { home })).toBe(false);
+  });
+
+  it('should scope roots to their users', async () => {
+    const sandbox = new FsSandbox([
+      { path: path.join(dir, 'secret'), users: ['admin'] },
+      { path: '~' },
+    ]);
+    const secret = path.join(dir, 'secret', 'key');
+    expect(await sandbox.isAllowed(secret, { user: 'admin', home })).toBe(true);
+    expect(await sandbox.isAllowed(secret, { user: 'alice', home })).toBe(false);
+    expect(await sandbox.isAllowed(secret, { home })).toBe(false);
+  });
+
+  it("should always allow the scratch directories of the user's sessions", async () => {
+    const scratch = path.join(dir, 'control', 's1', 'scratch');
+    fs.mkdirSync(scratch, { recursive: true });
+    const sandbox = new FsSandbox([{ path: '~' }], undefined, (user) =>
+      user === 'alice' ? [scratch] : []
+    );
+
+    expect(await sandbox.isAllowed(path.join(scratch, 'out.txt'), { user: 'alice', home })).toBe(
+      true
+    );
+    expect(await sandbox.isAllowed(scratch, { user: 'bob', home })).toBe(false);
+    expect(await sandbox.isAllowed(path.join(dir, 'control', 's1'), { user: 'alice', home })).toBe(
+      false
+    );
+  });
+
+  it('should resolve paths that do not exist yet', async () => {
+    expect(await realPathOf(path.join(home, 'missing', 'file'))).toBe(
+      path.join(home, 'missing', 'file')
+    );
+  });
+});
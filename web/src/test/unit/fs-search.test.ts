@@ -0,0 +1,81 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterAll, beforeAll, describe, expect, it } from 'vitest';
+import { searchFiles } from '../../server/utils/fs-search';
+import { globMatcher } from '../../server/utils/glob';
+
+describe('globMatcher', () => {
+  it('should match paths and base names', () => {
+    expect(globMatcher('**/*.go')('main.go')).toBe(true);
+    expect(globMatcher('**/*.go')('cmd/server/main.go')).toBe(true);
+    expect(globMatcher('*.go')('cmd/server/main.go')).toBe(true);
+    expect(globMatcher('cmd/*.go')('cmd/server/main.go')).toBe(false);
+    expect(globMatcher('*.{ts,tsx}')('app.tsx')).toBe(true);
+    expect(globMatcher('file?.[0-9]')('file1.2')).toBe(true);
+    expect(globMatcher('[!.]*')('.env')).toBe(false);
+    expect(globMatcher('*.md')('README.MD')).toBe(false);
+    expect(globMatcher('*.md', true)('README.MD')).toBe(true);
+    expect(globMatcher('a+b(1).txt')('a+b(1).txt')).toBe(true);
+  });
+});
+
+describe('searchFiles', () => {
+  let root: string;
+
+  beforeAll(() => {
+    root = fs.mkdtempSync(path.join(os.tmpdir(), 'fs-search-'));
+    const write = (file: string, content: string) => {
+      fs.mkdirSync(path.dirname(path.join(root, file)), { recursive: true });
+      fs.writeFileSync(path.join(root, file), content);
+    };
+    write('main.go', 'package main\n// TODO: flags\n');
+    write('pkg/util/util.go', 'package util\n');
+    write('pkg/util/util_test.go', 'package util\n// todo: more tests\n');
+    write('web/app.ts', '// TODO\n');
+    write('.hidden/secret.go', '// TODO\n');
+    write('node_modules/dep/index.go', '// TODO\n');
+    write('bin/tool.go', Buffer.from([0x54, 0x4f, 0x44, 0x4f, 0, 1]).toString('latin1'));
+    fs.symlinkSync(path.join(root, 'pkg'), path.join(root, 'pkg-link'));
+  });
+
+  afterAll(() => {
+    fs.rmSync(root, { recursive: true, force: true });
+  });
+
+  it('should find files by glob, skipping hidden, vendored and linked directories', async () => {
+    const { results, truncated } = await searchFiles(root, { pattern: '**/*.go' });
+    expect(results.map((result) => result.path).sort()).toEqual([
+      'bin/tool.go',
+      'main.go',
+      'pkg/util/util.go',
+      'pkg/util/util_test.go',
+    ]);
+    expect(truncated).toBe(false);
+
+    const hidden = await searchFiles(root, { pattern: '*.go', showHidden: true });
+    expect(hidden.results.map((result) => result.path)).toContain('.hidden/secret.go');
+  });
+
+  it('should search text content case-insensitively with line numbers', async () => {
+    const { results } = await searchFiles(root, { pattern: '**/*.go', content: 'TODO' });
+    expect(results).toEqual([
+      expect.objectContaining({ path: 'main.go', matches: [{ line: 2, text: '// TODO: flags' }] }),
+      expect.objectContaining({
+        path: 'pkg/util/util_test.go',
+        matches: [{ line: 2, text: '// todo: more tests' }],
+      }),
+    ]);
+  });
+
+  it('should stop at the result limit and honor canRead', async () => {
+    expect(await searchFiles(root, { pattern: '*', maxResults: 2 })).toMatchObject({
+      truncated: true,
+    });
+    const { results } = await searchFiles(root, {
+      pattern: '*.go',
+      canRead: async (target) => !target.includes(`${path.sep}pkg`),
+    });
+    expect(results.map((result) => result.path).sort()).toEqual(['bin/tool.go', 'main.go']);
+  });
+});
@@ -0,0 +1,66 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { FsTrash } from '../../server/services/fs-trash';
+
+describe('FsTrash', () => {
+  let dir: string;
+  let work: string;
+  let trash: FsTrash;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'fs-trash-'));
+    work = path.join(dir, 'work');
+    fs.mkdirSync(path.join(work, 'build'), { recursive: true });
+    fs.writeFileSync(path.join(work, 'notes.txt'), 'keep me');
+    fs.writeFileSync(path.join(work, 'build', 'out.log'), 'log');
+    trash = new FsTrash(path.join(dir, 'trash'), 7);
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should move items to the trash and restore them', async () => {
+    const entry = await trash.trash('alice', path.join(work, 'notes.txt'));
+    expect(entry).toMatchObject({ name: 'notes.txt', type: 'file', size: 7 });
+    expect(fs.existsSync(path.join(work, 'notes.txt'))).toBe(false);
+
+    await trash.trash('alice', path.join(work, 'build'));
+    expect((await trash.list('alice')).map((item) => item.type).sort()).toEqual([
+      'directory',
+      'file',
+    ]);
+    // Every user has a trash of their own
+    expect(await trash.list('bob')).toEqual([]);
+    await expect(trash.restore('bob', entry.id)).rejects.toMatchObject({ code: 'NOT_FOUND' });
+
+    await trash.restore('alice', entry.id);
+    expect(fs.readFileSync(path.join(work, 'notes.txt'), 'utf-8')).toBe('keep me');
+    expect(await trash.list('alice')).toHaveLength(1);
+  });
+
+  it('should not overwrite on restore unless asked, trashing what it replaces', async () => {
+    const entry = await trash.trash('alice', path.join(work, 'notes.txt'));
+    fs.writeFileSync(path.join(work, 'notes.txt'), 'newer');
+    await expect(trash.restore('alice', entry.id)).rejects.toMatchObject({ code: 'CONFLICT' });
+
+    await trash.restore('alice', entry.id, true);
+    expect(fs.readFileSync(path.join(work, 'notes.txt'), 'utf-8')).toBe('keep me');
+    const [replaced] = await trash.list('alice');
+    expect(replaced).toMatchObject({ originalPath: path.join(work, 'notes.txt'), size: 5 });
+  });
+
+  it('should purge expired entries and empty the trash', async () => {
+    await trash.trash('alice', path.join(work, 'notes.txt'));
+    await trash.trash('bob', path.join(work, 'build'));
+    expect(await trash.purge()).toBe(0);
+    expect(await trash.purge(Date.now() + 8 * 86400000)).toBe(2);
+
+    fs.writeFileSync(path.join(work, 'again.txt'), 'x');
+    await trash.trash('alice', path.join(work, 'again.txt'));
+    expect(await trash.empty('alice')).toBe(1);
+    expect(await trash.list('alice')).toEqual([]);
+  });
+});
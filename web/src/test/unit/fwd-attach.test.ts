@@ -0,0 +1,120 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { attachToSession, waitForSession } from '../../server/fwd-attach';
+import type { PtyManager } from '../../server/pty/index';
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms));
+
+describe('attachToSession', () => {
+  let tempDir: string;
+  let stdoutPath: string;
+  let ptyManager: PtyManager;
+  let sendInput: ReturnType<typeof vi.fn>;
+  let written: string[];
+
+  const record = (...events: unknown[]) => {
+    fs.appendFileSync(stdoutPath, events.map((event) => `${JSON.stringify(event)}\n`).join(''));
+  };
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'vt-fwd-attach-'));
+    stdoutPath = path.join(tempDir, 'stdout');
+    fs.writeFileSync(stdoutPath, `${JSON.stringify({ version: 2, width: 80, height: 24 })}\n`);
+
+    sendInput = vi.fn();
+    ptyManager = {
+      getSessionPaths: (sessionId: string) => (sessionId === 'host' ? { stdoutPath } : null),
+      sendInput,
+      resizeSession: vi.fn(),
+    } as unknown as PtyManager;
+
+    written = [];
+    vi.spyOn(process.stdout, 'write').mockImplementation((chunk: string | Uint8Array) => {
+      written.push(String(chunk));
+      return true;
+    });
+  });
+
+  afterEach(() => {
+    vi.restoreAllMocks();
+    fs.rmSync(tempDir, { recursive: true, force: true });
+  });
+
+  it('should show the output and end with the exit code of the session', async () => {
+    record([0.1, 'o', 'hello\r\n']);
+    const attached = attachToSession(ptyManager, 'host', 0);
+
+    await sleep(20);
+    record([0.2, 'o', 'world\r\n'], ['exit', 3, 'host']);
+
+    expect(await attached).toEqual({ reason: 'exited', exitCode: 3 });
+    expect(written.join('')).toBe('hello\r\nworld\r\n');
+  });
+
+  it('should detach when idle and show everything again on reattach', async () => {
+    record([0.1, 'o', 'first\r\n']);
+    const started = Date.now();
+
+    expect(await attachToSession(ptyManager, 'host', 80)).toEqual({ reason: 'idle' });
+    expect(Date.now() - started).toBeGreaterThanOrEqual(70);
+    expect(written.join('')).toBe('first\r\n');
+
+    // The session kept running while nobody was attached
+    record([5, 'o', 'second\r\n'], ['exit', 0, 'host']);
+    written = [];
+
+    expect(await attachToSession(ptyManager, 'host', 80)).toEqual({
+      reason: 'exited',
+      exitCode: 0,
+    });
+    expect(written.join('')).toBe('first\r\nsecond\r\n');
+  });
+
+  it('should forward input and restart the idle timer on it', async () => {
+    let result: unknown;
+    const attached = attachToSession(ptyManager, 'host', 150).then((value) => {
+      result = value;
+      return value;
+    });
+
+    await sleep(100);
+    process.stdin.emit('data', 'ls\r');
+    await sleep(100);
+    // 200ms after attaching, but only 100ms after the input
+    expect(result).toBeUndefined();
+    expect(sendInput).toHaveBeenCalledWith('host', { text: 'ls\r' });
+
+    expect(await attached).toEqual({ reason: 'idle' });
+    // Input after detaching no longer reaches the session
+    process.stdin.emit('data', 'pwd\r');
+    expect(sendInput).toHaveBeenCalledTimes(1);
+  });
+
+  it('should leave the session running when the terminal hangs up', async () => {
+    const listeners = process.listenerCount('SIGHUP');
+    const attached = attachToSession(ptyManager, 'host', 0);
+    await sleep(20);
+    process.emit('SIGHUP', 'SIGHUP');
+
+    expect(await attached).toEqual({ reason: 'hangup' });
+    expect(process.listenerCount('SIGHUP')).toBe(listeners);
+  });
+
+  it('should reject unknown sessions', async () => {
+    await expect(attachToSession(ptyManager, 'missing', 0)).rejects.toThrow(
+      'Session missing not found'
+    );
+  });
+});
+
+describe('waitForSession', () => {
+  it('should fail when the host exits before the session runs', async () => {
+    const ptyManager = {
+      getSession: () => ({ status: 'exited', exitCode: 127 }),
+    } as unknown as PtyManager;
+
+    await expect(waitForSession(ptyManager, 'host')).rejects.toThrow('exit code: 127');
+  });
+});
@@ -0,0 +1,61 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { HQClient } from '../../server/services/hq-client';
+import { RemoteTokenStore } from '../../server/services/remote-tokens';
+
+describe('HQClient offline queue', () => {
+  let client: HQClient;
+  let fetchMock: ReturnType<typeof vi.fn>;
+  const sentActions = () =>
+    fetchMock.mock.calls.map(([, init]) => JSON.parse(init.body as string).action);
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    fetchMock = vi.fn();
+    global.fetch = fetchMock;
+    client = new HQClient(
+      'http://hq.example.com',
+      'user',
+      'pass',
+      'remote-1',
+      'http://localhost:4020',
+      new RemoteTokenStore()
+    );
+  });
+
+  afterEach(async () => {
+    await client.destroy();
+    vi.useRealTimers();
+  });
+
+  it('should queue events while HQ is unreachable and replay them in order', async () => {
+    fetchMock.mockRejectedValue(new Error('ECONNREFUSED'));
+    await client.notifySessionChange('created', 'a');
+    await client.notifySessionChange('exited', 'a');
+    expect(client.getStats()).toMatchObject({ offline: true, queuedEvents: 2 });
+
+    fetchMock.mockClear();
+    fetchMock.mockResolvedValue(new Response('{}', { status: 200 }));
+    await vi.advanceTimersByTimeAsync(1000);
+
+    expect(sentActions()).toEqual(['created', 'exited']);
+    expect(client.getStats()).toMatchObject({
+      offline: false,
+      queuedEvents: 0,
+      replayedEvents: 2,
+    });
+  });
+
+  it('should treat server errors as unreachable', async () => {
+    fetchMock.mockResolvedValue(new Response('', { status: 503 }));
+    await client.notifySessionChange('created', 'a');
+    expect(client.getStats()).toMatchObject({ offline: true, queuedEvents: 1 });
+  });
+
+  it('should drop the oldest events when the queue is full', async () => {
+    fetchMock.mockRejectedValue(new Error('ECONNREFUSED'));
+    for (let i = 0; i < 501; i++) {
+      await client.notifySessionChange('created', `s${i}`);
+    }
+    expect(client.getStats()).toMatchObject({ queuedEvents: 500, droppedEvents: 1 });
+  });
+});
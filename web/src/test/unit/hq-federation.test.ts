@@ -0,0 +1,126 @@
+import express from 'express';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import type { PtyManager } from '../../server/pty/index';
+import { createSessionRoutes } from '../../server/routes/sessions';
+import type { ActivityMonitor } from '../../server/services/activity-monitor';
+import type { InputSequencer } from '../../server/services/input-sequencer';
+import { RemoteRegistry } from '../../server/services/remote-registry';
+import type { SizeNegotiator } from '../../server/services/size-negotiator';
+import type { StreamWatcher } from '../../server/services/stream-watcher';
+import type { TerminalManager } from '../../server/services/terminal-manager';
+import {
+  FEDERATION_DEPTH_HEADER,
+  MAX_FEDERATION_DEPTH,
+} from '../../server/utils/session-namespace';
+
+async function listen(app: express.Express): Promise<{ server: Server; url: string }> {
+  const server = app.listen(0);
+  await new Promise((resolve) => server.once('listening', resolve));
+  return { server, url: `http://localhost:${(server.address() as AddressInfo).port}` };
+}
+
+describe('federated HQ sessions', () => {
+  let teamHQ: Server;
+  let globalHQ: Server;
+  let baseUrl: string;
+  let registry: RemoteRegistry;
+  let requests: Array<{ path: string; depth?: string }>;
+
+  beforeEach(async () => {
+    requests = [];
+
+    // A team HQ re-exporting one session of its remote r1
+    const teamApp = express();
+    teamApp.get('/api/health', (_req, res) => {
+      res.json({ status: 'ok' });
+    });
+    teamApp.use((req, _res, next) => {
+      requests.push({
+        path: req.originalUrl,
+        depth: req.headers[FEDERATION_DEPTH_HEADER] as string | undefined,
+      });
+      next();
+    });
+    teamApp.get('/api/sessions', (_req, res) => {
+      res.json([{ id: 'r1/abc', name: 'build', status: 'running', remotePath: ['r1'] }]);
+    });
+    teamApp.get('/api/sessions/:sessionId', (req, res) => {
+      res.json({ id: req.params.sessionId, name: 'build', status: 'running' });
+    });
+    const team = await listen(teamApp);
+    teamHQ = team.server;
+
+    registry = new RemoteRegistry();
+    registry.register({ id: 'team-a-id', name: 'team-a', url: team.url });
+
+    const app = express();
+    app.use(express.json());
+    app.use(
+      '/api',
+      createSessionRoutes({
+        ptyManager: {
+          listSessions: () => [],
+          getSession: () => null,
+        } as unknown as PtyManager,
+        terminalManager: {} as TerminalManager,
+        streamWatcher: {} as StreamWatcher,
+        remoteRegistry: registry,
+        isHQMode: true,
+        activityMonitor: {} as ActivityMonitor,
+        inputSequencer: {} as InputSequencer,
+        sizeNegotiator: {} as SizeNegotiator,
+      })
+    );
+    const global = await listen(app);
+    globalHQ = global.server;
+    baseUrl = `${global.url}/api`;
+  });
+
+  afterEach(() => {
+    registry.destroy();
+    teamHQ.close();
+    globalHQ.close();
+  });
+
+  it('should namespace chained sessions again and keep their path', async () => {
+    const response = await fetch(`${baseUrl}/sessions`);
+    const sessions = await response.json();
+
+    expect(sessions).toHaveLength(1);
+    expect(sessions[0]).toMatchObject({
+      id: 'team-a/r1/abc',
+      remoteSessionId: 'r1/abc',
+      remoteName: 'team-a',
+      remotePath: ['team-a', 'r1'],
+    });
+    expect(requests).toEqual([{ path: '/api/sessions', depth: '1' }]);
+  });
+
+  it('should pass the federation depth on to remotes', async () => {
+    await fetch(`${baseUrl}/sessions`, { headers: { [FEDERATION_DEPTH_HEADER]: '2' } });
+
+    expect(requests).toEqual([{ path: '/api/sessions', depth: '3' }]);
+  });
+
+  it('should stop aggregating at the maximum federation depth', async () => {
+    const response = await fetch(`${baseUrl}/sessions`, {
+      headers: { [FEDERATION_DEPTH_HEADER]: String(MAX_FEDERATION_DEPTH) },
+    });
+
+    expect(await response.json()).toEqual([]);
+    expect(requests).toHaveLength(0);
+  });
+
+  it('should resolve unencoded chained IDs and forward them as one segment', async () => {
+    await fetch(`${baseUrl}/sessions`);
+    requests.length = 0;
+
+    const response = await fetch(`${baseUrl}/sessions/team-a/r1/abc`);
+
+    expect(response.status).toBe(200);
+    expect((await response.json()).id).toBe('team-a/r1/abc');
+    expect(requests.map((request) => request.path)).toEqual(['/api/sessions/r1%2Fabc']);
+  });
+});
@@ -0,0 +1,76 @@
+import { Terminal } from '@xterm/headless';
+import { describe, expect, it } from 'vitest';
+import { HyperlinkTracker } from '../../server/services/hyperlink-tracker';
+import { TerminalManager } from '../../server/services/terminal-manager';
+
+const link = (uri: string, text: string, params = '') =>
+  `\x1b]8;${params};${uri}\x1b\\${text}\x1b]8;;\x1b\\`;
+
+function createTerminal(cols = 20, rows = 5) {
+  const terminal = new Terminal({ cols, rows, allowProposedApi: true });
+  const tracker = new HyperlinkTracker(terminal);
+  const write = (data: string) => new Promise<void>((resolve) => terminal.write(data, resolve));
+  return { terminal, tracker, write };
+}
+
+describe('HyperlinkTracker', () => {
+  it('should record the columns a link covers', async () => {
+    const { tracker, write } = createTerminal();
+    await write(`ls: ${link('file:///tmp/a.txt', 'a.txt', 'id=1')} b\r\n`);
+
+    expect(tracker.getLinks(0, 5)).toEqual([
+      { uri: 'file:///tmp/a.txt', id: '1', spans: [[0, 4, 9]] },
+    ]);
+  });
+
+  it('should split wrapped links into one span per line', async () => {
+    const { tracker, write } = createTerminal(10);
+    await write(`12345${link('https://example.com', 'example.com')}`);
+
+    expect(tracker.getLinks(0, 5)?.[0].spans).toEqual([
+      [0, 5, 10],
+      [1, 0, 6],
+    ]);
+    // Rows are counted from the requested start line
+    expect(tracker.getLinks(1, 1)?.[0].spans).toEqual([[0, 0, 6]]);
+  });
+
+  it('should follow links as the screen scrolls', async () => {
+    const { tracker, write } = createTerminal(20, 3);
+    await write(`${link('https://a.test', 'a')}\r\n\r\n\r\n\r\n`);
+
+    // The link is now in the scrollback, two lines above the screen
+    expect(tracker.getLinks(0, 1)?.[0].spans).toEqual([[0, 0, 1]]);
+    expect(tracker.getLinks(2, 3)).toBeNull();
+  });
+
+  it('should drop links of the alternate screen when it is left', async () => {
+    const { tracker, write } = createTerminal();
+    await write(`\x1b[?1049h${link('https://alt.test', 'alt')}`);
+    expect(tracker.getLinks(0, 5)).toHaveLength(1);
+
+    await write('\x1b[?1049l');
+    expect(tracker.getLinks(0, 5)).toBeNull();
+  });
+});
+
+describe('TerminalManager link table', () => {
+  it('should flag the table and store its offset in the reserved field', () => {
+    const manager = new TerminalManager('/tmp/vibetunnel-test-control');
+    const spans: Array<[number, number, number]> = [[0, 0, 2]];
+    const links = [{ uri: 'https://example.com', spans }];
+    const encoded = manager.encodeSnapshot({
+      cols: 10,
+      rows: 1,
+      viewportY: 0,
+      cursorX: 0,
+      cursorY: 0,
+      cells: [Array.from('ab', (char) => ({ char, width: 1 }))],
+      links,
+    });
+
+    expect(encoded[3]).toBe(0x01);
+    const tableOffset = encoded.readUInt32LE(28);
+    expect(JSON.parse(encoded.subarray(tableOffset).toString('utf8'))).toEqual({ links });
+  });
+});
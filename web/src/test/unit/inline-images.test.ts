@@ -0,0 +1,90 @@
+import { describe, expect, it } from 'vitest';
+import {
+  decodeSixel,
+  type ExtractedImage,
+  InlineImageExtractor,
+  parseImageMarker,
+} from '../../server/pty/inline-images';
+
+const PNG = Buffer.concat([Buffer.from([0x89, 0x50, 0x4e, 0x47]), Buffer.alloc(16)]);
+
+function extract(chunks: string[]) {
+  let text = '';
+  const images: ExtractedImage[] = [];
+  const extractor = new InlineImageExtractor(
+    (output) => {
+      text += output;
+    },
+    (image) => {
+      images.push(image);
+      text += '[image]';
+    }
+  );
+  for (const chunk of chunks) {
+    extractor.feed(chunk);
+  }
+  return { text, images };
+}
+
+describe('InlineImageExtractor', () => {
+  const itermImage = `\x1b]1337;File=name=${Buffer.from('cat.png').toString('base64')};width=10;inline=1:${PNG.toString('base64')}\x07`;
+
+  it('should take iTerm2 images out of the output', () => {
+    const { text, images } = extract([`before${itermImage}after`]);
+    expect(text).toBe('before[image]after');
+    expect(images).toHaveLength(1);
+    expect(images[0]).toMatchObject({
+      format: 'iterm2',
+      mime: 'image/png',
+      name: 'cat.png',
+      width: '10',
+    });
+    expect(images[0].data.equals(PNG)).toBe(true);
+  });
+
+  it('should reassemble images split across chunks', () => {
+    const output = `a\x1b[1mb${itermImage}c`;
+    const chunks: string[] = [];
+    for (let i = 0; i < output.length; i += 5) {
+      chunks.push(output.slice(i, i + 5));
+    }
+    const { text, images } = extract(chunks);
+    expect(text).toBe('a\x1b[1mb[image]c');
+    expect(images).toHaveLength(1);
+  });
+
+  it('should leave file downloads and cancelled sequences in the output', () => {
+    const download = '\x1b]1337;File=name=eA==;inline=0:QQ==\x07';
+    const cancelled = '\x1bPq#0~~\x1b[0m';
+    const { text, images } = extract([`${download}x${cancelled}`]);
+    expect(text).toBe(`${download}x${cancelled}`);
+    expect(images).toHaveLength(0);
+  });
+
+  it('should convert sixel images to PNG', () => {
+    const { text, images } = extract(['\x1bPq#1;2;100;0;0~~@@-!3~\x1b\\done']);
+    expect(text).toBe('[image]done');
+    expect(images[0]).toMatchObject({
+      format: 'sixel',
+      mime: 'image/png',
+      width: '4px',
+      height: '12px',
+    });
+  });
+});
+
+describe('decodeSixel', () => {
+  it('should use the raster size and draw nothing for empty data', () => {
+    expect(decodeSixel('"1;1;8;6')).toMatchObject({ width: '8px', height: '6px' });
+    expect(decodeSixel('#0;2;0;0;0')).toBeNull();
+  });
+});
+
+describe('parseImageMarker', () => {
+  it('should read image markers only', () => {
+    const image = { id: '1', format: 'sixel', mime: 'image/png', size: 10 };
+    expect(parseImageMarker(`image ${JSON.stringify(image)}`)).toEqual(image);
+    expect(parseImageMarker('command ls')).toBeNull();
+    expect(parseImageMarker('image not-json')).toBeNull();
+  });
+});
@@ -0,0 +1,81 @@
+import { describe, expect, it } from 'vitest';
+import { compositionInputData, createUtf8ChunkDecoder } from '../../server/pty/input-encoding';
+import { parseInputBatch, validateComposition } from '../../server/services/input-sequencer';
+
+// Split a buffer into chunks at the given byte positions
+function splitAt(buffer: Buffer, ...positions: number[]): Buffer[] {
+  const chunks: Buffer[] = [];
+  let start = 0;
+  for (const position of positions) {
+    chunks.push(buffer.subarray(start, position));
+    start = position;
+  }
+  chunks.push(buffer.subarray(start));
+  return chunks;
+}
+
+describe('createUtf8ChunkDecoder', () => {
+  const samples = ['こんにちは', '한국어 입력', '中文输入法', 'emoji 👍🏽 ok', 'é and ß'];
+
+  for (const sample of samples) {
+    it(`should reassemble "${sample}" split at any byte boundary`, () => {
+      const bytes = Buffer.from(sample, 'utf8');
+      for (let i = 1; i < bytes.length; i++) {
+        const decoder = createUtf8ChunkDecoder();
+        const text = splitAt(bytes, i)
+          .map((chunk) => decoder.write(chunk))
+          .join('');
+        expect(text + decoder.end()).toBe(sample);
+      }
+    });
+  }
+
+  it('should reassemble a character split across three chunks', () => {
+    const bytes = Buffer.from('😀', 'utf8'); // 4 bytes
+    const decoder = createUtf8ChunkDecoder();
+    const parts = splitAt(bytes, 1, 3).map((chunk) => decoder.write(chunk));
+    expect(parts).toEqual(['', '', '😀']);
+  });
+
+  it('should not emit replacement characters for incomplete input', () => {
+    const bytes = Buffer.from('あ', 'utf8');
+    const decoder = createUtf8ChunkDecoder();
+    expect(decoder.write(bytes.subarray(0, 2))).toBe('');
+    expect(decoder.write(bytes.subarray(2))).toBe('あ');
+  });
+});
+
+describe('compositionInputData', () => {
+  it('should only produce output on commit', () => {
+    expect(compositionInputData({ composition: 'start', text: '' })).toBeNull();
+    expect(compositionInputData({ composition: 'update', text: 'にほ' })).toBeNull();
+    expect(compositionInputData({ composition: 'cancel', text: 'にほ' })).toBeNull();
+    expect(compositionInputData({ composition: 'commit', text: '日本' })).toBe('日本');
+  });
+
+  it('should ignore an empty commit', () => {
+    expect(compositionInputData({ composition: 'commit', text: '' })).toBeNull();
+  });
+});
+
+describe('composition validation', () => {
+  it('should require text to commit', () => {
+    expect(validateComposition('commit', undefined)).not.toBeNull();
+    expect(validateComposition('commit', '日本')).toBeNull();
+    expect(validateComposition('start', undefined)).toBeNull();
+    expect(validateComposition('bogus', 'x')).not.toBeNull();
+  });
+
+  it('should accept composition events in input batches', () => {
+    const result = parseInputBatch({
+      seq: 1,
+      inputs: [
+        { composition: 'start' },
+        { composition: 'update', text: 'にほ' },
+        { composition: 'commit', text: '日本' },
+      ],
+    });
+    expect(result.error).toBeUndefined();
+    expect(result.batch?.inputs.map(compositionInputData)).toEqual([null, null, '日本']);
+  });
+});
@@ -0,0 +1,69 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { InputLockManager } from '../../server/services/input-lock';
+
+describe('InputLockManager', () => {
+  let locks: InputLockManager;
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    locks = new InputLockManager();
+  });
+
+  afterEach(() => {
+    locks.destroy();
+    vi.useRealTimers();
+  });
+
+  it('should only accept input with the holder token', () => {
+    const result = locks.acquire('s1', { userId: 'alice' });
+    if (!result.acquired) throw new Error('lock not acquired');
+
+    expect(locks.checkInput('s1')).toBe(false);
+    expect(locks.checkInput('s1', 'wrong')).toBe(false);
+    expect(locks.checkInput('s1', result.token)).toBe(true);
+    expect(locks.checkInput('s2')).toBe(true);
+  });
+
+  it('should refuse a second holder unless forced', () => {
+    const first = locks.acquire('s1', { userId: 'alice' });
+    const second = locks.acquire('s1', { userId: 'bob' });
+    expect(second).toMatchObject({ acquired: false, lock: { userId: 'alice' } });
+
+    const forced = locks.acquire('s1', { userId: 'bob', force: true });
+    expect(forced.acquired).toBe(true);
+    if (first.acquired) {
+      expect(locks.checkInput('s1', first.token)).toBe(false);
+    }
+  });
+
+  it('should renew the lock with its token', () => {
+    const first = locks.acquire('s1', { userId: 'alice', ttlMs: 1000 });
+    if (!first.acquired) throw new Error('lock not acquired');
+    const renewed = locks.acquire('s1', { userId: 'alice', token: first.token, ttlMs: 1000 });
+    expect(renewed).toMatchObject({ acquired: true, token: first.token });
+  });
+
+  it('should expire idle locks and keep active ones', () => {
+    const changes = vi.fn();
+    locks.on('lock-changed', changes);
+    const result = locks.acquire('s1', { ttlMs: 1000 });
+    if (!result.acquired) throw new Error('lock not acquired');
+
+    vi.advanceTimersByTime(800);
+    expect(locks.checkInput('s1', result.token)).toBe(true);
+    vi.advanceTimersByTime(800);
+    expect(locks.getLock('s1')).not.toBeNull();
+
+    vi.advanceTimersByTime(200);
+    expect(locks.getLock('s1')).toBeNull();
+    expect(changes).toHaveBeenLastCalledWith('s1', null);
+  });
+
+  it('should only release with the matching token', () => {
+    const result = locks.acquire('s1');
+    if (!result.acquired) throw new Error('lock not acquired');
+    expect(locks.release('s1', 'wrong')).toBe(false);
+    expect(locks.release('s1', result.token)).toBe(true);
+    expect(locks.getLock('s1')).toBeNull();
+  });
+});
@@ -0,0 +1,89 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { InputSequencer, parseInputBatch } from '../../server/services/input-sequencer';
+import type { SessionInput } from '../../shared/types';
+
+describe('InputSequencer', () => {
+  let sequencer: InputSequencer;
+  let applied: string[];
+  const apply = (inputs: SessionInput[]) => {
+    for (const input of inputs) applied.push(input.text ?? input.key ?? '');
+  };
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    sequencer = new InputSequencer({ reorderTimeoutMs: 1000, maxPendingBatches: 2 });
+    applied = [];
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+  });
+
+  it('should apply batches in order and acknowledge the last sequence number', () => {
+    expect(sequencer.submit('s', { seq: 1, inputs: [{ text: 'a' }] }, apply)).toEqual({
+      ack: 1,
+      status: 'applied',
+    });
+    sequencer.submit('s', { seq: 2, inputs: [{ text: 'b' }, { key: 'enter' }] }, apply);
+    expect(applied).toEqual(['a', 'b', 'enter']);
+  });
+
+  it('should acknowledge retries without applying them again', () => {
+    sequencer.submit('s', { seq: 1, inputs: [{ text: 'a' }] }, apply);
+    expect(sequencer.submit('s', { seq: 1, inputs: [{ text: 'a' }] }, apply)).toEqual({
+      ack: 1,
+      status: 'duplicate',
+    });
+    expect(applied).toEqual(['a']);
+  });
+
+  it('should hold out-of-order batches until the gap is filled', () => {
+    sequencer.submit('s', { seq: 1, inputs: [{ text: 'a' }] }, apply);
+    expect(sequencer.submit('s', { seq: 3, inputs: [{ text: 'c' }] }, apply).status).toBe(
+      'queued'
+    );
+    expect(applied).toEqual(['a']);
+
+    expect(sequencer.submit('s', { seq: 2, inputs: [{ text: 'b' }] }, apply).ack).toBe(3);
+    expect(applied).toEqual(['a', 'b', 'c']);
+  });
+
+  it('should skip a gap that is never filled', () => {
+    sequencer.submit('s', { seq: 1, inputs: [{ text: 'a' }] }, apply);
+    sequencer.submit('s', { seq: 3, inputs: [{ text: 'c' }] }, apply);
+
+    vi.advanceTimersByTime(1000);
+    expect(applied).toEqual(['a', 'c']);
+  });
+
+  it('should track clients and sessions independently', () => {
+    sequencer.submit('s', { clientId: 'x', seq: 5, inputs: [{ text: 'x' }] }, apply);
+    sequencer.submit('s', { clientId: 'y', seq: 5, inputs: [{ text: 'y' }] }, apply);
+    sequencer.submit('t', { clientId: 'x', seq: 5, inputs: [{ text: 'z' }] }, apply);
+    expect(applied).toEqual(['x', 'y', 'z']);
+  });
+
+  it('should not acknowledge a batch that failed to apply', () => {
+    const failing = () => {
+      throw new Error('write failed');
+    };
+    expect(() => sequencer.submit('s', { seq: 1, inputs: [{ text: 'a' }] }, failing)).toThrow();
+    expect(sequencer.submit('s', { seq: 1, inputs: [{ text: 'a' }] }, apply).status).toBe(
+      'applied'
+    );
+  });
+});
+
+describe('parseInputBatch', () => {
+  it('should accept a valid batch', () => {
+    const result = parseInputBatch({ seq: 0, inputs: [{ text: 'ls' }, { key: 'enter' }] });
+    expect(result.batch?.inputs).toHaveLength(2);
+  });
+
+  it('should reject invalid batches', () => {
+    expect(parseInputBatch({ inputs: [{ text: 'a' }] }).error).toBeDefined();
+    expect(parseInputBatch({ seq: 1, inputs: [] }).error).toBeDefined();
+    expect(parseInputBatch({ seq: 1, inputs: [{ text: 'a', key: 'enter' }] }).error).toBeDefined();
+    expect(parseInputBatch({ seq: -1, inputs: [{ text: 'a' }] }).error).toBeDefined();
+  });
+});
@@ -0,0 +1,49 @@
+import { describe, expect, it } from 'vitest';
+import type { AuthenticatedRequest } from '../../server/middleware/auth';
+import {
+  INPUT_SOURCE_HEADER,
+  inputSourceFromRequest,
+  tokenFingerprint,
+} from '../../server/utils/input-source';
+
+const request = (fields: Partial<AuthenticatedRequest>) =>
+  ({ headers: {}, query: {}, ...fields }) as AuthenticatedRequest;
+
+describe('inputSourceFromRequest', () => {
+  it('should record the user, address and a token fingerprint', () => {
+    const source = inputSourceFromRequest(
+      request({
+        userId: 'alice',
+        ip: '10.0.0.2',
+        authMethod: 'password',
+        headers: { authorization: 'Bearer secret-token' },
+      })
+    );
+    expect(source).toEqual({
+      userId: 'alice',
+      ip: '10.0.0.2',
+      authMethod: 'password',
+      token: tokenFingerprint('secret-token'),
+    });
+    expect(source.token).toHaveLength(12);
+    expect(JSON.stringify(source)).not.toContain('secret-token');
+  });
+
+  it('should use the source forwarded by HQ', () => {
+    const source = inputSourceFromRequest(
+      request({
+        isHQRequest: true,
+        ip: '10.0.0.1',
+        headers: { [INPUT_SOURCE_HEADER]: JSON.stringify({ userId: 'bob', ip: '10.0.0.3' }) },
+      })
+    );
+    expect(source).toEqual({ userId: 'bob', ip: '10.0.0.3', via: 'hq' });
+  });
+
+  it('should ignore a malformed forwarded source', () => {
+    const source = inputSourceFromRequest(
+      request({ isHQRequest: true, headers: { [INPUT_SOURCE_HEADER]: 'not json' } })
+    );
+    expect(source).toEqual({ via: 'hq' });
+  });
+});
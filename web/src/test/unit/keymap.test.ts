@@ -0,0 +1,83 @@
+import { describe, expect, it } from 'vitest';
+import { encodeKey, isSpecialKey, parseKey } from '../../shared/keymap';
+
+describe('keymap', () => {
+  describe('encodeKey', () => {
+    it('should encode cursor keys in normal and application cursor mode', () => {
+      expect(encodeKey('arrow_up')).toBe('\x1b[A');
+      expect(encodeKey('arrow_left')).toBe('\x1b[D');
+      expect(encodeKey('home')).toBe('\x1b[H');
+      expect(encodeKey('end')).toBe('\x1b[F');
+
+      expect(encodeKey('arrow_up', { applicationCursor: true })).toBe('\x1bOA');
+      expect(encodeKey('home', { applicationCursor: true })).toBe('\x1bOH');
+    });
+
+    it('should encode modified cursor keys with the xterm modifier parameter', () => {
+      expect(encodeKey('shift_arrow_up')).toBe('\x1b[1;2A');
+      expect(encodeKey('alt_arrow_left')).toBe('\x1b[1;3D');
+      expect(encodeKey('ctrl_arrow_right')).toBe('\x1b[1;5C');
+      expect(encodeKey('ctrl_shift_end')).toBe('\x1b[1;6F');
+      // Modified keys ignore application cursor mode
+      expect(encodeKey('ctrl_arrow_up', { applicationCursor: true })).toBe('\x1b[1;5A');
+    });
+
+    it('should encode editing and function keys', () => {
+      expect(encodeKey('insert')).toBe('\x1b[2~');
+      expect(encodeKey('delete')).toBe('\x1b[3~');
+      expect(encodeKey('page_up')).toBe('\x1b[5~');
+      expect(encodeKey('page_down')).toBe('\x1b[6~');
+      expect(encodeKey('f1')).toBe('\x1bOP');
+      expect(encodeKey('f4')).toBe('\x1bOS');
+      expect(encodeKey('f5')).toBe('\x1b[15~');
+      expect(encodeKey('f12')).toBe('\x1b[24~');
+
+      expect(encodeKey('shift_f1')).toBe('\x1b[1;2P');
+      expect(encodeKey('ctrl_f5')).toBe('\x1b[15;5~');
+      expect(encodeKey('ctrl_delete')).toBe('\x1b[3;5~');
+    });
+
+    it('should keep the existing key encodings', () => {
+      expect(encodeKey('enter')).toBe('\r');
+      expect(encodeKey('escape')).toBe('\x1b');
+      expect(encodeKey('backspace')).toBe('\x7f');
+      expect(encodeKey('tab')).toBe('\t');
+      expect(encodeKey('shift_tab')).toBe('\x1b[Z');
+      expect(encodeKey('ctrl_enter')).toBe('\n');
+      expect(encodeKey('shift_enter')).toBe('\r\n');
+    });
+
+    it('should encode ctrl and alt combinations with characters', () => {
+      expect(encodeKey('ctrl_c')).toBe('\x03');
+      expect(encodeKey('ctrl_A')).toBe('\x01');
+      expect(encodeKey('ctrl_[')).toBe('\x1b');
+      expect(encodeKey('ctrl_space')).toBe('\x00');
+      expect(encodeKey('ctrl_backspace')).toBe('\x08');
+      expect(encodeKey('alt_x')).toBe('\x1bx');
+      expect(encodeKey('alt_shift_x')).toBe('\x1bX');
+      expect(encodeKey('ctrl_alt_c')).toBe('\x1b\x03');
+      expect(encodeKey('alt_backspace')).toBe('\x1b\x7f');
+      expect(encodeKey('alt_enter')).toBe('\x1b\r');
+    });
+
+    it('should reject unknown keys', () => {
+      expect(encodeKey('f13')).toBeNull();
+      expect(encodeKey('hello')).toBeNull();
+      expect(encodeKey('ctrl_!')).toBeNull();
+    });
+  });
+
+  describe('parseKey', () => {
+    it('should accept modifiers in any order', () => {
+      expect(parseKey('shift_ctrl_home')?.modifiers).toEqual(new Set(['shift', 'ctrl']));
+      expect(encodeKey('shift_ctrl_home')).toBe(encodeKey('ctrl_shift_home'));
+    });
+
+    it('should treat plain characters as text, not keys', () => {
+      expect(parseKey('a')).toBeNull();
+      expect(isSpecialKey('a')).toBe(false);
+      expect(isSpecialKey('ctrl_a')).toBe(true);
+      expect(isSpecialKey('arrow_up')).toBe(true);
+    });
+  });
+});
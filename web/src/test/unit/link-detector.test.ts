@@ -0,0 +1,38 @@
+import { describe, expect, it } from 'vitest';
+import { detectLinks } from '../../server/services/link-detector';
+
+describe('detectLinks', () => {
+  it('should find URLs without trailing punctuation', () => {
+    const links = detectLinks([
+      'Docs at https://example.com/guide.',
+      '(see https://en.wikipedia.org/wiki/Foo_(bar))',
+    ]);
+    expect(links.map((link) => link.value)).toEqual([
+      'https://en.wikipedia.org/wiki/Foo_(bar)',
+      'https://example.com/guide',
+    ]);
+  });
+
+  it('should find paths with line and column', () => {
+    const [link] = detectLinks(['src/app.ts: error in /tmp/app.ts:12:5: oops'], {
+      types: ['path'],
+    });
+    expect(link).toMatchObject({ type: 'path', value: '/tmp/app.ts', line: 12, column: 5 });
+  });
+
+  it('should not take URL paths or fractions for file paths', () => {
+    const links = detectLinks(['speed 10km/s at http://host/a/b'], { types: ['path'] });
+    expect(links).toEqual([]);
+  });
+
+  it('should report each link once with its most recent line', () => {
+    const links = detectLinks(['open /tmp', 'https://a.test', 'cd /tmp again']);
+    expect(links).toHaveLength(2);
+    expect(links[0]).toMatchObject({
+      value: '/tmp',
+      context: 'cd /tmp again',
+      occurrences: 2,
+      exists: true,
+    });
+  });
+});
@@ -0,0 +1,91 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import {
+  createPasswordVerifier,
+  isPasswordBackend,
+  verifyWithHelper,
+  verifyWithShadow,
+} from '../../server/services/password-verifier';
+import { shaCrypt, verifyCryptHash } from '../../server/utils/sha-crypt';
+
+// The PAM backend is not exercised here
+vi.mock('../../server/services/authenticate-pam-loader.js', () => ({ authenticate: vi.fn() }));
+
+const SHA256_HASH = '$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5';
+const SHA512_HASH =
+  '$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1';
+
+describe('shaCrypt', () => {
+  it('should match the reference vectors', () => {
+    expect(shaCrypt('Hello world!', '$5$saltstring')).toBe(SHA256_HASH);
+    expect(shaCrypt('Hello world!', '$6$saltstring')).toBe(SHA512_HASH);
+    // Salts are cut to 16 characters
+    expect(shaCrypt('Hello world!', '$5$rounds=10000$saltstringsaltstring')).toBe(
+      '$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA'
+    );
+  });
+
+  it('should verify passwords against hashes', () => {
+    expect(verifyCryptHash('Hello world!', SHA512_HASH)).toBe(true);
+    expect(verifyCryptHash('hello world!', SHA512_HASH)).toBe(false);
+    expect(verifyCryptHash('Hello world!', '$y$j9T$salt$hash')).toBeNull();
+  });
+});
+
+describe('password backends', () => {
+  let tempDir: string;
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'vt-local-auth-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(tempDir, { recursive: true, force: true });
+  });
+
+  it('should check passwords in a shadow file', async () => {
+    const shadowFile = path.join(tempDir, 'shadow');
+    fs.writeFileSync(
+      shadowFile,
+      [
+        `alice:${SHA512_HASH}:19000:0:99999:7:::`,
+        `locked:!${SHA512_HASH}:19000:0:99999:7:::`,
+        `expired:${SHA256_HASH}:19000:0:99999:7::1:`,
+        'nopass:*:19000:0:99999:7:::',
+      ].join('\n')
+    );
+
+    expect(await verifyWithShadow('alice', 'Hello world!', shadowFile)).toBe(true);
+    expect(await verifyWithShadow('alice', 'wrong', shadowFile)).toBe(false);
+    expect(await verifyWithShadow('locked', 'Hello world!', shadowFile)).toBe(false);
+    expect(await verifyWithShadow('expired', 'Hello world!', shadowFile)).toBe(false);
+    expect(await verifyWithShadow('nopass', '', shadowFile)).toBe(false);
+    expect(await verifyWithShadow('bob', 'Hello world!', shadowFile)).toBe(false);
+  });
+
+  it('should check passwords with a helper program', async () => {
+    const helper = path.join(tempDir, 'pwauth');
+    fs.writeFileSync(
+      helper,
+      '#!/bin/sh\nread user\nread pass\n[ "$user" = alice ] && [ "$pass" = "s3cret pass" ]\n',
+      { mode: 0o755 }
+    );
+
+    expect(await verifyWithHelper(helper, 'alice', 's3cret pass')).toBe(true);
+    expect(await verifyWithHelper(helper, 'alice', 'wrong')).toBe(false);
+    // A newline would let the password smuggle in another line
+    expect(await verifyWithHelper(helper, 'alice', 's3cret pass\nalice')).toBe(false);
+    expect(await verifyWithHelper(path.join(tempDir, 'missing'), 'alice', 'x')).toBe(false);
+
+    const verify = createPasswordVerifier('helper', helper);
+    expect(await verify('alice', 's3cret pass')).toBe(true);
+  });
+
+  it('should validate backend names', () => {
+    expect(isPasswordBackend('shadow')).toBe(true);
+    expect(isPasswordBackend('ldap')).toBe(false);
+    expect(() => createPasswordVerifier('helper')).toThrow('needs a helper program');
+  });
+});
@@ -0,0 +1,71 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import type { PtyManager } from '../../server/pty';
+import { LogForwarder, parseLogSink, parseLogSinks } from '../../server/services/log-forwarder';
+
+const ptyManager = {
+  getSession: (sessionId: string) => ({
+    id: sessionId,
+    name: 'build',
+    command: ['make'],
+    workingDir: '/tmp',
+  }),
+} as unknown as PtyManager;
+
+describe('parseLogSink', () => {
+  it('should parse targets', () => {
+    expect(parseLogSink('file:///var/log/vt.log')).toEqual({
+      type: 'file',
+      path: '/var/log/vt.log',
+    });
+    expect(parseLogSink('syslog+tcp://logs.local:601')).toEqual({
+      type: 'syslog',
+      host: 'logs.local',
+      port: 601,
+      protocol: 'tcp',
+    });
+    expect(parseLogSink('loki+https://loki.local')).toEqual({
+      type: 'loki',
+      url: 'https://loki.local',
+    });
+    expect(() => parseLogSink('ftp://example.com')).toThrow();
+  });
+
+  it('should validate sinks of a create request', () => {
+    expect(parseLogSinks([{ type: 'syslog', host: 'logs.local' }]).sinks).toHaveLength(1);
+    expect(parseLogSinks([{ type: 'file', path: 'relative.log' }]).error).toBeDefined();
+    expect(parseLogSinks('file:///tmp/a.log').error).toBeDefined();
+  });
+});
+
+describe('LogForwarder', () => {
+  let dir: string;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'vibetunnel-logs-'));
+    vi.useFakeTimers();
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should write plain lines to files and rotate them', async () => {
+    const file = path.join(dir, 'out.log');
+    const forwarder = new LogForwarder(ptyManager);
+    forwarder.addSessionSinks('s1', [{ type: 'file', path: file, maxBytes: 100 }]);
+
+    forwarder.handleOutput('s1', '\x1b[32mok\x1b[0m\r\n10%\r100%\r\nprompt$ ');
+    vi.advanceTimersByTime(1000);
+    // The unfinished line is written when the session ends
+    forwarder.handleOutput('s1', 'x'.repeat(80));
+    await forwarder.close();
+
+    const rotated = fs.readFileSync(`${file}.1`, 'utf8');
+    expect(rotated).toMatch(/ build\[s1\]: ok\n.* build\[s1\]: 100%\n$/);
+    expect(fs.readFileSync(file, 'utf8')).toMatch(/ build\[s1\]: prompt\$ x{80}\n$/);
+  });
+});
@@ -0,0 +1,133 @@
+import { describe, expect, it, vi } from 'vitest';
+import type { PtyManager } from '../../server/pty';
+import type { InputLockManager } from '../../server/services/input-lock';
+import { McpServer } from '../../server/services/mcp-server';
+import type { TerminalManager } from '../../server/services/terminal-manager';
+import { TextWaiter } from '../../server/services/text-waiter';
+
+function row(text: string) {
+  return Array.from(text, (char) => ({ char, width: 1 }));
+}
+
+const sessions = [
+  { id: 's1', name: 'build', command: ['make'], workingDir: '/src', status: 'running' },
+  { id: 's2', name: 'old', command: ['ls'], workingDir: '/', status: 'exited', exitCode: 0 },
+];
+
+function createServer(locked = false) {
+  const sendInput = vi.fn();
+  const ptyManager = {
+    listSessions: () => sessions,
+    getSession: (id: string) => sessions.find((session) => session.id === id),
+    sendInput,
+  } as unknown as PtyManager;
+  const terminalManager = {
+    getBufferSnapshot: async () => ({
+      cols: 80,
+      rows: 2,
+      cursorX: 2,
+      cursorY: 1,
+      cells: [row('make: done'), row('$ ')],
+    }),
+    subscribeToBufferChanges: async () => () => {},
+  } as unknown as TerminalManager;
+  const inputLocks = { checkInput: () => !locked } as unknown as InputLockManager;
+  const mcp = new McpServer({
+    ptyManager,
+    terminalManager,
+    textWaiter: new TextWaiter(terminalManager),
+    inputLocks,
+  });
+  return { mcp, sendInput };
+}
+
+const call = (id: number, method: string, params?: Record<string, unknown>) => ({
+  jsonrpc: '2.0',
+  id,
+  method,
+  params,
+});
+
+describe('McpServer', () => {
+  it('should initialize and answer notifications with nothing', async () => {
+    const { mcp } = createServer();
+    const response = await mcp.handle(call(1, 'initialize', { protocolVersion: '2025-03-26' }), {
+      source: {},
+    });
+    expect(response).toMatchObject({
+      id: 1,
+      result: { protocolVersion: '2025-03-26', capabilities: { tools: { listChanged: false } } },
+    });
+
+    const notification = { jsonrpc: '2.0', method: 'notifications/initialized' };
+    expect(await mcp.handle(notification, { source: {} })).toBeNull();
+  });
+
+  it('should list only the tools the token scopes allow', async () => {
+    const { mcp } = createServer();
+    const names = async (scopes?: Array<'sessions:read' | 'sessions:write' | 'admin'>) => {
+      const response = await mcp.handle(call(1, 'tools/list'), { scopes, source: {} });
+      const { tools } = (response as { result: { tools: Array<{ name: string }> } }).result;
+      return tools.map((tool) => tool.name);
+    };
+
+    expect(await names()).toEqual(['list_sessions', 'read_screen', 'send_input', 'wait_for_text']);
+    expect(await names(['sessions:read'])).toEqual([
+      'list_sessions',
+      'read_screen',
+      'wait_for_text',
+    ]);
+    expect(await names(['admin'])).toContain('send_input');
+  });
+
+  it('should run tools', async () => {
+    const { mcp, sendInput } = createServer();
+    const context = { source: { userId: 'alice' } };
+
+    const listed = await mcp.handle(call(1, 'tools/call', { name: 'list_sessions' }), context);
+    expect(listed).toMatchObject({
+      result: { structuredContent: { sessions: [{ id: 's1', command: 'make' }] } },
+    });
+
+    const screen = await mcp.handle(
+      call(2, 'tools/call', { name: 'read_screen', arguments: { sessionId: 's1' } }),
+      context
+    );
+    expect(screen).toMatchObject({
+      result: { content: [{ type: 'text', text: 'make: done\n$' }] },
+    });
+
+    const args = { sessionId: 's1', text: 'make test', submit: true };
+    await mcp.handle(call(3, 'tools/call', { name: 'send_input', arguments: args }), context);
+    expect(sendInput).toHaveBeenCalledWith('s1', { text: 'make test\r' }, { userId: 'alice' });
+  });
+
+  it('should report tool failures as error results', async () => {
+    const { mcp, sendInput } = createServer(true);
+    const send = (sessionId: string, scopes?: Array<'sessions:read'>) =>
+      mcp.handle(
+        call(1, 'tools/call', { name: 'send_input', arguments: { sessionId, key: 'ctrl_c' } }),
+        { scopes, source: {} }
+      );
+
+    // Locked, not running, and without the sessions:write scope
+    const responses = [await send('s1'), await send('s2'), await send('s1', ['sessions:read'])];
+    for (const response of responses) {
+      expect(response).toMatchObject({ result: { isError: true } });
+    }
+    expect(sendInput).not.toHaveBeenCalled();
+  });
+
+  it('should answer protocol errors', async () => {
+    const { mcp } = createServer();
+    expect(await mcp.handle(call(1, 'resources/list'), { source: {} })).toMatchObject({
+      error: { code: -32601 },
+    });
+    const unknownTool = await mcp.handle(call(2, 'tools/call', { name: 'rm_rf' }), { source: {} });
+    expect(unknownTool).toMatchObject({ error: { code: -32602 } });
+    expect(await mcp.handle([call(3, 'ping'), call(4, 'ping')], { source: {} })).toEqual([
+      { jsonrpc: '2.0', id: 3, result: {} },
+      { jsonrpc: '2.0', id: 4, result: {} },
+    ]);
+  });
+});
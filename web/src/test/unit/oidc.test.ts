@@ -0,0 +1,136 @@
+import { createHash, generateKeyPairSync } from 'crypto';
+import * as jwt from 'jsonwebtoken';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { type OidcConfig, OidcError, OidcService } from '../../server/services/oidc';
+
+const ISSUER = 'https://idp.example.com';
+const REDIRECT_URI = 'https://vt.example.com/api/auth/oidc/callback';
+
+describe('OidcService', () => {
+  const { privateKey, publicKey } = generateKeyPairSync('rsa', { modulusLength: 2048 });
+  let fetchMock: ReturnType<typeof vi.fn>;
+  let idTokenClaims: Record<string, unknown>;
+  let service: OidcService;
+
+  const createService = (config: Partial<OidcConfig> = {}) =>
+    new OidcService({ issuer: ISSUER, clientId: 'vibetunnel', clientSecret: 's3cret', ...config });
+
+  // Sign in at the fake provider and return what the callback would receive
+  async function authorize(): Promise<{ state: string; nonce: string; challenge: string }> {
+    const url = new URL(await service.authorizationUrl(REDIRECT_URI));
+    return {
+      state: url.searchParams.get('state') as string,
+      nonce: url.searchParams.get('nonce') as string,
+      challenge: url.searchParams.get('code_challenge') as string,
+    };
+  }
+
+  beforeEach(() => {
+    idTokenClaims = { sub: 'user-1', email: 'Alice@Example.com', email_verified: true };
+    fetchMock = vi.fn(async (url: string, init?: RequestInit) => {
+      switch (url) {
+        case `${ISSUER}/.well-known/openid-configuration`:
+          return Response.json({
+            issuer: ISSUER,
+            authorization_endpoint: `${ISSUER}/authorize`,
+            token_endpoint: `${ISSUER}/token`,
+            jwks_uri: `${ISSUER}/jwks`,
+          });
+        case `${ISSUER}/jwks`:
+          return Response.json({
+            keys: [{ ...publicKey.export({ format: 'jwk' }), kid: 'key-1', use: 'sig' }],
+          });
+        case `${ISSUER}/token`: {
+          const body = new URLSearchParams(init?.body as string);
+          const idToken = jwt.sign({ nonce: body.get('code'), ...idTokenClaims }, privateKey, {
+            algorithm: 'RS256',
+            keyid: 'key-1',
+            issuer: ISSUER,
+            audience: 'vibetunnel',
+            expiresIn: 300,
+          });
+          return Response.json({ id_token: idToken, access_token: 'access' });
+        }
+        default:
+          return new Response('not found', { status: 404 });
+      }
+    });
+    vi.stubGlobal('fetch', fetchMock);
+    service = createService();
+  });
+
+  afterEach(() => {
+    service.destroy();
+    vi.unstubAllGlobals();
+  });
+
+  it('should sign in with the authorization code flow', async () => {
+    const { state, nonce, challenge } = await authorize();
+
+    // The fake provider echoes the code as the nonce of the ID token
+    const identity = await service.completeLogin(state, nonce);
+    expect(identity).toEqual({
+      userId: 'alice@example.com',
+      subject: 'user-1',
+      email: 'alice@example.com',
+      groups: [],
+    });
+
+    const [, init] = fetchMock.mock.calls.find(([url]) => url === `${ISSUER}/token`) ?? [];
+    const body = new URLSearchParams(init.body);
+    expect(body.get('redirect_uri')).toBe(REDIRECT_URI);
+    const verifier = body.get('code_verifier') as string;
+    expect(createHash('sha256').update(verifier).digest('base64url')).toBe(challenge);
+    expect(init.headers.Authorization).toBe(
+      `Basic ${Buffer.from('vibetunnel:s3cret').toString('base64')}`
+    );
+  });
+
+  it('should accept each state only once', async () => {
+    const { state, nonce } = await authorize();
+    await service.completeLogin(state, nonce);
+    await expect(service.completeLogin(state, nonce)).rejects.toThrow('Login expired');
+  });
+
+  it('should reject ID tokens for another login', async () => {
+    const { state } = await authorize();
+    await expect(service.completeLogin(state, 'other-nonce')).rejects.toThrow(
+      'ID token rejected'
+    );
+  });
+
+  it('should reject unverified email addresses', async () => {
+    idTokenClaims.email_verified = false;
+    const { state, nonce } = await authorize();
+    await expect(service.completeLogin(state, nonce)).rejects.toThrow('is not verified');
+  });
+
+  it('should apply the allowed groups and emails', async () => {
+    service.destroy();
+    service = createService({ allowedGroups: ['ops'], allowedEmails: ['@corp.example.com'] });
+
+    const login = async () => {
+      const { state, nonce } = await authorize();
+      return service.completeLogin(state, nonce);
+    };
+
+    const denied = await login().catch((error) => error);
+    expect(denied).toBeInstanceOf(OidcError);
+    expect(denied.status).toBe(403);
+
+    idTokenClaims.groups = ['dev', 'ops'];
+    expect((await login()).groups).toEqual(['dev', 'ops']);
+
+    idTokenClaims = { sub: 'user-2', email: 'bob@corp.example.com', email_verified: true };
+    expect((await login()).userId).toBe('bob@corp.example.com');
+  });
+
+  it('should use another claim as the user ID', async () => {
+    service.destroy();
+    service = createService({ usernameClaim: 'preferred_username' });
+    idTokenClaims.preferred_username = 'alice';
+
+    const { state, nonce } = await authorize();
+    expect((await service.completeLogin(state, nonce)).userId).toBe('alice');
+  });
+});
@@ -0,0 +1,50 @@
+import { describe, expect, it } from 'vitest';
+import { OscParser, parseCwdReport } from '../../server/pty/osc-parser';
+
+function collect(codes: number[]) {
+  const reports: Array<[number, string]> = [];
+  const parser = new OscParser(codes, (code, data) => reports.push([code, data]));
+  return { parser, reports };
+}
+
+describe('OscParser', () => {
+  it('should report sequences ended by BEL or ST', () => {
+    const { parser, reports } = collect([7]);
+    parser.feed('a\x1b]7;file://host/tmp\x07b\x1b]7;file://host/var\x1b\\c');
+    expect(reports).toEqual([
+      [7, 'file://host/tmp'],
+      [7, 'file://host/var'],
+    ]);
+  });
+
+  it('should ignore other codes', () => {
+    const { parser, reports } = collect([7]);
+    parser.feed('\x1b]0;window title\x07\x1b]7;file:///home\x07');
+    expect(reports).toEqual([[7, 'file:///home']]);
+  });
+
+  it('should join sequences split across chunks', () => {
+    const { parser, reports } = collect([7]);
+    parser.feed('prompt \x1b');
+    parser.feed(']7;file://host/ho');
+    parser.feed('me/user\x1b');
+    parser.feed('\\$ ');
+    expect(reports).toEqual([[7, 'file://host/home/user']]);
+  });
+
+  it('should drop malformed sequences', () => {
+    const { parser, reports } = collect([7]);
+    parser.feed('\x1b]7;file:///a\x1b[0m');
+    parser.feed('\x1b]7;file:///b\x07');
+    expect(reports).toEqual([[7, 'file:///b']]);
+  });
+});
+
+describe('parseCwdReport', () => {
+  it('should decode the path of file URLs', () => {
+    expect(parseCwdReport('file://mac.local/Users/me/My%20Project')).toBe('/Users/me/My Project');
+    expect(parseCwdReport('file:///tmp')).toBe('/tmp');
+    expect(parseCwdReport('kitty-shell-cwd://host/srv')).toBe('/srv');
+    expect(parseCwdReport('not a url')).toBeNull();
+  });
+});
@@ -0,0 +1,88 @@
+import { describe, expect, it } from 'vitest';
+import {
+  OutputBroadcaster,
+  type OutputLine,
+  splitBacklog,
+} from '../../server/pty/output-broadcaster';
+
+describe('OutputBroadcaster', () => {
+  it('should tag lines with their byte offsets in the stream file', () => {
+    const broadcaster = new OutputBroadcaster();
+    broadcaster.publish('{"version":2}');
+    broadcaster.publish('[0.1,"o","héllo"]');
+
+    const { backlog } = broadcaster.subscribe(() => {});
+    expect(backlog).toHaveLength(2);
+    expect(backlog[0]).toMatchObject({ startOffset: 0, endOffset: 14 });
+    // é is two bytes in UTF-8
+    expect(backlog[1].startOffset).toBe(14);
+    expect(backlog[1].endOffset).toBe(14 + Buffer.byteLength('[0.1,"o","héllo"]') + 1);
+    expect(broadcaster.getOffset()).toBe(backlog[1].endOffset);
+  });
+
+  it('should deliver new lines to subscribers until they unsubscribe', () => {
+    const broadcaster = new OutputBroadcaster();
+    const received: string[] = [];
+    const subscription = broadcaster.subscribe((entry) => received.push(entry.line));
+
+    broadcaster.publish('a');
+    subscription.unsubscribe();
+    broadcaster.publish('b');
+
+    expect(received).toEqual(['a']);
+    expect(broadcaster.getListenerCount()).toBe(0);
+  });
+
+  it('should evict old lines beyond the ring size but keep the newest', () => {
+    const broadcaster = new OutputBroadcaster(10);
+    broadcaster.publish('12345');
+    broadcaster.publish('67890');
+    broadcaster.publish('abcdefghijklmnop');
+
+    const { backlog } = broadcaster.subscribe(() => {});
+    expect(backlog.map((entry) => entry.line)).toEqual(['abcdefghijklmnop']);
+  });
+
+  it('should not accept subscribers after close', () => {
+    const broadcaster = new OutputBroadcaster();
+    broadcaster.close();
+    broadcaster.subscribe(() => {});
+    expect(broadcaster.isClosed()).toBe(true);
+    expect(broadcaster.getListenerCount()).toBe(0);
+  });
+});
+
+describe('splitBacklog', () => {
+  const backlog: OutputLine[] = [
+    { line: 'a', startOffset: 0, endOffset: 10 },
+    { line: 'b', startOffset: 10, endOffset: 20 },
+    { line: 'c', startOffset: 20, endOffset: 30 },
+  ];
+
+  it('should read everything from the file when fully flushed', () => {
+    expect(splitBacklog(backlog, 30)).toEqual({
+      fileEndOffset: 30,
+      memoryLines: [],
+      hasGap: false,
+    });
+  });
+
+  it('should take unflushed lines from memory', () => {
+    const split = splitBacklog(backlog, 20);
+    expect(split.fileEndOffset).toBe(20);
+    expect(split.memoryLines.map((entry) => entry.line)).toEqual(['c']);
+    expect(split.hasGap).toBe(false);
+  });
+
+  it('should take a partially flushed line from memory', () => {
+    const split = splitBacklog(backlog, 15);
+    expect(split.fileEndOffset).toBe(10);
+    expect(split.memoryLines.map((entry) => entry.line)).toEqual(['b', 'c']);
+  });
+
+  it('should report a gap when unflushed lines were evicted', () => {
+    const split = splitBacklog(backlog.slice(2), 5);
+    expect(split.fileEndOffset).toBe(5);
+    expect(split.hasGap).toBe(true);
+  });
+});
@@ -0,0 +1,33 @@
+import { describe, expect, it } from 'vitest';
+import {
+  createBucket,
+  DEFAULT_OUTPUT_THROTTLE,
+  TokenBucket,
+} from '../../server/services/output-throttle';
+
+describe('TokenBucket', () => {
+  it('should allow a burst and refill at its rate', () => {
+    const bucket = new TokenBucket(100, 300, 0);
+    expect(bucket.take(250, 0)).toBe(true);
+    expect(bucket.take(100, 0)).toBe(false);
+    expect(bucket.available(0)).toBe(50);
+    expect(bucket.take(100, 500)).toBe(true);
+    expect(bucket.available(10_000)).toBe(300);
+  });
+
+  it('should go into debt for forced writes', () => {
+    const bucket = new TokenBucket(100, 100, 0);
+    bucket.force(300, 0);
+    expect(bucket.available(0)).toBe(-200);
+    expect(bucket.msUntilFull(0)).toBe(3000);
+    expect(bucket.take(1, 1000)).toBe(false);
+    expect(bucket.msUntilFull(3000)).toBe(0);
+  });
+
+  it('should create buckets only for enabled caps', () => {
+    expect(createBucket(0, DEFAULT_OUTPUT_THROTTLE)).toBeNull();
+    expect(createBucket(1000, DEFAULT_OUTPUT_THROTTLE, 0)?.capacity).toBe(1000);
+    const settings = { ...DEFAULT_OUTPUT_THROTTLE, burstBytes: 5000 };
+    expect(createBucket(1000, settings, 0)?.available(0)).toBe(5000);
+  });
+});
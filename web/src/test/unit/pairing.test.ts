@@ -0,0 +1,238 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { ApiTokenStore } from '../../server/services/api-tokens';
+import { PairingService, pairingUrl } from '../../server/services/pairing';
+import { encodeQrCode, qrCodeToText } from '../../shared/qr-code';
+
+// Format information for level M and masks 0-7 (ISO/IEC 18004 Annex C, Table C.1)
+const FORMAT_BITS_M = [
+  '101010000010010',
+  '101000100100101',
+  '101111001111100',
+  '101101101001011',
+  '100010111111001',
+  '100000011001110',
+  '100111110010111',
+  '100101010100000',
+];
+
+// Version information for versions 7-10 (ISO/IEC 18004 Annex D, Table D.1)
+const VERSION_BITS: Record<number, string> = {
+  7: '000111110010010100',
+  8: '001000010110111100',
+  9: '001001101010011001',
+  10: '001010010011010011',
+};
+
+const bitString = (bits: boolean[]) => bits.map((dark) => (dark ? '1' : '0')).join('');
+
+// Codewords of a version 1 symbol masked with pattern 0, read in placement order
+function readVersion1Codewords(modules: boolean[][]): number[] {
+  const size = modules.length;
+  const isFunction = (row: number, col: number) =>
+    row === 6 ||
+    col === 6 ||
+    (row < 9 && col < 9) ||
+    (row < 9 && col >= size - 8) ||
+    (row >= size - 8 && col < 9);
+
+  const bits: boolean[] = [];
+  for (let right = size - 1; right >= 1; right -= 2) {
+    if (right === 6) right = 5;
+    const upward = ((right + 1) & 2) === 0;
+    for (let vertical = 0; vertical < size; vertical++) {
+      const row = upward ? size - 1 - vertical : vertical;
+      for (const col of [right, right - 1]) {
+        if (!isFunction(row, col)) bits.push(modules[row][col] !== ((row + col) % 2 === 0));
+      }
+    }
+  }
+  const codewords: number[] = [];
+  for (let i = 0; i + 8 <= bits.length; i += 8) {
+    codewords.push(parseInt(bitString(bits.slice(i, i + 8)), 2));
+  }
+  return codewords;
+}
+
+// Syndromes of a Reed-Solomon codeword, all zero when it is free of errors
+function syndromes(codeword: number[], ecLength: number): number[] {
+  const exp: number[] = [];
+  const log: number[] = [];
+  for (let i = 0, value = 1; i < 255; i++) {
+    exp[i] = value;
+    log[value] = i;
+    value = value & 0x80 ? (value << 1) ^ 0x11d : value << 1;
+  }
+  const multiply = (a: number, b: number) => (a && b ? exp[(log[a] + log[b]) % 255] : 0);
+  return Array.from({ length: ecLength }, (_, i) =>
+    codeword.reduce((sum, byte) => multiply(sum, exp[i]) ^ byte, 0)
+  );
+}
+
+describe('encodeQrCode', () => {
+  it('should draw the format information of every mask', () => {
+    FORMAT_BITS_M.forEach((expected, mask) => {
+      const modules = encodeQrCode('vibetunnel', mask);
+      const size = modules.length;
+      // Both copies, most significant bit first
+      const first = [
+        ...[0, 1, 2, 3, 4, 5, 7].map((col) => modules[8][col]),
+        ...[8, 7, 5, 4, 3, 2, 1, 0].map((row) => modules[row][8]),
+      ];
+      const second = [
+        ...[1, 2, 3, 4, 5, 6, 7].map((i) => modules[size - i][8]),
+        ...[8, 7, 6, 5, 4, 3, 2, 1].map((i) => modules[8][size - i]),
+      ];
+      expect(bitString(first)).toBe(expected);
+      expect(bitString(second)).toBe(expected);
+      // Dark module above the lower format copy
+      expect(modules[size - 8][8]).toBe(true);
+    });
+  });
+
+  it('should draw the version information from version 7 on', () => {
+    // Byte lengths that just overflow versions 6 to 9
+    const lengths: Record<number, number> = { 7: 107, 8: 123, 9: 153, 10: 181 };
+    for (const [version, expected] of Object.entries(VERSION_BITS)) {
+      const modules = encodeQrCode('a'.repeat(lengths[Number(version)]));
+      const size = modules.length;
+      expect(size).toBe(17 + Number(version) * 4);
+
+      const topRight: boolean[] = [];
+      const bottomLeft: boolean[] = [];
+      for (let i = 17; i >= 0; i--) {
+        topRight.push(modules[Math.floor(i / 3)][size - 11 + (i % 3)]);
+        bottomLeft.push(modules[size - 11 + (i % 3)][Math.floor(i / 3)]);
+      }
+      expect(bitString(topRight)).toBe(expected);
+      expect(bitString(bottomLeft)).toBe(expected);
+    }
+  });
+
+  it('should encode byte mode data with Reed-Solomon error correction', () => {
+    // The ISO/IEC 18004 Annex I example (version 1-M) is a valid codeword
+    const annexI = [
+      0x10, 0x20, 0x0c, 0x56, 0x61, 0x80, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec,
+      0x11, 0xa5, 0x24, 0xd4, 0xc1, 0xed, 0x36, 0xc7, 0x87, 0x2c, 0x55,
+    ];
+    expect(syndromes(annexI, 10)).toEqual(new Array(10).fill(0));
+
+    const codewords = readVersion1Codewords(encodeQrCode('vibetunnel', 0));
+    expect(codewords).toHaveLength(26);
+    // Mode 0100, length 10, the text, terminator and pad codewords
+    expect(codewords.slice(0, 16)).toEqual([
+      0x40, 0xa7, 0x66, 0x96, 0x26, 0x57, 0x47, 0x56, 0xe6, 0xe6, 0x56, 0xc0, 0xec, 0x11, 0xec,
+      0x11,
+    ]);
+    expect(syndromes(codewords, 10)).toEqual(new Array(10).fill(0));
+  });
+
+
+  it('should pick the smallest version that fits', () => {
+    // Version 1 (21 modules) holds 14 bytes at level M, version 2 (25) holds 26
+    expect(encodeQrCode('a'.repeat(14)).length).toBe(21);
+    expect(encodeQrCode('a'.repeat(15)).length).toBe(25);
+    expect(() => encodeQrCode('a'.repeat(300))).toThrow();
+  });
+
+  it('should place the finder patterns', () => {
+    const modules = encodeQrCode('vibetunnel://pair?code=ABCD2345');
+    const size = modules.length;
+    for (const [top, left] of [
+      [0, 0],
+      [0, size - 7],
+      [size - 7, 0],
+    ]) {
+      // Dark outer ring, light ring, dark 3x3 center
+      expect(modules[top][left]).toBe(true);
+      expect(modules[top + 1][left + 1]).toBe(false);
+      expect(modules[top + 3][left + 3]).toBe(true);
+    }
+  });
+
+  it('should draw two module rows per text line', () => {
+    const modules = encodeQrCode('hello');
+    // Quiet zone of 2 on every side
+    expect(qrCodeToText(modules).split('\n').length).toBe(Math.ceil((modules.length + 4) / 2));
+  });
+});
+
+describe('PairingService', () => {
+  let tempDir: string;
+  let apiTokens: ApiTokenStore;
+  let pairing: PairingService;
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'vt-pairing-'));
+    apiTokens = new ApiTokenStore(path.join(tempDir, 'api-tokens.json'));
+    pairing = new PairingService(apiTokens);
+  });
+
+  afterEach(() => {
+    pairing.destroy();
+    vi.useRealTimers();
+    fs.rmSync(tempDir, { recursive: true, force: true });
+  });
+
+  it('should hand out a token once the device is approved', () => {
+    const started = pairing.start('alice');
+    expect(started.code).toMatch(/^[A-HJ-NP-Z2-9]{8}$/);
+
+    // Codes may be typed with dashes and in lower case
+    const code = `${started.code.slice(0, 4)}-${started.code.slice(4)}`.toLowerCase();
+    const { pairingId, secret } = pairing.claim(code, 'iPhone', '10.0.0.2');
+    expect(pairingId).toBe(started.id);
+    expect(pairing.get(started.id, 'alice').device?.name).toBe('iPhone');
+    expect(pairing.poll(pairingId, secret)).toEqual({ status: 'claimed' });
+
+    pairing.approve(started.id, 'alice');
+    const result = pairing.poll(pairingId, secret);
+    expect(result.status).toBe('approved');
+    expect(result.scopes).toEqual(['sessions:read', 'sessions:write']);
+
+    const token = apiTokens.verify(result.token as string);
+    expect(token?.userId).toBe('alice');
+    expect(token?.name).toBe('iPhone (paired)');
+
+    // The token is returned only once
+    expect(() => pairing.poll(pairingId, secret)).toThrow('Invalid or expired pairing');
+  });
+
+  it('should not create a token when the device is denied', () => {
+    const started = pairing.start('alice');
+    const { pairingId, secret } = pairing.claim(started.code, 'iPad', '10.0.0.3');
+    pairing.deny(started.id, 'alice');
+
+    expect(pairing.poll(pairingId, secret)).toEqual({ status: 'denied' });
+    expect(apiTokens.list('alice')).toEqual([]);
+  });
+
+  it('should reject a wrong secret, reused codes and other users', () => {
+    const started = pairing.start('alice');
+    const { pairingId } = pairing.claim(started.code, 'iPhone', '10.0.0.2');
+
+    expect(() => pairing.poll(pairingId, 'wrong')).toThrow('Invalid or expired pairing');
+    expect(() => pairing.claim(started.code, 'Other', '10.0.0.9')).toThrow(
+      'Invalid or expired pairing code'
+    );
+    expect(() => pairing.approve(started.id, 'bob')).toThrow('Pairing not found or expired');
+  });
+
+  it('should expire codes after five minutes', () => {
+    vi.useFakeTimers();
+    const started = pairing.start('alice');
+    vi.advanceTimersByTime(5 * 60 * 1000 + 1);
+
+    expect(() => pairing.claim(started.code, 'iPhone', '10.0.0.2')).toThrow(
+      'Invalid or expired pairing code'
+    );
+  });
+
+  it('should build the pairing URL', () => {
+    expect(pairingUrl('https://host:4020', 'ABCD2345')).toBe(
+      'vibetunnel://pair?server=https%3A%2F%2Fhost%3A4020&code=ABCD2345'
+    );
+  });
+});
@@ -0,0 +1,54 @@
+import { describe, expect, it } from 'vitest';
+import { PlainTextLines, PlainTextTransform } from '../../server/services/plain-text-stream';
+
+// A cast event as the stream watcher writes it
+function castEvent(event: unknown[], id: number): string {
+  return `id: ${id}\ndata: ${JSON.stringify(event)}\n\n`;
+}
+
+function readEvents(transform: PlainTextTransform): string[] {
+  const events: string[] = [];
+  transform.on('data', (chunk: Buffer) => events.push(chunk.toString()));
+  return events;
+}
+
+describe('plain-text stream', () => {
+  it('should keep what output leaves on a line', () => {
+    const lines = new PlainTextLines();
+    expect(lines.feed('\x1b[1;32mok\x1b[0m done\r\n')).toEqual(['ok done']);
+    // Progress redrawn in place, with and without erasing the line
+    expect(lines.feed('10%\r100%\r\n')).toEqual(['100%']);
+    expect(lines.feed('working...\r\x1b[Kdone\n')).toEqual(['done']);
+    expect(lines.feed('typo\b\bpo\n')).toEqual(['typo']);
+    expect(lines.feed('$ ')).toEqual([]);
+    expect(lines.current()).toBe('$');
+  });
+
+  it('should send the lines printed within the window as one event', async () => {
+    const transform = new PlainTextTransform(10);
+    const events = readEvents(transform);
+    transform.write(castEvent([0, 'o', 'one\r\n'], 100));
+    transform.write(castEvent([0.1, 'r', '80x24'], 120));
+    transform.write(castEvent([0.2, 'o', '\r\n\x1b[31mtwo\x1b[0m\r\n'], 150));
+    await new Promise((resolve) => setTimeout(resolve, 30));
+    expect(events).toEqual([`id: 150\ndata: ${JSON.stringify({ lines: ['one', 'two'] })}\n\n`]);
+  });
+
+  it('should send the rest of a line that was sent while printed', async () => {
+    const transform = new PlainTextTransform(10);
+    const events = readEvents(transform);
+    transform.write(castEvent([0, 'o', 'user@host:~$ '], 10));
+    await new Promise((resolve) => setTimeout(resolve, 30));
+    transform.write(castEvent([1, 'o', 'ls\r\nREADME.md\r\n'], 40));
+    transform.end(castEvent(['exit', 0, 's1'], 50));
+    await new Promise((resolve) => transform.on('end', resolve));
+
+    const data = events.map((event) => event.match(/^data: (.*)$/m)?.[1]);
+    expect(data).toEqual([
+      JSON.stringify({ lines: ['user@host:~$'] }),
+      JSON.stringify({ lines: ['ls', 'README.md'] }),
+      JSON.stringify({ exitCode: 0 }),
+    ]);
+    expect(events[2]).toMatch(/^event: exit\n/);
+  });
+});
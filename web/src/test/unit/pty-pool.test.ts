@@ -0,0 +1,97 @@
+import { EventEmitter } from 'events';
+import type { IPty } from 'node-pty';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { parsePtyPoolProfile, PtyPool } from '../../server/pty/pty-pool';
+
+// Just enough of node-pty's IPty for the pool
+class FakePty extends EventEmitter {
+  static nextPid = 1000;
+  pid = FakePty.nextPid++;
+  killed = false;
+
+  onData(listener: (data: string) => void) {
+    this.on('data', listener);
+    return { dispose: () => this.off('data', listener) };
+  }
+
+  onExit(listener: (event: { exitCode: number }) => void) {
+    this.on('exit', listener);
+    return { dispose: () => this.off('exit', listener) };
+  }
+
+  kill() {
+    this.killed = true;
+  }
+}
+
+describe('PtyPool', () => {
+  let ptys: FakePty[];
+  let pool: PtyPool;
+  let nextId: number;
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    ptys = [];
+    nextId = 0;
+    pool = new PtyPool([{ command: ['zsh', '-i', '-l'], workingDir: '/work', size: 2 }], () => {
+      const pty = new FakePty();
+      ptys.push(pty);
+      return { sessionId: `s${nextId++}`, root: 'default', ptyProcess: pty as unknown as IPty };
+    });
+    pool.start();
+  });
+
+  afterEach(() => {
+    pool.stop();
+    vi.useRealTimers();
+  });
+
+  it('should parse profiles', () => {
+    expect(parsePtyPoolProfile('bash -l;cwd=/src;size=3')).toEqual({
+      command: ['bash', '-l'],
+      workingDir: '/src',
+      size: 3,
+    });
+    expect(parsePtyPoolProfile('zsh').size).toBe(2);
+    expect(() => parsePtyPoolProfile(';size=2')).toThrow();
+    expect(() => parsePtyPoolProfile('zsh;size=0')).toThrow();
+    expect(() => parsePtyPoolProfile('zsh;shell=bash')).toThrow();
+  });
+
+  it('should hand out started shells with their output and replace them', () => {
+    expect(ptys).toHaveLength(2);
+    ptys[0].emit('data', '$ ');
+
+    const claimed = pool.claim(['zsh', '-i', '-l'], '/work', 'default');
+    expect(claimed).toMatchObject({ sessionId: 's0', output: '$ ' });
+    expect(claimed?.ptyProcess).toBe(ptys[0]);
+    // Output after the claim belongs to the session, not the pool
+    expect(ptys[0].listenerCount('data')).toBe(0);
+
+    vi.runOnlyPendingTimers();
+    expect(ptys).toHaveLength(3);
+    expect(pool.getStats()[0]).toMatchObject({ ready: 0, starting: 2, hits: 1, spawned: 3 });
+  });
+
+  it('should only match the same command, directory and root', () => {
+    expect(pool.claim(['zsh', '-i'], '/work', 'default')).toBeNull();
+    expect(pool.claim(['zsh', '-i', '-l'], '/elsewhere', 'default')).toBeNull();
+    expect(pool.claim(['zsh', '-i', '-l'], '/work', 'project')).toBeNull();
+    expect(pool.getStats()[0]).toMatchObject({ hits: 0, misses: 1 });
+  });
+
+  it('should retry shells that exit right away later', () => {
+    ptys[0].emit('exit', { exitCode: 1 });
+    expect(ptys).toHaveLength(2);
+    expect(pool.getStats()[0]).toMatchObject({ failures: 1, starting: 1 });
+
+    vi.advanceTimersByTime(5000);
+    expect(ptys).toHaveLength(3);
+  });
+
+  it('should kill unclaimed shells when stopped', () => {
+    pool.stop();
+    expect(ptys.every((pty) => pty.killed)).toBe(true);
+    expect(pool.claim(['zsh', '-i', '-l'], '/work', 'default')).toBeNull();
+  });
+});
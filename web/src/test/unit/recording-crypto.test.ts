@@ -0,0 +1,98 @@
+import { randomBytes } from 'crypto';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { OutputBroadcaster } from '../../server/pty/output-broadcaster';
+import {
+  decodeCastLine,
+  encodeCastLine,
+  parseRecordingKeys,
+  RecordingCipher,
+  rekeyRecording,
+  setRecordingCipher,
+} from '../../server/utils/recording-crypto';
+
+describe('recording encryption', () => {
+  const oldKey = { id: 'old', key: randomBytes(32) };
+  const newKey = { id: 'new', key: randomBytes(32) };
+  let testDir: string;
+
+  beforeEach(() => {
+    testDir = fs.mkdtempSync(path.join(os.tmpdir(), 'recording-crypto-test-'));
+  });
+
+  afterEach(() => {
+    setRecordingCipher(null);
+    fs.rmSync(testDir, { recursive: true, force: true });
+  });
+
+  it('should encrypt lines and decrypt them transparently', () => {
+    setRecordingCipher(new RecordingCipher([oldKey]));
+    const line = JSON.stringify([0.5, 'o', 'secret \u001b[1mtoken\u001b[0m']);
+
+    const stored = encodeCastLine(line);
+    expect(stored.startsWith('!vtenc1:old:')).toBe(true);
+    expect(stored).not.toContain('secret');
+    expect(decodeCastLine(stored)).toBe(line);
+    expect(decodeCastLine('[1,"o","plain"]')).toBe('[1,"o","plain"]');
+  });
+
+  it('should leave altered lines and unknown keys undecrypted', () => {
+    setRecordingCipher(new RecordingCipher([oldKey]));
+    const stored = encodeCastLine('[1,"o","x"]');
+    const tampered = `${stored.slice(0, -4)}AAA=`;
+
+    expect(decodeCastLine(tampered)).toBe(tampered);
+    setRecordingCipher(new RecordingCipher([newKey]));
+    expect(decodeCastLine(stored)).toBe(stored);
+  });
+
+  it('should rekey recordings with the current key', async () => {
+    const castPath = path.join(testDir, 'stdout');
+    setRecordingCipher(new RecordingCipher([oldKey]));
+    const lines = ['{"version":2,"width":80,"height":24}', '[0.1,"o","hello"]'];
+    fs.writeFileSync(castPath, `${encodeCastLine(lines[0])}\n${lines[1]}\n`);
+
+    setRecordingCipher(new RecordingCipher([newKey, oldKey]));
+    expect(await rekeyRecording(castPath)).toBe(true);
+    expect(await rekeyRecording(castPath)).toBe(false);
+
+    setRecordingCipher(new RecordingCipher([newKey]));
+    const stored = fs.readFileSync(castPath, 'utf8').trimEnd().split('\n');
+    expect(stored.every((line) => line.startsWith('!vtenc1:new:'))).toBe(true);
+    expect(stored.map(decodeCastLine)).toEqual(lines);
+  });
+
+  it('should keep recordings it cannot decrypt', async () => {
+    const castPath = path.join(testDir, 'stdout');
+    setRecordingCipher(new RecordingCipher([oldKey]));
+    const content = `${encodeCastLine('[0.1,"o","hello"]')}\n`;
+    fs.writeFileSync(castPath, content);
+
+    setRecordingCipher(new RecordingCipher([newKey]));
+    await expect(rekeyRecording(castPath)).rejects.toThrow('Unknown recording key old');
+    expect(fs.readFileSync(castPath, 'utf8')).toBe(content);
+    expect(fs.existsSync(`${castPath}.rekey`)).toBe(false);
+  });
+
+  it('should count offsets in stored bytes', () => {
+    const broadcaster = new OutputBroadcaster();
+    broadcaster.publish('[0.1,"o","hi"]', 'x'.repeat(99));
+    expect(broadcaster.getOffset()).toBe(100);
+  });
+});
+
+describe('parseRecordingKeys', () => {
+  it('should parse plain and KMS keys', () => {
+    const key = randomBytes(32).toString('base64');
+    expect(parseRecordingKeys(`# current\nk2:${key}\nk1:kms:AQIDAHh=`)).toEqual([
+      { id: 'k2', key: Buffer.from(key, 'base64') },
+      { id: 'k1', kmsCiphertext: 'AQIDAHh=' },
+    ]);
+  });
+
+  it('should reject keys of the wrong length', () => {
+    expect(() => parseRecordingKeys('short:AAAA')).toThrow('must be 32 base64-encoded bytes');
+  });
+});
@@ -0,0 +1,110 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import {
+  compareRecordings,
+  diffLines,
+  diffSequences,
+  readRecordingText,
+} from '../../server/services/recording-diff';
+
+describe('diffSequences', () => {
+  it('should find a shortest edit script', () => {
+    const ops = diffSequences('abcabba'.split(''), 'cbabac'.split(''), 100);
+    expect(ops?.filter((op) => op !== 'equal')).toHaveLength(5);
+  });
+
+  it('should give up past the edit limit', () => {
+    expect(diffSequences([1, 2, 3], [4, 5, 6], 2)).toBeNull();
+  });
+});
+
+describe('diffLines', () => {
+  it('should return hunks with context', () => {
+    const left = Array.from({ length: 20 }, (_, i) => `line ${i}`);
+    const right = [...left];
+    right[5] = 'changed';
+
+    const hunks = diffLines(left, right, 1);
+    expect(hunks).toEqual([
+      {
+        leftStart: 5,
+        leftLines: 3,
+        rightStart: 5,
+        rightLines: 3,
+        lines: [
+          { type: 'equal', text: 'line 4' },
+          { type: 'removed', text: 'line 5' },
+          { type: 'added', text: 'changed' },
+          { type: 'equal', text: 'line 6' },
+        ],
+      },
+    ]);
+  });
+
+  it('should return no hunks for equal lines', () => {
+    expect(diffLines(['a', 'b'], ['a', 'b'])).toEqual([]);
+  });
+});
+
+describe('compareRecordings', () => {
+  let dir: string;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'recording-diff-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  function writeCast(name: string, events: unknown[]): string {
+    const castPath = path.join(dir, name);
+    const lines = [{ version: 2, width: 80, height: 24 }, ...events].map((e) => JSON.stringify(e));
+    fs.writeFileSync(castPath, `${lines.join('\n')}\n`);
+    return castPath;
+  }
+
+  it('should compare the output of each command', async () => {
+    const passing = writeCast('passing.cast', [
+      [0, 'm', 'prompt'],
+      [0.1, 'o', '$ ./deploy.sh\r\n'],
+      [1, 'm', 'command ./deploy.sh'],
+      [1.5, 'o', 'uploading\r\n\x1b[32mdone\x1b[0m\r\n'],
+      [2, 'm', 'command-done 0'],
+    ]);
+    const failing = writeCast('failing.cast', [
+      [1, 'm', 'command ./deploy.sh'],
+      [1.5, 'o', 'uploading\r\nerror: timeout\r\n'],
+      [2, 'm', 'command-done 1'],
+      [3, 'm', 'command ./rollback.sh'],
+    ]);
+
+    const comparison = compareRecordings(
+      await readRecordingText(passing),
+      await readRecordingText(failing)
+    );
+    expect(comparison.mode).toBe('commands');
+    expect(comparison.identical).toBe(false);
+    expect(comparison.commands?.map((command) => command.status)).toEqual(['changed', 'added']);
+    expect(comparison.commands?.[0].left?.exitCode).toBe(0);
+    expect(comparison.commands?.[0].right?.exitCode).toBe(1);
+    expect(comparison.commands?.[0].hunks[0].lines).toEqual([
+      { type: 'equal', text: 'uploading' },
+      { type: 'removed', text: 'done' },
+      { type: 'added', text: 'error: timeout' },
+    ]);
+  });
+
+  it('should diff the whole output without marks', async () => {
+    const left = writeCast('left.cast', [[0, 'o', 'progress 10%\rprogress 100%\r\nok\r\n']]);
+    const right = writeCast('right.cast', [[0, 'o', 'progress 100%\r\nok\r\n']]);
+
+    const comparison = compareRecordings(
+      await readRecordingText(left),
+      await readRecordingText(right)
+    );
+    expect(comparison).toMatchObject({ mode: 'output', identical: true, hunks: [] });
+  });
+});
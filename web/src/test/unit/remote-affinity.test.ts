@@ -0,0 +1,72 @@
+import { describe, expect, it } from 'vitest';
+import {
+  matchingRemotes,
+  placeSession,
+  validateAffinity,
+  validateRemoteTags,
+} from '../../server/services/remote-affinity';
+import type { RemoteServer } from '../../server/services/remote-registry';
+
+function remote(name: string, tags: string[], sessions = 0): RemoteServer {
+  const now = new Date();
+  return {
+    id: `id-${name}`,
+    name,
+    url: `http://${name}:4020`,
+    token: `token-${name}`,
+    tokenIssuedAt: now,
+    registeredAt: now,
+    lastHeartbeat: now,
+    sessionIds: new Set(Array.from({ length: sessions }, (_, i) => `${name}/s${i}`)),
+    tags,
+  };
+}
+
+describe('remote affinity', () => {
+  const gpuBox = remote('gpu-box', ['gpu'], 3);
+  const gpuSpare = remote('gpu-spare', ['gpu', 'arm64'], 1);
+  const laptop = remote('laptop', [], 0);
+  const remotes = [laptop, gpuBox, gpuSpare];
+
+  it('should validate affinities and tags', () => {
+    expect(validateAffinity({ remotes: ['gpu-box'], fallback: 'local' })).toBeNull();
+    expect(validateAffinity({ tags: ['gpu'] })).toBeNull();
+    expect(validateAffinity({})).toMatch(/remotes or tags/);
+    expect(validateAffinity({ tags: ['g p u'] })).toMatch(/tags/);
+    expect(validateAffinity({ tags: ['gpu'], fallback: 'random' })).toMatch(/fallback/);
+    expect(validateAffinity(['gpu'])).toMatch(/affinity/);
+    expect(validateRemoteTags(['gpu', 'arm64'])).toBeNull();
+    expect(validateRemoteTags('gpu')).toMatch(/tags/);
+  });
+
+  it('should order named remotes first, then tagged ones by load', () => {
+    expect(matchingRemotes({ tags: ['gpu'] }, remotes)).toEqual([gpuSpare, gpuBox]);
+    expect(matchingRemotes({ remotes: ['gpu-box', 'gone'], tags: ['gpu'] }, remotes)).toEqual([
+      gpuBox,
+      gpuSpare,
+    ]);
+  });
+
+  it('should pin sessions to matching remotes', () => {
+    expect(placeSession({ remotes: ['gpu-box'] }, remotes)).toEqual({ remote: gpuBox });
+    expect(placeSession({ tags: ['gpu'] }, remotes, gpuBox)).toEqual({ remote: gpuBox });
+    expect(placeSession({ tags: ['gpu'] }, remotes, laptop)).toMatchObject({
+      code: 'INVALID_REQUEST',
+    });
+  });
+
+  it('should apply the fallback when no matching remote is registered', () => {
+    const affinity = { remotes: ['gpu-box'] };
+    expect(placeSession(affinity, [laptop])).toMatchObject({ code: 'UNAVAILABLE' });
+    expect(placeSession({ ...affinity, fallback: 'local' }, [laptop])).toEqual({});
+    expect(placeSession({ ...affinity, fallback: 'any' }, [gpuSpare, laptop])).toEqual({
+      remote: laptop,
+    });
+    expect(placeSession({ ...affinity, fallback: 'any' }, [laptop], laptop)).toEqual({
+      remote: laptop,
+    });
+    expect(placeSession({ ...affinity, fallback: 'any' }, [])).toMatchObject({
+      code: 'UNAVAILABLE',
+    });
+  });
+});
@@ -0,0 +1,81 @@
+import { afterEach, describe, expect, it, vi } from 'vitest';
+import {
+  RemoteTokenStore,
+  registrationPayload,
+  signPayload,
+  verifySignature,
+} from '../../server/services/remote-tokens';
+
+describe('remote tokens', () => {
+  afterEach(() => {
+    vi.useRealTimers();
+  });
+
+  describe('RemoteTokenStore', () => {
+    it('should accept only the issued token', () => {
+      const store = new RemoteTokenStore();
+      expect(store.check('a'.repeat(64))).toBe('unknown');
+
+      store.rotate('token-1');
+      expect(store.check('token-1')).toBe('valid');
+      expect(store.check('token-2')).toBe('unknown');
+    });
+
+    it('should keep the previous token valid for the grace period', () => {
+      vi.useFakeTimers();
+      const store = new RemoteTokenStore(1000);
+      store.rotate('token-1');
+      store.rotate('token-2');
+
+      expect(store.check('token-1')).toBe('valid');
+      expect(store.isCurrent('token-1')).toBe(false);
+      expect(store.isCurrent('token-2')).toBe(true);
+
+      vi.advanceTimersByTime(1000);
+      expect(store.check('token-1')).toBe('revoked');
+      expect(store.check('token-2')).toBe('valid');
+    });
+
+    it('should revoke the previous token at once when asked', () => {
+      const store = new RemoteTokenStore();
+      store.rotate('token-1');
+      store.rotate('token-2', { revokePrevious: true });
+
+      expect(store.check('token-1')).toBe('revoked');
+      expect(store.getRevokedCount()).toBe(1);
+    });
+
+    it('should never accept a revoked token again', () => {
+      const store = new RemoteTokenStore();
+      store.rotate('token-1');
+      store.revoke('token-1');
+      store.rotate('token-1');
+
+      expect(store.getCurrent()).toBe('token-1');
+      expect(store.check('token-1')).toBe('revoked');
+    });
+  });
+
+  describe('signatures', () => {
+    const secret = 'shared-secret';
+    const payload = registrationPayload('id', 'remote-1', 'http://localhost:4020');
+
+    it('should verify a fresh signature', () => {
+      const timestamp = Date.now().toString();
+      const signature = signPayload(secret, timestamp, payload);
+      expect(verifySignature(secret, timestamp, signature, payload)).toBe(true);
+    });
+
+    it('should reject wrong secrets, payloads and stale timestamps', () => {
+      const timestamp = Date.now().toString();
+      const signature = signPayload(secret, timestamp, payload);
+
+      expect(verifySignature('other', timestamp, signature, payload)).toBe(false);
+      expect(verifySignature(secret, timestamp, signature, `${payload}x`)).toBe(false);
+      expect(
+        verifySignature(secret, timestamp, signature, payload, Date.now() + 10 * 60 * 1000)
+      ).toBe(false);
+      expect(verifySignature(secret, undefined, signature, payload)).toBe(false);
+    });
+  });
+});
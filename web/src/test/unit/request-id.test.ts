@@ -0,0 +1,78 @@
+import type { NextFunction, Request, Response } from 'express';
+import { describe, expect, it, vi } from 'vitest';
+import { errorEnvelope } from '../../server/middleware/error-envelope';
+import { assignRequestId } from '../../server/middleware/request-id';
+import {
+  getRequestId,
+  isValidRequestId,
+  requestIdHeaders,
+  runWithRequestId,
+} from '../../server/utils/request-context';
+
+function handle(headers: Record<string, string>, next: NextFunction) {
+  const res = { setHeader: vi.fn() } as unknown as Response;
+  assignRequestId({ headers } as unknown as Request, res, next);
+  return res;
+}
+
+describe('assignRequestId', () => {
+  it('should keep a valid incoming request ID', () => {
+    let seen: string | undefined;
+    const res = handle({ 'x-request-id': 'abc-123' }, () => {
+      seen = getRequestId();
+    });
+
+    expect(seen).toBe('abc-123');
+    expect(res.setHeader).toHaveBeenCalledWith('X-Request-ID', 'abc-123');
+  });
+
+  it('should replace missing or unsafe request IDs', () => {
+    let seen: string | undefined;
+    handle({ 'x-request-id': 'bad id\nINJECTED' }, () => {
+      seen = getRequestId();
+    });
+
+    expect(seen).toMatch(/^[0-9a-f-]{36}$/);
+  });
+
+  it('should keep the context across async calls', async () => {
+    let seen: string | undefined;
+    await new Promise<void>((resolve) => {
+      handle({ 'x-request-id': 'async-id' }, async () => {
+        await new Promise((r) => setTimeout(r, 1));
+        seen = getRequestId();
+        resolve();
+      });
+    });
+
+    expect(seen).toBe('async-id');
+  });
+});
+
+describe('request context', () => {
+  it('should build forwarding headers only inside a request', () => {
+    expect(requestIdHeaders()).toEqual({});
+    runWithRequestId('req-1', () => {
+      expect(requestIdHeaders()).toEqual({ 'X-Request-ID': 'req-1' });
+    });
+  });
+
+  it('should validate request IDs', () => {
+    expect(isValidRequestId('a'.repeat(128))).toBe(true);
+    expect(isValidRequestId('a'.repeat(129))).toBe(false);
+    expect(isValidRequestId(['a'])).toBe(false);
+  });
+
+  it('should add the request ID to error bodies', () => {
+    const sent: unknown[] = [];
+    const res = {
+      statusCode: 404,
+      json: (body: unknown) => sent.push(body),
+    } as unknown as Response;
+    errorEnvelope({} as Request, res, vi.fn());
+
+    runWithRequestId('req-2', () => res.json({ error: 'Session not found' }));
+
+    expect(sent[0]).toMatchObject({ code: 'NOT_FOUND', requestId: 'req-2' });
+  });
+});
@@ -0,0 +1,117 @@
+import express from 'express';
+import * as fs from 'fs';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { PtyError, PtyManager } from '../../server/pty/index';
+import { createSessionRoutes } from '../../server/routes/sessions';
+import type { ActivityMonitor } from '../../server/services/activity-monitor';
+import type { InputSequencer } from '../../server/services/input-sequencer';
+import { SizeNegotiator } from '../../server/services/size-negotiator';
+import type { StreamWatcher } from '../../server/services/stream-watcher';
+import type { TerminalManager } from '../../server/services/terminal-manager';
+
+describe('PtyManager resize policy', () => {
+  let controlDir: string;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'resize-policy-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should reject resizes while client resizing is disabled', () => {
+    const ptyManager = new PtyManager(controlDir, { doNotAllowColumnSet: true });
+    expect(ptyManager.getDoNotAllowColumnSet()).toBe(true);
+
+    let error: unknown;
+    try {
+      ptyManager.resizeSession('s1', 100, 30);
+    } catch (caught) {
+      error = caught;
+    }
+    expect(error).toBeInstanceOf(PtyError);
+    expect((error as PtyError).code).toBe('RESIZE_DISABLED');
+
+    ptyManager.setDoNotAllowColumnSet(false);
+    expect(ptyManager.getDoNotAllowColumnSet()).toBe(false);
+  });
+});
+
+describe('resize routes', () => {
+  let server: Server;
+  let baseUrl: string;
+  let resizeAllowed: boolean;
+  const resizeSession = vi.fn();
+
+  beforeEach(async () => {
+    resizeAllowed = true;
+    resizeSession.mockClear();
+    const app = express();
+    app.use(express.json());
+    app.use(
+      '/api',
+      createSessionRoutes({
+        ptyManager: {
+          getSession: (id: string) => (id === 's1' ? { id, status: 'running' } : null),
+          getDoNotAllowColumnSet: () => !resizeAllowed,
+          resizeSession,
+        } as unknown as PtyManager,
+        terminalManager: {} as TerminalManager,
+        streamWatcher: {} as StreamWatcher,
+        remoteRegistry: null,
+        isHQMode: false,
+        activityMonitor: {} as ActivityMonitor,
+        inputSequencer: {} as InputSequencer,
+        sizeNegotiator: new SizeNegotiator({ defaultPolicy: 'largest-wins' }),
+      })
+    );
+    server = app.listen(0);
+    await new Promise((resolve) => server.once('listening', resolve));
+    baseUrl = `http://localhost:${(server.address() as AddressInfo).port}/api`;
+  });
+
+  afterEach(() => {
+    server.close();
+  });
+
+  const resize = (cols: number, rows: number) =>
+    fetch(`${baseUrl}/sessions/s1/resize`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ cols, rows }),
+    });
+
+  it('should report the resize policy', async () => {
+    resizeAllowed = false;
+    const response = await fetch(`${baseUrl}/resize-policy`);
+    expect(await response.json()).toEqual({
+      resizeAllowed: false,
+      defaultSizePolicy: 'largest-wins',
+    });
+
+    const policy = await fetch(`${baseUrl}/sessions/s1/size-policy`);
+    expect(await policy.json()).toMatchObject({ policy: 'largest-wins', resizeAllowed: false });
+  });
+
+  it('should reject client resizes with 403 while resizing is disabled', async () => {
+    resizeAllowed = false;
+    const response = await resize(100, 30);
+    expect(response.status).toBe(403);
+    expect(await response.json()).toEqual({
+      error: 'Terminal resizing is disabled by the server',
+      code: 'RESIZE_DISABLED',
+    });
+    expect(resizeSession).not.toHaveBeenCalled();
+  });
+
+  it('should resize when resizing is allowed', async () => {
+    const response = await resize(100, 30);
+    expect(response.status).toBe(200);
+    expect(resizeSession).toHaveBeenCalledWith('s1', 100, 30);
+  });
+});
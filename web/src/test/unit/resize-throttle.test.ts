@@ -0,0 +1,71 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { ResizeThrottle } from '../../server/pty/resize-throttle';
+
+describe('ResizeThrottle', () => {
+  let throttle: ResizeThrottle;
+  let applied: string[];
+  const apply = (cols: number, rows: number) => {
+    applied.push(`${cols}x${rows}`);
+    return true;
+  };
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    throttle = new ResizeThrottle(10); // One resize per 100ms
+    applied = [];
+  });
+
+  afterEach(() => {
+    throttle.clear('s1');
+    vi.useRealTimers();
+  });
+
+  it('should apply the first resize immediately', () => {
+    expect(throttle.request('s1', 80, 24, apply)).toBe(true);
+    expect(applied).toEqual(['80x24']);
+  });
+
+  it('should coalesce a burst into one resize with the latest size', () => {
+    throttle.request('s1', 80, 24, apply);
+    for (let cols = 81; cols <= 100; cols++) {
+      expect(throttle.request('s1', cols, 24, apply)).toBe(false);
+    }
+    expect(applied).toEqual(['80x24']);
+
+    vi.advanceTimersByTime(100);
+    expect(applied).toEqual(['80x24', '100x24']);
+    expect(throttle.getStats()).toEqual({ coalesced: 19, pending: 0 });
+  });
+
+  it('should not exceed the rate during a long burst', () => {
+    for (let i = 0; i < 100; i++) {
+      throttle.request('s1', 80 + i, 24, apply);
+      vi.advanceTimersByTime(10);
+    }
+    vi.advanceTimersByTime(100);
+    // 1 second of requests every 10ms: at most one resize per 100ms plus the trailing one
+    expect(applied.length).toBeLessThanOrEqual(11);
+    expect(applied[applied.length - 1]).toBe('179x24');
+  });
+
+  it('should not use up the rate for resizes that changed nothing', () => {
+    throttle.request('s1', 80, 24, () => false);
+    expect(throttle.request('s1', 90, 24, apply)).toBe(true);
+    expect(applied).toEqual(['90x24']);
+  });
+
+  it('should throttle sessions independently', () => {
+    throttle.request('s1', 80, 24, apply);
+    expect(throttle.request('s2', 100, 30, apply)).toBe(true);
+    expect(applied).toEqual(['80x24', '100x30']);
+    throttle.clear('s2');
+  });
+
+  it('should drop a queued resize when the session is cleared', () => {
+    throttle.request('s1', 80, 24, apply);
+    throttle.request('s1', 90, 24, apply);
+    throttle.clear('s1');
+    vi.advanceTimersByTime(200);
+    expect(applied).toEqual(['80x24']);
+  });
+});
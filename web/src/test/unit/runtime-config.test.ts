@@ -0,0 +1,141 @@
+import type { NextFunction, Response } from 'express';
+import { describe, expect, it, vi } from 'vitest';
+import {
+  type AuthenticatedRequest,
+  createAdminMiddleware,
+  isAdminRequest,
+} from '../../server/middleware/auth';
+import { createRateLimitMiddleware } from '../../server/middleware/rate-limit';
+import {
+  RuntimeConfig,
+  RuntimeConfigError,
+  type RuntimeSettingsPatch,
+} from '../../server/services/runtime-config';
+
+function createResponse() {
+  const res = {
+    status: vi.fn(() => res),
+    json: vi.fn(() => res),
+    setHeader: vi.fn(),
+  };
+  return res as typeof res & Response;
+}
+
+function request(fields: Partial<AuthenticatedRequest>): AuthenticatedRequest {
+  return { ip: '10.0.0.1', method: 'GET', path: '/sessions', ...fields } as AuthenticatedRequest;
+}
+
+describe('RuntimeConfig', () => {
+  it('should validate updates and leave settings unchanged on errors', () => {
+    const config = new RuntimeConfig();
+    const before = config.get();
+    const invalid = [
+      null,
+      [],
+      { unknown: 1 },
+      { logLevel: 'verbose' },
+      // Inherited object properties are not log levels
+      { logLevel: 'constructor' },
+      { logLevel: '__proto__' },
+      { terminalCleanupIntervalMs: 5 },
+      { rateLimit: null },
+      { rateLimit: 'fast' },
+      { rateLimit: [] },
+      { rateLimit: { windowMs: 10 } },
+      { rateLimit: { maxRequests: -1 } },
+      { logLevel: 'debug', rateLimit: { maxRequests: 1.5 } },
+      { sessionLimits: null },
+      { sessionLimits: 3 },
+      { sessionLimits: { maxSessions: -1 } },
+      { outputThrottle: null },
+      { outputThrottle: { burstBytes: 0.5 } },
+    ];
+    for (const patch of invalid) {
+      expect(() => config.update(patch as unknown as RuntimeSettingsPatch)).toThrow(
+        RuntimeConfigError
+      );
+    }
+    expect(config.get()).toEqual(before);
+  });
+
+  it('should apply valid updates and emit the changed keys', () => {
+    const config = new RuntimeConfig({ logLevel: 'info' });
+    const listener = vi.fn();
+    config.on('change', listener);
+
+    const updated = config.update({ rateLimit: { maxRequests: 100 } });
+    expect(updated.rateLimit).toEqual({ windowMs: 60_000, maxRequests: 100 });
+    expect(listener).toHaveBeenCalledWith(updated, ['rateLimit']);
+
+    config.update({ sessionLimits: { maxSessions: 5 } });
+    expect(config.get().sessionLimits).toEqual({ maxSessions: 5, maxSessionsPerUser: 0 });
+    expect(listener).toHaveBeenLastCalledWith(config.get(), ['sessionLimits']);
+
+    // Returned settings are copies
+    updated.rateLimit.maxRequests = 1;
+    expect(config.get().rateLimit.maxRequests).toBe(100);
+  });
+});
+
+describe('rate limit middleware', () => {
+  it('should limit requests per client and window', () => {
+    const config = new RuntimeConfig();
+    config.update({ rateLimit: { windowMs: 1000, maxRequests: 2 } });
+    const middleware = createRateLimitMiddleware(config);
+    const next = vi.fn() as NextFunction;
+
+    for (let i = 0; i < 3; i++) middleware(request({}), createResponse(), next);
+    const limited = createResponse();
+    middleware(request({}), limited, next);
+
+    expect(next).toHaveBeenCalledTimes(2);
+    expect(limited.status).toHaveBeenCalledWith(429);
+    expect(limited.setHeader).toHaveBeenCalledWith('Retry-After', '1');
+
+    // Other clients and HQ requests have their own budget
+    middleware(request({ userId: 'alice' }), createResponse(), next);
+    middleware(request({ isHQRequest: true }), createResponse(), next);
+    expect(next).toHaveBeenCalledTimes(4);
+  });
+
+  it('should start over when the limits change', () => {
+    const config = new RuntimeConfig();
+    config.update({ rateLimit: { maxRequests: 1 } });
+    const middleware = createRateLimitMiddleware(config);
+    const next = vi.fn() as NextFunction;
+
+    middleware(request({}), createResponse(), next);
+    middleware(request({}), createResponse(), next);
+    expect(next).toHaveBeenCalledTimes(1);
+
+    config.update({ rateLimit: { maxRequests: 0 } });
+    middleware(request({}), createResponse(), next);
+    expect(next).toHaveBeenCalledTimes(2);
+  });
+});
+
+describe('admin access', () => {
+  it('should grant admin to listed users and local operators', () => {
+    const admins = ['alice'];
+    expect(isAdminRequest(request({ userId: 'alice', authMethod: 'password' }), admins)).toBe(true);
+    expect(isAdminRequest(request({ userId: 'bob', authMethod: 'password' }), admins)).toBe(false);
+    expect(isAdminRequest(request({ authMethod: 'no-auth' }), [])).toBe(true);
+    expect(isAdminRequest(request({ authMethod: 'local-bypass' }), [])).toBe(true);
+    expect(isAdminRequest(request({ authMethod: 'hq-bearer' }), [])).toBe(true);
+    expect(isAdminRequest(request({}), [])).toBe(false);
+  });
+
+  it('should deny requests without the admin role', () => {
+    const middleware = createAdminMiddleware(['alice']);
+    const next = vi.fn() as NextFunction;
+    const denied = createResponse();
+    middleware(request({ userId: 'bob', authMethod: 'password' }), denied, next);
+
+    expect(next).not.toHaveBeenCalled();
+    expect(denied.status).toHaveBeenCalledWith(403);
+    expect(denied.json).toHaveBeenCalledWith(expect.objectContaining({ code: 'ADMIN_REQUIRED' }));
+
+    middleware(request({ userId: 'alice', authMethod: 'password' }), createResponse(), next);
+    expect(next).toHaveBeenCalledTimes(1);
+  });
+});
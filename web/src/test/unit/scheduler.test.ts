@@ -0,0 +1,186 @@
+import express from 'express';
+import * as fs from 'fs';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import type { AuthenticatedRequest } from '../../server/middleware/auth';
+import type { PtyManager } from '../../server/pty/index';
+import { createScheduleRoutes } from '../../server/routes/schedules';
+import { type ScheduledJobInput, Scheduler } from '../../server/services/scheduler';
+import type { Session } from '../../shared/types';
+
+// Local accounts are looked up for real; the current user always has one
+const LOCAL_USER = os.userInfo().username;
+
+const SESSIONS = [
+  { id: 'own-session', createdBy: LOCAL_USER, status: 'running' },
+  { id: 'other-session', createdBy: 'someone-else', status: 'running' },
+] as Session[];
+
+describe('Scheduler', () => {
+  let controlDir: string;
+  let createSession: ReturnType<typeof vi.fn>;
+  let ptyManager: PtyManager;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'scheduler-'));
+    createSession = vi.fn(async () => ({ sessionId: 'spawned' }));
+    ptyManager = { createSession } as unknown as PtyManager;
+  });
+
+  afterEach(() => {
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  const spawnJob = { name: 'backup', cron: '@daily', command: 'make backup' };
+
+  it("should spawn sessions as the owner's account and count them against the owner", async () => {
+    const scheduler = new Scheduler(controlDir, ptyManager, undefined, true);
+    const job = scheduler.create({ ...spawnJob, target: { type: 'spawn' } }, LOCAL_USER);
+    expect(job.owner).toBe(LOCAL_USER);
+
+    expect(await scheduler.runNow(job.id)).toMatchObject({ success: true, sessionId: 'spawned' });
+    const [command, options] = createSession.mock.calls[0];
+    expect(command.slice(1)).toEqual(['-c', 'make backup']);
+    expect(options.createdBy).toBe(LOCAL_USER);
+    expect(options.runAs.username).toBe(LOCAL_USER);
+    expect(options.workingDir).toBe(options.runAs.home);
+  });
+
+  it('should fail runs of owners without a local account', async () => {
+    const scheduler = new Scheduler(controlDir, ptyManager, undefined, true);
+    const job = scheduler.create({ ...spawnJob, target: { type: 'spawn' } }, 'no-such-user');
+
+    expect(await scheduler.runNow(job.id)).toMatchObject({
+      success: false,
+      error: 'no-such-user has no local account on this host',
+    });
+    expect(createSession).not.toHaveBeenCalled();
+  });
+
+  it('should keep the server account for jobs of operators', async () => {
+    const scheduler = new Scheduler(controlDir, ptyManager, undefined, true);
+    const job = scheduler.create({ ...spawnJob, target: { type: 'spawn' } });
+
+    await scheduler.runNow(job.id);
+    const options = createSession.mock.calls[0][1];
+    expect(options.createdBy).toBeUndefined();
+    expect(options.runAs).toBeUndefined();
+  });
+});
+
+describe('schedule routes', () => {
+  let controlDir: string;
+  let scheduler: Scheduler;
+  let server: Server;
+  let baseUrl: string;
+
+  // The caller is picked with a header: a user name, or "operator" for a local bypass
+  const request = (user: string, method: string, route: string, body?: unknown) =>
+    fetch(`${baseUrl}/${route}`, {
+      method,
+      headers: { 'Content-Type': 'application/json', 'X-Test-User': user },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+
+  const job = (target: unknown) => ({ name: 'job', cron: '@daily', command: 'date', target });
+
+  beforeEach(async () => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'scheduler-'));
+    const ptyManager = {
+      getSession: (sessionId: string) =>
+        SESSIONS.find((session) => session.id === sessionId) ?? null,
+      createSession: async () => ({ sessionId: 'spawned' }),
+    } as unknown as PtyManager;
+    scheduler = new Scheduler(controlDir, ptyManager, undefined, true);
+    const app = express();
+    app.use(express.json());
+    app.use((req: AuthenticatedRequest, _res, next) => {
+      const user = req.headers['x-test-user'];
+      if (user === 'operator') {
+        req.authMethod = 'local-bypass';
+      } else {
+        req.authMethod = 'password';
+        req.userId = user as string;
+      }
+      next();
+    });
+    app.use(
+      '/api',
+      createScheduleRoutes({ scheduler, ptyManager, adminUsers: ['root-admin'], localUsers: true })
+    );
+    server = app.listen(0);
+    await new Promise((resolve) => server.once('listening', resolve));
+    baseUrl = `http://localhost:${(server.address() as AddressInfo).port}/api`;
+  });
+
+  afterEach(() => {
+    server.close();
+    scheduler.stop();
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should record the owner and only allow sessions the owner may use', async () => {
+    const created = await request(LOCAL_USER, 'POST', 'schedules', job({ type: 'spawn' }));
+    expect(created.status).toBe(200);
+    const { id, owner } = await created.json();
+    expect(owner).toBe(LOCAL_USER);
+
+    const own = job({ type: 'session', sessionId: 'own-session' });
+    expect((await request(LOCAL_USER, 'POST', 'schedules', own)).status).toBe(200);
+    const other = job({ type: 'session', sessionId: 'other-session' });
+    const denied = await request(LOCAL_USER, 'POST', 'schedules', other);
+    expect(denied.status).toBe(403);
+    expect((await denied.json()).code).toBe('FORBIDDEN');
+
+    // Admins cannot point a user's job at sessions the user may not use either
+    const retarget = { target: { type: 'session', sessionId: 'other-session' } };
+    expect((await request('root-admin', 'PATCH', `schedules/${id}`, retarget)).status).toBe(403);
+    // Operators' jobs are not limited
+    expect((await request('operator', 'POST', 'schedules', other)).status).toBe(200);
+  });
+
+  it('should require a local account to create jobs', async () => {
+    const response = await request('no-such-user', 'POST', 'schedules', job({ type: 'spawn' }));
+    expect(response.status).toBe(403);
+    expect(scheduler.list()).toEqual([]);
+  });
+
+  it('should let only the owner or an admin see and change a job', async () => {
+    const { id } = scheduler.create(job({ type: 'spawn' }) as ScheduledJobInput, LOCAL_USER);
+    scheduler.create(job({ type: 'spawn' }) as ScheduledJobInput);
+
+    const listed = async (user: string) => (await request(user, 'GET', 'schedules')).json();
+    expect(await listed(LOCAL_USER)).toHaveLength(1);
+    expect(await listed('intruder')).toHaveLength(0);
+    expect(await listed('root-admin')).toHaveLength(2);
+
+    for (const [method, route, body] of [
+      ['GET', `schedules/${id}`],
+      ['PATCH', `schedules/${id}`, { enabled: false }],
+      ['POST', `schedules/${id}/run`],
+      ['GET', `schedules/${id}/runs`],
+      ['DELETE', `schedules/${id}`],
+    ] as const) {
+      const response = await request('intruder', method, route, body);
+      expect(response.status).toBe(404);
+      expect((await response.json()).code).toBe('SCHEDULE_NOT_FOUND');
+    }
+    expect(scheduler.get(id)?.enabled).toBe(true);
+
+    const patch = await request(LOCAL_USER, 'PATCH', `schedules/${id}`, { enabled: false });
+    expect(patch.status).toBe(200);
+    expect((await request('root-admin', 'DELETE', `schedules/${id}`)).status).toBe(200);
+  });
+
+  it('should answer invalid jobs with the API error format', async () => {
+    const response = await request(LOCAL_USER, 'POST', 'schedules', {
+      ...job({ type: 'spawn' }),
+      cron: 'every day',
+    });
+    expect(response.status).toBe(400);
+    expect((await response.json()).code).toBe('INVALID_REQUEST');
+  });
+});
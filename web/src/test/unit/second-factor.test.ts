@@ -0,0 +1,501 @@
+import {
+  createHash,
+  createPrivateKey,
+  generateKeyPairSync,
+  type KeyObject,
+  sign,
+} from 'crypto';
+import express from 'express';
+import * as fs from 'fs';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { createAuthRoutes } from '../../server/routes/auth';
+import type { AuthService } from '../../server/services/auth-service';
+import { SecondFactorError, SecondFactorService } from '../../server/services/second-factor';
+import {
+  base32Decode,
+  base32Encode,
+  generateTotp,
+  totpCounter,
+  verifyTotp,
+} from '../../server/utils/totp';
+import {
+  decodeCbor,
+  verifyAssertion,
+  verifyRegistration,
+  WebAuthnError,
+} from '../../server/utils/webauthn';
+
+const ORIGIN = 'https://vt.example.com';
+
+// Just enough CBOR to build attestation objects and COSE keys
+function cbor(value: unknown): Buffer {
+  const head = (major: number, length: number) =>
+    length < 24
+      ? Buffer.from([(major << 5) | length])
+      : length < 256
+        ? Buffer.from([(major << 5) | 24, length])
+        : Buffer.from([(major << 5) | 25, length >> 8, length & 255]);
+  if (typeof value === 'number') {
+    return value >= 0 ? head(0, value) : head(1, -1 - value);
+  }
+  if (typeof value === 'string') {
+    return Buffer.concat([head(3, Buffer.byteLength(value)), Buffer.from(value)]);
+  }
+  if (Buffer.isBuffer(value)) {
+    return Buffer.concat([head(2, value.length), value]);
+  }
+  const entries = Array.from(value as Map<unknown, unknown>);
+  return Buffer.concat([
+    head(5, entries.length),
+    ...entries.flatMap(([key, item]) => [cbor(key), cbor(item)]),
+  ]);
+}
+
+function clientData(type: string, challenge: string): string {
+  return Buffer.from(JSON.stringify({ type, challenge, origin: ORIGIN })).toString('base64url');
+}
+
+function authenticatorData(
+  signCount: number,
+  credential?: { id: Buffer; key: KeyObject } | { id: Buffer; coseKey: Buffer }
+) {
+  const flags = credential ? 0x41 : 0x01;
+  const header = Buffer.alloc(37);
+  createHash('sha256').update('vt.example.com').digest().copy(header);
+  header[32] = flags;
+  header.writeUInt32BE(signCount, 33);
+  if (!credential) return header;
+
+  let coseKey: Buffer;
+  if ('coseKey' in credential) {
+    coseKey = credential.coseKey;
+  } else {
+    const jwk = credential.key.export({ format: 'jwk' });
+    coseKey = cbor(
+      new Map<number, unknown>([
+        [1, 2],
+        [3, -7],
+        [-1, 1],
+        [-2, Buffer.from(jwk.x as string, 'base64url')],
+        [-3, Buffer.from(jwk.y as string, 'base64url')],
+      ])
+    );
+  }
+  const idLength = Buffer.alloc(2);
+  idLength.writeUInt16BE(credential.id.length);
+  return Buffer.concat([header, Buffer.alloc(16), idLength, credential.id, coseKey]);
+}
+
+describe('base32', () => {
+  // RFC 4648 section 10 test vectors; secrets are encoded without padding
+  const vectors: Array<[string, string]> = [
+    ['', ''],
+    ['f', 'MY======'],
+    ['fo', 'MZXQ===='],
+    ['foo', 'MZXW6==='],
+    ['foob', 'MZXW6YQ='],
+    ['fooba', 'MZXW6YTB'],
+    ['foobar', 'MZXW6YTBOI======'],
+  ];
+
+  it('should match the RFC 4648 test vectors', () => {
+    for (const [data, encoded] of vectors) {
+      expect(base32Encode(Buffer.from(data))).toBe(encoded.replace(/=+$/, ''));
+      expect(base32Decode(encoded).toString()).toBe(data);
+      expect(base32Decode(encoded.toLowerCase()).toString()).toBe(data);
+    }
+  });
+
+  it('should reject characters outside the alphabet', () => {
+    expect(() => base32Decode('MZXW1')).toThrow('Invalid base32 character');
+  });
+});
+
+describe('TOTP', () => {
+  // RFC 6238 test secret
+  const secret = base32Encode(Buffer.from('12345678901234567890'));
+
+  it('should match the RFC 4226 HOTP test vectors', () => {
+    const codes = [
+      '755224',
+      '287082',
+      '359152',
+      '969429',
+      '338314',
+      '254676',
+      '287922',
+      '162583',
+      '399871',
+      '520489',
+    ];
+    codes.forEach((code, counter) => {
+      expect(generateTotp(secret, counter)).toBe(code);
+    });
+  });
+
+  it('should match the RFC 6238 SHA-1 test vectors', () => {
+    // The RFC lists 8-digit codes; 6-digit codes are their last six digits
+    const vectors: Array<[number, string]> = [
+      [59, '94287082'],
+      [1111111109, '07081804'],
+      [1111111111, '14050471'],
+      [1234567890, '89005924'],
+      [2000000000, '69279037'],
+      [20000000000, '65353130'],
+    ];
+    for (const [seconds, code] of vectors) {
+      expect(generateTotp(secret, totpCounter(seconds * 1000))).toBe(code.slice(-6));
+    }
+  });
+
+  it('should accept neighbouring steps but not reused ones', () => {
+    const time = 1111111109 * 1000;
+    const counter = totpCounter(time);
+    expect(verifyTotp(secret, generateTotp(secret, counter - 1), -1, time)).toBe(counter - 1);
+    expect(verifyTotp(secret, generateTotp(secret, counter), counter, time)).toBeNull();
+    expect(verifyTotp(secret, generateTotp(secret, counter + 2), -1, time)).toBeNull();
+    expect(verifyTotp(secret, 'abcdef', -1, time)).toBeNull();
+  });
+});
+
+describe('decodeCbor', () => {
+  const decode = (hex: string) => decodeCbor(Buffer.from(hex, 'hex'));
+
+  // RFC 8949 Appendix A examples
+  it('should decode integers', () => {
+    const vectors: Array<[string, number]> = [
+      ['00', 0],
+      ['01', 1],
+      ['0a', 10],
+      ['17', 23],
+      ['1818', 24],
+      ['1819', 25],
+      ['1864', 100],
+      ['1903e8', 1000],
+      ['1a000f4240', 1000000],
+      ['1b000000e8d4a51000', 1000000000000],
+      ['20', -1],
+      ['29', -10],
+      ['3863', -100],
+      ['3903e7', -1000],
+    ];
+    for (const [hex, value] of vectors) {
+      expect(decode(hex)).toBe(value);
+    }
+  });
+
+  it('should decode floats and simple values', () => {
+    const vectors: Array<[string, unknown]> = [
+      ['f90000', 0],
+      ['f93c00', 1],
+      ['f93e00', 1.5],
+      ['f97bff', 65504],
+      ['f90001', 5.960464477539063e-8],
+      ['f90400', 0.00006103515625],
+      ['f9c400', -4],
+      ['f97c00', Number.POSITIVE_INFINITY],
+      ['f9fc00', Number.NEGATIVE_INFINITY],
+      ['fa47c35000', 100000],
+      ['fa7f7fffff', 3.4028234663852886e38],
+      ['fb3ff199999999999a', 1.1],
+      ['fb7e37e43c8800759c', 1e300],
+      ['f4', false],
+      ['f5', true],
+      ['f6', null],
+      ['f7', undefined],
+    ];
+    for (const [hex, value] of vectors) {
+      expect(decode(hex)).toBe(value);
+    }
+    expect(Object.is(decode('f98000'), -0)).toBe(true);
+    expect(Number.isNaN(decode('f97e00'))).toBe(true);
+  });
+
+  it('should decode strings, arrays, maps and tags', () => {
+    expect(decode('40')).toEqual(Buffer.alloc(0));
+    expect(decode('4401020304')).toEqual(Buffer.from([1, 2, 3, 4]));
+    expect(decode('60')).toBe('');
+    expect(decode('6161')).toBe('a');
+    expect(decode('6449455446')).toBe('IETF');
+    expect(decode('62225c')).toBe('"\\');
+    expect(decode('62c3bc')).toBe('\u00fc');
+    expect(decode('63e6b0b4')).toBe('\u6c34');
+    expect(decode('80')).toEqual([]);
+    expect(decode('83010203')).toEqual([1, 2, 3]);
+    expect(decode('8301820203820405')).toEqual([1, [2, 3], [4, 5]]);
+    expect(decode('a0')).toEqual(new Map());
+    expect(decode('a201020304')).toEqual(
+      new Map([
+        [1, 2],
+        [3, 4],
+      ])
+    );
+    expect(decode('a26161016162820203')).toEqual(
+      new Map<string, unknown>([
+        ['a', 1],
+        ['b', [2, 3]],
+      ])
+    );
+    expect(decode('c074323031332d30332d32315432303a30343a30305a')).toBe('2013-03-21T20:04:00Z');
+    expect(decode('d74401020304')).toEqual(Buffer.from([1, 2, 3, 4]));
+  });
+
+  it('should reject indefinite lengths and truncated data', () => {
+    expect(() => decode('5f42010243030405ff')).toThrow(WebAuthnError);
+    expect(() => decode('9fff')).toThrow(WebAuthnError);
+    expect(() => decode('1a000f42')).toThrow('Truncated CBOR data');
+    expect(() => decode('6449455')).toThrow(WebAuthnError);
+  });
+});
+
+describe('WebAuthn with the RFC 8152 example key', () => {
+  // COSE_Key "meriadoc.brandybuck@buckland.example" from RFC 8152 Appendix C.7
+  const x = '65eda5a12577c2bae829437fe338701a10aaa375e1bb5b5de108de439c08551d';
+  const y = '1e52ed75701163f7f9e40ddf9f341b3dc9ba860af7e0ca7ca7e9eecd0084d19c';
+  const d = 'aff907c99f9ad3aae6c4cdf21122bce2bd68b5283e6907154ad911840fa208cf';
+  // The same key as the CBOR map {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+  const coseKey = Buffer.from(`a5010203262001215820${x}225820${y}`, 'hex');
+  const expected = {
+    challenge: Buffer.from('challenge'),
+    origin: ORIGIN,
+    rpId: 'vt.example.com',
+  };
+  const clientDataJSON = (type: string) =>
+    clientData(type, expected.challenge.toString('base64url'));
+
+  it('should decode the COSE key', () => {
+    expect(decodeCbor(coseKey)).toEqual(
+      new Map<number, unknown>([
+        [1, 2],
+        [3, -7],
+        [-1, 1],
+        [-2, Buffer.from(x, 'hex')],
+        [-3, Buffer.from(y, 'hex')],
+      ])
+    );
+  });
+
+  it('should register the key and verify assertions signed with it', () => {
+    const id = Buffer.from('meriadoc');
+    const attestationObject = cbor(
+      new Map<string, unknown>([
+        ['fmt', 'none'],
+        ['attStmt', new Map()],
+        ['authData', authenticatorData(0, { id, coseKey })],
+      ])
+    );
+    const credential = verifyRegistration(
+      {
+        id: id.toString('base64url'),
+        response: {
+          clientDataJSON: clientDataJSON('webauthn.create'),
+          attestationObject: attestationObject.toString('base64url'),
+        },
+      },
+      expected
+    );
+    expect(credential).toMatchObject({ id: id.toString('base64url'), algorithm: -7 });
+
+    const privateKey = createPrivateKey({
+      key: {
+        kty: 'EC',
+        crv: 'P-256',
+        x: Buffer.from(x, 'hex').toString('base64url'),
+        y: Buffer.from(y, 'hex').toString('base64url'),
+        d: Buffer.from(d, 'hex').toString('base64url'),
+      },
+      format: 'jwk',
+    });
+    const authData = authenticatorData(7);
+    const getClientData = clientDataJSON('webauthn.get');
+    const signed = Buffer.concat([
+      authData,
+      createHash('sha256').update(Buffer.from(getClientData, 'base64url')).digest(),
+    ]);
+    const assertion = (signature: Buffer) => ({
+      id: credential.id,
+      response: {
+        clientDataJSON: getClientData,
+        authenticatorData: authData.toString('base64url'),
+        signature: signature.toString('base64url'),
+      },
+    });
+
+    const signature = sign('sha256', signed, privateKey);
+    expect(verifyAssertion(assertion(signature), credential, expected)).toBe(7);
+    const otherKey = generateKeyPairSync('ec', { namedCurve: 'P-256' }).privateKey;
+    expect(() =>
+      verifyAssertion(assertion(sign('sha256', signed, otherKey)), credential, expected)
+    ).toThrow('Invalid assertion signature');
+  });
+});
+
+describe('SecondFactorService', () => {
+  let testDir: string;
+  let filePath: string;
+  let service: SecondFactorService;
+
+  beforeEach(() => {
+    testDir = fs.mkdtempSync(path.join(os.tmpdir(), 'second-factor-test-'));
+    filePath = path.join(testDir, 'second-factor.json');
+    service = new SecondFactorService(filePath);
+  });
+
+  afterEach(() => {
+    service.destroy();
+    fs.rmSync(testDir, { recursive: true, force: true });
+  });
+
+  it('should require a confirmed authenticator app at login', () => {
+    const { secret } = service.beginTotpEnrollment('alice');
+    expect(service.isEnrolled('alice')).toBe(false);
+
+    // The confirmation code cannot be used again for the login
+    const code = generateTotp(secret);
+    service.confirmTotpEnrollment('alice', code);
+    expect(service.isEnrolled('alice')).toBe(true);
+
+    const login = service.startLogin('alice', 'password');
+    expect(login.methods).toEqual(['totp']);
+    expect(() => service.verifyTotpLogin(login.pendingToken, code)).toThrow(SecondFactorError);
+    expect(
+      service.verifyTotpLogin(login.pendingToken, generateTotp(secret, totpCounter() + 1))
+    ).toEqual({ userId: 'alice', authMethod: 'password' });
+
+    // Enrolled factors survive a restart
+    const reloaded = new SecondFactorService(filePath);
+    expect(reloaded.isEnrolled('alice')).toBe(true);
+    reloaded.destroy();
+  });
+
+  it('should drop pending logins after too many wrong codes', () => {
+    const { secret } = service.beginTotpEnrollment('alice');
+    service.confirmTotpEnrollment('alice', generateTotp(secret));
+    const { pendingToken } = service.startLogin('alice', 'password');
+
+    for (let attempt = 0; attempt < 5; attempt++) {
+      expect(() => service.verifyTotpLogin(pendingToken, '000000')).toThrow();
+    }
+    expect(() =>
+      service.verifyTotpLogin(pendingToken, generateTotp(secret, totpCounter() + 1))
+    ).toThrow('Login expired');
+  });
+
+  it('should register security keys and verify assertions', () => {
+    const { privateKey, publicKey } = generateKeyPairSync('ec', { namedCurve: 'P-256' });
+    const credentialId = Buffer.from('credential-1');
+
+    const creation = service.webAuthnRegistrationOptions('alice', ORIGIN);
+    expect(creation.rp.id).toBe('vt.example.com');
+    const attestationObject = cbor(
+      new Map<string, unknown>([
+        ['fmt', 'none'],
+        ['attStmt', new Map()],
+        ['authData', authenticatorData(0, { id: credentialId, key: publicKey })],
+      ])
+    );
+    const registered = service.finishWebAuthnRegistration(
+      'alice',
+      {
+        id: credentialId.toString('base64url'),
+        response: {
+          clientDataJSON: clientData('webauthn.create', creation.challenge),
+          attestationObject: attestationObject.toString('base64url'),
+        },
+      },
+      'YubiKey'
+    );
+    expect(registered.name).toBe('YubiKey');
+
+    const assert = (signCount: number) => {
+      const { pendingToken } = service.startLogin('alice', 'ssh-key');
+      const request = service.webAuthnLoginOptions(pendingToken, ORIGIN);
+      const authData = authenticatorData(signCount);
+      const clientDataJSON = clientData('webauthn.get', request.challenge);
+      const signed = Buffer.concat([
+        authData,
+        createHash('sha256').update(Buffer.from(clientDataJSON, 'base64url')).digest(),
+      ]);
+      return service.verifyWebAuthnLogin(pendingToken, {
+        id: registered.id,
+        response: {
+          clientDataJSON,
+          authenticatorData: authData.toString('base64url'),
+          signature: sign('sha256', signed, privateKey).toString('base64url'),
+        },
+      });
+    };
+
+    expect(assert(1)).toEqual({ userId: 'alice', authMethod: 'ssh-key' });
+    // A counter that does not increase suggests a cloned key
+    expect(() => assert(1)).toThrow('Signature counter did not increase');
+  });
+
+  it('should only offer keys registered for the requesting host', () => {
+    service.beginTotpEnrollment('alice');
+    const { pendingToken } = service.startLogin('alice', 'password');
+    expect(() => service.webAuthnLoginOptions(pendingToken, 'https://other.example.com')).toThrow(
+      'No security key is registered for other.example.com'
+    );
+  });
+});
+
+describe('second factor login routes', () => {
+  let testDir: string;
+  let service: SecondFactorService;
+  let server: Server;
+  let baseUrl: string;
+
+  const post = (route: string, body: unknown) =>
+    fetch(`${baseUrl}/auth/second-factor/${route}`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    });
+
+  beforeEach(async () => {
+    testDir = fs.mkdtempSync(path.join(os.tmpdir(), 'second-factor-test-'));
+    service = new SecondFactorService(path.join(testDir, 'second-factor.json'));
+    const authService = {
+      completeSecondFactor: (userId: string) => ({ success: true, userId, token: 'token' }),
+    } as unknown as AuthService;
+    const app = express();
+    app.use(express.json());
+    app.use('/api/auth', createAuthRoutes({ authService, secondFactor: service }));
+    server = app.listen(0);
+    await new Promise((resolve) => server.once('listening', resolve));
+    baseUrl = `http://localhost:${(server.address() as AddressInfo).port}/api`;
+  });
+
+  afterEach(() => {
+    server.close();
+    service.destroy();
+    fs.rmSync(testDir, { recursive: true, force: true });
+  });
+
+  it('should reject codes that are not strings', async () => {
+    const { secret } = service.beginTotpEnrollment('alice');
+    service.confirmTotpEnrollment('alice', generateTotp(secret));
+    const { pendingToken } = service.startLogin('alice', 'password');
+
+    for (const code of [123456, ['123456'], null]) {
+      const response = await post('totp', { pendingToken, code });
+      expect(response.status).toBe(400);
+      expect((await response.json()).code).toBe('INVALID_REQUEST');
+    }
+
+    const code = generateTotp(secret, totpCounter() + 1);
+    const response = await post('totp', { pendingToken, code });
+    expect(response.status).toBe(200);
+    expect(await response.json()).toEqual({
+      success: true,
+      token: 'token',
+      userId: 'alice',
+      authMethod: 'password',
+    });
+  });
+});
@@ -0,0 +1,110 @@
+import * as crypto from 'crypto';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { SelfUpdater, UpdateError } from '../../server/services/self-updater';
+
+const NEW_BUILD = 'new build';
+const sha256 = (data: string) => crypto.createHash('sha256').update(data).digest('hex');
+const request = {
+  url: 'https://example.com/vibetunnel',
+  sha256: sha256(NEW_BUILD),
+  restart: false,
+};
+
+describe('SelfUpdater', () => {
+  let tempDir: string;
+  let executablePath: string;
+  let updatesDir: string;
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'self-updater-'));
+    executablePath = path.join(tempDir, 'vibetunnel');
+    updatesDir = path.join(tempDir, 'control', 'updates');
+    fs.writeFileSync(executablePath, 'old build', { mode: 0o755 });
+    process.env.VIBETUNNEL_SEA = '1';
+  });
+
+  afterEach(() => {
+    delete process.env.VIBETUNNEL_SEA;
+    vi.restoreAllMocks();
+    fs.rmSync(tempDir, { recursive: true, force: true });
+  });
+
+  const createUpdater = (maxUpdateBytes?: number) =>
+    new SelfUpdater(path.join(tempDir, 'control'), executablePath, maxUpdateBytes);
+
+  const serveBuild = (data = NEW_BUILD) =>
+    vi.spyOn(globalThis, 'fetch').mockImplementation(async () => new Response(data));
+
+  it('should install a verified build and keep the previous one', async () => {
+    serveBuild();
+    const updater = createUpdater();
+
+    const status = await updater.update({ ...request, sha256: request.sha256.toUpperCase() });
+
+    expect(status).toMatchObject({ state: 'installed', sha256: sha256(NEW_BUILD) });
+    expect(fs.readFileSync(executablePath, 'utf8')).toBe(NEW_BUILD);
+    expect(fs.statSync(executablePath).mode & 0o777).toBe(0o755);
+    expect(fs.readFileSync(`${executablePath}.previous`, 'utf8')).toBe('old build');
+    expect(fs.readdirSync(updatesDir)).toEqual([]);
+  });
+
+  it('should discard downloads with a checksum mismatch', async () => {
+    serveBuild();
+    const updater = createUpdater();
+
+    await expect(updater.update({ ...request, sha256: sha256('x') })).rejects.toMatchObject({
+      status: 422,
+    });
+
+    expect(fs.readFileSync(executablePath, 'utf8')).toBe('old build');
+    expect(fs.existsSync(`${executablePath}.previous`)).toBe(false);
+    expect(fs.readdirSync(updatesDir)).toEqual([]);
+    expect(updater.getStatus()).toMatchObject({
+      state: 'failed',
+      error: expect.stringMatching(/^Checksum mismatch/),
+    });
+  });
+
+  it('should abort downloads over the size limit', async () => {
+    serveBuild();
+    const updater = createUpdater(4);
+
+    await expect(updater.update(request)).rejects.toMatchObject({ status: 413 });
+
+    expect(fs.readFileSync(executablePath, 'utf8')).toBe('old build');
+    expect(fs.readdirSync(updatesDir)).toEqual([]);
+  });
+
+  it('should refuse a second update while one is downloading', async () => {
+    let respond: (response: Response) => void = () => {};
+    vi.spyOn(globalThis, 'fetch').mockImplementation(
+      () => new Promise<Response>((resolve) => (respond = resolve))
+    );
+    const updater = createUpdater();
+
+    const first = updater.update(request);
+    await expect(updater.update(request)).rejects.toThrow(UpdateError);
+    await expect(updater.update(request)).rejects.toMatchObject({ status: 409 });
+
+    respond(new Response(NEW_BUILD));
+    await expect(first).resolves.toMatchObject({ state: 'installed' });
+  });
+
+  it('should roll back when the restart fails', async () => {
+    serveBuild();
+    const updater = createUpdater();
+    updater.setRestartHandler(async () => {
+      throw new Error('handoff failed');
+    });
+
+    await updater.update({ ...request, restart: true });
+    await vi.waitFor(() => expect(updater.getStatus().state).toBe('failed'));
+
+    expect(updater.getStatus().error).toBe('handoff failed');
+    expect(fs.readFileSync(executablePath, 'utf8')).toBe('old build');
+    expect(fs.existsSync(`${executablePath}.previous`)).toBe(false);
+  });
+});
@@ -0,0 +1,133 @@
+import express from 'express';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import type { AuthenticatedRequest } from '../../server/middleware/auth';
+import type { PtyManager } from '../../server/pty/index';
+import { createSessionRoutes } from '../../server/routes/sessions';
+import type { ActivityMonitor } from '../../server/services/activity-monitor';
+import type { InputSequencer } from '../../server/services/input-sequencer';
+import type { SizeNegotiator } from '../../server/services/size-negotiator';
+import type { StreamWatcher } from '../../server/services/stream-watcher';
+import type { TerminalManager } from '../../server/services/terminal-manager';
+import type { Session } from '../../shared/types';
+
+const SESSIONS = [
+  { id: 'alice-session', createdBy: 'alice', status: 'running' },
+  { id: 'bob-session', createdBy: 'bob', status: 'running' },
+] as Session[];
+
+describe('session access in local user mode', () => {
+  let server: Server;
+  let baseUrl: string;
+  const cleanupExitedSessions = vi.fn(() => []);
+
+  // The caller is picked with a header: a user name, or "operator" for a local bypass
+  const request = (user: string, method: string, route: string, body?: unknown) =>
+    fetch(`${baseUrl}/${route}`, {
+      method,
+      headers: { 'Content-Type': 'application/json', 'X-Test-User': user },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+
+  async function start(localUsers: boolean) {
+    const app = express();
+    app.use(express.json());
+    app.use((req: AuthenticatedRequest, _res, next) => {
+      const user = req.headers['x-test-user'];
+      if (user === 'operator') {
+        req.authMethod = 'local-bypass';
+      } else {
+        req.authMethod = 'password';
+        req.userId = user as string;
+      }
+      next();
+    });
+    app.use(
+      '/api',
+      createSessionRoutes({
+        ptyManager: {
+          getSession: (sessionId: string) =>
+            SESSIONS.find((session) => session.id === sessionId) ?? null,
+          listSessions: () => SESSIONS,
+          cleanupExitedSessions,
+        } as unknown as PtyManager,
+        terminalManager: {} as TerminalManager,
+        streamWatcher: {} as StreamWatcher,
+        remoteRegistry: null,
+        isHQMode: false,
+        activityMonitor: {} as ActivityMonitor,
+        inputSequencer: {} as InputSequencer,
+        sizeNegotiator: {} as SizeNegotiator,
+        adminUsers: ['root'],
+        localUsers,
+      } as unknown as Parameters<typeof createSessionRoutes>[0])
+    );
+    server = app.listen(0);
+    await new Promise((resolve) => server.once('listening', resolve));
+    baseUrl = `http://localhost:${(server.address() as AddressInfo).port}/api`;
+  }
+
+  beforeEach(() => {
+    cleanupExitedSessions.mockClear();
+  });
+
+  afterEach(() => {
+    server.close();
+  });
+
+  it("should deny other users' sessions", async () => {
+    await start(true);
+
+    const denied = [
+      ['GET', 'sessions/alice-session'],
+      ['POST', 'sessions/alice-session/input', { text: 'id\n' }],
+      ['POST', 'sessions/alice-session/resize', { cols: 80, rows: 24 }],
+      ['GET', 'sessions/alice-session/stream'],
+      ['DELETE', 'sessions/alice-session'],
+    ] as const;
+    for (const [method, route, body] of denied) {
+      const response = await request('bob', method, route, body);
+      expect(response.status).toBe(403);
+      expect((await response.json()).code).toBe('FORBIDDEN');
+    }
+
+    const compare = await request('bob', 'GET', 'sessions/compare?left=alice-session&right=x');
+    expect(compare.status).toBe(403);
+  });
+
+  it('should let owners, operators and admins use a session', async () => {
+    await start(true);
+
+    for (const user of ['alice', 'operator', 'root']) {
+      const response = await request(user, 'GET', 'sessions/alice-session');
+      expect(response.status).toBe(200);
+      expect((await response.json()).id).toBe('alice-session');
+    }
+  });
+
+  it('should list only the own sessions of users', async () => {
+    await start(true);
+
+    const ids = async (user: string) =>
+      ((await (await request(user, 'GET', 'sessions')).json()) as Session[]).map(({ id }) => id);
+    expect(await ids('bob')).toEqual(['bob-session']);
+    expect(await ids('root')).toEqual(['alice-session', 'bob-session']);
+  });
+
+  it('should leave cleaning up exited sessions to admins', async () => {
+    await start(true);
+
+    expect((await request('bob', 'POST', 'cleanup-exited')).status).toBe(403);
+    expect(cleanupExitedSessions).not.toHaveBeenCalled();
+    expect((await request('root', 'POST', 'cleanup-exited')).status).toBe(200);
+    expect(cleanupExitedSessions).toHaveBeenCalledTimes(1);
+  });
+
+  it('should not restrict sessions without local user mode', async () => {
+    await start(false);
+
+    expect((await request('bob', 'GET', 'sessions/alice-session')).status).toBe(200);
+    expect(await (await request('bob', 'GET', 'sessions')).json()).toHaveLength(2);
+  });
+});
@@ -0,0 +1,76 @@
+import { describe, expect, it } from 'vitest';
+import {
+  applyAgentFields,
+  matchesAgentFilter,
+  parseAgentFields,
+} from '../../server/pty/session-agent';
+import type { SessionInfo } from '../../shared/types';
+
+function sessionInfo(fields: Partial<SessionInfo> = {}): SessionInfo {
+  return {
+    id: 's1',
+    command: ['claude'],
+    name: 'claude',
+    workingDir: '/tmp',
+    status: 'running',
+    startedAt: '2025-01-01T00:00:00.000Z',
+    ...fields,
+  } as SessionInfo;
+}
+
+describe('session agent metadata', () => {
+  it('should parse and normalize agent fields', () => {
+    expect(
+      parseAgentFields({
+        cmd: 'agent',
+        agentType: ' Claude ',
+        taskDescription: 'Fix the\n  flaky test',
+        tokensUsed: 1200,
+      })
+    ).toEqual({ agentType: 'claude', taskDescription: 'Fix the flaky test', tokensUsed: 1200 });
+    expect(parseAgentFields({ agentType: null, tokensUsed: null })).toEqual({
+      agentType: null,
+      tokensUsed: null,
+    });
+    expect(parseAgentFields({ taskDescription: 'x'.repeat(600) }).taskDescription).toHaveLength(
+      500
+    );
+  });
+
+  it('should reject invalid agent fields', () => {
+    expect(() => parseAgentFields({ agentType: 'my agent' })).toThrow('agentType');
+    expect(() => parseAgentFields({ agentType: 42 })).toThrow('agentType');
+    expect(() => parseAgentFields({ taskDescription: ['task'] })).toThrow('taskDescription');
+    expect(() => parseAgentFields({ tokensUsed: -1 })).toThrow('tokensUsed');
+    expect(() => parseAgentFields({ tokensUsed: 1.5 })).toThrow('tokensUsed');
+  });
+
+  it('should apply, keep and clear fields', () => {
+    const info = sessionInfo();
+    expect(applyAgentFields(info, { agentType: 'gemini', tokensUsed: 10 })).toBe(true);
+    expect(info).toMatchObject({ agentType: 'gemini', tokensUsed: 10 });
+    expect(info.agentUpdatedAt).toBeDefined();
+
+    expect(applyAgentFields(info, { tokensUsed: 10 })).toBe(false);
+    expect(applyAgentFields(info, { tokensUsed: 25 })).toBe(true);
+    expect(info).toMatchObject({ agentType: 'gemini', tokensUsed: 25 });
+
+    expect(applyAgentFields(info, { agentType: null, taskDescription: null })).toBe(true);
+    expect(info.agentType).toBeUndefined();
+    expect(applyAgentFields(info, { taskDescription: null })).toBe(false);
+  });
+
+  it('should filter sessions by agent', () => {
+    const shell = sessionInfo();
+    const claude = sessionInfo({ agentType: 'claude' });
+    const gemini = sessionInfo({ agentType: 'gemini' });
+
+    const filtered = (filter: Record<string, string>) =>
+      [shell, claude, gemini].filter((session) => matchesAgentFilter(session, filter));
+
+    expect(filtered({})).toEqual([shell, claude, gemini]);
+    expect(filtered({ agent: 'true' })).toEqual([claude, gemini]);
+    expect(filtered({ agent: 'false' })).toEqual([shell]);
+    expect(filtered({ agentType: 'Gemini, codex' })).toEqual([gemini]);
+  });
+});
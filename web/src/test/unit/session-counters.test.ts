@@ -0,0 +1,46 @@
+import { describe, expect, it } from 'vitest';
+import { SessionCounters } from '../../server/pty/session-counters';
+
+describe('SessionCounters', () => {
+  const start = 1_700_000_000_000;
+
+  it('should count bytes, lines and input', () => {
+    const counters = new SessionCounters();
+    counters.recordOutput('héllo\r\nworld\n', start);
+    counters.recordInput('ls\r', start + 500);
+    counters.recordInput('q', start + 600);
+
+    const stats = counters.getStats(42, start + 1000);
+    expect(stats.bytesOut).toBe(14);
+    expect(stats.linesOut).toBe(2);
+    expect(stats.bytesIn).toBe(4);
+    expect(stats.inputEvents).toBe(2);
+    expect(stats.lastOutputAt).toBe(new Date(start).toISOString());
+    expect(stats.lastInputAt).toBe(new Date(start + 600).toISOString());
+    expect(stats.recordingBytes).toBe(42);
+  });
+
+  it('should average the output rate over the last full seconds', () => {
+    const counters = new SessionCounters();
+    for (let i = 0; i < 10; i++) {
+      counters.recordOutput(`${'x'.repeat(99)}\n`, start + i * 1000);
+    }
+    // Output in the current second is not part of the rate yet
+    counters.recordOutput('x'.repeat(5000), start + 10_000);
+
+    const stats = counters.getStats(0, start + 10_500);
+    expect(stats.outputRate).toEqual({ bytesPerSecond: 100, linesPerSecond: 1 });
+    expect(stats.outputHistory).toHaveLength(60);
+    expect(stats.outputHistory.slice(-2)).toEqual([100, 5000]);
+  });
+
+  it('should forget output older than the history', () => {
+    const counters = new SessionCounters();
+    counters.recordOutput('old\n', start);
+
+    const stats = counters.getStats(0, start + 61_000);
+    expect(stats.outputHistory.every((bytes) => bytes === 0)).toBe(true);
+    expect(stats.outputRate.bytesPerSecond).toBe(0);
+    expect(stats.bytesOut).toBe(4);
+  });
+});
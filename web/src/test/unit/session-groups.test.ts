@@ -0,0 +1,225 @@
+import express from 'express';
+import * as fs from 'fs';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { PtyError, type PtyManager, SessionLimitError } from '../../server/pty/index';
+import { createGroupRoutes } from '../../server/routes/groups';
+import type { InputLockManager } from '../../server/services/input-lock';
+import { SessionGroupStore } from '../../server/services/session-groups';
+
+describe('SessionGroupStore', () => {
+  let controlDir: string;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'session-groups-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should find groups by session and persist them', () => {
+    const store = new SessionGroupStore(controlDir);
+    const group = store.create('dev', ['a', 'b']);
+
+    expect(store.get(group.id)).toEqual(group);
+    expect(store.findBySessionId('b')?.id).toBe(group.id);
+    expect(store.findBySessionId('c')).toBeUndefined();
+    expect(new SessionGroupStore(controlDir).list()).toEqual([group]);
+
+    expect(store.delete(group.id)).toBe(true);
+    expect(store.delete(group.id)).toBe(false);
+    expect(new SessionGroupStore(controlDir).list()).toEqual([]);
+  });
+
+  it('should start empty when groups.json is corrupt', () => {
+    fs.writeFileSync(path.join(controlDir, 'groups.json'), '{not json');
+    expect(new SessionGroupStore(controlDir).list()).toEqual([]);
+  });
+});
+
+describe('group routes', () => {
+  let controlDir: string;
+  let groupStore: SessionGroupStore;
+  let server: Server;
+  let baseUrl: string;
+  const sessions = new Map<string, { id: string; status: string }>();
+
+  // Stub manager over the sessions map; session ids count up from s1
+  function createPtyManager() {
+    let nextId = 0;
+    return {
+      checkSessionLimits: vi.fn(),
+      createSession: vi.fn(async () => {
+        const sessionId = `s${++nextId}`;
+        sessions.set(sessionId, { id: sessionId, status: 'running' });
+        return { sessionId };
+      }),
+      getSession: (sessionId: string) => sessions.get(sessionId) ?? null,
+      sendInput: vi.fn(),
+      killSession: vi.fn(async (sessionId: string) => {
+        const session = sessions.get(sessionId);
+        if (session) session.status = 'exited';
+      }),
+      cleanupSession: vi.fn((sessionId: string) => {
+        sessions.delete(sessionId);
+      }),
+    };
+  }
+  let ptyManager: ReturnType<typeof createPtyManager>;
+  let inputLocks: { checkInput: ReturnType<typeof vi.fn> };
+
+  beforeEach(async () => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'group-routes-'));
+    groupStore = new SessionGroupStore(controlDir);
+    sessions.clear();
+    ptyManager = createPtyManager();
+    inputLocks = { checkInput: vi.fn(() => true) };
+
+    const app = express();
+    app.use(express.json());
+    app.use(
+      '/api',
+      createGroupRoutes({
+        ptyManager: ptyManager as unknown as PtyManager,
+        groupStore,
+        inputLocks: inputLocks as unknown as InputLockManager,
+      })
+    );
+    server = app.listen(0);
+    await new Promise((resolve) => server.once('listening', resolve));
+    baseUrl = `http://localhost:${(server.address() as AddressInfo).port}/api`;
+  });
+
+  afterEach(() => {
+    server.close();
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  async function request(method: string, url: string, body?: unknown) {
+    const response = await fetch(`${baseUrl}${url}`, {
+      method,
+      headers: { 'Content-Type': 'application/json' },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    return { status: response.status, body: await response.json() };
+  }
+
+  const specs = [
+    { command: ['vim'], name: 'editor' },
+    { command: ['npm', 'run', 'dev'], name: 'server' },
+  ];
+
+  it('should create all sessions of a group', async () => {
+    const { status, body } = await request('POST', '/groups', { name: ' dev ', sessions: specs });
+
+    expect(status).toBe(200);
+    expect(body.sessionIds).toEqual(['s1', 's2']);
+    expect(ptyManager.checkSessionLimits).toHaveBeenCalledWith(undefined, 2);
+    expect(ptyManager.createSession).toHaveBeenCalledWith(
+      ['vim'],
+      expect.objectContaining({ name: 'editor' })
+    );
+    expect(groupStore.get(body.groupId)).toMatchObject({ name: 'dev', sessionIds: ['s1', 's2'] });
+  });
+
+  it('should reject invalid groups', async () => {
+    expect((await request('POST', '/groups', { sessions: specs })).status).toBe(400);
+    expect((await request('POST', '/groups', { name: 'dev', sessions: [] })).status).toBe(400);
+    expect(
+      (await request('POST', '/groups', { name: 'dev', sessions: [{ command: 'vim' }] })).status
+    ).toBe(400);
+    expect(ptyManager.createSession).not.toHaveBeenCalled();
+  });
+
+  it('should remove created sessions when a member fails to spawn', async () => {
+    ptyManager.createSession.mockImplementationOnce(async () => {
+      sessions.set('s1', { id: 's1', status: 'running' });
+      return { sessionId: 's1' };
+    });
+    ptyManager.createSession.mockRejectedValueOnce(new PtyError('spawn failed'));
+
+    const { status, body } = await request('POST', '/groups', { name: 'dev', sessions: specs });
+
+    expect(status).toBe(500);
+    expect(body).toEqual({ error: 'Failed to create group', details: 'spawn failed' });
+    expect(ptyManager.killSession).toHaveBeenCalledWith('s1');
+    expect(ptyManager.cleanupSession).toHaveBeenCalledWith('s1');
+    expect(sessions.size).toBe(0);
+    expect(groupStore.list()).toEqual([]);
+  });
+
+  it('should not create any session when the group exceeds the limits', async () => {
+    ptyManager.checkSessionLimits.mockImplementationOnce(() => {
+      throw new SessionLimitError('server', 2, 1);
+    });
+
+    const { status, body } = await request('POST', '/groups', { name: 'dev', sessions: specs });
+
+    expect(status).toBe(429);
+    expect(body.code).toBe('SESSION_LIMIT_REACHED');
+    expect(ptyManager.createSession).not.toHaveBeenCalled();
+    expect(groupStore.list()).toEqual([]);
+  });
+
+  it('should broadcast input to running, unlocked sessions', async () => {
+    sessions.set('a', { id: 'a', status: 'running' });
+    sessions.set('b', { id: 'b', status: 'exited' });
+    sessions.set('c', { id: 'c', status: 'running' });
+    sessions.set('d', { id: 'd', status: 'running' });
+    const group = groupStore.create('dev', ['a', 'b', 'c', 'd']);
+    inputLocks.checkInput.mockImplementation((sessionId: string) => sessionId !== 'c');
+    ptyManager.sendInput.mockImplementation((sessionId: string) => {
+      if (sessionId === 'd') throw new Error('pipe closed');
+    });
+
+    const { status, body } = await request('POST', `/groups/${group.id}/input`, { key: 'enter' });
+
+    expect(status).toBe(200);
+    expect(body).toEqual({ success: false, sent: ['a'], failed: ['d'], locked: ['c'] });
+    expect(ptyManager.sendInput).toHaveBeenCalledWith('a', { key: 'enter' }, expect.anything());
+    expect(ptyManager.sendInput).toHaveBeenCalledTimes(2);
+  });
+
+  it('should require exactly one of text or key for group input', async () => {
+    const group = groupStore.create('dev', []);
+
+    expect((await request('POST', `/groups/${group.id}/input`, {})).status).toBe(400);
+    expect(
+      (await request('POST', `/groups/${group.id}/input`, { text: 'ls', key: 'enter' })).status
+    ).toBe(400);
+    expect((await request('POST', `/groups/${group.id}/input`, { key: 'nope' })).status).toBe(400);
+    expect((await request('POST', '/groups/missing/input', { text: 'ls' })).status).toBe(404);
+  });
+
+  it('should kill every session before removing the group', async () => {
+    sessions.set('a', { id: 'a', status: 'running' });
+    sessions.set('b', { id: 'b', status: 'running' });
+    const group = groupStore.create('dev', ['a', 'b']);
+
+    const { status } = await request('DELETE', `/groups/${group.id}`);
+
+    expect(status).toBe(200);
+    expect(ptyManager.killSession).toHaveBeenCalledWith('a', 'SIGTERM');
+    expect(ptyManager.killSession).toHaveBeenCalledWith('b', 'SIGTERM');
+    expect(groupStore.get(group.id)).toBeUndefined();
+  });
+
+  it('should keep the group when a session cannot be killed', async () => {
+    sessions.set('a', { id: 'a', status: 'running' });
+    sessions.set('b', { id: 'b', status: 'running' });
+    const group = groupStore.create('dev', ['a', 'b']);
+    ptyManager.killSession.mockImplementationOnce(async () => {
+      throw new Error('EPERM');
+    });
+
+    const { status, body } = await request('DELETE', `/groups/${group.id}`);
+
+    expect(status).toBe(500);
+    expect(body.failed).toEqual(['a']);
+    expect(groupStore.get(group.id)).toBeDefined();
+  });
+});
@@ -0,0 +1,68 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { ControlRoots } from '../../server/pty/control-roots';
+import { isValidSessionId, sessionPath } from '../../server/pty/session-id';
+import { SessionManager } from '../../server/pty/session-manager';
+import { isValidNamespacedSessionId } from '../../server/utils/session-namespace';
+
+const TRAVERSALS = ['..', '.', '../etc', '../../etc/passwd', 'a/b', 'a\\b', '.hidden', '', 'a\0b'];
+
+describe('session IDs', () => {
+  let dir: string;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'session-id-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should accept UUIDs and fwd IDs only', () => {
+    expect(isValidSessionId('3f2b8c1e-9d4a-4c6b-8e2f-1a2b3c4d5e6f')).toBe(true);
+    expect(isValidSessionId('fwd_1718000000000')).toBe(true);
+    expect(isValidSessionId('abc123')).toBe(true);
+    for (const id of TRAVERSALS) {
+      expect(isValidSessionId(id)).toBe(false);
+    }
+    expect(isValidSessionId('a'.repeat(129))).toBe(false);
+    expect(isValidSessionId(42)).toBe(false);
+  });
+
+  it('should keep session paths inside the root', () => {
+    expect(sessionPath(dir, 'abc', 'stdout')).toBe(path.join(dir, 'abc', 'stdout'));
+    expect(() => sessionPath(dir, 'abc', '..', 'other')).toThrow();
+    for (const id of TRAVERSALS) {
+      expect(() => sessionPath(dir, id)).toThrow(/Invalid session ID/);
+    }
+  });
+
+  it('should not resolve traversal attempts to directories', () => {
+    const roots = ControlRoots.from(dir);
+    const manager = new SessionManager(roots);
+    fs.mkdirSync(path.join(dir, '..', 'outside-session'), { recursive: true });
+    try {
+      for (const id of ['../outside-session', ...TRAVERSALS]) {
+        expect(roots.findRoot(id)).toBeUndefined();
+        expect(() => roots.sessionDir(id)).toThrow();
+        expect(manager.getSessionPaths(id)).toBeNull();
+        expect(manager.loadSessionInfo(id)).toBeNull();
+        expect(manager.sessionExists(id)).toBe(false);
+        expect(() => manager.createSessionDirectory(id)).toThrow();
+      }
+    } finally {
+      fs.rmSync(path.join(dir, '..', 'outside-session'), { recursive: true, force: true });
+    }
+  });
+
+  it('should accept namespaced IDs of remote sessions', () => {
+    expect(isValidNamespacedSessionId('remote/abc')).toBe(true);
+    expect(isValidNamespacedSessionId('team hq/remote/abc')).toBe(true);
+    expect(isValidNamespacedSessionId('abc')).toBe(false);
+    expect(isValidNamespacedSessionId('remote/..')).toBe(false);
+    expect(isValidNamespacedSessionId('../abc')).toBe(false);
+    expect(isValidNamespacedSessionId('/abc')).toBe(false);
+  });
+});
@@ -0,0 +1,81 @@
+import { spawnSync } from 'child_process';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import {
+  ENV_HOOK_SCRIPT,
+  envFileLines,
+  initScriptInput,
+  prepareRcInit,
+  supportsRcInit,
+} from '../../server/pty/session-init';
+
+describe('session init', () => {
+  let dir: string;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'session-init-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should type each script line followed by Enter', () => {
+    expect(initScriptInput('cd /tmp\nexport A=1\n\n')).toBe('cd /tmp\rexport A=1\r');
+    expect(initScriptInput('source venv/bin/activate\r\n')).toBe('source venv/bin/activate\r');
+  });
+
+  it('should support rc init for interactive bash and zsh only', () => {
+    expect(supportsRcInit(['/bin/bash'])).toBe(true);
+    expect(supportsRcInit(['zsh', '-l'])).toBe(true);
+    // Login bash shells do not read the rc file
+    expect(supportsRcInit(['bash', '-l'])).toBe(false);
+    expect(supportsRcInit(['bash', '-c', 'ls'])).toBe(false);
+    expect(supportsRcInit(['fish'])).toBe(false);
+  });
+
+  it('should start bash with an rc file that loads ~/.bashrc first', () => {
+    const rcInit = prepareRcInit(['/bin/bash', '-i'], 'export MARK=1', dir);
+    const rcFile = path.join(dir, 'init.bashrc');
+    expect(rcInit).toEqual({ args: ['--rcfile', rcFile, '-i'], env: {} });
+    expect(fs.readFileSync(rcFile, 'utf8')).toBe(
+      '[ -f ~/.bashrc ] && . ~/.bashrc\nexport MARK=1\n'
+    );
+  });
+
+  it('should point zsh at startup files that run the script after .zshrc', () => {
+    const rcInit = prepareRcInit(['zsh'], 'export MARK=1', dir);
+    const zdotdir = path.join(dir, 'init-zsh');
+    expect(rcInit?.args).toEqual([]);
+    expect(rcInit?.env.ZDOTDIR).toBe(zdotdir);
+
+    const zshrc = fs.readFileSync(path.join(zdotdir, '.zshrc'), 'utf8');
+    expect(zshrc.indexOf('.zshrc"')).toBeLessThan(zshrc.indexOf('export MARK=1'));
+    expect(fs.existsSync(path.join(zdotdir, '.zshenv'))).toBe(true);
+  });
+
+  it('should not prepare rc init for other commands', () => {
+    expect(prepareRcInit(['python3'], 'print(1)', dir)).toBeNull();
+    expect(fs.readdirSync(dir)).toEqual([]);
+  });
+
+  it('should apply env changes once at the next bash prompt', () => {
+    const envFile = path.join(dir, 'env.sh');
+    fs.writeFileSync(envFile, envFileLines({ GREETING: "it's $HOME", OLD: null }));
+    const script = [
+      ENV_HOOK_SCRIPT,
+      'false',
+      'eval "$PROMPT_COMMAND"',
+      'echo "$?|$GREETING|${OLD-unset}"',
+    ].join('\n');
+    const result = spawnSync('bash', ['--norc', '-c', script], {
+      env: { ...process.env, VIBETUNNEL_ENV_FILE: envFile, OLD: 'x' },
+      encoding: 'utf8',
+    });
+    // The exit status of the last command is kept for the user's prompt
+    expect(result.stdout).toBe("1|it's $HOME|unset\n");
+    expect(fs.readdirSync(dir)).toEqual([]);
+  });
+});
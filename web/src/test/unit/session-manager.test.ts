@@ -1,9 +1,11 @@
+import { spawnSync } from 'child_process';
 import * as fs from 'fs';
 import * as os from 'os';
 import * as path from 'path';
 import { afterAll, afterEach, beforeAll, beforeEach, describe, expect, it } from 'vitest';
 import { SessionManager } from '../../server/pty/session-manager';
-import type { SessionInfo } from '../../server/types';
+import { SESSION_SCHEMA_VERSION } from '../../shared/session-schema';
+import type { SessionInfo } from '../../shared/types';
 
 describe('SessionManager', () => {
   let sessionManager: SessionManager;
@@ -43,14 +45,12 @@ describe('SessionManager', () => {
     it('should save session info to file', () => {
       const sessionId = 'test123';
       const sessionInfo: SessionInfo = {
-        cmdline: ['echo', 'test'],
+        command: ['echo', 'test'],
         name: 'Test Session',
-        cwd: testDir,
+        workingDir: testDir,
         pid: 12345,
         status: 'running',
-        started_at: new Date().toISOString(),
-        term: 'xterm-256color',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       };
 
       // Create session directory first
@@ -71,14 +71,12 @@ describe('SessionManager', () => {
     it('should load session info from file', () => {
       const sessionId = 'test456';
       const sessionInfo: SessionInfo = {
-        cmdline: ['bash', '-l'],
+        command: ['bash', '-l'],
         name: 'Bash Session',
-        cwd: '/home/user',
+        workingDir: '/home/user',
         pid: 54321,
         status: 'running',
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       };
 
       // Create session directory and save info
@@ -90,6 +88,42 @@ describe('SessionManager', () => {
       expect(readInfo).toMatchObject(sessionInfo);
     });
 
+    it('should migrate session files written before versioning', () => {
+      const sessionId = 'legacy';
+      sessionManager.createSessionDirectory(sessionId);
+      fs.writeFileSync(
+        path.join(testDir, sessionId, 'session.json'),
+        JSON.stringify({
+          cmdline: ['bash'],
+          name: 'Legacy',
+          cwd: testDir,
+          status: 'exited',
+          exit_code: 2,
+          started_at: '2025-01-01T00:00:00.000Z',
+          term: 'xterm',
+          spawn_type: 'pty',
+        })
+      );
+
+      expect(sessionManager.loadSessionInfo(sessionId)).toEqual({
+        id: sessionId,
+        command: ['bash'],
+        name: 'Legacy',
+        workingDir: testDir,
+        status: 'exited',
+        exitCode: 2,
+        startedAt: '2025-01-01T00:00:00.000Z',
+      });
+
+      // Saving writes the current version
+      sessionManager.updateSessionStatus(sessionId, 'exited', undefined, 2);
+      const content = JSON.parse(
+        fs.readFileSync(path.join(testDir, sessionId, 'session.json'), 'utf-8')
+      );
+      expect(content).toMatchObject({ version: SESSION_SCHEMA_VERSION, command: ['bash'] });
+      expect(content).not.toHaveProperty('cmdline');
+    });
+
     it('should return null for non-existent session', () => {
       const info = sessionManager.loadSessionInfo('nonexistent');
       expect(info).toBeNull();
@@ -98,14 +132,12 @@ describe('SessionManager', () => {
     it('should update existing session status', () => {
       const sessionId = 'test789';
       const initialInfo: SessionInfo = {
-        cmdline: ['vim'],
+        command: ['vim'],
         name: 'Editor',
-        cwd: testDir,
+        workingDir: testDir,
         pid: 11111,
         status: 'running',
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       };
 
       // Create session directory and save initial info
@@ -133,15 +165,13 @@ describe('SessionManager', () => {
 
       for (const session of sessions) {
         const sessionInfo: SessionInfo = {
-          cmdline: ['echo', session.name],
+          command: ['echo', session.name],
           name: session.name,
-          cwd: testDir,
+          workingDir: testDir,
           pid: session.pid,
           status: session.status,
           exitCode: session.exitCode,
-          started_at: new Date().toISOString(),
-          term: 'xterm',
-          spawn_type: 'pty',
+          startedAt: new Date().toISOString(),
         };
         sessionManager.createSessionDirectory(session.id);
         sessionManager.saveSessionInfo(session.id, sessionInfo);
@@ -177,14 +207,12 @@ describe('SessionManager', () => {
       // Create a valid session
       sessionManager.createSessionDirectory('validsession');
       sessionManager.saveSessionInfo('validsession', {
-        cmdline: ['ls'],
+        command: ['ls'],
         name: 'Valid',
-        cwd: testDir,
+        workingDir: testDir,
         pid: 12345,
         status: 'running',
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       });
 
       const sessions = sessionManager.listSessions();
@@ -213,39 +241,33 @@ describe('SessionManager', () => {
 
       sessionManager.createSessionDirectory('running');
       sessionManager.saveSessionInfo('running', {
-        cmdline: ['node'],
+        command: ['node'],
         name: 'Running',
-        cwd: testDir,
+        workingDir: testDir,
         pid: runningPid,
         status: 'running',
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       });
 
       sessionManager.createSessionDirectory('zombie');
       sessionManager.saveSessionInfo('zombie', {
-        cmdline: ['ghost'],
+        command: ['ghost'],
         name: 'Zombie',
-        cwd: testDir,
+        workingDir: testDir,
         pid: zombiePid,
         status: 'running',
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       });
 
       sessionManager.createSessionDirectory('exited');
       sessionManager.saveSessionInfo('exited', {
-        cmdline: ['done'],
+        command: ['done'],
         name: 'Exited',
-        cwd: testDir,
+        workingDir: testDir,
         pid: 12345,
         status: 'exited',
         exitCode: 0,
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       });
 
       // Update zombie sessions
@@ -266,16 +288,49 @@ describe('SessionManager', () => {
       expect(exitedSession?.exitCode).toBe(0);
     });
 
+    it('should mark sessions whose host died as exited and end their recording', () => {
+      // The command is still running, the process holding its PTY is not
+      const deadPid = spawnSync('true').pid;
+      sessionManager.createSessionDirectory('orphan');
+      sessionManager.saveSessionInfo('orphan', {
+        command: ['nohup', 'make'],
+        name: 'Orphan',
+        workingDir: testDir,
+        pid: process.pid,
+        hostPid: deadPid,
+        status: 'running',
+        startedAt: new Date().toISOString(),
+      });
+      const stdoutPath = path.join(testDir, 'orphan', 'stdout');
+      // Killed in the middle of writing a line
+      fs.writeFileSync(stdoutPath, '{"version":2,"width":80,"height":24}\n[0.5,"o","bu');
+
+      expect(sessionManager.updateZombieSessions()).toEqual(['orphan']);
+      expect(sessionManager.loadSessionInfo('orphan')).toMatchObject({
+        status: 'exited',
+        exitCode: 1,
+        hostLost: true,
+      });
+      const lines = fs.readFileSync(stdoutPath, 'utf8').split('\n');
+      expect(lines.slice(1)).toEqual(['[0.5,"o","bu', '["exit",1,"orphan"]', '']);
+
+      // A recording that ended already is left alone
+      sessionManager.saveSessionInfo('orphan', {
+        ...sessionManager.loadSessionInfo('orphan'),
+        status: 'running',
+      } as SessionInfo);
+      sessionManager.updateZombieSessions();
+      expect(fs.readFileSync(stdoutPath, 'utf8').split('\n')).toEqual(lines);
+    });
+
     it('should handle sessions without PID', () => {
       sessionManager.createSessionDirectory('no-pid');
       sessionManager.saveSessionInfo('no-pid', {
-        cmdline: ['test'],
+        command: ['test'],
         name: 'No PID',
-        cwd: testDir,
+        workingDir: testDir,
         status: 'running',
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       } as SessionInfo); // Intentionally missing pid
 
       sessionManager.updateZombieSessions();
@@ -291,15 +346,13 @@ describe('SessionManager', () => {
       const sessionId = 'to-delete';
       sessionManager.createSessionDirectory(sessionId);
       sessionManager.saveSessionInfo(sessionId, {
-        cmdline: ['rm', '-rf'],
+        command: ['rm', '-rf'],
         name: 'Clean Me',
-        cwd: testDir,
+        workingDir: testDir,
         pid: 12345,
         status: 'exited',
         exitCode: 0,
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       });
 
       const sessionDir = path.join(testDir, sessionId);
@@ -310,6 +363,26 @@ describe('SessionManager', () => {
       expect(fs.existsSync(sessionDir)).toBe(false);
     });
 
+    it('should create a private scratch directory that is removed with the session', () => {
+      const sessionId = 'with-scratch';
+      sessionManager.createSessionDirectory(sessionId);
+
+      const scratchDir = sessionManager.createScratchDir(sessionId, {
+        uid: process.getuid?.() ?? 0,
+        gid: process.getgid?.() ?? 0,
+      });
+      fs.writeFileSync(path.join(scratchDir, 'artifact.txt'), 'data');
+
+      expect(scratchDir).toBe(path.join(testDir, sessionId, 'scratch'));
+      if (process.platform !== 'win32') {
+        expect(fs.statSync(scratchDir).mode & 0o777).toBe(0o700);
+      }
+
+      sessionManager.cleanupSession(sessionId);
+
+      expect(fs.existsSync(scratchDir)).toBe(false);
+    });
+
     it('should handle non-existent session cleanup gracefully', () => {
       // Should not throw
       expect(() => sessionManager.cleanupSession('nonexistent')).not.toThrow();
@@ -336,14 +409,12 @@ describe('SessionManager', () => {
       // Create session
       sessionManager.createSessionDirectory(sessionId);
       sessionManager.saveSessionInfo(sessionId, {
-        cmdline: ['test'],
+        command: ['test'],
         name: 'Test',
-        cwd: testDir,
+        workingDir: testDir,
         pid: 12345,
         status: 'running',
-        started_at: new Date().toISOString(),
-        term: 'xterm',
-        spawn_type: 'pty',
+        startedAt: new Date().toISOString(),
       });
 
       // Now it exists
@@ -0,0 +1,194 @@
+import express from 'express';
+import * as fs from 'fs';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { PtyError, PtyManager } from '../../server/pty/index';
+import { SessionManager } from '../../server/pty/session-manager';
+import { createSessionRoutes } from '../../server/routes/sessions';
+import type { ActivityMonitor } from '../../server/services/activity-monitor';
+import type { InputSequencer } from '../../server/services/input-sequencer';
+import type { SizeNegotiator } from '../../server/services/size-negotiator';
+import type { StreamWatcher } from '../../server/services/stream-watcher';
+import type { TerminalManager } from '../../server/services/terminal-manager';
+
+describe('PtyManager.updateSessionMetadata', () => {
+  let controlDir: string;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'session-metadata-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should update exited sessions on disk', async () => {
+    const sessionManager = new SessionManager(controlDir);
+    sessionManager.createSessionDirectory('s1');
+    sessionManager.saveSessionInfo('s1', {
+      id: 's1',
+      name: 'build',
+      command: ['make'],
+      workingDir: '/src',
+      status: 'exited',
+      exitCode: 0,
+      startedAt: new Date().toISOString(),
+      fields: { ticket: 'VT-1' },
+    });
+    const ptyManager = new PtyManager(controlDir);
+
+    const ack = await ptyManager.updateSessionMetadata('s1', {
+      cmd: 'metadata',
+      description: 'Nightly build',
+      fields: { ticket: null, stage: 'prod' },
+    });
+
+    expect(ack).toMatchObject({
+      ok: true,
+      metadata: { description: 'Nightly build', fields: { stage: 'prod' } },
+    });
+    expect(sessionManager.loadSessionInfo('s1')).toMatchObject({
+      description: 'Nightly build',
+      fields: { stage: 'prod' },
+    });
+  });
+
+  it('should report too many fields without saving', async () => {
+    const sessionManager = new SessionManager(controlDir);
+    sessionManager.createSessionDirectory('s1');
+    sessionManager.saveSessionInfo('s1', {
+      id: 's1',
+      name: 'build',
+      command: ['make'],
+      workingDir: '/src',
+      status: 'exited',
+      startedAt: new Date().toISOString(),
+    });
+    const fields = Object.fromEntries(
+      Array.from({ length: 33 }, (_, i) => [`field${i}`, String(i)])
+    );
+
+    const ack = await new PtyManager(controlDir).updateSessionMetadata('s1', {
+      cmd: 'metadata',
+      fields,
+    });
+
+    expect(ack).toMatchObject({ ok: false, error: 'a session has at most 32 fields' });
+    expect(sessionManager.loadSessionInfo('s1')).not.toHaveProperty('fields');
+  });
+});
+
+describe('session metadata and env routes', () => {
+  let server: Server;
+  let baseUrl: string;
+  const updateSessionMetadata = vi.fn();
+  const sendControlCommand = vi.fn();
+
+  const send = (method: string, route: string, body: unknown) =>
+    fetch(`${baseUrl}/sessions/s1/${route}`, {
+      method,
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    });
+
+  beforeEach(async () => {
+    updateSessionMetadata.mockReset();
+    sendControlCommand.mockReset();
+    const app = express();
+    app.use(express.json());
+    app.use(
+      '/api',
+      createSessionRoutes({
+        ptyManager: { updateSessionMetadata, sendControlCommand } as unknown as PtyManager,
+        terminalManager: {} as TerminalManager,
+        streamWatcher: {} as StreamWatcher,
+        remoteRegistry: null,
+        isHQMode: false,
+        activityMonitor: {} as ActivityMonitor,
+        inputSequencer: {} as InputSequencer,
+        sizeNegotiator: {} as SizeNegotiator,
+      })
+    );
+    server = app.listen(0);
+    await new Promise((resolve) => server.once('listening', resolve));
+    baseUrl = `http://localhost:${(server.address() as AddressInfo).port}/api`;
+  });
+
+  afterEach(() => {
+    server.close();
+  });
+
+  it('should return the updated metadata', async () => {
+    updateSessionMetadata.mockResolvedValue({
+      id: '1',
+      ok: true,
+      metadata: { currentWorkingDir: '/srv', description: 'Deploy', fields: {} },
+    });
+
+    const response = await send('PATCH', 'metadata', {
+      currentWorkingDir: '/srv',
+      description: 'Deploy',
+    });
+
+    expect(response.status).toBe(200);
+    expect(await response.json()).toEqual({
+      sessionId: 's1',
+      currentWorkingDir: '/srv',
+      description: 'Deploy',
+      fields: {},
+    });
+    expect(updateSessionMetadata).toHaveBeenCalledWith('s1', {
+      cmd: 'metadata',
+      currentWorkingDir: '/srv',
+      description: 'Deploy',
+      fields: undefined,
+    });
+  });
+
+  it('should reject invalid metadata before reaching the session', async () => {
+    const response = await send('PATCH', 'metadata', { currentWorkingDir: 'relative' });
+
+    expect(response.status).toBe(400);
+    expect((await response.json()).code).toBe('INVALID_REQUEST');
+    expect(updateSessionMetadata).not.toHaveBeenCalled();
+  });
+
+  it('should say whether env changes reach the shell', async () => {
+    sendControlCommand.mockResolvedValue({
+      id: '1',
+      ok: true,
+      env: { DEBUG: '1', OLD: null },
+      injected: true,
+    });
+
+    const response = await send('PUT', 'env', { set: { DEBUG: '1' }, unset: ['OLD'] });
+
+    expect(await response.json()).toEqual({
+      sessionId: 's1',
+      env: { DEBUG: '1', OLD: null },
+      injected: true,
+    });
+    expect((await send('PUT', 'env', { set: { 'NOT-VALID': '1' } })).status).toBe(400);
+    expect(sendControlCommand).toHaveBeenCalledTimes(1);
+  });
+
+  it('should map control command failures to API errors', async () => {
+    sendControlCommand.mockRejectedValueOnce(new PtyError('gone', 'SESSION_NOT_FOUND'));
+    sendControlCommand.mockRejectedValueOnce(new PtyError('exited', 'SESSION_NOT_RUNNING'));
+    sendControlCommand.mockRejectedValueOnce(new PtyError('no answer', 'CONTROL_MESSAGE_FAILED'));
+    updateSessionMetadata.mockResolvedValue({ id: '1', ok: false, error: 'too many fields' });
+
+    const statuses: number[] = [];
+    for (let i = 0; i < 3; i++) {
+      statuses.push((await send('PUT', 'env', { set: { A: '1' } })).status);
+    }
+    const rejected = await send('PATCH', 'metadata', { fields: { a: '1' } });
+
+    expect(statuses).toEqual([404, 400, 503]);
+    expect(rejected.status).toBe(400);
+    expect((await rejected.json()).message).toBe('too many fields');
+  });
+});
@@ -0,0 +1,40 @@
+import { describe, expect, it } from 'vitest';
+import {
+  namespaceSessionId,
+  parseNamespacedSessionId,
+  toRemoteSessionId,
+} from '../../server/utils/session-namespace';
+
+describe('session namespace', () => {
+  it('should prefix session IDs with the remote name', () => {
+    expect(namespaceSessionId('remote-1', 'abc')).toBe('remote-1/abc');
+  });
+
+  it('should parse namespaced IDs', () => {
+    expect(parseNamespacedSessionId('remote-1/abc')).toEqual({
+      remoteName: 'remote-1',
+      sessionId: 'abc',
+    });
+  });
+
+  it('should treat plain and malformed IDs as not namespaced', () => {
+    expect(parseNamespacedSessionId('abc')).toBeNull();
+    expect(parseNamespacedSessionId('/abc')).toBeNull();
+    expect(parseNamespacedSessionId('remote-1/')).toBeNull();
+  });
+
+  it('should map namespaced IDs back to the remote session ID', () => {
+    expect(toRemoteSessionId('remote-1/abc')).toBe('abc');
+    expect(toRemoteSessionId('abc')).toBe('abc');
+  });
+
+  it('should strip one level per hop behind a federated HQ', () => {
+    const id = namespaceSessionId('team-a', namespaceSessionId('remote-1', 'abc'));
+    expect(id).toBe('team-a/remote-1/abc');
+    expect(parseNamespacedSessionId(id)).toEqual({
+      remoteName: 'team-a',
+      sessionId: 'remote-1/abc',
+    });
+    expect(toRemoteSessionId(toRemoteSessionId(id))).toBe('abc');
+  });
+});
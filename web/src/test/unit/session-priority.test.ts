@@ -0,0 +1,56 @@
+import { type ChildProcess, spawn } from 'child_process';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import {
+  applySessionPriority,
+  isSessionPriority,
+  sessionCgroupDir,
+} from '../../server/pty/session-priority';
+
+describe('session priority', () => {
+  let dir: string;
+  let child: ChildProcess;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'session-priority-'));
+    child = spawn('sleep', ['30']);
+  });
+
+  afterEach(() => {
+    child.kill();
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should accept known priorities only', () => {
+    expect(isSessionPriority('background')).toBe(true);
+    expect(isSessionPriority('urgent')).toBe(false);
+    expect(isSessionPriority(undefined)).toBe(false);
+  });
+
+  it('should renice the processes', async () => {
+    const pid = child.pid as number;
+    const errors = await applySessionPriority([pid], 'low');
+    expect(os.getPriority(pid)).toBe(10);
+    expect(errors.filter((error) => error.startsWith('nice'))).toEqual([]);
+  });
+
+  it('should move the processes into a weighted cgroup', async () => {
+    const pid = child.pid as number;
+    const cgroupDir = sessionCgroupDir(dir, 'abc');
+    const errors = await applySessionPriority([pid], 'background', cgroupDir);
+
+    expect(cgroupDir).toBe(path.join(dir, 'session-abc'));
+    expect(fs.readFileSync(path.join(cgroupDir, 'cpu.weight'), 'utf8')).toBe('1');
+    expect(fs.readFileSync(path.join(cgroupDir, 'cgroup.procs'), 'utf8')).toBe(String(pid));
+    expect(errors.filter((error) => error.startsWith('cgroup'))).toEqual([]);
+  });
+
+  it('should ignore processes that already exited', async () => {
+    child.kill();
+    await new Promise((resolve) => child.once('exit', resolve));
+    const errors = await applySessionPriority([child.pid as number], 'low');
+    expect(errors.filter((error) => error.startsWith('nice'))).toEqual([]);
+  });
+});
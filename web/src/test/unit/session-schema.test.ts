@@ -0,0 +1,62 @@
+import * as fs from 'fs';
+import * as path from 'path';
+import { describe, expect, it } from 'vitest';
+import {
+  parseSessionInfo,
+  SESSION_SCHEMA_VERSION,
+  SessionSchemaError,
+  serializeSessionInfo,
+} from '../../shared/session-schema';
+
+// Golden files: <name>.json is read as a session named <name> and must equal <name>.expected.json
+const fixturesDir = path.join(__dirname, '../fixtures/session-json');
+const goldenNames = fs
+  .readdirSync(fixturesDir)
+  .filter((file) => file.endsWith('.json') && !file.endsWith('.expected.json'))
+  .map((file) => file.slice(0, -'.json'.length));
+
+function readFixture(file: string): unknown {
+  return JSON.parse(fs.readFileSync(path.join(fixturesDir, file), 'utf8'));
+}
+
+describe('session.json schema', () => {
+  it.each(goldenNames)('should read %s as the current schema', (name) => {
+    const info = parseSessionInfo(readFixture(`${name}.json`), name);
+    expect(info).toEqual(readFixture(`${name}.expected.json`));
+  });
+
+  it('should write the current version', () => {
+    const file = readFixture('v1.json') as Record<string, unknown>;
+    const written = JSON.parse(serializeSessionInfo(parseSessionInfo(file)));
+    expect(written).toEqual(file);
+    expect(Object.keys(written)).toEqual(Object.keys(file));
+    expect(written.version).toBe(SESSION_SCHEMA_VERSION);
+  });
+
+  it('should write migrated files without legacy names', () => {
+    const info = parseSessionInfo(readFixture('v0-go.json'));
+    const written = JSON.parse(serializeSessionInfo(info));
+    expect(written).not.toHaveProperty('cmdline');
+    expect(written).not.toHaveProperty('started_at');
+    expect(parseSessionInfo(written)).toEqual(info);
+  });
+
+  it('should reject invalid files', () => {
+    const valid = readFixture('v1.json') as Record<string, unknown>;
+    const invalid: unknown[] = [
+      null,
+      ['not', 'an', 'object'],
+      { ...valid, version: -1 },
+      { ...valid, version: '1' },
+      { ...valid, id: undefined },
+      { ...valid, command: 'pnpm test' },
+      { ...valid, command: ['pnpm', 1] },
+      { ...valid, workingDir: undefined },
+      { ...valid, status: 'stopped' },
+      { ...valid, startedAt: 42 },
+    ];
+    for (const data of invalid) {
+      expect(() => parseSessionInfo(data)).toThrow(SessionSchemaError);
+    }
+  });
+});
@@ -0,0 +1,151 @@
+import express from 'express';
+import * as fs from 'fs';
+import type { Server } from 'http';
+import type { AddressInfo } from 'net';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import type { AuthenticatedRequest } from '../../server/middleware/auth';
+import type { PtyManager } from '../../server/pty/index';
+import { createTemplateRoutes } from '../../server/routes/templates';
+import { SessionTemplateStore, validateTemplate } from '../../server/services/session-templates';
+
+describe('SessionTemplateStore', () => {
+  let controlDir: string;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'session-templates-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should validate templates', () => {
+    expect(validateTemplate({ name: 'web-dev' }, false)).toBeNull();
+    expect(validateTemplate({ name: 'web dev' }, false)).toMatch(/name/);
+    expect(validateTemplate({}, true)).toBeNull();
+    expect(validateTemplate({ command: [] }, true)).toMatch(/command/);
+    expect(validateTemplate({ env: { 'A-B': '1' } }, true)).toMatch(/invalid variable name/);
+    expect(validateTemplate({ env: { PORT: 3000 } }, true)).toMatch(/must be a string/);
+    expect(validateTemplate({ ports: [3000, 70000] }, true)).toMatch(/ports/);
+    expect(validateTemplate({ startup: ['npm install', 1] }, true)).toMatch(/startup/);
+    expect(validateTemplate({ priority: 'urgent' }, true)).toMatch(/priority/);
+  });
+
+  it('should store templates by name and keep them across restarts', () => {
+    const store = new SessionTemplateStore(controlDir);
+    const body = {
+      name: 'web',
+      workingDir: '~/src/web',
+      env: { NODE_ENV: 'development' },
+      ports: [3000],
+      startup: ['npm install', 'npm run dev'],
+      // Fields of stored templates are not taken from requests
+      createdAt: 'yesterday',
+      owner: 'mallory',
+    };
+    const template = store.create(body, 'alice');
+    expect(template.createdAt).not.toBe('yesterday');
+    expect(template.owner).toBe('alice');
+    expect(() => store.create({ name: 'web' })).toThrow(/already exists/);
+
+    const updated = store.update('web', { ports: [3000, 5173], workingDir: null });
+    expect(updated).toMatchObject({ ports: [3000, 5173], startup: ['npm install', 'npm run dev'] });
+    expect(updated).not.toHaveProperty('workingDir');
+    expect(() => store.update('web', { ports: [0] })).toThrow(/ports/);
+    expect(store.update('api', { ports: [8080] })).toBeUndefined();
+
+    const reloaded = new SessionTemplateStore(controlDir);
+    expect(reloaded.get('web')).toEqual(store.get('web'));
+    expect(reloaded.delete('web')).toBe(true);
+    expect(new SessionTemplateStore(controlDir).list()).toEqual([]);
+  });
+});
+
+describe('template routes', () => {
+  let controlDir: string;
+  let store: SessionTemplateStore;
+  let server: Server;
+  let baseUrl: string;
+
+  // The caller is picked with a header: a user name, or "operator" for a local bypass
+  const request = (user: string, method: string, route: string, body?: unknown) =>
+    fetch(`${baseUrl}/${route}`, {
+      method,
+      headers: { 'Content-Type': 'application/json', 'X-Test-User': user },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+
+  const names = async (user: string) => {
+    const { templates } = await (await request(user, 'GET', 'templates')).json();
+    return (templates as { name: string }[]).map(({ name }) => name);
+  };
+
+  beforeEach(async () => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'session-templates-'));
+    store = new SessionTemplateStore(controlDir);
+    const app = express();
+    app.use(express.json());
+    app.use((req: AuthenticatedRequest, _res, next) => {
+      const user = req.headers['x-test-user'];
+      if (user === 'operator') {
+        req.authMethod = 'local-bypass';
+      } else {
+        req.authMethod = 'password';
+        req.userId = user as string;
+      }
+      next();
+    });
+    app.use(
+      '/api',
+      createTemplateRoutes({
+        ptyManager: {} as PtyManager,
+        templateStore: store,
+        adminUsers: ['root'],
+      })
+    );
+    server = app.listen(0);
+    await new Promise((resolve) => server.once('listening', resolve));
+    baseUrl = `http://localhost:${(server.address() as AddressInfo).port}/api`;
+  });
+
+  afterEach(() => {
+    server.close();
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should record the owner and show templates only to their owner and admins', async () => {
+    const created = await request('alice', 'POST', 'templates', { name: 'alice-web' });
+    expect(created.status).toBe(201);
+    expect((await created.json()).owner).toBe('alice');
+    await request('operator', 'POST', 'templates', { name: 'shared' });
+    expect(store.get('shared')).not.toHaveProperty('owner');
+
+    expect(await names('alice')).toEqual(['alice-web', 'shared']);
+    expect(await names('bob')).toEqual(['shared']);
+    expect(await names('root')).toEqual(['alice-web', 'shared']);
+    expect((await request('bob', 'GET', 'templates/alice-web')).status).toBe(404);
+    expect((await request('bob', 'POST', 'templates/alice-web/launch', {})).status).toBe(404);
+  });
+
+  it('should let only the owner or an admin change a template', async () => {
+    store.create({ name: 'alice-web' }, 'alice');
+    store.create({ name: 'shared' });
+
+    expect((await request('bob', 'PATCH', 'templates/alice-web', { ports: [1] })).status).toBe(404);
+    expect((await request('bob', 'DELETE', 'templates/alice-web')).status).toBe(404);
+    const shared = await request('alice', 'PATCH', 'templates/shared', { ports: [1] });
+    expect(shared.status).toBe(403);
+    expect((await shared.json()).code).toBe('FORBIDDEN');
+    expect((await request('alice', 'DELETE', 'templates/shared')).status).toBe(403);
+    expect(store.get('shared')).not.toHaveProperty('ports');
+
+    const own = await request('alice', 'PATCH', 'templates/alice-web', { ports: [1] });
+    expect(own.status).toBe(200);
+    expect((await request('root', 'PATCH', 'templates/shared', { ports: [2] })).status).toBe(200);
+    expect((await request('root', 'DELETE', 'templates/alice-web')).status).toBe(200);
+    expect((await request('operator', 'DELETE', 'templates/shared')).status).toBe(200);
+    expect(store.list()).toEqual([]);
+  });
+});
@@ -0,0 +1,157 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import {
+  CommandTracker,
+  commandLineText,
+  commandScript,
+  readCommandHistory,
+} from '../../server/pty/shell-integration';
+
+function track() {
+  const markers: string[] = [];
+  const tracker = new CommandTracker((marker) => markers.push(marker));
+  return { tracker, markers };
+}
+
+describe('commandLineText', () => {
+  it('should strip escape sequences and apply backspaces', () => {
+    expect(commandLineText('\x1b[32mls\x1b[0m -lx\b\ba')).toBe('ls -a');
+    expect(commandLineText('echo \x1b]0;title\x07hi\r\n')).toBe('echo hi');
+  });
+});
+
+describe('CommandTracker', () => {
+  it('should mark prompts, commands and their exit codes', () => {
+    const { tracker, markers } = track();
+    tracker.handleMark('A');
+    tracker.handleText('$ ');
+    tracker.handleMark('B');
+    tracker.handleText('make test');
+    tracker.handleMark('C');
+    tracker.handleText('output\r\n');
+    tracker.handleMark('D;2');
+    expect(markers).toEqual(['prompt', 'command make test', 'command-done 2']);
+  });
+
+  it('should prefer the command line passed by the shell', () => {
+    const { tracker, markers } = track();
+    tracker.handleMark('B');
+    tracker.handleText('gi\x1b[Dt');
+    tracker.handleMark('C;cmdline_url=git%20status');
+    expect(markers).toEqual(['command git status']);
+  });
+
+  it('should ignore D marks without a command', () => {
+    const { tracker, markers } = track();
+    tracker.handleMark('D');
+    tracker.handleMark('A');
+    expect(markers).toEqual(['prompt']);
+  });
+});
+
+describe('readCommandHistory', () => {
+  let dir: string;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'shell-integration-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  function writeCast(events: unknown[]): string {
+    const castPath = path.join(dir, 'stdout');
+    const header = { version: 2, width: 80, height: 24, timestamp: 1700000000 };
+    const lines = [header, ...events].map((event) => JSON.stringify(event));
+    fs.writeFileSync(castPath, `${lines.join('\n')}\n`);
+    return castPath;
+  }
+
+  it('should list marked commands with timing and exit status', async () => {
+    const castPath = writeCast([
+      [0.5, 'm', 'prompt'],
+      [1, 'i', 'ls\r'],
+      [1.25, 'm', 'command ls'],
+      [1.3, 'o', 'file\r\n'],
+      [1.5, 'm', 'command-done 0'],
+      [2, 'm', 'command sleep 100'],
+    ]);
+
+    expect(await readCommandHistory(castPath)).toEqual({
+      source: 'marks',
+      commands: [
+        {
+          command: 'ls',
+          time: 1.25,
+          startedAt: '2023-11-14T22:13:21.250Z',
+          durationMs: 250,
+          exitCode: 0,
+        },
+        {
+          command: 'sleep 100',
+          time: 2,
+          startedAt: '2023-11-14T22:13:22.000Z',
+          durationMs: null,
+          exitCode: null,
+        },
+      ],
+    });
+  });
+
+  it('should fall back to typed lines without marks', async () => {
+    const castPath = writeCast([
+      [1, 'i', 'mkae'],
+      [1.5, 'i', '\x7f\x7f\x7fake test\r'],
+      [1.6, 'o', 'running\r\n'],
+      [4, 'o', 'ok\r\n'],
+      [5, 'i', 'rm -rf /\x03'],
+      [6, 'i', '\x1b[Agit status\r'],
+    ]);
+
+    const history = await readCommandHistory(castPath);
+    expect(history.source).toBe('input');
+    expect(history.commands.map((entry) => [entry.command, entry.time, entry.durationMs])).toEqual([
+      ['make test', 1.5, 2500],
+      ['git status', 6, null],
+    ]);
+  });
+});
+
+describe('commandScript', () => {
+  it('should write the commands after a cd to the working directory', () => {
+    const session = {
+      id: 'abc',
+      name: 'build',
+      workingDir: "/home/me/it's here",
+      startedAt: '2023-11-14T22:13:20.000Z',
+    };
+    const script = commandScript(session, {
+      source: 'marks',
+      commands: [
+        {
+          command: 'make test',
+          time: 1.5,
+          startedAt: '2023-11-14T22:13:21.500Z',
+          durationMs: 2500,
+          exitCode: 2,
+        },
+        {
+          command: 'sleep 100',
+          time: 6,
+          startedAt: '2023-11-14T22:13:26.000Z',
+          durationMs: null,
+          exitCode: null,
+        },
+      ],
+    });
+
+    const lines = script.split('\n');
+    expect(lines[0]).toBe('#!/bin/sh');
+    expect(lines).toContain("cd '/home/me/it'\\''s here' || exit 1");
+    expect(script).toContain('# 2023-11-14T22:13:21.500Z, 2.5s, exit 2\nmake test\n');
+    expect(script.endsWith('# 2023-11-14T22:13:26.000Z\nsleep 100\n')).toBe(true);
+  });
+});
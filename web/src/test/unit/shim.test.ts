@@ -0,0 +1,62 @@
+import { spawnSync } from 'child_process';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { parseShimCommand, shimScript, writeShims } from '../../server/shim';
+
+describe('shims', () => {
+  let dir: string;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'shim-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should split commands into program and arguments', () => {
+    expect(parseShimCommand(' npm  run dev ')).toEqual({ program: 'npm', args: ['run', 'dev'] });
+    expect(parseShimCommand('claude')).toEqual({ program: 'claude', args: [] });
+    expect(() => parseShimCommand('../bin/evil')).toThrow();
+    expect(() => parseShimCommand('')).toThrow();
+  });
+
+  it('should write one shim per program and remove stale shims', () => {
+    const shimDir = path.join(dir, 'shims');
+    expect(writeShims(shimDir, ['claude', 'npm run dev', 'npm start'], ['vt'])).toEqual([
+      'claude',
+      'npm',
+    ]);
+    const npm = fs.readFileSync(path.join(shimDir, 'npm'), 'utf8');
+    expect(npm).toContain(`{ [ "$1" = 'run' ] && [ "$2" = 'dev' ]; } || { [ "$1" = 'start' ]; }`);
+    expect(fs.statSync(path.join(shimDir, 'npm')).mode & 0o111).toBeTruthy();
+
+    fs.writeFileSync(path.join(shimDir, 'mine'), '#!/bin/sh\n');
+    writeShims(shimDir, ['npm run dev'], ['vt']);
+    expect(fs.existsSync(path.join(shimDir, 'claude'))).toBe(false);
+    expect(fs.existsSync(path.join(shimDir, 'mine'))).toBe(true);
+    expect(() => writeShims(shimDir, ['mine'], ['vt'])).toThrow(/not a VibeTunnel shim/);
+  });
+
+  it('should run the real program outside a terminal or when opted out', () => {
+    const shimDir = path.join(dir, 'shims');
+    const binDir = path.join(dir, 'bin');
+    fs.mkdirSync(shimDir);
+    fs.mkdirSync(binDir);
+    fs.writeFileSync(path.join(binDir, 'hello'), '#!/bin/sh\necho "real $*"\n', { mode: 0o755 });
+    fs.writeFileSync(path.join(shimDir, 'hello'), shimScript('hello', [[]], shimDir, ['false']), {
+      mode: 0o755,
+    });
+
+    const run = (env: NodeJS.ProcessEnv) =>
+      spawnSync(path.join(shimDir, 'hello'), ['a b'], {
+        env: { PATH: `${shimDir}:${binDir}:/usr/bin:/bin`, ...env },
+        encoding: 'utf8',
+      });
+    expect(run({}).stdout).toBe('real a b\n');
+    expect(run({ VIBETUNNEL_NO_SHIM: '1' }).stdout).toBe('real a b\n');
+    expect(run({ PATH: `${shimDir}:/usr/bin:/bin` }).status).toBe(127);
+  });
+});
@@ -0,0 +1,150 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { SizeNegotiator } from '../../server/services/size-negotiator';
+import { TerminalManager } from '../../server/services/terminal-manager';
+
+describe('SizeNegotiator', () => {
+  let negotiator: SizeNegotiator;
+
+  beforeEach(() => {
+    negotiator = new SizeNegotiator();
+  });
+
+  describe('follow-last', () => {
+    it('should apply the size of the viewer that resized last', () => {
+      expect(negotiator.reportViewerSize('s1', 'a', { cols: 80, rows: 24 })).toEqual({
+        cols: 80,
+        rows: 24,
+      });
+      expect(negotiator.reportViewerSize('s1', 'b', { cols: 120, rows: 40 })).toEqual({
+        cols: 120,
+        rows: 40,
+      });
+      expect(negotiator.getEffectiveSize('s1')).toEqual({ cols: 120, rows: 40 });
+    });
+
+    it('should re-apply a size that looks unchanged', () => {
+      negotiator.reportViewerSize('s1', 'a', { cols: 80, rows: 24 });
+      expect(negotiator.reportViewerSize('s1', 'a', { cols: 80, rows: 24 })).toEqual({
+        cols: 80,
+        rows: 24,
+      });
+    });
+  });
+
+  describe('largest-wins', () => {
+    beforeEach(() => {
+      negotiator.setSettings('s1', { policy: 'largest-wins' });
+    });
+
+    it('should use the largest cols and rows of all viewers', () => {
+      negotiator.reportViewerSize('s1', 'a', { cols: 200, rows: 20 });
+      negotiator.reportViewerSize('s1', 'b', { cols: 80, rows: 50 });
+      expect(negotiator.getEffectiveSize('s1')).toEqual({ cols: 200, rows: 50 });
+    });
+
+    it('should not resize when a smaller viewer joins', () => {
+      negotiator.reportViewerSize('s1', 'a', { cols: 120, rows: 40 });
+      expect(negotiator.reportViewerSize('s1', 'b', { cols: 80, rows: 24 })).toBeNull();
+    });
+
+    it('should shrink when the largest viewer leaves', () => {
+      negotiator.reportViewerSize('s1', 'a', { cols: 120, rows: 40 });
+      negotiator.reportViewerSize('s1', 'b', { cols: 80, rows: 24 });
+      expect(negotiator.removeViewer('s1', 'a')).toEqual({ cols: 80, rows: 24 });
+    });
+  });
+
+  describe('owner-wins', () => {
+    it('should make the first viewer the owner', () => {
+      negotiator.setSettings('s1', { policy: 'owner-wins' });
+      negotiator.reportViewerSize('s1', 'a', { cols: 100, rows: 30 });
+      expect(negotiator.reportViewerSize('s1', 'b', { cols: 200, rows: 60 })).toBeNull();
+      expect(negotiator.getSettings('s1').ownerId).toBe('a');
+      expect(negotiator.getEffectiveSize('s1')).toEqual({ cols: 100, rows: 30 });
+    });
+
+    it('should keep the size while the owner is away', () => {
+      negotiator.setSettings('s1', { policy: 'owner-wins', ownerId: 'owner' });
+      expect(negotiator.reportViewerSize('s1', 'guest', { cols: 80, rows: 24 })).toBeNull();
+      expect(negotiator.reportViewerSize('s1', 'owner', { cols: 90, rows: 30 })).toEqual({
+        cols: 90,
+        rows: 30,
+      });
+    });
+  });
+
+  describe('fixed', () => {
+    it('should ignore viewer sizes', () => {
+      expect(
+        negotiator.setSettings('s1', { policy: 'fixed', fixedSize: { cols: 132, rows: 43 } })
+      ).toEqual({ cols: 132, rows: 43 });
+      expect(negotiator.reportViewerSize('s1', 'a', { cols: 80, rows: 24 })).toBeNull();
+      expect(negotiator.getViewerSize('s1', 'a')).toEqual({ cols: 80, rows: 24 });
+    });
+  });
+
+  describe('stale viewers', () => {
+    afterEach(() => {
+      vi.useRealTimers();
+    });
+
+    it('should ignore viewers that stopped reporting', () => {
+      vi.useFakeTimers();
+      negotiator = new SizeNegotiator({ defaultPolicy: 'largest-wins', viewerTtlMs: 1000 });
+      negotiator.reportViewerSize('s1', 'a', { cols: 200, rows: 60 });
+      vi.advanceTimersByTime(2000);
+      negotiator.reportViewerSize('s1', 'b', { cols: 80, rows: 24 });
+      expect(negotiator.getEffectiveSize('s1')).toEqual({ cols: 80, rows: 24 });
+      expect(negotiator.getViewerCount('s1')).toBe(1);
+    });
+  });
+});
+
+describe('TerminalManager.cropSnapshot', () => {
+  const manager = new TerminalManager('/tmp/vibetunnel-test-control');
+
+  const row = (text: string) => Array.from(text, (char) => ({ char, width: 1 }));
+  const snapshot = {
+    cols: 10,
+    rows: 4,
+    viewportY: 100,
+    cursorX: 8,
+    cursorY: 3,
+    cells: [row('line one'), row('line two'), row('0123456789'), row('prompt $ ')],
+  };
+
+  it('should return the snapshot when the viewer is large enough', () => {
+    expect(manager.cropSnapshot(snapshot, 10, 4)).toBe(snapshot);
+  });
+
+  it('should keep the bottom rows and cut columns on the right', () => {
+    const cropped = manager.cropSnapshot(snapshot, 5, 2);
+    expect(cropped.cols).toBe(5);
+    expect(cropped.rows).toBe(2);
+    expect(cropped.viewportY).toBe(102);
+    expect(cropped.cursorY).toBe(1);
+    expect(cropped.cursorX).toBe(4);
+    expect(cropped.cells.map((cells) => cells.map((cell) => cell.char).join(''))).toEqual([
+      '01234',
+      'promp',
+    ]);
+  });
+
+  it('should keep the cursor line visible', () => {
+    const cropped = manager.cropSnapshot({ ...snapshot, cursorY: 0 }, 10, 2);
+    expect(cropped.viewportY).toBe(100);
+    expect(cropped.cursorY).toBe(0);
+  });
+
+  it('should reuse cropped rows between snapshots', () => {
+    const first = manager.cropSnapshot(snapshot, 5, 4);
+    const second = manager.cropSnapshot({ ...snapshot }, 5, 4);
+    expect(second.cells[0]).toBe(first.cells[0]);
+  });
+
+  it('should not split wide characters', () => {
+    const wide = [{ char: '日', width: 2 }, { char: '本', width: 2 }];
+    const cropped = manager.cropSnapshot({ ...snapshot, cells: [wide] }, 3, 4);
+    expect(cropped.cells[0].map((cell) => cell.char)).toEqual(['日']);
+  });
+});
@@ -0,0 +1,44 @@
+import { describe, expect, it } from 'vitest';
+import { renderSnapshotAnsi } from '../../server/utils/snapshot-ansi';
+
+describe('renderSnapshotAnsi', () => {
+  it('should clear the screen, draw rows and place the cursor', () => {
+    const output = renderSnapshotAnsi({
+      cells: [
+        [
+          { char: 'a', width: 1 },
+          { char: 'b', width: 1 },
+        ],
+        [{ char: 'c', width: 1 }],
+      ],
+      cursorX: 1,
+      cursorY: 1,
+    });
+    expect(output).toBe('\x1b[0m\x1b[H\x1b[2Jab\r\nc\x1b[0m\x1b[2;2H');
+  });
+
+  it('should only emit styles when they change', () => {
+    const output = renderSnapshotAnsi({
+      cells: [
+        [
+          { char: 'x', width: 1, fg: 1, attributes: 0x01 },
+          { char: 'y', width: 1, fg: 1, attributes: 0x01 },
+          { char: 'z', width: 1, bg: 0x102030 },
+        ],
+      ],
+      cursorX: 0,
+      cursorY: 0,
+    });
+    expect(output).toBe(
+      '\x1b[0m\x1b[H\x1b[2J\x1b[0;1;31mxy\x1b[0;48;2;16;32;48mz\x1b[0m\x1b[1;1H'
+    );
+  });
+
+  it('should map palette colors to basic, bright and 256-color codes', () => {
+    const render = (fg: number) =>
+      renderSnapshotAnsi({ cells: [[{ char: 'x', width: 1, fg }]], cursorX: 0, cursorY: 0 });
+    expect(render(2)).toContain('\x1b[0;32m');
+    expect(render(9)).toContain('\x1b[0;91m');
+    expect(render(200)).toContain('\x1b[0;38;5;200m');
+  });
+});
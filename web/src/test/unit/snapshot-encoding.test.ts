@@ -0,0 +1,162 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { decodeBinaryBuffer } from '../../client/utils/terminal-renderer';
+import { TerminalManager } from '../../server/services/terminal-manager';
+import { BufferPool } from '../../server/utils/buffer-pool';
+
+const toArrayBuffer = (buffer: Buffer) => new Uint8Array(buffer).slice().buffer;
+
+describe('BufferPool', () => {
+  it('should reuse released buffers of the same size class', () => {
+    const pool = new BufferPool();
+    const first = pool.acquire(1500);
+    expect(first.buffer).toHaveLength(1500);
+    first.buffer.fill(1);
+    first.release();
+    first.release();
+
+    const second = pool.acquire(2000);
+    expect(second.buffer).toHaveLength(2000);
+    expect(second.buffer[0]).toBe(1);
+    expect(pool.getStats()).toEqual({
+      allocations: 1,
+      reuses: 1,
+      pooledBuffers: 0,
+      pooledBytes: 0,
+    });
+
+    second.release();
+    pool.acquire(5000).release();
+    expect(pool.getStats()).toMatchObject({ allocations: 2, pooledBuffers: 2, pooledBytes: 10240 });
+  });
+
+  it('should keep at most the configured number of buffers per size class', () => {
+    const pool = new BufferPool(2);
+    const buffers = [pool.acquire(10), pool.acquire(10), pool.acquire(10)];
+    for (const pooled of buffers) pooled.release();
+    expect(pool.getStats()).toMatchObject({ allocations: 3, pooledBuffers: 2 });
+  });
+});
+
+describe('snapshot encoding', () => {
+  const snapshot = {
+    cols: 10,
+    rows: 3,
+    viewportY: 0,
+    cursorX: 2,
+    cursorY: 1,
+    cells: [
+      [
+        { char: 'h', width: 1, fg: 2 },
+        { char: 'i', width: 1, attributes: 0x01 },
+      ],
+      [{ char: ' ', width: 1 }],
+      [
+        { char: '中', width: 2 },
+        { char: '$', width: 1, bg: 4 },
+      ],
+    ],
+  };
+
+  it('should encode the same bytes into a pooled buffer after the prefix', () => {
+    const manager = new TerminalManager(os.tmpdir());
+    const encoded = manager.encodeSnapshot(snapshot);
+    const pooled = manager.encodeSnapshotPooled(snapshot, 7);
+
+    expect(pooled.buffer).toHaveLength(encoded.length + 7);
+    expect(Buffer.compare(pooled.buffer.subarray(7), encoded)).toBe(0);
+    pooled.release();
+
+    // Rows are encoded once per row array
+    expect(Buffer.compare(manager.encodeSnapshot(snapshot), encoded)).toBe(0);
+
+    const decoded = decodeBinaryBuffer(toArrayBuffer(encoded));
+    expect(decoded).toMatchObject({ cols: 10, rows: 3, cursorX: 2, cursorY: 1 });
+    expect(decoded.cells[0]).toEqual([
+      { char: 'h', width: 1, fg: 2 },
+      { char: 'i', width: 1, attributes: 0x01 },
+    ]);
+    expect(decoded.cells[2].map((cell) => cell.char)).toEqual(['中', '$']);
+  });
+});
+
+describe('snapshot reuse', () => {
+  let controlDir: string;
+  let manager: TerminalManager;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'snapshot-encoding-'));
+    manager = new TerminalManager(controlDir);
+  });
+
+  afterEach(() => {
+    manager.closeTerminal('s1');
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  // Start a terminal with the given output and wait until it is parsed
+  async function startTerminal(output: string, lastRow: number) {
+    const header = JSON.stringify({ version: 2, width: 80, height: 24 });
+    fs.mkdirSync(path.join(controlDir, 's1'));
+    fs.writeFileSync(
+      path.join(controlDir, 's1', 'stdout'),
+      `${header}\n${JSON.stringify([0, 'o', output])}\n`
+    );
+    const terminal = await manager.getTerminal('s1');
+    const snapshot = await waitForRow(lastRow);
+    return { terminal, snapshot };
+  }
+
+  function waitForRow(row: number) {
+    return vi.waitFor(async () => {
+      const snapshot = await manager.getBufferSnapshot('s1');
+      if (!snapshot.cells[row] || snapshot.cells[row][0].char === ' ') {
+        throw new Error('not parsed');
+      }
+      return snapshot;
+    });
+  }
+
+  it('should share unchanged rows between snapshots', async () => {
+    const { terminal, snapshot: before } = await startTerminal('first\r\nsecond\r\n', 1);
+
+    terminal.write('third');
+    const after = await waitForRow(2);
+
+    expect(after.cells[0]).toBe(before.cells[0]);
+    expect(after.cells[1]).toBe(before.cells[1]);
+    expect(after.cells[2]).not.toBe(before.cells[2]);
+    expect(after.cells[2].map((cell) => cell.char).join('')).toBe('third');
+  });
+
+  it('should return the same snapshot until the buffer changes', async () => {
+    const { terminal, snapshot } = await startTerminal('prompt$ ', 0);
+    expect(await manager.getBufferSnapshot('s1')).toBe(snapshot);
+
+    // A title change is parsed but leaves the visible content as it was
+    await new Promise<void>((resolve) => terminal.write('\x1b]0;build\x07', resolve));
+    expect(await manager.getBufferSnapshot('s1')).toBe(snapshot);
+
+    await new Promise<void>((resolve) => terminal.write('ls', resolve));
+    const changed = await manager.getBufferSnapshot('s1');
+    expect(changed).not.toBe(snapshot);
+    expect(changed.cursorX).toBe(snapshot.cursorX + 2);
+  });
+
+  it('should not notify listeners of unchanged frames', async () => {
+    const { terminal } = await startTerminal('prompt$ ', 0);
+    // Let the notification for the initial output pass
+    await new Promise((resolve) => setTimeout(resolve, 100));
+    const listener = vi.fn();
+    await manager.subscribeToBufferChanges('s1', listener);
+
+    terminal.write('ls');
+    await vi.waitFor(() => expect(listener).toHaveBeenCalledTimes(1));
+
+    terminal.write('\x1b]0;build\x07');
+    await new Promise((resolve) => setTimeout(resolve, 200));
+    expect(listener).toHaveBeenCalledTimes(1);
+  });
+});
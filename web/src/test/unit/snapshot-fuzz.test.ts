@@ -0,0 +1,172 @@
+import { describe, expect, it } from 'vitest';
+import {
+  type BufferCell,
+  decodeBinaryBuffer,
+  inflateBinaryBuffer,
+} from '../../client/utils/terminal-renderer';
+import type { SnapshotLink } from '../../server/services/hyperlink-tracker';
+import { TerminalManager } from '../../server/services/terminal-manager';
+import { FLAG_COMPRESSED } from '../../shared/buffer-protocol';
+import { cellWidth } from '../../shared/cell-width';
+
+// Seeded, so a failure names the seed that reproduces it
+function createRandom(seed: number): (n: number) => number {
+  let state = seed >>> 0;
+  return (n) => {
+    state = (state + 0x6d2b79f5) >>> 0;
+    let t = state;
+    t = Math.imul(t ^ (t >>> 15), t | 1);
+    t ^= t + Math.imul(t ^ (t >>> 7), t | 61);
+    return Math.floor((((t ^ (t >>> 14)) >>> 0) / 4294967296) * n);
+  };
+}
+
+// ASCII, wide, emoji, ZWJ sequences, characters with combining marks (also
+// after ASCII), an empty cell and a cluster longer than the 255-byte limit
+const CHARS = [
+  ' ',
+  'a',
+  'Z',
+  '~',
+  '\u2500',
+  '\u00e9',
+  'e\u0301',
+  '\u4e2d',
+  '\u{1f642}',
+  '\u{1f469}\u200d\u{1f4bb}',
+  '',
+  `a${'\u0301'.repeat(130)}`,
+];
+
+function randomCell(random: (n: number) => number): BufferCell {
+  const cell: BufferCell = { char: CHARS[random(CHARS.length)], width: 1 };
+  if (random(3) === 0) {
+    if (random(2)) cell.attributes = random(0x80);
+    // Palette 0-255 or RGB; RGB values up to 255 would decode as palette
+    // indexes, which the format cannot tell apart
+    if (random(2)) cell.fg = random(2) ? random(256) : 0x100 + random(0xffff00);
+    if (random(2)) cell.bg = random(2) ? random(256) : 0x100 + random(0xffff00);
+  }
+  return cell;
+}
+
+function randomSnapshot(random: (n: number) => number) {
+  const cells = Array.from({ length: random(30) + 1 }, () =>
+    Array.from({ length: random(40) + 1 }, () => randomCell(random))
+  );
+  const links: SnapshotLink[] = [];
+  for (let i = random(3); i > 0; i--) {
+    const row = random(cells.length);
+    const start = random(cells[row].length);
+    links.push({ uri: `https://example.com/${i}`, spans: [[row, start, start + random(10) + 1]] });
+  }
+  return {
+    cols: 80,
+    rows: cells.length,
+    viewportY: random(1000),
+    cursorX: random(80),
+    cursorY: random(cells.length),
+    cells,
+    ...(links.length > 0 ? { links } : {}),
+  };
+}
+
+// What a decoder sees of a cell: widths derived from the cluster, no zero
+// attributes, clusters cut to 255 bytes at a code point
+function expectedCells(snapshot: ReturnType<typeof randomSnapshot>): BufferCell[][] {
+  const cells = snapshot.cells.map((row) =>
+    row.map(({ width: _width, attributes, ...cell }) => {
+      const expected: BufferCell = { ...cell, width: 1 };
+      if (attributes) expected.attributes = attributes;
+      while (Buffer.byteLength(expected.char) > 255) {
+        expected.char = Array.from(expected.char).slice(0, -1).join('');
+      }
+      expected.width = cellWidth(expected.char);
+      return expected;
+    })
+  );
+  for (const { uri, spans } of snapshot.links ?? []) {
+    for (const [row, start, end] of spans) {
+      for (let i = start; i < Math.min(end, cells[row].length); i++) cells[row][i].link = uri;
+    }
+  }
+  return cells;
+}
+
+const toArrayBuffer = (buffer: Buffer) => new Uint8Array(buffer).slice().buffer;
+
+describe('snapshot encoder round trip', () => {
+  const manager = new TerminalManager('/tmp/vibetunnel-test-control');
+
+  it('should decode what it encodes', async () => {
+    for (let seed = 1; seed <= 200; seed++) {
+      const random = createRandom(seed);
+      const snapshot = randomSnapshot(random);
+      const expected = expectedCells(snapshot);
+
+      const plain = manager.encodeSnapshotPooled(snapshot);
+      const decoded = decodeBinaryBuffer(toArrayBuffer(plain.buffer));
+      expect(decoded.cells, `seed ${seed}`).toEqual(expected);
+      expect(decoded).toMatchObject({
+        cols: snapshot.cols,
+        rows: snapshot.rows,
+        viewportY: snapshot.viewportY,
+        cursorX: snapshot.cursorX,
+        cursorY: snapshot.cursorY,
+      });
+
+      // The same snapshot through the HTTP encoder
+      const http = manager.encodeSnapshot(snapshot);
+      expect(decodeBinaryBuffer(toArrayBuffer(http)).cells, `seed ${seed}`).toEqual(expected);
+
+      // A copy with some rows replaced, as a delta of the first
+      const next = randomSnapshot(createRandom(seed + 1000));
+      const changed = {
+        ...snapshot,
+        cells: snapshot.cells.map((row, i) => (random(3) === 0 ? (next.cells[i] ?? row) : row)),
+        links: undefined,
+      };
+      const delta = manager.encodeSnapshotPooled(changed, 0, { baseRows: plain.rows });
+      const previous = decodeBinaryBuffer(toArrayBuffer(plain.buffer)).cells;
+      const rebuilt = decodeBinaryBuffer(toArrayBuffer(delta.buffer), previous);
+      expect(rebuilt.cells, `seed ${seed}`).toEqual(expectedCells(changed));
+
+      // Small frames are sent uncompressed
+      const compressed = manager.encodeSnapshotPooled(snapshot, 0, { compress: true });
+      const frame = toArrayBuffer(compressed.buffer);
+      const deflated = (compressed.buffer[3] & FLAG_COMPRESSED) !== 0;
+      const inflated = deflated ? await inflateBinaryBuffer(frame) : frame;
+      expect(decodeBinaryBuffer(inflated).cells, `seed ${seed}`).toEqual(expected);
+
+      plain.release();
+      delta.release();
+      compressed.release();
+    }
+  });
+
+  it('should keep a single space with colors', () => {
+    const cells = [[{ char: ' ', width: 1, bg: 0 }]];
+    const snapshot = { cols: 80, rows: 1, viewportY: 0, cursorX: 0, cursorY: 0, cells };
+    const decoded = decodeBinaryBuffer(toArrayBuffer(manager.encodeSnapshot(snapshot)));
+    expect(decoded.cells).toEqual([[{ char: ' ', width: 1, bg: 0 }]]);
+  });
+});
+
+describe('snapshot decoder', () => {
+  const manager = new TerminalManager('/tmp/vibetunnel-test-control');
+
+  it('should return or throw on damaged frames', () => {
+    for (let seed = 1; seed <= 300; seed++) {
+      const random = createRandom(seed);
+      const frame = Buffer.from(manager.encodeSnapshot(randomSnapshot(random)));
+      // Flip bytes, then maybe cut the frame short
+      for (let i = random(8) + 1; i > 0; i--) frame[random(frame.length)] = random(256);
+      const damaged = frame.subarray(0, random(2) ? frame.length : random(frame.length));
+      try {
+        decodeBinaryBuffer(toArrayBuffer(damaged));
+      } catch (error) {
+        expect(error, `seed ${seed}`).toBeInstanceOf(Error);
+      }
+    }
+  });
+});
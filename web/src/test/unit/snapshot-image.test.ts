@@ -0,0 +1,71 @@
+import { inflateSync } from 'zlib';
+import { describe, expect, it } from 'vitest';
+import { renderSnapshotPng, renderSnapshotSvg } from '../../server/utils/snapshot-image';
+
+const snapshot = {
+  cols: 10,
+  rows: 2,
+  cells: [
+    [
+      { char: 'o', width: 1, fg: 1, attributes: 0x01 },
+      { char: 'k', width: 1, fg: 1, attributes: 0x01 },
+      { char: '<', width: 1, bg: 4 },
+    ],
+  ],
+  cursorX: 2,
+  cursorY: 1,
+};
+
+// Decode the RGB pixels of a PNG written by renderSnapshotPng (filter type 0 only)
+function decodePng(png: Buffer) {
+  expect(png.subarray(0, 4).toString('latin1')).toBe('\x89PNG');
+  const width = png.readUInt32BE(16);
+  const height = png.readUInt32BE(20);
+  const idat = png.indexOf('IDAT');
+  const raw = inflateSync(png.subarray(idat + 4, idat + 4 + png.readUInt32BE(idat - 4)));
+  const pixel = (x: number, y: number) => {
+    const i = y * (width * 3 + 1) + 1 + x * 3;
+    return (raw[i] << 16) | (raw[i + 1] << 8) | raw[i + 2];
+  };
+  return { width, height, pixel };
+}
+
+describe('renderSnapshotSvg', () => {
+  it('should render styled runs, backgrounds and the cursor', () => {
+    const svg = renderSnapshotSvg(snapshot);
+    expect(svg).toMatch(/^<svg xmlns="http:\/\/www.w3.org\/2000\/svg" width="84" height="34"/);
+    // Bold red is drawn in bright red
+    expect(svg).toContain('<text x="0.0" y="13" fill="#ff0000" font-weight="bold">ok</text>');
+    expect(svg).toContain('<text x="16.8" y="13">&lt;</text>');
+    expect(svg).toContain('<rect x="16.8" y="0" width="8.4" height="17" fill="#0000ee"/>');
+    expect(svg).toContain('<rect x="16.8" y="17" width="8.4" height="17" fill="#00ff00"/>');
+  });
+
+  it('should leave out the cursor on request', () => {
+    expect(renderSnapshotSvg(snapshot, { cursor: false })).not.toContain('#00ff00');
+  });
+});
+
+describe('renderSnapshotPng', () => {
+  it('should render 8x16 cells with colors and the cursor', () => {
+    const { width, height, pixel } = decodePng(renderSnapshotPng(snapshot));
+    expect([width, height]).toEqual([80, 32]);
+    // Background, a cell background and the cursor block
+    expect(pixel(79, 0)).toBe(0x1e1e1e);
+    expect(pixel(23, 0)).toBe(0x0000ee);
+    expect(pixel(23, 16)).toBe(0x00ff00);
+    // Some pixels of the 'o' glyph are drawn in bright red
+    let red = 0;
+    for (let y = 0; y < 16; y++) {
+      for (let x = 0; x < 8; x++) {
+        if (pixel(x, y) === 0xff0000) red++;
+      }
+    }
+    expect(red).toBeGreaterThan(0);
+  });
+
+  it('should scale cells', () => {
+    const { width, height } = decodePng(renderSnapshotPng(snapshot, { scale: 2 }));
+    expect([width, height]).toEqual([160, 64]);
+  });
+});
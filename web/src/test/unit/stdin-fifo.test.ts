@@ -0,0 +1,48 @@
+import { spawnSync } from 'child_process';
+import * as fs from 'fs';
+import type * as net from 'net';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import { openStdinFifo } from '../../server/pty/stdin-fifo';
+
+describe('openStdinFifo', () => {
+  let dir: string;
+  let reader: net.Socket | null = null;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'stdin-fifo-'));
+  });
+
+  afterEach(() => {
+    reader?.destroy();
+    reader = null;
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should read every writer in order without losing data between them', async () => {
+    const fifoPath = path.join(dir, 'stdin');
+    expect(spawnSync('mkfifo', [fifoPath]).status).toBe(0);
+
+    const chunks: Buffer[] = [];
+    reader = openStdinFifo(fifoPath, (data) => chunks.push(data));
+    expect(reader).not.toBeNull();
+
+    for (let i = 0; i < 50; i++) {
+      fs.appendFileSync(fifoPath, `${i},`);
+    }
+    spawnSync('sh', ['-c', `printf end > '${fifoPath}'`]);
+
+    const expected = `${Array.from({ length: 50 }, (_, i) => `${i},`).join('')}end`;
+    await expect
+      .poll(() => Buffer.concat(chunks).toString('utf8'), { timeout: 2000 })
+      .toBe(expected);
+  });
+
+  it('should ignore paths that are not FIFOs', () => {
+    const filePath = path.join(dir, 'stdin');
+    fs.writeFileSync(filePath, '');
+    expect(openStdinFifo(filePath, () => {})).toBeNull();
+    expect(openStdinFifo(path.join(dir, 'missing'), () => {})).toBeNull();
+  });
+});
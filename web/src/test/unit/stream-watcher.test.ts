@@ -0,0 +1,444 @@
+import type { Response } from 'express';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { OutputBroadcaster } from '../../server/pty/output-broadcaster';
+import type {
+  FileChangeListener,
+  FileWatchErrorListener,
+  FileWatcherPool,
+} from '../../server/services/file-watcher-pool';
+import { StreamWatcher } from '../../server/services/stream-watcher';
+import type { SessionAnnotation } from '../../shared/types';
+
+const SESSION_ID = 'session-1';
+const MISSING_STREAM = '/nonexistent/stream-out';
+const HEADER = '{"version":2,"width":80,"height":24}';
+
+function createWatcher(annotations: SessionAnnotation[] = []) {
+  const broadcaster = new OutputBroadcaster();
+  const watcher = new StreamWatcher({
+    liveOutput: { subscribeToOutput: (_sessionId, listener) => broadcaster.subscribe(listener) },
+    annotations: { list: () => annotations },
+  });
+  return { broadcaster, watcher };
+}
+
+function createResponse() {
+  const events: string[] = [];
+  const response = {
+    write: vi.fn((chunk: string) => events.push(chunk)),
+    end: vi.fn(),
+  } as unknown as Response;
+  return { response, events };
+}
+
+// Data of the SSE events written to a client, without IDs
+function eventData(events: string[]): unknown[] {
+  return events.map((event) => JSON.parse(event.match(/^data: (.*)$/m)?.[1] ?? 'null'));
+}
+
+// IDs (stream file offsets) of the SSE events written to a client
+function eventIds(events: string[]): number[] {
+  return events.map((event) => Number(event.match(/^id: (\d+)$/m)?.[1]));
+}
+
+function outputText(events: string[]): unknown[] {
+  return eventData(events).map((event) => (Array.isArray(event) ? event[2] : 'header'));
+}
+
+const line = (text: string) => `[0.1,"o","${text}"]`;
+
+describe('StreamWatcher pause', () => {
+  it('should hold output while paused and deliver it on resume', () => {
+    const { broadcaster, watcher } = createWatcher();
+    const { response, events } = createResponse();
+    watcher.addClient(SESSION_ID, MISSING_STREAM, response);
+
+    watcher.pauseSession(SESSION_ID);
+    broadcaster.publish('[0.1,"o","a"]');
+    broadcaster.publish('[0.2,"o","b"]');
+    expect(watcher.isPaused(SESSION_ID)).toBe(true);
+    expect(events).toHaveLength(0);
+
+    watcher.resumeSession(SESSION_ID);
+    expect(watcher.isPaused(SESSION_ID)).toBe(false);
+    expect(eventData(events).map((event) => (event as unknown[])[2])).toEqual(['a', 'b']);
+  });
+
+  it('should send the redraw and only output past its offset on resume', () => {
+    const { broadcaster, watcher } = createWatcher();
+    const { response, events } = createResponse();
+    watcher.addClient(SESSION_ID, MISSING_STREAM, response);
+
+    watcher.pauseSession(SESSION_ID);
+    broadcaster.publish('[0.1,"o","a"]');
+    const redrawOffset = broadcaster.getOffset();
+    broadcaster.publish('[0.2,"o","b"]');
+
+    watcher.resumeSession(SESSION_ID, { ansi: 'screen', offset: redrawOffset });
+    expect(eventData(events).map((event) => (event as unknown[])[2])).toEqual(['screen', 'b']);
+    expect(events[0]).toContain(`id: ${redrawOffset}\n`);
+  });
+
+  it('should deliver the exit event while paused', () => {
+    const { broadcaster, watcher } = createWatcher();
+    const { response, events } = createResponse();
+    watcher.addClient(SESSION_ID, MISSING_STREAM, response);
+
+    watcher.pauseSession(SESSION_ID);
+    broadcaster.publish('[0.1,"o","a"]');
+    broadcaster.publish(`["exit",0,"${SESSION_ID}"]`);
+
+    expect(eventData(events)).toEqual([['exit', 0, SESSION_ID]]);
+    expect(response.end).toHaveBeenCalled();
+  });
+});
+
+describe('StreamWatcher resume', () => {
+  let dir: string;
+  let streamPath: string;
+
+  beforeEach(() => {
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'stream-watcher-'));
+    streamPath = path.join(dir, 'stdout');
+  });
+
+  afterEach(() => {
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  describe('from the stream file', () => {
+    let changed: FileChangeListener;
+    let failed: FileWatchErrorListener | undefined;
+    let watches: number;
+    let unwatchable: boolean;
+    let watcher: StreamWatcher;
+
+    beforeEach(() => {
+      watches = 0;
+      unwatchable = false;
+      const watcherPool = {
+        watch: (
+          _filePath: string,
+          listener: FileChangeListener,
+          onError?: FileWatchErrorListener
+        ) => {
+          if (unwatchable) throw new Error('ENOSPC');
+          watches++;
+          changed = listener;
+          failed = onError;
+          return { close: () => {} };
+        },
+      } as unknown as FileWatcherPool;
+      watcher = new StreamWatcher({ watcherPool });
+    });
+
+    it('should tag events with the offset just past their line', async () => {
+      const lines = [HEADER, line('a'), line('é')];
+      fs.writeFileSync(streamPath, `${lines.join('\n')}\n`);
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, response);
+
+      await vi.waitFor(() => expect(events).toHaveLength(3));
+      let offset = 0;
+      const expected = lines.map((text) => (offset += Buffer.byteLength(text) + 1));
+      expect(eventIds(events)).toEqual(expected);
+      watcher.removeClient(SESSION_ID, response);
+    });
+
+    it('should replay only the events after the Last-Event-ID', async () => {
+      fs.writeFileSync(streamPath, `${[HEADER, line('a'), line('b'), line('c')].join('\n')}\n`);
+      const first = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, first.response);
+      await vi.waitFor(() => expect(first.events).toHaveLength(4));
+
+      const resumed = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, resumed.response, eventIds(first.events)[1]);
+      await vi.waitFor(() => expect(resumed.events).toHaveLength(2));
+
+      expect(outputText(resumed.events)).toEqual(['b', 'c']);
+      expect(eventIds(resumed.events)).toEqual(eventIds(first.events).slice(2));
+      watcher.removeClient(SESSION_ID, first.response);
+      watcher.removeClient(SESSION_ID, resumed.response);
+    });
+
+    it('should skip the rest of a line when resuming inside it', async () => {
+      fs.writeFileSync(streamPath, `${[HEADER, line('a'), line('ü'), line('c')].join('\n')}\n`);
+      const first = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, first.response);
+      await vi.waitFor(() => expect(first.events).toHaveLength(4));
+
+      // Inside the multi-byte character of the third line
+      const insideLine = eventIds(first.events)[1] + line('').length - 1;
+      const resumed = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, resumed.response, insideLine);
+      await vi.waitFor(() => expect(resumed.events).toHaveLength(1));
+      await new Promise((resolve) => setTimeout(resolve, 20));
+
+      expect(outputText(resumed.events)).toEqual(['c']);
+      expect(eventIds(resumed.events)).toEqual([eventIds(first.events)[3]]);
+      watcher.removeClient(SESSION_ID, first.response);
+      watcher.removeClient(SESSION_ID, resumed.response);
+    });
+
+    it('should complete a line that was partially written when the client connected', async () => {
+      const complete = `${HEADER}\n${line('a')}\n`;
+      const partial = line('b');
+      fs.writeFileSync(streamPath, complete + partial.slice(0, 8));
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, response);
+      await vi.waitFor(() => expect(events).toHaveLength(2));
+
+      fs.appendFileSync(streamPath, `${partial.slice(8)}\n`);
+      changed('change');
+
+      expect(outputText(events)).toEqual(['header', 'a', 'b']);
+      expect(eventIds(events)[2]).toBe(fs.statSync(streamPath).size);
+      watcher.removeClient(SESSION_ID, response);
+    });
+
+    it('should send only new output when resuming past the end of the file', async () => {
+      fs.writeFileSync(streamPath, `${HEADER}\n${line('a')}\n`);
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, response, 10_000);
+      await new Promise((resolve) => setTimeout(resolve, 20));
+      expect(events).toHaveLength(0);
+
+      fs.appendFileSync(streamPath, `${line('b')}\n`);
+      changed('change');
+
+      expect(outputText(events)).toEqual(['b']);
+      expect(eventIds(events)).toEqual([fs.statSync(streamPath).size]);
+      watcher.removeClient(SESSION_ID, response);
+    });
+
+    it('should watch the file again and catch up when the watcher fails', async () => {
+      fs.writeFileSync(streamPath, `${HEADER}\n`);
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, response);
+      await vi.waitFor(() => expect(events).toHaveLength(1));
+
+      // Output written while no watcher was running
+      fs.appendFileSync(streamPath, `${line('a')}\n`);
+      failed?.(new Error('EPERM'));
+
+      expect(watches).toBe(2);
+      expect(outputText(events)).toEqual(['header', 'a']);
+
+      fs.appendFileSync(streamPath, `${line('b')}\n`);
+      changed('change');
+      expect(outputText(events)).toEqual(['header', 'a', 'b']);
+      expect(response.end).not.toHaveBeenCalled();
+      watcher.removeClient(SESSION_ID, response);
+    });
+
+    it('should end the stream when the file cannot be watched again', async () => {
+      fs.writeFileSync(streamPath, `${HEADER}\n`);
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, response);
+      await vi.waitFor(() => expect(events).toHaveLength(1));
+
+      unwatchable = true;
+      failed?.(new Error('EPERM'));
+      expect(response.end).toHaveBeenCalled();
+
+      // A new client starts watching from scratch
+      unwatchable = false;
+      const next = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, next.response);
+      expect(watches).toBe(2);
+      await vi.waitFor(() => expect(next.events).toHaveLength(1));
+      watcher.removeClient(SESSION_ID, next.response);
+    });
+  });
+
+  describe('from the output broadcaster', () => {
+    let broadcaster: OutputBroadcaster;
+    let watcher: StreamWatcher;
+
+    beforeEach(() => {
+      ({ broadcaster, watcher } = createWatcher());
+    });
+
+    // Publish lines and flush the first `flushed` bytes of them to the stream file
+    function publish(lines: string[], flushed?: number) {
+      for (const text of lines) broadcaster.publish(text);
+      const content = lines.map((text) => `${text}\n`).join('');
+      fs.writeFileSync(streamPath, content.slice(0, flushed ?? content.length));
+    }
+
+    it('should split history between file and backlog without gaps or duplicates', async () => {
+      const lines = [HEADER, line('a'), line('b'), line('c'), line('d')];
+      // Flushed up to the middle of the line with "c"
+      const flushed = lines.slice(0, 3).join('\n').length + 1 + 5;
+      publish(lines, flushed);
+
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, response);
+      // Published while the file part is still being replayed
+      broadcaster.publish(line('e'));
+      await vi.waitFor(() => expect(events).toHaveLength(6));
+      await new Promise((resolve) => setTimeout(resolve, 20));
+
+      expect(outputText(events)).toEqual(['header', 'a', 'b', 'c', 'd', 'e']);
+      const ids = eventIds(events);
+      expect(ids).toEqual([...ids].sort((a, b) => a - b));
+      expect(new Set(ids).size).toBe(ids.length);
+      expect(ids[5]).toBe(broadcaster.getOffset());
+      watcher.removeClient(SESSION_ID, response);
+    });
+
+    it('should resume across the file and backlog split', async () => {
+      const lines = [HEADER, line('a'), line('b'), line('c'), line('d')];
+      const flushed = lines.slice(0, 3).join('\n').length + 1 + 5;
+      publish(lines, flushed);
+
+      const first = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, first.response);
+      await vi.waitFor(() => expect(first.events).toHaveLength(5));
+
+      // Resume once in the flushed part and once in the memory-only part
+      for (const [lastSeen, expected] of [
+        [1, ['b', 'c', 'd']],
+        [3, ['d']],
+      ] as const) {
+        const resumed = createResponse();
+        watcher.addClient(
+          SESSION_ID,
+          streamPath,
+          resumed.response,
+          eventIds(first.events)[lastSeen]
+        );
+        await vi.waitFor(() => expect(resumed.events).toHaveLength(expected.length));
+        await new Promise((resolve) => setTimeout(resolve, 20));
+
+        expect(outputText(resumed.events)).toEqual(expected);
+        expect(eventIds(resumed.events)).toEqual(eventIds(first.events).slice(lastSeen + 1));
+        watcher.removeClient(SESSION_ID, resumed.response);
+      }
+      watcher.removeClient(SESSION_ID, first.response);
+    });
+
+    it('should send only new output when resuming past the end of the stream', async () => {
+      publish([HEADER, line('a')]);
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, streamPath, response, broadcaster.getOffset() + 100);
+      await new Promise((resolve) => setTimeout(resolve, 20));
+      expect(events).toHaveLength(0);
+
+      broadcaster.publish(line('b'));
+      expect(outputText(events)).toEqual(['b']);
+      expect(eventIds(events)).toEqual([broadcaster.getOffset()]);
+      watcher.removeClient(SESSION_ID, response);
+    });
+  });
+});
+
+describe('StreamWatcher annotations', () => {
+  const annotation = (time: number, text: string): SessionAnnotation => ({
+    id: text,
+    time,
+    text,
+    createdAt: new Date().toISOString(),
+  });
+
+  it('should merge annotations into the replay by time', async () => {
+    const dir = fs.mkdtempSync(path.join(os.tmpdir(), 'stream-watcher-'));
+    const streamPath = path.join(dir, 'stdout');
+    fs.writeFileSync(
+      streamPath,
+      '{"version":2,"width":80,"height":24}\n[1,"o","a"]\n[3,"o","b"]\n'
+    );
+    const { watcher } = createWatcher([annotation(2, 'bug here'), annotation(10, 'end')]);
+    const { response, events } = createResponse();
+    watcher.addClient(SESSION_ID, streamPath, response);
+
+    await vi.waitFor(() => expect(events).toHaveLength(5));
+    expect(eventData(events).slice(1)).toEqual([
+      [0, 'o', 'a'],
+      [0, 'm', 'bug here'],
+      [0, 'o', 'b'],
+      [0, 'm', 'end'],
+    ]);
+
+    watcher.sendAnnotation(SESSION_ID, annotation(4, 'live'));
+    expect((eventData(events)[5] as unknown[]).slice(1)).toEqual(['m', 'live']);
+    watcher.removeClient(SESSION_ID, response);
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('should not replay annotations to resuming clients', () => {
+    const { watcher } = createWatcher([annotation(2, 'bug here')]);
+    const { response, events } = createResponse();
+    watcher.addClient(SESSION_ID, MISSING_STREAM, response, 100);
+    expect(events).toHaveLength(0);
+  });
+});
+
+describe('StreamWatcher output throttle', () => {
+  it('should skip output over the viewer cap and redraw once it allows output again', async () => {
+    vi.useFakeTimers();
+    try {
+      const broadcaster = new OutputBroadcaster();
+      const getRedraw = vi.fn(async () => ({ ansi: 'screen', offset: broadcaster.getOffset() }));
+      const watcher = new StreamWatcher({
+        liveOutput: {
+          subscribeToOutput: (_sessionId, listener) => broadcaster.subscribe(listener),
+        },
+        annotations: { list: () => [] },
+        throttle: { viewerBytesPerSecond: 1000, sessionBytesPerSecond: 0, burstBytes: 0 },
+        redraw: { getRedraw },
+      });
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, MISSING_STREAM, response);
+
+      broadcaster.publish(`[0.1,"o","${'a'.repeat(600)}"]`);
+      broadcaster.publish(`[0.2,"o","${'b'.repeat(600)}"]`);
+      broadcaster.publish('[0.3,"r","100x30"]');
+      expect(eventData(events).map((event) => (event as unknown[])[1])).toEqual(['o', 'r']);
+      expect(watcher.getThrottleStats().sessions).toMatchObject([
+        { sessionId: SESSION_ID, viewers: 1, throttledViewers: 1 },
+      ]);
+
+      await vi.advanceTimersByTimeAsync(1000);
+      expect(getRedraw).toHaveBeenCalledWith(SESSION_ID);
+      expect((eventData(events)[2] as unknown[])[2]).toBe('screen');
+      const [stats] = watcher.getThrottleStats().sessions;
+      expect(stats.throttledViewers).toBe(0);
+      expect(stats.skippedBytes).toBeGreaterThan(600);
+      watcher.removeClient(SESSION_ID, response);
+    } finally {
+      vi.useRealTimers();
+    }
+  });
+
+  it('should send events larger than the cap once the bucket is full', async () => {
+    vi.useFakeTimers();
+    try {
+      const { broadcaster, watcher } = createWatcher();
+      watcher.setOutputThrottle({
+        viewerBytesPerSecond: 100,
+        sessionBytesPerSecond: 0,
+        burstBytes: 0,
+      });
+      const { response, events } = createResponse();
+      watcher.addClient(SESSION_ID, MISSING_STREAM, response);
+
+      const chunk = 'x'.repeat(400);
+      broadcaster.publish(`[0.1,"o","${chunk}"]`);
+      broadcaster.publish(`[0.2,"o","${chunk}"]`);
+      expect(events).toHaveLength(1);
+
+      // Without a redraw source, output resumes once the debt is paid off
+      await vi.advanceTimersByTimeAsync(5000);
+      broadcaster.publish(`[0.3,"o","${chunk}"]`);
+      expect(events).toHaveLength(2);
+      expect(watcher.getThrottleStats().sessions[0].throttledViewers).toBe(0);
+      watcher.removeClient(SESSION_ID, response);
+    } finally {
+      vi.useRealTimers();
+    }
+  });
+});
@@ -0,0 +1,36 @@
+import * as os from 'os';
+import { describe, expect, it } from 'vitest';
+import { parseMeminfo, SystemStats } from '../../server/services/system-stats';
+
+describe('parseMeminfo', () => {
+  it('should parse fields into bytes', () => {
+    const meminfo = parseMeminfo(
+      'MemTotal:       16318480 kB\nMemAvailable:    8123456 kB\n' +
+        'Active(anon):        100 kB\nHugePages_Total:       0\n'
+    );
+    expect(meminfo.get('MemTotal')).toBe(16318480 * 1024);
+    expect(meminfo.get('MemAvailable')).toBe(8123456 * 1024);
+    expect(meminfo.get('Active(anon)')).toBe(100 * 1024);
+    // Counts without a unit are kept as they are
+    expect(meminfo.get('HugePages_Total')).toBe(0);
+  });
+});
+
+describe('SystemStats', () => {
+  it('should report the host', async () => {
+    const stats = await new SystemStats(os.tmpdir()).getStats();
+    expect(stats.cpu.count).toBe(os.cpus().length);
+    expect(stats.cpu.usagePercent).toBeGreaterThanOrEqual(0);
+    expect(stats.cpu.usagePercent).toBeLessThanOrEqual(100);
+    expect(stats.load).toHaveLength(3);
+    expect(stats.memory.totalBytes).toBe(os.totalmem());
+    expect(stats.memory.availableBytes).toBeLessThanOrEqual(stats.memory.totalBytes);
+    expect(stats.disk?.path).toBe(os.tmpdir());
+    expect(stats.disk?.totalBytes).toBeGreaterThan(0);
+  });
+
+  it('should report no disk for a missing path', async () => {
+    const stats = await new SystemStats('/nonexistent/vibetunnel').getStats();
+    expect(stats.disk).toBeNull();
+  });
+});
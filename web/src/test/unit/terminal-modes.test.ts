@@ -0,0 +1,64 @@
+import { describe, expect, it } from 'vitest';
+import { TerminalModeTracker } from '../../server/pty/terminal-modes';
+import { encodeKey } from '../../shared/keymap';
+
+describe('TerminalModeTracker', () => {
+  it('should start in normal cursor and numeric keypad mode', () => {
+    const tracker = new TerminalModeTracker();
+    expect(tracker.getModes()).toEqual({ applicationCursor: false, applicationKeypad: false });
+  });
+
+  it('should follow DECCKM and DECKPAM as set by full-screen programs', () => {
+    const tracker = new TerminalModeTracker();
+    // What vim/less send on startup (smkx)
+    tracker.feed('\x1b[?1049h\x1b[?1h\x1b=\x1b[H\x1b[2J');
+    expect(tracker.getModes()).toEqual({ applicationCursor: true, applicationKeypad: true });
+
+    // ... and on exit (rmkx)
+    tracker.feed('\x1b[?1l\x1b>\x1b[?1049l');
+    expect(tracker.getModes()).toEqual({ applicationCursor: false, applicationKeypad: false });
+  });
+
+  it('should handle combined DECSET parameters', () => {
+    const tracker = new TerminalModeTracker();
+    tracker.feed('\x1b[?25;1;1000h');
+    expect(tracker.getModes().applicationCursor).toBe(true);
+  });
+
+  it('should handle sequences split across chunks', () => {
+    const tracker = new TerminalModeTracker();
+    tracker.feed('output\x1b[?');
+    tracker.feed('1');
+    expect(tracker.getModes().applicationCursor).toBe(false);
+    tracker.feed('hmore output');
+    expect(tracker.getModes().applicationCursor).toBe(true);
+  });
+
+  it('should apply the last mode change in a chunk', () => {
+    const tracker = new TerminalModeTracker();
+    tracker.feed('\x1b[?1h text \x1b[?1l');
+    expect(tracker.getModes().applicationCursor).toBe(false);
+  });
+
+  it('should reset modes on RIS and DECSTR', () => {
+    const tracker = new TerminalModeTracker();
+    tracker.feed('\x1b[?1h\x1b=');
+    tracker.feed('\x1bc');
+    expect(tracker.getModes()).toEqual({ applicationCursor: false, applicationKeypad: false });
+
+    tracker.feed('\x1b[?1h\x1b=');
+    tracker.feed('\x1b[!p');
+    expect(tracker.getModes()).toEqual({ applicationCursor: false, applicationKeypad: false });
+  });
+
+  it('should drive key encoding', () => {
+    const tracker = new TerminalModeTracker();
+    expect(encodeKey('arrow_up', tracker.getModes())).toBe('\x1b[A');
+    expect(encodeKey('kp_5', tracker.getModes())).toBe('5');
+
+    tracker.feed('\x1b[?1h\x1b=');
+    expect(encodeKey('arrow_up', tracker.getModes())).toBe('\x1bOA');
+    expect(encodeKey('kp_5', tracker.getModes())).toBe('\x1bOu');
+    expect(encodeKey('kp_enter', tracker.getModes())).toBe('\x1bOM');
+  });
+});
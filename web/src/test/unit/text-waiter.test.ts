@@ -0,0 +1,87 @@
+import { describe, expect, it, vi } from 'vitest';
+import type { TerminalManager } from '../../server/services/terminal-manager';
+import { TextWaiter, WaitError } from '../../server/services/text-waiter';
+
+function row(text: string) {
+  return Array.from(text, (char) => ({ char, width: 1 }));
+}
+
+type Listener = (sessionId: string, snapshot: { cells: ReturnType<typeof row>[] }) => void;
+
+function fakeTerminalManager(screen: string[]) {
+  const listeners = new Set<Listener>();
+  const manager = {
+    getBufferSnapshot: vi.fn(async () => ({ cells: screen.map(row) })),
+    subscribeToBufferChanges: vi.fn(async (_sessionId: string, listener: Listener) => {
+      listeners.add(listener);
+      return () => listeners.delete(listener);
+    }),
+  };
+  const show = (lines: string[]) => {
+    for (const listener of listeners) listener('s1', { cells: lines.map(row) });
+  };
+  return { manager: manager as unknown as TerminalManager, listeners, show };
+}
+
+describe('TextWaiter', () => {
+  it('should match text already on the screen', async () => {
+    const { manager, listeners } = fakeTerminalManager(['$ make', 'Build OK in 3s']);
+    const waiter = new TextWaiter(manager);
+
+    const result = await waiter.waitFor('s1', { pattern: 'ok in (\\d+)s', ignoreCase: true });
+    expect(result).toMatchObject({ matched: true, match: 'OK in 3s', groups: ['3'] });
+    expect(result.line).toBe('Build OK in 3s');
+    expect(listeners.size).toBe(0);
+    expect(waiter.getPendingCount()).toBe(0);
+  });
+
+  it('should match screen changes after input is sent', async () => {
+    const { manager, show } = fakeTerminalManager(['$ ']);
+    const waiter = new TextWaiter(manager);
+
+    // The prompt already on the screen does not count once input is sent
+    const armed = vi.fn(() => show(['$ ls', 'a.txt', '$ ']));
+    const result = await waiter.waitFor('s1', { pattern: '^\\$$', scope: 'screen' }, armed);
+    expect(armed).toHaveBeenCalledOnce();
+    expect(result).toMatchObject({ matched: true, line: '$' });
+  });
+
+  it('should match new output without escape sequences', async () => {
+    const { manager } = fakeTerminalManager([]);
+    const waiter = new TextWaiter(manager);
+
+    const waiting = waiter.waitFor('s1', { pattern: 'password: $', scope: 'output' }, () => {
+      waiter.handleOutput('s2', 'password: ');
+      waiter.handleOutput('s1', '\x1b[1mEnter pass');
+    });
+    waiter.handleOutput('s1', 'word: \x1b[0m');
+    expect(await waiting).toMatchObject({ matched: true, line: 'Enter password: ' });
+  });
+
+  it('should end waits on timeout, exit and abort', async () => {
+    const { manager } = fakeTerminalManager(['nothing here']);
+    const waiter = new TextWaiter(manager);
+
+    expect(await waiter.waitFor('s1', { pattern: 'never', timeoutMs: 10 })).toMatchObject({
+      matched: false,
+      reason: 'timeout',
+    });
+
+    const exiting = waiter.waitFor('s1', { pattern: 'never', scope: 'output' });
+    waiter.removeSession('s1');
+    expect(await exiting).toMatchObject({ matched: false, reason: 'exited' });
+
+    const controller = new AbortController();
+    const aborting = waiter.waitFor('s1', { pattern: 'never', signal: controller.signal });
+    controller.abort();
+    expect(await aborting).toMatchObject({ matched: false, reason: 'aborted' });
+    expect(waiter.getPendingCount()).toBe(0);
+  });
+
+  it('should reject invalid options', () => {
+    const waiter = new TextWaiter(fakeTerminalManager([]).manager);
+    expect(() => waiter.waitFor('s1', { pattern: '(' })).toThrow(WaitError);
+    expect(() => waiter.waitFor('s1', { pattern: '' })).toThrow('Pattern is required');
+    expect(() => waiter.waitFor('s1', { pattern: 'x', timeoutMs: 0 })).toThrow(WaitError);
+  });
+});
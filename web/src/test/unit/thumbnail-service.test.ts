@@ -0,0 +1,55 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import type { TerminalManager } from '../../server/services/terminal-manager';
+import { ThumbnailService } from '../../server/services/thumbnail-service';
+
+function row(text: string) {
+  return Array.from(text, (char) => ({ char, width: 1 }));
+}
+
+describe('ThumbnailService', () => {
+  let screen: string[];
+  let getBufferSnapshot: ReturnType<typeof vi.fn>;
+  let service: ThumbnailService;
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    screen = ['$ make', 'building...  ', 'done', '', ''];
+    getBufferSnapshot = vi.fn(async () => ({
+      cols: 80,
+      rows: screen.length,
+      cells: screen.map(row),
+    }));
+    service = new ThumbnailService({ getBufferSnapshot } as unknown as TerminalManager, {
+      refreshMs: 1000,
+    });
+  });
+
+  afterEach(() => {
+    service.destroy();
+    vi.useRealTimers();
+  });
+
+  it('should return the last non-blank lines of the screen', async () => {
+    const thumbnail = await service.getThumbnail('s1', 2);
+    expect(thumbnail.lines).toEqual(['building...', 'done']);
+    expect(thumbnail).toMatchObject({ cols: 80, rows: 5 });
+  });
+
+  it('should reuse a thumbnail until the refresh interval passes', async () => {
+    await service.getThumbnail('s1');
+    screen[3] = 'next';
+    expect((await service.getThumbnail('s1')).lines).not.toContain('next');
+    expect(getBufferSnapshot).toHaveBeenCalledTimes(1);
+
+    vi.advanceTimersByTime(1000);
+    expect((await service.getThumbnail('s1')).lines).toContain('next');
+    expect(getBufferSnapshot).toHaveBeenCalledTimes(2);
+    expect(service.getStats()).toMatchObject({ rendered: 2, cacheHits: 1 });
+  });
+
+  it('should retry failed renders', async () => {
+    getBufferSnapshot.mockRejectedValueOnce(new Error('no terminal'));
+    await expect(service.getThumbnail('s1')).rejects.toThrow('no terminal');
+    expect((await service.getThumbnail('s1')).lines).toHaveLength(3);
+  });
+});
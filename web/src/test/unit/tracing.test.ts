@@ -0,0 +1,188 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import {
+  configureTracing,
+  getActiveSpan,
+  parseOtlpHeaders,
+  parseTraceparent,
+  SpanKind,
+  shutdownTracing,
+  startSpan,
+  tracedFetch,
+  withSpan,
+} from '../../server/utils/tracing';
+
+const COLLECTOR_URL = 'http://collector:4318/v1/traces';
+
+interface ExportedSpan {
+  traceId: string;
+  spanId: string;
+  parentSpanId?: string;
+  name: string;
+  kind: number;
+  status: { code: number };
+}
+
+describe('tracing', () => {
+  let fetchMock: ReturnType<typeof vi.fn>;
+
+  beforeEach(() => {
+    fetchMock = vi.fn(async () => new Response('{}', { status: 200 }));
+    vi.stubGlobal('fetch', fetchMock);
+    configureTracing({ endpoint: 'http://collector:4318/', serviceName: 'vibetunnel-test' });
+  });
+
+  afterEach(() => {
+    vi.unstubAllGlobals();
+  });
+
+  // Spans sent to the collector, in the order they ended
+  async function exportedSpans(): Promise<ExportedSpan[]> {
+    await shutdownTracing();
+    const exports = fetchMock.mock.calls.filter(([url]) => url === COLLECTOR_URL);
+    return exports.flatMap(([, init]) => {
+      const body = JSON.parse((init as RequestInit).body as string);
+      return body.resourceSpans[0].scopeSpans[0].spans;
+    });
+  }
+
+  it('should nest spans started within withSpan', async () => {
+    await withSpan('outer', {}, async () => {
+      await withSpan('inner', {}, async () => {
+        expect(getActiveSpan()).toBeDefined();
+      });
+    });
+
+    const [inner, outer] = await exportedSpans();
+    expect(inner.name).toBe('inner');
+    expect(inner.parentSpanId).toBe(outer.spanId);
+    expect(inner.traceId).toBe(outer.traceId);
+    expect(outer.parentSpanId).toBeUndefined();
+  });
+
+  it('should mark spans failed when the function throws', async () => {
+    await expect(
+      withSpan('failing', {}, async () => {
+        throw new Error('boom');
+      })
+    ).rejects.toThrow('boom');
+
+    const [span] = await exportedSpans();
+    expect(span.status.code).toBe(2);
+  });
+
+  // Shape of opentelemetry-proto examples/trace.json: hex IDs, enums as integers and
+  // 64-bit integers (timestamps, int attributes) as decimal strings
+  it('should encode spans as OTLP/JSON', async () => {
+    const span = startSpan('request', {
+      kind: SpanKind.SERVER,
+      attributes: { 'http.route': '/api/sessions', count: 42, ratio: 0.5, cached: false },
+    });
+    span?.addEvent('accepted', { attempt: 1 });
+    span?.end();
+    await shutdownTracing();
+
+    const [url, init] = fetchMock.mock.calls[0];
+    expect(url).toBe(COLLECTOR_URL);
+    expect(new Headers((init as RequestInit).headers).get('content-type')).toBe(
+      'application/json'
+    );
+    const body = JSON.parse((init as RequestInit).body as string);
+    const [resourceSpans] = body.resourceSpans;
+    expect(resourceSpans.resource.attributes).toEqual([
+      { key: 'service.name', value: { stringValue: 'vibetunnel-test' } },
+    ]);
+    expect(resourceSpans.scopeSpans[0].scope).toEqual({ name: 'vibetunnel' });
+
+    const [exported] = resourceSpans.scopeSpans[0].spans;
+    expect(exported.traceId).toMatch(/^[0-9a-f]{32}$/);
+    expect(exported.spanId).toMatch(/^[0-9a-f]{16}$/);
+    expect(exported.kind).toBe(2);
+    expect(exported.startTimeUnixNano).toMatch(/^\d{19}$/);
+    expect(BigInt(exported.endTimeUnixNano)).toBeGreaterThanOrEqual(
+      BigInt(exported.startTimeUnixNano)
+    );
+    expect(exported.attributes).toEqual([
+      { key: 'http.route', value: { stringValue: '/api/sessions' } },
+      { key: 'count', value: { intValue: '42' } },
+      { key: 'ratio', value: { doubleValue: 0.5 } },
+      { key: 'cached', value: { boolValue: false } },
+    ]);
+    expect(exported.events).toEqual([
+      {
+        timeUnixNano: expect.stringMatching(/^\d{19}$/),
+        name: 'accepted',
+        attributes: [{ key: 'attempt', value: { intValue: '1' } }],
+      },
+    ]);
+    expect(exported.status).toEqual({ code: 0 });
+  });
+
+  it('should continue traces from a traceparent', async () => {
+    const parent = parseTraceparent('00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01');
+    startSpan('request', { kind: SpanKind.SERVER, parent })?.end();
+
+    const [span] = await exportedSpans();
+    expect(span.traceId).toBe('0af7651916cd43dd8448eb211c80319c');
+    expect(span.parentSpanId).toBe('b7ad6b7169203331');
+    expect(span.kind).toBe(SpanKind.SERVER);
+  });
+
+  it('should pass the client span on to the remote', async () => {
+    await tracedFetch('http://remote:4020/api/sessions', { method: 'POST' });
+
+    const [, init] = fetchMock.mock.calls[0];
+    const traceparent = new Headers((init as RequestInit).headers).get('traceparent');
+    const [span] = await exportedSpans();
+    expect(span.kind).toBe(SpanKind.CLIENT);
+    expect(traceparent).toBe(`00-${span.traceId}-${span.spanId}-01`);
+  });
+});
+
+// Examples and test cases from the W3C Trace Context specification and its test suite
+describe('parseTraceparent', () => {
+  const TRACE_ID = '4bf92f3577b34da6a3ce929d0e0e4736';
+  const PARENT_ID = '00f067aa0ba902b7';
+
+  it('should accept the specification examples', () => {
+    for (const flags of ['01', '00']) {
+      expect(parseTraceparent(`00-${TRACE_ID}-${PARENT_ID}-${flags}`)).toEqual({
+        traceId: TRACE_ID,
+        spanId: PARENT_ID,
+      });
+    }
+  });
+
+  it('should accept future versions with extra fields', () => {
+    expect(
+      parseTraceparent(`cc-${TRACE_ID}-${PARENT_ID}-01-what-the-future-will-be-like`)
+    ).toEqual({ traceId: TRACE_ID, spanId: PARENT_ID });
+    expect(parseTraceparent(`cc-${TRACE_ID}-${PARENT_ID}-01.what-the-future`)).toBeUndefined();
+  });
+
+  it('should reject invalid headers', () => {
+    for (const value of [
+      `ff-${TRACE_ID}-${PARENT_ID}-01`,
+      `00-${TRACE_ID}-${PARENT_ID}-01-extra`,
+      `00-${TRACE_ID.toUpperCase()}-${PARENT_ID}-01`,
+      `00-${'0'.repeat(32)}-${PARENT_ID}-01`,
+      `00-${TRACE_ID}-${'0'.repeat(16)}-01`,
+      `00-${TRACE_ID}-${PARENT_ID}-1`,
+      `0-${TRACE_ID}-${PARENT_ID}-01`,
+      `00_${TRACE_ID}_${PARENT_ID}_01`,
+      '00-abc-def-01',
+      '',
+    ]) {
+      expect(parseTraceparent(value)).toBeUndefined();
+    }
+    expect(parseTraceparent(undefined)).toBeUndefined();
+  });
+});
+
+describe('parseOtlpHeaders', () => {
+  it('should parse comma-separated key=value pairs', () => {
+    expect(parseOtlpHeaders('api-key=secret, x-team=a%20b,invalid')).toEqual({
+      'api-key': 'secret',
+      'x-team': 'a b',
+    });
+  });
+});
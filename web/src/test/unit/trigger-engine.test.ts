@@ -0,0 +1,96 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import type { PtyManager } from '../../server/pty';
+import { TriggerEngine, TriggerError } from '../../server/services/trigger-engine';
+
+describe('TriggerEngine', () => {
+  let controlDir: string;
+  let ptyManager: {
+    getSession: ReturnType<typeof vi.fn>;
+    sendInput: ReturnType<typeof vi.fn>;
+    setSessionMark: ReturnType<typeof vi.fn>;
+  };
+  let engine: TriggerEngine;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'vibetunnel-triggers-'));
+    ptyManager = {
+      getSession: vi.fn((sessionId: string) => (sessionId === 's1' ? { id: 's1' } : null)),
+      sendInput: vi.fn(),
+      setSessionMark: vi.fn(() => true),
+    };
+    engine = new TriggerEngine(controlDir, ptyManager as unknown as PtyManager);
+  });
+
+  afterEach(() => {
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should match lines without escape sequences', () => {
+    const events: unknown[] = [];
+    engine.on('trigger', (event) => events.push(event));
+    engine.create({ name: 'errors', pattern: 'ERROR: (\\w+)', actions: [{ type: 'event' }] });
+
+    engine.handleOutput('s1', 'ok\r\n\x1b[31mERR');
+    engine.handleOutput('s1', 'OR: disk\x1b[0m full\r\n');
+
+    expect(events).toEqual([
+      expect.objectContaining({
+        sessionId: 's1',
+        line: 'ERROR: disk full',
+        match: 'ERROR: disk',
+        groups: ['disk'],
+      }),
+    ]);
+  });
+
+  it('should answer prompts once and respect the cooldown', () => {
+    engine.create({
+      name: 'confirm',
+      pattern: 'Continue\\? \\[y/N\\]',
+      actions: [{ type: 'input', text: 'y' }],
+    });
+
+    engine.handleOutput('s1', 'Continue? [y/N] ');
+    // The echo of the answer extends the same line
+    engine.handleOutput('s1', 'y\r\n');
+    engine.handleOutput('s1', 'Continue? [y/N] ');
+
+    expect(ptyManager.sendInput.mock.calls.map((call) => call[1])).toEqual([
+      { text: 'y' },
+      { key: 'enter' },
+    ]);
+  });
+
+  it('should mark sessions and only watch the session it is limited to', () => {
+    ptyManager.getSession.mockReturnValue({ id: 's2' });
+    engine.create({
+      name: 'Build failed',
+      pattern: 'failed',
+      ignoreCase: true,
+      sessionId: 's2',
+      actions: [{ type: 'mark' }],
+    });
+
+    engine.handleOutput('s1', 'FAILED\n');
+    engine.handleOutput('s2', 'FAILED\n');
+
+    expect(ptyManager.setSessionMark).toHaveBeenCalledTimes(1);
+    expect(ptyManager.setSessionMark).toHaveBeenCalledWith(
+      's2',
+      expect.objectContaining({ label: 'Build failed' })
+    );
+  });
+
+  it('should reject invalid patterns and keep triggers across restarts', () => {
+    expect(() => engine.create({ name: 'bad', pattern: '(', actions: [] })).toThrow(
+      TriggerError
+    );
+    const trigger = engine.create({ name: 'ok', pattern: 'done', actions: [{ type: 'event' }] });
+
+    const reloaded = new TriggerEngine(controlDir, ptyManager as unknown as PtyManager);
+    expect(reloaded.get(trigger.id)).toEqual(trigger);
+  });
+});
@@ -0,0 +1,92 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { mergeRemotePresence, ViewerPresence } from '../../server/services/viewer-presence';
+
+describe('ViewerPresence', () => {
+  let presence: ViewerPresence;
+
+  beforeEach(() => {
+    vi.useFakeTimers();
+    presence = new ViewerPresence();
+  });
+
+  afterEach(() => {
+    presence.destroy();
+    vi.useRealTimers();
+  });
+
+  it('should track viewers joining and leaving', () => {
+    const changes: number[] = [];
+    presence.on('presence-changed', (p) => changes.push(p.viewers.length));
+
+    const a = presence.join('s1', 'sse', 'alice');
+    presence.join('s1', 'websocket', 'bob', 'viewer-1');
+    expect(presence.getPresence('s1').viewers).toMatchObject([
+      { id: a, transport: 'sse', userId: 'alice', typing: false },
+      { transport: 'websocket', userId: 'bob', viewerId: 'viewer-1' },
+    ]);
+
+    presence.leave('s1', a);
+    presence.leave('s1', a);
+    expect(changes).toEqual([1, 2, 1]);
+  });
+
+  it('should mark users as typing until the timeout passes', () => {
+    presence.join('s1', 'websocket', 'alice');
+    const changed = vi.fn();
+    presence.on('presence-changed', changed);
+
+    presence.recordInput('s1', 'alice');
+    presence.recordInput('s1', 'alice');
+    expect(changed).toHaveBeenCalledTimes(1);
+    expect(presence.getPresence('s1')).toMatchObject({
+      viewers: [{ userId: 'alice', typing: true }],
+      typing: ['alice'],
+    });
+
+    vi.advanceTimersByTime(5000);
+    expect(changed).toHaveBeenCalledTimes(2);
+    expect(presence.getPresence('s1').typing).toEqual([]);
+  });
+
+  it('should forget sessions without viewers or typing users', () => {
+    const id = presence.join('s1', 'sse');
+    presence.leave('s1', id);
+    expect(presence.getStats()).toMatchObject({ sessions: 0, joined: 1, left: 1 });
+  });
+});
+
+describe('mergeRemotePresence', () => {
+  it('should tag remote viewers with the remote name', () => {
+    const merged = mergeRemotePresence(
+      {
+        sessionId: 'remote-1/s1',
+        viewers: [
+          {
+            id: 'hq',
+            transport: 'sse',
+            userId: 'alice',
+            connectedAt: '2024-01-01T00:00:00.000Z',
+            typing: true,
+          },
+        ],
+        typing: ['alice'],
+      },
+      {
+        viewers: [
+          {
+            id: 'r',
+            transport: 'websocket',
+            userId: 'bob',
+            connectedAt: '2024-01-01T00:00:00.000Z',
+            typing: false,
+            via: 'edge',
+          },
+        ],
+        typing: ['alice'],
+      },
+      'remote-1'
+    );
+    expect(merged.viewers.map((viewer) => viewer.via)).toEqual(['remote-1/edge', undefined]);
+    expect(merged.typing).toEqual(['alice']);
+  });
+});
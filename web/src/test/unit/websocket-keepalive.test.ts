@@ -0,0 +1,95 @@
+import { EventEmitter } from 'events';
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import {
+  type KeepaliveOptions,
+  type KeepaliveSocket,
+  startKeepalive,
+} from '../../server/services/websocket-keepalive';
+
+class FakeSocket extends EventEmitter {
+  readyState = 1;
+  bufferedAmount = 0;
+  ping = vi.fn();
+  terminate = vi.fn(() => {
+    this.readyState = 3;
+    this.emit('close');
+  });
+}
+
+const options = {
+  pingIntervalMs: 1000,
+  pongTimeoutMs: 500,
+  writeTimeoutMs: 2000,
+  checkIntervalMs: 100,
+};
+
+function start(
+  ws: FakeSocket,
+  keepaliveOptions: Partial<KeepaliveOptions>,
+  onStale?: (reason: string) => void
+) {
+  return startKeepalive(ws as unknown as KeepaliveSocket, keepaliveOptions, onStale);
+}
+
+describe('startKeepalive', () => {
+  beforeEach(() => {
+    vi.useFakeTimers();
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+  });
+
+  it('should ping an idle connection and keep it when the peer answers', () => {
+    const ws = new FakeSocket();
+    start(ws, options);
+
+    vi.advanceTimersByTime(1000);
+    expect(ws.ping).toHaveBeenCalledTimes(1);
+
+    ws.emit('pong');
+    vi.advanceTimersByTime(900);
+    expect(ws.terminate).not.toHaveBeenCalled();
+  });
+
+  it('should not ping while messages are arriving', () => {
+    const ws = new FakeSocket();
+    start(ws, options);
+
+    for (let i = 0; i < 5; i++) {
+      vi.advanceTimersByTime(500);
+      ws.emit('message', Buffer.from('{}'));
+    }
+    expect(ws.ping).not.toHaveBeenCalled();
+  });
+
+  it('should terminate when no pong arrives in time', () => {
+    const ws = new FakeSocket();
+    const onStale = vi.fn();
+    start(ws, options, onStale);
+
+    vi.advanceTimersByTime(1000 + 500);
+    expect(ws.terminate).toHaveBeenCalledTimes(1);
+    expect(onStale).toHaveBeenCalledWith('pong-timeout');
+  });
+
+  it('should terminate when queued writes do not drain', () => {
+    const ws = new FakeSocket();
+    const onStale = vi.fn();
+    start(ws, { ...options, pingIntervalMs: 60000 }, onStale);
+
+    ws.bufferedAmount = 4096;
+    vi.advanceTimersByTime(2200);
+    expect(onStale).toHaveBeenCalledWith('write-timeout');
+  });
+
+  it('should stop checking once the socket closes', () => {
+    const ws = new FakeSocket();
+    start(ws, options);
+
+    ws.emit('close');
+    vi.advanceTimersByTime(10000);
+    expect(ws.ping).not.toHaveBeenCalled();
+    expect(ws.listenerCount('pong')).toBe(0);
+  });
+});
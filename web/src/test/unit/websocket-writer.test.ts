@@ -0,0 +1,94 @@
+import { describe, expect, it, vi } from 'vitest';
+import { WebSocketWriter, type WriterSocket } from '../../server/services/websocket-writer';
+
+type SendCallback = (error?: Error) => void;
+
+class FakeSocket {
+  readyState = 1;
+  bufferedAmount = 0;
+  sent: Array<Buffer | string> = [];
+  callbacks: SendCallback[] = [];
+  close = vi.fn(() => {
+    this.readyState = 3;
+  });
+
+  send(data: Buffer | string, callback: SendCallback) {
+    this.sent.push(data);
+    this.callbacks.push(callback);
+    this.bufferedAmount += data.length;
+  }
+
+  // Simulate the oldest pending write reaching the network
+  drainOne() {
+    const data = this.sent[this.sent.length - this.callbacks.length];
+    this.bufferedAmount -= data.length;
+    this.callbacks.shift()?.();
+  }
+}
+
+function createWriter(options = {}) {
+  const ws = new FakeSocket();
+  const writer = new WebSocketWriter(ws as unknown as WriterSocket, {
+    maxBufferedBytes: 10,
+    ...options,
+  });
+  return { ws, writer };
+}
+
+describe('WebSocketWriter', () => {
+  it('should send immediately while the socket is not backed up', () => {
+    const { ws, writer } = createWriter();
+    writer.send('abc');
+    writer.sendFrame('s1', Buffer.from('def'));
+    expect(ws.sent).toEqual(['abc', Buffer.from('def')]);
+  });
+
+  it('should queue while backed up and flush in order once drained', () => {
+    const { ws, writer } = createWriter();
+    writer.send('0123456789');
+    writer.send('a');
+    writer.send('b');
+    expect(ws.sent).toEqual(['0123456789']);
+    expect(writer.getQueueLength()).toBe(2);
+
+    ws.drainOne();
+    expect(ws.sent).toEqual(['0123456789', 'a', 'b']);
+  });
+
+  it('should merge queued frames for the same key and release the replaced one', () => {
+    const { ws, writer } = createWriter();
+    const released = vi.fn();
+    writer.send('0123456789');
+    writer.sendFrame('s1', Buffer.from('old'), released);
+    writer.sendFrame('s2', Buffer.from('other'));
+    writer.sendFrame('s1', Buffer.from('new'));
+
+    expect(released).toHaveBeenCalledTimes(1);
+    expect(released).toHaveBeenCalledWith(false);
+    expect(writer.getQueueLength()).toBe(2);
+
+    ws.drainOne();
+    // Merged frame keeps its position ahead of s2
+    expect(ws.sent.slice(1)).toEqual([Buffer.from('new'), Buffer.from('other')]);
+    expect(writer.getStats().merged).toBe(1);
+  });
+
+  it('should report whether a frame was written', () => {
+    const { ws, writer } = createWriter();
+    const done = vi.fn();
+    writer.sendFrame('s1', Buffer.from('abc'), done);
+    ws.drainOne();
+    expect(done).toHaveBeenCalledWith(true);
+  });
+
+  it('should close the connection when the queue overflows', () => {
+    const { ws, writer } = createWriter({ maxQueuedMessages: 2 });
+    writer.send('0123456789');
+    writer.send('a');
+    writer.send('b');
+    writer.send('c');
+
+    expect(ws.close).toHaveBeenCalledWith(1008, 'Client too slow');
+    expect(writer.getQueueLength()).toBe(0);
+  });
+});
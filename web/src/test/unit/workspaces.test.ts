@@ -0,0 +1,82 @@
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { afterEach, beforeEach, describe, expect, it } from 'vitest';
+import {
+  isInWorkspace,
+  summarizeWorkspaceActivity,
+  validateWorkspace,
+  WorkspaceStore,
+} from '../../server/services/workspaces';
+
+describe('WorkspaceStore', () => {
+  let controlDir: string;
+
+  beforeEach(() => {
+    controlDir = fs.mkdtempSync(path.join(os.tmpdir(), 'workspaces-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(controlDir, { recursive: true, force: true });
+  });
+
+  it('should validate workspaces', () => {
+    expect(validateWorkspace({ name: 'api', rootDir: '/src/api' }, false)).toBeNull();
+    expect(validateWorkspace({ name: ' ', rootDir: '/src/api' }, false)).toMatch(/name/);
+    expect(validateWorkspace({ name: 'api', rootDir: 'src/api' }, false)).toMatch(/rootDir/);
+    expect(validateWorkspace({ defaultRemote: '' }, true)).toMatch(/defaultRemote/);
+    expect(validateWorkspace({ affinity: { tags: ['gpu'] } }, true)).toBeNull();
+    expect(validateWorkspace({ affinity: { fallback: 'local' } }, true)).toMatch(/affinity/);
+    expect(validateWorkspace({}, true)).toBeNull();
+  });
+
+  it('should store workspaces and keep them across restarts', () => {
+    const store = new WorkspaceStore(controlDir);
+    const workspace = store.create({ name: ' api ', rootDir: '/src/api/', defaultRemote: 'gpu' });
+    expect(workspace).toMatchObject({ name: 'api', rootDir: '/src/api', defaultRemote: 'gpu' });
+    expect(() => store.create({ name: 'api', rootDir: '/src/other' })).toThrow(/already exists/);
+
+    const updated = store.update(workspace.id, { rootDir: '/src/api-v2', defaultRemote: null });
+    expect(updated).toMatchObject({ name: 'api', rootDir: '/src/api-v2' });
+    expect(updated).not.toHaveProperty('defaultRemote');
+    const pinned = store.update(workspace.id, { affinity: { tags: ['gpu'], fallback: 'local' } });
+    expect(pinned?.affinity).toEqual({ tags: ['gpu'], fallback: 'local' });
+    expect(store.update(workspace.id, { affinity: null })).not.toHaveProperty('affinity');
+    expect(() => store.update(workspace.id, { name: null })).toThrow(/cannot be removed/);
+    expect(store.update('missing', { name: 'web' })).toBeUndefined();
+
+    const reloaded = new WorkspaceStore(controlDir);
+    expect(reloaded.get(workspace.id)).toEqual(store.get(workspace.id));
+    expect(reloaded.delete(workspace.id)).toBe(true);
+    expect(new WorkspaceStore(controlDir).list()).toEqual([]);
+  });
+
+  it('should scope paths to the workspace root', () => {
+    const store = new WorkspaceStore(controlDir);
+    const workspace = store.create({ name: 'api', rootDir: '/src/api' });
+    expect(isInWorkspace(workspace, '/src/api')).toBe(true);
+    expect(isInWorkspace(workspace, '/src/api/lib/index.ts')).toBe(true);
+    expect(isInWorkspace(workspace, '/src/api/../web')).toBe(false);
+    expect(isInWorkspace(workspace, '/src/api-v2')).toBe(false);
+  });
+
+  it('should summarize the activity of sessions', () => {
+    const now = Date.parse('2026-01-01T12:00:00Z');
+    const summary = summarizeWorkspaceActivity(
+      [
+        { status: 'running', lastModified: '2026-01-01T11:59:30Z' },
+        { status: 'running', lastModified: '2026-01-01T11:00:00Z' },
+        { status: 'exited', lastModified: '2026-01-01T11:59:50Z' },
+      ],
+      now
+    );
+    expect(summary).toEqual({
+      sessions: 3,
+      running: 2,
+      exited: 1,
+      active: 1,
+      lastActivity: '2026-01-01T11:59:50.000Z',
+    });
+    expect(summarizeWorkspaceActivity([], now).lastActivity).toBeNull();
+  });
+});
@@ -23,6 +23,8 @@ export interface ServerConfig {
   logOutput?: boolean;
   /** Server type for logging context */
   serverType?: string;
+  /** Server executable to run instead of the CLI (e.g. another implementation) */
+  binary?: string;
 }
 
 /**
@@ -139,11 +141,12 @@ export async function startTestServer(config: ServerConfig = {}): Promise<Server
     timeout = DEFAULT_TIMEOUT,
     logOutput = true,
     serverType = 'SERVER',
+    binary,
   } = config;
 
   // Build spawn command - use built binary if available for better compatibility
-  const useBuiltBinary = fs.existsSync(BUILT_CLI_PATH);
-  const command = useBuiltBinary ? BUILT_CLI_PATH : usePnpm ? 'pnpm' : 'tsx';
+  const useBuiltBinary = binary !== undefined || fs.existsSync(BUILT_CLI_PATH);
+  const command = binary ?? (useBuiltBinary ? BUILT_CLI_PATH : usePnpm ? 'pnpm' : 'tsx');
   const spawnArgs = useBuiltBinary
     ? args
     : usePnpm
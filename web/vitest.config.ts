@@ -12,7 +12,12 @@ export default defineConfig(({ mode }) => {
   const testInclude = isClient 
     ? ['src/client/**/*.test.ts']
     : isServer 
-    ? ['src/server/**/*.test.ts', 'src/test/e2e/**/*.test.ts', 'src/test/unit/**/*.test.ts']
+    ? [
+        'src/server/**/*.test.ts',
+        'src/test/e2e/**/*.test.ts',
+        'src/test/unit/**/*.test.ts',
+        'src/test/conformance/**/*.test.ts',
+      ]
     : ['src/**/*.test.ts'];
     
   const coverageInclude = isClient